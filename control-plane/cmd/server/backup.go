@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/internal/version"
+	"github.com/yourorg/control-plane/pkg/archive"
+	"github.com/yourorg/control-plane/pkg/backup"
+	"github.com/yourorg/control-plane/pkg/db"
+)
+
+var (
+	backupPassphraseFile string
+	backupSkipObjects    bool
+	backupMigrationsDir  string
+	restoreForce         bool
+	restoreDryRun        bool
+	restoreConfigOut     string
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup <file>",
+	Short: "Write an encrypted snapshot of the control plane",
+	Long: `Write an encrypted snapshot of the control plane to a file.
+
+The snapshot holds every table of the database, read in one consistent
+transaction, the archived execution outputs it references and the
+configuration file. It is encrypted with a passphrase read from
+--passphrase-file or CP_BACKUP_PASSPHRASE. Encrypted columns stay encrypted
+with the master keys, so keep those with the passphrase as well. Registered
+tenant databases are not included; tenant data is kept in the shared
+database, so they hold only their schema.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runBackup(args[0])
+	},
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "Restore an encrypted snapshot of the control plane",
+	Long: `Restore a snapshot written by backup into the configured database.
+
+The snapshot's schema version must be known to this control plane; migrations
+it predates are applied after the restore. The database must be empty unless
+--force is given, which drops its tables first. Stop every control plane
+replica before restoring, and check the snapshot with --dry-run first: a
+restore that fails part way leaves the database partially loaded.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRestore(args[0])
+	},
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{backupCmd, restoreCmd} {
+		cmd.Flags().StringVar(&backupPassphraseFile, "passphrase-file", "", "file holding the backup passphrase (default $CP_BACKUP_PASSPHRASE)")
+	}
+	backupCmd.Flags().BoolVar(&backupSkipObjects, "skip-objects", false, "leave archived execution outputs out of the backup")
+	restoreCmd.Flags().StringVar(&backupMigrationsDir, "migrations-dir", "", "directory of SQL migrations (default database.tenancy.migrations_dir)")
+	restoreCmd.Flags().BoolVar(&restoreForce, "force", false, "drop the tables of a database that is not empty")
+	restoreCmd.Flags().BoolVar(&restoreDryRun, "dry-run", false, "decrypt and validate the backup without writing")
+	restoreCmd.Flags().StringVar(&restoreConfigOut, "config-out", "", "write the backed up config file to this path")
+}
+
+// backupPassphrase reads the backup passphrase from --passphrase-file or
+// the backup.passphrase setting
+func backupPassphrase() (string, error) {
+	if backupPassphraseFile == "" {
+		if passphrase := viper.GetString("backup.passphrase"); passphrase != "" {
+			return passphrase, nil
+		}
+		return "", fmt.Errorf("a backup passphrase is required: set --passphrase-file or CP_BACKUP_PASSPHRASE")
+	}
+	data, err := os.ReadFile(backupPassphraseFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// backupArchiveStore returns the execution archive store, or nil when
+// archiving is disabled
+func backupArchiveStore() (archive.Store, error) {
+	if !viper.GetBool("executions.archive.enabled") {
+		return nil, nil
+	}
+	store, err := newArchiveStore()
+	if err != nil {
+		return nil, fmt.Errorf("invalid execution archive store: %w", err)
+	}
+	return store, nil
+}
+
+// openBackupDatabase connects to the control plane database
+func openBackupDatabase(logger *zap.Logger) (*db.Connection, error) {
+	dbConfig := &db.Config{
+		Host:     viper.GetString("database.host"),
+		Port:     viper.GetInt("database.port"),
+		Username: viper.GetString("database.user"),
+		Password: viper.GetString("database.password"),
+		Database: viper.GetString("database.name"),
+	}
+
+	if dbConfig.Host == "" {
+		dbConfig.Host = "localhost"
+	}
+	if dbConfig.Port == 0 {
+		dbConfig.Port = 3306
+	}
+	if dbConfig.Username == "" {
+		dbConfig.Username = "root"
+	}
+	if dbConfig.Database == "" {
+		dbConfig.Database = "vmmanager"
+	}
+
+	conn, err := db.NewConnection(dbConfig, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return conn, nil
+}
+
+func runBackup(path string) error {
+	logger, err := createLogger()
+	if err != nil {
+		return fmt.Errorf("failed to create logger: %w", err)
+	}
+	defer logger.Sync()
+
+	passphrase, err := backupPassphrase()
+	if err != nil {
+		return err
+	}
+	store, err := backupArchiveStore()
+	if err != nil {
+		return err
+	}
+
+	conn, err := openBackupDatabase(logger)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Write next to the destination and rename, so a failed backup never
+	// replaces a good one
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".backup-*")
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	manifest, err := backup.Create(context.Background(), conn.DB(), tmp, &backup.Options{
+		Passphrase:  passphrase,
+		Version:     version.Version,
+		Store:       store,
+		SkipObjects: backupSkipObjects,
+		ConfigFile:  viper.ConfigFileUsed(),
+	}, logger)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write backup file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write backup file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write backup file: %w", err)
+	}
+
+	var rows int64
+	for _, table := range manifest.Tables {
+		rows += table.Rows
+	}
+	logger.Info("backup written",
+		zap.String("file", path),
+		zap.String("schema_version", manifest.SchemaVersion),
+		zap.Int("tables", len(manifest.Tables)),
+		zap.Int64("rows", rows),
+		zap.Int("objects", manifest.Objects),
+		zap.Int("missing_objects", manifest.MissingObjects))
+	return nil
+}
+
+func runRestore(path string) error {
+	logger, err := createLogger()
+	if err != nil {
+		return fmt.Errorf("failed to create logger: %w", err)
+	}
+	defer logger.Sync()
+
+	passphrase, err := backupPassphrase()
+	if err != nil {
+		return err
+	}
+	store, err := backupArchiveStore()
+	if err != nil {
+		return err
+	}
+	migrationsDir := backupMigrationsDir
+	if migrationsDir == "" {
+		migrationsDir = tenancyConfig().MigrationsDir
+	}
+	if migrationsDir == "" {
+		migrationsDir = db.DefaultTenancyConfig().MigrationsDir
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer f.Close()
+
+	conn, err := openBackupDatabase(logger)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	report, err := backup.Restore(context.Background(), conn.DB(), f, &backup.RestoreOptions{
+		Passphrase:    passphrase,
+		MigrationsDir: migrationsDir,
+		Store:         store,
+		Force:         restoreForce,
+		DryRun:        restoreDryRun,
+		ConfigOut:     restoreConfigOut,
+	}, logger)
+	if report != nil {
+		// The table definitions are long and not useful here
+		summary := *report
+		if summary.Manifest != nil {
+			manifest := *summary.Manifest
+			manifest.Tables = nil
+			manifest.Routines = nil
+			summary.Manifest = &manifest
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(summary)
+	}
+	return err
+}
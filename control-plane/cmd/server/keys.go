@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/pkg/db"
+	"github.com/yourorg/control-plane/pkg/encryption"
+)
+
+var (
+	rotateBatchSize int
+	rotateDryRun    bool
+)
+
+var rotateKeysCmd = &cobra.Command{
+	Use:   "rotate-keys",
+	Short: "Re-encrypt sensitive columns with the active master key",
+	Long: `Re-encrypt all encrypted columns with encryption.active_key.
+
+Values encrypted with older master keys, and plaintext values written before
+encryption was enabled, are re-encrypted. Keep retired keys configured until
+this command completes, then remove them.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRotateKeys()
+	},
+}
+
+func init() {
+	rotateKeysCmd.Flags().IntVar(&rotateBatchSize, "batch-size", encryption.DefaultRotationBatchSize, "rows re-encrypted per batch")
+	rotateKeysCmd.Flags().BoolVar(&rotateDryRun, "dry-run", false, "count rows that need re-encryption without writing")
+}
+
+// encryptionConfig reads the encryption section of the configuration
+func encryptionConfig() *encryption.Config {
+	return &encryption.Config{
+		Enabled:           viper.GetBool("encryption.enabled"),
+		ActiveKey:         viper.GetString("encryption.active_key"),
+		Keys:              viper.GetStringMapString("encryption.keys"),
+		KeyCommand:        viper.GetStringSlice("encryption.key_command"),
+		KeyCommandTimeout: viper.GetDuration("encryption.key_command_timeout"),
+	}
+}
+
+// initEncryption loads the master keys and installs them for encrypted columns
+func initEncryption(logger *zap.Logger) (*encryption.KeyRing, error) {
+	keyRing, err := encryption.LoadKeyRing(context.Background(), encryptionConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load encryption keys: %w", err)
+	}
+
+	encryption.SetKeyRing(keyRing)
+	if keyRing == nil {
+		logger.Warn("column encryption is disabled, sensitive data is stored in plaintext")
+	} else {
+		logger.Info("column encryption enabled", zap.String("active_key", keyRing.ActiveKeyID()))
+	}
+
+	return keyRing, nil
+}
+
+func runRotateKeys() error {
+	logger, err := createLogger()
+	if err != nil {
+		return fmt.Errorf("failed to create logger: %w", err)
+	}
+	defer logger.Sync()
+
+	keyRing, err := initEncryption(logger)
+	if err != nil {
+		return err
+	}
+	if keyRing == nil {
+		return fmt.Errorf("encryption is not enabled")
+	}
+
+	dbConfig := &db.Config{
+		Host:     viper.GetString("database.host"),
+		Port:     viper.GetInt("database.port"),
+		Username: viper.GetString("database.user"),
+		Password: viper.GetString("database.password"),
+		Database: viper.GetString("database.name"),
+	}
+
+	if dbConfig.Host == "" {
+		dbConfig.Host = "localhost"
+	}
+	if dbConfig.Port == 0 {
+		dbConfig.Port = 3306
+	}
+	if dbConfig.Username == "" {
+		dbConfig.Username = "root"
+	}
+	if dbConfig.Database == "" {
+		dbConfig.Database = "vmmanager"
+	}
+
+	conn, err := db.NewConnection(dbConfig, logger)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close()
+
+	report, err := encryption.Rotate(context.Background(), conn.DB(), keyRing, rotateBatchSize, rotateDryRun, logger)
+	if report != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	}
+	return err
+}
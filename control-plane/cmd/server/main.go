@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -13,16 +15,34 @@ import (
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"gorm.io/gorm"
 
 	"github.com/yourorg/control-plane/internal/version"
 	"github.com/yourorg/control-plane/pkg/agent"
+	"github.com/yourorg/control-plane/pkg/analytics"
+	"github.com/yourorg/control-plane/pkg/anomaly"
 	"github.com/yourorg/control-plane/pkg/api"
+	"github.com/yourorg/control-plane/pkg/archive"
 	"github.com/yourorg/control-plane/pkg/audit"
 	"github.com/yourorg/control-plane/pkg/auth"
+	"github.com/yourorg/control-plane/pkg/breakglass"
 	"github.com/yourorg/control-plane/pkg/campaign"
+	"github.com/yourorg/control-plane/pkg/catalog"
+	"github.com/yourorg/control-plane/pkg/configprofile"
 	"github.com/yourorg/control-plane/pkg/db"
+	"github.com/yourorg/control-plane/pkg/gitsync"
+	"github.com/yourorg/control-plane/pkg/inbound"
 	"github.com/yourorg/control-plane/pkg/mcp"
+	"github.com/yourorg/control-plane/pkg/overview"
+	"github.com/yourorg/control-plane/pkg/portability"
+	"github.com/yourorg/control-plane/pkg/remediation"
+	"github.com/yourorg/control-plane/pkg/schedule"
+	"github.com/yourorg/control-plane/pkg/search"
+	"github.com/yourorg/control-plane/pkg/shell"
+	"github.com/yourorg/control-plane/pkg/shutdown"
+	"github.com/yourorg/control-plane/pkg/template"
 	"github.com/yourorg/control-plane/pkg/tenant"
+	"github.com/yourorg/control-plane/pkg/vulnerability"
 	"github.com/yourorg/control-plane/pkg/workflow"
 )
 
@@ -43,6 +63,10 @@ func init() {
 	rootCmd.AddCommand(serveCmd)
 	rootCmd.AddCommand(mcpCmd)
 	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(validateConfigCmd)
+	rootCmd.AddCommand(rotateKeysCmd)
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(restoreCmd)
 	rootCmd.AddCommand(versionCmd)
 }
 
@@ -56,7 +80,10 @@ func initConfig() {
 		viper.AddConfigPath("/etc/control-plane/")
 	}
 
+	// Nested keys map to CP_ variables with dots as underscores, e.g.
+	// database.password is CP_DATABASE_PASSWORD
 	viper.SetEnvPrefix("CP")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
 
 	if err := viper.ReadInConfig(); err != nil {
@@ -118,16 +145,22 @@ func runServer() error {
 	logger.Info("starting control plane server",
 		zap.String("version", version.Version))
 
+	// Load master keys for encrypted columns
+	if _, err := initEncryption(logger); err != nil {
+		return err
+	}
+
 	// Initialize database
 	dbConfig := &db.Config{
-		Host:            viper.GetString("database.host"),
-		Port:            viper.GetInt("database.port"),
-		User:            viper.GetString("database.user"),
-		Password:        viper.GetString("database.password"),
-		Database:        viper.GetString("database.name"),
-		MaxOpenConns:    viper.GetInt("database.max_open_conns"),
-		MaxIdleConns:    viper.GetInt("database.max_idle_conns"),
-		ConnMaxLifetime: viper.GetDuration("database.conn_max_lifetime"),
+		Host:               viper.GetString("database.host"),
+		Port:               viper.GetInt("database.port"),
+		User:               viper.GetString("database.user"),
+		Password:           viper.GetString("database.password"),
+		Database:           viper.GetString("database.name"),
+		MaxOpenConns:       viper.GetInt("database.max_open_conns"),
+		MaxIdleConns:       viper.GetInt("database.max_idle_conns"),
+		ConnMaxLifetime:    viper.GetDuration("database.conn_max_lifetime"),
+		SlowQueryThreshold: viper.GetDuration("database.slow_query_threshold"),
 	}
 
 	if dbConfig.Host == "" {
@@ -153,6 +186,15 @@ func runServer() error {
 		return fmt.Errorf("failed to run migrations: %w", err)
 	}
 
+	// Bring the schemas of registered tenant databases up to date with the
+	// shared one
+	tenantRouter := db.NewTenantRouter(database, tenancyConfig(), logger)
+	if viper.GetBool("database.tenancy.migrate_on_start") {
+		if _, err := tenantRouter.MigrateAll(context.Background()); err != nil {
+			return fmt.Errorf("failed to migrate tenant databases: %w", err)
+		}
+	}
+
 	// Initialize JWT auth
 	jwtSecret := viper.GetString("auth.jwt_secret")
 	if jwtSecret == "" {
@@ -165,14 +207,62 @@ func runServer() error {
 		Issuer:          viper.GetString("auth.issuer"),
 		TokenExpiry:     viper.GetDuration("auth.token_expiry"),
 		RefreshExpiry:   viper.GetDuration("auth.refresh_expiry"),
+		Leeway:          viper.GetDuration("auth.token_leeway"),
 	})
 
 	// Initialize managers
 	tenantManager := tenant.NewManager(database, logger)
 	agentRegistry := agent.NewRegistry(database, logger)
+	agentRegistry.SetClockSkewThreshold(viper.GetDuration("agents.clock_skew_threshold"))
 	agentRegistrar := agent.NewRegistrar(database, jwtAuth, logger)
+	keyManager := agent.NewKeyManager(database, logger)
+	installScripts := newInstallScriptGenerator(keyManager)
 	workflowManager := workflow.NewManager(database, logger)
 	campaignManager := campaign.NewManager(database, logger)
+	templateManager := template.NewManager(database, logger)
+	portabilityManager := portability.NewManager(database, logger)
+
+	// Sync workflows and templates from tenants' Git repositories
+	gitRepositories := gitsync.NewManager(database, workflowManager, templateManager, &gitsync.Config{
+		Interval: viper.GetDuration("gitsync.interval"),
+		Timeout:  viper.GetDuration("gitsync.timeout"),
+		WorkDir:  viper.GetString("gitsync.work_dir"),
+	}, logger)
+
+	// Workflow executor dispatches to agents through the Piko proxy
+	pikoURL := viper.GetString("piko.url")
+	if pikoURL == "" && viper.GetString("piko.endpoint") != "" {
+		pikoURL = "http://" + viper.GetString("piko.endpoint")
+	}
+	workflowExecutor := workflow.NewExecutor(database, pikoURL, logger)
+	workflowExecutor.SetDispatchConfig(&workflow.DispatchConfig{
+		ConnectTimeout:   viper.GetDuration("piko.dispatch.connect_timeout"),
+		ResponseTimeout:  viper.GetDuration("piko.dispatch.response_timeout"),
+		MaxAttempts:      viper.GetInt("piko.dispatch.max_attempts"),
+		RetryBackoff:     viper.GetDuration("piko.dispatch.retry_backoff"),
+		MaxRetryBackoff:  viper.GetDuration("piko.dispatch.max_retry_backoff"),
+		BreakerThreshold: viper.GetInt("piko.dispatch.breaker_threshold"),
+		BreakerCooldown:  viper.GetDuration("piko.dispatch.breaker_cooldown"),
+	})
+
+	// Template lint validators (content type -> command with {file} placeholder)
+	lintConfig := template.DefaultLinterConfig()
+	for contentType, command := range viper.GetStringMapStringSlice("templates.lint.validators") {
+		lintConfig.Validators[contentType] = command
+	}
+	if timeout := viper.GetDuration("templates.lint.timeout"); timeout > 0 {
+		lintConfig.Timeout = timeout
+	}
+	lintConfig.Sandbox = viper.GetStringSlice("templates.lint.sandbox")
+	lintConfig.SandboxDir = viper.GetString("templates.lint.sandbox_dir")
+	if len(lintConfig.Validators) > 0 && len(lintConfig.Sandbox) == 0 {
+		logger.Warn("template lint validators are ignored without templates.lint.sandbox")
+	}
+	templateManager.SetLinter(template.NewLinter(lintConfig))
+	templateManager.SetUploadConfig(template.UploadConfig{
+		MaxSize:  viper.GetInt64("templates.uploads.max_size"),
+		SpoolDir: viper.GetString("templates.uploads.spool_dir"),
+	})
 
 	// Initialize audit logger (optional)
 	var auditLogger *audit.Logger
@@ -183,6 +273,15 @@ func runServer() error {
 
 		quickwitClient := audit.NewQuickwitClient(quickwitConfig, logger)
 		auditLogger = audit.NewLogger(quickwitClient, quickwitConfig, logger)
+		auditLogger.SetChain(audit.NewChain(database))
+
+		if encoded := viper.GetString("audit.export_signing_key"); encoded != "" {
+			key, err := audit.ParseSigningKey(encoded)
+			if err != nil {
+				return fmt.Errorf("invalid audit.export_signing_key: %w", err)
+			}
+			auditLogger.SetExportSigningKey(key)
+		}
 
 		// Ensure index exists
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -192,11 +291,140 @@ func runServer() error {
 		cancel()
 	}
 
+	// Index execution step output for search (optional)
+	outputIndexer := newOutputIndexer(logger)
+	if outputIndexer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := outputIndexer.EnsureIndex(ctx); err != nil {
+			logger.Warn("failed to ensure execution output index", zap.Error(err))
+		}
+		cancel()
+
+		workflowExecutor.SetOutputIndexer(outputIndexer)
+	}
+
+	// Move the output of old executions to object storage (optional)
+	executionArchiver, err := newExecutionArchiver(database, logger)
+	if err != nil {
+		return err
+	}
+	if executionArchiver != nil {
+		workflowExecutor.SetArchiver(executionArchiver)
+	}
+
+	// Fail executions whose agent stopped reporting past the workflow timeout
+	executionWatchdog := workflow.NewWatchdog(database, workflowExecutor,
+		viper.GetDuration("executions.watchdog_interval"),
+		viper.GetDuration("executions.watchdog_grace"),
+		logger)
+	executionWatchdog.SetAuditLogger(auditLogger)
+
+	// Broker live agent shell sessions, recording them for audit
+	shellBroker := shell.NewBroker(database, workflowExecutor, &shell.Config{
+		MaxDuration:       viper.GetDuration("shell.max_duration"),
+		IdleTimeout:       viper.GetDuration("shell.idle_timeout"),
+		MaxRecordingBytes: viper.GetInt64("shell.max_recording_bytes"),
+	}, logger)
+	shellBroker.SetAuditLogger(auditLogger)
+
+	// Record template content changes with redacted diffs
+	templateManager.SetAuditLogger(auditLogger)
+
+	// Let platform admins operate as a tenant with short-lived tokens
+	jwtManager := auth.NewJWTManager(jwtSecret, viper.GetString("auth.issuer"), viper.GetDuration("auth.token_expiry"))
+	jwtManager.SetLeeway(viper.GetDuration("auth.token_leeway"))
+	impersonator := auth.NewImpersonator(database, jwtManager, viper.GetDuration("auth.impersonation.max_duration"), logger)
+	impersonator.SetAuditLogger(auditLogger)
+	tokenIssuer := auth.NewTokenIssuer(database, jwtManager, viper.GetDuration("auth.tokens.max_expiry"), logger)
+	tokenIssuer.SetAuditLogger(auditLogger)
+
+	// Time-limited emergency mode letting a tenant's incident workflow
+	// bypass campaign windows and manual approvals
+	breakGlass := breakglass.NewManager(database, &breakglass.Config{
+		DefaultDuration: viper.GetDuration("break_glass.default_duration"),
+		MaxDuration:     viper.GetDuration("break_glass.max_duration"),
+	}, logger)
+	breakGlass.SetAuditLogger(auditLogger)
+
+	// Audit authenticated API calls (requires the audit logger)
+	var apiAuditor *api.APIAuditor
+	if auditLogger != nil && viper.GetBool("audit.api_requests.enabled") {
+		apiAuditConfig := api.DefaultAPIAuditConfig()
+		if paths := viper.GetStringSlice("audit.api_requests.exclude_paths"); len(paths) > 0 {
+			apiAuditConfig.ExcludePaths = paths
+		}
+		for prefix, value := range viper.GetStringMapString("audit.api_requests.sample_rates") {
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || rate < 0 || rate > 1 {
+				return fmt.Errorf("invalid audit.api_requests.sample_rates %s: must be between 0 and 1, got %q", prefix, value)
+			}
+			apiAuditConfig.SampleRates[prefix] = rate
+		}
+		if size := viper.GetInt("audit.api_requests.queue_size"); size > 0 {
+			apiAuditConfig.QueueSize = size
+		}
+		apiAuditor = api.NewAPIAuditor(auditLogger, apiAuditConfig, logger)
+		apiAuditor.SetBreakGlass(breakGlass)
+	}
+
+	// Run remediation workflows on agents reporting matching health;
+	// remediation.enabled is the platform-wide kill switch
+	remediationManager := remediation.NewManager(database, workflowExecutor, viper.GetBool("remediation.enabled"), logger)
+	remediationManager.SetAuditLogger(auditLogger)
+
+	// Start workflows for external systems posting to inbound triggers
+	inboundTriggers := inbound.NewManager(database, workflowExecutor, logger)
+	inboundTriggers.SetAuditLogger(auditLogger)
+
+	// Run workflows by cron, in a fixed timezone or each agent's local one
+	schedules := schedule.NewManager(database, workflowExecutor, logger)
+
+	// Cross-tenant state for the operator dashboard
+	adminOverview := overview.NewReporter(tenantRouter, logger)
+	adminOverview.SetAuditLogger(auditLogger)
+	adminOverview.SetOutputIndexer(outputIndexer)
+
+	// Custom fields tenants attach to their audit events
+	auditFields := audit.NewFieldRegistry(database, logger)
+
+	// Match software inventory against advisories from OSV and NVD feeds
+	var vulnerabilityFeeds []vulnerability.Feed
+	if err := viper.UnmarshalKey("vulnerability.feeds", &vulnerabilityFeeds); err != nil {
+		return fmt.Errorf("invalid vulnerability.feeds: %w", err)
+	}
+	vulnerabilityManager := vulnerability.NewManager(database, campaignManager, &vulnerability.Config{
+		Feeds:         vulnerabilityFeeds,
+		SyncInterval:  viper.GetDuration("vulnerability.sync_interval"),
+		MatchInterval: viper.GetDuration("vulnerability.match_interval"),
+	}, logger)
+
+	// Workflows and templates the platform tenant publishes to every tenant
+	catalogManager := catalog.NewManager(database, viper.GetString("catalog.platform_tenant_id"), workflowManager, templateManager, logger)
+	catalogManager.SetAuditLogger(auditLogger)
+
+	// Analyse the audit log for anomalies (requires the audit logger)
+	var anomalyDetector *anomaly.Detector
+	if auditLogger != nil && viper.GetBool("audit.anomalies.enabled") {
+		anomalyDetector = anomaly.NewDetector(database, auditLogger, &anomaly.Config{
+			Interval:            viper.GetDuration("audit.anomalies.interval"),
+			Window:              viper.GetDuration("audit.anomalies.window"),
+			Baseline:            viper.GetDuration("audit.anomalies.baseline"),
+			FailedAuthFactor:    viper.GetFloat64("audit.anomalies.failed_auth_factor"),
+			FailedAuthMinimum:   viper.GetInt64("audit.anomalies.failed_auth_minimum"),
+			RegistrationFactor:  viper.GetFloat64("audit.anomalies.registration_factor"),
+			RegistrationMinimum: viper.GetInt64("audit.anomalies.registration_minimum"),
+			QuietHours:          viper.GetString("audit.anomalies.quiet_hours"),
+			Timezone:            viper.GetString("audit.anomalies.timezone"),
+			ActorHistory:        viper.GetDuration("audit.anomalies.actor_history"),
+		}, logger)
+	}
+
 	// Initialize server
 	serverConfig := api.DefaultServerConfig()
 	serverConfig.Host = viper.GetString("server.host")
 	serverConfig.Port = viper.GetInt("server.port")
 	serverConfig.Debug = viper.GetBool("server.debug")
+	serverConfig.DisableUI = viper.GetBool("server.disable_ui")
 
 	if serverConfig.Host == "" {
 		serverConfig.Host = "0.0.0.0"
@@ -206,38 +434,158 @@ func runServer() error {
 	}
 
 	server := api.NewServer(serverConfig, &api.Dependencies{
-		DB:              database,
-		Logger:          logger,
-		JWTAuth:         jwtAuth,
-		TenantManager:   tenantManager,
-		AgentRegistry:   agentRegistry,
-		AgentRegistrar:  agentRegistrar,
-		WorkflowManager: workflowManager,
-		CampaignManager: campaignManager,
-		AuditLogger:     auditLogger,
+		DB:                 database,
+		Logger:             logger,
+		JWTAuth:            jwtAuth,
+		TenantManager:      tenantManager,
+		AgentRegistry:      agentRegistry,
+		AgentRegistrar:     agentRegistrar,
+		KeyManager:         keyManager,
+		InstallScripts:     installScripts,
+		WorkflowManager:    workflowManager,
+		WorkflowExecutor:   workflowExecutor,
+		CampaignManager:    campaignManager,
+		TemplateManager:    templateManager,
+		PortabilityManager: portabilityManager,
+		AuditLogger:        auditLogger,
+		APIAuditor:         apiAuditor,
+		OutputIndexer:      outputIndexer,
+		ExecutionWatchdog:  executionWatchdog,
+		Analyzer:           analytics.NewAnalyzer(database, logger),
+		GitRepositories:    gitRepositories,
+		ConfigProfiles:     configprofile.NewManager(database, logger),
+		ShellBroker:        shellBroker,
+		AnomalyDetector:    anomalyDetector,
+		Impersonator:       impersonator,
+		TokenIssuer:        tokenIssuer,
+		Remediation:        remediationManager,
+		InboundTriggers:    inboundTriggers,
+		TenantDatabases:    tenantRouter,
+		Overview:           adminOverview,
+		BreakGlass:         breakGlass,
+		AuditFields:        auditFields,
+		Vulnerabilities:    vulnerabilityManager,
+		Catalog:            catalogManager,
+		Schedules:          schedules,
 	})
 
-	// Handle shutdown
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// Background loops stop together on shutdown, before the executor and
+	// the HTTP server are drained
+	workers := shutdown.NewWorkers(context.Background())
+
+	// Record campaign progress snapshots for the timeline API
+	timelineRecorder := campaign.NewTimelineRecorder(database, viper.GetDuration("campaigns.timeline_interval"), logger)
+	workers.Go(timelineRecorder.Run)
+
+	// Dispatch campaign phases, holding back work from busy agents and
+	// tenants at their concurrency cap
+	campaignDispatcher := campaign.NewDispatcher(database, workflowExecutor, &campaign.DispatcherConfig{
+		Interval:             viper.GetDuration("campaigns.dispatch.interval"),
+		MaxInFlightPerTenant: viper.GetInt("campaigns.dispatch.max_in_flight_per_tenant"),
+		MaxAgentJobs:         viper.GetInt("campaigns.dispatch.max_agent_jobs"),
+	}, logger)
+	campaignDispatcher.SetBreakGlass(breakGlass)
+	workers.Go(campaignDispatcher.Run)
+	workers.Go(breakGlass.Run)
+	workers.Go(schedules.Run)
+
+	// Delete agent health transitions past their retention
+	workers.Go(func(ctx context.Context) {
+		agentRegistry.RunHealthHistoryRetention(ctx, viper.GetDuration("agents.health_history_retention"))
+	})
+
+	// Delete the nonces of signed agent requests once they cannot be replayed
+	workers.Go(func(ctx context.Context) {
+		auth.RunNonceRetention(ctx, database.DB(), logger)
+	})
+
+	// Delete agent software changes past their retention
+	workers.Go(func(ctx context.Context) {
+		agentRegistry.RunSoftwareHistoryRetention(ctx, viper.GetDuration("agents.software_history_retention"))
+	})
+
+	// Import advisory feeds and match inventories against them
+	workers.Go(vulnerabilityManager.RunFeedSync)
+	workers.Go(vulnerabilityManager.RunMatching)
+
+	// Deliver queued executions from the dispatch outbox; every replica
+	// runs a queue and claims jobs with row locks
+	dispatchQueue := workflow.NewDispatchQueue(database, workflowExecutor, &workflow.DispatchQueueConfig{
+		Workers:         viper.GetInt("executions.dispatch_queue.workers"),
+		PollInterval:    viper.GetDuration("executions.dispatch_queue.poll_interval"),
+		BatchSize:       viper.GetInt("executions.dispatch_queue.batch_size"),
+		Lease:           viper.GetDuration("executions.dispatch_queue.lease"),
+		MaxAttempts:     viper.GetInt("executions.dispatch_queue.max_attempts"),
+		RetryBackoff:    viper.GetDuration("executions.dispatch_queue.retry_backoff"),
+		MaxRetryBackoff: viper.GetDuration("executions.dispatch_queue.max_retry_backoff"),
+		ResourceWait:    viper.GetDuration("executions.dispatch_queue.resource_wait"),
+	}, logger)
+	workflowExecutor.SetDispatchQueue(dispatchQueue)
+	workers.Go(dispatchQueue.Run)
+
+	// Close executions stuck past their workflow timeout
+	workers.Go(executionWatchdog.Run)
+
+	if executionArchiver != nil {
+		workers.Go(executionArchiver.Run)
+	}
+
+	// Sync Git repositories as they come due; replicas claim repositories
+	workers.Go(gitRepositories.Run)
+
+	// Close the records of shell sessions left open by stopped replicas
+	workers.Go(shellBroker.Run)
+
+	if anomalyDetector != nil {
+		workers.Go(anomalyDetector.Run)
+	}
+
+	// Alert as tenants use up their monthly execution budgets
+	budgetMonitor := tenant.NewBudgetMonitor(database, viper.GetDuration("executions.budget_check_interval"), logger)
+	budgetMonitor.SetAuditLogger(auditLogger)
+	workers.Go(budgetMonitor.Run)
+
+	// Close the connection pools of tenant databases no longer queried
+	workers.Go(tenantRouter.Run)
+
+	// Shut down in dependency order: stop taking work, finish what is in
+	// flight, then flush buffered events and close the database
+	shutdownManager := shutdown.NewManager(viper.GetDuration("server.shutdown_timeout"), logger)
+	shutdownManager.Add("stop API writes", func(ctx context.Context) error {
+		server.StartDraining()
+		return nil
+	})
+	shutdownManager.Add("end shell sessions", shellBroker.Shutdown)
+	shutdownManager.Add("stop campaign dispatch and background loops", workers.Stop)
+	shutdownManager.Add("drain execution dispatch", workflowExecutor.Drain)
+	shutdownManager.Add("close HTTP server", server.Shutdown)
+	if apiAuditor != nil {
+		shutdownManager.Add("write queued API audit events", apiAuditor.Close)
+	}
+	if auditLogger != nil {
+		shutdownManager.Add("flush audit log", func(ctx context.Context) error {
+			return auditLogger.Close()
+		})
+	}
+	if outputIndexer != nil {
+		shutdownManager.Add("flush execution output index", func(ctx context.Context) error {
+			return outputIndexer.Close()
+		})
+	}
+	shutdownManager.Add("close tenant databases", func(ctx context.Context) error {
+		return tenantRouter.Close()
+	})
+	shutdownManager.Add("close database", func(ctx context.Context) error {
+		return database.Close()
+	})
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
-		<-sigChan
-		logger.Info("received shutdown signal")
-		cancel()
-
-		if err := server.Shutdown(context.Background()); err != nil {
-			logger.Error("failed to shutdown server", zap.Error(err))
-		}
-
-		if auditLogger != nil {
-			if err := auditLogger.Close(); err != nil {
-				logger.Error("failed to close audit logger", zap.Error(err))
-			}
-		}
+		sig := <-sigChan
+		logger.Info("received shutdown signal", zap.String("signal", sig.String()))
+		shutdownManager.Shutdown()
 	}()
 
 	// Start server
@@ -248,8 +596,13 @@ func runServer() error {
 
 	select {
 	case err := <-errChan:
+		// The listener also returns once shutdown closes it
+		if err != nil {
+			logger.Error("HTTP server failed", zap.Error(err))
+		}
+		shutdownManager.Shutdown()
 		return err
-	case <-ctx.Done():
+	case <-shutdownManager.Done():
 		return nil
 	}
 }
@@ -262,6 +615,11 @@ func runMCP() error {
 	}
 	defer logger.Sync()
 
+	// Load master keys for encrypted columns
+	if _, err := initEncryption(logger); err != nil {
+		return err
+	}
+
 	// Initialize database
 	dbConfig := &db.Config{
 		Host:     viper.GetString("database.host"),
@@ -289,10 +647,20 @@ func runMCP() error {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	// The session's scopes come from the token it was started with;
+	// without one, tenant administration tools are not offered
+	scopes, err := mcpSessionScopes()
+	if err != nil {
+		return err
+	}
+
 	// Initialize managers
 	agentRegistry := agent.NewRegistry(database, logger)
+	keyManager := agent.NewKeyManager(database, logger)
+	installScripts := newInstallScriptGenerator(keyManager)
 	workflowManager := workflow.NewManager(database, logger)
 	campaignManager := campaign.NewManager(database, logger)
+	templateManager := template.NewManager(database, logger)
 
 	// Initialize audit logger (optional)
 	var auditLogger *audit.Logger
@@ -301,6 +669,17 @@ func runMCP() error {
 		quickwitConfig.BaseURL = viper.GetString("quickwit.url")
 		quickwitClient := audit.NewQuickwitClient(quickwitConfig, logger)
 		auditLogger = audit.NewLogger(quickwitClient, quickwitConfig, logger)
+		auditLogger.SetChain(audit.NewChain(database))
+	}
+
+	outputIndexer := newOutputIndexer(logger)
+	if outputIndexer != nil {
+		defer outputIndexer.Close()
+	}
+
+	executionArchiver, err := newExecutionArchiver(database, logger)
+	if err != nil {
+		return err
 	}
 
 	// Create MCP server
@@ -311,6 +690,15 @@ func runMCP() error {
 		WorkflowManager: workflowManager,
 		CampaignManager: campaignManager,
 		AuditLogger:     auditLogger,
+		OutputIndexer:   outputIndexer,
+		TemplateManager: templateManager,
+		InstallScripts:  installScripts,
+		Archiver:        executionArchiver,
+		TenantManager:   tenant.NewManager(database, logger),
+		KeyManager:      keyManager,
+		Scopes:          scopes,
+
+		ConfirmAboveAgents: viper.GetInt("mcp.confirm_above_agents"),
 	})
 
 	// Handle shutdown
@@ -329,6 +717,86 @@ func runMCP() error {
 	return mcpServer.Run(ctx)
 }
 
+// newExecutionArchiver creates the execution output archiver, or returns nil
+// when archival is disabled
+func newExecutionArchiver(database *gorm.DB, logger *zap.Logger) (*archive.Archiver, error) {
+	if !viper.GetBool("executions.archive.enabled") {
+		return nil, nil
+	}
+
+	store, err := newArchiveStore()
+	if err != nil {
+		return nil, fmt.Errorf("invalid execution archive store: %w", err)
+	}
+
+	config := archive.DefaultConfig()
+	if days := viper.GetInt("executions.archive.after_days"); days > 0 {
+		config.After = time.Duration(days) * 24 * time.Hour
+	}
+	config.Interval = viper.GetDuration("executions.archive.interval")
+	config.BatchSize = viper.GetInt("executions.archive.batch_size")
+	config.Prefix = viper.GetString("executions.archive.prefix")
+
+	return archive.NewArchiver(database, store, config, logger), nil
+}
+
+// newArchiveStore creates the object store configured for execution archives
+func newArchiveStore() (archive.Store, error) {
+	switch kind := viper.GetString("executions.archive.store"); kind {
+	case "", "s3":
+		return archive.NewS3Store(&archive.S3Config{
+			Endpoint:        viper.GetString("executions.archive.s3.endpoint"),
+			Region:          viper.GetString("executions.archive.s3.region"),
+			Bucket:          viper.GetString("executions.archive.s3.bucket"),
+			AccessKeyID:     viper.GetString("executions.archive.s3.access_key_id"),
+			SecretAccessKey: viper.GetString("executions.archive.s3.secret_access_key"),
+			PathStyle:       viper.GetBool("executions.archive.s3.path_style"),
+		})
+	case "filesystem":
+		return archive.NewFileStore(viper.GetString("executions.archive.path"))
+	default:
+		return nil, fmt.Errorf("unknown store %q: must be s3 or filesystem", kind)
+	}
+}
+
+// newOutputIndexer creates the execution output indexer, or returns nil when
+// output search is disabled
+func newOutputIndexer(logger *zap.Logger) *search.Indexer {
+	if !viper.GetBool("quickwit.enabled") || !viper.GetBool("quickwit.outputs.enabled") {
+		return nil
+	}
+
+	quickwitConfig := audit.DefaultQuickwitConfig()
+	quickwitConfig.BaseURL = viper.GetString("quickwit.url")
+
+	searchConfig := search.DefaultConfig()
+	if indexID := viper.GetString("quickwit.outputs.index_id"); indexID != "" {
+		searchConfig.IndexID = indexID
+	}
+	if maxBytes := viper.GetInt("quickwit.outputs.max_output_bytes"); maxBytes > 0 {
+		searchConfig.MaxOutputBytes = maxBytes
+	}
+
+	quickwitClient := audit.NewQuickwitClient(quickwitConfig, logger)
+	return search.NewIndexer(quickwitClient, searchConfig, logger)
+}
+
+// newInstallScriptGenerator creates the agent install command generator, or
+// returns nil when no agent release URL is configured
+func newInstallScriptGenerator(keyManager *agent.KeyManager) *agent.InstallScriptGenerator {
+	if viper.GetString("agents.install.release_url") == "" {
+		return nil
+	}
+
+	return agent.NewInstallScriptGenerator(keyManager, &agent.InstallScriptConfig{
+		ControlPlaneURL: viper.GetString("agents.install.control_plane_url"),
+		PikoURL:         viper.GetString("agents.install.piko_url"),
+		ReleaseURL:      viper.GetString("agents.install.release_url"),
+		Version:         viper.GetString("agents.install.version"),
+		Checksums:       viper.GetStringMapString("agents.install.checksums"),
+	})
+}
+
 func runMigrations() error {
 	logger, err := createLogger()
 	if err != nil {
@@ -362,7 +830,39 @@ func runMigrations() error {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	return db.RunMigrations(database, logger)
+	if err := db.RunMigrations(database, logger); err != nil {
+		return err
+	}
+
+	// Tenant databases get the same migrations; a failing tenant does not
+	// stop the others
+	results, err := db.NewTenantRouter(database, tenancyConfig(), logger).MigrateAll(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to migrate tenant databases: %w", err)
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tenant databases failed to migrate", failed, len(results))
+	}
+	return nil
+}
+
+// tenancyConfig returns the database-per-tenant settings
+func tenancyConfig() *db.TenancyConfig {
+	return &db.TenancyConfig{
+		MigrationsDir:      viper.GetString("database.tenancy.migrations_dir"),
+		MaxOpenConns:       viper.GetInt("database.tenancy.max_open_conns"),
+		MaxIdleConns:       viper.GetInt("database.tenancy.max_idle_conns"),
+		ConnMaxLifetime:    viper.GetDuration("database.tenancy.conn_max_lifetime"),
+		IdleTimeout:        viper.GetDuration("database.tenancy.idle_timeout"),
+		RefreshInterval:    viper.GetDuration("database.tenancy.refresh_interval"),
+		SlowQueryThreshold: viper.GetDuration("database.slow_query_threshold"),
+	}
 }
 
 func createLogger() (*zap.Logger, error) {
@@ -383,6 +883,30 @@ func createLogger() (*zap.Logger, error) {
 	return config.Build()
 }
 
+// mcpSessionScopes validates the token configured as mcp.token (CP_MCP_TOKEN)
+// and returns the scopes it grants; nil when no token is configured
+func mcpSessionScopes() ([]string, error) {
+	token := viper.GetString("mcp.token")
+	if token == "" {
+		return nil, nil
+	}
+
+	secret := viper.GetString("auth.jwt_secret")
+	if secret == "" {
+		secret = "default-secret-change-in-production"
+	}
+	jwtManager := auth.NewJWTManager(secret, viper.GetString("auth.issuer"), 0)
+	jwtManager.SetLeeway(viper.GetDuration("auth.token_leeway"))
+	claims, err := jwtManager.ValidateToken(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MCP session token: %w", err)
+	}
+	if claims.Type == "agent" {
+		return nil, fmt.Errorf("invalid MCP session token: agent tokens cannot start MCP sessions")
+	}
+	return claims.EffectiveScopes(), nil
+}
+
 func createMCPLogger() (*zap.Logger, error) {
 	// For MCP, log to stderr only
 	config := zap.NewProductionConfig()
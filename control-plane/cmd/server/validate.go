@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/db"
+	"github.com/yourorg/control-plane/pkg/encryption"
+)
+
+// Exit codes returned by validate-config
+const (
+	exitConfigValid    = 0
+	exitConfigFailed   = 1
+	exitConfigWarnings = 2
+)
+
+// defaultJWTSecret is the fallback secret used by serve when none is configured
+const defaultJWTSecret = "default-secret-change-in-production"
+
+// minJWTSecretLength is the minimum accepted HMAC secret length in bytes
+const minJWTSecretLength = 32
+
+// Check statuses
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+	checkSkip = "skip"
+)
+
+var (
+	validateOutput           string
+	validateStrict           bool
+	validateSkipConnectivity bool
+	validateTimeout          time.Duration
+)
+
+var validateConfigCmd = &cobra.Command{
+	Use:   "validate-config",
+	Short: "Validate configuration and connectivity",
+	Long: `Validate the control plane configuration without starting the server.
+
+Checks database connectivity, JWT secret strength, Quickwit reachability, the
+Piko URL and encryption keys, then prints a report. Exit codes:
+  0  all checks passed
+  1  one or more checks failed
+  2  warnings only (with --strict)`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runValidateConfig()
+	},
+}
+
+func init() {
+	validateConfigCmd.Flags().StringVarP(&validateOutput, "output", "o", "text", "output format (text, json)")
+	validateConfigCmd.Flags().BoolVar(&validateStrict, "strict", false, "treat warnings as failures")
+	validateConfigCmd.Flags().BoolVar(&validateSkipConnectivity, "skip-connectivity", false, "skip database and Quickwit connectivity checks")
+	validateConfigCmd.Flags().DurationVar(&validateTimeout, "timeout", 10*time.Second, "timeout per connectivity check")
+}
+
+// ConfigCheck is the result of a single configuration check
+type ConfigCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// ConfigReport is the result of validate-config
+type ConfigReport struct {
+	ConfigFile string        `json:"config_file,omitempty"`
+	Valid      bool          `json:"valid"`
+	Failures   int           `json:"failures"`
+	Warnings   int           `json:"warnings"`
+	Checks     []ConfigCheck `json:"checks"`
+}
+
+func (r *ConfigReport) add(name, status, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, ConfigCheck{
+		Name:    name,
+		Status:  status,
+		Message: fmt.Sprintf(format, args...),
+	})
+	switch status {
+	case checkFail:
+		r.Failures++
+	case checkWarn:
+		r.Warnings++
+	}
+}
+
+func runValidateConfig() error {
+	if validateOutput != "text" && validateOutput != "json" {
+		return fmt.Errorf("invalid output format: %s", validateOutput)
+	}
+
+	report := &ConfigReport{
+		ConfigFile: viper.ConfigFileUsed(),
+		Checks:     []ConfigCheck{},
+	}
+
+	if report.ConfigFile == "" {
+		report.add("config_file", checkWarn, "no config file found, using defaults and CP_* environment variables")
+	} else {
+		report.add("config_file", checkPass, "loaded %s", report.ConfigFile)
+	}
+
+	checkServerConfig(report)
+	checkDatabase(report)
+	checkJWTSecret(report)
+	checkQuickwit(report)
+	checkPiko(report)
+	checkEncryption(report)
+	checkAuditSigning(report)
+	checkExecutionArchive(report)
+
+	report.Valid = report.Failures == 0 && (!validateStrict || report.Warnings == 0)
+
+	if validateOutput == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return fmt.Errorf("failed to encode report: %w", err)
+		}
+	} else {
+		printConfigReport(report)
+	}
+
+	switch {
+	case report.Failures > 0:
+		os.Exit(exitConfigFailed)
+	case validateStrict && report.Warnings > 0:
+		os.Exit(exitConfigWarnings)
+	}
+	return nil
+}
+
+func printConfigReport(report *ConfigReport) {
+	for _, check := range report.Checks {
+		fmt.Printf("[%-4s] %-12s %s\n", strings.ToUpper(check.Status), check.Name, check.Message)
+	}
+	fmt.Printf("\n%d checks, %d failures, %d warnings\n", len(report.Checks), report.Failures, report.Warnings)
+	if report.Valid {
+		fmt.Println("Configuration is valid")
+	} else {
+		fmt.Println("Configuration is invalid")
+	}
+}
+
+func checkServerConfig(report *ConfigReport) {
+	port := viper.GetInt("server.port")
+	if port == 0 {
+		report.add("server", checkPass, "port not set, defaulting to 8080")
+		return
+	}
+	if port < 1 || port > 65535 {
+		report.add("server", checkFail, "server.port %d is out of range", port)
+		return
+	}
+	report.add("server", checkPass, "listening on port %d", port)
+}
+
+func checkDatabase(report *ConfigReport) {
+	dbConfig := &db.Config{
+		Host:     viper.GetString("database.host"),
+		Port:     viper.GetInt("database.port"),
+		Username: viper.GetString("database.user"),
+		Password: viper.GetString("database.password"),
+		Database: viper.GetString("database.name"),
+		LogLevel: "silent",
+	}
+
+	if dbConfig.Host == "" {
+		dbConfig.Host = "localhost"
+	}
+	if dbConfig.Port == 0 {
+		dbConfig.Port = 3306
+	}
+	if dbConfig.Username == "" {
+		dbConfig.Username = "root"
+	}
+	if dbConfig.Database == "" {
+		dbConfig.Database = "vmmanager"
+	}
+
+	if dbConfig.Port < 1 || dbConfig.Port > 65535 {
+		report.add("database", checkFail, "database.port %d is out of range", dbConfig.Port)
+		return
+	}
+	if dbConfig.Password == "" {
+		report.add("database", checkWarn, "database.password is empty")
+	}
+
+	target := fmt.Sprintf("%s@%s/%s", dbConfig.Username,
+		net.JoinHostPort(dbConfig.Host, strconv.Itoa(dbConfig.Port)), dbConfig.Database)
+
+	if validateSkipConnectivity {
+		report.add("database", checkSkip, "connectivity to %s not checked", target)
+		return
+	}
+
+	// The MySQL driver has no dial context here, so bound the attempt ourselves
+	errChan := make(chan error, 1)
+	go func() {
+		conn, err := db.NewConnection(dbConfig, zap.NewNop())
+		if err != nil {
+			errChan <- err
+			return
+		}
+		defer conn.Close()
+		errChan <- conn.Ping()
+	}()
+
+	select {
+	case err := <-errChan:
+		if err != nil {
+			report.add("database", checkFail, "cannot connect to %s: %v", target, err)
+			return
+		}
+		report.add("database", checkPass, "connected to %s", target)
+	case <-time.After(validateTimeout):
+		report.add("database", checkFail, "timed out connecting to %s after %s", target, validateTimeout)
+	}
+}
+
+func checkJWTSecret(report *ConfigReport) {
+	secret := viper.GetString("auth.jwt_secret")
+
+	switch {
+	case secret == "":
+		report.add("jwt_secret", checkFail, "auth.jwt_secret is not set; serve would fall back to the insecure default")
+		return
+	case secret == defaultJWTSecret:
+		report.add("jwt_secret", checkFail, "auth.jwt_secret is the built-in default")
+		return
+	case len(secret) < minJWTSecretLength:
+		report.add("jwt_secret", checkFail, "auth.jwt_secret is %d bytes, at least %d required", len(secret), minJWTSecretLength)
+		return
+	}
+
+	var classes, distinct int
+	var lower, upper, digit, other bool
+	seen := make(map[rune]bool)
+	for _, r := range secret {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+		if !seen[r] {
+			seen[r] = true
+			distinct++
+		}
+	}
+	for _, ok := range []bool{lower, upper, digit, other} {
+		if ok {
+			classes++
+		}
+	}
+
+	if classes < 2 || distinct < 10 {
+		report.add("jwt_secret", checkWarn, "auth.jwt_secret has low character variety; use a randomly generated value")
+		return
+	}
+	report.add("jwt_secret", checkPass, "%d bytes", len(secret))
+}
+
+func checkQuickwit(report *ConfigReport) {
+	if !viper.GetBool("quickwit.enabled") {
+		report.add("quickwit", checkSkip, "audit logging disabled")
+		return
+	}
+
+	quickwitConfig := audit.DefaultQuickwitConfig()
+	if baseURL := viper.GetString("quickwit.url"); baseURL != "" {
+		quickwitConfig.BaseURL = baseURL
+	}
+	if indexID := viper.GetString("quickwit.index_id"); indexID != "" {
+		quickwitConfig.IndexID = indexID
+	}
+
+	if err := validateHTTPURL(quickwitConfig.BaseURL); err != nil {
+		report.add("quickwit", checkFail, "quickwit.url: %v", err)
+		return
+	}
+
+	if validateSkipConnectivity {
+		report.add("quickwit", checkSkip, "reachability of %s not checked", quickwitConfig.BaseURL)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+	defer cancel()
+
+	client := audit.NewQuickwitClient(quickwitConfig, zap.NewNop())
+	if err := client.HealthCheck(ctx); err != nil {
+		report.add("quickwit", checkFail, "%s is not reachable: %v", quickwitConfig.BaseURL, err)
+		return
+	}
+	report.add("quickwit", checkPass, "%s is ready", quickwitConfig.BaseURL)
+}
+
+func checkPiko(report *ConfigReport) {
+	if pikoURL := viper.GetString("piko.url"); pikoURL != "" {
+		if err := validateHTTPURL(pikoURL); err != nil {
+			report.add("piko", checkFail, "piko.url: %v", err)
+			return
+		}
+		report.add("piko", checkPass, "proxy at %s", pikoURL)
+		return
+	}
+
+	endpoint := viper.GetString("piko.endpoint")
+	if endpoint == "" {
+		report.add("piko", checkWarn, "neither piko.url nor piko.endpoint is set; workflows cannot be dispatched to agents")
+		return
+	}
+
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil || host == "" {
+		report.add("piko", checkFail, "piko.endpoint %q must be host:port", endpoint)
+		return
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		report.add("piko", checkFail, "piko.endpoint %q has an invalid port", endpoint)
+		return
+	}
+	report.add("piko", checkPass, "proxy at http://%s", endpoint)
+}
+
+func checkEncryption(report *ConfigReport) {
+	cfg := encryptionConfig()
+	if !cfg.Enabled {
+		report.add("encryption", checkWarn, "column encryption disabled; tenant settings and template content are stored in plaintext")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+	defer cancel()
+
+	keyRing, err := encryption.LoadKeyRing(ctx, cfg)
+	if err != nil {
+		report.add("encryption", checkFail, "%v", err)
+		return
+	}
+	report.add("encryption", checkPass, "active key %s", keyRing.ActiveKeyID())
+}
+
+func checkAuditSigning(report *ConfigReport) {
+	encoded := viper.GetString("audit.export_signing_key")
+	if encoded == "" {
+		report.add("audit_signing", checkSkip, "audit.export_signing_key not set; signed audit exports are unavailable")
+		return
+	}
+	if _, err := audit.ParseSigningKey(encoded); err != nil {
+		report.add("audit_signing", checkFail, "audit.export_signing_key: %v", err)
+		return
+	}
+	report.add("audit_signing", checkPass, "export signing key configured")
+}
+
+func checkExecutionArchive(report *ConfigReport) {
+	if !viper.GetBool("executions.archive.enabled") {
+		report.add("execution_archive", checkSkip, "execution output archival disabled")
+		return
+	}
+	store, err := newArchiveStore()
+	if err != nil {
+		report.add("execution_archive", checkFail, "executions.archive: %v", err)
+		return
+	}
+	report.add("execution_archive", checkPass, "archiving to %s", store)
+}
+
+// validateHTTPURL checks that raw is an absolute http(s) URL with a host
+func validateHTTPURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %w", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("URL %q must use http or https", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("URL %q has no host", raw)
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+-- Template lint results (content-type-aware validation)
+-- MySQL 8.0+
+
+ALTER TABLE templates
+    ADD COLUMN lint_status VARCHAR(20) AFTER metadata,
+    ADD COLUMN lint_results JSON AFTER lint_status;
+
+CREATE INDEX idx_templates_lint_status ON templates(tenant_id, lint_status);
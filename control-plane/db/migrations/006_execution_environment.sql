@@ -0,0 +1,5 @@
+-- Execution environment snapshots (facts captured by the agent at workflow start)
+-- MySQL 8.0+
+
+ALTER TABLE workflow_executions
+    ADD COLUMN environment JSON AFTER result;
@@ -0,0 +1,19 @@
+-- Campaign progress timeline (per-phase snapshots, one per interval)
+-- MySQL 8.0+
+
+CREATE TABLE IF NOT EXISTS campaign_progress_snapshots (
+    id VARCHAR(64) PRIMARY KEY,
+    campaign_id VARCHAR(64) NOT NULL,
+    phase_name VARCHAR(64) NOT NULL,
+    phase_order INT NOT NULL,
+    phase_status VARCHAR(20) NOT NULL,
+    target_count INT NOT NULL DEFAULT 0,
+    success_count INT NOT NULL DEFAULT 0,
+    failure_count INT NOT NULL DEFAULT 0,
+    in_flight_count INT NOT NULL DEFAULT 0,
+    recorded_at TIMESTAMP NOT NULL,
+    UNIQUE KEY uk_campaign_snapshot (campaign_id, phase_name, recorded_at),
+    FOREIGN KEY (campaign_id) REFERENCES campaigns(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_campaign_snapshots_recorded ON campaign_progress_snapshots(campaign_id, recorded_at);
@@ -0,0 +1,5 @@
+-- Field-level encryption of sensitive columns
+-- MySQL 8.0+
+
+-- Encrypted tenant settings are stored as text envelopes, not JSON documents
+ALTER TABLE tenants MODIFY COLUMN settings MEDIUMTEXT;
@@ -0,0 +1,8 @@
+-- Agent drain mode (no new executions are dispatched to draining agents)
+-- MySQL 8.0+
+
+ALTER TABLE agents
+    ADD COLUMN drain_state ENUM('none', 'draining', 'drained') NOT NULL DEFAULT 'none' AFTER last_seen_at,
+    ADD COLUMN drain_requested_at TIMESTAMP NULL AFTER drain_state,
+    ADD COLUMN drained_at TIMESTAMP NULL AFTER drain_requested_at,
+    ADD INDEX idx_agents_drain_state (drain_state);
@@ -0,0 +1,19 @@
+-- Agent health history (overall and per-component status transitions)
+-- MySQL 8.0+
+
+CREATE TABLE IF NOT EXISTS agent_health_history (
+    id VARCHAR(64) PRIMARY KEY,
+    agent_id VARCHAR(64) NOT NULL,
+    tenant_id VARCHAR(64) NOT NULL,
+    component VARCHAR(128) NOT NULL DEFAULT '',
+    from_status VARCHAR(32) NOT NULL DEFAULT '',
+    to_status VARCHAR(32) NOT NULL,
+    message TEXT,
+    changed_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (agent_id) REFERENCES agents(id) ON DELETE CASCADE,
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_agent_health_history_agent ON agent_health_history(agent_id, changed_at);
+CREATE INDEX idx_agent_health_history_tenant ON agent_health_history(tenant_id, component, changed_at);
+CREATE INDEX idx_agent_health_history_changed_at ON agent_health_history(changed_at);
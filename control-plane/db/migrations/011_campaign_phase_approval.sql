@@ -0,0 +1,9 @@
+-- Manual approval gates between campaign phases
+-- MySQL 8.0+
+
+ALTER TABLE campaign_phases
+    MODIFY COLUMN status ENUM('pending', 'running', 'success', 'failed', 'cancelled', 'awaiting_approval') NOT NULL DEFAULT 'pending',
+    ADD COLUMN manual_approval BOOLEAN NOT NULL DEFAULT FALSE AFTER completed_at,
+    ADD COLUMN approved_by VARCHAR(255) NULL AFTER manual_approval,
+    ADD COLUMN approved_at TIMESTAMP NULL AFTER approved_by,
+    ADD COLUMN approval_note TEXT NULL AFTER approved_at;
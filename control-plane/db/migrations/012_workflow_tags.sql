@@ -0,0 +1,8 @@
+-- Workflow tags and indexes for workflow search
+-- MySQL 8.0+
+
+ALTER TABLE workflows
+    ADD COLUMN tags JSON NULL AFTER status;
+
+CREATE INDEX idx_workflows_created_by ON workflows(tenant_id, created_by);
+CREATE INDEX idx_workflow_executions_workflow_created ON workflow_executions(workflow_id, created_at);
@@ -0,0 +1,5 @@
+-- Per-tenant cap on in-flight campaign executions; 0 uses the configured default
+-- MySQL 8.0+
+
+ALTER TABLE tenants
+    ADD COLUMN quota_concurrent_executions INT NOT NULL DEFAULT 0 AFTER quota_workflows;
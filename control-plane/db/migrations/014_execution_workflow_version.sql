@@ -0,0 +1,6 @@
+-- Workflow version each execution ran, for duration trends across versions
+-- MySQL 8.0+
+
+ALTER TABLE workflow_executions
+    ADD COLUMN workflow_version INT NOT NULL DEFAULT 0 AFTER workflow_id,
+    ADD INDEX idx_workflow_executions_workflow_version (workflow_id, workflow_version);
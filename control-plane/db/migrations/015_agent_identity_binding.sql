@@ -0,0 +1,8 @@
+-- Agent identity binding (agent tokens are bound to a key generated on the agent)
+-- MySQL 8.0+
+
+ALTER TABLE agents
+    ADD COLUMN public_key VARCHAR(64) NOT NULL DEFAULT '' AFTER updated_at,
+    ADD COLUMN key_fingerprint VARCHAR(64) NOT NULL DEFAULT '' AFTER public_key,
+    ADD COLUMN bound_at TIMESTAMP NULL AFTER key_fingerprint,
+    ADD INDEX idx_agents_key_fingerprint (key_fingerprint);
@@ -0,0 +1,9 @@
+-- Audit log hash chain heads (one per tenant; events are stored in Quickwit)
+-- MySQL 8.0+
+
+CREATE TABLE IF NOT EXISTS audit_chain_heads (
+    tenant_id VARCHAR(64) PRIMARY KEY,
+    sequence BIGINT NOT NULL,
+    last_hash CHAR(64) NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
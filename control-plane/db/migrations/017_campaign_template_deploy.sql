@@ -0,0 +1,8 @@
+-- Campaign types (template_deploy campaigns roll a template out through a
+-- workflow generated for the campaign)
+-- MySQL 8.0+
+
+ALTER TABLE campaigns
+    ADD COLUMN campaign_type VARCHAR(32) NOT NULL DEFAULT 'workflow' AFTER updated_at,
+    ADD COLUMN deployment JSON NULL AFTER campaign_type,
+    ADD INDEX idx_campaigns_type (tenant_id, campaign_type);
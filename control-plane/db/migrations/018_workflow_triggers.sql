@@ -0,0 +1,28 @@
+-- Workflow triggers (run a workflow on the same agent when an execution of
+-- another workflow finishes)
+-- MySQL 8.0+
+
+CREATE TABLE IF NOT EXISTS workflow_triggers (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    source_workflow_id VARCHAR(64) NOT NULL,
+    target_workflow_id VARCHAR(64) NOT NULL,
+    trigger_on VARCHAR(16) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    description TEXT,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    UNIQUE KEY uniq_workflow_triggers_edge (tenant_id, source_workflow_id, target_workflow_id, trigger_on),
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE,
+    FOREIGN KEY (source_workflow_id) REFERENCES workflows(id) ON DELETE CASCADE,
+    FOREIGN KEY (target_workflow_id) REFERENCES workflows(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_workflow_triggers_source ON workflow_triggers(source_workflow_id, enabled);
+
+ALTER TABLE workflow_executions
+    ADD COLUMN triggered_by_execution_id VARCHAR(64) NULL AFTER workflow_version,
+    ADD COLUMN trigger_depth INT NOT NULL DEFAULT 0 AFTER triggered_by_execution_id,
+    ADD COLUMN triggers_fired BOOLEAN NOT NULL DEFAULT FALSE AFTER trigger_depth,
+    ADD INDEX idx_workflow_executions_triggered_by (triggered_by_execution_id);
@@ -0,0 +1,8 @@
+-- Execution output archival (output of old executions is moved to object
+-- storage; the key of the archived object is kept here)
+-- MySQL 8.0+
+
+ALTER TABLE workflow_executions
+    ADD COLUMN archive_key VARCHAR(512) NULL AFTER triggers_fired,
+    ADD COLUMN archived_at TIMESTAMP NULL AFTER archive_key,
+    ADD INDEX idx_workflow_executions_archive (archived_at, completed_at);
@@ -0,0 +1,6 @@
+-- Tenant workflow policy (defaults and ceilings applied when workflows are
+-- created or updated)
+-- MySQL 8.0+
+
+ALTER TABLE tenants
+    ADD COLUMN workflow_policy JSON NULL AFTER quota_concurrent_executions;
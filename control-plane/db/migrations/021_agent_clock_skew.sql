@@ -0,0 +1,9 @@
+-- Heartbeat latency and clock skew reported by agents
+-- MySQL 8.0+
+
+ALTER TABLE agents
+    ADD COLUMN latency_ms BIGINT NULL AFTER bound_at,
+    ADD COLUMN clock_skew_ms BIGINT NULL AFTER latency_ms,
+    ADD COLUMN clock_skewed BOOLEAN NOT NULL DEFAULT FALSE AFTER clock_skew_ms,
+    ADD COLUMN clock_checked_at TIMESTAMP NULL AFTER clock_skewed,
+    ADD INDEX idx_agents_clock_skewed (clock_skewed);
@@ -0,0 +1,6 @@
+-- Host facts (grains) reported by agents, used for template render previews
+-- MySQL 8.0+
+
+ALTER TABLE agents
+    ADD COLUMN grains JSON NULL AFTER clock_checked_at,
+    ADD COLUMN grains_updated_at TIMESTAMP NULL AFTER grains;
@@ -0,0 +1,27 @@
+-- Dispatch outbox (executions waiting to be delivered to their agent)
+-- MySQL 8.0+
+
+CREATE TABLE IF NOT EXISTS dispatch_jobs (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    execution_id VARCHAR(64) NOT NULL,
+    agent_id VARCHAR(64) NOT NULL,
+    priority VARCHAR(16) NOT NULL DEFAULT '',
+    payload JSON NOT NULL,
+    status ENUM('pending', 'in_progress', 'delivered', 'cancelled', 'dead') NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP(3) NOT NULL,
+    locked_by VARCHAR(64) NOT NULL DEFAULT '',
+    failure_class VARCHAR(32) NOT NULL DEFAULT '',
+    last_error TEXT,
+    delivered_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    UNIQUE KEY uniq_dispatch_jobs_execution (execution_id),
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE,
+    FOREIGN KEY (execution_id) REFERENCES workflow_executions(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_dispatch_jobs_due ON dispatch_jobs(status, next_attempt_at);
+CREATE INDEX idx_dispatch_jobs_tenant ON dispatch_jobs(tenant_id);
+CREATE INDEX idx_dispatch_jobs_agent ON dispatch_jobs(agent_id);
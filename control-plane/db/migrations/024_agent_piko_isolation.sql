@@ -0,0 +1,8 @@
+-- Per-agent Piko endpoints with a random suffix, and the key agents verify
+-- dispatch tokens with (encrypted with the tenant key)
+-- MySQL 8.0+
+
+ALTER TABLE agents
+    ADD COLUMN piko_endpoint VARCHAR(191) NULL AFTER bound_at,
+    ADD COLUMN dispatch_key TEXT NULL AFTER piko_endpoint,
+    ADD INDEX idx_agents_piko_endpoint (piko_endpoint);
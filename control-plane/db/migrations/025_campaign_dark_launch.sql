@@ -0,0 +1,9 @@
+-- Dark-launch campaigns, which run validate executions that only report what
+-- they would change
+-- MySQL 8.0+
+
+ALTER TABLE campaigns
+    ADD COLUMN campaign_mode VARCHAR(32) NOT NULL DEFAULT 'live' AFTER deployment;
+
+ALTER TABLE workflow_executions
+    ADD COLUMN execution_mode VARCHAR(16) NOT NULL DEFAULT 'live' AFTER workflow_version;
@@ -0,0 +1,43 @@
+-- Git repositories workflows and templates are synced from, and the
+-- provenance of synced resources
+-- MySQL 8.0+
+
+CREATE TABLE IF NOT EXISTS git_repositories (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    url VARCHAR(1024) NOT NULL,
+    branch VARCHAR(255) NOT NULL,
+    path VARCHAR(512) NOT NULL DEFAULT '',
+    deploy_key TEXT,
+    deploy_key_fingerprint VARCHAR(128) NOT NULL DEFAULT '',
+    known_hosts TEXT,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    last_sync_at TIMESTAMP NULL,
+    last_commit VARCHAR(64) NOT NULL DEFAULT '',
+    last_status VARCHAR(20) NOT NULL DEFAULT '',
+    last_error TEXT,
+    last_result JSON,
+    sync_started_at TIMESTAMP NULL,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    UNIQUE KEY uniq_git_repositories_tenant_name (tenant_id, name),
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_git_repositories_due ON git_repositories(enabled, last_sync_at);
+
+ALTER TABLE workflows
+    ADD COLUMN source_repository_id VARCHAR(64) NULL AFTER tags,
+    ADD COLUMN source_path VARCHAR(512) NOT NULL DEFAULT '' AFTER source_repository_id,
+    ADD COLUMN source_commit VARCHAR(64) NOT NULL DEFAULT '' AFTER source_path;
+
+CREATE INDEX idx_workflows_source ON workflows(source_repository_id, source_path);
+
+ALTER TABLE templates
+    ADD COLUMN source_repository_id VARCHAR(64) NULL AFTER lint_results,
+    ADD COLUMN source_path VARCHAR(512) NOT NULL DEFAULT '' AFTER source_repository_id,
+    ADD COLUMN source_commit VARCHAR(64) NOT NULL DEFAULT '' AFTER source_path;
+
+CREATE INDEX idx_templates_source ON templates(source_repository_id, source_path);
@@ -0,0 +1,45 @@
+-- Agent configuration profiles, their assignment and rollout history
+-- MySQL 8.0+
+
+CREATE TABLE IF NOT EXISTS config_profiles (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    settings JSON NOT NULL,
+    selector JSON,
+    priority INT NOT NULL DEFAULT 0,
+    version INT NOT NULL DEFAULT 1,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    UNIQUE KEY uniq_config_profiles_tenant_name (tenant_id, name),
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS agent_config_rollouts (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    agent_id VARCHAR(64) NOT NULL,
+    profile_id VARCHAR(64) NOT NULL,
+    profile_version INT NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    error TEXT,
+    pending_restart JSON,
+    reported_at TIMESTAMP NOT NULL,
+    INDEX idx_agent_config_rollouts_tenant (tenant_id),
+    INDEX idx_agent_config_rollouts_agent (agent_id, reported_at),
+    INDEX idx_agent_config_rollouts_profile (profile_id, reported_at),
+    FOREIGN KEY (agent_id) REFERENCES agents(id) ON DELETE CASCADE,
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+ALTER TABLE agents
+    ADD COLUMN config_profile_id VARCHAR(64) NULL AFTER grains_updated_at,
+    ADD COLUMN applied_profile_id VARCHAR(64) NOT NULL DEFAULT '' AFTER config_profile_id,
+    ADD COLUMN applied_profile_version INT NOT NULL DEFAULT 0 AFTER applied_profile_id,
+    ADD COLUMN config_status VARCHAR(20) NOT NULL DEFAULT '' AFTER applied_profile_version,
+    ADD COLUMN config_error TEXT AFTER config_status,
+    ADD COLUMN effective_config JSON NULL AFTER config_error,
+    ADD COLUMN config_reported_at TIMESTAMP NULL AFTER effective_config,
+    ADD INDEX idx_agents_config_profile (config_profile_id);
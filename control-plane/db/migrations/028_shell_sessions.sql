@@ -0,0 +1,39 @@
+-- Live agent shell sessions and their recordings
+-- MySQL 8.0+
+
+ALTER TABLE tenants
+    ADD COLUMN shell_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS shell_sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    agent_id VARCHAR(64) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    remote_addr VARCHAR(255),
+    end_reason VARCHAR(64),
+    exit_code INT,
+    bytes_in BIGINT NOT NULL DEFAULT 0,
+    bytes_out BIGINT NOT NULL DEFAULT 0,
+    recording_bytes BIGINT NOT NULL DEFAULT 0,
+    started_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP NULL,
+    INDEX idx_shell_sessions_tenant (tenant_id),
+    INDEX idx_shell_sessions_agent (agent_id),
+    INDEX idx_shell_sessions_status (status),
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Recording chunks hold encrypted JSON events
+CREATE TABLE IF NOT EXISTS shell_recording_chunks (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    session_id VARCHAR(64) NOT NULL,
+    seq INT NOT NULL,
+    events MEDIUMTEXT,
+    bytes BIGINT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE KEY uniq_shell_recording_chunks_seq (session_id, seq),
+    INDEX idx_shell_recording_chunks_tenant (tenant_id),
+    FOREIGN KEY (session_id) REFERENCES shell_sessions(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
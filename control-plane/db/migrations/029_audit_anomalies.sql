@@ -0,0 +1,23 @@
+-- Anomalies found by analysing the audit log
+-- MySQL 8.0+
+
+-- No foreign key on tenant_id: failed logins naming no tenant are analysed
+-- under the system tenant
+CREATE TABLE IF NOT EXISTS audit_anomalies (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    kind VARCHAR(32) NOT NULL,
+    severity VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    subject VARCHAR(255),
+    open_key VARCHAR(64),
+    observed BIGINT NOT NULL,
+    baseline DOUBLE NOT NULL,
+    details JSON,
+    detected_at TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NOT NULL,
+    resolved_at TIMESTAMP NULL,
+    UNIQUE KEY uniq_audit_anomalies_open_key (open_key),
+    INDEX idx_audit_anomalies_tenant (tenant_id),
+    INDEX idx_audit_anomalies_status (status)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,24 @@
+-- Monthly execution budgets per tenant and the alerts raised against them
+-- MySQL 8.0+
+
+ALTER TABLE tenants
+    ADD COLUMN quota_monthly_executions INT NOT NULL DEFAULT 0 AFTER quota_concurrent_executions,
+    ADD COLUMN quota_monthly_minutes INT NOT NULL DEFAULT 0 AFTER quota_monthly_executions,
+    ADD COLUMN quota_hard_cap BOOLEAN NOT NULL DEFAULT FALSE AFTER quota_monthly_minutes;
+
+-- Usage is measured from the executions created in the month
+CREATE INDEX idx_workflow_executions_tenant_created ON workflow_executions(tenant_id, created_at);
+
+-- One row per budget threshold crossed, so each alert fires once a month
+CREATE TABLE IF NOT EXISTS tenant_budget_alerts (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    month CHAR(7) NOT NULL,
+    metric VARCHAR(20) NOT NULL,
+    threshold INT NOT NULL,
+    used DOUBLE NOT NULL,
+    quota INT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE KEY uniq_tenant_budget_alerts (tenant_id, month, metric, threshold),
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
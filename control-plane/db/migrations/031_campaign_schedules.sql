@@ -0,0 +1,9 @@
+-- Campaign schedules and dispatch windows, set through the API or declared
+-- in documents applied with POST /api/v1/apply
+-- MySQL 8.0+
+
+ALTER TABLE campaigns
+    ADD COLUMN scheduled_at TIMESTAMP NULL AFTER campaign_mode,
+    ADD COLUMN rollout_window JSON NULL AFTER scheduled_at,
+    ADD INDEX idx_campaigns_scheduled (status, scheduled_at),
+    ADD INDEX idx_campaigns_name (tenant_id, name);
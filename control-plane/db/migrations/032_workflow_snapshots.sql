@@ -0,0 +1,22 @@
+-- Workflow definition snapshots, so executions record the definition they
+-- ran and campaigns dispatch the definition they were started with
+-- MySQL 8.0+
+
+CREATE TABLE IF NOT EXISTS workflow_snapshots (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    workflow_id VARCHAR(64) NOT NULL,
+    definition_hash CHAR(64) NOT NULL,
+    workflow_version INT NOT NULL,
+    definition JSON NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE KEY uniq_workflow_snapshots (tenant_id, workflow_id, definition_hash),
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE,
+    FOREIGN KEY (workflow_id) REFERENCES workflows(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+ALTER TABLE workflow_executions
+    ADD COLUMN definition_hash CHAR(64) NULL AFTER workflow_version;
+
+ALTER TABLE campaigns
+    ADD COLUMN definition_hash CHAR(64) NULL AFTER rollout_window;
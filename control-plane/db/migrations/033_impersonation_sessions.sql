@@ -0,0 +1,20 @@
+-- Impersonation sessions of platform admins operating as a tenant
+-- MySQL 8.0+
+
+CREATE TABLE IF NOT EXISTS impersonation_sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    admin_id VARCHAR(255) NOT NULL,
+    admin_tenant_id VARCHAR(64) NULL,
+    reason TEXT NOT NULL,
+    scopes JSON NULL,
+    remote_addr VARCHAR(255) NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP NULL,
+    ended_by VARCHAR(255) NULL,
+    INDEX idx_impersonation_sessions_tenant (tenant_id),
+    INDEX idx_impersonation_sessions_admin (admin_id),
+    INDEX idx_impersonation_sessions_expires (expires_at),
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
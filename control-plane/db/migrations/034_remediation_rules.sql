@@ -0,0 +1,42 @@
+-- Remediation workflows run automatically on agents reporting matching health
+-- MySQL 8.0+
+
+ALTER TABLE tenants
+    ADD COLUMN remediation_paused BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS remediation_rules (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NULL,
+    component VARCHAR(128) NOT NULL DEFAULT '',
+    status VARCHAR(32) NOT NULL,
+    workflow_id VARCHAR(64) NOT NULL,
+    cooldown_minutes INT NOT NULL DEFAULT 30,
+    max_runs_per_day INT NOT NULL DEFAULT 3,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by VARCHAR(255) NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    INDEX idx_remediation_rules_tenant (tenant_id),
+    INDEX idx_remediation_rules_workflow (workflow_id),
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE,
+    FOREIGN KEY (workflow_id) REFERENCES workflows(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS remediation_runs (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    rule_id VARCHAR(64) NOT NULL,
+    agent_id VARCHAR(64) NOT NULL,
+    execution_id VARCHAR(64) NULL,
+    component VARCHAR(128) NOT NULL DEFAULT '',
+    health_status VARCHAR(32) NOT NULL,
+    status VARCHAR(32) NOT NULL,
+    message TEXT NULL,
+    started_at TIMESTAMP NOT NULL,
+    INDEX idx_remediation_runs_tenant (tenant_id),
+    INDEX idx_remediation_runs_rule (rule_id, agent_id, started_at),
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE,
+    FOREIGN KEY (rule_id) REFERENCES remediation_rules(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
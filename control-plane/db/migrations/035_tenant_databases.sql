@@ -0,0 +1,21 @@
+-- Catalog of tenants whose operational data lives in their own database
+-- MySQL 8.0+
+
+CREATE TABLE IF NOT EXISTS tenant_databases (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    dsn TEXT NOT NULL,
+    host VARCHAR(255) NULL,
+    `database` VARCHAR(255) NULL,
+    max_open_conns INT NOT NULL DEFAULT 0,
+    max_idle_conns INT NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    schema_version VARCHAR(64) NULL,
+    last_migrated_at TIMESTAMP NULL,
+    last_error TEXT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    UNIQUE INDEX idx_tenant_databases_tenant (tenant_id),
+    INDEX idx_tenant_databases_status (status),
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
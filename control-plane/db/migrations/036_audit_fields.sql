@@ -0,0 +1,18 @@
+-- Custom structured fields tenants attach to their audit events
+-- MySQL 8.0+
+
+CREATE TABLE IF NOT EXISTS audit_fields (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    type VARCHAR(16) NOT NULL,
+    description TEXT NULL,
+    required BOOLEAN NOT NULL DEFAULT FALSE,
+    allowed_values JSON NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'active',
+    created_by VARCHAR(255) NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    UNIQUE INDEX idx_audit_fields_tenant_name (tenant_id, name),
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,13 @@
+-- Agent registration approval (tenants requiring approval admit new agents
+-- pending until an admin or an auto-approve rule approves them)
+-- MySQL 8.0+
+
+ALTER TABLE tenants
+    ADD COLUMN registration_policy JSON NULL AFTER workflow_policy;
+
+ALTER TABLE agents
+    ADD COLUMN approval_status ENUM('approved', 'pending', 'rejected') NOT NULL DEFAULT 'approved' AFTER clock_checked_at,
+    ADD COLUMN approved_by VARCHAR(255) NULL AFTER approval_status,
+    ADD COLUMN approved_at TIMESTAMP NULL AFTER approved_by,
+    ADD COLUMN registration_ip VARCHAR(45) NULL AFTER approved_at,
+    ADD INDEX idx_agents_approval_status (tenant_id, approval_status);
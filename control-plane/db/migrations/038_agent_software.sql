@@ -0,0 +1,41 @@
+-- Software inventory (packages reported by agents, with their change history)
+-- MySQL 8.0+
+
+ALTER TABLE agents
+    ADD COLUMN software_hash VARCHAR(80) NULL AFTER grains_updated_at,
+    ADD COLUMN software_packages INT NOT NULL DEFAULT 0 AFTER software_hash,
+    ADD COLUMN software_reported_at TIMESTAMP NULL AFTER software_packages;
+
+CREATE TABLE IF NOT EXISTS agent_software (
+    id VARCHAR(64) PRIMARY KEY,
+    agent_id VARCHAR(64) NOT NULL,
+    tenant_id VARCHAR(64) NOT NULL,
+    source VARCHAR(16) NOT NULL,
+    name VARCHAR(191) NOT NULL,
+    arch VARCHAR(32) NOT NULL DEFAULT '',
+    version VARCHAR(191) NOT NULL,
+    installed_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    UNIQUE KEY idx_agent_software_package (agent_id, source, name, arch),
+    INDEX idx_agent_software_name (tenant_id, name),
+    FOREIGN KEY (agent_id) REFERENCES agents(id) ON DELETE CASCADE,
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS agent_software_changes (
+    id VARCHAR(64) PRIMARY KEY,
+    agent_id VARCHAR(64) NOT NULL,
+    tenant_id VARCHAR(64) NOT NULL,
+    source VARCHAR(16) NOT NULL,
+    name VARCHAR(191) NOT NULL,
+    arch VARCHAR(32) NOT NULL DEFAULT '',
+    `change` VARCHAR(16) NOT NULL,
+    version VARCHAR(191) NOT NULL DEFAULT '',
+    previous_version VARCHAR(191) NOT NULL DEFAULT '',
+    recorded_at TIMESTAMP NOT NULL,
+    INDEX idx_agent_software_changes_agent (agent_id, recorded_at),
+    INDEX idx_agent_software_changes_tenant (tenant_id),
+    INDEX idx_agent_software_changes_recorded (recorded_at),
+    FOREIGN KEY (agent_id) REFERENCES agents(id) ON DELETE CASCADE,
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,59 @@
+-- Security advisories from OSV and NVD feeds, matched against agents'
+-- software inventory
+-- MySQL 8.0+
+
+CREATE TABLE IF NOT EXISTS advisories (
+    id VARCHAR(128) PRIMARY KEY,
+    source VARCHAR(16) NOT NULL,
+    cve_id VARCHAR(32) NULL,
+    aliases JSON NULL,
+    summary TEXT NULL,
+    severity VARCHAR(16) NOT NULL DEFAULT 'unknown',
+    cvss_score DOUBLE NULL,
+    `references` JSON NULL,
+    published_at TIMESTAMP NULL,
+    modified_at TIMESTAMP NOT NULL,
+    imported_at TIMESTAMP NOT NULL,
+    INDEX idx_advisories_cve (cve_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS advisory_ranges (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    advisory_id VARCHAR(128) NOT NULL,
+    source VARCHAR(16) NOT NULL DEFAULT '',
+    name VARCHAR(191) NOT NULL,
+    `release` VARCHAR(64) NOT NULL DEFAULT '',
+    introduced VARCHAR(191) NOT NULL DEFAULT '',
+    fixed VARCHAR(191) NOT NULL DEFAULT '',
+    last_affected VARCHAR(191) NOT NULL DEFAULT '',
+    INDEX idx_advisory_ranges_advisory (advisory_id),
+    INDEX idx_advisory_ranges_package (name, source),
+    FOREIGN KEY (advisory_id) REFERENCES advisories(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS vulnerability_findings (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    agent_id VARCHAR(64) NOT NULL,
+    advisory_id VARCHAR(128) NOT NULL,
+    cve_id VARCHAR(32) NULL,
+    severity VARCHAR(16) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    open_key VARCHAR(64) NULL,
+    source VARCHAR(16) NOT NULL,
+    package_name VARCHAR(191) NOT NULL,
+    arch VARCHAR(32) NOT NULL DEFAULT '',
+    installed_version VARCHAR(191) NOT NULL,
+    fixed_version VARCHAR(191) NOT NULL DEFAULT '',
+    detected_at TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NOT NULL,
+    resolved_at TIMESTAMP NULL,
+    UNIQUE INDEX idx_vulnerability_findings_open_key (open_key),
+    INDEX idx_vulnerability_findings_tenant (tenant_id, status),
+    INDEX idx_vulnerability_findings_cve (cve_id, status),
+    INDEX idx_vulnerability_findings_agent (agent_id),
+    INDEX idx_vulnerability_findings_advisory (advisory_id),
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE,
+    FOREIGN KEY (agent_id) REFERENCES agents(id) ON DELETE CASCADE,
+    FOREIGN KEY (advisory_id) REFERENCES advisories(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,47 @@
+-- Workflow hooks (actions the control plane takes when an execution of a
+-- workflow finishes) and the runs they recorded
+-- MySQL 8.0+
+
+CREATE TABLE IF NOT EXISTS workflow_hooks (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    workflow_id VARCHAR(64) NOT NULL,
+    hook_on VARCHAR(16) NOT NULL,
+    action VARCHAR(16) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    description TEXT,
+    url VARCHAR(2048),
+    method VARCHAR(8),
+    headers MEDIUMTEXT,
+    body TEXT,
+    target_workflow_id VARCHAR(64),
+    target_agent_id VARCHAR(255),
+    priority VARCHAR(16),
+    created_by VARCHAR(255),
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE,
+    FOREIGN KEY (workflow_id) REFERENCES workflows(id) ON DELETE CASCADE,
+    FOREIGN KEY (target_workflow_id) REFERENCES workflows(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_workflow_hooks_workflow ON workflow_hooks(workflow_id, enabled);
+
+CREATE TABLE IF NOT EXISTS workflow_hook_runs (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    hook_id VARCHAR(64) NOT NULL,
+    execution_id VARCHAR(64) NOT NULL,
+    action VARCHAR(16) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    status_code INT NOT NULL DEFAULT 0,
+    triggered_execution_id VARCHAR(64) NULL,
+    error TEXT,
+    started_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP NOT NULL,
+    UNIQUE KEY uniq_workflow_hook_runs (hook_id, execution_id),
+    INDEX idx_workflow_hook_runs_execution (execution_id),
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE,
+    FOREIGN KEY (hook_id) REFERENCES workflow_hooks(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,14 @@
+-- Zone-aware campaigns: phases run one zone of agents, grouped by a tag,
+-- at a time
+-- MySQL 8.0+
+
+ALTER TABLE campaigns
+    ADD COLUMN zone_config JSON NULL AFTER definition_hash;
+
+ALTER TABLE campaign_phases
+    ADD COLUMN config_index INT NOT NULL DEFAULT 0 AFTER phase_order,
+    ADD COLUMN zone VARCHAR(255) NOT NULL DEFAULT '' AFTER config_index,
+    ADD INDEX idx_campaign_phases_zone (campaign_id, zone);
+
+-- Phases of existing campaigns are their phase config entries in order
+UPDATE campaign_phases SET config_index = phase_order;
@@ -0,0 +1,43 @@
+-- Workflow and template catalog: entries the platform tenant publishes to
+-- every tenant, and the copies tenants made of them
+-- MySQL 8.0+
+
+CREATE TABLE IF NOT EXISTS catalog_entries (
+    id VARCHAR(64) PRIMARY KEY,
+    kind VARCHAR(16) NOT NULL,
+    source_tenant_id VARCHAR(64) NOT NULL,
+    source_id VARCHAR(64) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    version INT NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    tags JSON,
+    definition JSON,
+    content LONGTEXT,
+    content_type VARCHAR(100),
+    release_note TEXT,
+    published_by VARCHAR(255),
+    published_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    UNIQUE KEY uniq_catalog_entries_source (kind, source_id),
+    INDEX idx_catalog_entries_status (status, kind, name),
+    FOREIGN KEY (source_tenant_id) REFERENCES tenants(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS catalog_copies (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    entry_id VARCHAR(64) NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    target_id VARCHAR(64) NOT NULL,
+    version INT NOT NULL,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    UNIQUE KEY uniq_catalog_copies_target (kind, target_id),
+    INDEX idx_catalog_copies_entry (entry_id),
+    INDEX idx_catalog_copies_tenant (tenant_id),
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE,
+    FOREIGN KEY (entry_id) REFERENCES catalog_entries(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
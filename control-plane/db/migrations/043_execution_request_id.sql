@@ -0,0 +1,7 @@
+-- Request IDs on executions, tracing each execution to the API request or
+-- MCP tool call that started it
+-- MySQL 8.0+
+
+ALTER TABLE workflow_executions
+    ADD COLUMN request_id VARCHAR(128) NULL AFTER execution_mode,
+    ADD INDEX idx_workflow_executions_request_id (request_id);
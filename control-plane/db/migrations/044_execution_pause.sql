@@ -0,0 +1,6 @@
+-- Paused executions: workflows their agent stopped between steps until
+-- they are resumed
+-- MySQL 8.0+
+
+ALTER TABLE workflow_executions
+    MODIFY COLUMN status ENUM('pending', 'running', 'success', 'failed', 'cancelled', 'timeout', 'paused') NOT NULL DEFAULT 'pending';
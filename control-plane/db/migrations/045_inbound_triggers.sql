@@ -0,0 +1,44 @@
+-- Inbound triggers (external systems start a workflow on selected agents by
+-- posting to the trigger's URL) and the invocations they received
+-- MySQL 8.0+
+
+CREATE TABLE IF NOT EXISTS inbound_triggers (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NULL,
+    workflow_id VARCHAR(64) NOT NULL,
+    target_selector JSON NOT NULL,
+    parameters JSON NULL,
+    secret TEXT NOT NULL,
+    max_agents INT NOT NULL DEFAULT 10,
+    max_invocations_per_hour INT NOT NULL DEFAULT 60,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    last_invoked_at TIMESTAMP NULL,
+    created_by VARCHAR(255) NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    INDEX idx_inbound_triggers_tenant (tenant_id),
+    INDEX idx_inbound_triggers_workflow (workflow_id),
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE,
+    FOREIGN KEY (workflow_id) REFERENCES workflows(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS inbound_trigger_invocations (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    trigger_id VARCHAR(64) NOT NULL,
+    status VARCHAR(32) NOT NULL,
+    message TEXT NULL,
+    source_ip VARCHAR(64) NULL,
+    user_agent VARCHAR(512) NULL,
+    request_id VARCHAR(128) NULL,
+    vars JSON NULL,
+    agent_count INT NOT NULL DEFAULT 0,
+    execution_ids JSON NULL,
+    received_at TIMESTAMP NOT NULL,
+    INDEX idx_inbound_trigger_invocations_tenant (tenant_id),
+    INDEX idx_inbound_trigger_invocations_trigger (trigger_id, received_at),
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE,
+    FOREIGN KEY (trigger_id) REFERENCES inbound_triggers(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
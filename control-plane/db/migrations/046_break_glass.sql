@@ -0,0 +1,22 @@
+-- Break-glass sessions (time-limited emergency mode in which a tenant's
+-- incident workflow bypasses campaign windows and manual approvals)
+-- MySQL 8.0+
+
+CREATE TABLE IF NOT EXISTS break_glass_sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    workflow_id VARCHAR(64) NOT NULL,
+    incident VARCHAR(255) NOT NULL,
+    reason TEXT NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'active',
+    enabled_by VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_by VARCHAR(255) NULL,
+    ended_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    INDEX idx_break_glass_sessions_tenant (tenant_id, workflow_id),
+    INDEX idx_break_glass_sessions_status (status, expires_at),
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE,
+    FOREIGN KEY (workflow_id) REFERENCES workflows(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,7 @@
+-- Ad-hoc executions (one-off runs of an inline definition, recorded under
+-- a hidden per-tenant workflow that holds their definition snapshots)
+-- MySQL 8.0+
+
+ALTER TABLE workflow_executions
+    ADD COLUMN adhoc BOOLEAN NOT NULL DEFAULT FALSE AFTER execution_mode,
+    ADD INDEX idx_workflow_executions_adhoc (tenant_id, adhoc, created_at);
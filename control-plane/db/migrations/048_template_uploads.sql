@@ -0,0 +1,30 @@
+-- Uploaded template content (large content streamed to the content endpoint
+-- and stored in encrypted chunks instead of the content column)
+-- MySQL 8.0+
+
+ALTER TABLE templates
+    ADD COLUMN uploaded BOOLEAN NOT NULL DEFAULT FALSE AFTER content_type,
+    ADD COLUMN content_hash VARCHAR(80) AFTER uploaded,
+    ADD COLUMN content_size BIGINT NOT NULL DEFAULT 0 AFTER content_hash;
+
+ALTER TABLE template_versions
+    ADD COLUMN uploaded BOOLEAN NOT NULL DEFAULT FALSE AFTER content,
+    ADD COLUMN content_hash VARCHAR(80) AFTER uploaded,
+    ADD COLUMN content_size BIGINT NOT NULL DEFAULT 0 AFTER content_hash;
+
+-- Chunks hold encrypted, JSON-encoded bytes; byte_offset is the position of
+-- the chunk's first byte in the content
+CREATE TABLE IF NOT EXISTS template_chunks (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    template_id VARCHAR(64) NOT NULL,
+    version INT NOT NULL,
+    seq INT NOT NULL,
+    byte_offset BIGINT NOT NULL,
+    data MEDIUMTEXT,
+    size INT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE KEY uniq_template_chunks_seq (template_id, version, seq),
+    INDEX idx_template_chunks_tenant (tenant_id),
+    FOREIGN KEY (template_id) REFERENCES templates(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
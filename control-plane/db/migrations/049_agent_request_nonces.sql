@@ -0,0 +1,11 @@
+-- Nonces of signed agent requests, each accepted once per agent so a
+-- captured request cannot be replayed within the signature skew window
+-- MySQL 8.0+
+
+CREATE TABLE IF NOT EXISTS agent_request_nonces (
+    agent_id VARCHAR(64) NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (agent_id, nonce),
+    INDEX idx_agent_request_nonces_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,10 @@
+-- Template updates store a new version even when only the template's other
+-- fields change; content_version is the version whose chunks hold uploaded
+-- content carried over by such an update (0 for the row's own version)
+-- MySQL 8.0+
+
+ALTER TABLE templates
+    ADD COLUMN content_version INT NOT NULL DEFAULT 0 AFTER content_size;
+
+ALTER TABLE template_versions
+    ADD COLUMN content_version INT NOT NULL DEFAULT 0 AFTER content_size;
@@ -0,0 +1,6 @@
+-- Agent identity resets: a reset agent's key is bound again only by a signed
+-- registration, never by the key header of an ordinary request
+-- MySQL 8.0+
+
+ALTER TABLE agents
+    ADD COLUMN identity_reset_at TIMESTAMP NULL AFTER bound_at;
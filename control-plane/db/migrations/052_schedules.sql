@@ -0,0 +1,46 @@
+-- Schedules (a workflow run on selected agents by a cron expression, read in
+-- a fixed timezone or in each agent's own) and the per-agent next runs of
+-- agent-local schedules
+-- MySQL 8.0+
+
+CREATE TABLE IF NOT EXISTS schedules (
+    id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NULL,
+    workflow_id VARCHAR(64) NOT NULL,
+    target_selector JSON NOT NULL,
+    vars JSON NULL,
+    cron VARCHAR(255) NOT NULL,
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    timezone_mode VARCHAR(32) NOT NULL DEFAULT 'fixed',
+    max_agents INT NOT NULL DEFAULT 10,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    next_run_at TIMESTAMP NULL,
+    last_run_at TIMESTAMP NULL,
+    last_error TEXT NULL,
+    claimed_at TIMESTAMP NULL,
+    created_by VARCHAR(255) NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    INDEX idx_schedules_tenant (tenant_id),
+    INDEX idx_schedules_workflow (workflow_id),
+    INDEX idx_schedules_next_run (enabled, next_run_at),
+    FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE,
+    FOREIGN KEY (workflow_id) REFERENCES workflows(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS schedule_agent_runs (
+    schedule_id VARCHAR(64) NOT NULL,
+    agent_id VARCHAR(64) NOT NULL,
+    tenant_id VARCHAR(64) NOT NULL,
+    timezone VARCHAR(64) NOT NULL,
+    next_run_at TIMESTAMP NOT NULL,
+    last_run_at TIMESTAMP NULL,
+    last_execution_id VARCHAR(64) NULL,
+    last_error TEXT NULL,
+    PRIMARY KEY (schedule_id, agent_id),
+    INDEX idx_schedule_agent_runs_tenant (tenant_id),
+    FOREIGN KEY (schedule_id) REFERENCES schedules(id) ON DELETE CASCADE,
+    FOREIGN KEY (agent_id) REFERENCES agents(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,8 @@
+-- Signed inbound trigger invocations are accepted once: the digest of an
+-- invocation's timestamp and signature is recorded so a replay within the
+-- timestamp window is rejected
+-- MySQL 8.0+
+
+ALTER TABLE inbound_trigger_invocations
+    ADD COLUMN signature_digest VARCHAR(64) NULL AFTER request_id,
+    ADD INDEX idx_inbound_trigger_invocations_digest (trigger_id, signature_digest);
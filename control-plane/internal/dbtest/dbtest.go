@@ -0,0 +1,167 @@
+// Package dbtest provides a GORM database for tests whose statements are
+// answered by the test instead of a MySQL server.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Result answers a statement: the rows of a query, or the rows affected by
+// a write
+type Result struct {
+	Columns      []string
+	Rows         [][]driver.Value
+	RowsAffected int64
+}
+
+// Handler answers a statement with its arguments
+type Handler func(query string, args []driver.Value) (*Result, error)
+
+// Open returns a database whose statements are answered by handler, as the
+// MySQL dialect writes them. Transactions are accepted and do nothing.
+func Open(t testing.TB, handler Handler) *gorm.DB {
+	t.Helper()
+	sqlDB := sql.OpenDB(&connector{handler: handler})
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      sqlDB,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{
+		Logger:                 logger.Default.LogMode(logger.Silent),
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	return db
+}
+
+// Assignments returns the values an UPDATE statement assigns by column. The
+// remaining arguments, those of its WHERE clause, are returned in order.
+func Assignments(query string, args []driver.Value) (map[string]driver.Value, []driver.Value) {
+	_, set, found := strings.Cut(query, " SET ")
+	if !found {
+		return nil, args
+	}
+	set, _, _ = strings.Cut(set, " WHERE ")
+	values := make(map[string]driver.Value)
+	n := 0
+	for _, assignment := range strings.Split(set, ",") {
+		column, value, _ := strings.Cut(assignment, "=")
+		column = strings.Trim(column, "` ")
+		if strings.TrimSpace(value) != "?" || n >= len(args) {
+			continue
+		}
+		values[column] = args[n]
+		n++
+	}
+	return values, args[n:]
+}
+
+// Inserted returns the values of the first row an INSERT statement writes,
+// by column
+func Inserted(query string, args []driver.Value) map[string]driver.Value {
+	_, columns, found := strings.Cut(query, " (")
+	if !found {
+		return nil
+	}
+	columns, _, _ = strings.Cut(columns, ") VALUES")
+	values := make(map[string]driver.Value)
+	for i, column := range strings.Split(columns, ",") {
+		if i < len(args) {
+			values[strings.Trim(column, "` ")] = args[i]
+		}
+	}
+	return values
+}
+
+type connector struct {
+	handler Handler
+	mu      sync.Mutex
+}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) { return &conn{c}, nil }
+func (c *connector) Driver() driver.Driver                        { return unsupported{} }
+
+// unsupported is the connector's driver; connections only come from the
+// connector
+type unsupported struct{}
+
+func (unsupported) Open(string) (driver.Conn, error) {
+	return nil, fmt.Errorf("dbtest connections are opened by the connector")
+}
+
+// answer runs the handler; statements are answered one at a time
+func (c *connector) answer(query string, args []driver.NamedValue) (*Result, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result, err := c.handler(query, values)
+	if err == nil && result == nil {
+		result = &Result{}
+	}
+	return result, err
+}
+
+type conn struct{ c *connector }
+
+func (c *conn) Prepare(string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepared statements are not supported")
+}
+func (c *conn) Close() error              { return nil }
+func (c *conn) Begin() (driver.Tx, error) { return tx{}, nil }
+
+func (c *conn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) { return tx{}, nil }
+
+func (c *conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result, err := c.c.answer(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(result.RowsAffected), nil
+}
+
+func (c *conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result, err := c.c.answer(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{result: result}, nil
+}
+
+type tx struct{}
+
+func (tx) Commit() error   { return nil }
+func (tx) Rollback() error { return nil }
+
+type rows struct {
+	result *Result
+	next   int
+}
+
+func (r *rows) Columns() []string { return r.result.Columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.Rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.Rows[r.next])
+	r.next++
+	return nil
+}
@@ -0,0 +1,241 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// maxAutoApproveRules bounds the auto-approve rules of a registration policy
+const maxAutoApproveRules = 100
+
+// ValidateRegistrationPolicy checks a registration policy is well formed
+func ValidateRegistrationPolicy(policy *models.RegistrationPolicy) error {
+	if len(policy.AutoApprove) > maxAutoApproveRules {
+		return apperror.InvalidInput("at most %d auto_approve rules are allowed", maxAutoApproveRules)
+	}
+	for i, rule := range policy.AutoApprove {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		if len(rule.Tags) == 0 && len(rule.Subnets) == 0 {
+			return apperror.InvalidInput("auto_approve rule %s: tags or subnets are required", name)
+		}
+		for key := range rule.Tags {
+			if strings.TrimSpace(key) == "" {
+				return apperror.InvalidInput("auto_approve rule %s: tag keys must not be empty", name)
+			}
+		}
+		for _, subnet := range rule.Subnets {
+			if _, _, err := net.ParseCIDR(subnet); err != nil {
+				return apperror.InvalidInput("auto_approve rule %s: invalid subnet %q", name, subnet)
+			}
+		}
+	}
+	return nil
+}
+
+// autoApproveRule returns the first rule of a policy approving an agent
+// registering with tags from ip
+func autoApproveRule(policy *models.RegistrationPolicy, tags map[string]interface{}, ip string) (*models.AutoApproveRule, bool) {
+	addr := net.ParseIP(ip)
+	for i := range policy.AutoApprove {
+		rule := &policy.AutoApprove[i]
+		if ruleMatches(rule, tags, addr) {
+			return rule, true
+		}
+	}
+	return nil, false
+}
+
+// ruleMatches reports whether an agent matches all of a rule's tags and, if
+// the rule has subnets, registered from one of them
+func ruleMatches(rule *models.AutoApproveRule, tags map[string]interface{}, addr net.IP) bool {
+	for key, value := range rule.Tags {
+		tag, ok := tags[key]
+		if !ok || fmt.Sprint(tag) != value {
+			return false
+		}
+	}
+	if len(rule.Subnets) == 0 {
+		return true
+	}
+	if addr == nil {
+		return false
+	}
+	for _, subnet := range rule.Subnets {
+		if _, network, err := net.ParseCIDR(subnet); err == nil && network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// admission decides the approval status of a newly registered agent. It
+// returns the rule that approved it, if any.
+func (s *RegistrationService) admission(ctx context.Context, tenantID string, req *RegisterRequest) (models.AgentApprovalStatus, string, error) {
+	var tenant models.Tenant
+	if err := s.db.WithContext(ctx).Select("id", "registration_policy").
+		Where("id = ?", tenantID).First(&tenant).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return "", "", apperror.NotFound("tenant not found")
+		}
+		return "", "", fmt.Errorf("failed to load registration policy: %w", err)
+	}
+
+	policy := tenant.RegistrationPolicy
+	if policy == nil || !policy.RequireApproval {
+		return models.AgentApprovalApproved, "", nil
+	}
+	if rule, ok := autoApproveRule(policy, req.Tags, req.SourceIP); ok {
+		name := rule.Name
+		if name == "" {
+			name = "unnamed"
+		}
+		return models.AgentApprovalApproved, name, nil
+	}
+	return models.AgentApprovalPending, "", nil
+}
+
+// GetRegistrationPolicy returns the tenant's registration policy; it
+// admits agents on registration when unset
+func (r *Registry) GetRegistrationPolicy(ctx context.Context, tenantID string) (*models.RegistrationPolicy, error) {
+	var tenant models.Tenant
+	if err := r.db.WithContext(ctx).Select("id", "registration_policy").
+		Where("id = ?", tenantID).First(&tenant).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("tenant not found")
+		}
+		return nil, fmt.Errorf("failed to load registration policy: %w", err)
+	}
+	if tenant.RegistrationPolicy == nil {
+		return &models.RegistrationPolicy{}, nil
+	}
+	return tenant.RegistrationPolicy, nil
+}
+
+// SetRegistrationPolicy replaces the tenant's registration policy. It
+// applies to agents registering afterwards; agents already pending stay
+// pending until approved.
+func (r *Registry) SetRegistrationPolicy(ctx context.Context, tenantID string, policy *models.RegistrationPolicy) (*models.RegistrationPolicy, error) {
+	if err := ValidateRegistrationPolicy(policy); err != nil {
+		return nil, err
+	}
+
+	result := r.db.WithContext(ctx).Model(&models.Tenant{}).
+		Where("id = ?", tenantID).
+		Updates(map[string]interface{}{
+			"registration_policy": policy,
+			"updated_at":          time.Now(),
+		})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to update registration policy: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, apperror.NotFound("tenant not found")
+	}
+
+	r.logger.Info("registration policy updated",
+		zap.String("tenant_id", tenantID),
+		zap.Bool("require_approval", policy.RequireApproval),
+		zap.Int("auto_approve_rules", len(policy.AutoApprove)))
+
+	return policy, nil
+}
+
+// ApprovalRequest selects the agents to approve or reject: those listed by
+// ID, or every pending agent with all of Tags
+type ApprovalRequest struct {
+	AgentIDs []string          `json:"agent_ids"`
+	Tags     map[string]string `json:"tags"`
+}
+
+// ApprovalResult is the outcome of approving or rejecting agents
+type ApprovalResult struct {
+	Status models.AgentApprovalStatus `json:"status"`
+	// Updated are the agents whose status changed; agents that were
+	// already in the status or do not exist are left out
+	Updated []string `json:"updated"`
+	Count   int      `json:"count"`
+}
+
+// Approve admits pending or rejected agents so they receive executions
+func (r *Registry) Approve(ctx context.Context, tenantID string, req *ApprovalRequest, approvedBy string) (*ApprovalResult, error) {
+	return r.setApproval(ctx, tenantID, req, models.AgentApprovalApproved, approvedBy)
+}
+
+// Reject keeps pending agents from receiving executions. Rejected agents
+// cannot re-register until approved or deregistered.
+func (r *Registry) Reject(ctx context.Context, tenantID string, req *ApprovalRequest, rejectedBy string) (*ApprovalResult, error) {
+	return r.setApproval(ctx, tenantID, req, models.AgentApprovalRejected, rejectedBy)
+}
+
+// setApproval moves the selected agents to an approval status
+func (r *Registry) setApproval(ctx context.Context, tenantID string, req *ApprovalRequest, status models.AgentApprovalStatus, by string) (*ApprovalResult, error) {
+	if len(req.AgentIDs) == 0 && len(req.Tags) == 0 {
+		return nil, apperror.InvalidInput("agent_ids or tags are required")
+	}
+
+	result := &ApprovalResult{Status: status, Updated: []string{}}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&models.Agent{}).
+			Where("tenant_id = ? AND approval_status <> ?", tenantID, status)
+		if len(req.AgentIDs) > 0 {
+			query = query.Where("id IN ?", req.AgentIDs)
+		}
+		for key, value := range req.Tags {
+			query = query.Where("JSON_EXTRACT(tags, ?) = ?", "$."+key, value)
+		}
+		// Selecting by tags only picks from the queue, and approved agents
+		// are taken out of service by draining or deregistering them
+		if len(req.AgentIDs) == 0 || status == models.AgentApprovalRejected {
+			query = query.Where("approval_status = ?", models.AgentApprovalPending)
+		}
+
+		var ids []string
+		if err := query.Clauses(clause.Locking{Strength: "UPDATE"}).Pluck("id", &ids).Error; err != nil {
+			return fmt.Errorf("failed to select agents: %w", err)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		now := time.Now()
+		if err := tx.Model(&models.Agent{}).
+			Where("tenant_id = ? AND id IN ?", tenantID, ids).
+			Updates(map[string]interface{}{
+				"approval_status": status,
+				"approved_by":     by,
+				"approved_at":     now,
+				"updated_at":      now,
+			}).Error; err != nil {
+			return fmt.Errorf("failed to update agent approval: %w", err)
+		}
+		result.Updated = ids
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Count = len(result.Updated)
+
+	if result.Count > 0 {
+		r.logger.Info("agent approval updated",
+			zap.String("tenant_id", tenantID),
+			zap.String("status", string(status)),
+			zap.Int("agents", result.Count),
+			zap.String("by", by))
+	}
+
+	return result, nil
+}
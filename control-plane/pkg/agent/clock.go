@@ -0,0 +1,228 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/stats"
+)
+
+// DefaultClockSkewThreshold is the clock skew beyond which an agent is
+// flagged. Larger skews break JWT validation and scheduled work.
+const DefaultClockSkewThreshold = 30 * time.Second
+
+// maxSkewedAgents bounds the skewed agents listed in a fleet health summary
+const maxSkewedAgents = 50
+
+// HeartbeatTiming is the timing an agent includes with a heartbeat or
+// health report
+type HeartbeatTiming struct {
+	// AgentTime is the agent's local time when it sent the request
+	AgentTime *time.Time `json:"agent_time,omitempty"`
+	// LatencyMs is the round trip the agent measured on its previous request
+	LatencyMs *int64 `json:"latency_ms,omitempty"`
+}
+
+// FleetHealthSummary summarizes the health of a tenant's agents
+type FleetHealthSummary struct {
+	Total        int64            `json:"total"`
+	ByStatus     map[string]int64 `json:"by_status"`
+	ByDrainState map[string]int64 `json:"by_drain_state"`
+	Latency      LatencySummary   `json:"latency"`
+
+	ClockSkewThresholdMs int64 `json:"clock_skew_threshold_ms"`
+	ClockSkewed          int64 `json:"clock_skewed"`
+	// SkewedAgents lists the most skewed agents, largest skew first
+	SkewedAgents []SkewedAgent `json:"skewed_agents"`
+}
+
+// LatencySummary summarizes the latency reported by online agents
+type LatencySummary struct {
+	Agents int   `json:"agents"`
+	AvgMs  int64 `json:"avg_ms"`
+	P50Ms  int64 `json:"p50_ms"`
+	P95Ms  int64 `json:"p95_ms"`
+	MaxMs  int64 `json:"max_ms"`
+}
+
+// SkewedAgent is an agent whose clock skew exceeds the threshold
+type SkewedAgent struct {
+	ID             string     `json:"id"`
+	Hostname       string     `json:"hostname"`
+	ClockSkewMs    int64      `json:"clock_skew_ms"`
+	LatencyMs      *int64     `json:"latency_ms,omitempty"`
+	ClockCheckedAt *time.Time `json:"clock_checked_at,omitempty"`
+}
+
+// SetClockSkewThreshold sets the clock skew beyond which agents are flagged
+func (r *Registry) SetClockSkewThreshold(threshold time.Duration) {
+	if threshold <= 0 {
+		threshold = DefaultClockSkewThreshold
+	}
+	r.clockSkewThreshold = threshold
+}
+
+// RecordTiming records the latency and clock skew derived from a heartbeat
+// received at receivedAt. The agent's clock is compared at the moment the
+// request arrived, estimated as its send time plus half the round trip.
+func (r *Registry) RecordTiming(ctx context.Context, tenantID, agentID string, timing *HeartbeatTiming, receivedAt time.Time) error {
+	if timing == nil || (timing.AgentTime == nil && timing.LatencyMs == nil) {
+		return nil
+	}
+
+	updates := map[string]interface{}{}
+	if timing.LatencyMs != nil && *timing.LatencyMs >= 0 {
+		updates["latency_ms"] = *timing.LatencyMs
+	}
+
+	var skew time.Duration
+	if timing.AgentTime != nil && !timing.AgentTime.IsZero() {
+		agentNow := *timing.AgentTime
+		if timing.LatencyMs != nil && *timing.LatencyMs > 0 {
+			agentNow = agentNow.Add(time.Duration(*timing.LatencyMs) * time.Millisecond / 2)
+		}
+		skew = agentNow.Sub(receivedAt)
+		updates["clock_skew_ms"] = skew.Milliseconds()
+		updates["clock_skewed"] = absDuration(skew) > r.clockSkewThreshold
+		updates["clock_checked_at"] = receivedAt
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	var previous models.Agent
+	if err := r.db.WithContext(ctx).Select("id", "clock_skewed").
+		Where("id = ? AND tenant_id = ?", agentID, tenantID).
+		Take(&previous).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return apperror.NotFound("agent not found")
+		}
+		return fmt.Errorf("failed to load agent clock state: %w", err)
+	}
+
+	if err := r.db.WithContext(ctx).Model(&models.Agent{}).
+		Where("id = ? AND tenant_id = ?", agentID, tenantID).
+		Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to record heartbeat timing: %w", err)
+	}
+
+	if skewed, ok := updates["clock_skewed"].(bool); ok && skewed != previous.ClockSkewed {
+		if skewed {
+			r.logger.Warn("agent clock skew exceeds threshold",
+				zap.String("tenant_id", tenantID),
+				zap.String("agent_id", agentID),
+				zap.Duration("skew", skew),
+				zap.Duration("threshold", r.clockSkewThreshold))
+		} else {
+			r.logger.Info("agent clock skew back within threshold",
+				zap.String("tenant_id", tenantID),
+				zap.String("agent_id", agentID),
+				zap.Duration("skew", skew))
+		}
+	}
+
+	return nil
+}
+
+// GetFleetHealth summarizes the tenant's agents by status and drain state,
+// along with the latency of online agents and those with clock skew
+func (r *Registry) GetFleetHealth(ctx context.Context, tenantID string) (*FleetHealthSummary, error) {
+	summary := &FleetHealthSummary{
+		ByStatus:             make(map[string]int64),
+		ByDrainState:         make(map[string]int64),
+		ClockSkewThresholdMs: r.clockSkewThreshold.Milliseconds(),
+		SkewedAgents:         []SkewedAgent{},
+	}
+
+	type count struct {
+		Status     string
+		DrainState string
+		Count      int64
+	}
+	var counts []count
+	if err := r.db.WithContext(ctx).Model(&models.Agent{}).
+		Select("status, drain_state, count(*) as count").
+		Where("tenant_id = ?", tenantID).
+		Group("status, drain_state").
+		Find(&counts).Error; err != nil {
+		return nil, fmt.Errorf("failed to count agents: %w", err)
+	}
+	for _, c := range counts {
+		summary.Total += c.Count
+		summary.ByStatus[c.Status] += c.Count
+		summary.ByDrainState[c.DrainState] += c.Count
+	}
+
+	var latencies []int64
+	if err := r.db.WithContext(ctx).Model(&models.Agent{}).
+		Where("tenant_id = ? AND status <> ? AND latency_ms IS NOT NULL", tenantID, models.AgentStatusOffline).
+		Pluck("latency_ms", &latencies).Error; err != nil {
+		return nil, fmt.Errorf("failed to load agent latency: %w", err)
+	}
+	summary.Latency = summarizeLatency(latencies)
+
+	if err := r.db.WithContext(ctx).Model(&models.Agent{}).
+		Where("tenant_id = ? AND clock_skewed = ?", tenantID, true).
+		Count(&summary.ClockSkewed).Error; err != nil {
+		return nil, fmt.Errorf("failed to count skewed agents: %w", err)
+	}
+	if summary.ClockSkewed > 0 {
+		var agents []models.Agent
+		if err := r.db.WithContext(ctx).
+			Select("id", "hostname", "latency_ms", "clock_skew_ms", "clock_checked_at").
+			Where("tenant_id = ? AND clock_skewed = ?", tenantID, true).
+			Order("ABS(clock_skew_ms) DESC").
+			Limit(maxSkewedAgents).
+			Find(&agents).Error; err != nil {
+			return nil, fmt.Errorf("failed to list skewed agents: %w", err)
+		}
+		for _, ag := range agents {
+			skewed := SkewedAgent{
+				ID:             ag.ID,
+				Hostname:       ag.Hostname,
+				LatencyMs:      ag.LatencyMs,
+				ClockCheckedAt: ag.ClockCheckedAt,
+			}
+			if ag.ClockSkewMs != nil {
+				skewed.ClockSkewMs = *ag.ClockSkewMs
+			}
+			summary.SkewedAgents = append(summary.SkewedAgents, skewed)
+		}
+	}
+
+	return summary, nil
+}
+
+// summarizeLatency returns the average, median, 95th percentile and maximum
+// of the given latencies
+func summarizeLatency(latencies []int64) LatencySummary {
+	summary := LatencySummary{Agents: len(latencies)}
+	if len(latencies) == 0 {
+		return summary
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total int64
+	for _, l := range latencies {
+		total += l
+	}
+	summary.AvgMs = total / int64(len(latencies))
+	summary.P50Ms = stats.Percentile(latencies, 50)
+	summary.P95Ms = stats.Percentile(latencies, 95)
+	summary.MaxMs = latencies[len(latencies)-1]
+	return summary
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,811 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// fleetQueryBatchSize is the number of agents loaded per query while a fleet
+// query is evaluated
+const fleetQueryBatchSize = 500
+
+// maxFleetQueryLength bounds the length of a fleet query expression
+const maxFleetQueryLength = 4096
+
+// FleetQueryRequest represents a request to find the agents matching a fleet
+// query expression
+type FleetQueryRequest struct {
+	TenantID string
+	Query    string
+	Limit    int
+	Offset   int
+}
+
+// QueryFleet returns the tenant's agents matching a fleet query expression,
+// ordered by ID, along with the total number of matches. The expression is
+// evaluated server-side over agents loaded in batches.
+func (r *Registry) QueryFleet(ctx context.Context, req *FleetQueryRequest) ([]models.Agent, int64, error) {
+	query, err := ParseFleetQuery(req.Query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		matched []models.Agent
+		total   int64
+		agents  []models.Agent
+	)
+	now := time.Now()
+	result := r.db.WithContext(ctx).Model(&models.Agent{}).
+		Where("tenant_id = ?", req.TenantID).
+		Order("id ASC").
+		FindInBatches(&agents, fleetQueryBatchSize, func(tx *gorm.DB, batch int) error {
+			var health map[string]*models.AgentHealthReport
+			if query.usesHealth {
+				var err error
+				if health, err = r.latestHealthReports(ctx, agents); err != nil {
+					return err
+				}
+			}
+			var software map[string]map[string]string
+			if len(query.software) > 0 {
+				var err error
+				if software, err = r.softwareVersions(ctx, agents, query.software); err != nil {
+					return err
+				}
+			}
+			for i := range agents {
+				candidate := &queryAgent{agent: &agents[i], health: health[agents[i].ID], software: software[agents[i].ID], now: now}
+				if !query.root.match(candidate) {
+					continue
+				}
+				if total >= int64(req.Offset) && (req.Limit <= 0 || len(matched) < req.Limit) {
+					matched = append(matched, agents[i])
+				}
+				total++
+			}
+			return nil
+		})
+	if result.Error != nil {
+		return nil, 0, fmt.Errorf("failed to query fleet: %w", result.Error)
+	}
+
+	return matched, total, nil
+}
+
+// latestHealthReports returns the most recent health report of each agent
+func (r *Registry) latestHealthReports(ctx context.Context, agents []models.Agent) (map[string]*models.AgentHealthReport, error) {
+	if len(agents) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, len(agents))
+	for i := range agents {
+		ids[i] = agents[i].ID
+	}
+
+	latest := r.db.Model(&models.AgentHealthReport{}).
+		Select("agent_id, MAX(reported_at)").
+		Where("agent_id IN ?", ids).
+		Group("agent_id")
+
+	var reports []models.AgentHealthReport
+	if err := r.db.WithContext(ctx).
+		Where("(agent_id, reported_at) IN (?)", latest).
+		Find(&reports).Error; err != nil {
+		return nil, fmt.Errorf("failed to load health reports: %w", err)
+	}
+
+	health := make(map[string]*models.AgentHealthReport, len(reports))
+	for i := range reports {
+		if _, ok := health[reports[i].AgentID]; !ok {
+			health[reports[i].AgentID] = &reports[i]
+		}
+	}
+	return health, nil
+}
+
+// FleetQuery is a parsed fleet query expression, e.g.
+//
+//	os == "linux" && facts.kernel < "5.4" && status == "online"
+//
+// Fields are id, hostname, os, arch, version, status, drain_state,
+// approval_status, clock_skewed, latency_ms and last_seen_seconds; tags.<key>,
+// metadata.<key> and facts.<key> (the agent's grains, nested keys joined
+// with dots); and health (the overall status of the latest health report)
+// or health.<component>; and software.<package> (the installed version,
+// without epoch, of a package in the agent's software inventory, e.g.
+// software.openssl < "3.0.13"). Values are compared with ==, !=, <, <=, >, >=,
+// =~ (regular expression) and in [...], and combined with &&, || and !.
+//
+// Strings are ordered naturally, so runs of digits compare as numbers and
+// "5.15.0" > "5.4". A list fact equals a value when any element does. A
+// missing field only matches != and null.
+type FleetQuery struct {
+	root       queryNode
+	usesHealth bool
+	// software are the packages whose versions the query reads
+	software []string
+}
+
+// ParseFleetQuery parses a fleet query expression
+func ParseFleetQuery(expression string) (*FleetQuery, error) {
+	if strings.TrimSpace(expression) == "" {
+		return nil, apperror.InvalidInput("query is required")
+	}
+	if len(expression) > maxFleetQueryLength {
+		return nil, apperror.InvalidInput("query must be at most %d characters", maxFleetQueryLength)
+	}
+
+	tokens, err := lexFleetQuery(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, queryError(tok.pos, "unexpected %q", tok.text)
+	}
+
+	query := &FleetQuery{root: root, usesHealth: p.usesHealth}
+	for name := range p.software {
+		query.software = append(query.software, name)
+	}
+	return query, nil
+}
+
+// queryError reports an invalid fleet query expression
+func queryError(pos int, format string, args ...interface{}) error {
+	return apperror.InvalidInput("invalid query at position %d: %s", pos+1, fmt.Sprintf(format, args...))
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+type queryToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// queryOperators lists the operators, two-character ones first
+var queryOperators = []string{"==", "!=", "<=", ">=", "=~", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ","}
+
+// lexFleetQuery splits a fleet query expression into tokens
+func lexFleetQuery(expression string) ([]queryToken, error) {
+	var tokens []queryToken
+	for i := 0; i < len(expression); {
+		c := rune(expression[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '"' || c == '\'':
+			var sb strings.Builder
+			start := i
+			i++
+			for ; i < len(expression) && rune(expression[i]) != c; i++ {
+				if expression[i] == '\\' && i+1 < len(expression) {
+					i++
+				}
+				sb.WriteByte(expression[i])
+			}
+			if i >= len(expression) {
+				return nil, queryError(start, "unterminated string")
+			}
+			i++
+			tokens = append(tokens, queryToken{kind: tokenString, text: sb.String(), pos: start})
+
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(expression) && unicode.IsDigit(rune(expression[i+1]))):
+			start := i
+			for i++; i < len(expression) && (unicode.IsDigit(rune(expression[i])) || expression[i] == '.'); i++ {
+			}
+			tokens = append(tokens, queryToken{kind: tokenNumber, text: expression[start:i], pos: start})
+
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for ; i < len(expression) && isIdentRune(rune(expression[i])); i++ {
+			}
+			tokens = append(tokens, queryToken{kind: tokenIdent, text: expression[start:i], pos: start})
+
+		default:
+			op := ""
+			for _, candidate := range queryOperators {
+				if strings.HasPrefix(expression[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				if c == '=' {
+					return nil, queryError(i, "use == to compare values")
+				}
+				return nil, queryError(i, "unexpected character %q", c)
+			}
+			tokens = append(tokens, queryToken{kind: tokenOperator, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, queryToken{kind: tokenEOF, pos: len(expression)}), nil
+}
+
+// isIdentRune reports whether r may appear in a field name after its first
+// character
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-'
+}
+
+// queryParser is a recursive descent parser over fleet query tokens
+type queryParser struct {
+	tokens     []queryToken
+	pos        int
+	usesHealth bool
+	software   map[string]bool
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() queryToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is the given operator
+func (p *queryParser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokenOperator && tok.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) expect(op string) error {
+	if !p.accept(op) {
+		tok := p.peek()
+		return queryError(tok.pos, "expected %q", op)
+	}
+	return nil
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	if p.accept("!") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{x}, nil
+	}
+	if p.accept("(") {
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return x, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *queryParser) parseComparison() (queryNode, error) {
+	start := p.peek()
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+	switch {
+	case tok.kind == tokenOperator && isComparison(tok.text):
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return compareNode{op: tok.text, left: left, right: right}, nil
+
+	case tok.kind == tokenOperator && tok.text == "=~":
+		p.next()
+		pattern := p.next()
+		if pattern.kind != tokenString {
+			return nil, queryError(pattern.pos, "=~ must be followed by a quoted regular expression")
+		}
+		re, err := regexp.Compile(pattern.text)
+		if err != nil {
+			return nil, queryError(pattern.pos, "invalid regular expression: %v", err)
+		}
+		return regexNode{left: left, re: re}, nil
+
+	case tok.kind == tokenIdent && tok.text == "in":
+		p.next()
+		if err := p.expect("["); err != nil {
+			return nil, err
+		}
+		var values []interface{}
+		for !p.accept("]") {
+			if len(values) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			value, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			lit, ok := value.(literal)
+			if !ok {
+				return nil, queryError(p.tokens[p.pos-1].pos, "in lists may only contain literal values")
+			}
+			values = append(values, lit.v)
+		}
+		return inNode{left: left, values: values}, nil
+	}
+
+	// A field on its own matches when it is true, e.g. clock_skewed
+	if _, ok := left.(field); !ok {
+		return nil, queryError(start.pos, "expected a comparison")
+	}
+	return truthNode{left}, nil
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) parseOperand() (queryOperand, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenString:
+		return literal{tok.text}, nil
+	case tokenNumber:
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, queryError(tok.pos, "invalid number %q", tok.text)
+		}
+		return literal{n}, nil
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "null":
+			return literal{nil}, nil
+		}
+		f, err := parseField(tok)
+		if err != nil {
+			return nil, err
+		}
+		switch f.root {
+		case "health":
+			p.usesHealth = true
+		case "software":
+			if p.software == nil {
+				p.software = make(map[string]bool)
+			}
+			p.software[f.key] = true
+		}
+		return f, nil
+	case tokenEOF:
+		return nil, queryError(tok.pos, "unexpected end of query")
+	}
+	return nil, queryError(tok.pos, "unexpected %q", tok.text)
+}
+
+// scalarFields are the agent fields without keys
+var scalarFields = map[string]bool{
+	"id":                true,
+	"hostname":          true,
+	"os":                true,
+	"arch":              true,
+	"version":           true,
+	"status":            true,
+	"drain_state":       true,
+	"approval_status":   true,
+	"clock_skewed":      true,
+	"latency_ms":        true,
+	"last_seen_seconds": true,
+}
+
+// parseField parses a field name such as os, tags.env or facts.kernel
+func parseField(tok queryToken) (field, error) {
+	root, key, _ := strings.Cut(tok.text, ".")
+	switch {
+	case scalarFields[root]:
+		if key != "" {
+			return field{}, queryError(tok.pos, "%s has no keys", root)
+		}
+	case root == "tags", root == "metadata", root == "facts", root == "grains", root == "software":
+		if key == "" {
+			return field{}, queryError(tok.pos, "%s requires a key, e.g. %s.name", root, root)
+		}
+	case root == "health":
+	default:
+		return field{}, queryError(tok.pos, "unknown field %q", tok.text)
+	}
+	return field{root: root, key: key}, nil
+}
+
+// queryAgent is an agent a fleet query is evaluated against
+type queryAgent struct {
+	agent  *models.Agent
+	health *models.AgentHealthReport
+	// software maps package names to their installed versions
+	software map[string]string
+	now      time.Time
+}
+
+type queryNode interface {
+	match(a *queryAgent) bool
+}
+
+type queryOperand interface {
+	value(a *queryAgent) interface{}
+}
+
+type andNode struct{ left, right queryNode }
+
+func (n andNode) match(a *queryAgent) bool { return n.left.match(a) && n.right.match(a) }
+
+type orNode struct{ left, right queryNode }
+
+func (n orNode) match(a *queryAgent) bool { return n.left.match(a) || n.right.match(a) }
+
+type notNode struct{ x queryNode }
+
+func (n notNode) match(a *queryAgent) bool { return !n.x.match(a) }
+
+type truthNode struct{ x queryOperand }
+
+func (n truthNode) match(a *queryAgent) bool {
+	switch v := n.x.value(a).(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != "" && v != "false"
+	case float64:
+		return v != 0
+	}
+	return true
+}
+
+type compareNode struct {
+	op          string
+	left, right queryOperand
+}
+
+func (n compareNode) match(a *queryAgent) bool {
+	left, right := n.left.value(a), n.right.value(a)
+	switch n.op {
+	case "==":
+		return queryEqual(left, right)
+	case "!=":
+		return !queryEqual(left, right)
+	}
+
+	cmp, ok := queryOrder(left, right)
+	if !ok {
+		return false
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+type regexNode struct {
+	left queryOperand
+	re   *regexp.Regexp
+}
+
+func (n regexNode) match(a *queryAgent) bool {
+	value := n.left.value(a)
+	if list, ok := value.([]interface{}); ok {
+		for _, item := range list {
+			if item != nil && n.re.MatchString(queryString(item)) {
+				return true
+			}
+		}
+		return false
+	}
+	return value != nil && n.re.MatchString(queryString(value))
+}
+
+type inNode struct {
+	left   queryOperand
+	values []interface{}
+}
+
+func (n inNode) match(a *queryAgent) bool {
+	value := n.left.value(a)
+	for _, v := range n.values {
+		if queryEqual(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+type literal struct{ v interface{} }
+
+func (l literal) value(*queryAgent) interface{} { return l.v }
+
+// field is an agent field; key is the part of the name after the first dot
+type field struct {
+	root string
+	key  string
+}
+
+func (f field) value(a *queryAgent) interface{} {
+	agent := a.agent
+	switch f.root {
+	case "id":
+		return agent.ID
+	case "hostname":
+		return agent.Hostname
+	case "os":
+		return agent.OS
+	case "arch":
+		return agent.Arch
+	case "version":
+		return agent.Version
+	case "status":
+		return string(agent.Status)
+	case "drain_state":
+		return string(agent.DrainState)
+	case "approval_status":
+		return string(agent.ApprovalStatus)
+	case "clock_skewed":
+		return agent.ClockSkewed
+	case "latency_ms":
+		if agent.LatencyMs == nil {
+			return nil
+		}
+		return float64(*agent.LatencyMs)
+	case "last_seen_seconds":
+		if agent.LastSeenAt == nil {
+			return nil
+		}
+		return a.now.Sub(*agent.LastSeenAt).Seconds()
+	case "tags":
+		return lookupKey(agent.Tags, f.key)
+	case "metadata":
+		return lookupKey(agent.Metadata, f.key)
+	case "facts", "grains":
+		return lookupKey(agent.Grains, f.key)
+	case "software":
+		if version, ok := a.software[f.key]; ok {
+			return version
+		}
+		return nil
+	case "health":
+		if a.health == nil {
+			return nil
+		}
+		if f.key == "" {
+			return string(a.health.Status)
+		}
+		status, _ := ComponentStatus(a.health.Components[f.key])
+		if status == "" {
+			return nil
+		}
+		return status
+	}
+	return nil
+}
+
+// lookupKey returns the value of key in a JSON map. Keys that contain dots
+// themselves are matched before nested objects are walked.
+func lookupKey(m map[string]interface{}, key string) interface{} {
+	if m == nil {
+		return nil
+	}
+	if v, ok := m[key]; ok {
+		return v
+	}
+	head, rest, ok := strings.Cut(key, ".")
+	if !ok {
+		return nil
+	}
+	nested, ok := m[head].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return lookupKey(nested, rest)
+}
+
+// queryEqual reports whether two values are equal. A list equals a value
+// when any of its elements does.
+func queryEqual(left, right interface{}) bool {
+	if list, ok := left.([]interface{}); ok {
+		for _, item := range list {
+			if queryEqual(item, right) {
+				return true
+			}
+		}
+		return false
+	}
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+	if l, r, ok := queryNumbers(left, right); ok {
+		return l == r
+	}
+	return queryString(left) == queryString(right)
+}
+
+// queryOrder compares two values, numerically when either is a number and
+// the other converts to one, else naturally as strings
+func queryOrder(left, right interface{}) (int, bool) {
+	if left == nil || right == nil {
+		return 0, false
+	}
+	if _, ok := left.([]interface{}); ok {
+		return 0, false
+	}
+	if l, r, ok := queryNumbers(left, right); ok {
+		switch {
+		case l < r:
+			return -1, true
+		case l > r:
+			return 1, true
+		}
+		return 0, true
+	}
+	return naturalCompare(queryString(left), queryString(right)), true
+}
+
+// queryNumbers converts both values to numbers when at least one is a number
+func queryNumbers(left, right interface{}) (float64, float64, bool) {
+	_, leftNumber := left.(float64)
+	_, rightNumber := right.(float64)
+	if !leftNumber && !rightNumber {
+		return 0, 0, false
+	}
+	l, ok := queryNumber(left)
+	if !ok {
+		return 0, 0, false
+	}
+	r, ok := queryNumber(right)
+	if !ok {
+		return 0, 0, false
+	}
+	return l, r, true
+}
+
+func queryNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func queryString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// naturalCompare compares strings with runs of digits compared as numbers,
+// so versions order as expected: "5.4.0" < "5.15.0"
+func naturalCompare(a, b string) int {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			da, db := digitRun(a), digitRun(b)
+			na, nb := strings.TrimLeft(a[:da], "0"), strings.TrimLeft(b[:db], "0")
+			if len(na) != len(nb) {
+				if len(na) < len(nb) {
+					return -1
+				}
+				return 1
+			}
+			if c := strings.Compare(na, nb); c != 0 {
+				return c
+			}
+			a, b = a[da:], b[db:]
+			continue
+		}
+		if a[0] != b[0] {
+			if a[0] < b[0] {
+				return -1
+			}
+			return 1
+		}
+		a, b = a[1:], b[1:]
+	}
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	}
+	return 1
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// digitRun returns the length of the run of digits s starts with
+func digitRun(s string) int {
+	n := 0
+	for n < len(s) && isDigit(s[n]) {
+		n++
+	}
+	return n
+}
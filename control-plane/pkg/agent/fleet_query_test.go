@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+func TestParseFleetQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		// wantErr is part of the expected error message
+		wantErr      string
+		wantHealth   bool
+		wantSoftware []string
+	}{
+		{query: `os == "linux"`},
+		{query: `os == 'linux' && (status == "online" || status == "degraded")`},
+		{query: `!clock_skewed`},
+		{query: `latency_ms >= 250 && last_seen_seconds < -1.5`},
+		{query: `facts.kernel < "5.4" && tags.env in ["prod", "staging", null]`},
+		{query: `hostname =~ "^web-[0-9]+$"`},
+		{query: `grains.os.family == "debian" && metadata.rack != null`},
+		{query: `health == "unhealthy" || health.disk != "healthy"`, wantHealth: true},
+		{query: `software.openssl < "3.0.13"`, wantSoftware: []string{"openssl"}},
+		{query: `hostname == "say \"hi\""`},
+		{query: ``, wantErr: "query is required"},
+		{query: `   `, wantErr: "query is required"},
+		{query: strings.Repeat("a", maxFleetQueryLength+1), wantErr: "at most"},
+		{query: `os = "linux"`, wantErr: "position 4: use == to compare values"},
+		{query: `os == "linux`, wantErr: "position 7: unterminated string"},
+		{query: `os == "linux" & arch == "arm64"`, wantErr: "position 15: unexpected character '&'"},
+		{query: `kernel == "5.4"`, wantErr: `unknown field "kernel"`},
+		{query: `os.name == "linux"`, wantErr: "os has no keys"},
+		{query: `tags == "prod"`, wantErr: "tags requires a key"},
+		{query: `os ==`, wantErr: "unexpected end of query"},
+		{query: `(os == "linux"`, wantErr: `expected ")"`},
+		{query: `os == "linux")`, wantErr: `unexpected ")"`},
+		{query: `"linux"`, wantErr: "expected a comparison"},
+		{query: `hostname =~ web`, wantErr: "=~ must be followed by a quoted regular expression"},
+		{query: `hostname =~ "web-("`, wantErr: "invalid regular expression"},
+		{query: `os in ["linux" "windows"]`, wantErr: `expected ","`},
+		{query: `os in [arch]`, wantErr: "in lists may only contain literal values"},
+		{query: `os in "linux"`, wantErr: `expected "["`},
+		{query: `latency_ms > 1.2.3`, wantErr: `invalid number "1.2.3"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			query, err := ParseFleetQuery(tt.query)
+			if tt.wantErr != "" {
+				if !errors.Is(err, apperror.ErrInvalidInput) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseFleetQuery error = %v, want invalid input containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFleetQuery: %v", err)
+			}
+			if query.usesHealth != tt.wantHealth {
+				t.Errorf("usesHealth = %v, want %v", query.usesHealth, tt.wantHealth)
+			}
+			if strings.Join(query.software, ",") != strings.Join(tt.wantSoftware, ",") {
+				t.Errorf("software = %v, want %v", query.software, tt.wantSoftware)
+			}
+		})
+	}
+}
+
+func TestFleetQueryMatch(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	lastSeen := now.Add(-90 * time.Second)
+	latency := int64(120)
+	candidate := &queryAgent{
+		agent: &models.Agent{
+			ID:          "agent-1",
+			Hostname:    "web-12",
+			OS:          "linux",
+			Status:      models.AgentStatusOnline,
+			ClockSkewed: false,
+			LatencyMs:   &latency,
+			LastSeenAt:  &lastSeen,
+			Tags:        models.JSONMap{"env": "prod"},
+			Grains: models.JSONMap{
+				"kernel":       "5.15.0-91-generic",
+				"ip_addresses": []interface{}{"10.0.0.5", "192.168.1.5"},
+				"os":           map[string]interface{}{"family": "debian"},
+				"cpu.count":    float64(8),
+			},
+		},
+		health: &models.AgentHealthReport{
+			Status:     "degraded",
+			Components: models.JSONMap{"disk": map[string]interface{}{"status": "unhealthy", "message": "95% full"}},
+		},
+		software: map[string]string{"openssl": "3.0.2"},
+		now:      now,
+	}
+
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{`os == "linux" && status == "online"`, true},
+		{`os == "windows" || tags.env == "prod"`, true},
+		{`!(os == "linux")`, false},
+		{`!clock_skewed`, true},
+		{`facts.kernel > "5.4"`, true},
+		{`facts.kernel < "5.4"`, false},
+		{`grains.os.family == "debian"`, true},
+		{`facts.cpu.count >= 8`, true},
+		{`facts.cpu.count == "8"`, true},
+		{`facts.ip_addresses == "10.0.0.5"`, true},
+		{`facts.ip_addresses =~ "^192\\.168\\."`, true},
+		{`facts.ip_addresses < "z"`, false},
+		{`hostname =~ "^web-[0-9]+$"`, true},
+		{`tags.env in ["staging", "prod"]`, true},
+		{`tags.owner == null`, true},
+		{`tags.owner != "ops"`, true},
+		{`tags.owner < "ops"`, false},
+		{`latency_ms < 200 && last_seen_seconds > 60`, true},
+		{`health == "degraded" && health.disk == "unhealthy"`, true},
+		{`health.memory == null`, true},
+		{`software.openssl < "3.0.13"`, true},
+		{`software.nginx == null`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			query, err := ParseFleetQuery(tt.query)
+			if err != nil {
+				t.Fatalf("ParseFleetQuery: %v", err)
+			}
+			if got := query.root.match(candidate); got != tt.want {
+				t.Errorf("match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNaturalCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"5.4.0", "5.15.0", -1},
+		{"5.15.0", "5.4", 1},
+		{"3.0.13", "3.0.13", 0},
+		{"3.0.013", "3.0.13", 0},
+		{"1.2", "1.2.1", -1},
+		{"1.10a", "1.10b", -1},
+		{"", "a", -1},
+	}
+	for _, tt := range tests {
+		if got := naturalCompare(tt.a, tt.b); got != tt.want {
+			t.Errorf("naturalCompare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+// Package agent provides agent management for the control plane.
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// DefaultHealthHistoryRetention is how long health transitions are kept
+const DefaultHealthHistoryRetention = 7 * 24 * time.Hour
+
+// healthHistoryPruneInterval is how often expired health transitions are deleted
+const healthHistoryPruneInterval = time.Hour
+
+// HealthHistory is an agent's health transitions over a time window
+type HealthHistory struct {
+	AgentID     string                         `json:"agent_id"`
+	Since       time.Time                      `json:"since"`
+	Transitions []models.AgentHealthTransition `json:"transitions"`
+	// Flaps counts transitions per component; the overall status is keyed "overall"
+	Flaps map[string]int `json:"flaps"`
+}
+
+// healthTransitions compares a health report with the agent's previous report
+// and returns the overall and component status changes. With no previous
+// report every status is recorded as an initial transition.
+func healthTransitions(tenantID, agentID string, previous *models.AgentHealthReport, status models.AgentStatus, components map[string]interface{}, at time.Time) []models.AgentHealthTransition {
+	var transitions []models.AgentHealthTransition
+	add := func(component, from, to, message string) {
+		transitions = append(transitions, models.AgentHealthTransition{
+			ID:         uuid.New().String(),
+			AgentID:    agentID,
+			TenantID:   tenantID,
+			Component:  component,
+			FromStatus: from,
+			ToStatus:   to,
+			Message:    message,
+			ChangedAt:  at,
+		})
+	}
+
+	var previousStatus string
+	var previousComponents map[string]interface{}
+	if previous != nil {
+		previousStatus = string(previous.Status)
+		previousComponents = previous.Components
+	}
+
+	if string(status) != previousStatus {
+		add("", previousStatus, string(status), "")
+	}
+
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		to, message := ComponentStatus(components[name])
+		if to == "" {
+			continue
+		}
+		from, _ := ComponentStatus(previousComponents[name])
+		if to != from {
+			add(name, from, to, message)
+		}
+	}
+
+	return transitions
+}
+
+// ComponentStatus extracts the status and message of a reported component,
+// which is either an object with status and message fields or a bare status
+func ComponentStatus(value interface{}) (string, string) {
+	switch v := value.(type) {
+	case string:
+		return v, ""
+	case map[string]interface{}:
+		status, _ := v["status"].(string)
+		message, _ := v["message"].(string)
+		return status, message
+	default:
+		return "", ""
+	}
+}
+
+// GetHealthHistory returns an agent's health transitions since the given time,
+// oldest first. An empty component returns transitions for every component.
+func (r *Registry) GetHealthHistory(ctx context.Context, tenantID, agentID string, since time.Time, component string) (*HealthHistory, error) {
+	query := r.db.WithContext(ctx).
+		Where("tenant_id = ? AND agent_id = ? AND changed_at >= ?", tenantID, agentID, since)
+	if component != "" {
+		query = query.Where("component = ?", component)
+	}
+
+	var transitions []models.AgentHealthTransition
+	if err := query.Order("changed_at ASC").Find(&transitions).Error; err != nil {
+		return nil, fmt.Errorf("failed to get health history: %w", err)
+	}
+
+	history := &HealthHistory{
+		AgentID:     agentID,
+		Since:       since,
+		Transitions: transitions,
+		Flaps:       make(map[string]int),
+	}
+	for _, t := range transitions {
+		// The first status seen for a component is not a flap
+		if t.FromStatus == "" {
+			continue
+		}
+		key := t.Component
+		if key == "" {
+			key = "overall"
+		}
+		history.Flaps[key]++
+	}
+
+	return history, nil
+}
+
+// PruneHealthHistory deletes health transitions older than the given time
+func (r *Registry) PruneHealthHistory(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Where("changed_at < ?", before).
+		Delete(&models.AgentHealthTransition{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to prune health history: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// RunHealthHistoryRetention periodically deletes health transitions older
+// than retention until the context is cancelled
+func (r *Registry) RunHealthHistoryRetention(ctx context.Context, retention time.Duration) {
+	if retention <= 0 {
+		retention = DefaultHealthHistoryRetention
+	}
+
+	ticker := time.NewTicker(healthHistoryPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := r.PruneHealthHistory(ctx, time.Now().Add(-retention))
+		if err != nil {
+			r.logger.Error("failed to prune agent health history", zap.Error(err))
+		} else if deleted > 0 {
+			r.logger.Info("pruned agent health history", zap.Int64("deleted", deleted))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// latestHealthReport returns the agent's most recent health report, or nil
+func latestHealthReport(tx *gorm.DB, tenantID, agentID string) (*models.AgentHealthReport, error) {
+	var reports []models.AgentHealthReport
+	if err := tx.Where("tenant_id = ? AND agent_id = ?", tenantID, agentID).
+		Order("reported_at DESC").
+		Limit(1).
+		Find(&reports).Error; err != nil {
+		return nil, err
+	}
+	if len(reports) == 0 {
+		return nil, nil
+	}
+	return &reports[0], nil
+}
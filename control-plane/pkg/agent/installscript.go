@@ -0,0 +1,220 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+)
+
+// installPlatforms lists the OS/arch pairs agent binaries are released for
+var installPlatforms = map[string][]string{
+	"linux":   {"amd64", "arm64"},
+	"darwin":  {"amd64", "arm64"},
+	"windows": {"amd64"},
+}
+
+// sha256Pattern matches a hex-encoded SHA-256 digest
+var sha256Pattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// InstallScriptConfig configures generated agent install commands
+type InstallScriptConfig struct {
+	// ControlPlaneURL and PikoURL are the URLs agents use, which may differ
+	// from the addresses used inside the cluster
+	ControlPlaneURL string
+	PikoURL         string
+
+	// ReleaseURL is the base URL of agent releases. Binaries are fetched
+	// from <ReleaseURL>/<Version>/vm-agent-<os>-<arch>[.exe].
+	ReleaseURL string
+	Version    string
+
+	// Checksums maps "<os>-<arch>" to the SHA-256 of the release binary.
+	// Platforms without a checksum verify against the <binary>.sha256 file
+	// published with the release.
+	Checksums map[string]string
+}
+
+// InstallScriptGenerator creates installation keys and the commands that
+// download, verify and install an agent with them
+type InstallScriptGenerator struct {
+	keyManager *KeyManager
+	config     InstallScriptConfig
+}
+
+// NewInstallScriptGenerator creates a new install script generator
+func NewInstallScriptGenerator(keyManager *KeyManager, config *InstallScriptConfig) *InstallScriptGenerator {
+	cfg := *config
+	cfg.ControlPlaneURL = strings.TrimRight(cfg.ControlPlaneURL, "/")
+	cfg.ReleaseURL = strings.TrimRight(cfg.ReleaseURL, "/")
+	if cfg.Version == "" {
+		cfg.Version = "latest"
+	}
+	return &InstallScriptGenerator{
+		keyManager: keyManager,
+		config:     cfg,
+	}
+}
+
+// InstallScriptRequest represents a request for an install command
+type InstallScriptRequest struct {
+	TenantID    string
+	OS          string
+	Arch        string
+	ExpiryHours int
+	UsageLimit  int
+	Tags        map[string]interface{}
+}
+
+// InstallScript is a generated install command. The embedded installation
+// key is created for the script and only returned here.
+type InstallScript struct {
+	OS        string    `json:"os"`
+	Arch      string    `json:"arch"`
+	Shell     string    `json:"shell"`
+	Command   string    `json:"command"`
+	BinaryURL string    `json:"binary_url"`
+	Checksum  string    `json:"checksum,omitempty"`
+	KeyID     string    `json:"key_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Generate creates an installation key and a one-line install command
+// embedding it
+func (g *InstallScriptGenerator) Generate(ctx context.Context, req *InstallScriptRequest) (*InstallScript, error) {
+	if g.config.ControlPlaneURL == "" || g.config.PikoURL == "" || g.config.ReleaseURL == "" {
+		return nil, apperror.InvalidState("install scripts are not configured: agents.install.control_plane_url, piko_url and release_url are required")
+	}
+
+	osName := strings.ToLower(req.OS)
+	if osName == "" {
+		osName = "linux"
+	}
+	arch := strings.ToLower(req.Arch)
+	if arch == "" {
+		arch = "amd64"
+	}
+	arches, ok := installPlatforms[osName]
+	if !ok {
+		return nil, apperror.InvalidInput("unsupported os %q: must be linux, darwin or windows", req.OS)
+	}
+	if !containsString(arches, arch) {
+		return nil, apperror.InvalidInput("unsupported arch %q for %s: must be one of %s", req.Arch, osName, strings.Join(arches, ", "))
+	}
+
+	checksum := g.config.Checksums[osName+"-"+arch]
+	if checksum != "" && !sha256Pattern.MatchString(checksum) {
+		return nil, fmt.Errorf("configured checksum for %s-%s is not a SHA-256 hex digest", osName, arch)
+	}
+
+	key, err := g.keyManager.CreateKey(ctx, &CreateKeyRequest{
+		TenantID:    req.TenantID,
+		Description: fmt.Sprintf("install script (%s/%s)", osName, arch),
+		Tags:        req.Tags,
+		ExpiryHours: req.ExpiryHours,
+		UsageLimit:  req.UsageLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	binary := "vm-agent-" + osName + "-" + arch
+	if osName == "windows" {
+		binary += ".exe"
+	}
+	script := &InstallScript{
+		OS:        osName,
+		Arch:      arch,
+		BinaryURL: g.config.ReleaseURL + "/" + g.config.Version + "/" + binary,
+		Checksum:  strings.ToLower(checksum),
+		KeyID:     key.KeyID,
+		ExpiresAt: key.ExpiresAt,
+	}
+
+	installArgs := []string{
+		"--tenant-id", req.TenantID,
+		"--key", key.Key,
+		"--piko-url", g.config.PikoURL,
+		"--control-plane-url", g.config.ControlPlaneURL,
+	}
+
+	if osName == "windows" {
+		script.Shell = "powershell"
+		script.Command = powerShellInstallCommand(script, installArgs)
+	} else {
+		script.Shell = "sh"
+		script.Command = shellInstallCommand(script, installArgs)
+	}
+
+	return script, nil
+}
+
+// shellInstallCommand builds the Linux and macOS command. The binary is
+// installed to /usr/local/bin, where the service definitions expect it.
+func shellInstallCommand(script *InstallScript, installArgs []string) string {
+	sumTool := "sha256sum"
+	if script.OS == "darwin" {
+		sumTool = "shasum -a 256"
+	}
+
+	expected := shellQuote(script.Checksum)
+	if script.Checksum == "" {
+		expected = `"$(curl -fsSL ` + shellQuote(script.BinaryURL+".sha256") + ` | cut -d' ' -f1)"`
+	}
+
+	args := make([]string, len(installArgs))
+	for i, arg := range installArgs {
+		if strings.HasPrefix(arg, "--") {
+			args[i] = arg
+		} else {
+			args[i] = shellQuote(arg)
+		}
+	}
+
+	return fmt.Sprintf(`f="$(mktemp)" && curl -fsSL -o "$f" %s && h=%s && echo "$h  $f" | %s -c - && sudo install -m 0755 "$f" /usr/local/bin/vm-agent && rm -f "$f" && sudo /usr/local/bin/vm-agent install %s`,
+		shellQuote(script.BinaryURL), expected, sumTool, strings.Join(args, " "))
+}
+
+// powerShellInstallCommand builds the Windows command, to be run from an
+// elevated prompt. The installer copies itself to Program Files.
+func powerShellInstallCommand(script *InstallScript, installArgs []string) string {
+	expected := powerShellQuote(script.Checksum)
+	if script.Checksum == "" {
+		expected = `((New-Object Net.WebClient).DownloadString(` + powerShellQuote(script.BinaryURL+".sha256") + `) -split '\s+')[0]`
+	}
+
+	args := make([]string, len(installArgs))
+	for i, arg := range installArgs {
+		if strings.HasPrefix(arg, "--") {
+			args[i] = arg
+		} else {
+			args[i] = powerShellQuote(arg)
+		}
+	}
+
+	return fmt.Sprintf(`$ErrorActionPreference='Stop'; $f=Join-Path $env:TEMP 'vm-agent.exe'; Invoke-WebRequest -UseBasicParsing -Uri %s -OutFile $f; if ((Get-FileHash $f -Algorithm SHA256).Hash -ne %s) { Remove-Item $f; throw 'vm-agent checksum mismatch' }; & $f install %s; Remove-Item $f`,
+		powerShellQuote(script.BinaryURL), expected, strings.Join(args, " "))
+}
+
+// shellQuote quotes s for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// powerShellQuote quotes s as a PowerShell literal string
+func powerShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
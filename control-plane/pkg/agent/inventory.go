@@ -0,0 +1,198 @@
+package agent
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// inventoryBatchSize is the number of agents loaded per query while
+// exporting the inventory
+const inventoryBatchSize = 500
+
+// InventoryColumns are the columns of an inventory export, in order
+var InventoryColumns = []string{
+	"id",
+	"hostname",
+	"os",
+	"arch",
+	"version",
+	"status",
+	"drain_state",
+	"approval_status",
+	"tags",
+	"last_seen_at",
+	"registered_at",
+	"last_execution_id",
+	"last_execution_workflow_id",
+	"last_execution_status",
+	"last_execution_at",
+}
+
+// InventoryWriter writes inventory rows in an export format
+type InventoryWriter interface {
+	WriteRow(values []string) error
+	Close() error
+}
+
+// NewInventoryWriter returns a writer for format, which is csv or xlsx
+func NewInventoryWriter(w io.Writer, format string) (InventoryWriter, error) {
+	switch format {
+	case "csv":
+		return &csvInventoryWriter{w: csv.NewWriter(w)}, nil
+	case "xlsx":
+		return newXLSXWriter(w, "Agents")
+	default:
+		return nil, apperror.InvalidInput("invalid format %q: must be csv or xlsx", format)
+	}
+}
+
+// ExportInventory writes every agent matching the request's filters, with
+// its most recent execution, to w. Limit and Offset are ignored. Rows are
+// written in batches as they are loaded, so large fleets are not held in
+// memory.
+func (r *Registry) ExportInventory(ctx context.Context, req *ListRequest, w InventoryWriter) error {
+	if err := w.WriteRow(InventoryColumns); err != nil {
+		return err
+	}
+
+	var agents []models.Agent
+	result := r.filterQuery(req).WithContext(ctx).
+		Order("id ASC").
+		FindInBatches(&agents, inventoryBatchSize, func(tx *gorm.DB, batch int) error {
+			last, err := r.lastExecutions(ctx, agents)
+			if err != nil {
+				return err
+			}
+			for i := range agents {
+				if err := w.WriteRow(inventoryRow(&agents[i], last[agents[i].ID])); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to export agents: %w", result.Error)
+	}
+
+	return w.Close()
+}
+
+// lastExecutions returns the most recent execution of each agent
+func (r *Registry) lastExecutions(ctx context.Context, agents []models.Agent) (map[string]*models.WorkflowExecution, error) {
+	if len(agents) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, len(agents))
+	for i := range agents {
+		ids[i] = agents[i].ID
+	}
+
+	latest := r.db.Model(&models.WorkflowExecution{}).
+		Select("agent_id, MAX(created_at)").
+		Where("agent_id IN ?", ids).
+		Group("agent_id")
+
+	var executions []models.WorkflowExecution
+	if err := r.db.WithContext(ctx).
+		Select("id", "workflow_id", "agent_id", "status", "created_at", "completed_at").
+		Where("(agent_id, created_at) IN (?)", latest).
+		Find(&executions).Error; err != nil {
+		return nil, fmt.Errorf("failed to load last executions: %w", err)
+	}
+
+	last := make(map[string]*models.WorkflowExecution, len(executions))
+	for i := range executions {
+		e := &executions[i]
+		// Executions created in the same instant keep the first one found
+		if _, ok := last[e.AgentID]; !ok {
+			last[e.AgentID] = e
+		}
+	}
+	return last, nil
+}
+
+// inventoryRow formats an agent as the InventoryColumns values
+func inventoryRow(agent *models.Agent, last *models.WorkflowExecution) []string {
+	row := []string{
+		agent.ID,
+		agent.Hostname,
+		agent.OS,
+		agent.Arch,
+		agent.Version,
+		string(agent.Status),
+		string(agent.DrainState),
+		string(agent.ApprovalStatus),
+		formatTags(agent.Tags),
+		formatTime(agent.LastSeenAt),
+		formatTime(&agent.RegisteredAt),
+		"", "", "", "",
+	}
+	if last != nil {
+		at := last.CompletedAt
+		if at == nil {
+			at = &last.CreatedAt
+		}
+		row[10] = last.ID
+		row[11] = last.WorkflowID
+		row[12] = string(last.Status)
+		row[13] = formatTime(at)
+	}
+	return row
+}
+
+// formatTags renders tags as key=value pairs sorted by key
+func formatTags(tags models.JSONMap) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s=%v", k, tags[k])
+	}
+	return strings.Join(pairs, "; ")
+}
+
+// formatTime renders t in RFC 3339 UTC, or empty when unset
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// csvInventoryWriter writes inventory rows as CSV
+type csvInventoryWriter struct {
+	w *csv.Writer
+}
+
+// WriteRow writes one row. Values that spreadsheet applications would
+// evaluate as formulas are prefixed with a quote.
+func (c *csvInventoryWriter) WriteRow(values []string) error {
+	escaped := make([]string, len(values))
+	for i, v := range values {
+		if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+			v = "'" + v
+		}
+		escaped[i] = v
+	}
+	return c.w.Write(escaped)
+}
+
+// Close flushes buffered rows
+func (c *csvInventoryWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -9,6 +9,7 @@ import (
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 
+	"github.com/yourorg/control-plane/pkg/apperror"
 	"github.com/yourorg/control-plane/pkg/db/models"
 )
 
@@ -28,7 +29,7 @@ func NewKeyManager(db *gorm.DB, logger *zap.Logger) *KeyManager {
 
 // CreateKeyRequest represents a request to create an installation key
 type CreateKeyRequest struct {
-	TenantID    string                 `json:"tenant_id" binding:"required"`
+	TenantID    string                 `json:"tenant_id"`
 	Description string                 `json:"description"`
 	Tags        map[string]interface{} `json:"tags"`
 	ExpiryHours int                    `json:"expiry_hours"`
@@ -86,7 +87,7 @@ func (m *KeyManager) GetKey(ctx context.Context, tenantID, keyID string) (*model
 	var key models.InstallationKey
 	if err := m.db.Where("id = ? AND tenant_id = ?", keyID, tenantID).First(&key).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("key not found")
+			return nil, apperror.NotFound("key not found")
 		}
 		return nil, fmt.Errorf("failed to get key: %w", err)
 	}
@@ -121,7 +122,7 @@ func (m *KeyManager) RevokeKey(ctx context.Context, tenantID, keyID string) erro
 	}
 
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("key not found")
+		return apperror.NotFound("key not found")
 	}
 
 	m.logger.Info("installation key revoked",
@@ -140,7 +141,7 @@ func (m *KeyManager) DeleteKey(ctx context.Context, tenantID, keyID string) erro
 	}
 
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("key not found")
+		return apperror.NotFound("key not found")
 	}
 
 	return nil
@@ -10,8 +10,10 @@ import (
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 
+	"github.com/yourorg/control-plane/pkg/apperror"
 	"github.com/yourorg/control-plane/pkg/auth"
 	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/encryption"
 	"github.com/yourorg/control-plane/pkg/tenant"
 )
 
@@ -44,14 +46,30 @@ type RegisterRequest struct {
 	Arch            string                 `json:"arch"`
 	Version         string                 `json:"version"`
 	Tags            map[string]interface{} `json:"tags"`
+
+	// PublicKey is the base64 Ed25519 key generated on the agent. Signature
+	// signs auth.EnrollmentMessage at Timestamp (Unix seconds) to prove the
+	// agent holds its private key.
+	PublicKey string `json:"public_key" binding:"required"`
+	Timestamp string `json:"timestamp" binding:"required"`
+	Signature string `json:"signature" binding:"required"`
+
+	// SourceIP is the address the registration came from, matched against
+	// auto-approve subnets
+	SourceIP string `json:"-"`
 }
 
-// RegisterResponse represents the registration response
+// RegisterResponse represents the registration response. DispatchKey is the
+// base64 key the agent verifies dispatch tokens on Piko requests with; a new
+// one is issued on every registration. Pending agents receive no
+// executions until approved.
 type RegisterResponse struct {
-	Token    string `json:"token"`
-	AgentID  string `json:"agent_id"`
-	TenantID string `json:"tenant_id"`
-	Endpoint string `json:"endpoint"`
+	Token          string                     `json:"token"`
+	AgentID        string                     `json:"agent_id"`
+	TenantID       string                     `json:"tenant_id"`
+	Endpoint       string                     `json:"endpoint"`
+	DispatchKey    string                     `json:"dispatch_key"`
+	ApprovalStatus models.AgentApprovalStatus `json:"approval_status"`
 }
 
 // Register registers a new agent
@@ -59,7 +77,7 @@ func (s *RegistrationService) Register(ctx context.Context, req *RegisterRequest
 	// Validate installation key
 	tenantID, err := s.validateInstallationKey(req.InstallationKey)
 	if err != nil {
-		return nil, fmt.Errorf("invalid installation key: %w", err)
+		return nil, apperror.Unauthorized("invalid installation key: %w", err)
 	}
 
 	// Check quota
@@ -73,11 +91,40 @@ func (s *RegistrationService) Register(ctx context.Context, req *RegisterRequest
 		agentID = req.Hostname
 	}
 
+	fingerprint, err := s.verifyEnrollment(agentID, req)
+	if err != nil {
+		return nil, err
+	}
+
 	// Check if agent already exists
 	var existingAgent models.Agent
 	if err := s.db.Where("id = ? AND tenant_id = ?", agentID, tenantID).First(&existingAgent).Error; err == nil {
+		// An enrolled agent ID may only be re-registered with its own key
+		if existingAgent.PublicKey != "" && existingAgent.PublicKey != req.PublicKey {
+			s.logger.Warn("rejected registration of enrolled agent with a different key",
+				zap.String("agent_id", agentID),
+				zap.String("tenant_id", tenantID),
+				zap.String("key_fingerprint", fingerprint))
+			return nil, apperror.Conflict("agent %s is enrolled with a different key; an admin must reset its identity before it can re-register", agentID)
+		}
+		if existingAgent.ApprovalStatus == models.AgentApprovalRejected {
+			return nil, apperror.Forbidden("agent %s was rejected; an admin must approve or deregister it before it can re-register", agentID)
+		}
 		// Agent exists, update and return new token
-		return s.reRegisterAgent(ctx, &existingAgent, req)
+		return s.reRegisterAgent(ctx, &existingAgent, req, fingerprint)
+	}
+
+	endpoint, err := auth.NewAgentEndpoint(tenantID, agentID)
+	if err != nil {
+		return nil, err
+	}
+	dispatchKey, err := auth.NewDispatchKey()
+	if err != nil {
+		return nil, err
+	}
+	approval, rule, err := s.admission(ctx, tenantID, req)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create new agent
@@ -93,6 +140,18 @@ func (s *RegistrationService) Register(ctx context.Context, req *RegisterRequest
 		RegisteredAt: time.Now(),
 		UpdatedAt:    time.Now(),
 	}
+	now := time.Now()
+	agent.PublicKey = req.PublicKey
+	agent.KeyFingerprint = fingerprint
+	agent.BoundAt = &now
+	agent.PikoEndpoint = endpoint
+	agent.DispatchKey = dispatchKey
+	agent.ApprovalStatus = approval
+	agent.RegistrationIP = req.SourceIP
+	if rule != "" {
+		agent.ApprovedBy = "auto-approve:" + rule
+		agent.ApprovedAt = &now
+	}
 
 	if err := s.db.Create(agent).Error; err != nil {
 		return nil, fmt.Errorf("failed to create agent: %w", err)
@@ -125,18 +184,21 @@ func (s *RegistrationService) Register(ctx context.Context, req *RegisterRequest
 	s.logger.Info("agent registered",
 		zap.String("agent_id", agentID),
 		zap.String("tenant_id", tenantID),
-		zap.String("hostname", req.Hostname))
+		zap.String("hostname", req.Hostname),
+		zap.String("approval_status", string(approval)))
 
 	return &RegisterResponse{
-		Token:    token,
-		AgentID:  agentID,
-		TenantID: tenantID,
-		Endpoint: fmt.Sprintf("tenant-%s/%s", tenantID, agentID),
+		Token:          token,
+		AgentID:        agentID,
+		TenantID:       tenantID,
+		Endpoint:       agent.Endpoint(),
+		DispatchKey:    dispatchKey,
+		ApprovalStatus: approval,
 	}, nil
 }
 
 // reRegisterAgent handles re-registration of an existing agent
-func (s *RegistrationService) reRegisterAgent(ctx context.Context, agent *models.Agent, req *RegisterRequest) (*RegisterResponse, error) {
+func (s *RegistrationService) reRegisterAgent(ctx context.Context, agent *models.Agent, req *RegisterRequest, fingerprint string) (*RegisterResponse, error) {
 	// Update agent info
 	updates := map[string]interface{}{
 		"hostname":   req.Hostname,
@@ -148,6 +210,34 @@ func (s *RegistrationService) reRegisterAgent(ctx context.Context, agent *models
 	if req.Tags != nil {
 		updates["tags"] = req.Tags
 	}
+	if req.SourceIP != "" {
+		updates["registration_ip"] = req.SourceIP
+	}
+	// Bind agents enrolled before identity binding, or after a reset
+	if agent.PublicKey == "" {
+		updates["public_key"] = req.PublicKey
+		updates["key_fingerprint"] = fingerprint
+		updates["bound_at"] = time.Now()
+	}
+	// Agents registered with the predictable endpoint move to a random one
+	if agent.PikoEndpoint == "" {
+		endpoint, err := auth.NewAgentEndpoint(agent.TenantID, agent.ID)
+		if err != nil {
+			return nil, err
+		}
+		updates["piko_endpoint"] = endpoint
+		agent.PikoEndpoint = endpoint
+	}
+	dispatchKey, err := auth.NewDispatchKey()
+	if err != nil {
+		return nil, err
+	}
+	// Map updates bypass the serializer, so the key is sealed here
+	sealed, err := encryption.Seal(agent.TenantID, dispatchKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt dispatch key: %w", err)
+	}
+	updates["dispatch_key"] = sealed
 
 	if err := s.db.Model(agent).Updates(updates).Error; err != nil {
 		return nil, fmt.Errorf("failed to update agent: %w", err)
@@ -182,13 +272,41 @@ func (s *RegistrationService) reRegisterAgent(ctx context.Context, agent *models
 		zap.String("tenant_id", agent.TenantID))
 
 	return &RegisterResponse{
-		Token:    token,
-		AgentID:  agent.ID,
-		TenantID: agent.TenantID,
-		Endpoint: fmt.Sprintf("tenant-%s/%s", agent.TenantID, agent.ID),
+		Token:          token,
+		AgentID:        agent.ID,
+		TenantID:       agent.TenantID,
+		Endpoint:       agent.Endpoint(),
+		DispatchKey:    dispatchKey,
+		ApprovalStatus: agent.ApprovalStatus,
 	}, nil
 }
 
+// verifyEnrollment checks the registration proves possession of the
+// enrolled key and that the key is not bound to another agent. It returns
+// the key's fingerprint.
+func (s *RegistrationService) verifyEnrollment(agentID string, req *RegisterRequest) (string, error) {
+	key, err := auth.ParseAgentPublicKey(req.PublicKey)
+	if err != nil {
+		return "", apperror.InvalidInput("invalid public_key: %w", err)
+	}
+	message := auth.EnrollmentMessage(agentID, req.PublicKey, req.Timestamp)
+	if err := auth.VerifySignature(req.PublicKey, req.Timestamp, req.Signature, message); err != nil {
+		return "", apperror.Unauthorized("enrollment signature rejected: %w", err)
+	}
+
+	fingerprint := auth.KeyFingerprint(key)
+	var count int64
+	if err := s.db.Model(&models.Agent{}).
+		Where("key_fingerprint = ? AND id <> ?", fingerprint, agentID).
+		Count(&count).Error; err != nil {
+		return "", fmt.Errorf("failed to check key binding: %w", err)
+	}
+	if count > 0 {
+		return "", apperror.Conflict("public key is already enrolled by another agent")
+	}
+	return fingerprint, nil
+}
+
 // validateInstallationKey validates an installation key and returns the tenant ID
 func (s *RegistrationService) validateInstallationKey(key string) (string, error) {
 	keyHash := auth.HashToken(key)
@@ -205,6 +323,71 @@ func (s *RegistrationService) validateInstallationKey(key string) (string, error
 	return installKey.TenantID, nil
 }
 
+// RefreshResponse is a renewed agent token
+type RefreshResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RefreshToken issues an agent a new token ahead of the expiry of the one it
+// authenticated with, which is revoked. Only an unrevoked, unexpired token
+// of the agent can be refreshed; agents whose token has expired must
+// re-register with an installation key.
+func (s *RegistrationService) RefreshToken(ctx context.Context, tenantID, agentID, current string) (*RefreshResponse, error) {
+	var agent models.Agent
+	if err := s.db.WithContext(ctx).Where("id = ? AND tenant_id = ?", agentID, tenantID).First(&agent).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("agent not found")
+		}
+		return nil, fmt.Errorf("failed to get agent: %w", err)
+	}
+
+	now := time.Now()
+	var token string
+	var agentToken *models.AgentToken
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Revoking the current token claims it, so it is refreshed once
+		result := tx.Model(&models.AgentToken{}).
+			Where("agent_id = ? AND tenant_id = ? AND token_hash = ? AND revoked_at IS NULL AND expires_at > ?",
+				agentID, tenantID, auth.HashToken(current), now).
+			Update("revoked_at", now)
+		if result.Error != nil {
+			return fmt.Errorf("failed to revoke token: %w", result.Error)
+		}
+		if result.RowsAffected != 1 {
+			return apperror.Unauthorized("agent token is revoked or expired")
+		}
+
+		var err error
+		token, err = s.jwtManager.GenerateAgentToken(tenantID, agentID, s.tokenExpiry)
+		if err != nil {
+			return fmt.Errorf("failed to generate token: %w", err)
+		}
+		agentToken = &models.AgentToken{
+			ID:        uuid.New().String(),
+			AgentID:   agentID,
+			TenantID:  tenantID,
+			TokenHash: auth.HashToken(token),
+			ExpiresAt: now.Add(s.tokenExpiry),
+			CreatedAt: now,
+		}
+		if err := tx.Create(agentToken).Error; err != nil {
+			return fmt.Errorf("failed to store token: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("agent token refreshed",
+		zap.String("agent_id", agentID),
+		zap.String("tenant_id", tenantID),
+		zap.Time("expires_at", agentToken.ExpiresAt))
+
+	return &RefreshResponse{Token: token, ExpiresAt: agentToken.ExpiresAt}, nil
+}
+
 // markKeyUsed marks an installation key as used
 func (s *RegistrationService) markKeyUsed(key string) {
 	keyHash := auth.HashToken(key)
@@ -228,7 +411,7 @@ func (s *RegistrationService) Deregister(ctx context.Context, tenantID, agentID
 	}
 
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("agent not found")
+		return apperror.NotFound("agent not found")
 	}
 
 	s.logger.Info("agent deregistered",
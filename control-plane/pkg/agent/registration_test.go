@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/internal/dbtest"
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/auth"
+)
+
+func TestRefreshToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string
+		setup   func(*agentStore)
+		wantErr error
+	}{
+		{name: "current token", current: "agent-token"},
+		{
+			name:    "revoked token",
+			current: "agent-token",
+			setup:   func(s *agentStore) { s.tokens[auth.HashToken("agent-token")] = true },
+			wantErr: apperror.ErrUnauthorized,
+		},
+		{
+			name:    "expired token",
+			current: "agent-token",
+			setup:   func(s *agentStore) { s.expired[auth.HashToken("agent-token")] = true },
+			wantErr: apperror.ErrUnauthorized,
+		},
+		{name: "unknown token", current: "other-token", wantErr: apperror.ErrUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newAgentStore(t, "", "agent-token")
+			if tt.setup != nil {
+				tt.setup(store)
+			}
+			s := NewRegistrationService(dbtest.Open(t, store.handle), auth.NewJWTManager("secret", "test", time.Hour), zap.NewNop())
+
+			refreshed, err := s.RefreshToken(context.Background(), "tenant-1", "agent-1", tt.current)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("RefreshToken error = %v, want %v", err, tt.wantErr)
+				}
+				if len(store.tokens) != 1 {
+					t.Errorf("rejected refresh stored %d tokens", len(store.tokens)-1)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RefreshToken: %v", err)
+			}
+			if revoked := store.tokens[auth.HashToken(tt.current)]; !revoked {
+				t.Error("refreshed token was not revoked")
+			}
+			if revoked, ok := store.tokens[auth.HashToken(refreshed.Token)]; !ok || revoked {
+				t.Error("new token was not stored")
+			}
+
+			// The refreshed token cannot be refreshed again
+			if _, err := s.RefreshToken(context.Background(), "tenant-1", "agent-1", tt.current); !errors.Is(err, apperror.ErrUnauthorized) {
+				t.Errorf("second refresh error = %v, want unauthorized", err)
+			}
+		})
+	}
+}
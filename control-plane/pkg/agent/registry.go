@@ -9,20 +9,23 @@ import (
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 
+	"github.com/yourorg/control-plane/pkg/apperror"
 	"github.com/yourorg/control-plane/pkg/db/models"
 )
 
 // Registry manages agent records
 type Registry struct {
-	db     *gorm.DB
-	logger *zap.Logger
+	db                 *gorm.DB
+	logger             *zap.Logger
+	clockSkewThreshold time.Duration
 }
 
 // NewRegistry creates a new agent registry
 func NewRegistry(db *gorm.DB, logger *zap.Logger) *Registry {
 	return &Registry{
-		db:     db,
-		logger: logger,
+		db:                 db,
+		logger:             logger,
+		clockSkewThreshold: DefaultClockSkewThreshold,
 	}
 }
 
@@ -31,7 +34,7 @@ func (r *Registry) Get(ctx context.Context, tenantID, agentID string) (*models.A
 	var agent models.Agent
 	if err := r.db.Where("id = ? AND tenant_id = ?", agentID, tenantID).First(&agent).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("agent not found")
+			return nil, apperror.NotFound("agent not found")
 		}
 		return nil, fmt.Errorf("failed to get agent: %w", err)
 	}
@@ -40,29 +43,18 @@ func (r *Registry) Get(ctx context.Context, tenantID, agentID string) (*models.A
 
 // ListRequest represents a request to list agents
 type ListRequest struct {
-	TenantID string
-	Status   string
-	Tags     map[string]string
-	Limit    int
-	Offset   int
+	TenantID       string
+	Status         string
+	DrainState     string
+	ApprovalStatus string
+	Tags           map[string]string
+	Limit          int
+	Offset         int
 }
 
 // List lists agents
 func (r *Registry) List(ctx context.Context, req *ListRequest) ([]models.Agent, int64, error) {
-	query := r.db.Model(&models.Agent{})
-
-	if req.TenantID != "" {
-		query = query.Where("tenant_id = ?", req.TenantID)
-	}
-
-	if req.Status != "" {
-		query = query.Where("status = ?", req.Status)
-	}
-
-	// Filter by tags (JSON query)
-	for key, value := range req.Tags {
-		query = query.Where("JSON_EXTRACT(tags, ?) = ?", "$."+key, value)
-	}
+	query := r.filterQuery(req)
 
 	var total int64
 	if err := query.Count(&total).Error; err != nil {
@@ -84,6 +76,34 @@ func (r *Registry) List(ctx context.Context, req *ListRequest) ([]models.Agent,
 	return agents, total, nil
 }
 
+// filterQuery returns an agent query applying the request's filters
+func (r *Registry) filterQuery(req *ListRequest) *gorm.DB {
+	query := r.db.Model(&models.Agent{})
+
+	if req.TenantID != "" {
+		query = query.Where("tenant_id = ?", req.TenantID)
+	}
+
+	if req.Status != "" {
+		query = query.Where("status = ?", req.Status)
+	}
+
+	if req.DrainState != "" {
+		query = query.Where("drain_state = ?", req.DrainState)
+	}
+
+	if req.ApprovalStatus != "" {
+		query = query.Where("approval_status = ?", req.ApprovalStatus)
+	}
+
+	// Filter by tags (JSON query)
+	for key, value := range req.Tags {
+		query = query.Where("JSON_EXTRACT(tags, ?) = ?", "$."+key, value)
+	}
+
+	return query
+}
+
 // UpdateStatus updates an agent's status
 func (r *Registry) UpdateStatus(ctx context.Context, tenantID, agentID string, status models.AgentStatus) error {
 	result := r.db.Model(&models.Agent{}).
@@ -99,7 +119,7 @@ func (r *Registry) UpdateStatus(ctx context.Context, tenantID, agentID string, s
 	}
 
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("agent not found")
+		return apperror.NotFound("agent not found")
 	}
 
 	return nil
@@ -121,18 +141,50 @@ func (r *Registry) UpdateHeartbeat(ctx context.Context, tenantID, agentID string
 	return nil
 }
 
-// RecordHealthReport records a health report from an agent
+// RecordGrains stores the host facts an agent reported
+func (r *Registry) RecordGrains(ctx context.Context, tenantID, agentID string, grains map[string]interface{}) error {
+	result := r.db.WithContext(ctx).Model(&models.Agent{}).
+		Where("id = ? AND tenant_id = ?", agentID, tenantID).
+		Updates(map[string]interface{}{
+			"grains":            models.JSONMap(grains),
+			"grains_updated_at": time.Now(),
+		})
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to record grains: %w", result.Error)
+	}
+
+	return nil
+}
+
+// RecordHealthReport records a health report from an agent, along with any
+// overall or component status transitions since its previous report
 func (r *Registry) RecordHealthReport(ctx context.Context, tenantID, agentID string, status models.AgentStatus, components map[string]interface{}) error {
+	now := time.Now()
 	report := &models.AgentHealthReport{
-		ID:         fmt.Sprintf("%s-%d", agentID, time.Now().UnixNano()),
+		ID:         fmt.Sprintf("%s-%d", agentID, now.UnixNano()),
 		AgentID:    agentID,
 		TenantID:   tenantID,
 		Status:     status,
 		Components: components,
-		ReportedAt: time.Now(),
+		ReportedAt: now,
 	}
 
-	if err := r.db.Create(report).Error; err != nil {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		previous, err := latestHealthReport(tx, tenantID, agentID)
+		if err != nil {
+			return err
+		}
+
+		if transitions := healthTransitions(tenantID, agentID, previous, status, components, now); len(transitions) > 0 {
+			if err := tx.Create(&transitions).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(report).Error
+	})
+	if err != nil {
 		return fmt.Errorf("failed to record health report: %w", err)
 	}
 
@@ -175,6 +227,129 @@ func (r *Registry) MarkOfflineAgents(ctx context.Context, threshold time.Duratio
 	return result.RowsAffected, nil
 }
 
+// Drain stops new executions being dispatched to an agent. The agent stays
+// draining until it reports that its in-flight executions have finished.
+// Draining an agent that is already draining or drained is a no-op.
+func (r *Registry) Drain(ctx context.Context, tenantID, agentID string) (*models.Agent, error) {
+	now := time.Now()
+	result := r.db.WithContext(ctx).Model(&models.Agent{}).
+		Where("id = ? AND tenant_id = ? AND drain_state = ?", agentID, tenantID, models.AgentDrainNone).
+		Updates(map[string]interface{}{
+			"drain_state":        models.AgentDrainDraining,
+			"drain_requested_at": now,
+			"drained_at":         nil,
+			"updated_at":         now,
+		})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to drain agent: %w", result.Error)
+	}
+
+	agent, err := r.Get(ctx, tenantID, agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.RowsAffected > 0 {
+		r.logger.Info("agent draining",
+			zap.String("tenant_id", tenantID),
+			zap.String("agent_id", agentID))
+	}
+
+	return agent, nil
+}
+
+// Undrain returns a draining or drained agent to service
+func (r *Registry) Undrain(ctx context.Context, tenantID, agentID string) (*models.Agent, error) {
+	result := r.db.WithContext(ctx).Model(&models.Agent{}).
+		Where("id = ? AND tenant_id = ? AND drain_state <> ?", agentID, tenantID, models.AgentDrainNone).
+		Updates(map[string]interface{}{
+			"drain_state":        models.AgentDrainNone,
+			"drain_requested_at": nil,
+			"drained_at":         nil,
+			"updated_at":         time.Now(),
+		})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to undrain agent: %w", result.Error)
+	}
+
+	agent, err := r.Get(ctx, tenantID, agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.RowsAffected > 0 {
+		r.logger.Info("agent returned to service",
+			zap.String("tenant_id", tenantID),
+			zap.String("agent_id", agentID))
+	}
+
+	return agent, nil
+}
+
+// MarkDrained records that a draining agent has no executions in flight.
+// Reports from agents that are not draining are ignored.
+func (r *Registry) MarkDrained(ctx context.Context, tenantID, agentID string) error {
+	now := time.Now()
+	result := r.db.WithContext(ctx).Model(&models.Agent{}).
+		Where("id = ? AND tenant_id = ? AND drain_state = ?", agentID, tenantID, models.AgentDrainDraining).
+		Updates(map[string]interface{}{
+			"drain_state": models.AgentDrainDrained,
+			"drained_at":  now,
+			"updated_at":  now,
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to mark agent drained: %w", result.Error)
+	}
+
+	if result.RowsAffected > 0 {
+		r.logger.Info("agent drained",
+			zap.String("tenant_id", tenantID),
+			zap.String("agent_id", agentID))
+	}
+
+	return nil
+}
+
+// ResetIdentity removes an agent's key binding and revokes its tokens, so the
+// agent ID can be enrolled again with a new key, e.g. after a host rebuild.
+// The new key is only bound by a signed registration.
+func (r *Registry) ResetIdentity(ctx context.Context, tenantID, agentID string) (*models.Agent, error) {
+	now := time.Now()
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Agent{}).
+			Where("id = ? AND tenant_id = ?", agentID, tenantID).
+			Updates(map[string]interface{}{
+				"public_key":        "",
+				"key_fingerprint":   "",
+				"bound_at":          nil,
+				"identity_reset_at": now,
+				"updated_at":        now,
+			})
+		if result.Error != nil {
+			return fmt.Errorf("failed to reset agent identity: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return apperror.NotFound("agent not found")
+		}
+
+		if err := tx.Model(&models.AgentToken{}).
+			Where("agent_id = ? AND tenant_id = ? AND revoked_at IS NULL", agentID, tenantID).
+			Update("revoked_at", now).Error; err != nil {
+			return fmt.Errorf("failed to revoke agent tokens: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.logger.Info("agent identity reset",
+		zap.String("tenant_id", tenantID),
+		zap.String("agent_id", agentID))
+
+	return r.Get(ctx, tenantID, agentID)
+}
+
 // UpdateAgent updates agent information
 func (r *Registry) UpdateAgent(ctx context.Context, tenantID, agentID string, updates map[string]interface{}) error {
 	updates["updated_at"] = time.Now()
@@ -188,7 +363,7 @@ func (r *Registry) UpdateAgent(ctx context.Context, tenantID, agentID string, up
 	}
 
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("agent not found")
+		return apperror.NotFound("agent not found")
 	}
 
 	return nil
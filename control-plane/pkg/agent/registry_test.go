@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/internal/dbtest"
+	"github.com/yourorg/control-plane/pkg/auth"
+	// The agent model has encrypted columns
+	_ "github.com/yourorg/control-plane/pkg/encryption"
+)
+
+// agentStore answers the statements on a single agent and its tokens.
+// tokens maps the hashes of the agent's tokens to whether they are revoked;
+// expired holds the hashes of tokens that have expired.
+type agentStore struct {
+	t       *testing.T
+	row     map[string]driver.Value
+	tokens  map[string]bool
+	expired map[string]bool
+}
+
+var agentColumns = []string{"id", "tenant_id", "hostname", "public_key", "key_fingerprint", "bound_at", "identity_reset_at"}
+
+func newAgentStore(t *testing.T, publicKey string, tokens ...string) *agentStore {
+	s := &agentStore{t: t, tokens: map[string]bool{}, expired: map[string]bool{}, row: map[string]driver.Value{
+		"id": "agent-1", "tenant_id": "tenant-1", "hostname": "web-1",
+		"public_key": publicKey, "key_fingerprint": "fp", "bound_at": time.Now(), "identity_reset_at": nil,
+	}}
+	for _, token := range tokens {
+		s.tokens[auth.HashToken(token)] = false
+	}
+	return s
+}
+
+func (s *agentStore) handle(query string, args []driver.Value) (*dbtest.Result, error) {
+	switch {
+	case strings.HasPrefix(query, "SELECT count(*) FROM `agent_tokens`"):
+		var count int64
+		if revoked, ok := s.tokens[args[0].(string)]; ok && !revoked && !s.expired[args[0].(string)] {
+			count = 1
+		}
+		return &dbtest.Result{Columns: []string{"count"}, Rows: [][]driver.Value{{count}}}, nil
+	case strings.HasPrefix(query, "SELECT") && strings.Contains(query, "FROM `agents`"):
+		row := make([]driver.Value, len(agentColumns))
+		for i, column := range agentColumns {
+			row[i] = s.row[column]
+		}
+		return &dbtest.Result{Columns: agentColumns, Rows: [][]driver.Value{row}}, nil
+	case strings.HasPrefix(query, "UPDATE `agents`"):
+		set, _ := dbtest.Assignments(query, args)
+		for column, value := range set {
+			s.row[column] = value
+		}
+		return &dbtest.Result{RowsAffected: 1}, nil
+	case strings.HasPrefix(query, "UPDATE `agent_tokens`") && strings.Contains(query, "token_hash"):
+		// revoked_at, then agent_id, tenant_id and token_hash
+		hash := args[3].(string)
+		if revoked, ok := s.tokens[hash]; !ok || revoked || s.expired[hash] {
+			return &dbtest.Result{}, nil
+		}
+		s.tokens[hash] = true
+		return &dbtest.Result{RowsAffected: 1}, nil
+	case strings.HasPrefix(query, "UPDATE `agent_tokens`"):
+		for hash := range s.tokens {
+			s.tokens[hash] = true
+		}
+		return &dbtest.Result{RowsAffected: int64(len(s.tokens))}, nil
+	case strings.HasPrefix(query, "INSERT INTO `agent_tokens`"):
+		s.tokens[dbtest.Inserted(query, args)["token_hash"].(string)] = false
+		return &dbtest.Result{RowsAffected: 1}, nil
+	}
+	return &dbtest.Result{RowsAffected: 1}, nil
+}
+
+func TestResetIdentityRevokesTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	public, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := base64.StdEncoding.EncodeToString(public)
+	newPublic, newKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPublicKey := base64.StdEncoding.EncodeToString(newPublic)
+
+	store := newAgentStore(t, publicKey, "agent-token")
+	db := dbtest.Open(t, store.handle)
+	router := gin.New()
+	router.POST("/api/v1/agent/heartbeat", func(c *gin.Context) {
+		c.Set(string(auth.ContextKeyClaims), &auth.Claims{Type: string(auth.TokenTypeAgent), AgentID: "agent-1", TenantID: "tenant-1"})
+	}, auth.RequireAgentSignature(db), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	nonce := 0
+	send := func(key ed25519.PrivateKey, publicKey string) int {
+		nonce++
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		message := auth.RequestMessage(http.MethodPost, "/api/v1/agent/heartbeat", timestamp, strconv.Itoa(nonce), nil)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/agent/heartbeat", bytes.NewReader(nil))
+		req.Header.Set("Authorization", "Bearer agent-token")
+		req.Header.Set(auth.HeaderAgentTimestamp, timestamp)
+		req.Header.Set(auth.HeaderAgentNonce, strconv.Itoa(nonce))
+		req.Header.Set(auth.HeaderAgentPublicKey, publicKey)
+		req.Header.Set(auth.HeaderAgentSignature, base64.StdEncoding.EncodeToString(ed25519.Sign(key, message)))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := send(key, publicKey); code != http.StatusOK {
+		t.Fatalf("request before the reset: status = %d, want 200", code)
+	}
+
+	agent, err := NewRegistry(db, zap.NewNop()).ResetIdentity(context.Background(), "tenant-1", "agent-1")
+	if err != nil {
+		t.Fatalf("ResetIdentity: %v", err)
+	}
+	if agent.PublicKey != "" || agent.IdentityResetAt == nil {
+		t.Fatalf("reset agent has key %q, reset at %v", agent.PublicKey, agent.IdentityResetAt)
+	}
+
+	if code := send(key, publicKey); code != http.StatusUnauthorized {
+		t.Errorf("request with the revoked token: status = %d, want 401", code)
+	}
+	// A stolen token cannot bind a key of its own either
+	store.tokens[auth.HashToken("agent-token")] = false
+	if code := send(newKey, newPublicKey); code != http.StatusUnauthorized {
+		t.Errorf("request binding a key after the reset: status = %d, want 401", code)
+	}
+	if store.row["public_key"] != "" {
+		t.Errorf("request bound key %v after the reset", store.row["public_key"])
+	}
+}
@@ -0,0 +1,412 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// DefaultSoftwareHistoryRetention is how long software changes are kept
+const DefaultSoftwareHistoryRetention = 90 * 24 * time.Hour
+
+// softwareHistoryPruneInterval is how often expired software changes are deleted
+const softwareHistoryPruneInterval = time.Hour
+
+// maxSoftwarePackages bounds the packages of one inventory report
+const maxSoftwarePackages = 20000
+
+// softwareBatchSize is the number of rows written per statement while an
+// inventory report is stored
+const softwareBatchSize = 500
+
+// softwareSources are the package managers agents list software with
+var softwareSources = map[string]bool{"dpkg": true, "rpm": true, "choco": true, "winget": true}
+
+// SoftwarePackage is a package in a software inventory report
+type SoftwarePackage struct {
+	Source  string `json:"source"`
+	Name    string `json:"name"`
+	Arch    string `json:"arch,omitempty"`
+	Version string `json:"version"`
+}
+
+// key identifies a package on an agent; versions of one package replace
+// each other
+func (p *SoftwarePackage) key() string {
+	return p.Source + "\x00" + p.Name + "\x00" + p.Arch
+}
+
+// SoftwareReport is an agent's software inventory. Full reports list every
+// package. Delta reports apply to the inventory the agent reported as Base:
+// Packages are those installed or updated since and Removed those no longer
+// installed. A delta whose Base is not the agent's last reported Hash is
+// refused with a conflict, and the agent sends a full report instead.
+type SoftwareReport struct {
+	Full     bool              `json:"full"`
+	Base     string            `json:"base,omitempty"`
+	Hash     string            `json:"hash" binding:"required"`
+	Packages []SoftwarePackage `json:"packages"`
+	Removed  []SoftwarePackage `json:"removed,omitempty"`
+}
+
+// SoftwareReportResult summarizes how an inventory report changed the
+// agent's stored inventory
+type SoftwareReportResult struct {
+	Hash      string `json:"hash"`
+	Packages  int    `json:"packages"`
+	Installed int    `json:"installed"`
+	Updated   int    `json:"updated"`
+	Removed   int    `json:"removed"`
+}
+
+// validate checks a report is well formed
+func (r *SoftwareReport) validate() error {
+	if r.Hash == "" || len(r.Hash) > 80 {
+		return apperror.InvalidInput("hash is required and must be at most 80 characters")
+	}
+	if !r.Full && r.Base == "" {
+		return apperror.InvalidInput("base is required for delta reports")
+	}
+	if len(r.Packages)+len(r.Removed) > maxSoftwarePackages {
+		return apperror.InvalidInput("at most %d packages may be reported at once", maxSoftwarePackages)
+	}
+	if r.Full && len(r.Removed) > 0 {
+		return apperror.InvalidInput("full reports must not list removed packages")
+	}
+	for _, list := range [][]SoftwarePackage{r.Packages, r.Removed} {
+		for i := range list {
+			pkg := &list[i]
+			if !softwareSources[pkg.Source] {
+				return apperror.InvalidInput("package %q: unknown source %q", pkg.Name, pkg.Source)
+			}
+			if pkg.Name == "" || len(pkg.Name) > 191 || len(pkg.Version) > 191 || len(pkg.Arch) > 32 {
+				return apperror.InvalidInput("package %q: name, version or arch is empty or too long", pkg.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// RecordSoftware stores an agent's software inventory report and the
+// changes it shows. The first report of an agent sets its baseline without
+// recording every package as installed.
+func (r *Registry) RecordSoftware(ctx context.Context, tenantID, agentID string, report *SoftwareReport) (*SoftwareReportResult, error) {
+	if err := report.validate(); err != nil {
+		return nil, err
+	}
+
+	result := &SoftwareReportResult{Hash: report.Hash}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var agent models.Agent
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "software_hash").
+			Where("id = ? AND tenant_id = ?", agentID, tenantID).
+			First(&agent).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return apperror.NotFound("agent not found")
+			}
+			return fmt.Errorf("failed to get agent: %w", err)
+		}
+		if !report.Full && agent.SoftwareHash != report.Base {
+			return apperror.Conflict("software inventory %s is not the stored inventory; send a full report", report.Base)
+		}
+
+		var stored []models.AgentSoftware
+		if err := tx.Where("agent_id = ? AND tenant_id = ?", agentID, tenantID).
+			Find(&stored).Error; err != nil {
+			return fmt.Errorf("failed to load software inventory: %w", err)
+		}
+		current := make(map[string]*models.AgentSoftware, len(stored))
+		for i := range stored {
+			pkg := SoftwarePackage{Source: stored[i].Source, Name: stored[i].Name, Arch: stored[i].Arch}
+			current[pkg.key()] = &stored[i]
+		}
+		baseline := agent.SoftwareHash == "" && len(stored) == 0
+
+		now := time.Now()
+		var (
+			inserts []models.AgentSoftware
+			changes []models.AgentSoftwareChange
+			deletes []string
+		)
+		change := func(pkg *SoftwarePackage, kind models.SoftwareChange, version, previous string) {
+			if baseline {
+				return
+			}
+			changes = append(changes, models.AgentSoftwareChange{
+				ID:              uuid.New().String(),
+				AgentID:         agentID,
+				TenantID:        tenantID,
+				Source:          pkg.Source,
+				Name:            pkg.Name,
+				Arch:            pkg.Arch,
+				Change:          kind,
+				Version:         version,
+				PreviousVersion: previous,
+				RecordedAt:      now,
+			})
+		}
+
+		reported := make(map[string]bool, len(report.Packages))
+		for i := range report.Packages {
+			pkg := &report.Packages[i]
+			key := pkg.key()
+			if reported[key] {
+				continue
+			}
+			reported[key] = true
+
+			existing, ok := current[key]
+			switch {
+			case !ok:
+				inserts = append(inserts, models.AgentSoftware{
+					ID:          uuid.New().String(),
+					AgentID:     agentID,
+					TenantID:    tenantID,
+					Source:      pkg.Source,
+					Name:        pkg.Name,
+					Arch:        pkg.Arch,
+					Version:     pkg.Version,
+					InstalledAt: now,
+					UpdatedAt:   now,
+				})
+				change(pkg, models.SoftwareInstalled, pkg.Version, "")
+				result.Installed++
+			case existing.Version != pkg.Version:
+				if err := tx.Model(&models.AgentSoftware{}).
+					Where("id = ?", existing.ID).
+					Updates(map[string]interface{}{"version": pkg.Version, "updated_at": now}).Error; err != nil {
+					return fmt.Errorf("failed to update software inventory: %w", err)
+				}
+				change(pkg, models.SoftwareUpdated, pkg.Version, existing.Version)
+				result.Updated++
+			}
+		}
+
+		remove := func(key string) {
+			existing, ok := current[key]
+			if !ok {
+				return
+			}
+			delete(current, key)
+			deletes = append(deletes, existing.ID)
+			pkg := SoftwarePackage{Source: existing.Source, Name: existing.Name, Arch: existing.Arch}
+			change(&pkg, models.SoftwareRemoved, "", existing.Version)
+			result.Removed++
+		}
+		if report.Full {
+			for key := range current {
+				if !reported[key] {
+					remove(key)
+				}
+			}
+		} else {
+			for i := range report.Removed {
+				if key := report.Removed[i].key(); !reported[key] {
+					remove(key)
+				}
+			}
+		}
+
+		if len(inserts) > 0 {
+			if err := tx.CreateInBatches(inserts, softwareBatchSize).Error; err != nil {
+				return fmt.Errorf("failed to store software inventory: %w", err)
+			}
+		}
+		for start := 0; start < len(deletes); start += softwareBatchSize {
+			end := start + softwareBatchSize
+			if end > len(deletes) {
+				end = len(deletes)
+			}
+			if err := tx.Where("id IN ?", deletes[start:end]).Delete(&models.AgentSoftware{}).Error; err != nil {
+				return fmt.Errorf("failed to remove software: %w", err)
+			}
+		}
+		if len(changes) > 0 {
+			if err := tx.CreateInBatches(changes, softwareBatchSize).Error; err != nil {
+				return fmt.Errorf("failed to record software changes: %w", err)
+			}
+		}
+
+		result.Packages = len(current) + len(inserts)
+		return tx.Model(&models.Agent{}).
+			Where("id = ? AND tenant_id = ?", agentID, tenantID).
+			Updates(map[string]interface{}{
+				"software_hash":        report.Hash,
+				"software_packages":    result.Packages,
+				"software_reported_at": now,
+			}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Installed+result.Updated+result.Removed > 0 {
+		r.logger.Debug("software inventory updated",
+			zap.String("tenant_id", tenantID),
+			zap.String("agent_id", agentID),
+			zap.Int("installed", result.Installed),
+			zap.Int("updated", result.Updated),
+			zap.Int("removed", result.Removed))
+	}
+
+	return result, nil
+}
+
+// ListSoftwareRequest represents a request to list an agent's software
+type ListSoftwareRequest struct {
+	TenantID string
+	AgentID  string
+	// Name matches packages whose name contains it
+	Name   string
+	Source string
+	Limit  int
+	Offset int
+}
+
+// ListSoftware lists the packages installed on an agent, by name
+func (r *Registry) ListSoftware(ctx context.Context, req *ListSoftwareRequest) ([]models.AgentSoftware, int64, error) {
+	if _, err := r.Get(ctx, req.TenantID, req.AgentID); err != nil {
+		return nil, 0, err
+	}
+
+	query := r.db.WithContext(ctx).Model(&models.AgentSoftware{}).
+		Where("tenant_id = ? AND agent_id = ?", req.TenantID, req.AgentID)
+	if req.Name != "" {
+		query = query.Where("name LIKE ?", "%"+escapeLike(req.Name)+"%")
+	}
+	if req.Source != "" {
+		query = query.Where("source = ?", req.Source)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count software: %w", err)
+	}
+	if req.Limit > 0 {
+		query = query.Limit(req.Limit)
+	}
+	if req.Offset > 0 {
+		query = query.Offset(req.Offset)
+	}
+
+	var packages []models.AgentSoftware
+	if err := query.Order("name ASC, arch ASC").Find(&packages).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to list software: %w", err)
+	}
+	return packages, total, nil
+}
+
+// GetSoftwareHistory returns the software changes on an agent since the
+// given time, newest first, optionally for one package name
+func (r *Registry) GetSoftwareHistory(ctx context.Context, tenantID, agentID string, since time.Time, name string, limit int) ([]models.AgentSoftwareChange, error) {
+	query := r.db.WithContext(ctx).
+		Where("tenant_id = ? AND agent_id = ? AND recorded_at >= ?", tenantID, agentID, since)
+	if name != "" {
+		query = query.Where("name = ?", name)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	var changes []models.AgentSoftwareChange
+	if err := query.Order("recorded_at DESC, name ASC").Find(&changes).Error; err != nil {
+		return nil, fmt.Errorf("failed to get software history: %w", err)
+	}
+	return changes, nil
+}
+
+// PruneSoftwareHistory deletes software changes older than the given time
+func (r *Registry) PruneSoftwareHistory(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Where("recorded_at < ?", before).
+		Delete(&models.AgentSoftwareChange{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to prune software history: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// RunSoftwareHistoryRetention periodically deletes software changes older
+// than retention until the context is cancelled
+func (r *Registry) RunSoftwareHistoryRetention(ctx context.Context, retention time.Duration) {
+	if retention <= 0 {
+		retention = DefaultSoftwareHistoryRetention
+	}
+
+	ticker := time.NewTicker(softwareHistoryPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := r.PruneSoftwareHistory(ctx, time.Now().Add(-retention))
+		if err != nil {
+			r.logger.Error("failed to prune agent software history", zap.Error(err))
+		} else if deleted > 0 {
+			r.logger.Info("pruned agent software history", zap.Int64("deleted", deleted))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// softwareVersions returns the installed versions of the named packages on
+// each agent. A package installed for several architectures or by several
+// package managers yields its lowest version.
+func (r *Registry) softwareVersions(ctx context.Context, agents []models.Agent, names []string) (map[string]map[string]string, error) {
+	if len(agents) == 0 || len(names) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, len(agents))
+	for i := range agents {
+		ids[i] = agents[i].ID
+	}
+
+	var packages []models.AgentSoftware
+	if err := r.db.WithContext(ctx).
+		Select("agent_id", "name", "version").
+		Where("agent_id IN ? AND name IN ?", ids, names).
+		Find(&packages).Error; err != nil {
+		return nil, fmt.Errorf("failed to load software inventory: %w", err)
+	}
+
+	versions := make(map[string]map[string]string)
+	for _, pkg := range packages {
+		version := softwareVersion(pkg.Version)
+		byName := versions[pkg.AgentID]
+		if byName == nil {
+			byName = make(map[string]string)
+			versions[pkg.AgentID] = byName
+		}
+		if previous, ok := byName[pkg.Name]; !ok || naturalCompare(version, previous) < 0 {
+			byName[pkg.Name] = version
+		}
+	}
+	return versions, nil
+}
+
+// softwareVersion strips a Debian or RPM epoch ("1:") from a version, so
+// versions compare by their upstream part
+func softwareVersion(version string) string {
+	if epoch, rest, ok := strings.Cut(version, ":"); ok && epoch != "" && strings.Trim(epoch, "0123456789") == "" {
+		return rest
+	}
+	return version
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
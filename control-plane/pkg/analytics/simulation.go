@@ -0,0 +1,427 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/stats"
+)
+
+const (
+	// minSimulationSamples is the number of executions an estimate needs
+	// before it is based on them rather than on a broader history
+	minSimulationSamples = 5
+
+	// wilsonZ is the z-score of the 95% interval around failure rates
+	wilsonZ = 1.96
+)
+
+// Simulation bases, from most to least specific
+const (
+	BasisSelectedAgents = "selected_agents"
+	BasisWorkflow       = "workflow"
+	BasisSimilarSteps   = "similar_steps"
+	BasisNone           = "none"
+)
+
+// SimulationRequest asks what running a workflow on a set of agents would
+// likely take
+type SimulationRequest struct {
+	TenantID   string
+	WorkflowID string
+	// AgentIDs are the agents the workflow would run on
+	AgentIDs []string
+	// Concurrency is the number of agents run at once; all of them when zero
+	Concurrency int
+	Since       time.Time
+	Until       time.Time
+}
+
+// Range is an estimate: Low and High bound the likely values around Expected
+type Range struct {
+	Low      float64 `json:"low"`
+	Expected float64 `json:"expected"`
+	High     float64 `json:"high"`
+
+	// max is the slowest duration seen, for wall clock estimates
+	max float64
+}
+
+// StepEstimate is the estimate for one step, from executions of steps that
+// run the same thing in any of the tenant's workflows
+type StepEstimate struct {
+	StepID             string `json:"step_id"`
+	Name               string `json:"name,omitempty"`
+	Samples            int    `json:"samples"`
+	Duration           *Range `json:"duration_seconds,omitempty"`
+	FailureProbability *Range `json:"failure_probability,omitempty"`
+}
+
+// Simulation estimates the outcome of running a workflow on a set of agents.
+// Durations are per execution, in seconds; WallClock is the whole run at the
+// requested concurrency, assuming each wave of agents waits for its slowest.
+type Simulation struct {
+	WorkflowID         string         `json:"workflow_id"`
+	Name               string         `json:"name"`
+	Version            int            `json:"version"`
+	Agents             int            `json:"agents"`
+	Concurrency        int            `json:"concurrency"`
+	Basis              string         `json:"basis"`
+	Samples            int            `json:"samples"`
+	SampledAgents      int            `json:"sampled_agents"`
+	Duration           *Range         `json:"duration_seconds,omitempty"`
+	WallClock          *Range         `json:"wall_clock_seconds,omitempty"`
+	FailureProbability *Range         `json:"failure_probability,omitempty"`
+	ExpectedFailures   *Range         `json:"expected_failures,omitempty"`
+	Steps              []StepEstimate `json:"steps,omitempty"`
+	Warnings           []string       `json:"warnings"`
+	Since              time.Time      `json:"since"`
+	Until              time.Time      `json:"until"`
+}
+
+// Simulate estimates the duration and failure probability of running a
+// workflow on the given agents from its execution history. The history of
+// the workflow on those agents is used when there is enough of it, then its
+// history on any agent, then the history of similar steps, i.e. steps of any
+// of the tenant's workflows that run the same command, script, template or
+// plugin.
+func (a *Analyzer) Simulate(ctx context.Context, req *SimulationRequest) (*Simulation, error) {
+	until := req.Until
+	if until.IsZero() {
+		until = time.Now()
+	}
+	since := req.Since
+	if since.IsZero() {
+		since = until.Add(-DefaultWindow)
+	}
+	if !since.Before(until) {
+		return nil, apperror.InvalidInput("since must be before until")
+	}
+	if req.Concurrency < 0 {
+		return nil, apperror.InvalidInput("concurrency must not be negative")
+	}
+
+	var workflow models.Workflow
+	if err := a.db.WithContext(ctx).
+		Select("id", "name", "version", "definition").
+		Where("id = ? AND tenant_id = ?", req.WorkflowID, req.TenantID).
+		First(&workflow).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("workflow not found")
+		}
+		return nil, fmt.Errorf("failed to get workflow: %w", err)
+	}
+
+	concurrency := req.Concurrency
+	if concurrency == 0 || concurrency > len(req.AgentIDs) {
+		concurrency = len(req.AgentIDs)
+	}
+	sim := &Simulation{
+		WorkflowID:  workflow.ID,
+		Name:        workflow.Name,
+		Version:     workflow.Version,
+		Agents:      len(req.AgentIDs),
+		Concurrency: concurrency,
+		Basis:       BasisNone,
+		Warnings:    []string{},
+		Since:       since,
+		Until:       until,
+	}
+	if len(req.AgentIDs) == 0 {
+		sim.Warnings = append(sim.Warnings, "the selector matches no agents")
+		return sim, nil
+	}
+
+	executions, truncated, err := a.historicalExecutions(ctx, req.TenantID, workflow.ID, since, until)
+	if err != nil {
+		return nil, err
+	}
+	if truncated {
+		sim.Warnings = append(sim.Warnings, fmt.Sprintf("only the %d most recent executions were used", maxExecutions))
+	}
+
+	selected := make(map[string]bool, len(req.AgentIDs))
+	for _, id := range req.AgentIDs {
+		selected[id] = true
+	}
+	var onSelected, all []sample
+	for i := range executions {
+		s := newSample(&executions[i])
+		all = append(all, s)
+		if selected[s.agentID] {
+			onSelected = append(onSelected, s)
+		}
+	}
+
+	switch {
+	case len(onSelected) >= minSimulationSamples:
+		sim.Basis = BasisSelectedAgents
+		sim.estimateFromSamples(onSelected)
+	case len(all) >= minSimulationSamples:
+		sim.Basis = BasisWorkflow
+		sim.Warnings = append(sim.Warnings, fmt.Sprintf(
+			"only %d executions on the selected agents; estimated from all %d executions of the workflow", len(onSelected), len(all)))
+		sim.estimateFromSamples(all)
+	default:
+		if err := a.estimateFromSimilarSteps(ctx, req.TenantID, since, until, &workflow, sim); err != nil {
+			return nil, err
+		}
+	}
+
+	if sim.Duration != nil {
+		waves := float64((sim.Agents + sim.Concurrency - 1) / sim.Concurrency)
+		sim.WallClock = &Range{
+			Low:      round(waves * sim.Duration.Expected),
+			Expected: round(waves * sim.Duration.High),
+			High:     round(waves * sim.Duration.max),
+		}
+	}
+	if sim.FailureProbability != nil {
+		n := float64(sim.Agents)
+		sim.ExpectedFailures = &Range{
+			Low:      round(n * sim.FailureProbability.Low),
+			Expected: round(n * sim.FailureProbability.Expected),
+			High:     round(n * sim.FailureProbability.High),
+		}
+	}
+
+	return sim, nil
+}
+
+// historicalExecutions loads the tenant's finished executions in the period,
+// most recent first, of one workflow or of all when workflowID is empty. It
+// reports whether older executions were left out.
+func (a *Analyzer) historicalExecutions(ctx context.Context, tenantID, workflowID string, since, until time.Time) ([]models.WorkflowExecution, bool, error) {
+	query := a.db.WithContext(ctx).Model(&models.WorkflowExecution{}).
+		Select("id", "workflow_id", "workflow_version", "agent_id", "status", "result", "started_at", "completed_at").
+		Where("tenant_id = ? AND status IN ? AND completed_at >= ? AND completed_at < ?", tenantID, analysedStatuses, since, until).
+		Where("started_at IS NOT NULL AND execution_mode = ?", models.ExecutionModeLive)
+	if workflowID != "" {
+		query = query.Where("workflow_id = ?", workflowID)
+	}
+
+	var executions []models.WorkflowExecution
+	if err := query.Order("completed_at DESC").
+		Limit(maxExecutions + 1).
+		Find(&executions).Error; err != nil {
+		return nil, false, fmt.Errorf("failed to load executions: %w", err)
+	}
+	if len(executions) > maxExecutions {
+		return executions[:maxExecutions], true, nil
+	}
+	return executions, false, nil
+}
+
+// estimateFromSamples sets the estimate from executions of the workflow
+func (s *Simulation) estimateFromSamples(samples []sample) {
+	durations := make([]float64, len(samples))
+	agents := make(map[string]bool)
+	failed := 0
+	for i, sm := range samples {
+		durations[i] = sm.duration
+		agents[sm.agentID] = true
+		if sm.failed {
+			failed++
+		}
+	}
+
+	s.Samples = len(samples)
+	s.SampledAgents = len(agents)
+	s.Duration = durationRange(durations)
+	s.FailureProbability = failureRange(failed, len(samples))
+}
+
+// estimateFromSimilarSteps sets the estimate from the history of steps that
+// run the same thing as the workflow's steps. The workflow's duration is the
+// sum of its steps' and it fails when any step does.
+func (a *Analyzer) estimateFromSimilarSteps(ctx context.Context, tenantID string, since, until time.Time, workflow *models.Workflow, sim *Simulation) error {
+	steps, _ := workflow.Definition["steps"].([]interface{})
+	type target struct {
+		id, name, signature string
+	}
+	var targets []target
+	wanted := make(map[string]bool)
+	for _, raw := range steps {
+		step, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, _ := step["id"].(string)
+		name, _ := step["name"].(string)
+		signature := stepSignature(step)
+		targets = append(targets, target{id: id, name: name, signature: signature})
+		wanted[signature] = true
+	}
+	if len(targets) == 0 {
+		sim.Warnings = append(sim.Warnings, "the workflow has no steps to estimate")
+		return nil
+	}
+
+	executions, truncated, err := a.historicalExecutions(ctx, tenantID, "", since, until)
+	if err != nil {
+		return err
+	}
+	if truncated {
+		sim.Warnings = append(sim.Warnings, fmt.Sprintf("only the %d most recent executions were searched for similar steps", maxExecutions))
+	}
+
+	// Map the step IDs of each workflow that ran to what the step runs
+	workflowIDs := make(map[string]bool)
+	for i := range executions {
+		workflowIDs[executions[i].WorkflowID] = true
+	}
+	signatures, err := a.stepSignatures(ctx, tenantID, workflowIDs)
+	if err != nil {
+		return err
+	}
+
+	durations := make(map[string][]float64)
+	failures := make(map[string]int)
+	for i := range executions {
+		execution := &executions[i]
+		for _, step := range newSample(execution).steps {
+			signature, ok := signatures[execution.WorkflowID][step.id]
+			if !ok || !wanted[signature] {
+				continue
+			}
+			durations[signature] = append(durations[signature], step.duration)
+			if step.failed {
+				failures[signature]++
+			}
+		}
+	}
+
+	total := &Range{}
+	succeed := Range{Low: 1, Expected: 1, High: 1}
+	complete := true
+	for _, t := range targets {
+		estimate := StepEstimate{StepID: t.id, Name: t.name, Samples: len(durations[t.signature])}
+		if estimate.Samples > 0 {
+			estimate.Duration = durationRange(durations[t.signature])
+			estimate.FailureProbability = failureRange(failures[t.signature], estimate.Samples)
+
+			total.Low += estimate.Duration.Low
+			total.Expected += estimate.Duration.Expected
+			total.High += estimate.Duration.High
+			total.max += estimate.Duration.max
+			succeed.Low *= 1 - estimate.FailureProbability.Low
+			succeed.Expected *= 1 - estimate.FailureProbability.Expected
+			succeed.High *= 1 - estimate.FailureProbability.High
+		} else {
+			complete = false
+		}
+		if estimate.Samples > sim.Samples {
+			sim.Samples = estimate.Samples
+		}
+		sim.Steps = append(sim.Steps, estimate)
+	}
+
+	if !complete {
+		sim.Warnings = append(sim.Warnings, "not enough history: some steps have never run in any workflow, so no estimate was made")
+		return nil
+	}
+	sim.Basis = BasisSimilarSteps
+	sim.Warnings = append(sim.Warnings, "too few executions of the workflow; estimated from similar steps in any workflow")
+	total.Low, total.Expected, total.High, total.max = round(total.Low), round(total.Expected), round(total.High), round(total.max)
+	sim.Duration = total
+	sim.FailureProbability = &Range{
+		Low:      round(1 - succeed.Low),
+		Expected: round(1 - succeed.Expected),
+		High:     round(1 - succeed.High),
+	}
+	return nil
+}
+
+// stepSignatures returns, for each workflow, the signature of each of its
+// current steps by step ID
+func (a *Analyzer) stepSignatures(ctx context.Context, tenantID string, workflowIDs map[string]bool) (map[string]map[string]string, error) {
+	if len(workflowIDs) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, 0, len(workflowIDs))
+	for id := range workflowIDs {
+		ids = append(ids, id)
+	}
+
+	var workflows []models.Workflow
+	if err := a.db.WithContext(ctx).
+		Select("id", "definition").
+		Where("tenant_id = ? AND id IN ?", tenantID, ids).
+		Find(&workflows).Error; err != nil {
+		return nil, fmt.Errorf("failed to load workflows: %w", err)
+	}
+
+	signatures := make(map[string]map[string]string, len(workflows))
+	for i := range workflows {
+		steps, _ := workflows[i].Definition["steps"].([]interface{})
+		byID := make(map[string]string, len(steps))
+		for _, raw := range steps {
+			step, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if id, _ := step["id"].(string); id != "" {
+				byID[id] = stepSignature(step)
+			}
+		}
+		signatures[workflows[i].ID] = byID
+	}
+	return signatures, nil
+}
+
+// signatureFields are the step fields that decide what a step runs
+var signatureFields = []string{"type", "command", "args", "script", "interpreter", "template", "plugin", "callback"}
+
+// stepSignature identifies what a step runs, ignoring its ID, name, timeouts
+// and retries, so the same command in two workflows has the same signature
+func stepSignature(step map[string]interface{}) string {
+	fields := make(map[string]interface{}, len(signatureFields))
+	for _, key := range signatureFields {
+		if v, ok := step[key]; ok {
+			fields[key] = v
+		}
+	}
+	// Maps marshal with sorted keys, so equal steps have equal signatures
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// durationRange is the range of durations from the 10th to the 95th
+// percentile around the median
+func durationRange(durations []float64) *Range {
+	summary := summarise(durations)
+	sorted := append([]float64(nil), durations...)
+	sort.Float64s(sorted)
+	return &Range{
+		Low:      round(stats.Percentile(sorted, 10)),
+		Expected: summary.P50,
+		High:     summary.P95,
+		max:      summary.Max,
+	}
+}
+
+// failureRange is the observed failure rate with its 95% Wilson score
+// interval, which stays meaningful for small sample counts
+func failureRange(failed, total int) *Range {
+	n := float64(total)
+	p := float64(failed) / n
+	z2 := wilsonZ * wilsonZ
+	center := (p + z2/(2*n)) / (1 + z2/n)
+	margin := wilsonZ * math.Sqrt(p*(1-p)/n+z2/(4*n*n)) / (1 + z2/n)
+	return &Range{
+		Low:      round(math.Max(0, center-margin)),
+		Expected: round(p),
+		High:     round(math.Min(1, center+margin)),
+	}
+}
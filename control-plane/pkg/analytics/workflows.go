@@ -0,0 +1,425 @@
+// Package analytics aggregates execution history for the control plane.
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/stats"
+)
+
+const (
+	// DefaultWindow is the period analysed when no start time is given
+	DefaultWindow = 30 * 24 * time.Hour
+
+	// maxExecutions bounds the executions loaded for one report; the most
+	// recent are kept
+	maxExecutions = 10000
+
+	// minOutlierSamples is the number of executions an agent needs before
+	// it can be reported as an outlier
+	minOutlierSamples = 3
+
+	// outlierFactor is how many times slower than the workflow median an
+	// agent's median must be to be reported as an outlier
+	outlierFactor = 2.0
+
+	// regressionFactor is the p95 growth over the previous version above
+	// which a version is flagged as a regression
+	regressionFactor = 1.2
+
+	// minRegressionSamples is the number of executions both versions need
+	// before a regression is flagged
+	minRegressionSamples = 3
+)
+
+// analysedStatuses are the final statuses whose run time is meaningful.
+// Cancelled executions are left out because they were stopped early.
+var analysedStatuses = []models.ExecutionStatus{
+	models.ExecutionStatusSuccess,
+	models.ExecutionStatusFailed,
+	models.ExecutionStatusTimeout,
+}
+
+// Analyzer computes duration analytics from workflow executions
+type Analyzer struct {
+	db     *gorm.DB
+	logger *zap.Logger
+}
+
+// NewAnalyzer creates a new analyzer
+func NewAnalyzer(db *gorm.DB, logger *zap.Logger) *Analyzer {
+	return &Analyzer{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// WorkflowQuery selects the executions to analyse
+type WorkflowQuery struct {
+	TenantID   string
+	WorkflowID string // optional; all workflows when empty
+	Since      time.Time
+	Until      time.Time
+}
+
+// DurationStats summarises a set of durations, in seconds
+type DurationStats struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean_seconds"`
+	P50   float64 `json:"p50_seconds"`
+	P95   float64 `json:"p95_seconds"`
+	Max   float64 `json:"max_seconds"`
+}
+
+// StepStats is the duration of one workflow step across executions
+type StepStats struct {
+	StepID   string        `json:"step_id"`
+	Name     string        `json:"name,omitempty"`
+	Failed   int           `json:"failed"`
+	Duration DurationStats `json:"duration"`
+}
+
+// VersionStats is the duration of one workflow version. Changes are
+// percentages against the previous version with executions.
+type VersionStats struct {
+	Version    int           `json:"version"`
+	Executions int           `json:"executions"`
+	Failed     int           `json:"failed"`
+	Duration   DurationStats `json:"duration"`
+	P50Change  *float64      `json:"p50_change_percent,omitempty"`
+	P95Change  *float64      `json:"p95_change_percent,omitempty"`
+	Regression bool          `json:"regression"`
+}
+
+// AgentOutlier is an agent that runs a workflow much slower than the rest
+type AgentOutlier struct {
+	AgentID    string  `json:"agent_id"`
+	Executions int     `json:"executions"`
+	P50        float64 `json:"p50_seconds"`
+	Ratio      float64 `json:"ratio_to_median"`
+}
+
+// WorkflowStats is the duration analysis of one workflow
+type WorkflowStats struct {
+	WorkflowID     string         `json:"workflow_id"`
+	Name           string         `json:"name"`
+	CurrentVersion int            `json:"current_version"`
+	Executions     int            `json:"executions"`
+	Succeeded      int            `json:"succeeded"`
+	Failed         int            `json:"failed"`
+	Duration       DurationStats  `json:"duration"`
+	Steps          []StepStats    `json:"steps"`
+	Versions       []VersionStats `json:"versions"`
+	Outliers       []AgentOutlier `json:"outliers"`
+}
+
+// WorkflowReport is the duration analysis of a tenant's workflows
+type WorkflowReport struct {
+	Since     time.Time       `json:"since"`
+	Until     time.Time       `json:"until"`
+	Workflows []WorkflowStats `json:"workflows"`
+	// Truncated is set when only the most recent executions were analysed
+	Truncated bool `json:"truncated"`
+}
+
+// sample is the run time of one finished execution
+type sample struct {
+	version  int
+	agentID  string
+	failed   bool
+	duration float64
+	steps    []stepSample
+}
+
+// stepSample is the run time of one step of an execution
+type stepSample struct {
+	id       string
+	name     string
+	failed   bool
+	duration float64
+}
+
+// WorkflowDurations reports p50/p95 durations per workflow and step, the
+// trend over workflow versions and agents that are consistently slow.
+// Workflows are ordered by p95 duration, slowest first.
+func (a *Analyzer) WorkflowDurations(ctx context.Context, q *WorkflowQuery) (*WorkflowReport, error) {
+	until := q.Until
+	if until.IsZero() {
+		until = time.Now()
+	}
+	since := q.Since
+	if since.IsZero() {
+		since = until.Add(-DefaultWindow)
+	}
+	if !since.Before(until) {
+		return nil, apperror.InvalidInput("since must be before until")
+	}
+
+	query := a.db.WithContext(ctx).Model(&models.WorkflowExecution{}).
+		Select("id", "workflow_id", "workflow_version", "agent_id", "status", "result", "started_at", "completed_at").
+		Where("tenant_id = ? AND status IN ? AND completed_at >= ? AND completed_at < ?", q.TenantID, analysedStatuses, since, until).
+		// Validate executions skip most steps, so their timings say nothing
+		// about a real run
+		Where("started_at IS NOT NULL AND execution_mode = ?", models.ExecutionModeLive)
+	if q.WorkflowID != "" {
+		query = query.Where("workflow_id = ?", q.WorkflowID)
+	}
+
+	var executions []models.WorkflowExecution
+	if err := query.Order("completed_at DESC").Limit(maxExecutions + 1).Find(&executions).Error; err != nil {
+		return nil, fmt.Errorf("failed to load executions: %w", err)
+	}
+
+	report := &WorkflowReport{
+		Since:     since,
+		Until:     until,
+		Workflows: []WorkflowStats{},
+	}
+	if len(executions) > maxExecutions {
+		executions = executions[:maxExecutions]
+		report.Truncated = true
+	}
+	if len(executions) == 0 {
+		return report, nil
+	}
+
+	samples := make(map[string][]sample)
+	for i := range executions {
+		execution := &executions[i]
+		samples[execution.WorkflowID] = append(samples[execution.WorkflowID], newSample(execution))
+	}
+
+	workflowIDs := make([]string, 0, len(samples))
+	for id := range samples {
+		workflowIDs = append(workflowIDs, id)
+	}
+	var workflows []models.Workflow
+	if err := a.db.WithContext(ctx).
+		Select("id", "name", "version").
+		Where("tenant_id = ? AND id IN ?", q.TenantID, workflowIDs).
+		Find(&workflows).Error; err != nil {
+		return nil, fmt.Errorf("failed to load workflows: %w", err)
+	}
+	byID := make(map[string]*models.Workflow, len(workflows))
+	for i := range workflows {
+		byID[workflows[i].ID] = &workflows[i]
+	}
+
+	for _, id := range workflowIDs {
+		stats := analyseWorkflow(samples[id])
+		stats.WorkflowID = id
+		if wf, ok := byID[id]; ok {
+			stats.Name = wf.Name
+			stats.CurrentVersion = wf.Version
+		}
+		report.Workflows = append(report.Workflows, stats)
+	}
+
+	sort.Slice(report.Workflows, func(i, j int) bool {
+		if report.Workflows[i].Duration.P95 != report.Workflows[j].Duration.P95 {
+			return report.Workflows[i].Duration.P95 > report.Workflows[j].Duration.P95
+		}
+		return report.Workflows[i].WorkflowID < report.Workflows[j].WorkflowID
+	})
+
+	return report, nil
+}
+
+// newSample extracts run times from an execution. The agent's measured
+// durations are preferred; the control plane's timestamps are the fallback.
+func newSample(execution *models.WorkflowExecution) sample {
+	s := sample{
+		version: execution.WorkflowVersion,
+		agentID: execution.AgentID,
+		failed:  execution.Status != models.ExecutionStatusSuccess,
+	}
+
+	if d, ok := models.AgentDuration(execution.Result, "duration"); ok && d > 0 {
+		s.duration = d.Seconds()
+	} else if d := execution.Duration(); d != nil {
+		s.duration = d.Seconds()
+	}
+
+	steps, _ := execution.Result["steps"].([]interface{})
+	for _, raw := range steps {
+		step, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, _ := step["step_id"].(string)
+		if id == "" {
+			continue
+		}
+		status, _ := step["status"].(string)
+		if status == "skipped" || status == "pending" {
+			continue
+		}
+		d, _ := models.AgentDuration(step, "duration")
+		name, _ := step["step_name"].(string)
+		s.steps = append(s.steps, stepSample{
+			id:       id,
+			name:     name,
+			failed:   status == "failed",
+			duration: d.Seconds(),
+		})
+	}
+
+	return s
+}
+
+// analyseWorkflow aggregates the samples of one workflow
+func analyseWorkflow(samples []sample) WorkflowStats {
+	stats := WorkflowStats{
+		Executions: len(samples),
+		Steps:      []StepStats{},
+		Versions:   []VersionStats{},
+		Outliers:   []AgentOutlier{},
+	}
+
+	durations := make([]float64, 0, len(samples))
+	byVersion := make(map[int][]sample)
+	byAgent := make(map[string][]float64)
+	stepOrder := []string{}
+	steps := make(map[string]*StepStats)
+	stepDurations := make(map[string][]float64)
+
+	for _, s := range samples {
+		if s.failed {
+			stats.Failed++
+		} else {
+			stats.Succeeded++
+		}
+		durations = append(durations, s.duration)
+		byAgent[s.agentID] = append(byAgent[s.agentID], s.duration)
+		if s.version > 0 {
+			byVersion[s.version] = append(byVersion[s.version], s)
+		}
+
+		for _, step := range s.steps {
+			st, ok := steps[step.id]
+			if !ok {
+				st = &StepStats{StepID: step.id, Name: step.name}
+				steps[step.id] = st
+				stepOrder = append(stepOrder, step.id)
+			}
+			if step.failed {
+				st.Failed++
+			}
+			stepDurations[step.id] = append(stepDurations[step.id], step.duration)
+		}
+	}
+	stats.Duration = summarise(durations)
+
+	for _, id := range stepOrder {
+		st := steps[id]
+		st.Duration = summarise(stepDurations[id])
+		stats.Steps = append(stats.Steps, *st)
+	}
+
+	stats.Versions = versionTrend(byVersion)
+
+	if stats.Duration.P50 > 0 {
+		for agentID, agentDurations := range byAgent {
+			if len(agentDurations) < minOutlierSamples {
+				continue
+			}
+			p50 := summarise(agentDurations).P50
+			ratio := p50 / stats.Duration.P50
+			if ratio < outlierFactor {
+				continue
+			}
+			stats.Outliers = append(stats.Outliers, AgentOutlier{
+				AgentID:    agentID,
+				Executions: len(agentDurations),
+				P50:        p50,
+				Ratio:      round(ratio),
+			})
+		}
+		sort.Slice(stats.Outliers, func(i, j int) bool {
+			return stats.Outliers[i].Ratio > stats.Outliers[j].Ratio
+		})
+	}
+
+	return stats
+}
+
+// versionTrend summarises each version, oldest first, comparing it with the
+// previous version
+func versionTrend(byVersion map[int][]sample) []VersionStats {
+	versions := make([]int, 0, len(byVersion))
+	for v := range byVersion {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+
+	trend := make([]VersionStats, 0, len(versions))
+	for i, v := range versions {
+		durations := make([]float64, len(byVersion[v]))
+		vs := VersionStats{Version: v, Executions: len(byVersion[v])}
+		for j, s := range byVersion[v] {
+			durations[j] = s.duration
+			if s.failed {
+				vs.Failed++
+			}
+		}
+		vs.Duration = summarise(durations)
+
+		if i > 0 {
+			prev := trend[i-1]
+			vs.P50Change = percentChange(prev.Duration.P50, vs.Duration.P50)
+			vs.P95Change = percentChange(prev.Duration.P95, vs.Duration.P95)
+			vs.Regression = prev.Executions >= minRegressionSamples &&
+				vs.Executions >= minRegressionSamples &&
+				prev.Duration.P95 > 0 &&
+				vs.Duration.P95 > prev.Duration.P95*regressionFactor
+		}
+		trend = append(trend, vs)
+	}
+	return trend
+}
+
+// summarise computes duration statistics; percentiles use the nearest rank
+func summarise(durations []float64) DurationStats {
+	if len(durations) == 0 {
+		return DurationStats{}
+	}
+	sorted := append([]float64(nil), durations...)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, d := range sorted {
+		sum += d
+	}
+
+	return DurationStats{
+		Count: len(sorted),
+		Mean:  round(sum / float64(len(sorted))),
+		P50:   round(stats.Percentile(sorted, 50)),
+		P95:   round(stats.Percentile(sorted, 95)),
+		Max:   round(sorted[len(sorted)-1]),
+	}
+}
+
+// percentChange returns the change from before to after in percent, or nil
+// when before is zero
+func percentChange(before, after float64) *float64 {
+	if before == 0 {
+		return nil
+	}
+	change := round((after - before) / before * 100)
+	return &change
+}
+
+// round rounds to three decimal places
+func round(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
@@ -0,0 +1,378 @@
+// Package anomaly analyses the audit log for unusual activity: spikes in
+// failed logins, surges of agent registrations and actors starting
+// executions at odd hours. Findings are kept as anomalies and reported as
+// alert events in the audit log, where notification consumers pick them up.
+package anomaly
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// Config configures the audit log analysis
+type Config struct {
+	// Interval is how often the audit log is analysed
+	Interval time.Duration `json:"interval" yaml:"interval"`
+	// Window is the recent activity compared with the baseline
+	Window time.Duration `json:"window" yaml:"window"`
+	// Baseline is the period before the window that gives the usual
+	// activity per window
+	Baseline time.Duration `json:"baseline" yaml:"baseline"`
+
+	// A tenant's failed logins or registrations in the window are a spike
+	// when they reach both the minimum and the factor times the baseline
+	FailedAuthFactor    float64 `json:"failed_auth_factor" yaml:"failed_auth_factor"`
+	FailedAuthMinimum   int64   `json:"failed_auth_minimum" yaml:"failed_auth_minimum"`
+	RegistrationFactor  float64 `json:"registration_factor" yaml:"registration_factor"`
+	RegistrationMinimum int64   `json:"registration_minimum" yaml:"registration_minimum"`
+
+	// QuietHours is the daily range of hours, e.g. "22-6", in which actors
+	// starting executions are checked against their history; equal hours
+	// turn the check off
+	QuietHours string `json:"quiet_hours" yaml:"quiet_hours"`
+	// Timezone is the IANA zone of the quiet hours
+	Timezone string `json:"timezone" yaml:"timezone"`
+	// ActorHistory is how far back an actor's executions are looked at
+	ActorHistory time.Duration `json:"actor_history" yaml:"actor_history"`
+}
+
+// DefaultConfig returns the default analysis configuration
+func DefaultConfig() *Config {
+	return &Config{
+		Interval:            5 * time.Minute,
+		Window:              15 * time.Minute,
+		Baseline:            24 * time.Hour,
+		FailedAuthFactor:    3,
+		FailedAuthMinimum:   20,
+		RegistrationFactor:  3,
+		RegistrationMinimum: 10,
+		QuietHours:          "22-6",
+		Timezone:            "UTC",
+		ActorHistory:        30 * 24 * time.Hour,
+	}
+}
+
+// finding is an anomaly found by one analysis
+type finding struct {
+	tenantID string
+	kind     models.AnomalyKind
+	severity models.AnomalySeverity
+	subject  string
+	observed int64
+	baseline float64
+	details  models.JSONMap
+}
+
+// Detector analyses the audit log and keeps the anomalies it finds
+type Detector struct {
+	db          *gorm.DB
+	auditLogger *audit.Logger
+	config      *Config
+	location    *time.Location
+	quietStart  int
+	quietEnd    int
+	logger      *zap.Logger
+}
+
+// NewDetector creates an anomaly detector. Invalid quiet hours or time
+// zones fall back to the defaults.
+func NewDetector(db *gorm.DB, auditLogger *audit.Logger, config *Config, logger *zap.Logger) *Detector {
+	defaults := DefaultConfig()
+	cfg := *config
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaults.Interval
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = defaults.Window
+	}
+	if cfg.Baseline <= 0 {
+		cfg.Baseline = defaults.Baseline
+	}
+	if cfg.FailedAuthFactor <= 0 {
+		cfg.FailedAuthFactor = defaults.FailedAuthFactor
+	}
+	if cfg.FailedAuthMinimum <= 0 {
+		cfg.FailedAuthMinimum = defaults.FailedAuthMinimum
+	}
+	if cfg.RegistrationFactor <= 0 {
+		cfg.RegistrationFactor = defaults.RegistrationFactor
+	}
+	if cfg.RegistrationMinimum <= 0 {
+		cfg.RegistrationMinimum = defaults.RegistrationMinimum
+	}
+	if cfg.ActorHistory <= 0 {
+		cfg.ActorHistory = defaults.ActorHistory
+	}
+
+	quietStart, quietEnd, err := parseHours(cfg.QuietHours)
+	if err != nil {
+		logger.Warn("invalid anomaly quiet hours, using the default",
+			zap.String("quiet_hours", cfg.QuietHours),
+			zap.Error(err))
+		cfg.QuietHours = defaults.QuietHours
+		quietStart, quietEnd, _ = parseHours(cfg.QuietHours)
+	}
+	location, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		logger.Warn("invalid anomaly timezone, using UTC",
+			zap.String("timezone", cfg.Timezone),
+			zap.Error(err))
+		cfg.Timezone = "UTC"
+		location = time.UTC
+	}
+
+	return &Detector{
+		db:          db,
+		auditLogger: auditLogger,
+		config:      &cfg,
+		location:    location,
+		quietStart:  quietStart,
+		quietEnd:    quietEnd,
+		logger:      logger,
+	}
+}
+
+// parseHours parses a range of hours such as "22-6"
+func parseHours(hours string) (int, int, error) {
+	from, to, ok := strings.Cut(hours, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected a range such as 22-6")
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(from))
+	if err != nil || start < 0 || start > 23 {
+		return 0, 0, fmt.Errorf("invalid start hour %q", from)
+	}
+	end, err := strconv.Atoi(strings.TrimSpace(to))
+	if err != nil || end < 0 || end > 23 {
+		return 0, 0, fmt.Errorf("invalid end hour %q", to)
+	}
+	return start, end, nil
+}
+
+// Run analyses the audit log every interval until the context is
+// cancelled. Every replica runs the analysis; each anomaly is recorded and
+// alerted once.
+func (d *Detector) Run(ctx context.Context) {
+	d.logger.Info("audit anomaly detection started",
+		zap.Duration("interval", d.config.Interval),
+		zap.Duration("window", d.config.Window))
+
+	ticker := time.NewTicker(d.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		d.Analyse(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Analyse runs every analysis over the window ending at now, records what
+// they find and resolves the anomalies no longer found
+func (d *Detector) Analyse(ctx context.Context, now time.Time) {
+	analyses := []struct {
+		kind models.AnomalyKind
+		run  func(context.Context, time.Time) ([]finding, error)
+	}{
+		{models.AnomalyKindFailedAuthSpike, d.failedAuthSpikes},
+		{models.AnomalyKindRegistrationSurge, d.registrationSurges},
+		{models.AnomalyKindOddHourExecution, d.oddHourExecutions},
+	}
+
+	for _, analysis := range analyses {
+		findings, err := analysis.run(ctx, now)
+		if err != nil {
+			// Without a result, the kind's open anomalies are left as they are
+			d.logger.Warn("audit anomaly analysis failed",
+				zap.String("kind", string(analysis.kind)),
+				zap.Error(err))
+			continue
+		}
+		for i := range findings {
+			if err := d.record(ctx, &findings[i], now); err != nil {
+				d.logger.Error("failed to record anomaly",
+					zap.String("kind", string(analysis.kind)),
+					zap.String("tenant_id", findings[i].tenantID),
+					zap.Error(err))
+			}
+		}
+		if err := d.resolve(ctx, analysis.kind, now); err != nil {
+			d.logger.Error("failed to resolve anomalies",
+				zap.String("kind", string(analysis.kind)),
+				zap.Error(err))
+		}
+	}
+}
+
+// openKey identifies the open anomaly of a tenant, kind and subject
+func openKey(tenantID string, kind models.AnomalyKind, subject string) string {
+	sum := sha256.Sum256([]byte(tenantID + "\x00" + string(kind) + "\x00" + subject))
+	return hex.EncodeToString(sum[:])
+}
+
+// record opens an anomaly for a finding, raising an alert, or refreshes
+// the open one
+func (d *Detector) record(ctx context.Context, f *finding, now time.Time) error {
+	key := openKey(f.tenantID, f.kind, f.subject)
+	anomaly := &models.Anomaly{
+		ID:         uuid.New().String(),
+		TenantID:   f.tenantID,
+		Kind:       f.kind,
+		Severity:   f.severity,
+		Status:     models.AnomalyStatusOpen,
+		Subject:    f.subject,
+		OpenKey:    &key,
+		Observed:   f.observed,
+		Baseline:   f.baseline,
+		Details:    f.details,
+		DetectedAt: now,
+		LastSeenAt: now,
+	}
+
+	result := d.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(anomaly)
+	if result.Error != nil {
+		return fmt.Errorf("failed to create anomaly: %w", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		d.logger.Warn("audit anomaly detected",
+			zap.String("anomaly_id", anomaly.ID),
+			zap.String("tenant_id", anomaly.TenantID),
+			zap.String("kind", string(anomaly.Kind)),
+			zap.String("subject", anomaly.Subject),
+			zap.Int64("observed", anomaly.Observed),
+			zap.Float64("baseline", anomaly.Baseline))
+		d.alert(ctx, anomaly, audit.ActionCreate)
+		return nil
+	}
+
+	// Already open, possibly recorded by another replica
+	if err := d.db.WithContext(ctx).Model(&models.Anomaly{}).
+		Where("open_key = ?", key).
+		Updates(map[string]interface{}{
+			"severity":     f.severity,
+			"observed":     f.observed,
+			"baseline":     f.baseline,
+			"details":      f.details,
+			"last_seen_at": now,
+		}).Error; err != nil {
+		return fmt.Errorf("failed to update anomaly: %w", err)
+	}
+	return nil
+}
+
+// resolve resolves a kind's open anomalies that no analysis found for an
+// interval, so replicas analysing at different times agree
+func (d *Detector) resolve(ctx context.Context, kind models.AnomalyKind, now time.Time) error {
+	var stale []models.Anomaly
+	if err := d.db.WithContext(ctx).
+		Where("kind = ? AND status = ? AND last_seen_at < ?", kind, models.AnomalyStatusOpen, now.Add(-d.config.Interval)).
+		Find(&stale).Error; err != nil {
+		return fmt.Errorf("failed to find open anomalies: %w", err)
+	}
+
+	for i := range stale {
+		anomaly := &stale[i]
+		result := d.db.WithContext(ctx).Model(&models.Anomaly{}).
+			Where("id = ? AND status = ?", anomaly.ID, models.AnomalyStatusOpen).
+			Updates(map[string]interface{}{
+				"status":      models.AnomalyStatusResolved,
+				"open_key":    nil,
+				"resolved_at": now,
+			})
+		if result.Error != nil {
+			return fmt.Errorf("failed to resolve anomaly: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		anomaly.Status = models.AnomalyStatusResolved
+		anomaly.ResolvedAt = &now
+		d.logger.Info("audit anomaly resolved",
+			zap.String("anomaly_id", anomaly.ID),
+			zap.String("tenant_id", anomaly.TenantID),
+			zap.String("kind", string(anomaly.Kind)))
+		d.alert(ctx, anomaly, audit.ActionUpdate)
+	}
+	return nil
+}
+
+// alert writes an alert event for a detected or resolved anomaly
+func (d *Detector) alert(ctx context.Context, anomaly *models.Anomaly, action audit.EventAction) {
+	description := fmt.Sprintf("%s anomaly detected", anomaly.Kind)
+	if anomaly.Status == models.AnomalyStatusResolved {
+		description = fmt.Sprintf("%s anomaly resolved", anomaly.Kind)
+	}
+
+	if err := d.auditLogger.NewEventBuilder().
+		WithTenant(anomaly.TenantID).
+		WithType(audit.EventTypeAlert).
+		WithAction(action).
+		WithOutcome(audit.OutcomeSuccess).
+		WithActor("anomaly-detector", "system").
+		WithResource(anomaly.ID, "anomaly").
+		WithDescription(description).
+		WithMetadata(map[string]interface{}{
+			"kind":     anomaly.Kind,
+			"severity": anomaly.Severity,
+			"status":   anomaly.Status,
+			"subject":  anomaly.Subject,
+			"observed": anomaly.Observed,
+			"baseline": anomaly.Baseline,
+			"details":  anomaly.Details,
+		}).
+		Log(ctx); err != nil {
+		d.logger.Warn("failed to write anomaly alert",
+			zap.String("anomaly_id", anomaly.ID),
+			zap.Error(err))
+	}
+}
+
+// ListAnomaliesRequest filters a tenant's anomalies
+type ListAnomaliesRequest struct {
+	TenantID string
+	// Status is open (the default), resolved or all
+	Status string
+	Kind   models.AnomalyKind
+	Limit  int
+}
+
+// List lists a tenant's anomalies, most recently detected first
+func (d *Detector) List(ctx context.Context, req *ListAnomaliesRequest) ([]models.Anomaly, error) {
+	query := d.db.WithContext(ctx).Where("tenant_id = ?", req.TenantID)
+	switch req.Status {
+	case "", string(models.AnomalyStatusOpen):
+		query = query.Where("status = ?", models.AnomalyStatusOpen)
+	case string(models.AnomalyStatusResolved):
+		query = query.Where("status = ?", models.AnomalyStatusResolved)
+	case "all":
+	default:
+		return nil, apperror.InvalidInput("invalid status %q: must be open, resolved or all", req.Status)
+	}
+	if req.Kind != "" {
+		query = query.Where("kind = ?", req.Kind)
+	}
+
+	var anomalies []models.Anomaly
+	if err := query.Order("detected_at DESC").Limit(req.Limit).Find(&anomalies).Error; err != nil {
+		return nil, fmt.Errorf("failed to list anomalies: %w", err)
+	}
+	return anomalies, nil
+}
@@ -0,0 +1,208 @@
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+const (
+	// maxTenants bounds the tenants counted by one aggregation
+	maxTenants = 1000
+	// maxEvents bounds the events one search looks at
+	maxEvents = 1000
+)
+
+// failedAuthSpikes finds tenants with unusually many rejected credentials
+func (d *Detector) failedAuthSpikes(ctx context.Context, now time.Time) ([]finding, error) {
+	return d.spikes(ctx, now, models.AnomalyKindFailedAuthSpike, &audit.SearchQuery{
+		EventTypes: []audit.EventType{audit.EventTypeAuth},
+		Outcomes:   []audit.EventOutcome{audit.OutcomeFailure},
+	}, d.config.FailedAuthFactor, d.config.FailedAuthMinimum)
+}
+
+// registrationSurges finds tenants registering unusually many agents
+func (d *Detector) registrationSurges(ctx context.Context, now time.Time) ([]finding, error) {
+	return d.spikes(ctx, now, models.AnomalyKindRegistrationSurge, &audit.SearchQuery{
+		EventTypes: []audit.EventType{audit.EventTypeAgent},
+		Actions:    []audit.EventAction{audit.ActionRegister},
+		Outcomes:   []audit.EventOutcome{audit.OutcomeSuccess},
+	}, d.config.RegistrationFactor, d.config.RegistrationMinimum)
+}
+
+// spikes counts the events matching a query per tenant in the window and
+// in the baseline before it, and finds the tenants whose window count
+// reaches both the minimum and factor times their usual count per window
+func (d *Detector) spikes(ctx context.Context, now time.Time, kind models.AnomalyKind, query *audit.SearchQuery, factor float64, minimum int64) ([]finding, error) {
+	windowStart := now.Add(-d.config.Window)
+	baselineStart := windowStart.Add(-d.config.Baseline)
+
+	current := *query
+	current.StartTime, current.EndTime = &windowStart, &now
+	observed, err := d.auditLogger.CountBy(ctx, &current, "tenant_id", maxTenants)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count events: %w", err)
+	}
+	if len(observed) == 0 {
+		return nil, nil
+	}
+
+	previous := *query
+	previous.StartTime, previous.EndTime = &baselineStart, &windowStart
+	usual, err := d.auditLogger.CountBy(ctx, &previous, "tenant_id", maxTenants)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count baseline events: %w", err)
+	}
+
+	windows := float64(d.config.Baseline) / float64(d.config.Window)
+	var findings []finding
+	for tenantID, count := range observed {
+		baseline := float64(usual[tenantID]) / windows
+		threshold := math.Max(float64(minimum), baseline*factor)
+		if float64(count) < threshold {
+			continue
+		}
+
+		severity := models.AnomalySeverityWarning
+		if float64(count) >= 2*threshold {
+			severity = models.AnomalySeverityCritical
+		}
+		findings = append(findings, finding{
+			tenantID: tenantID,
+			kind:     kind,
+			severity: severity,
+			observed: count,
+			baseline: math.Round(baseline*100) / 100,
+			details: models.JSONMap{
+				"window":    d.config.Window.String(),
+				"threshold": math.Round(threshold*100) / 100,
+			},
+		})
+	}
+	return findings, nil
+}
+
+// actorExecutions are the executions an actor started in quiet hours
+type actorExecutions struct {
+	tenantID string
+	actorID  string
+	count    int64
+	first    time.Time
+	last     time.Time
+}
+
+// oddHourExecutions finds actors who started executions in quiet hours in
+// the window without having done so before. Actors with no executions at
+// all in their history are critical.
+func (d *Detector) oddHourExecutions(ctx context.Context, now time.Time) ([]finding, error) {
+	if d.quietStart == d.quietEnd {
+		return nil, nil
+	}
+	windowStart := now.Add(-d.config.Window)
+
+	result, err := d.auditLogger.Search(ctx, &audit.SearchQuery{
+		Actions:   []audit.EventAction{audit.ActionExecute},
+		Outcomes:  []audit.EventOutcome{audit.OutcomeSuccess},
+		StartTime: &windowStart,
+		EndTime:   &now,
+		MaxHits:   maxEvents,
+		SortBy:    []audit.SortField{{Field: "timestamp", Order: "desc"}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search executions: %w", err)
+	}
+	if result.NumHits > int64(len(result.Hits)) {
+		d.logger.Warn("too many executions to check for odd hours, checking the latest",
+			zap.Int64("executions", result.NumHits),
+			zap.Int("checked", len(result.Hits)))
+	}
+
+	var actors []*actorExecutions
+	byActor := make(map[string]*actorExecutions)
+	for _, event := range result.Hits {
+		if event.ActorID == "" || event.ActorType == "system" || !d.quiet(event.Timestamp) {
+			continue
+		}
+		key := event.TenantID + "/" + event.ActorID
+		actor, ok := byActor[key]
+		if !ok {
+			actor = &actorExecutions{tenantID: event.TenantID, actorID: event.ActorID, first: event.Timestamp}
+			byActor[key] = actor
+			actors = append(actors, actor)
+		}
+		actor.count++
+		if event.Timestamp.Before(actor.first) {
+			actor.first = event.Timestamp
+		}
+		if event.Timestamp.After(actor.last) {
+			actor.last = event.Timestamp
+		}
+	}
+
+	var findings []finding
+	for _, actor := range actors {
+		historyStart := windowStart.Add(-d.config.ActorHistory)
+		history, err := d.auditLogger.Search(ctx, &audit.SearchQuery{
+			TenantID:  actor.tenantID,
+			ActorID:   actor.actorID,
+			Actions:   []audit.EventAction{audit.ActionExecute},
+			Outcomes:  []audit.EventOutcome{audit.OutcomeSuccess},
+			StartTime: &historyStart,
+			EndTime:   &windowStart,
+			MaxHits:   maxEvents,
+			SortBy:    []audit.SortField{{Field: "timestamp", Order: "desc"}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to search executions of %s: %w", actor.actorID, err)
+		}
+
+		usual := false
+		for _, event := range history.Hits {
+			// Search matches the actor ID by terms; only exact matches count
+			if event.ActorID == actor.actorID && d.quiet(event.Timestamp) {
+				usual = true
+				break
+			}
+		}
+		if usual {
+			continue
+		}
+
+		severity := models.AnomalySeverityWarning
+		if history.NumHits == 0 {
+			severity = models.AnomalySeverityCritical
+		}
+		findings = append(findings, finding{
+			tenantID: actor.tenantID,
+			kind:     models.AnomalyKindOddHourExecution,
+			severity: severity,
+			subject:  actor.actorID,
+			observed: actor.count,
+			details: models.JSONMap{
+				"window":             d.config.Window.String(),
+				"quiet_hours":        d.config.QuietHours,
+				"timezone":           d.config.Timezone,
+				"first_execution_at": actor.first,
+				"last_execution_at":  actor.last,
+				"history":            d.config.ActorHistory.String(),
+				"history_executions": history.NumHits,
+			},
+		})
+	}
+	return findings, nil
+}
+
+// quiet reports whether a time falls in the quiet hours
+func (d *Detector) quiet(t time.Time) bool {
+	hour := t.In(d.location).Hour()
+	if d.quietStart < d.quietEnd {
+		return hour >= d.quietStart && hour < d.quietEnd
+	}
+	return hour >= d.quietStart || hour < d.quietEnd
+}
@@ -0,0 +1,292 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/auth"
+	"github.com/yourorg/control-plane/pkg/breakglass"
+)
+
+// apiAuditLogTimeout bounds writing one API request to the audit log
+const apiAuditLogTimeout = 10 * time.Second
+
+// unknownTenantID is the tenant of failed logins whose credentials named
+// none; they are logged with the system events
+const unknownTenantID = "system"
+
+// auditActionKey holds the action a handler wants its request audited as
+const auditActionKey = "audit_action"
+
+// auditAs makes the API auditor record the request with the given action
+// instead of the one following its method
+func auditAs(c *gin.Context, action audit.EventAction) {
+	c.Set(auditActionKey, action)
+}
+
+// APIAuditConfig configures the audit logging of API requests
+type APIAuditConfig struct {
+	// ExcludePaths are path prefixes that are never audited
+	ExcludePaths []string `json:"exclude_paths" yaml:"exclude_paths"`
+	// SampleRates maps path prefixes to the fraction (0-1) of their
+	// successful requests that are audited; the longest match applies.
+	// Failed requests are always audited.
+	SampleRates map[string]float64 `json:"sample_rates" yaml:"sample_rates"`
+	// QueueSize bounds requests waiting to be written; beyond it they are dropped
+	QueueSize int `json:"queue_size" yaml:"queue_size"`
+}
+
+// DefaultAPIAuditConfig returns the default API audit configuration
+func DefaultAPIAuditConfig() *APIAuditConfig {
+	return &APIAuditConfig{
+		ExcludePaths: []string{"/health", "/ready"},
+		SampleRates:  map[string]float64{},
+		QueueSize:    10000,
+	}
+}
+
+// APIAuditor writes authenticated API calls, and those whose credentials
+// were rejected, to the audit log. Requests are
+// queued and written in the background so auditing never delays a response.
+type APIAuditor struct {
+	auditLogger *audit.Logger
+	config      *APIAuditConfig
+	breakGlass  *breakglass.Manager
+	logger      *zap.Logger
+
+	queue   chan *audit.APIRequest
+	dropped atomic.Int64
+	closing chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+// NewAPIAuditor creates an API auditor and starts its writer
+func NewAPIAuditor(auditLogger *audit.Logger, config *APIAuditConfig, logger *zap.Logger) *APIAuditor {
+	if config == nil {
+		config = DefaultAPIAuditConfig()
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultAPIAuditConfig().QueueSize
+	}
+
+	a := &APIAuditor{
+		auditLogger: auditLogger,
+		config:      config,
+		logger:      logger,
+		queue:       make(chan *audit.APIRequest, config.QueueSize),
+		closing:     make(chan struct{}),
+	}
+
+	a.wg.Add(1)
+	go a.run()
+
+	return a
+}
+
+// SetBreakGlass audits every request of tenants under an active
+// break-glass session, regardless of sample rates
+func (a *APIAuditor) SetBreakGlass(manager *breakglass.Manager) {
+	a.breakGlass = manager
+}
+
+// Middleware returns a gin middleware that audits requests once they have
+// been authenticated. Requests whose credentials were rejected are audited
+// as failed logins; other unauthenticated requests are not audited.
+func (a *APIAuditor) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+
+		c.Next()
+
+		if a.excluded(path) {
+			return
+		}
+		claims := auth.GetClaimsFromGin(c)
+		if claims == nil {
+			if failure := auth.GetAuthFailureFromGin(c); failure != nil {
+				a.enqueueAuthFailure(c, failure, path, time.Since(start))
+			}
+			return
+		}
+
+		status := c.Writer.Status()
+		metadata := map[string]interface{}{}
+		if route := c.FullPath(); route != "" {
+			metadata["route"] = route
+		}
+		if claims.Impersonating() {
+			audit.Impersonation{AdminID: claims.ImpersonatedBy, SessionID: claims.ImpersonationID}.Annotate(metadata)
+		}
+		elevated := a.breakGlass != nil && a.breakGlass.Elevated(c.Request.Context(), claims.TenantID)
+		if elevated {
+			metadata["break_glass"] = true
+		}
+		if status < 400 && !elevated {
+			rate := a.sampleRate(path)
+			if rate < 1 {
+				if rand.Float64() >= rate {
+					return
+				}
+				metadata["sample_rate"] = rate
+			}
+		}
+
+		actorID, actorType := claims.UserID, claims.Type
+		if claims.AgentID != "" {
+			actorID = claims.AgentID
+		}
+		if actorID == "" {
+			actorID = claims.Subject
+		}
+
+		var action audit.EventAction
+		if value, ok := c.Get(auditActionKey); ok {
+			action, _ = value.(audit.EventAction)
+		}
+
+		a.enqueue(&audit.APIRequest{
+			TenantID:   claims.TenantID,
+			ActorID:    actorID,
+			ActorType:  actorType,
+			Method:     c.Request.Method,
+			Path:       path,
+			StatusCode: status,
+			Duration:   time.Since(start),
+			RequestID:  GetRequestID(c),
+			IPAddress:  c.ClientIP(),
+			UserAgent:  c.Request.UserAgent(),
+			Metadata:   metadata,
+			Action:     action,
+		})
+	}
+}
+
+// enqueueAuthFailure queues a request whose credentials were rejected
+func (a *APIAuditor) enqueueAuthFailure(c *gin.Context, failure *auth.AuthFailure, path string, duration time.Duration) {
+	tenantID := failure.TenantID
+	if tenantID == "" {
+		tenantID = unknownTenantID
+	}
+	a.enqueue(&audit.APIRequest{
+		TenantID:    tenantID,
+		ActorType:   "unknown",
+		Method:      c.Request.Method,
+		Path:        path,
+		StatusCode:  c.Writer.Status(),
+		Duration:    duration,
+		RequestID:   GetRequestID(c),
+		IPAddress:   c.ClientIP(),
+		UserAgent:   c.Request.UserAgent(),
+		Metadata:    map[string]interface{}{},
+		AuthFailure: failure.Reason,
+	})
+}
+
+// Close stops accepting requests and waits for the queued ones to be
+// written, or for ctx to be done
+func (a *APIAuditor) Close(ctx context.Context) error {
+	a.once.Do(func() { close(a.closing) })
+
+	done := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("gave up writing %d queued API audit events: %w", len(a.queue), ctx.Err())
+	}
+}
+
+// Dropped returns how many requests were not audited because the queue was full
+func (a *APIAuditor) Dropped() int64 {
+	return a.dropped.Load()
+}
+
+// enqueue queues a request without blocking, dropping it when the queue is full
+func (a *APIAuditor) enqueue(req *audit.APIRequest) {
+	select {
+	case <-a.closing:
+		return
+	default:
+	}
+
+	select {
+	case a.queue <- req:
+	default:
+		// Log the first drop and every 1000th after it
+		if n := a.dropped.Add(1); n%1000 == 1 {
+			a.logger.Warn("API audit queue full, dropping events",
+				zap.Int64("dropped", n),
+				zap.Int("queue_size", a.config.QueueSize))
+		}
+	}
+}
+
+// run writes queued requests until the auditor is closed and the queue is empty
+func (a *APIAuditor) run() {
+	defer a.wg.Done()
+
+	for {
+		select {
+		case req := <-a.queue:
+			a.write(req)
+		case <-a.closing:
+			for {
+				select {
+				case req := <-a.queue:
+					a.write(req)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (a *APIAuditor) write(req *audit.APIRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), apiAuditLogTimeout)
+	defer cancel()
+
+	if err := a.auditLogger.LogAPIRequest(ctx, req); err != nil {
+		a.logger.Warn("failed to audit API request",
+			zap.String("request_id", req.RequestID),
+			zap.String("path", req.Path),
+			zap.Error(err))
+	}
+}
+
+// excluded reports whether a path is never audited
+func (a *APIAuditor) excluded(path string) bool {
+	for _, prefix := range a.config.ExcludePaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sampleRate returns the sample rate of the longest matching prefix, or 1
+func (a *APIAuditor) sampleRate(path string) float64 {
+	rate, matched := 1.0, -1
+	for prefix, r := range a.config.SampleRates {
+		if strings.HasPrefix(path, prefix) && len(prefix) > matched {
+			rate, matched = r, len(prefix)
+		}
+	}
+	return rate
+}
@@ -0,0 +1,136 @@
+// Package api provides HTTP API handlers for the control plane.
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/workflow"
+)
+
+// ErrorCode is a stable, machine-readable API error code
+type ErrorCode string
+
+// API error codes
+const (
+	ErrCodeInvalidRequest   ErrorCode = "invalid_request"
+	ErrCodeValidationFailed ErrorCode = "validation_failed"
+	ErrCodeNotFound         ErrorCode = "not_found"
+	ErrCodeInvalidState     ErrorCode = "invalid_state"
+	ErrCodeConflict         ErrorCode = "conflict"
+	ErrCodeQuotaExceeded    ErrorCode = "quota_exceeded"
+	ErrCodeUnauthorized     ErrorCode = "unauthorized"
+	ErrCodeForbidden        ErrorCode = "forbidden"
+	ErrCodeRateLimited      ErrorCode = "rate_limited"
+	ErrCodePayloadTooLarge  ErrorCode = "payload_too_large"
+	ErrCodeInternal         ErrorCode = "internal_error"
+	ErrCodeUnavailable      ErrorCode = "service_unavailable"
+)
+
+// internalErrorMessage is returned in place of unclassified error messages,
+// which may contain database or other internal details
+const internalErrorMessage = "internal server error"
+
+// APIError is the error body returned by the REST API
+type APIError struct {
+	Code      ErrorCode   `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// ErrorResponse wraps an APIError in the response envelope
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// errorKinds maps domain error kinds to HTTP statuses and codes
+var errorKinds = []struct {
+	kind   error
+	status int
+	code   ErrorCode
+}{
+	{apperror.ErrNotFound, http.StatusNotFound, ErrCodeNotFound},
+	{apperror.ErrInvalidInput, http.StatusBadRequest, ErrCodeValidationFailed},
+	{apperror.ErrInvalidState, http.StatusConflict, ErrCodeInvalidState},
+	{apperror.ErrConflict, http.StatusConflict, ErrCodeConflict},
+	{apperror.ErrQuotaExceeded, http.StatusForbidden, ErrCodeQuotaExceeded},
+	{apperror.ErrUnauthorized, http.StatusUnauthorized, ErrCodeUnauthorized},
+	{apperror.ErrForbidden, http.StatusForbidden, ErrCodeForbidden},
+	{apperror.ErrRateLimited, http.StatusTooManyRequests, ErrCodeRateLimited},
+}
+
+// writeAPIError aborts the request with a structured error body
+func writeAPIError(c *gin.Context, status int, code ErrorCode, message string, details interface{}) {
+	c.AbortWithStatusJSON(status, ErrorResponse{
+		Error: APIError{
+			Code:      code,
+			Message:   message,
+			Details:   details,
+			RequestID: GetRequestID(c),
+		},
+	})
+}
+
+// writeError maps a domain error to its HTTP status and code. Unclassified
+// errors are reported as internal errors without their message; the original
+// error is attached to the context and logged by RequestLogger.
+func writeError(c *gin.Context, err error) {
+	c.Error(err)
+
+	kind := apperror.KindOf(err)
+	for _, k := range errorKinds {
+		if kind != k.kind {
+			continue
+		}
+		var details interface{}
+		var verrs workflow.ValidationErrors
+		var stale *apperror.StaleError
+		if errors.As(err, &verrs) {
+			details = validationDetails(verrs)
+		} else if errors.As(err, &stale) {
+			details = gin.H{"current": stale.Current}
+		}
+		writeAPIError(c, k.status, k.code, err.Error(), details)
+		return
+	}
+
+	writeAPIError(c, http.StatusInternalServerError, ErrCodeInternal, internalErrorMessage, nil)
+}
+
+// writeInvalidRequest reports a malformed request body or parameter
+func writeInvalidRequest(c *gin.Context, message string, err error) {
+	var details interface{}
+	if err != nil {
+		c.Error(err)
+		details = gin.H{"reason": err.Error()}
+	}
+	writeAPIError(c, http.StatusBadRequest, ErrCodeInvalidRequest, message, details)
+}
+
+// writeBindError reports a request body that failed to bind
+func writeBindError(c *gin.Context, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		c.Error(err)
+		writeAPIError(c, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge, "request body too large",
+			gin.H{"limit_bytes": maxBytesErr.Limit})
+		return
+	}
+	writeInvalidRequest(c, "invalid request body", err)
+}
+
+// validationDetails converts workflow validation errors to per-field details
+func validationDetails(verrs workflow.ValidationErrors) []gin.H {
+	details := make([]gin.H, 0, len(verrs))
+	for _, verr := range verrs {
+		details = append(details, gin.H{
+			"field":   verr.Field,
+			"message": verr.Message,
+		})
+	}
+	return details
+}
@@ -2,32 +2,77 @@
 package api
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
 
 	"github.com/yourorg/control-plane/pkg/agent"
+	"github.com/yourorg/control-plane/pkg/analytics"
+	"github.com/yourorg/control-plane/pkg/anomaly"
 	"github.com/yourorg/control-plane/pkg/audit"
 	"github.com/yourorg/control-plane/pkg/auth"
+	"github.com/yourorg/control-plane/pkg/breakglass"
 	"github.com/yourorg/control-plane/pkg/campaign"
+	"github.com/yourorg/control-plane/pkg/catalog"
+	"github.com/yourorg/control-plane/pkg/configprofile"
+	"github.com/yourorg/control-plane/pkg/db"
 	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/diff"
+	"github.com/yourorg/control-plane/pkg/gitsync"
+	"github.com/yourorg/control-plane/pkg/inbound"
+	"github.com/yourorg/control-plane/pkg/overview"
+	"github.com/yourorg/control-plane/pkg/portability"
+	"github.com/yourorg/control-plane/pkg/remediation"
+	"github.com/yourorg/control-plane/pkg/schedule"
+	"github.com/yourorg/control-plane/pkg/search"
+	"github.com/yourorg/control-plane/pkg/shell"
 	"github.com/yourorg/control-plane/pkg/template"
 	"github.com/yourorg/control-plane/pkg/tenant"
+	"github.com/yourorg/control-plane/pkg/tracing"
+	"github.com/yourorg/control-plane/pkg/vulnerability"
 	"github.com/yourorg/control-plane/pkg/workflow"
 )
 
 // Handlers contains all API handlers
 type Handlers struct {
-	logger          *zap.Logger
-	tenantManager   *tenant.Manager
-	agentRegistry   *agent.Registry
-	agentRegistrar  *agent.Registrar
-	workflowManager *workflow.Manager
-	campaignManager *campaign.Manager
-	templateManager *template.Manager
-	auditLogger     *audit.Logger
+	logger             *zap.Logger
+	tenantManager      *tenant.Manager
+	agentRegistry      *agent.Registry
+	agentRegistrar     *agent.Registrar
+	keyManager         *agent.KeyManager
+	installScripts     *agent.InstallScriptGenerator
+	workflowManager    *workflow.Manager
+	workflowExecutor   *workflow.Executor
+	campaignManager    *campaign.Manager
+	templateManager    *template.Manager
+	portabilityManager *portability.Manager
+	auditLogger        *audit.Logger
+	outputIndexer      *search.Indexer
+	executionWatchdog  *workflow.Watchdog
+	analyzer           *analytics.Analyzer
+	gitRepositories    *gitsync.Manager
+	configProfiles     *configprofile.Manager
+	shellBroker        *shell.Broker
+	anomalyDetector    *anomaly.Detector
+	impersonator       *auth.Impersonator
+	tokenIssuer        *auth.TokenIssuer
+	remediation        *remediation.Manager
+	inboundTriggers    *inbound.Manager
+	tenantDatabases    *db.TenantRouter
+	auditFields        *audit.FieldRegistry
+	vulnerabilities    *vulnerability.Manager
+	catalog            *catalog.Manager
+	overview           *overview.Reporter
+	breakGlass         *breakglass.Manager
+	schedules          *schedule.Manager
 }
 
 // NewHandlers creates new API handlers
@@ -36,20 +81,64 @@ func NewHandlers(
 	tenantManager *tenant.Manager,
 	agentRegistry *agent.Registry,
 	agentRegistrar *agent.Registrar,
+	keyManager *agent.KeyManager,
+	installScripts *agent.InstallScriptGenerator,
 	workflowManager *workflow.Manager,
+	workflowExecutor *workflow.Executor,
 	campaignManager *campaign.Manager,
 	templateManager *template.Manager,
+	portabilityManager *portability.Manager,
 	auditLogger *audit.Logger,
+	outputIndexer *search.Indexer,
+	executionWatchdog *workflow.Watchdog,
+	analyzer *analytics.Analyzer,
+	gitRepositories *gitsync.Manager,
+	configProfiles *configprofile.Manager,
+	shellBroker *shell.Broker,
+	anomalyDetector *anomaly.Detector,
+	impersonator *auth.Impersonator,
+	tokenIssuer *auth.TokenIssuer,
+	remediation *remediation.Manager,
+	inboundTriggers *inbound.Manager,
+	tenantDatabases *db.TenantRouter,
+	auditFields *audit.FieldRegistry,
+	vulnerabilities *vulnerability.Manager,
+	catalog *catalog.Manager,
+	overview *overview.Reporter,
+	breakGlass *breakglass.Manager,
+	schedules *schedule.Manager,
 ) *Handlers {
 	return &Handlers{
-		logger:          logger,
-		tenantManager:   tenantManager,
-		agentRegistry:   agentRegistry,
-		agentRegistrar:  agentRegistrar,
-		workflowManager: workflowManager,
-		campaignManager: campaignManager,
-		templateManager: templateManager,
-		auditLogger:     auditLogger,
+		logger:             logger,
+		tenantManager:      tenantManager,
+		agentRegistry:      agentRegistry,
+		agentRegistrar:     agentRegistrar,
+		keyManager:         keyManager,
+		installScripts:     installScripts,
+		workflowManager:    workflowManager,
+		workflowExecutor:   workflowExecutor,
+		campaignManager:    campaignManager,
+		templateManager:    templateManager,
+		portabilityManager: portabilityManager,
+		auditLogger:        auditLogger,
+		outputIndexer:      outputIndexer,
+		executionWatchdog:  executionWatchdog,
+		analyzer:           analyzer,
+		gitRepositories:    gitRepositories,
+		configProfiles:     configProfiles,
+		shellBroker:        shellBroker,
+		anomalyDetector:    anomalyDetector,
+		impersonator:       impersonator,
+		tokenIssuer:        tokenIssuer,
+		remediation:        remediation,
+		inboundTriggers:    inboundTriggers,
+		tenantDatabases:    tenantDatabases,
+		auditFields:        auditFields,
+		vulnerabilities:    vulnerabilities,
+		catalog:            catalog,
+		overview:           overview,
+		breakGlass:         breakGlass,
+		schedules:          schedules,
 	}
 }
 
@@ -80,7 +169,7 @@ func (h *Handlers) ListTenants(c *gin.Context) {
 	tenants, total, err := h.tenantManager.List(ctx, limit, offset)
 	if err != nil {
 		h.logger.Error("failed to list tenants", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeError(c, err)
 		return
 	}
 
@@ -99,27 +188,39 @@ func (h *Handlers) GetTenant(c *gin.Context) {
 
 	t, err := h.tenantManager.Get(ctx, tenantID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		writeError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, t)
 }
 
+// GetTenantUsage returns a tenant's execution usage and budgets for a
+// month, ?month=YYYY-MM, by default the current one
+func (h *Handlers) GetTenantUsage(c *gin.Context) {
+	usage, err := h.tenantManager.GetUsage(c.Request.Context(), c.Param("tenant_id"), c.Query("month"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
 // CreateTenant creates a new tenant
 func (h *Handlers) CreateTenant(c *gin.Context) {
 	ctx := c.Request.Context()
 
 	var req tenant.CreateTenantRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeBindError(c, err)
 		return
 	}
 
 	t, err := h.tenantManager.Create(ctx, &req)
 	if err != nil {
 		h.logger.Error("failed to create tenant", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeError(c, err)
 		return
 	}
 
@@ -131,521 +232,3841 @@ func (h *Handlers) UpdateTenant(c *gin.Context) {
 	ctx := c.Request.Context()
 	tenantID := c.Param("tenant_id")
 
-	var updates map[string]interface{}
-	if err := c.ShouldBindJSON(&updates); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var req tenant.UpdateTenantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
 		return
 	}
 
-	if err := h.tenantManager.Update(ctx, tenantID, updates); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	t, err := h.tenantManager.Update(ctx, tenantID, &req)
+	if err != nil {
+		writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "tenant updated"})
+	c.JSON(http.StatusOK, t)
 }
 
-// Agent handlers
-
-// ListAgents lists agents for a tenant
-func (h *Handlers) ListAgents(c *gin.Context) {
+// DeleteTenant soft-deletes a tenant
+func (h *Handlers) DeleteTenant(c *gin.Context) {
 	ctx := c.Request.Context()
-	tenantID := getTenantID(c)
-	status := c.Query("status")
-	limit := getIntParam(c, "limit", 50)
-	offset := getIntParam(c, "offset", 0)
+	tenantID := c.Param("tenant_id")
 
-	agents, total, err := h.agentRegistry.List(ctx, &agent.ListRequest{
-		TenantID: tenantID,
-		Status:   status,
-		Limit:    limit,
-		Offset:   offset,
-	})
-	if err != nil {
-		h.logger.Error("failed to list agents", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.tenantManager.Delete(ctx, tenantID); err != nil {
+		writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"agents": agents,
-		"total":  total,
-		"limit":  limit,
-		"offset": offset,
-	})
+	c.JSON(http.StatusOK, gin.H{"message": "tenant deleted"})
 }
 
-// GetAgent gets an agent by ID
-func (h *Handlers) GetAgent(c *gin.Context) {
+// Installation key handlers
+
+// ListInstallationKeys lists a tenant's installation keys. Expired keys are
+// included with include_expired=true.
+func (h *Handlers) ListInstallationKeys(c *gin.Context) {
 	ctx := c.Request.Context()
-	tenantID := getTenantID(c)
-	agentID := c.Param("agent_id")
+	tenantID := c.Param("tenant_id")
 
-	ag, err := h.agentRegistry.Get(ctx, tenantID, agentID)
+	keys, err := h.keyManager.ListKeys(ctx, tenantID, c.Query("include_expired") == "true")
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		h.logger.Error("failed to list installation keys", zap.Error(err))
+		writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, ag)
+	c.JSON(http.StatusOK, gin.H{
+		"keys":  keys,
+		"total": len(keys),
+	})
 }
 
-// RegisterAgent registers a new agent
-func (h *Handlers) RegisterAgent(c *gin.Context) {
+// CreateInstallationKey creates an installation key for a tenant. The key
+// itself is only returned in this response.
+func (h *Handlers) CreateInstallationKey(c *gin.Context) {
 	ctx := c.Request.Context()
+	tenantID := c.Param("tenant_id")
 
-	var req agent.RegistrationRequest
+	if _, err := h.tenantManager.Get(ctx, tenantID); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	var req agent.CreateKeyRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeBindError(c, err)
+		return
+	}
+	req.TenantID = tenantID
+
+	if req.ExpiryHours < 0 || req.UsageLimit < 0 {
+		writeInvalidRequest(c, "expiry_hours and usage_limit must be non-negative", nil)
 		return
 	}
 
-	result, err := h.agentRegistrar.Register(ctx, &req)
+	key, err := h.keyManager.CreateKey(ctx, &req)
 	if err != nil {
-		h.logger.Error("failed to register agent", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		h.logger.Error("failed to create installation key", zap.Error(err))
+		writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, result)
+	c.JSON(http.StatusCreated, key)
 }
 
-// AgentHeartbeat handles agent heartbeat
-func (h *Handlers) AgentHeartbeat(c *gin.Context) {
+// GetInstallationKey gets an installation key without its secret
+func (h *Handlers) GetInstallationKey(c *gin.Context) {
 	ctx := c.Request.Context()
-	tenantID := getTenantID(c)
-	agentID := c.Param("agent_id")
 
-	if err := h.agentRegistry.UpdateHeartbeat(ctx, tenantID, agentID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	key, err := h.keyManager.GetKey(ctx, c.Param("tenant_id"), c.Param("key_id"))
+	if err != nil {
+		writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "heartbeat recorded"})
+	c.JSON(http.StatusOK, key)
 }
 
-// AgentHealthReport handles agent health reports
-func (h *Handlers) AgentHealthReport(c *gin.Context) {
+// DeleteInstallationKey deletes an installation key. Agents already
+// registered with the key are not affected.
+func (h *Handlers) DeleteInstallationKey(c *gin.Context) {
 	ctx := c.Request.Context()
-	tenantID := getTenantID(c)
-	agentID := c.Param("agent_id")
 
-	var req struct {
-		Status     models.AgentStatus     `json:"status"`
-		Components map[string]interface{} `json:"components"`
+	if err := h.keyManager.DeleteKey(ctx, c.Param("tenant_id"), c.Param("key_id")); err != nil {
+		writeError(c, err)
+		return
 	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "installation key deleted"})
+}
+
+// IssueTenantToken issues a token for a tenant limited to the requested
+// scopes, e.g. read for a dashboard or execute for a CI system. The token
+// itself is only returned in this response.
+func (h *Handlers) IssueTenantToken(c *gin.Context) {
+	var req auth.TokenRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeBindError(c, err)
 		return
 	}
+	req.TenantID = c.Param("tenant_id")
+	if claims := auth.GetClaimsFromGin(c); claims != nil {
+		req.IssuedBy = claims.UserID
+		if req.IssuedBy == "" {
+			req.IssuedBy = claims.Subject
+		}
+	}
 
-	if err := h.agentRegistry.RecordHealthReport(ctx, tenantID, agentID, req.Status, req.Components); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	token, err := h.tokenIssuer.Issue(c.Request.Context(), &req)
+	if err != nil {
+		h.logger.Error("failed to issue token", zap.Error(err))
+		writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "health report recorded"})
+	c.JSON(http.StatusCreated, token)
 }
 
-// Workflow handlers
-
-// ListWorkflows lists workflows for a tenant
-func (h *Handlers) ListWorkflows(c *gin.Context) {
+// GetInstallScript creates an installation key and returns a one-line
+// install command for the requested OS and architecture embedding it
+func (h *Handlers) GetInstallScript(c *gin.Context) {
 	ctx := c.Request.Context()
-	tenantID := getTenantID(c)
-	status := models.WorkflowStatus(c.Query("status"))
-	limit := getIntParam(c, "limit", 50)
-	offset := getIntParam(c, "offset", 0)
+	tenantID := c.Param("tenant_id")
 
-	workflows, total, err := h.workflowManager.List(ctx, tenantID, status, limit, offset)
-	if err != nil {
-		h.logger.Error("failed to list workflows", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if h.installScripts == nil {
+		writeAPIError(c, http.StatusServiceUnavailable, ErrCodeUnavailable, "install scripts are not configured", nil)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"workflows": workflows,
-		"total":     total,
-		"limit":     limit,
-		"offset":    offset,
-	})
-}
+	if _, err := h.tenantManager.Get(ctx, tenantID); err != nil {
+		writeError(c, err)
+		return
+	}
 
-// GetWorkflow gets a workflow by ID
-func (h *Handlers) GetWorkflow(c *gin.Context) {
-	ctx := c.Request.Context()
-	tenantID := getTenantID(c)
-	workflowID := c.Param("workflow_id")
+	expiryHours := getIntParam(c, "expiry_hours", 0)
+	usageLimit := getIntParam(c, "usage_limit", 0)
+	if expiryHours < 0 || usageLimit < 0 {
+		writeInvalidRequest(c, "expiry_hours and usage_limit must be non-negative", nil)
+		return
+	}
 
-	wf, err := h.workflowManager.Get(ctx, tenantID, workflowID)
+	script, err := h.installScripts.Generate(ctx, &agent.InstallScriptRequest{
+		TenantID:    tenantID,
+		OS:          c.DefaultQuery("os", "linux"),
+		Arch:        c.DefaultQuery("arch", "amd64"),
+		ExpiryHours: expiryHours,
+		UsageLimit:  usageLimit,
+	})
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		h.logger.Error("failed to generate install script", zap.Error(err))
+		writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, wf)
+	c.JSON(http.StatusOK, script)
 }
 
-// CreateWorkflow creates a new workflow
-func (h *Handlers) CreateWorkflow(c *gin.Context) {
+// maxImportBundleSize limits the size of an uploaded import bundle
+const maxImportBundleSize = 64 << 20
+
+// ExportTenant exports a tenant's workflows, templates and campaigns as a
+// JSON document (format=json, default) or gzipped tar archive (format=tar)
+func (h *Handlers) ExportTenant(c *gin.Context) {
 	ctx := c.Request.Context()
-	tenantID := getTenantID(c)
+	tenantID := c.Param("tenant_id")
+	format := c.DefaultQuery("format", "json")
 
-	var req workflow.CreateWorkflowRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if format != "json" && format != "tar" {
+		writeInvalidRequest(c, "invalid format: must be json or tar", nil)
 		return
 	}
-	req.TenantID = tenantID
 
-	// Get created by from auth context
-	if claims, ok := c.Get("claims"); ok {
-		if authClaims, ok := claims.(*auth.Claims); ok {
-			req.CreatedBy = authClaims.UserID
-		}
+	if _, err := h.tenantManager.Get(ctx, tenantID); err != nil {
+		writeError(c, err)
+		return
 	}
 
-	wf, err := h.workflowManager.Create(ctx, &req)
+	bundle, err := h.portabilityManager.Export(ctx, tenantID)
 	if err != nil {
-		h.logger.Error("failed to create workflow", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		h.logger.Error("failed to export tenant", zap.String("tenant_id", tenantID), zap.Error(err))
+		writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, wf)
+	filename := fmt.Sprintf("tenant-%s-%s", tenantID, bundle.ExportedAt.Format("20060102T150405Z"))
+	if format == "tar" {
+		c.Header("Content-Type", "application/gzip")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".tar.gz"))
+		c.Status(http.StatusOK)
+		if err := portability.WriteTar(c.Writer, bundle); err != nil {
+			h.logger.Error("failed to write export archive", zap.String("tenant_id", tenantID), zap.Error(err))
+		}
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".json"))
+	c.JSON(http.StatusOK, bundle)
 }
 
-// UpdateWorkflow updates a workflow
-func (h *Handlers) UpdateWorkflow(c *gin.Context) {
+// ImportTenant imports a JSON or tar export bundle into a tenant. The
+// on_conflict query parameter (rename, skip or overwrite) controls name collisions.
+func (h *Handlers) ImportTenant(c *gin.Context) {
 	ctx := c.Request.Context()
-	tenantID := getTenantID(c)
-	workflowID := c.Param("workflow_id")
+	tenantID := c.Param("tenant_id")
 
-	var req workflow.UpdateWorkflowRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	mode, err := portability.ParseCollisionMode(c.Query("on_conflict"))
+	if err != nil {
+		writeError(c, err)
 		return
 	}
-	req.TenantID = tenantID
-	req.WorkflowID = workflowID
 
-	if err := h.workflowManager.Update(ctx, &req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if _, err := h.tenantManager.Get(ctx, tenantID); err != nil {
+		writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "workflow updated"})
-}
-
-// DeleteWorkflow deletes a workflow
-func (h *Handlers) DeleteWorkflow(c *gin.Context) {
-	ctx := c.Request.Context()
-	tenantID := getTenantID(c)
-	workflowID := c.Param("workflow_id")
+	bundle, err := portability.ReadBundle(http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBundleSize))
+	if err != nil {
+		writeBindError(c, err)
+		return
+	}
 
-	if err := h.workflowManager.Delete(ctx, tenantID, workflowID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	report, err := h.portabilityManager.Import(ctx, tenantID, bundle, mode)
+	if err != nil {
+		h.logger.Error("failed to import tenant", zap.String("tenant_id", tenantID), zap.Error(err))
+		writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "workflow deleted"})
+	c.JSON(http.StatusOK, report)
 }
 
-// Campaign handlers
+// Agent handlers
 
-// ListCampaigns lists campaigns for a tenant
-func (h *Handlers) ListCampaigns(c *gin.Context) {
+// ListAgents lists agents for a tenant
+func (h *Handlers) ListAgents(c *gin.Context) {
 	ctx := c.Request.Context()
-	tenantID := getTenantID(c)
-	status := models.CampaignStatus(c.Query("status"))
 	limit := getIntParam(c, "limit", 50)
 	offset := getIntParam(c, "offset", 0)
 
-	campaigns, total, err := h.campaignManager.List(ctx, tenantID, status, limit, offset)
+	req := agentListRequest(c)
+	req.Limit = limit
+	req.Offset = offset
+
+	agents, total, err := h.agentRegistry.List(ctx, req)
 	if err != nil {
-		h.logger.Error("failed to list campaigns", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		h.logger.Error("failed to list agents", zap.Error(err))
+		writeError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"campaigns": campaigns,
-		"total":     total,
-		"limit":     limit,
-		"offset":    offset,
+		"agents": agents,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
 	})
 }
 
-// GetCampaign gets a campaign by ID
-func (h *Handlers) GetCampaign(c *gin.Context) {
+// QueryFleet returns the agents matching a fleet query expression over their
+// facts, tags and health, e.g. os == "linux" && facts.kernel < "5.4"
+func (h *Handlers) QueryFleet(c *gin.Context) {
 	ctx := c.Request.Context()
-	tenantID := getTenantID(c)
-	campaignID := c.Param("campaign_id")
 
-	camp, err := h.campaignManager.Get(ctx, tenantID, campaignID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-		return
+	var req struct {
+		Query  string `json:"query" binding:"required"`
+		Limit  int    `json:"limit"`
+		Offset int    `json:"offset"`
 	}
-
-	c.JSON(http.StatusOK, camp)
-}
-
-// CreateCampaign creates a new campaign
-func (h *Handlers) CreateCampaign(c *gin.Context) {
-	ctx := c.Request.Context()
-	tenantID := getTenantID(c)
-
-	var req campaign.CreateCampaignRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeBindError(c, err)
 		return
 	}
-	req.TenantID = tenantID
-
-	// Get created by from auth context
-	if claims, ok := c.Get("claims"); ok {
-		if authClaims, ok := claims.(*auth.Claims); ok {
-			req.CreatedBy = authClaims.UserID
-		}
+	if req.Limit <= 0 {
+		req.Limit = 50
+	}
+	if req.Offset < 0 {
+		req.Offset = 0
 	}
 
-	camp, err := h.campaignManager.Create(ctx, &req)
+	agents, total, err := h.agentRegistry.QueryFleet(ctx, &agent.FleetQueryRequest{
+		TenantID: getTenantID(c),
+		Query:    req.Query,
+		Limit:    req.Limit,
+		Offset:   req.Offset,
+	})
 	if err != nil {
-		h.logger.Error("failed to create campaign", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, camp)
+	c.JSON(http.StatusOK, gin.H{
+		"agents": agents,
+		"total":  total,
+		"limit":  req.Limit,
+		"offset": req.Offset,
+	})
 }
 
-// StartCampaign starts a campaign
-func (h *Handlers) StartCampaign(c *gin.Context) {
+// ExportAgents streams the agent inventory as CSV (format=csv, default) or
+// an Excel workbook (format=xlsx), filtered like ListAgents
+func (h *Handlers) ExportAgents(c *gin.Context) {
 	ctx := c.Request.Context()
 	tenantID := getTenantID(c)
-	campaignID := c.Param("campaign_id")
+	format := c.DefaultQuery("format", "csv")
 
-	if err := h.campaignManager.Start(ctx, tenantID, campaignID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	w, err := agent.NewInventoryWriter(c.Writer, format)
+	if err != nil {
+		writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "campaign started"})
+	filename := fmt.Sprintf("agents-%s-%s.%s", tenantID, time.Now().UTC().Format("20060102T150405Z"), format)
+	contentType := "text/csv; charset=utf-8"
+	if format == "xlsx" {
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	// The status is sent with the first rows, so later failures can only
+	// truncate the download
+	if err := h.agentRegistry.ExportInventory(ctx, agentListRequest(c), w); err != nil {
+		h.logger.Error("failed to export agent inventory", zap.String("tenant_id", tenantID), zap.Error(err))
+	}
 }
 
-// PauseCampaign pauses a campaign
-func (h *Handlers) PauseCampaign(c *gin.Context) {
+// agentListRequest returns the agent filters given in the query string
+func agentListRequest(c *gin.Context) *agent.ListRequest {
+	return &agent.ListRequest{
+		TenantID:       getTenantID(c),
+		Status:         c.Query("status"),
+		DrainState:     c.Query("drain_state"),
+		ApprovalStatus: c.Query("approval_status"),
+	}
+}
+
+// GetAgent gets an agent by ID
+func (h *Handlers) GetAgent(c *gin.Context) {
 	ctx := c.Request.Context()
 	tenantID := getTenantID(c)
-	campaignID := c.Param("campaign_id")
+	agentID := c.Param("agent_id")
 
-	if err := h.campaignManager.Pause(ctx, tenantID, campaignID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	ag, err := h.agentRegistry.Get(ctx, tenantID, agentID)
+	if err != nil {
+		writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "campaign paused"})
+	c.JSON(http.StatusOK, ag)
 }
 
-// CancelCampaign cancels a campaign
-func (h *Handlers) CancelCampaign(c *gin.Context) {
+// RegisterAgent registers a new agent
+func (h *Handlers) RegisterAgent(c *gin.Context) {
 	ctx := c.Request.Context()
-	tenantID := getTenantID(c)
-	campaignID := c.Param("campaign_id")
 
-	if err := h.campaignManager.Cancel(ctx, tenantID, campaignID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var req agent.RegistrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
 		return
 	}
+	req.SourceIP = c.ClientIP()
 
-	c.JSON(http.StatusOK, gin.H{"message": "campaign cancelled"})
+	result, err := h.agentRegistrar.Register(ctx, &req)
+	if err != nil {
+		h.logger.Error("failed to register agent", zap.Error(err))
+		writeError(c, err)
+		return
+	}
+
+	// Registrations are unauthenticated, so the API auditor skips them
+	if h.auditLogger != nil {
+		if err := h.auditLogger.LogAgentEvent(ctx, result.TenantID, result.AgentID, string(audit.ActionRegister), true, map[string]interface{}{
+			"ip_address": c.ClientIP(),
+		}); err != nil {
+			h.logger.Warn("failed to audit agent registration",
+				zap.String("agent_id", result.AgentID),
+				zap.Error(err))
+		}
+	}
+
+	c.JSON(http.StatusCreated, result)
 }
 
-// GetCampaignProgress gets campaign progress
-func (h *Handlers) GetCampaignProgress(c *gin.Context) {
+// RefreshAgentToken issues the calling agent a new token to replace the one
+// it authenticated with before that expires
+func (h *Handlers) RefreshAgentToken(c *gin.Context) {
 	ctx := c.Request.Context()
-	tenantID := getTenantID(c)
-	campaignID := c.Param("campaign_id")
+	current := strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
 
-	progress, err := h.campaignManager.GetProgress(ctx, tenantID, campaignID)
+	result, err := h.agentRegistrar.RefreshToken(ctx, getTenantID(c), requestAgentID(c), current)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		h.logger.Error("failed to refresh agent token", zap.Error(err))
+		writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, progress)
+	c.JSON(http.StatusOK, result)
 }
 
-// Template handlers
-
-// ListTemplates lists templates for a tenant
-func (h *Handlers) ListTemplates(c *gin.Context) {
+// AgentHeartbeat handles agent heartbeat
+func (h *Handlers) AgentHeartbeat(c *gin.Context) {
+	receivedAt := time.Now()
 	ctx := c.Request.Context()
 	tenantID := getTenantID(c)
-	status := models.TemplateStatus(c.Query("status"))
-	limit := getIntParam(c, "limit", 50)
+	agentID := requestAgentID(c)
+
+	// The body, timing and configuration state, is optional
+	var req struct {
+		agent.HeartbeatTiming
+		Config *configprofile.AgentReport `json:"config"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			writeBindError(c, err)
+			return
+		}
+	}
+
+	if err := h.agentRegistry.UpdateHeartbeat(ctx, tenantID, agentID); err != nil {
+		writeError(c, err)
+		return
+	}
+	if err := h.agentRegistry.RecordTiming(ctx, tenantID, agentID, &req.HeartbeatTiming, receivedAt); err != nil {
+		writeError(c, err)
+		return
+	}
+	profile, err := h.configProfiles.SyncAgent(ctx, tenantID, agentID, req.Config)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "heartbeat recorded",
+		"server_time":    receivedAt.UTC(),
+		"config_profile": profile,
+	})
+}
+
+// AgentHealthReport handles agent health reports
+func (h *Handlers) AgentHealthReport(c *gin.Context) {
+	receivedAt := time.Now()
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	agentID := requestAgentID(c)
+
+	var req struct {
+		Status     models.AgentStatus     `json:"status"`
+		Overall    models.AgentStatus     `json:"overall"`
+		Components map[string]interface{} `json:"components"`
+		DrainState string                 `json:"drain_state"`
+		Grains     map[string]interface{} `json:"grains"`
+		agent.HeartbeatTiming
+		Config *configprofile.AgentReport `json:"config"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	// Agents report their overall health as "overall"
+	if req.Status == "" {
+		req.Status = req.Overall
+	}
+
+	if err := h.agentRegistry.RecordHealthReport(ctx, tenantID, agentID, req.Status, req.Components); err != nil {
+		writeError(c, err)
+		return
+	}
+	// Run the workflows of remediation rules the reported health matches
+	h.remediation.Evaluate(ctx, tenantID, agentID, req.Status, req.Components)
+
+	if req.DrainState == string(models.AgentDrainDrained) {
+		if err := h.agentRegistry.MarkDrained(ctx, tenantID, agentID); err != nil {
+			writeError(c, err)
+			return
+		}
+	}
+	if err := h.agentRegistry.RecordTiming(ctx, tenantID, agentID, &req.HeartbeatTiming, receivedAt); err != nil {
+		writeError(c, err)
+		return
+	}
+	if req.Grains != nil {
+		if err := h.agentRegistry.RecordGrains(ctx, tenantID, agentID, req.Grains); err != nil {
+			writeError(c, err)
+			return
+		}
+	}
+	// Agents pull their configuration profile with each report
+	profile, err := h.configProfiles.SyncAgent(ctx, tenantID, agentID, req.Config)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "health report recorded",
+		"server_time":    receivedAt.UTC(),
+		"config_profile": profile,
+	})
+}
+
+// GetFleetHealth summarizes the health of the tenant's agents, including
+// their heartbeat latency and the agents with clock skew
+func (h *Handlers) GetFleetHealth(c *gin.Context) {
+	summary, err := h.agentRegistry.GetFleetHealth(c.Request.Context(), getTenantID(c))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+// requestAgentID returns the agent a request is about: the agent_id path
+// parameter, or the calling agent on agent-authenticated routes
+func requestAgentID(c *gin.Context) string {
+	if agentID := c.Param("agent_id"); agentID != "" {
+		return agentID
+	}
+	if claims := auth.GetClaimsFromGin(c); claims != nil {
+		return claims.AgentID
+	}
+	return ""
+}
+
+// maxHealthHistoryWindow bounds the window of a health history request
+const maxHealthHistoryWindow = 30 * 24 * time.Hour
+
+// GetAgentHealthHistory returns an agent's overall and component health
+// transitions over a window (default 24h), optionally for one component
+func (h *Handlers) GetAgentHealthHistory(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	agentID := c.Param("agent_id")
+
+	window, err := time.ParseDuration(c.DefaultQuery("window", "24h"))
+	if err != nil || window <= 0 {
+		writeInvalidRequest(c, "invalid window: must be a positive duration such as 24h", nil)
+		return
+	}
+	if window > maxHealthHistoryWindow {
+		window = maxHealthHistoryWindow
+	}
+
+	if _, err := h.agentRegistry.Get(ctx, tenantID, agentID); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	history, err := h.agentRegistry.GetHealthHistory(ctx, tenantID, agentID, time.Now().Add(-window), c.Query("component"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+// AgentSoftwareReport handles agent software inventory uploads. A delta
+// against an inventory the control plane no longer holds is answered with
+// 409, and the agent resends its full inventory.
+func (h *Handlers) AgentSoftwareReport(c *gin.Context) {
+	var report agent.SoftwareReport
+	if err := c.ShouldBindJSON(&report); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	result, err := h.agentRegistry.RecordSoftware(c.Request.Context(), getTenantID(c), requestAgentID(c), &report)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ListAgentSoftware lists the packages installed on an agent, filtered by
+// name substring and package source
+func (h *Handlers) ListAgentSoftware(c *gin.Context) {
+	limit := getIntParam(c, "limit", 100)
 	offset := getIntParam(c, "offset", 0)
 
-	templates, total, err := h.templateManager.List(ctx, &template.ListTemplatesRequest{
-		TenantID: tenantID,
-		Status:   status,
+	packages, total, err := h.agentRegistry.ListSoftware(c.Request.Context(), &agent.ListSoftwareRequest{
+		TenantID: getTenantID(c),
+		AgentID:  c.Param("agent_id"),
+		Name:     c.Query("name"),
+		Source:   c.Query("source"),
 		Limit:    limit,
 		Offset:   offset,
 	})
 	if err != nil {
-		h.logger.Error("failed to list templates", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"templates": templates,
-		"total":     total,
-		"limit":     limit,
-		"offset":    offset,
+		"packages": packages,
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
 	})
 }
 
-// GetTemplate gets a template by ID
-func (h *Handlers) GetTemplate(c *gin.Context) {
+// maxSoftwareHistoryWindow bounds the window of a software history request
+const maxSoftwareHistoryWindow = 90 * 24 * time.Hour
+
+// GetAgentSoftwareHistory returns the packages installed, updated and
+// removed on an agent over a window (default 30 days), optionally for one
+// package
+func (h *Handlers) GetAgentSoftwareHistory(c *gin.Context) {
 	ctx := c.Request.Context()
 	tenantID := getTenantID(c)
-	templateID := c.Param("template_id")
+	agentID := c.Param("agent_id")
 
-	tpl, err := h.templateManager.Get(ctx, tenantID, templateID)
+	window, err := time.ParseDuration(c.DefaultQuery("window", "720h"))
+	if err != nil || window <= 0 {
+		writeInvalidRequest(c, "invalid window: must be a positive duration such as 720h", nil)
+		return
+	}
+	if window > maxSoftwareHistoryWindow {
+		window = maxSoftwareHistoryWindow
+	}
+
+	if _, err := h.agentRegistry.Get(ctx, tenantID, agentID); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	changes, err := h.agentRegistry.GetSoftwareHistory(ctx, tenantID, agentID,
+		time.Now().Add(-window), c.Query("name"), getIntParam(c, "limit", 500))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, tpl)
+	c.JSON(http.StatusOK, changes)
 }
 
-// GetTemplateContent gets raw template content (for agents to fetch)
-func (h *Handlers) GetTemplateContent(c *gin.Context) {
+// DrainAgent stops new executions being dispatched to an agent. The agent
+// finishes its in-flight executions and then reports drained.
+func (h *Handlers) DrainAgent(c *gin.Context) {
+	h.setAgentDrain(c, true)
+}
+
+// UndrainAgent returns a draining or drained agent to service
+func (h *Handlers) UndrainAgent(c *gin.Context) {
+	h.setAgentDrain(c, false)
+}
+
+// setAgentDrain records the drain state and forwards it to the agent. The
+// recorded state already stops dispatch if the agent cannot be reached.
+func (h *Handlers) setAgentDrain(c *gin.Context, drain bool) {
 	ctx := c.Request.Context()
 	tenantID := getTenantID(c)
-	templateID := c.Param("template_id")
+	agentID := c.Param("agent_id")
 
-	content, err := h.templateManager.GetContent(ctx, tenantID, templateID)
+	var ag *models.Agent
+	var err error
+	if drain {
+		ag, err = h.agentRegistry.Drain(ctx, tenantID, agentID)
+	} else {
+		ag, err = h.agentRegistry.Undrain(ctx, tenantID, agentID)
+	}
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		writeError(c, err)
 		return
 	}
 
-	// Return raw content with appropriate content type
-	tpl, _ := h.templateManager.Get(ctx, tenantID, templateID)
-	contentType := "text/plain"
-	if tpl != nil && tpl.ContentType != "" {
-		contentType = tpl.ContentType
+	notified := true
+	if err := h.workflowExecutor.SetAgentDrain(ctx, ag, drain); err != nil {
+		notified = false
+		h.logger.Warn("failed to notify agent of drain state",
+			zap.String("agent_id", agentID),
+			zap.Bool("drain", drain),
+			zap.Error(err))
+	} else if ag, err = h.agentRegistry.Get(ctx, tenantID, agentID); err != nil {
+		writeError(c, err)
+		return
 	}
 
-	c.Data(http.StatusOK, contentType, []byte(content))
+	c.JSON(http.StatusOK, gin.H{
+		"agent":          ag,
+		"agent_notified": notified,
+	})
 }
 
-// CreateTemplate creates a new template
-func (h *Handlers) CreateTemplate(c *gin.Context) {
+// ResetAgentIdentity removes an agent's enrolled key and revokes its tokens
+// so the agent ID can register again with a new key
+func (h *Handlers) ResetAgentIdentity(c *gin.Context) {
 	ctx := c.Request.Context()
 	tenantID := getTenantID(c)
+	agentID := c.Param("agent_id")
 
-	var req template.CreateTemplateRequest
+	ag, err := h.agentRegistry.ResetIdentity(ctx, tenantID, agentID)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ag)
+}
+
+// ApproveAgent admits a pending or rejected agent so it receives executions
+func (h *Handlers) ApproveAgent(c *gin.Context) {
+	h.setAgentApproval(c, &agent.ApprovalRequest{AgentIDs: []string{c.Param("agent_id")}}, true)
+}
+
+// RejectAgent rejects a pending agent
+func (h *Handlers) RejectAgent(c *gin.Context) {
+	h.setAgentApproval(c, &agent.ApprovalRequest{AgentIDs: []string{c.Param("agent_id")}}, false)
+}
+
+// ApproveAgents approves agents in bulk, by ID or every pending agent with
+// the given tags
+func (h *Handlers) ApproveAgents(c *gin.Context) {
+	var req agent.ApprovalRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeBindError(c, err)
 		return
 	}
-	req.TenantID = tenantID
+	h.setAgentApproval(c, &req, true)
+}
 
-	// Get created by from auth context
-	if claims, ok := c.Get("claims"); ok {
-		if authClaims, ok := claims.(*auth.Claims); ok {
-			req.CreatedBy = authClaims.UserID
-		}
+// RejectAgents rejects pending agents in bulk
+func (h *Handlers) RejectAgents(c *gin.Context) {
+	var req agent.ApprovalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
 	}
+	h.setAgentApproval(c, &req, false)
+}
 
-	tpl, err := h.templateManager.Create(ctx, &req)
+// setAgentApproval approves or rejects the requested agents
+func (h *Handlers) setAgentApproval(c *gin.Context, req *agent.ApprovalRequest, approve bool) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+
+	var by string
+	if claims := auth.GetClaimsFromGin(c); claims != nil {
+		by = claims.UserID
+	}
+
+	var result *agent.ApprovalResult
+	var err error
+	if approve {
+		result, err = h.agentRegistry.Approve(ctx, tenantID, req, by)
+	} else {
+		result, err = h.agentRegistry.Reject(ctx, tenantID, req, by)
+	}
 	if err != nil {
-		h.logger.Error("failed to create template", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, tpl)
+	c.JSON(http.StatusOK, result)
 }
 
-// UpdateTemplate updates a template
-func (h *Handlers) UpdateTemplate(c *gin.Context) {
+// GetRegistrationPolicy returns whether the tenant's new agents need
+// approval and the rules approving them automatically
+func (h *Handlers) GetRegistrationPolicy(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	policy, err := h.agentRegistry.GetRegistrationPolicy(ctx, getTenantID(c))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// SetRegistrationPolicy replaces the tenant's registration policy
+func (h *Handlers) SetRegistrationPolicy(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var policy models.RegistrationPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	updated, err := h.agentRegistry.SetRegistrationPolicy(ctx, getTenantID(c), &policy)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// AgentExecutionResult handles workflow results reported by agents
+func (h *Handlers) AgentExecutionResult(c *gin.Context) {
 	ctx := c.Request.Context()
 	tenantID := getTenantID(c)
-	templateID := c.Param("template_id")
 
-	var req template.UpdateTemplateRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var agentID string
+	if claims := auth.GetClaimsFromGin(c); claims != nil {
+		agentID = claims.AgentID
+	}
+
+	var result map[string]interface{}
+	if err := c.ShouldBindJSON(&result); err != nil {
+		writeBindError(c, err)
 		return
 	}
 
-	// Get changed by from auth context
-	if claims, ok := c.Get("claims"); ok {
-		if authClaims, ok := claims.(*auth.Claims); ok {
-			req.ChangedBy = authClaims.UserID
+	executionID, _ := result["workflow_id"].(string)
+	if executionID == "" {
+		writeInvalidRequest(c, "workflow_id is required", nil)
+		return
+	}
+
+	if err := h.workflowExecutor.RecordAgentResult(ctx, tenantID, agentID, executionID, result); err != nil {
+		h.logger.Warn("failed to record execution result",
+			zap.String("execution_id", executionID),
+			zap.String("agent_id", agentID),
+			zap.Error(err))
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "execution result recorded"})
+}
+
+// Workflow handlers
+
+// ListWorkflows lists workflows for a tenant
+func (h *Handlers) ListWorkflows(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	limit := getIntParam(c, "limit", 50)
+	offset := getIntParam(c, "offset", 0)
+
+	req := &workflow.ListWorkflowsRequest{
+		TenantID:  tenantID,
+		Status:    models.WorkflowStatus(c.Query("status")),
+		Name:      c.Query("name"),
+		CreatedBy: c.Query("created_by"),
+		Query:     c.Query("q"),
+		Limit:     limit,
+		Offset:    offset,
+	}
+
+	// Tags are given as repeated tag=key:value parameters
+	for _, tag := range c.QueryArray("tag") {
+		key, value, ok := strings.Cut(tag, ":")
+		if !ok || key == "" {
+			writeInvalidRequest(c, "invalid tag filter "+tag+": must be key:value", nil)
+			return
 		}
+		if req.Tags == nil {
+			req.Tags = make(map[string]string)
+		}
+		req.Tags[key] = value
 	}
 
-	tpl, err := h.templateManager.Update(ctx, tenantID, templateID, &req)
+	for key, dst := range map[string]**time.Time{"executed_after": &req.ExecutedAfter, "executed_before": &req.ExecutedBefore} {
+		if val := c.Query(key); val != "" {
+			t, err := time.Parse(time.RFC3339, val)
+			if err != nil {
+				writeInvalidRequest(c, "invalid "+key+": must be RFC 3339", nil)
+				return
+			}
+			*dst = &t
+		}
+	}
+
+	workflows, total, err := h.workflowManager.List(ctx, req)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		h.logger.Error("failed to list workflows", zap.Error(err))
+		writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, tpl)
+	c.JSON(http.StatusOK, gin.H{
+		"workflows": workflows,
+		"total":     total,
+		"limit":     limit,
+		"offset":    offset,
+	})
 }
 
-// DeleteTemplate deletes a template
-func (h *Handlers) DeleteTemplate(c *gin.Context) {
+// GetWorkflow gets a workflow by ID
+func (h *Handlers) GetWorkflow(c *gin.Context) {
 	ctx := c.Request.Context()
 	tenantID := getTenantID(c)
-	templateID := c.Param("template_id")
+	workflowID := c.Param("workflow_id")
 
-	if err := h.templateManager.Delete(ctx, tenantID, templateID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	wf, err := h.workflowManager.Get(ctx, tenantID, workflowID)
+	if err != nil {
+		writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "template deleted"})
+	c.JSON(http.StatusOK, wf)
 }
 
-// GetTemplateVersions gets all versions of a template
-func (h *Handlers) GetTemplateVersions(c *gin.Context) {
+// GetWorkflowDefinition returns the definition a workflow had when it
+// hashed to :hash, as recorded in an execution's definition_hash
+func (h *Handlers) GetWorkflowDefinition(c *gin.Context) {
 	ctx := c.Request.Context()
 	tenantID := getTenantID(c)
-	templateID := c.Param("template_id")
 
-	versions, err := h.templateManager.GetVersions(ctx, tenantID, templateID)
+	snapshot, err := h.workflowManager.GetSnapshot(ctx, tenantID, c.Param("workflow_id"), c.Param("hash"))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"versions": versions})
+	c.JSON(http.StatusOK, snapshot)
 }
 
-// ActivateTemplate activates a template
-func (h *Handlers) ActivateTemplate(c *gin.Context) {
+// maxDefinitionRequestSize bounds request bodies carrying a workflow
+// definition, leaving room for JSON escaping; the definition itself is
+// checked against workflow.MaxDefinitionSize when validated
+const maxDefinitionRequestSize = 2 * workflow.MaxDefinitionSize
+
+// CreateWorkflow creates a new workflow
+func (h *Handlers) CreateWorkflow(c *gin.Context) {
 	ctx := c.Request.Context()
 	tenantID := getTenantID(c)
-	templateID := c.Param("template_id")
 
-	if err := h.templateManager.Activate(ctx, tenantID, templateID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxDefinitionRequestSize)
+	var req workflow.CreateWorkflowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
 		return
 	}
+	req.TenantID = tenantID
 
-	c.JSON(http.StatusOK, gin.H{"message": "template activated"})
-}
+	// Get created by from auth context
+	if claims, ok := c.Get("claims"); ok {
+		if authClaims, ok := claims.(*auth.Claims); ok {
+			req.CreatedBy = authClaims.UserID
+		}
+	}
 
-// Helper functions
+	if req.PolicyOverride && !isAdmin(c) {
+		writeAPIError(c, http.StatusForbidden, ErrCodeForbidden, "only tenant admins may override the workflow policy", nil)
+		return
+	}
+
+	wf, err := h.workflowManager.Create(ctx, &req)
+	if err != nil {
+		h.logger.Error("failed to create workflow", zap.Error(err))
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, wf)
+}
+
+// UpdateWorkflow updates a workflow, or with ?preview=true reports the
+// definition diff, validation results and affected campaigns and triggers
+// of the update without applying it
+func (h *Handlers) UpdateWorkflow(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	workflowID := c.Param("workflow_id")
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxDefinitionRequestSize)
+	var req workflow.UpdateWorkflowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	if req.PolicyOverride && !isAdmin(c) {
+		writeAPIError(c, http.StatusForbidden, ErrCodeForbidden, "only tenant admins may override the workflow policy", nil)
+		return
+	}
+
+	if c.Query("preview") == "true" {
+		preview, err := h.workflowManager.PreviewUpdate(ctx, tenantID, workflowID, &req)
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, preview)
+		return
+	}
+
+	wf, err := h.workflowManager.Update(ctx, tenantID, workflowID, &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, wf)
+}
+
+// DeleteWorkflow deletes a workflow
+func (h *Handlers) DeleteWorkflow(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	workflowID := c.Param("workflow_id")
+
+	if err := h.workflowManager.Delete(ctx, tenantID, workflowID); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "workflow deleted"})
+}
+
+// lintWorkflowRequest is a definition to lint, given as a parsed
+// definition or as YAML or JSON source, whose lint:ignore comments apply
+type lintWorkflowRequest struct {
+	Definition map[string]interface{} `json:"definition"`
+	Source     string                 `json:"source"`
+}
+
+// LintWorkflowDefinition checks a workflow definition against best
+// practices before it is saved
+func (h *Handlers) LintWorkflowDefinition(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxDefinitionRequestSize)
+	var req lintWorkflowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	if (req.Definition == nil) == (req.Source == "") {
+		writeInvalidRequest(c, "exactly one of definition or source is required", nil)
+		return
+	}
+
+	var report *workflow.LintReport
+	var err error
+	if req.Source != "" {
+		report, err = h.workflowManager.LintSource(ctx, tenantID, []byte(req.Source))
+	} else {
+		report, err = h.workflowManager.LintDefinition(ctx, tenantID, req.Definition)
+	}
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// LintWorkflow checks a stored workflow against best practices
+func (h *Handlers) LintWorkflow(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	report, err := h.workflowManager.LintWorkflow(ctx, getTenantID(c), c.Param("workflow_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// GetWorkflowPolicy returns the tenant's workflow defaults and ceilings
+func (h *Handlers) GetWorkflowPolicy(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	policy, err := h.workflowManager.GetPolicy(ctx, getTenantID(c))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// SetWorkflowPolicy replaces the tenant's workflow defaults and ceilings
+func (h *Handlers) SetWorkflowPolicy(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var policy models.WorkflowPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	updated, err := h.workflowManager.SetPolicy(ctx, getTenantID(c), &policy)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// Workflow trigger handlers
+
+// ListWorkflowTriggers lists workflow triggers, optionally only those of one
+// workflow
+func (h *Handlers) ListWorkflowTriggers(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	triggers, err := h.workflowManager.ListTriggers(ctx, getTenantID(c), c.Query("workflow_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"triggers": triggers})
+}
+
+// CreateWorkflowTrigger creates a trigger that runs a workflow on the same
+// agent when another workflow's execution finishes
+func (h *Handlers) CreateWorkflowTrigger(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req workflow.CreateTriggerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	req.TenantID = getTenantID(c)
+	if claims, ok := c.Get("claims"); ok {
+		if authClaims, ok := claims.(*auth.Claims); ok {
+			req.CreatedBy = authClaims.UserID
+		}
+	}
+
+	trigger, err := h.workflowManager.CreateTrigger(ctx, &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, trigger)
+}
+
+// UpdateWorkflowTrigger enables or disables a trigger
+func (h *Handlers) UpdateWorkflowTrigger(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req workflow.UpdateTriggerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	trigger, err := h.workflowManager.UpdateTrigger(ctx, getTenantID(c), c.Param("trigger_id"), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, trigger)
+}
+
+// DeleteWorkflowTrigger deletes a trigger
+func (h *Handlers) DeleteWorkflowTrigger(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	if err := h.workflowManager.DeleteTrigger(ctx, getTenantID(c), c.Param("trigger_id")); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "trigger deleted"})
+}
+
+// GetWorkflowTriggerGraph returns the tenant's trigger graph as JSON, or as
+// Graphviz DOT with ?format=dot
+func (h *Handlers) GetWorkflowTriggerGraph(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "dot" {
+		writeAPIError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "format must be json or dot", nil)
+		return
+	}
+
+	graph, err := h.workflowManager.GetTriggerGraph(ctx, getTenantID(c))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	if format == "dot" {
+		c.Data(http.StatusOK, "text/vnd.graphviz; charset=utf-8", []byte(graph.DOT()))
+		return
+	}
+	c.JSON(http.StatusOK, graph)
+}
+
+// Workflow hook handlers
+
+// ListWorkflowHooks lists a workflow's control plane hooks
+func (h *Handlers) ListWorkflowHooks(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	hooks, err := h.workflowManager.ListHooks(ctx, getTenantID(c), c.Param("workflow_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"hooks": hooks})
+}
+
+// CreateWorkflowHook creates a hook the control plane runs when an
+// execution of the workflow finishes
+func (h *Handlers) CreateWorkflowHook(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req workflow.CreateHookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	req.TenantID = getTenantID(c)
+	req.WorkflowID = c.Param("workflow_id")
+	if claims, ok := c.Get("claims"); ok {
+		if authClaims, ok := claims.(*auth.Claims); ok {
+			req.CreatedBy = authClaims.UserID
+		}
+	}
+
+	hook, err := h.workflowManager.CreateHook(ctx, &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, hook)
+}
+
+// GetWorkflowHook retrieves a workflow hook
+func (h *Handlers) GetWorkflowHook(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	hook, err := h.workflowManager.GetHook(ctx, getTenantID(c), c.Param("workflow_id"), c.Param("hook_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, hook)
+}
+
+// UpdateWorkflowHook changes a workflow hook
+func (h *Handlers) UpdateWorkflowHook(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req workflow.UpdateHookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	hook, err := h.workflowManager.UpdateHook(ctx, getTenantID(c), c.Param("workflow_id"), c.Param("hook_id"), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, hook)
+}
+
+// DeleteWorkflowHook deletes a workflow hook
+func (h *Handlers) DeleteWorkflowHook(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	if err := h.workflowManager.DeleteHook(ctx, getTenantID(c), c.Param("workflow_id"), c.Param("hook_id")); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "hook deleted"})
+}
+
+// Execution handlers
+
+// ListExecutions lists workflow executions for a tenant
+func (h *Handlers) ListExecutions(c *gin.Context) {
+	ctx := c.Request.Context()
+	limit := getIntParam(c, "limit", 50)
+	offset := getIntParam(c, "offset", 0)
+
+	var adhoc *bool
+	if value := c.Query("adhoc"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			writeInvalidRequest(c, "adhoc must be true or false", nil)
+			return
+		}
+		adhoc = &parsed
+	}
+
+	executions, total, err := h.workflowExecutor.ListExecutions(ctx, &workflow.ListExecutionsRequest{
+		TenantID:   getTenantID(c),
+		WorkflowID: c.Query("workflow_id"),
+		AgentID:    c.Query("agent_id"),
+		CampaignID: c.Query("campaign_id"),
+		RequestID:  c.Query("request_id"),
+		Status:     models.ExecutionStatus(c.Query("status")),
+		Adhoc:      adhoc,
+		Limit:      limit,
+		Offset:     offset,
+	})
+	if err != nil {
+		h.logger.Error("failed to list executions", zap.Error(err))
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"executions": executions,
+		"total":      total,
+		"limit":      limit,
+		"offset":     offset,
+	})
+}
+
+// ExecuteAdhoc runs an inline workflow definition once on one agent. The
+// definition is validated and checked against the tenant's workflow policy
+// like one being saved, and recorded with the execution instead of as a
+// workflow.
+func (h *Handlers) ExecuteAdhoc(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	// The definition limit applies to its JSON encoding; allow the rest of
+	// the request around it
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 2*workflow.MaxAdhocDefinitionSize)
+
+	var req workflow.AdhocExecuteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	req.TenantID = getTenantID(c)
+
+	if req.PolicyOverride && !isAdmin(c) {
+		writeAPIError(c, http.StatusForbidden, ErrCodeForbidden, "only tenant admins may override the workflow policy", nil)
+		return
+	}
+
+	auditAs(c, audit.ActionExecute)
+	execution, err := h.workflowExecutor.ExecuteAdhoc(ctx, &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, execution)
+}
+
+// SearchExecutionOutputs searches step output across the tenant's executions.
+// The q parameter uses Quickwit query syntax; since/until are RFC 3339 timestamps.
+func (h *Handlers) SearchExecutionOutputs(c *gin.Context) {
+	if h.outputIndexer == nil {
+		writeAPIError(c, http.StatusServiceUnavailable, ErrCodeUnavailable, "execution output search is not enabled", nil)
+		return
+	}
+
+	ctx := c.Request.Context()
+	query := &search.Query{
+		TenantID:    getTenantID(c),
+		Text:        c.Query("q"),
+		AgentID:     c.Query("agent_id"),
+		WorkflowID:  c.Query("workflow_id"),
+		ExecutionID: c.Query("execution_id"),
+		CampaignID:  c.Query("campaign_id"),
+		Status:      c.Query("status"),
+		MaxHits:     getIntParam(c, "limit", 50),
+		StartOffset: getIntParam(c, "offset", 0),
+	}
+
+	for key, dst := range map[string]**time.Time{"since": &query.StartTime, "until": &query.EndTime} {
+		if val := c.Query(key); val != "" {
+			t, err := time.Parse(time.RFC3339, val)
+			if err != nil {
+				writeInvalidRequest(c, "invalid "+key+": must be RFC 3339", nil)
+				return
+			}
+			*dst = &t
+		}
+	}
+
+	result, err := h.outputIndexer.Search(ctx, query)
+	if err != nil {
+		h.logger.Error("failed to search execution output", zap.Error(err))
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"hits":         result.Hits,
+		"total":        result.NumHits,
+		"elapsed_secs": result.ElapsedSecs,
+		"limit":        query.MaxHits,
+		"offset":       query.StartOffset,
+	})
+}
+
+// GetExecution gets an execution with its result and environment snapshot
+func (h *Handlers) GetExecution(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	executionID := c.Param("execution_id")
+
+	execution, err := h.workflowExecutor.GetExecution(ctx, tenantID, executionID)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, execution)
+}
+
+// ListExecutionHookRuns lists the workflow hooks an execution fired and
+// their outcomes
+func (h *Handlers) ListExecutionHookRuns(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	runs, err := h.workflowManager.ListHookRuns(ctx, getTenantID(c), c.Param("execution_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"hook_runs": runs})
+}
+
+// PauseExecution asks the agent running an execution to pause it between
+// steps. The execution is paused once the agent reaches a pause point.
+func (h *Handlers) PauseExecution(c *gin.Context) {
+	ctx := c.Request.Context()
+	executionID := c.Param("execution_id")
+
+	auditAs(c, audit.ActionPause)
+	if err := h.workflowExecutor.PauseExecution(ctx, getTenantID(c), executionID); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"execution_id": executionID,
+		"status":       "pause_requested",
+	})
+}
+
+// ResumeExecution resumes a paused execution
+func (h *Handlers) ResumeExecution(c *gin.Context) {
+	ctx := c.Request.Context()
+	executionID := c.Param("execution_id")
+
+	auditAs(c, audit.ActionResume)
+	if err := h.workflowExecutor.ResumeExecution(ctx, getTenantID(c), executionID); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"execution_id": executionID,
+		"status":       "resumed",
+	})
+}
+
+// maxCallbackConfirmationSize limits the body of a callback confirmation
+const maxCallbackConfirmationSize = 1 << 20
+
+// ConfirmCallback relays an external system's confirmation of a callback
+// step to the agent running it. It needs no API credentials: the body must
+// be signed with the step's secret, which the agent verifies.
+func (h *Handlers) ConfirmCallback(c *gin.Context) {
+	signature := c.GetHeader(workflow.HeaderCallbackSignature)
+	if signature == "" {
+		writeAPIError(c, http.StatusUnauthorized, ErrCodeUnauthorized, "missing "+workflow.HeaderCallbackSignature+" header", nil)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxCallbackConfirmationSize))
+	if err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	if err := h.workflowExecutor.RelayCallbackConfirmation(c.Request.Context(), c.Param("execution_id"), c.Param("callback_id"), body, signature); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"execution_id": c.Param("execution_id"),
+		"callback_id":  c.Param("callback_id"),
+		"status":       "confirmed",
+	})
+}
+
+// ListStuckExecutions lists pending or running executions past their
+// workflow timeout plus the watchdog grace period
+func (h *Handlers) ListStuckExecutions(c *gin.Context) {
+	if h.executionWatchdog == nil {
+		writeAPIError(c, http.StatusServiceUnavailable, ErrCodeUnavailable, "execution watchdog is not enabled", nil)
+		return
+	}
+
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+
+	stuck, err := h.executionWatchdog.ListStuck(ctx, tenantID, getIntParam(c, "limit", 100))
+	if err != nil {
+		h.logger.Error("failed to list stuck executions", zap.Error(err))
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"executions": stuck,
+		"total":      len(stuck),
+	})
+}
+
+// ResolveStuckExecutions closes or requeues stuck executions in bulk
+func (h *Handlers) ResolveStuckExecutions(c *gin.Context) {
+	if h.executionWatchdog == nil {
+		writeAPIError(c, http.StatusServiceUnavailable, ErrCodeUnavailable, "execution watchdog is not enabled", nil)
+		return
+	}
+
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+
+	var req workflow.ResolveStuckRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	var actorID string
+	if claims := auth.GetClaimsFromGin(c); claims != nil {
+		actorID = claims.UserID
+		if actorID == "" {
+			actorID = claims.Subject
+		}
+	}
+
+	resolutions, err := h.executionWatchdog.Resolve(ctx, tenantID, &req, actorID)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	failed := 0
+	for _, r := range resolutions {
+		if r.Error != "" {
+			failed++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results":  resolutions,
+		"resolved": len(resolutions) - failed,
+		"failed":   failed,
+	})
+}
+
+// ListDispatchJobs lists the tenant's queued and dead-lettered deliveries
+func (h *Handlers) ListDispatchJobs(c *gin.Context) {
+	ctx := c.Request.Context()
+	limit := getIntParam(c, "limit", 50)
+	offset := getIntParam(c, "offset", 0)
+
+	jobs, total, err := h.workflowExecutor.ListDispatchJobs(ctx, &workflow.ListDispatchJobsRequest{
+		TenantID: getTenantID(c),
+		Status:   models.DispatchJobStatus(c.Query("status")),
+		AgentID:  c.Query("agent_id"),
+		Limit:    limit,
+		Offset:   offset,
+	})
+	if err != nil {
+		h.logger.Error("failed to list dispatch jobs", zap.Error(err))
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":   jobs,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// RequeueDispatchJob retries delivery of a dead-lettered dispatch job
+func (h *Handlers) RequeueDispatchJob(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	auditAs(c, audit.ActionExecute)
+	job, err := h.workflowExecutor.RequeueDispatchJob(ctx, getTenantID(c), c.Param("job_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// Audit handlers
+
+// IngestAuditEvent records an audit event pushed by tenant automation. The
+// event is stored with type custom, and its tenant, actor and request
+// information come from the caller rather than the body.
+func (h *Handlers) IngestAuditEvent(c *gin.Context) {
+	if h.auditLogger == nil {
+		writeAPIError(c, http.StatusServiceUnavailable, ErrCodeUnavailable, "audit logging is not enabled", nil)
+		return
+	}
+
+	claims := auth.GetClaimsFromGin(c)
+	if claims == nil || claims.Type == "agent" {
+		writeAPIError(c, http.StatusForbidden, ErrCodeForbidden, "custom audit events require a user or API token", nil)
+		return
+	}
+
+	var req audit.CustomEventRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	event, err := audit.NewCustomEvent(&req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	if event.Fields, err = h.auditFields.Validate(c.Request.Context(), getTenantID(c), req.Fields); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	actorID := claims.UserID
+	if actorID == "" {
+		actorID = claims.Subject
+	}
+	event.TenantID = getTenantID(c)
+	event.ActorID = actorID
+	event.ActorType = claims.Type
+	event.IPAddress = c.ClientIP()
+	event.UserAgent = c.Request.UserAgent()
+	event.RequestID = GetRequestID(c)
+
+	if err := h.auditLogger.Log(c.Request.Context(), event); err != nil {
+		h.logger.Error("failed to record custom audit event", zap.Error(err))
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"id":        event.ID,
+		"timestamp": event.Timestamp,
+	})
+}
+
+// maxAuditSearchHits bounds the events returned by one audit search
+const maxAuditSearchHits = 1000
+
+// SearchAuditEvents searches the tenant's audit log, newest first. Custom
+// fields are filtered as field.<name>=<value>.
+func (h *Handlers) SearchAuditEvents(c *gin.Context) {
+	if h.auditLogger == nil {
+		writeAPIError(c, http.StatusServiceUnavailable, ErrCodeUnavailable, "audit logging is not enabled", nil)
+		return
+	}
+
+	since, until, ok := auditRange(c)
+	if !ok {
+		return
+	}
+	limit := getIntParam(c, "limit", 100)
+	if limit <= 0 || limit > maxAuditSearchHits {
+		limit = 100
+	}
+	offset := getIntParam(c, "offset", 0)
+	if offset < 0 {
+		offset = 0
+	}
+	requestID := c.Query("request_id")
+	if requestID != "" && !tracing.ValidRequestID(requestID) {
+		writeInvalidRequest(c, "invalid request_id", nil)
+		return
+	}
+
+	tenantID := getTenantID(c)
+	query := &audit.SearchQuery{
+		TenantID:    tenantID,
+		Query:       c.Query("q"),
+		ActorID:     c.Query("actor_id"),
+		ResourceID:  c.Query("resource_id"),
+		RequestID:   requestID,
+		StartTime:   since,
+		EndTime:     until,
+		MaxHits:     limit,
+		StartOffset: offset,
+		SortBy:      []audit.SortField{{Field: "timestamp", Order: "desc"}},
+	}
+	for _, eventType := range c.QueryArray("event_type") {
+		query.EventTypes = append(query.EventTypes, audit.EventType(eventType))
+	}
+	for _, action := range c.QueryArray("action") {
+		query.Actions = append(query.Actions, audit.EventAction(action))
+	}
+	for _, outcome := range c.QueryArray("outcome") {
+		query.Outcomes = append(query.Outcomes, audit.EventOutcome(outcome))
+	}
+
+	filters := map[string]string{}
+	for key, values := range c.Request.URL.Query() {
+		if name, ok := strings.CutPrefix(key, "field."); ok && len(values) > 0 {
+			filters[name] = values[0]
+		}
+	}
+	var err error
+	if query.Fields, err = h.auditFields.SearchFilters(c.Request.Context(), tenantID, filters); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	result, err := h.auditLogger.Search(c.Request.Context(), query)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ListAuditFields lists the tenant's custom audit fields
+func (h *Handlers) ListAuditFields(c *gin.Context) {
+	fields, err := h.auditFields.List(c.Request.Context(), getTenantID(c))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"fields": fields})
+}
+
+// CreateAuditField registers a custom audit field
+func (h *Handlers) CreateAuditField(c *gin.Context) {
+	var req audit.CreateFieldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	req.TenantID = getTenantID(c)
+	if claims := auth.GetClaimsFromGin(c); claims != nil {
+		req.CreatedBy = claims.UserID
+	}
+
+	field, err := h.auditFields.Create(c.Request.Context(), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, field)
+}
+
+// GetAuditField returns a custom audit field
+func (h *Handlers) GetAuditField(c *gin.Context) {
+	field, err := h.auditFields.Get(c.Request.Context(), getTenantID(c), c.Param("name"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, field)
+}
+
+// UpdateAuditField changes a custom audit field's description, whether it
+// is required and its allowed values
+func (h *Handlers) UpdateAuditField(c *gin.Context) {
+	var req audit.UpdateFieldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	field, err := h.auditFields.Update(c.Request.Context(), getTenantID(c), c.Param("name"), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, field)
+}
+
+// RetireAuditField stops a custom audit field accepting values; events
+// carrying it stay searchable
+func (h *Handlers) RetireAuditField(c *gin.Context) {
+	field, err := h.auditFields.Retire(c.Request.Context(), getTenantID(c), c.Param("name"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, field)
+}
+
+// auditRange parses the optional since and until query parameters
+func auditRange(c *gin.Context) (since, until *time.Time, ok bool) {
+	for key, dst := range map[string]**time.Time{"since": &since, "until": &until} {
+		if val := c.Query(key); val != "" {
+			t, err := time.Parse(time.RFC3339, val)
+			if err != nil {
+				writeInvalidRequest(c, "invalid "+key+": must be RFC 3339", nil)
+				return nil, nil, false
+			}
+			*dst = &t
+		}
+	}
+	return since, until, true
+}
+
+// VerifyAuditChain checks the integrity of the tenant's audit hash chain
+func (h *Handlers) VerifyAuditChain(c *gin.Context) {
+	if h.auditLogger == nil {
+		writeAPIError(c, http.StatusServiceUnavailable, ErrCodeUnavailable, "audit logging is not enabled", nil)
+		return
+	}
+
+	since, until, ok := auditRange(c)
+	if !ok {
+		return
+	}
+
+	verification, err := h.auditLogger.VerifyChain(c.Request.Context(), getTenantID(c), since, until)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, verification)
+}
+
+// ExportAuditBundle returns a signed bundle of the tenant's audit events
+func (h *Handlers) ExportAuditBundle(c *gin.Context) {
+	if h.auditLogger == nil {
+		writeAPIError(c, http.StatusServiceUnavailable, ErrCodeUnavailable, "audit logging is not enabled", nil)
+		return
+	}
+
+	since, until, ok := auditRange(c)
+	if !ok {
+		return
+	}
+
+	tenantID := getTenantID(c)
+	bundle, err := h.auditLogger.Export(c.Request.Context(), tenantID, since, until)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	filename := fmt.Sprintf("audit-%s-%s.json", tenantID, bundle.Manifest.GeneratedAt.Format("20060102T150405Z"))
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.JSON(http.StatusOK, bundle)
+}
+
+// ListAuditAnomalies lists the anomalies found in the tenant's audit log,
+// by default the open ones
+func (h *Handlers) ListAuditAnomalies(c *gin.Context) {
+	if h.anomalyDetector == nil {
+		writeAPIError(c, http.StatusServiceUnavailable, ErrCodeUnavailable, "audit logging is not enabled", nil)
+		return
+	}
+
+	limit := getIntParam(c, "limit", 100)
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	anomalies, err := h.anomalyDetector.List(c.Request.Context(), &anomaly.ListAnomaliesRequest{
+		TenantID: getTenantID(c),
+		Status:   c.Query("status"),
+		Kind:     models.AnomalyKind(c.Query("kind")),
+		Limit:    limit,
+	})
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"anomalies": anomalies})
+}
+
+// GetAuditSigningKey returns the public key audit exports are signed with
+func (h *Handlers) GetAuditSigningKey(c *gin.Context) {
+	if h.auditLogger == nil || h.auditLogger.ExportPublicKey() == "" {
+		writeAPIError(c, http.StatusServiceUnavailable, ErrCodeUnavailable, "audit export signing is not configured", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"algorithm":  "ed25519",
+		"public_key": h.auditLogger.ExportPublicKey(),
+	})
+}
+
+// Campaign handlers
+
+// ListCampaigns lists campaigns for a tenant
+func (h *Handlers) ListCampaigns(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	status := models.CampaignStatus(c.Query("status"))
+	limit := getIntParam(c, "limit", 50)
+	offset := getIntParam(c, "offset", 0)
+
+	campaigns, total, err := h.campaignManager.List(ctx, tenantID, status, limit, offset)
+	if err != nil {
+		h.logger.Error("failed to list campaigns", zap.Error(err))
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"campaigns": campaigns,
+		"total":     total,
+		"limit":     limit,
+		"offset":    offset,
+	})
+}
+
+// GetCampaign gets a campaign by ID
+func (h *Handlers) GetCampaign(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	campaignID := c.Param("campaign_id")
+
+	camp, err := h.campaignManager.Get(ctx, tenantID, campaignID)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, camp)
+}
+
+// CreateCampaign creates a new campaign
+func (h *Handlers) CreateCampaign(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+
+	var req campaign.CreateCampaignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	req.TenantID = tenantID
+
+	// Get created by from auth context
+	if claims, ok := c.Get("claims"); ok {
+		if authClaims, ok := claims.(*auth.Claims); ok {
+			req.CreatedBy = authClaims.UserID
+		}
+	}
+
+	camp, err := h.campaignManager.Create(ctx, &req)
+	if err != nil {
+		h.logger.Error("failed to create campaign", zap.Error(err))
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, camp)
+}
+
+// StartCampaign starts a campaign
+func (h *Handlers) StartCampaign(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	campaignID := c.Param("campaign_id")
+
+	auditAs(c, audit.ActionExecute)
+	if err := h.campaignManager.Start(ctx, tenantID, campaignID); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "campaign started"})
+}
+
+// ApproveCampaignPhase signs off a phase awaiting manual approval so the
+// campaign continues with the next phase. :phase is the phase ID or name.
+func (h *Handlers) ApproveCampaignPhase(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	campaignID := c.Param("campaign_id")
+
+	var req struct {
+		Note string `json:"note"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			writeBindError(c, err)
+			return
+		}
+	}
+
+	// Record who signed off; agents cannot approve phases
+	approvedBy := ""
+	if claims, ok := c.Get("claims"); ok {
+		if authClaims, ok := claims.(*auth.Claims); ok && authClaims.Type != "agent" {
+			approvedBy = authClaims.UserID
+			if approvedBy == "" {
+				approvedBy = authClaims.Subject
+			}
+		}
+	}
+	if approvedBy == "" {
+		writeAPIError(c, http.StatusForbidden, ErrCodeForbidden, "phase approval requires a user identity", nil)
+		return
+	}
+
+	phase, err := h.campaignManager.ApprovePhase(ctx, tenantID, campaignID, c.Param("phase"), approvedBy, req.Note)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, phase)
+}
+
+// ExcludeCampaignZone takes a zone out of a zoned campaign; the campaign
+// continues with its next zone
+func (h *Handlers) ExcludeCampaignZone(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	campaignID := c.Param("campaign_id")
+
+	excludedBy := ""
+	if claims, ok := c.Get("claims"); ok {
+		if authClaims, ok := claims.(*auth.Claims); ok {
+			excludedBy = authClaims.UserID
+			if excludedBy == "" {
+				excludedBy = authClaims.Subject
+			}
+		}
+	}
+
+	campaign, err := h.campaignManager.ExcludeZone(ctx, tenantID, campaignID, c.Param("zone"), excludedBy)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+// PauseCampaign pauses a campaign
+func (h *Handlers) PauseCampaign(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	campaignID := c.Param("campaign_id")
+
+	if err := h.campaignManager.Pause(ctx, tenantID, campaignID); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "campaign paused"})
+}
+
+// CancelCampaign cancels a campaign
+func (h *Handlers) CancelCampaign(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	campaignID := c.Param("campaign_id")
+
+	if err := h.campaignManager.Cancel(ctx, tenantID, campaignID); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "campaign cancelled"})
+}
+
+// maxApplySize limits the documents of an apply request
+const maxApplySize = 1 << 20
+
+// Apply applies declarative campaign documents, one or more YAML documents
+// in the body. Re-applying unchanged documents changes nothing;
+// ?dry_run=true returns the planned changes and their diffs only.
+func (h *Handlers) Apply(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxApplySize))
+	if err != nil {
+		writeBindError(c, err)
+		return
+	}
+	specs, err := campaign.ParseSpecs(body)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	req := &campaign.ApplyRequest{
+		TenantID: tenantID,
+		Specs:    specs,
+		DryRun:   c.Query("dry_run") == "true",
+	}
+	if claims, ok := c.Get("claims"); ok {
+		if authClaims, ok := claims.(*auth.Claims); ok {
+			req.AppliedBy = authClaims.UserID
+		}
+	}
+
+	result, err := h.campaignManager.Apply(ctx, req)
+	if err != nil {
+		h.logger.Error("failed to apply campaigns", zap.Error(err))
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetCampaignProgress gets campaign progress
+func (h *Handlers) GetCampaignProgress(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	campaignID := c.Param("campaign_id")
+
+	progress, err := h.campaignManager.GetProgress(ctx, tenantID, campaignID)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, progress)
+}
+
+// GetCampaignReadiness returns the readiness report of a dark-launch
+// campaign: the files each agent would change and the pre-checks that failed
+func (h *Handlers) GetCampaignReadiness(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	campaignID := c.Param("campaign_id")
+
+	report, err := h.campaignManager.GetReadiness(ctx, tenantID, campaignID)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// CompareCampaigns compares a campaign with the baseline campaign of the
+// same workflow given by the baseline query parameter
+func (h *Handlers) CompareCampaigns(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	campaignID := c.Param("campaign_id")
+
+	baselineID := c.Query("baseline")
+	if baselineID == "" {
+		writeInvalidRequest(c, "baseline query parameter is required", nil)
+		return
+	}
+
+	comparison, err := h.campaignManager.Compare(ctx, tenantID, baselineID, campaignID)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, comparison)
+}
+
+// GetCampaignTimeline returns campaign progress snapshots over time.
+// Optional since/until query parameters are RFC 3339 timestamps.
+func (h *Handlers) GetCampaignTimeline(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	campaignID := c.Param("campaign_id")
+
+	var since, until time.Time
+	for key, dst := range map[string]*time.Time{"since": &since, "until": &until} {
+		if val := c.Query(key); val != "" {
+			t, err := time.Parse(time.RFC3339, val)
+			if err != nil {
+				writeInvalidRequest(c, "invalid "+key+": must be RFC 3339", nil)
+				return
+			}
+			*dst = t
+		}
+	}
+
+	points, err := h.campaignManager.GetTimeline(ctx, tenantID, campaignID, since, until)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"campaign_id": campaignID,
+		"points":      points,
+	})
+}
+
+// Analytics handlers
+
+// GetWorkflowAnalytics returns duration percentiles, step durations, version
+// trends and slow agents for the tenant's workflows
+func (h *Handlers) GetWorkflowAnalytics(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+
+	var since, until time.Time
+	for key, dst := range map[string]*time.Time{"since": &since, "until": &until} {
+		if val := c.Query(key); val != "" {
+			t, err := time.Parse(time.RFC3339, val)
+			if err != nil {
+				writeInvalidRequest(c, "invalid "+key+": must be RFC 3339", nil)
+				return
+			}
+			*dst = t
+		}
+	}
+
+	report, err := h.analyzer.WorkflowDurations(ctx, &analytics.WorkflowQuery{
+		TenantID:   tenantID,
+		WorkflowID: c.Query("workflow_id"),
+		Since:      since,
+		Until:      until,
+	})
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// SimulateWorkflow estimates the duration and failure probability of running
+// a workflow on the agents matching a selector, from execution history. The
+// selector is either tags and status, matched like a campaign's target
+// selector, or a fleet query expression.
+func (h *Handlers) SimulateWorkflow(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+
+	var req struct {
+		WorkflowID string `json:"workflow_id" binding:"required"`
+		Selector   struct {
+			Tags   map[string]string `json:"tags"`
+			Status string            `json:"status"`
+			Query  string            `json:"query"`
+		} `json:"selector"`
+		Concurrency int        `json:"concurrency"`
+		Since       *time.Time `json:"since"`
+		Until       *time.Time `json:"until"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	var agents []models.Agent
+	var err error
+	if req.Selector.Query != "" {
+		if len(req.Selector.Tags) > 0 || req.Selector.Status != "" {
+			writeInvalidRequest(c, "selector.query cannot be combined with selector.tags or selector.status", nil)
+			return
+		}
+		agents, _, err = h.agentRegistry.QueryFleet(ctx, &agent.FleetQueryRequest{
+			TenantID: tenantID,
+			Query:    req.Selector.Query,
+		})
+	} else {
+		// Like campaigns, draining and drained agents are not targeted
+		agents, _, err = h.agentRegistry.List(ctx, &agent.ListRequest{
+			TenantID:   tenantID,
+			Status:     req.Selector.Status,
+			DrainState: string(models.AgentDrainNone),
+			Tags:       req.Selector.Tags,
+		})
+	}
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	simReq := &analytics.SimulationRequest{
+		TenantID:    tenantID,
+		WorkflowID:  req.WorkflowID,
+		AgentIDs:    make([]string, len(agents)),
+		Concurrency: req.Concurrency,
+	}
+	for i := range agents {
+		simReq.AgentIDs[i] = agents[i].ID
+	}
+	if req.Since != nil {
+		simReq.Since = *req.Since
+	}
+	if req.Until != nil {
+		simReq.Until = *req.Until
+	}
+
+	simulation, err := h.analyzer.Simulate(ctx, simReq)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, simulation)
+}
+
+// Template handlers
+
+// ListTemplates lists templates for a tenant
+func (h *Handlers) ListTemplates(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	status := models.TemplateStatus(c.Query("status"))
+	limit := getIntParam(c, "limit", 50)
+	offset := getIntParam(c, "offset", 0)
+
+	templates, total, err := h.templateManager.List(ctx, &template.ListTemplatesRequest{
+		TenantID: tenantID,
+		Status:   status,
+		Limit:    limit,
+		Offset:   offset,
+	})
+	if err != nil {
+		h.logger.Error("failed to list templates", zap.Error(err))
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"templates": templates,
+		"total":     total,
+		"limit":     limit,
+		"offset":    offset,
+	})
+}
+
+// GetTemplate gets a template by ID
+func (h *Handlers) GetTemplate(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	templateID := c.Param("template_id")
+
+	tpl, err := h.templateManager.Get(ctx, tenantID, templateID)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tpl)
+}
+
+// GetTemplateContent gets raw template content (for agents to fetch). The
+// ETag is the content hash; requests with a matching If-None-Match get 304
+// Not Modified without the content.
+func (h *Handlers) GetTemplateContent(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	templateID := c.Param("template_id")
+
+	// version pins the content deployed by a template_deploy campaign
+	version := getIntParam(c, "version", 0)
+	if version < 0 {
+		writeInvalidRequest(c, "invalid version: must be a positive integer", nil)
+		return
+	}
+
+	content, err := h.templateManager.OpenContent(ctx, tenantID, templateID, version)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	etag := `"` + content.Hash + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, no-cache")
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	// Range requests let agents fetch uploaded content in parts; only the
+	// stored chunks a range covers are read
+	c.Header("Content-Type", content.ContentType)
+	http.ServeContent(c.Writer, c.Request, "", content.ModTime, content)
+}
+
+// templateUploadTimeout bounds reading an uploaded template's content,
+// replacing the server's read timeout
+const templateUploadTimeout = 10 * time.Minute
+
+// UploadTemplateContent stores the raw request body as a template's next
+// version, for content too large to send inline. The body is streamed to
+// disk rather than buffered; expected_version is required as for updates.
+func (h *Handlers) UploadTemplateContent(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	templateID := c.Param("template_id")
+
+	expectedVersion, err := strconv.Atoi(c.Query("expected_version"))
+	if err != nil || expectedVersion < 1 {
+		writeInvalidRequest(c, "expected_version is required and must be a positive integer", nil)
+		return
+	}
+
+	req := &template.UploadContentRequest{
+		ContentType:     c.Query("content_type"),
+		ChangeNote:      c.Query("change_note"),
+		ExpectedVersion: expectedVersion,
+	}
+	if claims, ok := c.Get("claims"); ok {
+		if authClaims, ok := claims.(*auth.Claims); ok {
+			req.ChangedBy = authClaims.UserID
+		}
+	}
+
+	// Servers not exposing their connection keep the configured timeout
+	_ = http.NewResponseController(c.Writer).SetReadDeadline(time.Now().Add(templateUploadTimeout))
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, h.templateManager.MaxUploadSize())
+
+	tpl, err := h.templateManager.UploadContent(ctx, tenantID, templateID, body, req)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeBindError(c, err)
+			return
+		}
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tpl)
+}
+
+// maxTemplateRequestSize bounds request bodies carrying inline template
+// content; larger content is uploaded with UploadTemplateContent
+const maxTemplateRequestSize = 2 * template.MaxInlineContentSize
+
+// CreateTemplate creates a new template
+func (h *Handlers) CreateTemplate(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxTemplateRequestSize)
+	var req template.CreateTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	req.TenantID = tenantID
+
+	// Get created by from auth context
+	if claims, ok := c.Get("claims"); ok {
+		if authClaims, ok := claims.(*auth.Claims); ok {
+			req.CreatedBy = authClaims.UserID
+		}
+	}
+
+	tpl, err := h.templateManager.Create(ctx, &req)
+	if err != nil {
+		h.logger.Error("failed to create template", zap.Error(err))
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, tpl)
+}
+
+// UpdateTemplate updates a template
+func (h *Handlers) UpdateTemplate(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	templateID := c.Param("template_id")
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxTemplateRequestSize)
+	var req template.UpdateTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	// Get changed by from auth context
+	if claims, ok := c.Get("claims"); ok {
+		if authClaims, ok := claims.(*auth.Claims); ok {
+			req.ChangedBy = authClaims.UserID
+		}
+	}
+
+	tpl, err := h.templateManager.Update(ctx, tenantID, templateID, &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tpl)
+}
+
+// DeleteTemplate deletes a template
+func (h *Handlers) DeleteTemplate(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	templateID := c.Param("template_id")
+
+	if err := h.templateManager.Delete(ctx, tenantID, templateID); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "template deleted"})
+}
+
+// GetTemplateVersions gets all versions of a template
+func (h *Handlers) GetTemplateVersions(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	templateID := c.Param("template_id")
+
+	versions, err := h.templateManager.GetVersions(ctx, tenantID, templateID)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"versions": versions})
+}
+
+// GetTemplatePathHistory returns the audited content changes of the
+// templates synced from a Git file (?path=), oldest first, with redacted diffs
+func (h *Handlers) GetTemplatePathHistory(c *gin.Context) {
+	changes, err := h.templateManager.PathHistory(c.Request.Context(), getTenantID(c), c.Query("path"), getIntParam(c, "limit", 100))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"path": c.Query("path"), "changes": changes})
+}
+
+// DiffTemplateVersions returns the unified diff between two template versions
+// as JSON hunks (format=json, default) or a text/plain patch (format=text or
+// Accept: text/plain)
+func (h *Handlers) DiffTemplateVersions(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	templateID := c.Param("template_id")
+
+	from, err := strconv.Atoi(c.Param("from_version"))
+	if err != nil || from < 1 {
+		writeInvalidRequest(c, "invalid version: "+c.Param("from_version"), nil)
+		return
+	}
+	to, err := strconv.Atoi(c.Param("to_version"))
+	if err != nil || to < 1 {
+		writeInvalidRequest(c, "invalid version: "+c.Param("to_version"), nil)
+		return
+	}
+
+	format := c.Query("format")
+	if format == "" {
+		format = "json"
+		if c.NegotiateFormat(gin.MIMEJSON, gin.MIMEPlain) == gin.MIMEPlain {
+			format = "text"
+		}
+	}
+	if format != "json" && format != "text" {
+		writeInvalidRequest(c, "invalid format: must be json or text", nil)
+		return
+	}
+
+	result, err := h.templateManager.DiffVersions(ctx, tenantID, templateID, from, to, getIntParam(c, "context", diff.DefaultContext))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	if format == "text" {
+		c.Data(http.StatusOK, "text/x-diff; charset=utf-8", []byte(result.String()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"template_id":  templateID,
+		"from_version": from,
+		"to_version":   to,
+		"diff":         result,
+		"unified":      result.String(),
+	})
+}
+
+// ActivateTemplate activates a template
+func (h *Handlers) ActivateTemplate(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	templateID := c.Param("template_id")
+
+	if err := h.templateManager.Activate(ctx, tenantID, templateID); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "template activated"})
+}
+
+// LintTemplate re-runs lint checks for a template
+func (h *Handlers) LintTemplate(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	templateID := c.Param("template_id")
+
+	result, err := h.templateManager.Lint(ctx, tenantID, templateID)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// RenderTemplate renders a template preview with supplied variables
+func (h *Handlers) RenderTemplate(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	templateID := c.Param("template_id")
+
+	var req template.RenderPreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	result, err := h.templateManager.RenderPreview(ctx, tenantID, templateID, &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// Git repository handlers
+
+// ListGitRepositories lists the tenant's Git repositories
+func (h *Handlers) ListGitRepositories(c *gin.Context) {
+	repos, err := h.gitRepositories.List(c.Request.Context(), getTenantID(c))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"repositories": repos})
+}
+
+// CreateGitRepository registers a Git repository to sync workflows and
+// templates from
+func (h *Handlers) CreateGitRepository(c *gin.Context) {
+	var req gitsync.CreateRepositoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	req.TenantID = getTenantID(c)
+	if claims, ok := c.Get("claims"); ok {
+		if authClaims, ok := claims.(*auth.Claims); ok {
+			req.CreatedBy = authClaims.UserID
+		}
+	}
+
+	repo, err := h.gitRepositories.Create(c.Request.Context(), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, repo)
+}
+
+// GetGitRepository gets a Git repository and the result of its last sync
+func (h *Handlers) GetGitRepository(c *gin.Context) {
+	repo, err := h.gitRepositories.Get(c.Request.Context(), getTenantID(c), c.Param("repository_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, repo)
+}
+
+// UpdateGitRepository updates a Git repository
+func (h *Handlers) UpdateGitRepository(c *gin.Context) {
+	var req gitsync.UpdateRepositoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	repo, err := h.gitRepositories.Update(c.Request.Context(), getTenantID(c), c.Param("repository_id"), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, repo)
+}
+
+// DeleteGitRepository removes a Git repository, releasing the resources
+// synced from it
+func (h *Handlers) DeleteGitRepository(c *gin.Context) {
+	if err := h.gitRepositories.Delete(c.Request.Context(), getTenantID(c), c.Param("repository_id")); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Git repository deleted"})
+}
+
+// SyncGitRepository schedules a Git repository for an immediate sync
+func (h *Handlers) SyncGitRepository(c *gin.Context) {
+	repo, err := h.gitRepositories.RequestSync(c.Request.Context(), getTenantID(c), c.Param("repository_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, repo)
+}
+
+// Configuration profile handlers
+
+// ListConfigProfiles lists the tenant's agent configuration profiles
+func (h *Handlers) ListConfigProfiles(c *gin.Context) {
+	profiles, err := h.configProfiles.List(c.Request.Context(), getTenantID(c))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"profiles":     profiles,
+		"setting_keys": configprofile.SettingKeys(),
+	})
+}
+
+// CreateConfigProfile creates an agent configuration profile
+func (h *Handlers) CreateConfigProfile(c *gin.Context) {
+	var req configprofile.CreateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	req.TenantID = getTenantID(c)
+	if claims, ok := c.Get("claims"); ok {
+		if authClaims, ok := claims.(*auth.Claims); ok {
+			req.CreatedBy = authClaims.UserID
+		}
+	}
+
+	profile, err := h.configProfiles.Create(c.Request.Context(), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, profile)
+}
+
+// GetConfigProfile gets an agent configuration profile
+func (h *Handlers) GetConfigProfile(c *gin.Context) {
+	profile, err := h.configProfiles.Get(c.Request.Context(), getTenantID(c), c.Param("profile_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// UpdateConfigProfile updates an agent configuration profile; new settings
+// roll out to its agents on their next report
+func (h *Handlers) UpdateConfigProfile(c *gin.Context) {
+	var req configprofile.UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	profile, err := h.configProfiles.Update(c.Request.Context(), getTenantID(c), c.Param("profile_id"), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// DeleteConfigProfile deletes an agent configuration profile
+func (h *Handlers) DeleteConfigProfile(c *gin.Context) {
+	if err := h.configProfiles.Delete(c.Request.Context(), getTenantID(c), c.Param("profile_id")); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "configuration profile deleted"})
+}
+
+// GetConfigProfileRollout summarizes which agents run the current version
+// of a profile
+func (h *Handlers) GetConfigProfileRollout(c *gin.Context) {
+	rollout, err := h.configProfiles.GetRollout(c.Request.Context(), getTenantID(c), c.Param("profile_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rollout)
+}
+
+// AssignAgentConfigProfile assigns a configuration profile to an agent,
+// overriding profiles selecting it by tags
+func (h *Handlers) AssignAgentConfigProfile(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	agentID := c.Param("agent_id")
+
+	var req struct {
+		ProfileID string `json:"profile_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	if err := h.configProfiles.AssignAgent(ctx, tenantID, agentID, req.ProfileID); err != nil {
+		writeError(c, err)
+		return
+	}
+	config, err := h.configProfiles.GetAgentConfig(ctx, tenantID, agentID)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, config)
+}
+
+// UnassignAgentConfigProfile removes an agent's directly assigned profile;
+// profiles selecting it by tags apply again
+func (h *Handlers) UnassignAgentConfigProfile(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := getTenantID(c)
+	agentID := c.Param("agent_id")
+
+	if err := h.configProfiles.AssignAgent(ctx, tenantID, agentID, ""); err != nil {
+		writeError(c, err)
+		return
+	}
+	config, err := h.configProfiles.GetAgentConfig(ctx, tenantID, agentID)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, config)
+}
+
+// GetAgentConfig returns an agent's assigned profile, the state it last
+// reported and its effective profile-managed settings
+func (h *Handlers) GetAgentConfig(c *gin.Context) {
+	config, err := h.configProfiles.GetAgentConfig(c.Request.Context(), getTenantID(c), requestAgentID(c))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, config)
+}
+
+// ListAgentConfigRollouts returns an agent's configuration rollout history
+func (h *Handlers) ListAgentConfigRollouts(c *gin.Context) {
+	limit := getIntParam(c, "limit", 50)
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+
+	rollouts, err := h.configProfiles.ListAgentRollouts(c.Request.Context(), getTenantID(c), c.Param("agent_id"), limit)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rollouts": rollouts})
+}
+
+// Shell session handlers
+
+// shellUpgrader upgrades shell requests to WebSockets. Cross-origin
+// upgrades are refused, so a page on another site cannot open a shell with
+// the operator's credentials.
+var shellUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 32 << 10,
+}
+
+// OpenAgentShell opens a live shell on an agent and relays it over a
+// WebSocket until the session ends. Keystrokes and output are exchanged as
+// binary messages and control messages as JSON text; see
+// shell.ControlMessage. The initial terminal size comes from the rows and
+// cols query parameters.
+func (h *Handlers) OpenAgentShell(c *gin.Context) {
+	if !websocket.IsWebSocketUpgrade(c.Request) {
+		writeInvalidRequest(c, "shell sessions require a WebSocket upgrade", nil)
+		return
+	}
+
+	req := &shell.OpenRequest{
+		TenantID:   getTenantID(c),
+		AgentID:    c.Param("agent_id"),
+		RemoteAddr: c.ClientIP(),
+		Rows:       uint16(getIntParam(c, "rows", 24)),
+		Cols:       uint16(getIntParam(c, "cols", 80)),
+	}
+	if claims, ok := c.Get("claims"); ok {
+		if authClaims, ok := claims.(*auth.Claims); ok {
+			req.UserID = authClaims.UserID
+		}
+	}
+
+	session, err := h.shellBroker.Open(c.Request.Context(), req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	conn, err := shellUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has written the error response
+		session.End(c.Request.Context(), shell.ReasonClosed, nil)
+		return
+	}
+	// Sessions outlive the server's read and write timeouts
+	conn.SetReadDeadline(time.Time{})
+	conn.SetWriteDeadline(time.Time{})
+
+	session.Serve(c.Request.Context(), conn)
+}
+
+// ListShellSessions lists the tenant's shell sessions, optionally those on
+// one agent
+func (h *Handlers) ListShellSessions(c *gin.Context) {
+	limit := getIntParam(c, "limit", 50)
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+
+	sessions, err := h.shellBroker.ListSessions(c.Request.Context(), getTenantID(c), c.Query("agent_id"), limit)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// GetShellSession gets a shell session by ID
+func (h *Handlers) GetShellSession(c *gin.Context) {
+	session, err := h.shellBroker.GetSession(c.Request.Context(), getTenantID(c), c.Param("session_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+// GetShellSessionRecording returns a shell session's recorded input and
+// output, with offsets from the start of the session
+func (h *Handlers) GetShellSessionRecording(c *gin.Context) {
+	recording, err := h.shellBroker.GetRecording(c.Request.Context(), getTenantID(c), c.Param("session_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, recording)
+}
+
+// StartImpersonation issues a platform admin a short-lived token operating
+// as a tenant. Impersonation tokens cannot start further impersonations.
+func (h *Handlers) StartImpersonation(c *gin.Context) {
+	claims := auth.GetClaimsFromGin(c)
+	if claims == nil || claims.Type == "agent" || claims.Impersonating() {
+		writeAPIError(c, http.StatusForbidden, ErrCodeForbidden, "impersonation requires a platform admin's own token", nil)
+		return
+	}
+
+	var req auth.ImpersonationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	req.AdminID = claims.UserID
+	if req.AdminID == "" {
+		req.AdminID = claims.Subject
+	}
+	req.AdminTenantID = claims.TenantID
+	req.RemoteAddr = c.ClientIP()
+
+	result, err := h.impersonator.Start(c.Request.Context(), &req)
+	if err != nil {
+		h.logger.Error("failed to start impersonation", zap.Error(err))
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
+
+// ListImpersonations lists the active impersonation sessions, optionally
+// of one tenant (?tenant_id=)
+func (h *Handlers) ListImpersonations(c *gin.Context) {
+	sessions, err := h.impersonator.ListActive(c.Request.Context(), c.Query("tenant_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// EndImpersonation ends an impersonation session, revoking its token
+func (h *Handlers) EndImpersonation(c *gin.Context) {
+	var endedBy string
+	if claims := auth.GetClaimsFromGin(c); claims != nil {
+		endedBy = claims.UserID
+		if endedBy == "" {
+			endedBy = claims.Subject
+		}
+	}
+
+	session, err := h.impersonator.End(c.Request.Context(), c.Param("session_id"), endedBy)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+// Remediation handlers
+
+// ListRemediationRules lists the tenant's remediation rules
+func (h *Handlers) ListRemediationRules(c *gin.Context) {
+	rules, err := h.remediation.ListRules(c.Request.Context(), getTenantID(c))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// CreateRemediationRule binds a workflow to a health condition
+func (h *Handlers) CreateRemediationRule(c *gin.Context) {
+	var req remediation.CreateRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	req.TenantID = getTenantID(c)
+	if claims := auth.GetClaimsFromGin(c); claims != nil {
+		req.CreatedBy = claims.UserID
+	}
+
+	rule, err := h.remediation.CreateRule(c.Request.Context(), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, rule)
+}
+
+// GetRemediationRule returns a remediation rule
+func (h *Handlers) GetRemediationRule(c *gin.Context) {
+	rule, err := h.remediation.GetRule(c.Request.Context(), getTenantID(c), c.Param("rule_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+// UpdateRemediationRule updates a remediation rule; enabled=false is the
+// rule's kill switch
+func (h *Handlers) UpdateRemediationRule(c *gin.Context) {
+	var req remediation.UpdateRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	rule, err := h.remediation.UpdateRule(c.Request.Context(), getTenantID(c), c.Param("rule_id"), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+// DeleteRemediationRule deletes a remediation rule
+func (h *Handlers) DeleteRemediationRule(c *gin.Context) {
+	if err := h.remediation.DeleteRule(c.Request.Context(), getTenantID(c), c.Param("rule_id")); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "remediation rule deleted"})
+}
+
+// ListRemediationRuns lists remediation runs, newest first, filtered by
+// ?rule_id=, ?agent_id= and ?since= (RFC 3339)
+func (h *Handlers) ListRemediationRuns(c *gin.Context) {
+	req := &remediation.ListRunsRequest{
+		TenantID: getTenantID(c),
+		RuleID:   c.Query("rule_id"),
+		AgentID:  c.Query("agent_id"),
+		Limit:    getIntParam(c, "limit", 100),
+	}
+	if val := c.Query("since"); val != "" {
+		since, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			writeInvalidRequest(c, "invalid since: must be RFC 3339", nil)
+			return
+		}
+		req.Since = since
+	}
+
+	runs, err := h.remediation.ListRuns(c.Request.Context(), req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
+
+// SetRemediationPaused pauses or resumes all of the tenant's remediation
+// rules: the tenant's kill switch
+func (h *Handlers) SetRemediationPaused(c *gin.Context) {
+	var req struct {
+		Paused *bool `json:"paused" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	if err := h.remediation.SetPaused(c.Request.Context(), getTenantID(c), *req.Paused); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"paused": *req.Paused})
+}
+
+// Inbound trigger handlers
+
+// maxTriggerPayloadSize limits the body posted to an inbound trigger
+const maxTriggerPayloadSize = 1 << 20
+
+// InvokeInboundTrigger starts an inbound trigger's workflow for an external
+// system. It needs no API credentials: the timestamped body must be signed
+// with the trigger's secret, or the secret sent as a token.
+func (h *Handlers) InvokeInboundTrigger(c *gin.Context) {
+	signature := c.GetHeader(inbound.HeaderSignature)
+	token := c.GetHeader(inbound.HeaderToken)
+	if signature == "" && token == "" {
+		writeAPIError(c, http.StatusUnauthorized, ErrCodeUnauthorized, "missing "+inbound.HeaderSignature+" or "+inbound.HeaderToken+" header", nil)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxTriggerPayloadSize))
+	if err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	result, err := h.inboundTriggers.Invoke(c.Request.Context(), &inbound.Invocation{
+		TriggerID: c.Param("trigger_id"),
+		Body:      body,
+		Signature: signature,
+		Timestamp: c.GetHeader(inbound.HeaderTimestamp),
+		Token:     token,
+		SourceIP:  c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, result)
+}
+
+// ListInboundTriggers lists the tenant's inbound triggers
+func (h *Handlers) ListInboundTriggers(c *gin.Context) {
+	triggers, err := h.inboundTriggers.ListTriggers(c.Request.Context(), getTenantID(c))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"triggers": triggers})
+}
+
+// CreateInboundTrigger creates an inbound trigger. Its secret is returned
+// in this response only.
+func (h *Handlers) CreateInboundTrigger(c *gin.Context) {
+	var req inbound.CreateTriggerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	req.TenantID = getTenantID(c)
+	if claims := auth.GetClaimsFromGin(c); claims != nil {
+		req.CreatedBy = claims.UserID
+	}
+
+	trigger, err := h.inboundTriggers.CreateTrigger(c.Request.Context(), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, trigger)
+}
+
+// GetInboundTrigger returns an inbound trigger
+func (h *Handlers) GetInboundTrigger(c *gin.Context) {
+	trigger, err := h.inboundTriggers.GetTrigger(c.Request.Context(), getTenantID(c), c.Param("trigger_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, trigger)
+}
+
+// UpdateInboundTrigger updates an inbound trigger; enabled=false stops it
+// accepting invocations
+func (h *Handlers) UpdateInboundTrigger(c *gin.Context) {
+	var req inbound.UpdateTriggerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	trigger, err := h.inboundTriggers.UpdateTrigger(c.Request.Context(), getTenantID(c), c.Param("trigger_id"), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, trigger)
+}
+
+// DeleteInboundTrigger deletes an inbound trigger
+func (h *Handlers) DeleteInboundTrigger(c *gin.Context) {
+	if err := h.inboundTriggers.DeleteTrigger(c.Request.Context(), getTenantID(c), c.Param("trigger_id")); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "inbound trigger deleted"})
+}
+
+// RotateInboundTriggerSecret replaces an inbound trigger's secret and
+// returns the new one
+func (h *Handlers) RotateInboundTriggerSecret(c *gin.Context) {
+	trigger, err := h.inboundTriggers.RotateSecret(c.Request.Context(), getTenantID(c), c.Param("trigger_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, trigger)
+}
+
+// ListInboundTriggerInvocations lists a trigger's invocations, newest
+// first, filtered by ?status= and ?since= (RFC 3339)
+func (h *Handlers) ListInboundTriggerInvocations(c *gin.Context) {
+	req := &inbound.ListInvocationsRequest{
+		TenantID:  getTenantID(c),
+		TriggerID: c.Param("trigger_id"),
+		Status:    models.InvocationStatus(c.Query("status")),
+		Limit:     getIntParam(c, "limit", 100),
+	}
+	if val := c.Query("since"); val != "" {
+		since, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			writeInvalidRequest(c, "invalid since: must be RFC 3339", nil)
+			return
+		}
+		req.Since = since
+	}
+
+	invocations, err := h.inboundTriggers.ListInvocations(c.Request.Context(), req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invocations": invocations})
+}
+
+// Schedule handlers
+
+// ListSchedules lists the tenant's schedules
+func (h *Handlers) ListSchedules(c *gin.Context) {
+	schedules, err := h.schedules.ListSchedules(c.Request.Context(), getTenantID(c))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schedules": schedules})
+}
+
+// CreateSchedule creates a schedule; timezone_mode agent_local reads its
+// cron expression in each agent's local timezone
+func (h *Handlers) CreateSchedule(c *gin.Context) {
+	var req schedule.CreateScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	req.TenantID = getTenantID(c)
+	if claims := auth.GetClaimsFromGin(c); claims != nil {
+		req.CreatedBy = claims.UserID
+	}
+
+	created, err := h.schedules.CreateSchedule(c.Request.Context(), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// GetSchedule returns a schedule
+func (h *Handlers) GetSchedule(c *gin.Context) {
+	s, err := h.schedules.GetSchedule(c.Request.Context(), getTenantID(c), c.Param("schedule_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, s)
+}
+
+// UpdateSchedule updates a schedule; enabled=false stops it running
+func (h *Handlers) UpdateSchedule(c *gin.Context) {
+	var req schedule.UpdateScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	updated, err := h.schedules.UpdateSchedule(c.Request.Context(), getTenantID(c), c.Param("schedule_id"), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteSchedule deletes a schedule
+func (h *Handlers) DeleteSchedule(c *gin.Context) {
+	if err := h.schedules.DeleteSchedule(c.Request.Context(), getTenantID(c), c.Param("schedule_id")); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "schedule deleted"})
+}
+
+// ListScheduleAgentRuns lists each agent's next and last run of an
+// agent-local schedule
+func (h *Handlers) ListScheduleAgentRuns(c *gin.Context) {
+	runs, err := h.schedules.ListAgentRuns(c.Request.Context(), getTenantID(c), c.Param("schedule_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
+
+// Vulnerability handlers
+
+// ListVulnerabilities lists the tenant's vulnerability findings, open by
+// default, filtered by agent, CVE or advisory and severity
+func (h *Handlers) ListVulnerabilities(c *gin.Context) {
+	limit := getIntParam(c, "limit", 100)
+	offset := getIntParam(c, "offset", 0)
+
+	agentID := c.Param("agent_id")
+	if agentID == "" {
+		agentID = c.Query("agent_id")
+	}
+	findings, total, err := h.vulnerabilities.ListFindings(c.Request.Context(), &vulnerability.ListFindingsRequest{
+		TenantID: getTenantID(c),
+		AgentID:  agentID,
+		ID:       c.Query("cve"),
+		Severity: models.AdvisorySeverity(c.Query("severity")),
+		Status:   models.FindingStatus(c.Query("status")),
+		Limit:    limit,
+		Offset:   offset,
+	})
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"findings": findings,
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
+	})
+}
+
+// GetAdvisory returns an advisory, by its ID or CVE, with its affected
+// package ranges
+func (h *Handlers) GetAdvisory(c *gin.Context) {
+	advisory, err := h.vulnerabilities.GetAdvisory(c.Request.Context(), c.Param("cve_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, advisory)
+}
+
+// ListAffectedAgents lists the tenant's agents with vulnerable packages for
+// a CVE or advisory
+func (h *Handlers) ListAffectedAgents(c *gin.Context) {
+	affected, err := h.vulnerabilities.AffectedAgents(c.Request.Context(), getTenantID(c), c.Param("cve_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"cve_id": c.Param("cve_id"),
+		"agents": affected,
+		"total":  len(affected),
+	})
+}
+
+// CreatePatchCampaign creates a draft campaign upgrading the vulnerable
+// packages on the agents affected by a CVE or advisory
+func (h *Handlers) CreatePatchCampaign(c *gin.Context) {
+	var req vulnerability.PatchCampaignRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			writeBindError(c, err)
+			return
+		}
+	}
+	req.TenantID = getTenantID(c)
+	req.ID = c.Param("cve_id")
+	if claims := auth.GetClaimsFromGin(c); claims != nil {
+		req.CreatedBy = claims.UserID
+	}
+
+	camp, err := h.vulnerabilities.PatchCampaign(c.Request.Context(), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, camp)
+}
+
+// Catalog handlers
+
+// ListCatalogEntries lists the workflows and templates published to every
+// tenant, optionally of one kind (?kind=) and matching a name (?search=).
+// Withdrawn entries are included with include_withdrawn=true.
+func (h *Handlers) ListCatalogEntries(c *gin.Context) {
+	limit := getIntParam(c, "limit", 50)
+	offset := getIntParam(c, "offset", 0)
+
+	entries, total, err := h.catalog.List(c.Request.Context(), &catalog.ListEntriesRequest{
+		Kind:             models.CatalogKind(c.Query("kind")),
+		Search:           c.Query("search"),
+		IncludeWithdrawn: c.Query("include_withdrawn") == "true",
+		Limit:            limit,
+		Offset:           offset,
+	})
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries": entries,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// GetCatalogEntry gets a catalog entry with its published definition or
+// content
+func (h *Handlers) GetCatalogEntry(c *gin.Context) {
+	entry, err := h.catalog.Get(c.Request.Context(), c.Param("entry_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, entry)
+}
+
+// PublishCatalogEntry publishes a workflow or template of the platform
+// tenant, or its newer version
+func (h *Handlers) PublishCatalogEntry(c *gin.Context) {
+	var req catalog.PublishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	if claims := auth.GetClaimsFromGin(c); claims != nil {
+		req.PublishedBy = claims.UserID
+		if req.PublishedBy == "" {
+			req.PublishedBy = claims.Subject
+		}
+	}
+
+	entry, err := h.catalog.Publish(c.Request.Context(), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, entry)
+}
+
+// WithdrawCatalogEntry takes an entry out of the catalog; copies already
+// made are kept
+func (h *Handlers) WithdrawCatalogEntry(c *gin.Context) {
+	entry, err := h.catalog.Withdraw(c.Request.Context(), c.Param("entry_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, entry)
+}
+
+// InstantiateCatalogEntry copies a catalog entry into the tenant as a draft
+// workflow or template
+func (h *Handlers) InstantiateCatalogEntry(c *gin.Context) {
+	var req catalog.InstantiateRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			writeBindError(c, err)
+			return
+		}
+	}
+	req.TenantID = getTenantID(c)
+	req.EntryID = c.Param("entry_id")
+	if claims := auth.GetClaimsFromGin(c); claims != nil {
+		req.CreatedBy = claims.UserID
+	}
+
+	result, err := h.catalog.Instantiate(c.Request.Context(), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
+
+// ListCatalogCopies lists the tenant's copies of catalog entries and
+// whether newer versions have been published, only those behind with
+// updates=true
+func (h *Handlers) ListCatalogCopies(c *gin.Context) {
+	copies, err := h.catalog.ListCopies(c.Request.Context(), getTenantID(c), c.Query("updates") == "true")
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"copies": copies})
+}
+
+// UpgradeCatalogCopy replaces a copy with the latest published version of
+// its entry
+func (h *Handlers) UpgradeCatalogCopy(c *gin.Context) {
+	changedBy := ""
+	if claims := auth.GetClaimsFromGin(c); claims != nil {
+		changedBy = claims.UserID
+	}
+
+	result, err := h.catalog.Upgrade(c.Request.Context(), getTenantID(c), c.Param("copy_id"), changedBy)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// Admin handlers
+
+// GetAdminOverview returns the state of the whole platform across tenants:
+// agents, campaigns in progress, failure hotspots over a window (default
+// 24h), queue backlogs and database health
+func (h *Handlers) GetAdminOverview(c *gin.Context) {
+	window, err := time.ParseDuration(c.DefaultQuery("window", "24h"))
+	if err != nil || window <= 0 {
+		writeInvalidRequest(c, "invalid window: must be a positive duration such as 24h", nil)
+		return
+	}
+
+	result, err := h.overview.Overview(c.Request.Context(), &overview.Request{
+		Window: window,
+		Limit:  getIntParam(c, "limit", overview.DefaultLimit),
+	})
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// Break-glass handlers
+
+// EnableBreakGlass starts a time-limited break-glass session for a tenant's
+// incident workflow
+func (h *Handlers) EnableBreakGlass(c *gin.Context) {
+	var req breakglass.EnableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	req.TenantID = c.Param("tenant_id")
+	if claims := auth.GetClaimsFromGin(c); claims != nil {
+		req.EnabledBy = claims.UserID
+		if req.EnabledBy == "" {
+			req.EnabledBy = claims.Subject
+		}
+	}
+
+	session, err := h.breakGlass.Enable(c.Request.Context(), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, session)
+}
+
+// ListBreakGlassSessions lists a tenant's break-glass sessions, only the
+// active ones with ?active=true
+func (h *Handlers) ListBreakGlassSessions(c *gin.Context) {
+	sessions, err := h.breakGlass.List(c.Request.Context(), c.Param("tenant_id"), c.Query("active") == "true")
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": sessions,
+		"total":    len(sessions),
+	})
+}
+
+// RevokeBreakGlass ends a break-glass session before it expires
+func (h *Handlers) RevokeBreakGlass(c *gin.Context) {
+	var revokedBy string
+	if claims := auth.GetClaimsFromGin(c); claims != nil {
+		revokedBy = claims.UserID
+		if revokedBy == "" {
+			revokedBy = claims.Subject
+		}
+	}
+
+	session, err := h.breakGlass.Revoke(c.Request.Context(), c.Param("tenant_id"), c.Param("session_id"), revokedBy)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+// Tenant database handlers
+
+// RegisterTenantDatabase registers and migrates a database for a tenant
+// without data
+func (h *Handlers) RegisterTenantDatabase(c *gin.Context) {
+	var req db.RegisterTenantDatabaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	req.TenantID = c.Param("tenant_id")
+
+	record, err := h.tenantDatabases.Register(c.Request.Context(), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, record)
+}
+
+// GetTenantDatabase returns a tenant's database entry
+func (h *Handlers) GetTenantDatabase(c *gin.Context) {
+	record, err := h.tenantDatabases.Get(c.Request.Context(), c.Param("tenant_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, record)
+}
+
+// MigrateTenantDatabase applies pending migrations to a tenant's database
+func (h *Handlers) MigrateTenantDatabase(c *gin.Context) {
+	result, err := h.tenantDatabases.Migrate(c.Request.Context(), c.Param("tenant_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ListTenantDatabases lists the registered tenant databases
+func (h *Handlers) ListTenantDatabases(c *gin.Context) {
+	records, err := h.tenantDatabases.List(c.Request.Context())
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tenant_databases": records,
+		"total":            len(records),
+	})
+}
+
+// MigrateTenantDatabases applies pending migrations to every tenant
+// database and reports each tenant
+func (h *Handlers) MigrateTenantDatabases(c *gin.Context) {
+	results, err := h.tenantDatabases.MigrateAll(c.Request.Context())
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"migrations": results,
+		"total":      len(results),
+		"failed":     failed,
+	})
+}
+
+// Helper functions
+
+// etagMatches reports whether an If-None-Match header lists etag. Weak
+// validators match too: the content endpoint only compares contents.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// isAdmin reports whether the caller holds the admin scope
+func isAdmin(c *gin.Context) bool {
+	if claims, ok := c.Get("claims"); ok {
+		if authClaims, ok := claims.(*auth.Claims); ok {
+			return authClaims.HasScope("admin")
+		}
+	}
+	return false
+}
 
 func getTenantID(c *gin.Context) string {
 	// First try to get from claims
@@ -5,18 +5,38 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 
 	"github.com/yourorg/control-plane/pkg/agent"
+	"github.com/yourorg/control-plane/pkg/analytics"
+	"github.com/yourorg/control-plane/pkg/anomaly"
 	"github.com/yourorg/control-plane/pkg/audit"
 	"github.com/yourorg/control-plane/pkg/auth"
+	"github.com/yourorg/control-plane/pkg/breakglass"
 	"github.com/yourorg/control-plane/pkg/campaign"
+	"github.com/yourorg/control-plane/pkg/catalog"
+	"github.com/yourorg/control-plane/pkg/configprofile"
+	"github.com/yourorg/control-plane/pkg/db"
+	"github.com/yourorg/control-plane/pkg/gitsync"
+	"github.com/yourorg/control-plane/pkg/inbound"
+	"github.com/yourorg/control-plane/pkg/overview"
+	"github.com/yourorg/control-plane/pkg/portability"
+	"github.com/yourorg/control-plane/pkg/remediation"
+	"github.com/yourorg/control-plane/pkg/schedule"
+	"github.com/yourorg/control-plane/pkg/search"
+	"github.com/yourorg/control-plane/pkg/shell"
 	"github.com/yourorg/control-plane/pkg/template"
 	"github.com/yourorg/control-plane/pkg/tenant"
+	"github.com/yourorg/control-plane/pkg/tracing"
+	"github.com/yourorg/control-plane/pkg/ui"
+	"github.com/yourorg/control-plane/pkg/vulnerability"
 	"github.com/yourorg/control-plane/pkg/workflow"
 )
 
@@ -29,6 +49,7 @@ type ServerConfig struct {
 	ShutdownTimeout time.Duration `json:"shutdown_timeout" yaml:"shutdown_timeout"`
 	Debug           bool          `json:"debug" yaml:"debug"`
 	TrustedProxies  []string      `json:"trusted_proxies" yaml:"trusted_proxies"`
+	DisableUI       bool          `json:"disable_ui" yaml:"disable_ui"`
 }
 
 // DefaultServerConfig returns default server configuration
@@ -52,20 +73,49 @@ type Server struct {
 	server   *http.Server
 	handlers *Handlers
 	jwtAuth  *auth.JWTAuth
+
+	impersonator *auth.Impersonator
+
+	// Set on shutdown: API writes other than agent reports are rejected
+	// and readiness fails so the load balancer stops routing here
+	draining atomic.Bool
 }
 
 // Dependencies contains all dependencies needed by the server
 type Dependencies struct {
-	DB              *gorm.DB
-	Logger          *zap.Logger
-	JWTAuth         *auth.JWTAuth
-	TenantManager   *tenant.Manager
-	AgentRegistry   *agent.Registry
-	AgentRegistrar  *agent.Registrar
-	WorkflowManager *workflow.Manager
-	CampaignManager *campaign.Manager
-	TemplateManager *template.Manager
-	AuditLogger     *audit.Logger
+	DB                 *gorm.DB
+	Logger             *zap.Logger
+	JWTAuth            *auth.JWTAuth
+	TenantManager      *tenant.Manager
+	AgentRegistry      *agent.Registry
+	AgentRegistrar     *agent.Registrar
+	KeyManager         *agent.KeyManager
+	InstallScripts     *agent.InstallScriptGenerator
+	WorkflowManager    *workflow.Manager
+	WorkflowExecutor   *workflow.Executor
+	CampaignManager    *campaign.Manager
+	TemplateManager    *template.Manager
+	PortabilityManager *portability.Manager
+	AuditLogger        *audit.Logger
+	APIAuditor         *APIAuditor
+	OutputIndexer      *search.Indexer
+	ExecutionWatchdog  *workflow.Watchdog
+	Analyzer           *analytics.Analyzer
+	GitRepositories    *gitsync.Manager
+	ConfigProfiles     *configprofile.Manager
+	ShellBroker        *shell.Broker
+	AnomalyDetector    *anomaly.Detector
+	Impersonator       *auth.Impersonator
+	TokenIssuer        *auth.TokenIssuer
+	Remediation        *remediation.Manager
+	InboundTriggers    *inbound.Manager
+	TenantDatabases    *db.TenantRouter
+	AuditFields        *audit.FieldRegistry
+	Vulnerabilities    *vulnerability.Manager
+	Catalog            *catalog.Manager
+	Overview           *overview.Reporter
+	BreakGlass         *breakglass.Manager
+	Schedules          *schedule.Manager
 }
 
 // NewServer creates a new HTTP server
@@ -75,8 +125,17 @@ func NewServer(config *ServerConfig, deps *Dependencies) *Server {
 	}
 
 	router := gin.New()
-	router.Use(gin.Recovery())
+	router.Use(RequestID())
+	router.Use(gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
+		writeAPIError(c, http.StatusInternalServerError, ErrCodeInternal, internalErrorMessage, nil)
+	}))
 	router.Use(RequestLogger(deps.Logger))
+	if deps.APIAuditor != nil {
+		router.Use(deps.APIAuditor.Middleware())
+	}
+	router.NoRoute(func(c *gin.Context) {
+		writeAPIError(c, http.StatusNotFound, ErrCodeNotFound, "route not found", nil)
+	})
 
 	if len(config.TrustedProxies) > 0 {
 		router.SetTrustedProxies(config.TrustedProxies)
@@ -87,10 +146,32 @@ func NewServer(config *ServerConfig, deps *Dependencies) *Server {
 		deps.TenantManager,
 		deps.AgentRegistry,
 		deps.AgentRegistrar,
+		deps.KeyManager,
+		deps.InstallScripts,
 		deps.WorkflowManager,
+		deps.WorkflowExecutor,
 		deps.CampaignManager,
 		deps.TemplateManager,
+		deps.PortabilityManager,
 		deps.AuditLogger,
+		deps.OutputIndexer,
+		deps.ExecutionWatchdog,
+		deps.Analyzer,
+		deps.GitRepositories,
+		deps.ConfigProfiles,
+		deps.ShellBroker,
+		deps.AnomalyDetector,
+		deps.Impersonator,
+		deps.TokenIssuer,
+		deps.Remediation,
+		deps.InboundTriggers,
+		deps.TenantDatabases,
+		deps.AuditFields,
+		deps.Vulnerabilities,
+		deps.Catalog,
+		deps.Overview,
+		deps.BreakGlass,
+		deps.Schedules,
 	)
 
 	s := &Server{
@@ -100,8 +181,11 @@ func NewServer(config *ServerConfig, deps *Dependencies) *Server {
 		router:   router,
 		handlers: handlers,
 		jwtAuth:  deps.JWTAuth,
+
+		impersonator: deps.Impersonator,
 	}
 
+	router.Use(s.rejectWritesWhileDraining())
 	s.setupRoutes()
 
 	return s
@@ -112,6 +196,12 @@ func (s *Server) setupRoutes() {
 	// Health checks (no auth)
 	s.router.GET("/health", s.handlers.HealthCheck)
 	s.router.GET("/ready", s.handlers.Readiness)
+	s.router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// Embedded dashboard (no auth; it calls the API with the user's token)
+	if !s.config.DisableUI {
+		ui.Register(s.router)
+	}
 
 	// API v1 routes
 	v1 := s.router.Group("/api/v1")
@@ -120,20 +210,40 @@ func (s *Server) setupRoutes() {
 	public := v1.Group("")
 	{
 		public.POST("/agents/register", s.handlers.RegisterAgent)
+		public.POST("/callbacks/:execution_id/:callback_id", s.handlers.ConfirmCallback)
+		public.POST("/triggers/:trigger_id", s.handlers.InvokeInboundTrigger)
 	}
 
+	// Every authenticated route requires a scope. Write tokens may also
+	// run workflows and execute tokens read what they run. Agent scopes are
+	// only granted on the signed agent routes, which act on the token's own
+	// agent; agent tokens cannot reach the user routes.
+	read := auth.RequireScope(auth.ScopeRead)
+	execute := auth.RequireScope(auth.ScopeExecute)
+	write := auth.RequireScope(auth.ScopeWrite)
+	heartbeat := auth.RequireScope(auth.ScopeAgentHeartbeat)
+	healthReport := auth.RequireScope(auth.ScopeAgentHealth)
+	agentConfig := auth.RequireScope(auth.ScopeAgentConfig)
+
 	// Agent routes (agent auth)
 	agentRoutes := v1.Group("/agent")
 	agentRoutes.Use(auth.AuthMiddleware(s.jwtAuth))
 	agentRoutes.Use(auth.RequireTokenType("agent"))
+	agentRoutes.Use(auth.RequireAgentSignature(s.db))
 	{
-		agentRoutes.POST("/heartbeat", s.handlers.AgentHeartbeat)
-		agentRoutes.POST("/health", s.handlers.AgentHealthReport)
+		agentRoutes.POST("/heartbeat", heartbeat, s.handlers.AgentHeartbeat)
+		agentRoutes.POST("/token/refresh", heartbeat, s.handlers.RefreshAgentToken)
+		agentRoutes.POST("/health", healthReport, s.handlers.AgentHealthReport)
+		agentRoutes.POST("/software", healthReport, s.handlers.AgentSoftwareReport)
+		agentRoutes.POST("/executions/results", auth.RequireScope(auth.ScopeAgentResults), s.handlers.AgentExecutionResult)
+		agentRoutes.GET("/config", agentConfig, s.handlers.GetAgentConfig)
+		agentRoutes.GET("/templates/:template_id/content", agentConfig, s.handlers.GetTemplateContent)
 	}
 
 	// Authenticated routes
 	authenticated := v1.Group("")
 	authenticated.Use(auth.AuthMiddleware(s.jwtAuth))
+	authenticated.Use(s.verifyImpersonation())
 	{
 		// Tenant routes (admin only)
 		tenants := authenticated.Group("/tenants")
@@ -143,50 +253,271 @@ func (s *Server) setupRoutes() {
 			tenants.POST("", s.handlers.CreateTenant)
 			tenants.GET("/:tenant_id", s.handlers.GetTenant)
 			tenants.PUT("/:tenant_id", s.handlers.UpdateTenant)
+			tenants.DELETE("/:tenant_id", s.handlers.DeleteTenant)
+			tenants.GET("/:tenant_id/usage", s.handlers.GetTenantUsage)
+			tenants.GET("/:tenant_id/installation-keys", s.handlers.ListInstallationKeys)
+			tenants.POST("/:tenant_id/installation-keys", s.handlers.CreateInstallationKey)
+			tenants.GET("/:tenant_id/installation-keys/:key_id", s.handlers.GetInstallationKey)
+			tenants.DELETE("/:tenant_id/installation-keys/:key_id", s.handlers.DeleteInstallationKey)
+			tenants.GET("/:tenant_id/install-script", s.handlers.GetInstallScript)
+			tenants.POST("/:tenant_id/tokens", s.handlers.IssueTenantToken)
+			tenants.GET("/:tenant_id/export", s.handlers.ExportTenant)
+			tenants.POST("/:tenant_id/import", s.handlers.ImportTenant)
+			tenants.POST("/:tenant_id/database", s.handlers.RegisterTenantDatabase)
+			tenants.GET("/:tenant_id/database", s.handlers.GetTenantDatabase)
+			tenants.POST("/:tenant_id/database/migrate", s.handlers.MigrateTenantDatabase)
+			tenants.GET("/:tenant_id/break-glass", s.handlers.ListBreakGlassSessions)
+			tenants.POST("/:tenant_id/break-glass", s.handlers.EnableBreakGlass)
+			tenants.DELETE("/:tenant_id/break-glass/:session_id", s.handlers.RevokeBreakGlass)
+		}
+
+		// Databases registered for tenants
+		tenantDatabases := authenticated.Group("/tenant-databases")
+		tenantDatabases.Use(auth.RequireScope("admin"))
+		{
+			tenantDatabases.GET("", s.handlers.ListTenantDatabases)
+			tenantDatabases.POST("/migrate", s.handlers.MigrateTenantDatabases)
+		}
+
+		// Platform-wide views for operators, across tenants
+		admin := authenticated.Group("/admin")
+		admin.Use(auth.RequireScope("admin"))
+		{
+			admin.GET("/overview", s.handlers.GetAdminOverview)
 		}
 
 		// Agent management routes
 		agents := authenticated.Group("/agents")
 		{
-			agents.GET("", s.handlers.ListAgents)
-			agents.GET("/:agent_id", s.handlers.GetAgent)
-			agents.POST("/:agent_id/heartbeat", s.handlers.AgentHeartbeat)
-			agents.POST("/:agent_id/health", s.handlers.AgentHealthReport)
+			agents.GET("", read, s.handlers.ListAgents)
+			agents.GET("/export", read, s.handlers.ExportAgents)
+			agents.GET("/health", read, s.handlers.GetFleetHealth)
+			agents.GET("/registration-policy", read, s.handlers.GetRegistrationPolicy)
+			agents.PUT("/registration-policy", auth.RequireScope("admin"), s.handlers.SetRegistrationPolicy)
+			agents.POST("/approve", auth.RequireScope("admin"), s.handlers.ApproveAgents)
+			agents.POST("/reject", auth.RequireScope("admin"), s.handlers.RejectAgents)
+			agents.GET("/:agent_id", read, s.handlers.GetAgent)
+			agents.POST("/:agent_id/heartbeat", write, s.handlers.AgentHeartbeat)
+			agents.POST("/:agent_id/health", write, s.handlers.AgentHealthReport)
+			agents.GET("/:agent_id/health/history", read, s.handlers.GetAgentHealthHistory)
+			agents.GET("/:agent_id/software", read, s.handlers.ListAgentSoftware)
+			agents.GET("/:agent_id/software/history", read, s.handlers.GetAgentSoftwareHistory)
+			agents.GET("/:agent_id/vulnerabilities", read, s.handlers.ListVulnerabilities)
+			agents.POST("/:agent_id/drain", write, s.handlers.DrainAgent)
+			agents.POST("/:agent_id/undrain", write, s.handlers.UndrainAgent)
+			agents.POST("/:agent_id/reset-identity", auth.RequireScope("admin"), s.handlers.ResetAgentIdentity)
+			agents.POST("/:agent_id/approve", auth.RequireScope("admin"), s.handlers.ApproveAgent)
+			agents.POST("/:agent_id/reject", auth.RequireScope("admin"), s.handlers.RejectAgent)
+			agents.GET("/:agent_id/config", read, s.handlers.GetAgentConfig)
+			agents.GET("/:agent_id/config/history", read, s.handlers.ListAgentConfigRollouts)
+			agents.PUT("/:agent_id/config-profile", auth.RequireScope("admin"), s.handlers.AssignAgentConfigProfile)
+			agents.DELETE("/:agent_id/config-profile", auth.RequireScope("admin"), s.handlers.UnassignAgentConfigProfile)
+			agents.GET("/:agent_id/shell", auth.RequireScope("admin"), s.handlers.OpenAgentShell)
 		}
 
+		authenticated.POST("/fleet/query", read, s.handlers.QueryFleet)
+
 		// Workflow routes
 		workflows := authenticated.Group("/workflows")
 		{
-			workflows.GET("", s.handlers.ListWorkflows)
-			workflows.POST("", s.handlers.CreateWorkflow)
-			workflows.GET("/:workflow_id", s.handlers.GetWorkflow)
-			workflows.PUT("/:workflow_id", s.handlers.UpdateWorkflow)
-			workflows.DELETE("/:workflow_id", s.handlers.DeleteWorkflow)
+			workflows.GET("", read, s.handlers.ListWorkflows)
+			workflows.POST("", write, s.handlers.CreateWorkflow)
+			workflows.GET("/policy", read, s.handlers.GetWorkflowPolicy)
+			workflows.PUT("/policy", auth.RequireScope("admin"), s.handlers.SetWorkflowPolicy)
+			workflows.POST("/lint", read, s.handlers.LintWorkflowDefinition)
+			workflows.GET("/triggers", read, s.handlers.ListWorkflowTriggers)
+			workflows.POST("/triggers", write, s.handlers.CreateWorkflowTrigger)
+			workflows.GET("/triggers/graph", read, s.handlers.GetWorkflowTriggerGraph)
+			workflows.PUT("/triggers/:trigger_id", write, s.handlers.UpdateWorkflowTrigger)
+			workflows.DELETE("/triggers/:trigger_id", write, s.handlers.DeleteWorkflowTrigger)
+			workflows.GET("/:workflow_id", read, s.handlers.GetWorkflow)
+			workflows.GET("/:workflow_id/definitions/:hash", read, s.handlers.GetWorkflowDefinition)
+			workflows.GET("/:workflow_id/lint", read, s.handlers.LintWorkflow)
+			workflows.GET("/:workflow_id/hooks", read, s.handlers.ListWorkflowHooks)
+			workflows.POST("/:workflow_id/hooks", auth.RequireScope("admin"), s.handlers.CreateWorkflowHook)
+			workflows.GET("/:workflow_id/hooks/:hook_id", read, s.handlers.GetWorkflowHook)
+			workflows.PUT("/:workflow_id/hooks/:hook_id", auth.RequireScope("admin"), s.handlers.UpdateWorkflowHook)
+			workflows.DELETE("/:workflow_id/hooks/:hook_id", auth.RequireScope("admin"), s.handlers.DeleteWorkflowHook)
+			workflows.PUT("/:workflow_id", write, s.handlers.UpdateWorkflow)
+			workflows.DELETE("/:workflow_id", write, s.handlers.DeleteWorkflow)
+		}
+
+		// Execution routes
+		executions := authenticated.Group("/executions")
+		{
+			executions.GET("", read, s.handlers.ListExecutions)
+			executions.POST("/adhoc", execute, s.handlers.ExecuteAdhoc)
+			executions.GET("/search", read, s.handlers.SearchExecutionOutputs)
+			executions.GET("/stuck", auth.RequireScope("admin"), s.handlers.ListStuckExecutions)
+			executions.POST("/stuck/resolve", auth.RequireScope("admin"), s.handlers.ResolveStuckExecutions)
+			executions.GET("/dispatch-jobs", read, s.handlers.ListDispatchJobs)
+			executions.POST("/dispatch-jobs/:job_id/requeue", auth.RequireScope("admin"), s.handlers.RequeueDispatchJob)
+			executions.GET("/:execution_id", read, s.handlers.GetExecution)
+			executions.GET("/:execution_id/hooks", read, s.handlers.ListExecutionHookRuns)
+			executions.POST("/:execution_id/pause", execute, s.handlers.PauseExecution)
+			executions.POST("/:execution_id/resume", execute, s.handlers.ResumeExecution)
+		}
+
+		// Audit routes
+		auditRoutes := authenticated.Group("/audit")
+		{
+			auditRoutes.GET("/events", read, s.handlers.SearchAuditEvents)
+			auditRoutes.POST("/events", write, s.handlers.IngestAuditEvent)
+			auditRoutes.GET("/fields", read, s.handlers.ListAuditFields)
+			auditRoutes.POST("/fields", auth.RequireScope("admin"), s.handlers.CreateAuditField)
+			auditRoutes.GET("/fields/:name", read, s.handlers.GetAuditField)
+			auditRoutes.PUT("/fields/:name", auth.RequireScope("admin"), s.handlers.UpdateAuditField)
+			auditRoutes.DELETE("/fields/:name", auth.RequireScope("admin"), s.handlers.RetireAuditField)
+			auditRoutes.GET("/verify", auth.RequireScope("admin"), s.handlers.VerifyAuditChain)
+			auditRoutes.GET("/export", auth.RequireScope("admin"), s.handlers.ExportAuditBundle)
+			auditRoutes.GET("/anomalies", auth.RequireScope("admin"), s.handlers.ListAuditAnomalies)
+			auditRoutes.GET("/signing-key", read, s.handlers.GetAuditSigningKey)
 		}
 
 		// Campaign routes
 		campaigns := authenticated.Group("/campaigns")
 		{
-			campaigns.GET("", s.handlers.ListCampaigns)
-			campaigns.POST("", s.handlers.CreateCampaign)
-			campaigns.GET("/:campaign_id", s.handlers.GetCampaign)
-			campaigns.POST("/:campaign_id/start", s.handlers.StartCampaign)
-			campaigns.POST("/:campaign_id/pause", s.handlers.PauseCampaign)
-			campaigns.POST("/:campaign_id/cancel", s.handlers.CancelCampaign)
-			campaigns.GET("/:campaign_id/progress", s.handlers.GetCampaignProgress)
+			campaigns.GET("", read, s.handlers.ListCampaigns)
+			campaigns.POST("", execute, s.handlers.CreateCampaign)
+			campaigns.GET("/:campaign_id", read, s.handlers.GetCampaign)
+			campaigns.POST("/:campaign_id/start", execute, s.handlers.StartCampaign)
+			campaigns.POST("/:campaign_id/pause", execute, s.handlers.PauseCampaign)
+			campaigns.POST("/:campaign_id/cancel", execute, s.handlers.CancelCampaign)
+			campaigns.POST("/:campaign_id/phases/:phase/approve", execute, s.handlers.ApproveCampaignPhase)
+			campaigns.POST("/:campaign_id/zones/:zone/exclude", execute, s.handlers.ExcludeCampaignZone)
+			campaigns.GET("/:campaign_id/progress", read, s.handlers.GetCampaignProgress)
+			campaigns.GET("/:campaign_id/timeline", read, s.handlers.GetCampaignTimeline)
+			campaigns.GET("/:campaign_id/readiness", read, s.handlers.GetCampaignReadiness)
+			campaigns.GET("/:campaign_id/compare", read, s.handlers.CompareCampaigns)
+		}
+
+		// Declarative campaign documents
+		authenticated.POST("/apply", write, s.handlers.Apply)
+
+		// Analytics routes
+		analyticsRoutes := authenticated.Group("/analytics")
+		{
+			analyticsRoutes.GET("/workflows", read, s.handlers.GetWorkflowAnalytics)
+			analyticsRoutes.POST("/simulate", read, s.handlers.SimulateWorkflow)
 		}
 
 		// Template routes (Salt Stack-like template management)
 		templates := authenticated.Group("/templates")
 		{
-			templates.GET("", s.handlers.ListTemplates)
-			templates.POST("", s.handlers.CreateTemplate)
-			templates.GET("/:template_id", s.handlers.GetTemplate)
-			templates.GET("/:template_id/content", s.handlers.GetTemplateContent)
-			templates.PUT("/:template_id", s.handlers.UpdateTemplate)
-			templates.DELETE("/:template_id", s.handlers.DeleteTemplate)
-			templates.GET("/:template_id/versions", s.handlers.GetTemplateVersions)
-			templates.POST("/:template_id/activate", s.handlers.ActivateTemplate)
+			templates.GET("", read, s.handlers.ListTemplates)
+			templates.POST("", write, s.handlers.CreateTemplate)
+			templates.GET("/history", read, s.handlers.GetTemplatePathHistory)
+			templates.GET("/:template_id", read, s.handlers.GetTemplate)
+			templates.GET("/:template_id/content", read, s.handlers.GetTemplateContent)
+			templates.PUT("/:template_id", write, s.handlers.UpdateTemplate)
+			templates.PUT("/:template_id/content", write, s.handlers.UploadTemplateContent)
+			templates.DELETE("/:template_id", write, s.handlers.DeleteTemplate)
+			templates.GET("/:template_id/versions", read, s.handlers.GetTemplateVersions)
+			templates.GET("/:template_id/versions/:from_version/diff/:to_version", read, s.handlers.DiffTemplateVersions)
+			templates.POST("/:template_id/activate", write, s.handlers.ActivateTemplate)
+			templates.POST("/:template_id/render", read, s.handlers.RenderTemplate)
+			templates.POST("/:template_id/lint", read, s.handlers.LintTemplate)
+		}
+
+		// Git repository routes (workflows and templates synced from Git)
+		gitRepositories := authenticated.Group("/git-repositories")
+		{
+			gitRepositories.GET("", read, s.handlers.ListGitRepositories)
+			gitRepositories.POST("", auth.RequireScope("admin"), s.handlers.CreateGitRepository)
+			gitRepositories.GET("/:repository_id", read, s.handlers.GetGitRepository)
+			gitRepositories.PUT("/:repository_id", auth.RequireScope("admin"), s.handlers.UpdateGitRepository)
+			gitRepositories.DELETE("/:repository_id", auth.RequireScope("admin"), s.handlers.DeleteGitRepository)
+			gitRepositories.POST("/:repository_id/sync", write, s.handlers.SyncGitRepository)
+		}
+
+		// Agent configuration profile routes
+		configProfiles := authenticated.Group("/config-profiles")
+		{
+			configProfiles.GET("", read, s.handlers.ListConfigProfiles)
+			configProfiles.POST("", auth.RequireScope("admin"), s.handlers.CreateConfigProfile)
+			configProfiles.GET("/:profile_id", read, s.handlers.GetConfigProfile)
+			configProfiles.PUT("/:profile_id", auth.RequireScope("admin"), s.handlers.UpdateConfigProfile)
+			configProfiles.DELETE("/:profile_id", auth.RequireScope("admin"), s.handlers.DeleteConfigProfile)
+			configProfiles.GET("/:profile_id/rollout", read, s.handlers.GetConfigProfileRollout)
+		}
+
+		// Remediation workflows run on agents reporting matching health
+		remediationRoutes := authenticated.Group("/remediation")
+		{
+			remediationRoutes.GET("/rules", read, s.handlers.ListRemediationRules)
+			remediationRoutes.POST("/rules", auth.RequireScope("admin"), s.handlers.CreateRemediationRule)
+			remediationRoutes.GET("/rules/:rule_id", read, s.handlers.GetRemediationRule)
+			remediationRoutes.PUT("/rules/:rule_id", auth.RequireScope("admin"), s.handlers.UpdateRemediationRule)
+			remediationRoutes.DELETE("/rules/:rule_id", auth.RequireScope("admin"), s.handlers.DeleteRemediationRule)
+			remediationRoutes.GET("/runs", read, s.handlers.ListRemediationRuns)
+			remediationRoutes.PUT("/paused", auth.RequireScope("admin"), s.handlers.SetRemediationPaused)
+		}
+
+		// Inbound triggers let external systems start workflows; they are
+		// invoked on the public route above
+		inboundTriggers := authenticated.Group("/triggers")
+		{
+			inboundTriggers.GET("", read, s.handlers.ListInboundTriggers)
+			inboundTriggers.POST("", auth.RequireScope("admin"), s.handlers.CreateInboundTrigger)
+			inboundTriggers.GET("/:trigger_id", read, s.handlers.GetInboundTrigger)
+			inboundTriggers.PUT("/:trigger_id", auth.RequireScope("admin"), s.handlers.UpdateInboundTrigger)
+			inboundTriggers.DELETE("/:trigger_id", auth.RequireScope("admin"), s.handlers.DeleteInboundTrigger)
+			inboundTriggers.POST("/:trigger_id/secret", auth.RequireScope("admin"), s.handlers.RotateInboundTriggerSecret)
+			inboundTriggers.GET("/:trigger_id/invocations", read, s.handlers.ListInboundTriggerInvocations)
+		}
+
+		// Schedules run workflows by cron, in a fixed timezone or each
+		// agent's local one
+		schedules := authenticated.Group("/schedules")
+		{
+			schedules.GET("", read, s.handlers.ListSchedules)
+			schedules.POST("", execute, s.handlers.CreateSchedule)
+			schedules.GET("/:schedule_id", read, s.handlers.GetSchedule)
+			schedules.PUT("/:schedule_id", execute, s.handlers.UpdateSchedule)
+			schedules.DELETE("/:schedule_id", execute, s.handlers.DeleteSchedule)
+			schedules.GET("/:schedule_id/runs", read, s.handlers.ListScheduleAgentRuns)
+		}
+
+		// Vulnerability findings from matching software inventory against
+		// advisories
+		vulnerabilities := authenticated.Group("/vulnerabilities")
+		{
+			vulnerabilities.GET("", read, s.handlers.ListVulnerabilities)
+			vulnerabilities.GET("/:cve_id", read, s.handlers.GetAdvisory)
+			vulnerabilities.GET("/:cve_id/agents", read, s.handlers.ListAffectedAgents)
+			vulnerabilities.POST("/:cve_id/patch-campaign", execute, s.handlers.CreatePatchCampaign)
+		}
+
+		// Workflows and templates the platform tenant publishes to every
+		// tenant
+		catalogRoutes := authenticated.Group("/catalog")
+		{
+			catalogRoutes.GET("", read, s.handlers.ListCatalogEntries)
+			catalogRoutes.POST("", auth.RequireScope("admin"), s.handlers.PublishCatalogEntry)
+			catalogRoutes.GET("/copies", read, s.handlers.ListCatalogCopies)
+			catalogRoutes.POST("/copies/:copy_id/upgrade", write, s.handlers.UpgradeCatalogCopy)
+			catalogRoutes.GET("/:entry_id", read, s.handlers.GetCatalogEntry)
+			catalogRoutes.POST("/:entry_id/withdraw", auth.RequireScope("admin"), s.handlers.WithdrawCatalogEntry)
+			catalogRoutes.POST("/:entry_id/instantiate", write, s.handlers.InstantiateCatalogEntry)
+		}
+
+		// Live shell session records (admin only; recordings hold everything
+		// typed and printed)
+		shellSessions := authenticated.Group("/shell-sessions")
+		shellSessions.Use(auth.RequireScope("admin"))
+		{
+			shellSessions.GET("", s.handlers.ListShellSessions)
+			shellSessions.GET("/:session_id", s.handlers.GetShellSession)
+			shellSessions.GET("/:session_id/recording", s.handlers.GetShellSessionRecording)
+		}
+
+		// Platform admins operating as a tenant
+		impersonations := authenticated.Group("/impersonations")
+		impersonations.Use(auth.RequireScope("admin"))
+		{
+			impersonations.POST("", s.handlers.StartImpersonation)
+			impersonations.GET("", s.handlers.ListImpersonations)
+			impersonations.DELETE("/:session_id", s.handlers.EndImpersonation)
 		}
 	}
 }
@@ -214,6 +545,9 @@ func (s *Server) Start() error {
 // Shutdown gracefully shuts down the server
 func (s *Server) Shutdown(ctx context.Context) error {
 	s.logger.Info("shutting down HTTP server")
+	if s.server == nil {
+		return nil
+	}
 
 	shutdownCtx, cancel := context.WithTimeout(ctx, s.config.ShutdownTimeout)
 	defer cancel()
@@ -221,11 +555,106 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	return s.server.Shutdown(shutdownCtx)
 }
 
+// StartDraining stops the server accepting API writes. Agents can still
+// report heartbeats and execution results so in-flight work completes.
+func (s *Server) StartDraining() {
+	s.draining.Store(true)
+}
+
+// verifyImpersonation returns a middleware that rejects impersonation
+// tokens whose session has ended and marks the audit events of the others
+// with the impersonating admin
+func (s *Server) verifyImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := auth.GetClaimsFromGin(c)
+		if claims == nil || !claims.Impersonating() {
+			c.Next()
+			return
+		}
+		if s.impersonator == nil {
+			writeAPIError(c, http.StatusUnauthorized, ErrCodeUnauthorized, "impersonation is not enabled", nil)
+			return
+		}
+
+		ctx := c.Request.Context()
+		if err := s.impersonator.Verify(ctx, claims); err != nil {
+			writeError(c, err)
+			return
+		}
+
+		c.Request = c.Request.WithContext(audit.WithImpersonation(ctx, audit.Impersonation{
+			AdminID:   claims.ImpersonatedBy,
+			SessionID: claims.ImpersonationID,
+		}))
+		c.Next()
+	}
+}
+
+// rejectWritesWhileDraining returns a middleware that, once the server is
+// draining, fails readiness and rejects writes outside the agent routes
+func (s *Server) rejectWritesWhileDraining() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.draining.Load() {
+			c.Next()
+			return
+		}
+
+		path := c.Request.URL.Path
+		if path == "/ready" {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"ready": false})
+			return
+		}
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		if strings.HasPrefix(path, "/api/v1/agent/") {
+			c.Next()
+			return
+		}
+
+		c.Header("Retry-After", "5")
+		writeAPIError(c, http.StatusServiceUnavailable, ErrCodeUnavailable, "control plane is shutting down", nil)
+	}
+}
+
 // Router returns the gin router for testing
 func (s *Server) Router() *gin.Engine {
 	return s.router
 }
 
+// RequestIDHeader is the header carrying the request ID
+const RequestIDHeader = tracing.Header
+
+// requestIDKey is the gin context key for the request ID
+const requestIDKey = "request_id"
+
+// RequestID returns a gin middleware that assigns each request an ID. A
+// well-formed X-Request-ID from the client is kept, otherwise a UUID is
+// generated. The ID is echoed in the response header and error bodies, and
+// the request context carries it to the executions and audit events the
+// request causes.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !tracing.ValidRequestID(requestID) {
+			requestID = tracing.NewRequestID()
+		}
+
+		c.Set(requestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+		c.Request = c.Request.WithContext(tracing.WithRequestID(c.Request.Context(), requestID))
+
+		c.Next()
+	}
+}
+
+// GetRequestID returns the request ID assigned by the RequestID middleware
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
 // RequestLogger returns a gin middleware for logging requests
 func RequestLogger(logger *zap.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -239,6 +668,7 @@ func RequestLogger(logger *zap.Logger) gin.HandlerFunc {
 		status := c.Writer.Status()
 
 		fields := []zap.Field{
+			zap.String("request_id", GetRequestID(c)),
 			zap.Int("status", status),
 			zap.String("method", c.Request.Method),
 			zap.String("path", path),
@@ -266,7 +696,8 @@ func CORS() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization, X-Request-ID")
+		c.Header("Access-Control-Expose-Headers", "X-Request-ID")
 		c.Header("Access-Control-Max-Age", "86400")
 
 		if c.Request.Method == "OPTIONS" {
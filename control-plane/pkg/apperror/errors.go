@@ -0,0 +1,121 @@
+// Package apperror provides classified domain errors shared by the control
+// plane packages, so transports can map them to stable codes and statuses.
+package apperror
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error kinds. Test for them with errors.Is.
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrInvalidInput  = errors.New("invalid input")
+	ErrInvalidState  = errors.New("invalid state")
+	ErrConflict      = errors.New("conflict")
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	ErrUnauthorized  = errors.New("unauthorized")
+	ErrForbidden     = errors.New("forbidden")
+	ErrRateLimited   = errors.New("rate limited")
+)
+
+// Error is a domain error of a given kind. Its message is safe to return to
+// API clients.
+type Error struct {
+	kind error
+	err  error
+}
+
+// Error returns the error message
+func (e *Error) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the error kind and any wrapped cause
+func (e *Error) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// Kind returns the error kind
+func (e *Error) Kind() error {
+	return e.kind
+}
+
+// New creates an error of the given kind. The format follows fmt.Errorf, so
+// %w may be used to wrap a cause.
+func New(kind error, format string, args ...interface{}) error {
+	return &Error{kind: kind, err: fmt.Errorf(format, args...)}
+}
+
+// NotFound creates an ErrNotFound error
+func NotFound(format string, args ...interface{}) error {
+	return New(ErrNotFound, format, args...)
+}
+
+// InvalidInput creates an ErrInvalidInput error
+func InvalidInput(format string, args ...interface{}) error {
+	return New(ErrInvalidInput, format, args...)
+}
+
+// InvalidState creates an ErrInvalidState error
+func InvalidState(format string, args ...interface{}) error {
+	return New(ErrInvalidState, format, args...)
+}
+
+// Conflict creates an ErrConflict error
+func Conflict(format string, args ...interface{}) error {
+	return New(ErrConflict, format, args...)
+}
+
+// QuotaExceeded creates an ErrQuotaExceeded error
+func QuotaExceeded(format string, args ...interface{}) error {
+	return New(ErrQuotaExceeded, format, args...)
+}
+
+// Unauthorized creates an ErrUnauthorized error
+func Unauthorized(format string, args ...interface{}) error {
+	return New(ErrUnauthorized, format, args...)
+}
+
+// Forbidden creates an ErrForbidden error
+func Forbidden(format string, args ...interface{}) error {
+	return New(ErrForbidden, format, args...)
+}
+
+// RateLimited creates an ErrRateLimited error
+func RateLimited(format string, args ...interface{}) error {
+	return New(ErrRateLimited, format, args...)
+}
+
+// KindOf returns the kind of the first classified error in err's chain, or
+// nil if err is not a domain error
+func KindOf(err error) error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr.kind
+	}
+	return nil
+}
+
+// StaleError is an ErrConflict error for an update made against an outdated
+// version of a resource. It carries the resource's current state so clients
+// can merge their changes and retry.
+type StaleError struct {
+	Current interface{}
+	err     error
+}
+
+// Error returns the error message
+func (e *StaleError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying conflict error
+func (e *StaleError) Unwrap() error {
+	return e.err
+}
+
+// Stale creates a StaleError holding the resource's current state
+func Stale(current interface{}, format string, args ...interface{}) error {
+	return &StaleError{Current: current, err: Conflict(format, args...)}
+}
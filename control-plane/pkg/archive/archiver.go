@@ -0,0 +1,278 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// Config configures execution archival
+type Config struct {
+	// After is how long after completing an execution's output is archived
+	After time.Duration `json:"after" yaml:"after"`
+	// Interval is how often archivable executions are looked for
+	Interval time.Duration `json:"interval" yaml:"interval"`
+	// BatchSize is the number of executions archived per pass
+	BatchSize int `json:"batch_size" yaml:"batch_size"`
+	// Prefix is prepended to object keys, e.g. "vm-manager/"
+	Prefix string `json:"prefix" yaml:"prefix"`
+}
+
+// DefaultConfig returns the default archival configuration
+func DefaultConfig() *Config {
+	return &Config{
+		After:     30 * 24 * time.Hour,
+		Interval:  time.Hour,
+		BatchSize: 100,
+	}
+}
+
+// archiveFormatVersion is the version of the archived document layout
+const archiveFormatVersion = 1
+
+// Document is the archived form of an execution's output
+type Document struct {
+	FormatVersion int            `json:"format_version"`
+	ExecutionID   string         `json:"execution_id"`
+	TenantID      string         `json:"tenant_id"`
+	WorkflowID    string         `json:"workflow_id"`
+	AgentID       string         `json:"agent_id"`
+	ArchivedAt    time.Time      `json:"archived_at"`
+	Result        models.JSONMap `json:"result,omitempty"`
+	Environment   models.JSONMap `json:"environment,omitempty"`
+}
+
+// Archiver compresses the result and environment of completed executions
+// into the object store. The database keeps the object key and a summary
+// of the result without step output, so listings and analytics still work.
+type Archiver struct {
+	db     *gorm.DB
+	store  Store
+	config *Config
+	logger *zap.Logger
+}
+
+// NewArchiver creates an execution archiver
+func NewArchiver(db *gorm.DB, store Store, config *Config, logger *zap.Logger) *Archiver {
+	defaults := DefaultConfig()
+	cfg := *config
+	if cfg.After <= 0 {
+		cfg.After = defaults.After
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaults.Interval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaults.BatchSize
+	}
+	return &Archiver{
+		db:     db,
+		store:  store,
+		config: &cfg,
+		logger: logger,
+	}
+}
+
+// Run archives executions every interval until the context is cancelled
+func (a *Archiver) Run(ctx context.Context) {
+	a.logger.Info("execution archiver started",
+		zap.String("store", a.store.String()),
+		zap.Duration("after", a.config.After))
+
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			archived, err := a.ArchiveBatch(ctx, time.Now().Add(-a.config.After))
+			if err != nil {
+				a.logger.Error("failed to archive executions", zap.Error(err))
+				break
+			}
+			if archived > 0 {
+				a.logger.Info("archived execution output", zap.Int("executions", archived))
+			}
+			// Keep going while there is a backlog
+			if archived < a.config.BatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ArchiveBatch archives up to one batch of executions completed before
+// cutoff and returns how many were archived
+func (a *Archiver) ArchiveBatch(ctx context.Context, cutoff time.Time) (int, error) {
+	var executions []models.WorkflowExecution
+	if err := a.db.WithContext(ctx).
+		Select("id", "tenant_id", "workflow_id", "agent_id", "status", "result", "environment", "completed_at").
+		Where("completed_at < ? AND archived_at IS NULL", cutoff).
+		Where("status IN ?", []models.ExecutionStatus{
+			models.ExecutionStatusSuccess,
+			models.ExecutionStatusFailed,
+			models.ExecutionStatusCancelled,
+			models.ExecutionStatusTimeout,
+		}).
+		Order("completed_at ASC").
+		Limit(a.config.BatchSize).
+		Find(&executions).Error; err != nil {
+		return 0, fmt.Errorf("failed to find archivable executions: %w", err)
+	}
+
+	archived := 0
+	for i := range executions {
+		if err := a.archive(ctx, &executions[i]); err != nil {
+			// A failing store fails the rest of the batch too
+			return archived, fmt.Errorf("execution %s: %w", executions[i].ID, err)
+		}
+		archived++
+	}
+	return archived, nil
+}
+
+// archive uploads one execution's output and replaces it with the pointer
+func (a *Archiver) archive(ctx context.Context, execution *models.WorkflowExecution) error {
+	now := time.Now().UTC()
+	data, err := encodeDocument(&Document{
+		FormatVersion: archiveFormatVersion,
+		ExecutionID:   execution.ID,
+		TenantID:      execution.TenantID,
+		WorkflowID:    execution.WorkflowID,
+		AgentID:       execution.AgentID,
+		ArchivedAt:    now,
+		Result:        execution.Result,
+		Environment:   execution.Environment,
+	})
+	if err != nil {
+		return err
+	}
+
+	key := a.objectKey(execution)
+	if err := a.store.Put(ctx, key, data); err != nil {
+		return err
+	}
+
+	// The upload is idempotent, so a concurrent archiver winning the update
+	// only leaves the same object written twice
+	res := a.db.WithContext(ctx).Model(&models.WorkflowExecution{}).
+		Where("id = ? AND archived_at IS NULL", execution.ID).
+		Updates(map[string]interface{}{
+			"result":      summarizeResult(execution.Result),
+			"environment": nil,
+			"archive_key": key,
+			"archived_at": now,
+		})
+	if res.Error != nil {
+		return fmt.Errorf("failed to record archive: %w", res.Error)
+	}
+	return nil
+}
+
+// objectKey returns the key an execution is archived under, grouped by
+// tenant and completion day
+func (a *Archiver) objectKey(execution *models.WorkflowExecution) string {
+	completed := time.Now().UTC()
+	if execution.CompletedAt != nil {
+		completed = execution.CompletedAt.UTC()
+	}
+	return a.config.Prefix + path.Join("executions", execution.TenantID, completed.Format("2006/01/02"), execution.ID+".json.gz")
+}
+
+// Restore replaces an archived execution's summary result and environment
+// with the archived originals
+func (a *Archiver) Restore(ctx context.Context, execution *models.WorkflowExecution) error {
+	if execution.ArchiveKey == nil {
+		return nil
+	}
+
+	data, err := a.store.Get(ctx, *execution.ArchiveKey)
+	if err != nil {
+		return err
+	}
+	doc, err := decodeDocument(data)
+	if err != nil {
+		return err
+	}
+	if doc.ExecutionID != execution.ID {
+		return fmt.Errorf("archive %s belongs to execution %s", *execution.ArchiveKey, doc.ExecutionID)
+	}
+
+	execution.Result = doc.Result
+	execution.Environment = doc.Environment
+	return nil
+}
+
+// summarizeResult returns the result kept in the database once archived:
+// everything but the environment and the steps' output
+func summarizeResult(result models.JSONMap) models.JSONMap {
+	summary := models.JSONMap{"archived": true}
+	for key, value := range result {
+		switch key {
+		case "environment":
+		case "steps":
+			steps, _ := value.([]interface{})
+			trimmed := make([]interface{}, 0, len(steps))
+			for _, raw := range steps {
+				step, ok := raw.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				kept := make(map[string]interface{}, len(step))
+				for k, v := range step {
+					if k != "output" {
+						kept[k] = v
+					}
+				}
+				trimmed = append(trimmed, kept)
+			}
+			summary["steps"] = trimmed
+		default:
+			summary[key] = value
+		}
+	}
+	return summary
+}
+
+func encodeDocument(doc *Document) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(doc); err != nil {
+		return nil, fmt.Errorf("failed to encode archive: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress archive: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeDocument(data []byte) (*Document, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress archive: %w", err)
+	}
+	defer zr.Close()
+
+	var doc Document
+	if err := json.NewDecoder(zr).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode archive: %w", err)
+	}
+	if doc.FormatVersion != archiveFormatVersion {
+		return nil, fmt.Errorf("unsupported archive format version %d", doc.FormatVersion)
+	}
+	return &doc, nil
+}
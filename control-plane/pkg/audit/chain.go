@@ -0,0 +1,267 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// genesisHash is the previous hash of the first event of a chain
+var genesisHash = hex.EncodeToString(make([]byte, sha256.Size))
+
+// verifyPageSize is the number of events read per search while verifying
+const verifyPageSize = 1000
+
+// indexingGrace is how long after the chain head last moved trailing events
+// may still be missing because the index has not committed them yet
+const indexingGrace = time.Minute
+
+// Chain links each tenant's audit events into a hash chain: every event
+// records its sequence number, the hash of the previous event and its own
+// hash, so altered, removed or reordered events are detectable. The chain
+// head of each tenant is kept in the database.
+type Chain struct {
+	db *gorm.DB
+	mu sync.Mutex
+}
+
+// NewChain creates a chain backed by the audit_chain_heads table
+func NewChain(db *gorm.DB) *Chain {
+	return &Chain{db: db}
+}
+
+// Link assigns the event the next position in its tenant's chain and sets
+// its hash
+func (c *Chain) Link(ctx context.Context, event *AuditEvent) error {
+	// Hashes cover the timestamp as the index returns it
+	event.Timestamp = event.Timestamp.UTC().Truncate(time.Millisecond)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var head models.AuditChainHead
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("tenant_id = ?", event.TenantID).
+			First(&head).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			head = models.AuditChainHead{TenantID: event.TenantID, LastHash: genesisHash}
+		} else if err != nil {
+			return fmt.Errorf("failed to load audit chain head: %w", err)
+		}
+
+		event.Sequence = head.Sequence + 1
+		event.PrevHash = head.LastHash
+		hash, err := EventHash(event)
+		if err != nil {
+			return err
+		}
+		event.Hash = hash
+
+		head.Sequence = event.Sequence
+		head.LastHash = hash
+		head.UpdatedAt = time.Now()
+		if err := tx.Save(&head).Error; err != nil {
+			return fmt.Errorf("failed to advance audit chain head: %w", err)
+		}
+		return nil
+	})
+}
+
+// Head returns the tenant's chain head, or nil when it has no chained events
+func (c *Chain) Head(ctx context.Context, tenantID string) (*models.AuditChainHead, error) {
+	var head models.AuditChainHead
+	err := c.db.WithContext(ctx).Where("tenant_id = ?", tenantID).First(&head).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load audit chain head: %w", err)
+	}
+	return &head, nil
+}
+
+// EventHash returns the hex SHA-256 of an event's JSON encoding without its
+// own hash. The encoding includes the previous hash and sequence. It is
+// canonicalised with sorted object keys, so metadata hashes the same before
+// indexing and after being read back.
+func EventHash(event *AuditEvent) (string, error) {
+	unhashed := *event
+	unhashed.Hash = ""
+	unhashed.Timestamp = unhashed.Timestamp.UTC()
+
+	data, err := json.Marshal(&unhashed)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit event: %w", err)
+	}
+
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return "", fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	if data, err = json.Marshal(doc); err != nil {
+		return "", fmt.Errorf("failed to encode audit event: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ChainProblem is an integrity failure found while verifying a chain
+type ChainProblem struct {
+	Sequence int64  `json:"sequence"`
+	EventID  string `json:"event_id,omitempty"`
+	Problem  string `json:"problem"`
+}
+
+// ChainVerification is the result of checking a tenant's chain over a time
+// range
+type ChainVerification struct {
+	TenantID      string         `json:"tenant_id"`
+	Since         *time.Time     `json:"since,omitempty"`
+	Until         *time.Time     `json:"until,omitempty"`
+	Valid         bool           `json:"valid"`
+	Events        int            `json:"events"`
+	Unchained     int            `json:"unchained"` // Events logged before chaining was enabled
+	FirstSequence int64          `json:"first_sequence,omitempty"`
+	LastSequence  int64          `json:"last_sequence,omitempty"`
+	LastHash      string         `json:"last_hash,omitempty"`
+	HeadSequence  int64          `json:"head_sequence"`
+	Pending       int64          `json:"pending,omitempty"` // Recent events not yet searchable
+	Problems      []ChainProblem `json:"problems,omitempty"`
+	VerifiedAt    time.Time      `json:"verified_at"`
+}
+
+// verifyEvents checks events, sorted by sequence, link to each other.
+// Sequence gaps mean events were removed from the index.
+func verifyEvents(v *ChainVerification, events []AuditEvent) {
+	var prev *AuditEvent
+	for i := range events {
+		e := &events[i]
+		if e.Sequence == 0 {
+			v.Unchained++
+			continue
+		}
+		v.Events++
+		if v.FirstSequence == 0 {
+			v.FirstSequence = e.Sequence
+		}
+
+		hash, err := EventHash(e)
+		if err != nil || hash != e.Hash {
+			v.Problems = append(v.Problems, ChainProblem{Sequence: e.Sequence, EventID: e.ID, Problem: "event content does not match its hash"})
+		}
+
+		if e.Sequence == 1 && e.PrevHash != genesisHash {
+			v.Problems = append(v.Problems, ChainProblem{Sequence: e.Sequence, EventID: e.ID, Problem: "first event does not start the chain"})
+		}
+		if prev != nil {
+			switch {
+			case e.Sequence == prev.Sequence:
+				v.Problems = append(v.Problems, ChainProblem{Sequence: e.Sequence, EventID: e.ID, Problem: "duplicate sequence number"})
+			case e.Sequence > prev.Sequence+1:
+				v.Problems = append(v.Problems, ChainProblem{
+					Sequence: prev.Sequence + 1,
+					Problem:  fmt.Sprintf("%d events missing before sequence %d", e.Sequence-prev.Sequence-1, e.Sequence),
+				})
+			case e.PrevHash != prev.Hash:
+				v.Problems = append(v.Problems, ChainProblem{Sequence: e.Sequence, EventID: e.ID, Problem: "previous hash does not match the preceding event"})
+			}
+		}
+
+		prev = e
+		v.LastSequence = e.Sequence
+		v.LastHash = e.Hash
+	}
+}
+
+// VerifyChain checks the integrity of a tenant's audit chain between since
+// and until, which may be nil for an open range. Pending batched events are
+// flushed first. When the range is open-ended the last indexed event must
+// also be the chain head, otherwise trailing events were removed.
+func (l *Logger) VerifyChain(ctx context.Context, tenantID string, since, until *time.Time) (*ChainVerification, error) {
+	if l.chain == nil {
+		return nil, apperror.InvalidState("audit hash chaining is not enabled")
+	}
+	if err := l.Flush(ctx); err != nil {
+		return nil, fmt.Errorf("failed to flush pending audit events: %w", err)
+	}
+
+	events, err := l.chainEvents(ctx, tenantID, since, until)
+	if err != nil {
+		return nil, err
+	}
+	return l.verify(ctx, tenantID, since, until, events)
+}
+
+// verify checks events against each other and the chain head
+func (l *Logger) verify(ctx context.Context, tenantID string, since, until *time.Time, events []AuditEvent) (*ChainVerification, error) {
+	head, err := l.chain.Head(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &ChainVerification{
+		TenantID:   tenantID,
+		Since:      since,
+		Until:      until,
+		VerifiedAt: time.Now(),
+	}
+	if head != nil {
+		v.HeadSequence = head.Sequence
+	}
+	verifyEvents(v, events)
+
+	if until == nil && head != nil {
+		switch {
+		case v.LastSequence < head.Sequence && time.Since(head.UpdatedAt) < indexingGrace:
+			v.Pending = head.Sequence - v.LastSequence
+		case v.LastSequence < head.Sequence:
+			v.Problems = append(v.Problems, ChainProblem{
+				Sequence: v.LastSequence + 1,
+				Problem:  fmt.Sprintf("%d events after sequence %d are missing from the index", head.Sequence-v.LastSequence, v.LastSequence),
+			})
+		case v.LastSequence == head.Sequence && v.LastHash != head.LastHash:
+			v.Problems = append(v.Problems, ChainProblem{Sequence: v.LastSequence, Problem: "last event does not match the chain head"})
+		}
+	}
+
+	v.Valid = len(v.Problems) == 0
+	return v, nil
+}
+
+// chainEvents reads a tenant's events in the range in sequence order
+func (l *Logger) chainEvents(ctx context.Context, tenantID string, since, until *time.Time) ([]AuditEvent, error) {
+	var events []AuditEvent
+	for offset := 0; ; offset += verifyPageSize {
+		result, err := l.client.Search(ctx, &SearchQuery{
+			TenantID:    tenantID,
+			StartTime:   since,
+			EndTime:     until,
+			MaxHits:     verifyPageSize,
+			StartOffset: offset,
+			SortBy:      []SortField{{Field: "sequence", Order: "asc"}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit events: %w", err)
+		}
+		events = append(events, result.Hits...)
+		if len(result.Hits) < verifyPageSize {
+			return events, nil
+		}
+	}
+}
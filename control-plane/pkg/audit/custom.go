@@ -0,0 +1,105 @@
+// Package audit provides audit logging with Quickwit integration.
+package audit
+
+import (
+	"encoding/json"
+	"regexp"
+	"time"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+)
+
+// Limits on custom audit events pushed by tenant automation
+const (
+	MaxCustomMetadataBytes   = 8 * 1024
+	maxCustomDescriptionLen  = 1024
+	maxCustomResourceIDLen   = 255
+	maxCustomTimestampSkew   = 5 * time.Minute
+	maxCustomTimestampMaxAge = 24 * time.Hour
+)
+
+// customActionPattern restricts custom actions and resource types to short
+// lowercase identifiers such as "deploy" or "change.approved"
+var customActionPattern = regexp.MustCompile(`^[a-z][a-z0-9_.-]{0,63}$`)
+
+// CustomEventRequest is the subset of an audit event that tenant automation
+// may set. Tenant, actor and request information are filled in from the
+// caller's credentials.
+type CustomEventRequest struct {
+	// EventType must be empty or "custom"
+	EventType    string                 `json:"event_type"`
+	Action       string                 `json:"action" binding:"required"`
+	Outcome      string                 `json:"outcome"`
+	ResourceID   string                 `json:"resource_id"`
+	ResourceType string                 `json:"resource_type"`
+	Description  string                 `json:"description"`
+	Metadata     map[string]interface{} `json:"metadata"`
+	// Fields are values of the tenant's registered custom fields, checked
+	// by its FieldRegistry
+	Fields map[string]interface{} `json:"fields"`
+	// Timestamp is when the event happened in the external system;
+	// defaults to now and may be at most 24 hours in the past
+	Timestamp *time.Time `json:"timestamp"`
+}
+
+// NewCustomEvent validates a custom event request and converts it to an
+// audit event of type custom. The caller sets the tenant and actor.
+func NewCustomEvent(req *CustomEventRequest) (*AuditEvent, error) {
+	if req.EventType != "" && EventType(req.EventType) != EventTypeCustom {
+		return nil, apperror.InvalidInput("event_type must be %q", EventTypeCustom)
+	}
+	if !customActionPattern.MatchString(req.Action) {
+		return nil, apperror.InvalidInput("action must be a lowercase identifier of at most 64 characters")
+	}
+	if req.ResourceType != "" && !customActionPattern.MatchString(req.ResourceType) {
+		return nil, apperror.InvalidInput("resource_type must be a lowercase identifier of at most 64 characters")
+	}
+	if len(req.ResourceID) > maxCustomResourceIDLen {
+		return nil, apperror.InvalidInput("resource_id must be at most %d characters", maxCustomResourceIDLen)
+	}
+	if len(req.Description) > maxCustomDescriptionLen {
+		return nil, apperror.InvalidInput("description must be at most %d characters", maxCustomDescriptionLen)
+	}
+
+	outcome := EventOutcome(req.Outcome)
+	switch outcome {
+	case "":
+		outcome = OutcomeSuccess
+	case OutcomeSuccess, OutcomeFailure, OutcomeUnknown:
+	default:
+		return nil, apperror.InvalidInput("outcome must be success, failure or unknown")
+	}
+
+	if len(req.Metadata) > 0 {
+		data, err := json.Marshal(req.Metadata)
+		if err != nil {
+			return nil, apperror.InvalidInput("metadata is not valid JSON: %v", err)
+		}
+		if len(data) > MaxCustomMetadataBytes {
+			return nil, apperror.InvalidInput("metadata must be at most %d bytes when encoded", MaxCustomMetadataBytes)
+		}
+	}
+
+	now := time.Now()
+	timestamp := now
+	if req.Timestamp != nil {
+		if req.Timestamp.After(now.Add(maxCustomTimestampSkew)) {
+			return nil, apperror.InvalidInput("timestamp is in the future")
+		}
+		if req.Timestamp.Before(now.Add(-maxCustomTimestampMaxAge)) {
+			return nil, apperror.InvalidInput("timestamp must be within the last %s", maxCustomTimestampMaxAge)
+		}
+		timestamp = *req.Timestamp
+	}
+
+	return &AuditEvent{
+		Timestamp:    timestamp,
+		EventType:    EventTypeCustom,
+		Action:       EventAction(req.Action),
+		Outcome:      outcome,
+		ResourceID:   req.ResourceID,
+		ResourceType: req.ResourceType,
+		Description:  req.Description,
+		Metadata:     req.Metadata,
+	}, nil
+}
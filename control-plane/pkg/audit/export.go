@@ -0,0 +1,125 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+)
+
+// ExportManifest summarises an export bundle; its signature covers the
+// manifest's JSON encoding, which in turn covers the events by digest
+type ExportManifest struct {
+	TenantID      string     `json:"tenant_id"`
+	Since         *time.Time `json:"since,omitempty"`
+	Until         *time.Time `json:"until,omitempty"`
+	EventCount    int        `json:"event_count"`
+	FirstSequence int64      `json:"first_sequence,omitempty"`
+	LastSequence  int64      `json:"last_sequence,omitempty"`
+	LastHash      string     `json:"last_hash,omitempty"`
+	ChainValid    bool       `json:"chain_valid"`
+	EventsSHA256  string     `json:"events_sha256"`
+	GeneratedAt   time.Time  `json:"generated_at"`
+}
+
+// ExportBundle is a signed set of audit events for auditors. Verify it by
+// checking Signature over the JSON encoding of Manifest with PublicKey, that
+// events_sha256 is the SHA-256 of the JSON encoding of Events, and the hash
+// chain of the events.
+type ExportBundle struct {
+	Manifest     ExportManifest     `json:"manifest"`
+	Signature    string             `json:"signature"`  // base64 Ed25519
+	PublicKey    string             `json:"public_key"` // base64 Ed25519
+	Verification *ChainVerification `json:"verification"`
+	Events       []AuditEvent       `json:"events"`
+}
+
+// ParseSigningKey decodes a base64 Ed25519 private key or 32-byte seed
+func ParseSigningKey(encoded string) (ed25519.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("signing key is not valid base64: %w", err)
+	}
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	default:
+		return nil, fmt.Errorf("signing key must be a %d-byte Ed25519 seed or %d-byte private key", ed25519.SeedSize, ed25519.PrivateKeySize)
+	}
+}
+
+// SetExportSigningKey sets the key export bundles are signed with
+func (l *Logger) SetExportSigningKey(key ed25519.PrivateKey) {
+	l.exportKey = key
+}
+
+// ExportPublicKey returns the base64 public key export bundles are signed
+// with, or "" when export signing is not configured
+func (l *Logger) ExportPublicKey() string {
+	if l.exportKey == nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(l.exportKey.Public().(ed25519.PublicKey))
+}
+
+// Export builds a signed bundle of a tenant's chained audit events between
+// since and until, with the chain verification of the range
+func (l *Logger) Export(ctx context.Context, tenantID string, since, until *time.Time) (*ExportBundle, error) {
+	if l.chain == nil {
+		return nil, apperror.InvalidState("audit hash chaining is not enabled")
+	}
+	if l.exportKey == nil {
+		return nil, apperror.InvalidState("audit export signing key is not configured")
+	}
+	if err := l.Flush(ctx); err != nil {
+		return nil, fmt.Errorf("failed to flush pending audit events: %w", err)
+	}
+
+	events, err := l.chainEvents(ctx, tenantID, since, until)
+	if err != nil {
+		return nil, err
+	}
+	verification, err := l.verify(ctx, tenantID, since, until, events)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := json.Marshal(events)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit events: %w", err)
+	}
+	digest := sha256.Sum256(encoded)
+
+	manifest := ExportManifest{
+		TenantID:      tenantID,
+		Since:         since,
+		Until:         until,
+		EventCount:    len(events),
+		FirstSequence: verification.FirstSequence,
+		LastSequence:  verification.LastSequence,
+		LastHash:      verification.LastHash,
+		ChainValid:    verification.Valid,
+		EventsSHA256:  hex.EncodeToString(digest[:]),
+		GeneratedAt:   time.Now().UTC(),
+	}
+	signed, err := json.Marshal(&manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode export manifest: %w", err)
+	}
+
+	return &ExportBundle{
+		Manifest:     manifest,
+		Signature:    base64.StdEncoding.EncodeToString(ed25519.Sign(l.exportKey, signed)),
+		PublicKey:    l.ExportPublicKey(),
+		Verification: verification,
+		Events:       events,
+	}, nil
+}
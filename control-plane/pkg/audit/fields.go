@@ -0,0 +1,397 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// EventSchemaVersion is the version of the audit event document written
+// now. Version 2 added custom fields; events without a version are 1.
+const EventSchemaVersion = 2
+
+// Limits on custom audit fields
+const (
+	MaxFieldsPerTenant    = 50
+	maxFieldStringLen     = 1024
+	maxFieldAllowedValues = 100
+)
+
+// fieldNamePattern restricts field names to lowercase identifiers, which
+// are searched as fields.<name>
+var fieldNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// CreateFieldRequest represents a request to register a custom audit field
+type CreateFieldRequest struct {
+	TenantID      string                `json:"-"`
+	Name          string                `json:"name" binding:"required"`
+	Type          models.AuditFieldType `json:"type" binding:"required"`
+	Description   string                `json:"description"`
+	Required      bool                  `json:"required"`
+	AllowedValues []string              `json:"allowed_values"`
+	CreatedBy     string                `json:"-"`
+}
+
+// UpdateFieldRequest changes a custom audit field. A field's name and type
+// are fixed once events may carry it; retire it and register a new one
+// instead.
+type UpdateFieldRequest struct {
+	Description   *string   `json:"description"`
+	Required      *bool     `json:"required"`
+	AllowedValues *[]string `json:"allowed_values"`
+}
+
+// FieldRegistry keeps the custom fields each tenant attaches to its audit
+// events and validates the values events carry
+type FieldRegistry struct {
+	db     *gorm.DB
+	logger *zap.Logger
+}
+
+// NewFieldRegistry creates a custom audit field registry
+func NewFieldRegistry(db *gorm.DB, logger *zap.Logger) *FieldRegistry {
+	return &FieldRegistry{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Create registers a custom audit field for a tenant
+func (r *FieldRegistry) Create(ctx context.Context, req *CreateFieldRequest) (*models.AuditField, error) {
+	name := strings.TrimSpace(req.Name)
+	if !fieldNamePattern.MatchString(name) {
+		return nil, apperror.InvalidInput("name must be a lowercase identifier of at most 64 characters")
+	}
+	switch req.Type {
+	case models.AuditFieldString, models.AuditFieldInteger, models.AuditFieldNumber,
+		models.AuditFieldBoolean, models.AuditFieldDatetime:
+	default:
+		return nil, apperror.InvalidInput("type must be string, integer, number, boolean or datetime")
+	}
+
+	now := time.Now()
+	field := &models.AuditField{
+		ID:          uuid.New().String(),
+		TenantID:    req.TenantID,
+		Name:        name,
+		Type:        req.Type,
+		Description: req.Description,
+		Required:    req.Required,
+		Status:      models.AuditFieldActive,
+		CreatedBy:   req.CreatedBy,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := setAllowedValues(field, req.AllowedValues); err != nil {
+		return nil, err
+	}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing []models.AuditField
+		if err := tx.Select("name").Where("tenant_id = ?", req.TenantID).Find(&existing).Error; err != nil {
+			return fmt.Errorf("failed to list audit fields: %w", err)
+		}
+		for _, e := range existing {
+			if e.Name == name {
+				return apperror.Conflict("audit field %s already exists", name)
+			}
+		}
+		// Retired fields count too: events carrying them stay indexed
+		if len(existing) >= MaxFieldsPerTenant {
+			return apperror.QuotaExceeded("tenants can register at most %d audit fields", MaxFieldsPerTenant)
+		}
+		if err := tx.Create(field).Error; err != nil {
+			return fmt.Errorf("failed to create audit field: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.logger.Info("audit field registered",
+		zap.String("tenant_id", field.TenantID),
+		zap.String("name", field.Name),
+		zap.String("type", string(field.Type)))
+
+	return field, nil
+}
+
+// Get returns a tenant's custom audit field by name
+func (r *FieldRegistry) Get(ctx context.Context, tenantID, name string) (*models.AuditField, error) {
+	var field models.AuditField
+	if err := r.db.WithContext(ctx).Where("tenant_id = ? AND name = ?", tenantID, name).First(&field).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("audit field not found")
+		}
+		return nil, fmt.Errorf("failed to get audit field: %w", err)
+	}
+	return &field, nil
+}
+
+// List returns a tenant's custom audit fields, retired ones included
+func (r *FieldRegistry) List(ctx context.Context, tenantID string) ([]models.AuditField, error) {
+	var fields []models.AuditField
+	if err := r.db.WithContext(ctx).Where("tenant_id = ?", tenantID).Order("name ASC").Find(&fields).Error; err != nil {
+		return nil, fmt.Errorf("failed to list audit fields: %w", err)
+	}
+	return fields, nil
+}
+
+// Update changes a custom audit field's description, whether events must
+// carry it and the values it allows
+func (r *FieldRegistry) Update(ctx context.Context, tenantID, name string, req *UpdateFieldRequest) (*models.AuditField, error) {
+	field, err := r.Get(ctx, tenantID, name)
+	if err != nil {
+		return nil, err
+	}
+	if field.Status == models.AuditFieldRetired {
+		return nil, apperror.InvalidState("audit field %s is retired", name)
+	}
+
+	if req.Description != nil {
+		field.Description = *req.Description
+	}
+	if req.Required != nil {
+		field.Required = *req.Required
+	}
+	if req.AllowedValues != nil {
+		if err := setAllowedValues(field, *req.AllowedValues); err != nil {
+			return nil, err
+		}
+	}
+
+	field.UpdatedAt = time.Now()
+	if err := r.db.WithContext(ctx).Save(field).Error; err != nil {
+		return nil, fmt.Errorf("failed to update audit field: %w", err)
+	}
+	return field, nil
+}
+
+// Retire stops a custom audit field accepting values. Events already
+// carrying it remain searchable by it, so the name stays taken.
+func (r *FieldRegistry) Retire(ctx context.Context, tenantID, name string) (*models.AuditField, error) {
+	field, err := r.Get(ctx, tenantID, name)
+	if err != nil {
+		return nil, err
+	}
+	if field.Status == models.AuditFieldRetired {
+		return field, nil
+	}
+
+	field.Status = models.AuditFieldRetired
+	field.Required = false
+	field.UpdatedAt = time.Now()
+	if err := r.db.WithContext(ctx).Model(field).Updates(map[string]interface{}{
+		"status":     field.Status,
+		"required":   false,
+		"updated_at": field.UpdatedAt,
+	}).Error; err != nil {
+		return nil, fmt.Errorf("failed to retire audit field: %w", err)
+	}
+
+	r.logger.Info("audit field retired",
+		zap.String("tenant_id", tenantID),
+		zap.String("name", name))
+	return field, nil
+}
+
+// Validate checks the custom field values of an event against the tenant's
+// registry and returns them normalised: integers as int64, datetimes in
+// UTC RFC 3339. Unknown and retired fields are rejected and required
+// fields must be present.
+func (r *FieldRegistry) Validate(ctx context.Context, tenantID string, values map[string]interface{}) (map[string]interface{}, error) {
+	registered, err := r.List(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*models.AuditField, len(registered))
+	for i := range registered {
+		byName[registered[i].Name] = &registered[i]
+	}
+
+	normalized := make(map[string]interface{}, len(values))
+	for name, value := range values {
+		field, ok := byName[name]
+		if !ok {
+			return nil, apperror.InvalidInput("unknown audit field %s", name)
+		}
+		if field.Status == models.AuditFieldRetired {
+			return nil, apperror.InvalidInput("audit field %s is retired", name)
+		}
+		v, err := fieldValue(field, value)
+		if err != nil {
+			return nil, err
+		}
+		normalized[name] = v
+	}
+
+	var missing []string
+	for _, field := range registered {
+		if _, ok := normalized[field.Name]; field.Required && field.Status == models.AuditFieldActive && !ok {
+			missing = append(missing, field.Name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, apperror.InvalidInput("missing required audit fields: %s", strings.Join(missing, ", "))
+	}
+
+	if len(normalized) == 0 {
+		return nil, nil
+	}
+	return normalized, nil
+}
+
+// SearchFilters checks filters on custom fields against the tenant's
+// registry and returns their values normalised for searching. Retired
+// fields can still be searched.
+func (r *FieldRegistry) SearchFilters(ctx context.Context, tenantID string, filters map[string]string) (map[string]string, error) {
+	if len(filters) == 0 {
+		return nil, nil
+	}
+
+	normalized := make(map[string]string, len(filters))
+	for name, raw := range filters {
+		field, err := r.Get(ctx, tenantID, name)
+		if err != nil {
+			if errors.Is(err, apperror.ErrNotFound) {
+				return nil, apperror.InvalidInput("unknown audit field %s", name)
+			}
+			return nil, err
+		}
+
+		var value interface{} = raw
+		switch field.Type {
+		case models.AuditFieldInteger, models.AuditFieldNumber:
+			f, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return nil, apperror.InvalidInput("audit field %s must be a number", name)
+			}
+			value = f
+		case models.AuditFieldBoolean:
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				return nil, apperror.InvalidInput("audit field %s must be true or false", name)
+			}
+			value = b
+		}
+		// Searches match values outside the allowed set, which may have
+		// been allowed when the events were written
+		unrestricted := *field
+		unrestricted.AllowedValues = nil
+		v, err := fieldValue(&unrestricted, value)
+		if err != nil {
+			return nil, err
+		}
+		normalized[name] = fmt.Sprint(v)
+	}
+	return normalized, nil
+}
+
+// fieldValue checks a value against a field's type and normalises it
+func fieldValue(field *models.AuditField, value interface{}) (interface{}, error) {
+	switch field.Type {
+	case models.AuditFieldString:
+		s, ok := value.(string)
+		if !ok {
+			return nil, apperror.InvalidInput("audit field %s must be a string", field.Name)
+		}
+		if len(s) > maxFieldStringLen {
+			return nil, apperror.InvalidInput("audit field %s must be at most %d characters", field.Name, maxFieldStringLen)
+		}
+		if len(field.AllowedValues) > 0 {
+			for _, allowed := range field.AllowedValues {
+				if s == allowed {
+					return s, nil
+				}
+			}
+			return nil, apperror.InvalidInput("audit field %s must be one of: %s", field.Name, strings.Join(field.AllowedValues, ", "))
+		}
+		return s, nil
+
+	case models.AuditFieldInteger:
+		f, ok := number(value)
+		if !ok || f != math.Trunc(f) || math.Abs(f) > 1<<53 {
+			return nil, apperror.InvalidInput("audit field %s must be an integer", field.Name)
+		}
+		return int64(f), nil
+
+	case models.AuditFieldNumber:
+		f, ok := number(value)
+		if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, apperror.InvalidInput("audit field %s must be a number", field.Name)
+		}
+		return f, nil
+
+	case models.AuditFieldBoolean:
+		b, ok := value.(bool)
+		if !ok {
+			return nil, apperror.InvalidInput("audit field %s must be true or false", field.Name)
+		}
+		return b, nil
+
+	case models.AuditFieldDatetime:
+		s, ok := value.(string)
+		if !ok {
+			return nil, apperror.InvalidInput("audit field %s must be an RFC 3339 timestamp", field.Name)
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil, apperror.InvalidInput("audit field %s must be an RFC 3339 timestamp", field.Name)
+		}
+		return t.UTC().Format(time.RFC3339), nil
+	}
+	return nil, fmt.Errorf("audit field %s has unknown type %s", field.Name, field.Type)
+}
+
+// number returns a JSON number as a float64
+func number(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// setAllowedValues sets the values a string field allows
+func setAllowedValues(field *models.AuditField, values []string) error {
+	if len(values) == 0 {
+		field.AllowedValues = nil
+		return nil
+	}
+	if field.Type != models.AuditFieldString {
+		return apperror.InvalidInput("allowed_values only apply to string fields")
+	}
+	if len(values) > maxFieldAllowedValues {
+		return apperror.InvalidInput("at most %d allowed values", maxFieldAllowedValues)
+	}
+	for _, v := range values {
+		if v == "" || len(v) > maxFieldStringLen {
+			return apperror.InvalidInput("allowed values must be non-empty strings of at most %d characters", maxFieldStringLen)
+		}
+	}
+	field.AllowedValues = models.StringArray(values)
+	return nil
+}
@@ -0,0 +1,31 @@
+package audit
+
+import "context"
+
+// impersonationKey is the context key of the impersonation behind a request
+type impersonationKey struct{}
+
+// Impersonation identifies the platform admin, and the session, behind a
+// request made with an impersonation token
+type Impersonation struct {
+	AdminID   string
+	SessionID string
+}
+
+// WithImpersonation returns a context whose audit events are marked as
+// made by the admin impersonating the tenant
+func WithImpersonation(ctx context.Context, impersonation Impersonation) context.Context {
+	return context.WithValue(ctx, impersonationKey{}, impersonation)
+}
+
+// ImpersonationFromContext returns the impersonation behind ctx, if any
+func ImpersonationFromContext(ctx context.Context) (Impersonation, bool) {
+	impersonation, ok := ctx.Value(impersonationKey{}).(Impersonation)
+	return impersonation, ok
+}
+
+// Annotate records the impersonation in an event's metadata
+func (i Impersonation) Annotate(metadata map[string]interface{}) {
+	metadata["impersonated_by"] = i.AdminID
+	metadata["impersonation_id"] = i.SessionID
+}
@@ -3,12 +3,15 @@ package audit
 
 import (
 	"context"
+	"crypto/ed25519"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/pkg/tracing"
 )
 
 // Logger provides audit logging functionality
@@ -17,6 +20,10 @@ type Logger struct {
 	logger        *zap.Logger
 	config        *QuickwitConfig
 
+	// Tamper evidence
+	chain     *Chain
+	exportKey ed25519.PrivateKey
+
 	// Batching
 	mu            sync.Mutex
 	batch         []AuditEvent
@@ -42,6 +49,11 @@ func NewLogger(client *QuickwitClient, config *QuickwitConfig, logger *zap.Logge
 	return l
 }
 
+// SetChain enables hash chaining of logged events
+func (l *Logger) SetChain(chain *Chain) {
+	l.chain = chain
+}
+
 // startBatchProcessor starts the background batch processor
 func (l *Logger) startBatchProcessor() {
 	l.flushTicker = time.NewTicker(l.config.FlushInterval)
@@ -92,6 +104,30 @@ func (l *Logger) Log(ctx context.Context, event *AuditEvent) error {
 		event.Outcome = OutcomeSuccess
 	}
 
+	if event.SchemaVersion == 0 {
+		event.SchemaVersion = EventSchemaVersion
+	}
+
+	// Events caused by a request carry its ID, however far from the
+	// handler they are logged
+	if event.RequestID == "" {
+		event.RequestID = tracing.RequestID(ctx)
+	}
+
+	// Mark events caused by an admin impersonating the tenant
+	if impersonation, ok := ImpersonationFromContext(ctx); ok {
+		if event.Metadata == nil {
+			event.Metadata = make(map[string]interface{})
+		}
+		impersonation.Annotate(event.Metadata)
+	}
+
+	if l.chain != nil {
+		if err := l.chain.Link(ctx, event); err != nil {
+			return fmt.Errorf("failed to chain audit event: %w", err)
+		}
+	}
+
 	if l.config.EnableBatch {
 		return l.addToBatch(ctx, event)
 	}
@@ -137,6 +173,14 @@ func (l *Logger) Flush(ctx context.Context) error {
 	return nil
 }
 
+// Pending returns the number of events waiting to be sent to Quickwit,
+// including those put back after a failed flush
+func (l *Logger) Pending() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.batch)
+}
+
 // LogAuth logs an authentication event
 func (l *Logger) LogAuth(ctx context.Context, tenantID, actorID, actorType, action string, success bool, metadata map[string]interface{}) error {
 	outcome := OutcomeSuccess
@@ -211,28 +255,76 @@ func (l *Logger) LogCampaignEvent(ctx context.Context, tenantID, campaignID, act
 	})
 }
 
-// LogAPIRequest logs an API request
-func (l *Logger) LogAPIRequest(ctx context.Context, tenantID, actorID, actorType, method, path string, statusCode int, duration time.Duration, metadata map[string]interface{}) error {
+// APIRequest describes an authenticated API call for LogAPIRequest
+type APIRequest struct {
+	TenantID   string
+	ActorID    string
+	ActorType  string
+	Method     string
+	Path       string
+	StatusCode int
+	Duration   time.Duration
+	RequestID  string
+	IPAddress  string
+	UserAgent  string
+	Metadata   map[string]interface{}
+
+	// Action overrides the action derived from the method, e.g. for
+	// requests that start workflow executions
+	Action EventAction
+	// AuthFailure is why the request could not be authenticated; such
+	// requests are logged as failed logins
+	AuthFailure string
+}
+
+// LogAPIRequest logs an API request. The action follows the HTTP method
+// unless the request names one.
+func (l *Logger) LogAPIRequest(ctx context.Context, req *APIRequest) error {
 	outcome := OutcomeSuccess
-	if statusCode >= 400 {
+	if req.StatusCode >= 400 {
 		outcome = OutcomeFailure
 	}
 
+	metadata := req.Metadata
 	if metadata == nil {
 		metadata = make(map[string]interface{})
 	}
-	metadata["method"] = method
-	metadata["path"] = path
-	metadata["status_code"] = statusCode
+	metadata["method"] = req.Method
+	metadata["path"] = req.Path
+	metadata["status_code"] = req.StatusCode
+
+	action := ActionRead
+	switch req.Method {
+	case "POST":
+		action = ActionCreate
+	case "PUT", "PATCH":
+		action = ActionUpdate
+	case "DELETE":
+		action = ActionDelete
+	}
+	if req.Action != "" {
+		action = req.Action
+	}
+
+	eventType := EventTypeAPI
+	if req.AuthFailure != "" {
+		eventType = EventTypeAuth
+		action = ActionLogin
+		outcome = OutcomeFailure
+		metadata["reason"] = req.AuthFailure
+	}
 
 	return l.Log(ctx, &AuditEvent{
-		TenantID:  tenantID,
-		EventType: EventTypeAPI,
-		Action:    ActionRead,
+		TenantID:  req.TenantID,
+		EventType: eventType,
+		Action:    action,
 		Outcome:   outcome,
-		ActorID:   actorID,
-		ActorType: actorType,
-		Duration:  duration.Milliseconds(),
+		ActorID:   req.ActorID,
+		ActorType: req.ActorType,
+		IPAddress: req.IPAddress,
+		UserAgent: req.UserAgent,
+		RequestID: req.RequestID,
+		Duration:  req.Duration.Milliseconds(),
 		Metadata:  metadata,
 	})
 }
@@ -293,6 +385,12 @@ func (l *Logger) GetEventsByResource(ctx context.Context, tenantID, resourceID s
 	})
 }
 
+// CountBy counts the events matching a query by the values of a fast field,
+// returning at most size values
+func (l *Logger) CountBy(ctx context.Context, query *SearchQuery, field string, size int) (map[string]int64, error) {
+	return l.client.AggregateQuery(ctx, query, field, size)
+}
+
 // GetAggregatedCounts gets aggregated counts by field
 func (l *Logger) GetAggregatedCounts(ctx context.Context, tenantID, field string, startTime, endTime *time.Time) (map[string]int64, error) {
 	return l.client.Aggregate(ctx, tenantID, field, startTime, endTime)
@@ -9,6 +9,8 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -182,6 +184,88 @@ func (c *QuickwitClient) IngestSingle(ctx context.Context, event *AuditEvent) er
 	return c.Ingest(ctx, []AuditEvent{*event})
 }
 
+// IngestDocuments ingests arbitrary documents into the given index
+func (c *QuickwitClient) IngestDocuments(ctx context.Context, indexID string, docs []interface{}) error {
+	if len(docs) == 0 {
+		return nil
+	}
+
+	var buffer bytes.Buffer
+	for _, doc := range docs {
+		data, err := json.Marshal(doc)
+		if err != nil {
+			c.logger.Error("failed to marshal document", zap.Error(err), zap.String("index_id", indexID))
+			continue
+		}
+		buffer.Write(data)
+		buffer.WriteByte('\n')
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/api/v1/%s/ingest", c.baseURL, indexID),
+		&buffer)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to ingest documents: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to ingest documents: status=%d body=%s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// RawSearchResult represents search results with undecoded hits
+type RawSearchResult struct {
+	Hits        []json.RawMessage `json:"hits"`
+	NumHits     int64             `json:"num_hits"`
+	ElapsedSecs float64           `json:"elapsed_secs"`
+}
+
+// SearchIndex runs a search request against the given index
+func (c *QuickwitClient) SearchIndex(ctx context.Context, indexID string, searchReq map[string]interface{}) (*RawSearchResult, error) {
+	data, err := json.Marshal(searchReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal search request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/api/v1/%s/search", c.baseURL, indexID),
+		bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("search failed: status=%d body=%s", resp.StatusCode, string(body))
+	}
+
+	var result RawSearchResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
 // Search searches the audit log index
 func (c *QuickwitClient) Search(ctx context.Context, query *SearchQuery) (*SearchResult, error) {
 	// Build query string
@@ -308,6 +392,21 @@ func (c *QuickwitClient) buildQueryString(query *SearchQuery) string {
 		parts = append(parts, fmt.Sprintf("resource_id:%s", query.ResourceID))
 	}
 
+	// Add request filter
+	if query.RequestID != "" {
+		parts = append(parts, fmt.Sprintf("request_id:%q", query.RequestID))
+	}
+
+	// Add custom field filters, in name order so queries are stable
+	names := make([]string, 0, len(query.Fields))
+	for name := range query.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("fields.%s:%s", name, fieldTerm(query.Fields[name])))
+	}
+
 	// Add free-text query
 	if query.Query != "" {
 		parts = append(parts, query.Query)
@@ -320,6 +419,18 @@ func (c *QuickwitClient) buildQueryString(query *SearchQuery) string {
 	return strings.Join(parts, " AND ")
 }
 
+// plainTerm matches numbers and booleans, which are searched unquoted
+var plainTerm = regexp.MustCompile(`^(-?[0-9]+(\.[0-9]+)?|true|false)$`)
+
+// fieldTerm returns a custom field value as a query term: numbers and
+// booleans as they are, anything else as a quoted phrase
+func fieldTerm(value string) string {
+	if plainTerm.MatchString(value) {
+		return value
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
 // Aggregate performs aggregation queries
 func (c *QuickwitClient) Aggregate(ctx context.Context, tenantID string, field string, startTime, endTime *time.Time) (map[string]int64, error) {
 	queryStr := fmt.Sprintf("tenant_id:%s", tenantID)
@@ -343,6 +454,41 @@ func (c *QuickwitClient) Aggregate(ctx context.Context, tenantID string, field s
 		aggReq["end_timestamp"] = endTime.Unix()
 	}
 
+	return c.aggregate(ctx, aggReq)
+}
+
+// AggregateQuery counts the events matching a search query by the values of
+// field, which must be a fast field. size bounds the number of values
+// returned, the most frequent first.
+func (c *QuickwitClient) AggregateQuery(ctx context.Context, query *SearchQuery, field string, size int) (map[string]int64, error) {
+	terms := map[string]interface{}{
+		"field": field,
+	}
+	if size > 0 {
+		terms["size"] = size
+	}
+	aggReq := map[string]interface{}{
+		"query":    c.buildQueryString(query),
+		"max_hits": 0,
+		"aggs": map[string]interface{}{
+			"counts": map[string]interface{}{
+				"terms": terms,
+			},
+		},
+	}
+
+	if query.StartTime != nil {
+		aggReq["start_timestamp"] = query.StartTime.Unix()
+	}
+	if query.EndTime != nil {
+		aggReq["end_timestamp"] = query.EndTime.Unix()
+	}
+
+	return c.aggregate(ctx, aggReq)
+}
+
+// aggregate runs a terms aggregation named counts
+func (c *QuickwitClient) aggregate(ctx context.Context, aggReq map[string]interface{}) (map[string]int64, error) {
 	data, err := json.Marshal(aggReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal aggregation request: %w", err)
@@ -0,0 +1,67 @@
+package audit
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces secrets scrubbed from audited content
+const Redacted = "[REDACTED]"
+
+// secretAssignment matches a secret-looking key assigned a value, e.g.
+// password: hunter2 or API_TOKEN="abc"; the key and separator are kept
+var secretAssignment = regexp.MustCompile(`(?i)([\w.-]*(?:password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key|private[_-]?key|credential)[\w.-]*["']?\s*[:=]\s*)("[^"]*"|'[^']*'|\S+)`)
+
+// secretValues match secrets recognisable on their own, with their
+// replacements
+var secretValues = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// Authorization header values
+	{regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/-]+=*`), "${1} " + Redacted},
+	// JWTs
+	{regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`), Redacted},
+	// AWS access key IDs
+	{regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`), Redacted},
+	// Credentials in URLs
+	{regexp.MustCompile(`://[^/\s:@]+:[^/\s@]+@`), "://" + Redacted + "@"},
+}
+
+// pemBegin and pemEnd delimit private keys, whose lines are dropped
+var (
+	pemBegin = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)
+	pemEnd   = regexp.MustCompile(`-----END [A-Z ]*PRIVATE KEY-----`)
+)
+
+// RedactSecrets scrubs secret-looking values from text, line by line, so
+// content can be recorded in the audit log. Template expressions assigned
+// to secret keys are redacted too; their values are not known here.
+func RedactSecrets(text string) string {
+	lines := strings.Split(text, "\n")
+	inKey := false
+	for i, line := range lines {
+		lines[i], inKey = redactLine(line, inKey)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// redactLine scrubs one line; inKey reports whether the line is inside a
+// private key block
+func redactLine(line string, inKey bool) (string, bool) {
+	if inKey {
+		if pemEnd.MatchString(line) {
+			return line, false
+		}
+		return Redacted, true
+	}
+	if pemBegin.MatchString(line) {
+		return line, !pemEnd.MatchString(line)
+	}
+
+	line = secretAssignment.ReplaceAllString(line, "${1}"+Redacted)
+	for _, secret := range secretValues {
+		line = secret.pattern.ReplaceAllString(line, secret.replacement)
+	}
+	return line, false
+}
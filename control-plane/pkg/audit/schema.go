@@ -17,6 +17,8 @@ const (
 	EventTypeConfig     EventType = "config"
 	EventTypeAPI        EventType = "api"
 	EventTypeSystem     EventType = "system"
+	EventTypeCustom     EventType = "custom" // pushed by tenant automation
+	EventTypeAlert      EventType = "alert"  // anomalies found in the audit log
 )
 
 // EventAction represents the action performed
@@ -67,6 +69,17 @@ type AuditEvent struct {
 	Duration    int64                  `json:"duration_ms,omitempty"`
 	ErrorCode   string                 `json:"error_code,omitempty"`
 	ErrorMsg    string                 `json:"error_message,omitempty"`
+
+	// Fields are the tenant's custom fields, validated against its field
+	// registry and indexed as fields.<name>
+	Fields map[string]interface{} `json:"fields,omitempty"`
+	// SchemaVersion is the event document version; empty for version 1
+	SchemaVersion int `json:"schema_version,omitempty"`
+
+	// Hash chain position, set when chaining is enabled
+	Sequence int64  `json:"sequence,omitempty"`
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
 }
 
 // QuickwitIndexConfig represents Quickwit index configuration
@@ -86,6 +99,16 @@ type DocMapping struct {
 	TimestampField  string          `json:"timestamp_field"`
 	TagFields       []string        `json:"tag_fields"`
 	PartitionKey    string          `json:"partition_key,omitempty"`
+	// DynamicMapping indexes fields missing from FieldMappings
+	DynamicMapping  *DynamicMapping `json:"dynamic_mapping,omitempty"`
+}
+
+// DynamicMapping represents how fields without a mapping are indexed
+type DynamicMapping struct {
+	Indexed   bool   `json:"indexed"`
+	Stored    bool   `json:"stored"`
+	Fast      bool   `json:"fast"`
+	Tokenizer string `json:"tokenizer,omitempty"`
 }
 
 // FieldMapping represents a field mapping
@@ -96,6 +119,7 @@ type FieldMapping struct {
 	Stored     bool   `json:"stored,omitempty"`
 	Fast       bool   `json:"fast,omitempty"`
 	Tokenizer  string `json:"tokenizer,omitempty"`
+	Record     string `json:"record,omitempty"`
 }
 
 // SearchSettings represents search configuration
@@ -140,6 +164,8 @@ func DefaultAuditIndexConfig(indexID string) *QuickwitIndexConfig {
 			TimestampField: "timestamp",
 			TagFields:      []string{"tenant_id", "event_type", "action", "outcome", "actor_type", "resource_type"},
 			PartitionKey:   "tenant_id",
+			// Values of fields added later are matched exactly
+			DynamicMapping: &DynamicMapping{Indexed: true, Stored: true, Fast: true, Tokenizer: "raw"},
 			FieldMappings: []FieldMapping{
 				{Name: "id", Type: "text", Indexed: true, Stored: true},
 				{Name: "timestamp", Type: "datetime", Indexed: true, Stored: true, Fast: true},
@@ -158,6 +184,12 @@ func DefaultAuditIndexConfig(indexID string) *QuickwitIndexConfig {
 				{Name: "duration_ms", Type: "i64", Indexed: true, Stored: true, Fast: true},
 				{Name: "error_code", Type: "text", Indexed: true, Stored: true},
 				{Name: "error_message", Type: "text", Indexed: true, Stored: true},
+				{Name: "sequence", Type: "i64", Indexed: true, Stored: true, Fast: true},
+				{Name: "prev_hash", Type: "text", Indexed: true, Stored: true, Tokenizer: "raw"},
+				{Name: "hash", Type: "text", Indexed: true, Stored: true, Tokenizer: "raw"},
+				{Name: "schema_version", Type: "i64", Indexed: true, Stored: true, Fast: true},
+				// Tenant custom fields, searched as fields.<name>
+				{Name: "fields", Type: "json", Indexed: true, Stored: true, Fast: true, Tokenizer: "raw"},
 			},
 		},
 		SearchSettings: SearchSettings{
@@ -192,6 +224,10 @@ type SearchQuery struct {
 	Outcomes    []EventOutcome    `json:"-"`
 	ActorID     string            `json:"-"`
 	ResourceID  string            `json:"-"`
+	RequestID   string            `json:"-"`
+	// Fields filters on custom fields, name to value; values are matched
+	// exactly
+	Fields      map[string]string `json:"-"`
 	StartTime   *time.Time        `json:"-"`
 	EndTime     *time.Time        `json:"-"`
 	MaxHits     int               `json:"max_hits"`
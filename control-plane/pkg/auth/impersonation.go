@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// Impersonation token lifetimes
+const (
+	DefaultImpersonationDuration    = time.Hour
+	DefaultMaxImpersonationDuration = 4 * time.Hour
+)
+
+// ImpersonationRequest asks for a token operating as a tenant
+type ImpersonationRequest struct {
+	TenantID string `json:"tenant_id" binding:"required"`
+	// Reason is recorded with the session, e.g. a support ticket
+	Reason          string `json:"reason" binding:"required"`
+	DurationMinutes int    `json:"duration_minutes"`
+	// Scopes are limited to those tokens issued for a tenant may carry;
+	// without any the token has a tenant user's default access
+	Scopes []string `json:"scopes"`
+
+	AdminID       string `json:"-"`
+	AdminTenantID string `json:"-"`
+	RemoteAddr    string `json:"-"`
+}
+
+// ImpersonationToken is a started impersonation session and its token
+type ImpersonationToken struct {
+	Session *models.ImpersonationSession `json:"session"`
+	Token   string                       `json:"token"`
+}
+
+// Impersonator lets platform admins operate as a tenant without its
+// credentials. Each impersonation is a session whose short-lived token
+// carries the admin's identity, so every request made with it is audited
+// as the admin acting for the tenant.
+type Impersonator struct {
+	db          *gorm.DB
+	jwtManager  *JWTManager
+	maxDuration time.Duration
+	auditLogger *audit.Logger
+	logger      *zap.Logger
+}
+
+// NewImpersonator creates an impersonator issuing tokens valid for at most
+// maxDuration (default 4h)
+func NewImpersonator(db *gorm.DB, jwtManager *JWTManager, maxDuration time.Duration, logger *zap.Logger) *Impersonator {
+	if maxDuration <= 0 {
+		maxDuration = DefaultMaxImpersonationDuration
+	}
+	return &Impersonator{
+		db:          db,
+		jwtManager:  jwtManager,
+		maxDuration: maxDuration,
+		logger:      logger,
+	}
+}
+
+// SetAuditLogger sets the logger that records sessions starting and
+// ending in the impersonated tenant's audit log
+func (i *Impersonator) SetAuditLogger(auditLogger *audit.Logger) {
+	i.auditLogger = auditLogger
+}
+
+// Start opens an impersonation session and issues its token
+func (i *Impersonator) Start(ctx context.Context, req *ImpersonationRequest) (*ImpersonationToken, error) {
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		return nil, apperror.InvalidInput("reason is required")
+	}
+	if req.AdminID == "" {
+		return nil, apperror.InvalidInput("impersonation requires an identified admin")
+	}
+
+	duration := DefaultImpersonationDuration
+	if req.DurationMinutes > 0 {
+		duration = time.Duration(req.DurationMinutes) * time.Minute
+	}
+	if duration > i.maxDuration {
+		if req.DurationMinutes > 0 {
+			return nil, apperror.InvalidInput("duration_minutes must be at most %d", int(i.maxDuration/time.Minute))
+		}
+		duration = i.maxDuration
+	}
+
+	// Platform and agent scopes reach beyond what a tenant's user may do
+	var scopes []string
+	if len(req.Scopes) > 0 {
+		var err error
+		if scopes, err = issuedScopes(req.Scopes); err != nil {
+			return nil, err
+		}
+	}
+
+	var tenant models.Tenant
+	if err := i.db.WithContext(ctx).Select("id", "status").Where("id = ?", req.TenantID).First(&tenant).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("tenant not found")
+		}
+		return nil, fmt.Errorf("failed to get tenant: %w", err)
+	}
+	if tenant.Status != models.TenantStatusActive {
+		return nil, apperror.InvalidState("tenant %s is %s", tenant.ID, tenant.Status)
+	}
+
+	now := time.Now()
+	session := &models.ImpersonationSession{
+		ID:            uuid.New().String(),
+		TenantID:      req.TenantID,
+		AdminID:       req.AdminID,
+		AdminTenantID: req.AdminTenantID,
+		Reason:        reason,
+		Scopes:        scopes,
+		RemoteAddr:    req.RemoteAddr,
+		ExpiresAt:     now.Add(duration),
+		CreatedAt:     now,
+	}
+	if err := i.db.WithContext(ctx).Create(session).Error; err != nil {
+		return nil, fmt.Errorf("failed to create impersonation session: %w", err)
+	}
+
+	token, err := i.jwtManager.GenerateImpersonationToken(session.TenantID, session.AdminID, session.ID, session.Scopes, session.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	i.logger.Info("impersonation session started",
+		zap.String("session_id", session.ID),
+		zap.String("tenant_id", session.TenantID),
+		zap.String("admin_id", session.AdminID),
+		zap.Time("expires_at", session.ExpiresAt))
+	i.record(ctx, session, audit.ActionLogin, session.AdminID,
+		fmt.Sprintf("%s started impersonating the tenant: %s", session.AdminID, session.Reason))
+
+	return &ImpersonationToken{Session: session, Token: token}, nil
+}
+
+// ListActive returns the sessions whose tokens are still accepted, newest
+// first, optionally only those impersonating one tenant
+func (i *Impersonator) ListActive(ctx context.Context, tenantID string) ([]models.ImpersonationSession, error) {
+	query := i.db.WithContext(ctx).
+		Where("ended_at IS NULL AND expires_at > ?", time.Now())
+	if tenantID != "" {
+		query = query.Where("tenant_id = ?", tenantID)
+	}
+
+	var sessions []models.ImpersonationSession
+	if err := query.Order("created_at DESC").Find(&sessions).Error; err != nil {
+		return nil, fmt.Errorf("failed to list impersonation sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// End ends a session, revoking its token
+func (i *Impersonator) End(ctx context.Context, sessionID, endedBy string) (*models.ImpersonationSession, error) {
+	now := time.Now()
+	result := i.db.WithContext(ctx).Model(&models.ImpersonationSession{}).
+		Where("id = ? AND ended_at IS NULL", sessionID).
+		Updates(map[string]interface{}{"ended_at": now, "ended_by": endedBy})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to end impersonation session: %w", result.Error)
+	}
+
+	var session models.ImpersonationSession
+	if err := i.db.WithContext(ctx).Where("id = ?", sessionID).First(&session).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("impersonation session not found")
+		}
+		return nil, fmt.Errorf("failed to get impersonation session: %w", err)
+	}
+	if result.RowsAffected == 0 {
+		return nil, apperror.InvalidState("impersonation session %s already ended", sessionID)
+	}
+
+	i.logger.Info("impersonation session ended",
+		zap.String("session_id", session.ID),
+		zap.String("tenant_id", session.TenantID),
+		zap.String("ended_by", endedBy))
+	i.record(ctx, &session, audit.ActionLogout, endedBy,
+		fmt.Sprintf("%s ended the impersonation by %s", endedBy, session.AdminID))
+
+	return &session, nil
+}
+
+// record writes a session event to the impersonated tenant's audit log
+func (i *Impersonator) record(ctx context.Context, session *models.ImpersonationSession, action audit.EventAction, actorID, description string) {
+	if i.auditLogger == nil {
+		return
+	}
+	if err := i.auditLogger.NewEventBuilder().
+		WithTenant(session.TenantID).
+		WithType(audit.EventTypeAuth).
+		WithAction(action).
+		WithOutcome(audit.OutcomeSuccess).
+		WithActor(actorID, "user").
+		WithResource(session.ID, "impersonation_session").
+		WithDescription(description).
+		WithMetadata(map[string]interface{}{
+			"impersonated_by":  session.AdminID,
+			"impersonation_id": session.ID,
+			"admin_tenant_id":  session.AdminTenantID,
+			"reason":           session.Reason,
+			"expires_at":       session.ExpiresAt,
+		}).
+		Log(ctx); err != nil {
+		i.logger.Warn("failed to audit impersonation session",
+			zap.String("session_id", session.ID),
+			zap.Error(err))
+	}
+}
+
+// Verify checks that the session behind impersonation claims is still
+// active for the tenant they name
+func (i *Impersonator) Verify(ctx context.Context, claims *Claims) error {
+	var session models.ImpersonationSession
+	if err := i.db.WithContext(ctx).Where("id = ?", claims.ImpersonationID).First(&session).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return apperror.Unauthorized("impersonation session not found")
+		}
+		return fmt.Errorf("failed to get impersonation session: %w", err)
+	}
+	if session.TenantID != claims.TenantID || session.AdminID != claims.ImpersonatedBy {
+		return apperror.Unauthorized("impersonation token does not match its session")
+	}
+	if !session.Active(time.Now()) {
+		return apperror.Unauthorized("impersonation session %s has ended", session.ID)
+	}
+	return nil
+}
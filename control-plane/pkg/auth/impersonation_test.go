@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/internal/dbtest"
+	"github.com/yourorg/control-plane/pkg/apperror"
+)
+
+func TestImpersonationScopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		scopes  []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "none keeps the default access"},
+		{name: "tenant scopes", scopes: []string{"write", " read", "write"}, want: []string{ScopeRead, ScopeWrite}},
+		{name: "admin", scopes: []string{ScopeAdmin}, wantErr: true},
+		{name: "all", scopes: []string{ScopeAll}, wantErr: true},
+		{name: "agent scope", scopes: []string{ScopeRead, ScopeAgentConfig}, wantErr: true},
+		{name: "agent wildcard", scopes: []string{"agent:*"}, wantErr: true},
+		{name: "unknown scope", scopes: []string{"superuser"}, wantErr: true},
+		{name: "empty scope", scopes: []string{""}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sessionScopes driver.Value
+			db := dbtest.Open(t, func(query string, args []driver.Value) (*dbtest.Result, error) {
+				switch {
+				case strings.HasPrefix(query, "SELECT") && strings.Contains(query, "FROM `tenants`"):
+					return &dbtest.Result{Columns: []string{"id", "status"}, Rows: [][]driver.Value{{"tenant-1", "active"}}}, nil
+				case strings.HasPrefix(query, "INSERT INTO `impersonation_sessions`"):
+					sessionScopes = dbtest.Inserted(query, args)["scopes"]
+					return &dbtest.Result{RowsAffected: 1}, nil
+				}
+				t.Fatalf("unexpected statement: %s", query)
+				return nil, nil
+			})
+			i := NewImpersonator(db, NewJWTManager("test-secret", "test", time.Hour), 0, zap.NewNop())
+
+			started, err := i.Start(context.Background(), &ImpersonationRequest{
+				TenantID: "tenant-1",
+				Reason:   "ticket 42",
+				Scopes:   tt.scopes,
+				AdminID:  "admin-1",
+			})
+			if tt.wantErr {
+				if !errors.Is(err, apperror.ErrInvalidInput) {
+					t.Fatalf("Start error = %v, want invalid input", err)
+				}
+				if sessionScopes != nil {
+					t.Errorf("session was recorded with scopes %s", sessionScopes)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Start: %v", err)
+			}
+			if got := strings.Join(started.Session.Scopes, ","); got != strings.Join(tt.want, ",") {
+				t.Errorf("session scopes = %q, want %q", got, strings.Join(tt.want, ","))
+			}
+		})
+	}
+}
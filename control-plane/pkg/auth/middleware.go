@@ -21,8 +21,26 @@ const (
 	ContextKeyTenantID contextKey = "tenant_id"
 	ContextKeyAgentID  contextKey = "agent_id"
 	ContextKeyUserID   contextKey = "user_id"
+	// ContextKeyAuthFailure holds the *AuthFailure of a rejected request
+	ContextKeyAuthFailure contextKey = "auth_failure"
 )
 
+// AuthFailure describes a request whose credentials were rejected.
+// TenantID is the tenant the credentials named, if any, and is not
+// verified.
+type AuthFailure struct {
+	TenantID string
+	Reason   string
+}
+
+// reject aborts a request whose credentials were rejected, recording why
+func reject(c *gin.Context, tenantID, reason string) {
+	c.Set(string(ContextKeyAuthFailure), &AuthFailure{TenantID: tenantID, Reason: reason})
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+		"error": reason,
+	})
+}
+
 // Middleware provides authentication middleware
 type Middleware struct {
 	jwtManager *JWTManager
@@ -44,9 +62,7 @@ func (m *Middleware) Authenticate() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := m.extractToken(c)
 		if token == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "missing authorization token",
-			})
+			reject(c, "", "missing authorization token")
 			return
 		}
 
@@ -54,9 +70,7 @@ func (m *Middleware) Authenticate() gin.HandlerFunc {
 		if err != nil {
 			m.logger.Debug("token validation failed",
 				zap.Error(err))
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid token",
-			})
+			reject(c, m.jwtManager.UnverifiedTenantID(token), "invalid token")
 			return
 		}
 
@@ -67,9 +81,7 @@ func (m *Middleware) Authenticate() gin.HandlerFunc {
 				m.logger.Debug("tenant not found or inactive",
 					zap.String("tenant_id", claims.TenantID),
 					zap.Error(err))
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-					"error": "tenant not found or suspended",
-				})
+				reject(c, claims.TenantID, "tenant not found or suspended")
 				return
 			}
 		}
@@ -93,17 +105,13 @@ func (m *Middleware) AuthenticateAgent() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := m.extractToken(c)
 		if token == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "missing authorization token",
-			})
+			reject(c, "", "missing authorization token")
 			return
 		}
 
 		claims, err := m.jwtManager.ValidateToken(token)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid token",
-			})
+			reject(c, m.jwtManager.UnverifiedTenantID(token), "invalid token")
 			return
 		}
 
@@ -117,9 +125,19 @@ func (m *Middleware) AuthenticateAgent() gin.HandlerFunc {
 		// Verify agent exists
 		var agent models.Agent
 		if err := m.db.Where("id = ? AND tenant_id = ?", claims.AgentID, claims.TenantID).First(&agent).Error; err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "agent not found",
-			})
+			reject(c, claims.TenantID, "agent not found")
+			return
+		}
+
+		// Verify the token has not been revoked
+		if err := checkAgentToken(m.db, &agent, token); err != nil {
+			reject(c, claims.TenantID, err.Error())
+			return
+		}
+
+		// Verify the request was signed with the agent's enrolled key
+		if err := verifyAgentRequest(c, m.db, &agent); err != nil {
+			reject(c, claims.TenantID, err.Error())
 			return
 		}
 
@@ -146,14 +164,7 @@ func (m *Middleware) RequireScopes(requiredScopes ...string) gin.HandlerFunc {
 
 		// Check if user has all required scopes
 		for _, required := range requiredScopes {
-			found := false
-			for _, scope := range authClaims.Scopes {
-				if scope == required || scope == "*" {
-					found = true
-					break
-				}
-			}
-			if !found {
+			if !authClaims.HasScope(required) {
 				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
 					"error":          "insufficient permissions",
 					"required_scope": required,
@@ -207,9 +218,7 @@ func (m *Middleware) APIKeyAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		apiKey := c.GetHeader("X-API-Key")
 		if apiKey == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "API key required",
-			})
+			reject(c, "", "API key required")
 			return
 		}
 
@@ -218,9 +227,7 @@ func (m *Middleware) APIKeyAuth() gin.HandlerFunc {
 
 		var tenantKey models.TenantAPIKey
 		if err := m.db.Where("key_hash = ? AND (expires_at IS NULL OR expires_at > NOW()) AND revoked_at IS NULL", keyHash).First(&tenantKey).Error; err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid API key",
-			})
+			reject(c, "", "invalid API key")
 			return
 		}
 
@@ -230,9 +237,7 @@ func (m *Middleware) APIKeyAuth() gin.HandlerFunc {
 		// Verify tenant is active
 		var tenant models.Tenant
 		if err := m.db.Where("id = ? AND status = ?", tenantKey.TenantID, models.TenantStatusActive).First(&tenant).Error; err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "tenant not found or suspended",
-			})
+			reject(c, tenantKey.TenantID, "tenant not found or suspended")
 			return
 		}
 
@@ -275,6 +280,15 @@ func GetClaimsFromGin(c *gin.Context) *Claims {
 	return nil
 }
 
+// GetAuthFailureFromGin returns why a request's credentials were rejected,
+// or nil
+func GetAuthFailureFromGin(c *gin.Context) *AuthFailure {
+	if failure, exists := c.Get(string(ContextKeyAuthFailure)); exists {
+		return failure.(*AuthFailure)
+	}
+	return nil
+}
+
 // GetTenantIDFromGin extracts tenant ID from Gin context
 func GetTenantIDFromGin(c *gin.Context) string {
 	if tenantID, exists := c.Get(string(ContextKeyTenantID)); exists {
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Token scopes. Read, execute and write grant access within the token's
+// tenant; admin reaches across tenants and "*" grants everything.
+const (
+	ScopeAll     = "*"
+	ScopeAdmin   = "admin"
+	ScopeWrite   = "write"
+	ScopeExecute = "execute"
+	ScopeRead    = "read"
+
+	// Agent scopes grant the reports an agent makes about itself and the
+	// configuration and templates it fetches
+	ScopeAgentHeartbeat = "agent:heartbeat"
+	ScopeAgentHealth    = "agent:health"
+	ScopeAgentResults   = "agent:results"
+	ScopeAgentConfig    = "agent:config"
+)
+
+// AgentScopes are the scopes of agent tokens
+var AgentScopes = []string{ScopeAgentHeartbeat, ScopeAgentHealth, ScopeAgentResults, ScopeAgentConfig}
+
+// impliedScopes lists the scopes each scope includes: write tokens may run
+// what they define, and execute tokens read what they run
+var impliedScopes = map[string][]string{
+	ScopeAdmin:   {ScopeWrite, ScopeExecute, ScopeRead},
+	ScopeWrite:   {ScopeExecute, ScopeRead},
+	ScopeExecute: {ScopeRead},
+}
+
+// issuableScopes are the scopes tokens issued for a tenant may carry
+var issuableScopes = map[string]bool{
+	ScopeRead:    true,
+	ScopeExecute: true,
+	ScopeWrite:   true,
+}
+
+// EffectiveScopes returns the scopes the claims grant. Tokens issued before
+// scoping carry none: agent tokens get AgentScopes and other tokens keep
+// the full tenant access they always had.
+func (c *Claims) EffectiveScopes() []string {
+	if len(c.Scopes) > 0 {
+		return c.Scopes
+	}
+	if c.Type == string(TokenTypeAgent) {
+		return AgentScopes
+	}
+	return []string{ScopeWrite}
+}
+
+// GrantsScope reports whether scopes grant scope, directly, through a scope
+// that implies it or through "*"
+func GrantsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope || s == ScopeAll {
+			return true
+		}
+		for _, implied := range impliedScopes[s] {
+			if implied == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RequireScope returns middleware that lets a request through when its
+// token grants any of the scopes. It must run after the token has been
+// authenticated.
+func RequireScope(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := GetClaimsFromGin(c)
+		if claims == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "authentication required",
+			})
+			return
+		}
+
+		for _, scope := range scopes {
+			if claims.HasScope(scope) {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error":          "insufficient permissions",
+			"required_scope": strings.Join(scopes, " or "),
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGrantsScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		scope  string
+		want   bool
+	}{
+		{"direct", []string{ScopeRead}, ScopeRead, true},
+		{"all", []string{ScopeAll}, ScopeAdmin, true},
+		{"admin implies write", []string{ScopeAdmin}, ScopeWrite, true},
+		{"write implies execute", []string{ScopeWrite}, ScopeExecute, true},
+		{"write implies read", []string{ScopeWrite}, ScopeRead, true},
+		{"execute implies read", []string{ScopeExecute}, ScopeRead, true},
+		{"read does not imply execute", []string{ScopeRead}, ScopeExecute, false},
+		{"execute does not imply write", []string{ScopeExecute}, ScopeWrite, false},
+		{"write does not imply admin", []string{ScopeWrite}, ScopeAdmin, false},
+		{"write does not imply agent scopes", []string{ScopeWrite}, ScopeAgentConfig, false},
+		{"agent scopes do not imply read", AgentScopes, ScopeRead, false},
+		{"agent scopes do not imply write", AgentScopes, ScopeWrite, false},
+		{"none", nil, ScopeRead, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GrantsScope(tt.scopes, tt.scope); got != tt.want {
+				t.Errorf("GrantsScope(%v, %q) = %v, want %v", tt.scopes, tt.scope, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEffectiveScopes(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims Claims
+		scope  string
+		want   bool
+	}{
+		{"unscoped user token keeps write", Claims{Type: "user"}, ScopeWrite, true},
+		{"unscoped user token is not admin", Claims{Type: "user"}, ScopeAdmin, false},
+		{"unscoped agent token gets agent scopes", Claims{Type: string(TokenTypeAgent)}, ScopeAgentHeartbeat, true},
+		{"unscoped agent token cannot read", Claims{Type: string(TokenTypeAgent)}, ScopeRead, false},
+		{"scoped agent token cannot write", Claims{Type: string(TokenTypeAgent), Scopes: AgentScopes}, ScopeWrite, false},
+		{"scoped token is limited to its scopes", Claims{Type: "api", Scopes: []string{ScopeRead}}, ScopeExecute, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.claims.HasScope(tt.scope); got != tt.want {
+				t.Errorf("HasScope(%q) = %v, want %v", tt.scope, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	agentClaims := &Claims{Type: string(TokenTypeAgent), AgentID: "agent-1", TenantID: "tenant-1"}
+	readClaims := &Claims{Type: "api", TenantID: "tenant-1", Scopes: []string{ScopeRead}}
+	writeClaims := &Claims{Type: "user", TenantID: "tenant-1"}
+
+	tests := []struct {
+		name   string
+		claims *Claims
+		scopes []string
+		want   int
+	}{
+		{"no claims", nil, []string{ScopeRead}, http.StatusUnauthorized},
+		{"agent token on read route", agentClaims, []string{ScopeRead}, http.StatusForbidden},
+		{"agent token on write route", agentClaims, []string{ScopeWrite}, http.StatusForbidden},
+		{"agent token on agent route", agentClaims, []string{ScopeAgentConfig}, http.StatusOK},
+		{"user token on agent route", writeClaims, []string{ScopeAgentHeartbeat}, http.StatusForbidden},
+		{"read token on read route", readClaims, []string{ScopeRead}, http.StatusOK},
+		{"read token on write route", readClaims, []string{ScopeWrite}, http.StatusForbidden},
+		{"read token on read or write route", readClaims, []string{ScopeWrite, ScopeRead}, http.StatusOK},
+		{"write token on read route", writeClaims, []string{ScopeRead}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/", func(c *gin.Context) {
+				if tt.claims != nil {
+					c.Set(string(ContextKeyClaims), tt.claims)
+				}
+			}, RequireScope(tt.scopes...), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
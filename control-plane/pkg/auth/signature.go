@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// Headers carrying an agent's request signature
+const (
+	HeaderAgentTimestamp = "X-Agent-Timestamp"
+	HeaderAgentNonce     = "X-Agent-Nonce"
+	HeaderAgentSignature = "X-Agent-Signature"
+	HeaderAgentPublicKey = "X-Agent-Public-Key"
+)
+
+// MaxSignatureSkew is how far a signed request's timestamp may be from the
+// control plane's clock
+const MaxSignatureSkew = 5 * time.Minute
+
+// MaxSignedRequestSize bounds the body of a signed agent request. The body
+// is buffered to be verified before the token's agent is known to have
+// sent it, so the bound applies to every agent route.
+const MaxSignedRequestSize = 32 << 20
+
+// maxNonceLength bounds the nonce of a signed request
+const maxNonceLength = 64
+
+// nonceRetention is how long a nonce is kept: a request signed with it
+// passes the timestamp check for at most twice the skew after it is first
+// seen
+const nonceRetention = 2 * MaxSignatureSkew
+
+// noncePruneInterval is how often expired nonces are deleted
+const noncePruneInterval = 10 * time.Minute
+
+// ParseAgentPublicKey decodes a base64 Ed25519 public key as sent by agents
+func ParseAgentPublicKey(encoded string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("public key is not valid base64: %w", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be a %d-byte Ed25519 key", ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// KeyFingerprint returns the hex SHA-256 of a decoded public key
+func KeyFingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
+
+// EnrollmentMessage is what an agent signs at registration to prove it holds
+// the private key of the public key it enrolls
+func EnrollmentMessage(agentID, publicKey, timestamp string) []byte {
+	return []byte("register\n" + agentID + "\n" + publicKey + "\n" + timestamp)
+}
+
+// RequestMessage is what an agent signs for an authenticated request: the
+// method, path, timestamp, nonce and SHA-256 of the body, newline separated
+func RequestMessage(method, path, timestamp, nonce string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	return []byte(method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(sum[:]))
+}
+
+// VerifySignature checks a base64 signature of message by publicKey, made
+// at timestamp (Unix seconds)
+func VerifySignature(publicKey, timestamp, signature string, message []byte) error {
+	key, err := ParseAgentPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid signature timestamp %q", timestamp)
+	}
+	skew := time.Since(time.Unix(unix, 0))
+	if skew > MaxSignatureSkew || skew < -MaxSignatureSkew {
+		return fmt.Errorf("signature timestamp is outside the allowed %s window", MaxSignatureSkew)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || !ed25519.Verify(key, message, sig) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// RequireAgentSignature returns middleware that verifies an agent token's
+// request was signed with the key bound to the agent at enrollment. It must
+// run after the token has been authenticated.
+func RequireAgentSignature(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := GetClaimsFromGin(c)
+		if claims == nil || claims.AgentID == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "agent token required",
+			})
+			return
+		}
+
+		var agent models.Agent
+		if err := db.Select("id", "tenant_id", "public_key", "identity_reset_at").
+			Where("id = ? AND tenant_id = ?", claims.AgentID, claims.TenantID).
+			First(&agent).Error; err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "agent not found",
+			})
+			return
+		}
+		if err := checkAgentToken(db, &agent, bearerToken(c)); err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		if err := verifyAgentRequest(c, db, &agent); err != nil {
+			status := http.StatusUnauthorized
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			c.AbortWithStatusJSON(status, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// bearerToken returns the token of a request's Authorization header
+func bearerToken(c *gin.Context) string {
+	parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+// checkAgentToken fails unless the token a request authenticated with is
+// stored for the agent and neither revoked nor expired, so revoking a token
+// takes effect before its JWT expires
+func checkAgentToken(db *gorm.DB, agent *models.Agent, token string) error {
+	if token == "" {
+		return fmt.Errorf("agent token required")
+	}
+	var count int64
+	if err := db.Model(&models.AgentToken{}).
+		Where("token_hash = ? AND agent_id = ? AND tenant_id = ? AND revoked_at IS NULL AND expires_at > ?",
+			HashToken(token), agent.ID, agent.TenantID, time.Now()).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check agent token")
+	}
+	if count == 0 {
+		return fmt.Errorf("agent token is revoked or expired")
+	}
+	return nil
+}
+
+// verifyAgentRequest checks the request signature against the agent's bound
+// public key and uses up its nonce, so the request cannot be replayed. The
+// body is restored for the handler.
+//
+// Agents registered before identity binding have no key; their first signed
+// request binds the key it presents, since only that agent holds its token.
+// Afterwards the agent ID can only be re-bound by an admin reset, after
+// which only a signed registration binds a key.
+func verifyAgentRequest(c *gin.Context, db *gorm.DB, agent *models.Agent) error {
+	publicKey := agent.PublicKey
+	bind := publicKey == ""
+	if bind {
+		if agent.IdentityResetAt != nil {
+			return fmt.Errorf("agent identity was reset; re-register the agent to enroll its key")
+		}
+		publicKey = c.GetHeader(HeaderAgentPublicKey)
+		if publicKey == "" {
+			return fmt.Errorf("agent identity is not bound; upgrade or re-register the agent")
+		}
+	}
+
+	nonce := c.GetHeader(HeaderAgentNonce)
+	if nonce == "" || len(nonce) > maxNonceLength {
+		return fmt.Errorf("request nonce is missing or invalid; upgrade the agent")
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, MaxSignedRequestSize))
+	if err != nil {
+		return fmt.Errorf("failed to read request body: %w", err)
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	timestamp := c.GetHeader(HeaderAgentTimestamp)
+	message := RequestMessage(c.Request.Method, c.Request.URL.EscapedPath(), timestamp, nonce, body)
+	if err := VerifySignature(publicKey, timestamp, c.GetHeader(HeaderAgentSignature), message); err != nil {
+		return fmt.Errorf("request signature rejected: %w", err)
+	}
+	if err := useNonce(db, agent.ID, nonce); err != nil {
+		return err
+	}
+
+	if bind {
+		key, _ := ParseAgentPublicKey(publicKey)
+		now := time.Now()
+		// The public_key condition keeps concurrent first requests from
+		// binding different keys
+		result := db.Model(&models.Agent{}).
+			Where("id = ? AND tenant_id = ? AND public_key = ''", agent.ID, agent.TenantID).
+			Updates(map[string]interface{}{
+				"public_key":      publicKey,
+				"key_fingerprint": KeyFingerprint(key),
+				"bound_at":        now,
+			})
+		if result.Error != nil {
+			return fmt.Errorf("failed to bind agent identity")
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("agent identity was bound concurrently; retry the request")
+		}
+	}
+	return nil
+}
+
+// useNonce records the nonce of a verified request, failing if the agent
+// has already used it. The primary key makes the check hold across
+// replicas.
+func useNonce(db *gorm.DB, agentID, nonce string) error {
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.AgentRequestNonce{
+		AgentID:   agentID,
+		Nonce:     nonce,
+		ExpiresAt: time.Now().Add(nonceRetention),
+	})
+	if result.Error != nil {
+		return fmt.Errorf("failed to record request nonce")
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("request nonce was already used")
+	}
+	return nil
+}
+
+// PruneAgentNonces deletes nonces that can no longer be replayed
+func PruneAgentNonces(ctx context.Context, db *gorm.DB) (int64, error) {
+	result := db.WithContext(ctx).
+		Where("expires_at < ?", time.Now()).
+		Delete(&models.AgentRequestNonce{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to prune agent request nonces: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// RunNonceRetention periodically deletes expired nonces until the context
+// is cancelled
+func RunNonceRetention(ctx context.Context, db *gorm.DB, logger *zap.Logger) {
+	ticker := time.NewTicker(noncePruneInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := PruneAgentNonces(ctx, db); err != nil {
+			logger.Error("failed to prune agent request nonces", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/yourorg/control-plane/internal/dbtest"
+	// The agent model has encrypted columns
+	_ "github.com/yourorg/control-plane/pkg/encryption"
+)
+
+func newTestKey(t *testing.T) (string, ed25519.PrivateKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(public), private
+}
+
+func sign(key ed25519.PrivateKey, message []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, message))
+}
+
+func TestVerifySignature(t *testing.T) {
+	publicKey, key := newTestKey(t)
+	otherKey, _ := newTestKey(t)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-MaxSignatureSkew-time.Minute).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(MaxSignatureSkew+time.Minute).Unix(), 10)
+	message := RequestMessage(http.MethodPost, "/api/v1/agent/health", now, "nonce-1", []byte(`{}`))
+
+	tests := []struct {
+		name      string
+		publicKey string
+		timestamp string
+		signature string
+		message   []byte
+		wantErr   string
+	}{
+		{"valid", publicKey, now, sign(key, message), message, ""},
+		{"different key", otherKey, now, sign(key, message), message, "invalid signature"},
+		{"tampered message", publicKey, now, sign(key, message), RequestMessage(http.MethodPost, "/api/v1/agent/health", now, "nonce-2", []byte(`{}`)), "invalid signature"},
+		{"stale timestamp", publicKey, stale, sign(key, message), message, "outside the allowed"},
+		{"future timestamp", publicKey, future, sign(key, message), message, "outside the allowed"},
+		{"malformed timestamp", publicKey, "yesterday", sign(key, message), message, "invalid signature timestamp"},
+		{"malformed signature", publicKey, now, "not base64!", message, "invalid signature"},
+		{"malformed key", "not base64!", now, sign(key, message), message, "not valid base64"},
+		{"short key", base64.StdEncoding.EncodeToString([]byte("short")), now, sign(key, message), message, "Ed25519 key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifySignature(tt.publicKey, tt.timestamp, tt.signature, tt.message)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRequestMessageCoversNonce(t *testing.T) {
+	a := RequestMessage(http.MethodGet, "/api/v1/agent/config", "1", "a", nil)
+	b := RequestMessage(http.MethodGet, "/api/v1/agent/config", "1", "b", nil)
+	if bytes.Equal(a, b) {
+		t.Fatal("messages with different nonces are equal")
+	}
+}
+
+// agentTable answers the agent and token lookups and nonce inserts of the
+// signature middleware, remembering the nonces it has accepted. tokens holds
+// the hashes of the agent's unrevoked tokens.
+type agentTable struct {
+	publicKey string
+	resetAt   *time.Time
+	tokens    map[string]bool
+	nonces    map[string]bool
+	bound     string
+}
+
+func (a *agentTable) handle(query string, args []driver.Value) (*dbtest.Result, error) {
+	switch {
+	case strings.HasPrefix(query, "SELECT count(*) FROM `agent_tokens`"):
+		var count int64
+		if a.tokens[args[0].(string)] {
+			count = 1
+		}
+		return &dbtest.Result{Columns: []string{"count"}, Rows: [][]driver.Value{{count}}}, nil
+	case strings.HasPrefix(query, "SELECT"):
+		return &dbtest.Result{
+			Columns: []string{"id", "tenant_id", "public_key", "identity_reset_at"},
+			Rows:    [][]driver.Value{{"agent-1", "tenant-1", a.publicKey, a.resetAt}},
+		}, nil
+	case strings.HasPrefix(query, "INSERT INTO `agent_request_nonces`"):
+		key := args[0].(string) + "/" + args[1].(string)
+		if a.nonces[key] {
+			return &dbtest.Result{}, nil
+		}
+		a.nonces[key] = true
+		return &dbtest.Result{RowsAffected: 1}, nil
+	case strings.HasPrefix(query, "UPDATE `agents`"):
+		// The key is the only argument that parses as one
+		for _, arg := range args {
+			if s, ok := arg.(string); ok {
+				if _, err := ParseAgentPublicKey(s); err == nil {
+					a.bound = s
+				}
+			}
+		}
+		return &dbtest.Result{RowsAffected: 1}, nil
+	}
+	return &dbtest.Result{}, nil
+}
+
+func TestRequireAgentSignature(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	publicKey, key := newTestKey(t)
+	otherKey, other := newTestKey(t)
+
+	type request struct {
+		// token is the bearer token, the agent's token when empty
+		token     string
+		key       ed25519.PrivateKey
+		publicKey string
+		nonce     string
+		timestamp time.Time
+		body      []byte
+		// signedBody is the body signed, when it differs from the one sent
+		signedBody []byte
+	}
+	signed := func(r request) *http.Request {
+		if r.timestamp.IsZero() {
+			r.timestamp = time.Now()
+		}
+		if r.signedBody == nil {
+			r.signedBody = r.body
+		}
+		timestamp := strconv.FormatInt(r.timestamp.Unix(), 10)
+		if r.token == "" {
+			r.token = "agent-token"
+		}
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/agent/health", bytes.NewReader(r.body))
+		req.Header.Set("Authorization", "Bearer "+r.token)
+		req.Header.Set(HeaderAgentTimestamp, timestamp)
+		if r.nonce != "" {
+			req.Header.Set(HeaderAgentNonce, r.nonce)
+		}
+		req.Header.Set(HeaderAgentPublicKey, r.publicKey)
+		req.Header.Set(HeaderAgentSignature, sign(r.key, RequestMessage(http.MethodPost, "/api/v1/agent/health", timestamp, r.nonce, r.signedBody)))
+		return req
+	}
+
+	tests := []struct {
+		name string
+		// boundKey is the key bound to the agent; empty before binding
+		boundKey string
+		// reset is set when an admin reset the agent's identity
+		reset     bool
+		requests  []request
+		want      []int
+		wantBound string
+	}{
+		{
+			name:     "signed request",
+			boundKey: publicKey,
+			requests: []request{{key: key, publicKey: publicKey, nonce: "n1", body: []byte(`{"overall":"healthy"}`)}},
+			want:     []int{http.StatusOK},
+		},
+		{
+			name:     "replayed nonce",
+			boundKey: publicKey,
+			requests: []request{
+				{key: key, publicKey: publicKey, nonce: "n1", body: []byte(`{}`)},
+				{key: key, publicKey: publicKey, nonce: "n1", body: []byte(`{}`)},
+			},
+			want: []int{http.StatusOK, http.StatusUnauthorized},
+		},
+		{
+			name:     "missing nonce",
+			boundKey: publicKey,
+			requests: []request{{key: key, publicKey: publicKey, body: []byte(`{}`)}},
+			want:     []int{http.StatusUnauthorized},
+		},
+		{
+			name:     "oversized nonce",
+			boundKey: publicKey,
+			requests: []request{{key: key, publicKey: publicKey, nonce: strings.Repeat("n", maxNonceLength+1), body: []byte(`{}`)}},
+			want:     []int{http.StatusUnauthorized},
+		},
+		{
+			name:     "stale timestamp",
+			boundKey: publicKey,
+			requests: []request{{key: key, publicKey: publicKey, nonce: "n1", timestamp: time.Now().Add(-2 * MaxSignatureSkew), body: []byte(`{}`)}},
+			want:     []int{http.StatusUnauthorized},
+		},
+		{
+			name:     "tampered body",
+			boundKey: publicKey,
+			requests: []request{{key: key, publicKey: publicKey, nonce: "n1", body: []byte(`{"overall":"critical"}`), signedBody: []byte(`{}`)}},
+			want:     []int{http.StatusUnauthorized},
+		},
+		{
+			name:     "key other than the bound key",
+			boundKey: publicKey,
+			requests: []request{{key: other, publicKey: otherKey, nonce: "n1", body: []byte(`{}`)}},
+			want:     []int{http.StatusUnauthorized},
+		},
+		{
+			name:     "oversized body",
+			boundKey: publicKey,
+			requests: []request{{key: key, publicKey: publicKey, nonce: "n1", body: make([]byte, MaxSignedRequestSize+1)}},
+			want:     []int{http.StatusRequestEntityTooLarge},
+		},
+		{
+			name:     "revoked token",
+			boundKey: publicKey,
+			requests: []request{{token: "revoked-token", key: key, publicKey: publicKey, nonce: "n1", body: []byte(`{}`)}},
+			want:     []int{http.StatusUnauthorized},
+		},
+		{
+			name:     "missing token",
+			boundKey: publicKey,
+			requests: []request{{token: " ", key: key, publicKey: publicKey, nonce: "n1", body: []byte(`{}`)}},
+			want:     []int{http.StatusUnauthorized},
+		},
+		{
+			name:      "first request binds its key",
+			requests:  []request{{key: other, publicKey: otherKey, nonce: "n1", body: []byte(`{}`)}},
+			want:      []int{http.StatusOK},
+			wantBound: otherKey,
+		},
+		{
+			name:     "reset agent binds no key from a request",
+			reset:    true,
+			requests: []request{{key: other, publicKey: otherKey, nonce: "n1", body: []byte(`{}`)}},
+			want:     []int{http.StatusUnauthorized},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := &agentTable{publicKey: tt.boundKey, tokens: map[string]bool{HashToken("agent-token"): true}, nonces: map[string]bool{}}
+			if tt.reset {
+				resetAt := time.Now()
+				table.resetAt = &resetAt
+			}
+			db := dbtest.Open(t, table.handle)
+
+			router := gin.New()
+			router.POST("/api/v1/agent/health", func(c *gin.Context) {
+				c.Set(string(ContextKeyClaims), &Claims{Type: string(TokenTypeAgent), AgentID: "agent-1", TenantID: "tenant-1"})
+			}, RequireAgentSignature(db), func(c *gin.Context) {
+				// The handler still reads the whole body
+				body, _ := io.ReadAll(c.Request.Body)
+				c.String(http.StatusOK, "%d", len(body))
+			})
+
+			for i, r := range tt.requests {
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, signed(r))
+				if rec.Code != tt.want[i] {
+					t.Fatalf("request %d: status = %d, want %d: %s", i, rec.Code, tt.want[i], rec.Body.String())
+				}
+				if rec.Code == http.StatusOK && rec.Body.String() != strconv.Itoa(len(r.body)) {
+					t.Fatalf("request %d: handler read %s bytes, want %d", i, rec.Body.String(), len(r.body))
+				}
+			}
+			if table.bound != tt.wantBound {
+				t.Errorf("bound key = %q, want %q", table.bound, tt.wantBound)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// DefaultMaxTokenExpiry bounds the lifetime of issued tokens when no
+// maximum is configured
+const DefaultMaxTokenExpiry = 90 * 24 * time.Hour
+
+// TokenRequest asks for a scoped token for a tenant, e.g. a read-only token
+// for a dashboard or an execute token for a CI system
+type TokenRequest struct {
+	// Type is "api" (default) or "user"; user tokens name UserID
+	Type   string   `json:"type"`
+	UserID string   `json:"user_id"`
+	Scopes []string `json:"scopes" binding:"required"`
+	// ExpiresInHours defaults to the configured token expiry
+	ExpiresInHours int `json:"expires_in_hours"`
+
+	TenantID string `json:"-"`
+	IssuedBy string `json:"-"`
+}
+
+// IssuedToken is an issued token and what it grants. The token is only
+// returned once.
+type IssuedToken struct {
+	Token     string    `json:"token"`
+	Type      string    `json:"type"`
+	TenantID  string    `json:"tenant_id"`
+	UserID    string    `json:"user_id,omitempty"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// TokenIssuer issues tokens scoped to what their holder needs
+type TokenIssuer struct {
+	db          *gorm.DB
+	jwtManager  *JWTManager
+	maxExpiry   time.Duration
+	auditLogger *audit.Logger
+	logger      *zap.Logger
+}
+
+// NewTokenIssuer creates a token issuer issuing tokens valid for at most
+// maxExpiry (default 90 days)
+func NewTokenIssuer(db *gorm.DB, jwtManager *JWTManager, maxExpiry time.Duration, logger *zap.Logger) *TokenIssuer {
+	if maxExpiry <= 0 {
+		maxExpiry = DefaultMaxTokenExpiry
+	}
+	return &TokenIssuer{
+		db:         db,
+		jwtManager: jwtManager,
+		maxExpiry:  maxExpiry,
+		logger:     logger,
+	}
+}
+
+// SetAuditLogger sets the logger that records issued tokens in the
+// tenant's audit log
+func (t *TokenIssuer) SetAuditLogger(auditLogger *audit.Logger) {
+	t.auditLogger = auditLogger
+}
+
+// Issue validates a token request and issues the token. Only read, execute
+// and write may be granted; platform scopes are never issued.
+func (t *TokenIssuer) Issue(ctx context.Context, req *TokenRequest) (*IssuedToken, error) {
+	tokenType := TokenType(req.Type)
+	if tokenType == "" {
+		tokenType = TokenTypeAPI
+	}
+	userID := strings.TrimSpace(req.UserID)
+	switch tokenType {
+	case TokenTypeAPI:
+		if userID != "" {
+			return nil, apperror.InvalidInput("user_id is only valid for user tokens")
+		}
+	case TokenTypeUser:
+		if userID == "" {
+			return nil, apperror.InvalidInput("user tokens require user_id")
+		}
+	default:
+		return nil, apperror.InvalidInput("type must be api or user")
+	}
+
+	scopes, err := issuedScopes(req.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	expiry := t.jwtManager.defaultExpiry
+	if req.ExpiresInHours < 0 {
+		return nil, apperror.InvalidInput("expires_in_hours must not be negative")
+	}
+	if req.ExpiresInHours > 0 {
+		expiry = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+	if expiry <= 0 || expiry > t.maxExpiry {
+		if req.ExpiresInHours > 0 {
+			return nil, apperror.InvalidInput("expires_in_hours must be at most %d", int(t.maxExpiry/time.Hour))
+		}
+		expiry = t.maxExpiry
+	}
+
+	var tenant models.Tenant
+	if err := t.db.WithContext(ctx).Select("id", "status").Where("id = ?", req.TenantID).First(&tenant).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("tenant not found")
+		}
+		return nil, fmt.Errorf("failed to get tenant: %w", err)
+	}
+	if tenant.Status != models.TenantStatusActive {
+		return nil, apperror.InvalidState("tenant %s is %s", tenant.ID, tenant.Status)
+	}
+
+	var token string
+	if tokenType == TokenTypeUser {
+		token, err = t.jwtManager.GenerateUserToken(tenant.ID, userID, scopes, expiry)
+	} else {
+		token, err = t.jwtManager.GenerateAPIToken(tenant.ID, scopes, expiry)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	issued := &IssuedToken{
+		Token:     token,
+		Type:      string(tokenType),
+		TenantID:  tenant.ID,
+		UserID:    userID,
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(expiry),
+	}
+
+	t.logger.Info("token issued",
+		zap.String("tenant_id", issued.TenantID),
+		zap.String("type", issued.Type),
+		zap.Strings("scopes", issued.Scopes),
+		zap.String("issued_by", req.IssuedBy),
+		zap.Time("expires_at", issued.ExpiresAt))
+	t.record(ctx, issued, req.IssuedBy)
+
+	return issued, nil
+}
+
+// issuedScopes validates and normalizes the scopes of a token request
+func issuedScopes(requested []string) ([]string, error) {
+	seen := make(map[string]bool, len(requested))
+	scopes := make([]string, 0, len(requested))
+	for _, scope := range requested {
+		scope = strings.TrimSpace(scope)
+		if !issuableScopes[scope] {
+			return nil, apperror.InvalidInput("scope %q cannot be issued; use read, execute or write", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, apperror.InvalidInput("at least one scope is required")
+	}
+	sort.Strings(scopes)
+	return scopes, nil
+}
+
+// record writes an issued token to the tenant's audit log
+func (t *TokenIssuer) record(ctx context.Context, issued *IssuedToken, issuedBy string) {
+	if t.auditLogger == nil {
+		return
+	}
+	if err := t.auditLogger.NewEventBuilder().
+		WithTenant(issued.TenantID).
+		WithType(audit.EventTypeAuth).
+		WithAction(audit.ActionCreate).
+		WithOutcome(audit.OutcomeSuccess).
+		WithActor(issuedBy, "user").
+		WithResource(issued.UserID, issued.Type+"_token").
+		WithDescription(fmt.Sprintf("%s issued a %s token with scopes %s", issuedBy, issued.Type, strings.Join(issued.Scopes, ", "))).
+		WithMetadata(map[string]interface{}{
+			"scopes":     issued.Scopes,
+			"expires_at": issued.ExpiresAt,
+		}).
+		Log(ctx); err != nil {
+		t.logger.Warn("failed to audit issued token",
+			zap.String("tenant_id", issued.TenantID),
+			zap.Error(err))
+	}
+}
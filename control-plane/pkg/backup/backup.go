@@ -0,0 +1,500 @@
+// Package backup writes and restores encrypted snapshots of the control
+// plane: every table of its database, read in one consistent transaction,
+// the archived execution outputs the snapshot references and the
+// configuration file.
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/archive"
+)
+
+// FormatVersion is the version of the backup layout written by Create
+const FormatVersion = 1
+
+// Entries of the backup archive, in the order they are written
+const (
+	manifestEntry = "manifest.json"
+	configPrefix  = "config/"
+	tablePrefix   = "tables/"
+	objectPrefix  = "objects/"
+)
+
+// Manifest describes a backup. It is the first entry of the archive, so
+// restores validate it before touching the database.
+type Manifest struct {
+	FormatVersion int       `json:"format_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	// Version is the control plane version that took the backup
+	Version  string `json:"version"`
+	Database string `json:"database"`
+	// SchemaVersion is the last migration applied to the database, and
+	// Migrations every applied migration
+	SchemaVersion string          `json:"schema_version"`
+	Migrations    []string        `json:"migrations"`
+	Tables        []TableManifest `json:"tables"`
+	Routines      []Routine       `json:"routines,omitempty"`
+	// Objects is the number of archived execution outputs included;
+	// MissingObjects were referenced but not found in the store
+	Objects        int    `json:"objects"`
+	MissingObjects int    `json:"missing_objects"`
+	SkippedObjects bool   `json:"skipped_objects,omitempty"`
+	ConfigFile     string `json:"config_file,omitempty"`
+}
+
+// TableManifest describes a backed up table
+type TableManifest struct {
+	Name      string   `json:"name"`
+	CreateSQL string   `json:"create_sql"`
+	Columns   []string `json:"columns"`
+	Rows      int64    `json:"rows"`
+}
+
+// Routine is a stored procedure or function
+type Routine struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	CreateSQL string `json:"create_sql"`
+}
+
+// Options configures a backup
+type Options struct {
+	// Passphrase encrypts the backup; at least MinPassphraseLength long
+	Passphrase string
+	// Version is recorded in the manifest
+	Version string
+	// Store is the execution archive store; nil when archiving is not
+	// configured
+	Store archive.Store
+	// SkipObjects leaves archived execution outputs out of the backup
+	SkipObjects bool
+	// ConfigFile is included when set
+	ConfigFile string
+	// TempDir holds table dumps while the backup is written; the system
+	// temporary directory by default
+	TempDir string
+}
+
+// Create writes an encrypted backup to w. Tables are read in a single
+// consistent-snapshot transaction and spooled to a temporary directory,
+// so the manifest with their row counts can be written first.
+func Create(ctx context.Context, db *gorm.DB, w io.Writer, opts *Options, logger *zap.Logger) (*Manifest, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database connection: %w", err)
+	}
+	defer conn.Close()
+
+	spool, err := os.MkdirTemp(opts.TempDir, "control-plane-backup-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	defer os.RemoveAll(spool)
+
+	manifest := &Manifest{
+		FormatVersion:  FormatVersion,
+		CreatedAt:      time.Now().UTC(),
+		Version:        opts.Version,
+		SkippedObjects: opts.SkipObjects,
+	}
+	keys, err := snapshot(ctx, conn, spool, manifest, logger)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) > 0 && !opts.SkipObjects && opts.Store == nil {
+		return nil, fmt.Errorf("%d executions have archived outputs but no archive store is configured; configure executions.archive or skip objects", len(keys))
+	}
+	if opts.SkipObjects {
+		keys = nil
+	}
+	// The object count is only known once they are read, so objects are
+	// spooled too
+	objects, err := spoolObjects(ctx, opts.Store, keys, spool, manifest, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	var config []byte
+	if opts.ConfigFile != "" {
+		if config, err = os.ReadFile(opts.ConfigFile); err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		manifest.ConfigFile = filepath.Base(opts.ConfigFile)
+	}
+
+	enc, err := newEncryptWriter(w, opts.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(enc)
+	tw := tar.NewWriter(gz)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeEntry(tw, manifestEntry, data); err != nil {
+		return nil, err
+	}
+	if config != nil {
+		if err := writeEntry(tw, configPrefix+manifest.ConfigFile, config); err != nil {
+			return nil, err
+		}
+	}
+	for _, table := range manifest.Tables {
+		if err := writeFileEntry(tw, tablePrefix+table.Name+".jsonl", filepath.Join(spool, "tables", table.Name)); err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range objects {
+		if err := writeFileEntry(tw, objectPrefix+key, filepath.Join(spool, "objects", filepath.FromSlash(key))); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// snapshot dumps the schema and rows of every table in one transaction and
+// returns the archive keys the executions reference
+func snapshot(ctx context.Context, conn *sql.Conn, spool string, manifest *Manifest, logger *zap.Logger) ([]string, error) {
+	// Timestamps are dumped and restored in UTC so they survive a change
+	// of server time zone
+	for _, stmt := range []string{
+		"SET SESSION time_zone = '+00:00'",
+		"SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ",
+		"START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY",
+	} {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return nil, fmt.Errorf("failed to start snapshot: %w", err)
+		}
+	}
+	defer conn.ExecContext(context.Background(), "ROLLBACK")
+
+	if err := conn.QueryRowContext(ctx, "SELECT DATABASE()").Scan(&manifest.Database); err != nil {
+		return nil, fmt.Errorf("failed to read database name: %w", err)
+	}
+
+	tables, err := queryStrings(ctx, conn,
+		"SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE' ORDER BY table_name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Join(spool, "tables"), 0o700); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, name := range tables {
+		table, err := dumpTable(ctx, conn, name, filepath.Join(spool, "tables", name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to dump %s: %w", name, err)
+		}
+		manifest.Tables = append(manifest.Tables, *table)
+		logger.Info("table dumped", zap.String("table", name), zap.Int64("rows", table.Rows))
+
+		switch name {
+		case "schema_migrations":
+			if manifest.Migrations, err = queryStrings(ctx, conn, "SELECT version FROM schema_migrations ORDER BY version"); err != nil {
+				return nil, fmt.Errorf("failed to read migration history: %w", err)
+			}
+			if n := len(manifest.Migrations); n > 0 {
+				manifest.SchemaVersion = manifest.Migrations[n-1]
+			}
+		case "workflow_executions":
+			if keys, err = queryStrings(ctx, conn, "SELECT archive_key FROM workflow_executions WHERE archive_key IS NOT NULL ORDER BY archive_key"); err != nil {
+				return nil, fmt.Errorf("failed to list archived executions: %w", err)
+			}
+		}
+	}
+	if manifest.SchemaVersion == "" {
+		return nil, fmt.Errorf("database %s has no migration history; run migrate first", manifest.Database)
+	}
+
+	rows, err := conn.QueryContext(ctx,
+		"SELECT routine_name, routine_type FROM information_schema.routines WHERE routine_schema = DATABASE() ORDER BY routine_name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routines: %w", err)
+	}
+	var routines []Routine
+	for rows.Next() {
+		var routine Routine
+		if err := rows.Scan(&routine.Name, &routine.Type); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		routines = append(routines, routine)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, routine := range routines {
+		// SHOW CREATE returns the name, the SQL mode and the statement
+		var name, mode string
+		var create sql.NullString
+		var rest [3]sql.RawBytes
+		if err := conn.QueryRowContext(ctx, fmt.Sprintf("SHOW CREATE %s %s", routine.Type, quoteIdent(routine.Name))).
+			Scan(&name, &mode, &create, &rest[0], &rest[1], &rest[2]); err != nil {
+			return nil, fmt.Errorf("failed to read routine %s: %w", routine.Name, err)
+		}
+		if !create.Valid {
+			return nil, fmt.Errorf("not allowed to read the definition of routine %s", routine.Name)
+		}
+		routine.CreateSQL = create.String
+		manifest.Routines = append(manifest.Routines, routine)
+	}
+
+	return keys, nil
+}
+
+// dumpTable writes the rows of a table to a file, one JSON array of column
+// values per line. Generated columns are left out; they are recomputed.
+func dumpTable(ctx context.Context, conn *sql.Conn, name, file string) (*TableManifest, error) {
+	table := &TableManifest{Name: name}
+
+	var ignored string
+	if err := conn.QueryRowContext(ctx, "SHOW CREATE TABLE "+quoteIdent(name)).Scan(&ignored, &table.CreateSQL); err != nil {
+		return nil, err
+	}
+	columns, err := queryStrings(ctx, conn,
+		"SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND extra NOT LIKE '%GENERATED%' ORDER BY ordinal_position",
+		name)
+	if err != nil {
+		return nil, err
+	}
+	table.Columns = columns
+
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	out := bufio.NewWriter(f)
+
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s", quoteIdents(columns), quoteIdent(name)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	encoded := make([]json.RawMessage, len(columns))
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		for i, value := range values {
+			if encoded[i], err = encodeValue(value); err != nil {
+				return nil, fmt.Errorf("column %s: %w", columns[i], err)
+			}
+		}
+		line, err := json.Marshal(encoded)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(line)
+		if err := out.WriteByte('\n'); err != nil {
+			return nil, err
+		}
+		table.Rows++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := out.Flush(); err != nil {
+		return nil, err
+	}
+	return table, f.Close()
+}
+
+// binaryValue holds column values that are not valid UTF-8
+type binaryValue struct {
+	Base64 string `json:"base64"`
+}
+
+// encodeValue encodes a column value as it reads back from MySQL: null, a
+// string, or the base64 of binary data
+func encodeValue(value interface{}) (json.RawMessage, error) {
+	var text string
+	switch v := value.(type) {
+	case nil:
+		return json.RawMessage("null"), nil
+	case []byte:
+		if !utf8.Valid(v) {
+			return json.Marshal(binaryValue{Base64: base64.StdEncoding.EncodeToString(v)})
+		}
+		text = string(v)
+	case string:
+		text = v
+	case time.Time:
+		// Dates parsed by the driver are written back the way MySQL
+		// prints them
+		if v.IsZero() {
+			text = "0000-00-00 00:00:00"
+		} else {
+			text = v.Format("2006-01-02 15:04:05.999999")
+		}
+	case int64:
+		text = strconv.FormatInt(v, 10)
+	case uint64:
+		text = strconv.FormatUint(v, 10)
+	case float64:
+		text = strconv.FormatFloat(v, 'g', -1, 64)
+	case float32:
+		text = strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case bool:
+		text = "0"
+		if v {
+			text = "1"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", value)
+	}
+	return json.Marshal(text)
+}
+
+// spoolObjects copies the archived execution outputs to the spool
+// directory and returns the keys found
+func spoolObjects(ctx context.Context, store archive.Store, keys []string, spool string, manifest *Manifest, logger *zap.Logger) ([]string, error) {
+	var found []string
+	for _, key := range keys {
+		if !validObjectKey(key) {
+			return nil, fmt.Errorf("invalid archive key %q", key)
+		}
+		data, err := store.Get(ctx, key)
+		if errors.Is(err, archive.ErrNotFound) {
+			logger.Warn("archived execution output not found", zap.String("key", key))
+			manifest.MissingObjects++
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive %s: %w", key, err)
+		}
+
+		file := filepath.Join(spool, "objects", filepath.FromSlash(key))
+		if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(file, data, 0o600); err != nil {
+			return nil, err
+		}
+		found = append(found, key)
+	}
+	manifest.Objects = len(found)
+	return found, nil
+}
+
+// writeEntry adds a file to the archive
+func writeEntry(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o600,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// writeFileEntry adds a spooled file to the archive
+func writeFileEntry(tw *tar.Writer, name, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o600,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// validObjectKey reports whether an archive key is a relative path that
+// stays under the store's root
+func validObjectKey(key string) bool {
+	return key != "" && !path.IsAbs(key) && path.Clean(key) == key &&
+		key != ".." && !strings.HasPrefix(key, "../")
+}
+
+// quoteIdent quotes a table, column or routine name
+func quoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// quoteIdents quotes a list of column names
+func quoteIdents(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteIdent(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// queryStrings returns the first column of a query's rows
+func queryStrings(ctx context.Context, conn *sql.Conn, query string, args ...interface{}) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
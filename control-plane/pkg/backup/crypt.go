@@ -0,0 +1,259 @@
+package backup
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Backups are encrypted with AES-256-GCM under a key derived from a
+// passphrase with scrypt. The header is
+//
+//	magic (8) | scrypt log2 N (1) | r (1) | p (1) | salt (16) | nonce prefix (7)
+//
+// followed by chunks of chunkSize plaintext bytes, each sealed with a nonce
+// of the prefix, the chunk counter and a flag marking the last chunk, and
+// the header as additional data. Reordered, truncated or modified chunks,
+// and wrong passphrases, fail to decrypt.
+const (
+	magic       = "CPBACKUP"
+	chunkSize   = 64 << 10
+	saltSize    = 16
+	prefixSize  = 7
+	headerSize  = len(magic) + 3 + saltSize + prefixSize
+	scryptLogN  = 15
+	scryptR     = 8
+	scryptP     = 1
+	maxLogN     = 20
+	keySize     = 32
+	chunkFinal  = 1
+	maxChunkSeq = 1<<32 - 1
+)
+
+// MinPassphraseLength is the shortest passphrase backups are encrypted with
+const MinPassphraseLength = 12
+
+// ErrDecrypt is returned when a backup cannot be decrypted
+var ErrDecrypt = errors.New("failed to decrypt backup: wrong passphrase or corrupted file")
+
+// deriveKey derives the encryption key from a passphrase
+func deriveKey(passphrase string, salt []byte, logN, r, p int) ([]byte, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("a backup passphrase is required")
+	}
+	return scrypt.Key([]byte(passphrase), salt, 1<<logN, r, p, keySize)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce of a chunk
+func chunkNonce(prefix []byte, seq uint32, final bool) []byte {
+	nonce := make([]byte, prefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[prefixSize:], seq)
+	if final {
+		nonce[prefixSize+4] = chunkFinal
+	}
+	return nonce
+}
+
+// encryptWriter encrypts what is written to it in chunks
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	seq    uint32
+	buf    []byte
+	closed bool
+}
+
+// newEncryptWriter writes the header to w and returns a writer encrypting
+// to it; Close writes the last chunk and must be called
+func newEncryptWriter(w io.Writer, passphrase string) (*encryptWriter, error) {
+	if len(passphrase) < MinPassphraseLength {
+		return nil, fmt.Errorf("backup passphrase must be at least %d characters", MinPassphraseLength)
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	header[len(magic)] = scryptLogN
+	header[len(magic)+1] = scryptR
+	header[len(magic)+2] = scryptP
+	random := header[len(magic)+3:]
+	if _, err := rand.Read(random); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	salt, prefix := random[:saltSize], random[saltSize:]
+
+	key, err := deriveKey(passphrase, salt, scryptLogN, scryptR, scryptP)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		header: header,
+		prefix: prefix,
+		buf:    make([]byte, 0, chunkSize),
+	}, nil
+}
+
+// Write buffers p, sealing full chunks once more data follows them
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, fmt.Errorf("write to closed backup")
+	}
+	written := 0
+	for len(p) > 0 {
+		if len(e.buf) == chunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):chunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the last chunk
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.seal(true)
+}
+
+func (e *encryptWriter) seal(final bool) error {
+	if e.seq == maxChunkSeq {
+		return fmt.Errorf("backup is too large")
+	}
+	sealed := e.aead.Seal(nil, chunkNonce(e.prefix, e.seq, final), e.buf, e.header)
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+	e.seq++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// decryptReader decrypts a backup written by encryptWriter
+type decryptReader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	seq    uint32
+	sealed []byte
+	plain  []byte
+	done   bool
+}
+
+// newDecryptReader reads the header from r and returns a reader decrypting
+// the rest
+func newDecryptReader(r io.Reader, passphrase string) (*decryptReader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("not a control plane backup: %w", err)
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, fmt.Errorf("not a control plane backup")
+	}
+	logN := int(header[len(magic)])
+	if logN < 10 || logN > maxLogN {
+		return nil, fmt.Errorf("unsupported backup key derivation parameters")
+	}
+	salt := header[len(magic)+3 : len(magic)+3+saltSize]
+	prefix := header[len(magic)+3+saltSize:]
+
+	key, err := deriveKey(passphrase, salt, logN, int(header[len(magic)+1]), int(header[len(magic)+2]))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{
+		r:      bufio.NewReaderSize(r, chunkSize+aead.Overhead()),
+		aead:   aead,
+		header: header,
+		prefix: prefix,
+		sealed: make([]byte, chunkSize+aead.Overhead()),
+	}, nil
+}
+
+// Read returns decrypted data; it fails rather than return data past a
+// chunk that does not authenticate
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next chunk. A short chunk, or a full one at
+// the end of the input, must be the last.
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.r, d.sealed)
+	final := false
+	switch err {
+	case nil:
+		if _, err := d.r.Peek(1); err == io.EOF {
+			final = true
+		} else if err != nil {
+			return err
+		}
+	case io.ErrUnexpectedEOF:
+		final = true
+	case io.EOF:
+		return fmt.Errorf("backup is truncated")
+	default:
+		return err
+	}
+
+	plain, err := d.aead.Open(nil, chunkNonce(d.prefix, d.seq, final), d.sealed[:n], d.header)
+	if err != nil {
+		if d.seq > 0 {
+			return fmt.Errorf("backup is truncated or corrupted")
+		}
+		return ErrDecrypt
+	}
+	d.seq++
+	d.plain = plain
+	d.done = final
+	return nil
+}
@@ -0,0 +1,385 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/archive"
+	"github.com/yourorg/control-plane/pkg/db"
+)
+
+// Rows are inserted in batches bounded by count, placeholders and size
+const (
+	insertBatchRows     = 500
+	maxInsertParameters = 65535
+	maxInsertBytes      = 4 << 20
+)
+
+// RestoreOptions configures a restore
+type RestoreOptions struct {
+	// Passphrase the backup was encrypted with
+	Passphrase string
+	// MigrationsDir holds this control plane's migrations. Every migration
+	// recorded in the backup must be among them; the ones the backup
+	// predates are applied after the restore.
+	MigrationsDir string
+	// Store receives the archived execution outputs; nil when archiving
+	// is not configured
+	Store archive.Store
+	// Force drops the tables of a database that is not empty
+	Force bool
+	// DryRun decrypts and validates the whole backup without writing
+	DryRun bool
+	// ConfigOut receives the backed up configuration file when set; it
+	// must not exist
+	ConfigOut string
+}
+
+// RestoreReport summarizes a restore
+type RestoreReport struct {
+	Manifest *Manifest `json:"manifest"`
+	DryRun   bool      `json:"dry_run"`
+	Tables   int       `json:"tables"`
+	Rows     int64     `json:"rows"`
+	Objects  int       `json:"objects"`
+	// ConfigFile is where the configuration file was written
+	ConfigFile string `json:"config_file,omitempty"`
+	// SchemaVersion is the schema version after pending migrations ran
+	SchemaVersion string `json:"schema_version,omitempty"`
+}
+
+// Restore restores a backup written by Create into the database. The
+// backup's schema must be known to this control plane; the database must
+// be empty unless Force is set. Tables are recreated from the backup's
+// definitions and loaded with foreign key checks off, then migrations the
+// backup predates are applied. Encrypted columns are restored as they
+// were, so the master keys in use when the backup was taken must be
+// configured.
+func Restore(ctx context.Context, database *gorm.DB, r io.Reader, opts *RestoreOptions, logger *zap.Logger) (*RestoreReport, error) {
+	dec, err := newDecryptReader(r, opts.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(dec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	manifest, err := readManifest(tr)
+	if err != nil {
+		return nil, err
+	}
+	report := &RestoreReport{Manifest: manifest, DryRun: opts.DryRun}
+
+	runner := db.NewMigrationRunner(database.WithContext(ctx), logger)
+	if err := checkSchema(runner, opts.MigrationsDir, manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Objects > 0 && opts.Store == nil && !opts.DryRun {
+		return nil, fmt.Errorf("backup includes %d archived execution outputs but no archive store is configured", manifest.Objects)
+	}
+
+	var conn *sql.Conn
+	if !opts.DryRun {
+		sqlDB, err := database.DB()
+		if err != nil {
+			return nil, err
+		}
+		if conn, err = sqlDB.Conn(ctx); err != nil {
+			return nil, fmt.Errorf("failed to open database connection: %w", err)
+		}
+		defer conn.Close()
+		if err := prepareDatabase(ctx, conn, manifest, opts.Force, logger); err != nil {
+			return nil, err
+		}
+	}
+
+	tables := make(map[string]*TableManifest, len(manifest.Tables))
+	for i := range manifest.Tables {
+		tables[manifest.Tables[i].Name] = &manifest.Tables[i]
+	}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, fmt.Errorf("failed to read backup: %w", err)
+		}
+
+		switch name := header.Name; {
+		case strings.HasPrefix(name, configPrefix):
+			if err := restoreConfig(tr, opts, report); err != nil {
+				return report, err
+			}
+		case strings.HasPrefix(name, tablePrefix):
+			table := tables[strings.TrimSuffix(strings.TrimPrefix(name, tablePrefix), ".jsonl")]
+			if table == nil {
+				return report, fmt.Errorf("backup entry %s is not in the manifest", name)
+			}
+			rows, err := loadTable(ctx, conn, table, tr)
+			if err != nil {
+				return report, fmt.Errorf("failed to restore %s: %w", table.Name, err)
+			}
+			if rows != table.Rows {
+				return report, fmt.Errorf("backup of %s has %d rows, the manifest lists %d", table.Name, rows, table.Rows)
+			}
+			delete(tables, table.Name)
+			report.Tables++
+			report.Rows += rows
+			logger.Info("table restored", zap.String("table", table.Name), zap.Int64("rows", rows))
+		case strings.HasPrefix(name, objectPrefix):
+			key := strings.TrimPrefix(name, objectPrefix)
+			if !validObjectKey(key) {
+				return report, fmt.Errorf("invalid archive key %q", key)
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return report, fmt.Errorf("failed to read backup: %w", err)
+			}
+			if !opts.DryRun {
+				if err := opts.Store.Put(ctx, key, data); err != nil {
+					return report, fmt.Errorf("failed to restore archive %s: %w", key, err)
+				}
+			}
+			report.Objects++
+		default:
+			return report, fmt.Errorf("unexpected backup entry %s", name)
+		}
+	}
+
+	if len(tables) > 0 {
+		missing := make([]string, 0, len(tables))
+		for name := range tables {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return report, fmt.Errorf("backup is missing the rows of %s", strings.Join(missing, ", "))
+	}
+	if report.Objects != manifest.Objects {
+		return report, fmt.Errorf("backup has %d archived execution outputs, the manifest lists %d", report.Objects, manifest.Objects)
+	}
+	if opts.DryRun {
+		return report, nil
+	}
+
+	// Bring the restored schema up to this control plane's version
+	if err := runner.Run(opts.MigrationsDir); err != nil {
+		return report, fmt.Errorf("restored, but migrating failed: %w", err)
+	}
+	history, err := runner.Status()
+	if err != nil {
+		return report, fmt.Errorf("failed to read migration history: %w", err)
+	}
+	if len(history) > 0 {
+		report.SchemaVersion = history[len(history)-1].Version
+	}
+	return report, nil
+}
+
+// readManifest reads the first entry of a backup
+func readManifest(tr *tar.Reader) (*Manifest, error) {
+	header, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+	if header.Name != manifestEntry {
+		return nil, fmt.Errorf("backup does not start with a manifest")
+	}
+
+	var manifest Manifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %w", err)
+	}
+	if manifest.FormatVersion != FormatVersion {
+		return nil, fmt.Errorf("unsupported backup format version %d", manifest.FormatVersion)
+	}
+	return &manifest, nil
+}
+
+// checkSchema checks that every migration applied to the backed up
+// database is one this control plane has
+func checkSchema(runner *db.MigrationRunner, migrationsDir string, manifest *Manifest) error {
+	available, err := runner.Available(migrationsDir)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(available))
+	for _, migration := range available {
+		known[migration.Version] = true
+	}
+	for _, version := range manifest.Migrations {
+		if !known[version] {
+			return fmt.Errorf("backup schema version %s (taken by control plane %s) includes migration %s, which this control plane does not have; restore with a control plane at least as new",
+				manifest.SchemaVersion, manifest.Version, version)
+		}
+	}
+	return nil
+}
+
+// prepareDatabase configures the restore session and recreates the
+// backup's tables and routines
+func prepareDatabase(ctx context.Context, conn *sql.Conn, manifest *Manifest, force bool, logger *zap.Logger) error {
+	for _, stmt := range []string{
+		"SET SESSION time_zone = '+00:00'",
+		"SET SESSION foreign_key_checks = 0",
+		"SET SESSION unique_checks = 0",
+		"SET SESSION sql_mode = 'NO_AUTO_VALUE_ON_ZERO'",
+	} {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to configure restore session: %w", err)
+		}
+	}
+
+	existing, err := queryStrings(ctx, conn,
+		"SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE'")
+	if err != nil {
+		return fmt.Errorf("failed to list tables: %w", err)
+	}
+	if len(existing) > 0 {
+		if !force {
+			return fmt.Errorf("database is not empty (%d tables); restore into an empty database or force replacing it", len(existing))
+		}
+		logger.Warn("dropping existing tables", zap.Int("tables", len(existing)))
+		for _, name := range existing {
+			if _, err := conn.ExecContext(ctx, "DROP TABLE "+quoteIdent(name)); err != nil {
+				return fmt.Errorf("failed to drop %s: %w", name, err)
+			}
+		}
+	}
+
+	for _, table := range manifest.Tables {
+		if _, err := conn.ExecContext(ctx, table.CreateSQL); err != nil {
+			return fmt.Errorf("failed to create %s: %w", table.Name, err)
+		}
+	}
+	for _, routine := range manifest.Routines {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("DROP %s IF EXISTS %s", routine.Type, quoteIdent(routine.Name))); err != nil {
+			return fmt.Errorf("failed to drop routine %s: %w", routine.Name, err)
+		}
+		if _, err := conn.ExecContext(ctx, routine.CreateSQL); err != nil {
+			return fmt.Errorf("failed to create routine %s: %w", routine.Name, err)
+		}
+	}
+	return nil
+}
+
+// restoreConfig writes the backed up configuration file to ConfigOut
+func restoreConfig(r io.Reader, opts *RestoreOptions, report *RestoreReport) error {
+	if opts.ConfigOut == "" || opts.DryRun {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}
+
+	f, err := os.OpenFile(opts.ConfigOut, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	report.ConfigFile = opts.ConfigOut
+	return nil
+}
+
+// loadTable inserts the rows of a table dump and returns their count. With
+// no connection, the dump is only decoded.
+func loadTable(ctx context.Context, conn *sql.Conn, table *TableManifest, r io.Reader) (int64, error) {
+	columns := len(table.Columns)
+	if columns == 0 {
+		return 0, fmt.Errorf("table has no columns")
+	}
+	batchRows := insertBatchRows
+	if limit := maxInsertParameters / columns; limit < batchRows {
+		batchRows = limit
+	}
+	prefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES ", quoteIdent(table.Name), quoteIdents(table.Columns))
+	group := "(" + strings.TrimSuffix(strings.Repeat("?, ", columns), ", ") + ")"
+
+	var (
+		args      []interface{}
+		batched   int
+		batchSize int
+		total     int64
+	)
+	flush := func() error {
+		if batched == 0 {
+			return nil
+		}
+		if conn != nil {
+			query := prefix + strings.TrimSuffix(strings.Repeat(group+", ", batched), ", ")
+			if _, err := conn.ExecContext(ctx, query, args...); err != nil {
+				return err
+			}
+		}
+		args, batched, batchSize = args[:0], 0, 0
+		return nil
+	}
+
+	dec := json.NewDecoder(r)
+	for {
+		var row []json.RawMessage
+		if err := dec.Decode(&row); err == io.EOF {
+			break
+		} else if err != nil {
+			return total, fmt.Errorf("invalid row %d: %w", total+1, err)
+		}
+		if len(row) != columns {
+			return total, fmt.Errorf("row %d has %d values for %d columns", total+1, len(row), columns)
+		}
+
+		for _, raw := range row {
+			value, err := decodeValue(raw)
+			if err != nil {
+				return total, fmt.Errorf("invalid row %d: %w", total+1, err)
+			}
+			args = append(args, value)
+			batchSize += len(raw)
+		}
+		batched++
+		total++
+		if batched == batchRows || batchSize >= maxInsertBytes {
+			if err := flush(); err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, flush()
+}
+
+// decodeValue decodes a column value written by encodeValue
+func decodeValue(raw json.RawMessage) (interface{}, error) {
+	switch {
+	case string(raw) == "null":
+		return nil, nil
+	case len(raw) > 0 && raw[0] == '"':
+		var text string
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return nil, err
+		}
+		return text, nil
+	default:
+		var binary binaryValue
+		if err := json.Unmarshal(raw, &binary); err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(binary.Base64)
+	}
+}
@@ -0,0 +1,430 @@
+// Package breakglass provides the emergency mode platform admins enable for
+// a tenant during an incident: for a limited time, campaigns of the
+// incident workflow dispatch outside their windows and skip manual phase
+// approvals. Every bypass is audited, the tenant's API requests are audited
+// without sampling, and enabling, revoking and expiry raise alerts.
+package breakglass
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// Gates a session bypasses
+const (
+	GateWindow   = "window"
+	GateApproval = "manual_approval"
+)
+
+// Actor is the approver recorded on phases approved under break-glass
+const Actor = "break-glass"
+
+const (
+	// expiryInterval is how often expired sessions are ended
+	expiryInterval = 30 * time.Second
+	// elevatedTTL is how long the tenants with active sessions are cached
+	// for request auditing
+	elevatedTTL = 15 * time.Second
+	// maxSessionsShown bounds the sessions listed
+	maxSessionsShown = 200
+)
+
+// Config bounds break-glass sessions
+type Config struct {
+	// DefaultDuration is the length of sessions enabled without one
+	DefaultDuration time.Duration `json:"default_duration" yaml:"default_duration"`
+	// MaxDuration is the longest session that may be enabled
+	MaxDuration time.Duration `json:"max_duration" yaml:"max_duration"`
+}
+
+// DefaultConfig returns the default break-glass limits
+func DefaultConfig() *Config {
+	return &Config{
+		DefaultDuration: time.Hour,
+		MaxDuration:     4 * time.Hour,
+	}
+}
+
+// EnableRequest enables break-glass for a tenant's incident workflow
+type EnableRequest struct {
+	TenantID   string `json:"-"`
+	WorkflowID string `json:"workflow_id" binding:"required"`
+	Incident   string `json:"incident" binding:"required"`
+	Reason     string `json:"reason" binding:"required"`
+	// Duration is how long the session lasts, e.g. "2h"; the configured
+	// default when empty
+	Duration  string `json:"duration"`
+	EnabledBy string `json:"-"`
+}
+
+// Manager enables and ends break-glass sessions and reports the active ones
+type Manager struct {
+	db          *gorm.DB
+	config      *Config
+	auditLogger *audit.Logger
+	logger      *zap.Logger
+
+	mu         sync.Mutex
+	elevated   map[string]time.Time
+	elevatedAt time.Time
+}
+
+// NewManager creates a break-glass manager
+func NewManager(db *gorm.DB, config *Config, logger *zap.Logger) *Manager {
+	defaults := DefaultConfig()
+	cfg := *defaults
+	if config != nil {
+		cfg = *config
+	}
+	if cfg.MaxDuration <= 0 {
+		cfg.MaxDuration = defaults.MaxDuration
+	}
+	if cfg.DefaultDuration <= 0 {
+		cfg.DefaultDuration = defaults.DefaultDuration
+	}
+	if cfg.DefaultDuration > cfg.MaxDuration {
+		cfg.DefaultDuration = cfg.MaxDuration
+	}
+	return &Manager{
+		db:     db,
+		config: &cfg,
+		logger: logger,
+	}
+}
+
+// SetAuditLogger sets the logger that receives session events, bypasses
+// and alerts
+func (m *Manager) SetAuditLogger(auditLogger *audit.Logger) {
+	m.auditLogger = auditLogger
+}
+
+// Enable starts a break-glass session. A workflow has at most one active
+// session at a time.
+func (m *Manager) Enable(ctx context.Context, req *EnableRequest) (*models.BreakGlassSession, error) {
+	duration := m.config.DefaultDuration
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			return nil, apperror.InvalidInput("invalid duration %q: must be a positive duration such as 2h", req.Duration)
+		}
+		duration = d
+	}
+	if duration > m.config.MaxDuration {
+		return nil, apperror.InvalidInput("duration may be at most %s", m.config.MaxDuration)
+	}
+
+	var wf models.Workflow
+	if err := m.db.WithContext(ctx).Select("id", "name", "status").
+		Where("id = ? AND tenant_id = ?", req.WorkflowID, req.TenantID).
+		First(&wf).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("workflow not found")
+		}
+		return nil, fmt.Errorf("failed to get workflow: %w", err)
+	}
+	if wf.Status == models.WorkflowStatusDeleted {
+		return nil, apperror.NotFound("workflow not found")
+	}
+
+	now := time.Now()
+	session := &models.BreakGlassSession{
+		ID:         uuid.New().String(),
+		TenantID:   req.TenantID,
+		WorkflowID: wf.ID,
+		Incident:   req.Incident,
+		Reason:     req.Reason,
+		Status:     models.BreakGlassActive,
+		EnabledBy:  req.EnabledBy,
+		ExpiresAt:  now.Add(duration),
+	}
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Serialise enables of the tenant's sessions on its row
+		if err := tx.Exec("SELECT id FROM tenants WHERE id = ? FOR UPDATE", req.TenantID).Error; err != nil {
+			return fmt.Errorf("failed to lock tenant: %w", err)
+		}
+		var active int64
+		if err := tx.Model(&models.BreakGlassSession{}).
+			Where("tenant_id = ? AND workflow_id = ? AND status = ? AND expires_at > ?",
+				req.TenantID, wf.ID, models.BreakGlassActive, now).
+			Count(&active).Error; err != nil {
+			return fmt.Errorf("failed to check active sessions: %w", err)
+		}
+		if active > 0 {
+			return apperror.Conflict("workflow %s already has an active break-glass session", wf.Name)
+		}
+		if err := tx.Create(session).Error; err != nil {
+			return fmt.Errorf("failed to create break-glass session: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.invalidate()
+
+	m.logger.Warn("break-glass enabled",
+		zap.String("session_id", session.ID),
+		zap.String("tenant_id", session.TenantID),
+		zap.String("workflow_id", session.WorkflowID),
+		zap.String("incident", session.Incident),
+		zap.String("enabled_by", session.EnabledBy),
+		zap.Time("expires_at", session.ExpiresAt))
+	m.audit(ctx, session, audit.ActionCreate, session.EnabledBy, "user",
+		fmt.Sprintf("Break-glass enabled for workflow %s until %s: %s", wf.Name, session.ExpiresAt.UTC().Format(time.RFC3339), session.Incident))
+	return session, nil
+}
+
+// Revoke ends an active session before it expires
+func (m *Manager) Revoke(ctx context.Context, tenantID, sessionID, revokedBy string) (*models.BreakGlassSession, error) {
+	session, err := m.Get(ctx, tenantID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := m.db.WithContext(ctx).Model(&models.BreakGlassSession{}).
+		Where("id = ? AND status = ? AND expires_at > ?", session.ID, models.BreakGlassActive, now).
+		Updates(map[string]interface{}{
+			"status":     models.BreakGlassRevoked,
+			"revoked_by": revokedBy,
+			"ended_at":   now,
+			"updated_at": now,
+		})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to revoke break-glass session: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, apperror.InvalidState("break-glass session is not active")
+	}
+	m.invalidate()
+
+	session.Status = models.BreakGlassRevoked
+	session.RevokedBy = revokedBy
+	session.EndedAt = &now
+	m.logger.Warn("break-glass revoked",
+		zap.String("session_id", session.ID),
+		zap.String("tenant_id", session.TenantID),
+		zap.String("revoked_by", revokedBy))
+	m.audit(ctx, session, audit.ActionDelete, revokedBy, "user",
+		fmt.Sprintf("Break-glass revoked for incident %s", session.Incident))
+	return session, nil
+}
+
+// Get returns a tenant's session
+func (m *Manager) Get(ctx context.Context, tenantID, sessionID string) (*models.BreakGlassSession, error) {
+	var session models.BreakGlassSession
+	if err := m.db.WithContext(ctx).Where("id = ? AND tenant_id = ?", sessionID, tenantID).First(&session).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("break-glass session not found")
+		}
+		return nil, fmt.Errorf("failed to get break-glass session: %w", err)
+	}
+	return &session, nil
+}
+
+// List returns a tenant's sessions, newest first, or only the active ones
+func (m *Manager) List(ctx context.Context, tenantID string, activeOnly bool) ([]models.BreakGlassSession, error) {
+	query := m.db.WithContext(ctx).Where("tenant_id = ?", tenantID)
+	if activeOnly {
+		query = query.Where("status = ? AND expires_at > ?", models.BreakGlassActive, time.Now())
+	}
+	var sessions []models.BreakGlassSession
+	if err := query.Order("created_at DESC").Limit(maxSessionsShown).Find(&sessions).Error; err != nil {
+		return nil, fmt.Errorf("failed to list break-glass sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// Active returns the active session of a tenant's workflow, or nil
+func (m *Manager) Active(ctx context.Context, tenantID, workflowID string) (*models.BreakGlassSession, error) {
+	var session models.BreakGlassSession
+	err := m.db.WithContext(ctx).
+		Where("tenant_id = ? AND workflow_id = ? AND status = ? AND expires_at > ?",
+			tenantID, workflowID, models.BreakGlassActive, time.Now()).
+		Order("created_at DESC").
+		First(&session).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active break-glass session: %w", err)
+	}
+	return &session, nil
+}
+
+// Elevated reports whether a tenant has an active session, so that its
+// requests are audited in full. It reads a cache refreshed every few
+// seconds and never fails: a tenant is not elevated if the cache cannot be
+// refreshed and has no entry for it.
+func (m *Manager) Elevated(ctx context.Context, tenantID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if m.elevated == nil || now.Sub(m.elevatedAt) > elevatedTTL {
+		// Failed loads are retried after the TTL too, not on every request
+		m.elevatedAt = now
+		var sessions []models.BreakGlassSession
+		if err := m.db.WithContext(ctx).Select("tenant_id", "expires_at").
+			Where("status = ? AND expires_at > ?", models.BreakGlassActive, now).
+			Find(&sessions).Error; err != nil {
+			m.logger.Warn("failed to load active break-glass sessions", zap.Error(err))
+		} else {
+			m.elevated = make(map[string]time.Time, len(sessions))
+			for _, session := range sessions {
+				if session.ExpiresAt.After(m.elevated[session.TenantID]) {
+					m.elevated[session.TenantID] = session.ExpiresAt
+				}
+			}
+		}
+	}
+	return now.Before(m.elevated[tenantID])
+}
+
+// invalidate makes the next Elevated call reload the active sessions
+func (m *Manager) invalidate() {
+	m.mu.Lock()
+	m.elevated = nil
+	m.mu.Unlock()
+}
+
+// RecordBypass audits a gate a campaign passed under a session
+func (m *Manager) RecordBypass(ctx context.Context, session *models.BreakGlassSession, campaign *models.Campaign, gate string, metadata map[string]interface{}) {
+	m.logger.Warn("campaign gate bypassed under break-glass",
+		zap.String("session_id", session.ID),
+		zap.String("campaign_id", campaign.ID),
+		zap.String("gate", gate))
+	if m.auditLogger == nil {
+		return
+	}
+
+	details := map[string]interface{}{
+		"break_glass_session_id": session.ID,
+		"incident":               session.Incident,
+		"gate":                   gate,
+		"workflow_id":            campaign.WorkflowID,
+	}
+	for key, value := range metadata {
+		details[key] = value
+	}
+	if err := m.auditLogger.NewEventBuilder().
+		WithTenant(campaign.TenantID).
+		WithType(audit.EventTypeCampaign).
+		WithAction(audit.ActionUpdate).
+		WithOutcome(audit.OutcomeSuccess).
+		WithActor(session.ID, "break_glass").
+		WithResource(campaign.ID, "campaign").
+		WithDescription(fmt.Sprintf("Campaign %s bypassed its %s under break-glass for incident %s", campaign.Name, gate, session.Incident)).
+		WithMetadata(details).
+		Log(ctx); err != nil {
+		m.logger.Warn("failed to audit break-glass bypass",
+			zap.String("session_id", session.ID),
+			zap.Error(err))
+	}
+}
+
+// Run ends sessions as they expire until the context is cancelled
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	for {
+		m.expire(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expire marks active sessions past their expiry as expired and alerts on
+// each
+func (m *Manager) expire(ctx context.Context) {
+	now := time.Now()
+	var sessions []models.BreakGlassSession
+	if err := m.db.WithContext(ctx).
+		Where("status = ? AND expires_at <= ?", models.BreakGlassActive, now).
+		Find(&sessions).Error; err != nil {
+		m.logger.Warn("failed to list expired break-glass sessions", zap.Error(err))
+		return
+	}
+
+	for i := range sessions {
+		session := &sessions[i]
+		result := m.db.WithContext(ctx).Model(&models.BreakGlassSession{}).
+			Where("id = ? AND status = ?", session.ID, models.BreakGlassActive).
+			Updates(map[string]interface{}{
+				"status":     models.BreakGlassExpired,
+				"ended_at":   session.ExpiresAt,
+				"updated_at": now,
+			})
+		if result.Error != nil {
+			m.logger.Warn("failed to expire break-glass session",
+				zap.String("session_id", session.ID),
+				zap.Error(result.Error))
+			continue
+		}
+		if result.RowsAffected == 0 {
+			// Revoked or expired by another replica
+			continue
+		}
+
+		session.Status = models.BreakGlassExpired
+		session.EndedAt = &session.ExpiresAt
+		m.logger.Warn("break-glass expired",
+			zap.String("session_id", session.ID),
+			zap.String("tenant_id", session.TenantID))
+		m.audit(ctx, session, audit.ActionStop, Actor, "system", fmt.Sprintf("Break-glass expired for incident %s", session.Incident))
+	}
+	if len(sessions) > 0 {
+		m.invalidate()
+	}
+}
+
+// audit records a session change as a tenant event and raises an alert for
+// notification consumers
+func (m *Manager) audit(ctx context.Context, session *models.BreakGlassSession, action audit.EventAction, actorID, actorType, description string) {
+	if m.auditLogger == nil {
+		return
+	}
+
+	metadata := map[string]interface{}{
+		"kind":                   "break_glass",
+		"break_glass_session_id": session.ID,
+		"workflow_id":            session.WorkflowID,
+		"incident":               session.Incident,
+		"reason":                 session.Reason,
+		"status":                 session.Status,
+		"enabled_by":             session.EnabledBy,
+		"expires_at":             session.ExpiresAt,
+		"bypasses":               []string{GateWindow, GateApproval},
+	}
+	for _, eventType := range []audit.EventType{audit.EventTypeTenant, audit.EventTypeAlert} {
+		if err := m.auditLogger.NewEventBuilder().
+			WithTenant(session.TenantID).
+			WithType(eventType).
+			WithAction(action).
+			WithOutcome(audit.OutcomeSuccess).
+			WithActor(actorID, actorType).
+			WithResource(session.ID, "break_glass_session").
+			WithDescription(description).
+			WithMetadata(metadata).
+			Log(ctx); err != nil {
+			m.logger.Warn("failed to audit break-glass session",
+				zap.String("session_id", session.ID),
+				zap.String("event_type", string(eventType)),
+				zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package campaign
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/diff"
+)
+
+// KindCampaign is the kind of a campaign document
+const KindCampaign = "campaign"
+
+// maxSpecs bounds the documents one apply accepts
+const maxSpecs = 100
+
+// Apply actions
+const (
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionUnchanged = "unchanged"
+)
+
+// Spec is a campaign declared as a YAML document, so rollouts can be kept
+// in a repository and reviewed like code:
+//
+//	kind: campaign
+//	name: nginx-upgrade
+//	workflow: upgrade-nginx
+//	selector: {tags: {role: web}}
+//	phases:
+//	  - {name: canary, agents: [web-01, web-02], success_threshold: 100, manual_approval: true}
+//	  - {name: early, percentage: 5, success_threshold: 100}
+//	  - {name: fleet, percentage: 100, success_threshold: 95}
+//	schedule: 2026-11-02T22:00:00Z
+//	window: {days: [mon, tue, wed, thu], start: "22:00", end: "04:00", timezone: Europe/Berlin}
+//	zones: {tag: region, order: [eu-west, us-east], success_threshold: 95}
+//
+// The campaign is identified by its name. Workflow is the ID or name of an
+// active workflow.
+type Spec struct {
+	Kind        string                 `json:"kind" yaml:"kind"`
+	Name        string                 `json:"name" yaml:"name"`
+	Description string                 `json:"description,omitempty" yaml:"description"`
+	Workflow    string                 `json:"workflow" yaml:"workflow"`
+	Mode        models.CampaignMode    `json:"mode" yaml:"mode"`
+	Selector    map[string]interface{} `json:"selector" yaml:"selector"`
+	Phases      []PhaseConfig          `json:"phases" yaml:"phases"`
+	Schedule    *time.Time             `json:"schedule,omitempty" yaml:"schedule"`
+	Window      *Window                `json:"window,omitempty" yaml:"window"`
+	Zones       *Zones                 `json:"zones,omitempty" yaml:"zones"`
+
+	// WorkflowID is the resolved workflow, shown in diffs so a workflow
+	// replaced under the same name is a change
+	WorkflowID string `json:"workflow_id" yaml:"-"`
+}
+
+// ParseSpecs reads the YAML documents of an apply request. Unknown fields
+// are rejected so a misspelt field is not silently dropped.
+func ParseSpecs(data []byte) ([]*Spec, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	var specs []*Spec
+	for n := 1; ; n++ {
+		var spec *Spec
+		if err := decoder.Decode(&spec); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, apperror.InvalidInput("document %d: %v", n, err)
+		}
+		if spec == nil {
+			continue
+		}
+		if spec.Kind != KindCampaign {
+			return nil, apperror.InvalidInput("document %d: unsupported kind %q", n, spec.Kind)
+		}
+		specs = append(specs, spec)
+		if len(specs) > maxSpecs {
+			return nil, apperror.InvalidInput("at most %d documents can be applied at once", maxSpecs)
+		}
+	}
+	if len(specs) == 0 {
+		return nil, apperror.InvalidInput("no documents to apply")
+	}
+	return specs, nil
+}
+
+// ApplyRequest applies campaign specs. DryRun plans the changes without
+// making them.
+type ApplyRequest struct {
+	TenantID  string
+	AppliedBy string
+	Specs     []*Spec
+	DryRun    bool
+}
+
+// AppliedResource is the outcome of applying one document. Diff compares
+// the existing campaign with the declared one.
+type AppliedResource struct {
+	Kind   string                `json:"kind"`
+	Name   string                `json:"name"`
+	Action string                `json:"action"`
+	ID     string                `json:"id,omitempty"`
+	Status models.CampaignStatus `json:"status,omitempty"`
+	Diff   *diff.Result          `json:"diff"`
+}
+
+// ApplyResult is the outcome of an apply request
+type ApplyResult struct {
+	DryRun    bool              `json:"dry_run"`
+	Resources []AppliedResource `json:"resources"`
+	Created   int               `json:"created"`
+	Updated   int               `json:"updated"`
+	Unchanged int               `json:"unchanged"`
+}
+
+// applyPlan is what applying one spec will do
+type applyPlan struct {
+	spec     *Spec
+	existing *models.Campaign
+	resource AppliedResource
+}
+
+// Apply makes the tenant's campaigns match the specs: campaigns that do not
+// exist are created as drafts, draft campaigns that differ are updated, and
+// the rest are left alone. Campaigns that have started cannot be changed.
+// Every spec is checked before any change is made; should one fail to
+// apply, those before it stay applied and the request can be repeated.
+func (m *Manager) Apply(ctx context.Context, req *ApplyRequest) (*ApplyResult, error) {
+	names := make(map[string]bool, len(req.Specs))
+	plans := make([]*applyPlan, 0, len(req.Specs))
+	for _, spec := range req.Specs {
+		if names[spec.Name] {
+			return nil, apperror.InvalidInput("campaign %q is declared more than once", spec.Name)
+		}
+		names[spec.Name] = true
+
+		plan, err := m.plan(ctx, req.TenantID, spec)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+
+	result := &ApplyResult{DryRun: req.DryRun, Resources: make([]AppliedResource, 0, len(plans))}
+	for _, plan := range plans {
+		if !req.DryRun {
+			if err := m.applyPlan(ctx, req, plan); err != nil {
+				return nil, fmt.Errorf("failed to apply campaign %q: %w", plan.spec.Name, err)
+			}
+		}
+		switch plan.resource.Action {
+		case ActionCreate:
+			result.Created++
+		case ActionUpdate:
+			result.Updated++
+		default:
+			result.Unchanged++
+		}
+		result.Resources = append(result.Resources, plan.resource)
+	}
+
+	if !req.DryRun && result.Created+result.Updated > 0 {
+		m.logger.Info("campaigns applied",
+			zap.String("tenant_id", req.TenantID),
+			zap.String("applied_by", req.AppliedBy),
+			zap.Int("created", result.Created),
+			zap.Int("updated", result.Updated),
+			zap.Int("unchanged", result.Unchanged))
+	}
+	return result, nil
+}
+
+// plan validates a spec and works out what applying it changes
+func (m *Manager) plan(ctx context.Context, tenantID string, spec *Spec) (*applyPlan, error) {
+	if spec.Name == "" {
+		return nil, apperror.InvalidInput("campaign name is required")
+	}
+	if spec.Workflow == "" {
+		return nil, apperror.InvalidInput("campaign %q: workflow is required", spec.Name)
+	}
+	if len(spec.Phases) == 0 {
+		return nil, apperror.InvalidInput("campaign %q: at least one phase is required", spec.Name)
+	}
+	switch spec.Mode {
+	case "":
+		spec.Mode = models.CampaignModeLive
+	case models.CampaignModeLive, models.CampaignModeDarkLaunch:
+	default:
+		return nil, apperror.InvalidInput("campaign %q: invalid mode %q: must be live or dark_launch", spec.Name, spec.Mode)
+	}
+	if spec.Selector == nil {
+		spec.Selector = map[string]interface{}{}
+	}
+	if _, err := parseFlappingFilter(spec.Selector); err != nil {
+		return nil, fmt.Errorf("campaign %q: %w", spec.Name, err)
+	}
+	if err := validatePhases(spec.Phases); err != nil {
+		return nil, fmt.Errorf("campaign %q: %w", spec.Name, err)
+	}
+	if spec.Window != nil {
+		if _, err := spec.Window.validate(); err != nil {
+			return nil, fmt.Errorf("campaign %q: %w", spec.Name, err)
+		}
+	}
+	if spec.Zones != nil {
+		if err := spec.Zones.validate(); err != nil {
+			return nil, fmt.Errorf("campaign %q: %w", spec.Name, err)
+		}
+	}
+	if spec.Schedule != nil {
+		// Stored to the second, in UTC
+		scheduled := spec.Schedule.UTC().Truncate(time.Second)
+		spec.Schedule = &scheduled
+	}
+
+	wf, err := m.resolveWorkflow(ctx, tenantID, spec.Workflow)
+	if err != nil {
+		return nil, fmt.Errorf("campaign %q: %w", spec.Name, err)
+	}
+	spec.Workflow, spec.WorkflowID = wf.Name, wf.ID
+
+	plan := &applyPlan{
+		spec: spec,
+		resource: AppliedResource{
+			Kind: KindCampaign,
+			Name: spec.Name,
+		},
+	}
+	declared, err := spec.document()
+	if err != nil {
+		return nil, err
+	}
+
+	var existing models.Campaign
+	err = m.db.WithContext(ctx).
+		Where("tenant_id = ? AND name = ?", tenantID, spec.Name).
+		Order("created_at DESC").
+		First(&existing).Error
+	if err == gorm.ErrRecordNotFound {
+		plan.resource.Action = ActionCreate
+		plan.resource.Diff = diff.Compute("", "campaign/"+spec.Name, "", declared, diff.DefaultContext)
+		return plan, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get campaign %q: %w", spec.Name, err)
+	}
+	if existing.Type != models.CampaignTypeWorkflow {
+		return nil, apperror.Conflict("campaign %q is a %s campaign and cannot be applied", spec.Name, existing.Type)
+	}
+
+	current, err := m.specOf(ctx, &existing)
+	if err != nil {
+		return nil, err
+	}
+	currentDoc, err := current.document()
+	if err != nil {
+		return nil, err
+	}
+
+	plan.existing = &existing
+	plan.resource.ID = existing.ID
+	plan.resource.Status = existing.Status
+	plan.resource.Diff = diff.Compute("campaign/"+spec.Name, "campaign/"+spec.Name, currentDoc, declared, diff.DefaultContext)
+	if !plan.resource.Diff.Changed {
+		plan.resource.Action = ActionUnchanged
+		return plan, nil
+	}
+	if existing.Status != models.CampaignStatusDraft {
+		return nil, apperror.Conflict("campaign %q is %s; only draft campaigns can be changed", spec.Name, existing.Status)
+	}
+	plan.resource.Action = ActionUpdate
+	return plan, nil
+}
+
+// applyPlan makes the change a plan describes
+func (m *Manager) applyPlan(ctx context.Context, req *ApplyRequest, plan *applyPlan) error {
+	spec := plan.spec
+	switch plan.resource.Action {
+	case ActionCreate:
+		campaign, err := m.Create(ctx, &CreateCampaignRequest{
+			TenantID:       req.TenantID,
+			WorkflowID:     spec.WorkflowID,
+			Name:           spec.Name,
+			Description:    spec.Description,
+			TargetSelector: spec.Selector,
+			PhaseConfig:    spec.Phases,
+			CreatedBy:      req.AppliedBy,
+			Type:           models.CampaignTypeWorkflow,
+			Mode:           spec.Mode,
+			ScheduledAt:    spec.Schedule,
+			Window:         spec.Window,
+			Zones:          spec.Zones,
+		})
+		if err != nil {
+			return err
+		}
+		plan.resource.ID = campaign.ID
+		plan.resource.Status = campaign.Status
+		return nil
+
+	case ActionUpdate:
+		window, err := scheduleColumns(spec.Window)
+		if err != nil {
+			return err
+		}
+		var zones models.JSONMap
+		if spec.Zones != nil {
+			if zones, err = spec.Zones.toMap(); err != nil {
+				return err
+			}
+		}
+		return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			// Draft campaigns have no executions, so their phases are
+			// replaced outright
+			result := tx.Model(&models.Campaign{}).
+				Where("id = ? AND status = ?", plan.existing.ID, models.CampaignStatusDraft).
+				Updates(map[string]interface{}{
+					"workflow_id":     spec.WorkflowID,
+					"description":     spec.Description,
+					"target_selector": models.JSONMap(spec.Selector),
+					"phase_config":    phaseConfigMap(spec.Phases),
+					"campaign_mode":   spec.Mode,
+					"scheduled_at":    spec.Schedule,
+					"rollout_window":  window,
+					"zone_config":     zones,
+					"updated_at":      time.Now(),
+				})
+			if result.Error != nil {
+				return fmt.Errorf("failed to update campaign: %w", result.Error)
+			}
+			if result.RowsAffected == 0 {
+				return apperror.Conflict("campaign %q was started while it was being applied", spec.Name)
+			}
+			if err := tx.Where("campaign_id = ?", plan.existing.ID).Delete(&models.CampaignPhase{}).Error; err != nil {
+				return fmt.Errorf("failed to replace campaign phases: %w", err)
+			}
+			return createPhases(tx, plan.existing.ID, spec.Phases, spec.Zones)
+		})
+	}
+	return nil
+}
+
+// resolveWorkflow finds the active workflow a spec refers to by ID or name,
+// preferring the latest version when several share the name
+func (m *Manager) resolveWorkflow(ctx context.Context, tenantID, ref string) (*models.Workflow, error) {
+	var wf models.Workflow
+	err := m.db.WithContext(ctx).
+		Where("tenant_id = ? AND id = ? AND status = ?", tenantID, ref, models.WorkflowStatusActive).
+		First(&wf).Error
+	if err == gorm.ErrRecordNotFound {
+		err = m.db.WithContext(ctx).
+			Where("tenant_id = ? AND name = ? AND status = ?", tenantID, ref, models.WorkflowStatusActive).
+			Order("version DESC").Order("created_at DESC").
+			First(&wf).Error
+	}
+	if err == gorm.ErrRecordNotFound {
+		return nil, apperror.InvalidState("workflow %q not found or not active", ref)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workflow: %w", err)
+	}
+	return &wf, nil
+}
+
+// specOf describes an existing campaign as a spec
+func (m *Manager) specOf(ctx context.Context, campaign *models.Campaign) (*Spec, error) {
+	spec := &Spec{
+		Kind:        KindCampaign,
+		Name:        campaign.Name,
+		Description: campaign.Description,
+		Workflow:    campaign.WorkflowID,
+		WorkflowID:  campaign.WorkflowID,
+		Mode:        campaign.Mode,
+		Selector:    campaign.TargetSelector,
+		Schedule:    campaign.ScheduledAt,
+	}
+	var wf models.Workflow
+	if err := m.db.WithContext(ctx).Select("name").Where("id = ?", campaign.WorkflowID).First(&wf).Error; err == nil {
+		spec.Workflow = wf.Name
+	}
+	if spec.Schedule != nil {
+		scheduled := spec.Schedule.UTC()
+		spec.Schedule = &scheduled
+	}
+
+	data, err := json.Marshal(campaign.PhaseConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode phase config: %w", err)
+	}
+	var config struct {
+		Phases []PhaseConfig `json:"phases"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to decode phase config: %w", err)
+	}
+	spec.Phases = config.Phases
+
+	if spec.Window, err = campaignWindow(campaign); err != nil {
+		return nil, err
+	}
+	if spec.Zones, err = campaignZones(campaign); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// document renders a spec as the YAML it is diffed as. Going through JSON
+// makes values read from the database and from YAML compare alike.
+func (s *Spec) document() (string, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", fmt.Errorf("failed to render campaign %q: %w", s.Name, err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", fmt.Errorf("failed to render campaign %q: %w", s.Name, err)
+	}
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("failed to render campaign %q: %w", s.Name, err)
+	}
+	return string(out), nil
+}
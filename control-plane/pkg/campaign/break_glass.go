@@ -0,0 +1,62 @@
+package campaign
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/pkg/breakglass"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// breakGlassSession returns the active break-glass session of a campaign's
+// workflow, or nil
+func (d *Dispatcher) breakGlassSession(ctx context.Context, campaign *models.Campaign) (*models.BreakGlassSession, error) {
+	if d.breakGlass == nil {
+		return nil, nil
+	}
+	return d.breakGlass.Active(ctx, campaign.TenantID, campaign.WorkflowID)
+}
+
+// approveUnderBreakGlass approves the campaign's phases awaiting manual
+// approval on behalf of a break-glass session
+func (d *Dispatcher) approveUnderBreakGlass(ctx context.Context, campaign *models.Campaign, session *models.BreakGlassSession) error {
+	var phases []models.CampaignPhase
+	if err := d.db.WithContext(ctx).
+		Where("campaign_id = ? AND status = ?", campaign.ID, models.PhaseStatusAwaitingApproval).
+		Order("phase_order ASC").
+		Find(&phases).Error; err != nil {
+		return fmt.Errorf("failed to list phases awaiting approval: %w", err)
+	}
+
+	for i := range phases {
+		phase := &phases[i]
+		result := d.db.WithContext(ctx).Model(&models.CampaignPhase{}).
+			Where("id = ? AND status = ?", phase.ID, models.PhaseStatusAwaitingApproval).
+			Updates(map[string]interface{}{
+				"status":        models.PhaseStatusSuccess,
+				"approved_by":   breakglass.Actor,
+				"approved_at":   time.Now(),
+				"approval_note": fmt.Sprintf("break-glass session %s for incident %s", session.ID, session.Incident),
+			})
+		if result.Error != nil {
+			return fmt.Errorf("failed to approve campaign phase: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			// Approved or cancelled in the meantime
+			continue
+		}
+
+		d.logger.Warn("campaign phase approved under break-glass",
+			zap.String("campaign_id", campaign.ID),
+			zap.String("phase_name", phase.PhaseName),
+			zap.String("session_id", session.ID))
+		d.breakGlass.RecordBypass(ctx, session, campaign, breakglass.GateApproval, map[string]interface{}{
+			"phase_id":   phase.ID,
+			"phase_name": phase.PhaseName,
+		})
+	}
+	return nil
+}
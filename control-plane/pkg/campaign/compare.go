@@ -0,0 +1,519 @@
+package campaign
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/stats"
+)
+
+// maxCommonTags bounds the tags a comparison reports for newly failing agents
+const maxCommonTags = 10
+
+// Comparison compares a campaign with an earlier baseline campaign of the
+// same workflow. Deltas are the campaign's value minus the baseline's.
+type Comparison struct {
+	WorkflowID string       `json:"workflow_id"`
+	Baseline   RunSummary   `json:"baseline"`
+	Campaign   RunSummary   `json:"campaign"`
+	Deltas     RunDeltas    `json:"deltas"`
+	Phases     []PhaseDelta `json:"phases"`
+	// Steps are the steps that failed on any agent in either run
+	Steps []StepDelta `json:"steps"`
+	// NewlyFailing are the agents that succeeded in the baseline and fail
+	// in the campaign; Recovered the agents that did the reverse
+	NewlyFailing []AgentChange `json:"newly_failing"`
+	Recovered    []AgentChange `json:"recovered"`
+	// CommonTags are the tags most shared by the newly failing agents
+	CommonTags []TagShare `json:"common_tags"`
+}
+
+// RunSummary sums up the outcome of one campaign, from the latest finished
+// execution of each agent it targeted
+type RunSummary struct {
+	CampaignID     string                `json:"campaign_id"`
+	Name           string                `json:"name"`
+	Status         models.CampaignStatus `json:"status"`
+	DefinitionHash string                `json:"definition_hash,omitempty"`
+	StartedAt      *time.Time            `json:"started_at,omitempty"`
+	CompletedAt    *time.Time            `json:"completed_at,omitempty"`
+	// RolloutSeconds is the time from start to completion, once completed
+	RolloutSeconds *float64 `json:"rollout_seconds,omitempty"`
+
+	Agents    int `json:"agents"`
+	InFlight  int `json:"in_flight"`
+	Succeeded int `json:"succeeded"`
+	// Failed counts failed and timed out agents; cancelled agents count
+	// neither way in the success rate
+	Failed      int     `json:"failed"`
+	Cancelled   int     `json:"cancelled"`
+	SuccessRate float64 `json:"success_rate"`
+
+	Duration RunDuration `json:"duration"`
+}
+
+// RunDuration is the duration of the finished executions of a campaign
+type RunDuration struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean_seconds"`
+	P50   float64 `json:"p50_seconds"`
+	P95   float64 `json:"p95_seconds"`
+}
+
+// RunDeltas are the changes from the baseline to the campaign
+type RunDeltas struct {
+	// SuccessRate is in percentage points
+	SuccessRate float64 `json:"success_rate"`
+	Failed      int     `json:"failed"`
+	MeanSeconds float64 `json:"mean_seconds"`
+	P50Seconds  float64 `json:"p50_seconds"`
+	P95Seconds  float64 `json:"p95_seconds"`
+	// RolloutSeconds is set when both campaigns completed
+	RolloutSeconds *float64 `json:"rollout_seconds,omitempty"`
+}
+
+// PhaseDelta compares the success rate of the phases of both campaigns
+// with the same order
+type PhaseDelta struct {
+	Order               int     `json:"order"`
+	Name                string  `json:"name"`
+	BaselineAgents      int     `json:"baseline_agents"`
+	Agents              int     `json:"agents"`
+	BaselineSuccessRate float64 `json:"baseline_success_rate"`
+	SuccessRate         float64 `json:"success_rate"`
+	SuccessRateDelta    float64 `json:"success_rate_delta"`
+}
+
+// StepDelta compares the agents one step failed on in both campaigns
+type StepDelta struct {
+	StepID           string `json:"step_id"`
+	StepName         string `json:"step_name,omitempty"`
+	BaselineFailures int    `json:"baseline_failures"`
+	Failures         int    `json:"failures"`
+	Delta            int    `json:"delta"`
+}
+
+// AgentChange is an agent whose outcome changed between the campaigns
+type AgentChange struct {
+	AgentID        string                 `json:"agent_id"`
+	Hostname       string                 `json:"hostname,omitempty"`
+	BaselineStatus models.ExecutionStatus `json:"baseline_status"`
+	Status         models.ExecutionStatus `json:"status"`
+	ExecutionID    string                 `json:"execution_id"`
+	// FailedStep and Error are from the failing execution
+	FailedStep string                 `json:"failed_step,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	Tags       map[string]interface{} `json:"tags,omitempty"`
+}
+
+// TagShare is a tag carried by newly failing agents: Share is the percent
+// of newly failing agents carrying it, and CampaignShare the percent of all
+// agents the campaign finished on, so a tag over-represented among the
+// failures stands out
+type TagShare struct {
+	Key           string  `json:"key"`
+	Value         string  `json:"value"`
+	Agents        int     `json:"agents"`
+	Share         float64 `json:"share"`
+	CampaignShare float64 `json:"campaign_share"`
+}
+
+// agentOutcome is the latest finished execution of an agent in a campaign
+type agentOutcome struct {
+	execution *models.WorkflowExecution
+	phase     int
+}
+
+// campaignRun is a campaign with the outcome of each agent it targeted
+type campaignRun struct {
+	campaign *models.Campaign
+	phases   []models.CampaignPhase
+	outcomes map[string]*agentOutcome
+	inFlight int
+}
+
+// Compare compares a campaign with a baseline campaign of the same
+// workflow, e.g. this month's patch run with last month's: success rates,
+// durations and failing steps, and the agents that newly fail with the tags
+// they have in common
+func (m *Manager) Compare(ctx context.Context, tenantID, baselineID, campaignID string) (*Comparison, error) {
+	if baselineID == campaignID {
+		return nil, apperror.InvalidInput("baseline must be a different campaign")
+	}
+	baseline, err := m.loadRun(ctx, tenantID, baselineID)
+	if err != nil {
+		return nil, err
+	}
+	current, err := m.loadRun(ctx, tenantID, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	if baseline.campaign.WorkflowID != current.campaign.WorkflowID {
+		return nil, apperror.InvalidInput("campaigns %s and %s run different workflows", baseline.campaign.Name, current.campaign.Name)
+	}
+
+	comparison := &Comparison{
+		WorkflowID:   current.campaign.WorkflowID,
+		Baseline:     baseline.summary(),
+		Campaign:     current.summary(),
+		NewlyFailing: []AgentChange{},
+		Recovered:    []AgentChange{},
+		CommonTags:   []TagShare{},
+	}
+	comparison.Deltas = RunDeltas{
+		SuccessRate: round(comparison.Campaign.SuccessRate - comparison.Baseline.SuccessRate),
+		Failed:      comparison.Campaign.Failed - comparison.Baseline.Failed,
+		MeanSeconds: round(comparison.Campaign.Duration.Mean - comparison.Baseline.Duration.Mean),
+		P50Seconds:  round(comparison.Campaign.Duration.P50 - comparison.Baseline.Duration.P50),
+		P95Seconds:  round(comparison.Campaign.Duration.P95 - comparison.Baseline.Duration.P95),
+	}
+	if comparison.Baseline.RolloutSeconds != nil && comparison.Campaign.RolloutSeconds != nil {
+		delta := round(*comparison.Campaign.RolloutSeconds - *comparison.Baseline.RolloutSeconds)
+		comparison.Deltas.RolloutSeconds = &delta
+	}
+	comparison.Phases = comparePhases(baseline, current)
+	comparison.Steps = compareSteps(baseline, current)
+
+	agentIDs := make([]string, 0, len(current.outcomes))
+	for agentID := range current.outcomes {
+		agentIDs = append(agentIDs, agentID)
+	}
+	agents := make(map[string]*models.Agent, len(agentIDs))
+	if len(agentIDs) > 0 {
+		var rows []models.Agent
+		if err := m.db.WithContext(ctx).
+			Select("id", "hostname", "tags").
+			Where("tenant_id = ? AND id IN ?", tenantID, agentIDs).
+			Find(&rows).Error; err != nil {
+			return nil, fmt.Errorf("failed to load campaign agents: %w", err)
+		}
+		for i := range rows {
+			agents[rows[i].ID] = &rows[i]
+		}
+	}
+
+	for agentID, outcome := range current.outcomes {
+		before, ok := baseline.outcomes[agentID]
+		if !ok {
+			continue
+		}
+		succeeded := outcome.execution.Status == models.ExecutionStatusSuccess
+		succeededBefore := before.execution.Status == models.ExecutionStatusSuccess
+		switch {
+		case succeededBefore && executionFailed(outcome.execution.Status):
+			comparison.NewlyFailing = append(comparison.NewlyFailing, agentChange(agents[agentID], before, outcome, outcome))
+		case executionFailed(before.execution.Status) && succeeded:
+			comparison.Recovered = append(comparison.Recovered, agentChange(agents[agentID], before, outcome, before))
+		}
+	}
+	sortChanges(comparison.NewlyFailing)
+	sortChanges(comparison.Recovered)
+	comparison.CommonTags = commonTags(comparison.NewlyFailing, current, agents)
+
+	return comparison, nil
+}
+
+// loadRun loads a campaign and the latest finished execution of each agent
+func (m *Manager) loadRun(ctx context.Context, tenantID, campaignID string) (*campaignRun, error) {
+	campaign, err := m.Get(ctx, tenantID, campaignID)
+	if err != nil {
+		return nil, err
+	}
+
+	var executions []models.WorkflowExecution
+	if err := m.db.WithContext(ctx).
+		Select("id", "agent_id", "status", "result", "started_at", "completed_at", "created_at").
+		Where("campaign_id = ?", campaignID).
+		Order("created_at ASC").
+		Find(&executions).Error; err != nil {
+		return nil, fmt.Errorf("failed to load campaign executions: %w", err)
+	}
+
+	run := &campaignRun{
+		campaign: campaign,
+		phases:   append([]models.CampaignPhase(nil), campaign.Phases...),
+		outcomes: make(map[string]*agentOutcome),
+	}
+	sort.Slice(run.phases, func(i, j int) bool { return run.phases[i].PhaseOrder < run.phases[j].PhaseOrder })
+
+	// An agent's latest execution decides its outcome, so a retry that is
+	// still running leaves the agent in flight
+	latest := make(map[string]*models.WorkflowExecution)
+	for i := range executions {
+		latest[executions[i].AgentID] = &executions[i]
+	}
+	for agentID, execution := range latest {
+		if !execution.IsComplete() {
+			run.inFlight++
+			continue
+		}
+		run.outcomes[agentID] = &agentOutcome{
+			execution: execution,
+			phase:     executionPhase(run.phases, execution.CreatedAt),
+		}
+	}
+	return run, nil
+}
+
+// summary sums up the outcomes of a campaign run
+func (r *campaignRun) summary() RunSummary {
+	campaign := r.campaign
+	summary := RunSummary{
+		CampaignID:     campaign.ID,
+		Name:           campaign.Name,
+		Status:         campaign.Status,
+		DefinitionHash: campaign.DefinitionHash,
+		StartedAt:      campaign.StartedAt,
+		CompletedAt:    campaign.CompletedAt,
+		Agents:         len(r.outcomes),
+		InFlight:       r.inFlight,
+	}
+	if campaign.StartedAt != nil && campaign.CompletedAt != nil {
+		rollout := round(campaign.CompletedAt.Sub(*campaign.StartedAt).Seconds())
+		summary.RolloutSeconds = &rollout
+	}
+
+	durations := make([]float64, 0, len(r.outcomes))
+	for _, outcome := range r.outcomes {
+		switch status := outcome.execution.Status; {
+		case status == models.ExecutionStatusSuccess:
+			summary.Succeeded++
+		case executionFailed(status):
+			summary.Failed++
+		default:
+			summary.Cancelled++
+		}
+		if d := outcome.execution.Duration(); d != nil {
+			durations = append(durations, d.Seconds())
+		}
+	}
+	summary.SuccessRate = successRate(summary.Succeeded, summary.Failed)
+	summary.Duration = runDuration(durations)
+	return summary
+}
+
+// comparePhases pairs the phases of both campaigns by order
+func comparePhases(baseline, current *campaignRun) []PhaseDelta {
+	count := max(len(baseline.phases), len(current.phases))
+	deltas := make([]PhaseDelta, count)
+	for i := range deltas {
+		delta := &deltas[i]
+		if i < len(baseline.phases) {
+			delta.Order = baseline.phases[i].PhaseOrder
+			delta.Name = baseline.phases[i].PhaseName
+		}
+		if i < len(current.phases) {
+			delta.Order = current.phases[i].PhaseOrder
+			delta.Name = current.phases[i].PhaseName
+		}
+		var baseSucceeded, baseFailed, succeeded, failed int
+		delta.BaselineAgents, baseSucceeded, baseFailed = baseline.phaseOutcomes(i)
+		delta.Agents, succeeded, failed = current.phaseOutcomes(i)
+		delta.BaselineSuccessRate = successRate(baseSucceeded, baseFailed)
+		delta.SuccessRate = successRate(succeeded, failed)
+		delta.SuccessRateDelta = round(delta.SuccessRate - delta.BaselineSuccessRate)
+	}
+	return deltas
+}
+
+// phaseOutcomes counts the agents finished in the phase at index, and those
+// of them that succeeded and failed
+func (r *campaignRun) phaseOutcomes(index int) (agents, succeeded, failed int) {
+	for _, outcome := range r.outcomes {
+		if outcome.phase != index {
+			continue
+		}
+		agents++
+		if outcome.execution.Status == models.ExecutionStatusSuccess {
+			succeeded++
+		} else if executionFailed(outcome.execution.Status) {
+			failed++
+		}
+	}
+	return agents, succeeded, failed
+}
+
+// compareSteps counts the agents each step failed on in both campaigns
+func compareSteps(baseline, current *campaignRun) []StepDelta {
+	byID := make(map[string]*StepDelta)
+	count := func(run *campaignRun, failures func(*StepDelta) *int) {
+		for _, outcome := range run.outcomes {
+			for _, step := range failedSteps(outcome.execution) {
+				delta, ok := byID[step.StepID]
+				if !ok {
+					delta = &StepDelta{StepID: step.StepID}
+					byID[step.StepID] = delta
+				}
+				if step.StepName != "" {
+					delta.StepName = step.StepName
+				}
+				*failures(delta)++
+			}
+		}
+	}
+	count(baseline, func(d *StepDelta) *int { return &d.BaselineFailures })
+	count(current, func(d *StepDelta) *int { return &d.Failures })
+
+	steps := make([]StepDelta, 0, len(byID))
+	for _, delta := range byID {
+		delta.Delta = delta.Failures - delta.BaselineFailures
+		steps = append(steps, *delta)
+	}
+	sort.Slice(steps, func(i, j int) bool {
+		if steps[i].Delta != steps[j].Delta {
+			return steps[i].Delta > steps[j].Delta
+		}
+		return steps[i].StepID < steps[j].StepID
+	})
+	return steps
+}
+
+// failedSteps returns the steps that failed in an execution
+func failedSteps(execution *models.WorkflowExecution) []CheckFailure {
+	var failures []CheckFailure
+	steps, _ := execution.Result["steps"].([]interface{})
+	for _, raw := range steps {
+		step, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if status, _ := step["status"].(string); status != "failed" {
+			continue
+		}
+		failure := CheckFailure{}
+		failure.StepID, _ = step["step_id"].(string)
+		failure.StepName, _ = step["step_name"].(string)
+		failure.Error, _ = step["error"].(string)
+		failures = append(failures, failure)
+	}
+	return failures
+}
+
+// agentChange describes an agent whose outcome changed; failing is the
+// outcome its failed step and error are read from
+func agentChange(agent *models.Agent, before, after, failing *agentOutcome) AgentChange {
+	change := AgentChange{
+		AgentID:        after.execution.AgentID,
+		BaselineStatus: before.execution.Status,
+		Status:         after.execution.Status,
+		ExecutionID:    after.execution.ID,
+	}
+	if agent != nil {
+		change.Hostname = agent.Hostname
+		change.Tags = agent.Tags
+	}
+	if steps := failedSteps(failing.execution); len(steps) > 0 {
+		change.FailedStep = steps[0].StepID
+		change.Error = steps[0].Error
+	}
+	if errMsg, ok := failing.execution.Result["error"].(string); ok && errMsg != "" {
+		change.Error = errMsg
+	}
+	return change
+}
+
+// sortChanges orders agent changes by hostname, then ID
+func sortChanges(changes []AgentChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Hostname != changes[j].Hostname {
+			return changes[i].Hostname < changes[j].Hostname
+		}
+		return changes[i].AgentID < changes[j].AgentID
+	})
+}
+
+// commonTags returns the tags most carried by the newly failing agents,
+// with their share of the agents the campaign finished on
+func commonTags(failing []AgentChange, current *campaignRun, agents map[string]*models.Agent) []TagShare {
+	if len(failing) == 0 {
+		return []TagShare{}
+	}
+
+	counts := make(map[[2]string]int)
+	for _, change := range failing {
+		for key, value := range change.Tags {
+			counts[[2]string{key, fmt.Sprint(value)}]++
+		}
+	}
+	campaignCounts := make(map[[2]string]int)
+	for agentID := range current.outcomes {
+		agent := agents[agentID]
+		if agent == nil {
+			continue
+		}
+		for key, value := range agent.Tags {
+			tag := [2]string{key, fmt.Sprint(value)}
+			if _, ok := counts[tag]; ok {
+				campaignCounts[tag]++
+			}
+		}
+	}
+
+	tags := make([]TagShare, 0, len(counts))
+	for tag, count := range counts {
+		tags = append(tags, TagShare{
+			Key:           tag[0],
+			Value:         tag[1],
+			Agents:        count,
+			Share:         round(float64(count) / float64(len(failing)) * 100),
+			CampaignShare: round(float64(campaignCounts[tag]) / float64(len(current.outcomes)) * 100),
+		})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Agents != tags[j].Agents {
+			return tags[i].Agents > tags[j].Agents
+		}
+		if tags[i].CampaignShare != tags[j].CampaignShare {
+			return tags[i].CampaignShare < tags[j].CampaignShare
+		}
+		if tags[i].Key != tags[j].Key {
+			return tags[i].Key < tags[j].Key
+		}
+		return tags[i].Value < tags[j].Value
+	})
+	if len(tags) > maxCommonTags {
+		tags = tags[:maxCommonTags]
+	}
+	return tags
+}
+
+// executionFailed reports whether an execution status counts as a failure
+func executionFailed(status models.ExecutionStatus) bool {
+	return status == models.ExecutionStatusFailed || status == models.ExecutionStatusTimeout
+}
+
+// successRate returns succeeded out of succeeded and failed in percent
+func successRate(succeeded, failed int) float64 {
+	if succeeded+failed == 0 {
+		return 0
+	}
+	return round(float64(succeeded) / float64(succeeded+failed) * 100)
+}
+
+// runDuration sums up execution durations in seconds
+func runDuration(durations []float64) RunDuration {
+	if len(durations) == 0 {
+		return RunDuration{}
+	}
+	sort.Float64s(durations)
+	var total float64
+	for _, d := range durations {
+		total += d
+	}
+	return RunDuration{
+		Count: len(durations),
+		Mean:  round(total / float64(len(durations))),
+		P50:   round(stats.Percentile(durations, 50)),
+		P95:   round(stats.Percentile(durations, 95)),
+	}
+}
+
+// round rounds to three decimals
+func round(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
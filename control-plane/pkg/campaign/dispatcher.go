@@ -0,0 +1,600 @@
+package campaign
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/breakglass"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/workflow"
+)
+
+// DefaultDispatchInterval is how often running campaigns are advanced
+const DefaultDispatchInterval = 15 * time.Second
+
+// inFlightStatuses are the statuses of executions still occupying an agent
+var inFlightStatuses = []models.ExecutionStatus{
+	models.ExecutionStatusPending,
+	models.ExecutionStatusRunning,
+	models.ExecutionStatusPaused,
+}
+
+// DispatcherConfig controls how fast campaigns hand work to agents
+type DispatcherConfig struct {
+	Interval time.Duration
+
+	// MaxInFlightPerTenant caps the pending and running executions of a
+	// tenant while campaign executions are dispatched. A tenant's
+	// quota_concurrent_executions overrides it; 0 means no cap.
+	MaxInFlightPerTenant int
+
+	// MaxAgentJobs defers dispatch to agents running at least this many
+	// jobs. Agents are always deferred at their own max_concurrent; 0 leaves
+	// only that limit.
+	MaxAgentJobs int
+}
+
+// Dispatcher starts the phases of running campaigns and dispatches their
+// executions. Dispatch is throttled: agents whose queue is full and tenants
+// at their concurrency cap are skipped and retried on the next tick, so
+// jobs are delayed rather than piled onto busy agents.
+type Dispatcher struct {
+	db         *gorm.DB
+	phases     *PhaseExecutor
+	executor   *workflow.Executor
+	breakGlass *breakglass.Manager
+	config     DispatcherConfig
+	logger     *zap.Logger
+}
+
+// NewDispatcher creates a new campaign dispatcher
+func NewDispatcher(db *gorm.DB, executor *workflow.Executor, config *DispatcherConfig, logger *zap.Logger) *Dispatcher {
+	cfg := DispatcherConfig{}
+	if config != nil {
+		cfg = *config
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultDispatchInterval
+	}
+	return &Dispatcher{
+		db:       db,
+		phases:   NewPhaseExecutor(db, logger),
+		executor: executor,
+		config:   cfg,
+		logger:   logger,
+	}
+}
+
+// SetBreakGlass lets campaigns of workflows under an active break-glass
+// session dispatch outside their windows and pass manual approvals
+func (d *Dispatcher) SetBreakGlass(manager *breakglass.Manager) {
+	d.breakGlass = manager
+}
+
+// Run advances running campaigns until the context is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.config.Interval)
+	defer ticker.Stop()
+
+	d.dispatchAll(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.dispatchAll(ctx)
+		}
+	}
+}
+
+// dispatchAll starts the scheduled campaigns that are due and advances every
+// running campaign. Tenant budgets are shared by the tenant's campaigns
+// within a tick.
+func (d *Dispatcher) dispatchAll(ctx context.Context) {
+	d.startScheduled(ctx)
+
+	var campaigns []models.Campaign
+	if err := d.db.WithContext(ctx).
+		Where("status = ?", models.CampaignStatusRunning).
+		Order("started_at ASC").
+		Find(&campaigns).Error; err != nil {
+		d.logger.Error("failed to list running campaigns", zap.Error(err))
+		return
+	}
+
+	budgets := make(map[string]*tenantBudget)
+	for i := range campaigns {
+		campaign := &campaigns[i]
+		budget, ok := budgets[campaign.TenantID]
+		if !ok {
+			var err error
+			if budget, err = d.tenantBudget(ctx, campaign.TenantID); err != nil {
+				d.logger.Warn("failed to load tenant dispatch budget",
+					zap.String("tenant_id", campaign.TenantID),
+					zap.Error(err))
+				continue
+			}
+			budgets[campaign.TenantID] = budget
+		}
+
+		if err := d.advance(ctx, campaign, budget); err != nil {
+			d.logger.Warn("failed to advance campaign",
+				zap.String("campaign_id", campaign.ID),
+				zap.Error(err))
+		}
+	}
+}
+
+// startScheduled starts the draft campaigns whose scheduled time has passed,
+// pinning their workflow definitions. The update is conditional on the
+// status, so each campaign starts once across replicas.
+func (d *Dispatcher) startScheduled(ctx context.Context) {
+	now := time.Now()
+	var due []models.Campaign
+	if err := d.db.WithContext(ctx).
+		Where("status = ? AND scheduled_at IS NOT NULL AND scheduled_at <= ?", models.CampaignStatusDraft, now).
+		Find(&due).Error; err != nil {
+		d.logger.Error("failed to list scheduled campaigns", zap.Error(err))
+		return
+	}
+
+	for i := range due {
+		campaign := &due[i]
+		updates := map[string]interface{}{
+			"status":     models.CampaignStatusRunning,
+			"started_at": now,
+			"updated_at": now,
+		}
+		if campaign.DefinitionHash == "" {
+			hash, err := pinWorkflow(ctx, d.db, campaign)
+			if err != nil {
+				d.logger.Warn("failed to start scheduled campaign",
+					zap.String("campaign_id", campaign.ID),
+					zap.Error(err))
+				continue
+			}
+			updates["definition_hash"] = hash
+		}
+
+		result := d.db.WithContext(ctx).Model(&models.Campaign{}).
+			Where("id = ? AND status = ?", campaign.ID, models.CampaignStatusDraft).
+			Updates(updates)
+		if result.Error != nil {
+			d.logger.Error("failed to start scheduled campaign",
+				zap.String("campaign_id", campaign.ID),
+				zap.Error(result.Error))
+			continue
+		}
+		if result.RowsAffected > 0 {
+			d.logger.Info("scheduled campaign started",
+				zap.String("campaign_id", campaign.ID),
+				zap.Time("scheduled_at", *campaign.ScheduledAt))
+		}
+	}
+}
+
+// tenantBudget tracks how many more executions a tenant may start
+type tenantBudget struct {
+	limit     int // 0 means unlimited
+	remaining int
+}
+
+// take reserves one execution slot, reporting false when the tenant is at
+// its cap
+func (b *tenantBudget) take() bool {
+	if b.limit == 0 {
+		return true
+	}
+	if b.remaining <= 0 {
+		return false
+	}
+	b.remaining--
+	return true
+}
+
+// release returns a slot reserved by take
+func (b *tenantBudget) release() {
+	if b.limit > 0 {
+		b.remaining++
+	}
+}
+
+// exhausted reports whether no slot is left
+func (b *tenantBudget) exhausted() bool {
+	return b.limit > 0 && b.remaining <= 0
+}
+
+// tenantBudget returns the tenant's concurrency cap less its in-flight
+// executions
+func (d *Dispatcher) tenantBudget(ctx context.Context, tenantID string) (*tenantBudget, error) {
+	var tenant struct {
+		QuotaConcurrentExecutions int
+	}
+	if err := d.db.WithContext(ctx).Table("tenants").
+		Select("quota_concurrent_executions").
+		Where("id = ?", tenantID).
+		First(&tenant).Error; err != nil {
+		return nil, err
+	}
+
+	limit := d.config.MaxInFlightPerTenant
+	if tenant.QuotaConcurrentExecutions > 0 {
+		limit = tenant.QuotaConcurrentExecutions
+	}
+	if limit <= 0 {
+		return &tenantBudget{}, nil
+	}
+
+	var inFlight int64
+	if err := d.db.WithContext(ctx).Model(&models.WorkflowExecution{}).
+		Where("tenant_id = ? AND status IN ?", tenantID, inFlightStatuses).
+		Count(&inFlight).Error; err != nil {
+		return nil, err
+	}
+
+	return &tenantBudget{limit: limit, remaining: limit - int(inFlight)}, nil
+}
+
+// advance starts the next phase of a campaign when none is running,
+// dispatches the running phase's remaining agents and completes the phase
+// once all of its executions have finished. Outside the campaign's window
+// only in-flight executions are waited on, unless its workflow is under
+// break-glass, which also approves phases awaiting manual approval.
+func (d *Dispatcher) advance(ctx context.Context, campaign *models.Campaign, budget *tenantBudget) error {
+	open, err := inWindow(campaign, time.Now())
+	if err != nil {
+		return err
+	}
+
+	session, err := d.breakGlassSession(ctx, campaign)
+	if err != nil {
+		return err
+	}
+	bypassWindow := !open && session != nil
+	if session != nil {
+		if err := d.approveUnderBreakGlass(ctx, campaign, session); err != nil {
+			return err
+		}
+		open = true
+	}
+
+	var phase models.CampaignPhase
+	err = d.db.WithContext(ctx).
+		Where("campaign_id = ? AND status = ?", campaign.ID, models.PhaseStatusRunning).
+		Order("phase_order ASC").
+		First(&phase).Error
+	if err == gorm.ErrRecordNotFound {
+		if !open {
+			return nil
+		}
+		if bypassWindow {
+			d.breakGlass.RecordBypass(ctx, session, campaign, breakglass.GateWindow, map[string]interface{}{
+				"action": "start_phase",
+			})
+		}
+		return d.startNextPhase(ctx, campaign)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load running phase: %w", err)
+	}
+
+	dispatched, err := d.phaseCounts(ctx, campaign.ID, &phase)
+	if err != nil {
+		return err
+	}
+
+	if remaining := phase.TargetCount - dispatched.total; remaining > 0 {
+		if !open {
+			d.logger.Debug("campaign outside its window, deferring dispatch",
+				zap.String("campaign_id", campaign.ID))
+			return nil
+		}
+		if budget.exhausted() {
+			d.logger.Debug("tenant at concurrency cap, deferring campaign dispatch",
+				zap.String("campaign_id", campaign.ID),
+				zap.String("tenant_id", campaign.TenantID),
+				zap.Int("limit", budget.limit))
+			return nil
+		}
+		started, exhausted, err := d.dispatchPhase(ctx, campaign, &phase, remaining, budget)
+		if bypassWindow && started > 0 {
+			d.breakGlass.RecordBypass(ctx, session, campaign, breakglass.GateWindow, map[string]interface{}{
+				"action":     "dispatch",
+				"phase_name": phase.PhaseName,
+				"executions": started,
+			})
+		}
+		if err != nil || !exhausted || started > 0 || dispatched.inFlight > 0 {
+			return err
+		}
+		// Fewer agents are left than the phase targeted when it started
+		return d.completePhase(ctx, campaign, &phase)
+	}
+
+	if dispatched.inFlight > 0 {
+		return nil
+	}
+	return d.completePhase(ctx, campaign, &phase)
+}
+
+// startNextPhase marks the next pending phase running and fixes its target
+// count, or finishes the campaign when no phases are left
+func (d *Dispatcher) startNextPhase(ctx context.Context, campaign *models.Campaign) error {
+	next, err := d.phases.GetNextPhase(ctx, campaign.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get next phase: %w", err)
+	}
+	if next == nil {
+		return d.finishCampaign(ctx, campaign)
+	}
+
+	agents, err := d.phases.GetPhaseAgents(ctx, campaign, next)
+	if err != nil {
+		return fmt.Errorf("failed to select phase agents: %w", err)
+	}
+
+	if err := d.db.WithContext(ctx).Model(&models.CampaignPhase{}).
+		Where("id = ?", next.ID).
+		Update("target_count", len(agents)).Error; err != nil {
+		return fmt.Errorf("failed to set phase target: %w", err)
+	}
+	return d.phases.ExecutePhase(ctx, campaign.ID, next.ID)
+}
+
+// phaseExecutionCounts counts the executions of a phase
+type phaseExecutionCounts struct {
+	total    int
+	inFlight int
+	success  int
+	failure  int
+}
+
+// phaseCounts counts the executions of the running phase and stores its
+// progress. Phases run one at a time, so the phase's executions are those
+// created since it started.
+func (d *Dispatcher) phaseCounts(ctx context.Context, campaignID string, phase *models.CampaignPhase) (*phaseExecutionCounts, error) {
+	query := d.db.WithContext(ctx).Model(&models.WorkflowExecution{}).
+		Where("campaign_id = ?", campaignID)
+	if phase.StartedAt != nil {
+		query = query.Where("created_at >= ?", *phase.StartedAt)
+	}
+
+	var rows []struct {
+		Status models.ExecutionStatus
+		Count  int
+	}
+	if err := query.Select("status, COUNT(*) AS count").Group("status").Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to count phase executions: %w", err)
+	}
+
+	counts := &phaseExecutionCounts{}
+	for _, row := range rows {
+		counts.total += row.Count
+		switch row.Status {
+		case models.ExecutionStatusPending, models.ExecutionStatusRunning, models.ExecutionStatusPaused:
+			counts.inFlight += row.Count
+		case models.ExecutionStatusSuccess:
+			counts.success += row.Count
+		default:
+			counts.failure += row.Count
+		}
+	}
+
+	if counts.success != phase.SuccessCount || counts.failure != phase.FailureCount {
+		if err := d.phases.UpdatePhaseProgress(ctx, phase.ID, counts.success, counts.failure); err != nil {
+			return nil, fmt.Errorf("failed to update phase progress: %w", err)
+		}
+		phase.SuccessCount = counts.success
+		phase.FailureCount = counts.failure
+	}
+
+	return counts, nil
+}
+
+// dispatchPhase starts executions on up to remaining agents that are not yet
+// part of the campaign and returns how many were started. Busy agents are
+// passed over for idle ones and picked up again on a later tick if still
+// needed; the slots of busy pinned agents are held for them. exhausted
+// reports that no agent is left to dispatch to.
+func (d *Dispatcher) dispatchPhase(ctx context.Context, campaign *models.Campaign, phase *models.CampaignPhase, remaining int, budget *tenantBudget) (started int, exhausted bool, err error) {
+	selection, err := d.phases.phaseSelection(ctx, campaign, phase)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to list phase candidates: %w", err)
+	}
+	candidates := selection.candidates()
+
+	deferred, failed, held := 0, 0, 0
+	for i := range candidates {
+		if started+held >= remaining {
+			break
+		}
+		agent := &candidates[i]
+		pinned := i < len(selection.pinned)
+
+		busy, err := d.agentBusy(ctx, agent)
+		if err != nil {
+			return started, false, err
+		}
+		if busy {
+			deferred++
+			if pinned {
+				held++
+			}
+			continue
+		}
+		if !budget.take() {
+			d.logger.Debug("tenant reached concurrency cap during campaign dispatch",
+				zap.String("campaign_id", campaign.ID),
+				zap.String("tenant_id", campaign.TenantID),
+				zap.Int("limit", budget.limit))
+			return started, false, nil
+		}
+
+		if _, err := d.executor.Execute(ctx, &workflow.ExecuteRequest{
+			TenantID:   campaign.TenantID,
+			WorkflowID: campaign.WorkflowID,
+			AgentID:    agent.ID,
+			CampaignID: campaign.ID,
+			Mode:       campaign.ExecutionMode(),
+			// Executions run the definition pinned when the campaign started
+			DefinitionHash: campaign.DefinitionHash,
+		}); err != nil {
+			// Give back the slot; the agent is retried on the next tick
+			budget.release()
+			failed++
+			if pinned {
+				held++
+			}
+			d.logger.Warn("failed to dispatch campaign execution",
+				zap.String("campaign_id", campaign.ID),
+				zap.String("agent_id", agent.ID),
+				zap.Error(err))
+			continue
+		}
+		started++
+	}
+
+	if deferred > 0 {
+		d.logger.Info("deferred campaign dispatch to busy agents",
+			zap.String("campaign_id", campaign.ID),
+			zap.String("phase_name", phase.PhaseName),
+			zap.Int("deferred", deferred),
+			zap.Int("remaining", remaining-started))
+	}
+	return started, started < remaining && deferred == 0 && failed == 0, nil
+}
+
+// agentBusy reports whether the agent's queue is full. The agent's own
+// count is combined with the executions the control plane has in flight on
+// it, which covers jobs sent since the agent last counted.
+func (d *Dispatcher) agentBusy(ctx context.Context, agent *models.Agent) (bool, error) {
+	var inFlight int64
+	if err := d.db.WithContext(ctx).Model(&models.WorkflowExecution{}).
+		Where("tenant_id = ? AND agent_id = ? AND status IN ?", agent.TenantID, agent.ID, inFlightStatuses).
+		Count(&inFlight).Error; err != nil {
+		return false, fmt.Errorf("failed to count agent executions: %w", err)
+	}
+
+	active := int(inFlight)
+	limit := d.config.MaxAgentJobs
+
+	load, err := d.executor.AgentLoad(ctx, agent.TenantID, agent.ID)
+	if err != nil {
+		d.logger.Debug("failed to read agent load",
+			zap.String("agent_id", agent.ID),
+			zap.Error(err))
+	}
+	if load != nil {
+		if load.ActiveJobs > active {
+			active = load.ActiveJobs
+		}
+		if load.MaxConcurrent > 0 && (limit == 0 || load.MaxConcurrent < limit) {
+			limit = load.MaxConcurrent
+		}
+	}
+
+	return limit > 0 && active >= limit, nil
+}
+
+// completePhase records the outcome of a phase whose executions have all
+// finished. A phase below its success threshold fails the campaign, as does
+// the last phase of a zone below the zone's threshold, except in a dark
+// launch, which runs every phase to report on all of its targets.
+func (d *Dispatcher) completePhase(ctx context.Context, campaign *models.Campaign, phase *models.CampaignPhase) error {
+	threshold := phaseThreshold(campaign, phase.ConfigIndex)
+	success := phase.TargetCount == 0 || phase.SuccessRate() >= threshold
+
+	if err := d.phases.CompletePhase(ctx, phase.ID, success); err != nil {
+		return fmt.Errorf("failed to complete phase: %w", err)
+	}
+
+	d.logger.Info("campaign phase finished",
+		zap.String("campaign_id", campaign.ID),
+		zap.String("phase_name", phase.PhaseName),
+		zap.Bool("success", success),
+		zap.Float64("success_rate", phase.SuccessRate()),
+		zap.Float64("threshold", threshold))
+
+	if campaign.Mode == models.CampaignModeDarkLaunch {
+		return nil
+	}
+	if !success {
+		return d.setCampaignStatus(ctx, campaign, models.CampaignStatusFailed)
+	}
+	if phase.Zone == "" {
+		return nil
+	}
+
+	zones, err := campaignZones(campaign)
+	if err != nil || zones == nil || zones.excluded(phase.Zone) {
+		return err
+	}
+	rate, zoneThreshold, ok, err := d.zoneOutcome(ctx, campaign, zones, phase.Zone)
+	if err != nil || ok {
+		return err
+	}
+	d.logger.Warn("campaign zone below its success threshold",
+		zap.String("campaign_id", campaign.ID),
+		zap.String("zone", phase.Zone),
+		zap.Float64("success_rate", rate),
+		zap.Float64("threshold", zoneThreshold))
+	return d.setCampaignStatus(ctx, campaign, models.CampaignStatusFailed)
+}
+
+// finishCampaign completes a campaign whose phases have all run
+func (d *Dispatcher) finishCampaign(ctx context.Context, campaign *models.Campaign) error {
+	var open int64
+	if err := d.db.WithContext(ctx).Model(&models.CampaignPhase{}).
+		Where("campaign_id = ? AND status IN ?", campaign.ID, []models.PhaseStatus{
+			models.PhaseStatusPending,
+			models.PhaseStatusRunning,
+			models.PhaseStatusAwaitingApproval,
+		}).
+		Count(&open).Error; err != nil {
+		return fmt.Errorf("failed to count open phases: %w", err)
+	}
+	if open > 0 {
+		// Waiting for a manual approval
+		return nil
+	}
+	return d.setCampaignStatus(ctx, campaign, models.CampaignStatusCompleted)
+}
+
+// setCampaignStatus moves a running campaign to a final status
+func (d *Dispatcher) setCampaignStatus(ctx context.Context, campaign *models.Campaign, status models.CampaignStatus) error {
+	now := time.Now()
+	if err := d.db.WithContext(ctx).Model(&models.Campaign{}).
+		Where("id = ? AND status = ?", campaign.ID, models.CampaignStatusRunning).
+		Updates(map[string]interface{}{
+			"status":       status,
+			"completed_at": now,
+			"updated_at":   now,
+		}).Error; err != nil {
+		return fmt.Errorf("failed to update campaign status: %w", err)
+	}
+
+	d.logger.Info("campaign finished",
+		zap.String("campaign_id", campaign.ID),
+		zap.String("status", string(status)))
+	return nil
+}
+
+// phaseThreshold returns the success threshold, in percent, configured for
+// the phase at index
+func phaseThreshold(campaign *models.Campaign, index int) float64 {
+	phases, ok := campaign.PhaseConfig["phases"].([]interface{})
+	if !ok || index >= len(phases) {
+		return 0
+	}
+	config, ok := phases[index].(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	threshold, _ := config["success_threshold"].(float64)
+	return threshold
+}
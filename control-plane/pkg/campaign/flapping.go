@@ -0,0 +1,93 @@
+// Package campaign provides campaign management for the control plane.
+package campaign
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// Defaults for the target selector's exclude_flapping option
+const (
+	defaultFlappingWindow         = time.Hour
+	defaultFlappingMaxTransitions = 3
+)
+
+// flappingFilter excludes agents whose overall health status changed at
+// least MaxTransitions times within Window before each phase starts
+type flappingFilter struct {
+	Window         time.Duration
+	MaxTransitions int
+}
+
+// parseFlappingFilter reads the exclude_flapping option of a target selector:
+//
+//	"exclude_flapping": {"window": "1h", "max_transitions": 3}
+//
+// true enables the filter with defaults. It returns nil when the option is
+// absent or false.
+func parseFlappingFilter(selector map[string]interface{}) (*flappingFilter, error) {
+	raw, ok := selector["exclude_flapping"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+
+	filter := &flappingFilter{
+		Window:         defaultFlappingWindow,
+		MaxTransitions: defaultFlappingMaxTransitions,
+	}
+
+	switch v := raw.(type) {
+	case bool:
+		if !v {
+			return nil, nil
+		}
+	case map[string]interface{}:
+		if window, ok := v["window"]; ok {
+			s, ok := window.(string)
+			if !ok {
+				return nil, apperror.InvalidInput("target_selector.exclude_flapping.window must be a duration string")
+			}
+			d, err := time.ParseDuration(s)
+			if err != nil || d <= 0 {
+				return nil, apperror.InvalidInput("target_selector.exclude_flapping.window: invalid duration %q", s)
+			}
+			filter.Window = d
+		}
+		if max, ok := v["max_transitions"]; ok {
+			n, ok := max.(float64)
+			if !ok || n < 1 || n != float64(int(n)) {
+				return nil, apperror.InvalidInput("target_selector.exclude_flapping.max_transitions must be a positive integer")
+			}
+			filter.MaxTransitions = int(n)
+		}
+	default:
+		return nil, apperror.InvalidInput("target_selector.exclude_flapping must be a boolean or an object")
+	}
+
+	return filter, nil
+}
+
+// flappingAgentIDs returns the set of agents of a tenant that flapped within
+// the filter's window
+func (f *flappingFilter) flappingAgentIDs(db *gorm.DB, tenantID string) (map[string]bool, error) {
+	var agentIDs []string
+	if err := db.Model(&models.AgentHealthTransition{}).
+		Where("tenant_id = ? AND component = '' AND from_status != '' AND changed_at >= ?",
+			tenantID, time.Now().Add(-f.Window)).
+		Group("agent_id").
+		Having("COUNT(*) >= ?", f.MaxTransitions).
+		Pluck("agent_id", &agentIDs).Error; err != nil {
+		return nil, fmt.Errorf("failed to get flapping agents: %w", err)
+	}
+
+	flapping := make(map[string]bool, len(agentIDs))
+	for _, id := range agentIDs {
+		flapping[id] = true
+	}
+	return flapping, nil
+}
@@ -10,7 +10,9 @@ import (
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 
+	"github.com/yourorg/control-plane/pkg/apperror"
 	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/workflow"
 )
 
 // Manager manages campaigns
@@ -30,42 +32,106 @@ func NewManager(db *gorm.DB, logger *zap.Logger) *Manager {
 // CreateCampaignRequest represents a request to create a campaign
 type CreateCampaignRequest struct {
 	TenantID       string                 `json:"tenant_id" binding:"required"`
-	WorkflowID     string                 `json:"workflow_id" binding:"required"`
+	WorkflowID     string                 `json:"workflow_id"`
 	Name           string                 `json:"name" binding:"required"`
 	Description    string                 `json:"description"`
 	TargetSelector map[string]interface{} `json:"target_selector" binding:"required"`
 	PhaseConfig    []PhaseConfig          `json:"phase_config" binding:"required"`
 	CreatedBy      string                 `json:"created_by"`
+
+	// Type is workflow (default), which requires WorkflowID, or
+	// template_deploy, which requires TemplateDeploy and generates the
+	// workflow
+	Type           models.CampaignType `json:"type"`
+	TemplateDeploy *TemplateDeployment `json:"template_deploy"`
+
+	// Mode is live (default) or dark_launch, which runs every phase with
+	// validate executions and reports readiness without changing agents
+	Mode models.CampaignMode `json:"mode"`
+
+	// ScheduledAt starts the campaign at that time; Window restricts when
+	// it dispatches
+	ScheduledAt *time.Time `json:"scheduled_at"`
+	Window      *Window    `json:"window"`
+
+	// Zones runs the phases one zone of agents at a time
+	Zones *Zones `json:"zones"`
 }
 
 // PhaseConfig represents phase configuration
 type PhaseConfig struct {
-	Name             string  `json:"name"`
-	Percentage       float64 `json:"percentage"`
-	SuccessThreshold float64 `json:"success_threshold"`
-	WaitMinutes      int     `json:"wait_minutes"`
+	Name             string  `json:"name" yaml:"name"`
+	Percentage       float64 `json:"percentage" yaml:"percentage"`
+	SuccessThreshold float64 `json:"success_threshold" yaml:"success_threshold"`
+	WaitMinutes      int     `json:"wait_minutes" yaml:"wait_minutes"`
+	// ManualApproval halts the campaign after this phase until it is approved
+	ManualApproval bool `json:"manual_approval" yaml:"manual_approval"`
+	// Agents pins agents to the phase by ID or hostname, and Tags pins the
+	// group of agents carrying all of them. Pinned agents are dispatched
+	// to first, on top of Percentage; a pinned phase without a percentage
+	// targets only its pinned agents. Percentages skip agents pinned by
+	// later phases.
+	Agents []string          `json:"agents,omitempty" yaml:"agents,omitempty"`
+	Tags   map[string]string `json:"tags,omitempty" yaml:"tags,omitempty"`
 }
 
 // Create creates a new campaign
 func (m *Manager) Create(ctx context.Context, req *CreateCampaignRequest) (*models.Campaign, error) {
-	// Verify workflow exists and is active
-	var workflow models.Workflow
-	if err := m.db.Where("id = ? AND tenant_id = ? AND status = ?", req.WorkflowID, req.TenantID, models.WorkflowStatusActive).First(&workflow).Error; err != nil {
-		return nil, fmt.Errorf("workflow not found or not active")
+	switch req.Type {
+	case "", models.CampaignTypeWorkflow:
+		req.Type = models.CampaignTypeWorkflow
+		if req.TemplateDeploy != nil {
+			return nil, apperror.InvalidInput("template_deploy is only valid for template_deploy campaigns")
+		}
+		if req.WorkflowID == "" {
+			return nil, apperror.InvalidInput("workflow_id is required")
+		}
+		// Verify workflow exists and is active
+		var workflow models.Workflow
+		if err := m.db.Where("id = ? AND tenant_id = ? AND status = ?", req.WorkflowID, req.TenantID, models.WorkflowStatusActive).First(&workflow).Error; err != nil {
+			return nil, apperror.InvalidState("workflow not found or not active")
+		}
+	case models.CampaignTypeTemplateDeploy:
+		if req.TemplateDeploy == nil {
+			return nil, apperror.InvalidInput("template_deploy is required for template_deploy campaigns")
+		}
+		if req.WorkflowID != "" {
+			return nil, apperror.InvalidInput("workflow_id cannot be set for template_deploy campaigns; the deployment workflow is generated")
+		}
+	default:
+		return nil, apperror.InvalidInput("invalid campaign type %q: must be workflow or template_deploy", req.Type)
 	}
 
-	// Convert phase config to map
-	phaseConfigMap := make(map[string]interface{})
-	phases := make([]map[string]interface{}, len(req.PhaseConfig))
-	for i, phase := range req.PhaseConfig {
-		phases[i] = map[string]interface{}{
-			"name":              phase.Name,
-			"percentage":        phase.Percentage,
-			"success_threshold": phase.SuccessThreshold,
-			"wait_minutes":      phase.WaitMinutes,
+	switch req.Mode {
+	case "":
+		req.Mode = models.CampaignModeLive
+	case models.CampaignModeLive, models.CampaignModeDarkLaunch:
+	default:
+		return nil, apperror.InvalidInput("invalid campaign mode %q: must be live or dark_launch", req.Mode)
+	}
+
+	if _, err := parseFlappingFilter(req.TargetSelector); err != nil {
+		return nil, err
+	}
+	if _, err := selectorAgentIDs(req.TargetSelector); err != nil {
+		return nil, err
+	}
+	if err := validatePhases(req.PhaseConfig); err != nil {
+		return nil, err
+	}
+	window, err := scheduleColumns(req.Window)
+	if err != nil {
+		return nil, err
+	}
+	var zones models.JSONMap
+	if req.Zones != nil {
+		if err := req.Zones.validate(); err != nil {
+			return nil, err
+		}
+		if zones, err = req.Zones.toMap(); err != nil {
+			return nil, err
 		}
 	}
-	phaseConfigMap["phases"] = phases
 
 	campaign := &models.Campaign{
 		ID:             uuid.New().String(),
@@ -75,44 +141,100 @@ func (m *Manager) Create(ctx context.Context, req *CreateCampaignRequest) (*mode
 		Description:    req.Description,
 		Status:         models.CampaignStatusDraft,
 		TargetSelector: req.TargetSelector,
-		PhaseConfig:    phaseConfigMap,
+		PhaseConfig:    phaseConfigMap(req.PhaseConfig),
 		CreatedBy:      req.CreatedBy,
 		CreatedAt:      time.Now(),
 		UpdatedAt:      time.Now(),
+		Type:           req.Type,
+		Mode:           req.Mode,
+		ScheduledAt:    req.ScheduledAt,
+		Window:         window,
+		Zones:          zones,
 	}
 
-	if err := m.db.Create(campaign).Error; err != nil {
-		return nil, fmt.Errorf("failed to create campaign: %w", err)
-	}
-
-	// Create phase records
-	for i, phase := range req.PhaseConfig {
-		campaignPhase := &models.CampaignPhase{
-			ID:         uuid.New().String(),
-			CampaignID: campaign.ID,
-			PhaseName:  phase.Name,
-			PhaseOrder: i,
-			Status:     models.PhaseStatusPending,
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if req.Type == models.CampaignTypeTemplateDeploy {
+			wf, err := m.createDeployWorkflow(ctx, tx, req)
+			if err != nil {
+				return err
+			}
+			campaign.WorkflowID = wf.ID
+			if campaign.Deployment, err = req.TemplateDeploy.toMap(); err != nil {
+				return err
+			}
 		}
-		if err := m.db.Create(campaignPhase).Error; err != nil {
-			return nil, fmt.Errorf("failed to create campaign phase: %w", err)
+
+		if err := tx.Create(campaign).Error; err != nil {
+			return fmt.Errorf("failed to create campaign: %w", err)
 		}
+
+		return createPhases(tx, campaign.ID, req.PhaseConfig, req.Zones)
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	m.logger.Info("campaign created",
 		zap.String("campaign_id", campaign.ID),
 		zap.String("tenant_id", req.TenantID),
-		zap.String("workflow_id", req.WorkflowID))
+		zap.String("type", string(campaign.Type)),
+		zap.String("mode", string(campaign.Mode)),
+		zap.String("workflow_id", campaign.WorkflowID))
 
 	return campaign, nil
 }
 
+// phaseConfigMap converts phase config to the map stored on the campaign
+func phaseConfigMap(config []PhaseConfig) models.JSONMap {
+	phases := make([]map[string]interface{}, len(config))
+	for i, phase := range config {
+		phases[i] = map[string]interface{}{
+			"name":              phase.Name,
+			"percentage":        phase.Percentage,
+			"success_threshold": phase.SuccessThreshold,
+			"wait_minutes":      phase.WaitMinutes,
+			"manual_approval":   phase.ManualApproval,
+		}
+		if len(phase.Agents) > 0 {
+			phases[i]["agents"] = phase.Agents
+		}
+		if len(phase.Tags) > 0 {
+			phases[i]["tags"] = phase.Tags
+		}
+	}
+	return models.JSONMap{"phases": phases}
+}
+
+// createPhases creates the phase records of a campaign, once per zone of a
+// zoned campaign
+func createPhases(tx *gorm.DB, campaignID string, config []PhaseConfig, zones *Zones) error {
+	for _, campaignPhase := range zonePhases(config, zones) {
+		campaignPhase.ID = uuid.New().String()
+		campaignPhase.CampaignID = campaignID
+		if err := tx.Create(&campaignPhase).Error; err != nil {
+			return fmt.Errorf("failed to create campaign phase: %w", err)
+		}
+	}
+	return nil
+}
+
+// scheduleColumns validates a campaign's window and encodes it for storage
+func scheduleColumns(window *Window) (models.JSONMap, error) {
+	if window == nil {
+		return nil, nil
+	}
+	if _, err := window.validate(); err != nil {
+		return nil, err
+	}
+	return window.toMap()
+}
+
 // Get retrieves a campaign by ID
 func (m *Manager) Get(ctx context.Context, tenantID, campaignID string) (*models.Campaign, error) {
 	var campaign models.Campaign
 	if err := m.db.Preload("Phases").Where("id = ? AND tenant_id = ?", campaignID, tenantID).First(&campaign).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("campaign not found")
+			return nil, apperror.NotFound("campaign not found")
 		}
 		return nil, err
 	}
@@ -127,7 +249,7 @@ func (m *Manager) Start(ctx context.Context, tenantID, campaignID string) error
 	}
 
 	if campaign.Status != models.CampaignStatusDraft && campaign.Status != models.CampaignStatusPaused {
-		return fmt.Errorf("campaign cannot be started from status: %s", campaign.Status)
+		return apperror.InvalidState("campaign cannot be started from status: %s", campaign.Status)
 	}
 
 	now := time.Now()
@@ -139,6 +261,13 @@ func (m *Manager) Start(ctx context.Context, tenantID, campaignID string) error
 	if campaign.StartedAt == nil {
 		updates["started_at"] = now
 	}
+	if campaign.DefinitionHash == "" {
+		hash, err := pinWorkflow(ctx, m.db, campaign)
+		if err != nil {
+			return err
+		}
+		updates["definition_hash"] = hash
+	}
 
 	if err := m.db.Model(campaign).Updates(updates).Error; err != nil {
 		return fmt.Errorf("failed to start campaign: %w", err)
@@ -150,6 +279,89 @@ func (m *Manager) Start(ctx context.Context, tenantID, campaignID string) error
 	return nil
 }
 
+// StartPreview is what starting a campaign would dispatch to
+type StartPreview struct {
+	CampaignID string                `json:"campaign_id"`
+	Name       string                `json:"name"`
+	WorkflowID string                `json:"workflow_id"`
+	Status     models.CampaignStatus `json:"status"`
+	Mode       models.CampaignMode   `json:"mode"`
+	// TargetAgents counts the agents the campaign can still dispatch to
+	// over all its remaining phases
+	TargetAgents int `json:"target_agents"`
+	// NextPhase is the phase dispatched first and NextPhaseAgents the
+	// agents it selects
+	NextPhase       string   `json:"next_phase,omitempty"`
+	NextPhaseAgents []string `json:"next_phase_agents,omitempty"`
+}
+
+// PreviewStart returns what starting a campaign would dispatch to, without
+// starting it
+func (m *Manager) PreviewStart(ctx context.Context, tenantID, campaignID string) (*StartPreview, error) {
+	campaign, err := m.Get(ctx, tenantID, campaignID)
+	if err != nil {
+		return nil, err
+	}
+
+	if campaign.Status != models.CampaignStatusDraft && campaign.Status != models.CampaignStatusPaused {
+		return nil, apperror.InvalidState("campaign cannot be started from status: %s", campaign.Status)
+	}
+
+	phases := NewPhaseExecutor(m.db, m.logger)
+	_, available, err := phases.availableAgents(ctx, campaign, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve campaign targets: %w", err)
+	}
+
+	preview := &StartPreview{
+		CampaignID:   campaign.ID,
+		Name:         campaign.Name,
+		WorkflowID:   campaign.WorkflowID,
+		Status:       campaign.Status,
+		Mode:         campaign.Mode,
+		TargetAgents: len(available),
+	}
+
+	next, err := phases.GetNextPhase(ctx, campaign.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get next phase: %w", err)
+	}
+	if next != nil {
+		agents, err := phases.GetPhaseAgents(ctx, campaign, next)
+		if err != nil {
+			return nil, fmt.Errorf("failed to select phase agents: %w", err)
+		}
+		preview.NextPhase = next.PhaseName
+		for _, agent := range agents {
+			preview.NextPhaseAgents = append(preview.NextPhaseAgents, agent.ID)
+		}
+	}
+
+	return preview, nil
+}
+
+// pinWorkflow snapshots the definition of a campaign's workflow and returns
+// its hash, which the campaign's executions then dispatch
+func pinWorkflow(ctx context.Context, db *gorm.DB, campaign *models.Campaign) (string, error) {
+	var wf models.Workflow
+	if err := db.WithContext(ctx).
+		Where("id = ? AND tenant_id = ?", campaign.WorkflowID, campaign.TenantID).
+		First(&wf).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return "", apperror.InvalidState("campaign workflow not found")
+		}
+		return "", fmt.Errorf("failed to get campaign workflow: %w", err)
+	}
+	if wf.Status != models.WorkflowStatusActive {
+		return "", apperror.InvalidState("campaign workflow is not active")
+	}
+	snapshot, err := workflow.Snapshot(ctx, db, &wf)
+	if err != nil {
+		return "", err
+	}
+	return snapshot.DefinitionHash, nil
+}
+
 // Pause pauses a running campaign
 func (m *Manager) Pause(ctx context.Context, tenantID, campaignID string) error {
 	result := m.db.Model(&models.Campaign{}).
@@ -161,7 +373,7 @@ func (m *Manager) Pause(ctx context.Context, tenantID, campaignID string) error
 	}
 
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("campaign not found or not running")
+		return apperror.InvalidState("campaign not found or not running")
 	}
 
 	return nil
@@ -186,7 +398,7 @@ func (m *Manager) Cancel(ctx context.Context, tenantID, campaignID string) error
 	}
 
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("campaign not found or cannot be cancelled")
+		return apperror.InvalidState("campaign not found or cannot be cancelled")
 	}
 
 	m.logger.Info("campaign cancelled",
@@ -195,6 +407,58 @@ func (m *Manager) Cancel(ctx context.Context, tenantID, campaignID string) error
 	return nil
 }
 
+// ApprovePhase signs off a phase that is awaiting manual approval so the
+// campaign can continue with the next phase. phaseRef is the phase ID or
+// name; a name shared by the phases of several zones refers to the one
+// awaiting approval.
+func (m *Manager) ApprovePhase(ctx context.Context, tenantID, campaignID, phaseRef, approvedBy, note string) (*models.CampaignPhase, error) {
+	if _, err := m.Get(ctx, tenantID, campaignID); err != nil {
+		return nil, err
+	}
+
+	var phase models.CampaignPhase
+	if err := m.db.Where("campaign_id = ? AND (id = ? OR phase_name = ?)", campaignID, phaseRef, phaseRef).
+		Order("status = '" + string(models.PhaseStatusAwaitingApproval) + "' DESC, phase_order ASC").
+		First(&phase).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("campaign phase not found")
+		}
+		return nil, fmt.Errorf("failed to get campaign phase: %w", err)
+	}
+
+	if !phase.ManualApproval {
+		return nil, apperror.InvalidState("phase %s does not require manual approval", phase.PhaseName)
+	}
+
+	now := time.Now()
+	result := m.db.Model(&models.CampaignPhase{}).
+		Where("id = ? AND status = ?", phase.ID, models.PhaseStatusAwaitingApproval).
+		Updates(map[string]interface{}{
+			"status":        models.PhaseStatusSuccess,
+			"approved_by":   approvedBy,
+			"approved_at":   now,
+			"approval_note": note,
+		})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to approve campaign phase: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, apperror.InvalidState("phase %s is not awaiting approval (status: %s)", phase.PhaseName, phase.Status)
+	}
+
+	m.logger.Info("campaign phase approved",
+		zap.String("campaign_id", campaignID),
+		zap.String("phase_id", phase.ID),
+		zap.String("phase_name", phase.PhaseName),
+		zap.String("approved_by", approvedBy))
+
+	phase.Status = models.PhaseStatusSuccess
+	phase.ApprovedBy = approvedBy
+	phase.ApprovedAt = &now
+	phase.ApprovalNote = note
+	return &phase, nil
+}
+
 // List lists campaigns
 func (m *Manager) List(ctx context.Context, tenantID string, status models.CampaignStatus, limit, offset int) ([]models.Campaign, int64, error) {
 	query := m.db.Model(&models.Campaign{}).Where("tenant_id = ?", tenantID)
@@ -237,8 +501,10 @@ func (m *Manager) GetProgress(ctx context.Context, tenantID, campaignID string)
 	if err := m.db.Where("campaign_id = ? AND status IN ?", campaignID, []models.PhaseStatus{
 		models.PhaseStatusPending,
 		models.PhaseStatusRunning,
+		models.PhaseStatusAwaitingApproval,
 	}).Order("phase_order ASC").First(&currentPhase).Error; err == nil {
 		progress.CurrentPhase = currentPhase.PhaseName
+		progress.AwaitingApproval = currentPhase.Status == models.PhaseStatusAwaitingApproval
 	}
 
 	// Count executions
@@ -256,6 +522,20 @@ func (m *Manager) GetProgress(ctx context.Context, tenantID, campaignID string)
 		progress.SuccessRate = float64(progress.SuccessfulAgents) / float64(progress.CompletedAgents) * 100
 	}
 
+	if campaign.Type == models.CampaignTypeTemplateDeploy {
+		if progress.Deployment, err = m.deployProgress(ctx, campaignID); err != nil {
+			return nil, err
+		}
+	}
+
+	zones, err := campaignZones(campaign)
+	if err != nil {
+		return nil, err
+	}
+	if zones != nil {
+		progress.Zones = zoneProgress(zones, campaign.Phases)
+	}
+
 	// Update campaign progress
 	m.db.Model(campaign).Update("progress", progress)
 
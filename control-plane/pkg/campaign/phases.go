@@ -48,12 +48,25 @@ func (e *PhaseExecutor) ExecutePhase(ctx context.Context, campaignID, phaseID st
 	return nil
 }
 
-// CompletePhase marks a phase as complete
+// CompletePhase marks a phase as complete. A successful phase with manual
+// approval waits in awaiting_approval until ApprovePhase is called; dark
+// launches change nothing and skip the gate.
 func (e *PhaseExecutor) CompletePhase(ctx context.Context, phaseID string, success bool) error {
+	var phase models.CampaignPhase
+	if err := e.db.Preload("Campaign").First(&phase, "id = ?", phaseID).Error; err != nil {
+		return fmt.Errorf("phase not found: %w", err)
+	}
+
 	now := time.Now()
 	status := models.PhaseStatusSuccess
 	if !success {
 		status = models.PhaseStatusFailed
+	} else if phase.ManualApproval && phase.Campaign.Mode != models.CampaignModeDarkLaunch {
+		status = models.PhaseStatusAwaitingApproval
+		e.logger.Info("phase awaiting manual approval",
+			zap.String("campaign_id", phase.CampaignID),
+			zap.String("phase_id", phaseID),
+			zap.String("phase_name", phase.PhaseName))
 	}
 
 	return e.db.Model(&models.CampaignPhase{}).Where("id = ?", phaseID).Updates(map[string]interface{}{
@@ -70,24 +83,65 @@ func (e *PhaseExecutor) UpdatePhaseProgress(ctx context.Context, phaseID string,
 	}).Error
 }
 
-// GetPhaseAgents returns the agents targeted by a phase
-func (e *PhaseExecutor) GetPhaseAgents(ctx context.Context, campaign *models.Campaign, phaseIndex int) ([]models.Agent, error) {
-	// Get phase config
-	phaseConfigRaw := campaign.PhaseConfig["phases"]
-	phases, ok := phaseConfigRaw.([]interface{})
-	if !ok || phaseIndex >= len(phases) {
+// GetPhaseAgents returns the agents targeted by a phase: those it pins,
+// then its percentage of the campaign's agents in selection order
+func (e *PhaseExecutor) GetPhaseAgents(ctx context.Context, campaign *models.Campaign, phase *models.CampaignPhase) ([]models.Agent, error) {
+	selection, err := e.phaseSelection(ctx, campaign, phase)
+	if err != nil {
+		return nil, err
+	}
+	return selection.candidates()[:selection.targetCount()], nil
+}
+
+// phaseSelection splits the agents still available to a campaign into the
+// ones a phase pins and the ones it may pick from, both in selection order.
+// The phase of a zoned campaign selects from its zone's agents only.
+func (e *PhaseExecutor) phaseSelection(ctx context.Context, campaign *models.Campaign, phase *models.CampaignPhase) (*phaseSelection, error) {
+	targets, err := parsePhaseTargets(campaign)
+	if err != nil {
+		return nil, err
+	}
+	phaseIndex := phase.ConfigIndex
+	if phaseIndex < 0 || phaseIndex >= len(targets) {
 		return nil, fmt.Errorf("invalid phase index")
 	}
 
-	phaseConfig, ok := phases[phaseIndex].(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("invalid phase config")
+	total, availableAgents, err := e.availableAgents(ctx, campaign, phase.Zone)
+	if err != nil {
+		return nil, err
 	}
+	sortForSelection(campaign.ID, availableAgents)
 
-	percentage := phaseConfig["percentage"].(float64)
+	selection := &phaseSelection{targets: targets[phaseIndex], total: total}
+	later := targets[phaseIndex+1:]
+	for _, agent := range availableAgents {
+		if selection.targets.pins(&agent) {
+			selection.pinned = append(selection.pinned, agent)
+			continue
+		}
+		reserved := false
+		for i := range later {
+			if later[i].pins(&agent) {
+				reserved = true
+				break
+			}
+		}
+		if !reserved {
+			selection.rest = append(selection.rest, agent)
+		}
+	}
+	return selection, nil
+}
 
-	// Get all matching agents
-	query := e.db.Model(&models.Agent{}).Where("tenant_id = ?", campaign.TenantID)
+// availableAgents returns the number of agents matching the campaign's
+// target selector and those of them that can still be dispatched to: not
+// processed in an earlier phase and not excluded as flapping. A zone other
+// than "" restricts both to the agents of that zone.
+func (e *PhaseExecutor) availableAgents(ctx context.Context, campaign *models.Campaign, zone string) (int, []models.Agent, error) {
+	// Get all matching agents; draining, drained and unapproved agents take
+	// no new work
+	query := e.db.Model(&models.Agent{}).
+		Where("tenant_id = ? AND drain_state = ? AND approval_status = ?", campaign.TenantID, models.AgentDrainNone, models.AgentApprovalApproved)
 
 	// Apply target selector filters
 	if tags, ok := campaign.TargetSelector["tags"].(map[string]interface{}); ok {
@@ -100,15 +154,32 @@ func (e *PhaseExecutor) GetPhaseAgents(ctx context.Context, campaign *models.Cam
 		query = query.Where("status = ?", status)
 	}
 
+	ids, err := selectorAgentIDs(campaign.TargetSelector)
+	if err != nil {
+		return 0, nil, err
+	}
+	if ids != nil {
+		query = query.Where("id IN ?", ids)
+	}
+
 	var allAgents []models.Agent
 	if err := query.Find(&allAgents).Error; err != nil {
-		return nil, err
+		return 0, nil, err
 	}
-
-	// Calculate number of agents for this phase
-	targetCount := int(float64(len(allAgents)) * percentage / 100)
-	if targetCount < 1 && len(allAgents) > 0 {
-		targetCount = 1
+	if zone != "" {
+		zones, err := campaignZones(campaign)
+		if err != nil {
+			return 0, nil, err
+		}
+		if zones != nil {
+			inZone := allAgents[:0]
+			for _, agent := range allAgents {
+				if zones.agentZone(&agent) == zone {
+					inZone = append(inZone, agent)
+				}
+			}
+			allAgents = inZone
+		}
 	}
 
 	// Get agents already processed in previous phases
@@ -122,20 +193,34 @@ func (e *PhaseExecutor) GetPhaseAgents(ctx context.Context, campaign *models.Cam
 		processedMap[id] = true
 	}
 
-	// Filter out already processed agents
-	var availableAgents []models.Agent
-	for _, agent := range allAgents {
-		if !processedMap[agent.ID] {
-			availableAgents = append(availableAgents, agent)
+	// Agents that flapped recently are skipped when the selector asks for it
+	flapping := map[string]bool{}
+	filter, err := parseFlappingFilter(campaign.TargetSelector)
+	if err != nil {
+		return 0, nil, err
+	}
+	if filter != nil {
+		if flapping, err = filter.flappingAgentIDs(e.db, campaign.TenantID); err != nil {
+			return 0, nil, err
 		}
 	}
 
-	// Select agents for this phase
-	if targetCount > len(availableAgents) {
-		targetCount = len(availableAgents)
+	// Filter out already processed and flapping agents
+	var availableAgents []models.Agent
+	for _, agent := range allAgents {
+		if processedMap[agent.ID] {
+			continue
+		}
+		if flapping[agent.ID] {
+			e.logger.Info("excluding flapping agent from campaign phase",
+				zap.String("campaign_id", campaign.ID),
+				zap.String("agent_id", agent.ID))
+			continue
+		}
+		availableAgents = append(availableAgents, agent)
 	}
 
-	return availableAgents[:targetCount], nil
+	return len(allAgents), availableAgents, nil
 }
 
 // CheckPhaseCompletion checks if a phase is complete
@@ -150,7 +235,7 @@ func (e *PhaseExecutor) CheckPhaseCompletion(ctx context.Context, phaseID string
 	e.db.Model(&models.WorkflowExecution{}).
 		Where("campaign_id = ? AND status IN ?",
 			phase.CampaignID,
-			[]models.ExecutionStatus{models.ExecutionStatusPending, models.ExecutionStatusRunning}).
+			[]models.ExecutionStatus{models.ExecutionStatusPending, models.ExecutionStatusRunning, models.ExecutionStatusPaused}).
 		Count(&pending)
 
 	return pending == 0, nil
@@ -167,8 +252,19 @@ func (e *PhaseExecutor) CheckPhaseSuccess(ctx context.Context, phaseID string, t
 	return successRate >= threshold, nil
 }
 
-// GetNextPhase returns the next pending phase
+// GetNextPhase returns the next pending phase, or nil if there is none or a
+// phase is still awaiting manual approval
 func (e *PhaseExecutor) GetNextPhase(ctx context.Context, campaignID string) (*models.CampaignPhase, error) {
+	var awaiting int64
+	if err := e.db.Model(&models.CampaignPhase{}).
+		Where("campaign_id = ? AND status = ?", campaignID, models.PhaseStatusAwaitingApproval).
+		Count(&awaiting).Error; err != nil {
+		return nil, err
+	}
+	if awaiting > 0 {
+		return nil, nil
+	}
+
 	var phase models.CampaignPhase
 	if err := e.db.Where("campaign_id = ? AND status = ?", campaignID, models.PhaseStatusPending).
 		Order("phase_order ASC").First(&phase).Error; err != nil {
@@ -0,0 +1,253 @@
+package campaign
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// maxReportText bounds each diff and failure output in a readiness report
+const maxReportText = 16 * 1024
+
+// ReadinessReport is what a dark-launch campaign found on its targets: the
+// files each agent would change and the pre-checks that failed
+type ReadinessReport struct {
+	CampaignID string                `json:"campaign_id"`
+	Status     models.CampaignStatus `json:"status"`
+	// Complete is set once every phase has run; Ready is set when the
+	// campaign is complete and every agent passed
+	Complete bool `json:"complete"`
+	Ready    bool `json:"ready"`
+
+	Checked      int `json:"checked"`
+	InFlight     int `json:"in_flight"`
+	ReadyAgents  int `json:"ready_agents"`
+	FailedAgents int `json:"failed_agents"`
+	// WouldChange counts the agents with at least one file change
+	WouldChange int `json:"would_change"`
+
+	Phases []PhaseReadiness `json:"phases"`
+	Agents []AgentReadiness `json:"agents"`
+}
+
+// PhaseReadiness sums up the agents checked in one phase
+type PhaseReadiness struct {
+	Name        string             `json:"name"`
+	Order       int                `json:"order"`
+	Status      models.PhaseStatus `json:"status"`
+	Checked     int                `json:"checked"`
+	Ready       int                `json:"ready"`
+	Failed      int                `json:"failed"`
+	WouldChange int                `json:"would_change"`
+}
+
+// AgentReadiness is the outcome of one agent's validate execution
+type AgentReadiness struct {
+	AgentID     string                 `json:"agent_id"`
+	ExecutionID string                 `json:"execution_id"`
+	Phase       string                 `json:"phase,omitempty"`
+	Status      models.ExecutionStatus `json:"status"`
+	Ready       bool                   `json:"ready"`
+	Changes     []FileChange           `json:"changes,omitempty"`
+	Failures    []CheckFailure         `json:"failures,omitempty"`
+	// Skipped are the steps that did not run, in validate mode or because
+	// of their condition
+	Skipped []string `json:"skipped,omitempty"`
+	// Error is an execution-level error, e.g. the agent was unreachable
+	Error string `json:"error,omitempty"`
+}
+
+// FileChange is a file a template step would create or update
+type FileChange struct {
+	StepID       string `json:"step_id"`
+	Dest         string `json:"dest"`
+	Status       string `json:"status"` // would_create or would_update
+	LinesAdded   int    `json:"lines_added"`
+	LinesRemoved int    `json:"lines_removed"`
+	Diff         string `json:"diff,omitempty"`
+}
+
+// CheckFailure is a step that failed in a validate execution
+type CheckFailure struct {
+	StepID   string `json:"step_id"`
+	StepName string `json:"step_name"`
+	Error    string `json:"error,omitempty"`
+	Output   string `json:"output,omitempty"`
+}
+
+// GetReadiness returns the readiness report of a dark-launch campaign from
+// the executions it has finished so far
+func (m *Manager) GetReadiness(ctx context.Context, tenantID, campaignID string) (*ReadinessReport, error) {
+	campaign, err := m.Get(ctx, tenantID, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	if campaign.Mode != models.CampaignModeDarkLaunch {
+		return nil, apperror.InvalidState("campaign %s is not a dark launch", campaign.Name)
+	}
+
+	var executions []models.WorkflowExecution
+	if err := m.db.WithContext(ctx).
+		Select("id", "agent_id", "status", "result", "created_at").
+		Where("campaign_id = ?", campaignID).
+		Order("created_at ASC").
+		Find(&executions).Error; err != nil {
+		return nil, fmt.Errorf("failed to load campaign executions: %w", err)
+	}
+
+	phases := append([]models.CampaignPhase(nil), campaign.Phases...)
+	sort.Slice(phases, func(i, j int) bool { return phases[i].PhaseOrder < phases[j].PhaseOrder })
+
+	report := &ReadinessReport{
+		CampaignID: campaign.ID,
+		Status:     campaign.Status,
+		Phases:     make([]PhaseReadiness, len(phases)),
+		Agents:     make([]AgentReadiness, 0, len(executions)),
+	}
+	report.Complete = campaign.Status == models.CampaignStatusCompleted
+	for i, phase := range phases {
+		report.Phases[i] = PhaseReadiness{Name: phase.PhaseName, Order: phase.PhaseOrder, Status: phase.Status}
+	}
+
+	for i := range executions {
+		execution := &executions[i]
+		if execution.Status == models.ExecutionStatusPending || execution.Status == models.ExecutionStatusRunning || execution.Status == models.ExecutionStatusPaused {
+			report.InFlight++
+			continue
+		}
+
+		agent := agentReadiness(execution)
+		var phase *PhaseReadiness
+		if index := executionPhase(phases, execution.CreatedAt); index >= 0 {
+			phase = &report.Phases[index]
+			agent.Phase = phase.Name
+		}
+
+		report.Checked++
+		if agent.Ready {
+			report.ReadyAgents++
+		} else {
+			report.FailedAgents++
+		}
+		if len(agent.Changes) > 0 {
+			report.WouldChange++
+		}
+		if phase != nil {
+			phase.Checked++
+			if agent.Ready {
+				phase.Ready++
+			} else {
+				phase.Failed++
+			}
+			if len(agent.Changes) > 0 {
+				phase.WouldChange++
+			}
+		}
+		report.Agents = append(report.Agents, agent)
+	}
+
+	report.Ready = report.Complete && report.FailedAgents == 0
+	return report, nil
+}
+
+// executionPhase returns the index of the phase an execution was dispatched
+// in, the last one started before it was created, or -1
+func executionPhase(phases []models.CampaignPhase, createdAt time.Time) int {
+	index := -1
+	for i, phase := range phases {
+		if phase.StartedAt != nil && !phase.StartedAt.After(createdAt) {
+			index = i
+		}
+	}
+	return index
+}
+
+// agentReadiness reads the file changes and failed steps of one agent's
+// finished validate execution
+func agentReadiness(execution *models.WorkflowExecution) AgentReadiness {
+	agent := AgentReadiness{
+		AgentID:     execution.AgentID,
+		ExecutionID: execution.ID,
+		Status:      execution.Status,
+		Ready:       execution.Status == models.ExecutionStatusSuccess,
+	}
+	if errMsg, ok := execution.Result["error"].(string); ok {
+		agent.Error = errMsg
+	}
+
+	steps, _ := execution.Result["steps"].([]interface{})
+	for _, raw := range steps {
+		step, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		stepID, _ := step["step_id"].(string)
+		stepName, _ := step["step_name"].(string)
+		status, _ := step["status"].(string)
+		output, _ := step["output"].(string)
+
+		switch status {
+		case "failed":
+			stepErr, _ := step["error"].(string)
+			agent.Failures = append(agent.Failures, CheckFailure{
+				StepID:   stepID,
+				StepName: stepName,
+				Error:    stepErr,
+				Output:   truncateReportText(output),
+			})
+		case "skipped":
+			agent.Skipped = append(agent.Skipped, stepID)
+		}
+
+		if change, ok := parseFileChange(stepID, output); ok {
+			agent.Changes = append(agent.Changes, change)
+		}
+	}
+	return agent
+}
+
+// parseFileChange reads the change a template step previewed from its
+// output; ok is false for other steps and for unchanged files
+func parseFileChange(stepID, output string) (FileChange, bool) {
+	status, added, removed := parseDeployOutput(output)
+	if status != "would_create" && status != "would_update" {
+		return FileChange{}, false
+	}
+
+	change := FileChange{StepID: stepID, Status: status, LinesAdded: added, LinesRemoved: removed}
+	var diff strings.Builder
+	inDiff := false
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "Destination: ") && !inDiff:
+			change.Dest = strings.TrimPrefix(line, "Destination: ")
+		case line == "Changes:":
+			inDiff = true
+		case !inDiff:
+		case strings.HasPrefix(line, "Error: "):
+			inDiff = false
+		default:
+			diff.WriteString(line)
+			diff.WriteByte('\n')
+		}
+	}
+	change.Diff = truncateReportText(diff.String())
+	return change, true
+}
+
+// truncateReportText shortens text to maxReportText bytes
+func truncateReportText(s string) string {
+	if len(s) <= maxReportText {
+		return s
+	}
+	return s[:maxReportText] + "\n... (truncated)"
+}
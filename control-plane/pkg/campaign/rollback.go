@@ -99,6 +99,9 @@ func (m *RollbackManager) CanRollback(ctx context.Context, campaignID string) (b
 	if err := m.db.First(&campaign, "id = ?", campaignID).Error; err != nil {
 		return false, "campaign not found"
 	}
+	if campaign.Mode == models.CampaignModeDarkLaunch {
+		return false, "dark-launch campaigns change nothing to roll back"
+	}
 
 	switch campaign.Status {
 	case models.CampaignStatusRunning, models.CampaignStatusPaused:
@@ -0,0 +1,211 @@
+package campaign
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// phaseTargets is what a phase selects: agents pinned by ID or hostname,
+// agents pinned as a group by their tags, and a percentage of the
+// campaign's agents picked in selection order
+type phaseTargets struct {
+	percentage float64
+	agents     map[string]bool
+	tags       map[string]string
+}
+
+// isPinned reports whether the phase pins agents
+func (t *phaseTargets) isPinned() bool {
+	return len(t.agents) > 0 || len(t.tags) > 0
+}
+
+// pins reports whether the phase pins an agent
+func (t *phaseTargets) pins(agent *models.Agent) bool {
+	if t.agents[agent.ID] || t.agents[agent.Hostname] {
+		return true
+	}
+	if len(t.tags) == 0 {
+		return false
+	}
+	for key, value := range t.tags {
+		tag, ok := agent.Tags[key]
+		if !ok || fmt.Sprint(tag) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// parsePhaseTargets reads the targets of every phase of a campaign
+func parsePhaseTargets(campaign *models.Campaign) ([]phaseTargets, error) {
+	var phases []interface{}
+	switch v := campaign.PhaseConfig["phases"].(type) {
+	case []interface{}:
+		phases = v
+	case []map[string]interface{}:
+		for _, phase := range v {
+			phases = append(phases, phase)
+		}
+	default:
+		return nil, fmt.Errorf("invalid phase config")
+	}
+
+	targets := make([]phaseTargets, len(phases))
+	for i, raw := range phases {
+		config, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid phase config")
+		}
+		targets[i].percentage, _ = config["percentage"].(float64)
+
+		var agents []string
+		switch v := config["agents"].(type) {
+		case []string:
+			agents = v
+		case []interface{}:
+			for _, agent := range v {
+				if s, ok := agent.(string); ok {
+					agents = append(agents, s)
+				}
+			}
+		}
+		if len(agents) > 0 {
+			targets[i].agents = make(map[string]bool, len(agents))
+			for _, agent := range agents {
+				targets[i].agents[agent] = true
+			}
+		}
+
+		switch v := config["tags"].(type) {
+		case map[string]string:
+			targets[i].tags = v
+		case map[string]interface{}:
+			targets[i].tags = make(map[string]string, len(v))
+			for key, value := range v {
+				targets[i].tags[key] = fmt.Sprint(value)
+			}
+		}
+	}
+	return targets, nil
+}
+
+// validatePhases checks the percentages and pins of a campaign's phases
+func validatePhases(phases []PhaseConfig) error {
+	for i, phase := range phases {
+		name := phase.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		if phase.Percentage < 0 || phase.Percentage > 100 {
+			return apperror.InvalidInput("phase %s: percentage must be between 0 and 100", name)
+		}
+		for _, agent := range phase.Agents {
+			if strings.TrimSpace(agent) == "" {
+				return apperror.InvalidInput("phase %s: agents must not be empty", name)
+			}
+		}
+		for key := range phase.Tags {
+			if strings.TrimSpace(key) == "" {
+				return apperror.InvalidInput("phase %s: tag keys must not be empty", name)
+			}
+		}
+	}
+	return nil
+}
+
+// maxSelectorAgentIDs bounds the agent_ids of a target selector
+const maxSelectorAgentIDs = 10000
+
+// selectorAgentIDs reads the agent_ids option of a target selector, which
+// restricts the campaign to the listed agents. It returns nil when the
+// option is absent.
+func selectorAgentIDs(selector map[string]interface{}) ([]string, error) {
+	raw, ok := selector["agent_ids"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+
+	var ids []string
+	switch v := raw.(type) {
+	case []string:
+		ids = v
+	case []interface{}:
+		for _, item := range v {
+			id, ok := item.(string)
+			if !ok {
+				return nil, apperror.InvalidInput("target_selector.agent_ids must be a list of agent IDs")
+			}
+			ids = append(ids, id)
+		}
+	default:
+		return nil, apperror.InvalidInput("target_selector.agent_ids must be a list of agent IDs")
+	}
+	if len(ids) == 0 || len(ids) > maxSelectorAgentIDs {
+		return nil, apperror.InvalidInput("target_selector.agent_ids must list between 1 and %d agents", maxSelectorAgentIDs)
+	}
+	return ids, nil
+}
+
+// phaseSelection is the agents a phase can still be dispatched to
+type phaseSelection struct {
+	targets phaseTargets
+	// total is the number of agents matching the campaign's selector
+	total int
+	// pinned are the agents the phase pins and rest those it may pick
+	// from for its percentage; agents pinned by a later phase are kept
+	// for it. Both are in selection order.
+	pinned []models.Agent
+	rest   []models.Agent
+}
+
+// targetCount returns how many agents the phase targets: its pinned agents
+// and its percentage of the campaign's agents. Unpinned phases target at
+// least one agent.
+func (s *phaseSelection) targetCount() int {
+	share := 0
+	if !s.targets.isPinned() || s.targets.percentage > 0 {
+		share = int(float64(s.total) * s.targets.percentage / 100)
+		if share < 1 && s.total > 0 {
+			share = 1
+		}
+	}
+	if share > len(s.rest) {
+		share = len(s.rest)
+	}
+	return len(s.pinned) + share
+}
+
+// candidates returns the agents the phase dispatches to, pinned agents first
+func (s *phaseSelection) candidates() []models.Agent {
+	if s.targets.isPinned() && s.targets.percentage <= 0 {
+		return s.pinned
+	}
+	return append(append([]models.Agent(nil), s.pinned...), s.rest...)
+}
+
+// sortForSelection orders agents by a hash of the campaign and agent IDs.
+// The order does not depend on how the database returns agents, so a
+// campaign picks the same agents however often it is paused and resumed,
+// while different campaigns spread over different agents.
+func sortForSelection(campaignID string, agents []models.Agent) {
+	keys := make(map[string]uint64, len(agents))
+	for _, agent := range agents {
+		h := fnv.New64a()
+		h.Write([]byte(campaignID))
+		h.Write([]byte{0})
+		h.Write([]byte(agent.ID))
+		keys[agent.ID] = h.Sum64()
+	}
+	sort.SliceStable(agents, func(i, j int) bool {
+		ki, kj := keys[agents[i].ID], keys[agents[j].ID]
+		if ki != kj {
+			return ki < kj
+		}
+		return agents[i].ID < agents[j].ID
+	})
+}
@@ -0,0 +1,305 @@
+package campaign
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/workflow"
+)
+
+// Step IDs of a generated template deployment workflow
+const (
+	deployStepID     = "deploy"
+	restartStepID    = "restart"
+	driftCheckStepID = "drift-check"
+)
+
+// TemplateDeployment configures a template_deploy campaign
+type TemplateDeployment struct {
+	TemplateID string `json:"template_id" binding:"required"`
+	// TemplateVersion pins the template version rolled out; the template's
+	// current version is pinned when unset
+	TemplateVersion int                    `json:"template_version,omitempty"`
+	Dest            string                 `json:"dest" binding:"required"`
+	Variables       map[string]interface{} `json:"variables,omitempty"`
+	Mode            string                 `json:"mode,omitempty"`
+	Owner           string                 `json:"owner,omitempty"`
+	Group           string                 `json:"group,omitempty"`
+	Backup          *bool                  `json:"backup,omitempty"` // Defaults to the tenant policy, else true
+	CreateDirs      bool                   `json:"create_dirs,omitempty"`
+	// RestartCommand runs after the file is deployed, e.g. "systemctl reload nginx"
+	RestartCommand string `json:"restart_command,omitempty"`
+	// DriftCheck re-renders the template at the end of each agent's run and
+	// fails it when the file no longer matches. Defaults to true.
+	DriftCheck *bool `json:"drift_check,omitempty"`
+}
+
+// validate checks the deployment and fills in defaults
+func (d *TemplateDeployment) validate() error {
+	if d.TemplateID == "" {
+		return apperror.InvalidInput("template_deploy.template_id is required")
+	}
+	if d.Dest == "" {
+		return apperror.InvalidInput("template_deploy.dest is required")
+	}
+	if d.TemplateVersion < 0 {
+		return apperror.InvalidInput("template_deploy.template_version must be positive")
+	}
+	if d.Mode != "" {
+		if len(d.Mode) < 3 || len(d.Mode) > 4 || strings.Trim(d.Mode, "01234567") != "" {
+			return apperror.InvalidInput("template_deploy.mode must be octal, e.g. 0644")
+		}
+	}
+	if d.Backup == nil {
+		backup := true
+		d.Backup = &backup
+	}
+	if d.DriftCheck == nil {
+		check := true
+		d.DriftCheck = &check
+	}
+	return nil
+}
+
+// source returns the agent template source of the pinned version
+func (d *TemplateDeployment) source() string {
+	return fmt.Sprintf("control-plane://templates/%s/content?version=%d", d.TemplateID, d.TemplateVersion)
+}
+
+// definition returns the workflow each target agent runs: deploy the
+// rendered template, optionally restart the service, then check the file
+// still matches the template
+func (d *TemplateDeployment) definition(name string) map[string]interface{} {
+	file := map[string]interface{}{
+		"source":      d.source(),
+		"dest":        d.Dest,
+		"backup":      *d.Backup,
+		"create_dirs": d.CreateDirs,
+	}
+	for key, value := range map[string]string{"mode": d.Mode, "owner": d.Owner, "group": d.Group} {
+		if value != "" {
+			file[key] = value
+		}
+	}
+
+	steps := []interface{}{
+		map[string]interface{}{
+			"id":       deployStepID,
+			"name":     "Deploy " + path.Base(d.Dest),
+			"type":     "template",
+			"template": file,
+		},
+	}
+	if d.RestartCommand != "" {
+		steps = append(steps, map[string]interface{}{
+			"id":      restartStepID,
+			"name":    "Restart service",
+			"type":    "command",
+			"command": d.RestartCommand,
+		})
+	}
+	if *d.DriftCheck {
+		steps = append(steps, map[string]interface{}{
+			"id":   driftCheckStepID,
+			"name": "Check for drift",
+			"type": "template",
+			"template": map[string]interface{}{
+				"source":         d.source(),
+				"dest":           d.Dest,
+				"diff_only":      true,
+				"fail_on_change": true,
+			},
+		})
+	}
+
+	definition := map[string]interface{}{
+		"name":  name,
+		"steps": steps,
+	}
+	if len(d.Variables) > 0 {
+		definition["vars"] = d.Variables
+	}
+	return definition
+}
+
+// toMap returns the deployment as stored on the campaign
+func (d *TemplateDeployment) toMap() (models.JSONMap, error) {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode template deployment: %w", err)
+	}
+	var m models.JSONMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to encode template deployment: %w", err)
+	}
+	return m, nil
+}
+
+// createDeployWorkflow pins the template version and creates the active
+// workflow a template_deploy campaign dispatches
+func (m *Manager) createDeployWorkflow(ctx context.Context, tx *gorm.DB, req *CreateCampaignRequest) (*models.Workflow, error) {
+	deploy := req.TemplateDeploy
+	policy, err := workflow.LoadPolicy(ctx, tx, req.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	// The tenant's backup default applies when the deployment leaves it unset
+	if deploy.Backup == nil && policy != nil && policy.DefaultTemplateBackup != nil {
+		backup := *policy.DefaultTemplateBackup
+		deploy.Backup = &backup
+	}
+	if err := deploy.validate(); err != nil {
+		return nil, err
+	}
+
+	var tpl models.Template
+	if err := tx.WithContext(ctx).Select("id", "name", "version", "status").
+		Where("id = ? AND tenant_id = ? AND status = ?", deploy.TemplateID, req.TenantID, models.TemplateStatusActive).
+		First(&tpl).Error; err != nil {
+		return nil, apperror.InvalidState("template not found or not active")
+	}
+	if deploy.TemplateVersion == 0 {
+		deploy.TemplateVersion = tpl.Version
+	} else if deploy.TemplateVersion != tpl.Version {
+		var count int64
+		if err := tx.WithContext(ctx).Model(&models.TemplateVersion{}).
+			Where("template_id = ? AND tenant_id = ? AND version = ?", tpl.ID, req.TenantID, deploy.TemplateVersion).
+			Count(&count).Error; err != nil {
+			return nil, fmt.Errorf("failed to look up template version: %w", err)
+		}
+		if count == 0 {
+			return nil, apperror.InvalidInput("template %s has no version %d", tpl.Name, deploy.TemplateVersion)
+		}
+	}
+
+	definition := deploy.definition(fmt.Sprintf("Deploy %s v%d", tpl.Name, deploy.TemplateVersion))
+	if err := workflow.NewValidator().Validate(definition); err != nil {
+		return nil, apperror.InvalidInput("generated deployment workflow is invalid: %w", err)
+	}
+	if err := workflow.ApplyPolicy(definition, policy, false); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	wf := &models.Workflow{
+		ID:          uuid.New().String(),
+		TenantID:    req.TenantID,
+		Name:        fmt.Sprintf("%s (template deployment)", req.Name),
+		Description: fmt.Sprintf("Generated for campaign %q: deploys template %s v%d to %s", req.Name, tpl.Name, deploy.TemplateVersion, deploy.Dest),
+		Definition:  definition,
+		Version:     1,
+		Status:      models.WorkflowStatusActive,
+		Tags: models.JSONMap{
+			"generated_by": string(models.CampaignTypeTemplateDeploy),
+			"template_id":  tpl.ID,
+		},
+		CreatedBy: req.CreatedBy,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := tx.WithContext(ctx).Create(wf).Error; err != nil {
+		return nil, fmt.Errorf("failed to create deployment workflow: %w", err)
+	}
+	return wf, nil
+}
+
+// deployProgress summarises the file changes of a template_deploy
+// campaign's finished executions from their step output
+func (m *Manager) deployProgress(ctx context.Context, campaignID string) (*models.TemplateDeployProgress, error) {
+	var executions []models.WorkflowExecution
+	if err := m.db.WithContext(ctx).
+		Select("id", "agent_id", "status", "result").
+		Where("campaign_id = ? AND status NOT IN ?", campaignID, inFlightStatuses).
+		Order("completed_at ASC").
+		Find(&executions).Error; err != nil {
+		return nil, fmt.Errorf("failed to load deployment results: %w", err)
+	}
+
+	progress := &models.TemplateDeployProgress{Agents: make([]models.AgentDeploySummary, 0, len(executions))}
+	for i := range executions {
+		summary := summarizeDeploy(&executions[i])
+		switch {
+		case summary.Drifted:
+			progress.Drifted++
+		case summary.Status != models.ExecutionStatusSuccess:
+			progress.Failed++
+		case summary.FileStatus == "created":
+			progress.Created++
+		case summary.FileStatus == "updated":
+			progress.Updated++
+		case summary.FileStatus == "unchanged":
+			progress.Unchanged++
+		}
+		progress.Agents = append(progress.Agents, summary)
+	}
+	return progress, nil
+}
+
+// summarizeDeploy reads the deploy and drift check step results of one
+// agent's execution
+func summarizeDeploy(execution *models.WorkflowExecution) models.AgentDeploySummary {
+	summary := models.AgentDeploySummary{
+		AgentID:     execution.AgentID,
+		ExecutionID: execution.ID,
+		Status:      execution.Status,
+	}
+	if errMsg, ok := execution.Result["error"].(string); ok {
+		summary.Error = errMsg
+	}
+
+	steps, _ := execution.Result["steps"].([]interface{})
+	for _, raw := range steps {
+		step, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		output, _ := step["output"].(string)
+		status, _ := step["status"].(string)
+		switch step["step_id"] {
+		case deployStepID:
+			summary.FileStatus, summary.LinesAdded, summary.LinesRemoved = parseDeployOutput(output)
+			if stepErr, _ := step["error"].(string); stepErr != "" {
+				summary.Error = stepErr
+			}
+		case driftCheckStepID:
+			summary.Drifted = status == "failed"
+		}
+	}
+	return summary
+}
+
+// parseDeployOutput reads the file status and diff line counts from a
+// template step's output
+func parseDeployOutput(output string) (status string, added, removed int) {
+	inDiff := false
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "Deploy status: "):
+			status = strings.TrimPrefix(line, "Deploy status: ")
+		case line == "Changes:":
+			inDiff = true
+		case !inDiff:
+		case strings.HasPrefix(line, "Error: "):
+			inDiff = false
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		case strings.HasPrefix(line, "+"):
+			added++
+		case strings.HasPrefix(line, "-"):
+			removed++
+		}
+	}
+	return status, added, removed
+}
@@ -0,0 +1,209 @@
+// Package campaign provides campaign management for the control plane.
+package campaign
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// DefaultTimelineInterval is how often campaign progress snapshots are taken
+const DefaultTimelineInterval = time.Minute
+
+// TimelineRecorder periodically snapshots per-phase progress of active campaigns
+type TimelineRecorder struct {
+	db       *gorm.DB
+	logger   *zap.Logger
+	interval time.Duration
+}
+
+// NewTimelineRecorder creates a new timeline recorder
+func NewTimelineRecorder(db *gorm.DB, interval time.Duration, logger *zap.Logger) *TimelineRecorder {
+	if interval <= 0 {
+		interval = DefaultTimelineInterval
+	}
+	return &TimelineRecorder{
+		db:       db,
+		logger:   logger,
+		interval: interval,
+	}
+}
+
+// Run records snapshots until the context is cancelled
+func (r *TimelineRecorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	r.recordActive(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.recordActive(ctx)
+		}
+	}
+}
+
+// recordActive snapshots every campaign that is currently rolling out
+func (r *TimelineRecorder) recordActive(ctx context.Context) {
+	var campaignIDs []string
+	if err := r.db.WithContext(ctx).Model(&models.Campaign{}).
+		Where("status IN ?", []models.CampaignStatus{
+			models.CampaignStatusRunning,
+			models.CampaignStatusPaused,
+			models.CampaignStatusRollingBack,
+		}).
+		Pluck("id", &campaignIDs).Error; err != nil {
+		r.logger.Error("failed to list active campaigns", zap.Error(err))
+		return
+	}
+
+	for _, campaignID := range campaignIDs {
+		if err := r.RecordSnapshot(ctx, campaignID); err != nil {
+			r.logger.Warn("failed to record campaign snapshot",
+				zap.String("campaign_id", campaignID),
+				zap.Error(err))
+		}
+	}
+}
+
+// RecordSnapshot stores the current per-phase counts of a campaign. Snapshots
+// are bucketed to the recorder interval, so repeated calls within one bucket
+// overwrite the same row.
+func (r *TimelineRecorder) RecordSnapshot(ctx context.Context, campaignID string) error {
+	var phases []models.CampaignPhase
+	if err := r.db.WithContext(ctx).Where("campaign_id = ?", campaignID).
+		Order("phase_order ASC").Find(&phases).Error; err != nil {
+		return fmt.Errorf("failed to load campaign phases: %w", err)
+	}
+	if len(phases) == 0 {
+		return nil
+	}
+
+	// Phases run one at a time, so unfinished executions belong to the running phase
+	var inFlight int64
+	if err := r.db.WithContext(ctx).Model(&models.WorkflowExecution{}).
+		Where("campaign_id = ? AND status IN ?", campaignID, []models.ExecutionStatus{
+			models.ExecutionStatusPending,
+			models.ExecutionStatusRunning,
+			models.ExecutionStatusPaused,
+		}).
+		Count(&inFlight).Error; err != nil {
+		return fmt.Errorf("failed to count in-flight executions: %w", err)
+	}
+
+	recordedAt := time.Now().UTC().Truncate(r.interval)
+	snapshots := make([]models.CampaignProgressSnapshot, 0, len(phases))
+	for _, phase := range phases {
+		snapshot := models.CampaignProgressSnapshot{
+			ID:           uuid.New().String(),
+			CampaignID:   campaignID,
+			PhaseName:    phase.PhaseName,
+			PhaseOrder:   phase.PhaseOrder,
+			PhaseStatus:  phase.Status,
+			TargetCount:  phase.TargetCount,
+			SuccessCount: phase.SuccessCount,
+			FailureCount: phase.FailureCount,
+			RecordedAt:   recordedAt,
+		}
+		if phase.Status == models.PhaseStatusRunning {
+			snapshot.InFlightCount = int(inFlight)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "campaign_id"}, {Name: "phase_name"}, {Name: "recorded_at"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"phase_status", "target_count", "success_count", "failure_count", "in_flight_count",
+		}),
+	}).Create(&snapshots).Error
+	if err != nil {
+		return fmt.Errorf("failed to store campaign snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// TimelinePhase is one phase's counts within a timeline point
+type TimelinePhase struct {
+	Name     string             `json:"name"`
+	Order    int                `json:"order"`
+	Status   models.PhaseStatus `json:"status"`
+	Target   int                `json:"target"`
+	Success  int                `json:"success"`
+	Failed   int                `json:"failed"`
+	InFlight int                `json:"in_flight"`
+}
+
+// TimelinePoint is the campaign's progress at one snapshot time
+type TimelinePoint struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Success   int             `json:"success"`
+	Failed    int             `json:"failed"`
+	InFlight  int             `json:"in_flight"`
+	Phases    []TimelinePhase `json:"phases"`
+}
+
+// GetTimeline returns campaign progress snapshots between since and until,
+// oldest first. Zero times leave the range open.
+func (m *Manager) GetTimeline(ctx context.Context, tenantID, campaignID string, since, until time.Time) ([]TimelinePoint, error) {
+	if _, err := m.Get(ctx, tenantID, campaignID); err != nil {
+		return nil, err
+	}
+
+	query := m.db.WithContext(ctx).Where("campaign_id = ?", campaignID)
+	if !since.IsZero() {
+		query = query.Where("recorded_at >= ?", since)
+	}
+	if !until.IsZero() {
+		query = query.Where("recorded_at <= ?", until)
+	}
+
+	var snapshots []models.CampaignProgressSnapshot
+	if err := query.Order("recorded_at ASC, phase_order ASC").Find(&snapshots).Error; err != nil {
+		return nil, fmt.Errorf("failed to load campaign timeline: %w", err)
+	}
+
+	points := make([]TimelinePoint, 0)
+	index := make(map[int64]int)
+	for _, s := range snapshots {
+		key := s.RecordedAt.Unix()
+		i, ok := index[key]
+		if !ok {
+			points = append(points, TimelinePoint{Timestamp: s.RecordedAt})
+			i = len(points) - 1
+			index[key] = i
+		}
+
+		point := &points[i]
+		point.Success += s.SuccessCount
+		point.Failed += s.FailureCount
+		point.InFlight += s.InFlightCount
+		point.Phases = append(point.Phases, TimelinePhase{
+			Name:     s.PhaseName,
+			Order:    s.PhaseOrder,
+			Status:   s.PhaseStatus,
+			Target:   s.TargetCount,
+			Success:  s.SuccessCount,
+			Failed:   s.FailureCount,
+			InFlight: s.InFlightCount,
+		})
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Timestamp.Before(points[j].Timestamp)
+	})
+
+	return points, nil
+}
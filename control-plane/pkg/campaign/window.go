@@ -0,0 +1,153 @@
+package campaign
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// windowClock is the layout of a window's start and end
+const windowClock = "15:04"
+
+// windowDays maps the day names a window accepts to weekdays
+var windowDays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Window is the recurring time a campaign may start phases and dispatch
+// executions in, e.g. 22:00-04:00 on weekdays. Executions already running
+// when the window closes finish; the rest wait for it to open again. A
+// window ending before it starts runs past midnight, and Days are the days
+// it opens on. All days are allowed when Days is empty.
+type Window struct {
+	Days     []string `json:"days,omitempty" yaml:"days,omitempty"`
+	Start    string   `json:"start" yaml:"start"`
+	End      string   `json:"end" yaml:"end"`
+	Timezone string   `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+}
+
+// openWindow is a validated window
+type openWindow struct {
+	days     map[time.Weekday]bool
+	start    time.Duration
+	end      time.Duration
+	location *time.Location
+}
+
+// validate checks the window and normalises its day names
+func (w *Window) validate() (*openWindow, error) {
+	start, err := time.Parse(windowClock, w.Start)
+	if err != nil {
+		return nil, apperror.InvalidInput("window.start %q must be HH:MM", w.Start)
+	}
+	end, err := time.Parse(windowClock, w.End)
+	if err != nil {
+		return nil, apperror.InvalidInput("window.end %q must be HH:MM", w.End)
+	}
+	if start.Equal(end) {
+		return nil, apperror.InvalidInput("window.start and window.end must differ")
+	}
+	if w.Timezone == "" {
+		w.Timezone = "UTC"
+	}
+	location, err := time.LoadLocation(w.Timezone)
+	if err != nil {
+		return nil, apperror.InvalidInput("invalid window.timezone %q", w.Timezone)
+	}
+
+	open := &openWindow{
+		start:    time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute,
+		end:      time.Duration(end.Hour())*time.Hour + time.Duration(end.Minute())*time.Minute,
+		location: location,
+	}
+	if len(w.Days) > 0 {
+		open.days = make(map[time.Weekday]bool, len(w.Days))
+		for i, day := range w.Days {
+			name := strings.ToLower(day)
+			if len(name) > 3 {
+				name = name[:3]
+			}
+			weekday, ok := windowDays[name]
+			if !ok {
+				return nil, apperror.InvalidInput("invalid window day %q", day)
+			}
+			w.Days[i] = name
+			open.days[weekday] = true
+		}
+	}
+	return open, nil
+}
+
+// contains reports whether t falls in the window. A window running past
+// midnight belongs to the day it opened on.
+func (o *openWindow) contains(t time.Time) bool {
+	t = t.In(o.location)
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	day := t.Weekday()
+	if o.start < o.end {
+		if clock < o.start || clock >= o.end {
+			return false
+		}
+	} else {
+		switch {
+		case clock >= o.start:
+		case clock < o.end:
+			day = (day + 6) % 7
+		default:
+			return false
+		}
+	}
+	return o.days == nil || o.days[day]
+}
+
+// toMap encodes the window for storage
+func (w *Window) toMap() (models.JSONMap, error) {
+	data, err := json.Marshal(w)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode window: %w", err)
+	}
+	var m models.JSONMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to encode window: %w", err)
+	}
+	return m, nil
+}
+
+// campaignWindow returns a campaign's window, or nil when it has none
+func campaignWindow(campaign *models.Campaign) (*Window, error) {
+	if len(campaign.Window) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(campaign.Window)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode window: %w", err)
+	}
+	var w Window
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, fmt.Errorf("failed to decode window: %w", err)
+	}
+	return &w, nil
+}
+
+// inWindow reports whether the campaign may dispatch at t
+func inWindow(campaign *models.Campaign, t time.Time) (bool, error) {
+	w, err := campaignWindow(campaign)
+	if err != nil || w == nil {
+		return true, err
+	}
+	open, err := w.validate()
+	if err != nil {
+		return false, err
+	}
+	return open.contains(t), nil
+}
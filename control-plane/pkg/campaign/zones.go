@@ -0,0 +1,325 @@
+package campaign
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// maxZones bounds the zones of a campaign
+const maxZones = 100
+
+// Zones runs a campaign one zone at a time. Agents are grouped by the value
+// of their Tag, e.g. region, and every phase of the campaign runs in the
+// first zone of Order before any agent of the next zone is touched.
+// Percentages are of the zone's agents. Agents whose zone is not listed are
+// not targeted.
+//
+// After a zone's last phase its success rate across all of its phases must
+// reach its threshold, from Thresholds or SuccessThreshold, or the campaign
+// fails before the next zone. Excluded zones, set through ExcludeZone, are
+// skipped and their unfinished phases cancelled.
+type Zones struct {
+	Tag              string             `json:"tag" yaml:"tag"`
+	Order            []string           `json:"order" yaml:"order"`
+	SuccessThreshold float64            `json:"success_threshold,omitempty" yaml:"success_threshold,omitempty"`
+	Thresholds       map[string]float64 `json:"thresholds,omitempty" yaml:"thresholds,omitempty"`
+	Excluded         []string           `json:"excluded,omitempty" yaml:"excluded,omitempty"`
+}
+
+// validate checks the zones of a new campaign
+func (z *Zones) validate() error {
+	z.Tag = strings.TrimSpace(z.Tag)
+	if z.Tag == "" {
+		return apperror.InvalidInput("zones.tag is required")
+	}
+	if len(z.Order) == 0 || len(z.Order) > maxZones {
+		return apperror.InvalidInput("zones.order must list between 1 and %d zones", maxZones)
+	}
+	seen := make(map[string]bool, len(z.Order))
+	for _, zone := range z.Order {
+		if strings.TrimSpace(zone) == "" {
+			return apperror.InvalidInput("zones.order must not contain empty zones")
+		}
+		if len(zone) > 255 {
+			return apperror.InvalidInput("zone %q is longer than 255 characters", zone)
+		}
+		if seen[zone] {
+			return apperror.InvalidInput("zone %q is listed twice", zone)
+		}
+		seen[zone] = true
+	}
+	if z.SuccessThreshold < 0 || z.SuccessThreshold > 100 {
+		return apperror.InvalidInput("zones.success_threshold must be between 0 and 100")
+	}
+	for zone, threshold := range z.Thresholds {
+		if !seen[zone] {
+			return apperror.InvalidInput("zones.thresholds: zone %q is not in zones.order", zone)
+		}
+		if threshold < 0 || threshold > 100 {
+			return apperror.InvalidInput("zones.thresholds: %s must be between 0 and 100", zone)
+		}
+	}
+	z.Excluded = nil
+	return nil
+}
+
+// threshold returns a zone's success threshold in percent
+func (z *Zones) threshold(zone string) float64 {
+	if threshold, ok := z.Thresholds[zone]; ok {
+		return threshold
+	}
+	return z.SuccessThreshold
+}
+
+// excluded reports whether a zone has been excluded
+func (z *Zones) excluded(zone string) bool {
+	for _, excluded := range z.Excluded {
+		if excluded == zone {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether a zone is one of the campaign's
+func (z *Zones) contains(zone string) bool {
+	for _, listed := range z.Order {
+		if listed == zone {
+			return true
+		}
+	}
+	return false
+}
+
+// agentZone returns the zone of an agent, or "" when it has none
+func (z *Zones) agentZone(agent *models.Agent) string {
+	value, ok := agent.Tags[z.Tag]
+	if !ok || value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// toMap encodes the zones for storage
+func (z *Zones) toMap() (models.JSONMap, error) {
+	data, err := json.Marshal(z)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode zones: %w", err)
+	}
+	var m models.JSONMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to encode zones: %w", err)
+	}
+	return m, nil
+}
+
+// campaignZones returns a campaign's zones, or nil when it is not zoned
+func campaignZones(campaign *models.Campaign) (*Zones, error) {
+	if len(campaign.Zones) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(campaign.Zones)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode zones: %w", err)
+	}
+	var z Zones
+	if err := json.Unmarshal(data, &z); err != nil {
+		return nil, fmt.Errorf("failed to decode zones: %w", err)
+	}
+	return &z, nil
+}
+
+// zonePhases returns the phases of a campaign run through every zone in
+// order, or just the configured phases when it is not zoned
+func zonePhases(config []PhaseConfig, zones *Zones) []models.CampaignPhase {
+	names := []string{""}
+	if zones != nil {
+		names = zones.Order
+	}
+
+	phases := make([]models.CampaignPhase, 0, len(config)*len(names))
+	for _, zone := range names {
+		for i, phase := range config {
+			phases = append(phases, models.CampaignPhase{
+				PhaseName:      phase.Name,
+				PhaseOrder:     len(phases),
+				ConfigIndex:    i,
+				Zone:           zone,
+				Status:         models.PhaseStatusPending,
+				ManualApproval: phase.ManualApproval,
+			})
+		}
+	}
+	return phases
+}
+
+// zoneOutcome checks whether a zone whose phases have all finished met its
+// success threshold. It returns the zone's success rate, the threshold and
+// ok; ok is also true while the zone still has phases to run.
+func (d *Dispatcher) zoneOutcome(ctx context.Context, campaign *models.Campaign, zones *Zones, zone string) (rate, threshold float64, ok bool, err error) {
+	var phases []models.CampaignPhase
+	if err := d.db.WithContext(ctx).
+		Where("campaign_id = ? AND zone = ?", campaign.ID, zone).
+		Find(&phases).Error; err != nil {
+		return 0, 0, false, fmt.Errorf("failed to load zone phases: %w", err)
+	}
+
+	success, failure := 0, 0
+	for _, phase := range phases {
+		if phase.Status == models.PhaseStatusPending || phase.Status == models.PhaseStatusRunning {
+			return 0, 0, true, nil
+		}
+		success += phase.SuccessCount
+		failure += phase.FailureCount
+	}
+	threshold = zones.threshold(zone)
+	if success+failure == 0 {
+		return 0, threshold, true, nil
+	}
+	rate = float64(success) / float64(success+failure) * 100
+	return rate, threshold, rate >= threshold, nil
+}
+
+// ExcludeZone takes a zone out of a running, paused or draft campaign
+// without cancelling the campaign. The zone's unfinished phases are
+// cancelled and the campaign continues with the next zone; executions
+// already dispatched in the zone run to completion.
+func (m *Manager) ExcludeZone(ctx context.Context, tenantID, campaignID, zone, excludedBy string) (*models.Campaign, error) {
+	campaign, err := m.Get(ctx, tenantID, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	switch campaign.Status {
+	case models.CampaignStatusDraft, models.CampaignStatusRunning, models.CampaignStatusPaused:
+	default:
+		return nil, apperror.InvalidState("zones cannot be excluded from a campaign with status: %s", campaign.Status)
+	}
+	zones, err := campaignZones(campaign)
+	if err != nil {
+		return nil, err
+	}
+	if zones == nil {
+		return nil, apperror.InvalidState("campaign is not zoned")
+	}
+	if !zones.contains(zone) {
+		return nil, apperror.NotFound("zone %q is not part of the campaign", zone)
+	}
+	if zones.excluded(zone) {
+		return nil, apperror.Conflict("zone %q is already excluded", zone)
+	}
+	zones.Excluded = append(zones.Excluded, zone)
+	stored, err := zones.toMap()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var cancelled int64
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// The status is checked again so a campaign finishing meanwhile
+		// is left alone
+		result := tx.Model(&models.Campaign{}).
+			Where("id = ? AND status = ?", campaign.ID, campaign.Status).
+			Updates(map[string]interface{}{
+				"zone_config": stored,
+				"updated_at":  now,
+			})
+		if result.Error != nil {
+			return fmt.Errorf("failed to exclude zone: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return apperror.Conflict("campaign changed status; try again")
+		}
+
+		result = tx.Model(&models.CampaignPhase{}).
+			Where("campaign_id = ? AND zone = ? AND status IN ?", campaign.ID, zone, []models.PhaseStatus{
+				models.PhaseStatusPending,
+				models.PhaseStatusRunning,
+				models.PhaseStatusAwaitingApproval,
+			}).
+			Updates(map[string]interface{}{
+				"status":       models.PhaseStatusCancelled,
+				"completed_at": now,
+			})
+		if result.Error != nil {
+			return fmt.Errorf("failed to cancel zone phases: %w", result.Error)
+		}
+		cancelled = result.RowsAffected
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.logger.Info("campaign zone excluded",
+		zap.String("campaign_id", campaign.ID),
+		zap.String("zone", zone),
+		zap.Int64("cancelled_phases", cancelled),
+		zap.String("excluded_by", excludedBy))
+
+	return m.Get(ctx, tenantID, campaignID)
+}
+
+// zoneProgress summarises each zone of a campaign from its phases
+func zoneProgress(zones *Zones, phases []models.CampaignPhase) []models.ZoneProgress {
+	progress := make([]models.ZoneProgress, len(zones.Order))
+	index := make(map[string]int, len(zones.Order))
+	for i, zone := range zones.Order {
+		progress[i] = models.ZoneProgress{
+			Zone:      zone,
+			Status:    models.ZoneStatusPending,
+			Threshold: zones.threshold(zone),
+		}
+		index[zone] = i
+	}
+
+	finished := make(map[string]int)
+	total := make(map[string]int)
+	for _, phase := range phases {
+		i, ok := index[phase.Zone]
+		if !ok {
+			continue
+		}
+		p := &progress[i]
+		p.SuccessfulAgents += phase.SuccessCount
+		p.FailedAgents += phase.FailureCount
+		total[phase.Zone]++
+		switch phase.Status {
+		case models.PhaseStatusRunning, models.PhaseStatusAwaitingApproval:
+			p.Status = models.ZoneStatusRunning
+		case models.PhaseStatusFailed:
+			p.Status = models.ZoneStatusFailed
+		case models.PhaseStatusSuccess, models.PhaseStatusCancelled:
+			finished[phase.Zone]++
+		}
+	}
+
+	for i := range progress {
+		p := &progress[i]
+		if completed := p.SuccessfulAgents + p.FailedAgents; completed > 0 {
+			p.SuccessRate = float64(p.SuccessfulAgents) / float64(completed) * 100
+		}
+		switch {
+		case zones.excluded(p.Zone):
+			p.Status = models.ZoneStatusExcluded
+		case p.Status != models.ZoneStatusPending:
+		case total[p.Zone] > 0 && finished[p.Zone] == total[p.Zone]:
+			p.Status = models.ZoneStatusCompleted
+			if p.SuccessfulAgents+p.FailedAgents > 0 && p.SuccessRate < p.Threshold {
+				p.Status = models.ZoneStatusFailed
+			}
+		case finished[p.Zone] > 0:
+			p.Status = models.ZoneStatusRunning
+		}
+	}
+	return progress
+}
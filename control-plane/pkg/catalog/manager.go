@@ -0,0 +1,549 @@
+// Package catalog publishes workflows and templates of the platform tenant
+// to every tenant. Tenants see published entries read-only and instantiate
+// copies of them they own and may change; when an entry is published at a
+// new version, tenants with older copies are alerted and may upgrade them.
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/template"
+	"github.com/yourorg/control-plane/pkg/workflow"
+)
+
+// Manager manages the catalog
+type Manager struct {
+	db               *gorm.DB
+	platformTenantID string
+	workflowManager  *workflow.Manager
+	templateManager  *template.Manager
+	auditLogger      *audit.Logger
+	logger           *zap.Logger
+}
+
+// NewManager creates a catalog manager publishing from the platform
+// tenant. Publishing is refused while no platform tenant is configured.
+func NewManager(db *gorm.DB, platformTenantID string, workflowManager *workflow.Manager, templateManager *template.Manager, logger *zap.Logger) *Manager {
+	return &Manager{
+		db:               db,
+		platformTenantID: platformTenantID,
+		workflowManager:  workflowManager,
+		templateManager:  templateManager,
+		logger:           logger,
+	}
+}
+
+// SetAuditLogger sets the logger that receives upstream update alerts
+func (m *Manager) SetAuditLogger(auditLogger *audit.Logger) {
+	m.auditLogger = auditLogger
+}
+
+// PublishRequest publishes a workflow or template of the platform tenant,
+// or publishes it again at its current version
+type PublishRequest struct {
+	Kind        models.CatalogKind `json:"kind" binding:"required"`
+	SourceID    string             `json:"source_id" binding:"required"`
+	ReleaseNote string             `json:"release_note"`
+	PublishedBy string             `json:"-"`
+}
+
+// Publish snapshots an active workflow or template of the platform tenant
+// into the catalog. Publishing a source already in the catalog updates the
+// entry when the source has a newer version, alerting tenants whose copies
+// are behind, and republishes a withdrawn entry.
+func (m *Manager) Publish(ctx context.Context, req *PublishRequest) (*models.CatalogEntry, error) {
+	if m.platformTenantID == "" {
+		return nil, apperror.InvalidState("no platform tenant is configured (catalog.platform_tenant_id)")
+	}
+
+	snapshot, err := m.snapshot(ctx, req.Kind, req.SourceID)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.ReleaseNote = req.ReleaseNote
+	snapshot.PublishedBy = req.PublishedBy
+
+	var entry models.CatalogEntry
+	err = m.db.WithContext(ctx).
+		Where("kind = ? AND source_id = ?", req.Kind, req.SourceID).
+		First(&entry).Error
+	if err == gorm.ErrRecordNotFound {
+		now := time.Now()
+		snapshot.ID = uuid.New().String()
+		snapshot.Status = models.CatalogEntryPublished
+		snapshot.PublishedAt = now
+		snapshot.CreatedAt = now
+		snapshot.UpdatedAt = now
+		if err := m.db.WithContext(ctx).Create(snapshot).Error; err != nil {
+			return nil, fmt.Errorf("failed to publish %s: %w", req.Kind, err)
+		}
+		m.logger.Info("catalog entry published",
+			zap.String("entry_id", snapshot.ID),
+			zap.String("kind", string(snapshot.Kind)),
+			zap.String("name", snapshot.Name),
+			zap.Int("version", snapshot.Version))
+		return snapshot, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get catalog entry: %w", err)
+	}
+
+	if snapshot.Version <= entry.Version && entry.Status == models.CatalogEntryPublished {
+		return nil, apperror.Conflict("%s %s is already published at version %d", req.Kind, req.SourceID, entry.Version)
+	}
+
+	previous := entry.Version
+	now := time.Now()
+	if err := m.db.WithContext(ctx).Model(&entry).Updates(map[string]interface{}{
+		"name":         snapshot.Name,
+		"description":  snapshot.Description,
+		"version":      snapshot.Version,
+		"status":       models.CatalogEntryPublished,
+		"tags":         snapshot.Tags,
+		"definition":   snapshot.Definition,
+		"content":      snapshot.Content,
+		"content_type": snapshot.ContentType,
+		"release_note": snapshot.ReleaseNote,
+		"published_by": snapshot.PublishedBy,
+		"published_at": now,
+		"updated_at":   now,
+	}).Error; err != nil {
+		return nil, fmt.Errorf("failed to publish %s: %w", req.Kind, err)
+	}
+
+	updated, err := m.Get(ctx, entry.ID)
+	if err != nil {
+		return nil, err
+	}
+	m.logger.Info("catalog entry republished",
+		zap.String("entry_id", updated.ID),
+		zap.String("name", updated.Name),
+		zap.Int("previous_version", previous),
+		zap.Int("version", updated.Version))
+	if updated.Version > previous {
+		m.alertCopies(ctx, updated, previous)
+	}
+	return updated, nil
+}
+
+// snapshot reads the source of a catalog entry from the platform tenant
+func (m *Manager) snapshot(ctx context.Context, kind models.CatalogKind, sourceID string) (*models.CatalogEntry, error) {
+	entry := &models.CatalogEntry{
+		Kind:           kind,
+		SourceTenantID: m.platformTenantID,
+		SourceID:       sourceID,
+	}
+
+	switch kind {
+	case models.CatalogKindWorkflow:
+		source, err := m.workflowManager.Get(ctx, m.platformTenantID, sourceID)
+		if err != nil {
+			return nil, err
+		}
+		if source.Status != models.WorkflowStatusActive {
+			return nil, apperror.InvalidState("only active workflows can be published; workflow %s is %s", source.ID, source.Status)
+		}
+		entry.Name = source.Name
+		entry.Description = source.Description
+		entry.Version = source.Version
+		entry.Tags = source.Tags
+		entry.Definition = source.Definition
+	case models.CatalogKindTemplate:
+		source, err := m.templateManager.Get(ctx, m.platformTenantID, sourceID)
+		if err != nil {
+			return nil, err
+		}
+		if source.Status != models.TemplateStatusActive {
+			return nil, apperror.InvalidState("only active templates can be published; template %s is %s", source.ID, source.Status)
+		}
+		if source.Uploaded {
+			return nil, apperror.InvalidState("templates with uploaded content cannot be published; template %s has %d bytes uploaded", source.ID, source.ContentSize)
+		}
+		entry.Name = source.Name
+		entry.Description = source.Description
+		entry.Version = source.Version
+		entry.Tags = source.Tags
+		entry.Content = source.Content
+		entry.ContentType = source.ContentType
+	default:
+		return nil, apperror.InvalidInput("kind must be workflow or template")
+	}
+	return entry, nil
+}
+
+// Withdraw takes an entry out of the catalog. Existing copies are kept but
+// no new copies can be made and copies can no longer be upgraded.
+func (m *Manager) Withdraw(ctx context.Context, entryID string) (*models.CatalogEntry, error) {
+	result := m.db.WithContext(ctx).Model(&models.CatalogEntry{}).
+		Where("id = ? AND status = ?", entryID, models.CatalogEntryPublished).
+		Updates(map[string]interface{}{
+			"status":     models.CatalogEntryWithdrawn,
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to withdraw catalog entry: %w", result.Error)
+	}
+
+	entry, err := m.Get(ctx, entryID)
+	if err != nil {
+		return nil, err
+	}
+	if result.RowsAffected == 0 {
+		return nil, apperror.InvalidState("catalog entry %s is already withdrawn", entryID)
+	}
+
+	m.logger.Info("catalog entry withdrawn",
+		zap.String("entry_id", entry.ID),
+		zap.String("name", entry.Name))
+	return entry, nil
+}
+
+// Get returns a catalog entry
+func (m *Manager) Get(ctx context.Context, entryID string) (*models.CatalogEntry, error) {
+	var entry models.CatalogEntry
+	if err := m.db.WithContext(ctx).Where("id = ?", entryID).First(&entry).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("catalog entry not found")
+		}
+		return nil, fmt.Errorf("failed to get catalog entry: %w", err)
+	}
+	return &entry, nil
+}
+
+// ListEntriesRequest filters catalog entries
+type ListEntriesRequest struct {
+	Kind models.CatalogKind
+	// Search matches the name
+	Search string
+	// IncludeWithdrawn lists withdrawn entries too
+	IncludeWithdrawn bool
+	Limit            int
+	Offset           int
+}
+
+// List returns catalog entries by name
+func (m *Manager) List(ctx context.Context, req *ListEntriesRequest) ([]models.CatalogEntry, int64, error) {
+	query := m.db.WithContext(ctx).Model(&models.CatalogEntry{})
+	if req.Kind != "" {
+		query = query.Where("kind = ?", req.Kind)
+	}
+	if !req.IncludeWithdrawn {
+		query = query.Where("status = ?", models.CatalogEntryPublished)
+	}
+	if search := strings.TrimSpace(req.Search); search != "" {
+		query = query.Where("name LIKE ?", "%"+search+"%")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count catalog entries: %w", err)
+	}
+
+	limit := req.Limit
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+	var entries []models.CatalogEntry
+	if err := query.Order("name ASC, kind ASC").Limit(limit).Offset(req.Offset).Find(&entries).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to list catalog entries: %w", err)
+	}
+	return entries, total, nil
+}
+
+// InstantiateRequest copies a catalog entry into a tenant
+type InstantiateRequest struct {
+	// Name of the copy; defaults to the entry's name
+	Name      string `json:"name"`
+	TenantID  string `json:"-"`
+	EntryID   string `json:"-"`
+	CreatedBy string `json:"-"`
+}
+
+// CopyResult is a tenant's copy of a catalog entry and the workflow or
+// template holding it
+type CopyResult struct {
+	Copy     *models.CatalogCopy `json:"copy"`
+	Workflow *models.Workflow    `json:"workflow,omitempty"`
+	Template *models.Template    `json:"template,omitempty"`
+}
+
+// Instantiate copies a published entry into a tenant as a draft workflow or
+// template the tenant owns. The tenant's quotas and workflow policy apply
+// to the copy as to any workflow it creates.
+func (m *Manager) Instantiate(ctx context.Context, req *InstantiateRequest) (*CopyResult, error) {
+	entry, err := m.Get(ctx, req.EntryID)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Status != models.CatalogEntryPublished {
+		return nil, apperror.InvalidState("catalog entry %s is withdrawn", entry.ID)
+	}
+	if req.TenantID == entry.SourceTenantID {
+		return nil, apperror.InvalidInput("the platform tenant owns catalog entry %s and cannot copy it", entry.ID)
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = entry.Name
+	}
+
+	result := &CopyResult{}
+	var targetID string
+	switch entry.Kind {
+	case models.CatalogKindWorkflow:
+		created, err := m.workflowManager.Create(ctx, &workflow.CreateWorkflowRequest{
+			TenantID:    req.TenantID,
+			Name:        name,
+			Description: entry.Description,
+			Definition:  entry.Definition,
+			Tags:        stringTags(entry.Tags),
+			CreatedBy:   req.CreatedBy,
+		})
+		if err != nil {
+			return nil, err
+		}
+		result.Workflow = created
+		targetID = created.ID
+	case models.CatalogKindTemplate:
+		created, err := m.templateManager.Create(ctx, &template.CreateTemplateRequest{
+			TenantID:    req.TenantID,
+			Name:        name,
+			Description: entry.Description,
+			Content:     entry.Content,
+			ContentType: entry.ContentType,
+			Tags:        entry.Tags,
+			CreatedBy:   req.CreatedBy,
+		})
+		if err != nil {
+			return nil, err
+		}
+		result.Template = created
+		targetID = created.ID
+	default:
+		return nil, fmt.Errorf("catalog entry %s has unknown kind %q", entry.ID, entry.Kind)
+	}
+
+	now := time.Now()
+	result.Copy = &models.CatalogCopy{
+		ID:        uuid.New().String(),
+		TenantID:  req.TenantID,
+		EntryID:   entry.ID,
+		Kind:      entry.Kind,
+		TargetID:  targetID,
+		Version:   entry.Version,
+		CreatedBy: req.CreatedBy,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := m.db.WithContext(ctx).Create(result.Copy).Error; err != nil {
+		return nil, fmt.Errorf("failed to record catalog copy: %w", err)
+	}
+
+	m.logger.Info("catalog entry copied",
+		zap.String("entry_id", entry.ID),
+		zap.String("tenant_id", req.TenantID),
+		zap.String("target_id", targetID),
+		zap.Int("version", entry.Version))
+	return result, nil
+}
+
+// CopyStatus is a tenant's copy of a catalog entry and whether the entry
+// has been published at a later version since
+type CopyStatus struct {
+	models.CatalogCopy
+	Name            string                    `json:"name"`
+	EntryStatus     models.CatalogEntryStatus `json:"entry_status"`
+	LatestVersion   int                       `json:"latest_version"`
+	UpdateAvailable bool                      `json:"update_available"`
+	ReleaseNote     string                    `json:"release_note,omitempty"`
+}
+
+// ListCopies returns a tenant's copies of catalog entries, optionally only
+// those with an upstream update available
+func (m *Manager) ListCopies(ctx context.Context, tenantID string, updatesOnly bool) ([]CopyStatus, error) {
+	var copies []models.CatalogCopy
+	if err := m.db.WithContext(ctx).
+		Where("tenant_id = ?", tenantID).
+		Order("created_at DESC").
+		Find(&copies).Error; err != nil {
+		return nil, fmt.Errorf("failed to list catalog copies: %w", err)
+	}
+	if len(copies) == 0 {
+		return []CopyStatus{}, nil
+	}
+
+	ids := make([]string, 0, len(copies))
+	for _, c := range copies {
+		ids = append(ids, c.EntryID)
+	}
+	var entries []models.CatalogEntry
+	if err := m.db.WithContext(ctx).
+		Select("id", "name", "version", "status", "release_note").
+		Where("id IN ?", ids).
+		Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("failed to get catalog entries: %w", err)
+	}
+	byID := make(map[string]models.CatalogEntry, len(entries))
+	for _, entry := range entries {
+		byID[entry.ID] = entry
+	}
+
+	statuses := make([]CopyStatus, 0, len(copies))
+	for _, c := range copies {
+		entry := byID[c.EntryID]
+		status := CopyStatus{
+			CatalogCopy:     c,
+			Name:            entry.Name,
+			EntryStatus:     entry.Status,
+			LatestVersion:   entry.Version,
+			UpdateAvailable: entry.Status == models.CatalogEntryPublished && entry.Version > c.Version,
+		}
+		if status.UpdateAvailable {
+			status.ReleaseNote = entry.ReleaseNote
+		} else if updatesOnly {
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Upgrade replaces a copy's definition or content with that of the latest
+// published version of its entry, as a new version of the copy. Changes the
+// tenant made to the copy are overwritten.
+func (m *Manager) Upgrade(ctx context.Context, tenantID, copyID, changedBy string) (*CopyResult, error) {
+	var catalogCopy models.CatalogCopy
+	if err := m.db.WithContext(ctx).Where("id = ? AND tenant_id = ?", copyID, tenantID).First(&catalogCopy).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("catalog copy not found")
+		}
+		return nil, fmt.Errorf("failed to get catalog copy: %w", err)
+	}
+	entry, err := m.Get(ctx, catalogCopy.EntryID)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Status != models.CatalogEntryPublished {
+		return nil, apperror.InvalidState("catalog entry %s is withdrawn", entry.ID)
+	}
+	if entry.Version <= catalogCopy.Version {
+		return nil, apperror.InvalidState("copy is already at the latest version %d", entry.Version)
+	}
+
+	result := &CopyResult{Copy: &catalogCopy}
+	switch catalogCopy.Kind {
+	case models.CatalogKindWorkflow:
+		current, err := m.workflowManager.Get(ctx, tenantID, catalogCopy.TargetID)
+		if err != nil {
+			return nil, err
+		}
+		result.Workflow, err = m.workflowManager.Update(ctx, tenantID, catalogCopy.TargetID, &workflow.UpdateWorkflowRequest{
+			Definition:      entry.Definition,
+			ExpectedVersion: &current.Version,
+		})
+		if err != nil {
+			return nil, err
+		}
+	case models.CatalogKindTemplate:
+		current, err := m.templateManager.Get(ctx, tenantID, catalogCopy.TargetID)
+		if err != nil {
+			return nil, err
+		}
+		result.Template, err = m.templateManager.Update(ctx, tenantID, catalogCopy.TargetID, &template.UpdateTemplateRequest{
+			Content:         &entry.Content,
+			ContentType:     &entry.ContentType,
+			ChangedBy:       changedBy,
+			ChangeNote:      fmt.Sprintf("Upgraded to catalog version %d", entry.Version),
+			ExpectedVersion: &current.Version,
+		})
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("catalog copy %s has unknown kind %q", catalogCopy.ID, catalogCopy.Kind)
+	}
+
+	previous := catalogCopy.Version
+	catalogCopy.Version = entry.Version
+	catalogCopy.UpdatedAt = time.Now()
+	if err := m.db.WithContext(ctx).Model(&catalogCopy).Updates(map[string]interface{}{
+		"version":    catalogCopy.Version,
+		"updated_at": catalogCopy.UpdatedAt,
+	}).Error; err != nil {
+		return nil, fmt.Errorf("failed to update catalog copy: %w", err)
+	}
+
+	m.logger.Info("catalog copy upgraded",
+		zap.String("copy_id", catalogCopy.ID),
+		zap.String("tenant_id", tenantID),
+		zap.Int("previous_version", previous),
+		zap.Int("version", catalogCopy.Version))
+	return result, nil
+}
+
+// alertCopies raises an alert in each tenant holding a copy of an entry
+// made before it was published at its current version
+func (m *Manager) alertCopies(ctx context.Context, entry *models.CatalogEntry, previous int) {
+	if m.auditLogger == nil {
+		return
+	}
+
+	var copies []models.CatalogCopy
+	if err := m.db.WithContext(ctx).
+		Where("entry_id = ? AND version < ?", entry.ID, entry.Version).
+		Find(&copies).Error; err != nil {
+		m.logger.Warn("failed to list catalog copies to alert",
+			zap.String("entry_id", entry.ID),
+			zap.Error(err))
+		return
+	}
+
+	for _, c := range copies {
+		description := fmt.Sprintf("catalog %s %q was published at version %d; your copy is at version %d", entry.Kind, entry.Name, entry.Version, c.Version)
+		if err := m.auditLogger.NewEventBuilder().
+			WithTenant(c.TenantID).
+			WithType(audit.EventTypeAlert).
+			WithAction(audit.ActionCreate).
+			WithOutcome(audit.OutcomeSuccess).
+			WithActor("catalog", "system").
+			WithResource(c.TargetID, string(c.Kind)).
+			WithDescription(description).
+			WithMetadata(map[string]interface{}{
+				"kind":             "catalog_update",
+				"entry_id":         entry.ID,
+				"copy_id":          c.ID,
+				"copy_version":     c.Version,
+				"previous_version": previous,
+				"latest_version":   entry.Version,
+				"release_note":     entry.ReleaseNote,
+			}).
+			Log(ctx); err != nil {
+			m.logger.Warn("failed to alert tenant of catalog update",
+				zap.String("entry_id", entry.ID),
+				zap.String("tenant_id", c.TenantID),
+				zap.Error(err))
+		}
+	}
+}
+
+// stringTags converts stored tags to workflow tags
+func stringTags(tags models.JSONMap) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	converted := make(map[string]string, len(tags))
+	for key, value := range tags {
+		converted[key] = fmt.Sprint(value)
+	}
+	return converted
+}
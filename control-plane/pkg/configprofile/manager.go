@@ -0,0 +1,286 @@
+// Package configprofile manages agent configuration profiles: settings
+// assigned to agents directly or by tags, pulled by agents with their
+// health reports.
+package configprofile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// Assignment sources: how an agent's profile was chosen
+const (
+	SourceAgent    = "agent"
+	SourceSelector = "selector"
+)
+
+// Manager manages configuration profiles and their rollout to agents
+type Manager struct {
+	db     *gorm.DB
+	logger *zap.Logger
+}
+
+// NewManager creates a configuration profile manager
+func NewManager(db *gorm.DB, logger *zap.Logger) *Manager {
+	return &Manager{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// CreateProfileRequest represents a request to create a configuration
+// profile
+type CreateProfileRequest struct {
+	TenantID    string                 `json:"tenant_id"`
+	Name        string                 `json:"name" binding:"required"`
+	Description string                 `json:"description"`
+	Settings    map[string]interface{} `json:"settings" binding:"required"`
+	// Selector assigns the profile to agents having all of these tags
+	Selector  map[string]string `json:"selector"`
+	Priority  int               `json:"priority"`
+	CreatedBy string            `json:"created_by"`
+}
+
+// Create creates a configuration profile
+func (m *Manager) Create(ctx context.Context, req *CreateProfileRequest) (*models.ConfigProfile, error) {
+	settings, err := normalizeSettings(req.Settings)
+	if err != nil {
+		return nil, err
+	}
+	selector, err := normalizeSelector(req.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &models.ConfigProfile{
+		ID:          uuid.New().String(),
+		TenantID:    req.TenantID,
+		Name:        strings.TrimSpace(req.Name),
+		Description: req.Description,
+		Settings:    settings,
+		Selector:    selector,
+		Priority:    req.Priority,
+		Version:     1,
+		CreatedBy:   req.CreatedBy,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := m.checkName(ctx, profile); err != nil {
+		return nil, err
+	}
+
+	if err := m.db.WithContext(ctx).Create(profile).Error; err != nil {
+		return nil, fmt.Errorf("failed to create configuration profile: %w", err)
+	}
+
+	m.logger.Info("configuration profile created",
+		zap.String("profile_id", profile.ID),
+		zap.String("tenant_id", profile.TenantID),
+		zap.String("name", profile.Name))
+
+	return profile, nil
+}
+
+// Get retrieves a configuration profile by ID
+func (m *Manager) Get(ctx context.Context, tenantID, profileID string) (*models.ConfigProfile, error) {
+	var profile models.ConfigProfile
+	if err := m.db.WithContext(ctx).Where("id = ? AND tenant_id = ?", profileID, tenantID).First(&profile).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("configuration profile not found")
+		}
+		return nil, fmt.Errorf("failed to get configuration profile: %w", err)
+	}
+	return &profile, nil
+}
+
+// List lists a tenant's configuration profiles by descending priority
+func (m *Manager) List(ctx context.Context, tenantID string) ([]models.ConfigProfile, error) {
+	var profiles []models.ConfigProfile
+	if err := m.db.WithContext(ctx).Where("tenant_id = ?", tenantID).
+		Order("priority DESC, name ASC").
+		Find(&profiles).Error; err != nil {
+		return nil, fmt.Errorf("failed to list configuration profiles: %w", err)
+	}
+	return profiles, nil
+}
+
+// UpdateProfileRequest represents a request to update a configuration
+// profile. Settings replace the profile's settings.
+type UpdateProfileRequest struct {
+	Name        *string                `json:"name"`
+	Description *string                `json:"description"`
+	Settings    map[string]interface{} `json:"settings"`
+	Selector    *map[string]string     `json:"selector"`
+	Priority    *int                   `json:"priority"`
+}
+
+// Update updates a configuration profile. Changing its settings creates a
+// new version, which agents apply on their next health report.
+func (m *Manager) Update(ctx context.Context, tenantID, profileID string, req *UpdateProfileRequest) (*models.ConfigProfile, error) {
+	profile, err := m.Get(ctx, tenantID, profileID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		profile.Name = strings.TrimSpace(*req.Name)
+		if err := m.checkName(ctx, profile); err != nil {
+			return nil, err
+		}
+	}
+	if req.Description != nil {
+		profile.Description = *req.Description
+	}
+	if req.Settings != nil {
+		settings, err := normalizeSettings(req.Settings)
+		if err != nil {
+			return nil, err
+		}
+		if !settingsEqual(profile.Settings, settings) {
+			profile.Settings = settings
+			profile.Version++
+		}
+	}
+	if req.Selector != nil {
+		selector, err := normalizeSelector(*req.Selector)
+		if err != nil {
+			return nil, err
+		}
+		profile.Selector = selector
+	}
+	if req.Priority != nil {
+		profile.Priority = *req.Priority
+	}
+	profile.UpdatedAt = time.Now()
+
+	if err := m.db.WithContext(ctx).Model(profile).
+		Select("name", "description", "settings", "selector", "priority", "version", "updated_at").
+		Updates(profile).Error; err != nil {
+		return nil, fmt.Errorf("failed to update configuration profile: %w", err)
+	}
+
+	m.logger.Info("configuration profile updated",
+		zap.String("profile_id", profile.ID),
+		zap.String("tenant_id", tenantID),
+		zap.Int("version", profile.Version))
+
+	return profile, nil
+}
+
+// Delete deletes a configuration profile and its direct assignments.
+// Agents keep the settings they applied.
+func (m *Manager) Delete(ctx context.Context, tenantID, profileID string) error {
+	if _, err := m.Get(ctx, tenantID, profileID); err != nil {
+		return err
+	}
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Agent{}).
+			Where("tenant_id = ? AND config_profile_id = ?", tenantID, profileID).
+			Update("config_profile_id", nil).Error; err != nil {
+			return fmt.Errorf("failed to unassign configuration profile: %w", err)
+		}
+		if err := tx.Where("id = ? AND tenant_id = ?", profileID, tenantID).
+			Delete(&models.ConfigProfile{}).Error; err != nil {
+			return fmt.Errorf("failed to delete configuration profile: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.logger.Info("configuration profile deleted",
+		zap.String("profile_id", profileID),
+		zap.String("tenant_id", tenantID))
+
+	return nil
+}
+
+// AssignAgent assigns a profile to an agent directly, overriding profiles
+// selecting it by tags. An empty profile ID removes the assignment.
+func (m *Manager) AssignAgent(ctx context.Context, tenantID, agentID, profileID string) error {
+	if _, err := m.loadAgent(ctx, tenantID, agentID); err != nil {
+		return err
+	}
+	var value interface{}
+	if profileID != "" {
+		if _, err := m.Get(ctx, tenantID, profileID); err != nil {
+			return err
+		}
+		value = profileID
+	}
+
+	if err := m.db.WithContext(ctx).Model(&models.Agent{}).
+		Where("id = ? AND tenant_id = ?", agentID, tenantID).
+		Update("config_profile_id", value).Error; err != nil {
+		return fmt.Errorf("failed to assign configuration profile: %w", err)
+	}
+
+	m.logger.Info("configuration profile assigned",
+		zap.String("agent_id", agentID),
+		zap.String("tenant_id", tenantID),
+		zap.String("profile_id", profileID))
+
+	return nil
+}
+
+// checkName rejects empty and duplicate profile names
+func (m *Manager) checkName(ctx context.Context, profile *models.ConfigProfile) error {
+	if profile.Name == "" {
+		return apperror.InvalidInput("name is required")
+	}
+	var count int64
+	if err := m.db.WithContext(ctx).Model(&models.ConfigProfile{}).
+		Where("tenant_id = ? AND name = ? AND id != ?", profile.TenantID, profile.Name, profile.ID).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check configuration profile name: %w", err)
+	}
+	if count > 0 {
+		return apperror.Conflict("a configuration profile named %q already exists", profile.Name)
+	}
+	return nil
+}
+
+// resolve chooses an agent's profile among the tenant's profiles: the one
+// assigned to it directly, otherwise the highest priority profile whose
+// selector matches its tags. profiles must be sorted as List returns them.
+func resolve(profiles []models.ConfigProfile, agent *models.Agent) (*models.ConfigProfile, string) {
+	if agent.ConfigProfileID != nil {
+		for i := range profiles {
+			if profiles[i].ID == *agent.ConfigProfileID {
+				return &profiles[i], SourceAgent
+			}
+		}
+	}
+	for i := range profiles {
+		if matchesSelector(profiles[i].Selector, agent.Tags) {
+			return &profiles[i], SourceSelector
+		}
+	}
+	return nil, ""
+}
+
+// matchesSelector reports whether tags include all of a non-empty
+// selector's tags
+func matchesSelector(selector, tags models.JSONMap) bool {
+	if len(selector) == 0 {
+		return false
+	}
+	for key, want := range selector {
+		have, ok := tags[key]
+		if !ok || fmt.Sprint(have) != fmt.Sprint(want) {
+			return false
+		}
+	}
+	return true
+}
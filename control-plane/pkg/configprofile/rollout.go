@@ -0,0 +1,384 @@
+package configprofile
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+const (
+	// maxReportError bounds the apply error stored from an agent report
+	maxReportError = 4096
+	// maxRolloutAgents bounds the lagging agents listed in a rollout summary
+	maxRolloutAgents = 100
+	// rolloutHistoryLimit is how many rollout events a summary includes
+	rolloutHistoryLimit = 50
+)
+
+// AgentReport is the configuration state an agent includes in its health
+// reports once it has handled a profile
+type AgentReport struct {
+	// ProfileID and Version are the profile the agent last applied
+	ProfileID string                   `json:"profile_id"`
+	Version   int                      `json:"version"`
+	Status    models.ConfigApplyStatus `json:"status"`
+	Error     string                   `json:"error,omitempty"`
+	// PendingRestart lists the settings saved but not yet in effect
+	PendingRestart []string `json:"pending_restart,omitempty"`
+	// Effective maps the profile-managed keys to the values the agent runs
+	// with
+	Effective map[string]interface{} `json:"effective,omitempty"`
+}
+
+// Assignment is the profile an agent should apply, sent in reply to its
+// heartbeats and health reports
+type Assignment struct {
+	ProfileID string         `json:"profile_id"`
+	Name      string         `json:"name"`
+	Version   int            `json:"version"`
+	Settings  models.JSONMap `json:"settings"`
+}
+
+// SyncAgent records the configuration state an agent reported, if any, and
+// returns the profile it should apply, or nil if none is assigned. Agents
+// without a profile keep their configuration.
+func (m *Manager) SyncAgent(ctx context.Context, tenantID, agentID string, report *AgentReport) (*Assignment, error) {
+	agent, err := m.loadAgent(ctx, tenantID, agentID)
+	if err != nil {
+		return nil, err
+	}
+	if report != nil {
+		if err := m.recordReport(ctx, agent, report); err != nil {
+			return nil, err
+		}
+	}
+
+	profiles, err := m.List(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	profile, _ := resolve(profiles, agent)
+	if profile == nil {
+		return nil, nil
+	}
+	return assignment(profile), nil
+}
+
+// recordReport stores an agent's reported configuration state and records
+// a rollout event when its outcome changed
+func (m *Manager) recordReport(ctx context.Context, agent *models.Agent, report *AgentReport) error {
+	if report.ProfileID == "" {
+		return apperror.InvalidInput("config.profile_id is required")
+	}
+	switch report.Status {
+	case models.ConfigApplyStatusApplied, models.ConfigApplyStatusPendingRestart, models.ConfigApplyStatusFailed:
+	default:
+		return apperror.InvalidInput("invalid config.status %q: must be applied, pending_restart or failed", report.Status)
+	}
+	reportError := report.Error
+	if len(reportError) > maxReportError {
+		reportError = reportError[:maxReportError]
+	}
+
+	// Only profile-managed keys are kept
+	effective := make(models.JSONMap, len(settings))
+	for key, value := range report.Effective {
+		if _, ok := settings[key]; ok {
+			effective[key] = value
+		}
+	}
+
+	now := time.Now()
+	if err := m.db.WithContext(ctx).Model(&models.Agent{}).
+		Where("id = ? AND tenant_id = ?", agent.ID, agent.TenantID).
+		Updates(map[string]interface{}{
+			"applied_profile_id":      report.ProfileID,
+			"applied_profile_version": report.Version,
+			"config_status":           report.Status,
+			"config_error":            reportError,
+			"effective_config":        effective,
+			"config_reported_at":      now,
+		}).Error; err != nil {
+		return fmt.Errorf("failed to record agent configuration: %w", err)
+	}
+
+	if agent.AppliedProfileID == report.ProfileID && agent.AppliedProfileVersion == report.Version &&
+		agent.ConfigStatus == report.Status && agent.ConfigError == reportError {
+		return nil
+	}
+
+	var pending models.JSONArray
+	for _, key := range report.PendingRestart {
+		pending = append(pending, key)
+	}
+	rollout := &models.AgentConfigRollout{
+		ID:             uuid.New().String(),
+		TenantID:       agent.TenantID,
+		AgentID:        agent.ID,
+		ProfileID:      report.ProfileID,
+		ProfileVersion: report.Version,
+		Status:         report.Status,
+		Error:          reportError,
+		PendingRestart: pending,
+		ReportedAt:     now,
+	}
+	if err := m.db.WithContext(ctx).Create(rollout).Error; err != nil {
+		return fmt.Errorf("failed to record configuration rollout: %w", err)
+	}
+
+	fields := []zap.Field{
+		zap.String("agent_id", agent.ID),
+		zap.String("tenant_id", agent.TenantID),
+		zap.String("profile_id", report.ProfileID),
+		zap.Int("version", report.Version),
+		zap.String("status", string(report.Status)),
+	}
+	if report.Status == models.ConfigApplyStatusFailed {
+		m.logger.Warn("agent failed to apply configuration profile", append(fields, zap.String("error", reportError))...)
+	} else {
+		m.logger.Info("agent applied configuration profile", fields...)
+	}
+	return nil
+}
+
+// AgentConfig is an agent's configuration profile state: the profile
+// assigned to it and what it last reported
+type AgentConfig struct {
+	AgentID string `json:"agent_id"`
+	// Profile is the profile assigned to the agent and Source how it was
+	// chosen, agent or selector
+	Profile *Assignment `json:"profile,omitempty"`
+	Source  string      `json:"source,omitempty"`
+
+	AppliedProfileID string                   `json:"applied_profile_id,omitempty"`
+	AppliedVersion   int                      `json:"applied_version,omitempty"`
+	Status           models.ConfigApplyStatus `json:"status,omitempty"`
+	Error            string                   `json:"error,omitempty"`
+	PendingRestart   models.JSONArray         `json:"pending_restart,omitempty"`
+	Effective        models.JSONMap           `json:"effective,omitempty"`
+	ReportedAt       *time.Time               `json:"reported_at,omitempty"`
+
+	// InSync is set when the agent runs the current version of its profile,
+	// or has none assigned; Drift lists the profile's settings that differ
+	// from the agent's effective values
+	InSync bool     `json:"in_sync"`
+	Drift  []string `json:"drift,omitempty"`
+}
+
+// GetAgentConfig returns an agent's assigned profile and effective
+// configuration
+func (m *Manager) GetAgentConfig(ctx context.Context, tenantID, agentID string) (*AgentConfig, error) {
+	agent, err := m.loadAgent(ctx, tenantID, agentID)
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := m.List(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	view := &AgentConfig{
+		AgentID:          agent.ID,
+		AppliedProfileID: agent.AppliedProfileID,
+		AppliedVersion:   agent.AppliedProfileVersion,
+		Status:           agent.ConfigStatus,
+		Error:            agent.ConfigError,
+		Effective:        agent.EffectiveConfig,
+		ReportedAt:       agent.ConfigReportedAt,
+		InSync:           true,
+	}
+	if agent.ConfigStatus == models.ConfigApplyStatusPendingRestart {
+		var latest models.AgentConfigRollout
+		err := m.db.WithContext(ctx).Where("tenant_id = ? AND agent_id = ?", tenantID, agentID).
+			Order("reported_at DESC").
+			First(&latest).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("failed to get configuration rollout: %w", err)
+		}
+		view.PendingRestart = latest.PendingRestart
+	}
+
+	profile, source := resolve(profiles, agent)
+	if profile == nil {
+		return view, nil
+	}
+	view.Profile = assignment(profile)
+	view.Source = source
+	for _, key := range sortedKeys(profile.Settings) {
+		value, ok := agent.EffectiveConfig[key]
+		if !ok || fmt.Sprint(value) != fmt.Sprint(profile.Settings[key]) {
+			view.Drift = append(view.Drift, key)
+		}
+	}
+	view.InSync = agent.AppliedProfileID == profile.ID && agent.AppliedProfileVersion == profile.Version &&
+		agent.ConfigStatus == models.ConfigApplyStatusApplied && len(view.Drift) == 0
+	return view, nil
+}
+
+// ListAgentRollouts returns an agent's configuration rollout history,
+// newest first
+func (m *Manager) ListAgentRollouts(ctx context.Context, tenantID, agentID string, limit int) ([]models.AgentConfigRollout, error) {
+	if _, err := m.loadAgent(ctx, tenantID, agentID); err != nil {
+		return nil, err
+	}
+	var rollouts []models.AgentConfigRollout
+	if err := m.db.WithContext(ctx).Where("tenant_id = ? AND agent_id = ?", tenantID, agentID).
+		Order("reported_at DESC").
+		Limit(limit).
+		Find(&rollouts).Error; err != nil {
+		return nil, fmt.Errorf("failed to list configuration rollouts: %w", err)
+	}
+	return rollouts, nil
+}
+
+// ProfileRollout summarizes a profile's rollout to the agents assigned it
+type ProfileRollout struct {
+	ProfileID string `json:"profile_id"`
+	Version   int    `json:"version"`
+	// Targeted agents are assigned the profile; the others count them by
+	// their outcome for the current version. Outdated agents have not yet
+	// reported it.
+	Targeted       int `json:"targeted"`
+	Applied        int `json:"applied"`
+	PendingRestart int `json:"pending_restart"`
+	Failed         int `json:"failed"`
+	Outdated       int `json:"outdated"`
+	// Lagging lists targeted agents that have not applied the current
+	// version, up to maxRolloutAgents
+	Lagging []RolloutAgent `json:"lagging"`
+	// History is the profile's most recent rollout events
+	History []models.AgentConfigRollout `json:"history"`
+}
+
+// RolloutAgent is an agent's progress in a profile rollout
+type RolloutAgent struct {
+	AgentID        string                   `json:"agent_id"`
+	Hostname       string                   `json:"hostname"`
+	Source         string                   `json:"source"`
+	AppliedVersion int                      `json:"applied_version,omitempty"`
+	Status         models.ConfigApplyStatus `json:"status,omitempty"`
+	Error          string                   `json:"error,omitempty"`
+	ReportedAt     *time.Time               `json:"reported_at,omitempty"`
+}
+
+// GetRollout summarizes which agents run the current version of a profile
+func (m *Manager) GetRollout(ctx context.Context, tenantID, profileID string) (*ProfileRollout, error) {
+	profiles, err := m.List(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	var profile *models.ConfigProfile
+	for i := range profiles {
+		if profiles[i].ID == profileID {
+			profile = &profiles[i]
+		}
+	}
+	if profile == nil {
+		return nil, apperror.NotFound("configuration profile not found")
+	}
+
+	var agents []models.Agent
+	if err := m.db.WithContext(ctx).
+		Select("id", "tenant_id", "hostname", "tags", "config_profile_id", "applied_profile_id",
+			"applied_profile_version", "config_status", "config_error", "config_reported_at").
+		Where("tenant_id = ?", tenantID).
+		Order("hostname ASC").
+		Find(&agents).Error; err != nil {
+		return nil, fmt.Errorf("failed to list agents: %w", err)
+	}
+
+	summary := &ProfileRollout{
+		ProfileID: profile.ID,
+		Version:   profile.Version,
+		Lagging:   []RolloutAgent{},
+	}
+	for i := range agents {
+		agent := &agents[i]
+		resolved, source := resolve(profiles, agent)
+		if resolved == nil || resolved.ID != profile.ID {
+			continue
+		}
+		summary.Targeted++
+
+		current := agent.AppliedProfileID == profile.ID && agent.AppliedProfileVersion == profile.Version
+		switch {
+		case !current:
+			summary.Outdated++
+		case agent.ConfigStatus == models.ConfigApplyStatusApplied:
+			summary.Applied++
+			continue
+		case agent.ConfigStatus == models.ConfigApplyStatusPendingRestart:
+			summary.PendingRestart++
+		default:
+			summary.Failed++
+		}
+		if len(summary.Lagging) < maxRolloutAgents {
+			lagging := RolloutAgent{
+				AgentID:    agent.ID,
+				Hostname:   agent.Hostname,
+				Source:     source,
+				ReportedAt: agent.ConfigReportedAt,
+			}
+			if agent.AppliedProfileID == profile.ID {
+				lagging.AppliedVersion = agent.AppliedProfileVersion
+				lagging.Status = agent.ConfigStatus
+				lagging.Error = agent.ConfigError
+			}
+			summary.Lagging = append(summary.Lagging, lagging)
+		}
+	}
+
+	if err := m.db.WithContext(ctx).Where("tenant_id = ? AND profile_id = ?", tenantID, profileID).
+		Order("reported_at DESC").
+		Limit(rolloutHistoryLimit).
+		Find(&summary.History).Error; err != nil {
+		return nil, fmt.Errorf("failed to list configuration rollouts: %w", err)
+	}
+
+	return summary, nil
+}
+
+// loadAgent loads the fields of an agent its profile is resolved and
+// reported with
+func (m *Manager) loadAgent(ctx context.Context, tenantID, agentID string) (*models.Agent, error) {
+	var agent models.Agent
+	if err := m.db.WithContext(ctx).
+		Select("id", "tenant_id", "tags", "config_profile_id", "applied_profile_id", "applied_profile_version",
+			"config_status", "config_error", "effective_config", "config_reported_at").
+		Where("id = ? AND tenant_id = ?", agentID, tenantID).
+		Take(&agent).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("agent not found")
+		}
+		return nil, fmt.Errorf("failed to get agent: %w", err)
+	}
+	return &agent, nil
+}
+
+// assignment returns the profile as sent to agents
+func assignment(profile *models.ConfigProfile) *Assignment {
+	return &Assignment{
+		ProfileID: profile.ID,
+		Name:      profile.Name,
+		Version:   profile.Version,
+		Settings:  profile.Settings,
+	}
+}
+
+// sortedKeys returns a map's keys in order
+func sortedKeys(m models.JSONMap) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,145 @@
+package configprofile
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// settingKind is the type of a profile setting's value
+type settingKind int
+
+const (
+	settingInt settingKind = iota
+	settingDuration
+	settingEnum
+)
+
+// setting describes an agent configuration key a profile can manage
+type setting struct {
+	kind settingKind
+	// min and max bound integer settings
+	min, max int64
+	// minDuration bounds duration settings
+	minDuration time.Duration
+	// values are the allowed values of enum settings
+	values []string
+}
+
+// settings are the agent configuration keys profiles manage. Connection
+// and identity settings stay with the agent's enrollment.
+var settings = map[string]setting{
+	"webhook.port":           {kind: settingInt, min: 1, max: 65535},
+	"probe.max_concurrent":   {kind: settingInt, min: 1, max: 1000},
+	"probe.default_timeout":  {kind: settingDuration, minDuration: time.Second},
+	"probe.priority_aging":   {kind: settingDuration},
+	"health.check_interval":  {kind: settingDuration, minDuration: time.Second},
+	"health.report_interval": {kind: settingDuration, minDuration: 10 * time.Second},
+	"logging.level":          {kind: settingEnum, values: []string{"debug", "info", "warn", "error"}},
+	"logging.format":         {kind: settingEnum, values: []string{"json", "console"}},
+}
+
+// SettingKeys returns the configuration keys profiles can set, sorted
+func SettingKeys() []string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// normalizeSettings validates a profile's settings and converts each value
+// to the form agents apply and report: integers, durations as Go duration
+// strings ("5m0s") and enum strings
+func normalizeSettings(values map[string]interface{}) (models.JSONMap, error) {
+	if len(values) == 0 {
+		return nil, apperror.InvalidInput("settings are required")
+	}
+	normalized := make(models.JSONMap, len(values))
+	for key, value := range values {
+		s, ok := settings[key]
+		if !ok {
+			return nil, apperror.InvalidInput("unknown setting %q: must be one of %s", key, strings.Join(SettingKeys(), ", "))
+		}
+		v, err := s.normalize(value)
+		if err != nil {
+			return nil, apperror.InvalidInput("invalid %s: %v", key, err)
+		}
+		normalized[key] = v
+	}
+	return normalized, nil
+}
+
+// normalize converts a value decoded from JSON
+func (s setting) normalize(value interface{}) (interface{}, error) {
+	switch s.kind {
+	case settingInt:
+		n, ok := value.(float64)
+		if !ok || n != math.Trunc(n) {
+			return nil, fmt.Errorf("must be an integer")
+		}
+		if int64(n) < s.min || int64(n) > s.max {
+			return nil, fmt.Errorf("must be between %d and %d", s.min, s.max)
+		}
+		return int64(n), nil
+	case settingDuration:
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be a duration such as \"30s\"")
+		}
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return nil, fmt.Errorf("must be a duration such as \"30s\"")
+		}
+		if d < s.minDuration {
+			return nil, fmt.Errorf("must be at least %s", s.minDuration)
+		}
+		return d.String(), nil
+	default:
+		str, ok := value.(string)
+		if ok {
+			for _, allowed := range s.values {
+				if str == allowed {
+					return str, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("must be one of %s", strings.Join(s.values, ", "))
+	}
+}
+
+// normalizeSelector validates a profile's tag selector
+func normalizeSelector(selector map[string]string) (models.JSONMap, error) {
+	if len(selector) == 0 {
+		return nil, nil
+	}
+	normalized := make(models.JSONMap, len(selector))
+	for key, value := range selector {
+		if strings.TrimSpace(key) == "" {
+			return nil, apperror.InvalidInput("selector tag names must not be empty")
+		}
+		normalized[key] = value
+	}
+	return normalized, nil
+}
+
+// settingsEqual compares stored settings with normalized ones. Values read
+// back from JSON are float64, so they are compared by their formatting.
+func settingsEqual(a, b models.JSONMap) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		other, ok := b[key]
+		if !ok || fmt.Sprint(value) != fmt.Sprint(other) {
+			return false
+		}
+	}
+	return true
+}
@@ -3,6 +3,8 @@ package db
 
 import (
 	"fmt"
+	"log"
+	"os"
 	"time"
 
 	"go.uber.org/zap"
@@ -11,6 +13,9 @@ import (
 	"gorm.io/gorm/logger"
 
 	"github.com/yourorg/control-plane/pkg/db/models"
+
+	// Registers the serializer for encrypted model columns
+	_ "github.com/yourorg/control-plane/pkg/encryption"
 )
 
 // Config contains database configuration
@@ -24,6 +29,8 @@ type Config struct {
 	MaxIdleConnections int
 	ConnectionLifetime time.Duration
 	LogLevel           string
+	// SlowQueryThreshold is the duration beyond which queries are logged
+	SlowQueryThreshold time.Duration
 }
 
 // Connection wraps the GORM database connection
@@ -58,8 +65,14 @@ func NewConnection(cfg *Config, zapLogger *zap.Logger) (*Connection, error) {
 		logLevel = logger.Warn
 	}
 
+	// Slow queries are logged by the query metrics plugin, and SQL is
+	// logged without its bound parameters
 	gormConfig := &gorm.Config{
-		Logger: logger.Default.LogMode(logLevel),
+		Logger: logger.New(log.New(os.Stdout, "\r\n", log.LstdFlags), logger.Config{
+			LogLevel:             logLevel,
+			Colorful:             true,
+			ParameterizedQueries: true,
+		}),
 	}
 
 	db, err := gorm.Open(mysql.Open(dsn), gormConfig)
@@ -67,6 +80,10 @@ func NewConnection(cfg *Config, zapLogger *zap.Logger) (*Connection, error) {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	if err := db.Use(NewQueryMetrics(cfg.SlowQueryThreshold, zapLogger)); err != nil {
+		return nil, fmt.Errorf("failed to register query metrics: %w", err)
+	}
+
 	// Configure connection pool
 	sqlDB, err := db.DB()
 	if err != nil {
@@ -127,6 +144,8 @@ func (c *Connection) AutoMigrate() error {
 		&models.WorkflowExecution{},
 		&models.Campaign{},
 		&models.CampaignPhase{},
+		&models.WorkflowTrigger{},
+		&models.DispatchJob{},
 	)
 }
 
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils"
+)
+
+// DefaultSlowQueryThreshold is the query duration beyond which queries are
+// logged as slow
+const DefaultSlowQueryThreshold = 500 * time.Millisecond
+
+// queryStartKey is the statement setting holding when a query started
+const queryStartKey = "query_metrics:start"
+
+var (
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "vmmanager",
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "Duration of database queries by model and operation.",
+		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"model", "operation"})
+
+	queryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vmmanager",
+		Subsystem: "db",
+		Name:      "query_errors_total",
+		Help:      "Database queries that failed, by model and operation. Record not found is not counted.",
+	}, []string{"model", "operation"})
+
+	slowQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vmmanager",
+		Subsystem: "db",
+		Name:      "slow_queries_total",
+		Help:      "Database queries slower than the slow query threshold, by model and operation.",
+	}, []string{"model", "operation"})
+)
+
+// QueryMetrics is a GORM plugin that records query durations as Prometheus
+// histograms and logs slow queries. Logged SQL keeps its placeholders; the
+// bound parameters are never logged.
+type QueryMetrics struct {
+	slowThreshold time.Duration
+	logger        *zap.Logger
+}
+
+// NewQueryMetrics creates the query metrics plugin. A non-positive
+// threshold uses DefaultSlowQueryThreshold.
+func NewQueryMetrics(slowThreshold time.Duration, logger *zap.Logger) *QueryMetrics {
+	if slowThreshold <= 0 {
+		slowThreshold = DefaultSlowQueryThreshold
+	}
+	return &QueryMetrics{
+		slowThreshold: slowThreshold,
+		logger:        logger,
+	}
+}
+
+// Name implements gorm.Plugin
+func (p *QueryMetrics) Name() string {
+	return "query_metrics"
+}
+
+// Initialize implements gorm.Plugin, timing every kind of statement
+func (p *QueryMetrics) Initialize(db *gorm.DB) error {
+	callbacks := []struct {
+		operation string
+		before    func(name string, fn func(*gorm.DB)) error
+		after     func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", db.Callback().Create().Before("gorm:create").Register, db.Callback().Create().After("gorm:create").Register},
+		{"query", db.Callback().Query().Before("gorm:query").Register, db.Callback().Query().After("gorm:query").Register},
+		{"update", db.Callback().Update().Before("gorm:update").Register, db.Callback().Update().After("gorm:update").Register},
+		{"delete", db.Callback().Delete().Before("gorm:delete").Register, db.Callback().Delete().After("gorm:delete").Register},
+		{"row", db.Callback().Row().Before("gorm:row").Register, db.Callback().Row().After("gorm:row").Register},
+		{"raw", db.Callback().Raw().Before("gorm:raw").Register, db.Callback().Raw().After("gorm:raw").Register},
+	}
+
+	for _, cb := range callbacks {
+		operation := cb.operation
+		if err := cb.before(p.Name()+":before_"+operation, p.before); err != nil {
+			return err
+		}
+		if err := cb.after(p.Name()+":after_"+operation, func(tx *gorm.DB) { p.after(tx, operation) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *QueryMetrics) before(tx *gorm.DB) {
+	tx.InstanceSet(queryStartKey, time.Now())
+}
+
+func (p *QueryMetrics) after(tx *gorm.DB, operation string) {
+	value, ok := tx.InstanceGet(queryStartKey)
+	if !ok {
+		return
+	}
+	start, ok := value.(time.Time)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(start)
+
+	model := statementModel(tx.Statement)
+	queryDuration.WithLabelValues(model, operation).Observe(elapsed.Seconds())
+	if tx.Error != nil && tx.Error != gorm.ErrRecordNotFound {
+		queryErrors.WithLabelValues(model, operation).Inc()
+	}
+
+	if elapsed < p.slowThreshold {
+		return
+	}
+	slowQueries.WithLabelValues(model, operation).Inc()
+
+	fields := []zap.Field{
+		zap.String("model", model),
+		zap.String("operation", operation),
+		zap.Duration("elapsed", elapsed),
+		zap.Duration("threshold", p.slowThreshold),
+		zap.Int64("rows", tx.Statement.RowsAffected),
+		zap.String("sql", tx.Statement.SQL.String()),
+		zap.Int("bound_params", len(tx.Statement.Vars)),
+		zap.String("caller", utils.FileWithLineNum()),
+	}
+	if tx.Error != nil {
+		fields = append(fields, zap.Error(tx.Error))
+	}
+	p.logger.Warn("slow database query", fields...)
+}
+
+// statementModel returns the model a statement is for: the model's name,
+// else its table, else "raw"
+func statementModel(stmt *gorm.Statement) string {
+	switch {
+	case stmt.Schema != nil:
+		return stmt.Schema.Name
+	case stmt.Table != "":
+		return stmt.Table
+	default:
+		return "raw"
+	}
+}
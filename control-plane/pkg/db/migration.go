@@ -83,6 +83,11 @@ func (r *MigrationRunner) Run(migrationsDir string) error {
 	return nil
 }
 
+// Available returns the migrations in a directory in version order
+func (r *MigrationRunner) Available(migrationsDir string) ([]Migration, error) {
+	return r.readMigrationFiles(migrationsDir)
+}
+
 // getAppliedMigrations returns a map of applied migration versions
 func (r *MigrationRunner) getAppliedMigrations() (map[string]bool, error) {
 	var history []migrationHistory
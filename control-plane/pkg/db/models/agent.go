@@ -2,6 +2,7 @@
 package models
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -15,20 +16,98 @@ const (
 	AgentStatusUnknown  AgentStatus = "unknown"
 )
 
+// AgentDrainState represents whether an agent accepts new executions
+type AgentDrainState string
+
+const (
+	AgentDrainNone     AgentDrainState = "none"
+	AgentDrainDraining AgentDrainState = "draining"
+	AgentDrainDrained  AgentDrainState = "drained"
+)
+
+// AgentApprovalStatus represents whether an agent has been admitted to its
+// tenant. Only approved agents receive executions.
+type AgentApprovalStatus string
+
+const (
+	AgentApprovalApproved AgentApprovalStatus = "approved"
+	AgentApprovalPending  AgentApprovalStatus = "pending"
+	AgentApprovalRejected AgentApprovalStatus = "rejected"
+)
+
 // Agent represents a registered agent
 type Agent struct {
-	ID           string       `gorm:"primaryKey;size:64" json:"id"`
-	TenantID     string       `gorm:"size:64;not null;index" json:"tenant_id"`
-	Hostname     string       `gorm:"size:255;not null" json:"hostname"`
-	OS           string       `gorm:"size:64" json:"os,omitempty"`
-	Arch         string       `gorm:"size:64" json:"arch,omitempty"`
-	Version      string       `gorm:"size:64" json:"version,omitempty"`
-	Status       AgentStatus  `gorm:"type:enum('online','offline','degraded','unknown');default:'unknown'" json:"status"`
-	Tags         JSONMap      `gorm:"type:json" json:"tags,omitempty"`
-	Metadata     JSONMap      `gorm:"type:json" json:"metadata,omitempty"`
-	LastSeenAt   *time.Time   `json:"last_seen_at,omitempty"`
-	RegisteredAt time.Time    `json:"registered_at"`
-	UpdatedAt    time.Time    `json:"updated_at"`
+	ID               string          `gorm:"primaryKey;size:64" json:"id"`
+	TenantID         string          `gorm:"size:64;not null;index" json:"tenant_id"`
+	Hostname         string          `gorm:"size:255;not null" json:"hostname"`
+	OS               string          `gorm:"size:64" json:"os,omitempty"`
+	Arch             string          `gorm:"size:64" json:"arch,omitempty"`
+	Version          string          `gorm:"size:64" json:"version,omitempty"`
+	Status           AgentStatus     `gorm:"type:enum('online','offline','degraded','unknown');default:'unknown'" json:"status"`
+	Tags             JSONMap         `gorm:"type:json" json:"tags,omitempty"`
+	Metadata         JSONMap         `gorm:"type:json" json:"metadata,omitempty"`
+	LastSeenAt       *time.Time      `json:"last_seen_at,omitempty"`
+	DrainState       AgentDrainState `gorm:"type:enum('none','draining','drained');default:'none';index" json:"drain_state"`
+	DrainRequestedAt *time.Time      `json:"drain_requested_at,omitempty"`
+	DrainedAt        *time.Time      `json:"drained_at,omitempty"`
+	RegisteredAt     time.Time       `json:"registered_at"`
+	UpdatedAt        time.Time       `json:"updated_at"`
+
+	// Identity binding: the Ed25519 key the agent enrolled with. Requests
+	// with its token must be signed by this key. IdentityResetAt is when an
+	// admin last reset the binding; a reset agent's key is only bound again
+	// by a signed enrollment.
+	PublicKey       string     `gorm:"size:64" json:"-"`
+	KeyFingerprint  string     `gorm:"size:64;index" json:"key_fingerprint,omitempty"`
+	BoundAt         *time.Time `json:"bound_at,omitempty"`
+	IdentityResetAt *time.Time `json:"identity_reset_at,omitempty"`
+
+	// Piko isolation: the endpoint the agent registered under, with a random
+	// suffix, and the key it verifies dispatch tokens with, encrypted with
+	// the tenant key. Agents registered before either was issued have none.
+	PikoEndpoint string `gorm:"size:191;index" json:"piko_endpoint,omitempty"`
+	DispatchKey  string `gorm:"type:text;serializer:encrypted" json:"-"`
+
+	// Heartbeat timing: the round trip the agent measured on its previous
+	// report and how far its clock is ahead of (positive) or behind the
+	// control plane's. ClockSkewed is set beyond agents.clock_skew_threshold.
+	LatencyMs      *int64     `json:"latency_ms,omitempty"`
+	ClockSkewMs    *int64     `json:"clock_skew_ms,omitempty"`
+	ClockSkewed    bool       `gorm:"not null;default:false;index" json:"clock_skewed"`
+	ClockCheckedAt *time.Time `json:"clock_checked_at,omitempty"`
+
+	// Grains are the host facts (fqdn, ip_addresses, ...) from the agent's
+	// latest health report, used to render template previews as that agent
+	Grains          JSONMap    `gorm:"type:json" json:"grains,omitempty"`
+	GrainsUpdatedAt *time.Time `json:"grains_updated_at,omitempty"`
+
+	// Software inventory: the hash the agent gave its package list at its
+	// latest inventory report, which delta reports build on, and how many
+	// packages that list holds
+	SoftwareHash       string     `gorm:"size:80" json:"-"`
+	SoftwarePackages   int        `gorm:"not null;default:0" json:"software_packages,omitempty"`
+	SoftwareReportedAt *time.Time `json:"software_reported_at,omitempty"`
+
+	// Registration approval: agents of tenants requiring approval register
+	// pending until approved, by an admin or an auto-approve rule.
+	// ApprovedBy and ApprovedAt record the last approval or rejection;
+	// RegistrationIP is the address the agent registered from.
+	ApprovalStatus AgentApprovalStatus `gorm:"type:enum('approved','pending','rejected');default:'approved';index" json:"approval_status"`
+	ApprovedBy     string              `gorm:"size:255" json:"approved_by,omitempty"`
+	ApprovedAt     *time.Time          `json:"approved_at,omitempty"`
+	RegistrationIP string              `gorm:"size:45" json:"registration_ip,omitempty"`
+
+	// Configuration profile: ConfigProfileID is assigned to the agent
+	// directly and takes precedence over profiles selecting it by tags. The
+	// rest is what the agent last reported: the profile version it applied,
+	// the outcome and its effective profile-managed settings.
+	ConfigProfileID       *string           `gorm:"size:64;index" json:"config_profile_id,omitempty"`
+	AppliedProfileID      string            `gorm:"size:64" json:"applied_profile_id,omitempty"`
+	AppliedProfileVersion int               `gorm:"not null;default:0" json:"applied_profile_version,omitempty"`
+	ConfigStatus          ConfigApplyStatus `gorm:"size:20" json:"config_status,omitempty"`
+	ConfigError           string            `gorm:"type:text" json:"config_error,omitempty"`
+	EffectiveConfig       JSONMap           `gorm:"type:json" json:"effective_config,omitempty"`
+	ConfigReportedAt      *time.Time        `json:"config_reported_at,omitempty"`
 
 	// Relationships
 	Tenant       Tenant          `gorm:"foreignKey:TenantID" json:"tenant,omitempty"`
@@ -42,6 +121,15 @@ func (Agent) TableName() string {
 	return "agents"
 }
 
+// Endpoint returns the agent's Piko endpoint. Agents registered before
+// endpoints were randomized use the predictable tenant-{id}/{agent} form.
+func (a *Agent) Endpoint() string {
+	if a.PikoEndpoint != "" {
+		return a.PikoEndpoint
+	}
+	return fmt.Sprintf("tenant-%s/%s", a.TenantID, a.ID)
+}
+
 // AgentToken represents a JWT token for agent authentication
 type AgentToken struct {
 	ID        string     `gorm:"primaryKey;size:64" json:"id"`
@@ -79,6 +167,25 @@ func (AgentHealthReport) TableName() string {
 	return "agent_health_reports"
 }
 
+// AgentHealthTransition records a change in an agent's overall health status
+// or in the status of one of its health components. Component is empty for
+// the overall status.
+type AgentHealthTransition struct {
+	ID         string    `gorm:"primaryKey;size:64" json:"id"`
+	AgentID    string    `gorm:"size:64;not null;index:idx_agent_health_history_agent" json:"agent_id"`
+	TenantID   string    `gorm:"size:64;not null;index:idx_agent_health_history_tenant" json:"tenant_id"`
+	Component  string    `gorm:"size:128;not null;default:'';index:idx_agent_health_history_tenant" json:"component,omitempty"`
+	FromStatus string    `gorm:"size:32;not null;default:''" json:"from_status,omitempty"`
+	ToStatus   string    `gorm:"size:32;not null" json:"to_status"`
+	Message    string    `gorm:"type:text" json:"message,omitempty"`
+	ChangedAt  time.Time `gorm:"not null;index:idx_agent_health_history_agent;index:idx_agent_health_history_tenant;index" json:"changed_at"`
+}
+
+// TableName returns the table name for AgentHealthTransition
+func (AgentHealthTransition) TableName() string {
+	return "agent_health_history"
+}
+
 // InstallationKey represents a one-time installation key
 type InstallationKey struct {
 	ID          string     `gorm:"primaryKey;size:64" json:"id"`
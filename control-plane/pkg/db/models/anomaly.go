@@ -0,0 +1,68 @@
+// Package models contains database models for the control plane.
+package models
+
+import (
+	"time"
+)
+
+// AnomalyKind is the kind of unusual activity an anomaly reports
+type AnomalyKind string
+
+const (
+	// AnomalyKindFailedAuthSpike is a spike in rejected credentials
+	AnomalyKindFailedAuthSpike AnomalyKind = "failed_auth_spike"
+	// AnomalyKindRegistrationSurge is a surge of agent registrations
+	AnomalyKindRegistrationSurge AnomalyKind = "registration_surge"
+	// AnomalyKindOddHourExecution is an actor starting executions during
+	// quiet hours, which they have not done before
+	AnomalyKindOddHourExecution AnomalyKind = "odd_hour_execution"
+)
+
+// AnomalySeverity is how far activity departs from the usual
+type AnomalySeverity string
+
+const (
+	AnomalySeverityWarning  AnomalySeverity = "warning"
+	AnomalySeverityCritical AnomalySeverity = "critical"
+)
+
+// AnomalyStatus is the state of an anomaly
+type AnomalyStatus string
+
+const (
+	AnomalyStatusOpen     AnomalyStatus = "open"
+	AnomalyStatusResolved AnomalyStatus = "resolved"
+)
+
+// Anomaly is unusual activity found in the audit log. It stays open while
+// the analysis keeps finding it and is resolved once activity is back to
+// normal.
+type Anomaly struct {
+	ID       string          `gorm:"primaryKey;size:64" json:"id"`
+	TenantID string          `gorm:"size:64;not null;index" json:"tenant_id"`
+	Kind     AnomalyKind     `gorm:"size:32;not null" json:"kind"`
+	Severity AnomalySeverity `gorm:"size:20;not null" json:"severity"`
+	Status   AnomalyStatus   `gorm:"size:20;not null;index" json:"status"`
+	// Subject is what the anomaly is about, e.g. the actor of odd-hour
+	// executions; empty for tenant-wide anomalies
+	Subject string `gorm:"size:255" json:"subject,omitempty"`
+	// OpenKey identifies the open anomaly of a tenant, kind and subject, so
+	// replicas running the analysis report it once; it is cleared on
+	// resolution
+	OpenKey *string `gorm:"size:64;uniqueIndex" json:"-"`
+
+	// Observed is the count of events in the last window and Baseline the
+	// usual count per window
+	Observed int64   `gorm:"not null" json:"observed"`
+	Baseline float64 `gorm:"not null" json:"baseline"`
+	Details  JSONMap `gorm:"type:json" json:"details,omitempty"`
+
+	DetectedAt time.Time  `gorm:"not null" json:"detected_at"`
+	LastSeenAt time.Time  `gorm:"not null" json:"last_seen_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// TableName returns the table name for Anomaly
+func (Anomaly) TableName() string {
+	return "audit_anomalies"
+}
@@ -0,0 +1,64 @@
+// Package models contains database models for the control plane.
+package models
+
+import (
+	"time"
+)
+
+// AuditChainHead is the latest link of a tenant's audit hash chain. Events
+// themselves live in the audit index; the head makes truncation detectable.
+type AuditChainHead struct {
+	TenantID  string    `gorm:"primaryKey;size:64" json:"tenant_id"`
+	Sequence  int64     `gorm:"not null" json:"sequence"`
+	LastHash  string    `gorm:"size:64;not null" json:"last_hash"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName returns the table name for AuditChainHead
+func (AuditChainHead) TableName() string {
+	return "audit_chain_heads"
+}
+
+// AuditFieldType is the type of a tenant's custom audit field
+type AuditFieldType string
+
+const (
+	AuditFieldString   AuditFieldType = "string"
+	AuditFieldInteger  AuditFieldType = "integer"
+	AuditFieldNumber   AuditFieldType = "number"
+	AuditFieldBoolean  AuditFieldType = "boolean"
+	AuditFieldDatetime AuditFieldType = "datetime"
+)
+
+// AuditFieldStatus is whether a custom audit field accepts values
+type AuditFieldStatus string
+
+const (
+	AuditFieldActive AuditFieldStatus = "active"
+	// AuditFieldRetired fields accept no new values; events already
+	// carrying them stay searchable, and the name is not reused
+	AuditFieldRetired AuditFieldStatus = "retired"
+)
+
+// AuditField is a structured field a tenant attaches to its audit events,
+// such as a change ticket ID or cost center. Values are validated against
+// the field at ingest and indexed under fields.<name>.
+type AuditField struct {
+	ID          string         `gorm:"primaryKey;size:64" json:"id"`
+	TenantID    string         `gorm:"size:64;not null;uniqueIndex:idx_audit_fields_tenant_name" json:"tenant_id"`
+	Name        string         `gorm:"size:64;not null;uniqueIndex:idx_audit_fields_tenant_name" json:"name"`
+	Type        AuditFieldType `gorm:"size:16;not null" json:"type"`
+	Description string         `gorm:"type:text" json:"description,omitempty"`
+	Required    bool           `gorm:"not null;default:false" json:"required"`
+	// AllowedValues restricts string fields to a set of values
+	AllowedValues StringArray      `gorm:"type:json" json:"allowed_values,omitempty"`
+	Status        AuditFieldStatus `gorm:"size:16;not null;default:'active'" json:"status"`
+	CreatedBy     string           `gorm:"size:255" json:"created_by,omitempty"`
+	CreatedAt     time.Time        `json:"created_at"`
+	UpdatedAt     time.Time        `json:"updated_at"`
+}
+
+// TableName returns the table name for AuditField
+func (AuditField) TableName() string {
+	return "audit_fields"
+}
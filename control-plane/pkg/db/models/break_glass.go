@@ -0,0 +1,49 @@
+// Package models contains database models for the control plane.
+package models
+
+import (
+	"time"
+)
+
+// BreakGlassStatus is the state of a break-glass session
+type BreakGlassStatus string
+
+const (
+	// BreakGlassActive lets the session's workflow bypass gates until it
+	// expires
+	BreakGlassActive BreakGlassStatus = "active"
+	// BreakGlassExpired ran out its time
+	BreakGlassExpired BreakGlassStatus = "expired"
+	// BreakGlassRevoked was ended early by a platform admin
+	BreakGlassRevoked BreakGlassStatus = "revoked"
+)
+
+// BreakGlassSession is a time-limited emergency mode a platform admin
+// enables for a tenant during an incident. While it is active, campaigns of
+// its workflow dispatch outside their windows and skip manual phase
+// approvals, and every bypass and API request of the tenant is audited.
+type BreakGlassSession struct {
+	ID         string `gorm:"primaryKey;size:64" json:"id"`
+	TenantID   string `gorm:"size:64;not null;index:idx_break_glass_sessions_tenant" json:"tenant_id"`
+	WorkflowID string `gorm:"size:64;not null;index:idx_break_glass_sessions_tenant" json:"workflow_id"`
+	// Incident is the incident reference, such as a ticket number
+	Incident  string           `gorm:"size:255;not null" json:"incident"`
+	Reason    string           `gorm:"type:text;not null" json:"reason"`
+	Status    BreakGlassStatus `gorm:"size:16;not null;default:'active';index:idx_break_glass_sessions_status" json:"status"`
+	EnabledBy string           `gorm:"size:255;not null" json:"enabled_by"`
+	ExpiresAt time.Time        `gorm:"not null;index:idx_break_glass_sessions_status" json:"expires_at"`
+	RevokedBy string           `gorm:"size:255" json:"revoked_by,omitempty"`
+	EndedAt   *time.Time       `json:"ended_at,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+// TableName returns the table name for BreakGlassSession
+func (BreakGlassSession) TableName() string {
+	return "break_glass_sessions"
+}
+
+// ActiveAt reports whether the session lets its workflow bypass gates at t
+func (s *BreakGlassSession) ActiveAt(t time.Time) bool {
+	return s.Status == BreakGlassActive && t.Before(s.ExpiresAt)
+}
@@ -0,0 +1,33 @@
+// Package models contains database models for the control plane.
+package models
+
+import (
+	"time"
+)
+
+// BudgetMetric is a tenant's monthly execution budget
+type BudgetMetric string
+
+const (
+	BudgetMetricExecutions BudgetMetric = "executions"
+	BudgetMetricMinutes    BudgetMetric = "minutes"
+)
+
+// BudgetAlert records that a tenant's usage crossed a threshold of a
+// monthly budget, so the alert is raised once per month
+type BudgetAlert struct {
+	ID       string       `gorm:"primaryKey;size:64" json:"id"`
+	TenantID string       `gorm:"size:64;not null" json:"tenant_id"`
+	Month    string       `gorm:"size:7;not null" json:"month"`
+	Metric   BudgetMetric `gorm:"size:20;not null" json:"metric"`
+	// Threshold is the percentage of the budget crossed
+	Threshold int       `gorm:"not null" json:"threshold"`
+	Used      float64   `gorm:"not null" json:"used"`
+	Quota     int       `gorm:"not null" json:"quota"`
+	CreatedAt time.Time `gorm:"not null" json:"created_at"`
+}
+
+// TableName returns the table name for BudgetAlert
+func (BudgetAlert) TableName() string {
+	return "tenant_budget_alerts"
+}
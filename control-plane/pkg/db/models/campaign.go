@@ -18,6 +18,28 @@ const (
 	CampaignStatusRollingBack CampaignStatus = "rolling_back"
 )
 
+// CampaignType is what a campaign rolls out
+type CampaignType string
+
+const (
+	// CampaignTypeWorkflow runs an existing workflow on each target agent
+	CampaignTypeWorkflow CampaignType = "workflow"
+	// CampaignTypeTemplateDeploy renders and deploys a template on each
+	// target agent through a workflow generated for the campaign
+	CampaignTypeTemplateDeploy CampaignType = "template_deploy"
+)
+
+// CampaignMode is whether a campaign changes its targets
+type CampaignMode string
+
+const (
+	// CampaignModeLive rolls the workflow out
+	CampaignModeLive CampaignMode = "live"
+	// CampaignModeDarkLaunch runs every phase with validate executions and
+	// collects a readiness report instead of changing the targets
+	CampaignModeDarkLaunch CampaignMode = "dark_launch"
+)
+
 // Campaign represents a phased workflow rollout campaign
 type Campaign struct {
 	ID             string         `gorm:"primaryKey;size:64" json:"id"`
@@ -35,6 +57,28 @@ type Campaign struct {
 	CreatedAt      time.Time      `json:"created_at"`
 	UpdatedAt      time.Time      `json:"updated_at"`
 
+	// Type is workflow (the default) or template_deploy; Deployment holds
+	// the template, version and destination of a template_deploy campaign
+	Type       CampaignType `gorm:"column:campaign_type;size:32;not null;default:'workflow'" json:"type"`
+	Deployment JSONMap      `gorm:"type:json" json:"deployment,omitempty"`
+
+	// Mode is live (the default) or dark_launch
+	Mode CampaignMode `gorm:"column:campaign_mode;size:32;not null;default:'live'" json:"mode"`
+
+	// ScheduledAt starts a draft campaign once it passes; Window restricts
+	// when phases start and executions are dispatched
+	ScheduledAt *time.Time `json:"scheduled_at,omitempty"`
+	Window      JSONMap    `gorm:"column:rollout_window;type:json" json:"window,omitempty"`
+
+	// DefinitionHash pins the workflow definition when the campaign first
+	// starts; every execution dispatches that snapshot even if the workflow
+	// is edited while the campaign runs
+	DefinitionHash string `gorm:"size:64" json:"definition_hash,omitempty"`
+
+	// Zones runs the phases one zone of agents, grouped by a tag, at a
+	// time, with per-zone success thresholds
+	Zones JSONMap `gorm:"column:zone_config;type:json" json:"zones,omitempty"`
+
 	// Relationships
 	Tenant     Tenant              `gorm:"foreignKey:TenantID" json:"tenant,omitempty"`
 	Workflow   Workflow            `gorm:"foreignKey:WorkflowID" json:"workflow,omitempty"`
@@ -47,6 +91,14 @@ func (Campaign) TableName() string {
 	return "campaigns"
 }
 
+// ExecutionMode returns the mode the campaign's executions run in
+func (c *Campaign) ExecutionMode() ExecutionMode {
+	if c.Mode == CampaignModeDarkLaunch {
+		return ExecutionModeValidate
+	}
+	return ExecutionModeLive
+}
+
 // PhaseStatus represents the status of a campaign phase
 type PhaseStatus string
 
@@ -56,6 +108,9 @@ const (
 	PhaseStatusSuccess   PhaseStatus = "success"
 	PhaseStatusFailed    PhaseStatus = "failed"
 	PhaseStatusCancelled PhaseStatus = "cancelled"
+	// PhaseStatusAwaitingApproval is a successful phase with manual_approval
+	// set; the campaign does not continue until the phase is approved
+	PhaseStatusAwaitingApproval PhaseStatus = "awaiting_approval"
 )
 
 // CampaignPhase represents a phase in a campaign
@@ -67,10 +122,22 @@ type CampaignPhase struct {
 	TargetCount  int         `gorm:"default:0" json:"target_count"`
 	SuccessCount int         `gorm:"default:0" json:"success_count"`
 	FailureCount int         `gorm:"default:0" json:"failure_count"`
-	Status       PhaseStatus `gorm:"type:enum('pending','running','success','failed','cancelled');default:'pending'" json:"status"`
+	Status       PhaseStatus `gorm:"type:enum('pending','running','success','failed','cancelled','awaiting_approval');default:'pending'" json:"status"`
 	StartedAt    *time.Time  `json:"started_at,omitempty"`
 	CompletedAt  *time.Time  `json:"completed_at,omitempty"`
 
+	// ConfigIndex is the phase's entry in the campaign's phase config, and
+	// Zone the zone it runs in; a zoned campaign runs its phases once per
+	// zone
+	ConfigIndex int    `gorm:"not null;default:0" json:"config_index"`
+	Zone        string `gorm:"size:255;not null;default:''" json:"zone,omitempty"`
+
+	// Manual gate: the next phase waits for sign-off after this phase succeeds
+	ManualApproval bool       `gorm:"default:false" json:"manual_approval"`
+	ApprovedBy     string     `gorm:"size:255" json:"approved_by,omitempty"`
+	ApprovedAt     *time.Time `json:"approved_at,omitempty"`
+	ApprovalNote   string     `gorm:"type:text" json:"approval_note,omitempty"`
+
 	Campaign Campaign `gorm:"foreignKey:CampaignID" json:"campaign,omitempty"`
 }
 
@@ -98,20 +165,96 @@ func (p *CampaignPhase) IsComplete() bool {
 	}
 }
 
+// CampaignProgressSnapshot records a phase's execution counts at a point in
+// time. Snapshots are taken once per interval while a campaign is active.
+type CampaignProgressSnapshot struct {
+	ID            string      `gorm:"primaryKey;size:64" json:"id"`
+	CampaignID    string      `gorm:"size:64;not null;index" json:"campaign_id"`
+	PhaseName     string      `gorm:"size:64;not null" json:"phase_name"`
+	PhaseOrder    int         `gorm:"not null" json:"phase_order"`
+	PhaseStatus   PhaseStatus `gorm:"size:20;not null" json:"phase_status"`
+	TargetCount   int         `gorm:"default:0" json:"target_count"`
+	SuccessCount  int         `gorm:"default:0" json:"success_count"`
+	FailureCount  int         `gorm:"default:0" json:"failure_count"`
+	InFlightCount int         `gorm:"default:0" json:"in_flight_count"`
+	RecordedAt    time.Time   `gorm:"not null;index" json:"recorded_at"`
+}
+
+// TableName returns the table name for CampaignProgressSnapshot
+func (CampaignProgressSnapshot) TableName() string {
+	return "campaign_progress_snapshots"
+}
+
 // PhaseConfig represents the configuration for a campaign phase
 type PhaseConfig struct {
 	Name             string  `json:"name"`
 	Percentage       float64 `json:"percentage"`
 	SuccessThreshold float64 `json:"success_threshold"`
 	WaitMinutes      int     `json:"wait_minutes"`
+	// Agents and Tags pin agents to the phase ahead of its percentage
+	Agents []string          `json:"agents,omitempty"`
+	Tags   map[string]string `json:"tags,omitempty"`
 }
 
 // CampaignProgress represents the progress of a campaign
 type CampaignProgress struct {
 	CurrentPhase     string  `json:"current_phase"`
+	AwaitingApproval bool    `json:"awaiting_approval"`
 	TotalAgents      int     `json:"total_agents"`
 	CompletedAgents  int     `json:"completed_agents"`
 	SuccessfulAgents int     `json:"successful_agents"`
 	FailedAgents     int     `json:"failed_agents"`
 	SuccessRate      float64 `json:"success_rate"`
+
+	// Deployment summarises per-agent file changes of a template_deploy
+	// campaign
+	Deployment *TemplateDeployProgress `json:"deployment,omitempty"`
+
+	// Zones is the progress of each zone of a zoned campaign, in order
+	Zones []ZoneProgress `json:"zones,omitempty"`
+}
+
+// ZoneStatus is the state of a zone of a zoned campaign
+type ZoneStatus string
+
+const (
+	ZoneStatusPending   ZoneStatus = "pending"
+	ZoneStatusRunning   ZoneStatus = "running"
+	ZoneStatusCompleted ZoneStatus = "completed"
+	ZoneStatusFailed    ZoneStatus = "failed"
+	ZoneStatusExcluded  ZoneStatus = "excluded"
+)
+
+// ZoneProgress is the progress of one zone of a zoned campaign
+type ZoneProgress struct {
+	Zone             string     `json:"zone"`
+	Status           ZoneStatus `json:"status"`
+	SuccessfulAgents int        `json:"successful_agents"`
+	FailedAgents     int        `json:"failed_agents"`
+	SuccessRate      float64    `json:"success_rate"`
+	Threshold        float64    `json:"threshold"`
+}
+
+// TemplateDeployProgress counts the outcome of a template deployment on the
+// agents it has finished on
+type TemplateDeployProgress struct {
+	Created   int                  `json:"created"`
+	Updated   int                  `json:"updated"`
+	Unchanged int                  `json:"unchanged"`
+	Drifted   int                  `json:"drifted"`
+	Failed    int                  `json:"failed"`
+	Agents    []AgentDeploySummary `json:"agents"`
+}
+
+// AgentDeploySummary is the diff summary of a template deployment on one
+// agent
+type AgentDeploySummary struct {
+	AgentID      string          `json:"agent_id"`
+	ExecutionID  string          `json:"execution_id"`
+	Status       ExecutionStatus `json:"status"`
+	FileStatus   string          `json:"file_status,omitempty"` // created, updated, unchanged or error
+	LinesAdded   int             `json:"lines_added"`
+	LinesRemoved int             `json:"lines_removed"`
+	Drifted      bool            `json:"drifted,omitempty"` // The file no longer matched after the deployment
+	Error        string          `json:"error,omitempty"`
 }
@@ -0,0 +1,72 @@
+package models
+
+import "time"
+
+// CatalogKind is what a catalog entry publishes
+type CatalogKind string
+
+const (
+	CatalogKindWorkflow CatalogKind = "workflow"
+	CatalogKindTemplate CatalogKind = "template"
+)
+
+// CatalogEntryStatus is the publication state of a catalog entry
+type CatalogEntryStatus string
+
+const (
+	CatalogEntryPublished CatalogEntryStatus = "published"
+	CatalogEntryWithdrawn CatalogEntryStatus = "withdrawn"
+)
+
+// CatalogEntry is a workflow or template of the platform tenant published
+// to every tenant. It holds a snapshot of the source as published: later
+// edits to the source reach tenants only when it is published again.
+// Version is the source's version at the last publication.
+type CatalogEntry struct {
+	ID             string             `gorm:"primaryKey;size:64" json:"id"`
+	Kind           CatalogKind        `gorm:"size:16;not null" json:"kind"`
+	SourceTenantID string             `gorm:"size:64;not null" json:"source_tenant_id"`
+	SourceID       string             `gorm:"size:64;not null" json:"source_id"`
+	Name           string             `gorm:"size:255;not null" json:"name"`
+	Description    string             `gorm:"type:text" json:"description,omitempty"`
+	Version        int                `gorm:"not null" json:"version"`
+	Status         CatalogEntryStatus `gorm:"size:16;not null" json:"status"`
+	Tags           JSONMap            `gorm:"type:json" json:"tags,omitempty"`
+
+	// Definition is the published workflow definition; Content and
+	// ContentType the published template
+	Definition  JSONMap `gorm:"type:json" json:"definition,omitempty"`
+	Content     string  `gorm:"type:longtext;serializer:encrypted" json:"content,omitempty"`
+	ContentType string  `gorm:"size:100" json:"content_type,omitempty"`
+
+	ReleaseNote string    `gorm:"type:text" json:"release_note,omitempty"`
+	PublishedBy string    `gorm:"size:255" json:"published_by,omitempty"`
+	PublishedAt time.Time `json:"published_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// TableName returns the table name for CatalogEntry
+func (CatalogEntry) TableName() string {
+	return "catalog_entries"
+}
+
+// CatalogCopy is a tenant's copy of a catalog entry. Version is the entry
+// version the copy was made or last upgraded from; the copy is behind when
+// the entry has been published at a later version.
+type CatalogCopy struct {
+	ID        string      `gorm:"primaryKey;size:64" json:"id"`
+	TenantID  string      `gorm:"size:64;not null;index" json:"tenant_id"`
+	EntryID   string      `gorm:"size:64;not null;index" json:"entry_id"`
+	Kind      CatalogKind `gorm:"size:16;not null" json:"kind"`
+	TargetID  string      `gorm:"size:64;not null" json:"target_id"`
+	Version   int         `gorm:"not null" json:"version"`
+	CreatedBy string      `gorm:"size:255" json:"created_by,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+// TableName returns the table name for CatalogCopy
+func (CatalogCopy) TableName() string {
+	return "catalog_copies"
+}
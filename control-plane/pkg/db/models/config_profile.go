@@ -0,0 +1,72 @@
+// Package models contains database models for the control plane.
+package models
+
+import (
+	"time"
+)
+
+// ConfigApplyStatus is the outcome of an agent applying a configuration
+// profile
+type ConfigApplyStatus string
+
+const (
+	// ConfigApplyStatusApplied means the agent runs with the profile
+	ConfigApplyStatusApplied ConfigApplyStatus = "applied"
+	// ConfigApplyStatusPendingRestart means the profile was saved to the
+	// agent's configuration but some settings take effect on restart
+	ConfigApplyStatusPendingRestart ConfigApplyStatus = "pending_restart"
+	// ConfigApplyStatusFailed means the agent rejected the profile and kept
+	// its configuration
+	ConfigApplyStatusFailed ConfigApplyStatus = "failed"
+)
+
+// ConfigProfile is a set of agent settings managed centrally. A profile is
+// assigned to agents directly or to the group of agents its selector
+// matches; agents pull it with their health reports.
+type ConfigProfile struct {
+	ID          string `gorm:"primaryKey;size:64" json:"id"`
+	TenantID    string `gorm:"size:64;not null;index" json:"tenant_id"`
+	Name        string `gorm:"size:255;not null" json:"name"`
+	Description string `gorm:"type:text" json:"description,omitempty"`
+	// Settings maps agent configuration keys (webhook.port,
+	// health.report_interval, ...) to their values
+	Settings JSONMap `gorm:"type:json;not null" json:"settings"`
+	// Selector assigns the profile to the agents having all of its tags;
+	// among matching profiles the highest priority wins
+	Selector JSONMap `gorm:"type:json" json:"selector,omitempty"`
+	Priority int     `gorm:"not null;default:0" json:"priority"`
+	// Version is incremented each time the settings change
+	Version int `gorm:"not null;default:1" json:"version"`
+
+	CreatedBy string    `gorm:"size:255" json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Relationships
+	Tenant Tenant `gorm:"foreignKey:TenantID" json:"tenant,omitempty"`
+}
+
+// TableName returns the table name for ConfigProfile
+func (ConfigProfile) TableName() string {
+	return "config_profiles"
+}
+
+// AgentConfigRollout records an agent reporting a new outcome for a
+// configuration profile version
+type AgentConfigRollout struct {
+	ID             string            `gorm:"primaryKey;size:64" json:"id"`
+	TenantID       string            `gorm:"size:64;not null;index" json:"tenant_id"`
+	AgentID        string            `gorm:"size:64;not null;index:idx_agent_config_rollouts_agent" json:"agent_id"`
+	ProfileID      string            `gorm:"size:64;not null;index:idx_agent_config_rollouts_profile" json:"profile_id"`
+	ProfileVersion int               `gorm:"not null" json:"profile_version"`
+	Status         ConfigApplyStatus `gorm:"size:20;not null" json:"status"`
+	Error          string            `gorm:"type:text" json:"error,omitempty"`
+	// PendingRestart lists the settings waiting for an agent restart
+	PendingRestart JSONArray `gorm:"type:json" json:"pending_restart,omitempty"`
+	ReportedAt     time.Time `gorm:"not null;index:idx_agent_config_rollouts_agent;index:idx_agent_config_rollouts_profile" json:"reported_at"`
+}
+
+// TableName returns the table name for AgentConfigRollout
+func (AgentConfigRollout) TableName() string {
+	return "agent_config_rollouts"
+}
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// DispatchJobStatus represents the delivery state of a dispatch job
+type DispatchJobStatus string
+
+const (
+	// DispatchJobPending is waiting for its next delivery attempt
+	DispatchJobPending DispatchJobStatus = "pending"
+	// DispatchJobInProgress is claimed by a worker until LockedUntil
+	DispatchJobInProgress DispatchJobStatus = "in_progress"
+	// DispatchJobDelivered was accepted by the agent
+	DispatchJobDelivered DispatchJobStatus = "delivered"
+	// DispatchJobCancelled was dropped because its execution was cancelled
+	DispatchJobCancelled DispatchJobStatus = "cancelled"
+	// DispatchJobDead exhausted its attempts or was rejected by the agent
+	DispatchJobDead DispatchJobStatus = "dead"
+)
+
+// DispatchJob is an execution waiting in the outbox to be delivered to its
+// agent. Jobs are written with the execution and delivered at least once by
+// the dispatch queue workers of any control plane replica.
+type DispatchJob struct {
+	ID          string            `gorm:"primaryKey;size:64" json:"id"`
+	TenantID    string            `gorm:"size:64;not null;index" json:"tenant_id"`
+	ExecutionID string            `gorm:"size:64;not null;uniqueIndex" json:"execution_id"`
+	AgentID     string            `gorm:"size:64;not null;index" json:"agent_id"`
+	Priority    string            `gorm:"size:16" json:"priority,omitempty"`
+	Payload     JSONMap           `gorm:"type:json;not null" json:"-"`
+	Status      DispatchJobStatus `gorm:"type:enum('pending','in_progress','delivered','cancelled','dead');default:'pending';index:idx_dispatch_jobs_due,priority:1" json:"status"`
+	Attempts    int               `gorm:"not null;default:0" json:"attempts"`
+	// NextAttemptAt is when a pending job is next due, or when an in-progress
+	// job's claim expires and another worker may retry it
+	NextAttemptAt time.Time  `gorm:"not null;index:idx_dispatch_jobs_due,priority:2" json:"next_attempt_at"`
+	LockedBy      string     `gorm:"size:64" json:"locked_by,omitempty"`
+	FailureClass  string     `gorm:"size:32" json:"failure_class,omitempty"`
+	LastError     string     `gorm:"type:text" json:"last_error,omitempty"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// TableName returns the table name for DispatchJob
+func (DispatchJob) TableName() string {
+	return "dispatch_jobs"
+}
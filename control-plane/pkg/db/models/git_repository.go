@@ -0,0 +1,68 @@
+// Package models contains database models for the control plane.
+package models
+
+import (
+	"time"
+)
+
+// GitSyncStatus is the outcome of a Git repository's last sync
+type GitSyncStatus string
+
+const (
+	// GitSyncStatusSuccess means every resource file was applied
+	GitSyncStatusSuccess GitSyncStatus = "success"
+	// GitSyncStatusPartial means the repository was read but some files
+	// were rejected; the rest were applied
+	GitSyncStatusPartial GitSyncStatus = "partial"
+	// GitSyncStatusFailed means the repository could not be fetched
+	GitSyncStatusFailed GitSyncStatus = "failed"
+)
+
+// GitRepository is a Git repository a tenant's workflows and templates are
+// synced from. Resources synced from it can only be changed in the
+// repository.
+type GitRepository struct {
+	ID       string `gorm:"primaryKey;size:64" json:"id"`
+	TenantID string `gorm:"size:64;not null;index" json:"tenant_id"`
+	Name     string `gorm:"size:255;not null" json:"name"`
+	URL      string `gorm:"size:1024;not null" json:"url"`
+	Branch   string `gorm:"size:255;not null" json:"branch"`
+	// Path is the directory resource files are read from, relative to the
+	// repository root
+	Path string `gorm:"size:512" json:"path,omitempty"`
+	// DeployKey is the SSH private key the repository is cloned with;
+	// KnownHosts pins the server's host keys
+	DeployKey            string `gorm:"type:text;serializer:encrypted" json:"-"`
+	DeployKeyFingerprint string `gorm:"size:128" json:"deploy_key_fingerprint,omitempty"`
+	KnownHosts           string `gorm:"type:text" json:"known_hosts,omitempty"`
+	Enabled              bool   `gorm:"not null" json:"enabled"`
+
+	LastSyncAt *time.Time    `json:"last_sync_at,omitempty"`
+	LastCommit string        `gorm:"size:64" json:"last_commit,omitempty"`
+	LastStatus GitSyncStatus `gorm:"size:20" json:"last_status,omitempty"`
+	LastError  string        `gorm:"type:text" json:"last_error,omitempty"`
+	LastResult JSONMap       `gorm:"type:json" json:"last_result,omitempty"`
+	// SyncStartedAt is set while a replica is syncing the repository
+	SyncStartedAt *time.Time `json:"-"`
+
+	CreatedBy string    `gorm:"size:255" json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Relationships
+	Tenant Tenant `gorm:"foreignKey:TenantID" json:"tenant,omitempty"`
+}
+
+// TableName returns the table name for GitRepository
+func (GitRepository) TableName() string {
+	return "git_repositories"
+}
+
+// GitSyncAction is what a sync did to one resource
+type GitSyncAction string
+
+const (
+	GitSyncActionCreated   GitSyncAction = "created"
+	GitSyncActionUpdated   GitSyncAction = "updated"
+	GitSyncActionUnchanged GitSyncAction = "unchanged"
+)
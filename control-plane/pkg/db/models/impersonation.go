@@ -0,0 +1,37 @@
+// Package models contains database models for the control plane.
+package models
+
+import (
+	"time"
+)
+
+// ImpersonationSession is a platform admin operating as a tenant with a
+// short-lived tenant-scoped token. The token names the session, so ending
+// the session revokes it.
+type ImpersonationSession struct {
+	ID string `gorm:"primaryKey;size:64" json:"id"`
+	// TenantID is the impersonated tenant
+	TenantID string `gorm:"size:64;not null;index" json:"tenant_id"`
+	// AdminID and AdminTenantID identify the admin impersonating it
+	AdminID       string      `gorm:"size:255;not null;index" json:"admin_id"`
+	AdminTenantID string      `gorm:"size:64" json:"admin_tenant_id,omitempty"`
+	Reason        string      `gorm:"type:text;not null" json:"reason"`
+	Scopes        StringArray `gorm:"type:json" json:"scopes,omitempty"`
+	// RemoteAddr is the admin's client address
+	RemoteAddr string `gorm:"size:255" json:"remote_addr,omitempty"`
+
+	ExpiresAt time.Time  `gorm:"not null;index" json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	EndedBy   string     `gorm:"size:255" json:"ended_by,omitempty"`
+}
+
+// TableName returns the table name for ImpersonationSession
+func (ImpersonationSession) TableName() string {
+	return "impersonation_sessions"
+}
+
+// Active reports whether the session's token is still accepted at t
+func (s *ImpersonationSession) Active(t time.Time) bool {
+	return s.EndedAt == nil && t.Before(s.ExpiresAt)
+}
@@ -0,0 +1,82 @@
+// Package models contains database models for the control plane.
+package models
+
+import (
+	"time"
+)
+
+// InboundTrigger lets an external system, such as CI or monitoring, start
+// a workflow on the agents its selector matches by posting to the trigger.
+// Vars for the execution are taken from the posted payload.
+type InboundTrigger struct {
+	ID          string `gorm:"primaryKey;size:64" json:"id"`
+	TenantID    string `gorm:"size:64;not null;index" json:"tenant_id"`
+	Name        string `gorm:"size:255;not null" json:"name"`
+	Description string `gorm:"type:text" json:"description,omitempty"`
+	WorkflowID  string `gorm:"size:64;not null;index" json:"workflow_id"`
+	// TargetSelector picks the agents by tags and agent_ids, like a
+	// campaign's
+	TargetSelector JSONMap `gorm:"type:json;not null" json:"target_selector"`
+	// Parameters maps workflow vars to the payload paths they are read from
+	Parameters JSONMap `gorm:"type:json" json:"parameters,omitempty"`
+	// Secret signs invocations; it is only returned when created or rotated
+	Secret string `gorm:"type:text;not null;serializer:encrypted" json:"-"`
+	// MaxAgents rejects invocations whose selector matches more agents
+	MaxAgents int `gorm:"not null;default:10" json:"max_agents"`
+	// MaxInvocationsPerHour caps the invocations accepted in an hour; 0 is
+	// unlimited
+	MaxInvocationsPerHour int        `gorm:"not null;default:60" json:"max_invocations_per_hour"`
+	Enabled               bool       `gorm:"not null;default:true" json:"enabled"`
+	LastInvokedAt         *time.Time `json:"last_invoked_at,omitempty"`
+	CreatedBy             string     `gorm:"size:255" json:"created_by,omitempty"`
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+}
+
+// TableName returns the table name for InboundTrigger
+func (InboundTrigger) TableName() string {
+	return "inbound_triggers"
+}
+
+// InvocationStatus is what became of an inbound trigger invocation
+type InvocationStatus string
+
+const (
+	// InvocationAccepted started an execution on every selected agent
+	InvocationAccepted InvocationStatus = "accepted"
+	// InvocationPartial started executions on some of the selected agents
+	InvocationPartial InvocationStatus = "partial"
+	// InvocationFailed started no execution
+	InvocationFailed InvocationStatus = "failed"
+	// InvocationRejected failed verification or had an unusable payload
+	InvocationRejected InvocationStatus = "rejected"
+	// InvocationRateLimited came over the trigger's hourly cap
+	InvocationRateLimited InvocationStatus = "rate_limited"
+)
+
+// InboundTriggerInvocation records a request received by an inbound
+// trigger, with where it came from and the executions it started
+type InboundTriggerInvocation struct {
+	ID        string           `gorm:"primaryKey;size:64" json:"id"`
+	TenantID  string           `gorm:"size:64;not null;index" json:"tenant_id"`
+	TriggerID string           `gorm:"size:64;not null;index:idx_inbound_trigger_invocations_trigger;index:idx_inbound_trigger_invocations_digest" json:"trigger_id"`
+	Status    InvocationStatus `gorm:"size:32;not null" json:"status"`
+	// Message is why the invocation was rejected or what failed
+	Message   string `gorm:"type:text" json:"message,omitempty"`
+	SourceIP  string `gorm:"size:64" json:"source_ip,omitempty"`
+	UserAgent string `gorm:"size:512" json:"user_agent,omitempty"`
+	RequestID string `gorm:"size:128" json:"request_id,omitempty"`
+	// SignatureDigest identifies a signed invocation by its timestamp and
+	// signature, so a replay of it is rejected; empty for token invocations
+	SignatureDigest string `gorm:"size:64;index:idx_inbound_trigger_invocations_digest" json:"-"`
+	// Vars are the workflow vars read from the payload
+	Vars         JSONMap     `gorm:"type:json" json:"vars,omitempty"`
+	AgentCount   int         `gorm:"not null;default:0" json:"agent_count"`
+	ExecutionIDs StringArray `gorm:"type:json" json:"execution_ids,omitempty"`
+	ReceivedAt   time.Time   `gorm:"not null;index:idx_inbound_trigger_invocations_trigger" json:"received_at"`
+}
+
+// TableName returns the table name for InboundTriggerInvocation
+func (InboundTriggerInvocation) TableName() string {
+	return "inbound_trigger_invocations"
+}
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// WorkflowPolicy holds a tenant's workflow defaults and ceilings. Defaults
+// are filled into steps that leave a setting unset; ceilings reject
+// workflows that exceed them unless a tenant admin overrides the policy.
+// Empty fields are not enforced.
+type WorkflowPolicy struct {
+	// MaxWorkflowTimeout and MaxStepTimeout cap the timeouts authors may set
+	MaxWorkflowTimeout string `json:"max_workflow_timeout,omitempty"`
+	MaxStepTimeout     string `json:"max_step_timeout,omitempty"`
+	// MaxRetryCount caps each step's retry_count
+	MaxRetryCount *int `json:"max_retry_count,omitempty"`
+	// AllowedStepTypes and AllowedInterpreters restrict the step types and
+	// script interpreters (sh, bash, python3, pwsh, cmd) workflows may use
+	AllowedStepTypes    []string `json:"allowed_step_types,omitempty"`
+	AllowedInterpreters []string `json:"allowed_interpreters,omitempty"`
+
+	// DefaultStepTimeout is set on steps without a timeout; MaxStepTimeout
+	// is used when only the ceiling is configured
+	DefaultStepTimeout string `json:"default_step_timeout,omitempty"`
+	// DefaultTemplateBackup is set on template steps that do not say
+	// whether to back up the file they replace
+	DefaultTemplateBackup *bool `json:"default_template_backup,omitempty"`
+
+	// AllowedPlugins maps the plugins plugin steps may run to the SHA-256 of
+	// their executable. It is sent with each dispatch and agents refuse
+	// plugins that are missing from it or do not match their checksum.
+	AllowedPlugins map[string]string `json:"allowed_plugins,omitempty"`
+}
+
+// IsEmpty reports whether the policy sets nothing
+func (p *WorkflowPolicy) IsEmpty() bool {
+	return p == nil || (p.MaxWorkflowTimeout == "" && p.MaxStepTimeout == "" && p.MaxRetryCount == nil &&
+		len(p.AllowedStepTypes) == 0 && len(p.AllowedInterpreters) == 0 &&
+		p.DefaultStepTimeout == "" && p.DefaultTemplateBackup == nil && len(p.AllowedPlugins) == 0)
+}
+
+// Value implements the driver.Valuer interface
+func (p WorkflowPolicy) Value() (driver.Value, error) {
+	return json.Marshal(p)
+}
+
+// Scan implements the sql.Scanner interface
+func (p *WorkflowPolicy) Scan(value interface{}) error {
+	if value == nil {
+		*p = WorkflowPolicy{}
+		return nil
+	}
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported workflow policy column type %T", value)
+	}
+	return json.Unmarshal(data, p)
+}
+
+// RegistrationPolicy is how a tenant admits newly registered agents
+type RegistrationPolicy struct {
+	// RequireApproval registers new agents pending until approved
+	RequireApproval bool `json:"require_approval"`
+	// AutoApprove rules approve matching agents on registration
+	AutoApprove []AutoApproveRule `json:"auto_approve,omitempty"`
+}
+
+// AutoApproveRule approves agents registering with all of its tags from
+// one of its subnets. A rule without subnets matches any address and one
+// without tags any agent from its subnets.
+type AutoApproveRule struct {
+	Name    string            `json:"name"`
+	Tags    map[string]string `json:"tags,omitempty"`
+	Subnets []string          `json:"subnets,omitempty"`
+}
+
+// Value implements the driver.Valuer interface
+func (p RegistrationPolicy) Value() (driver.Value, error) {
+	return json.Marshal(p)
+}
+
+// Scan implements the sql.Scanner interface
+func (p *RegistrationPolicy) Scan(value interface{}) error {
+	if value == nil {
+		*p = RegistrationPolicy{}
+		return nil
+	}
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported registration policy column type %T", value)
+	}
+	return json.Unmarshal(data, p)
+}
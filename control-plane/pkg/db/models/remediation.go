@@ -0,0 +1,61 @@
+// Package models contains database models for the control plane.
+package models
+
+import (
+	"time"
+)
+
+// RemediationRule runs a workflow on an agent whose health matches a
+// condition: Component (empty for the overall status) reported as Status.
+// Runs on an agent are spaced by the cooldown and capped per day.
+type RemediationRule struct {
+	ID          string `gorm:"primaryKey;size:64" json:"id"`
+	TenantID    string `gorm:"size:64;not null;index" json:"tenant_id"`
+	Name        string `gorm:"size:255;not null" json:"name"`
+	Description string `gorm:"type:text" json:"description,omitempty"`
+	Component   string `gorm:"size:128;not null;default:''" json:"component,omitempty"`
+	Status      string `gorm:"size:32;not null" json:"status"`
+	WorkflowID  string `gorm:"size:64;not null;index" json:"workflow_id"`
+	// CooldownMinutes is the least time between runs on one agent
+	CooldownMinutes int `gorm:"not null;default:30" json:"cooldown_minutes"`
+	// MaxRunsPerDay caps the runs on one agent in 24 hours; 0 is unlimited
+	MaxRunsPerDay int       `gorm:"not null;default:3" json:"max_runs_per_day"`
+	Enabled       bool      `gorm:"not null;default:true" json:"enabled"`
+	CreatedBy     string    `gorm:"size:255" json:"created_by,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// TableName returns the table name for RemediationRule
+func (RemediationRule) TableName() string {
+	return "remediation_rules"
+}
+
+// RemediationRunStatus is whether a remediation run started its execution
+type RemediationRunStatus string
+
+const (
+	RemediationRunStarted RemediationRunStatus = "started"
+	RemediationRunFailed  RemediationRunStatus = "failed"
+)
+
+// RemediationRun is a run of a remediation rule on an agent, with the health
+// that matched the rule and the execution it started
+type RemediationRun struct {
+	ID           string               `gorm:"primaryKey;size:64" json:"id"`
+	TenantID     string               `gorm:"size:64;not null;index" json:"tenant_id"`
+	RuleID       string               `gorm:"size:64;not null;index:idx_remediation_runs_rule" json:"rule_id"`
+	AgentID      string               `gorm:"size:64;not null;index:idx_remediation_runs_rule" json:"agent_id"`
+	ExecutionID  string               `gorm:"size:64" json:"execution_id,omitempty"`
+	Component    string               `gorm:"size:128;not null;default:''" json:"component,omitempty"`
+	HealthStatus string               `gorm:"size:32;not null" json:"health_status"`
+	Status       RemediationRunStatus `gorm:"size:32;not null" json:"status"`
+	// Message is the component's health message, or why the run failed
+	Message   string    `gorm:"type:text" json:"message,omitempty"`
+	StartedAt time.Time `gorm:"not null;index:idx_remediation_runs_rule" json:"started_at"`
+}
+
+// TableName returns the table name for RemediationRun
+func (RemediationRun) TableName() string {
+	return "remediation_runs"
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// AgentRequestNonce is the nonce of a signed agent request. A nonce is
+// accepted once per agent; it is kept until a request signed with it could
+// no longer pass the timestamp check, across all control plane replicas.
+type AgentRequestNonce struct {
+	AgentID   string    `gorm:"primaryKey;size:64"`
+	Nonce     string    `gorm:"primaryKey;size:64"`
+	ExpiresAt time.Time `gorm:"not null;index:idx_agent_request_nonces_expires"`
+}
+
+// TableName returns the table name for AgentRequestNonce
+func (AgentRequestNonce) TableName() string {
+	return "agent_request_nonces"
+}
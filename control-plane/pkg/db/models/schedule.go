@@ -0,0 +1,81 @@
+// Package models contains database models for the control plane.
+package models
+
+import (
+	"time"
+)
+
+// ScheduleTimezoneMode is how a schedule's cron expression is read
+type ScheduleTimezoneMode string
+
+const (
+	// ScheduleTimezoneFixed reads the cron expression in the schedule's
+	// timezone, so every agent runs at the same moment
+	ScheduleTimezoneFixed ScheduleTimezoneMode = "fixed"
+	// ScheduleTimezoneAgentLocal reads the cron expression in each agent's
+	// timezone, as the agent reports it in its timezone grain, so "0 3 * * *"
+	// runs at 3am wherever the agent is
+	ScheduleTimezoneAgentLocal ScheduleTimezoneMode = "agent_local"
+)
+
+// Schedule runs a workflow on the agents its selector matches whenever its
+// cron expression comes due
+type Schedule struct {
+	ID          string `gorm:"primaryKey;size:64" json:"id"`
+	TenantID    string `gorm:"size:64;not null;index" json:"tenant_id"`
+	Name        string `gorm:"size:255;not null" json:"name"`
+	Description string `gorm:"type:text" json:"description,omitempty"`
+	WorkflowID  string `gorm:"size:64;not null;index" json:"workflow_id"`
+	// TargetSelector picks the agents by tags and agent_ids, like an
+	// inbound trigger's
+	TargetSelector JSONMap `gorm:"type:json;not null" json:"target_selector"`
+	// Vars are set as workflow vars for every execution
+	Vars JSONMap `gorm:"type:json" json:"vars,omitempty"`
+	// Cron is a five-field cron expression: minute, hour, day of month,
+	// month and day of week
+	Cron string `gorm:"size:255;not null" json:"cron"`
+	// Timezone is the IANA timezone a fixed schedule is read in, and the
+	// one an agent-local schedule falls back to for agents that report none
+	Timezone     string               `gorm:"size:64;not null;default:UTC" json:"timezone"`
+	TimezoneMode ScheduleTimezoneMode `gorm:"size:32;not null;default:fixed" json:"timezone_mode"`
+	// MaxAgents skips a run whose selector matches more agents
+	MaxAgents int  `gorm:"not null;default:10" json:"max_agents"`
+	Enabled   bool `gorm:"not null;default:true" json:"enabled"`
+	// NextRunAt is when the schedule is next evaluated: its next run for a
+	// fixed schedule, the earliest of its agents' next runs for an
+	// agent-local one
+	NextRunAt *time.Time `gorm:"index" json:"next_run_at,omitempty"`
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	// LastError is why the last run skipped or failed on some agents
+	LastError string `gorm:"type:text" json:"last_error,omitempty"`
+	// ClaimedAt is set while a control plane replica evaluates the schedule
+	ClaimedAt *time.Time `json:"-"`
+	CreatedBy string     `gorm:"size:255" json:"created_by,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// TableName returns the table name for Schedule
+func (Schedule) TableName() string {
+	return "schedules"
+}
+
+// ScheduleAgentRun is an agent's next run of an agent-local schedule, worked
+// out in the timezone the agent reported
+type ScheduleAgentRun struct {
+	ScheduleID string `gorm:"primaryKey;size:64" json:"schedule_id"`
+	AgentID    string `gorm:"primaryKey;size:64" json:"agent_id"`
+	TenantID   string `gorm:"size:64;not null;index" json:"tenant_id"`
+	// Timezone is the one NextRunAt was worked out in; the next run is
+	// worked out again when the agent reports another
+	Timezone        string     `gorm:"size:64;not null" json:"timezone"`
+	NextRunAt       time.Time  `gorm:"not null" json:"next_run_at"`
+	LastRunAt       *time.Time `json:"last_run_at,omitempty"`
+	LastExecutionID string     `gorm:"size:64" json:"last_execution_id,omitempty"`
+	LastError       string     `gorm:"type:text" json:"last_error,omitempty"`
+}
+
+// TableName returns the table name for ScheduleAgentRun
+func (ScheduleAgentRun) TableName() string {
+	return "schedule_agent_runs"
+}
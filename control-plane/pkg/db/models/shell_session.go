@@ -0,0 +1,74 @@
+// Package models contains database models for the control plane.
+package models
+
+import (
+	"time"
+)
+
+// ShellSessionStatus is the state of a live shell session
+type ShellSessionStatus string
+
+const (
+	ShellSessionStatusActive ShellSessionStatus = "active"
+	ShellSessionStatusEnded  ShellSessionStatus = "ended"
+)
+
+// ShellSession is an operator's interactive shell on an agent, brokered by
+// the control plane. Its keystrokes and output are recorded in
+// ShellRecordingChunks.
+type ShellSession struct {
+	ID       string             `gorm:"primaryKey;size:64" json:"id"`
+	TenantID string             `gorm:"size:64;not null;index" json:"tenant_id"`
+	AgentID  string             `gorm:"size:64;not null;index" json:"agent_id"`
+	UserID   string             `gorm:"size:255;not null" json:"user_id"`
+	Status   ShellSessionStatus `gorm:"size:20;not null;index" json:"status"`
+	// RemoteAddr is the operator's client address
+	RemoteAddr string `gorm:"size:255" json:"remote_addr,omitempty"`
+	// EndReason is why the session ended: closed, exited, max_duration,
+	// idle_timeout, recording_limit, agent_unreachable, ...
+	EndReason string `gorm:"size:64" json:"end_reason,omitempty"`
+	ExitCode  *int   `json:"exit_code,omitempty"`
+
+	BytesIn  int64 `gorm:"not null;default:0" json:"bytes_in"`
+	BytesOut int64 `gorm:"not null;default:0" json:"bytes_out"`
+	// RecordingBytes is the size of the recorded input and output
+	RecordingBytes int64 `gorm:"not null;default:0" json:"recording_bytes"`
+
+	StartedAt time.Time  `gorm:"not null" json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+
+	// Relationships
+	Tenant Tenant `gorm:"foreignKey:TenantID" json:"tenant,omitempty"`
+}
+
+// TableName returns the table name for ShellSession
+func (ShellSession) TableName() string {
+	return "shell_sessions"
+}
+
+// ShellEvent is a recorded piece of a shell session: input the operator
+// typed or output the shell printed
+type ShellEvent struct {
+	// OffsetMS is the time since the session started
+	OffsetMS int64 `json:"t"`
+	// Kind is "i" for input, "o" for output and "r" for a resize
+	Kind string `json:"k"`
+	Data []byte `json:"d,omitempty"`
+}
+
+// ShellRecordingChunk is a consecutive part of a session's recording,
+// encrypted for the tenant
+type ShellRecordingChunk struct {
+	ID        string       `gorm:"primaryKey;size:64" json:"id"`
+	TenantID  string       `gorm:"size:64;not null;index" json:"tenant_id"`
+	SessionID string       `gorm:"size:64;not null;uniqueIndex:uniq_shell_recording_chunks_seq" json:"session_id"`
+	Seq       int          `gorm:"not null;uniqueIndex:uniq_shell_recording_chunks_seq" json:"seq"`
+	Events    []ShellEvent `gorm:"type:mediumtext;serializer:encrypted" json:"events"`
+	Bytes     int64        `gorm:"not null" json:"bytes"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// TableName returns the table name for ShellRecordingChunk
+func (ShellRecordingChunk) TableName() string {
+	return "shell_recording_chunks"
+}
@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// SoftwareChange is how a package changed between inventory reports
+type SoftwareChange string
+
+const (
+	SoftwareInstalled SoftwareChange = "installed"
+	SoftwareUpdated   SoftwareChange = "updated"
+	SoftwareRemoved   SoftwareChange = "removed"
+)
+
+// AgentSoftware is a package installed on an agent, as last reported by its
+// software inventory. Source is the package manager it was listed by
+// (dpkg, rpm, choco or winget).
+type AgentSoftware struct {
+	ID          string    `gorm:"primaryKey;size:64" json:"-"`
+	AgentID     string    `gorm:"size:64;not null;uniqueIndex:idx_agent_software_package" json:"agent_id"`
+	TenantID    string    `gorm:"size:64;not null;index:idx_agent_software_name" json:"tenant_id"`
+	Source      string    `gorm:"size:16;not null;uniqueIndex:idx_agent_software_package" json:"source"`
+	Name        string    `gorm:"size:191;not null;uniqueIndex:idx_agent_software_package;index:idx_agent_software_name" json:"name"`
+	Arch        string    `gorm:"size:32;not null;default:'';uniqueIndex:idx_agent_software_package" json:"arch,omitempty"`
+	Version     string    `gorm:"size:191;not null" json:"version"`
+	InstalledAt time.Time `gorm:"not null" json:"installed_at"`
+	UpdatedAt   time.Time `gorm:"not null" json:"updated_at"`
+}
+
+// TableName returns the table name for AgentSoftware
+func (AgentSoftware) TableName() string {
+	return "agent_software"
+}
+
+// AgentSoftwareChange records a package installed, updated or removed on an
+// agent; RecordedAt is when the report showing the change was received
+type AgentSoftwareChange struct {
+	ID              string         `gorm:"primaryKey;size:64" json:"id"`
+	AgentID         string         `gorm:"size:64;not null;index:idx_agent_software_changes_agent" json:"agent_id"`
+	TenantID        string         `gorm:"size:64;not null;index:idx_agent_software_changes_tenant" json:"tenant_id"`
+	Source          string         `gorm:"size:16;not null" json:"source"`
+	Name            string         `gorm:"size:191;not null" json:"name"`
+	Arch            string         `gorm:"size:32;not null;default:''" json:"arch,omitempty"`
+	Change          SoftwareChange `gorm:"size:16;not null" json:"change"`
+	Version         string         `gorm:"size:191;not null;default:''" json:"version,omitempty"`
+	PreviousVersion string         `gorm:"size:191;not null;default:''" json:"previous_version,omitempty"`
+	RecordedAt      time.Time      `gorm:"not null;index:idx_agent_software_changes_agent;index:idx_agent_software_changes_recorded" json:"recorded_at"`
+}
+
+// TableName returns the table name for AgentSoftwareChange
+func (AgentSoftwareChange) TableName() string {
+	return "agent_software_changes"
+}
@@ -21,15 +21,31 @@ type Template struct {
 	TenantID    string         `gorm:"size:64;not null;index" json:"tenant_id"`
 	Name        string         `gorm:"size:255;not null" json:"name"`
 	Description string         `gorm:"type:text" json:"description,omitempty"`
-	Content     string         `gorm:"type:longtext;not null" json:"content"`
+	Content     string         `gorm:"type:longtext;not null;serializer:encrypted" json:"content"`
 	ContentType string         `gorm:"size:100;default:'text/plain'" json:"content_type"`
 	Version     int            `gorm:"default:1" json:"version"`
 	Status      TemplateStatus `gorm:"type:enum('draft','active','deprecated','deleted');default:'draft'" json:"status"`
 	Tags        JSONMap        `gorm:"type:json" json:"tags,omitempty"`
 	Metadata    JSONMap        `gorm:"type:json" json:"metadata,omitempty"`
-	CreatedBy   string         `gorm:"size:255" json:"created_by,omitempty"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
+	LintStatus  string         `gorm:"size:20" json:"lint_status,omitempty"`
+	LintResults JSONMap        `gorm:"type:json" json:"lint_results,omitempty"`
+	// Uploaded content is stored in TemplateChunks rather than Content and
+	// is deployed verbatim; ContentHash and ContentSize describe it
+	Uploaded    bool   `gorm:"not null;default:false" json:"uploaded,omitempty"`
+	ContentHash string `gorm:"size:80" json:"content_hash,omitempty"`
+	ContentSize int64  `gorm:"not null;default:0" json:"content_size,omitempty"`
+	// ContentVersion is the version whose chunks hold the uploaded content
+	// when a later update changed only other fields; 0 for this version
+	ContentVersion int `gorm:"not null;default:0" json:"-"`
+	// SourceRepositoryID is the Git repository the template is synced from,
+	// SourcePath the file defining it and SourceCommit the commit that last
+	// changed it
+	SourceRepositoryID *string   `gorm:"size:64;index" json:"source_repository_id,omitempty"`
+	SourcePath         string    `gorm:"size:512" json:"source_path,omitempty"`
+	SourceCommit       string    `gorm:"size:64" json:"source_commit,omitempty"`
+	CreatedBy          string    `gorm:"size:255" json:"created_by,omitempty"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
 
 	// Relationships
 	Tenant Tenant `gorm:"foreignKey:TenantID" json:"tenant,omitempty"`
@@ -42,14 +58,20 @@ func (Template) TableName() string {
 
 // TemplateVersion represents a version history entry for a template
 type TemplateVersion struct {
-	ID         string    `gorm:"primaryKey;size:64" json:"id"`
-	TemplateID string    `gorm:"size:64;not null;index" json:"template_id"`
-	TenantID   string    `gorm:"size:64;not null;index" json:"tenant_id"`
-	Version    int       `gorm:"not null" json:"version"`
-	Content    string    `gorm:"type:longtext;not null" json:"content"`
-	ChangedBy  string    `gorm:"size:255" json:"changed_by,omitempty"`
-	ChangeNote string    `gorm:"type:text" json:"change_note,omitempty"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID          string `gorm:"primaryKey;size:64" json:"id"`
+	TemplateID  string `gorm:"size:64;not null;index" json:"template_id"`
+	TenantID    string `gorm:"size:64;not null;index" json:"tenant_id"`
+	Version     int    `gorm:"not null" json:"version"`
+	Content     string `gorm:"type:longtext;not null;serializer:encrypted" json:"content"`
+	Uploaded    bool   `gorm:"not null;default:false" json:"uploaded,omitempty"`
+	ContentHash string `gorm:"size:80" json:"content_hash,omitempty"`
+	ContentSize int64  `gorm:"not null;default:0" json:"content_size,omitempty"`
+	// ContentVersion is the version whose chunks hold the uploaded content,
+	// as on Template
+	ContentVersion int       `gorm:"not null;default:0" json:"-"`
+	ChangedBy      string    `gorm:"size:255" json:"changed_by,omitempty"`
+	ChangeNote     string    `gorm:"type:text" json:"change_note,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
 
 	// Relationships
 	Template Template `gorm:"foreignKey:TemplateID" json:"template,omitempty"`
@@ -60,3 +82,22 @@ type TemplateVersion struct {
 func (TemplateVersion) TableName() string {
 	return "template_versions"
 }
+
+// TemplateChunk is a consecutive part of the uploaded content of a template
+// version, encrypted for the tenant
+type TemplateChunk struct {
+	ID         string    `gorm:"primaryKey;size:64" json:"id"`
+	TenantID   string    `gorm:"size:64;not null;index" json:"tenant_id"`
+	TemplateID string    `gorm:"size:64;not null;uniqueIndex:uniq_template_chunks_seq" json:"template_id"`
+	Version    int       `gorm:"not null;uniqueIndex:uniq_template_chunks_seq" json:"version"`
+	Seq        int       `gorm:"not null;uniqueIndex:uniq_template_chunks_seq" json:"seq"`
+	ByteOffset int64     `gorm:"not null" json:"byte_offset"`
+	Data       []byte    `gorm:"type:mediumtext;serializer:encrypted" json:"-"`
+	Size       int       `gorm:"not null" json:"size"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// TableName returns the table name for TemplateChunk
+func (TemplateChunk) TableName() string {
+	return "template_chunks"
+}
@@ -24,9 +24,34 @@ type Tenant struct {
 	Name        string         `gorm:"size:255;uniqueIndex;not null" json:"name"`
 	Description string         `gorm:"type:text" json:"description,omitempty"`
 	Status      TenantStatus   `gorm:"type:enum('active','suspended','deleted');default:'active'" json:"status"`
-	Settings    JSONMap        `gorm:"type:json" json:"settings,omitempty"`
+	Settings    JSONMap        `gorm:"type:mediumtext;serializer:encrypted" json:"settings,omitempty"`
 	QuotaAgents int            `gorm:"default:1000" json:"quota_agents"`
 	QuotaWorkflows int         `gorm:"default:100" json:"quota_workflows"`
+	// QuotaConcurrentExecutions caps in-flight campaign executions; 0 uses
+	// the control plane default
+	QuotaConcurrentExecutions int `gorm:"default:0" json:"quota_concurrent_executions"`
+	// QuotaMonthlyExecutions and QuotaMonthlyMinutes budget the executions
+	// started per calendar month (UTC) and their total run time; 0 means
+	// no budget. Budgets only raise alerts unless QuotaHardCap is set, in
+	// which case no executions start once one is used up.
+	QuotaMonthlyExecutions int  `gorm:"default:0" json:"quota_monthly_executions"`
+	QuotaMonthlyMinutes    int  `gorm:"default:0" json:"quota_monthly_minutes"`
+	QuotaHardCap           bool `gorm:"not null;default:false" json:"quota_hard_cap"`
+
+	// WorkflowPolicy holds workflow defaults and ceilings; nil when unset
+	WorkflowPolicy *WorkflowPolicy `gorm:"type:json" json:"workflow_policy,omitempty"`
+
+	// RegistrationPolicy decides whether new agents need approval; nil
+	// admits them on registration
+	RegistrationPolicy *RegistrationPolicy `gorm:"type:json" json:"registration_policy,omitempty"`
+
+	// ShellEnabled allows the tenant's admins to open live shell sessions
+	// on agents that enable them
+	ShellEnabled bool `gorm:"not null;default:false" json:"shell_enabled"`
+
+	// RemediationPaused stops remediation rules starting workflows
+	RemediationPaused bool `gorm:"not null;default:false" json:"remediation_paused"`
+
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
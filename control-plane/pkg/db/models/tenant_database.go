@@ -0,0 +1,47 @@
+// Package models contains database models for the control plane.
+package models
+
+import (
+	"time"
+)
+
+// TenantDatabaseStatus represents the state of a tenant's own database
+type TenantDatabaseStatus string
+
+const (
+	// TenantDatabasePending databases are registered but not yet migrated;
+	// the tenant's data stays in the shared database
+	TenantDatabasePending TenantDatabaseStatus = "pending"
+	TenantDatabaseActive  TenantDatabaseStatus = "active"
+	// TenantDatabaseFailed databases failed their last migration and are
+	// not used until one succeeds
+	TenantDatabaseFailed TenantDatabaseStatus = "failed"
+)
+
+// TenantDatabase is a database registered for a tenant in the shared
+// catalog database. Registered databases are migrated with the shared one,
+// but tenant data is not routed to them yet; see db.TenantRouter.
+type TenantDatabase struct {
+	ID       string `gorm:"primaryKey;size:64" json:"id"`
+	TenantID string `gorm:"size:64;not null;uniqueIndex" json:"tenant_id"`
+	// DSN is the go-sql-driver/mysql data source name, encrypted at rest
+	DSN string `gorm:"type:text;not null;serializer:encrypted" json:"-"`
+	// Host and Database are display copies of the DSN's address and schema
+	Host     string `gorm:"size:255" json:"host"`
+	Database string `gorm:"size:255" json:"database"`
+	// MaxOpenConns and MaxIdleConns size the tenant's connection pool;
+	// zero uses the configured defaults
+	MaxOpenConns   int                  `gorm:"not null;default:0" json:"max_open_conns"`
+	MaxIdleConns   int                  `gorm:"not null;default:0" json:"max_idle_conns"`
+	Status         TenantDatabaseStatus `gorm:"size:20;not null;default:'pending'" json:"status"`
+	SchemaVersion  string               `gorm:"size:64" json:"schema_version,omitempty"`
+	LastMigratedAt *time.Time           `json:"last_migrated_at,omitempty"`
+	LastError      string               `gorm:"type:text" json:"last_error,omitempty"`
+	CreatedAt      time.Time            `json:"created_at"`
+	UpdatedAt      time.Time            `json:"updated_at"`
+}
+
+// TableName returns the table name for TenantDatabase
+func (TenantDatabase) TableName() string {
+	return "tenant_databases"
+}
@@ -0,0 +1,99 @@
+package models
+
+import "time"
+
+// AdvisorySeverity is how severe the vulnerability of an advisory is
+type AdvisorySeverity string
+
+const (
+	AdvisorySeverityCritical AdvisorySeverity = "critical"
+	AdvisorySeverityHigh     AdvisorySeverity = "high"
+	AdvisorySeverityMedium   AdvisorySeverity = "medium"
+	AdvisorySeverityLow      AdvisorySeverity = "low"
+	AdvisorySeverityUnknown  AdvisorySeverity = "unknown"
+)
+
+// Advisory is a security advisory ingested from an OSV or NVD feed.
+// Advisories are shared by all tenants. CVEID is the CVE the advisory is
+// about: its ID, or the first CVE among its aliases.
+type Advisory struct {
+	ID          string           `gorm:"primaryKey;size:128" json:"id"`
+	Source      string           `gorm:"size:16;not null" json:"source"`
+	CVEID       string           `gorm:"column:cve_id;size:32;index:idx_advisories_cve" json:"cve_id,omitempty"`
+	Aliases     StringArray      `gorm:"type:json" json:"aliases,omitempty"`
+	Summary     string           `gorm:"type:text" json:"summary,omitempty"`
+	Severity    AdvisorySeverity `gorm:"size:16;not null;default:'unknown'" json:"severity"`
+	CVSSScore   *float64         `gorm:"column:cvss_score" json:"cvss_score,omitempty"`
+	References  StringArray      `gorm:"type:json" json:"references,omitempty"`
+	PublishedAt *time.Time       `json:"published_at,omitempty"`
+	ModifiedAt  time.Time        `gorm:"not null" json:"modified_at"`
+	ImportedAt  time.Time        `gorm:"not null" json:"imported_at"`
+
+	Ranges []AdvisoryRange `gorm:"foreignKey:AdvisoryID" json:"ranges,omitempty"`
+}
+
+// TableName returns the table name for Advisory
+func (Advisory) TableName() string {
+	return "advisories"
+}
+
+// AdvisoryRange is a range of affected versions of a package. Versions from
+// Introduced ("" for all) up to Fixed, excluded, or LastAffected, included,
+// are affected; with neither, every later version is. Source is the package
+// manager the range applies to, empty for any, and Release the
+// distribution release, e.g. debian:12, empty for any.
+type AdvisoryRange struct {
+	ID           uint64 `gorm:"primaryKey;autoIncrement" json:"-"`
+	AdvisoryID   string `gorm:"size:128;not null;index:idx_advisory_ranges_advisory" json:"-"`
+	Source       string `gorm:"size:16;not null;default:'';index:idx_advisory_ranges_package,priority:2" json:"source,omitempty"`
+	Name         string `gorm:"size:191;not null;index:idx_advisory_ranges_package,priority:1" json:"name"`
+	Release      string `gorm:"size:64;not null;default:''" json:"release,omitempty"`
+	Introduced   string `gorm:"size:191;not null;default:''" json:"introduced,omitempty"`
+	Fixed        string `gorm:"size:191;not null;default:''" json:"fixed,omitempty"`
+	LastAffected string `gorm:"size:191;not null;default:''" json:"last_affected,omitempty"`
+}
+
+// TableName returns the table name for AdvisoryRange
+func (AdvisoryRange) TableName() string {
+	return "advisory_ranges"
+}
+
+// FindingStatus is the state of a vulnerability finding
+type FindingStatus string
+
+const (
+	FindingStatusOpen     FindingStatus = "open"
+	FindingStatusResolved FindingStatus = "resolved"
+)
+
+// VulnerabilityFinding is a package installed on an agent in a version an
+// advisory affects. It stays open while matching keeps finding it and is
+// resolved once the package is upgraded or removed.
+type VulnerabilityFinding struct {
+	ID         string           `gorm:"primaryKey;size:64" json:"id"`
+	TenantID   string           `gorm:"size:64;not null;index:idx_vulnerability_findings_tenant" json:"tenant_id"`
+	AgentID    string           `gorm:"size:64;not null;index:idx_vulnerability_findings_agent" json:"agent_id"`
+	AdvisoryID string           `gorm:"size:128;not null;index:idx_vulnerability_findings_advisory" json:"advisory_id"`
+	CVEID      string           `gorm:"column:cve_id;size:32;index:idx_vulnerability_findings_cve" json:"cve_id,omitempty"`
+	Severity   AdvisorySeverity `gorm:"size:16;not null" json:"severity"`
+	Status     FindingStatus    `gorm:"size:16;not null;index:idx_vulnerability_findings_tenant;index:idx_vulnerability_findings_cve" json:"status"`
+	// OpenKey identifies the open finding of an agent, advisory and
+	// package, so matching reports it once; it is cleared on resolution
+	OpenKey *string `gorm:"size:64;uniqueIndex:idx_vulnerability_findings_open_key" json:"-"`
+
+	Source           string `gorm:"size:16;not null" json:"source"`
+	PackageName      string `gorm:"size:191;not null" json:"package_name"`
+	Arch             string `gorm:"size:32;not null;default:''" json:"arch,omitempty"`
+	InstalledVersion string `gorm:"size:191;not null" json:"installed_version"`
+	// FixedVersion is the first version the advisory lists as fixed, if any
+	FixedVersion string `gorm:"size:191;not null;default:''" json:"fixed_version,omitempty"`
+
+	DetectedAt time.Time  `gorm:"not null" json:"detected_at"`
+	LastSeenAt time.Time  `gorm:"not null" json:"last_seen_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// TableName returns the table name for VulnerabilityFinding
+func (VulnerabilityFinding) TableName() string {
+	return "vulnerability_findings"
+}
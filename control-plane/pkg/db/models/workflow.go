@@ -2,6 +2,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -24,9 +25,16 @@ type Workflow struct {
 	Definition  JSONMap        `gorm:"type:json;not null" json:"definition"`
 	Version     int            `gorm:"default:1" json:"version"`
 	Status      WorkflowStatus `gorm:"type:enum('draft','active','deprecated','deleted');default:'draft'" json:"status"`
-	CreatedBy   string         `gorm:"size:255" json:"created_by,omitempty"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
+	Tags        JSONMap        `gorm:"type:json" json:"tags,omitempty"`
+	// SourceRepositoryID is the Git repository the workflow is synced from,
+	// SourcePath the file defining it and SourceCommit the commit that last
+	// changed it
+	SourceRepositoryID *string   `gorm:"size:64;index" json:"source_repository_id,omitempty"`
+	SourcePath         string    `gorm:"size:512" json:"source_path,omitempty"`
+	SourceCommit       string    `gorm:"size:64" json:"source_commit,omitempty"`
+	CreatedBy          string    `gorm:"size:255" json:"created_by,omitempty"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
 
 	// Relationships
 	Tenant     Tenant              `gorm:"foreignKey:TenantID" json:"tenant,omitempty"`
@@ -39,6 +47,25 @@ func (Workflow) TableName() string {
 	return "workflows"
 }
 
+// WorkflowSnapshot is a copy of a workflow definition as dispatched, kept
+// once per distinct definition so executions can be traced to, and
+// campaigns pinned to, content that later edits do not change
+type WorkflowSnapshot struct {
+	ID             string `gorm:"primaryKey;size:64" json:"id"`
+	TenantID       string `gorm:"size:64;not null;index" json:"tenant_id"`
+	WorkflowID     string `gorm:"size:64;not null" json:"workflow_id"`
+	DefinitionHash string `gorm:"size:64;not null" json:"definition_hash"`
+	// WorkflowVersion is the version the definition was first seen at
+	WorkflowVersion int       `gorm:"not null" json:"workflow_version"`
+	Definition      JSONMap   `gorm:"type:json;not null" json:"definition"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// TableName returns the table name for WorkflowSnapshot
+func (WorkflowSnapshot) TableName() string {
+	return "workflow_snapshots"
+}
+
 // ExecutionStatus represents the status of a workflow execution
 type ExecutionStatus string
 
@@ -49,6 +76,21 @@ const (
 	ExecutionStatusFailed    ExecutionStatus = "failed"
 	ExecutionStatusCancelled ExecutionStatus = "cancelled"
 	ExecutionStatusTimeout   ExecutionStatus = "timeout"
+	// ExecutionStatusPaused is an execution its agent stopped between
+	// steps until it is resumed
+	ExecutionStatusPaused ExecutionStatus = "paused"
+)
+
+// ExecutionMode is how an agent runs an execution's steps
+type ExecutionMode string
+
+const (
+	// ExecutionModeLive runs every step
+	ExecutionModeLive ExecutionMode = "live"
+	// ExecutionModeValidate changes nothing on the agent: pre-check steps
+	// run, template steps only report the diff they would apply and all
+	// other steps are skipped
+	ExecutionModeValidate ExecutionMode = "validate"
 )
 
 // WorkflowExecution represents a workflow execution
@@ -58,12 +100,47 @@ type WorkflowExecution struct {
 	TenantID    string          `gorm:"size:64;not null;index" json:"tenant_id"`
 	AgentID     string          `gorm:"size:64;not null;index" json:"agent_id"`
 	CampaignID  *string         `gorm:"size:64;index" json:"campaign_id,omitempty"`
-	Status      ExecutionStatus `gorm:"type:enum('pending','running','success','failed','cancelled','timeout');default:'pending'" json:"status"`
+	Status      ExecutionStatus `gorm:"type:enum('pending','running','success','failed','cancelled','timeout','paused');default:'pending'" json:"status"`
 	Result      JSONMap         `gorm:"type:json" json:"result,omitempty"`
+	Environment JSONMap         `gorm:"type:json" json:"environment,omitempty"`
 	StartedAt   *time.Time      `json:"started_at,omitempty"`
 	CompletedAt *time.Time      `json:"completed_at,omitempty"`
 	CreatedAt   time.Time       `json:"created_at"`
 
+	// WorkflowVersion is the workflow version dispatched; 0 for executions
+	// recorded before versions were tracked
+	WorkflowVersion int `gorm:"default:0" json:"workflow_version"`
+
+	// DefinitionHash is the SHA-256 of the definition dispatched, a
+	// WorkflowSnapshot of the workflow
+	DefinitionHash string `gorm:"size:64" json:"definition_hash,omitempty"`
+
+	// Mode is live, or validate for an execution that only reports what it
+	// would change
+	Mode ExecutionMode `gorm:"column:execution_mode;size:16;not null;default:'live'" json:"mode"`
+
+	// Adhoc is set for a one-off execution of an inline definition; its
+	// WorkflowID is the tenant's hidden ad-hoc workflow and DefinitionHash
+	// the snapshot of the definition
+	Adhoc bool `gorm:"not null;default:false" json:"adhoc,omitempty"`
+
+	// RequestID is the ID of the API request or MCP tool call that started
+	// the execution, sent to the agent with the dispatch and echoed in its
+	// step results
+	RequestID string `gorm:"size:128;index" json:"request_id,omitempty"`
+
+	// Trigger chain: the execution whose completion started this one, the
+	// number of triggers between it and the chain's first execution, and
+	// whether this execution's own triggers have been fired
+	TriggeredByID *string `gorm:"column:triggered_by_execution_id;size:64;index" json:"triggered_by_execution_id,omitempty"`
+	TriggerDepth  int     `gorm:"default:0" json:"trigger_depth,omitempty"`
+	TriggersFired bool    `gorm:"default:false" json:"-"`
+
+	// ArchiveKey is the object store key the execution's output was moved
+	// to; Result then only keeps a summary without step output
+	ArchiveKey *string    `gorm:"size:512" json:"archive_key,omitempty"`
+	ArchivedAt *time.Time `gorm:"index" json:"archived_at,omitempty"`
+
 	// Relationships
 	Workflow Workflow  `gorm:"foreignKey:WorkflowID" json:"workflow,omitempty"`
 	Tenant   Tenant    `gorm:"foreignKey:TenantID" json:"tenant,omitempty"`
@@ -85,6 +162,66 @@ func (e *WorkflowExecution) Duration() *time.Duration {
 	return &d
 }
 
+// MarshalJSON writes the execution's timestamps in UTC and adds its
+// duration in milliseconds once it has completed
+func (e WorkflowExecution) MarshalJSON() ([]byte, error) {
+	type plain WorkflowExecution
+	out := struct {
+		plain
+		StartedAt   *time.Time `json:"started_at,omitempty"`
+		CompletedAt *time.Time `json:"completed_at,omitempty"`
+		CreatedAt   time.Time  `json:"created_at"`
+		ArchivedAt  *time.Time `json:"archived_at,omitempty"`
+		DurationMs  *int64     `json:"duration_ms,omitempty"`
+	}{
+		plain:       plain(e),
+		StartedAt:   utcTime(e.StartedAt),
+		CompletedAt: utcTime(e.CompletedAt),
+		CreatedAt:   e.CreatedAt.UTC(),
+		ArchivedAt:  utcTime(e.ArchivedAt),
+	}
+	if d := e.Duration(); d != nil {
+		ms := d.Milliseconds()
+		out.DurationMs = &ms
+	}
+	return json.Marshal(out)
+}
+
+// utcTime returns an optional timestamp in UTC
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
+// AgentDuration reads a duration an agent reported in a result or step
+// result: field_ms in milliseconds, or field in nanoseconds as agents before
+// duration_ms reported it
+func AgentDuration(result map[string]interface{}, field string) (time.Duration, bool) {
+	if ms, ok := number(result[field+"_ms"]); ok {
+		return time.Duration(ms * float64(time.Millisecond)), true
+	}
+	if ns, ok := number(result[field]); ok {
+		return time.Duration(ns), true
+	}
+	return 0, false
+}
+
+// number reads a JSON number decoded into an interface or set in Go
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
 // IsComplete returns true if the execution is complete
 func (e *WorkflowExecution) IsComplete() bool {
 	switch e.Status {
@@ -94,3 +231,124 @@ func (e *WorkflowExecution) IsComplete() bool {
 		return false
 	}
 }
+
+// TriggerCondition is the outcome of a source workflow's execution that
+// fires a trigger
+type TriggerCondition string
+
+const (
+	TriggerOnSuccess    TriggerCondition = "success"    // Execution succeeded
+	TriggerOnFailure    TriggerCondition = "failure"    // Execution failed or timed out
+	TriggerOnCompletion TriggerCondition = "completion" // Either of the above; cancelled executions fire nothing
+)
+
+// Matches reports whether an execution that finished with status fires the
+// condition
+func (c TriggerCondition) Matches(status ExecutionStatus) bool {
+	switch status {
+	case ExecutionStatusSuccess:
+		return c == TriggerOnSuccess || c == TriggerOnCompletion
+	case ExecutionStatusFailed, ExecutionStatusTimeout:
+		return c == TriggerOnFailure || c == TriggerOnCompletion
+	default:
+		return false
+	}
+}
+
+// WorkflowTrigger runs the target workflow on the same agent when an
+// execution of the source workflow finishes with a matching outcome
+type WorkflowTrigger struct {
+	ID               string           `gorm:"primaryKey;size:64" json:"id"`
+	TenantID         string           `gorm:"size:64;not null;index" json:"tenant_id"`
+	SourceWorkflowID string           `gorm:"size:64;not null;index" json:"source_workflow_id"`
+	TargetWorkflowID string           `gorm:"size:64;not null;index" json:"target_workflow_id"`
+	On               TriggerCondition `gorm:"column:trigger_on;size:16;not null" json:"on"`
+	Enabled          bool             `gorm:"default:true" json:"enabled"`
+	Description      string           `gorm:"type:text" json:"description,omitempty"`
+	CreatedBy        string           `gorm:"size:255" json:"created_by,omitempty"`
+	CreatedAt        time.Time        `json:"created_at"`
+	UpdatedAt        time.Time        `json:"updated_at"`
+}
+
+// TableName returns the table name for WorkflowTrigger
+func (WorkflowTrigger) TableName() string {
+	return "workflow_triggers"
+}
+
+// HookAction is what a workflow hook does when it fires
+type HookAction string
+
+const (
+	// HookActionWebhook sends an HTTP request, for notifications and
+	// ticketing systems
+	HookActionWebhook HookAction = "webhook"
+	// HookActionWorkflow runs another workflow, on the same agent or
+	// another one
+	HookActionWorkflow HookAction = "workflow"
+)
+
+// WorkflowHook is an action the control plane takes when an execution of a
+// workflow finishes with a matching outcome. Unlike a workflow's own
+// on_success and on_failure steps it runs on the control plane, not the
+// agent. URL, Headers values, Body and TargetAgentID are templates
+// rendered with the finished execution.
+type WorkflowHook struct {
+	ID          string           `gorm:"primaryKey;size:64" json:"id"`
+	TenantID    string           `gorm:"size:64;not null;index" json:"tenant_id"`
+	WorkflowID  string           `gorm:"size:64;not null;index" json:"workflow_id"`
+	On          TriggerCondition `gorm:"column:hook_on;size:16;not null" json:"on"`
+	Action      HookAction       `gorm:"size:16;not null" json:"action"`
+	Enabled     bool             `gorm:"default:true" json:"enabled"`
+	Description string           `gorm:"type:text" json:"description,omitempty"`
+
+	// Webhook request; headers may carry credentials and are encrypted
+	URL     string  `gorm:"size:2048" json:"url,omitempty"`
+	Method  string  `gorm:"size:8" json:"method,omitempty"`
+	Headers JSONMap `gorm:"type:mediumtext;serializer:encrypted" json:"headers,omitempty"`
+	Body    string  `gorm:"type:text" json:"body,omitempty"`
+
+	// Workflow run; an empty TargetAgentID runs it on the execution's agent
+	TargetWorkflowID string `gorm:"size:64" json:"target_workflow_id,omitempty"`
+	TargetAgentID    string `gorm:"size:255" json:"target_agent_id,omitempty"`
+	Priority         string `gorm:"size:16" json:"priority,omitempty"`
+
+	CreatedBy string    `gorm:"size:255" json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName returns the table name for WorkflowHook
+func (WorkflowHook) TableName() string {
+	return "workflow_hooks"
+}
+
+// HookRunStatus is the outcome of a workflow hook run
+type HookRunStatus string
+
+const (
+	HookRunSuccess HookRunStatus = "success"
+	HookRunFailed  HookRunStatus = "failed"
+)
+
+// WorkflowHookRun records a workflow hook fired by an execution
+type WorkflowHookRun struct {
+	ID          string        `gorm:"primaryKey;size:64" json:"id"`
+	TenantID    string        `gorm:"size:64;not null;index" json:"tenant_id"`
+	HookID      string        `gorm:"size:64;not null;index" json:"hook_id"`
+	ExecutionID string        `gorm:"size:64;not null;index" json:"execution_id"`
+	Action      HookAction    `gorm:"size:16;not null" json:"action"`
+	Status      HookRunStatus `gorm:"size:16;not null" json:"status"`
+	Attempts    int           `gorm:"not null" json:"attempts"`
+	// StatusCode is the webhook response status of the last attempt
+	StatusCode int `json:"status_code,omitempty"`
+	// TriggeredExecutionID is the execution a workflow hook started
+	TriggeredExecutionID *string   `gorm:"size:64" json:"triggered_execution_id,omitempty"`
+	Error                string    `gorm:"type:text" json:"error,omitempty"`
+	StartedAt            time.Time `json:"started_at"`
+	CompletedAt          time.Time `json:"completed_at"`
+}
+
+// TableName returns the table name for WorkflowHookRun
+func (WorkflowHookRun) TableName() string {
+	return "workflow_hook_runs"
+}
@@ -0,0 +1,511 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// TenancyConfig configures the databases registered for tenants
+type TenancyConfig struct {
+	// MigrationsDir holds the SQL migrations applied to tenant databases;
+	// they get the same schema as the shared database
+	MigrationsDir string
+	// MaxOpenConns, MaxIdleConns and ConnMaxLifetime size each tenant's
+	// connection pool unless its catalog entry overrides them
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	// IdleTimeout closes the pools of tenants without queries for this long
+	IdleTimeout time.Duration
+	// RefreshInterval is how long a tenant's catalog entry is used before
+	// it is read again, so registrations reach every replica
+	RefreshInterval    time.Duration
+	SlowQueryThreshold time.Duration
+}
+
+// DefaultTenancyConfig returns the default tenancy configuration
+func DefaultTenancyConfig() *TenancyConfig {
+	return &TenancyConfig{
+		MigrationsDir:      "migrations",
+		MaxOpenConns:       10,
+		MaxIdleConns:       2,
+		ConnMaxLifetime:    time.Hour,
+		IdleTimeout:        15 * time.Minute,
+		RefreshInterval:    time.Minute,
+		SlowQueryThreshold: DefaultSlowQueryThreshold,
+	}
+}
+
+// RegisterTenantDatabaseRequest registers a database for a tenant's data
+type RegisterTenantDatabaseRequest struct {
+	TenantID     string `json:"-"`
+	DSN          string `json:"dsn" binding:"required"`
+	MaxOpenConns int    `json:"max_open_conns"`
+	MaxIdleConns int    `json:"max_idle_conns"`
+}
+
+// TenantMigration reports migrating one tenant database
+type TenantMigration struct {
+	TenantID      string                      `json:"tenant_id"`
+	Status        models.TenantDatabaseStatus `json:"status"`
+	SchemaVersion string                      `json:"schema_version,omitempty"`
+	Error         string                      `json:"error,omitempty"`
+}
+
+// tenantPool is a tenant's routing entry; a nil db routes the tenant to
+// the shared database
+type tenantPool struct {
+	db        *gorm.DB
+	dsn       string
+	checkedAt time.Time
+	usedAt    time.Time
+}
+
+// TenantRouter keeps the catalog of tenant databases, their connection
+// pools and their migrations. Tenants with an active entry in the catalog's
+// tenant_databases table have a database of their own, reached through a
+// connection pool per tenant that is opened on first use and closed when
+// idle.
+//
+// It is the catalog and migration plumbing for database-per-tenant
+// isolation, not the isolation itself: the managers read and write every
+// tenant's operational data in the shared database, and only the platform
+// overview reads through DB.
+type TenantRouter struct {
+	catalog *gorm.DB
+	config  *TenancyConfig
+	logger  *zap.Logger
+
+	mu    sync.Mutex
+	pools map[string]*tenantPool
+}
+
+// NewTenantRouter creates a router over the shared catalog database
+func NewTenantRouter(catalog *gorm.DB, cfg *TenancyConfig, logger *zap.Logger) *TenantRouter {
+	defaults := DefaultTenancyConfig()
+	if cfg == nil {
+		cfg = defaults
+	}
+	if cfg.MigrationsDir == "" {
+		cfg.MigrationsDir = defaults.MigrationsDir
+	}
+	if cfg.MaxOpenConns <= 0 {
+		cfg.MaxOpenConns = defaults.MaxOpenConns
+	}
+	if cfg.MaxIdleConns <= 0 {
+		cfg.MaxIdleConns = defaults.MaxIdleConns
+	}
+	if cfg.ConnMaxLifetime <= 0 {
+		cfg.ConnMaxLifetime = defaults.ConnMaxLifetime
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = defaults.IdleTimeout
+	}
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = defaults.RefreshInterval
+	}
+	return &TenantRouter{
+		catalog: catalog,
+		config:  cfg,
+		logger:  logger,
+		pools:   make(map[string]*tenantPool),
+	}
+}
+
+// Catalog returns the shared database holding the catalog, platform data
+// and the data of tenants without their own database
+func (r *TenantRouter) Catalog() *gorm.DB {
+	return r.catalog
+}
+
+// DB returns a tenant's own database, or the shared database for tenants
+// without an active one
+func (r *TenantRouter) DB(ctx context.Context, tenantID string) (*gorm.DB, error) {
+	now := time.Now()
+
+	r.mu.Lock()
+	if pool, ok := r.pools[tenantID]; ok && now.Sub(pool.checkedAt) < r.config.RefreshInterval {
+		pool.usedAt = now
+		db := pool.db
+		r.mu.Unlock()
+		if db == nil {
+			return r.catalog.WithContext(ctx), nil
+		}
+		return db.WithContext(ctx), nil
+	}
+	r.mu.Unlock()
+
+	var record models.TenantDatabase
+	err := r.catalog.WithContext(ctx).
+		Where("tenant_id = ? AND status = ?", tenantID, models.TenantDatabaseActive).
+		First(&record).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, fmt.Errorf("failed to look up tenant database: %w", err)
+	}
+
+	// Tenants without an active database share the catalog database
+	if err == gorm.ErrRecordNotFound {
+		r.replace(tenantID, &tenantPool{checkedAt: now, usedAt: now})
+		return r.catalog.WithContext(ctx), nil
+	}
+
+	r.mu.Lock()
+	if pool, ok := r.pools[tenantID]; ok && pool.db != nil && pool.dsn == record.DSN {
+		pool.checkedAt, pool.usedAt = now, now
+		db := pool.db
+		r.mu.Unlock()
+		return db.WithContext(ctx), nil
+	}
+	r.mu.Unlock()
+
+	db, err := r.open(&record)
+	if err != nil {
+		return nil, err
+	}
+	r.replace(tenantID, &tenantPool{db: db, dsn: record.DSN, checkedAt: now, usedAt: now})
+	r.logger.Info("opened tenant database pool",
+		zap.String("tenant_id", tenantID),
+		zap.String("host", record.Host),
+		zap.String("database", record.Database))
+	return db.WithContext(ctx), nil
+}
+
+// Register adds a database for a tenant and migrates it. The tenant's
+// operational data is still kept in the shared database; only tenants
+// without data there can register, so none would have to be moved once the
+// managers route through DB.
+func (r *TenantRouter) Register(ctx context.Context, req *RegisterTenantDatabaseRequest) (*models.TenantDatabase, error) {
+	var tenant models.Tenant
+	if err := r.catalog.WithContext(ctx).Select("id").Where("id = ?", req.TenantID).First(&tenant).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("tenant not found")
+		}
+		return nil, fmt.Errorf("failed to get tenant: %w", err)
+	}
+
+	dsn, cfg, err := normalizeDSN(req.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if req.MaxOpenConns < 0 || req.MaxIdleConns < 0 {
+		return nil, apperror.InvalidInput("connection pool sizes must not be negative")
+	}
+
+	for _, model := range []interface{}{&models.Agent{}, &models.Workflow{}, &models.Template{}} {
+		var count int64
+		if err := r.catalog.WithContext(ctx).Model(model).Where("tenant_id = ?", req.TenantID).Count(&count).Error; err != nil {
+			return nil, fmt.Errorf("failed to check tenant data: %w", err)
+		}
+		if count > 0 {
+			return nil, apperror.InvalidState("tenant %s already has data in the shared database", req.TenantID)
+		}
+	}
+
+	now := time.Now()
+	record := &models.TenantDatabase{
+		ID:           uuid.New().String(),
+		TenantID:     req.TenantID,
+		DSN:          dsn,
+		Host:         cfg.Addr,
+		Database:     cfg.DBName,
+		MaxOpenConns: req.MaxOpenConns,
+		MaxIdleConns: req.MaxIdleConns,
+		Status:       models.TenantDatabasePending,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	result := r.catalog.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to register tenant database: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, apperror.Conflict("tenant %s already has a database", req.TenantID)
+	}
+
+	r.logger.Info("tenant database registered",
+		zap.String("tenant_id", record.TenantID),
+		zap.String("host", record.Host),
+		zap.String("database", record.Database))
+
+	migration := r.migrate(ctx, record)
+	if migration.Error != "" {
+		return record, apperror.InvalidState("tenant database registered but not migrated: %s", migration.Error)
+	}
+	return record, nil
+}
+
+// Get returns a tenant's database entry
+func (r *TenantRouter) Get(ctx context.Context, tenantID string) (*models.TenantDatabase, error) {
+	var record models.TenantDatabase
+	if err := r.catalog.WithContext(ctx).Where("tenant_id = ?", tenantID).First(&record).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("tenant %s uses the shared database", tenantID)
+		}
+		return nil, fmt.Errorf("failed to get tenant database: %w", err)
+	}
+	return &record, nil
+}
+
+// List returns every tenant database entry
+func (r *TenantRouter) List(ctx context.Context) ([]models.TenantDatabase, error) {
+	var records []models.TenantDatabase
+	if err := r.catalog.WithContext(ctx).Order("tenant_id ASC").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to list tenant databases: %w", err)
+	}
+	return records, nil
+}
+
+// Migrate applies pending migrations to one tenant's database
+func (r *TenantRouter) Migrate(ctx context.Context, tenantID string) (*TenantMigration, error) {
+	record, err := r.Get(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return r.migrate(ctx, record), nil
+}
+
+// MigrateAll applies pending migrations to every tenant database, one at a
+// time. A failing tenant is reported and the others are still migrated.
+func (r *TenantRouter) MigrateAll(ctx context.Context) ([]TenantMigration, error) {
+	records, err := r.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]TenantMigration, 0, len(records))
+	for i := range records {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		results = append(results, *r.migrate(ctx, &records[i]))
+	}
+	return results, nil
+}
+
+// migrate runs the migrations on a tenant database and seeds its copy of
+// the tenant row, which its foreign keys reference; the catalog's row stays
+// authoritative. Pending databases become active once migrated. A failed
+// migration leaves an active database in use and records the error.
+func (r *TenantRouter) migrate(ctx context.Context, record *models.TenantDatabase) *TenantMigration {
+	result := &TenantMigration{TenantID: record.TenantID, Status: record.Status}
+
+	version, err := r.migrateDatabase(ctx, record)
+	now := time.Now()
+	updates := map[string]interface{}{"updated_at": now}
+	if err != nil {
+		result.Error = err.Error()
+		if record.Status != models.TenantDatabaseActive {
+			result.Status = models.TenantDatabaseFailed
+		}
+		updates["last_error"] = result.Error
+		r.logger.Error("failed to migrate tenant database",
+			zap.String("tenant_id", record.TenantID),
+			zap.Error(err))
+	} else {
+		result.Status = models.TenantDatabaseActive
+		result.SchemaVersion = version
+		updates["schema_version"] = version
+		updates["last_migrated_at"] = now
+		updates["last_error"] = ""
+		r.logger.Info("tenant database migrated",
+			zap.String("tenant_id", record.TenantID),
+			zap.String("schema_version", version))
+	}
+	updates["status"] = result.Status
+
+	if err := r.catalog.WithContext(ctx).Model(&models.TenantDatabase{}).
+		Where("id = ?", record.ID).
+		Updates(updates).Error; err != nil {
+		r.logger.Error("failed to record tenant database migration",
+			zap.String("tenant_id", record.TenantID),
+			zap.Error(err))
+	}
+	record.Status = result.Status
+	r.forget(record.TenantID)
+	return result
+}
+
+// migrateDatabase migrates a tenant database over a connection of its own
+// and returns the latest applied migration
+func (r *TenantRouter) migrateDatabase(ctx context.Context, record *models.TenantDatabase) (string, error) {
+	db, err := r.open(record)
+	if err != nil {
+		return "", err
+	}
+	defer closeDB(db)
+
+	runner := NewMigrationRunner(db.WithContext(ctx), r.logger.With(zap.String("tenant_id", record.TenantID)))
+	if err := runner.Run(r.config.MigrationsDir); err != nil {
+		return "", err
+	}
+	history, err := runner.Status()
+	if err != nil {
+		return "", fmt.Errorf("failed to read migration history: %w", err)
+	}
+
+	var tenant models.Tenant
+	if err := r.catalog.WithContext(ctx).Where("id = ?", record.TenantID).First(&tenant).Error; err != nil {
+		return "", fmt.Errorf("failed to get tenant: %w", err)
+	}
+	if err := db.WithContext(ctx).Omit(clause.Associations).
+		Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&tenant).Error; err != nil {
+		return "", fmt.Errorf("failed to seed tenant: %w", err)
+	}
+
+	if len(history) == 0 {
+		return "", nil
+	}
+	return history[len(history)-1].Version, nil
+}
+
+// open opens a connection pool to a tenant database and checks it answers
+func (r *TenantRouter) open(record *models.TenantDatabase) (*gorm.DB, error) {
+	db, err := gorm.Open(mysql.Open(record.DSN), &gorm.Config{Logger: r.catalog.Logger})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to tenant database: %w", err)
+	}
+	if err := db.Use(NewQueryMetrics(r.config.SlowQueryThreshold, r.logger)); err != nil {
+		closeDB(db)
+		return nil, fmt.Errorf("failed to register query metrics: %w", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get underlying sql.DB: %w", err)
+	}
+	maxOpen, maxIdle := r.config.MaxOpenConns, r.config.MaxIdleConns
+	if record.MaxOpenConns > 0 {
+		maxOpen = record.MaxOpenConns
+	}
+	if record.MaxIdleConns > 0 {
+		maxIdle = record.MaxIdleConns
+	}
+	sqlDB.SetMaxOpenConns(maxOpen)
+	sqlDB.SetMaxIdleConns(maxIdle)
+	sqlDB.SetConnMaxLifetime(r.config.ConnMaxLifetime)
+
+	if err := sqlDB.Ping(); err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("failed to reach tenant database: %w", err)
+	}
+	return db, nil
+}
+
+// replace stores a tenant's routing entry, closing the pool it replaces
+func (r *TenantRouter) replace(tenantID string, pool *tenantPool) {
+	r.mu.Lock()
+	previous := r.pools[tenantID]
+	r.pools[tenantID] = pool
+	r.mu.Unlock()
+
+	if previous != nil && previous.db != nil && previous.db != pool.db {
+		closeDB(previous.db)
+	}
+}
+
+// forget drops a tenant's routing entry so the catalog is read again
+func (r *TenantRouter) forget(tenantID string) {
+	r.mu.Lock()
+	pool := r.pools[tenantID]
+	delete(r.pools, tenantID)
+	r.mu.Unlock()
+
+	if pool != nil && pool.db != nil {
+		closeDB(pool.db)
+	}
+}
+
+// Run closes idle tenant pools until the context is cancelled
+func (r *TenantRouter) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.IdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.closeIdle(time.Now())
+		}
+	}
+}
+
+// closeIdle closes the pools of tenants unused since the idle timeout
+func (r *TenantRouter) closeIdle(now time.Time) {
+	var idle []*gorm.DB
+	r.mu.Lock()
+	for tenantID, pool := range r.pools {
+		if now.Sub(pool.usedAt) < r.config.IdleTimeout {
+			continue
+		}
+		if pool.db != nil {
+			idle = append(idle, pool.db)
+			r.logger.Info("closing idle tenant database pool", zap.String("tenant_id", tenantID))
+		}
+		delete(r.pools, tenantID)
+	}
+	r.mu.Unlock()
+
+	for _, db := range idle {
+		closeDB(db)
+	}
+}
+
+// Close closes every tenant pool
+func (r *TenantRouter) Close() error {
+	r.mu.Lock()
+	pools := r.pools
+	r.pools = make(map[string]*tenantPool)
+	r.mu.Unlock()
+
+	for _, pool := range pools {
+		if pool.db != nil {
+			closeDB(pool.db)
+		}
+	}
+	return nil
+}
+
+// normalizeDSN checks a tenant DSN names a database and sets the options
+// the control plane relies on: UTC time parsing, utf8mb4 and multiple
+// statements per migration
+func normalizeDSN(dsn string) (string, *mysqldriver.Config, error) {
+	cfg, err := mysqldriver.ParseDSN(strings.TrimSpace(dsn))
+	if err != nil {
+		return "", nil, apperror.InvalidInput("invalid dsn: %v", err)
+	}
+	if cfg.DBName == "" {
+		return "", nil, apperror.InvalidInput("dsn must name a database")
+	}
+	cfg.ParseTime = true
+	cfg.Loc = time.UTC
+	cfg.MultiStatements = true
+	if cfg.Params == nil {
+		cfg.Params = map[string]string{}
+	}
+	if _, ok := cfg.Params["charset"]; !ok {
+		cfg.Params["charset"] = "utf8mb4"
+	}
+	return cfg.FormatDSN(), cfg, nil
+}
+
+// closeDB closes a GORM connection pool
+func closeDB(db *gorm.DB) {
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
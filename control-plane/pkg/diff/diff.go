@@ -0,0 +1,267 @@
+// Package diff computes line-based unified diffs between text documents.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultContext is the number of unchanged lines shown around each hunk
+const DefaultContext = 3
+
+// maxLCSCells bounds the LCS table size. Inputs whose changed region is
+// larger are diffed as a single replacement instead.
+const maxLCSCells = 4 * 1024 * 1024
+
+// Line kinds
+const (
+	LineContext = "context"
+	LineAdded   = "added"
+	LineRemoved = "removed"
+)
+
+// Line is a single line in a hunk. OldLine and NewLine are 1-based line
+// numbers, zero when the line does not exist on that side.
+type Line struct {
+	Kind    string `json:"kind"`
+	Content string `json:"content"`
+	OldLine int    `json:"old_line,omitempty"`
+	NewLine int    `json:"new_line,omitempty"`
+}
+
+// Hunk is a group of changes with surrounding context
+type Hunk struct {
+	OldStart int    `json:"old_start"`
+	OldLines int    `json:"old_lines"`
+	NewStart int    `json:"new_start"`
+	NewLines int    `json:"new_lines"`
+	Lines    []Line `json:"lines"`
+}
+
+// Result is a structured unified diff
+type Result struct {
+	OldName   string `json:"old_name"`
+	NewName   string `json:"new_name"`
+	Changed   bool   `json:"changed"`
+	Additions int    `json:"additions"`
+	Deletions int    `json:"deletions"`
+	Hunks     []Hunk `json:"hunks"`
+}
+
+// op is a single line-level edit
+type op struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// Unified returns the unified diff between two strings, or an empty string
+// if the contents are identical
+func Unified(oldName, newName, oldContent, newContent string) string {
+	return Compute(oldName, newName, oldContent, newContent, DefaultContext).String()
+}
+
+// Compute diffs two strings line by line, showing context unchanged lines
+// around each hunk
+func Compute(oldName, newName, oldContent, newContent string, context int) *Result {
+	result := &Result{
+		OldName: oldName,
+		NewName: newName,
+		Hunks:   []Hunk{},
+	}
+	if oldContent == newContent {
+		return result
+	}
+	if context < 0 {
+		context = 0
+	}
+
+	ops := diffLines(strings.Split(oldContent, "\n"), strings.Split(newContent, "\n"))
+	result.Changed = true
+
+	// Group ops into hunks separated by more than 2*context unchanged lines
+	i := 0
+	for i < len(ops) {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*context {
+				break
+			}
+			end = run
+		}
+		stop := end + context
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		oldLine, newLine := lineNumbers(ops, start)
+		hunk := Hunk{OldStart: oldLine, NewStart: newLine}
+		for _, o := range ops[start:stop] {
+			line := Line{Content: o.line}
+			switch o.kind {
+			case ' ':
+				line.Kind = LineContext
+				line.OldLine, line.NewLine = oldLine, newLine
+				oldLine++
+				newLine++
+				hunk.OldLines++
+				hunk.NewLines++
+			case '-':
+				line.Kind = LineRemoved
+				line.OldLine = oldLine
+				oldLine++
+				hunk.OldLines++
+				result.Deletions++
+			case '+':
+				line.Kind = LineAdded
+				line.NewLine = newLine
+				newLine++
+				hunk.NewLines++
+				result.Additions++
+			}
+			hunk.Lines = append(hunk.Lines, line)
+		}
+		result.Hunks = append(result.Hunks, hunk)
+
+		i = stop
+	}
+
+	return result
+}
+
+// String renders the result in unified diff format
+func (r *Result) String() string {
+	if !r.Changed {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("--- %s\n", r.OldName))
+	b.WriteString(fmt.Sprintf("+++ %s\n", r.NewName))
+	for _, hunk := range r.Hunks {
+		b.WriteString(fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", hunk.OldStart, hunk.OldLines, hunk.NewStart, hunk.NewLines))
+		for _, line := range hunk.Lines {
+			switch line.Kind {
+			case LineAdded:
+				b.WriteByte('+')
+			case LineRemoved:
+				b.WriteByte('-')
+			default:
+				b.WriteByte(' ')
+			}
+			b.WriteString(line.Content)
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// lineNumbers returns the 1-based old and new line numbers at op index idx
+func lineNumbers(ops []op, idx int) (int, int) {
+	oldLine, newLine := 1, 1
+	for _, o := range ops[:idx] {
+		if o.kind != '+' {
+			oldLine++
+		}
+		if o.kind != '-' {
+			newLine++
+		}
+	}
+	return oldLine, newLine
+}
+
+// diffLines computes a line-level edit script using the longest common
+// subsequence of the lines between the common prefix and suffix
+func diffLines(a, b []string) []op {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]op, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, op{' ', line})
+	}
+	ops = append(ops, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, op{' ', line})
+	}
+	return ops
+}
+
+// diffMiddle computes the LCS edit script for the changed region
+func diffMiddle(a, b []string) []op {
+	n, m := len(a), len(b)
+	ops := make([]op, 0, n+m)
+
+	if (n+1)*(m+1) > maxLCSCells {
+		for _, line := range a {
+			ops = append(ops, op{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, op{'+', line})
+		}
+		return ops
+	}
+
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, op{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, op{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, op{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, op{'-', a[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, op{'+', b[j]})
+	}
+
+	return ops
+}
@@ -0,0 +1,106 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{
+			name: "identical",
+			old:  "a\nb\n",
+			new:  "a\nb\n",
+			want: "",
+		},
+		{
+			name: "changed line",
+			old:  "a\nb\nc",
+			new:  "a\nB\nc",
+			want: "--- v1\n+++ v2\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name: "added lines",
+			old:  "a\nb",
+			new:  "a\nb\nc\nd",
+			want: "--- v1\n+++ v2\n@@ -1,2 +1,4 @@\n a\n b\n+c\n+d\n",
+		},
+		{
+			name: "removed line",
+			old:  "a\nb\nc",
+			new:  "a\nc",
+			want: "--- v1\n+++ v2\n@@ -1,3 +1,2 @@\n a\n-b\n c\n",
+		},
+		{
+			name: "from empty",
+			old:  "",
+			new:  "a",
+			want: "--- v1\n+++ v2\n@@ -1,1 +1,1 @@\n-\n+a\n",
+		},
+		{
+			name: "distant changes make separate hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10",
+			new:  "one\n2\n3\n4\n5\n6\n7\n8\n9\nten",
+			want: "--- v1\n+++ v2\n" +
+				"@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n" +
+				"@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n",
+		},
+		{
+			name: "close changes share a hunk",
+			old:  "1\n2\n3\n4\n5\n6\n7",
+			new:  "one\n2\n3\n4\n5\n6\nseven",
+			want: "--- v1\n+++ v2\n@@ -1,7 +1,7 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n-7\n+seven\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Unified("v1", "v2", tt.old, tt.new); got != tt.want {
+				t.Errorf("Unified() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeCounts(t *testing.T) {
+	tests := []struct {
+		name                 string
+		old, new             string
+		context              int
+		additions, deletions int
+		hunks                int
+	}{
+		{"no changes", "a\nb", "a\nb", 3, 0, 0, 0},
+		{"replacement", "a\nb\nc", "a\nx\ny\nc", 3, 2, 1, 1},
+		{"no context splits hunks", "a\nb\nc", "x\nb\ny", 0, 2, 2, 2},
+		{"negative context is no context", "a\nb\nc", "x\nb\ny", -1, 2, 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Compute("old", "new", tt.old, tt.new, tt.context)
+			if r.Additions != tt.additions || r.Deletions != tt.deletions || len(r.Hunks) != tt.hunks {
+				t.Errorf("got +%d -%d in %d hunks, want +%d -%d in %d hunks",
+					r.Additions, r.Deletions, len(r.Hunks), tt.additions, tt.deletions, tt.hunks)
+			}
+			if r.Changed != (tt.additions+tt.deletions > 0) {
+				t.Errorf("Changed = %v", r.Changed)
+			}
+		})
+	}
+}
+
+func TestComputeLineNumbers(t *testing.T) {
+	r := Compute("old", "new", "a\nb\nc", "a\nc\nd", 3)
+	var got []string
+	for _, line := range r.Hunks[0].Lines {
+		got = append(got, fmt.Sprintf("%s:%s:%d:%d", line.Kind[:1], line.Content, line.OldLine, line.NewLine))
+	}
+	want := "c:a:1:1 r:b:2:0 c:c:3:2 a:d:0:3"
+	if strings.Join(got, " ") != want {
+		t.Errorf("lines = %s, want %s", strings.Join(got, " "), want)
+	}
+}
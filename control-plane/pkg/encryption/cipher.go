@@ -0,0 +1,121 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// ciphertextPrefix marks encrypted column values. The full format is
+// enc:v1:<key id>:<base64url tenant id>:<base64url nonce+ciphertext>.
+const ciphertextPrefix = "enc:v1:"
+
+// Envelope describes an encrypted column value
+type Envelope struct {
+	KeyID    string
+	TenantID string
+	data     []byte
+}
+
+// IsEncrypted reports whether a stored value is an encrypted envelope
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, ciphertextPrefix)
+}
+
+// ParseEnvelope parses an encrypted column value
+func ParseEnvelope(value string) (*Envelope, error) {
+	if !IsEncrypted(value) {
+		return nil, fmt.Errorf("value is not encrypted")
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, ciphertextPrefix), ":", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed encrypted value")
+	}
+
+	tenantID, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed encrypted value: %w", err)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed encrypted value: %w", err)
+	}
+
+	return &Envelope{
+		KeyID:    parts[0],
+		TenantID: string(tenantID),
+		data:     data,
+	}, nil
+}
+
+// Encrypt encrypts plaintext for a tenant with the active master key
+func (k *KeyRing) Encrypt(tenantID string, plaintext []byte) (string, error) {
+	if tenantID == "" {
+		return "", fmt.Errorf("tenant ID is required for encryption")
+	}
+
+	aead, err := k.aead(k.activeID, tenantID)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	// The tenant ID is authenticated so envelopes cannot be relabelled
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(tenantID))
+
+	return ciphertextPrefix + k.activeID + ":" +
+		base64.RawURLEncoding.EncodeToString([]byte(tenantID)) + ":" +
+		base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts an encrypted column value
+func (k *KeyRing) Decrypt(value string) ([]byte, *Envelope, error) {
+	env, err := ParseEnvelope(value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aead, err := k.aead(env.KeyID, env.TenantID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(env.data) < aead.NonceSize() {
+		return nil, nil, fmt.Errorf("malformed encrypted value")
+	}
+	nonce, sealed := env.data[:aead.NonceSize()], env.data[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, sealed, []byte(env.TenantID))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decrypt value: %w", err)
+	}
+
+	return plaintext, env, nil
+}
+
+// aead returns the AES-GCM cipher for a master key and tenant
+func (k *KeyRing) aead(keyID, tenantID string) (cipher.AEAD, error) {
+	key, err := k.tenantKey(keyID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return aead, nil
+}
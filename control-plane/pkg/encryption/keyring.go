@@ -0,0 +1,172 @@
+// Package encryption provides field-level encryption of sensitive columns
+// with per-tenant keys derived from versioned master keys.
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// MasterKeySize is the required master key length in bytes
+const MasterKeySize = 32
+
+// keyIDPattern restricts key IDs so they can be embedded in ciphertexts
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// Config contains encryption configuration
+type Config struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+
+	// ActiveKey is the ID of the master key used for new writes
+	ActiveKey string `json:"active_key" yaml:"active_key"`
+
+	// Keys maps key IDs to base64-encoded 32-byte master keys. Retired keys
+	// stay listed until rotate-keys has re-encrypted all data.
+	Keys map[string]string `json:"keys" yaml:"keys"`
+
+	// KeyCommand optionally fetches master keys from an external KMS. It must
+	// print one "<id>=<base64 key>" line per key; these override Keys.
+	KeyCommand []string `json:"key_command" yaml:"key_command"`
+
+	// KeyCommandTimeout bounds the key command
+	KeyCommandTimeout time.Duration `json:"key_command_timeout" yaml:"key_command_timeout"`
+}
+
+// KeyRing holds the master keys used to derive per-tenant data keys
+type KeyRing struct {
+	activeID string
+	keys     map[string][]byte
+}
+
+// NewKeyRing creates a key ring from raw master keys
+func NewKeyRing(activeID string, keys map[string][]byte) (*KeyRing, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no master keys configured")
+	}
+	for id, key := range keys {
+		if !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid master key id %q", id)
+		}
+		if len(key) != MasterKeySize {
+			return nil, fmt.Errorf("master key %q must be %d bytes, got %d", id, MasterKeySize, len(key))
+		}
+	}
+	if _, ok := keys[activeID]; !ok {
+		return nil, fmt.Errorf("active key %q is not configured", activeID)
+	}
+
+	return &KeyRing{
+		activeID: activeID,
+		keys:     keys,
+	}, nil
+}
+
+// LoadKeyRing builds the key ring described by the config. It returns nil
+// without error when encryption is disabled.
+func LoadKeyRing(ctx context.Context, cfg *Config) (*KeyRing, error) {
+	if cfg == nil || !cfg.Enabled {
+		return nil, nil
+	}
+
+	keys := make(map[string][]byte)
+	for id, encoded := range cfg.Keys {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode master key %q: %w", id, err)
+		}
+		keys[id] = key
+	}
+
+	if len(cfg.KeyCommand) > 0 {
+		fetched, err := runKeyCommand(ctx, cfg.KeyCommand, cfg.KeyCommandTimeout)
+		if err != nil {
+			return nil, err
+		}
+		for id, key := range fetched {
+			keys[id] = key
+		}
+	}
+
+	return NewKeyRing(cfg.ActiveKey, keys)
+}
+
+// runKeyCommand runs the configured KMS command and parses its key lines
+func runKeyCommand(ctx context.Context, command []string, timeout time.Duration) (map[string][]byte, error) {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("key command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseKeyLines(bytes.NewReader(output))
+}
+
+// parseKeyLines parses "<id>=<base64 key>" lines, ignoring blanks and comments
+func parseKeyLines(r io.Reader) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid key command output: expected <id>=<key>")
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode master key %q: %w", id, err)
+		}
+		keys[strings.TrimSpace(id)] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read key command output: %w", err)
+	}
+	return keys, nil
+}
+
+// ActiveKeyID returns the ID of the key used for new writes
+func (k *KeyRing) ActiveKeyID() string {
+	return k.activeID
+}
+
+// HasKey reports whether a master key is available
+func (k *KeyRing) HasKey(id string) bool {
+	_, ok := k.keys[id]
+	return ok
+}
+
+// tenantKey derives the AES-256 data key for a tenant with HKDF-SHA256
+func (k *KeyRing) tenantKey(keyID, tenantID string) ([]byte, error) {
+	master, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("master key %q is not configured", keyID)
+	}
+
+	key := make([]byte, 32)
+	reader := hkdf.New(sha256.New, master, nil, []byte("vm-manager/tenant/"+tenantID))
+	if _, err := io.ReadFull(reader, key); err != nil {
+		return nil, fmt.Errorf("failed to derive tenant key: %w", err)
+	}
+	return key, nil
+}
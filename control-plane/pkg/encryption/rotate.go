@@ -0,0 +1,162 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Column identifies an encrypted column. Keep in sync with the
+// serializer:encrypted tags on the models.
+type Column struct {
+	Table        string
+	TenantColumn string
+	Column       string
+	// BatchSize caps the rows read per batch for columns holding large values
+	BatchSize int
+}
+
+// Columns lists all encrypted columns
+var Columns = []Column{
+	{Table: "tenants", TenantColumn: "id", Column: "settings"},
+	{Table: "templates", TenantColumn: "tenant_id", Column: "content"},
+	{Table: "template_versions", TenantColumn: "tenant_id", Column: "content"},
+	{Table: "agents", TenantColumn: "tenant_id", Column: "dispatch_key"},
+	{Table: "git_repositories", TenantColumn: "tenant_id", Column: "deploy_key"},
+	{Table: "shell_recording_chunks", TenantColumn: "tenant_id", Column: "events"},
+	{Table: "template_chunks", TenantColumn: "tenant_id", Column: "data", BatchSize: 16},
+	{Table: "tenant_databases", TenantColumn: "tenant_id", Column: "dsn"},
+}
+
+// DefaultRotationBatchSize is the number of rows re-encrypted per batch
+const DefaultRotationBatchSize = 500
+
+// ColumnRotation reports re-encryption of one column
+type ColumnRotation struct {
+	Table     string `json:"table"`
+	Column    string `json:"column"`
+	Scanned   int    `json:"scanned"`
+	Rotated   int    `json:"rotated"`
+	Plaintext int    `json:"plaintext"`
+}
+
+// RotationReport summarizes a key rotation run
+type RotationReport struct {
+	ActiveKey string           `json:"active_key"`
+	DryRun    bool             `json:"dry_run"`
+	Columns   []ColumnRotation `json:"columns"`
+}
+
+// rotationRow is a row of an encrypted column
+type rotationRow struct {
+	ID       string
+	TenantID string
+	Value    *string
+}
+
+// Rotate re-encrypts every encrypted column with the active key. Plaintext
+// values written before encryption was enabled are encrypted as well. With
+// dryRun, rows are counted but not written.
+func Rotate(ctx context.Context, db *gorm.DB, k *KeyRing, batchSize int, dryRun bool, logger *zap.Logger) (*RotationReport, error) {
+	if k == nil {
+		return nil, fmt.Errorf("encryption is not configured")
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultRotationBatchSize
+	}
+
+	report := &RotationReport{
+		ActiveKey: k.ActiveKeyID(),
+		DryRun:    dryRun,
+	}
+
+	for _, col := range Columns {
+		result, err := rotateColumn(ctx, db, k, col, batchSize, dryRun)
+		if err != nil {
+			return report, fmt.Errorf("failed to rotate %s.%s: %w", col.Table, col.Column, err)
+		}
+		report.Columns = append(report.Columns, *result)
+
+		logger.Info("rotated encrypted column",
+			zap.String("table", col.Table),
+			zap.String("column", col.Column),
+			zap.Int("scanned", result.Scanned),
+			zap.Int("rotated", result.Rotated),
+			zap.Int("plaintext", result.Plaintext),
+			zap.Bool("dry_run", dryRun))
+	}
+
+	return report, nil
+}
+
+// rotateColumn re-encrypts one column in primary key order
+func rotateColumn(ctx context.Context, db *gorm.DB, k *KeyRing, col Column, batchSize int, dryRun bool) (*ColumnRotation, error) {
+	result := &ColumnRotation{
+		Table:  col.Table,
+		Column: col.Column,
+	}
+	if col.BatchSize > 0 && col.BatchSize < batchSize {
+		batchSize = col.BatchSize
+	}
+
+	lastID := ""
+	for {
+		var rows []rotationRow
+		err := db.WithContext(ctx).Table(col.Table).
+			Select(fmt.Sprintf("id AS id, %s AS tenant_id, %s AS value", col.TenantColumn, col.Column)).
+			Where("id > ?", lastID).
+			Order("id ASC").
+			Limit(batchSize).
+			Scan(&rows).Error
+		if err != nil {
+			return result, fmt.Errorf("failed to read rows: %w", err)
+		}
+		if len(rows) == 0 {
+			return result, nil
+		}
+
+		for _, row := range rows {
+			lastID = row.ID
+			result.Scanned++
+			if row.Value == nil {
+				continue
+			}
+
+			var plaintext []byte
+			if IsEncrypted(*row.Value) {
+				env, err := ParseEnvelope(*row.Value)
+				if err != nil {
+					return result, fmt.Errorf("row %s: %w", row.ID, err)
+				}
+				if env.TenantID != row.TenantID {
+					return result, fmt.Errorf("row %s: value belongs to a different tenant", row.ID)
+				}
+				if env.KeyID == k.ActiveKeyID() {
+					continue
+				}
+				if plaintext, _, err = k.Decrypt(*row.Value); err != nil {
+					return result, fmt.Errorf("row %s: %w", row.ID, err)
+				}
+			} else {
+				plaintext = []byte(*row.Value)
+				result.Plaintext++
+			}
+
+			result.Rotated++
+			if dryRun {
+				continue
+			}
+
+			sealed, err := k.Encrypt(row.TenantID, plaintext)
+			if err != nil {
+				return result, fmt.Errorf("row %s: %w", row.ID, err)
+			}
+			if err := db.WithContext(ctx).Table(col.Table).Where("id = ?", row.ID).
+				UpdateColumn(col.Column, sealed).Error; err != nil {
+				return result, fmt.Errorf("failed to update row %s: %w", row.ID, err)
+			}
+		}
+	}
+}
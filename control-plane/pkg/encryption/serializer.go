@@ -0,0 +1,163 @@
+package encryption
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm/schema"
+)
+
+// SerializerName is the GORM serializer for encrypted columns, used as
+// `gorm:"serializer:encrypted"`. The tenant key is taken from the model's
+// TenantID field, or its primary key for the tenants table itself.
+const SerializerName = "encrypted"
+
+func init() {
+	schema.RegisterSerializer(SerializerName, Serializer{})
+}
+
+var (
+	keyRingMu sync.RWMutex
+	keyRing   *KeyRing
+)
+
+// SetKeyRing installs the key ring used by the serializer. With no key ring,
+// values are written in plaintext and encrypted values cannot be read.
+func SetKeyRing(k *KeyRing) {
+	keyRingMu.Lock()
+	defer keyRingMu.Unlock()
+	keyRing = k
+}
+
+// CurrentKeyRing returns the installed key ring, or nil if encryption is disabled
+func CurrentKeyRing() *KeyRing {
+	keyRingMu.RLock()
+	defer keyRingMu.RUnlock()
+	return keyRing
+}
+
+// Seal converts a column value to its stored form. Strings are stored as-is
+// and other values as JSON, encrypted for the tenant when a key ring is set.
+// Use it for map-based updates, which bypass GORM serializers.
+func Seal(tenantID string, value interface{}) (interface{}, error) {
+	var plaintext []byte
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		plaintext = []byte(v)
+	case *string:
+		if v == nil {
+			return nil, nil
+		}
+		plaintext = []byte(*v)
+	default:
+		rv := reflect.ValueOf(value)
+		if (rv.Kind() == reflect.Map || rv.Kind() == reflect.Slice || rv.Kind() == reflect.Ptr) && rv.IsNil() {
+			return nil, nil
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode value: %w", err)
+		}
+		plaintext = data
+	}
+
+	k := CurrentKeyRing()
+	if k == nil {
+		return string(plaintext), nil
+	}
+	return k.Encrypt(tenantID, plaintext)
+}
+
+// Open converts a stored column value back to plaintext. Values written
+// before encryption was enabled are returned unchanged.
+func Open(stored string) ([]byte, *Envelope, error) {
+	if !IsEncrypted(stored) {
+		return []byte(stored), nil, nil
+	}
+
+	k := CurrentKeyRing()
+	if k == nil {
+		return nil, nil, fmt.Errorf("value is encrypted but encryption is not configured")
+	}
+	return k.Decrypt(stored)
+}
+
+// Serializer is the GORM serializer for encrypted columns
+type Serializer struct{}
+
+// Scan implements schema.SerializerInterface
+func (Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	if dbValue == nil {
+		return nil
+	}
+
+	var stored string
+	switch v := dbValue.(type) {
+	case []byte:
+		stored = string(v)
+	case string:
+		stored = v
+	default:
+		return fmt.Errorf("failed to decrypt %s: unsupported value type %T", field.Name, dbValue)
+	}
+
+	plaintext, env, err := Open(stored)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s: %w", field.Name, err)
+	}
+
+	// Reject envelopes copied from another tenant's rows when the owner is known
+	if env != nil {
+		if tenantID := tenantIDOf(ctx, field, dst); tenantID != "" && tenantID != env.TenantID {
+			return fmt.Errorf("failed to decrypt %s: value belongs to a different tenant", field.Name)
+		}
+	}
+
+	fieldValue := reflect.New(field.FieldType)
+	if field.FieldType.Kind() == reflect.String {
+		fieldValue.Elem().SetString(string(plaintext))
+	} else if len(plaintext) > 0 {
+		if err := json.Unmarshal(plaintext, fieldValue.Interface()); err != nil {
+			return fmt.Errorf("failed to decode %s: %w", field.Name, err)
+		}
+	}
+
+	field.ReflectValueOf(ctx, dst).Set(fieldValue.Elem())
+	return nil
+}
+
+// Value implements schema.SerializerInterface
+func (Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	tenantID := tenantIDOf(ctx, field, dst)
+	if tenantID == "" && CurrentKeyRing() != nil {
+		return nil, fmt.Errorf("failed to encrypt %s: tenant ID is not set", field.Name)
+	}
+	return Seal(tenantID, fieldValue)
+}
+
+// tenantIDOf returns the owning tenant of the model value being serialized
+func tenantIDOf(ctx context.Context, field *schema.Field, dst reflect.Value) string {
+	if field.Schema == nil || !dst.IsValid() {
+		return ""
+	}
+
+	owner := field.Schema.LookUpField("TenantID")
+	if owner == nil {
+		owner = field.Schema.PrioritizedPrimaryField
+	}
+	if owner == nil {
+		return ""
+	}
+
+	value, zero := owner.ValueOf(ctx, dst)
+	if zero {
+		return ""
+	}
+	tenantID, _ := value.(string)
+	return tenantID
+}
@@ -0,0 +1,249 @@
+package gitsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Limits on what a sync reads from a repository
+const (
+	maxResourceFiles = 1000
+	maxFileSize      = 1 << 20
+)
+
+// Resource kinds a file can define
+const (
+	KindWorkflow = "workflow"
+	KindTemplate = "template"
+)
+
+// resourceFile is a workflow or template defined in a repository. Files
+// whose kind is neither are ignored, so the synced directory can hold other
+// YAML files.
+type resourceFile struct {
+	Kind        string                 `yaml:"kind"`
+	Name        string                 `yaml:"name"`
+	Description string                 `yaml:"description"`
+	Status      string                 `yaml:"status"`
+	Tags        map[string]interface{} `yaml:"tags"`
+
+	// Definition is a workflow's definition
+	Definition map[string]interface{} `yaml:"definition"`
+
+	// Content or ContentFile, a path relative to this file, is a template's
+	// content
+	Content     string                 `yaml:"content"`
+	ContentFile string                 `yaml:"content_file"`
+	ContentType string                 `yaml:"content_type"`
+	Metadata    map[string]interface{} `yaml:"metadata"`
+}
+
+// loadedFile is a resource file read from the checkout. Path is relative
+// to the repository root; Err is set if the file is invalid.
+type loadedFile struct {
+	Path     string
+	Resource *resourceFile
+	Err      error
+}
+
+// loadResources reads the resource files under dir, relative to the
+// checkout root, in path order
+func loadResources(root, dir string) ([]loadedFile, error) {
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve checkout: %w", err)
+	}
+	base := filepath.Join(root, filepath.FromSlash(dir))
+	info, err := os.Stat(base)
+	if err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("path %q is not a directory in the repository", dir)
+	}
+
+	var files []loadedFile
+	err = filepath.WalkDir(base, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Symlinks could point outside the checkout
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(p))
+		if ext != ".yaml" && ext != ".yml" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		file, ok := loadResource(root, filepath.ToSlash(rel))
+		if !ok {
+			return nil
+		}
+		if len(files) == maxResourceFiles {
+			return fmt.Errorf("repository has more than %d resource files", maxResourceFiles)
+		}
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// loadResource reads one YAML file; ok is false if it does not define a
+// workflow or template
+func loadResource(root, rel string) (loadedFile, bool) {
+	file := loadedFile{Path: rel}
+	data, err := readFile(root, rel)
+	if err != nil {
+		file.Err = err
+		return file, true
+	}
+
+	var header struct {
+		Kind string `yaml:"kind"`
+	}
+	if err := yaml.Unmarshal(data, &header); err != nil {
+		// Not knowing the kind, the file is reported rather than ignored
+		file.Err = fmt.Errorf("invalid YAML: %w", err)
+		return file, true
+	}
+	if header.Kind != KindWorkflow && header.Kind != KindTemplate {
+		return file, false
+	}
+
+	var resource resourceFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&resource); err != nil {
+		file.Err = fmt.Errorf("invalid %s file: %w", header.Kind, err)
+		return file, true
+	}
+	file.Resource = &resource
+
+	if err := resource.normalize(root, rel); err != nil {
+		file.Err = err
+	}
+	return file, true
+}
+
+// normalize checks the fields of a resource's kind and converts its maps
+// to the JSON types they are stored as, so they compare equal to stored
+// values
+func (r *resourceFile) normalize(root, rel string) error {
+	var err error
+	if r.Tags, err = toJSONMap(r.Tags); err != nil {
+		return fmt.Errorf("invalid tags: %w", err)
+	}
+
+	switch r.Kind {
+	case KindWorkflow:
+		if r.Definition == nil {
+			return fmt.Errorf("definition is required")
+		}
+		if r.Content != "" || r.ContentFile != "" || r.ContentType != "" || r.Metadata != nil {
+			return fmt.Errorf("content, content_file, content_type and metadata are template fields")
+		}
+		if r.Definition, err = toJSONMap(r.Definition); err != nil {
+			return fmt.Errorf("invalid definition: %w", err)
+		}
+	case KindTemplate:
+		if r.Definition != nil {
+			return fmt.Errorf("definition is a workflow field")
+		}
+		if (r.Content == "") == (r.ContentFile == "") {
+			return fmt.Errorf("exactly one of content and content_file is required")
+		}
+		if r.ContentFile != "" {
+			contentPath := path.Join(path.Dir(rel), r.ContentFile)
+			if path.IsAbs(r.ContentFile) || contentPath == ".." || strings.HasPrefix(contentPath, "../") {
+				return fmt.Errorf("content_file %q is outside the repository", r.ContentFile)
+			}
+			data, err := readFile(root, contentPath)
+			if err != nil {
+				return fmt.Errorf("content_file: %w", err)
+			}
+			r.Content = string(data)
+		}
+		if r.Metadata, err = toJSONMap(r.Metadata); err != nil {
+			return fmt.Errorf("invalid metadata: %w", err)
+		}
+	}
+	return nil
+}
+
+// workflowTags converts a workflow's tags, which are strings
+func (r *resourceFile) workflowTags() (map[string]string, error) {
+	if r.Tags == nil {
+		return nil, nil
+	}
+	tags := make(map[string]string, len(r.Tags))
+	for key, value := range r.Tags {
+		switch v := value.(type) {
+		case string:
+			tags[key] = v
+		case float64, bool:
+			tags[key] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("invalid tags: %s must be a string", key)
+		}
+	}
+	return tags, nil
+}
+
+// readFile reads a regular file of the checkout, refusing files symlinked
+// from outside it and files over maxFileSize. root must have its symlinks
+// resolved.
+func readFile(root, rel string) ([]byte, error) {
+	p, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		return nil, fmt.Errorf("%s not found", rel)
+	}
+	if !strings.HasPrefix(p, root+string(filepath.Separator)) {
+		return nil, fmt.Errorf("%s is outside the repository", rel)
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, fmt.Errorf("%s not found", rel)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", rel)
+	}
+	if info.Size() > maxFileSize {
+		return nil, fmt.Errorf("%s exceeds %d bytes", rel, maxFileSize)
+	}
+	return os.ReadFile(p)
+}
+
+// toJSONMap round-trips a YAML map through JSON
+func toJSONMap(m map[string]interface{}) (map[string]interface{}, error) {
+	if m == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
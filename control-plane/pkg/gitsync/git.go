@@ -0,0 +1,138 @@
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// maxGitOutput bounds the git error output kept in sync errors
+const maxGitOutput = 4096
+
+// workspace is a temporary directory holding a repository's clone and the
+// SSH files git is run with
+type workspace struct {
+	dir string
+	env []string
+}
+
+// newWorkspace creates a workspace for a repository. The deploy key and
+// known hosts are written with owner-only permissions and removed with it.
+func newWorkspace(workDir string, repo *models.GitRepository) (*workspace, error) {
+	dir, err := os.MkdirTemp(workDir, "gitsync-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+	w := &workspace{dir: dir}
+
+	knownHosts := filepath.Join(dir, "known_hosts")
+	if err := os.WriteFile(knownHosts, []byte(repo.KnownHosts), 0600); err != nil {
+		w.remove()
+		return nil, fmt.Errorf("failed to write known hosts: %w", err)
+	}
+	// Without pinned host keys the server's key is accepted on first use,
+	// and every sync starts with an empty known_hosts
+	hostKeyChecking := "accept-new"
+	if strings.TrimSpace(repo.KnownHosts) != "" {
+		hostKeyChecking = "yes"
+	}
+	sshCommand := fmt.Sprintf("ssh -o BatchMode=yes -o UserKnownHostsFile=%s -o StrictHostKeyChecking=%s",
+		shellQuote(knownHosts), hostKeyChecking)
+	if repo.DeployKey != "" {
+		key := filepath.Join(dir, "deploy_key")
+		if err := os.WriteFile(key, []byte(strings.TrimRight(repo.DeployKey, "\n")+"\n"), 0600); err != nil {
+			w.remove()
+			return nil, fmt.Errorf("failed to write deploy key: %w", err)
+		}
+		sshCommand += fmt.Sprintf(" -o IdentitiesOnly=yes -i %s", shellQuote(key))
+	}
+
+	// Run git isolated from the host's configuration and credentials, and
+	// only over network transports
+	w.env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + dir,
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_ALLOW_PROTOCOL=https:ssh",
+		"GIT_SSH_COMMAND=" + sshCommand,
+	}
+	return w, nil
+}
+
+// remove deletes the workspace
+func (w *workspace) remove() {
+	os.RemoveAll(w.dir)
+}
+
+// checkoutDir is where the repository is cloned
+func (w *workspace) checkoutDir() string {
+	return filepath.Join(w.dir, "checkout")
+}
+
+// remoteCommit returns the commit a branch points to without cloning
+func (w *workspace) remoteCommit(ctx context.Context, url, branch string) (string, error) {
+	out, err := w.git(ctx, "", "ls-remote", "--heads", "--", url, "refs/heads/"+branch)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == "refs/heads/"+branch {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("branch %s not found", branch)
+}
+
+// clone makes a shallow clone of a branch and returns its commit
+func (w *workspace) clone(ctx context.Context, url, branch string) (string, error) {
+	if _, err := w.git(ctx, "", "clone", "--quiet", "--depth", "1", "--single-branch", "--no-tags",
+		"--branch", branch, "--", url, w.checkoutDir()); err != nil {
+		return "", err
+	}
+	out, err := w.git(ctx, w.checkoutDir(), "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// git runs a git command and returns its standard output
+func (w *workspace) git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = w.env
+	// ssh may outlive a killed git and hold the output pipes open
+	cmd.WaitDelay = 5 * time.Second
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("git %s: %w", args[0], ctx.Err())
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > maxGitOutput {
+			msg = msg[:maxGitOutput] + "... (truncated)"
+		}
+		if msg == "" {
+			return "", fmt.Errorf("git %s: %w", args[0], err)
+		}
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, msg)
+	}
+	return stdout.String(), nil
+}
+
+// shellQuote quotes a path for GIT_SSH_COMMAND, which git runs with the shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
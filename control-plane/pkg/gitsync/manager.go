@@ -0,0 +1,409 @@
+// Package gitsync syncs workflows and templates from tenants' Git
+// repositories into the control plane.
+package gitsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/template"
+	"github.com/yourorg/control-plane/pkg/workflow"
+)
+
+// Config configures repository syncing
+type Config struct {
+	// Interval is how often each repository is synced
+	Interval time.Duration `json:"interval" yaml:"interval"`
+	// Timeout bounds fetching a repository
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+	// WorkDir is where repositories are cloned; it defaults to the system
+	// temporary directory
+	WorkDir string `json:"work_dir" yaml:"work_dir"`
+}
+
+// DefaultConfig returns the default sync configuration
+func DefaultConfig() *Config {
+	return &Config{
+		Interval: 5 * time.Minute,
+		Timeout:  2 * time.Minute,
+	}
+}
+
+// Manager manages tenants' Git repositories and syncs them
+type Manager struct {
+	db        *gorm.DB
+	workflows *workflow.Manager
+	templates *template.Manager
+	config    *Config
+	logger    *zap.Logger
+}
+
+// NewManager creates a Git repository manager
+func NewManager(db *gorm.DB, workflows *workflow.Manager, templates *template.Manager, config *Config, logger *zap.Logger) *Manager {
+	defaults := DefaultConfig()
+	cfg := *config
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaults.Interval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaults.Timeout
+	}
+	return &Manager{
+		db:        db,
+		workflows: workflows,
+		templates: templates,
+		config:    &cfg,
+		logger:    logger,
+	}
+}
+
+// CreateRepositoryRequest represents a request to register a Git repository
+type CreateRepositoryRequest struct {
+	TenantID string `json:"tenant_id"`
+	Name     string `json:"name" binding:"required"`
+	// URL is an https:// or ssh:// URL, or an scp-like user@host:path
+	URL string `json:"url" binding:"required"`
+	// Branch defaults to main
+	Branch string `json:"branch"`
+	Path   string `json:"path"`
+	// DeployKey is an unencrypted SSH private key with read access to the
+	// repository; KnownHosts holds known_hosts lines for the server
+	DeployKey  string `json:"deploy_key"`
+	KnownHosts string `json:"known_hosts"`
+	// Enabled defaults to true
+	Enabled   *bool  `json:"enabled"`
+	CreatedBy string `json:"created_by"`
+}
+
+// Create registers a Git repository. It is synced on the next pass of the
+// sync loop.
+func (m *Manager) Create(ctx context.Context, req *CreateRepositoryRequest) (*models.GitRepository, error) {
+	repo := &models.GitRepository{
+		ID:         uuid.New().String(),
+		TenantID:   req.TenantID,
+		Name:       strings.TrimSpace(req.Name),
+		URL:        strings.TrimSpace(req.URL),
+		Branch:     strings.TrimSpace(req.Branch),
+		Path:       req.Path,
+		DeployKey:  req.DeployKey,
+		KnownHosts: req.KnownHosts,
+		Enabled:    req.Enabled == nil || *req.Enabled,
+		CreatedBy:  req.CreatedBy,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	if repo.Branch == "" {
+		repo.Branch = "main"
+	}
+	if err := m.validate(ctx, repo); err != nil {
+		return nil, err
+	}
+
+	if err := m.db.WithContext(ctx).Create(repo).Error; err != nil {
+		return nil, fmt.Errorf("failed to create Git repository: %w", err)
+	}
+
+	m.logger.Info("Git repository registered",
+		zap.String("repository_id", repo.ID),
+		zap.String("tenant_id", repo.TenantID),
+		zap.String("url", repo.URL),
+		zap.String("branch", repo.Branch))
+
+	return repo, nil
+}
+
+// Get retrieves a Git repository by ID
+func (m *Manager) Get(ctx context.Context, tenantID, repositoryID string) (*models.GitRepository, error) {
+	var repo models.GitRepository
+	if err := m.db.WithContext(ctx).Where("id = ? AND tenant_id = ?", repositoryID, tenantID).First(&repo).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("Git repository not found")
+		}
+		return nil, fmt.Errorf("failed to get Git repository: %w", err)
+	}
+	return &repo, nil
+}
+
+// List lists a tenant's Git repositories
+func (m *Manager) List(ctx context.Context, tenantID string) ([]models.GitRepository, error) {
+	var repos []models.GitRepository
+	if err := m.db.WithContext(ctx).Where("tenant_id = ?", tenantID).
+		Order("name ASC").
+		Find(&repos).Error; err != nil {
+		return nil, fmt.Errorf("failed to list Git repositories: %w", err)
+	}
+	return repos, nil
+}
+
+// UpdateRepositoryRequest represents a request to update a Git repository.
+// An empty DeployKey removes the key.
+type UpdateRepositoryRequest struct {
+	Name       *string `json:"name"`
+	URL        *string `json:"url"`
+	Branch     *string `json:"branch"`
+	Path       *string `json:"path"`
+	DeployKey  *string `json:"deploy_key"`
+	KnownHosts *string `json:"known_hosts"`
+	Enabled    *bool   `json:"enabled"`
+}
+
+// Update updates a Git repository. Changing where files are read from
+// schedules an immediate sync.
+func (m *Manager) Update(ctx context.Context, tenantID, repositoryID string, req *UpdateRepositoryRequest) (*models.GitRepository, error) {
+	repo, err := m.Get(ctx, tenantID, repositoryID)
+	if err != nil {
+		return nil, err
+	}
+	original := *repo
+
+	if req.Name != nil {
+		repo.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.URL != nil {
+		repo.URL = strings.TrimSpace(*req.URL)
+	}
+	if req.Branch != nil {
+		repo.Branch = strings.TrimSpace(*req.Branch)
+	}
+	if req.Path != nil {
+		repo.Path = *req.Path
+	}
+	if req.DeployKey != nil {
+		repo.DeployKey = *req.DeployKey
+	}
+	if req.KnownHosts != nil {
+		repo.KnownHosts = *req.KnownHosts
+	}
+	if req.Enabled != nil {
+		repo.Enabled = *req.Enabled
+	}
+	if err := m.validate(ctx, repo); err != nil {
+		return nil, err
+	}
+
+	resync := repo.URL != original.URL || repo.Branch != original.Branch || repo.Path != original.Path ||
+		repo.DeployKey != original.DeployKey || repo.KnownHosts != original.KnownHosts
+	if resync {
+		repo.LastSyncAt = nil
+		repo.LastCommit = ""
+	}
+	repo.UpdatedAt = time.Now()
+
+	// A struct update, unlike a map, goes through the encrypted serializer
+	if err := m.db.WithContext(ctx).Model(repo).
+		Select("name", "url", "branch", "path", "deploy_key", "deploy_key_fingerprint", "known_hosts",
+			"enabled", "last_sync_at", "last_commit", "updated_at").
+		Updates(repo).Error; err != nil {
+		return nil, fmt.Errorf("failed to update Git repository: %w", err)
+	}
+
+	m.logger.Info("Git repository updated",
+		zap.String("repository_id", repo.ID),
+		zap.String("tenant_id", tenantID),
+		zap.Bool("resync", resync))
+
+	return m.Get(ctx, tenantID, repositoryID)
+}
+
+// Delete removes a Git repository. The workflows and templates synced from
+// it are kept and can be edited through the API again.
+func (m *Manager) Delete(ctx context.Context, tenantID, repositoryID string) error {
+	if _, err := m.Get(ctx, tenantID, repositoryID); err != nil {
+		return err
+	}
+
+	release := map[string]interface{}{
+		"source_repository_id": nil,
+		"source_path":          "",
+		"source_commit":        "",
+	}
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Workflow{}).
+			Where("tenant_id = ? AND source_repository_id = ?", tenantID, repositoryID).
+			Updates(release).Error; err != nil {
+			return fmt.Errorf("failed to release synced workflows: %w", err)
+		}
+		if err := tx.Model(&models.Template{}).
+			Where("tenant_id = ? AND source_repository_id = ?", tenantID, repositoryID).
+			Updates(release).Error; err != nil {
+			return fmt.Errorf("failed to release synced templates: %w", err)
+		}
+		if err := tx.Where("id = ? AND tenant_id = ?", repositoryID, tenantID).
+			Delete(&models.GitRepository{}).Error; err != nil {
+			return fmt.Errorf("failed to delete Git repository: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.logger.Info("Git repository deleted",
+		zap.String("repository_id", repositoryID),
+		zap.String("tenant_id", tenantID))
+
+	return nil
+}
+
+// RequestSync schedules a repository for the next pass of the sync loop
+func (m *Manager) RequestSync(ctx context.Context, tenantID, repositoryID string) (*models.GitRepository, error) {
+	repo, err := m.Get(ctx, tenantID, repositoryID)
+	if err != nil {
+		return nil, err
+	}
+	if !repo.Enabled {
+		return nil, apperror.InvalidState("Git repository %s is disabled", repo.Name)
+	}
+
+	if err := m.db.WithContext(ctx).Model(&models.GitRepository{}).
+		Where("id = ?", repo.ID).
+		Update("last_sync_at", nil).Error; err != nil {
+		return nil, fmt.Errorf("failed to schedule sync: %w", err)
+	}
+	repo.LastSyncAt = nil
+	return repo, nil
+}
+
+var (
+	// scpLikeURL matches the user@host:path form understood by git and ssh
+	scpLikeURL = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:[A-Za-z0-9._~/-]+$`)
+	// branchPattern restricts branch names to characters safe on a command line
+	branchPattern = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+)
+
+// validate checks a repository about to be saved and derives the deploy
+// key's fingerprint
+func (m *Manager) validate(ctx context.Context, repo *models.GitRepository) error {
+	if repo.Name == "" {
+		return apperror.InvalidInput("name is required")
+	}
+	var count int64
+	if err := m.db.WithContext(ctx).Model(&models.GitRepository{}).
+		Where("tenant_id = ? AND name = ? AND id != ?", repo.TenantID, repo.Name, repo.ID).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check Git repository name: %w", err)
+	}
+	if count > 0 {
+		return apperror.Conflict("a Git repository named %q already exists", repo.Name)
+	}
+
+	sshURL, err := validateURL(repo.URL)
+	if err != nil {
+		return err
+	}
+	if err := validateBranch(repo.Branch); err != nil {
+		return err
+	}
+	cleaned, err := cleanPath(repo.Path)
+	if err != nil {
+		return err
+	}
+	repo.Path = cleaned
+
+	repo.DeployKeyFingerprint = ""
+	if repo.DeployKey != "" {
+		if !sshURL {
+			return apperror.InvalidInput("a deploy key requires an SSH repository URL")
+		}
+		signer, err := ssh.ParsePrivateKey([]byte(repo.DeployKey))
+		if err != nil {
+			var missing *ssh.PassphraseMissingError
+			if errors.As(err, &missing) {
+				return apperror.InvalidInput("deploy key must not be protected by a passphrase")
+			}
+			return apperror.InvalidInput("invalid deploy key: %v", err)
+		}
+		repo.DeployKeyFingerprint = ssh.FingerprintSHA256(signer.PublicKey())
+	}
+	if err := validateKnownHosts(repo.KnownHosts); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateURL checks that a repository URL uses a network transport git
+// may be pointed at and reports whether it uses SSH
+func validateURL(raw string) (bool, error) {
+	if raw == "" {
+		return false, apperror.InvalidInput("url is required")
+	}
+	if strings.HasPrefix(raw, "-") {
+		return false, apperror.InvalidInput("invalid repository URL")
+	}
+	if scpLikeURL.MatchString(raw) {
+		return true, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return false, apperror.InvalidInput("url must be an https:// or ssh:// URL, or user@host:path")
+	}
+	switch u.Scheme {
+	case "https":
+		if _, hasPassword := u.User.Password(); hasPassword {
+			return false, apperror.InvalidInput("url must not contain credentials; use an SSH URL with a deploy key")
+		}
+		return false, nil
+	case "ssh":
+		return true, nil
+	default:
+		return false, apperror.InvalidInput("url must be an https:// or ssh:// URL, or user@host:path")
+	}
+}
+
+// validateBranch checks a branch name
+func validateBranch(branch string) error {
+	if !branchPattern.MatchString(branch) || strings.HasPrefix(branch, "-") || strings.HasPrefix(branch, "/") ||
+		strings.HasSuffix(branch, "/") || strings.HasSuffix(branch, ".lock") ||
+		strings.Contains(branch, "..") || strings.Contains(branch, "//") {
+		return apperror.InvalidInput("invalid branch %q", branch)
+	}
+	return nil
+}
+
+// cleanPath normalizes the directory files are read from, which must stay
+// inside the repository
+func cleanPath(p string) (string, error) {
+	p = strings.Trim(strings.TrimSpace(p), "/")
+	if p == "" {
+		return "", nil
+	}
+	cleaned := path.Clean(p)
+	if cleaned == "." {
+		return "", nil
+	}
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") || cleaned == ".git" || strings.HasPrefix(cleaned, ".git/") {
+		return "", apperror.InvalidInput("invalid path %q: must be a directory inside the repository", p)
+	}
+	return cleaned, nil
+}
+
+// validateKnownHosts checks that every non-comment line is a known_hosts entry
+func validateKnownHosts(knownHosts string) error {
+	rest := []byte(knownHosts)
+	for len(rest) > 0 {
+		var err error
+		_, _, _, _, rest, err = ssh.ParseKnownHosts(rest)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return apperror.InvalidInput("invalid known_hosts: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,431 @@
+package gitsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/template"
+	"github.com/yourorg/control-plane/pkg/workflow"
+)
+
+// pollInterval is how often the sync loop looks for repositories due for a
+// sync; it is shortened to the sync interval if that is shorter
+const pollInterval = 15 * time.Second
+
+// maxDuePerPoll caps how many repositories one pass syncs
+const maxDuePerPoll = 20
+
+// SyncResult is the outcome of syncing a repository
+type SyncResult struct {
+	Commit string               `json:"commit,omitempty"`
+	Status models.GitSyncStatus `json:"status"`
+	// UpToDate is set when the branch had not moved since the last
+	// successful sync and nothing was read
+	UpToDate   bool             `json:"up_to_date,omitempty"`
+	Created    []SyncedResource `json:"created,omitempty"`
+	Updated    []SyncedResource `json:"updated,omitempty"`
+	Unchanged  int              `json:"unchanged"`
+	Deprecated []SyncedResource `json:"deprecated,omitempty"`
+	Errors     []FileError      `json:"errors,omitempty"`
+	// Error is why the repository could not be synced at all
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// SyncedResource is a workflow or template a sync changed
+type SyncedResource struct {
+	Kind string `json:"kind"`
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// FileError is a resource file a sync rejected
+type FileError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// Run syncs repositories as they come due until the context is cancelled.
+// Every replica runs the loop; a repository is claimed before it is synced.
+func (m *Manager) Run(ctx context.Context) {
+	interval := pollInterval
+	if m.config.Interval < interval {
+		interval = m.config.Interval
+	}
+	m.logger.Info("Git repository sync started",
+		zap.Duration("interval", m.config.Interval))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		m.syncDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncDue syncs the enabled repositories whose last sync is older than the
+// interval
+func (m *Manager) syncDue(ctx context.Context) {
+	now := time.Now()
+	var repos []models.GitRepository
+	if err := m.db.WithContext(ctx).
+		Where("enabled = ? AND (last_sync_at IS NULL OR last_sync_at < ?)", true, now.Add(-m.config.Interval)).
+		Where("sync_started_at IS NULL OR sync_started_at < ?", now.Add(-m.lease())).
+		Order("last_sync_at ASC").
+		Limit(maxDuePerPoll).
+		Find(&repos).Error; err != nil {
+		m.logger.Error("failed to find Git repositories due for sync", zap.Error(err))
+		return
+	}
+
+	for i := range repos {
+		if ctx.Err() != nil {
+			return
+		}
+		repo := &repos[i]
+		claimed, err := m.claim(ctx, repo)
+		if err != nil {
+			m.logger.Error("failed to claim Git repository",
+				zap.String("repository_id", repo.ID),
+				zap.Error(err))
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		result := m.sync(ctx, repo)
+		if err := m.record(ctx, repo, result); err != nil {
+			m.logger.Error("failed to record Git repository sync",
+				zap.String("repository_id", repo.ID),
+				zap.Error(err))
+		}
+	}
+}
+
+// lease is how long a claim on a repository lasts before another replica
+// may take it over from a replica that died mid-sync
+func (m *Manager) lease() time.Duration {
+	return 2 * m.config.Timeout
+}
+
+// claim marks a repository as being synced; it returns false if another
+// replica holds it
+func (m *Manager) claim(ctx context.Context, repo *models.GitRepository) (bool, error) {
+	now := time.Now()
+	result := m.db.WithContext(ctx).Model(&models.GitRepository{}).
+		Where("id = ? AND (sync_started_at IS NULL OR sync_started_at < ?)", repo.ID, now.Add(-m.lease())).
+		Update("sync_started_at", now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// record stores the result of a sync and releases the claim
+func (m *Manager) record(ctx context.Context, repo *models.GitRepository, result *SyncResult) error {
+	updates := map[string]interface{}{
+		"last_sync_at":    time.Now(),
+		"sync_started_at": nil,
+	}
+	// An up-to-date branch keeps the result of the sync that read it
+	if !result.UpToDate {
+		updates["last_status"] = result.Status
+		updates["last_error"] = result.Error
+		updates["last_result"] = resultToMap(result)
+	}
+	if result.Commit != "" {
+		updates["last_commit"] = result.Commit
+	}
+	// Record the outcome even if shutdown interrupted the sync
+	return m.db.WithContext(context.WithoutCancel(ctx)).Model(&models.GitRepository{}).
+		Where("id = ?", repo.ID).
+		Updates(updates).Error
+}
+
+// sync fetches a repository and applies its resource files
+func (m *Manager) sync(ctx context.Context, repo *models.GitRepository) *SyncResult {
+	started := time.Now()
+	result := &SyncResult{}
+	defer func() {
+		result.DurationMs = time.Since(started).Milliseconds()
+	}()
+
+	w, err := newWorkspace(m.config.WorkDir, repo)
+	if err != nil {
+		result.fail(err)
+		return result
+	}
+	defer w.remove()
+
+	fetchCtx, cancel := context.WithTimeout(ctx, m.config.Timeout)
+	defer cancel()
+
+	commit, err := w.remoteCommit(fetchCtx, repo.URL, repo.Branch)
+	if err != nil {
+		result.fail(err)
+		return result
+	}
+	if commit == repo.LastCommit && repo.LastStatus == models.GitSyncStatusSuccess {
+		result.Commit = commit
+		result.Status = models.GitSyncStatusSuccess
+		result.UpToDate = true
+		return result
+	}
+
+	if result.Commit, err = w.clone(fetchCtx, repo.URL, repo.Branch); err != nil {
+		result.fail(err)
+		return result
+	}
+	files, err := loadResources(w.checkoutDir(), repo.Path)
+	if err != nil {
+		result.fail(err)
+		return result
+	}
+
+	if err := m.apply(ctx, repo, result, files); err != nil {
+		result.fail(err)
+		return result
+	}
+
+	result.Status = models.GitSyncStatusSuccess
+	if len(result.Errors) > 0 {
+		result.Status = models.GitSyncStatusPartial
+	}
+	m.logger.Info("Git repository synced",
+		zap.String("repository_id", repo.ID),
+		zap.String("tenant_id", repo.TenantID),
+		zap.String("commit", result.Commit),
+		zap.Int("created", len(result.Created)),
+		zap.Int("updated", len(result.Updated)),
+		zap.Int("deprecated", len(result.Deprecated)),
+		zap.Int("errors", len(result.Errors)))
+	return result
+}
+
+// apply creates and updates the resources defined by the files, templates
+// first, and deprecates those whose files are gone
+func (m *Manager) apply(ctx context.Context, repo *models.GitRepository, result *SyncResult, files []loadedFile) error {
+	// Paths defining each kind; unreadable files count for both so their
+	// resources are kept until the file is fixed or removed
+	present := map[string][]string{KindWorkflow: nil, KindTemplate: nil}
+	for _, file := range files {
+		if file.Resource == nil {
+			present[KindWorkflow] = append(present[KindWorkflow], file.Path)
+			present[KindTemplate] = append(present[KindTemplate], file.Path)
+			continue
+		}
+		present[file.Resource.Kind] = append(present[file.Resource.Kind], file.Path)
+	}
+
+	for _, kind := range []string{KindTemplate, KindWorkflow} {
+		for _, file := range files {
+			if file.Resource == nil {
+				if kind == KindTemplate {
+					result.addError(file.Path, file.Err)
+				}
+				continue
+			}
+			if file.Resource.Kind != kind {
+				continue
+			}
+			if file.Err != nil {
+				result.addError(file.Path, file.Err)
+				continue
+			}
+			if err := m.applyFile(ctx, repo, result, &file, present[kind]); err != nil {
+				result.addError(file.Path, err)
+			}
+		}
+	}
+
+	return m.deprecateRemoved(ctx, repo, result, present)
+}
+
+// applyFile syncs the resource defined by one file
+func (m *Manager) applyFile(ctx context.Context, repo *models.GitRepository, result *SyncResult, file *loadedFile, present []string) error {
+	r := file.Resource
+	if err := m.adoptMoved(ctx, repo, r.Kind, r.Name, file.Path, present); err != nil {
+		return err
+	}
+
+	changedBy := "git:" + repo.Name
+	var id, name string
+	var action models.GitSyncAction
+	switch r.Kind {
+	case KindWorkflow:
+		tags, err := r.workflowTags()
+		if err != nil {
+			return err
+		}
+		wf, a, err := m.workflows.Sync(ctx, &workflow.SyncWorkflowRequest{
+			TenantID:     repo.TenantID,
+			RepositoryID: repo.ID,
+			Path:         file.Path,
+			Commit:       result.Commit,
+			Name:         r.Name,
+			Description:  r.Description,
+			Definition:   r.Definition,
+			Tags:         tags,
+			Status:       models.WorkflowStatus(r.Status),
+			CreatedBy:    changedBy,
+		})
+		if err != nil {
+			return err
+		}
+		id, name, action = wf.ID, wf.Name, a
+	case KindTemplate:
+		tpl, a, err := m.templates.Sync(ctx, &template.SyncTemplateRequest{
+			TenantID:     repo.TenantID,
+			RepositoryID: repo.ID,
+			Path:         file.Path,
+			Commit:       result.Commit,
+			Name:         r.Name,
+			Description:  r.Description,
+			Content:      r.Content,
+			ContentType:  r.ContentType,
+			Tags:         r.Tags,
+			Metadata:     r.Metadata,
+			Status:       models.TemplateStatus(r.Status),
+			CreatedBy:    changedBy,
+		})
+		if err != nil {
+			return err
+		}
+		id, name, action = tpl.ID, tpl.Name, a
+	}
+
+	resource := SyncedResource{Kind: r.Kind, ID: id, Name: name, Path: file.Path}
+	switch action {
+	case models.GitSyncActionCreated:
+		result.Created = append(result.Created, resource)
+	case models.GitSyncActionUpdated:
+		result.Updated = append(result.Updated, resource)
+	default:
+		result.Unchanged++
+	}
+	return nil
+}
+
+// adoptMoved points a resource of the repository at its new path when its
+// file was moved or renamed: a resource with the same kind and name whose
+// old file is gone
+func (m *Manager) adoptMoved(ctx context.Context, repo *models.GitRepository, kind, name, path string, present []string) error {
+	model := interface{}(&models.Workflow{})
+	if kind == KindTemplate {
+		model = &models.Template{}
+	}
+
+	var count int64
+	if err := m.db.WithContext(ctx).Model(model).
+		Where("tenant_id = ? AND source_repository_id = ? AND source_path = ?", repo.TenantID, repo.ID, path).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check synced resource: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	query := m.db.WithContext(ctx).Model(model).
+		Where("tenant_id = ? AND source_repository_id = ? AND name = ? AND source_path != ?", repo.TenantID, repo.ID, name, path)
+	if len(present) > 0 {
+		query = query.Where("source_path NOT IN ?", present)
+	}
+	// Only one resource can take the path; the rest are deprecated as removed
+	if err := query.Limit(1).Update("source_path", path).Error; err != nil {
+		return fmt.Errorf("failed to follow moved file: %w", err)
+	}
+	return nil
+}
+
+// deprecateRemoved deprecates the repository's resources whose file is no
+// longer present
+func (m *Manager) deprecateRemoved(ctx context.Context, repo *models.GitRepository, result *SyncResult, present map[string][]string) error {
+	removed := func(db *gorm.DB, kind string) *gorm.DB {
+		db = db.Where("tenant_id = ? AND source_repository_id = ?", repo.TenantID, repo.ID)
+		if len(present[kind]) > 0 {
+			db = db.Where("source_path NOT IN ?", present[kind])
+		}
+		return db
+	}
+
+	var workflows []models.Workflow
+	if err := removed(m.db.WithContext(ctx), KindWorkflow).
+		Select("id", "tenant_id", "name", "source_path", "version").
+		Where("status IN ?", []models.WorkflowStatus{models.WorkflowStatusDraft, models.WorkflowStatusActive}).
+		Find(&workflows).Error; err != nil {
+		return fmt.Errorf("failed to find removed workflows: %w", err)
+	}
+	for i := range workflows {
+		wf := &workflows[i]
+		// A workflow changed meanwhile is left to the next sync
+		if err := m.workflows.DeprecateSynced(ctx, wf); errors.Is(err, apperror.ErrConflict) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to deprecate removed workflow: %w", err)
+		}
+		result.Deprecated = append(result.Deprecated, SyncedResource{Kind: KindWorkflow, ID: wf.ID, Name: wf.Name, Path: wf.SourcePath})
+	}
+
+	var templates []models.Template
+	if err := removed(m.db.WithContext(ctx), KindTemplate).
+		Where("status IN ?", []models.TemplateStatus{models.TemplateStatusDraft, models.TemplateStatusActive}).
+		Find(&templates).Error; err != nil {
+		return fmt.Errorf("failed to find removed templates: %w", err)
+	}
+	for i := range templates {
+		tpl := &templates[i]
+		if err := m.templates.DeprecateSynced(ctx, tpl); errors.Is(err, apperror.ErrConflict) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to deprecate removed template: %w", err)
+		}
+		result.Deprecated = append(result.Deprecated, SyncedResource{Kind: KindTemplate, ID: tpl.ID, Name: tpl.Name, Path: tpl.SourcePath})
+	}
+
+	if len(result.Deprecated) > 0 {
+		m.logger.Info("deprecated resources removed from Git repository",
+			zap.String("repository_id", repo.ID),
+			zap.Int("count", len(result.Deprecated)))
+	}
+	return nil
+}
+
+// fail marks the sync as failed
+func (r *SyncResult) fail(err error) {
+	r.Status = models.GitSyncStatusFailed
+	r.Error = err.Error()
+}
+
+// addError records a rejected file
+func (r *SyncResult) addError(path string, err error) {
+	r.Errors = append(r.Errors, FileError{Path: path, Error: err.Error()})
+}
+
+// resultToMap converts a sync result for storage in a JSON column
+func resultToMap(result *SyncResult) models.JSONMap {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil
+	}
+	var m models.JSONMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,504 @@
+package inbound
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/tracing"
+	"github.com/yourorg/control-plane/pkg/workflow"
+)
+
+// Headers an invocation authenticates with: HeaderSignature carries
+// "sha256=" and the hex HMAC-SHA256 keyed with the trigger's secret of
+// SignedPayload, HeaderTimestamp the Unix seconds it was signed at; callers
+// that cannot sign send the secret in HeaderToken instead
+const (
+	HeaderSignature = "X-Trigger-Signature"
+	HeaderTimestamp = "X-Trigger-Timestamp"
+	HeaderToken     = "X-Trigger-Token"
+)
+
+// signaturePrefix prefixes the hex HMAC in a signature header
+const signaturePrefix = "sha256="
+
+// MaxTimestampSkew is how far a signed invocation's timestamp may be from
+// the control plane's clock
+const MaxTimestampSkew = 5 * time.Minute
+
+// maxSelectorAgentIDs bounds the agent_ids a trigger's selector may list
+const maxSelectorAgentIDs = 1000
+
+// Invocation is a request received by an inbound trigger
+type Invocation struct {
+	TriggerID string
+	Body      []byte
+	// Signature, Timestamp and Token are the values of HeaderSignature,
+	// HeaderTimestamp and HeaderToken
+	Signature string
+	Timestamp string
+	Token     string
+	SourceIP  string
+	UserAgent string
+}
+
+// InvocationResult is what an invocation started
+type InvocationResult struct {
+	InvocationID string                  `json:"invocation_id"`
+	Status       models.InvocationStatus `json:"status"`
+	Message      string                  `json:"message,omitempty"`
+	ExecutionIDs []string                `json:"execution_ids"`
+}
+
+// Invoke verifies an invocation and starts the trigger's workflow on the
+// agents its selector matches, with the vars mapped from the payload. Every
+// invocation of a known trigger is recorded and audited, whether it is
+// accepted or not; an unknown trigger is reported as not found. A signed
+// invocation is accepted once: a replay of it is rejected as unauthorized.
+func (m *Manager) Invoke(ctx context.Context, inv *Invocation) (*InvocationResult, error) {
+	var trigger models.InboundTrigger
+	if err := m.db.WithContext(ctx).Where("id = ?", inv.TriggerID).First(&trigger).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("inbound trigger not found")
+		}
+		return nil, fmt.Errorf("failed to get inbound trigger: %w", err)
+	}
+
+	record := &models.InboundTriggerInvocation{
+		ID:         uuid.New().String(),
+		TenantID:   trigger.TenantID,
+		TriggerID:  trigger.ID,
+		SourceIP:   inv.SourceIP,
+		UserAgent:  inv.UserAgent,
+		RequestID:  tracing.RequestID(ctx),
+		ReceivedAt: time.Now(),
+	}
+
+	if err := verify(&trigger, inv, time.Now()); err != nil {
+		return nil, m.reject(ctx, &trigger, record, models.InvocationRejected, err)
+	}
+	record.SignatureDigest = signatureDigest(inv)
+	if !trigger.Enabled {
+		return nil, m.reject(ctx, &trigger, record, models.InvocationRejected,
+			apperror.InvalidState("inbound trigger is disabled"))
+	}
+	var tenant models.Tenant
+	if err := m.db.WithContext(ctx).Select("id", "status").Where("id = ?", trigger.TenantID).First(&tenant).Error; err != nil {
+		return nil, fmt.Errorf("failed to get tenant: %w", err)
+	}
+	if tenant.Status != models.TenantStatusActive {
+		return nil, m.reject(ctx, &trigger, record, models.InvocationRejected,
+			apperror.Forbidden("tenant is not active"))
+	}
+
+	vars, err := mapVars(trigger.Parameters, inv.Body)
+	if err != nil {
+		return nil, m.reject(ctx, &trigger, record, models.InvocationRejected, err)
+	}
+	record.Vars = vars
+
+	agentIDs, err := m.selectAgents(ctx, &trigger)
+	if err != nil {
+		return nil, m.reject(ctx, &trigger, record, models.InvocationFailed, err)
+	}
+	record.AgentCount = len(agentIDs)
+	if len(agentIDs) == 0 {
+		return nil, m.reject(ctx, &trigger, record, models.InvocationFailed,
+			apperror.InvalidState("target selector matches no agents that can take work"))
+	}
+	if len(agentIDs) > trigger.MaxAgents {
+		return nil, m.reject(ctx, &trigger, record, models.InvocationRejected,
+			apperror.InvalidState("target selector matches %d agents, more than the trigger's max_agents of %d", len(agentIDs), trigger.MaxAgents))
+	}
+
+	// The invocation is checked for a replay and counted against the hourly
+	// cap before anything is started, so concurrent invocations cannot both
+	// slip through
+	status, err := m.admit(ctx, &trigger, record)
+	if err != nil {
+		return nil, err
+	}
+	switch status {
+	case models.InvocationRejected:
+		return nil, m.reject(ctx, &trigger, record, models.InvocationRejected,
+			apperror.Unauthorized("signed invocation was already received"))
+	case models.InvocationRateLimited:
+		return nil, m.reject(ctx, &trigger, record, models.InvocationRateLimited,
+			apperror.RateLimited("inbound trigger allows %d invocations per hour", trigger.MaxInvocationsPerHour))
+	}
+
+	var failures []string
+	for _, agentID := range agentIDs {
+		execution, err := m.executor.Execute(ctx, &workflow.ExecuteRequest{
+			TenantID:   trigger.TenantID,
+			WorkflowID: trigger.WorkflowID,
+			AgentID:    agentID,
+			Vars:       vars,
+		})
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", agentID, err))
+			continue
+		}
+		record.ExecutionIDs = append(record.ExecutionIDs, execution.ID)
+	}
+
+	switch {
+	case len(failures) == 0:
+		record.Status = models.InvocationAccepted
+	case len(record.ExecutionIDs) > 0:
+		record.Status = models.InvocationPartial
+		record.Message = strings.Join(failures, "; ")
+	default:
+		record.Status = models.InvocationFailed
+		record.Message = strings.Join(failures, "; ")
+	}
+	m.finish(ctx, &trigger, record)
+
+	return &InvocationResult{
+		InvocationID: record.ID,
+		Status:       record.Status,
+		Message:      record.Message,
+		ExecutionIDs: record.ExecutionIDs,
+	}, nil
+}
+
+// SignedPayload is what an invocation's signature covers: the timestamp, a
+// dot and the body
+func SignedPayload(timestamp string, body []byte) []byte {
+	return append([]byte(timestamp+"."), body...)
+}
+
+// verify checks an invocation's signature and its timestamp against now, or
+// its token when it is unsigned
+func verify(trigger *models.InboundTrigger, inv *Invocation, now time.Time) error {
+	if inv.Signature != "" {
+		if inv.Timestamp == "" {
+			return apperror.Unauthorized("signed invocation is missing the %s header", HeaderTimestamp)
+		}
+		unix, err := strconv.ParseInt(strings.TrimSpace(inv.Timestamp), 10, 64)
+		if err != nil {
+			return apperror.Unauthorized("invalid trigger timestamp %q", inv.Timestamp)
+		}
+		skew := now.Sub(time.Unix(unix, 0))
+		if skew > MaxTimestampSkew || skew < -MaxTimestampSkew {
+			return apperror.Unauthorized("trigger timestamp is outside the allowed %s window", MaxTimestampSkew)
+		}
+
+		got, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(inv.Signature), signaturePrefix))
+		if err != nil {
+			return apperror.Unauthorized("invalid trigger signature")
+		}
+		mac := hmac.New(sha256.New, []byte(trigger.Secret))
+		mac.Write(SignedPayload(strings.TrimSpace(inv.Timestamp), inv.Body))
+		if !hmac.Equal(got, mac.Sum(nil)) {
+			return apperror.Unauthorized("invalid trigger signature")
+		}
+		return nil
+	}
+	if inv.Token != "" && subtle.ConstantTimeCompare([]byte(inv.Token), []byte(trigger.Secret)) == 1 {
+		return nil
+	}
+	return apperror.Unauthorized("invalid trigger token")
+}
+
+// signatureDigest identifies a signed invocation by its timestamp and
+// signature; it is empty for an unsigned one
+func signatureDigest(inv *Invocation) string {
+	if inv.Signature == "" {
+		return ""
+	}
+	signature := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(inv.Signature), signaturePrefix))
+	digest := sha256.Sum256([]byte(strings.TrimSpace(inv.Timestamp) + "." + signature))
+	return hex.EncodeToString(digest[:])
+}
+
+// admit records an invocation as accepted unless it replays a signed
+// invocation already received, reported as InvocationRejected, or the
+// trigger's hourly cap has no room for it, reported as
+// InvocationRateLimited. The trigger row is locked while invocations are
+// counted.
+func (m *Manager) admit(ctx context.Context, trigger *models.InboundTrigger, record *models.InboundTriggerInvocation) (models.InvocationStatus, error) {
+	var status models.InvocationStatus
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var locked models.InboundTrigger
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", trigger.ID).First(&locked).Error; err != nil {
+			return fmt.Errorf("failed to lock inbound trigger: %w", err)
+		}
+
+		// A timestamp is accepted up to MaxTimestampSkew either side of the
+		// clock, so a signature can only be reused within twice the skew
+		if record.SignatureDigest != "" {
+			var seen int64
+			if err := tx.Model(&models.InboundTriggerInvocation{}).
+				Where("trigger_id = ? AND signature_digest = ? AND received_at >= ?", trigger.ID, record.SignatureDigest,
+					record.ReceivedAt.Add(-2*MaxTimestampSkew)).
+				Count(&seen).Error; err != nil {
+				return fmt.Errorf("failed to look up inbound trigger invocation: %w", err)
+			}
+			if seen > 0 {
+				status = models.InvocationRejected
+				return nil
+			}
+		}
+
+		if locked.MaxInvocationsPerHour > 0 {
+			var count int64
+			if err := tx.Model(&models.InboundTriggerInvocation{}).
+				Where("trigger_id = ? AND received_at >= ? AND status IN ?", trigger.ID, record.ReceivedAt.Add(-time.Hour),
+					[]models.InvocationStatus{models.InvocationAccepted, models.InvocationPartial, models.InvocationFailed}).
+				Count(&count).Error; err != nil {
+				return fmt.Errorf("failed to count inbound trigger invocations: %w", err)
+			}
+			if count >= int64(locked.MaxInvocationsPerHour) {
+				status = models.InvocationRateLimited
+				return nil
+			}
+		}
+
+		// Recorded as accepted until the executions are started
+		record.Status = models.InvocationAccepted
+		if err := tx.Create(record).Error; err != nil {
+			return fmt.Errorf("failed to record inbound trigger invocation: %w", err)
+		}
+		status = models.InvocationAccepted
+		return nil
+	})
+	return status, err
+}
+
+// reject records and audits an invocation that started nothing and returns
+// err for the caller
+func (m *Manager) reject(ctx context.Context, trigger *models.InboundTrigger, record *models.InboundTriggerInvocation, status models.InvocationStatus, err error) error {
+	record.Status = status
+	record.Message = err.Error()
+	if createErr := m.db.WithContext(ctx).Create(record).Error; createErr != nil {
+		m.logger.Warn("failed to record inbound trigger invocation",
+			zap.String("trigger_id", trigger.ID),
+			zap.Error(createErr))
+	}
+	m.audit(ctx, trigger, record)
+	return err
+}
+
+// finish saves what an admitted invocation started and audits it
+func (m *Manager) finish(ctx context.Context, trigger *models.InboundTrigger, record *models.InboundTriggerInvocation) {
+	if err := m.db.WithContext(ctx).Save(record).Error; err != nil {
+		m.logger.Warn("failed to record inbound trigger invocation",
+			zap.String("trigger_id", trigger.ID),
+			zap.Error(err))
+	}
+	if err := m.db.WithContext(ctx).Model(&models.InboundTrigger{}).
+		Where("id = ?", trigger.ID).
+		Update("last_invoked_at", record.ReceivedAt).Error; err != nil {
+		m.logger.Warn("failed to update inbound trigger",
+			zap.String("trigger_id", trigger.ID),
+			zap.Error(err))
+	}
+
+	m.logger.Info("inbound trigger invoked",
+		zap.String("trigger_id", trigger.ID),
+		zap.String("tenant_id", trigger.TenantID),
+		zap.String("status", string(record.Status)),
+		zap.Int("executions", len(record.ExecutionIDs)))
+	m.audit(ctx, trigger, record)
+}
+
+// audit logs an invocation to the tenant's audit trail
+func (m *Manager) audit(ctx context.Context, trigger *models.InboundTrigger, record *models.InboundTriggerInvocation) {
+	if m.auditLogger == nil {
+		return
+	}
+
+	outcome := audit.OutcomeSuccess
+	if record.Status != models.InvocationAccepted {
+		outcome = audit.OutcomeFailure
+	}
+	if err := m.auditLogger.NewEventBuilder().
+		WithTenant(trigger.TenantID).
+		WithType(audit.EventTypeWorkflow).
+		WithAction(audit.ActionExecute).
+		WithOutcome(outcome).
+		WithActor(trigger.ID, "inbound_trigger").
+		WithResource(trigger.WorkflowID, "workflow").
+		WithDescription(fmt.Sprintf("Inbound trigger %s invoked: %s", trigger.Name, record.Status)).
+		WithMetadata(map[string]interface{}{
+			"invocation_id": record.ID,
+			"trigger_id":    trigger.ID,
+			"status":        record.Status,
+			"message":       record.Message,
+			"agent_count":   record.AgentCount,
+			"execution_ids": record.ExecutionIDs,
+		}).
+		WithRequestInfo(record.SourceIP, record.UserAgent, record.RequestID).
+		Log(ctx); err != nil {
+		m.logger.Warn("failed to audit inbound trigger invocation",
+			zap.String("trigger_id", trigger.ID),
+			zap.Error(err))
+	}
+}
+
+// selectAgents returns the agents a trigger's selector matches that can
+// take new work
+func (m *Manager) selectAgents(ctx context.Context, trigger *models.InboundTrigger) ([]string, error) {
+	query := m.db.WithContext(ctx).Model(&models.Agent{}).
+		Where("tenant_id = ? AND drain_state = ? AND approval_status = ?", trigger.TenantID, models.AgentDrainNone, models.AgentApprovalApproved)
+
+	if tags, ok := trigger.TargetSelector["tags"].(map[string]interface{}); ok {
+		for key, value := range tags {
+			query = query.Where("JSON_EXTRACT(tags, ?) = ?", "$."+key, value)
+		}
+	}
+	if status, ok := trigger.TargetSelector["status"].(string); ok {
+		query = query.Where("status = ?", status)
+	}
+	ids, err := selectorAgentIDs(trigger.TargetSelector)
+	if err != nil {
+		return nil, err
+	}
+	if ids != nil {
+		query = query.Where("id IN ?", ids)
+	}
+
+	// One more than the trigger allows is enough to reject the invocation
+	var agentIDs []string
+	if err := query.Order("id").Limit(trigger.MaxAgents+1).Pluck("id", &agentIDs).Error; err != nil {
+		return nil, fmt.Errorf("failed to select agents: %w", err)
+	}
+	return agentIDs, nil
+}
+
+// validateSelector checks that a selector names tags or agent_ids, so a
+// trigger never targets every agent of a tenant
+func validateSelector(selector map[string]interface{}) error {
+	if selector == nil {
+		return apperror.InvalidInput("target_selector is required")
+	}
+	tags, _ := selector["tags"].(map[string]interface{})
+	if raw, ok := selector["tags"]; ok && raw != nil && tags == nil {
+		return apperror.InvalidInput("target_selector.tags must be an object")
+	}
+	ids, err := selectorAgentIDs(selector)
+	if err != nil {
+		return err
+	}
+	if len(tags) == 0 && ids == nil {
+		return apperror.InvalidInput("target_selector must select agents by tags or agent_ids")
+	}
+	if raw, ok := selector["status"]; ok {
+		if _, ok := raw.(string); !ok {
+			return apperror.InvalidInput("target_selector.status must be a string")
+		}
+	}
+	return nil
+}
+
+// selectorAgentIDs returns the agent IDs a selector lists, or nil if it
+// lists none
+func selectorAgentIDs(selector map[string]interface{}) ([]string, error) {
+	raw, ok := selector["agent_ids"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+
+	var ids []string
+	switch v := raw.(type) {
+	case []string:
+		ids = v
+	case []interface{}:
+		for _, item := range v {
+			id, ok := item.(string)
+			if !ok {
+				return nil, apperror.InvalidInput("target_selector.agent_ids must be a list of agent IDs")
+			}
+			ids = append(ids, id)
+		}
+	default:
+		return nil, apperror.InvalidInput("target_selector.agent_ids must be a list of agent IDs")
+	}
+	if len(ids) == 0 || len(ids) > maxSelectorAgentIDs {
+		return nil, apperror.InvalidInput("target_selector.agent_ids must list between 1 and %d agents", maxSelectorAgentIDs)
+	}
+	return ids, nil
+}
+
+// mapVars reads a trigger's mapped parameters from a JSON payload. A
+// trigger without parameters accepts any body, JSON or not.
+func mapVars(parameters models.JSONMap, body []byte) (models.JSONMap, error) {
+	if len(parameters) == 0 {
+		return nil, nil
+	}
+
+	var payload interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, apperror.InvalidInput("payload must be JSON: %v", err)
+	}
+
+	vars := make(models.JSONMap, len(parameters))
+	for name, rawPath := range parameters {
+		path, _ := rawPath.(string)
+		value, ok := lookup(payload, path)
+		if !ok {
+			return nil, apperror.InvalidInput("payload has no %s for parameter %s", path, name)
+		}
+		if number, ok := value.(json.Number); ok {
+			value = numberValue(number)
+		}
+		vars[name] = value
+	}
+	return vars, nil
+}
+
+// lookup resolves a dotted path, with an optional "$." prefix and numeric
+// segments indexing arrays, in a decoded JSON value
+func lookup(value interface{}, path string) (interface{}, bool) {
+	path = strings.TrimPrefix(strings.TrimSpace(path), "$.")
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// numberValue keeps integers from a payload as integers rather than floats
+func numberValue(number json.Number) interface{} {
+	if i, err := number.Int64(); err == nil {
+		return i
+	}
+	if f, err := number.Float64(); err == nil {
+		return f
+	}
+	return number.String()
+}
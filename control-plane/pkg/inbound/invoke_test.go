@@ -0,0 +1,193 @@
+package inbound
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/internal/dbtest"
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1767225600, 0)
+	trigger := &models.InboundTrigger{Secret: "trigger-secret"}
+	body := []byte(`{"service":"api"}`)
+	sign := func(secret, timestamp string, body []byte) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(SignedPayload(timestamp, body))
+		return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+	}
+	ts := func(at time.Time) string { return strconv.FormatInt(at.Unix(), 10) }
+
+	tests := []struct {
+		name    string
+		inv     Invocation
+		wantErr bool
+	}{
+		{
+			name: "valid signature",
+			inv:  Invocation{Body: body, Timestamp: ts(now), Signature: sign("trigger-secret", ts(now), body)},
+		},
+		{
+			name: "signature without prefix",
+			inv:  Invocation{Body: body, Timestamp: ts(now), Signature: sign("trigger-secret", ts(now), body)[len(signaturePrefix):]},
+		},
+		{
+			name: "timestamp within skew",
+			inv:  Invocation{Body: body, Timestamp: ts(now.Add(-4 * time.Minute)), Signature: sign("trigger-secret", ts(now.Add(-4*time.Minute)), body)},
+		},
+		{
+			name:    "stale timestamp",
+			inv:     Invocation{Body: body, Timestamp: ts(now.Add(-6 * time.Minute)), Signature: sign("trigger-secret", ts(now.Add(-6*time.Minute)), body)},
+			wantErr: true,
+		},
+		{
+			name:    "future timestamp",
+			inv:     Invocation{Body: body, Timestamp: ts(now.Add(6 * time.Minute)), Signature: sign("trigger-secret", ts(now.Add(6*time.Minute)), body)},
+			wantErr: true,
+		},
+		{
+			name:    "missing timestamp",
+			inv:     Invocation{Body: body, Signature: sign("trigger-secret", ts(now), body)},
+			wantErr: true,
+		},
+		{
+			name:    "malformed timestamp",
+			inv:     Invocation{Body: body, Timestamp: "yesterday", Signature: sign("trigger-secret", "yesterday", body)},
+			wantErr: true,
+		},
+		{
+			name:    "timestamp not covered by the signature",
+			inv:     Invocation{Body: body, Timestamp: ts(now), Signature: sign("trigger-secret", ts(now.Add(-time.Hour)), body)},
+			wantErr: true,
+		},
+		{
+			name:    "tampered body",
+			inv:     Invocation{Body: []byte(`{"service":"db"}`), Timestamp: ts(now), Signature: sign("trigger-secret", ts(now), body)},
+			wantErr: true,
+		},
+		{
+			name:    "other secret",
+			inv:     Invocation{Body: body, Timestamp: ts(now), Signature: sign("other-secret", ts(now), body)},
+			wantErr: true,
+		},
+		{
+			name:    "malformed signature",
+			inv:     Invocation{Body: body, Timestamp: ts(now), Signature: "sha256=zz"},
+			wantErr: true,
+		},
+		{
+			name: "valid token",
+			inv:  Invocation{Body: body, Token: "trigger-secret"},
+		},
+		{
+			name:    "wrong token",
+			inv:     Invocation{Body: body, Token: "guess"},
+			wantErr: true,
+		},
+		{
+			name:    "no credentials",
+			inv:     Invocation{Body: body},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verify(trigger, &tt.inv, now)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("verify: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, apperror.ErrUnauthorized) {
+				t.Fatalf("verify error = %v, want an unauthorized error", err)
+			}
+		})
+	}
+}
+
+// triggerStore answers the statements of invoking one trigger up to
+// admission. Invocations are only recorded; none is started.
+type triggerStore struct {
+	t            *testing.T
+	tenantStatus models.TenantStatus
+	invocations  []map[string]driver.Value
+}
+
+func (s *triggerStore) handle(query string, args []driver.Value) (*dbtest.Result, error) {
+	switch {
+	case strings.HasPrefix(query, "SELECT") && strings.Contains(query, "FROM `inbound_triggers`"):
+		return &dbtest.Result{
+			Columns: []string{"id", "tenant_id", "name", "workflow_id", "target_selector", "secret", "max_agents", "max_invocations_per_hour", "enabled"},
+			Rows:    [][]driver.Value{{"trigger-1", "tenant-1", "deploy", "wf-1", []byte(`{"agent_ids":["agent-1"]}`), "trigger-secret", int64(10), int64(0), true}},
+		}, nil
+	case strings.HasPrefix(query, "SELECT") && strings.Contains(query, "FROM `tenants`"):
+		return &dbtest.Result{Columns: []string{"id", "status"}, Rows: [][]driver.Value{{"tenant-1", string(s.tenantStatus)}}}, nil
+	case strings.HasPrefix(query, "SELECT") && strings.Contains(query, "FROM `agents`"):
+		return &dbtest.Result{Columns: []string{"id"}, Rows: [][]driver.Value{{"agent-1"}}}, nil
+	case strings.HasPrefix(query, "SELECT count(*)") && strings.Contains(query, "signature_digest"):
+		var seen int64
+		for _, inv := range s.invocations {
+			if inv["signature_digest"] == args[1] {
+				seen++
+			}
+		}
+		return &dbtest.Result{Columns: []string{"count(*)"}, Rows: [][]driver.Value{{seen}}}, nil
+	case strings.HasPrefix(query, "INSERT INTO `inbound_trigger_invocations`"):
+		s.invocations = append(s.invocations, dbtest.Inserted(query, args))
+		return &dbtest.Result{RowsAffected: 1}, nil
+	}
+	s.t.Fatalf("unexpected statement: %s", query)
+	return nil, nil
+}
+
+func TestInvokeRejects(t *testing.T) {
+	body := []byte(`{"service":"api"}`)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte("trigger-secret"))
+	mac.Write(SignedPayload(timestamp, body))
+	signed := &Invocation{TriggerID: "trigger-1", Body: body, Timestamp: timestamp, Signature: signaturePrefix + hex.EncodeToString(mac.Sum(nil))}
+
+	t.Run("replayed signature", func(t *testing.T) {
+		store := &triggerStore{t: t, tenantStatus: models.TenantStatusActive}
+		m := NewManager(dbtest.Open(t, store.handle), nil, zap.NewNop())
+		// The first invocation was admitted; the signature is the same
+		// whatever case its hex is sent in
+		store.invocations = append(store.invocations, map[string]driver.Value{"signature_digest": signatureDigest(signed)})
+		replay := *signed
+		replay.Signature = strings.ToUpper(replay.Signature[len(signaturePrefix):])
+
+		_, err := m.Invoke(context.Background(), &replay)
+		if !errors.Is(err, apperror.ErrUnauthorized) {
+			t.Fatalf("Invoke replay: err = %v, want an unauthorized error", err)
+		}
+		if len(store.invocations) != 2 || store.invocations[1]["status"] != string(models.InvocationRejected) {
+			t.Errorf("replay recorded as %v", store.invocations[len(store.invocations)-1])
+		}
+	})
+
+	t.Run("suspended tenant", func(t *testing.T) {
+		store := &triggerStore{t: t, tenantStatus: models.TenantStatusSuspended}
+		m := NewManager(dbtest.Open(t, store.handle), nil, zap.NewNop())
+
+		_, err := m.Invoke(context.Background(), signed)
+		if !errors.Is(err, apperror.ErrForbidden) {
+			t.Fatalf("Invoke for a suspended tenant: err = %v, want a forbidden error", err)
+		}
+		if len(store.invocations) != 1 || store.invocations[0]["status"] != string(models.InvocationRejected) {
+			t.Errorf("invocation recorded as %v", store.invocations)
+		}
+	})
+}
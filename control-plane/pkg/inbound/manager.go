@@ -0,0 +1,338 @@
+// Package inbound lets external systems start workflows: a tenant creates a
+// trigger bound to a workflow and an agent selector, and CI pipelines or
+// monitoring post to the trigger's URL, signed with its secret, to run the
+// workflow with vars read from the posted payload. Invocations are capped
+// per hour and every one is recorded and audited.
+package inbound
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/workflow"
+)
+
+// Trigger defaults
+const (
+	DefaultMaxAgents             = 10
+	DefaultMaxInvocationsPerHour = 60
+)
+
+// Limits on trigger settings
+const (
+	maxTriggerAgents    = 1000
+	maxParameters       = 50
+	maxInvocationsShown = 500
+	secretLength        = 32
+)
+
+// CreateTriggerRequest represents a request to create an inbound trigger
+type CreateTriggerRequest struct {
+	TenantID    string `json:"-"`
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+	WorkflowID  string `json:"workflow_id" binding:"required"`
+	// TargetSelector picks agents by tags, agent_ids and status; it must
+	// name tags or agent_ids
+	TargetSelector map[string]interface{} `json:"target_selector" binding:"required"`
+	// Parameters maps workflow vars to payload paths such as
+	// "release.tag_name" or "alerts.0.labels.instance"
+	Parameters map[string]string `json:"parameters"`
+	// MaxAgents defaults to 10 and MaxInvocationsPerHour to 60; a
+	// MaxInvocationsPerHour of 0 removes the cap
+	MaxAgents             *int   `json:"max_agents"`
+	MaxInvocationsPerHour *int   `json:"max_invocations_per_hour"`
+	Enabled               *bool  `json:"enabled"`
+	CreatedBy             string `json:"-"`
+}
+
+// UpdateTriggerRequest represents a request to update an inbound trigger.
+// Parameters and the target selector, when set, replace the current ones.
+type UpdateTriggerRequest struct {
+	Name                  *string                 `json:"name"`
+	Description           *string                 `json:"description"`
+	WorkflowID            *string                 `json:"workflow_id"`
+	TargetSelector        *map[string]interface{} `json:"target_selector"`
+	Parameters            *map[string]string      `json:"parameters"`
+	MaxAgents             *int                    `json:"max_agents"`
+	MaxInvocationsPerHour *int                    `json:"max_invocations_per_hour"`
+	Enabled               *bool                   `json:"enabled"`
+}
+
+// TriggerWithSecret is a trigger with its secret, returned only when the
+// trigger is created or its secret rotated
+type TriggerWithSecret struct {
+	*models.InboundTrigger
+	Secret string `json:"secret"`
+}
+
+// ListInvocationsRequest filters trigger invocations
+type ListInvocationsRequest struct {
+	TenantID  string
+	TriggerID string
+	Status    models.InvocationStatus
+	Since     time.Time
+	Limit     int
+}
+
+// Manager manages inbound triggers and runs their invocations
+type Manager struct {
+	db          *gorm.DB
+	executor    *workflow.Executor
+	auditLogger *audit.Logger
+	logger      *zap.Logger
+}
+
+// NewManager creates an inbound trigger manager
+func NewManager(db *gorm.DB, executor *workflow.Executor, logger *zap.Logger) *Manager {
+	return &Manager{
+		db:       db,
+		executor: executor,
+		logger:   logger,
+	}
+}
+
+// SetAuditLogger sets the logger that records trigger invocations
+func (m *Manager) SetAuditLogger(auditLogger *audit.Logger) {
+	m.auditLogger = auditLogger
+}
+
+// CreateTrigger creates an inbound trigger for one of the tenant's
+// workflows and generates its secret
+func (m *Manager) CreateTrigger(ctx context.Context, req *CreateTriggerRequest) (*TriggerWithSecret, error) {
+	secret, err := models.GenerateKey(secretLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate trigger secret: %w", err)
+	}
+
+	now := time.Now()
+	trigger := &models.InboundTrigger{
+		ID:                    uuid.New().String(),
+		TenantID:              req.TenantID,
+		Name:                  strings.TrimSpace(req.Name),
+		Description:           req.Description,
+		WorkflowID:            req.WorkflowID,
+		TargetSelector:        req.TargetSelector,
+		Parameters:            parameterMap(req.Parameters),
+		Secret:                secret,
+		MaxAgents:             DefaultMaxAgents,
+		MaxInvocationsPerHour: DefaultMaxInvocationsPerHour,
+		Enabled:               true,
+		CreatedBy:             req.CreatedBy,
+		CreatedAt:             now,
+		UpdatedAt:             now,
+	}
+	if req.MaxAgents != nil {
+		trigger.MaxAgents = *req.MaxAgents
+	}
+	if req.MaxInvocationsPerHour != nil {
+		trigger.MaxInvocationsPerHour = *req.MaxInvocationsPerHour
+	}
+	if req.Enabled != nil {
+		trigger.Enabled = *req.Enabled
+	}
+	if err := m.validateTrigger(ctx, trigger); err != nil {
+		return nil, err
+	}
+
+	if err := m.db.WithContext(ctx).Create(trigger).Error; err != nil {
+		return nil, fmt.Errorf("failed to create inbound trigger: %w", err)
+	}
+
+	m.logger.Info("inbound trigger created",
+		zap.String("trigger_id", trigger.ID),
+		zap.String("tenant_id", trigger.TenantID),
+		zap.String("workflow_id", trigger.WorkflowID))
+
+	return &TriggerWithSecret{InboundTrigger: trigger, Secret: secret}, nil
+}
+
+// GetTrigger returns an inbound trigger
+func (m *Manager) GetTrigger(ctx context.Context, tenantID, triggerID string) (*models.InboundTrigger, error) {
+	var trigger models.InboundTrigger
+	if err := m.db.WithContext(ctx).Where("id = ? AND tenant_id = ?", triggerID, tenantID).First(&trigger).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("inbound trigger not found")
+		}
+		return nil, fmt.Errorf("failed to get inbound trigger: %w", err)
+	}
+	return &trigger, nil
+}
+
+// ListTriggers returns a tenant's inbound triggers
+func (m *Manager) ListTriggers(ctx context.Context, tenantID string) ([]models.InboundTrigger, error) {
+	var triggers []models.InboundTrigger
+	if err := m.db.WithContext(ctx).Where("tenant_id = ?", tenantID).Order("name ASC").Find(&triggers).Error; err != nil {
+		return nil, fmt.Errorf("failed to list inbound triggers: %w", err)
+	}
+	return triggers, nil
+}
+
+// UpdateTrigger updates an inbound trigger; its secret is kept
+func (m *Manager) UpdateTrigger(ctx context.Context, tenantID, triggerID string, req *UpdateTriggerRequest) (*models.InboundTrigger, error) {
+	trigger, err := m.GetTrigger(ctx, tenantID, triggerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		trigger.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		trigger.Description = *req.Description
+	}
+	if req.WorkflowID != nil {
+		trigger.WorkflowID = *req.WorkflowID
+	}
+	if req.TargetSelector != nil {
+		trigger.TargetSelector = *req.TargetSelector
+	}
+	if req.Parameters != nil {
+		trigger.Parameters = parameterMap(*req.Parameters)
+	}
+	if req.MaxAgents != nil {
+		trigger.MaxAgents = *req.MaxAgents
+	}
+	if req.MaxInvocationsPerHour != nil {
+		trigger.MaxInvocationsPerHour = *req.MaxInvocationsPerHour
+	}
+	if req.Enabled != nil {
+		trigger.Enabled = *req.Enabled
+	}
+	if err := m.validateTrigger(ctx, trigger); err != nil {
+		return nil, err
+	}
+
+	trigger.UpdatedAt = time.Now()
+	if err := m.db.WithContext(ctx).Save(trigger).Error; err != nil {
+		return nil, fmt.Errorf("failed to update inbound trigger: %w", err)
+	}
+	return trigger, nil
+}
+
+// RotateSecret replaces a trigger's secret. Invocations signed with the old
+// secret are rejected from then on.
+func (m *Manager) RotateSecret(ctx context.Context, tenantID, triggerID string) (*TriggerWithSecret, error) {
+	trigger, err := m.GetTrigger(ctx, tenantID, triggerID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := models.GenerateKey(secretLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate trigger secret: %w", err)
+	}
+	trigger.Secret = secret
+	trigger.UpdatedAt = time.Now()
+	if err := m.db.WithContext(ctx).Save(trigger).Error; err != nil {
+		return nil, fmt.Errorf("failed to rotate inbound trigger secret: %w", err)
+	}
+
+	m.logger.Info("inbound trigger secret rotated",
+		zap.String("trigger_id", trigger.ID),
+		zap.String("tenant_id", trigger.TenantID))
+
+	return &TriggerWithSecret{InboundTrigger: trigger, Secret: secret}, nil
+}
+
+// DeleteTrigger deletes an inbound trigger and its invocation history
+func (m *Manager) DeleteTrigger(ctx context.Context, tenantID, triggerID string) error {
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND tenant_id = ?", triggerID, tenantID).Delete(&models.InboundTrigger{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete inbound trigger: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return apperror.NotFound("inbound trigger not found")
+		}
+		if err := tx.Where("trigger_id = ?", triggerID).Delete(&models.InboundTriggerInvocation{}).Error; err != nil {
+			return fmt.Errorf("failed to delete inbound trigger invocations: %w", err)
+		}
+		return nil
+	})
+}
+
+// ListInvocations returns trigger invocations, newest first
+func (m *Manager) ListInvocations(ctx context.Context, req *ListInvocationsRequest) ([]models.InboundTriggerInvocation, error) {
+	query := m.db.WithContext(ctx).Where("tenant_id = ?", req.TenantID)
+	if req.TriggerID != "" {
+		query = query.Where("trigger_id = ?", req.TriggerID)
+	}
+	if req.Status != "" {
+		query = query.Where("status = ?", req.Status)
+	}
+	if !req.Since.IsZero() {
+		query = query.Where("received_at >= ?", req.Since)
+	}
+	limit := req.Limit
+	if limit <= 0 || limit > maxInvocationsShown {
+		limit = maxInvocationsShown
+	}
+
+	var invocations []models.InboundTriggerInvocation
+	if err := query.Order("received_at DESC").Limit(limit).Find(&invocations).Error; err != nil {
+		return nil, fmt.Errorf("failed to list inbound trigger invocations: %w", err)
+	}
+	return invocations, nil
+}
+
+// validateTrigger checks a trigger's settings and that its workflow is one
+// of the tenant's
+func (m *Manager) validateTrigger(ctx context.Context, trigger *models.InboundTrigger) error {
+	if trigger.Name == "" {
+		return apperror.InvalidInput("name is required")
+	}
+	if trigger.MaxAgents < 1 || trigger.MaxAgents > maxTriggerAgents {
+		return apperror.InvalidInput("max_agents must be between 1 and %d", maxTriggerAgents)
+	}
+	if trigger.MaxInvocationsPerHour < 0 {
+		return apperror.InvalidInput("max_invocations_per_hour must not be negative")
+	}
+	if err := validateSelector(trigger.TargetSelector); err != nil {
+		return err
+	}
+	if len(trigger.Parameters) > maxParameters {
+		return apperror.InvalidInput("at most %d parameters can be mapped", maxParameters)
+	}
+	for name, path := range trigger.Parameters {
+		switch name {
+		case "grains", "steps":
+			return apperror.InvalidInput("parameters.%s: %s is a reserved var", name, name)
+		}
+		if p, _ := path.(string); strings.TrimPrefix(strings.TrimSpace(p), "$.") == "" {
+			return apperror.InvalidInput("parameters.%s must be a payload path", name)
+		}
+	}
+
+	var count int64
+	if err := m.db.WithContext(ctx).Model(&models.Workflow{}).
+		Where("id = ? AND tenant_id = ? AND status <> ?", trigger.WorkflowID, trigger.TenantID, models.WorkflowStatusDeleted).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to look up workflow: %w", err)
+	}
+	if count == 0 {
+		return apperror.NotFound("workflow not found")
+	}
+	return nil
+}
+
+// parameterMap stores a parameter mapping as a JSON column
+func parameterMap(parameters map[string]string) models.JSONMap {
+	if len(parameters) == 0 {
+		return nil
+	}
+	m := make(models.JSONMap, len(parameters))
+	for name, path := range parameters {
+		m[strings.TrimSpace(name)] = strings.TrimSpace(path)
+	}
+	return m
+}
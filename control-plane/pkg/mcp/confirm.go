@@ -0,0 +1,149 @@
+package mcp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultConfirmAboveAgents is the number of agents a tool call may act on
+// before it has to be confirmed
+const DefaultConfirmAboveAgents = 10
+
+// confirmationTTL is how long a confirmation token can be replayed
+const confirmationTTL = 5 * time.Minute
+
+// confirmationArg is the argument a confirmation token is replayed in
+const confirmationArg = "confirmation_token"
+
+// pendingConfirmation is a tool call that waits for its token to be replayed
+type pendingConfirmation struct {
+	tool        string
+	fingerprint string
+	expiresAt   time.Time
+}
+
+// confirmations holds the confirmation tokens a session has issued. A token
+// confirms one call only: the tool and arguments it was issued for.
+type confirmations struct {
+	mu      sync.Mutex
+	pending map[string]*pendingConfirmation
+}
+
+func newConfirmations() *confirmations {
+	return &confirmations{pending: make(map[string]*pendingConfirmation)}
+}
+
+// issue returns a token confirming a call
+func (c *confirmations) issue(tool string, args map[string]interface{}) (string, time.Time, error) {
+	fingerprint, err := callFingerprint(args)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate confirmation token: %w", err)
+	}
+	token := hex.EncodeToString(raw)
+	expiresAt := time.Now().Add(confirmationTTL)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+	c.pending[token] = &pendingConfirmation{tool: tool, fingerprint: fingerprint, expiresAt: expiresAt}
+	return token, expiresAt, nil
+}
+
+// redeem uses up a token, returning an error unless it was issued for the
+// same tool and arguments and has not expired
+func (c *confirmations) redeem(tool, token string, args map[string]interface{}) error {
+	fingerprint, err := callFingerprint(args)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+	pending, ok := c.pending[token]
+	if !ok {
+		return fmt.Errorf("confirmation token is unknown, used or expired; call %s without it for a new one", tool)
+	}
+	if pending.tool != tool || pending.fingerprint != fingerprint {
+		return fmt.Errorf("confirmation token was issued for a different call; call %s without it for a new one", tool)
+	}
+	delete(c.pending, token)
+	return nil
+}
+
+// expire drops the tokens past their expiry; c.mu must be held
+func (c *confirmations) expire() {
+	now := time.Now()
+	for token, pending := range c.pending {
+		if now.After(pending.expiresAt) {
+			delete(c.pending, token)
+		}
+	}
+}
+
+// callFingerprint hashes the arguments of a call, without its confirmation
+// token. Map keys are marshalled sorted, so equal arguments hash equally.
+func callFingerprint(args map[string]interface{}) (string, error) {
+	rest := make(map[string]interface{}, len(args))
+	for key, value := range args {
+		if key != confirmationArg {
+			rest[key] = value
+		}
+	}
+	data, err := json.Marshal(rest)
+	if err != nil {
+		return "", fmt.Errorf("failed to fingerprint call: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// confirm gates a call acting on agents. Calls on at most the session's
+// limit proceed; larger ones get back a preview and a confirmation token,
+// and proceed when called again with the same arguments and the token. It
+// returns the result to send instead of acting, or nil to go ahead.
+func (h *ToolHandler) confirm(tool string, args map[string]interface{}, agents int, preview interface{}) (*CallToolResult, error) {
+	if h.confirmations == nil || h.confirmAbove < 0 || agents <= h.confirmAbove {
+		return nil, nil
+	}
+
+	if token, _ := args[confirmationArg].(string); token != "" {
+		if err := h.confirmations.redeem(tool, token, args); err != nil {
+			return nil, err
+		}
+		h.logger.Info("tool call confirmed via MCP",
+			zap.String("tool", tool),
+			zap.Int("agents", agents))
+		return nil, nil
+	}
+
+	token, expiresAt, err := h.confirmations.issue(tool, args)
+	if err != nil {
+		return nil, err
+	}
+	h.logger.Info("tool call held for confirmation via MCP",
+		zap.String("tool", tool),
+		zap.Int("agents", agents),
+		zap.Int("confirm_above_agents", h.confirmAbove))
+
+	return h.jsonResult(map[string]interface{}{
+		"confirmation_required": true,
+		"confirmation_token":    token,
+		"expires_at":            expiresAt.UTC().Format(time.RFC3339),
+		"agents":                agents,
+		"preview":               preview,
+		"message": fmt.Sprintf("Nothing was run. This call acts on %d agents, more than the %d allowed without confirmation. "+
+			"Review the preview, then call %s again with the same arguments and confirmation_token to proceed.", agents, h.confirmAbove, tool),
+	})
+}
@@ -0,0 +1,322 @@
+// Package mcp provides MCP (Model Context Protocol) server implementation.
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// Limits of a diagnosis, so the bundle stays small enough to reason over
+const (
+	defaultDiagnosisOutputLines = 40
+	maxDiagnosisOutputLines     = 400
+	defaultDiagnosisWindow      = 15 * time.Minute
+	maxDiagnosisAuditEvents     = 25
+)
+
+// ExecutionDiagnosis is what is known about one execution: its steps, the
+// agent's health around it and the audit events about both
+type ExecutionDiagnosis struct {
+	Execution  DiagnosedExecution             `json:"execution"`
+	FailedStep *DiagnosedStep                 `json:"failed_step,omitempty"`
+	Steps      []DiagnosedStep                `json:"steps"`
+	Agent      *DiagnosedAgent                `json:"agent,omitempty"`
+	Health     *DiagnosedHealth               `json:"health_at_start,omitempty"`
+	Changes    []models.AgentHealthTransition `json:"health_changes"`
+	Audit      []audit.AuditEvent             `json:"audit_events"`
+	// Notes lists what could not be gathered
+	Notes []string `json:"notes,omitempty"`
+}
+
+// DiagnosedExecution summarises an execution
+type DiagnosedExecution struct {
+	ID              string                 `json:"id"`
+	WorkflowID      string                 `json:"workflow_id"`
+	WorkflowName    string                 `json:"workflow_name,omitempty"`
+	WorkflowVersion int                    `json:"workflow_version"`
+	CampaignID      *string                `json:"campaign_id,omitempty"`
+	TriggeredByID   *string                `json:"triggered_by_execution_id,omitempty"`
+	RequestID       string                 `json:"request_id,omitempty"`
+	Status          models.ExecutionStatus `json:"status"`
+	Mode            models.ExecutionMode   `json:"mode"`
+	Error           string                 `json:"error,omitempty"`
+	// Dispatch is how delivery to the agent failed, for executions that
+	// never reached it
+	Dispatch    interface{} `json:"dispatch,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+	StartedAt   *time.Time  `json:"started_at,omitempty"`
+	CompletedAt *time.Time  `json:"completed_at,omitempty"`
+	Duration    string      `json:"duration,omitempty"`
+	// Environment is the agent's snapshot taken when the run started
+	Environment models.JSONMap `json:"environment,omitempty"`
+}
+
+// DiagnosedStep is a step result with its output cut to the last lines
+type DiagnosedStep struct {
+	ID         string      `json:"id"`
+	Name       string      `json:"name,omitempty"`
+	Status     string      `json:"status"`
+	ExitCode   interface{} `json:"exit_code,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	RetryCount interface{} `json:"retry_count,omitempty"`
+	// Iterations is the number of iterations a repeated step ran
+	Iterations int    `json:"iterations,omitempty"`
+	Duration   string `json:"duration,omitempty"`
+	Output     string `json:"output,omitempty"`
+	// OmittedLines is the number of output lines cut from the start
+	OmittedLines int `json:"omitted_lines,omitempty"`
+}
+
+// DiagnosedAgent is the agent an execution ran on, as it is now
+type DiagnosedAgent struct {
+	ID         string                 `json:"id"`
+	Hostname   string                 `json:"hostname"`
+	OS         string                 `json:"os,omitempty"`
+	Version    string                 `json:"version,omitempty"`
+	Status     models.AgentStatus     `json:"status"`
+	DrainState models.AgentDrainState `json:"drain_state"`
+	LastSeenAt *time.Time             `json:"last_seen_at,omitempty"`
+	Tags       models.JSONMap         `json:"tags,omitempty"`
+}
+
+// DiagnosedHealth is an agent health report
+type DiagnosedHealth struct {
+	Status     models.AgentStatus `json:"status"`
+	Components models.JSONMap     `json:"components,omitempty"`
+	ReportedAt time.Time          `json:"reported_at"`
+}
+
+func (h *ToolHandler) diagnoseExecution(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+	tenantID, _ := args["tenant_id"].(string)
+	executionID, _ := args["execution_id"].(string)
+	if tenantID == "" || executionID == "" {
+		return nil, fmt.Errorf("tenant_id and execution_id are required")
+	}
+
+	lines := getIntArg(args, "output_lines", defaultDiagnosisOutputLines)
+	if lines < 0 {
+		lines = 0
+	}
+	if lines > maxDiagnosisOutputLines {
+		lines = maxDiagnosisOutputLines
+	}
+	window := defaultDiagnosisWindow
+	if minutes := getIntArg(args, "window_minutes", 0); minutes > 0 {
+		window = time.Duration(minutes) * time.Minute
+	}
+
+	var execution models.WorkflowExecution
+	if err := h.db.WithContext(ctx).Preload("Workflow").
+		Where("id = ? AND tenant_id = ?", executionID, tenantID).
+		First(&execution).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("execution not found")
+		}
+		return nil, fmt.Errorf("failed to get execution: %w", err)
+	}
+
+	diagnosis := &ExecutionDiagnosis{
+		Changes: []models.AgentHealthTransition{},
+		Audit:   []audit.AuditEvent{},
+	}
+	if execution.ArchiveKey != nil {
+		if h.archiver == nil {
+			diagnosis.Notes = append(diagnosis.Notes, "step output is archived and archival is not configured; output is omitted")
+		} else if err := h.archiver.Restore(ctx, &execution); err != nil {
+			diagnosis.Notes = append(diagnosis.Notes, fmt.Sprintf("failed to read archived step output: %v", err))
+		}
+	}
+
+	diagnosis.Execution = diagnosedExecution(&execution)
+	diagnosis.Steps = diagnosedSteps(execution.Result, lines)
+	for i := range diagnosis.Steps {
+		if diagnosis.Steps[i].Status == "failed" {
+			diagnosis.FailedStep = &diagnosis.Steps[i]
+			break
+		}
+	}
+
+	// The period to look at runs from a window before the execution
+	// started to a window after it ended
+	started := execution.CreatedAt
+	if execution.StartedAt != nil {
+		started = *execution.StartedAt
+	}
+	from := started.Add(-window)
+	until := time.Now()
+	if execution.CompletedAt != nil {
+		until = execution.CompletedAt.Add(window)
+	}
+
+	var agentRecord models.Agent
+	if err := h.db.WithContext(ctx).
+		Where("id = ? AND tenant_id = ?", execution.AgentID, tenantID).
+		First(&agentRecord).Error; err == nil {
+		diagnosis.Agent = &DiagnosedAgent{
+			ID:         agentRecord.ID,
+			Hostname:   agentRecord.Hostname,
+			OS:         agentRecord.OS,
+			Version:    agentRecord.Version,
+			Status:     agentRecord.Status,
+			DrainState: agentRecord.DrainState,
+			LastSeenAt: agentRecord.LastSeenAt,
+			Tags:       agentRecord.Tags,
+		}
+	} else {
+		diagnosis.Notes = append(diagnosis.Notes, "the agent is no longer registered")
+	}
+
+	// The last health report before the run started
+	var report models.AgentHealthReport
+	if err := h.db.WithContext(ctx).
+		Where("tenant_id = ? AND agent_id = ? AND reported_at <= ?", tenantID, execution.AgentID, started).
+		Order("reported_at DESC").
+		First(&report).Error; err == nil {
+		diagnosis.Health = &DiagnosedHealth{
+			Status:     report.Status,
+			Components: report.Components,
+			ReportedAt: report.ReportedAt,
+		}
+	} else {
+		diagnosis.Notes = append(diagnosis.Notes, "no health report from before the execution started")
+	}
+
+	if err := h.db.WithContext(ctx).
+		Where("tenant_id = ? AND agent_id = ? AND changed_at BETWEEN ? AND ?", tenantID, execution.AgentID, from, until).
+		Order("changed_at ASC").
+		Find(&diagnosis.Changes).Error; err != nil {
+		diagnosis.Notes = append(diagnosis.Notes, fmt.Sprintf("failed to read health history: %v", err))
+	}
+
+	if events, err := h.diagnosisAuditEvents(ctx, tenantID, &execution, from, until); err != nil {
+		diagnosis.Notes = append(diagnosis.Notes, err.Error())
+	} else {
+		diagnosis.Audit = events
+	}
+
+	// Agents keep their own logs; the control plane does not collect them
+	diagnosis.Notes = append(diagnosis.Notes, "agent logs are not collected by the control plane; read them on the host")
+
+	return h.jsonResult(diagnosis)
+}
+
+// diagnosisAuditEvents returns the audit events about an execution and
+// those about its agent in the period, newest first
+func (h *ToolHandler) diagnosisAuditEvents(ctx context.Context, tenantID string, execution *models.WorkflowExecution, from, until time.Time) ([]audit.AuditEvent, error) {
+	if h.auditLogger == nil {
+		return nil, fmt.Errorf("audit logging not configured; audit events are omitted")
+	}
+
+	queries := []*audit.SearchQuery{
+		{TenantID: tenantID, ResourceID: execution.ID},
+		{TenantID: tenantID, ResourceID: execution.AgentID, StartTime: &from, EndTime: &until},
+		{TenantID: tenantID, ActorID: execution.AgentID, StartTime: &from, EndTime: &until},
+	}
+	// The events of the request that started the execution
+	if execution.RequestID != "" {
+		queries = append(queries, &audit.SearchQuery{TenantID: tenantID, RequestID: execution.RequestID})
+	}
+	seen := make(map[string]bool)
+	var events []audit.AuditEvent
+	for _, query := range queries {
+		query.MaxHits = maxDiagnosisAuditEvents
+		query.SortBy = []audit.SortField{{Field: "timestamp", Order: "desc"}}
+		result, err := h.auditLogger.Search(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search audit events: %v", err)
+		}
+		for _, event := range result.Hits {
+			if !seen[event.ID] {
+				seen[event.ID] = true
+				events = append(events, event)
+			}
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].Timestamp.After(events[j].Timestamp) })
+	if len(events) > maxDiagnosisAuditEvents {
+		events = events[:maxDiagnosisAuditEvents]
+	}
+	if events == nil {
+		events = []audit.AuditEvent{}
+	}
+	return events, nil
+}
+
+// diagnosedExecution summarises an execution record
+func diagnosedExecution(execution *models.WorkflowExecution) DiagnosedExecution {
+	summary := DiagnosedExecution{
+		ID:              execution.ID,
+		WorkflowID:      execution.WorkflowID,
+		WorkflowName:    execution.Workflow.Name,
+		WorkflowVersion: execution.WorkflowVersion,
+		CampaignID:      execution.CampaignID,
+		TriggeredByID:   execution.TriggeredByID,
+		RequestID:       execution.RequestID,
+		Status:          execution.Status,
+		Mode:            execution.Mode,
+		CreatedAt:       execution.CreatedAt,
+		StartedAt:       execution.StartedAt,
+		CompletedAt:     execution.CompletedAt,
+		Environment:     execution.Environment,
+	}
+	summary.Error, _ = execution.Result["error"].(string)
+	summary.Dispatch = execution.Result["dispatch"]
+	if execution.StartedAt != nil && execution.CompletedAt != nil {
+		summary.Duration = execution.CompletedAt.Sub(*execution.StartedAt).Round(time.Millisecond).String()
+	}
+	return summary
+}
+
+// diagnosedSteps reads the step results of an execution, keeping the last
+// lines of each step's output
+func diagnosedSteps(result models.JSONMap, lines int) []DiagnosedStep {
+	raw, _ := result["steps"].([]interface{})
+	steps := make([]DiagnosedStep, 0, len(raw))
+	for _, item := range raw {
+		step, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		diagnosed := DiagnosedStep{
+			ExitCode:   step["exit_code"],
+			RetryCount: step["retry_count"],
+		}
+		diagnosed.ID, _ = step["step_id"].(string)
+		diagnosed.Name, _ = step["step_name"].(string)
+		diagnosed.Status, _ = step["status"].(string)
+		diagnosed.Error, _ = step["error"].(string)
+		if iterations, ok := step["iterations"].([]interface{}); ok {
+			diagnosed.Iterations = len(iterations)
+		}
+		if d, ok := models.AgentDuration(step, "duration"); ok {
+			diagnosed.Duration = d.Round(time.Millisecond).String()
+		}
+		if output, ok := step["output"].(string); ok {
+			diagnosed.Output, diagnosed.OmittedLines = tailLines(output, lines)
+		}
+		steps = append(steps, diagnosed)
+	}
+	return steps
+}
+
+// tailLines returns the last n lines of text and how many were cut
+func tailLines(text string, n int) (string, int) {
+	text = strings.TrimRight(text, "\n")
+	if text == "" {
+		return "", 0
+	}
+	all := strings.Split(text, "\n")
+	if len(all) <= n {
+		return text, 0
+	}
+	return strings.Join(all[len(all)-n:], "\n"), len(all) - n
+}
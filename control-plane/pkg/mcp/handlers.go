@@ -5,15 +5,24 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
 	"gorm.io/gorm"
 
 	"github.com/yourorg/control-plane/pkg/agent"
+	"github.com/yourorg/control-plane/pkg/analytics"
+	"github.com/yourorg/control-plane/pkg/archive"
 	"github.com/yourorg/control-plane/pkg/audit"
 	"github.com/yourorg/control-plane/pkg/campaign"
 	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/diff"
+	"github.com/yourorg/control-plane/pkg/search"
+	"github.com/yourorg/control-plane/pkg/template"
+	"github.com/yourorg/control-plane/pkg/tenant"
+	"github.com/yourorg/control-plane/pkg/tracing"
 	"github.com/yourorg/control-plane/pkg/workflow"
 )
 
@@ -25,6 +34,22 @@ type ToolHandler struct {
 	workflowManager *workflow.Manager
 	campaignManager *campaign.Manager
 	auditLogger     *audit.Logger
+	outputIndexer   *search.Indexer
+	templateManager *template.Manager
+	installScripts  *agent.InstallScriptGenerator
+	analyzer        *analytics.Analyzer
+	auditFields     *audit.FieldRegistry
+	archiver        *archive.Archiver
+
+	// Tenant administration, available to admin-scoped sessions only
+	tenantManager *tenant.Manager
+	keyManager    *agent.KeyManager
+	admin         bool
+
+	// Calls acting on more than confirmAbove agents need a confirmation
+	// token issued from confirmations; a negative limit disables this
+	confirmations *confirmations
+	confirmAbove  int
 }
 
 // NewToolHandler creates a new tool handler
@@ -35,6 +60,9 @@ func NewToolHandler(
 	workflowManager *workflow.Manager,
 	campaignManager *campaign.Manager,
 	auditLogger *audit.Logger,
+	outputIndexer *search.Indexer,
+	templateManager *template.Manager,
+	installScripts *agent.InstallScriptGenerator,
 ) *ToolHandler {
 	return &ToolHandler{
 		db:              db,
@@ -43,18 +71,49 @@ func NewToolHandler(
 		workflowManager: workflowManager,
 		campaignManager: campaignManager,
 		auditLogger:     auditLogger,
+		outputIndexer:   outputIndexer,
+		templateManager: templateManager,
+		installScripts:  installScripts,
+		analyzer:        analytics.NewAnalyzer(db, logger),
+		auditFields:     audit.NewFieldRegistry(db, logger),
 	}
 }
 
+// SetArchiver sets the archiver archived execution output is read back
+// from
+func (h *ToolHandler) SetArchiver(archiver *archive.Archiver) {
+	h.archiver = archiver
+}
+
+// SetConfirmation makes calls acting on more than above agents wait for
+// confirmation with a token from confirmations
+func (h *ToolHandler) SetConfirmation(confirmations *confirmations, above int) {
+	h.confirmations = confirmations
+	h.confirmAbove = above
+}
+
+// SetAdmin enables the tenant administration tools for an admin-scoped session
+func (h *ToolHandler) SetAdmin(tenantManager *tenant.Manager, keyManager *agent.KeyManager) {
+	h.tenantManager = tenantManager
+	h.keyManager = keyManager
+	h.admin = true
+}
+
 // HandleTool handles a tool invocation
 func (h *ToolHandler) HandleTool(ctx context.Context, name string, args map[string]interface{}) (*CallToolResult, error) {
 	h.logger.Debug("handling tool", zap.String("name", name))
 
+	if adminTools[name] && !h.admin {
+		return nil, fmt.Errorf("%s requires an admin-scoped MCP session", name)
+	}
+
 	switch name {
 	case "list_agents":
 		return h.listAgents(ctx, args)
 	case "get_agent":
 		return h.getAgent(ctx, args)
+	case "query_fleet":
+		return h.queryFleet(ctx, args)
 	case "list_workflows":
 		return h.listWorkflows(ctx, args)
 	case "get_workflow":
@@ -73,10 +132,34 @@ func (h *ToolHandler) HandleTool(ctx context.Context, name string, args map[stri
 		return h.startCampaign(ctx, args)
 	case "get_campaign_progress":
 		return h.getCampaignProgress(ctx, args)
+	case "get_campaign_readiness":
+		return h.getCampaignReadiness(ctx, args)
+	case "compare_campaigns":
+		return h.compareCampaigns(ctx, args)
 	case "search_audit_logs":
 		return h.searchAuditLogs(ctx, args)
+	case "search_execution_output":
+		return h.searchExecutionOutput(ctx, args)
+	case "diagnose_execution":
+		return h.diagnoseExecution(ctx, args)
 	case "generate_workflow":
 		return h.generateWorkflow(ctx, args)
+	case "diff_template_versions":
+		return h.diffTemplateVersions(ctx, args)
+	case "generate_install_script":
+		return h.generateInstallScript(ctx, args)
+	case "get_workflow_analytics":
+		return h.getWorkflowAnalytics(ctx, args)
+	case "list_tenants":
+		return h.listTenants(ctx, args)
+	case "get_tenant_stats":
+		return h.getTenantStats(ctx, args)
+	case "create_install_key":
+		return h.createInstallKey(ctx, args)
+	case "approve_agents":
+		return h.setAgentApproval(ctx, args, true)
+	case "reject_agents":
+		return h.setAgentApproval(ctx, args, false)
 	default:
 		return nil, fmt.Errorf("unknown tool: %s", name)
 	}
@@ -89,6 +172,7 @@ func (h *ToolHandler) listAgents(ctx context.Context, args map[string]interface{
 	}
 
 	status, _ := args["status"].(string)
+	approval, _ := args["approval_status"].(string)
 	limit := getIntArg(args, "limit", 50)
 	offset := getIntArg(args, "offset", 0)
 
@@ -103,11 +187,12 @@ func (h *ToolHandler) listAgents(ctx context.Context, args map[string]interface{
 	}
 
 	agents, total, err := h.agentRegistry.List(ctx, &agent.ListRequest{
-		TenantID: tenantID,
-		Status:   status,
-		Tags:     tags,
-		Limit:    limit,
-		Offset:   offset,
+		TenantID:       tenantID,
+		Status:         status,
+		ApprovalStatus: approval,
+		Tags:           tags,
+		Limit:          limit,
+		Offset:         offset,
 	})
 	if err != nil {
 		return nil, err
@@ -139,6 +224,36 @@ func (h *ToolHandler) getAgent(ctx context.Context, args map[string]interface{})
 	return h.jsonResult(agentData)
 }
 
+func (h *ToolHandler) queryFleet(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+	tenantID, _ := args["tenant_id"].(string)
+	query, _ := args["query"].(string)
+	if tenantID == "" || query == "" {
+		return nil, fmt.Errorf("tenant_id and query are required")
+	}
+
+	limit := getIntArg(args, "limit", 50)
+	offset := getIntArg(args, "offset", 0)
+
+	agents, total, err := h.agentRegistry.QueryFleet(ctx, &agent.FleetQueryRequest{
+		TenantID: tenantID,
+		Query:    query,
+		Limit:    limit,
+		Offset:   offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+		"agents": agents,
+	}
+
+	return h.jsonResult(result)
+}
+
 func (h *ToolHandler) listWorkflows(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
 	tenantID, _ := args["tenant_id"].(string)
 	if tenantID == "" {
@@ -149,7 +264,41 @@ func (h *ToolHandler) listWorkflows(ctx context.Context, args map[string]interfa
 	limit := getIntArg(args, "limit", 50)
 	offset := getIntArg(args, "offset", 0)
 
-	workflows, total, err := h.workflowManager.List(ctx, tenantID, models.WorkflowStatus(status), limit, offset)
+	req := &workflow.ListWorkflowsRequest{
+		TenantID:  tenantID,
+		Status:    models.WorkflowStatus(status),
+		Name:      getStringArg(args, "name", ""),
+		CreatedBy: getStringArg(args, "created_by", ""),
+		Query:     getStringArg(args, "query", ""),
+		Limit:     limit,
+		Offset:    offset,
+	}
+
+	if tagsRaw, ok := args["tags"].(map[string]interface{}); ok {
+		req.Tags = make(map[string]string)
+		for k, v := range tagsRaw {
+			if s, ok := v.(string); ok {
+				req.Tags[k] = s
+			}
+		}
+	}
+
+	if after, ok := args["executed_after"].(string); ok && after != "" {
+		t, err := time.Parse(time.RFC3339, after)
+		if err != nil {
+			return nil, fmt.Errorf("invalid executed_after: must be RFC 3339")
+		}
+		req.ExecutedAfter = &t
+	}
+	if before, ok := args["executed_before"].(string); ok && before != "" {
+		t, err := time.Parse(time.RFC3339, before)
+		if err != nil {
+			return nil, fmt.Errorf("invalid executed_before: must be RFC 3339")
+		}
+		req.ExecutedBefore = &t
+	}
+
+	workflows, total, err := h.workflowManager.List(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -206,10 +355,24 @@ func (h *ToolHandler) createWorkflow(ctx context.Context, args map[string]interf
 func (h *ToolHandler) executeWorkflow(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
 	tenantID, _ := args["tenant_id"].(string)
 	workflowID, _ := args["workflow_id"].(string)
-	agentID, _ := args["agent_id"].(string)
 
-	if tenantID == "" || workflowID == "" || agentID == "" {
-		return nil, fmt.Errorf("tenant_id, workflow_id, and agent_id are required")
+	var agentIDs []string
+	seen := make(map[string]bool)
+	addAgent := func(id interface{}) {
+		if s, ok := id.(string); ok && s != "" && !seen[s] {
+			seen[s] = true
+			agentIDs = append(agentIDs, s)
+		}
+	}
+	addAgent(args["agent_id"])
+	if ids, ok := args["agent_ids"].([]interface{}); ok {
+		for _, id := range ids {
+			addAgent(id)
+		}
+	}
+
+	if tenantID == "" || workflowID == "" || len(agentIDs) == 0 {
+		return nil, fmt.Errorf("tenant_id, workflow_id, and agent_id or agent_ids are required")
 	}
 
 	var params map[string]interface{}
@@ -217,22 +380,47 @@ func (h *ToolHandler) executeWorkflow(ctx context.Context, args map[string]inter
 		params = p
 	}
 
-	// Create execution record
-	executor := workflow.NewExecutor(h.db, h.logger)
-	executionID, err := executor.StartExecution(ctx, &workflow.ExecutionRequest{
-		TenantID:   tenantID,
-		WorkflowID: workflowID,
-		AgentID:    agentID,
-		Parameters: params,
-	})
+	// Large fan-outs wait for the caller to confirm what they are about to do
+	wf, err := h.workflowManager.Get(ctx, tenantID, workflowID)
 	if err != nil {
 		return nil, err
 	}
+	held, err := h.confirm("execute_workflow", args, len(agentIDs), map[string]interface{}{
+		"workflow_id":   wf.ID,
+		"workflow_name": wf.Name,
+		"version":       wf.Version,
+		"agent_ids":     agentIDs,
+		"parameters":    params,
+	})
+	if err != nil || held != nil {
+		return held, err
+	}
+
+	// Create execution records
+	executor := workflow.NewExecutor(h.db, h.logger)
+	var executionIDs []string
+	for _, agentID := range agentIDs {
+		executionID, err := executor.StartExecution(ctx, &workflow.ExecutionRequest{
+			TenantID:   tenantID,
+			WorkflowID: workflowID,
+			AgentID:    agentID,
+			Parameters: params,
+		})
+		if err != nil {
+			return nil, err
+		}
+		executionIDs = append(executionIDs, executionID)
+	}
 
 	result := map[string]interface{}{
-		"execution_id": executionID,
-		"status":       "pending",
-		"message":      "Workflow execution started",
+		"request_id": tracing.RequestID(ctx),
+		"status":     "pending",
+		"message":    "Workflow execution started",
+	}
+	if len(executionIDs) == 1 {
+		result["execution_id"] = executionIDs[0]
+	} else {
+		result["execution_ids"] = executionIDs
 	}
 
 	return h.jsonResult(result)
@@ -284,9 +472,25 @@ func (h *ToolHandler) createCampaign(ctx context.Context, args map[string]interf
 	workflowID, _ := args["workflow_id"].(string)
 	name, _ := args["name"].(string)
 	description, _ := args["description"].(string)
+	campaignType := models.CampaignType(getStringArg(args, "type", string(models.CampaignTypeWorkflow)))
 
-	if tenantID == "" || workflowID == "" || name == "" {
-		return nil, fmt.Errorf("tenant_id, workflow_id, and name are required")
+	if tenantID == "" || name == "" {
+		return nil, fmt.Errorf("tenant_id and name are required")
+	}
+	if campaignType == models.CampaignTypeWorkflow && workflowID == "" {
+		return nil, fmt.Errorf("workflow_id is required")
+	}
+
+	var deploy *campaign.TemplateDeployment
+	if raw, ok := args["template_deploy"].(map[string]interface{}); ok {
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template_deploy: %w", err)
+		}
+		deploy = &campaign.TemplateDeployment{}
+		if err := json.Unmarshal(data, deploy); err != nil {
+			return nil, fmt.Errorf("invalid template_deploy: %w", err)
+		}
 	}
 
 	targetSelector := make(map[string]interface{})
@@ -303,6 +507,7 @@ func (h *ToolHandler) createCampaign(ctx context.Context, args map[string]interf
 					Percentage:       getFloatArg(pm, "percentage", 0),
 					SuccessThreshold: getFloatArg(pm, "success_threshold", 95),
 					WaitMinutes:      getIntArg(pm, "wait_minutes", 15),
+					ManualApproval:   getBoolArg(pm, "manual_approval", false),
 				}
 				phases = append(phases, phase)
 			}
@@ -320,6 +525,9 @@ func (h *ToolHandler) createCampaign(ctx context.Context, args map[string]interf
 		Description:    description,
 		TargetSelector: targetSelector,
 		PhaseConfig:    phases,
+		Type:           campaignType,
+		TemplateDeploy: deploy,
+		Mode:           models.CampaignMode(getStringArg(args, "mode", string(models.CampaignModeLive))),
 	})
 	if err != nil {
 		return nil, err
@@ -336,6 +544,15 @@ func (h *ToolHandler) startCampaign(ctx context.Context, args map[string]interfa
 		return nil, fmt.Errorf("tenant_id and campaign_id are required")
 	}
 
+	preview, err := h.campaignManager.PreviewStart(ctx, tenantID, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	held, err := h.confirm("start_campaign", args, preview.TargetAgents, preview)
+	if err != nil || held != nil {
+		return held, err
+	}
+
 	if err := h.campaignManager.Start(ctx, tenantID, campaignID); err != nil {
 		return nil, err
 	}
@@ -365,6 +582,39 @@ func (h *ToolHandler) getCampaignProgress(ctx context.Context, args map[string]i
 	return h.jsonResult(progress)
 }
 
+func (h *ToolHandler) getCampaignReadiness(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+	tenantID, _ := args["tenant_id"].(string)
+	campaignID, _ := args["campaign_id"].(string)
+
+	if tenantID == "" || campaignID == "" {
+		return nil, fmt.Errorf("tenant_id and campaign_id are required")
+	}
+
+	report, err := h.campaignManager.GetReadiness(ctx, tenantID, campaignID)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.jsonResult(report)
+}
+
+func (h *ToolHandler) compareCampaigns(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+	tenantID, _ := args["tenant_id"].(string)
+	campaignID, _ := args["campaign_id"].(string)
+	baselineID, _ := args["baseline_campaign_id"].(string)
+
+	if tenantID == "" || campaignID == "" || baselineID == "" {
+		return nil, fmt.Errorf("tenant_id, campaign_id and baseline_campaign_id are required")
+	}
+
+	comparison, err := h.campaignManager.Compare(ctx, tenantID, baselineID, campaignID)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.jsonResult(comparison)
+}
+
 func (h *ToolHandler) searchAuditLogs(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
 	tenantID, _ := args["tenant_id"].(string)
 	if tenantID == "" {
@@ -376,12 +626,16 @@ func (h *ToolHandler) searchAuditLogs(ctx context.Context, args map[string]inter
 		Query:       getStringArg(args, "query", ""),
 		ActorID:     getStringArg(args, "actor_id", ""),
 		ResourceID:  getStringArg(args, "resource_id", ""),
+		RequestID:   getStringArg(args, "request_id", ""),
 		MaxHits:     getIntArg(args, "limit", 100),
 		StartOffset: 0,
 		SortBy: []audit.SortField{
 			{Field: "timestamp", Order: "desc"},
 		},
 	}
+	if query.RequestID != "" && !tracing.ValidRequestID(query.RequestID) {
+		return nil, fmt.Errorf("invalid request_id")
+	}
 
 	// Parse event types
 	if eventTypes, ok := args["event_types"].([]interface{}); ok {
@@ -413,6 +667,18 @@ func (h *ToolHandler) searchAuditLogs(ctx context.Context, args map[string]inter
 		}
 	}
 
+	// Parse custom field filters
+	if fields, ok := args["fields"].(map[string]interface{}); ok {
+		filters := make(map[string]string, len(fields))
+		for name, value := range fields {
+			filters[name] = fmt.Sprint(value)
+		}
+		var err error
+		if query.Fields, err = h.auditFields.SearchFilters(ctx, tenantID, filters); err != nil {
+			return nil, err
+		}
+	}
+
 	if h.auditLogger == nil {
 		return nil, fmt.Errorf("audit logging not configured")
 	}
@@ -425,6 +691,297 @@ func (h *ToolHandler) searchAuditLogs(ctx context.Context, args map[string]inter
 	return h.jsonResult(result)
 }
 
+func (h *ToolHandler) searchExecutionOutput(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+	tenantID, _ := args["tenant_id"].(string)
+	if tenantID == "" {
+		return nil, fmt.Errorf("tenant_id is required")
+	}
+
+	query := &search.Query{
+		TenantID:    tenantID,
+		Text:        getStringArg(args, "query", ""),
+		AgentID:     getStringArg(args, "agent_id", ""),
+		WorkflowID:  getStringArg(args, "workflow_id", ""),
+		ExecutionID: getStringArg(args, "execution_id", ""),
+		CampaignID:  getStringArg(args, "campaign_id", ""),
+		Status:      getStringArg(args, "status", ""),
+		MaxHits:     getIntArg(args, "limit", 50),
+	}
+
+	// Parse time range
+	if startTime, ok := args["start_time"].(string); ok && startTime != "" {
+		if t, err := time.Parse(time.RFC3339, startTime); err == nil {
+			query.StartTime = &t
+		}
+	}
+	if endTime, ok := args["end_time"].(string); ok && endTime != "" {
+		if t, err := time.Parse(time.RFC3339, endTime); err == nil {
+			query.EndTime = &t
+		}
+	}
+
+	if h.outputIndexer == nil {
+		return nil, fmt.Errorf("execution output search not configured")
+	}
+
+	result, err := h.outputIndexer.Search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.jsonResult(result)
+}
+
+func (h *ToolHandler) diffTemplateVersions(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+	tenantID, _ := args["tenant_id"].(string)
+	if tenantID == "" {
+		return nil, fmt.Errorf("tenant_id is required")
+	}
+	templateID, _ := args["template_id"].(string)
+	if templateID == "" {
+		return nil, fmt.Errorf("template_id is required")
+	}
+
+	fromVersion := getIntArg(args, "from_version", 0)
+	toVersion := getIntArg(args, "to_version", 0)
+	if fromVersion < 1 || toVersion < 1 {
+		return nil, fmt.Errorf("from_version and to_version are required")
+	}
+
+	if h.templateManager == nil {
+		return nil, fmt.Errorf("template management not configured")
+	}
+
+	result, err := h.templateManager.DiffVersions(ctx, tenantID, templateID, fromVersion, toVersion, getIntArg(args, "context", diff.DefaultContext))
+	if err != nil {
+		return nil, err
+	}
+
+	if !result.Changed {
+		return &CallToolResult{
+			Content: []Content{TextContent(fmt.Sprintf("Versions %d and %d of template %s are identical.", fromVersion, toVersion, templateID))},
+		}, nil
+	}
+
+	summary := fmt.Sprintf("Template %s, version %d -> %d: %d lines added, %d lines removed in %d hunks.\n\n",
+		templateID, fromVersion, toVersion, result.Additions, result.Deletions, len(result.Hunks))
+
+	return &CallToolResult{
+		Content: []Content{TextContent(summary + result.String())},
+	}, nil
+}
+
+func (h *ToolHandler) getWorkflowAnalytics(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+	tenantID, _ := args["tenant_id"].(string)
+	if tenantID == "" {
+		return nil, fmt.Errorf("tenant_id is required")
+	}
+
+	query := &analytics.WorkflowQuery{
+		TenantID:   tenantID,
+		WorkflowID: getStringArg(args, "workflow_id", ""),
+	}
+	for key, dst := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		if val := getStringArg(args, key, ""); val != "" {
+			t, err := time.Parse(time.RFC3339, val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: must be RFC 3339", key)
+			}
+			*dst = t
+		}
+	}
+
+	report, err := h.analyzer.WorkflowDurations(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.jsonResult(report)
+}
+
+func (h *ToolHandler) generateInstallScript(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+	tenantID, _ := args["tenant_id"].(string)
+	if tenantID == "" {
+		return nil, fmt.Errorf("tenant_id is required")
+	}
+
+	if h.installScripts == nil {
+		return nil, fmt.Errorf("install scripts not configured")
+	}
+
+	var tags map[string]interface{}
+	if tagsRaw, ok := args["tags"].(map[string]interface{}); ok {
+		tags = tagsRaw
+	}
+
+	script, err := h.installScripts.Generate(ctx, &agent.InstallScriptRequest{
+		TenantID:    tenantID,
+		OS:          getStringArg(args, "os", "linux"),
+		Arch:        getStringArg(args, "arch", "amd64"),
+		ExpiryHours: getIntArg(args, "expiry_hours", 0),
+		UsageLimit:  getIntArg(args, "usage_limit", 0),
+		Tags:        tags,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	prompt := "Run as a user with sudo access"
+	if script.Shell == "powershell" {
+		prompt = "Run in an elevated PowerShell prompt"
+	}
+	summary := fmt.Sprintf("%s on the %s/%s machine to install the agent. The embedded installation key %s expires at %s.\n\n",
+		prompt, script.OS, script.Arch, script.KeyID, script.ExpiresAt.Format(time.RFC3339))
+
+	return &CallToolResult{
+		Content: []Content{TextContent(summary + script.Command)},
+	}, nil
+}
+
+func (h *ToolHandler) listTenants(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+	tenants, total, err := h.tenantManager.List(ctx, &tenant.ListTenantsRequest{
+		Status: getStringArg(args, "status", ""),
+		Limit:  getIntArg(args, "limit", 50),
+		Offset: getIntArg(args, "offset", 0),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Settings may hold credentials, so only the tenant's identity and
+	// quotas are returned
+	summaries := make([]map[string]interface{}, 0, len(tenants))
+	for _, t := range tenants {
+		summaries = append(summaries, map[string]interface{}{
+			"id":                          t.ID,
+			"name":                        t.Name,
+			"description":                 t.Description,
+			"status":                      t.Status,
+			"quota_agents":                t.QuotaAgents,
+			"quota_workflows":             t.QuotaWorkflows,
+			"quota_concurrent_executions": t.QuotaConcurrentExecutions,
+			"quota_monthly_executions":    t.QuotaMonthlyExecutions,
+			"quota_monthly_minutes":       t.QuotaMonthlyMinutes,
+			"quota_hard_cap":              t.QuotaHardCap,
+			"created_at":                  t.CreatedAt,
+		})
+	}
+
+	result := map[string]interface{}{
+		"tenants": summaries,
+		"total":   total,
+	}
+
+	return h.jsonResult(result)
+}
+
+func (h *ToolHandler) getTenantStats(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+	tenantID, _ := args["tenant_id"].(string)
+	if tenantID == "" {
+		return nil, fmt.Errorf("tenant_id is required")
+	}
+
+	t, err := h.tenantManager.Get(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := h.tenantManager.GetStats(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	byStatus, err := h.agentRegistry.GetAgentCount(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{
+		"tenant_id":        t.ID,
+		"name":             t.Name,
+		"status":           t.Status,
+		"stats":            stats,
+		"agents_by_status": byStatus,
+	}
+
+	return h.jsonResult(result)
+}
+
+func (h *ToolHandler) createInstallKey(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+	tenantID, _ := args["tenant_id"].(string)
+	if tenantID == "" {
+		return nil, fmt.Errorf("tenant_id is required")
+	}
+
+	if _, err := h.tenantManager.Get(ctx, tenantID); err != nil {
+		return nil, err
+	}
+
+	var tags map[string]interface{}
+	if tagsRaw, ok := args["tags"].(map[string]interface{}); ok {
+		tags = tagsRaw
+	}
+
+	key, err := h.keyManager.CreateKey(ctx, &agent.CreateKeyRequest{
+		TenantID:    tenantID,
+		Description: getStringArg(args, "description", ""),
+		Tags:        tags,
+		ExpiryHours: getIntArg(args, "expiry_hours", 0),
+		UsageLimit:  getIntArg(args, "usage_limit", 0),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h.logger.Info("installation key created via MCP",
+		zap.String("tenant_id", tenantID),
+		zap.String("key_id", key.KeyID))
+
+	return h.jsonResult(key)
+}
+
+func (h *ToolHandler) setAgentApproval(ctx context.Context, args map[string]interface{}, approve bool) (*CallToolResult, error) {
+	tenantID, _ := args["tenant_id"].(string)
+	if tenantID == "" {
+		return nil, fmt.Errorf("tenant_id is required")
+	}
+
+	req := &agent.ApprovalRequest{}
+	if ids, ok := args["agent_ids"].([]interface{}); ok {
+		for _, id := range ids {
+			if s, ok := id.(string); ok {
+				req.AgentIDs = append(req.AgentIDs, s)
+			}
+		}
+	}
+	if tagsRaw, ok := args["tags"].(map[string]interface{}); ok {
+		req.Tags = make(map[string]string)
+		for k, v := range tagsRaw {
+			if s, ok := v.(string); ok {
+				req.Tags[k] = s
+			}
+		}
+	}
+
+	var result *agent.ApprovalResult
+	var err error
+	if approve {
+		result, err = h.agentRegistry.Approve(ctx, tenantID, req, "mcp")
+	} else {
+		result, err = h.agentRegistry.Reject(ctx, tenantID, req, "mcp")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	h.logger.Info("agent approval updated via MCP",
+		zap.String("tenant_id", tenantID),
+		zap.String("status", string(result.Status)),
+		zap.Int("agents", result.Count))
+
+	return h.jsonResult(result)
+}
+
 func (h *ToolHandler) generateWorkflow(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
 	description, _ := args["description"].(string)
 	if description == "" {
@@ -432,88 +989,45 @@ func (h *ToolHandler) generateWorkflow(ctx context.Context, args map[string]inte
 	}
 
 	targetOS := getStringArg(args, "target_os", "linux")
+	switch targetOS {
+	case "linux", "windows", "both":
+	default:
+		return nil, fmt.Errorf("invalid target_os: %s", targetOS)
+	}
 	includeRollback := getBoolArg(args, "include_rollback", true)
 
 	// Generate a template workflow based on common patterns
-	workflow := h.generateWorkflowTemplate(description, targetOS, includeRollback)
+	workflow, err := h.generateWorkflowTemplate(description, targetOS, includeRollback)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate the emitted YAML exactly as it will be stored and sent to agents
+	report := validateWorkflowYAML(workflow)
+	if !report.Valid {
+		h.logger.Warn("generated workflow failed validation",
+			zap.Int("errors", len(report.Errors)))
+	}
 
 	result := map[string]interface{}{
 		"generated_workflow": workflow,
+		"validation":         report,
 		"notes":              "This is a template workflow. Please review and customize as needed.",
 	}
 
 	return h.jsonResult(result)
 }
 
-func (h *ToolHandler) generateWorkflowTemplate(description, targetOS string, includeRollback bool) string {
-	// Generate a basic workflow template
-	template := fmt.Sprintf(`# Generated workflow for: %s
-# Target OS: %s
-
-name: generated_workflow
-description: "%s"
-
-variables:
-  backup_dir: /var/backup
-  log_file: /var/log/workflow.log
-
-steps:
-  - name: pre_check
-    description: Pre-execution checks
-    command: |
-      echo "Starting workflow execution at $(date)"
-      echo "Checking system prerequisites..."
-`, description, targetOS, description)
-
-	if targetOS == "linux" || targetOS == "both" {
-		template += `
-  - name: execute_main_linux
-    description: Main execution for Linux
-    condition: "{{ .OS == 'linux' }}"
-    command: |
-      echo "Executing main task on Linux..."
-      # Add your Linux-specific commands here
-    retry:
-      max_attempts: 3
-      delay_seconds: 10
-`
-	}
-
-	if targetOS == "windows" || targetOS == "both" {
-		template += `
-  - name: execute_main_windows
-    description: Main execution for Windows
-    condition: "{{ .OS == 'windows' }}"
-    command: |
-      Write-Host "Executing main task on Windows..."
-      # Add your Windows-specific commands here
-    shell: powershell
-    retry:
-      max_attempts: 3
-      delay_seconds: 10
-`
-	}
-
-	template += `
-  - name: verify
-    description: Verify execution
-    command: |
-      echo "Verifying execution results..."
-      # Add verification commands here
-`
-
-	if includeRollback {
-		template += `
-rollback:
-  - name: rollback_changes
-    description: Rollback on failure
-    command: |
-      echo "Rolling back changes..."
-      # Add rollback commands here
-`
-	}
-
-	return template
+func (h *ToolHandler) generateWorkflowTemplate(description, targetOS string, includeRollback bool) (string, error) {
+	wf := buildGeneratedWorkflow(description, targetOS, includeRollback)
+
+	data, err := yaml.Marshal(wf)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal workflow: %w", err)
+	}
+
+	header := fmt.Sprintf("# Generated workflow for: %s\n# Target OS: %s\n\n", strings.ReplaceAll(description, "\n", " "), targetOS)
+	return header + string(data), nil
 }
 
 func (h *ToolHandler) jsonResult(data interface{}) (*CallToolResult, error) {
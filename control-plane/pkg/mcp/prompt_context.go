@@ -0,0 +1,258 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/pkg/analytics"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+const (
+	// promptHistoryWindow is how far back execution history is summarised
+	promptHistoryWindow = 30 * 24 * time.Hour
+
+	// promptTopTags is the number of most common tag values listed
+	promptTopTags = 10
+
+	// promptPhaseHistory is the number of recent finished phases analysed
+	promptPhaseHistory = 200
+)
+
+// fleetSummary describes a tenant's agents
+type fleetSummary struct {
+	Total    int
+	Statuses map[string]int
+	OS       map[string]int
+	Tags     map[string]int // "key=value" -> agents
+}
+
+// loadFleetSummary counts the tenant's agents by status, OS and tag
+func (s *Server) loadFleetSummary(ctx context.Context, tenantID string) (*fleetSummary, error) {
+	var agents []models.Agent
+	if err := s.db.WithContext(ctx).
+		Select("status", "os", "tags").
+		Where("tenant_id = ?", tenantID).
+		Find(&agents).Error; err != nil {
+		return nil, fmt.Errorf("failed to load agents: %w", err)
+	}
+
+	summary := &fleetSummary{
+		Total:    len(agents),
+		Statuses: make(map[string]int),
+		OS:       make(map[string]int),
+		Tags:     make(map[string]int),
+	}
+	for _, a := range agents {
+		summary.Statuses[string(a.Status)]++
+		osName := a.OS
+		if osName == "" {
+			osName = "unknown"
+		}
+		summary.OS[osName]++
+		for k, v := range a.Tags {
+			summary.Tags[fmt.Sprintf("%s=%v", k, v)]++
+		}
+	}
+	return summary, nil
+}
+
+// fleetContext renders the tenant's fleet for a prompt
+func (s *Server) fleetContext(ctx context.Context, tenantID string) (string, *fleetSummary) {
+	summary, err := s.loadFleetSummary(ctx, tenantID)
+	if err != nil {
+		s.logger.Warn("failed to load fleet context for prompt", zap.String("tenant_id", tenantID), zap.Error(err))
+		return "", nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Fleet (tenant %s): %d agents\n", tenantID, summary.Total)
+	if summary.Total == 0 {
+		return b.String(), summary
+	}
+	fmt.Fprintf(&b, "- Status: %s\n", formatCounts(summary.Statuses, 0))
+	fmt.Fprintf(&b, "- OS: %s\n", formatCounts(summary.OS, 0))
+	if len(summary.Tags) > 0 {
+		fmt.Fprintf(&b, "- Most common tags: %s\n", formatCounts(summary.Tags, promptTopTags))
+	}
+	return b.String(), summary
+}
+
+// workflowContext renders recent results of the tenant's workflow named
+// name. The workflow is nil when no such workflow exists.
+func (s *Server) workflowContext(ctx context.Context, tenantID, name string) (string, *models.Workflow) {
+	var workflow models.Workflow
+	if err := s.db.WithContext(ctx).
+		Where("tenant_id = ? AND name = ? AND status <> ?", tenantID, name, models.WorkflowStatusDeleted).
+		Order("updated_at DESC").
+		First(&workflow).Error; err != nil {
+		return fmt.Sprintf("Workflow %q: not found in this tenant\n", name), nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Workflow %q: version %d, status %s\n", workflow.Name, workflow.Version, workflow.Status)
+
+	report, err := analytics.NewAnalyzer(s.db, s.logger).WorkflowDurations(ctx, &analytics.WorkflowQuery{
+		TenantID:   tenantID,
+		WorkflowID: workflow.ID,
+		Since:      time.Now().Add(-promptHistoryWindow),
+	})
+	if err != nil {
+		s.logger.Warn("failed to load workflow history for prompt", zap.String("workflow_id", workflow.ID), zap.Error(err))
+		return b.String(), &workflow
+	}
+	if len(report.Workflows) == 0 {
+		b.WriteString("- No finished executions in the last 30 days\n")
+		return b.String(), &workflow
+	}
+
+	stats := report.Workflows[0]
+	fmt.Fprintf(&b, "- Last 30 days: %d executions, %.1f%% succeeded, p50 %.0fs, p95 %.0fs\n",
+		stats.Executions, percent(stats.Succeeded, stats.Executions), stats.Duration.P50, stats.Duration.P95)
+	for _, v := range stats.Versions {
+		if v.Version == workflow.Version || v.Regression {
+			line := fmt.Sprintf("- Version %d: %d executions, %.1f%% succeeded, p95 %.0fs",
+				v.Version, v.Executions, percent(v.Executions-v.Failed, v.Executions), v.Duration.P95)
+			if v.Regression {
+				line += " (slower than the previous version)"
+			}
+			b.WriteString(line + "\n")
+		}
+	}
+	if len(stats.Outliers) > 0 {
+		fmt.Fprintf(&b, "- %d agents run it at least twice as slow as the median\n", len(stats.Outliers))
+	}
+	return b.String(), &workflow
+}
+
+// phaseHistory summarises finished phases at one position in a campaign
+type phaseHistory struct {
+	phases    int
+	failed    int
+	duration  time.Duration
+	successes int
+	targets   int
+}
+
+// phaseHistoryContext renders how long and how successfully recent campaign
+// phases ran, by phase position. Campaigns of workflowID are preferred; the
+// tenant's other campaigns are used when it has none.
+func (s *Server) phaseHistoryContext(ctx context.Context, tenantID, workflowID string) string {
+	load := func(workflowID string) ([]models.CampaignPhase, error) {
+		query := s.db.WithContext(ctx).
+			Select("campaign_phases.*").
+			Joins("JOIN campaigns ON campaigns.id = campaign_phases.campaign_id").
+			Where("campaigns.tenant_id = ? AND campaign_phases.status IN ?", tenantID, []models.PhaseStatus{
+				models.PhaseStatusSuccess,
+				models.PhaseStatusFailed,
+			}).
+			Where("campaign_phases.started_at IS NOT NULL AND campaign_phases.completed_at IS NOT NULL")
+		if workflowID != "" {
+			query = query.Where("campaigns.workflow_id = ?", workflowID)
+		}
+		var phases []models.CampaignPhase
+		err := query.Order("campaign_phases.completed_at DESC").Limit(promptPhaseHistory).Find(&phases).Error
+		return phases, err
+	}
+
+	scope := "this workflow's campaigns"
+	var phases []models.CampaignPhase
+	var err error
+	if workflowID != "" {
+		phases, err = load(workflowID)
+	}
+	if err == nil && len(phases) == 0 {
+		scope = "this tenant's campaigns"
+		phases, err = load("")
+	}
+	if err != nil {
+		s.logger.Warn("failed to load phase history for prompt", zap.String("tenant_id", tenantID), zap.Error(err))
+		return ""
+	}
+	if len(phases) == 0 {
+		return "Campaign history: no finished campaign phases yet\n"
+	}
+
+	byOrder := make(map[int]*phaseHistory)
+	for _, p := range phases {
+		h, ok := byOrder[p.PhaseOrder]
+		if !ok {
+			h = &phaseHistory{}
+			byOrder[p.PhaseOrder] = h
+		}
+		h.phases++
+		if p.Status == models.PhaseStatusFailed {
+			h.failed++
+		}
+		h.duration += p.CompletedAt.Sub(*p.StartedAt)
+		h.successes += p.SuccessCount
+		h.targets += p.SuccessCount + p.FailureCount
+	}
+
+	orders := make([]int, 0, len(byOrder))
+	for order := range byOrder {
+		orders = append(orders, order)
+	}
+	sort.Ints(orders)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Campaign history (last %d finished phases of %s):\n", len(phases), scope)
+	for _, order := range orders {
+		h := byOrder[order]
+		avg := (h.duration / time.Duration(h.phases)).Round(time.Minute)
+		fmt.Fprintf(&b, "- Phase %d: %d runs, %d failed, average duration %s, %.1f%% of executions succeeded\n",
+			order+1, h.phases, h.failed, avg, percent(h.successes, h.targets))
+	}
+	return b.String()
+}
+
+// formatCounts renders counts as "key (n)" pairs, largest first; limit 0
+// lists all
+func formatCounts(counts map[string]int, limit int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s (%d)", k, counts[k])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// percent returns n as a percentage of total
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total) * 100
+}
+
+// withLiveContext appends the live context sections to a prompt
+func withLiveContext(prompt string, sections ...string) string {
+	var parts []string
+	for _, s := range sections {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	if len(parts) == 0 {
+		return prompt
+	}
+	return prompt + "\n\nCurrent data from VM Manager:\n\n" + strings.Join(parts, "\n")
+}
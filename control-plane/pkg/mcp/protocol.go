@@ -136,12 +136,17 @@ type ToolsListResult struct {
 type CallToolRequest struct {
 	Name      string                 `json:"name"`
 	Arguments map[string]interface{} `json:"arguments,omitempty"`
+	// Meta may carry the client's request_id for the call
+	Meta map[string]interface{} `json:"_meta,omitempty"`
 }
 
 // CallToolResult represents a tools/call response
 type CallToolResult struct {
 	Content []Content `json:"content"`
 	IsError bool      `json:"isError,omitempty"`
+	// Meta carries the call's request_id, which executions it started and
+	// their audit events record
+	Meta map[string]interface{} `json:"_meta,omitempty"`
 }
 
 // Content represents content in responses
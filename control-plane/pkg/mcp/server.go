@@ -14,8 +14,15 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/yourorg/control-plane/pkg/agent"
+	"github.com/yourorg/control-plane/pkg/archive"
 	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/auth"
 	"github.com/yourorg/control-plane/pkg/campaign"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/search"
+	"github.com/yourorg/control-plane/pkg/template"
+	"github.com/yourorg/control-plane/pkg/tenant"
+	"github.com/yourorg/control-plane/pkg/tracing"
 	"github.com/yourorg/control-plane/pkg/workflow"
 )
 
@@ -27,6 +34,18 @@ type Server struct {
 	workflowManager *workflow.Manager
 	campaignManager *campaign.Manager
 	auditLogger     *audit.Logger
+	outputIndexer   *search.Indexer
+	templateManager *template.Manager
+	installScripts  *agent.InstallScriptGenerator
+	archiver        *archive.Archiver
+	tenantManager   *tenant.Manager
+	keyManager      *agent.KeyManager
+	scopes          []string
+
+	// Calls on more than confirmAbove agents wait for a confirmation token
+	// issued by this session
+	confirmations *confirmations
+	confirmAbove  int
 
 	reader io.Reader
 	writer io.Writer
@@ -43,10 +62,35 @@ type ServerConfig struct {
 	WorkflowManager *workflow.Manager
 	CampaignManager *campaign.Manager
 	AuditLogger     *audit.Logger
+	OutputIndexer   *search.Indexer
+	TemplateManager *template.Manager
+	InstallScripts  *agent.InstallScriptGenerator
+	// Archiver reads back archived execution output; nil when archival is
+	// disabled
+	Archiver *archive.Archiver
+
+	// TenantManager and KeyManager back the tenant administration tools,
+	// offered when Scopes (from the session's token) include admin. Tools
+	// the scopes do not grant are withheld; nil scopes, for sessions
+	// started without a token, grant every tenant tool.
+	TenantManager *tenant.Manager
+	KeyManager    *agent.KeyManager
+	Scopes        []string
+
+	// ConfirmAboveAgents is the number of agents execute_workflow and
+	// start_campaign may act on before the call has to be confirmed by
+	// replaying the token it returns. Zero uses DefaultConfirmAboveAgents;
+	// a negative value never asks for confirmation.
+	ConfirmAboveAgents int
 }
 
 // NewServer creates a new MCP server
 func NewServer(config *ServerConfig) *Server {
+	confirmAbove := config.ConfirmAboveAgents
+	if confirmAbove == 0 {
+		confirmAbove = DefaultConfirmAboveAgents
+	}
+
 	return &Server{
 		db:              config.DB,
 		logger:          config.Logger,
@@ -54,11 +98,39 @@ func NewServer(config *ServerConfig) *Server {
 		workflowManager: config.WorkflowManager,
 		campaignManager: config.CampaignManager,
 		auditLogger:     config.AuditLogger,
+		outputIndexer:   config.OutputIndexer,
+		templateManager: config.TemplateManager,
+		installScripts:  config.InstallScripts,
+		archiver:        config.Archiver,
+		tenantManager:   config.TenantManager,
+		keyManager:      config.KeyManager,
+		scopes:          config.Scopes,
+		confirmations:   newConfirmations(),
+		confirmAbove:    confirmAbove,
 		reader:          os.Stdin,
 		writer:          os.Stdout,
 	}
 }
 
+// isAdmin reports whether the session is admin-scoped and the tenant
+// administration tools are available
+func (s *Server) isAdmin() bool {
+	if s.tenantManager == nil || s.keyManager == nil {
+		return false
+	}
+	for _, scope := range s.scopes {
+		if scope == "admin" || scope == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// permits reports whether the session's scopes grant a tool
+func (s *Server) permits(tool string) bool {
+	return s.scopes == nil || auth.GrantsScope(s.scopes, toolScope(tool))
+}
+
 // SetIO sets custom input/output streams
 func (s *Server) SetIO(reader io.Reader, writer io.Writer) {
 	s.reader = reader
@@ -178,8 +250,10 @@ Available operations:
 - List and get agent information
 - Create and manage workflows
 - Create and execute campaigns for phased rollouts
+- Confirm large actions: execute_workflow and start_campaign calls on many agents return a preview and a confirmation_token instead of running; call again with the same arguments and the token to proceed
 - Search audit logs
-- Generate workflow definitions from natural language`,
+- Generate workflow definitions from natural language
+- Administer tenants (admin-scoped sessions only)`,
 	}
 
 	return NewSuccessResponse(request.ID, result)
@@ -187,7 +261,15 @@ Available operations:
 
 // handleToolsList handles the tools/list request
 func (s *Server) handleToolsList(ctx context.Context, request *JSONRPCRequest) *JSONRPCResponse {
-	tools := GetToolDefinitions()
+	var tools []Tool
+	for _, tool := range GetToolDefinitions() {
+		if s.permits(tool.Name) {
+			tools = append(tools, tool)
+		}
+	}
+	if s.isAdmin() {
+		tools = append(tools, GetAdminToolDefinitions()...)
+	}
 	return NewSuccessResponse(request.ID, &ToolsListResult{Tools: tools})
 }
 
@@ -206,15 +288,38 @@ func (s *Server) handleToolsCall(ctx context.Context, request *JSONRPCRequest) *
 		return NewErrorResponse(request.ID, ErrorCodeInvalidParams, "Invalid params", err.Error())
 	}
 
-	handler := NewToolHandler(s.db, s.logger, s.agentRegistry, s.workflowManager, s.campaignManager, s.auditLogger)
+	// The call keeps a well-formed request ID from the client, or gets one
+	requestID, _ := params.Meta["request_id"].(string)
+	if !tracing.ValidRequestID(requestID) {
+		requestID = tracing.NewRequestID()
+	}
+	ctx = tracing.WithRequestID(ctx, requestID)
+	meta := map[string]interface{}{"request_id": requestID}
+
+	if !adminTools[params.Name] && !s.permits(params.Name) {
+		return NewSuccessResponse(request.ID, &CallToolResult{
+			Content: []Content{TextContent(fmt.Sprintf("Error: %s requires the %s scope", params.Name, toolScope(params.Name)))},
+			IsError: true,
+			Meta:    meta,
+		})
+	}
+
+	handler := NewToolHandler(s.db, tracing.Logger(ctx, s.logger), s.agentRegistry, s.workflowManager, s.campaignManager, s.auditLogger, s.outputIndexer, s.templateManager, s.installScripts)
+	handler.SetArchiver(s.archiver)
+	handler.SetConfirmation(s.confirmations, s.confirmAbove)
+	if s.isAdmin() {
+		handler.SetAdmin(s.tenantManager, s.keyManager)
+	}
 	result, err := handler.HandleTool(ctx, params.Name, params.Arguments)
 	if err != nil {
 		return NewSuccessResponse(request.ID, &CallToolResult{
 			Content: []Content{TextContent(fmt.Sprintf("Error: %s", err.Error()))},
 			IsError: true,
+			Meta:    meta,
 		})
 	}
 
+	result.Meta = meta
 	return NewSuccessResponse(request.ID, result)
 }
 
@@ -267,6 +372,7 @@ func (s *Server) handlePromptsList(ctx context.Context, request *JSONRPCRequest)
 				{Name: "software_name", Description: "Name of the software to update", Required: true},
 				{Name: "target_version", Description: "Target version to install", Required: true},
 				{Name: "target_os", Description: "Target operating system (linux/windows)", Required: false},
+				{Name: "tenant_id", Description: "Tenant whose fleet the workflow is for; adds its agent OS and tag breakdown", Required: false},
 			},
 		},
 		{
@@ -274,6 +380,7 @@ func (s *Server) handlePromptsList(ctx context.Context, request *JSONRPCRequest)
 			Description: "Generate a workflow for running health checks on VMs",
 			Arguments: []PromptArgument{
 				{Name: "check_type", Description: "Type of health check (disk, memory, cpu, network)", Required: true},
+				{Name: "tenant_id", Description: "Tenant whose fleet the checks are for; adds its agent status and OS breakdown", Required: false},
 			},
 		},
 		{
@@ -281,8 +388,9 @@ func (s *Server) handlePromptsList(ctx context.Context, request *JSONRPCRequest)
 			Description: "Generate a campaign configuration for phased rollout",
 			Arguments: []PromptArgument{
 				{Name: "workflow_name", Description: "Name of the workflow to roll out", Required: true},
-				{Name: "target_count", Description: "Approximate number of target agents", Required: true},
+				{Name: "target_count", Description: "Approximate number of target agents; defaults to the tenant's agent count", Required: false},
 				{Name: "risk_level", Description: "Risk level (low/medium/high)", Required: false},
+				{Name: "tenant_id", Description: "Tenant to roll out in; adds its fleet, the workflow's recent success rates and past phase durations", Required: false},
 			},
 		},
 	}
@@ -301,11 +409,11 @@ func (s *Server) handlePromptsGet(ctx context.Context, request *JSONRPCRequest)
 
 	switch params.Name {
 	case "create_update_workflow":
-		result = s.generateUpdateWorkflowPrompt(params.Arguments)
+		result = s.generateUpdateWorkflowPrompt(ctx, params.Arguments)
 	case "create_health_check_workflow":
-		result = s.generateHealthCheckPrompt(params.Arguments)
+		result = s.generateHealthCheckPrompt(ctx, params.Arguments)
 	case "create_rollout_campaign":
-		result = s.generateRolloutCampaignPrompt(params.Arguments)
+		result = s.generateRolloutCampaignPrompt(ctx, params.Arguments)
 	default:
 		return NewErrorResponse(request.ID, ErrorCodeInvalidParams, "Unknown prompt", params.Name)
 	}
@@ -313,7 +421,7 @@ func (s *Server) handlePromptsGet(ctx context.Context, request *JSONRPCRequest)
 	return NewSuccessResponse(request.ID, result)
 }
 
-func (s *Server) generateUpdateWorkflowPrompt(args map[string]string) *GetPromptResult {
+func (s *Server) generateUpdateWorkflowPrompt(ctx context.Context, args map[string]string) *GetPromptResult {
 	softwareName := args["software_name"]
 	targetVersion := args["target_version"]
 	targetOS := args["target_os"]
@@ -321,12 +429,17 @@ func (s *Server) generateUpdateWorkflowPrompt(args map[string]string) *GetPrompt
 		targetOS = "linux"
 	}
 
+	var fleet string
+	if tenantID := args["tenant_id"]; tenantID != "" {
+		fleet, _ = s.fleetContext(ctx, tenantID)
+	}
+
 	return &GetPromptResult{
 		Description: fmt.Sprintf("Workflow for updating %s to version %s", softwareName, targetVersion),
 		Messages: []PromptMessage{
 			{
 				Role: "user",
-				Content: TextContent(fmt.Sprintf(`Generate a VM Manager workflow YAML for updating %s to version %s on %s systems.
+				Content: TextContent(withLiveContext(fmt.Sprintf(`Generate a VM Manager workflow YAML for updating %s to version %s on %s systems.
 
 The workflow should include:
 1. Pre-update checks (disk space, current version)
@@ -339,21 +452,26 @@ Use the standard VM Manager workflow format with:
 - name, description fields
 - steps array with step names, commands, and optional conditions
 - Support for retry on failure
-- Appropriate timeout values`, softwareName, targetVersion, targetOS)),
+- Appropriate timeout values`, softwareName, targetVersion, targetOS), fleet)),
 			},
 		},
 	}
 }
 
-func (s *Server) generateHealthCheckPrompt(args map[string]string) *GetPromptResult {
+func (s *Server) generateHealthCheckPrompt(ctx context.Context, args map[string]string) *GetPromptResult {
 	checkType := args["check_type"]
 
+	var fleet string
+	if tenantID := args["tenant_id"]; tenantID != "" {
+		fleet, _ = s.fleetContext(ctx, tenantID)
+	}
+
 	return &GetPromptResult{
 		Description: fmt.Sprintf("Health check workflow for %s monitoring", checkType),
 		Messages: []PromptMessage{
 			{
 				Role: "user",
-				Content: TextContent(fmt.Sprintf(`Generate a VM Manager workflow YAML for performing %s health checks.
+				Content: TextContent(withLiveContext(fmt.Sprintf(`Generate a VM Manager workflow YAML for performing %s health checks.
 
 The workflow should:
 1. Collect relevant metrics
@@ -361,13 +479,13 @@ The workflow should:
 3. Report status (healthy/degraded/unhealthy)
 4. Include remediation suggestions
 
-Make sure the workflow works on both Linux and Windows systems where applicable.`, checkType)),
+Make sure the workflow works on both Linux and Windows systems where applicable.`, checkType), fleet)),
 			},
 		},
 	}
 }
 
-func (s *Server) generateRolloutCampaignPrompt(args map[string]string) *GetPromptResult {
+func (s *Server) generateRolloutCampaignPrompt(ctx context.Context, args map[string]string) *GetPromptResult {
 	workflowName := args["workflow_name"]
 	targetCount := args["target_count"]
 	riskLevel := args["risk_level"]
@@ -375,12 +493,32 @@ func (s *Server) generateRolloutCampaignPrompt(args map[string]string) *GetPromp
 		riskLevel = "medium"
 	}
 
+	var fleet, history, phases string
+	if tenantID := args["tenant_id"]; tenantID != "" {
+		var summary *fleetSummary
+		fleet, summary = s.fleetContext(ctx, tenantID)
+		if targetCount == "" && summary != nil {
+			targetCount = fmt.Sprint(summary.Total)
+		}
+
+		var workflowID string
+		var wf *models.Workflow
+		history, wf = s.workflowContext(ctx, tenantID, workflowName)
+		if wf != nil {
+			workflowID = wf.ID
+		}
+		phases = s.phaseHistoryContext(ctx, tenantID, workflowID)
+	}
+	if targetCount == "" {
+		targetCount = "all matching"
+	}
+
 	return &GetPromptResult{
 		Description: fmt.Sprintf("Campaign configuration for %s rollout", workflowName),
 		Messages: []PromptMessage{
 			{
 				Role: "user",
-				Content: TextContent(fmt.Sprintf(`Generate a campaign configuration for rolling out the %s workflow to approximately %s agents.
+				Content: TextContent(withLiveContext(fmt.Sprintf(`Generate a campaign configuration for rolling out the %s workflow to approximately %s agents.
 
 Risk level: %s
 
@@ -393,7 +531,7 @@ Please recommend:
 Consider:
 - Smaller canary for high-risk changes
 - Longer wait times between phases for critical systems
-- Success thresholds based on historical data`, workflowName, targetCount, riskLevel)),
+- Success thresholds based on historical data`, workflowName, targetCount, riskLevel), fleet, history, phases)),
 			},
 		},
 	}
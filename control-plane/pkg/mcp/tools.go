@@ -1,6 +1,8 @@
 // Package mcp provides MCP (Model Context Protocol) server implementation.
 package mcp
 
+import "github.com/yourorg/control-plane/pkg/auth"
+
 // Tool definitions for the VM Manager MCP server
 
 // GetToolDefinitions returns all available tool definitions
@@ -8,6 +10,7 @@ func GetToolDefinitions() []Tool {
 	return []Tool{
 		listAgentsTool(),
 		getAgentTool(),
+		queryFleetTool(),
 		listWorkflowsTool(),
 		getWorkflowTool(),
 		createWorkflowTool(),
@@ -17,7 +20,11 @@ func GetToolDefinitions() []Tool {
 		createCampaignTool(),
 		startCampaignTool(),
 		getCampaignProgressTool(),
+		getCampaignReadinessTool(),
+		compareCampaignsTool(),
 		searchAuditLogsTool(),
+		searchExecutionOutputTool(),
+		diagnoseExecutionTool(),
 		generateWorkflowTool(),
 		// Template management tools (Salt Stack-like)
 		listTemplatesTool(),
@@ -25,9 +32,53 @@ func GetToolDefinitions() []Tool {
 		createTemplateTool(),
 		updateTemplateTool(),
 		generateTemplateWorkflowTool(),
+		diffTemplateVersionsTool(),
+		// Agent onboarding
+		generateInstallScriptTool(),
+		// Analytics
+		getWorkflowAnalyticsTool(),
+	}
+}
+
+// GetAdminToolDefinitions returns the tenant administration tools, which are
+// only offered to admin-scoped sessions
+func GetAdminToolDefinitions() []Tool {
+	return []Tool{
+		listTenantsTool(),
+		getTenantStatsTool(),
+		createInstallKeyTool(),
+		approveAgentsTool(),
+		rejectAgentsTool(),
 	}
 }
 
+// adminTools names the tools that require an admin-scoped session
+var adminTools = map[string]bool{
+	"list_tenants":       true,
+	"get_tenant_stats":   true,
+	"create_install_key": true,
+	"approve_agents":     true,
+	"reject_agents":      true,
+}
+
+// toolScopes names the scope of tools that change the tenant; every other
+// tool only reads
+var toolScopes = map[string]string{
+	"create_workflow":         auth.ScopeWrite,
+	"generate_install_script": auth.ScopeWrite,
+	"execute_workflow":        auth.ScopeExecute,
+	"create_campaign":         auth.ScopeExecute,
+	"start_campaign":          auth.ScopeExecute,
+}
+
+// toolScope returns the scope a tool requires
+func toolScope(name string) string {
+	if scope, ok := toolScopes[name]; ok {
+		return scope
+	}
+	return auth.ScopeRead
+}
+
 func listAgentsTool() Tool {
 	return Tool{
 		Name:        "list_agents",
@@ -44,6 +95,11 @@ func listAgentsTool() Tool {
 					"description": "Filter by agent status (online, offline, degraded)",
 					"enum":        []string{"online", "offline", "degraded"},
 				},
+				"approval_status": map[string]interface{}{
+					"type":        "string",
+					"description": "Filter by registration approval; pending lists the approval queue",
+					"enum":        []string{"approved", "pending", "rejected"},
+				},
 				"tags": map[string]interface{}{
 					"type":        "object",
 					"description": "Filter by tags (key-value pairs)",
@@ -88,10 +144,41 @@ func getAgentTool() Tool {
 	}
 }
 
+func queryFleetTool() Tool {
+	return Tool{
+		Name:        "query_fleet",
+		Description: "Find the agents matching an expression over their facts, tags and health, e.g. os == \"linux\" && facts.kernel < \"5.4\" && status == \"online\". Fields: id, hostname, os, arch, version, status, drain_state, approval_status, clock_skewed, latency_ms, last_seen_seconds, tags.<key>, metadata.<key>, facts.<key>, health, health.<component> and software.<package> (installed version, e.g. software.openssl < \"3.0.13\"). Operators: == != < <= > >= =~ in [...] && || ! and parentheses. Versions compare naturally (\"5.15\" > \"5.4\").",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"tenant_id": map[string]interface{}{
+					"type":        "string",
+					"description": "The tenant ID",
+				},
+				"query": map[string]interface{}{
+					"type":        "string",
+					"description": "The fleet query expression",
+				},
+				"limit": map[string]interface{}{
+					"type":        "integer",
+					"description": "Maximum number of agents to return",
+					"default":     50,
+				},
+				"offset": map[string]interface{}{
+					"type":        "integer",
+					"description": "Offset for pagination",
+					"default":     0,
+				},
+			},
+			"required": []string{"tenant_id", "query"},
+		},
+	}
+}
+
 func listWorkflowsTool() Tool {
 	return Tool{
 		Name:        "list_workflows",
-		Description: "List all workflows for a tenant",
+		Description: "List workflows for a tenant, optionally filtered by name, tags, author, last execution time or text in the definition",
 		InputSchema: map[string]interface{}{
 			"type": "object",
 			"properties": map[string]interface{}{
@@ -104,6 +191,33 @@ func listWorkflowsTool() Tool {
 					"description": "Filter by workflow status",
 					"enum":        []string{"draft", "active", "deprecated"},
 				},
+				"name": map[string]interface{}{
+					"type":        "string",
+					"description": "Filter by workflows whose name contains this text",
+				},
+				"tags": map[string]interface{}{
+					"type":        "object",
+					"description": "Filter by workflow tags (all must match)",
+					"additionalProperties": map[string]interface{}{
+						"type": "string",
+					},
+				},
+				"created_by": map[string]interface{}{
+					"type":        "string",
+					"description": "Filter by the user who created the workflow",
+				},
+				"executed_after": map[string]interface{}{
+					"type":        "string",
+					"description": "Only workflows last executed at or after this time (RFC3339)",
+				},
+				"executed_before": map[string]interface{}{
+					"type":        "string",
+					"description": "Only workflows last executed at or before this time (RFC3339)",
+				},
+				"query": map[string]interface{}{
+					"type":        "string",
+					"description": "Free-text search over workflow names, descriptions and definitions (e.g. a command or package name)",
+				},
 				"limit": map[string]interface{}{
 					"type":        "integer",
 					"description": "Maximum number of workflows to return",
@@ -173,7 +287,7 @@ func createWorkflowTool() Tool {
 func executeWorkflowTool() Tool {
 	return Tool{
 		Name:        "execute_workflow",
-		Description: "Execute a workflow on a specific agent",
+		Description: "Execute a workflow on one or more agents. A call on many agents returns a preview and a confirmation_token instead of running; call again with the same arguments and the token to proceed",
 		InputSchema: map[string]interface{}{
 			"type": "object",
 			"properties": map[string]interface{}{
@@ -189,13 +303,24 @@ func executeWorkflowTool() Tool {
 					"type":        "string",
 					"description": "The agent ID to execute on",
 				},
+				"agent_ids": map[string]interface{}{
+					"type":        "array",
+					"description": "Agent IDs to execute on, instead of or besides agent_id",
+					"items": map[string]interface{}{
+						"type": "string",
+					},
+				},
 				"parameters": map[string]interface{}{
 					"type":        "object",
 					"description": "Parameters to pass to the workflow",
 					"additionalProperties": true,
 				},
+				"confirmation_token": map[string]interface{}{
+					"type":        "string",
+					"description": "Token returned by a previous call with the same arguments, confirming it",
+				},
 			},
-			"required": []string{"tenant_id", "workflow_id", "agent_id"},
+			"required": []string{"tenant_id", "workflow_id"},
 		},
 	}
 }
@@ -256,7 +381,7 @@ func getCampaignTool() Tool {
 func createCampaignTool() Tool {
 	return Tool{
 		Name:        "create_campaign",
-		Description: "Create a new campaign for phased workflow rollout",
+		Description: "Create a new campaign for phased workflow rollout, or with type template_deploy a phased rollout of a template to target agents",
 		InputSchema: map[string]interface{}{
 			"type": "object",
 			"properties": map[string]interface{}{
@@ -264,9 +389,39 @@ func createCampaignTool() Tool {
 					"type":        "string",
 					"description": "The tenant ID",
 				},
+				"type": map[string]interface{}{
+					"type":        "string",
+					"description": "Campaign type: workflow runs workflow_id; template_deploy generates a deployment workflow from template_deploy",
+					"enum":        []string{"workflow", "template_deploy"},
+					"default":     "workflow",
+				},
+				"mode": map[string]interface{}{
+					"type":        "string",
+					"description": "Campaign mode: live rolls out; dark_launch runs every phase in validate mode without changing agents and produces a readiness report (get_campaign_readiness)",
+					"enum":        []string{"live", "dark_launch"},
+					"default":     "live",
+				},
 				"workflow_id": map[string]interface{}{
 					"type":        "string",
-					"description": "The workflow ID to execute",
+					"description": "The workflow ID to execute (workflow campaigns only)",
+				},
+				"template_deploy": map[string]interface{}{
+					"type":        "object",
+					"description": "Template deployment for template_deploy campaigns. Each agent deploys the rendered template, runs restart_command if set, then re-checks the file for drift; progress includes per-agent diff summaries.",
+					"properties": map[string]interface{}{
+						"template_id":      map[string]interface{}{"type": "string", "description": "The active template to deploy"},
+						"template_version": map[string]interface{}{"type": "integer", "description": "Version to deploy; the current version is pinned when omitted"},
+						"dest":             map[string]interface{}{"type": "string", "description": "Destination path on target agents (supports variables)"},
+						"variables":        map[string]interface{}{"type": "object", "description": "Variables passed to the template", "additionalProperties": true},
+						"mode":             map[string]interface{}{"type": "string", "description": "File permissions (e.g., '0644')"},
+						"owner":            map[string]interface{}{"type": "string", "description": "File owner"},
+						"group":            map[string]interface{}{"type": "string", "description": "File group"},
+						"backup":           map[string]interface{}{"type": "boolean", "description": "Back up the existing file", "default": true},
+						"create_dirs":      map[string]interface{}{"type": "boolean", "description": "Create missing parent directories"},
+						"restart_command":  map[string]interface{}{"type": "string", "description": "Command run after deployment (e.g., 'systemctl reload nginx')"},
+						"drift_check":      map[string]interface{}{"type": "boolean", "description": "Fail agents whose file no longer matches after deployment", "default": true},
+					},
+					"required": []string{"template_id", "dest"},
 				},
 				"name": map[string]interface{}{
 					"type":        "string",
@@ -289,6 +444,10 @@ func createCampaignTool() Tool {
 						"status": map[string]interface{}{
 							"type": "string",
 						},
+						"exclude_flapping": map[string]interface{}{
+							"type":        "object",
+							"description": "Skip agents whose health status changed at least max_transitions times within window (e.g. {\"window\": \"1h\", \"max_transitions\": 3})",
+						},
 					},
 				},
 				"phases": map[string]interface{}{
@@ -315,12 +474,17 @@ func createCampaignTool() Tool {
 								"description": "Minutes to wait after phase completion",
 								"default":     15,
 							},
+							"manual_approval": map[string]interface{}{
+								"type":        "boolean",
+								"description": "Halt after this phase until it is approved via POST /campaigns/:id/phases/:phase/approve",
+								"default":     false,
+							},
 						},
 						"required": []string{"name", "percentage"},
 					},
 				},
 			},
-			"required": []string{"tenant_id", "workflow_id", "name", "target_selector", "phases"},
+			"required": []string{"tenant_id", "name", "target_selector", "phases"},
 		},
 	}
 }
@@ -328,7 +492,7 @@ func createCampaignTool() Tool {
 func startCampaignTool() Tool {
 	return Tool{
 		Name:        "start_campaign",
-		Description: "Start a campaign",
+		Description: "Start a campaign. Starting one that targets many agents returns a preview and a confirmation_token instead; call again with the same arguments and the token to proceed",
 		InputSchema: map[string]interface{}{
 			"type": "object",
 			"properties": map[string]interface{}{
@@ -340,6 +504,10 @@ func startCampaignTool() Tool {
 					"type":        "string",
 					"description": "The campaign ID to start",
 				},
+				"confirmation_token": map[string]interface{}{
+					"type":        "string",
+					"description": "Token returned by a previous call with the same arguments, confirming it",
+				},
 			},
 			"required": []string{"tenant_id", "campaign_id"},
 		},
@@ -367,6 +535,52 @@ func getCampaignProgressTool() Tool {
 	}
 }
 
+func getCampaignReadinessTool() Tool {
+	return Tool{
+		Name:        "get_campaign_readiness",
+		Description: "Get the readiness report of a dark-launch campaign: per-agent would-change diffs and failed pre-checks, summed up per phase",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"tenant_id": map[string]interface{}{
+					"type":        "string",
+					"description": "The tenant ID",
+				},
+				"campaign_id": map[string]interface{}{
+					"type":        "string",
+					"description": "The dark-launch campaign ID",
+				},
+			},
+			"required": []string{"tenant_id", "campaign_id"},
+		},
+	}
+}
+
+func compareCampaignsTool() Tool {
+	return Tool{
+		Name:        "compare_campaigns",
+		Description: "Compare a campaign with an earlier campaign of the same workflow, e.g. this month's patch with last month's: success rate and duration deltas overall and per phase, failing steps, agents that newly fail or recovered, and the tags the newly failing agents have in common. Use it to answer whether a fix improved rollout health.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"tenant_id": map[string]interface{}{
+					"type":        "string",
+					"description": "The tenant ID",
+				},
+				"campaign_id": map[string]interface{}{
+					"type":        "string",
+					"description": "The campaign to evaluate",
+				},
+				"baseline_campaign_id": map[string]interface{}{
+					"type":        "string",
+					"description": "The earlier campaign to compare against",
+				},
+			},
+			"required": []string{"tenant_id", "campaign_id", "baseline_campaign_id"},
+		},
+	}
+}
+
 func searchAuditLogsTool() Tool {
 	return Tool{
 		Name:        "search_audit_logs",
@@ -387,7 +601,7 @@ func searchAuditLogsTool() Tool {
 					"description": "Filter by event types",
 					"items": map[string]interface{}{
 						"type": "string",
-						"enum": []string{"auth", "agent", "workflow", "campaign", "tenant", "config", "api", "system"},
+						"enum": []string{"auth", "agent", "workflow", "campaign", "tenant", "config", "api", "system", "custom", "alert"},
 					},
 				},
 				"actions": map[string]interface{}{
@@ -405,6 +619,15 @@ func searchAuditLogsTool() Tool {
 					"type":        "string",
 					"description": "Filter by resource ID",
 				},
+				"request_id": map[string]interface{}{
+					"type":        "string",
+					"description": "Filter by the request ID of the API request or tool call that caused the events, as recorded on executions",
+				},
+				"fields": map[string]interface{}{
+					"type":                 "object",
+					"description":          "Filter by the tenant's custom audit fields, field name to exact value, e.g. {\"change_ticket\": \"CHG-1234\"}",
+					"additionalProperties": map[string]interface{}{"type": []string{"string", "number", "boolean"}},
+				},
 				"start_time": map[string]interface{}{
 					"type":        "string",
 					"format":      "date-time",
@@ -426,10 +649,98 @@ func searchAuditLogsTool() Tool {
 	}
 }
 
+func searchExecutionOutputTool() Tool {
+	return Tool{
+		Name:        "search_execution_output",
+		Description: "Search workflow step output and errors across executions on all agents, for example \"connection refused\" over the last week. Results are newest first.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"tenant_id": map[string]interface{}{
+					"type":        "string",
+					"description": "The tenant ID",
+				},
+				"query": map[string]interface{}{
+					"type":        "string",
+					"description": "Search query over step output and errors; quote phrases, e.g. \"connection refused\"",
+				},
+				"agent_id": map[string]interface{}{
+					"type":        "string",
+					"description": "Filter by agent ID",
+				},
+				"workflow_id": map[string]interface{}{
+					"type":        "string",
+					"description": "Filter by workflow ID",
+				},
+				"execution_id": map[string]interface{}{
+					"type":        "string",
+					"description": "Filter by execution ID",
+				},
+				"campaign_id": map[string]interface{}{
+					"type":        "string",
+					"description": "Filter by campaign ID",
+				},
+				"status": map[string]interface{}{
+					"type":        "string",
+					"description": "Filter by step status",
+					"enum":        []string{"success", "failed", "skipped", "cancelled"},
+				},
+				"start_time": map[string]interface{}{
+					"type":        "string",
+					"format":      "date-time",
+					"description": "Start time for the search range (ISO 8601)",
+				},
+				"end_time": map[string]interface{}{
+					"type":        "string",
+					"format":      "date-time",
+					"description": "End time for the search range (ISO 8601)",
+				},
+				"limit": map[string]interface{}{
+					"type":        "integer",
+					"description": "Maximum number of results",
+					"default":     50,
+				},
+			},
+			"required": []string{"tenant_id"},
+		},
+	}
+}
+
+func diagnoseExecutionTool() Tool {
+	return Tool{
+		Name:        "diagnose_execution",
+		Description: "Gather what is known about one execution in a single call: its step results with the last lines of output, the failed step, the agent's health report from before it started and health changes around it, and recent audit events about the execution and its agent. Start here when asked why an execution failed.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"tenant_id": map[string]interface{}{
+					"type":        "string",
+					"description": "The tenant ID",
+				},
+				"execution_id": map[string]interface{}{
+					"type":        "string",
+					"description": "The execution ID",
+				},
+				"output_lines": map[string]interface{}{
+					"type":        "integer",
+					"description": "Lines of output kept from the end of each step (at most 400)",
+					"default":     40,
+				},
+				"window_minutes": map[string]interface{}{
+					"type":        "integer",
+					"description": "Minutes before the execution started and after it ended to include health changes and audit events from",
+					"default":     15,
+				},
+			},
+			"required": []string{"tenant_id", "execution_id"},
+		},
+	}
+}
+
 func generateWorkflowTool() Tool {
 	return Tool{
 		Name:        "generate_workflow",
-		Description: "Generate a workflow YAML definition based on a natural language description. This helps create workflows for common operations.",
+		Description: "Generate a workflow YAML definition based on a natural language description. The workflow follows the agent executor schema (step ids, types, duration timeouts) and is returned with a validation report listing errors and warnings.",
 		InputSchema: map[string]interface{}{
 			"type": "object",
 			"properties": map[string]interface{}{
@@ -445,7 +756,7 @@ func generateWorkflowTool() Tool {
 				},
 				"include_rollback": map[string]interface{}{
 					"type":        "boolean",
-					"description": "Whether to include rollback steps",
+					"description": "Whether to include rollback steps (emitted as on_failure hooks)",
 					"default":     true,
 				},
 			},
@@ -565,7 +876,7 @@ func createTemplateTool() Tool {
 func updateTemplateTool() Tool {
 	return Tool{
 		Name:        "update_template",
-		Description: "Update an existing template. Creates a new version when content is changed.",
+		Description: "Update an existing template. Every update creates a new version.",
 		InputSchema: map[string]interface{}{
 			"type": "object",
 			"properties": map[string]interface{}{
@@ -659,3 +970,232 @@ func generateTemplateWorkflowTool() Tool {
 		},
 	}
 }
+
+func diffTemplateVersionsTool() Tool {
+	return Tool{
+		Name:        "diff_template_versions",
+		Description: "Show a unified diff between two versions of a template, with added and removed line counts. Use this to summarize what changed between template versions.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"tenant_id": map[string]interface{}{
+					"type":        "string",
+					"description": "The tenant ID",
+				},
+				"template_id": map[string]interface{}{
+					"type":        "string",
+					"description": "The template ID",
+				},
+				"from_version": map[string]interface{}{
+					"type":        "integer",
+					"description": "The older version number",
+				},
+				"to_version": map[string]interface{}{
+					"type":        "integer",
+					"description": "The newer version number",
+				},
+				"context": map[string]interface{}{
+					"type":        "integer",
+					"description": "Number of unchanged lines shown around each change",
+					"default":     3,
+				},
+			},
+			"required": []string{"tenant_id", "template_id", "from_version", "to_version"},
+		},
+	}
+}
+
+func generateInstallScriptTool() Tool {
+	return Tool{
+		Name:        "generate_install_script",
+		Description: "Create a single-use installation key and a one-line shell (Linux, macOS) or PowerShell (Windows) command that downloads the agent, verifies its checksum and installs it. Give the command to the user to run on the machine being onboarded; the key is only shown once.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"tenant_id": map[string]interface{}{
+					"type":        "string",
+					"description": "The tenant ID",
+				},
+				"os": map[string]interface{}{
+					"type":        "string",
+					"description": "Operating system of the machine",
+					"enum":        []string{"linux", "darwin", "windows"},
+					"default":     "linux",
+				},
+				"arch": map[string]interface{}{
+					"type":        "string",
+					"description": "CPU architecture of the machine",
+					"enum":        []string{"amd64", "arm64"},
+					"default":     "amd64",
+				},
+				"expiry_hours": map[string]interface{}{
+					"type":        "integer",
+					"description": "Hours until the installation key expires",
+					"default":     24,
+				},
+				"usage_limit": map[string]interface{}{
+					"type":        "integer",
+					"description": "Number of agents that can register with the key",
+					"default":     1,
+				},
+				"tags": map[string]interface{}{
+					"type":        "object",
+					"description": "Tags applied to agents that register with the key",
+				},
+			},
+			"required": []string{"tenant_id"},
+		},
+	}
+}
+
+func listTenantsTool() Tool {
+	return Tool{
+		Name:        "list_tenants",
+		Description: "List tenants, newest first. Deleted tenants are omitted unless filtered by status. Requires an admin-scoped session.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"status": map[string]interface{}{
+					"type":        "string",
+					"description": "Filter by tenant status",
+					"enum":        []string{"active", "suspended", "deleted"},
+				},
+				"limit": map[string]interface{}{
+					"type":        "integer",
+					"description": "Maximum number of tenants to return",
+					"default":     50,
+				},
+				"offset": map[string]interface{}{
+					"type":        "integer",
+					"description": "Offset for pagination",
+					"default":     0,
+				},
+			},
+		},
+	}
+}
+
+func getTenantStatsTool() Tool {
+	return Tool{
+		Name:        "get_tenant_stats",
+		Description: "Get a tenant's agent, workflow and campaign counts, with agents broken down by status (online, offline, degraded). Requires an admin-scoped session.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"tenant_id": map[string]interface{}{
+					"type":        "string",
+					"description": "The tenant ID",
+				},
+			},
+			"required": []string{"tenant_id"},
+		},
+	}
+}
+
+func createInstallKeyTool() Tool {
+	return Tool{
+		Name:        "create_install_key",
+		Description: "Create an installation key agents use to register with a tenant. The key is only shown once. Use generate_install_script instead for a ready-to-run install command. Requires an admin-scoped session.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"tenant_id": map[string]interface{}{
+					"type":        "string",
+					"description": "The tenant ID",
+				},
+				"description": map[string]interface{}{
+					"type":        "string",
+					"description": "What the key is for",
+				},
+				"expiry_hours": map[string]interface{}{
+					"type":        "integer",
+					"description": "Hours until the key expires",
+					"default":     24,
+				},
+				"usage_limit": map[string]interface{}{
+					"type":        "integer",
+					"description": "Number of agents that can register with the key",
+					"default":     1,
+				},
+				"tags": map[string]interface{}{
+					"type":        "object",
+					"description": "Tags applied to agents that register with the key",
+				},
+			},
+			"required": []string{"tenant_id"},
+		},
+	}
+}
+
+func approveAgentsTool() Tool {
+	return Tool{
+		Name:        "approve_agents",
+		Description: "Approve agents waiting in the registration approval queue so they receive executions, by ID or every pending agent with the given tags. Rejected agents can be approved by ID. Requires an admin-scoped session.",
+		InputSchema: approvalInputSchema(),
+	}
+}
+
+func rejectAgentsTool() Tool {
+	return Tool{
+		Name:        "reject_agents",
+		Description: "Reject pending agents, by ID or every pending agent with the given tags. Rejected agents receive no executions and cannot re-register until approved or deregistered. Requires an admin-scoped session.",
+		InputSchema: approvalInputSchema(),
+	}
+}
+
+// approvalInputSchema is the input of approve_agents and reject_agents
+func approvalInputSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"tenant_id": map[string]interface{}{
+				"type":        "string",
+				"description": "The tenant ID",
+			},
+			"agent_ids": map[string]interface{}{
+				"type":        "array",
+				"description": "The agents to update",
+				"items":       map[string]interface{}{"type": "string"},
+			},
+			"tags": map[string]interface{}{
+				"type":        "object",
+				"description": "Select every pending agent with these tags (key-value pairs)",
+				"additionalProperties": map[string]interface{}{
+					"type": "string",
+				},
+			},
+		},
+		"required": []string{"tenant_id"},
+	}
+}
+
+func getWorkflowAnalyticsTool() Tool {
+	return Tool{
+		Name:        "get_workflow_analytics",
+		Description: "Analyze workflow execution durations: p50/p95 per workflow and step, the trend across workflow versions with regressions flagged, and agents that run a workflow much slower than the rest. Use it to spot slowdowns after a workflow was edited.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"tenant_id": map[string]interface{}{
+					"type":        "string",
+					"description": "The tenant ID",
+				},
+				"workflow_id": map[string]interface{}{
+					"type":        "string",
+					"description": "Analyze a single workflow; all workflows when omitted",
+				},
+				"since": map[string]interface{}{
+					"type":        "string",
+					"format":      "date-time",
+					"description": "Start of the period by execution completion time (ISO 8601); defaults to 30 days ago",
+				},
+				"until": map[string]interface{}{
+					"type":        "string",
+					"format":      "date-time",
+					"description": "End of the period (ISO 8601); defaults to now",
+				},
+			},
+			"required": []string{"tenant_id"},
+		},
+	}
+}
@@ -0,0 +1,297 @@
+// Package mcp provides MCP (Model Context Protocol) server implementation.
+package mcp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/yourorg/control-plane/pkg/workflow"
+)
+
+// generatedWorkflow mirrors the agent executor's workflow schema (probe.Workflow).
+// Durations are strings such as "5m" so they round-trip through the agent's YAML parser.
+type generatedWorkflow struct {
+	ID          string            `yaml:"id,omitempty"`
+	Name        string            `yaml:"name"`
+	Description string            `yaml:"description,omitempty"`
+	Version     string            `yaml:"version,omitempty"`
+	Timeout     string            `yaml:"timeout,omitempty"`
+	Vars        map[string]string `yaml:"vars,omitempty"`
+	Steps       []generatedStep   `yaml:"steps"`
+	OnFailure   []generatedStep   `yaml:"on_failure,omitempty"`
+}
+
+// generatedStep mirrors the agent executor's step schema (probe.Step)
+type generatedStep struct {
+	ID              string   `yaml:"id"`
+	Name            string   `yaml:"name"`
+	Type            string   `yaml:"type"`
+	Command         string   `yaml:"command,omitempty"`
+	Args            []string `yaml:"args,omitempty"`
+	Timeout         string   `yaml:"timeout,omitempty"`
+	RetryCount      int      `yaml:"retry_count,omitempty"`
+	RetryDelay      string   `yaml:"retry_delay,omitempty"`
+	ContinueOnError bool     `yaml:"continue_on_error,omitempty"`
+	Condition       string   `yaml:"condition,omitempty"`
+}
+
+// ValidationIssue is a single finding in a workflow validation report
+type ValidationIssue struct {
+	Field    string `json:"field"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// ValidationReport is the machine-readable result of validating a workflow
+type ValidationReport struct {
+	Valid    bool              `json:"valid"`
+	Errors   []ValidationIssue `json:"errors"`
+	Warnings []ValidationIssue `json:"warnings"`
+}
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// linuxCondition is evaluated by the agent with sh -c before running a step
+const linuxCondition = `test "$(uname -s)" = Linux`
+
+// executableStepTypes are the step types the agent executor can run today
+var executableStepTypes = map[string]bool{
+	"command":  true,
+	"script":   true,
+	"template": true,
+}
+
+// buildGeneratedWorkflow builds a template workflow that follows the executor schema
+func buildGeneratedWorkflow(description, targetOS string, includeRollback bool) *generatedWorkflow {
+	wf := &generatedWorkflow{
+		Name:        "generated_workflow",
+		Description: description,
+		Version:     "1.0.0",
+		Timeout:     "30m",
+		Vars: map[string]string{
+			"target_os": targetOS,
+		},
+	}
+
+	linux := targetOS == "linux" || targetOS == "both"
+	windows := targetOS == "windows" || targetOS == "both"
+
+	if linux {
+		wf.Steps = append(wf.Steps,
+			osStep("pre_check_linux", "Pre-execution checks (Linux)", "linux", targetOS,
+				`echo "Starting workflow execution at $(date)"`+"\n"+`echo "Checking system prerequisites..."`),
+		)
+	}
+	if windows {
+		wf.Steps = append(wf.Steps,
+			osStep("pre_check_windows", "Pre-execution checks (Windows)", "windows", targetOS,
+				`Write-Host "Starting workflow execution at $(Get-Date)"`+"\n"+`Write-Host "Checking system prerequisites..."`),
+		)
+	}
+
+	if linux {
+		step := osStep("execute_main_linux", "Main execution (Linux)", "linux", targetOS,
+			`echo "Executing main task on Linux..."`+"\n"+`# Add your Linux-specific commands here`)
+		step.Timeout = "10m"
+		step.RetryCount = 3
+		step.RetryDelay = "10s"
+		wf.Steps = append(wf.Steps, step)
+	}
+	if windows {
+		step := osStep("execute_main_windows", "Main execution (Windows)", "windows", targetOS,
+			`Write-Host "Executing main task on Windows..."`+"\n"+`# Add your Windows-specific commands here`)
+		step.Timeout = "10m"
+		step.RetryCount = 3
+		step.RetryDelay = "10s"
+		wf.Steps = append(wf.Steps, step)
+	}
+
+	if linux {
+		wf.Steps = append(wf.Steps,
+			osStep("verify_linux", "Verify execution (Linux)", "linux", targetOS,
+				`echo "Verifying execution results..."`+"\n"+`# Add verification commands here`),
+		)
+	}
+	if windows {
+		wf.Steps = append(wf.Steps,
+			osStep("verify_windows", "Verify execution (Windows)", "windows", targetOS,
+				`Write-Host "Verifying execution results..."`+"\n"+`# Add verification commands here`),
+		)
+	}
+
+	if includeRollback {
+		if linux {
+			step := osStep("rollback_linux", "Rollback changes (Linux)", "linux", targetOS,
+				`echo "Rolling back changes..."`+"\n"+`# Add rollback commands here`)
+			step.ContinueOnError = true
+			wf.OnFailure = append(wf.OnFailure, step)
+		}
+		if windows {
+			step := osStep("rollback_windows", "Rollback changes (Windows)", "windows", targetOS,
+				`Write-Host "Rolling back changes..."`+"\n"+`# Add rollback commands here`)
+			step.ContinueOnError = true
+			wf.OnFailure = append(wf.OnFailure, step)
+		}
+	}
+
+	return wf
+}
+
+// osStep builds a command step for one operating system. The agent runs
+// commands with sh -c, so Windows steps invoke PowerShell through args.
+func osStep(id, name, stepOS, targetOS, body string) generatedStep {
+	step := generatedStep{
+		ID:      id,
+		Name:    name,
+		Type:    "command",
+		Timeout: "5m",
+	}
+
+	if stepOS == "windows" {
+		step.Args = []string{"powershell.exe", "-NoProfile", "-NonInteractive", "-Command", body}
+	} else {
+		step.Command = body
+	}
+
+	// Mixed fleets skip Linux steps on other hosts
+	if stepOS == "linux" && targetOS == "both" {
+		step.Condition = linuxCondition
+	}
+
+	return step
+}
+
+// validateWorkflowYAML parses a workflow document and validates it against
+// the executor schema, adding static analysis warnings
+func validateWorkflowYAML(content string) *ValidationReport {
+	report := &ValidationReport{
+		Errors:   []ValidationIssue{},
+		Warnings: []ValidationIssue{},
+	}
+
+	var definition map[string]interface{}
+	if err := yaml.Unmarshal([]byte(content), &definition); err != nil {
+		report.Errors = append(report.Errors, ValidationIssue{
+			Field:    "",
+			Severity: severityError,
+			Message:  fmt.Sprintf("failed to parse workflow: %v", err),
+		})
+		return report
+	}
+
+	if err := workflow.NewValidator().Validate(definition); err != nil {
+		var verrs workflow.ValidationErrors
+		if errors.As(err, &verrs) {
+			for _, verr := range verrs {
+				report.Errors = append(report.Errors, ValidationIssue{
+					Field:    verr.Field,
+					Severity: severityError,
+					Message:  verr.Message,
+				})
+			}
+		} else {
+			report.Errors = append(report.Errors, ValidationIssue{
+				Severity: severityError,
+				Message:  err.Error(),
+			})
+		}
+	}
+
+	report.Warnings = append(report.Warnings, analyzeWorkflow(definition)...)
+	report.Valid = len(report.Errors) == 0
+
+	return report
+}
+
+// analyzeWorkflow reports issues that pass schema validation but are likely
+// to misbehave on the agent
+func analyzeWorkflow(definition map[string]interface{}) []ValidationIssue {
+	var warnings []ValidationIssue
+
+	for _, field := range []string{"steps", "on_success", "on_failure", "on_cancel"} {
+		steps, _ := definition[field].([]interface{})
+		for i, step := range steps {
+			stepMap, ok := step.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			prefix := fmt.Sprintf("%s[%d]", field, i)
+
+			stepType, _ := stepMap["type"].(string)
+			if stepType != "" && !executableStepTypes[stepType] {
+				warnings = append(warnings, ValidationIssue{
+					Field:    prefix + ".type",
+					Severity: severityWarning,
+					Message:  fmt.Sprintf("step type %q is accepted but not executed by the agent", stepType),
+				})
+			}
+
+			if _, ok := stepMap["timeout"]; !ok {
+				warnings = append(warnings, ValidationIssue{
+					Field:    prefix + ".timeout",
+					Severity: severityWarning,
+					Message:  "no timeout set; the agent default of 5m applies",
+				})
+			}
+
+			if _, ok := stepMap["retry_count"]; ok {
+				if _, ok := stepMap["retry_delay"]; !ok {
+					warnings = append(warnings, ValidationIssue{
+						Field:    prefix + ".retry_delay",
+						Severity: severityWarning,
+						Message:  "retries are attempted immediately without retry_delay",
+					})
+				}
+			}
+
+			if condition, ok := stepMap["condition"].(string); ok && strings.Contains(condition, "{{") {
+				warnings = append(warnings, ValidationIssue{
+					Field:    prefix + ".condition",
+					Severity: severityWarning,
+					Message:  "conditions are run as shell commands, not templates",
+				})
+			}
+
+			if hasPlaceholderOnly(stepMap) {
+				warnings = append(warnings, ValidationIssue{
+					Field:    prefix,
+					Severity: severityWarning,
+					Message:  "step only contains placeholder commands; add the real implementation",
+				})
+			}
+		}
+	}
+
+	return warnings
+}
+
+// hasPlaceholderOnly reports whether a step's command only echoes and comments
+func hasPlaceholderOnly(step map[string]interface{}) bool {
+	body, _ := step["command"].(string)
+	if args, ok := step["args"].([]interface{}); ok && len(args) > 0 {
+		body, _ = args[len(args)-1].(string)
+	}
+	if body == "" {
+		return false
+	}
+
+	hasPlaceholder := false
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "# Add "):
+			hasPlaceholder = true
+		case strings.HasPrefix(line, "#"), strings.HasPrefix(line, "echo "), strings.HasPrefix(line, "Write-Host "):
+		default:
+			return false
+		}
+	}
+	return hasPlaceholder
+}
@@ -0,0 +1,739 @@
+// Package overview aggregates the state of the whole platform, across
+// tenants and their databases, for the operator dashboard.
+package overview
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/db"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/search"
+)
+
+const (
+	// DefaultWindow is how far back failures are counted
+	DefaultWindow = 24 * time.Hour
+	// MaxWindow bounds the window, which is scanned on every request
+	MaxWindow = 7 * 24 * time.Hour
+
+	// DefaultLimit is the number of entries in each ranked list
+	DefaultLimit = 10
+	// MaxLimit bounds the ranked lists
+	MaxLimit = 100
+
+	// maxActiveCampaigns bounds the campaigns listed as running now
+	maxActiveCampaigns = 50
+
+	// pingTimeout bounds the database health checks
+	pingTimeout = 2 * time.Second
+)
+
+// sharedDatabase names the shared database in section errors
+const sharedDatabase = "shared"
+
+// Request selects the window failures are counted over and the length of
+// the ranked lists
+type Request struct {
+	Window time.Duration
+	Limit  int
+}
+
+// Overview is the platform state at GeneratedAt. Counts cover the shared
+// database and every reachable tenant database; Errors lists what could
+// not be read, so a partial overview is still returned.
+type Overview struct {
+	GeneratedAt time.Time        `json:"generated_at"`
+	Window      string           `json:"window"`
+	Since       time.Time        `json:"since"`
+	Tenants     TenantSummary    `json:"tenants"`
+	Agents      AgentSummary     `json:"agents"`
+	Campaigns   CampaignSummary  `json:"campaigns"`
+	Executions  ExecutionSummary `json:"executions"`
+	Hotspots    Hotspots         `json:"failure_hotspots"`
+	Backlogs    Backlogs         `json:"backlogs"`
+	Databases   DatabaseSummary  `json:"databases"`
+	Errors      []SectionError   `json:"errors,omitempty"`
+}
+
+// SectionError is a part of the overview that could not be read
+type SectionError struct {
+	Section string `json:"section"`
+	// Database is "shared" or the tenant whose database failed
+	Database string `json:"database"`
+	Error    string `json:"error"`
+}
+
+// TenantSummary counts tenants by status
+type TenantSummary struct {
+	Total    int64            `json:"total"`
+	ByStatus map[string]int64 `json:"by_status"`
+}
+
+// AgentSummary counts agents by status, with the tenants that have the most
+type AgentSummary struct {
+	Total           int64            `json:"total"`
+	ByStatus        map[string]int64 `json:"by_status"`
+	PendingApproval int64            `json:"pending_approval"`
+	Draining        int64            `json:"draining"`
+	Drained         int64            `json:"drained"`
+	TopTenants      []TenantAgents   `json:"top_tenants"`
+}
+
+// TenantAgents is one tenant's agents
+type TenantAgents struct {
+	TenantID string `json:"tenant_id"`
+	Name     string `json:"name,omitempty"`
+	Total    int64  `json:"total"`
+	Online   int64  `json:"online"`
+	Offline  int64  `json:"offline"`
+	Degraded int64  `json:"degraded"`
+}
+
+// CampaignSummary counts the campaigns in progress and lists those running
+type CampaignSummary struct {
+	Running     int64            `json:"running"`
+	Paused      int64            `json:"paused"`
+	RollingBack int64            `json:"rolling_back"`
+	Active      []ActiveCampaign `json:"active"`
+}
+
+// ActiveCampaign is a running or rolling back campaign
+type ActiveCampaign struct {
+	ID         string                `json:"id"`
+	TenantID   string                `json:"tenant_id"`
+	Name       string                `json:"name"`
+	WorkflowID string                `json:"workflow_id"`
+	Status     models.CampaignStatus `json:"status"`
+	StartedAt  *time.Time            `json:"started_at,omitempty"`
+	Progress   models.JSONMap        `json:"progress,omitempty"`
+}
+
+// ExecutionSummary counts executions in progress now, and those created in
+// the window by status
+type ExecutionSummary struct {
+	Pending  int64            `json:"pending"`
+	Running  int64            `json:"running"`
+	Paused   int64            `json:"paused"`
+	InWindow map[string]int64 `json:"in_window"`
+}
+
+// Hotspots rank what failed most in the window
+type Hotspots struct {
+	Workflows []Hotspot `json:"workflows"`
+	Agents    []Hotspot `json:"agents"`
+	Tenants   []Hotspot `json:"tenants"`
+}
+
+// Hotspot is a workflow, agent or tenant with failed or timed out
+// executions in the window
+type Hotspot struct {
+	ID          string  `json:"id"`
+	TenantID    string  `json:"tenant_id,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	Failures    int64   `json:"failures"`
+	Executions  int64   `json:"executions"`
+	FailureRate float64 `json:"failure_rate"`
+}
+
+// Backlogs is the work queued in and behind the control plane
+type Backlogs struct {
+	// Audit and OutputIndex are the events and documents waiting for
+	// Quickwit; nil when it is not configured
+	Audit       *QueueBacklog   `json:"audit,omitempty"`
+	OutputIndex *QueueBacklog   `json:"output_index,omitempty"`
+	Dispatch    DispatchBacklog `json:"dispatch"`
+}
+
+// QueueBacklog is an in-memory queue of this replica
+type QueueBacklog struct {
+	Pending int `json:"pending"`
+	// Max is the most kept before the oldest are dropped; 0 is unbounded
+	Max int `json:"max,omitempty"`
+}
+
+// DispatchBacklog is the executions waiting in the dispatch outbox
+type DispatchBacklog struct {
+	Pending       int64      `json:"pending"`
+	InProgress    int64      `json:"in_progress"`
+	Dead          int64      `json:"dead"`
+	OldestPending *time.Time `json:"oldest_pending,omitempty"`
+}
+
+// DatabaseSummary is the health of the shared database and tenant databases
+type DatabaseSummary struct {
+	Shared          DatabaseHealth        `json:"shared"`
+	TenantDatabases TenantDatabaseSummary `json:"tenant_databases"`
+}
+
+// DatabaseHealth is the result of pinging a database, with its pool
+type DatabaseHealth struct {
+	Reachable bool       `json:"reachable"`
+	LatencyMs int64      `json:"latency_ms"`
+	Error     string     `json:"error,omitempty"`
+	Pool      *PoolStats `json:"pool,omitempty"`
+}
+
+// PoolStats is a database's connection pool on this replica
+type PoolStats struct {
+	MaxOpenConnections int   `json:"max_open_connections"`
+	OpenConnections    int   `json:"open_connections"`
+	InUse              int   `json:"in_use"`
+	Idle               int   `json:"idle"`
+	WaitCount          int64 `json:"wait_count"`
+	WaitDurationMs     int64 `json:"wait_duration_ms"`
+}
+
+// TenantDatabaseSummary counts tenant databases by status and lists those
+// that failed or could not be reached
+type TenantDatabaseSummary struct {
+	Total     int64                  `json:"total"`
+	ByStatus  map[string]int64       `json:"by_status"`
+	Unhealthy []TenantDatabaseHealth `json:"unhealthy"`
+}
+
+// TenantDatabaseHealth is a failed or unreachable tenant database
+type TenantDatabaseHealth struct {
+	TenantID string                      `json:"tenant_id"`
+	Status   models.TenantDatabaseStatus `json:"status"`
+	Host     string                      `json:"host,omitempty"`
+	Database string                      `json:"database,omitempty"`
+	Error    string                      `json:"error,omitempty"`
+}
+
+// source is a database holding operational data; tenantID is empty for the
+// shared database
+type source struct {
+	tenantID string
+	db       *gorm.DB
+}
+
+func (s source) name() string {
+	if s.tenantID == "" {
+		return sharedDatabase
+	}
+	return s.tenantID
+}
+
+// Reporter builds the overview
+type Reporter struct {
+	tenants       *db.TenantRouter
+	auditLogger   *audit.Logger
+	outputIndexer *search.Indexer
+	logger        *zap.Logger
+}
+
+// NewReporter creates a reporter over the shared database and the tenant
+// databases the router reaches
+func NewReporter(tenants *db.TenantRouter, logger *zap.Logger) *Reporter {
+	return &Reporter{
+		tenants: tenants,
+		logger:  logger,
+	}
+}
+
+// SetAuditLogger reports the audit events waiting for Quickwit
+func (r *Reporter) SetAuditLogger(auditLogger *audit.Logger) {
+	r.auditLogger = auditLogger
+}
+
+// SetOutputIndexer reports the output documents waiting for Quickwit
+func (r *Reporter) SetOutputIndexer(indexer *search.Indexer) {
+	r.outputIndexer = indexer
+}
+
+// Overview returns the platform state. Only the shared database failing is
+// an error; a tenant database that cannot be read is listed in Errors and
+// left out of the counts.
+func (r *Reporter) Overview(ctx context.Context, req *Request) (*Overview, error) {
+	window, limit, err := normalize(req)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	o := &Overview{
+		GeneratedAt: now,
+		Window:      window.String(),
+		Since:       now.Add(-window),
+	}
+	catalog := r.tenants.Catalog().WithContext(ctx)
+
+	o.Databases.Shared = health(ctx, r.tenants.Catalog())
+	if !o.Databases.Shared.Reachable {
+		return nil, fmt.Errorf("shared database is unreachable: %s", o.Databases.Shared.Error)
+	}
+
+	names, err := r.tenantSummary(catalog, o)
+	if err != nil {
+		return nil, err
+	}
+	sources, err := r.sources(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+
+	tenantAgents := make(map[string]*TenantAgents)
+	o.Agents.ByStatus = make(map[string]int64)
+	o.Executions.InWindow = make(map[string]int64)
+	for _, src := range sources {
+		tx := src.db.WithContext(ctx)
+		if err := agentSummary(tx, &o.Agents, tenantAgents); err != nil {
+			o.fail("agents", src, err)
+		}
+		if err := campaignSummary(tx, &o.Campaigns); err != nil {
+			o.fail("campaigns", src, err)
+		}
+		if err := executionSummary(tx, o.Since, &o.Executions); err != nil {
+			o.fail("executions", src, err)
+		}
+		if err := hotspots(tx, o.Since, limit, &o.Hotspots); err != nil {
+			o.fail("failure_hotspots", src, err)
+		}
+		if err := dispatchBacklog(tx, &o.Backlogs.Dispatch); err != nil {
+			o.fail("dispatch", src, err)
+		}
+	}
+
+	o.Agents.TopTenants = topTenants(tenantAgents, names, limit)
+	sort.Slice(o.Campaigns.Active, func(i, j int) bool {
+		return startedBefore(o.Campaigns.Active[i].StartedAt, o.Campaigns.Active[j].StartedAt)
+	})
+	if len(o.Campaigns.Active) > maxActiveCampaigns {
+		o.Campaigns.Active = o.Campaigns.Active[:maxActiveCampaigns]
+	}
+	o.Hotspots.Workflows = rank(o.Hotspots.Workflows, limit)
+	o.Hotspots.Agents = rank(o.Hotspots.Agents, limit)
+	o.Hotspots.Tenants = rank(o.Hotspots.Tenants, limit)
+	for i := range o.Hotspots.Tenants {
+		o.Hotspots.Tenants[i].Name = names[o.Hotspots.Tenants[i].ID]
+	}
+
+	if r.auditLogger != nil {
+		o.Backlogs.Audit = &QueueBacklog{Pending: r.auditLogger.Pending()}
+	}
+	if r.outputIndexer != nil {
+		pending, max := r.outputIndexer.Pending()
+		o.Backlogs.OutputIndex = &QueueBacklog{Pending: pending, Max: max}
+	}
+
+	if len(o.Errors) > 0 {
+		r.logger.Warn("admin overview is partial", zap.Int("errors", len(o.Errors)))
+	}
+	return o, nil
+}
+
+// normalize applies the defaults and bounds to a request
+func normalize(req *Request) (time.Duration, int, error) {
+	window, limit := DefaultWindow, DefaultLimit
+	if req != nil {
+		if req.Window < 0 || req.Window > MaxWindow {
+			return 0, 0, apperror.InvalidInput("window must be between 0 and %s", MaxWindow)
+		}
+		if req.Limit < 0 || req.Limit > MaxLimit {
+			return 0, 0, apperror.InvalidInput("limit must be between 0 and %d", MaxLimit)
+		}
+		if req.Window > 0 {
+			window = req.Window
+		}
+		if req.Limit > 0 {
+			limit = req.Limit
+		}
+	}
+	return window, limit, nil
+}
+
+func (o *Overview) fail(section string, src source, err error) {
+	o.Errors = append(o.Errors, SectionError{Section: section, Database: src.name(), Error: err.Error()})
+}
+
+// tenantSummary counts tenants by status and returns their names
+func (r *Reporter) tenantSummary(catalog *gorm.DB, o *Overview) (map[string]string, error) {
+	var tenants []models.Tenant
+	if err := catalog.Select("id", "name", "status").Find(&tenants).Error; err != nil {
+		return nil, fmt.Errorf("failed to list tenants: %w", err)
+	}
+
+	names := make(map[string]string, len(tenants))
+	o.Tenants.ByStatus = make(map[string]int64)
+	for _, tenant := range tenants {
+		names[tenant.ID] = tenant.Name
+		o.Tenants.Total++
+		o.Tenants.ByStatus[string(tenant.Status)]++
+	}
+	return names, nil
+}
+
+// sources returns the shared database and the active tenant databases that
+// respond to a ping, and reports the health of every tenant database
+func (r *Reporter) sources(ctx context.Context, o *Overview) ([]source, error) {
+	records, err := r.tenants.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := []source{{db: r.tenants.Catalog()}}
+	summary := &o.Databases.TenantDatabases
+	summary.ByStatus = make(map[string]int64)
+	summary.Unhealthy = []TenantDatabaseHealth{}
+	for _, record := range records {
+		summary.Total++
+		summary.ByStatus[string(record.Status)]++
+
+		unhealthy := TenantDatabaseHealth{
+			TenantID: record.TenantID,
+			Status:   record.Status,
+			Host:     record.Host,
+			Database: record.Database,
+		}
+		if record.Status == models.TenantDatabaseFailed {
+			unhealthy.Error = record.LastError
+			summary.Unhealthy = append(summary.Unhealthy, unhealthy)
+			continue
+		}
+		if record.Status != models.TenantDatabaseActive {
+			continue
+		}
+
+		tenantDB, err := r.tenants.DB(ctx, record.TenantID)
+		if err == nil {
+			if state := health(ctx, tenantDB); !state.Reachable {
+				err = fmt.Errorf("%s", state.Error)
+			}
+		}
+		if err != nil {
+			unhealthy.Error = err.Error()
+			summary.Unhealthy = append(summary.Unhealthy, unhealthy)
+			o.fail("databases", source{tenantID: record.TenantID}, err)
+			continue
+		}
+		sources = append(sources, source{tenantID: record.TenantID, db: tenantDB})
+	}
+	return sources, nil
+}
+
+// health pings a database and reads its connection pool
+func health(ctx context.Context, gdb *gorm.DB) DatabaseHealth {
+	sqlDB, err := gdb.DB()
+	if err != nil {
+		return DatabaseHealth{Error: err.Error()}
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	started := time.Now()
+	err = sqlDB.PingContext(pingCtx)
+	state := DatabaseHealth{
+		Reachable: err == nil,
+		LatencyMs: time.Since(started).Milliseconds(),
+	}
+	if err != nil {
+		state.Error = err.Error()
+	}
+
+	stats := sqlDB.Stats()
+	state.Pool = &PoolStats{
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+		WaitDurationMs:     stats.WaitDuration.Milliseconds(),
+	}
+	return state
+}
+
+// agentSummary adds a database's agents to the summary and to the per
+// tenant counts
+func agentSummary(tx *gorm.DB, summary *AgentSummary, tenants map[string]*TenantAgents) error {
+	var rows []struct {
+		TenantID       string
+		Status         string
+		DrainState     string
+		ApprovalStatus string
+		Count          int64
+	}
+	if err := tx.Model(&models.Agent{}).
+		Select("tenant_id, status, drain_state, approval_status, COUNT(*) AS count").
+		Group("tenant_id, status, drain_state, approval_status").
+		Scan(&rows).Error; err != nil {
+		return fmt.Errorf("failed to count agents: %w", err)
+	}
+
+	for _, row := range rows {
+		summary.Total += row.Count
+		summary.ByStatus[row.Status] += row.Count
+		switch models.AgentDrainState(row.DrainState) {
+		case models.AgentDrainDraining:
+			summary.Draining += row.Count
+		case models.AgentDrainDrained:
+			summary.Drained += row.Count
+		}
+		if models.AgentApprovalStatus(row.ApprovalStatus) == models.AgentApprovalPending {
+			summary.PendingApproval += row.Count
+		}
+
+		tenant, ok := tenants[row.TenantID]
+		if !ok {
+			tenant = &TenantAgents{TenantID: row.TenantID}
+			tenants[row.TenantID] = tenant
+		}
+		tenant.Total += row.Count
+		switch models.AgentStatus(row.Status) {
+		case models.AgentStatusOnline:
+			tenant.Online += row.Count
+		case models.AgentStatusOffline:
+			tenant.Offline += row.Count
+		case models.AgentStatusDegraded:
+			tenant.Degraded += row.Count
+		}
+	}
+	return nil
+}
+
+// topTenants returns the tenants with the most agents
+func topTenants(tenants map[string]*TenantAgents, names map[string]string, limit int) []TenantAgents {
+	top := make([]TenantAgents, 0, len(tenants))
+	for _, tenant := range tenants {
+		tenant.Name = names[tenant.TenantID]
+		top = append(top, *tenant)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Total != top[j].Total {
+			return top[i].Total > top[j].Total
+		}
+		return top[i].TenantID < top[j].TenantID
+	})
+	if len(top) > limit {
+		top = top[:limit]
+	}
+	return top
+}
+
+// campaignSummary adds a database's campaigns in progress to the summary
+func campaignSummary(tx *gorm.DB, summary *CampaignSummary) error {
+	var counts []struct {
+		Status string
+		Count  int64
+	}
+	if err := tx.Model(&models.Campaign{}).
+		Select("status, COUNT(*) AS count").
+		Where("status IN ?", []models.CampaignStatus{
+			models.CampaignStatusRunning,
+			models.CampaignStatusPaused,
+			models.CampaignStatusRollingBack,
+		}).
+		Group("status").
+		Scan(&counts).Error; err != nil {
+		return fmt.Errorf("failed to count campaigns: %w", err)
+	}
+	for _, count := range counts {
+		switch models.CampaignStatus(count.Status) {
+		case models.CampaignStatusRunning:
+			summary.Running += count.Count
+		case models.CampaignStatusPaused:
+			summary.Paused += count.Count
+		case models.CampaignStatusRollingBack:
+			summary.RollingBack += count.Count
+		}
+	}
+
+	var campaigns []models.Campaign
+	if err := tx.Select("id", "tenant_id", "name", "workflow_id", "status", "started_at", "progress").
+		Where("status IN ?", []models.CampaignStatus{models.CampaignStatusRunning, models.CampaignStatusRollingBack}).
+		Order("started_at ASC").
+		Limit(maxActiveCampaigns).
+		Find(&campaigns).Error; err != nil {
+		return fmt.Errorf("failed to list running campaigns: %w", err)
+	}
+	if summary.Active == nil {
+		summary.Active = []ActiveCampaign{}
+	}
+	for _, campaign := range campaigns {
+		summary.Active = append(summary.Active, ActiveCampaign{
+			ID:         campaign.ID,
+			TenantID:   campaign.TenantID,
+			Name:       campaign.Name,
+			WorkflowID: campaign.WorkflowID,
+			Status:     campaign.Status,
+			StartedAt:  campaign.StartedAt,
+			Progress:   campaign.Progress,
+		})
+	}
+	return nil
+}
+
+// startedBefore orders campaigns by start, those not started last
+func startedBefore(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a != nil
+	}
+	return a.Before(*b)
+}
+
+// executionSummary adds a database's executions in progress, and those
+// created since the window started, to the summary
+func executionSummary(tx *gorm.DB, since time.Time, summary *ExecutionSummary) error {
+	var current []struct {
+		Status string
+		Count  int64
+	}
+	if err := tx.Model(&models.WorkflowExecution{}).
+		Select("status, COUNT(*) AS count").
+		Where("status IN ?", []models.ExecutionStatus{
+			models.ExecutionStatusPending,
+			models.ExecutionStatusRunning,
+			models.ExecutionStatusPaused,
+		}).
+		Group("status").
+		Scan(&current).Error; err != nil {
+		return fmt.Errorf("failed to count executions in progress: %w", err)
+	}
+	for _, count := range current {
+		switch models.ExecutionStatus(count.Status) {
+		case models.ExecutionStatusPending:
+			summary.Pending += count.Count
+		case models.ExecutionStatusRunning:
+			summary.Running += count.Count
+		case models.ExecutionStatusPaused:
+			summary.Paused += count.Count
+		}
+	}
+
+	var recent []struct {
+		Status string
+		Count  int64
+	}
+	if err := tx.Model(&models.WorkflowExecution{}).
+		Select("status, COUNT(*) AS count").
+		Where("created_at >= ?", since).
+		Group("status").
+		Scan(&recent).Error; err != nil {
+		return fmt.Errorf("failed to count recent executions: %w", err)
+	}
+	for _, count := range recent {
+		summary.InWindow[count.Status] += count.Count
+	}
+	return nil
+}
+
+// failureCount is the share of an execution group's columns that counts
+// failures
+const failureCount = "SUM(CASE WHEN e.status IN ('failed', 'timeout') THEN 1 ELSE 0 END)"
+
+// hotspots adds a database's most failing workflows, agents and tenants in
+// the window to the hotspots. Databases hold disjoint tenants, so the top
+// entries of each contain the overall top entries.
+func hotspots(tx *gorm.DB, since time.Time, limit int, spots *Hotspots) error {
+	queries := []struct {
+		into *[]Hotspot
+		what string
+		join string
+		// columns are the id, tenant_id and name selected and grouped by
+		columns string
+	}{
+		{&spots.Workflows, "workflows", "LEFT JOIN workflows w ON w.id = e.workflow_id", "e.workflow_id AS id, e.tenant_id, w.name"},
+		{&spots.Agents, "agents", "LEFT JOIN agents a ON a.id = e.agent_id", "e.agent_id AS id, e.tenant_id, a.hostname AS name"},
+		{&spots.Tenants, "tenants", "", "e.tenant_id AS id"},
+	}
+	for _, q := range queries {
+		var rows []Hotspot
+		query := tx.Table("workflow_executions AS e")
+		if q.join != "" {
+			query = query.Joins(q.join)
+		}
+		if err := query.
+			Select(q.columns+", COUNT(*) AS executions, "+failureCount+" AS failures").
+			Where("e.created_at >= ?", since).
+			Group(groupBy(q.columns)).
+			Having(failureCount + " > 0").
+			Order("failures DESC").
+			Limit(limit).
+			Scan(&rows).Error; err != nil {
+			return fmt.Errorf("failed to rank failing %s: %w", q.what, err)
+		}
+		*q.into = append(*q.into, rows...)
+	}
+	return nil
+}
+
+// groupBy returns the expressions of a select list, without their aliases
+func groupBy(columns string) string {
+	fields := strings.Split(columns, ",")
+	for i, field := range fields {
+		field = strings.TrimSpace(field)
+		if cut := strings.Index(field, " AS "); cut >= 0 {
+			field = field[:cut]
+		}
+		fields[i] = field
+	}
+	return strings.Join(fields, ", ")
+}
+
+// rank orders hotspots by failures, then failure rate, and keeps the first
+func rank(spots []Hotspot, limit int) []Hotspot {
+	for i := range spots {
+		if spots[i].Executions > 0 {
+			spots[i].FailureRate = float64(spots[i].Failures) / float64(spots[i].Executions)
+		}
+	}
+	sort.Slice(spots, func(i, j int) bool {
+		if spots[i].Failures != spots[j].Failures {
+			return spots[i].Failures > spots[j].Failures
+		}
+		if spots[i].FailureRate != spots[j].FailureRate {
+			return spots[i].FailureRate > spots[j].FailureRate
+		}
+		return spots[i].ID < spots[j].ID
+	})
+	if len(spots) > limit {
+		spots = spots[:limit]
+	}
+	if spots == nil {
+		spots = []Hotspot{}
+	}
+	return spots
+}
+
+// dispatchBacklog adds a database's undelivered dispatch jobs to the backlog
+func dispatchBacklog(tx *gorm.DB, backlog *DispatchBacklog) error {
+	var rows []struct {
+		Status string
+		Count  int64
+		Oldest *time.Time
+	}
+	if err := tx.Model(&models.DispatchJob{}).
+		Select("status, COUNT(*) AS count, MIN(created_at) AS oldest").
+		Where("status IN ?", []models.DispatchJobStatus{
+			models.DispatchJobPending,
+			models.DispatchJobInProgress,
+			models.DispatchJobDead,
+		}).
+		Group("status").
+		Scan(&rows).Error; err != nil {
+		return fmt.Errorf("failed to count dispatch jobs: %w", err)
+	}
+
+	for _, row := range rows {
+		switch models.DispatchJobStatus(row.Status) {
+		case models.DispatchJobPending:
+			backlog.Pending += row.Count
+			if row.Oldest != nil && (backlog.OldestPending == nil || row.Oldest.Before(*backlog.OldestPending)) {
+				backlog.OldestPending = row.Oldest
+			}
+		case models.DispatchJobInProgress:
+			backlog.InProgress += row.Count
+		case models.DispatchJobDead:
+			backlog.Dead += row.Count
+		}
+	}
+	return nil
+}
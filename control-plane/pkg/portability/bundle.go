@@ -0,0 +1,272 @@
+// Package portability provides tenant data export and import for moving
+// tenants between control-plane installations and for backups.
+package portability
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// BundleFormatVersion is the current bundle format version
+const BundleFormatVersion = 1
+
+// Bundle is a portable snapshot of a tenant's configuration data
+type Bundle struct {
+	FormatVersion  int              `json:"format_version"`
+	SourceTenantID string           `json:"source_tenant_id"`
+	ExportedAt     time.Time        `json:"exported_at"`
+	Workflows      []WorkflowRecord `json:"workflows"`
+	Templates      []TemplateRecord `json:"templates"`
+	Campaigns      []CampaignRecord `json:"campaigns"`
+}
+
+// WorkflowRecord is an exported workflow
+type WorkflowRecord struct {
+	ID          string                `json:"id"`
+	Name        string                `json:"name"`
+	Description string                `json:"description,omitempty"`
+	Definition  models.JSONMap        `json:"definition"`
+	Version     int                   `json:"version"`
+	Status      models.WorkflowStatus `json:"status"`
+	Tags        models.JSONMap        `json:"tags,omitempty"`
+	CreatedBy   string                `json:"created_by,omitempty"`
+	CreatedAt   time.Time             `json:"created_at"`
+	UpdatedAt   time.Time             `json:"updated_at"`
+}
+
+// TemplateRecord is an exported template with its version history
+type TemplateRecord struct {
+	ID          string                  `json:"id"`
+	Name        string                  `json:"name"`
+	Description string                  `json:"description,omitempty"`
+	Content     string                  `json:"content"`
+	ContentType string                  `json:"content_type"`
+	Version     int                     `json:"version"`
+	Status      models.TemplateStatus   `json:"status"`
+	Tags        models.JSONMap          `json:"tags,omitempty"`
+	Metadata    models.JSONMap          `json:"metadata,omitempty"`
+	CreatedBy   string                  `json:"created_by,omitempty"`
+	CreatedAt   time.Time               `json:"created_at"`
+	UpdatedAt   time.Time               `json:"updated_at"`
+	Versions    []TemplateVersionRecord `json:"versions"`
+}
+
+// TemplateVersionRecord is an exported template version
+type TemplateVersionRecord struct {
+	Version    int       `json:"version"`
+	Content    string    `json:"content"`
+	ChangedBy  string    `json:"changed_by,omitempty"`
+	ChangeNote string    `json:"change_note,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CampaignRecord is an exported campaign with its phases
+type CampaignRecord struct {
+	ID             string                `json:"id"`
+	WorkflowID     string                `json:"workflow_id"`
+	Name           string                `json:"name"`
+	Description    string                `json:"description,omitempty"`
+	Status         models.CampaignStatus `json:"status"`
+	TargetSelector models.JSONMap        `json:"target_selector"`
+	PhaseConfig    models.JSONMap        `json:"phase_config"`
+	Zones          models.JSONMap        `json:"zones,omitempty"`
+	Progress       models.JSONMap        `json:"progress,omitempty"`
+	CreatedBy      string                `json:"created_by,omitempty"`
+	StartedAt      *time.Time            `json:"started_at,omitempty"`
+	CompletedAt    *time.Time            `json:"completed_at,omitempty"`
+	CreatedAt      time.Time             `json:"created_at"`
+	Phases         []CampaignPhaseRecord `json:"phases"`
+}
+
+// CampaignPhaseRecord is an exported campaign phase
+type CampaignPhaseRecord struct {
+	Name         string             `json:"name"`
+	Order        int                `json:"order"`
+	ConfigIndex  *int               `json:"config_index,omitempty"`
+	Zone         string             `json:"zone,omitempty"`
+	TargetCount  int                `json:"target_count"`
+	SuccessCount int                `json:"success_count"`
+	FailureCount int                `json:"failure_count"`
+	Status       models.PhaseStatus `json:"status"`
+	StartedAt    *time.Time         `json:"started_at,omitempty"`
+	CompletedAt  *time.Time         `json:"completed_at,omitempty"`
+
+	ManualApproval bool       `json:"manual_approval,omitempty"`
+	ApprovedBy     string     `json:"approved_by,omitempty"`
+	ApprovedAt     *time.Time `json:"approved_at,omitempty"`
+}
+
+// manifest is the manifest.json entry of a tar bundle
+type manifest struct {
+	FormatVersion  int       `json:"format_version"`
+	SourceTenantID string    `json:"source_tenant_id"`
+	ExportedAt     time.Time `json:"exported_at"`
+	Workflows      int       `json:"workflows"`
+	Templates      int       `json:"templates"`
+	Campaigns      int       `json:"campaigns"`
+}
+
+// WriteJSON writes the bundle as a single JSON document
+func WriteJSON(w io.Writer, bundle *Bundle) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(bundle); err != nil {
+		return fmt.Errorf("failed to encode bundle: %w", err)
+	}
+	return nil
+}
+
+// WriteTar writes the bundle as a gzipped tar archive with a manifest and one
+// JSON file per resource (workflows/<id>.json, templates/<id>.json, campaigns/<id>.json)
+func WriteTar(w io.Writer, bundle *Bundle) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	add := func(name string, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", name, err)
+		}
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: bundle.ExportedAt,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		return nil
+	}
+
+	if err := add("manifest.json", &manifest{
+		FormatVersion:  bundle.FormatVersion,
+		SourceTenantID: bundle.SourceTenantID,
+		ExportedAt:     bundle.ExportedAt,
+		Workflows:      len(bundle.Workflows),
+		Templates:      len(bundle.Templates),
+		Campaigns:      len(bundle.Campaigns),
+	}); err != nil {
+		return err
+	}
+	for i := range bundle.Workflows {
+		if err := add("workflows/"+bundle.Workflows[i].ID+".json", &bundle.Workflows[i]); err != nil {
+			return err
+		}
+	}
+	for i := range bundle.Templates {
+		if err := add("templates/"+bundle.Templates[i].ID+".json", &bundle.Templates[i]); err != nil {
+			return err
+		}
+	}
+	for i := range bundle.Campaigns {
+		if err := add("campaigns/"+bundle.Campaigns[i].ID+".json", &bundle.Campaigns[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return gz.Close()
+}
+
+// ReadBundle reads a bundle in either JSON or gzipped tar form
+func ReadBundle(r io.Reader) (*Bundle, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+
+	var bundle *Bundle
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		bundle, err = readTar(br)
+	} else {
+		bundle = &Bundle{}
+		if err = json.NewDecoder(br).Decode(bundle); err != nil {
+			err = fmt.Errorf("failed to decode bundle: %w", err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if bundle.FormatVersion == 0 || bundle.FormatVersion > BundleFormatVersion {
+		return nil, fmt.Errorf("unsupported bundle format version: %d", bundle.FormatVersion)
+	}
+
+	return bundle, nil
+}
+
+// readTar reads a gzipped tar bundle written by WriteTar
+func readTar(r io.Reader) (*Bundle, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer gz.Close()
+
+	bundle := &Bundle{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg || !strings.HasSuffix(hdr.Name, ".json") {
+			continue
+		}
+
+		dec := json.NewDecoder(tr)
+		switch dir, _ := path.Split(path.Clean(hdr.Name)); dir {
+		case "":
+			if hdr.Name != "manifest.json" {
+				continue
+			}
+			var m manifest
+			if err := dec.Decode(&m); err != nil {
+				return nil, fmt.Errorf("failed to decode manifest: %w", err)
+			}
+			bundle.FormatVersion = m.FormatVersion
+			bundle.SourceTenantID = m.SourceTenantID
+			bundle.ExportedAt = m.ExportedAt
+		case "workflows/":
+			var rec WorkflowRecord
+			if err := dec.Decode(&rec); err != nil {
+				return nil, fmt.Errorf("failed to decode %s: %w", hdr.Name, err)
+			}
+			bundle.Workflows = append(bundle.Workflows, rec)
+		case "templates/":
+			var rec TemplateRecord
+			if err := dec.Decode(&rec); err != nil {
+				return nil, fmt.Errorf("failed to decode %s: %w", hdr.Name, err)
+			}
+			bundle.Templates = append(bundle.Templates, rec)
+		case "campaigns/":
+			var rec CampaignRecord
+			if err := dec.Decode(&rec); err != nil {
+				return nil, fmt.Errorf("failed to decode %s: %w", hdr.Name, err)
+			}
+			bundle.Campaigns = append(bundle.Campaigns, rec)
+		}
+	}
+
+	return bundle, nil
+}
@@ -0,0 +1,667 @@
+// Package portability provides tenant data export and import for moving
+// tenants between control-plane installations and for backups.
+package portability
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/encryption"
+)
+
+// CollisionMode controls what happens when an imported resource has the same
+// name as an existing resource in the target tenant
+type CollisionMode string
+
+const (
+	CollisionRename    CollisionMode = "rename"
+	CollisionSkip      CollisionMode = "skip"
+	CollisionOverwrite CollisionMode = "overwrite"
+)
+
+// ParseCollisionMode parses a collision mode, defaulting to rename
+func ParseCollisionMode(s string) (CollisionMode, error) {
+	switch CollisionMode(s) {
+	case "":
+		return CollisionRename, nil
+	case CollisionRename, CollisionSkip, CollisionOverwrite:
+		return CollisionMode(s), nil
+	default:
+		return "", apperror.InvalidInput("invalid collision mode %q: must be rename, skip or overwrite", s)
+	}
+}
+
+// Import actions recorded in the report
+const (
+	ActionCreated     = "created"
+	ActionRenamed     = "renamed"
+	ActionSkipped     = "skipped"
+	ActionOverwritten = "overwritten"
+)
+
+// ImportItem describes what happened to one imported resource
+type ImportItem struct {
+	Kind     string `json:"kind"`
+	SourceID string `json:"source_id"`
+	ID       string `json:"id,omitempty"`
+	Name     string `json:"name"`
+	Action   string `json:"action"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// ImportReport summarizes an import
+type ImportReport struct {
+	TenantID string         `json:"tenant_id"`
+	Mode     CollisionMode  `json:"mode"`
+	Items    []ImportItem   `json:"items"`
+	Counts   map[string]int `json:"counts"`
+}
+
+// Manager exports and imports tenant data
+type Manager struct {
+	db     *gorm.DB
+	logger *zap.Logger
+}
+
+// NewManager creates a new portability manager
+func NewManager(db *gorm.DB, logger *zap.Logger) *Manager {
+	return &Manager{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Export builds a bundle of the tenant's workflows, templates and campaigns.
+// Deleted workflows and templates are not exported. Templates with uploaded
+// content, and uploaded versions, are left out of the bundle, which is built
+// in memory; upload their content again after importing.
+func (m *Manager) Export(ctx context.Context, tenantID string) (*Bundle, error) {
+	db := m.db.WithContext(ctx)
+
+	bundle := &Bundle{
+		FormatVersion:  BundleFormatVersion,
+		SourceTenantID: tenantID,
+		ExportedAt:     time.Now().UTC(),
+		Workflows:      []WorkflowRecord{},
+		Templates:      []TemplateRecord{},
+		Campaigns:      []CampaignRecord{},
+	}
+
+	var workflows []models.Workflow
+	if err := db.Where("tenant_id = ? AND status != ?", tenantID, models.WorkflowStatusDeleted).
+		Order("created_at ASC").Find(&workflows).Error; err != nil {
+		return nil, fmt.Errorf("failed to export workflows: %w", err)
+	}
+	for _, wf := range workflows {
+		bundle.Workflows = append(bundle.Workflows, WorkflowRecord{
+			ID:          wf.ID,
+			Name:        wf.Name,
+			Description: wf.Description,
+			Definition:  wf.Definition,
+			Version:     wf.Version,
+			Status:      wf.Status,
+			Tags:        wf.Tags,
+			CreatedBy:   wf.CreatedBy,
+			CreatedAt:   wf.CreatedAt,
+			UpdatedAt:   wf.UpdatedAt,
+		})
+	}
+
+	var templates []models.Template
+	if err := db.Where("tenant_id = ? AND status != ? AND uploaded = ?", tenantID, models.TemplateStatusDeleted, false).
+		Order("created_at ASC").Find(&templates).Error; err != nil {
+		return nil, fmt.Errorf("failed to export templates: %w", err)
+	}
+	for _, t := range templates {
+		var versions []models.TemplateVersion
+		if err := db.Where("template_id = ? AND tenant_id = ? AND uploaded = ?", t.ID, tenantID, false).
+			Order("version ASC").Find(&versions).Error; err != nil {
+			return nil, fmt.Errorf("failed to export template versions: %w", err)
+		}
+
+		rec := TemplateRecord{
+			ID:          t.ID,
+			Name:        t.Name,
+			Description: t.Description,
+			Content:     t.Content,
+			ContentType: t.ContentType,
+			Version:     t.Version,
+			Status:      t.Status,
+			Tags:        t.Tags,
+			Metadata:    t.Metadata,
+			CreatedBy:   t.CreatedBy,
+			CreatedAt:   t.CreatedAt,
+			UpdatedAt:   t.UpdatedAt,
+			Versions:    make([]TemplateVersionRecord, 0, len(versions)),
+		}
+		for _, v := range versions {
+			rec.Versions = append(rec.Versions, TemplateVersionRecord{
+				Version:    v.Version,
+				Content:    v.Content,
+				ChangedBy:  v.ChangedBy,
+				ChangeNote: v.ChangeNote,
+				CreatedAt:  v.CreatedAt,
+			})
+		}
+		bundle.Templates = append(bundle.Templates, rec)
+	}
+
+	var campaigns []models.Campaign
+	if err := db.Preload("Phases").Where("tenant_id = ?", tenantID).
+		Order("created_at ASC").Find(&campaigns).Error; err != nil {
+		return nil, fmt.Errorf("failed to export campaigns: %w", err)
+	}
+	for _, c := range campaigns {
+		rec := CampaignRecord{
+			ID:             c.ID,
+			WorkflowID:     c.WorkflowID,
+			Name:           c.Name,
+			Description:    c.Description,
+			Status:         c.Status,
+			TargetSelector: c.TargetSelector,
+			PhaseConfig:    c.PhaseConfig,
+			Zones:          c.Zones,
+			Progress:       c.Progress,
+			CreatedBy:      c.CreatedBy,
+			StartedAt:      c.StartedAt,
+			CompletedAt:    c.CompletedAt,
+			CreatedAt:      c.CreatedAt,
+			Phases:         make([]CampaignPhaseRecord, 0, len(c.Phases)),
+		}
+		for _, p := range c.Phases {
+			configIndex := p.ConfigIndex
+			rec.Phases = append(rec.Phases, CampaignPhaseRecord{
+				Name:         p.PhaseName,
+				Order:        p.PhaseOrder,
+				ConfigIndex:  &configIndex,
+				Zone:         p.Zone,
+				TargetCount:  p.TargetCount,
+				SuccessCount: p.SuccessCount,
+				FailureCount: p.FailureCount,
+				Status:       p.Status,
+				StartedAt:    p.StartedAt,
+				CompletedAt:  p.CompletedAt,
+
+				ManualApproval: p.ManualApproval,
+				ApprovedBy:     p.ApprovedBy,
+				ApprovedAt:     p.ApprovedAt,
+			})
+		}
+		bundle.Campaigns = append(bundle.Campaigns, rec)
+	}
+
+	m.logger.Info("tenant exported",
+		zap.String("tenant_id", tenantID),
+		zap.Int("workflows", len(bundle.Workflows)),
+		zap.Int("templates", len(bundle.Templates)),
+		zap.Int("campaigns", len(bundle.Campaigns)))
+
+	return bundle, nil
+}
+
+// Import loads a bundle into a tenant in a single transaction. Resources get
+// new IDs; references between them (campaign workflows, control-plane
+// template sources in workflow definitions) are rewritten to the new IDs.
+func (m *Manager) Import(ctx context.Context, tenantID string, bundle *Bundle, mode CollisionMode) (*ImportReport, error) {
+	report := &ImportReport{
+		TenantID: tenantID,
+		Mode:     mode,
+		Items:    []ImportItem{},
+		Counts:   map[string]int{},
+	}
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		imp := &importer{
+			tx:          tx,
+			tenantID:    tenantID,
+			mode:        mode,
+			report:      report,
+			templateIDs: make(map[string]string),
+			workflowIDs: make(map[string]string),
+		}
+
+		// Templates first so workflow definitions can be rewritten to the new IDs
+		for i := range bundle.Templates {
+			if err := imp.importTemplate(&bundle.Templates[i]); err != nil {
+				return err
+			}
+		}
+		for i := range bundle.Workflows {
+			if err := imp.importWorkflow(&bundle.Workflows[i]); err != nil {
+				return err
+			}
+		}
+		for i := range bundle.Campaigns {
+			if err := imp.importCampaign(&bundle.Campaigns[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range report.Items {
+		report.Counts[item.Action]++
+	}
+
+	m.logger.Info("tenant imported",
+		zap.String("tenant_id", tenantID),
+		zap.String("source_tenant_id", bundle.SourceTenantID),
+		zap.String("mode", string(mode)),
+		zap.Int("items", len(report.Items)))
+
+	return report, nil
+}
+
+// importer carries state across one import transaction
+type importer struct {
+	tx          *gorm.DB
+	tenantID    string
+	mode        CollisionMode
+	report      *ImportReport
+	templateIDs map[string]string // source ID -> imported ID
+	workflowIDs map[string]string
+}
+
+// record adds an item to the report
+func (i *importer) record(kind, sourceID, id, name, action string) {
+	i.report.Items = append(i.report.Items, ImportItem{
+		Kind:     kind,
+		SourceID: sourceID,
+		ID:       id,
+		Name:     name,
+		Action:   action,
+	})
+}
+
+// uniqueName returns name, or name with an "(imported N)" suffix, that is not
+// used by any row of the table in the tenant
+func (i *importer) uniqueName(table, name string) (string, error) {
+	candidate := name
+	for n := 1; ; n++ {
+		var count int64
+		if err := i.tx.Table(table).Where("tenant_id = ? AND name = ?", i.tenantID, candidate).
+			Count(&count).Error; err != nil {
+			return "", fmt.Errorf("failed to check name collision: %w", err)
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		if n == 1 {
+			candidate = fmt.Sprintf("%s (imported)", name)
+		} else {
+			candidate = fmt.Sprintf("%s (imported %d)", name, n)
+		}
+	}
+}
+
+// importTemplate imports a template and its version history
+func (i *importer) importTemplate(rec *TemplateRecord) error {
+	var existing models.Template
+	err := i.tx.Where("tenant_id = ? AND name = ? AND status != ?", i.tenantID, rec.Name, models.TemplateStatusDeleted).
+		First(&existing).Error
+	found := err == nil
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return fmt.Errorf("failed to look up template %q: %w", rec.Name, err)
+	}
+
+	name := rec.Name
+	action := ActionCreated
+	if found {
+		switch i.mode {
+		case CollisionSkip:
+			i.templateIDs[rec.ID] = existing.ID
+			i.record("template", rec.ID, existing.ID, rec.Name, ActionSkipped)
+			return nil
+		case CollisionOverwrite:
+			if existing.SourceRepositoryID != nil {
+				i.templateIDs[rec.ID] = existing.ID
+				i.record("template", rec.ID, existing.ID, rec.Name, ActionSkipped)
+				i.report.Items[len(i.report.Items)-1].Reason = "synced from a Git repository"
+				return nil
+			}
+			return i.overwriteTemplate(&existing, rec)
+		default:
+			if name, err = i.uniqueName("templates", rec.Name); err != nil {
+				return err
+			}
+			action = ActionRenamed
+		}
+	}
+
+	t := &models.Template{
+		ID:          uuid.New().String(),
+		TenantID:    i.tenantID,
+		Name:        name,
+		Description: rec.Description,
+		Content:     rec.Content,
+		ContentType: rec.ContentType,
+		Version:     rec.Version,
+		Status:      rec.Status,
+		Tags:        rec.Tags,
+		Metadata:    rec.Metadata,
+		CreatedBy:   rec.CreatedBy,
+		CreatedAt:   rec.CreatedAt,
+		UpdatedAt:   time.Now(),
+	}
+	if err := i.tx.Create(t).Error; err != nil {
+		return fmt.Errorf("failed to import template %q: %w", rec.Name, err)
+	}
+	if err := i.createTemplateVersions(t.ID, rec); err != nil {
+		return err
+	}
+
+	i.templateIDs[rec.ID] = t.ID
+	i.record("template", rec.ID, t.ID, name, action)
+	return nil
+}
+
+// overwriteTemplate replaces an existing template's content with the
+// imported one as its next version, the way an update would; its history is
+// kept and the bundle's is not imported over it. Inline content replaces
+// uploaded content, whose versions keep their chunks.
+func (i *importer) overwriteTemplate(existing *models.Template, rec *TemplateRecord) error {
+	// Map updates bypass the model's encrypted column serializer
+	content, err := encryption.Seal(existing.TenantID, rec.Content)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt template %q: %w", rec.Name, err)
+	}
+
+	now := time.Now()
+	version := existing.Version + 1
+	result := i.tx.Model(&models.Template{}).
+		Where("id = ? AND version = ?", existing.ID, existing.Version).
+		Updates(map[string]interface{}{
+			"description":     rec.Description,
+			"content":         content,
+			"content_type":    rec.ContentType,
+			"version":         version,
+			"status":          rec.Status,
+			"tags":            rec.Tags,
+			"metadata":        rec.Metadata,
+			"lint_status":     "",
+			"lint_results":    nil,
+			"uploaded":        false,
+			"content_hash":    "",
+			"content_size":    0,
+			"content_version": 0,
+			"updated_at":      now,
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to overwrite template %q: %w", rec.Name, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return apperror.Conflict("template %q was modified during the import", rec.Name)
+	}
+
+	if err := i.tx.Create(&models.TemplateVersion{
+		ID:         uuid.New().String(),
+		TemplateID: existing.ID,
+		TenantID:   i.tenantID,
+		Version:    version,
+		Content:    rec.Content,
+		ChangeNote: "Overwritten by import",
+		CreatedAt:  now,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to record template version of %q: %w", rec.Name, err)
+	}
+
+	i.templateIDs[rec.ID] = existing.ID
+	i.record("template", rec.ID, existing.ID, rec.Name, ActionOverwritten)
+	return nil
+}
+
+// createTemplateVersions inserts the version history of an imported template
+func (i *importer) createTemplateVersions(templateID string, rec *TemplateRecord) error {
+	for _, v := range rec.Versions {
+		version := &models.TemplateVersion{
+			ID:         uuid.New().String(),
+			TemplateID: templateID,
+			TenantID:   i.tenantID,
+			Version:    v.Version,
+			Content:    v.Content,
+			ChangedBy:  v.ChangedBy,
+			ChangeNote: v.ChangeNote,
+			CreatedAt:  v.CreatedAt,
+		}
+		if err := i.tx.Create(version).Error; err != nil {
+			return fmt.Errorf("failed to import template version %d of %q: %w", v.Version, rec.Name, err)
+		}
+	}
+	return nil
+}
+
+// importWorkflow imports a workflow, rewriting template references
+func (i *importer) importWorkflow(rec *WorkflowRecord) error {
+	definition, _ := rewriteTemplateRefs(map[string]interface{}(rec.Definition), i.templateIDs).(map[string]interface{})
+
+	var existing models.Workflow
+	err := i.tx.Where("tenant_id = ? AND name = ? AND status != ?", i.tenantID, rec.Name, models.WorkflowStatusDeleted).
+		First(&existing).Error
+	found := err == nil
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return fmt.Errorf("failed to look up workflow %q: %w", rec.Name, err)
+	}
+
+	name := rec.Name
+	action := ActionCreated
+	if found {
+		switch i.mode {
+		case CollisionSkip:
+			i.workflowIDs[rec.ID] = existing.ID
+			i.record("workflow", rec.ID, existing.ID, rec.Name, ActionSkipped)
+			return nil
+		case CollisionOverwrite:
+			if existing.SourceRepositoryID != nil {
+				i.workflowIDs[rec.ID] = existing.ID
+				i.record("workflow", rec.ID, existing.ID, rec.Name, ActionSkipped)
+				i.report.Items[len(i.report.Items)-1].Reason = "synced from a Git repository"
+				return nil
+			}
+			// Overwriting is an update: the workflow moves to its next
+			// version, unless another update got there first
+			result := i.tx.Model(&models.Workflow{}).
+				Where("id = ? AND version = ?", existing.ID, existing.Version).
+				Updates(map[string]interface{}{
+					"description": rec.Description,
+					"definition":  models.JSONMap(definition),
+					"version":     existing.Version + 1,
+					"status":      rec.Status,
+					"updated_at":  time.Now(),
+				})
+			if result.Error != nil {
+				return fmt.Errorf("failed to overwrite workflow %q: %w", rec.Name, result.Error)
+			}
+			if result.RowsAffected == 0 {
+				return apperror.Conflict("workflow %q was modified during the import", rec.Name)
+			}
+			i.workflowIDs[rec.ID] = existing.ID
+			i.record("workflow", rec.ID, existing.ID, rec.Name, ActionOverwritten)
+			return nil
+		default:
+			if name, err = i.uniqueName("workflows", rec.Name); err != nil {
+				return err
+			}
+			action = ActionRenamed
+		}
+	}
+
+	wf := &models.Workflow{
+		ID:          uuid.New().String(),
+		TenantID:    i.tenantID,
+		Name:        name,
+		Description: rec.Description,
+		Definition:  definition,
+		Version:     rec.Version,
+		Status:      rec.Status,
+		Tags:        rec.Tags,
+		CreatedBy:   rec.CreatedBy,
+		CreatedAt:   rec.CreatedAt,
+		UpdatedAt:   time.Now(),
+	}
+	if err := i.tx.Create(wf).Error; err != nil {
+		return fmt.Errorf("failed to import workflow %q: %w", rec.Name, err)
+	}
+
+	i.workflowIDs[rec.ID] = wf.ID
+	i.record("workflow", rec.ID, wf.ID, name, action)
+	return nil
+}
+
+// importCampaign imports a campaign. Campaigns that were still in progress are
+// imported as drafts with pending phases; they never resume automatically on
+// the target installation. Executions are not part of the bundle.
+func (i *importer) importCampaign(rec *CampaignRecord) error {
+	workflowID, ok := i.workflowIDs[rec.WorkflowID]
+	if !ok {
+		// The workflow was deleted before export
+		i.record("campaign", rec.ID, "", rec.Name, ActionSkipped)
+		i.report.Items[len(i.report.Items)-1].Reason = "workflow not in bundle"
+		return nil
+	}
+
+	var existing models.Campaign
+	err := i.tx.Where("tenant_id = ? AND name = ?", i.tenantID, rec.Name).First(&existing).Error
+	found := err == nil
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return fmt.Errorf("failed to look up campaign %q: %w", rec.Name, err)
+	}
+
+	name := rec.Name
+	action := ActionCreated
+	if found {
+		switch i.mode {
+		case CollisionSkip:
+			i.record("campaign", rec.ID, existing.ID, rec.Name, ActionSkipped)
+			return nil
+		case CollisionOverwrite:
+			if err := i.tx.Select("Phases").Delete(&existing).Error; err != nil {
+				return fmt.Errorf("failed to overwrite campaign %q: %w", rec.Name, err)
+			}
+			action = ActionOverwritten
+		default:
+			if name, err = i.uniqueName("campaigns", rec.Name); err != nil {
+				return err
+			}
+			action = ActionRenamed
+		}
+	}
+
+	finished := false
+	switch rec.Status {
+	case models.CampaignStatusCompleted, models.CampaignStatusFailed, models.CampaignStatusCancelled:
+		finished = true
+	}
+
+	c := &models.Campaign{
+		ID:             uuid.New().String(),
+		TenantID:       i.tenantID,
+		WorkflowID:     workflowID,
+		Name:           name,
+		Description:    rec.Description,
+		Status:         models.CampaignStatusDraft,
+		TargetSelector: rec.TargetSelector,
+		PhaseConfig:    rec.PhaseConfig,
+		Zones:          rec.Zones,
+		CreatedBy:      rec.CreatedBy,
+		CreatedAt:      rec.CreatedAt,
+		UpdatedAt:      time.Now(),
+	}
+	if !finished && len(rec.Zones) > 0 {
+		// The imported draft runs every zone again
+		zones := models.JSONMap{}
+		for key, value := range rec.Zones {
+			if key != "excluded" {
+				zones[key] = value
+			}
+		}
+		c.Zones = zones
+	}
+	if finished {
+		c.Status = rec.Status
+		c.Progress = rec.Progress
+		c.StartedAt = rec.StartedAt
+		c.CompletedAt = rec.CompletedAt
+	}
+	if err := i.tx.Create(c).Error; err != nil {
+		return fmt.Errorf("failed to import campaign %q: %w", rec.Name, err)
+	}
+
+	for _, p := range rec.Phases {
+		phase := &models.CampaignPhase{
+			ID:             uuid.New().String(),
+			CampaignID:     c.ID,
+			PhaseName:      p.Name,
+			PhaseOrder:     p.Order,
+			ConfigIndex:    p.Order,
+			Zone:           p.Zone,
+			Status:         models.PhaseStatusPending,
+			ManualApproval: p.ManualApproval,
+		}
+		// Bundles from before zones have one phase per config entry
+		if p.ConfigIndex != nil {
+			phase.ConfigIndex = *p.ConfigIndex
+		}
+		if finished {
+			phase.TargetCount = p.TargetCount
+			phase.SuccessCount = p.SuccessCount
+			phase.FailureCount = p.FailureCount
+			phase.Status = p.Status
+			phase.StartedAt = p.StartedAt
+			phase.CompletedAt = p.CompletedAt
+			phase.ApprovedBy = p.ApprovedBy
+			phase.ApprovedAt = p.ApprovedAt
+		}
+		if err := i.tx.Create(phase).Error; err != nil {
+			return fmt.Errorf("failed to import phase %q of campaign %q: %w", p.Name, rec.Name, err)
+		}
+	}
+
+	i.record("campaign", rec.ID, c.ID, name, action)
+	return nil
+}
+
+// templateSourcePrefix is how workflow template steps reference stored templates
+const templateSourcePrefix = "control-plane://templates/"
+
+// rewriteTemplateRefs returns a copy of v with control-plane template sources
+// pointing at the imported template IDs
+func rewriteTemplateRefs(v interface{}, ids map[string]string) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[k] = rewriteTemplateRefs(item, ids)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for idx, item := range val {
+			out[idx] = rewriteTemplateRefs(item, ids)
+		}
+		return out
+	case string:
+		if rest, ok := strings.CutPrefix(val, templateSourcePrefix); ok {
+			id, suffix := rest, ""
+			if n := strings.IndexAny(rest, "/?#"); n >= 0 {
+				id, suffix = rest[:n], rest[n:]
+			}
+			if newID, ok := ids[id]; ok {
+				return templateSourcePrefix + newID + suffix
+			}
+		}
+		return val
+	default:
+		return v
+	}
+}
@@ -0,0 +1,180 @@
+package portability
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/internal/dbtest"
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// tenantStore answers the statements of importing over one existing
+// uploaded template and one existing workflow. Once moved is set, another
+// update has bumped both between the import's read and its write.
+type tenantStore struct {
+	t         *testing.T
+	moved     bool
+	template  map[string]driver.Value
+	workflow  map[string]driver.Value
+	versions  []map[string]driver.Value
+	deletions []string
+}
+
+var (
+	templateColumns = []string{"id", "tenant_id", "name", "version", "status", "uploaded", "content_hash", "content_size", "content_version", "source_repository_id"}
+	workflowColumns = []string{"id", "tenant_id", "name", "version", "status", "source_repository_id"}
+)
+
+func newTenantStore(t *testing.T) *tenantStore {
+	return &tenantStore{
+		t: t,
+		template: map[string]driver.Value{
+			"id": "tpl-1", "tenant_id": "tenant-1", "name": "motd", "version": int64(4), "status": "active",
+			"uploaded": true, "content_hash": "sha256:abc", "content_size": int64(1 << 20), "content_version": int64(3),
+			"source_repository_id": nil,
+		},
+		workflow: map[string]driver.Value{
+			"id": "wf-1", "tenant_id": "tenant-1", "name": "patch", "version": int64(7), "status": "active",
+			"source_repository_id": nil,
+		},
+	}
+}
+
+func (s *tenantStore) row(values map[string]driver.Value, columns []string) *dbtest.Result {
+	row := make([]driver.Value, len(columns))
+	for i, column := range columns {
+		row[i] = values[column]
+	}
+	return &dbtest.Result{Columns: columns, Rows: [][]driver.Value{row}}
+}
+
+func (s *tenantStore) update(values map[string]driver.Value, query string, args []driver.Value) (*dbtest.Result, error) {
+	set, where := dbtest.Assignments(query, args)
+	// WHERE id = ? AND version = ?
+	if s.moved || where[1] != values["version"] {
+		return &dbtest.Result{}, nil
+	}
+	for column, value := range set {
+		values[column] = value
+	}
+	return &dbtest.Result{RowsAffected: 1}, nil
+}
+
+func (s *tenantStore) handle(query string, args []driver.Value) (*dbtest.Result, error) {
+	switch {
+	case strings.HasPrefix(query, "SELECT") && strings.Contains(query, "FROM `templates`"):
+		return s.row(s.template, templateColumns), nil
+	case strings.HasPrefix(query, "SELECT") && strings.Contains(query, "FROM `workflows`"):
+		return s.row(s.workflow, workflowColumns), nil
+	case strings.HasPrefix(query, "UPDATE `templates`"):
+		return s.update(s.template, query, args)
+	case strings.HasPrefix(query, "UPDATE `workflows`"):
+		return s.update(s.workflow, query, args)
+	case strings.HasPrefix(query, "INSERT INTO `template_versions`"):
+		s.versions = append(s.versions, dbtest.Inserted(query, args))
+		return &dbtest.Result{RowsAffected: 1}, nil
+	case strings.HasPrefix(query, "DELETE"):
+		s.deletions = append(s.deletions, query)
+		return &dbtest.Result{RowsAffected: 1}, nil
+	}
+	s.t.Fatalf("unexpected statement: %s", query)
+	return nil, nil
+}
+
+func overwriteBundle() *Bundle {
+	return &Bundle{
+		FormatVersion: BundleFormatVersion,
+		Templates: []TemplateRecord{{
+			ID:          "src-tpl",
+			Name:        "motd",
+			Content:     "Welcome to {{ .hostname }}",
+			ContentType: "text/plain",
+			Version:     2,
+			Status:      models.TemplateStatusActive,
+			Versions: []TemplateVersionRecord{
+				{Version: 1, Content: "Welcome"},
+				{Version: 2, Content: "Welcome to {{ .hostname }}"},
+			},
+		}},
+		Workflows: []WorkflowRecord{{
+			ID:         "src-wf",
+			Name:       "patch",
+			Definition: models.JSONMap{"name": "patch"},
+			Version:    2,
+			Status:     models.WorkflowStatusActive,
+		}},
+	}
+}
+
+func TestImportOverwrite(t *testing.T) {
+	store := newTenantStore(t)
+	m := NewManager(dbtest.Open(t, store.handle), zap.NewNop())
+
+	report, err := m.Import(context.Background(), "tenant-1", overwriteBundle(), CollisionOverwrite)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if report.Counts[ActionOverwritten] != 2 {
+		t.Fatalf("report = %+v, want the template and workflow overwritten", report.Items)
+	}
+
+	// The existing versions are moved on, not set back to the bundle's
+	if got := store.template["version"]; got != int64(5) {
+		t.Errorf("template version = %v, want 5", got)
+	}
+	if got := store.workflow["version"]; got != int64(8) {
+		t.Errorf("workflow version = %v, want 8", got)
+	}
+
+	// The imported content is inline, so nothing may still point at the
+	// uploaded content's chunks
+	wantReset := map[string]driver.Value{
+		"uploaded":        false,
+		"content_hash":    "",
+		"content_size":    int64(0),
+		"content_version": int64(0),
+	}
+	for column, want := range wantReset {
+		if got := store.template[column]; got != want {
+			t.Errorf("template %s = %#v, want %#v", column, got, want)
+		}
+	}
+	if got := store.template["content"]; got != "Welcome to {{ .hostname }}" {
+		t.Errorf("template content = %v", got)
+	}
+
+	// The template's history is kept and the new content recorded as its
+	// next version
+	if len(store.deletions) != 0 {
+		t.Errorf("overwrite deleted %v", store.deletions)
+	}
+	if len(store.versions) != 1 {
+		t.Fatalf("recorded %d template versions, want 1", len(store.versions))
+	}
+	if got := store.versions[0]["version"]; got != int64(5) {
+		t.Errorf("recorded template version %v, want 5", got)
+	}
+	if got := store.versions[0]["content"]; got != "Welcome to {{ .hostname }}" {
+		t.Errorf("recorded template version content = %v", got)
+	}
+}
+
+func TestImportOverwriteConflict(t *testing.T) {
+	store := newTenantStore(t)
+	store.moved = true
+	m := NewManager(dbtest.Open(t, store.handle), zap.NewNop())
+
+	_, err := m.Import(context.Background(), "tenant-1", overwriteBundle(), CollisionOverwrite)
+	if !errors.Is(err, apperror.ErrConflict) {
+		t.Fatalf("Import over a template updated meanwhile: err = %v, want a conflict", err)
+	}
+	if len(store.versions) != 0 {
+		t.Errorf("recorded %d template versions for a failed overwrite", len(store.versions))
+	}
+}
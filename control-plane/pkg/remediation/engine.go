@@ -0,0 +1,270 @@
+package remediation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/yourorg/control-plane/pkg/agent"
+	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/workflow"
+)
+
+// skipReason explains why a matching rule did not run
+type skipReason string
+
+const (
+	notSkipped   skipReason = ""
+	skipCooldown skipReason = "cooldown"
+	skipDailyCap skipReason = "daily cap reached"
+	skipInFlight skipReason = "previous remediation still running"
+)
+
+// errSkip rolls back the run transaction of a rule held back by its limits
+var errSkip = errors.New("remediation skipped")
+
+// Evaluate matches an agent's health report against the tenant's enabled
+// rules and starts the workflow of each matching rule that is out of its
+// cooldown and under its daily cap. Failures are logged; they never fail
+// the health report.
+func (m *Manager) Evaluate(ctx context.Context, tenantID, agentID string, status models.AgentStatus, components map[string]interface{}) {
+	if !m.enabled {
+		return
+	}
+
+	var rules []models.RemediationRule
+	if err := m.db.WithContext(ctx).
+		Where("tenant_id = ? AND enabled = ?", tenantID, true).
+		Find(&rules).Error; err != nil {
+		m.logger.Error("failed to load remediation rules",
+			zap.String("tenant_id", tenantID),
+			zap.Error(err))
+		return
+	}
+
+	type match struct {
+		rule    models.RemediationRule
+		status  string
+		message string
+	}
+	var matches []match
+	for _, rule := range rules {
+		reported, message := string(status), ""
+		if rule.Component != "" {
+			reported, message = agent.ComponentStatus(components[rule.Component])
+		}
+		if reported != "" && reported == rule.Status {
+			matches = append(matches, match{rule: rule, status: reported, message: message})
+		}
+	}
+	if len(matches) == 0 {
+		return
+	}
+
+	var tenant models.Tenant
+	if err := m.db.WithContext(ctx).Select("id", "remediation_paused").Where("id = ?", tenantID).First(&tenant).Error; err != nil {
+		m.logger.Error("failed to get tenant for remediation",
+			zap.String("tenant_id", tenantID),
+			zap.Error(err))
+		return
+	}
+	if tenant.RemediationPaused {
+		return
+	}
+
+	for _, match := range matches {
+		if err := m.remediate(ctx, &match.rule, agentID, match.status, match.message); err != nil {
+			m.logger.Error("failed to run remediation",
+				zap.String("rule_id", match.rule.ID),
+				zap.String("agent_id", agentID),
+				zap.Error(err))
+		}
+	}
+}
+
+// remediate starts a rule's workflow on an agent unless the rule's limits
+// hold it back. The rule row is locked while the limits are checked and the
+// run is recorded, so replicas receiving reports at once start one run.
+func (m *Manager) remediate(ctx context.Context, rule *models.RemediationRule, agentID, healthStatus, message string) error {
+	now := time.Now()
+	run := &models.RemediationRun{
+		ID:           uuid.New().String(),
+		TenantID:     rule.TenantID,
+		RuleID:       rule.ID,
+		AgentID:      agentID,
+		Component:    rule.Component,
+		HealthStatus: healthStatus,
+		Status:       models.RemediationRunStarted,
+		Message:      message,
+		StartedAt:    now,
+	}
+
+	var skipped skipReason
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var locked models.RemediationRule
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND enabled = ?", rule.ID, true).
+			First(&locked).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				// Disabled or deleted since the rules were loaded
+				return errSkip
+			}
+			return fmt.Errorf("failed to lock remediation rule: %w", err)
+		}
+
+		reason, err := m.limit(tx, &locked, agentID, now)
+		if err != nil {
+			return err
+		}
+		if reason != notSkipped {
+			skipped = reason
+			return errSkip
+		}
+
+		if err := tx.Create(run).Error; err != nil {
+			return fmt.Errorf("failed to record remediation run: %w", err)
+		}
+		return nil
+	})
+	if err == errSkip {
+		if skipped != notSkipped {
+			m.logger.Debug("remediation held back",
+				zap.String("rule_id", rule.ID),
+				zap.String("agent_id", agentID),
+				zap.String("reason", string(skipped)))
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	execution, execErr := m.executor.Execute(ctx, &workflow.ExecuteRequest{
+		TenantID:   rule.TenantID,
+		WorkflowID: rule.WorkflowID,
+		AgentID:    agentID,
+		Priority:   "high",
+	})
+	updates := map[string]interface{}{}
+	if execErr != nil {
+		run.Status = models.RemediationRunFailed
+		run.Message = execErr.Error()
+		updates["status"] = run.Status
+		updates["message"] = run.Message
+	} else {
+		run.ExecutionID = execution.ID
+		updates["execution_id"] = execution.ID
+	}
+	if err := m.db.WithContext(ctx).Model(run).Updates(updates).Error; err != nil {
+		m.logger.Warn("failed to update remediation run",
+			zap.String("run_id", run.ID),
+			zap.Error(err))
+	}
+
+	m.record(ctx, rule, run)
+	if execErr != nil {
+		return fmt.Errorf("failed to start remediation workflow: %w", execErr)
+	}
+
+	m.logger.Info("remediation started",
+		zap.String("rule_id", rule.ID),
+		zap.String("agent_id", agentID),
+		zap.String("execution_id", run.ExecutionID),
+		zap.String("component", rule.Component),
+		zap.String("health_status", healthStatus))
+	return nil
+}
+
+// limit returns why a rule may not run on an agent now, if it may not: its
+// cooldown since the last run, its daily cap, or a run still executing
+func (m *Manager) limit(tx *gorm.DB, rule *models.RemediationRule, agentID string, now time.Time) (skipReason, error) {
+	var last models.RemediationRun
+	err := tx.Where("rule_id = ? AND agent_id = ?", rule.ID, agentID).
+		Order("started_at DESC").
+		First(&last).Error
+	if err == gorm.ErrRecordNotFound {
+		return notSkipped, nil
+	}
+	if err != nil {
+		return notSkipped, fmt.Errorf("failed to get last remediation run: %w", err)
+	}
+
+	cooldown := time.Duration(rule.CooldownMinutes) * time.Minute
+	if now.Sub(last.StartedAt) < cooldown {
+		return skipCooldown, nil
+	}
+
+	if rule.MaxRunsPerDay > 0 {
+		var count int64
+		if err := tx.Model(&models.RemediationRun{}).
+			Where("rule_id = ? AND agent_id = ? AND started_at > ?", rule.ID, agentID, now.Add(-24*time.Hour)).
+			Count(&count).Error; err != nil {
+			return notSkipped, fmt.Errorf("failed to count remediation runs: %w", err)
+		}
+		if count >= int64(rule.MaxRunsPerDay) {
+			return skipDailyCap, nil
+		}
+	}
+
+	// A long workflow outlasting the cooldown is not started again
+	if last.ExecutionID != "" {
+		var running int64
+		if err := tx.Model(&models.WorkflowExecution{}).
+			Where("id = ? AND status IN ?", last.ExecutionID,
+				[]models.ExecutionStatus{models.ExecutionStatusPending, models.ExecutionStatusRunning, models.ExecutionStatusPaused}).
+			Count(&running).Error; err != nil {
+			return notSkipped, fmt.Errorf("failed to get remediation execution: %w", err)
+		}
+		if running > 0 {
+			return skipInFlight, nil
+		}
+	}
+
+	return notSkipped, nil
+}
+
+// record writes a remediation run to the tenant's audit log
+func (m *Manager) record(ctx context.Context, rule *models.RemediationRule, run *models.RemediationRun) {
+	if m.auditLogger == nil {
+		return
+	}
+
+	outcome := audit.OutcomeSuccess
+	if run.Status == models.RemediationRunFailed {
+		outcome = audit.OutcomeFailure
+	}
+	condition := "overall status"
+	if rule.Component != "" {
+		condition = "component " + rule.Component
+	}
+	if err := m.auditLogger.NewEventBuilder().
+		WithTenant(rule.TenantID).
+		WithType(audit.EventTypeWorkflow).
+		WithAction(audit.ActionExecute).
+		WithOutcome(outcome).
+		WithActor("remediation", "system").
+		WithResource(rule.WorkflowID, "workflow").
+		WithDescription(fmt.Sprintf("remediation rule %s ran on agent %s: %s %s",
+			rule.Name, run.AgentID, condition, run.HealthStatus)).
+		WithMetadata(map[string]interface{}{
+			"rule_id":       rule.ID,
+			"run_id":        run.ID,
+			"agent_id":      run.AgentID,
+			"execution_id":  run.ExecutionID,
+			"component":     run.Component,
+			"health_status": run.HealthStatus,
+			"message":       run.Message,
+		}).
+		Log(ctx); err != nil {
+		m.logger.Warn("failed to audit remediation run",
+			zap.String("run_id", run.ID),
+			zap.Error(err))
+	}
+}
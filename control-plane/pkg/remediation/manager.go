@@ -0,0 +1,297 @@
+// Package remediation closes the loop on agent health: tenants bind
+// workflows to health conditions, and agents reporting a matching status
+// have the workflow run on them. Runs are spaced by per-rule cooldowns and
+// capped per day, and rules, tenants and the whole platform can be switched
+// off.
+package remediation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/workflow"
+)
+
+// Rule defaults
+const (
+	DefaultCooldownMinutes = 30
+	DefaultMaxRunsPerDay   = 3
+)
+
+// maxRuns bounds the runs returned by ListRuns
+const maxRuns = 500
+
+// CreateRuleRequest represents a request to create a remediation rule
+type CreateRuleRequest struct {
+	TenantID    string `json:"-"`
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+	// Component is the health component to watch; empty watches the
+	// agent's overall status
+	Component  string `json:"component"`
+	Status     string `json:"status" binding:"required"`
+	WorkflowID string `json:"workflow_id" binding:"required"`
+	// CooldownMinutes defaults to 30 and MaxRunsPerDay to 3; a
+	// MaxRunsPerDay of 0 removes the cap
+	CooldownMinutes *int   `json:"cooldown_minutes"`
+	MaxRunsPerDay   *int   `json:"max_runs_per_day"`
+	Enabled         *bool  `json:"enabled"`
+	CreatedBy       string `json:"-"`
+}
+
+// UpdateRuleRequest represents a request to update a remediation rule
+type UpdateRuleRequest struct {
+	Name            *string `json:"name"`
+	Description     *string `json:"description"`
+	Component       *string `json:"component"`
+	Status          *string `json:"status"`
+	WorkflowID      *string `json:"workflow_id"`
+	CooldownMinutes *int    `json:"cooldown_minutes"`
+	MaxRunsPerDay   *int    `json:"max_runs_per_day"`
+	Enabled         *bool   `json:"enabled"`
+}
+
+// ListRunsRequest filters remediation runs
+type ListRunsRequest struct {
+	TenantID string
+	RuleID   string
+	AgentID  string
+	Since    time.Time
+	Limit    int
+}
+
+// Manager manages remediation rules and starts their workflows on agents
+// reporting matching health
+type Manager struct {
+	db          *gorm.DB
+	executor    *workflow.Executor
+	enabled     bool
+	auditLogger *audit.Logger
+	logger      *zap.Logger
+}
+
+// NewManager creates a remediation manager. With enabled false rules can be
+// managed but none runs: the platform-wide kill switch.
+func NewManager(db *gorm.DB, executor *workflow.Executor, enabled bool, logger *zap.Logger) *Manager {
+	return &Manager{
+		db:       db,
+		executor: executor,
+		enabled:  enabled,
+		logger:   logger,
+	}
+}
+
+// SetAuditLogger sets the logger that records the workflows remediation
+// starts
+func (m *Manager) SetAuditLogger(auditLogger *audit.Logger) {
+	m.auditLogger = auditLogger
+}
+
+// CreateRule creates a remediation rule for one of the tenant's workflows
+func (m *Manager) CreateRule(ctx context.Context, req *CreateRuleRequest) (*models.RemediationRule, error) {
+	now := time.Now()
+	rule := &models.RemediationRule{
+		ID:              uuid.New().String(),
+		TenantID:        req.TenantID,
+		Name:            strings.TrimSpace(req.Name),
+		Description:     req.Description,
+		Component:       strings.TrimSpace(req.Component),
+		Status:          strings.TrimSpace(req.Status),
+		WorkflowID:      req.WorkflowID,
+		CooldownMinutes: DefaultCooldownMinutes,
+		MaxRunsPerDay:   DefaultMaxRunsPerDay,
+		Enabled:         true,
+		CreatedBy:       req.CreatedBy,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	if req.CooldownMinutes != nil {
+		rule.CooldownMinutes = *req.CooldownMinutes
+	}
+	if req.MaxRunsPerDay != nil {
+		rule.MaxRunsPerDay = *req.MaxRunsPerDay
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	if err := m.validateRule(ctx, rule); err != nil {
+		return nil, err
+	}
+
+	if err := m.db.WithContext(ctx).Create(rule).Error; err != nil {
+		return nil, fmt.Errorf("failed to create remediation rule: %w", err)
+	}
+
+	m.logger.Info("remediation rule created",
+		zap.String("rule_id", rule.ID),
+		zap.String("tenant_id", rule.TenantID),
+		zap.String("workflow_id", rule.WorkflowID))
+
+	return rule, nil
+}
+
+// GetRule returns a remediation rule
+func (m *Manager) GetRule(ctx context.Context, tenantID, ruleID string) (*models.RemediationRule, error) {
+	var rule models.RemediationRule
+	if err := m.db.WithContext(ctx).Where("id = ? AND tenant_id = ?", ruleID, tenantID).First(&rule).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("remediation rule not found")
+		}
+		return nil, fmt.Errorf("failed to get remediation rule: %w", err)
+	}
+	return &rule, nil
+}
+
+// ListRules returns a tenant's remediation rules
+func (m *Manager) ListRules(ctx context.Context, tenantID string) ([]models.RemediationRule, error) {
+	var rules []models.RemediationRule
+	if err := m.db.WithContext(ctx).Where("tenant_id = ?", tenantID).Order("name ASC").Find(&rules).Error; err != nil {
+		return nil, fmt.Errorf("failed to list remediation rules: %w", err)
+	}
+	return rules, nil
+}
+
+// UpdateRule updates a remediation rule
+func (m *Manager) UpdateRule(ctx context.Context, tenantID, ruleID string, req *UpdateRuleRequest) (*models.RemediationRule, error) {
+	rule, err := m.GetRule(ctx, tenantID, ruleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		rule.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		rule.Description = *req.Description
+	}
+	if req.Component != nil {
+		rule.Component = strings.TrimSpace(*req.Component)
+	}
+	if req.Status != nil {
+		rule.Status = strings.TrimSpace(*req.Status)
+	}
+	if req.WorkflowID != nil {
+		rule.WorkflowID = *req.WorkflowID
+	}
+	if req.CooldownMinutes != nil {
+		rule.CooldownMinutes = *req.CooldownMinutes
+	}
+	if req.MaxRunsPerDay != nil {
+		rule.MaxRunsPerDay = *req.MaxRunsPerDay
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	if err := m.validateRule(ctx, rule); err != nil {
+		return nil, err
+	}
+
+	rule.UpdatedAt = time.Now()
+	if err := m.db.WithContext(ctx).Save(rule).Error; err != nil {
+		return nil, fmt.Errorf("failed to update remediation rule: %w", err)
+	}
+	return rule, nil
+}
+
+// DeleteRule deletes a remediation rule and its run history
+func (m *Manager) DeleteRule(ctx context.Context, tenantID, ruleID string) error {
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND tenant_id = ?", ruleID, tenantID).Delete(&models.RemediationRule{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete remediation rule: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return apperror.NotFound("remediation rule not found")
+		}
+		if err := tx.Where("rule_id = ?", ruleID).Delete(&models.RemediationRun{}).Error; err != nil {
+			return fmt.Errorf("failed to delete remediation runs: %w", err)
+		}
+		return nil
+	})
+}
+
+// ListRuns returns remediation runs, newest first
+func (m *Manager) ListRuns(ctx context.Context, req *ListRunsRequest) ([]models.RemediationRun, error) {
+	query := m.db.WithContext(ctx).Where("tenant_id = ?", req.TenantID)
+	if req.RuleID != "" {
+		query = query.Where("rule_id = ?", req.RuleID)
+	}
+	if req.AgentID != "" {
+		query = query.Where("agent_id = ?", req.AgentID)
+	}
+	if !req.Since.IsZero() {
+		query = query.Where("started_at >= ?", req.Since)
+	}
+	limit := req.Limit
+	if limit <= 0 || limit > maxRuns {
+		limit = maxRuns
+	}
+
+	var runs []models.RemediationRun
+	if err := query.Order("started_at DESC").Limit(limit).Find(&runs).Error; err != nil {
+		return nil, fmt.Errorf("failed to list remediation runs: %w", err)
+	}
+	return runs, nil
+}
+
+// SetPaused pauses or resumes remediation for a tenant: the tenant's kill
+// switch. Paused rules keep their settings and history.
+func (m *Manager) SetPaused(ctx context.Context, tenantID string, paused bool) error {
+	result := m.db.WithContext(ctx).Model(&models.Tenant{}).
+		Where("id = ?", tenantID).
+		Update("remediation_paused", paused)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update tenant: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		var count int64
+		if err := m.db.WithContext(ctx).Model(&models.Tenant{}).Where("id = ?", tenantID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to get tenant: %w", err)
+		}
+		if count == 0 {
+			return apperror.NotFound("tenant not found")
+		}
+	}
+
+	m.logger.Info("tenant remediation switched",
+		zap.String("tenant_id", tenantID),
+		zap.Bool("paused", paused))
+	return nil
+}
+
+// validateRule checks a rule's settings and that its workflow is one of the
+// tenant's
+func (m *Manager) validateRule(ctx context.Context, rule *models.RemediationRule) error {
+	if rule.Name == "" {
+		return apperror.InvalidInput("name is required")
+	}
+	if rule.Status == "" {
+		return apperror.InvalidInput("status is required")
+	}
+	if rule.CooldownMinutes < 0 {
+		return apperror.InvalidInput("cooldown_minutes must not be negative")
+	}
+	if rule.MaxRunsPerDay < 0 {
+		return apperror.InvalidInput("max_runs_per_day must not be negative")
+	}
+
+	var count int64
+	if err := m.db.WithContext(ctx).Model(&models.Workflow{}).
+		Where("id = ? AND tenant_id = ? AND status <> ?", rule.WorkflowID, rule.TenantID, models.WorkflowStatusDeleted).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to look up workflow: %w", err)
+	}
+	if count == 0 {
+		return apperror.NotFound("workflow not found")
+	}
+	return nil
+}
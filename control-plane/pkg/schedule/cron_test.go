@@ -0,0 +1,190 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{spec: "* * * * *"},
+		{spec: "0 3 * * *"},
+		{spec: "*/15 0-6,22,23 * * mon-fri"},
+		{spec: "5/20 * 1,15 JAN-jun 7"},
+		{spec: "30 2 * * SUN"},
+		{spec: "@daily"},
+		{spec: "@Hourly"},
+		{spec: "  0 0 1 1 *  "},
+		{spec: "", wantErr: true},
+		{spec: "0 3 * *", wantErr: true},
+		{spec: "0 3 * * * *", wantErr: true},
+		{spec: "60 * * * *", wantErr: true},
+		{spec: "* 24 * * *", wantErr: true},
+		{spec: "* * 0 * *", wantErr: true},
+		{spec: "* * * 13 *", wantErr: true},
+		{spec: "* * * * 8", wantErr: true},
+		{spec: "10-5 * * * *", wantErr: true},
+		{spec: "*/0 * * * *", wantErr: true},
+		{spec: "*/x * * * *", wantErr: true},
+		{spec: "* * * foo *", wantErr: true},
+		{spec: "1,,2 * * * *", wantErr: true},
+		{spec: "@fortnightly", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			_, err := Parse(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("timezone database unavailable: %v", err)
+	}
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skipf("timezone database unavailable: %v", err)
+	}
+	at := func(loc *time.Location, s string) time.Time {
+		t.Helper()
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name  string
+		spec  string
+		loc   *time.Location
+		after time.Time
+		want  time.Time
+	}{
+		{
+			name:  "later the same day",
+			spec:  "0 3 * * *",
+			loc:   time.UTC,
+			after: at(time.UTC, "2026-10-14 01:30"),
+			want:  at(time.UTC, "2026-10-14 03:00"),
+		},
+		{
+			name:  "not the same minute again",
+			spec:  "0 3 * * *",
+			loc:   time.UTC,
+			after: at(time.UTC, "2026-10-14 03:00"),
+			want:  at(time.UTC, "2026-10-15 03:00"),
+		},
+		{
+			name:  "read in the agent's timezone",
+			spec:  "0 3 * * *",
+			loc:   kolkata,
+			after: at(time.UTC, "2026-10-14 00:00"),
+			want:  at(time.UTC, "2026-10-14 21:30"),
+		},
+		{
+			name:  "steps",
+			spec:  "*/15 * * * *",
+			loc:   time.UTC,
+			after: at(time.UTC, "2026-10-14 10:16"),
+			want:  at(time.UTC, "2026-10-14 10:30"),
+		},
+		{
+			name:  "end of month rolls into the next year",
+			spec:  "0 0 1 1 *",
+			loc:   time.UTC,
+			after: at(time.UTC, "2026-10-14 10:00"),
+			want:  at(time.UTC, "2027-01-01 00:00"),
+		},
+		{
+			name:  "weekdays only",
+			spec:  "0 9 * * mon-fri",
+			loc:   time.UTC,
+			after: at(time.UTC, "2026-10-16 09:00"), // a Friday
+			want:  at(time.UTC, "2026-10-19 09:00"),
+		},
+		{
+			name:  "day of month or day of week when both are restricted",
+			spec:  "0 0 20 * mon",
+			loc:   time.UTC,
+			after: at(time.UTC, "2026-10-14 00:00"), // a Wednesday
+			want:  at(time.UTC, "2026-10-19 00:00"),
+		},
+		{
+			name:  "sunday as 7",
+			spec:  "0 0 * * 7",
+			loc:   time.UTC,
+			after: at(time.UTC, "2026-10-14 00:00"),
+			want:  at(time.UTC, "2026-10-18 00:00"),
+		},
+		{
+			name:  "leap day",
+			spec:  "0 0 29 2 *",
+			loc:   time.UTC,
+			after: at(time.UTC, "2026-10-14 00:00"),
+			want:  at(time.UTC, "2028-02-29 00:00"),
+		},
+		{
+			name:  "time skipped by clocks going forward does not run that day",
+			spec:  "30 2 * * *",
+			loc:   newYork,
+			after: at(newYork, "2026-03-08 00:00"),
+			want:  at(newYork, "2026-03-09 02:30"),
+		},
+		{
+			name:  "3am local on the day clocks go forward",
+			spec:  "0 3 * * *",
+			loc:   newYork,
+			after: at(newYork, "2026-03-08 00:00"),
+			want:  time.Date(2026, 3, 8, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "time repeated by clocks going back runs in its first pass",
+			spec:  "30 1 * * *",
+			loc:   newYork,
+			after: at(newYork, "2026-11-01 00:00"),
+			want:  time.Date(2026, 11, 1, 5, 30, 0, 0, time.UTC),
+		},
+		{
+			name:  "time repeated by clocks going back does not run twice",
+			spec:  "30 1 * * *",
+			loc:   newYork,
+			after: time.Date(2026, 11, 1, 5, 30, 0, 0, time.UTC),
+			want:  at(newYork, "2026-11-02 01:30"),
+		},
+		{
+			name:  "hourly through clocks going back",
+			spec:  "0 * * * *",
+			loc:   newYork,
+			after: time.Date(2026, 11, 1, 5, 0, 0, 0, time.UTC), // 1am EDT
+			want:  time.Date(2026, 11, 1, 7, 0, 0, 0, time.UTC), // 2am EST
+		},
+		{
+			name:  "never matches",
+			spec:  "0 0 30 2 *",
+			loc:   time.UTC,
+			after: at(time.UTC, "2026-10-14 00:00"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.spec, err)
+			}
+			got := expr.Next(tt.after, tt.loc)
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.after, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+// Package schedule runs workflows on a cron schedule. A schedule binds a
+// workflow to an agent selector and a cron expression, read either in one
+// fixed timezone or in each agent's own local timezone as the agent reports
+// it, so one "0 3 * * *" schedule backs up every region at 3am local time.
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/workflow"
+)
+
+// DefaultMaxAgents is the max_agents of a schedule that sets none
+const DefaultMaxAgents = 10
+
+// Limits on schedule settings
+const (
+	maxScheduleAgents   = 1000
+	maxSelectorAgentIDs = 1000
+	maxAgentRunsShown   = 1000
+)
+
+// CreateScheduleRequest represents a request to create a schedule
+type CreateScheduleRequest struct {
+	TenantID    string `json:"-"`
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+	WorkflowID  string `json:"workflow_id" binding:"required"`
+	// TargetSelector picks agents by tags, agent_ids and status; it must
+	// name tags or agent_ids
+	TargetSelector map[string]interface{} `json:"target_selector" binding:"required"`
+	Vars           map[string]interface{} `json:"vars"`
+	Cron           string                 `json:"cron" binding:"required"`
+	// Timezone defaults to UTC and TimezoneMode to fixed; agent_local reads
+	// the cron expression in each agent's timezone, falling back to
+	// Timezone for agents that report none
+	Timezone     string                      `json:"timezone"`
+	TimezoneMode models.ScheduleTimezoneMode `json:"timezone_mode"`
+	MaxAgents    *int                        `json:"max_agents"`
+	Enabled      *bool                       `json:"enabled"`
+	CreatedBy    string                      `json:"-"`
+}
+
+// UpdateScheduleRequest represents a request to update a schedule. Vars
+// and the target selector, when set, replace the current ones.
+type UpdateScheduleRequest struct {
+	Name           *string                      `json:"name"`
+	Description    *string                      `json:"description"`
+	WorkflowID     *string                      `json:"workflow_id"`
+	TargetSelector *map[string]interface{}      `json:"target_selector"`
+	Vars           *map[string]interface{}      `json:"vars"`
+	Cron           *string                      `json:"cron"`
+	Timezone       *string                      `json:"timezone"`
+	TimezoneMode   *models.ScheduleTimezoneMode `json:"timezone_mode"`
+	MaxAgents      *int                         `json:"max_agents"`
+	Enabled        *bool                        `json:"enabled"`
+}
+
+// executor starts the scheduled executions; it is the workflow executor
+// outside tests
+type executor interface {
+	Execute(ctx context.Context, req *workflow.ExecuteRequest) (*models.WorkflowExecution, error)
+}
+
+// Manager manages schedules and runs them as they come due
+type Manager struct {
+	db       *gorm.DB
+	executor executor
+	logger   *zap.Logger
+}
+
+// NewManager creates a schedule manager
+func NewManager(db *gorm.DB, executor *workflow.Executor, logger *zap.Logger) *Manager {
+	return &Manager{
+		db:       db,
+		executor: executor,
+		logger:   logger,
+	}
+}
+
+// CreateSchedule creates a schedule for one of the tenant's workflows
+func (m *Manager) CreateSchedule(ctx context.Context, req *CreateScheduleRequest) (*models.Schedule, error) {
+	now := time.Now()
+	schedule := &models.Schedule{
+		ID:             uuid.New().String(),
+		TenantID:       req.TenantID,
+		Name:           strings.TrimSpace(req.Name),
+		Description:    req.Description,
+		WorkflowID:     req.WorkflowID,
+		TargetSelector: req.TargetSelector,
+		Vars:           req.Vars,
+		Cron:           strings.TrimSpace(req.Cron),
+		Timezone:       strings.TrimSpace(req.Timezone),
+		TimezoneMode:   req.TimezoneMode,
+		MaxAgents:      DefaultMaxAgents,
+		Enabled:        true,
+		CreatedBy:      req.CreatedBy,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if req.MaxAgents != nil {
+		schedule.MaxAgents = *req.MaxAgents
+	}
+	if req.Enabled != nil {
+		schedule.Enabled = *req.Enabled
+	}
+	if err := m.validateSchedule(ctx, schedule, now); err != nil {
+		return nil, err
+	}
+
+	if err := m.db.WithContext(ctx).Create(schedule).Error; err != nil {
+		return nil, fmt.Errorf("failed to create schedule: %w", err)
+	}
+
+	m.logger.Info("schedule created",
+		zap.String("schedule_id", schedule.ID),
+		zap.String("tenant_id", schedule.TenantID),
+		zap.String("workflow_id", schedule.WorkflowID),
+		zap.String("timezone_mode", string(schedule.TimezoneMode)))
+
+	return schedule, nil
+}
+
+// GetSchedule returns a schedule
+func (m *Manager) GetSchedule(ctx context.Context, tenantID, scheduleID string) (*models.Schedule, error) {
+	var schedule models.Schedule
+	if err := m.db.WithContext(ctx).Where("id = ? AND tenant_id = ?", scheduleID, tenantID).First(&schedule).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("schedule not found")
+		}
+		return nil, fmt.Errorf("failed to get schedule: %w", err)
+	}
+	return &schedule, nil
+}
+
+// ListSchedules returns a tenant's schedules
+func (m *Manager) ListSchedules(ctx context.Context, tenantID string) ([]models.Schedule, error) {
+	var schedules []models.Schedule
+	if err := m.db.WithContext(ctx).Where("tenant_id = ?", tenantID).Order("name ASC").Find(&schedules).Error; err != nil {
+		return nil, fmt.Errorf("failed to list schedules: %w", err)
+	}
+	return schedules, nil
+}
+
+// UpdateSchedule updates a schedule and works out its next run again. An
+// agent-local schedule whose expression or timezone changed also works out
+// each agent's next run again.
+func (m *Manager) UpdateSchedule(ctx context.Context, tenantID, scheduleID string, req *UpdateScheduleRequest) (*models.Schedule, error) {
+	schedule, err := m.GetSchedule(ctx, tenantID, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	timing := schedule.Cron + "|" + schedule.Timezone + "|" + string(schedule.TimezoneMode)
+
+	if req.Name != nil {
+		schedule.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		schedule.Description = *req.Description
+	}
+	if req.WorkflowID != nil {
+		schedule.WorkflowID = *req.WorkflowID
+	}
+	if req.TargetSelector != nil {
+		schedule.TargetSelector = *req.TargetSelector
+	}
+	if req.Vars != nil {
+		schedule.Vars = *req.Vars
+	}
+	if req.Cron != nil {
+		schedule.Cron = strings.TrimSpace(*req.Cron)
+	}
+	if req.Timezone != nil {
+		schedule.Timezone = strings.TrimSpace(*req.Timezone)
+	}
+	if req.TimezoneMode != nil {
+		schedule.TimezoneMode = *req.TimezoneMode
+	}
+	if req.MaxAgents != nil {
+		schedule.MaxAgents = *req.MaxAgents
+	}
+	if req.Enabled != nil {
+		schedule.Enabled = *req.Enabled
+	}
+	now := time.Now()
+	if err := m.validateSchedule(ctx, schedule, now); err != nil {
+		return nil, err
+	}
+	retimed := timing != schedule.Cron+"|"+schedule.Timezone+"|"+string(schedule.TimezoneMode)
+
+	schedule.UpdatedAt = now
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(schedule).Error; err != nil {
+			return fmt.Errorf("failed to update schedule: %w", err)
+		}
+		if retimed {
+			if err := tx.Where("schedule_id = ?", schedule.ID).Delete(&models.ScheduleAgentRun{}).Error; err != nil {
+				return fmt.Errorf("failed to reset schedule agent runs: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// DeleteSchedule deletes a schedule and its agents' runs
+func (m *Manager) DeleteSchedule(ctx context.Context, tenantID, scheduleID string) error {
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND tenant_id = ?", scheduleID, tenantID).Delete(&models.Schedule{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete schedule: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return apperror.NotFound("schedule not found")
+		}
+		if err := tx.Where("schedule_id = ?", scheduleID).Delete(&models.ScheduleAgentRun{}).Error; err != nil {
+			return fmt.Errorf("failed to delete schedule agent runs: %w", err)
+		}
+		return nil
+	})
+}
+
+// ListAgentRuns returns the next and last run of each agent of an
+// agent-local schedule, soonest first
+func (m *Manager) ListAgentRuns(ctx context.Context, tenantID, scheduleID string) ([]models.ScheduleAgentRun, error) {
+	if _, err := m.GetSchedule(ctx, tenantID, scheduleID); err != nil {
+		return nil, err
+	}
+
+	var runs []models.ScheduleAgentRun
+	if err := m.db.WithContext(ctx).
+		Where("schedule_id = ? AND tenant_id = ?", scheduleID, tenantID).
+		Order("next_run_at ASC").
+		Limit(maxAgentRunsShown).
+		Find(&runs).Error; err != nil {
+		return nil, fmt.Errorf("failed to list schedule agent runs: %w", err)
+	}
+	return runs, nil
+}
+
+// validateSchedule checks a schedule's settings and that its workflow is
+// one of the tenant's, fills in the timezone defaults and sets when the
+// schedule is next evaluated
+func (m *Manager) validateSchedule(ctx context.Context, schedule *models.Schedule, now time.Time) error {
+	if schedule.Name == "" {
+		return apperror.InvalidInput("name is required")
+	}
+	if schedule.MaxAgents < 1 || schedule.MaxAgents > maxScheduleAgents {
+		return apperror.InvalidInput("max_agents must be between 1 and %d", maxScheduleAgents)
+	}
+	if err := validateSelector(schedule.TargetSelector); err != nil {
+		return err
+	}
+	for name := range schedule.Vars {
+		switch name {
+		case "grains", "steps":
+			return apperror.InvalidInput("vars.%s: %s is a reserved var", name, name)
+		}
+	}
+
+	if schedule.Timezone == "" {
+		schedule.Timezone = "UTC"
+	}
+	loc, err := loadLocation(schedule.Timezone)
+	if err != nil {
+		return apperror.InvalidInput("timezone: %v", err)
+	}
+	switch schedule.TimezoneMode {
+	case "":
+		schedule.TimezoneMode = models.ScheduleTimezoneFixed
+	case models.ScheduleTimezoneFixed, models.ScheduleTimezoneAgentLocal:
+	default:
+		return apperror.InvalidInput("timezone_mode must be %s or %s", models.ScheduleTimezoneFixed, models.ScheduleTimezoneAgentLocal)
+	}
+
+	expr, err := Parse(schedule.Cron)
+	if err != nil {
+		return apperror.InvalidInput("cron: %v", err)
+	}
+	next := expr.Next(now, loc)
+	if next.IsZero() {
+		return apperror.InvalidInput("cron: expression %q never matches", schedule.Cron)
+	}
+	// An agent-local schedule is evaluated right away to work out when each
+	// of its agents next runs
+	if schedule.TimezoneMode == models.ScheduleTimezoneAgentLocal {
+		next = now
+	}
+	schedule.NextRunAt = &next
+
+	var count int64
+	if err := m.db.WithContext(ctx).Model(&models.Workflow{}).
+		Where("id = ? AND tenant_id = ? AND status <> ?", schedule.WorkflowID, schedule.TenantID, models.WorkflowStatusDeleted).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to look up workflow: %w", err)
+	}
+	if count == 0 {
+		return apperror.NotFound("workflow not found")
+	}
+	return nil
+}
+
+// loadLocation loads an IANA timezone. "Local" is refused: it is the
+// control plane's own timezone, not one a schedule can rely on.
+func loadLocation(name string) (*time.Location, error) {
+	if name == "Local" {
+		return nil, fmt.Errorf("unknown time zone %s", name)
+	}
+	return time.LoadLocation(name)
+}
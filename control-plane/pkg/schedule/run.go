@@ -0,0 +1,418 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/workflow"
+)
+
+// pollInterval is how often the run loop looks for schedules that are due
+const pollInterval = 30 * time.Second
+
+// maxDuePerPoll caps how many schedules one pass evaluates
+const maxDuePerPoll = 50
+
+// claimLease is how long a claim on a schedule lasts before another replica
+// may take it over from a replica that died mid-run
+const claimLease = 10 * time.Minute
+
+// reevaluateInterval is the longest an agent-local schedule goes without
+// being evaluated, so agents that start matching its selector or report
+// another timezone are picked up between runs
+const reevaluateInterval = 15 * time.Minute
+
+// scheduledAgent is an agent a schedule's selector matches, with the
+// grains its local timezone is read from
+type scheduledAgent struct {
+	ID     string
+	Grains models.JSONMap `gorm:"type:json"`
+}
+
+// outcome is what evaluating a schedule did
+type outcome struct {
+	next time.Time
+	// ran is set when executions were started, or could not be
+	ran bool
+	err string
+}
+
+// Run evaluates schedules as they come due until the context is cancelled.
+// Every replica runs the loop; a schedule is claimed before it is
+// evaluated.
+func (m *Manager) Run(ctx context.Context) {
+	m.logger.Info("Schedule runner started",
+		zap.Duration("poll_interval", pollInterval))
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		m.runDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runDue evaluates the enabled schedules whose next run has come
+func (m *Manager) runDue(ctx context.Context) {
+	now := time.Now()
+	var schedules []models.Schedule
+	if err := m.db.WithContext(ctx).
+		Where("enabled = ? AND next_run_at <= ?", true, now).
+		Where("claimed_at IS NULL OR claimed_at < ?", now.Add(-claimLease)).
+		Order("next_run_at ASC").
+		Limit(maxDuePerPoll).
+		Find(&schedules).Error; err != nil {
+		m.logger.Error("failed to find due schedules", zap.Error(err))
+		return
+	}
+
+	for i := range schedules {
+		if ctx.Err() != nil {
+			return
+		}
+		schedule := &schedules[i]
+		claimed, err := m.claim(ctx, schedule)
+		if err != nil {
+			m.logger.Error("failed to claim schedule",
+				zap.String("schedule_id", schedule.ID),
+				zap.Error(err))
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		result := m.evaluate(ctx, schedule, time.Now())
+		if err := m.record(ctx, schedule, result); err != nil {
+			m.logger.Error("failed to record schedule run",
+				zap.String("schedule_id", schedule.ID),
+				zap.Error(err))
+		}
+	}
+}
+
+// claim marks a schedule as being evaluated; it returns false if another
+// replica holds it or already evaluated it
+func (m *Manager) claim(ctx context.Context, schedule *models.Schedule) (bool, error) {
+	now := time.Now()
+	result := m.db.WithContext(ctx).Model(&models.Schedule{}).
+		Where("id = ? AND next_run_at = ? AND (claimed_at IS NULL OR claimed_at < ?)", schedule.ID, schedule.NextRunAt, now.Add(-claimLease)).
+		Update("claimed_at", now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// record stores when a schedule is next evaluated and releases the claim
+func (m *Manager) record(ctx context.Context, schedule *models.Schedule, result *outcome) error {
+	updates := map[string]interface{}{
+		"next_run_at": result.next,
+		"claimed_at":  nil,
+	}
+	if result.ran {
+		updates["last_run_at"] = time.Now()
+		updates["last_error"] = result.err
+	}
+	// Record the next run even if shutdown interrupted this one
+	return m.db.WithContext(context.WithoutCancel(ctx)).Model(&models.Schedule{}).
+		Where("id = ?", schedule.ID).
+		Updates(updates).Error
+}
+
+// evaluate runs a due schedule and works out when it is next evaluated
+func (m *Manager) evaluate(ctx context.Context, schedule *models.Schedule, now time.Time) *outcome {
+	expr, err := Parse(schedule.Cron)
+	if err != nil {
+		// Only valid expressions are stored; check again in a while
+		return &outcome{next: now.Add(reevaluateInterval), ran: true, err: err.Error()}
+	}
+	loc, err := loadLocation(schedule.Timezone)
+	if err != nil {
+		return &outcome{next: now.Add(reevaluateInterval), ran: true, err: err.Error()}
+	}
+
+	if schedule.TimezoneMode == models.ScheduleTimezoneAgentLocal {
+		return m.evaluateAgentLocal(ctx, schedule, expr, loc, now)
+	}
+
+	result := &outcome{next: expr.Next(now, loc), ran: true}
+	agents, err := m.selectAgents(ctx, schedule)
+	if err == nil {
+		err = checkAgentCount(schedule, len(agents))
+	}
+	if err != nil {
+		result.err = err.Error()
+		return result
+	}
+	var failures []string
+	for _, agent := range agents {
+		if _, err := m.execute(ctx, schedule, agent.ID); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", agent.ID, err))
+		}
+	}
+	result.err = strings.Join(failures, "; ")
+	return result
+}
+
+// evaluateAgentLocal runs an agent-local schedule on the agents whose own
+// next run has come. Each agent's next run is worked out in the timezone it
+// reports: when the agent is first seen, after each run, and again when it
+// reports another timezone. The schedule is next evaluated at the earliest
+// of its agents' next runs.
+func (m *Manager) evaluateAgentLocal(ctx context.Context, schedule *models.Schedule, expr *Expression, fallback *time.Location, now time.Time) *outcome {
+	result := &outcome{next: now.Add(reevaluateInterval)}
+	agents, err := m.selectAgents(ctx, schedule)
+	if err == nil {
+		err = checkAgentCount(schedule, len(agents))
+	}
+	if err != nil {
+		result.ran, result.err = true, err.Error()
+		return result
+	}
+
+	var existing []models.ScheduleAgentRun
+	if err := m.db.WithContext(ctx).Where("schedule_id = ?", schedule.ID).Find(&existing).Error; err != nil {
+		result.ran, result.err = true, fmt.Sprintf("failed to load agent runs: %v", err)
+		return result
+	}
+	runs := make(map[string]*models.ScheduleAgentRun, len(existing))
+	for i := range existing {
+		runs[existing[i].AgentID] = &existing[i]
+	}
+
+	var failures []string
+	for _, agent := range agents {
+		zone, loc := agentLocation(agent.Grains, schedule.Timezone, fallback)
+		run, seen := runs[agent.ID]
+		delete(runs, agent.ID)
+		if !seen {
+			run = &models.ScheduleAgentRun{
+				ScheduleID: schedule.ID,
+				AgentID:    agent.ID,
+				TenantID:   schedule.TenantID,
+			}
+		}
+
+		// A run worked out in a timezone the agent no longer reports is
+		// worked out again rather than run
+		due := seen && run.Timezone == zone && !run.NextRunAt.After(now)
+		if due {
+			result.ran = true
+			runAt := now
+			run.LastRunAt = &runAt
+			run.LastError = ""
+			execution, err := m.execute(ctx, schedule, agent.ID)
+			if err != nil {
+				run.LastError = err.Error()
+				failures = append(failures, fmt.Sprintf("%s: %v", agent.ID, err))
+			} else {
+				run.LastExecutionID = execution.ID
+			}
+		}
+
+		if !seen || due || run.Timezone != zone {
+			run.Timezone = zone
+			run.NextRunAt = expr.Next(now, loc)
+			if run.NextRunAt.IsZero() {
+				// The expression matches no time in this zone soon; look
+				// again later
+				run.NextRunAt = now.Add(reevaluateInterval)
+			}
+			if err := m.saveAgentRun(ctx, run, seen); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", agent.ID, err))
+			}
+		}
+		if run.NextRunAt.Before(result.next) {
+			result.next = run.NextRunAt
+		}
+	}
+
+	// Agents the selector no longer matches are no longer tracked
+	if len(runs) > 0 {
+		stale := make([]string, 0, len(runs))
+		for agentID := range runs {
+			stale = append(stale, agentID)
+		}
+		if err := m.db.WithContext(ctx).
+			Where("schedule_id = ? AND agent_id IN ?", schedule.ID, stale).
+			Delete(&models.ScheduleAgentRun{}).Error; err != nil {
+			m.logger.Warn("failed to remove stale schedule agent runs",
+				zap.String("schedule_id", schedule.ID),
+				zap.Error(err))
+		}
+	}
+
+	if result.ran {
+		result.err = strings.Join(failures, "; ")
+	}
+	return result
+}
+
+// saveAgentRun stores an agent's run of a schedule
+func (m *Manager) saveAgentRun(ctx context.Context, run *models.ScheduleAgentRun, exists bool) error {
+	db := m.db.WithContext(context.WithoutCancel(ctx))
+	if !exists {
+		if err := db.Create(run).Error; err != nil {
+			return fmt.Errorf("failed to record agent run: %w", err)
+		}
+		return nil
+	}
+	if err := db.Model(&models.ScheduleAgentRun{}).
+		Where("schedule_id = ? AND agent_id = ?", run.ScheduleID, run.AgentID).
+		Updates(map[string]interface{}{
+			"timezone":          run.Timezone,
+			"next_run_at":       run.NextRunAt,
+			"last_run_at":       run.LastRunAt,
+			"last_execution_id": run.LastExecutionID,
+			"last_error":        run.LastError,
+		}).Error; err != nil {
+		return fmt.Errorf("failed to record agent run: %w", err)
+	}
+	return nil
+}
+
+// execute starts the schedule's workflow on an agent
+func (m *Manager) execute(ctx context.Context, schedule *models.Schedule, agentID string) (*models.WorkflowExecution, error) {
+	execution, err := m.executor.Execute(ctx, &workflow.ExecuteRequest{
+		TenantID:   schedule.TenantID,
+		WorkflowID: schedule.WorkflowID,
+		AgentID:    agentID,
+		Vars:       schedule.Vars,
+	})
+	if err != nil {
+		m.logger.Warn("scheduled execution failed to start",
+			zap.String("schedule_id", schedule.ID),
+			zap.String("agent_id", agentID),
+			zap.Error(err))
+		return nil, err
+	}
+	return execution, nil
+}
+
+// checkAgentCount refuses a run whose selector matches no agents or more
+// than the schedule allows
+func checkAgentCount(schedule *models.Schedule, count int) error {
+	if count == 0 {
+		return apperror.InvalidState("target selector matches no agents that can take work")
+	}
+	if count > schedule.MaxAgents {
+		return apperror.InvalidState("target selector matches more than the schedule's max_agents of %d", schedule.MaxAgents)
+	}
+	return nil
+}
+
+// agentLocation returns the timezone an agent-local schedule is read in for
+// an agent: the IANA timezone the agent reports, else its reported UTC
+// offset, else the schedule's own timezone
+func agentLocation(grains models.JSONMap, fallbackName string, fallback *time.Location) (string, *time.Location) {
+	if name, _ := grains["timezone"].(string); name != "" {
+		if loc, err := loadLocation(name); err == nil {
+			return name, loc
+		}
+	}
+	if offset, _ := grains["utc_offset"].(string); offset != "" {
+		if t, err := time.Parse("-07:00", offset); err == nil {
+			_, seconds := t.Zone()
+			name := "UTC" + offset
+			return name, time.FixedZone(name, seconds)
+		}
+	}
+	return fallbackName, fallback
+}
+
+// selectAgents returns the agents a schedule's selector matches that can
+// take new work, one more than the schedule allows at most
+func (m *Manager) selectAgents(ctx context.Context, schedule *models.Schedule) ([]scheduledAgent, error) {
+	query := m.db.WithContext(ctx).Model(&models.Agent{}).
+		Select("id", "grains").
+		Where("tenant_id = ? AND drain_state = ? AND approval_status = ?", schedule.TenantID, models.AgentDrainNone, models.AgentApprovalApproved)
+
+	if tags, ok := schedule.TargetSelector["tags"].(map[string]interface{}); ok {
+		for key, value := range tags {
+			query = query.Where("JSON_EXTRACT(tags, ?) = ?", "$."+key, value)
+		}
+	}
+	if status, ok := schedule.TargetSelector["status"].(string); ok {
+		query = query.Where("status = ?", status)
+	}
+	ids, err := selectorAgentIDs(schedule.TargetSelector)
+	if err != nil {
+		return nil, err
+	}
+	if ids != nil {
+		query = query.Where("id IN ?", ids)
+	}
+
+	var agents []scheduledAgent
+	if err := query.Order("id").Limit(schedule.MaxAgents + 1).Find(&agents).Error; err != nil {
+		return nil, fmt.Errorf("failed to select agents: %w", err)
+	}
+	return agents, nil
+}
+
+// validateSelector checks that a selector names tags or agent_ids, so a
+// schedule never targets every agent of a tenant
+func validateSelector(selector map[string]interface{}) error {
+	if selector == nil {
+		return apperror.InvalidInput("target_selector is required")
+	}
+	tags, _ := selector["tags"].(map[string]interface{})
+	if raw, ok := selector["tags"]; ok && raw != nil && tags == nil {
+		return apperror.InvalidInput("target_selector.tags must be an object")
+	}
+	ids, err := selectorAgentIDs(selector)
+	if err != nil {
+		return err
+	}
+	if len(tags) == 0 && ids == nil {
+		return apperror.InvalidInput("target_selector must select agents by tags or agent_ids")
+	}
+	if raw, ok := selector["status"]; ok {
+		if _, ok := raw.(string); !ok {
+			return apperror.InvalidInput("target_selector.status must be a string")
+		}
+	}
+	return nil
+}
+
+// selectorAgentIDs returns the agent IDs a selector lists, or nil if it
+// lists none
+func selectorAgentIDs(selector map[string]interface{}) ([]string, error) {
+	raw, ok := selector["agent_ids"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+
+	var ids []string
+	switch v := raw.(type) {
+	case []string:
+		ids = v
+	case []interface{}:
+		for _, item := range v {
+			id, ok := item.(string)
+			if !ok {
+				return nil, apperror.InvalidInput("target_selector.agent_ids must be a list of agent IDs")
+			}
+			ids = append(ids, id)
+		}
+	default:
+		return nil, apperror.InvalidInput("target_selector.agent_ids must be a list of agent IDs")
+	}
+	if len(ids) == 0 || len(ids) > maxSelectorAgentIDs {
+		return nil, apperror.InvalidInput("target_selector.agent_ids must list between 1 and %d agents", maxSelectorAgentIDs)
+	}
+	return ids, nil
+}
@@ -0,0 +1,247 @@
+package schedule
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/internal/dbtest"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/workflow"
+	// The agent model has encrypted columns
+	_ "github.com/yourorg/control-plane/pkg/encryption"
+)
+
+// fleet answers the statements of evaluating an agent-local schedule: the
+// agents its selector matches, by ID with their grains, and their runs
+type fleet struct {
+	t      *testing.T
+	order  []string
+	grains map[string]map[string]interface{}
+	runs   map[string]map[string]driver.Value
+}
+
+var runColumns = []string{"schedule_id", "agent_id", "tenant_id", "timezone", "next_run_at", "last_run_at", "last_execution_id", "last_error"}
+
+func (f *fleet) handle(query string, args []driver.Value) (*dbtest.Result, error) {
+	switch {
+	case strings.HasPrefix(query, "SELECT") && strings.Contains(query, "FROM `agents`"):
+		result := &dbtest.Result{Columns: []string{"id", "grains"}}
+		for _, id := range f.order {
+			grains, _ := json.Marshal(f.grains[id])
+			result.Rows = append(result.Rows, []driver.Value{id, grains})
+		}
+		return result, nil
+	case strings.HasPrefix(query, "SELECT") && strings.Contains(query, "FROM `schedule_agent_runs`"):
+		result := &dbtest.Result{Columns: runColumns}
+		for _, run := range f.runs {
+			row := make([]driver.Value, len(runColumns))
+			for i, column := range runColumns {
+				row[i] = run[column]
+			}
+			result.Rows = append(result.Rows, row)
+		}
+		return result, nil
+	case strings.HasPrefix(query, "INSERT INTO `schedule_agent_runs`"):
+		row := dbtest.Inserted(query, args)
+		f.runs[row["agent_id"].(string)] = row
+		return &dbtest.Result{RowsAffected: 1}, nil
+	case strings.HasPrefix(query, "UPDATE `schedule_agent_runs`"):
+		set, where := dbtest.Assignments(query, args)
+		run := f.runs[where[1].(string)]
+		for column, value := range set {
+			run[column] = value
+		}
+		return &dbtest.Result{RowsAffected: 1}, nil
+	case strings.HasPrefix(query, "DELETE FROM `schedule_agent_runs`"):
+		for _, arg := range args[1:] {
+			delete(f.runs, arg.(string))
+		}
+		return &dbtest.Result{RowsAffected: int64(len(args) - 1)}, nil
+	}
+	f.t.Fatalf("unexpected statement: %s", query)
+	return nil, nil
+}
+
+// startedExecutions records the agents executions were started on
+type startedExecutions struct {
+	agents []string
+}
+
+func (s *startedExecutions) Execute(ctx context.Context, req *workflow.ExecuteRequest) (*models.WorkflowExecution, error) {
+	s.agents = append(s.agents, req.AgentID)
+	return &models.WorkflowExecution{ID: "exec-" + req.AgentID}, nil
+}
+
+func TestEvaluateAgentLocal(t *testing.T) {
+	f := &fleet{
+		t:     t,
+		order: []string{"agent-delhi", "agent-nyc", "agent-offset", "agent-unknown"},
+		grains: map[string]map[string]interface{}{
+			"agent-delhi":   {"timezone": "Asia/Kolkata"},
+			"agent-nyc":     {"timezone": "America/New_York", "utc_offset": "-04:00"},
+			"agent-offset":  {"timezone": "XYZ", "utc_offset": "+02:00"},
+			"agent-unknown": {},
+		},
+		runs: map[string]map[string]driver.Value{},
+	}
+	started := &startedExecutions{}
+	m := &Manager{db: dbtest.Open(t, f.handle), executor: started, logger: zap.NewNop()}
+	schedule := &models.Schedule{
+		ID:             "schedule-1",
+		TenantID:       "tenant-1",
+		WorkflowID:     "backup",
+		TargetSelector: models.JSONMap{"tags": map[string]interface{}{"role": "db"}},
+		Cron:           "0 3 * * *",
+		Timezone:       "UTC",
+		TimezoneMode:   models.ScheduleTimezoneAgentLocal,
+		MaxAgents:      10,
+	}
+	nextRun := func(agentID string) time.Time {
+		t.Helper()
+		run, ok := f.runs[agentID]
+		if !ok {
+			t.Fatalf("no run recorded for %s", agentID)
+		}
+		return run["next_run_at"].(time.Time)
+	}
+	utc := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	// First seen, every agent's next 3am is worked out in its own timezone
+	// and nothing runs
+	now := utc("2026-10-14 12:00")
+	result := m.evaluate(context.Background(), schedule, now)
+	if result.ran || len(started.agents) != 0 {
+		t.Fatalf("first evaluation started %v", started.agents)
+	}
+	wantNext := map[string]time.Time{
+		"agent-delhi":   utc("2026-10-14 21:30"),
+		"agent-nyc":     utc("2026-10-15 07:00"),
+		"agent-offset":  utc("2026-10-15 01:00"),
+		"agent-unknown": utc("2026-10-15 03:00"),
+	}
+	for agentID, want := range wantNext {
+		if got := nextRun(agentID); !got.Equal(want) {
+			t.Errorf("%s next run = %v, want %v", agentID, got, want)
+		}
+	}
+	if want := now.Add(reevaluateInterval); !result.next.Equal(want) {
+		t.Errorf("schedule next evaluated at %v, want %v", result.next, want)
+	}
+	if tz := f.runs["agent-offset"]["timezone"]; tz != "UTC+02:00" {
+		t.Errorf("agent-offset timezone = %v, want UTC+02:00", tz)
+	}
+
+	// At 3am in Delhi only the Delhi agent runs, and its next run moves to
+	// the following day
+	now = utc("2026-10-14 21:30")
+	result = m.evaluate(context.Background(), schedule, now)
+	if !result.ran || result.err != "" {
+		t.Fatalf("evaluation at Delhi's 3am: ran = %v, err = %q", result.ran, result.err)
+	}
+	if len(started.agents) != 1 || started.agents[0] != "agent-delhi" {
+		t.Fatalf("started %v, want [agent-delhi]", started.agents)
+	}
+	if got, want := nextRun("agent-delhi"), utc("2026-10-15 21:30"); !got.Equal(want) {
+		t.Errorf("agent-delhi next run = %v, want %v", got, want)
+	}
+	if got := f.runs["agent-delhi"]["last_execution_id"]; got != "exec-agent-delhi" {
+		t.Errorf("agent-delhi last execution = %v", got)
+	}
+	if want := now.Add(reevaluateInterval); !result.next.Equal(want) {
+		t.Errorf("schedule next evaluated at %v, want %v", result.next, want)
+	}
+
+	// An agent reporting another timezone has its next run worked out
+	// again; an agent the selector no longer matches is dropped
+	f.grains["agent-nyc"] = map[string]interface{}{"timezone": "Europe/Berlin"}
+	f.order = []string{"agent-delhi", "agent-nyc", "agent-offset"}
+	now = utc("2026-10-14 23:50")
+	result = m.evaluate(context.Background(), schedule, now)
+	if result.ran || len(started.agents) != 1 {
+		t.Fatalf("re-evaluation started %v", started.agents)
+	}
+	if got, want := nextRun("agent-nyc"), utc("2026-10-15 01:00"); !got.Equal(want) {
+		t.Errorf("agent-nyc next run after moving to Berlin = %v, want %v", got, want)
+	}
+	if _, ok := f.runs["agent-unknown"]; ok {
+		t.Error("run of an agent no longer selected was kept")
+	}
+	if want := utc("2026-10-15 00:05"); !result.next.Equal(want) {
+		t.Errorf("schedule next evaluated at %v, want %v", result.next, want)
+	}
+
+	// The Berlin and +02:00 agents both run at 01:00 UTC
+	now = utc("2026-10-15 01:00")
+	result = m.evaluate(context.Background(), schedule, now)
+	if !result.ran || len(started.agents) != 3 {
+		t.Fatalf("started %v, want the Berlin and +02:00 agents too", started.agents)
+	}
+}
+
+func TestEvaluateAgentLocalMaxAgents(t *testing.T) {
+	f := &fleet{
+		t:      t,
+		order:  []string{"agent-1", "agent-2"},
+		grains: map[string]map[string]interface{}{},
+		runs:   map[string]map[string]driver.Value{},
+	}
+	started := &startedExecutions{}
+	m := &Manager{db: dbtest.Open(t, f.handle), executor: started, logger: zap.NewNop()}
+	schedule := &models.Schedule{
+		ID:             "schedule-1",
+		TenantID:       "tenant-1",
+		TargetSelector: models.JSONMap{"agent_ids": []interface{}{"agent-1", "agent-2"}},
+		Cron:           "0 3 * * *",
+		Timezone:       "UTC",
+		TimezoneMode:   models.ScheduleTimezoneAgentLocal,
+		MaxAgents:      1,
+	}
+
+	result := m.evaluate(context.Background(), schedule, time.Now())
+	if !result.ran || !strings.Contains(result.err, "max_agents") {
+		t.Fatalf("ran = %v, err = %q, want a max_agents error", result.ran, result.err)
+	}
+	if len(started.agents) != 0 || len(f.runs) != 0 {
+		t.Fatalf("over max_agents started %v and recorded %d runs", started.agents, len(f.runs))
+	}
+}
+
+func TestAgentLocation(t *testing.T) {
+	fallback := time.FixedZone("fallback", 0)
+	tests := []struct {
+		name     string
+		grains   models.JSONMap
+		wantZone string
+	}{
+		{name: "IANA timezone", grains: models.JSONMap{"timezone": "Europe/Berlin", "utc_offset": "+02:00"}, wantZone: "Europe/Berlin"},
+		{name: "unknown timezone falls back to the offset", grains: models.JSONMap{"timezone": "CEST", "utc_offset": "+02:00"}, wantZone: "UTC+02:00"},
+		{name: "offset only", grains: models.JSONMap{"utc_offset": "-05:30"}, wantZone: "UTC-05:30"},
+		{name: "control plane's local timezone is not used", grains: models.JSONMap{"timezone": "Local"}, wantZone: "Europe/Paris"},
+		{name: "malformed offset", grains: models.JSONMap{"utc_offset": "five"}, wantZone: "Europe/Paris"},
+		{name: "no grains", grains: nil, wantZone: "Europe/Paris"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone, loc := agentLocation(tt.grains, "Europe/Paris", fallback)
+			if zone != tt.wantZone {
+				t.Errorf("zone = %q, want %q", zone, tt.wantZone)
+			}
+			if loc == nil {
+				t.Fatal("nil location")
+			}
+		})
+	}
+}
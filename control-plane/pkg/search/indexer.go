@@ -0,0 +1,382 @@
+// Package search indexes workflow execution output in Quickwit so it can be
+// searched across executions and agents.
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// maxSearchHits caps the number of hits returned by a single search
+const maxSearchHits = 1000
+
+// Indexer batches execution step output into the output index
+type Indexer struct {
+	client *audit.QuickwitClient
+	config *Config
+	logger *zap.Logger
+
+	// Batching
+	mu          sync.Mutex
+	batch       []OutputDocument
+	flushTicker *time.Ticker
+	stopChan    chan struct{}
+	wg          sync.WaitGroup
+}
+
+// NewIndexer creates a new execution output indexer and starts its batch processor
+func NewIndexer(client *audit.QuickwitClient, config *Config, logger *zap.Logger) *Indexer {
+	i := &Indexer{
+		client:   client,
+		config:   config,
+		logger:   logger,
+		batch:    make([]OutputDocument, 0, config.BatchSize),
+		stopChan: make(chan struct{}),
+	}
+
+	i.flushTicker = time.NewTicker(config.FlushInterval)
+	i.wg.Add(1)
+
+	go func() {
+		defer i.wg.Done()
+		for {
+			select {
+			case <-i.flushTicker.C:
+				if err := i.Flush(context.Background()); err != nil {
+					i.logger.Error("failed to flush execution output", zap.Error(err))
+				}
+			case <-i.stopChan:
+				return
+			}
+		}
+	}()
+
+	return i
+}
+
+// Close stops the indexer and flushes remaining documents
+func (i *Indexer) Close() error {
+	i.flushTicker.Stop()
+	close(i.stopChan)
+	i.wg.Wait()
+
+	return i.Flush(context.Background())
+}
+
+// EnsureIndex ensures the output index exists
+func (i *Indexer) EnsureIndex(ctx context.Context) error {
+	exists, err := i.client.IndexExists(ctx, i.config.IndexID)
+	if err != nil {
+		return fmt.Errorf("failed to check index existence: %w", err)
+	}
+
+	if !exists {
+		if err := i.client.CreateIndex(ctx, DefaultOutputIndexConfig(i.config.IndexID)); err != nil {
+			return fmt.Errorf("failed to create index: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// agentResult is the part of an agent workflow result that is indexed
+type agentResult struct {
+	Name    string      `json:"name"`
+	Status  string      `json:"status"`
+	Error   string      `json:"error"`
+	EndedAt *time.Time  `json:"ended_at"`
+	Steps   []agentStep `json:"steps"`
+}
+
+// agentStep is the part of an agent step result that is indexed
+type agentStep struct {
+	StepID   string     `json:"step_id"`
+	StepName string     `json:"step_name"`
+	Status   string     `json:"status"`
+	ExitCode int        `json:"exit_code"`
+	Output   string     `json:"output"`
+	Error    string     `json:"error"`
+	EndedAt  *time.Time `json:"ended_at"`
+	// ParsedOutput is the stdout parsed by the step's output parser
+	ParsedOutput map[string]interface{} `json:"parsed_output"`
+}
+
+// IndexResult queues the step output of a completed execution for indexing.
+// It never blocks on Quickwit; documents are sent by the batch processor.
+func (i *Indexer) IndexResult(execution *models.WorkflowExecution, result map[string]interface{}) {
+	docs, err := i.documents(execution, result)
+	if err != nil {
+		i.logger.Warn("failed to decode execution result for indexing",
+			zap.String("execution_id", execution.ID),
+			zap.Error(err))
+		return
+	}
+	if len(docs) == 0 {
+		return
+	}
+
+	i.mu.Lock()
+	i.batch = append(i.batch, docs...)
+	dropped := i.trimLocked()
+	i.mu.Unlock()
+
+	if dropped > 0 {
+		i.logger.Warn("execution output index backlog full, dropped oldest documents",
+			zap.Int("dropped", dropped))
+	}
+}
+
+// Pending returns the number of documents waiting to be indexed and the
+// most that are kept before the oldest are dropped
+func (i *Indexer) Pending() (pending, max int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return len(i.batch), i.config.MaxPending
+}
+
+// trimLocked drops the oldest queued documents beyond MaxPending
+func (i *Indexer) trimLocked() int {
+	if i.config.MaxPending <= 0 || len(i.batch) <= i.config.MaxPending {
+		return 0
+	}
+	dropped := len(i.batch) - i.config.MaxPending
+	i.batch = append([]OutputDocument(nil), i.batch[dropped:]...)
+	return dropped
+}
+
+// documents converts an agent workflow result into output documents
+func (i *Indexer) documents(execution *models.WorkflowExecution, result map[string]interface{}) ([]OutputDocument, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	var res agentResult
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+
+	completedAt := time.Now().UTC()
+	if res.EndedAt != nil && !res.EndedAt.IsZero() {
+		completedAt = *res.EndedAt
+	}
+
+	base := OutputDocument{
+		TenantID:     execution.TenantID,
+		ExecutionID:  execution.ID,
+		WorkflowID:   execution.WorkflowID,
+		WorkflowName: res.Name,
+		AgentID:      execution.AgentID,
+	}
+	if execution.CampaignID != nil {
+		base.CampaignID = *execution.CampaignID
+	}
+
+	docs := make([]OutputDocument, 0, len(res.Steps)+1)
+	for _, step := range res.Steps {
+		doc := base
+		doc.ID = execution.ID + ":" + step.StepID
+		doc.Timestamp = completedAt
+		if step.EndedAt != nil && !step.EndedAt.IsZero() {
+			doc.Timestamp = *step.EndedAt
+		}
+		doc.StepID = step.StepID
+		doc.StepName = step.StepName
+		doc.Status = step.Status
+		doc.ExitCode = step.ExitCode
+		doc.Output, doc.OutputTruncated = truncate(step.Output, i.config.MaxOutputBytes)
+		doc.Error, _ = truncate(step.Error, i.config.MaxOutputBytes)
+		doc.Parsed = step.ParsedOutput
+		docs = append(docs, doc)
+	}
+
+	if res.Error != "" {
+		doc := base
+		doc.ID = execution.ID
+		doc.Timestamp = completedAt
+		doc.Status = res.Status
+		doc.Error, _ = truncate(res.Error, i.config.MaxOutputBytes)
+		docs = append(docs, doc)
+	}
+
+	return docs, nil
+}
+
+// truncate shortens s to at most max bytes without splitting a UTF-8 sequence
+func truncate(s string, max int) (string, bool) {
+	if max <= 0 || len(s) <= max {
+		return s, false
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut], true
+}
+
+// Flush sends queued documents to Quickwit
+func (i *Indexer) Flush(ctx context.Context) error {
+	for {
+		i.mu.Lock()
+		if len(i.batch) == 0 {
+			i.mu.Unlock()
+			return nil
+		}
+
+		n := len(i.batch)
+		if i.config.BatchSize > 0 && n > i.config.BatchSize {
+			n = i.config.BatchSize
+		}
+		batch := i.batch[:n]
+		i.batch = i.batch[n:]
+		i.mu.Unlock()
+
+		docs := make([]interface{}, len(batch))
+		for j := range batch {
+			docs[j] = batch[j]
+		}
+
+		if err := i.client.IngestDocuments(ctx, i.config.IndexID, docs); err != nil {
+			// Put documents back in the queue on failure
+			i.mu.Lock()
+			i.batch = append(append([]OutputDocument(nil), batch...), i.batch...)
+			i.trimLocked()
+			i.mu.Unlock()
+			return err
+		}
+
+		i.logger.Debug("flushed execution output", zap.Int("count", len(batch)))
+	}
+}
+
+// Search searches execution output. Results are always restricted to the
+// query's tenant and sorted newest first.
+func (i *Indexer) Search(ctx context.Context, query *Query) (*Result, error) {
+	if query.TenantID == "" {
+		return nil, fmt.Errorf("tenant ID is required")
+	}
+	if err := validateQueryText(query.Text); err != nil {
+		return nil, err
+	}
+
+	maxHits := query.MaxHits
+	if maxHits <= 0 {
+		maxHits = 50
+	}
+	if maxHits > maxSearchHits {
+		maxHits = maxSearchHits
+	}
+
+	searchReq := map[string]interface{}{
+		"query":        buildQueryString(query),
+		"max_hits":     maxHits,
+		"start_offset": query.StartOffset,
+		"sort_by":      "-timestamp",
+	}
+	if query.StartTime != nil {
+		searchReq["start_timestamp"] = query.StartTime.Unix()
+	}
+	if query.EndTime != nil {
+		searchReq["end_timestamp"] = query.EndTime.Unix()
+	}
+
+	raw, err := i.client.SearchIndex(ctx, i.config.IndexID, searchReq)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		NumHits:     raw.NumHits,
+		ElapsedSecs: raw.ElapsedSecs,
+		Hits:        make([]OutputDocument, 0, len(raw.Hits)),
+	}
+	for _, hit := range raw.Hits {
+		var doc OutputDocument
+		if err := json.Unmarshal(hit, &doc); err != nil {
+			i.logger.Error("failed to unmarshal hit", zap.Error(err))
+			continue
+		}
+		result.Hits = append(result.Hits, doc)
+	}
+
+	return result, nil
+}
+
+// buildQueryString builds the Quickwit query for an output search. Filter
+// values are quoted, and the free-text query is parenthesized so it cannot
+// widen the tenant filter.
+func buildQueryString(query *Query) string {
+	parts := []string{fmt.Sprintf("tenant_id:%s", quote(query.TenantID))}
+
+	filters := []struct {
+		field string
+		value string
+	}{
+		{"agent_id", query.AgentID},
+		{"workflow_id", query.WorkflowID},
+		{"execution_id", query.ExecutionID},
+		{"campaign_id", query.CampaignID},
+		{"status", query.Status},
+	}
+	for _, f := range filters {
+		if f.value != "" {
+			parts = append(parts, fmt.Sprintf("%s:%s", f.field, quote(f.value)))
+		}
+	}
+
+	if text := strings.TrimSpace(query.Text); text != "" {
+		parts = append(parts, "("+text+")")
+	}
+
+	return strings.Join(parts, " AND ")
+}
+
+// validateQueryText checks that quotes and parentheses in a free-text query
+// are balanced, so it stays a single group when combined with the filters
+func validateQueryText(text string) error {
+	depth := 0
+	inQuote := false
+	for j := 0; j < len(text); j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case '"':
+			inQuote = !inQuote
+		case '(':
+			if !inQuote {
+				depth++
+			}
+		case ')':
+			if !inQuote {
+				depth--
+				if depth < 0 {
+					return apperror.InvalidInput("query has unbalanced parentheses")
+				}
+			}
+		}
+	}
+	if inQuote {
+		return apperror.InvalidInput("query has an unterminated quote")
+	}
+	if depth != 0 {
+		return apperror.InvalidInput("query has unbalanced parentheses")
+	}
+	return nil
+}
+
+// quote returns value as a Quickwit phrase
+func quote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`
+}
@@ -0,0 +1,125 @@
+// Package search indexes workflow execution output in Quickwit so it can be
+// searched across executions and agents.
+package search
+
+import (
+	"time"
+
+	"github.com/yourorg/control-plane/pkg/audit"
+)
+
+// Config represents execution output indexing configuration
+type Config struct {
+	IndexID        string        `json:"index_id" yaml:"index_id"`
+	MaxOutputBytes int           `json:"max_output_bytes" yaml:"max_output_bytes"`
+	BatchSize      int           `json:"batch_size" yaml:"batch_size"`
+	FlushInterval  time.Duration `json:"flush_interval" yaml:"flush_interval"`
+	MaxPending     int           `json:"max_pending" yaml:"max_pending"`
+}
+
+// DefaultConfig returns default configuration
+func DefaultConfig() *Config {
+	return &Config{
+		IndexID:        "execution-outputs",
+		MaxOutputBytes: 64 * 1024,
+		BatchSize:      200,
+		FlushInterval:  5 * time.Second,
+		MaxPending:     10000,
+	}
+}
+
+// OutputDocument is the indexed output of one execution step. Workflow-level
+// errors are indexed with an empty step ID.
+type OutputDocument struct {
+	ID              string    `json:"id"`
+	Timestamp       time.Time `json:"timestamp"`
+	TenantID        string    `json:"tenant_id"`
+	ExecutionID     string    `json:"execution_id"`
+	WorkflowID      string    `json:"workflow_id"`
+	WorkflowName    string    `json:"workflow_name,omitempty"`
+	AgentID         string    `json:"agent_id"`
+	CampaignID      string    `json:"campaign_id,omitempty"`
+	StepID          string    `json:"step_id,omitempty"`
+	StepName        string    `json:"step_name,omitempty"`
+	Status          string    `json:"status"`
+	ExitCode        int       `json:"exit_code"`
+	Output          string    `json:"output,omitempty"`
+	Error           string    `json:"error,omitempty"`
+	OutputTruncated bool      `json:"output_truncated,omitempty"`
+	// Parsed is the step's parsed output, searchable as parsed.<key>
+	Parsed map[string]interface{} `json:"parsed,omitempty"`
+}
+
+// DefaultOutputIndexConfig returns the default index configuration for execution output
+func DefaultOutputIndexConfig(indexID string) *audit.QuickwitIndexConfig {
+	return &audit.QuickwitIndexConfig{
+		Version: "0.7",
+		IndexID: indexID,
+		DocMapping: audit.DocMapping{
+			Mode:           "dynamic",
+			TimestampField: "timestamp",
+			TagFields:      []string{"tenant_id", "status"},
+			PartitionKey:   "tenant_id",
+			FieldMappings: []audit.FieldMapping{
+				{Name: "id", Type: "text", Indexed: true, Stored: true, Tokenizer: "raw"},
+				{Name: "timestamp", Type: "datetime", Indexed: true, Stored: true, Fast: true},
+				{Name: "tenant_id", Type: "text", Indexed: true, Stored: true, Fast: true, Tokenizer: "raw"},
+				{Name: "execution_id", Type: "text", Indexed: true, Stored: true, Tokenizer: "raw"},
+				{Name: "workflow_id", Type: "text", Indexed: true, Stored: true, Fast: true, Tokenizer: "raw"},
+				{Name: "workflow_name", Type: "text", Indexed: true, Stored: true},
+				{Name: "agent_id", Type: "text", Indexed: true, Stored: true, Fast: true, Tokenizer: "raw"},
+				{Name: "campaign_id", Type: "text", Indexed: true, Stored: true, Tokenizer: "raw"},
+				{Name: "step_id", Type: "text", Indexed: true, Stored: true, Tokenizer: "raw"},
+				{Name: "step_name", Type: "text", Indexed: true, Stored: true},
+				{Name: "status", Type: "text", Indexed: true, Stored: true, Fast: true, Tokenizer: "raw"},
+				{Name: "exit_code", Type: "i64", Indexed: true, Stored: true, Fast: true},
+				{Name: "output", Type: "text", Indexed: true, Stored: true, Tokenizer: "default", Record: "position"},
+				{Name: "error", Type: "text", Indexed: true, Stored: true, Tokenizer: "default", Record: "position"},
+				{Name: "output_truncated", Type: "bool", Indexed: true, Stored: true},
+				{Name: "parsed", Type: "json", Indexed: true, Stored: true, Fast: true, Tokenizer: "raw"},
+			},
+		},
+		SearchSettings: audit.SearchSettings{
+			DefaultSearchFields: []string{"output", "error"},
+		},
+		IndexingSettings: audit.IndexingSettings{
+			CommitTimeoutSecs: 30,
+			MergePolicy: audit.MergePolicy{
+				Type:             "log_merge",
+				MinMergeSegments: 3,
+				MergeFactor:      10,
+				MaxMergeSegments: 10,
+			},
+			Resources: audit.IndexingResources{
+				NumThreads: 2,
+				HeapSize:   "500MB",
+			},
+		},
+		RetentionPolicy: &audit.RetentionPolicy{
+			Period:   "30 days",
+			Schedule: "daily",
+		},
+	}
+}
+
+// Query represents an execution output search
+type Query struct {
+	TenantID    string     `json:"-"`
+	Text        string     `json:"query"`
+	AgentID     string     `json:"agent_id,omitempty"`
+	WorkflowID  string     `json:"workflow_id,omitempty"`
+	ExecutionID string     `json:"execution_id,omitempty"`
+	CampaignID  string     `json:"campaign_id,omitempty"`
+	Status      string     `json:"status,omitempty"`
+	StartTime   *time.Time `json:"start_time,omitempty"`
+	EndTime     *time.Time `json:"end_time,omitempty"`
+	MaxHits     int        `json:"max_hits"`
+	StartOffset int        `json:"start_offset"`
+}
+
+// Result represents execution output search results
+type Result struct {
+	Hits        []OutputDocument `json:"hits"`
+	NumHits     int64            `json:"num_hits"`
+	ElapsedSecs float64          `json:"elapsed_secs"`
+}
@@ -0,0 +1,352 @@
+// Package shell brokers live shell sessions between operators and agents.
+// The operator's WebSocket is relayed through Piko to a pseudo-terminal on
+// the agent, and everything typed and printed is recorded for audit.
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/workflow"
+)
+
+// Reasons a session ends. Agents report their own reasons, such as
+// orphaned or agent_stopped, when they end a session first.
+const (
+	ReasonClosed           = "closed"
+	ReasonExited           = "exited"
+	ReasonMaxDuration      = "max_duration"
+	ReasonIdleTimeout      = "idle_timeout"
+	ReasonRecordingLimit   = "recording_limit"
+	ReasonAgentUnreachable = "agent_unreachable"
+	ReasonAbandoned        = "abandoned"
+	// ReasonSessionLost means the agent no longer knows the session, e.g.
+	// after it restarted
+	ReasonSessionLost = "session_lost"
+	// ReasonRecordingFailed means the recording could not be written, so
+	// the session could not go on being audited
+	ReasonRecordingFailed = "recording_failed"
+	// ReasonShutdown means the control plane replica serving the session
+	// stopped
+	ReasonShutdown = "control_plane_stopped"
+)
+
+// Config contains shell session limits
+type Config struct {
+	// MaxDuration ends sessions that run longer
+	MaxDuration time.Duration `json:"max_duration" yaml:"max_duration"`
+	// IdleTimeout ends sessions without input for this long
+	IdleTimeout time.Duration `json:"idle_timeout" yaml:"idle_timeout"`
+	// MaxRecordingBytes ends sessions whose recording grows larger, so no
+	// session escapes the audit trail
+	MaxRecordingBytes int64 `json:"max_recording_bytes" yaml:"max_recording_bytes"`
+}
+
+// DefaultConfig returns the default shell session limits
+func DefaultConfig() *Config {
+	return &Config{
+		MaxDuration:       30 * time.Minute,
+		IdleTimeout:       10 * time.Minute,
+		MaxRecordingBytes: 16 << 20,
+	}
+}
+
+// Broker opens shell sessions on agents and tracks their records
+type Broker struct {
+	db          *gorm.DB
+	executor    *workflow.Executor
+	auditLogger *audit.Logger
+	config      *Config
+	logger      *zap.Logger
+
+	mu sync.Mutex
+	// serving holds the sessions this replica relays
+	serving map[string]*Session
+	closing bool
+}
+
+// NewBroker creates a shell session broker
+func NewBroker(db *gorm.DB, executor *workflow.Executor, config *Config, logger *zap.Logger) *Broker {
+	defaults := DefaultConfig()
+	cfg := *config
+	if cfg.MaxDuration <= 0 {
+		cfg.MaxDuration = defaults.MaxDuration
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = defaults.IdleTimeout
+	}
+	if cfg.MaxRecordingBytes <= 0 {
+		cfg.MaxRecordingBytes = defaults.MaxRecordingBytes
+	}
+	return &Broker{
+		db:       db,
+		executor: executor,
+		config:   &cfg,
+		logger:   logger,
+		serving:  make(map[string]*Session),
+	}
+}
+
+// SetAuditLogger sets the logger that receives session start and end events
+func (b *Broker) SetAuditLogger(auditLogger *audit.Logger) {
+	b.auditLogger = auditLogger
+}
+
+// OpenRequest represents a request to open a shell session
+type OpenRequest struct {
+	TenantID   string
+	AgentID    string
+	UserID     string
+	RemoteAddr string
+	Rows       uint16
+	Cols       uint16
+}
+
+// Open starts a shell session on an agent. The tenant must have shell
+// sessions enabled and the agent must be online.
+func (b *Broker) Open(ctx context.Context, req *OpenRequest) (*Session, error) {
+	b.mu.Lock()
+	closing := b.closing
+	b.mu.Unlock()
+	if closing {
+		return nil, apperror.InvalidState("control plane is shutting down")
+	}
+
+	var tenant models.Tenant
+	if err := b.db.WithContext(ctx).Select("id", "shell_enabled").
+		Where("id = ?", req.TenantID).
+		First(&tenant).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("tenant not found")
+		}
+		return nil, fmt.Errorf("failed to get tenant: %w", err)
+	}
+	if !tenant.ShellEnabled {
+		return nil, apperror.Forbidden("live shell sessions are not enabled for this tenant")
+	}
+
+	var agent models.Agent
+	if err := b.db.WithContext(ctx).Select("id", "tenant_id", "status", "approval_status").
+		Where("id = ? AND tenant_id = ?", req.AgentID, req.TenantID).
+		First(&agent).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("agent not found")
+		}
+		return nil, fmt.Errorf("failed to get agent: %w", err)
+	}
+	if agent.Status != models.AgentStatusOnline && agent.Status != models.AgentStatusDegraded {
+		return nil, apperror.InvalidState("agent is %s", agent.Status)
+	}
+	if agent.ApprovalStatus != models.AgentApprovalApproved {
+		return nil, apperror.InvalidState("agent registration is %s", agent.ApprovalStatus)
+	}
+
+	record := &models.ShellSession{
+		ID:         uuid.New().String(),
+		TenantID:   req.TenantID,
+		AgentID:    req.AgentID,
+		UserID:     req.UserID,
+		Status:     models.ShellSessionStatusActive,
+		RemoteAddr: req.RemoteAddr,
+		StartedAt:  time.Now(),
+	}
+	if err := b.db.WithContext(ctx).Create(record).Error; err != nil {
+		return nil, fmt.Errorf("failed to create shell session: %w", err)
+	}
+
+	err := b.executor.ShellRequest(ctx, req.TenantID, req.AgentID, record.ID, "open", map[string]uint16{
+		"rows": req.Rows,
+		"cols": req.Cols,
+	}, nil)
+	// A conflict means an earlier attempt opened the session and its
+	// response was lost; session IDs are never reused
+	if err != nil && !errors.Is(err, apperror.ErrConflict) {
+		now := time.Now()
+		record.Status = models.ShellSessionStatusEnded
+		record.EndReason = "open_failed"
+		record.EndedAt = &now
+		if updateErr := b.db.WithContext(ctx).Model(record).
+			Select("status", "end_reason", "ended_at").
+			Updates(record).Error; updateErr != nil {
+			b.logger.Warn("failed to end shell session",
+				zap.String("session_id", record.ID),
+				zap.Error(updateErr))
+		}
+		b.audit(ctx, record, audit.ActionStart, audit.OutcomeFailure, "shell session refused", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return nil, err
+	}
+
+	b.logger.Info("shell session opened",
+		zap.String("session_id", record.ID),
+		zap.String("tenant_id", record.TenantID),
+		zap.String("agent_id", record.AgentID),
+		zap.String("user_id", record.UserID))
+	b.audit(ctx, record, audit.ActionStart, audit.OutcomeSuccess, "shell session opened", map[string]interface{}{
+		"remote_addr": record.RemoteAddr,
+	})
+
+	return newSession(b, record), nil
+}
+
+// GetSession retrieves a shell session by ID
+func (b *Broker) GetSession(ctx context.Context, tenantID, sessionID string) (*models.ShellSession, error) {
+	var record models.ShellSession
+	if err := b.db.WithContext(ctx).Where("id = ? AND tenant_id = ?", sessionID, tenantID).First(&record).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("shell session not found")
+		}
+		return nil, fmt.Errorf("failed to get shell session: %w", err)
+	}
+	return &record, nil
+}
+
+// ListSessions lists a tenant's shell sessions, most recent first,
+// optionally only those on one agent
+func (b *Broker) ListSessions(ctx context.Context, tenantID, agentID string, limit int) ([]models.ShellSession, error) {
+	query := b.db.WithContext(ctx).Where("tenant_id = ?", tenantID)
+	if agentID != "" {
+		query = query.Where("agent_id = ?", agentID)
+	}
+
+	var records []models.ShellSession
+	if err := query.Order("started_at DESC").Limit(limit).Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to list shell sessions: %w", err)
+	}
+	return records, nil
+}
+
+// Recording is the full record of a shell session
+type Recording struct {
+	Session *models.ShellSession `json:"session"`
+	Events  []models.ShellEvent  `json:"events"`
+}
+
+// GetRecording returns a session's recorded input and output in order
+func (b *Broker) GetRecording(ctx context.Context, tenantID, sessionID string) (*Recording, error) {
+	record, err := b.GetSession(ctx, tenantID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks []models.ShellRecordingChunk
+	if err := b.db.WithContext(ctx).Where("session_id = ? AND tenant_id = ?", sessionID, tenantID).
+		Order("seq ASC").
+		Find(&chunks).Error; err != nil {
+		return nil, fmt.Errorf("failed to get shell session recording: %w", err)
+	}
+
+	recording := &Recording{Session: record, Events: []models.ShellEvent{}}
+	for _, chunk := range chunks {
+		recording.Events = append(recording.Events, chunk.Events...)
+	}
+	return recording, nil
+}
+
+// Shutdown ends the sessions this replica relays and waits for their
+// recordings to be written. New sessions are refused.
+func (b *Broker) Shutdown(ctx context.Context) error {
+	b.mu.Lock()
+	b.closing = true
+	sessions := make([]*Session, 0, len(b.serving))
+	for _, s := range b.serving {
+		sessions = append(sessions, s)
+	}
+	b.mu.Unlock()
+
+	for _, s := range sessions {
+		s.interrupt(ReasonShutdown)
+	}
+	for _, s := range sessions {
+		select {
+		case <-s.Done():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// track registers a session while it is served
+func (b *Broker) track(s *Session) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.serving[s.ID()] = s
+}
+
+// untrack forgets a session once it ended
+func (b *Broker) untrack(s *Session) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.serving, s.ID())
+}
+
+// Run ends the records of sessions that outlived the maximum duration,
+// left active by a control plane replica that stopped while serving them
+func (b *Broker) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.endAbandoned(ctx)
+		}
+	}
+}
+
+// endAbandoned ends active sessions started before the maximum duration
+// plus a grace period
+func (b *Broker) endAbandoned(ctx context.Context) {
+	cutoff := time.Now().Add(-b.config.MaxDuration - 5*time.Minute)
+	result := b.db.WithContext(ctx).Model(&models.ShellSession{}).
+		Where("status = ? AND started_at < ?", models.ShellSessionStatusActive, cutoff).
+		Updates(map[string]interface{}{
+			"status":     models.ShellSessionStatusEnded,
+			"end_reason": ReasonAbandoned,
+			"ended_at":   time.Now(),
+		})
+	if result.Error != nil {
+		b.logger.Warn("failed to end abandoned shell sessions", zap.Error(result.Error))
+		return
+	}
+	if result.RowsAffected > 0 {
+		b.logger.Info("ended abandoned shell sessions", zap.Int64("count", result.RowsAffected))
+	}
+}
+
+// audit records a shell session event if an audit logger is configured
+func (b *Broker) audit(ctx context.Context, record *models.ShellSession, action audit.EventAction, outcome audit.EventOutcome, description string, metadata map[string]interface{}) {
+	if b.auditLogger == nil {
+		return
+	}
+
+	metadata["agent_id"] = record.AgentID
+	if err := b.auditLogger.NewEventBuilder().
+		WithTenant(record.TenantID).
+		WithType(audit.EventTypeAgent).
+		WithAction(action).
+		WithOutcome(outcome).
+		WithActor(record.UserID, "user").
+		WithResource(record.ID, "shell_session").
+		WithDescription(description).
+		WithMetadata(metadata).
+		Log(ctx); err != nil {
+		b.logger.Warn("failed to audit shell session",
+			zap.String("session_id", record.ID),
+			zap.Error(err))
+	}
+}
@@ -0,0 +1,200 @@
+package shell
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+)
+
+const (
+	// maxInputMessage bounds one message of operator input
+	maxInputMessage = 64 << 10
+	// writeTimeout bounds writing one message to the operator
+	writeTimeout = 10 * time.Second
+)
+
+// ControlMessage is a WebSocket text message. Operators send resize and
+// close; the control plane sends session when the shell is ready and exit
+// when the session ends. Input and output travel as binary messages.
+type ControlMessage struct {
+	Type string `json:"type"`
+	Rows uint16 `json:"rows,omitempty"`
+	Cols uint16 `json:"cols,omitempty"`
+
+	SessionID   string `json:"session_id,omitempty"`
+	MaxDuration string `json:"max_duration,omitempty"`
+	IdleTimeout string `json:"idle_timeout,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+	ExitCode    *int   `json:"exit_code,omitempty"`
+}
+
+// sessionEnd is why a served session ends
+type sessionEnd struct {
+	reason   string
+	exitCode *int
+}
+
+// Serve relays a session between an operator's WebSocket and the agent
+// until the shell exits, the operator disconnects or a limit is reached,
+// then ends the session and closes the connection.
+func (s *Session) Serve(ctx context.Context, conn *websocket.Conn) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.broker.track(s)
+	defer s.broker.untrack(s)
+
+	var writeMu sync.Mutex
+	write := func(messageType int, data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		return conn.WriteMessage(messageType, data)
+	}
+	writeControl := func(msg *ControlMessage) error {
+		data, _ := json.Marshal(msg)
+		return write(websocket.TextMessage, data)
+	}
+
+	ended := make(chan sessionEnd, 1)
+	stop := func(reason string, exitCode *int) {
+		select {
+		case ended <- sessionEnd{reason: reason, exitCode: exitCode}:
+		default:
+		}
+	}
+
+	writeControl(&ControlMessage{
+		Type:        "session",
+		SessionID:   s.ID(),
+		MaxDuration: s.broker.config.MaxDuration.String(),
+		IdleTimeout: s.broker.config.IdleTimeout.String(),
+	})
+
+	// Operator input and control messages
+	go func() {
+		conn.SetReadLimit(maxInputMessage)
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				stop(ReasonClosed, nil)
+				return
+			}
+			switch messageType {
+			case websocket.BinaryMessage:
+				err = s.Input(ctx, data)
+			case websocket.TextMessage:
+				var msg ControlMessage
+				if json.Unmarshal(data, &msg) != nil {
+					continue
+				}
+				switch msg.Type {
+				case "resize":
+					err = s.Resize(ctx, msg.Rows, msg.Cols)
+					if errors.Is(err, apperror.ErrInvalidInput) {
+						err = nil
+					}
+				case "close":
+					stop(ReasonClosed, nil)
+					return
+				}
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					stop(s.endReason(err), nil)
+				}
+				return
+			}
+		}
+	}()
+
+	// Shell output
+	go func() {
+		for {
+			out, err := s.read(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					stop(s.endReason(err), nil)
+				}
+				return
+			}
+			if len(out.Data) > 0 {
+				if err := write(websocket.BinaryMessage, out.Data); err != nil {
+					stop(ReasonClosed, nil)
+					return
+				}
+			}
+			if out.Exited {
+				reason := out.Reason
+				if reason == "" {
+					reason = ReasonExited
+				}
+				exitCode := out.ExitCode
+				stop(reason, &exitCode)
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var end sessionEnd
+wait:
+	for {
+		select {
+		case end = <-ended:
+			break wait
+		case reason := <-s.interrupts:
+			end = sessionEnd{reason: reason}
+			break wait
+		case <-ctx.Done():
+			end = sessionEnd{reason: ReasonClosed}
+			break wait
+		case <-ticker.C:
+			if reason := s.expired(); reason != "" {
+				stop(reason, nil)
+			}
+			if err := s.flush(ctx, false); err != nil {
+				s.broker.logger.Error("failed to write shell session recording",
+					zap.String("session_id", s.ID()),
+					zap.Error(err))
+				stop(ReasonRecordingFailed, nil)
+			}
+		}
+	}
+	cancel()
+
+	endCtx, endCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer endCancel()
+	s.End(endCtx, end.reason, end.exitCode)
+
+	writeControl(&ControlMessage{Type: "exit", Reason: end.reason, ExitCode: end.exitCode})
+	writeMu.Lock()
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, end.reason),
+		time.Now().Add(writeTimeout))
+	writeMu.Unlock()
+	conn.Close()
+}
+
+// endReason maps a failed session request to the reason the session ends
+func (s *Session) endReason(err error) string {
+	switch {
+	case errors.Is(err, errRecordingLimit):
+		return ReasonRecordingLimit
+	case errors.Is(err, apperror.ErrNotFound):
+		return ReasonSessionLost
+	}
+	s.broker.logger.Warn("shell session request failed",
+		zap.String("session_id", s.ID()),
+		zap.String("agent_id", s.record.AgentID),
+		zap.Error(err))
+	return ReasonAgentUnreachable
+}
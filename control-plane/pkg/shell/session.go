@@ -0,0 +1,284 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+const (
+	// readWait is how long each output read waits on the agent
+	readWait = 5 * time.Second
+	// chunkBytes and chunkInterval bound the recording held in memory
+	// before it is written
+	chunkBytes    = 64 << 10
+	chunkInterval = 5 * time.Second
+)
+
+// output is a read from the agent's session
+type output struct {
+	Data     []byte `json:"data"`
+	Exited   bool   `json:"exited"`
+	ExitCode int    `json:"exit_code"`
+	Reason   string `json:"reason"`
+}
+
+// Session is an open shell session. Input and resizes may be sent while
+// another goroutine reads output.
+type Session struct {
+	broker *Broker
+	record *models.ShellSession
+
+	// inputMu orders input so sequence numbers follow the operator's
+	// keystrokes
+	inputMu  sync.Mutex
+	inputSeq uint64
+	// offset is the output received so far, acknowledged on each read
+	offset uint64
+
+	mu        sync.Mutex
+	lastInput time.Time
+	events    []models.ShellEvent
+	pending   int64
+	lastFlush time.Time
+	chunkSeq  int
+	ended     bool
+	done      chan struct{}
+	// interrupts carries requests to end a served session early
+	interrupts chan string
+}
+
+// newSession wraps a newly opened session's record
+func newSession(broker *Broker, record *models.ShellSession) *Session {
+	return &Session{
+		broker:     broker,
+		record:     record,
+		lastInput:  record.StartedAt,
+		lastFlush:  record.StartedAt,
+		done:       make(chan struct{}),
+		interrupts: make(chan string, 1),
+	}
+}
+
+// ID returns the session's ID
+func (s *Session) ID() string {
+	return s.record.ID
+}
+
+// Done is closed when the session ends
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// interrupt asks the goroutine serving the session to end it
+func (s *Session) interrupt(reason string) {
+	select {
+	case s.interrupts <- reason:
+	default:
+	}
+}
+
+// Input records the operator's input and writes it to the shell
+func (s *Session) Input(ctx context.Context, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	s.inputMu.Lock()
+	defer s.inputMu.Unlock()
+
+	if err := s.addEvent(models.ShellEvent{Kind: "i", Data: data}); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.lastInput = time.Now()
+	s.mu.Unlock()
+
+	s.inputSeq++
+	return s.broker.executor.ShellRequest(ctx, s.record.TenantID, s.record.AgentID, s.record.ID, "input", map[string]interface{}{
+		"seq":  s.inputSeq,
+		"data": data,
+	}, nil)
+}
+
+// Resize sets the shell's terminal size
+func (s *Session) Resize(ctx context.Context, rows, cols uint16) error {
+	if rows == 0 || cols == 0 {
+		return apperror.InvalidInput("rows and cols must be positive")
+	}
+	if err := s.addEvent(models.ShellEvent{Kind: "r", Data: []byte(fmt.Sprintf("%dx%d", cols, rows))}); err != nil {
+		return err
+	}
+	return s.broker.executor.ShellRequest(ctx, s.record.TenantID, s.record.AgentID, s.record.ID, "resize", map[string]uint16{
+		"rows": rows,
+		"cols": cols,
+	}, nil)
+}
+
+// read waits for the shell's next output and records it. The shell has
+// exited once the returned output says so.
+func (s *Session) read(ctx context.Context) (*output, error) {
+	var out output
+	if err := s.broker.executor.ShellRequest(ctx, s.record.TenantID, s.record.AgentID, s.record.ID, "read", map[string]interface{}{
+		"offset":  s.offset,
+		"wait_ms": readWait.Milliseconds(),
+	}, &out); err != nil {
+		return nil, err
+	}
+	s.offset += uint64(len(out.Data))
+	if len(out.Data) > 0 {
+		if err := s.addEvent(models.ShellEvent{Kind: "o", Data: out.Data}); err != nil {
+			return nil, err
+		}
+	}
+	return &out, nil
+}
+
+// addEvent adds an event to the recording. Once the recording reaches its
+// limit nothing more is let through, and the session must end.
+func (s *Session) addEvent(event models.ShellEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	size := int64(len(event.Data))
+	if s.record.RecordingBytes+size > s.broker.config.MaxRecordingBytes {
+		return errRecordingLimit
+	}
+	event.OffsetMS = time.Since(s.record.StartedAt).Milliseconds()
+	s.events = append(s.events, event)
+	s.pending += size
+	s.record.RecordingBytes += size
+	switch event.Kind {
+	case "i":
+		s.record.BytesIn += size
+	case "o":
+		s.record.BytesOut += size
+	}
+	return nil
+}
+
+// errRecordingLimit is returned once a session's recording is full
+var errRecordingLimit = errors.New("shell session recording limit reached")
+
+// flush writes the recorded events when enough accumulated, or all of them
+// when force is set
+func (s *Session) flush(ctx context.Context, force bool) error {
+	s.mu.Lock()
+	if len(s.events) == 0 || (!force && s.pending < chunkBytes && time.Since(s.lastFlush) < chunkInterval) {
+		s.mu.Unlock()
+		return nil
+	}
+	chunk := &models.ShellRecordingChunk{
+		ID:        uuid.New().String(),
+		TenantID:  s.record.TenantID,
+		SessionID: s.record.ID,
+		Seq:       s.chunkSeq,
+		Events:    s.events,
+		Bytes:     s.pending,
+		CreatedAt: time.Now(),
+	}
+	s.chunkSeq++
+	s.events = nil
+	s.pending = 0
+	s.lastFlush = time.Now()
+	counters := map[string]interface{}{
+		"bytes_in":        s.record.BytesIn,
+		"bytes_out":       s.record.BytesOut,
+		"recording_bytes": s.record.RecordingBytes,
+	}
+	s.mu.Unlock()
+
+	if err := s.broker.db.WithContext(ctx).Create(chunk).Error; err != nil {
+		return fmt.Errorf("failed to write shell session recording: %w", err)
+	}
+	if err := s.broker.db.WithContext(ctx).Model(&models.ShellSession{}).
+		Where("id = ?", s.record.ID).
+		Updates(counters).Error; err != nil {
+		return fmt.Errorf("failed to update shell session: %w", err)
+	}
+	return nil
+}
+
+// expired returns why the session must end because of its time limits, or
+// "" while it may continue
+func (s *Session) expired() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case time.Since(s.record.StartedAt) > s.broker.config.MaxDuration:
+		return ReasonMaxDuration
+	case time.Since(s.lastInput) > s.broker.config.IdleTimeout:
+		return ReasonIdleTimeout
+	}
+	return ""
+}
+
+// End closes the session on the agent, unless it already ended there,
+// writes the remaining recording and closes the record. Only the first call
+// has an effect.
+func (s *Session) End(ctx context.Context, reason string, exitCode *int) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.mu.Unlock()
+	defer close(s.done)
+
+	record := s.record
+	if reason != ReasonExited && reason != ReasonAgentUnreachable {
+		if err := s.broker.executor.ShellRequest(ctx, record.TenantID, record.AgentID, record.ID, "close", struct{}{}, nil); err != nil && !errors.Is(err, apperror.ErrNotFound) {
+			s.broker.logger.Warn("failed to close shell session on agent",
+				zap.String("session_id", record.ID),
+				zap.String("agent_id", record.AgentID),
+				zap.Error(err))
+		}
+	}
+
+	if err := s.flush(ctx, true); err != nil {
+		s.broker.logger.Error("failed to write shell session recording",
+			zap.String("session_id", record.ID),
+			zap.Error(err))
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	record.Status = models.ShellSessionStatusEnded
+	record.EndReason = reason
+	record.ExitCode = exitCode
+	record.EndedAt = &now
+	s.mu.Unlock()
+	if err := s.broker.db.WithContext(ctx).Model(record).
+		Select("status", "end_reason", "exit_code", "ended_at", "bytes_in", "bytes_out", "recording_bytes").
+		Updates(record).Error; err != nil {
+		s.broker.logger.Error("failed to end shell session",
+			zap.String("session_id", record.ID),
+			zap.Error(err))
+	}
+
+	s.broker.logger.Info("shell session ended",
+		zap.String("session_id", record.ID),
+		zap.String("agent_id", record.AgentID),
+		zap.String("reason", reason),
+		zap.Duration("duration", now.Sub(record.StartedAt)))
+	metadata := map[string]interface{}{
+		"reason":          reason,
+		"duration_ms":     now.Sub(record.StartedAt).Milliseconds(),
+		"bytes_in":        record.BytesIn,
+		"bytes_out":       record.BytesOut,
+		"recording_bytes": record.RecordingBytes,
+	}
+	if exitCode != nil {
+		metadata["exit_code"] = *exitCode
+	}
+	s.broker.audit(ctx, record, audit.ActionStop, audit.OutcomeSuccess, "shell session ended", metadata)
+}
@@ -0,0 +1,159 @@
+// Package shutdown runs the control plane's shutdown steps in dependency
+// order within a bounded drain time.
+package shutdown
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultTimeout is the default maximum time spent draining on shutdown
+const DefaultTimeout = 30 * time.Second
+
+// phase is one named shutdown step
+type phase struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// Manager runs shutdown phases in the order they were added. Phases share
+// one drain deadline; once it passes, the remaining phases still run with an
+// expired context so they release resources without waiting.
+type Manager struct {
+	timeout time.Duration
+	logger  *zap.Logger
+	phases  []phase
+	once    sync.Once
+	done    chan struct{}
+}
+
+// NewManager creates a shutdown manager with the given maximum drain time
+func NewManager(timeout time.Duration, logger *zap.Logger) *Manager {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Manager{
+		timeout: timeout,
+		logger:  logger,
+		done:    make(chan struct{}),
+	}
+}
+
+// Add appends a phase. Phases should return promptly once ctx is done.
+func (m *Manager) Add(name string, fn func(ctx context.Context) error) {
+	m.phases = append(m.phases, phase{name: name, fn: fn})
+}
+
+// Shutdown runs every phase once; later calls wait for the first to finish
+func (m *Manager) Shutdown() {
+	m.once.Do(func() {
+		defer close(m.done)
+		m.run()
+	})
+	<-m.done
+}
+
+// Done is closed once shutdown has completed
+func (m *Manager) Done() <-chan struct{} {
+	return m.done
+}
+
+func (m *Manager) run() {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	start := time.Now()
+	m.logger.Info("shutdown started",
+		zap.Int("phases", len(m.phases)),
+		zap.Duration("max_drain", m.timeout))
+
+	failed := 0
+	for i, p := range m.phases {
+		phaseStart := time.Now()
+		m.logger.Info("shutdown phase started",
+			zap.String("phase", p.name),
+			zap.String("step", fmt.Sprintf("%d/%d", i+1, len(m.phases))))
+
+		if err := m.runPhase(ctx, p); err != nil {
+			failed++
+			m.logger.Error("shutdown phase failed",
+				zap.String("phase", p.name),
+				zap.Duration("elapsed", time.Since(phaseStart)),
+				zap.Error(err))
+			continue
+		}
+
+		m.logger.Info("shutdown phase completed",
+			zap.String("phase", p.name),
+			zap.Duration("elapsed", time.Since(phaseStart)))
+	}
+
+	fields := []zap.Field{
+		zap.Duration("elapsed", time.Since(start)),
+		zap.Int("failed_phases", failed),
+	}
+	if ctx.Err() != nil {
+		m.logger.Warn("shutdown exceeded max drain time", fields...)
+		return
+	}
+	m.logger.Info("shutdown completed", fields...)
+}
+
+// runPhase runs a phase, recovering a panic so later phases still run
+func (m *Manager) runPhase(ctx context.Context, p phase) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return p.fn(ctx)
+}
+
+// Workers tracks background loops that stop when their context is cancelled
+type Workers struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewWorkers creates a worker group whose context is derived from parent
+func NewWorkers(parent context.Context) *Workers {
+	ctx, cancel := context.WithCancel(parent)
+	return &Workers{ctx: ctx, cancel: cancel}
+}
+
+// Go runs fn in a goroutine with the group's context
+func (w *Workers) Go(fn func(ctx context.Context)) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		fn(w.ctx)
+	}()
+}
+
+// Stop cancels the workers' context and waits for them to return, or for
+// ctx to be done
+func (w *Workers) Stop(ctx context.Context) error {
+	w.cancel()
+	return Wait(ctx, &w.wg)
+}
+
+// Wait waits for wg, giving up when ctx is done
+func Wait(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("gave up waiting: %w", ctx.Err())
+	}
+}
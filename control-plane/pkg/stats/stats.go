@@ -0,0 +1,22 @@
+// Package stats holds summary statistics shared by the reporting packages.
+package stats
+
+import "math"
+
+// Number is a value Percentile can summarize
+type Number interface {
+	~int | ~int64 | ~float64
+}
+
+// Percentile returns the nearest-rank p-th percentile of sorted values,
+// which must not be empty
+func Percentile[T Number](sorted []T, p float64) T {
+	rank := int(math.Ceil(p * float64(len(sorted)) / 100))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
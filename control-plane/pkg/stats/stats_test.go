@@ -0,0 +1,37 @@
+package stats
+
+import "testing"
+
+func TestPercentile(t *testing.T) {
+	hundred := make([]int64, 100)
+	for i := range hundred {
+		hundred[i] = int64(i + 1)
+	}
+
+	tests := []struct {
+		name   string
+		sorted []int64
+		p      float64
+		want   int64
+	}{
+		{"single value", []int64{7}, 95, 7},
+		{"median of odd count", []int64{1, 2, 3, 4, 5}, 50, 3},
+		{"median of even count", []int64{1, 2, 3, 4}, 50, 2},
+		{"rank rounds up", []int64{1, 2, 3, 4}, 60, 3},
+		{"exact rank", hundred, 95, 95},
+		{"zeroth is the minimum", hundred, 0, 1},
+		{"hundredth is the maximum", hundred, 100, 100},
+		{"above a hundred is the maximum", hundred, 150, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Percentile(tt.sorted, tt.p); got != tt.want {
+				t.Errorf("Percentile(%v) = %d, want %d", tt.p, got, tt.want)
+			}
+		})
+	}
+
+	if got := Percentile([]float64{0.5, 1.5, 2.5}, 95); got != 2.5 {
+		t.Errorf("Percentile of floats = %v, want 2.5", got)
+	}
+}
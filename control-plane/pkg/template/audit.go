@@ -0,0 +1,203 @@
+package template
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/diff"
+)
+
+// maxAuditDiffBytes caps the diff recorded with a content change
+const maxAuditDiffBytes = 16 * 1024
+
+// maxHistoryChanges bounds the changes returned by PathHistory
+const maxHistoryChanges = 500
+
+// ContentChange is a change to a template's content as recorded in the
+// audit log. Diff is redacted of secrets and capped in size; Path is the
+// Git file defining synced templates. Uploaded content is recorded by its
+// hash and size instead of a diff.
+type ContentChange struct {
+	TemplateID    string    `json:"template_id"`
+	TemplateName  string    `json:"template_name"`
+	Path          string    `json:"path,omitempty"`
+	Commit        string    `json:"commit,omitempty"`
+	FromVersion   int       `json:"from_version"`
+	ToVersion     int       `json:"to_version"`
+	ChangeNote    string    `json:"change_note,omitempty"`
+	Additions     int       `json:"additions"`
+	Deletions     int       `json:"deletions"`
+	Diff          string    `json:"diff"`
+	DiffTruncated bool      `json:"diff_truncated,omitempty"`
+	ContentHash   string    `json:"content_hash,omitempty"`
+	ContentSize   int64     `json:"content_size,omitempty"`
+	ChangedBy     string    `json:"changed_by,omitempty"`
+	ChangedAt     time.Time `json:"changed_at"`
+}
+
+// SetAuditLogger sets the logger that records content changes with their
+// redacted diffs
+func (m *Manager) SetAuditLogger(auditLogger *audit.Logger) {
+	m.auditLogger = auditLogger
+}
+
+// auditContentChange records a change of template content from oldContent,
+// at fromVersion (0 for a new template), to the template's current content
+func (m *Manager) auditContentChange(ctx context.Context, template *models.Template, fromVersion int, oldContent, changedBy, changeNote string) {
+	if m.auditLogger == nil {
+		return
+	}
+
+	result := &diff.Result{}
+	description := fmt.Sprintf("template %s content uploaded as version %d (%d bytes)",
+		template.Name, template.Version, template.ContentSize)
+	if !template.Uploaded {
+		result = diff.Compute(
+			fmt.Sprintf("%s (version %d)", template.Name, fromVersion),
+			fmt.Sprintf("%s (version %d)", template.Name, template.Version),
+			oldContent, template.Content, diff.DefaultContext)
+		description = fmt.Sprintf("template %s content changed to version %d (+%d -%d)",
+			template.Name, template.Version, result.Additions, result.Deletions)
+	}
+	change := &ContentChange{
+		TemplateID:   template.ID,
+		TemplateName: template.Name,
+		Path:         template.SourcePath,
+		Commit:       template.SourceCommit,
+		FromVersion:  fromVersion,
+		ToVersion:    template.Version,
+		ChangeNote:   changeNote,
+		Additions:    result.Additions,
+		Deletions:    result.Deletions,
+		ContentHash:  template.ContentHash,
+		ContentSize:  template.ContentSize,
+	}
+	if !template.Uploaded {
+		change.Diff = audit.RedactSecrets(result.String())
+	}
+	if len(change.Diff) > maxAuditDiffBytes {
+		change.Diff = truncateUTF8(change.Diff, maxAuditDiffBytes)
+		change.DiffTruncated = true
+	}
+
+	metadata, err := changeMetadata(change)
+	if err != nil {
+		m.logger.Warn("failed to audit template change", zap.Error(err))
+		return
+	}
+
+	action := audit.ActionUpdate
+	if fromVersion == 0 {
+		action = audit.ActionCreate
+	}
+	actorType := "user"
+	if changedBy == "" {
+		changedBy, actorType = "template-manager", "system"
+	}
+	if err := m.auditLogger.NewEventBuilder().
+		WithTenant(template.TenantID).
+		WithType(audit.EventTypeConfig).
+		WithAction(action).
+		WithOutcome(audit.OutcomeSuccess).
+		WithActor(changedBy, actorType).
+		WithResource(template.ID, "template").
+		WithDescription(description).
+		WithMetadata(metadata).
+		Log(ctx); err != nil {
+		m.logger.Warn("failed to audit template change",
+			zap.String("template_id", template.ID),
+			zap.Error(err))
+	}
+}
+
+// PathHistory reconstructs the content changes of the templates defined by
+// a Git file path from the audit log, oldest first. The file's templates
+// are followed across versions, and across deletion and re-creation.
+func (m *Manager) PathHistory(ctx context.Context, tenantID, path string, limit int) ([]ContentChange, error) {
+	if m.auditLogger == nil {
+		return nil, apperror.InvalidState("audit logging is not enabled")
+	}
+	if path == "" {
+		return nil, apperror.InvalidInput("path is required")
+	}
+	if limit <= 0 || limit > maxHistoryChanges {
+		limit = maxHistoryChanges
+	}
+
+	path, err := quote(path)
+	if err != nil {
+		return nil, err
+	}
+	result, err := m.auditLogger.Search(ctx, &audit.SearchQuery{
+		TenantID:   tenantID,
+		EventTypes: []audit.EventType{audit.EventTypeConfig},
+		Query:      "resource_type:template AND metadata.path:" + path,
+		MaxHits:    limit,
+		SortBy:     []audit.SortField{{Field: "timestamp", Order: "asc"}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search template changes: %w", err)
+	}
+
+	changes := make([]ContentChange, 0, len(result.Hits))
+	for _, event := range result.Hits {
+		var change ContentChange
+		data, err := json.Marshal(event.Metadata)
+		if err == nil {
+			err = json.Unmarshal(data, &change)
+		}
+		if err != nil {
+			m.logger.Warn("skipping unreadable template change",
+				zap.String("event_id", event.ID),
+				zap.Error(err))
+			continue
+		}
+		change.ChangedBy = event.ActorID
+		change.ChangedAt = event.Timestamp
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// changeMetadata encodes a change as audit event metadata
+func changeMetadata(change *ContentChange) (map[string]interface{}, error) {
+	data, err := json.Marshal(change)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode template change: %w", err)
+	}
+	var metadata map[string]interface{}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to encode template change: %w", err)
+	}
+	// The actor and timestamp are the event's own
+	delete(metadata, "changed_by")
+	delete(metadata, "changed_at")
+	return metadata, nil
+}
+
+// quote returns value as a quoted query term
+func quote(value string) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", apperror.InvalidInput("invalid path")
+	}
+	return string(data), nil
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,13 @@
+package template
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// ContentHash returns the hash agents cache template content under and the
+// content endpoint's ETag, "sha256:" followed by the hex digest
+func ContentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,496 @@
+// Package template provides template management for the control plane.
+package template
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Template content types with built-in or command-based lint support
+const (
+	ContentTypeJSON    = "application/json"
+	ContentTypeYAML    = "application/x-yaml"
+	ContentTypeXML     = "application/xml"
+	ContentTypeSystemd = "text/x-systemd-unit"
+	ContentTypeNginx   = "text/x-nginx-conf"
+	ContentTypeApache  = "text/x-apache-conf"
+)
+
+// contentTypeAliases maps alternative content types to their canonical form
+var contentTypeAliases = map[string]string{
+	"text/json":          ContentTypeJSON,
+	"application/yaml":   ContentTypeYAML,
+	"text/yaml":          ContentTypeYAML,
+	"text/x-yaml":        ContentTypeYAML,
+	"text/xml":           ContentTypeXML,
+	"text/x-systemd":     ContentTypeSystemd,
+	"text/x-nginx":       ContentTypeNginx,
+	"text/x-apache":      ContentTypeApache,
+	"text/x-apache-conf": ContentTypeApache,
+}
+
+// LintStatus represents the overall outcome of linting a template
+type LintStatus string
+
+const (
+	LintStatusPassed  LintStatus = "passed"
+	LintStatusWarning LintStatus = "warning"
+	LintStatusFailed  LintStatus = "failed"
+	LintStatusSkipped LintStatus = "skipped"
+)
+
+// LintSeverity represents the severity of a lint issue
+type LintSeverity string
+
+const (
+	LintSeverityError   LintSeverity = "error"
+	LintSeverityWarning LintSeverity = "warning"
+)
+
+// LintIssue represents a single lint finding
+type LintIssue struct {
+	Severity LintSeverity `json:"severity"`
+	Line     int          `json:"line,omitempty"`
+	Message  string       `json:"message"`
+	Source   string       `json:"source"`
+}
+
+// LintResult contains the result of linting a template
+type LintResult struct {
+	Status      LintStatus  `json:"status"`
+	ContentType string      `json:"content_type"`
+	Issues      []LintIssue `json:"issues"`
+	LintedAt    time.Time   `json:"linted_at"`
+}
+
+// addIssue appends an issue to the result
+func (r *LintResult) addIssue(severity LintSeverity, line int, source, message string) {
+	r.Issues = append(r.Issues, LintIssue{
+		Severity: severity,
+		Line:     line,
+		Message:  message,
+		Source:   source,
+	})
+}
+
+// HasErrors returns true if any issue has error severity
+func (r *LintResult) HasErrors() bool {
+	for _, issue := range r.Issues {
+		if issue.Severity == LintSeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// LinterConfig contains linter configuration
+type LinterConfig struct {
+	// Validators maps a content type to an external validator command.
+	// The placeholder {file} is replaced with the path of the rendered content.
+	// Validators only run inside the Sandbox and are ignored without one.
+	Validators map[string][]string
+	// Sandbox is the command prefix validators run under, e.g. a bwrap or
+	// container invocation that isolates them from the host filesystem and
+	// network. The placeholder {dir} is replaced with the scratch directory
+	// holding the rendered content, which the sandbox must expose at the
+	// same path.
+	Sandbox []string
+	// Timeout bounds the run time of each validator command
+	Timeout time.Duration
+	// SandboxDir is the parent directory for per-run scratch directories
+	SandboxDir string
+}
+
+// DefaultLinterConfig returns the default linter configuration, with only
+// the built-in checks
+func DefaultLinterConfig() *LinterConfig {
+	return &LinterConfig{
+		Validators: map[string][]string{},
+		Timeout:    10 * time.Second,
+	}
+}
+
+// Linter validates rendered template content according to its content type
+type Linter struct {
+	renderer   *Renderer
+	validators map[string][]string
+	sandbox    []string
+	timeout    time.Duration
+	sandboxDir string
+}
+
+// NewLinter creates a new template linter
+func NewLinter(cfg *LinterConfig) *Linter {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	// Tenant content never reaches a validator outside a sandbox
+	validators := make(map[string][]string)
+	if len(cfg.Sandbox) > 0 {
+		for contentType, command := range cfg.Validators {
+			if len(command) > 0 {
+				validators[normalizeContentType(contentType)] = command
+			}
+		}
+	}
+
+	return &Linter{
+		renderer:   NewRenderer(),
+		validators: validators,
+		sandbox:    cfg.Sandbox,
+		timeout:    timeout,
+		sandboxDir: cfg.SandboxDir,
+	}
+}
+
+// normalizeContentType strips parameters and resolves aliases
+func normalizeContentType(contentType string) string {
+	ct := strings.ToLower(strings.TrimSpace(contentType))
+	if idx := strings.Index(ct, ";"); idx >= 0 {
+		ct = strings.TrimSpace(ct[:idx])
+	}
+	if canonical, ok := contentTypeAliases[ct]; ok {
+		return canonical
+	}
+	return ct
+}
+
+// Lint renders the template with the sample variables and validates the
+// output for its content type. Format checks are skipped with a warning
+// when the template references variables not present in vars, since the
+// rendered output would not be representative.
+func (l *Linter) Lint(ctx context.Context, name, content, contentType string, vars map[string]interface{}) *LintResult {
+	ct := normalizeContentType(contentType)
+	result := &LintResult{
+		ContentType: ct,
+		Issues:      make([]LintIssue, 0),
+		LintedAt:    time.Now(),
+	}
+
+	renderCtx := &RenderContext{Vars: vars}
+	rendered, err := l.renderer.Render(content, renderCtx)
+	if err != nil {
+		result.addIssue(LintSeverityError, 0, "template", err.Error())
+		result.Status = LintStatusFailed
+		return result
+	}
+
+	if !l.supports(ct) {
+		result.Status = LintStatusSkipped
+		return result
+	}
+
+	if undefined := l.renderer.UndefinedVariables(content, renderCtx); len(undefined) > 0 {
+		result.addIssue(LintSeverityWarning, 0, "template",
+			fmt.Sprintf("format checks skipped, undefined variables: %s (set metadata.lint_vars to sample values)",
+				strings.Join(undefined, ", ")))
+	} else {
+		l.lintFormat(ctx, result, name, ct, rendered)
+	}
+
+	switch {
+	case result.HasErrors():
+		result.Status = LintStatusFailed
+	case len(result.Issues) > 0:
+		result.Status = LintStatusWarning
+	default:
+		result.Status = LintStatusPassed
+	}
+
+	return result
+}
+
+// supports returns true if there is a built-in or configured check for the content type
+func (l *Linter) supports(contentType string) bool {
+	switch contentType {
+	case ContentTypeJSON, ContentTypeYAML, ContentTypeXML, ContentTypeSystemd:
+		return true
+	}
+	_, ok := l.validators[contentType]
+	return ok
+}
+
+// lintFormat runs the built-in check and any configured validator command
+func (l *Linter) lintFormat(ctx context.Context, result *LintResult, name, contentType, rendered string) {
+	switch contentType {
+	case ContentTypeJSON:
+		lintJSON(result, rendered)
+	case ContentTypeYAML:
+		lintYAML(result, rendered)
+	case ContentTypeXML:
+		lintXML(result, rendered)
+	case ContentTypeSystemd:
+		lintSystemdUnit(result, rendered)
+	}
+
+	if command, ok := l.validators[contentType]; ok {
+		l.runValidator(ctx, result, name, contentType, command, rendered)
+	}
+}
+
+// lintJSON checks JSON well-formedness
+func lintJSON(result *LintResult, content string) {
+	var v interface{}
+	err := json.Unmarshal([]byte(content), &v)
+	if err == nil {
+		return
+	}
+
+	line := 0
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line = lineAtOffset(content, syntaxErr.Offset)
+	}
+	result.addIssue(LintSeverityError, line, "json", err.Error())
+}
+
+// lintYAML checks YAML well-formedness, including multi-document streams
+func lintYAML(result *LintResult, content string) {
+	decoder := yaml.NewDecoder(strings.NewReader(content))
+	for {
+		var node yaml.Node
+		err := decoder.Decode(&node)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			result.addIssue(LintSeverityError, yamlErrorLine(err), "yaml", err.Error())
+			return
+		}
+	}
+}
+
+// yamlErrorLine extracts the line number from a yaml.v3 error message
+func yamlErrorLine(err error) int {
+	var line int
+	if _, scanErr := fmt.Sscanf(err.Error(), "yaml: line %d:", &line); scanErr == nil {
+		return line
+	}
+	return 0
+}
+
+// lintXML checks XML well-formedness
+func lintXML(result *LintResult, content string) {
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			line := 0
+			var syntaxErr *xml.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				line = syntaxErr.Line
+			}
+			result.addIssue(LintSeverityError, line, "xml", err.Error())
+			return
+		}
+	}
+}
+
+// systemdSections lists the section names systemd recognises in unit files
+var systemdSections = map[string]bool{
+	"Unit": true, "Install": true, "Service": true, "Socket": true,
+	"Timer": true, "Mount": true, "Automount": true, "Swap": true,
+	"Path": true, "Slice": true, "Scope": true,
+}
+
+// lintSystemdUnit performs structural verification of a systemd unit file
+func lintSystemdUnit(result *LintResult, content string) {
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	lineNum := 0
+	section := ""
+	seen := make(map[string]bool)
+	continued := false
+
+	for scanner.Scan() {
+		lineNum++
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+
+		if continued {
+			continued = strings.HasSuffix(line, "\\")
+			continue
+		}
+
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				result.addIssue(LintSeverityError, lineNum, "systemd", "malformed section header")
+				continue
+			}
+			section = strings.TrimSuffix(strings.TrimPrefix(line, "["), "]")
+			if !systemdSections[section] && !strings.HasPrefix(section, "X-") {
+				result.addIssue(LintSeverityWarning, lineNum, "systemd", fmt.Sprintf("unknown section [%s]", section))
+			}
+			seen[section] = true
+			continue
+		}
+
+		if section == "" {
+			result.addIssue(LintSeverityError, lineNum, "systemd", "assignment outside of a section")
+			continue
+		}
+
+		key, _, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			result.addIssue(LintSeverityError, lineNum, "systemd", "expected Key=Value assignment")
+			continue
+		}
+		if strings.ContainsAny(strings.TrimSpace(key), " \t") {
+			result.addIssue(LintSeverityError, lineNum, "systemd", fmt.Sprintf("invalid key %q", strings.TrimSpace(key)))
+		}
+
+		continued = strings.HasSuffix(line, "\\")
+	}
+
+	if len(seen) == 0 {
+		result.addIssue(LintSeverityError, 0, "systemd", "unit file has no sections")
+		return
+	}
+	if seen["Service"] && !strings.Contains(content, "ExecStart") {
+		result.addIssue(LintSeverityWarning, 0, "systemd", "[Service] section has no ExecStart")
+	}
+}
+
+// runValidator runs an external validator command against the rendered
+// content under the sandbox, in a scratch directory with a minimal
+// environment
+func (l *Linter) runValidator(ctx context.Context, result *LintResult, name, contentType string, command []string, rendered string) {
+	source := filepath.Base(command[0])
+
+	if _, err := exec.LookPath(l.sandbox[0]); err != nil {
+		result.addIssue(LintSeverityWarning, 0, source,
+			fmt.Sprintf("validator sandbox %q not available on control plane host", l.sandbox[0]))
+		return
+	}
+
+	sandbox, err := os.MkdirTemp(l.sandboxDir, "template-lint-")
+	if err != nil {
+		result.addIssue(LintSeverityWarning, 0, source, fmt.Sprintf("failed to create sandbox: %v", err))
+		return
+	}
+	defer os.RemoveAll(sandbox)
+
+	filePath := filepath.Join(sandbox, lintFileName(name, contentType))
+	if err := os.WriteFile(filePath, []byte(rendered), 0600); err != nil {
+		result.addIssue(LintSeverityWarning, 0, source, fmt.Sprintf("failed to write sandbox file: %v", err))
+		return
+	}
+
+	args := make([]string, 0, len(l.sandbox)+len(command)-1)
+	for _, arg := range l.sandbox[1:] {
+		args = append(args, strings.ReplaceAll(arg, "{dir}", sandbox))
+	}
+	for _, arg := range command {
+		args = append(args, strings.ReplaceAll(arg, "{file}", filePath))
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(runCtx, l.sandbox[0], args...)
+	cmd.Dir = sandbox
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + sandbox, "TMPDIR=" + sandbox}
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err = cmd.Run()
+	if runCtx.Err() == context.DeadlineExceeded {
+		result.addIssue(LintSeverityWarning, 0, source, fmt.Sprintf("validator timed out after %s", l.timeout))
+		return
+	}
+	if err == nil {
+		return
+	}
+
+	message := validatorMessage(output.String(), filePath, lintFileName(name, contentType))
+	if message == "" {
+		message = fmt.Sprintf("validator rejected the template (%v)", err)
+	}
+	const maxMessageLen = 4096
+	if len(message) > maxMessageLen {
+		message = message[:maxMessageLen] + "..."
+	}
+	result.addIssue(LintSeverityError, 0, source, message)
+}
+
+// absolutePath matches an absolute path and the character before it
+var absolutePath = regexp.MustCompile(`(^|[\s"'(=,\[])/[^\s"'),:;\]]*`)
+
+// validatorMessage returns the validator output that concerns the rendered
+// file, named as name, so no host file content or path reaches the tenant.
+// A line naming the rendered file and the lines after it that name no other
+// path are kept; output about other files is dropped, and other paths in
+// kept lines are replaced.
+func validatorMessage(output, filePath, name string) string {
+	var kept []string
+	keep := false
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case strings.Contains(line, filePath):
+			keep = true
+			line = strings.ReplaceAll(line, filePath, name)
+		case absolutePath.MatchString(line):
+			keep = false
+		}
+		if keep {
+			kept = append(kept, absolutePath.ReplaceAllString(line, "${1}<path>"))
+		}
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+// lintFileName returns the sandbox file name, preserving unit suffixes
+// since systemd-analyze infers the unit type from the extension
+func lintFileName(name, contentType string) string {
+	base := filepath.Base(name)
+	if base == "." || base == "/" || base == "" {
+		base = "template"
+	}
+	if contentType == ContentTypeSystemd && filepath.Ext(base) == "" {
+		base += ".service"
+	}
+	return base
+}
+
+// lineAtOffset returns the 1-based line number at a byte offset
+func lineAtOffset(content string, offset int64) int {
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	return strings.Count(content[:offset], "\n") + 1
+}
+
+// lintVars extracts sample lint variables from template metadata
+func lintVars(metadata map[string]interface{}) map[string]interface{} {
+	if metadata == nil {
+		return nil
+	}
+	if vars, ok := metadata["lint_vars"].(map[string]interface{}); ok {
+		return vars
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package template
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValidatorMessage(t *testing.T) {
+	const file = "/tmp/template-lint-1/site.conf"
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name:   "error in the rendered file",
+			output: "nginx: [emerg] unknown directive \"serve\" in " + file + ":3\nnginx: configuration file " + file + " test failed\n",
+			want:   "nginx: [emerg] unknown directive \"serve\" in site.conf:3\nnginx: configuration file site.conf test failed",
+		},
+		{
+			name:   "detail lines after the file are kept",
+			output: "AH00526: Syntax error on line 2 of " + file + ":\nInvalid command 'Serve', perhaps misspelled\n",
+			want:   "AH00526: Syntax error on line 2 of site.conf:\nInvalid command 'Serve', perhaps misspelled",
+		},
+		{
+			name:   "other paths are replaced",
+			output: "nginx: [emerg] open() \"/etc/nginx/mime.types\" failed (2: No such file or directory) in " + file + ":5",
+			want:   "nginx: [emerg] open() \"<path>\" failed (2: No such file or directory) in site.conf:5",
+		},
+		{
+			name:   "output about included files is dropped",
+			output: "AH00526: Syntax error on line 1 of /etc/passwd:\nInvalid command 'root:x:0:0:root:/root:/bin/bash'\n",
+			want:   "",
+		},
+		{
+			name:   "included file after the rendered file",
+			output: "error in " + file + ":1\nsee below\nSyntax error on line 1 of /etc/shadow:\nroot:$6$secret\n",
+			want:   "error in site.conf:1\nsee below",
+		},
+		{
+			name:   "output naming no file",
+			output: "leaked host content\n",
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validatorMessage(tt.output, file, "site.conf"); got != tt.want {
+				t.Errorf("validatorMessage() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinterValidators(t *testing.T) {
+	// The validator fails, naming the rendered file and an included one
+	validator := []string{"sh", "-c", `echo "bad directive in $0:1"; echo "open() /etc/hosts failed"; exit 1`, "{file}"}
+
+	tests := []struct {
+		name       string
+		sandbox    []string
+		wantStatus LintStatus
+		wantIssue  string
+	}{
+		{
+			name:       "validators are ignored without a sandbox",
+			wantStatus: LintStatusSkipped,
+		},
+		{
+			name: "validator runs in the sandbox",
+			// The sandbox checks it is given the scratch directory holding the file
+			sandbox:    []string{"sh", "-c", `[ -d "$0" ] && exec "$@"`, "{dir}"},
+			wantStatus: LintStatusFailed,
+			wantIssue:  "bad directive in site.conf:1",
+		},
+		{
+			name:       "sandbox failing without output",
+			sandbox:    []string{"false"},
+			wantStatus: LintStatusFailed,
+			wantIssue:  "validator rejected the template (exit status 1)",
+		},
+		{
+			name:       "missing sandbox",
+			sandbox:    []string{"no-such-sandbox"},
+			wantStatus: LintStatusWarning,
+			wantIssue:  `validator sandbox "no-such-sandbox" not available on control plane host`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLinter(&LinterConfig{
+				Validators: map[string][]string{ContentTypeNginx: validator},
+				Sandbox:    tt.sandbox,
+				SandboxDir: t.TempDir(),
+			})
+			result := l.Lint(context.Background(), "site.conf", "server {}", ContentTypeNginx, nil)
+			if result.Status != tt.wantStatus {
+				t.Fatalf("status = %s, want %s: %+v", result.Status, tt.wantStatus, result.Issues)
+			}
+			if tt.wantIssue == "" {
+				if len(result.Issues) != 0 {
+					t.Errorf("issues = %+v, want none", result.Issues)
+				}
+				return
+			}
+			if len(result.Issues) != 1 || result.Issues[0].Message != tt.wantIssue {
+				t.Fatalf("issues = %+v, want %q", result.Issues, tt.wantIssue)
+			}
+			if strings.Contains(result.Issues[0].Message, "/etc/hosts") {
+				t.Errorf("issue reveals a host path: %s", result.Issues[0].Message)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package template
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -10,26 +11,43 @@ import (
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/audit"
 	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/diff"
+	"github.com/yourorg/control-plane/pkg/encryption"
 )
 
 // Manager manages templates
 type Manager struct {
-	db     *gorm.DB
-	logger *zap.Logger
+	db       *gorm.DB
+	logger   *zap.Logger
+	renderer *Renderer
+	linter   *Linter
+	uploads  UploadConfig
+
+	auditLogger *audit.Logger
 }
 
 // NewManager creates a new template manager
 func NewManager(db *gorm.DB, logger *zap.Logger) *Manager {
 	return &Manager{
-		db:     db,
-		logger: logger,
+		db:       db,
+		logger:   logger,
+		renderer: NewRenderer(),
+		linter:   NewLinter(DefaultLinterConfig()),
+		uploads:  UploadConfig{MaxSize: DefaultMaxUploadSize},
 	}
 }
 
+// SetLinter replaces the template linter (e.g. with configured validator commands)
+func (m *Manager) SetLinter(linter *Linter) {
+	m.linter = linter
+}
+
 // CreateTemplateRequest represents a request to create a template
 type CreateTemplateRequest struct {
-	TenantID    string                 `json:"tenant_id" binding:"required"`
+	TenantID    string                 `json:"tenant_id"`
 	Name        string                 `json:"name" binding:"required"`
 	Description string                 `json:"description"`
 	Content     string                 `json:"content" binding:"required"`
@@ -43,7 +61,10 @@ type CreateTemplateRequest struct {
 func (m *Manager) Create(ctx context.Context, req *CreateTemplateRequest) (*models.Template, error) {
 	// Validate template content (basic validation)
 	if len(req.Content) == 0 {
-		return nil, fmt.Errorf("template content cannot be empty")
+		return nil, apperror.InvalidInput("template content cannot be empty")
+	}
+	if err := checkInlineSize(req.Content); err != nil {
+		return nil, err
 	}
 
 	contentType := req.ContentType
@@ -67,6 +88,10 @@ func (m *Manager) Create(ctx context.Context, req *CreateTemplateRequest) (*mode
 		UpdatedAt:   time.Now(),
 	}
 
+	lintResult := m.linter.Lint(ctx, req.Name, req.Content, contentType, lintVars(req.Metadata))
+	template.LintStatus = string(lintResult.Status)
+	template.LintResults = lintResultToMap(lintResult)
+
 	if err := m.db.Create(template).Error; err != nil {
 		return nil, fmt.Errorf("failed to create template: %w", err)
 	}
@@ -91,6 +116,7 @@ func (m *Manager) Create(ctx context.Context, req *CreateTemplateRequest) (*mode
 		zap.String("template_id", template.ID),
 		zap.String("tenant_id", req.TenantID),
 		zap.String("name", req.Name))
+	m.auditContentChange(ctx, template, 0, "", req.CreatedBy, version.ChangeNote)
 
 	return template, nil
 }
@@ -100,7 +126,7 @@ func (m *Manager) Get(ctx context.Context, tenantID, templateID string) (*models
 	var template models.Template
 	if err := m.db.Where("id = ? AND tenant_id = ?", templateID, tenantID).First(&template).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("template not found")
+			return nil, apperror.NotFound("template not found")
 		}
 		return nil, fmt.Errorf("failed to get template: %w", err)
 	}
@@ -112,41 +138,81 @@ func (m *Manager) GetByName(ctx context.Context, tenantID, name string) (*models
 	var template models.Template
 	if err := m.db.Where("name = ? AND tenant_id = ? AND status != ?", name, tenantID, models.TemplateStatusDeleted).First(&template).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("template not found")
+			return nil, apperror.NotFound("template not found")
 		}
 		return nil, fmt.Errorf("failed to get template: %w", err)
 	}
 	return &template, nil
 }
 
-// GetContent retrieves only the template content
+// GetContent retrieves only the template content. Uploaded content is read
+// with OpenContent.
 func (m *Manager) GetContent(ctx context.Context, tenantID, templateID string) (string, error) {
+	return m.GetVersionContent(ctx, tenantID, templateID, 0)
+}
+
+// GetVersionContent retrieves the content of a template version; version 0
+// is the current version. Uploaded content is read with OpenContent.
+func (m *Manager) GetVersionContent(ctx context.Context, tenantID, templateID string, version int) (string, error) {
 	template, err := m.Get(ctx, tenantID, templateID)
 	if err != nil {
 		return "", err
 	}
-	return template.Content, nil
+	if version == 0 || version == template.Version {
+		if err := checkInline(template, "read whole"); err != nil {
+			return "", err
+		}
+		return template.Content, nil
+	}
+	templateVersion, err := m.GetVersion(ctx, tenantID, templateID, version)
+	if err != nil {
+		return "", err
+	}
+	if templateVersion.Uploaded {
+		return "", apperror.InvalidState("version %d of template %s has uploaded content, which cannot be read whole", version, template.Name)
+	}
+	return templateVersion.Content, nil
 }
 
 // UpdateTemplateRequest represents a request to update a template
 type UpdateTemplateRequest struct {
-	Name        *string                 `json:"name"`
-	Description *string                 `json:"description"`
-	Content     *string                 `json:"content"`
-	ContentType *string                 `json:"content_type"`
-	Status      *models.TemplateStatus  `json:"status"`
-	Tags        map[string]interface{}  `json:"tags"`
-	Metadata    map[string]interface{}  `json:"metadata"`
-	ChangedBy   string                  `json:"changed_by"`
-	ChangeNote  string                  `json:"change_note"`
+	Name        *string                `json:"name"`
+	Description *string                `json:"description"`
+	Content     *string                `json:"content"`
+	ContentType *string                `json:"content_type"`
+	Status      *models.TemplateStatus `json:"status"`
+	Tags        map[string]interface{} `json:"tags"`
+	Metadata    map[string]interface{} `json:"metadata"`
+	ChangedBy   string                 `json:"changed_by"`
+	ChangeNote  string                 `json:"change_note"`
+
+	// ExpectedVersion is the version the client last read; the update fails
+	// with a conflict if the template has changed since. Every update stores a
+	// new version. ExpectedUpdatedAt is accepted instead from clients that
+	// predate expected_version.
+	ExpectedVersion   *int       `json:"expected_version"`
+	ExpectedUpdatedAt *time.Time `json:"expected_updated_at"`
 }
 
-// Update updates a template
+// Update updates a template. Updates are rejected with an apperror.StaleError
+// holding the current template if it was modified after the expected version.
 func (m *Manager) Update(ctx context.Context, tenantID, templateID string, req *UpdateTemplateRequest) (*models.Template, error) {
+	if req.ExpectedVersion == nil && req.ExpectedUpdatedAt == nil {
+		return nil, apperror.InvalidInput("expected_version or expected_updated_at is required")
+	}
+
 	template, err := m.Get(ctx, tenantID, templateID)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkNotSynced(template); err != nil {
+		return nil, err
+	}
+
+	if (req.ExpectedVersion != nil && *req.ExpectedVersion != template.Version) ||
+		(req.ExpectedUpdatedAt != nil && !req.ExpectedUpdatedAt.Equal(template.UpdatedAt)) {
+		return nil, apperror.Stale(template, "template was modified by another update (current version %d)", template.Version)
+	}
 
 	updates := make(map[string]interface{})
 	contentChanged := false
@@ -157,10 +223,25 @@ func (m *Manager) Update(ctx context.Context, tenantID, templateID string, req *
 	if req.Description != nil {
 		updates["description"] = *req.Description
 	}
-	if req.Content != nil && *req.Content != template.Content {
-		updates["content"] = *req.Content
-		updates["version"] = template.Version + 1
+	if req.Content != nil && (template.Uploaded || *req.Content != template.Content) {
+		if err := checkInlineSize(*req.Content); err != nil {
+			return nil, err
+		}
+		// Map updates bypass the model's encrypted column serializer
+		content, err := encryption.Seal(tenantID, *req.Content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt template content: %w", err)
+		}
+		updates["content"] = content
 		contentChanged = true
+
+		// Inline content replaces uploaded content
+		if template.Uploaded {
+			updates["uploaded"] = false
+			updates["content_hash"] = ""
+			updates["content_size"] = 0
+			updates["content_version"] = 0
+		}
 	}
 	if req.ContentType != nil {
 		updates["content_type"] = *req.ContentType
@@ -179,49 +260,103 @@ func (m *Manager) Update(ctx context.Context, tenantID, templateID string, req *
 		return template, nil
 	}
 
-	updates["updated_at"] = time.Now()
+	// Re-lint when anything affecting the rendered output changed; uploaded
+	// content is not linted
+	if contentChanged || (!template.Uploaded && (req.ContentType != nil || req.Metadata != nil)) {
+		name, content, contentType, metadata := template.Name, template.Content, template.ContentType, map[string]interface{}(template.Metadata)
+		if req.Name != nil {
+			name = *req.Name
+		}
+		if contentChanged {
+			content = *req.Content
+		}
+		if req.ContentType != nil {
+			contentType = *req.ContentType
+		}
+		if req.Metadata != nil {
+			metadata = req.Metadata
+		}
 
-	if err := m.db.Model(template).Updates(updates).Error; err != nil {
-		return nil, fmt.Errorf("failed to update template: %w", err)
+		lintResult := m.linter.Lint(ctx, name, content, contentType, lintVars(metadata))
+		updates["lint_status"] = string(lintResult.Status)
+		updates["lint_results"] = lintResultToMap(lintResult)
+		template.LintStatus = string(lintResult.Status)
 	}
 
-	// Create version record if content changed
-	if contentChanged {
-		version := &models.TemplateVersion{
-			ID:         uuid.New().String(),
-			TemplateID: templateID,
-			TenantID:   tenantID,
-			Version:    template.Version + 1,
-			Content:    *req.Content,
-			ChangedBy:  req.ChangedBy,
-			ChangeNote: req.ChangeNote,
-			CreatedAt:  time.Now(),
-		}
+	if req.Status != nil && *req.Status == models.TemplateStatusActive && template.LintStatus == string(LintStatusFailed) {
+		return nil, apperror.InvalidState("template failed lint checks and cannot be activated")
+	}
+
+	// Every update stores a new version, so the version alone tells whether
+	// another update landed since the read above
+	version := &models.TemplateVersion{
+		ID:         uuid.New().String(),
+		TemplateID: templateID,
+		TenantID:   tenantID,
+		Version:    template.Version + 1,
+		Content:    template.Content,
+		ChangedBy:  req.ChangedBy,
+		ChangeNote: req.ChangeNote,
+		CreatedAt:  time.Now(),
+	}
+	switch {
+	case contentChanged:
+		version.Content = *req.Content
+	case template.Uploaded:
+		// The new version keeps reading the chunks of the uploaded content
+		version.Uploaded, version.ContentHash, version.ContentSize = true, template.ContentHash, template.ContentSize
+		version.ContentVersion = chunkVersion(template.Version, template.ContentVersion)
+		updates["content_version"] = version.ContentVersion
+	}
+	updates["version"] = version.Version
+	updates["updated_at"] = version.CreatedAt
 
-		if err := m.db.Create(version).Error; err != nil {
-			m.logger.Warn("failed to create version record", zap.Error(err))
+	result := m.db.Model(&models.Template{}).
+		Where("id = ? AND tenant_id = ? AND version = ?", templateID, tenantID, template.Version).
+		Updates(updates)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to update template: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		current, err := m.Get(ctx, tenantID, templateID)
+		if err != nil {
+			return nil, err
 		}
+		return nil, apperror.Stale(current, "template was modified by another update (current version %d)", current.Version)
+	}
+
+	if err := m.db.Create(version).Error; err != nil {
+		m.logger.Warn("failed to create version record", zap.Error(err))
 	}
 
 	m.logger.Info("template updated",
 		zap.String("template_id", templateID),
 		zap.String("tenant_id", tenantID))
 
-	return m.Get(ctx, tenantID, templateID)
+	updated, err := m.Get(ctx, tenantID, templateID)
+	if err != nil {
+		return nil, err
+	}
+	if contentChanged {
+		m.auditContentChange(ctx, updated, template.Version, template.Content, req.ChangedBy, req.ChangeNote)
+	}
+	return updated, nil
 }
 
 // Delete soft-deletes a template
 func (m *Manager) Delete(ctx context.Context, tenantID, templateID string) error {
-	result := m.db.Model(&models.Template{}).
-		Where("id = ? AND tenant_id = ?", templateID, tenantID).
-		Update("status", models.TemplateStatusDeleted)
-
-	if result.Error != nil {
-		return fmt.Errorf("failed to delete template: %w", result.Error)
+	template, err := m.Get(ctx, tenantID, templateID)
+	if err != nil {
+		return err
 	}
-
-	if result.RowsAffected == 0 {
-		return fmt.Errorf("template not found")
+	if err := checkNotSynced(template); err != nil {
+		return err
+	}
+	if template.Status == models.TemplateStatusDeleted {
+		return apperror.NotFound("template not found")
+	}
+	if err := m.setStatus(ctx, template, models.TemplateStatusDeleted); err != nil {
+		return err
 	}
 
 	m.logger.Info("template deleted",
@@ -292,43 +427,146 @@ func (m *Manager) GetVersion(ctx context.Context, tenantID, templateID string, v
 	if err := m.db.Where("template_id = ? AND tenant_id = ? AND version = ?", templateID, tenantID, version).
 		First(&templateVersion).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("template version not found")
+			return nil, apperror.NotFound("template version not found")
 		}
 		return nil, fmt.Errorf("failed to get template version: %w", err)
 	}
 	return &templateVersion, nil
 }
 
-// Activate activates a template
-func (m *Manager) Activate(ctx context.Context, tenantID, templateID string) error {
-	result := m.db.Model(&models.Template{}).
-		Where("id = ? AND tenant_id = ? AND status = ?", templateID, tenantID, models.TemplateStatusDraft).
-		Update("status", models.TemplateStatusActive)
+// DiffVersions returns the unified diff from version a to version b of a template
+func (m *Manager) DiffVersions(ctx context.Context, tenantID, templateID string, a, b, contextLines int) (*diff.Result, error) {
+	tpl, err := m.Get(ctx, tenantID, templateID)
+	if err != nil {
+		return nil, err
+	}
 
-	if result.Error != nil {
-		return fmt.Errorf("failed to activate template: %w", result.Error)
+	from, err := m.GetVersion(ctx, tenantID, templateID, a)
+	if err != nil {
+		return nil, err
+	}
+	to, err := m.GetVersion(ctx, tenantID, templateID, b)
+	if err != nil {
+		return nil, err
+	}
+	if from.Uploaded || to.Uploaded {
+		return nil, apperror.InvalidState("versions with uploaded content cannot be diffed; compare their content hashes")
 	}
 
-	if result.RowsAffected == 0 {
-		return fmt.Errorf("template not found or not in draft status")
+	return diff.Compute(
+		fmt.Sprintf("%s (version %d)", tpl.Name, from.Version),
+		fmt.Sprintf("%s (version %d)", tpl.Name, to.Version),
+		from.Content, to.Content, contextLines), nil
+}
+
+// Lint re-runs lint checks for a template and stores the result
+func (m *Manager) Lint(ctx context.Context, tenantID, templateID string) (*LintResult, error) {
+	template, err := m.Get(ctx, tenantID, templateID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkInline(template, "linted"); err != nil {
+		return nil, err
 	}
 
-	return nil
+	lintResult := m.linter.Lint(ctx, template.Name, template.Content, template.ContentType, lintVars(template.Metadata))
+
+	if err := m.db.Model(template).Updates(map[string]interface{}{
+		"lint_status":  string(lintResult.Status),
+		"lint_results": lintResultToMap(lintResult),
+	}).Error; err != nil {
+		return nil, fmt.Errorf("failed to store lint results: %w", err)
+	}
+
+	return lintResult, nil
+}
+
+// Activate activates a template
+func (m *Manager) Activate(ctx context.Context, tenantID, templateID string) error {
+	template, err := m.Get(ctx, tenantID, templateID)
+	if err != nil {
+		return err
+	}
+	if err := checkNotSynced(template); err != nil {
+		return err
+	}
+	if template.Status != models.TemplateStatusDraft {
+		return apperror.InvalidState("template not found or not in draft status")
+	}
+	if template.LintStatus == string(LintStatusFailed) {
+		return apperror.InvalidState("template failed lint checks and cannot be activated")
+	}
+	return m.setStatus(ctx, template, models.TemplateStatusActive)
 }
 
 // Deprecate deprecates a template
 func (m *Manager) Deprecate(ctx context.Context, tenantID, templateID string) error {
-	result := m.db.Model(&models.Template{}).
-		Where("id = ? AND tenant_id = ? AND status = ?", templateID, tenantID, models.TemplateStatusActive).
-		Update("status", models.TemplateStatusDeprecated)
+	template, err := m.Get(ctx, tenantID, templateID)
+	if err != nil {
+		return err
+	}
+	if err := checkNotSynced(template); err != nil {
+		return err
+	}
+	if template.Status != models.TemplateStatusActive {
+		return apperror.InvalidState("template not found or not active")
+	}
+	return m.setStatus(ctx, template, models.TemplateStatusDeprecated)
+}
 
-	if result.Error != nil {
-		return fmt.Errorf("failed to deprecate template: %w", result.Error)
+// setStatus moves a template read by the caller to another status. Like an
+// update it stores a new version with the same content, and fails with an
+// apperror.StaleError if the template was modified after it was read.
+func (m *Manager) setStatus(ctx context.Context, template *models.Template, status models.TemplateStatus) error {
+	version := &models.TemplateVersion{
+		ID:         uuid.New().String(),
+		TemplateID: template.ID,
+		TenantID:   template.TenantID,
+		Version:    template.Version + 1,
+		Content:    template.Content,
+		ChangeNote: "Status changed to " + string(status),
+		CreatedAt:  time.Now(),
+	}
+	updates := map[string]interface{}{
+		"status":     status,
+		"version":    version.Version,
+		"updated_at": version.CreatedAt,
+	}
+	if template.Uploaded {
+		version.Uploaded, version.ContentHash, version.ContentSize = true, template.ContentHash, template.ContentSize
+		version.ContentVersion = chunkVersion(template.Version, template.ContentVersion)
+		updates["content_version"] = version.ContentVersion
 	}
 
+	result := m.db.Model(&models.Template{}).
+		Where("id = ? AND tenant_id = ? AND version = ?", template.ID, template.TenantID, template.Version).
+		Updates(updates)
+	if result.Error != nil {
+		return fmt.Errorf("failed to set template status: %w", result.Error)
+	}
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("template not found or not active")
+		current, err := m.Get(ctx, template.TenantID, template.ID)
+		if err != nil {
+			return err
+		}
+		return apperror.Stale(current, "template was modified by another update (current version %d)", current.Version)
 	}
 
+	if err := m.db.Create(version).Error; err != nil {
+		m.logger.Warn("failed to create version record", zap.Error(err))
+	}
 	return nil
 }
+
+// lintResultToMap converts a lint result for storage in a JSON column
+func lintResultToMap(result *LintResult) models.JSONMap {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil
+	}
+	var m models.JSONMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,297 @@
+package template
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/internal/dbtest"
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// templateStore answers the statements of template updates for a single
+// template, keeping its version history. interleave runs before an update
+// of the template is applied, standing in for an update that landed between
+// the read and the write.
+type templateStore struct {
+	t          *testing.T
+	row        map[string]driver.Value
+	versions   []map[string]driver.Value
+	interleave func(*templateStore)
+}
+
+var templateColumns = []string{"id", "tenant_id", "name", "content", "content_type", "version", "status",
+	"uploaded", "content_hash", "content_size", "content_version", "updated_at"}
+
+func newTemplateStore(t *testing.T, version int, updatedAt time.Time) *templateStore {
+	return &templateStore{t: t, row: map[string]driver.Value{
+		"id":              "tpl-1",
+		"tenant_id":       "tenant-1",
+		"name":            "original",
+		"content":         "port: 80",
+		"content_type":    "text/plain",
+		"version":         int64(version),
+		"status":          string(models.TemplateStatusDraft),
+		"uploaded":        false,
+		"content_hash":    "",
+		"content_size":    int64(0),
+		"content_version": int64(0),
+		"updated_at":      updatedAt,
+	}}
+}
+
+func (s *templateStore) handle(query string, args []driver.Value) (*dbtest.Result, error) {
+	switch {
+	case strings.HasPrefix(query, "SELECT") && strings.Contains(query, "FROM `templates`"):
+		row := make([]driver.Value, len(templateColumns))
+		for i, column := range templateColumns {
+			row[i] = s.row[column]
+		}
+		return &dbtest.Result{Columns: templateColumns, Rows: [][]driver.Value{row}}, nil
+	case strings.HasPrefix(query, "UPDATE `templates`"):
+		_, where, _ := strings.Cut(query, " WHERE ")
+		if strings.Contains(where, "updated_at") {
+			s.t.Errorf("update is guarded on updated_at: %s", where)
+		}
+		if s.interleave != nil {
+			s.interleave(s)
+			s.interleave = nil
+		}
+		set, conditions := dbtest.Assignments(query, args)
+		if conditions[len(conditions)-1] != s.row["version"] {
+			return &dbtest.Result{}, nil
+		}
+		for column, value := range set {
+			if n, ok := value.(int); ok {
+				value = int64(n)
+			}
+			s.row[column] = value
+		}
+		return &dbtest.Result{RowsAffected: 1}, nil
+	case strings.HasPrefix(query, "INSERT INTO `template_versions`"):
+		s.versions = append(s.versions, dbtest.Inserted(query, args))
+		return &dbtest.Result{RowsAffected: 1}, nil
+	}
+	return &dbtest.Result{}, nil
+}
+
+// bump stands in for a concurrent update
+func (s *templateStore) bump() {
+	s.row["version"] = s.row["version"].(int64) + 1
+	s.row["updated_at"] = s.row["updated_at"].(time.Time).Add(time.Second)
+}
+
+func TestUpdateOptimisticLock(t *testing.T) {
+	readAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	intp := func(v int) *int { return &v }
+	timep := func(v time.Time) *time.Time { return &v }
+	renamed := "renamed"
+
+	tests := []struct {
+		name       string
+		req        UpdateTemplateRequest
+		interleave func(*templateStore)
+		wantErr    error
+		wantStale  int
+	}{
+		{
+			name: "name change stores a new version",
+			req:  UpdateTemplateRequest{Name: &renamed, ExpectedVersion: intp(3)},
+		},
+		{
+			name: "legacy expected_updated_at",
+			req:  UpdateTemplateRequest{Name: &renamed, ExpectedUpdatedAt: timep(readAt)},
+		},
+		{
+			name:    "expectation required",
+			req:     UpdateTemplateRequest{Name: &renamed},
+			wantErr: apperror.ErrInvalidInput,
+		},
+		{
+			name:      "outdated version",
+			req:       UpdateTemplateRequest{Name: &renamed, ExpectedVersion: intp(2)},
+			wantErr:   apperror.ErrConflict,
+			wantStale: 3,
+		},
+		{
+			name:      "outdated updated_at",
+			req:       UpdateTemplateRequest{Name: &renamed, ExpectedUpdatedAt: timep(readAt.Add(time.Second))},
+			wantErr:   apperror.ErrConflict,
+			wantStale: 3,
+		},
+		{
+			name:       "concurrent update",
+			req:        UpdateTemplateRequest{Name: &renamed, ExpectedVersion: intp(3)},
+			interleave: (*templateStore).bump,
+			wantErr:    apperror.ErrConflict,
+			wantStale:  4,
+		},
+		{
+			name: "concurrent change of updated_at alone",
+			req:  UpdateTemplateRequest{Name: &renamed, ExpectedVersion: intp(3)},
+			interleave: func(s *templateStore) {
+				s.row["updated_at"] = s.row["updated_at"].(time.Time).Add(time.Second)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTemplateStore(t, 3, readAt)
+			store.interleave = tt.interleave
+			m := NewManager(dbtest.Open(t, store.handle), zap.NewNop())
+
+			updated, err := m.Update(context.Background(), "tenant-1", "tpl-1", &tt.req)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Update error = %v, want %v", err, tt.wantErr)
+				}
+				if tt.wantStale > 0 {
+					var stale *apperror.StaleError
+					if !errors.As(err, &stale) {
+						t.Fatalf("Update error = %v, want a stale error", err)
+					}
+					if current := stale.Current.(*models.Template); current.Version != tt.wantStale {
+						t.Errorf("stale error holds version %d, want %d", current.Version, tt.wantStale)
+					}
+				}
+				if len(store.versions) != 0 {
+					t.Errorf("rejected update recorded %d versions", len(store.versions))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			if updated.Version != 4 || updated.Name != renamed {
+				t.Errorf("updated to %q version %d, want %q version 4", updated.Name, updated.Version, renamed)
+			}
+		})
+	}
+}
+
+func TestUpdateRecordsEveryVersion(t *testing.T) {
+	readAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	renamed, content := "renamed", "port: 8080"
+
+	tests := []struct {
+		name     string
+		uploaded bool
+		// contentVersion is the stored content_version of the template
+		contentVersion int64
+		req            UpdateTemplateRequest
+		want           map[string]driver.Value
+		wantRow        map[string]driver.Value
+	}{
+		{
+			name: "name change keeps the content",
+			req:  UpdateTemplateRequest{Name: &renamed},
+			want: map[string]driver.Value{"version": int64(4), "content": "port: 80", "uploaded": false, "content_version": int64(0)},
+		},
+		{
+			name: "content change",
+			req:  UpdateTemplateRequest{Content: &content},
+			want: map[string]driver.Value{"version": int64(4), "content": content, "uploaded": false, "content_version": int64(0)},
+		},
+		{
+			name:     "name change of uploaded content reads its chunks",
+			uploaded: true,
+			req:      UpdateTemplateRequest{Name: &renamed},
+			want:     map[string]driver.Value{"version": int64(4), "uploaded": true, "content_hash": "sha256:abc", "content_size": int64(1024), "content_version": int64(3)},
+			wantRow:  map[string]driver.Value{"content_version": int64(3)},
+		},
+		{
+			name:           "chunks of an earlier version are kept",
+			uploaded:       true,
+			contentVersion: 2,
+			req:            UpdateTemplateRequest{Name: &renamed},
+			want:           map[string]driver.Value{"version": int64(4), "uploaded": true, "content_version": int64(2)},
+			wantRow:        map[string]driver.Value{"content_version": int64(2)},
+		},
+		{
+			name:     "inline content replaces uploaded content",
+			uploaded: true,
+			req:      UpdateTemplateRequest{Content: &content},
+			want:     map[string]driver.Value{"version": int64(4), "content": content, "uploaded": false, "content_version": int64(0)},
+			wantRow:  map[string]driver.Value{"uploaded": false, "content_version": int64(0)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTemplateStore(t, 3, readAt)
+			if tt.uploaded {
+				store.row["content"] = ""
+				store.row["uploaded"] = true
+				store.row["content_hash"] = "sha256:abc"
+				store.row["content_size"] = int64(1024)
+				store.row["content_version"] = tt.contentVersion
+			}
+			m := NewManager(dbtest.Open(t, store.handle), zap.NewNop())
+
+			tt.req.ExpectedVersion = new(int)
+			*tt.req.ExpectedVersion = 3
+			if _, err := m.Update(context.Background(), "tenant-1", "tpl-1", &tt.req); err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+
+			if len(store.versions) != 1 {
+				t.Fatalf("recorded %d versions, want 1", len(store.versions))
+			}
+			for column, want := range tt.want {
+				if got := store.versions[0][column]; got != want {
+					t.Errorf("version %s = %v, want %v", column, got, want)
+				}
+			}
+			for column, want := range tt.wantRow {
+				if got := store.row[column]; got != want {
+					t.Errorf("template %s = %v, want %v", column, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSetStatusRecordsVersion(t *testing.T) {
+	readAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	store := newTemplateStore(t, 3, readAt)
+	store.row["status"] = string(models.TemplateStatusActive)
+	store.row["content"] = ""
+	store.row["uploaded"] = true
+	store.row["content_hash"] = "sha256:abc"
+	store.row["content_size"] = int64(1024)
+	m := NewManager(dbtest.Open(t, store.handle), zap.NewNop())
+
+	if err := m.Deprecate(context.Background(), "tenant-1", "tpl-1"); err != nil {
+		t.Fatalf("Deprecate: %v", err)
+	}
+	if store.row["status"] != string(models.TemplateStatusDeprecated) || store.row["version"] != int64(4) {
+		t.Errorf("template is %v version %v, want deprecated version 4", store.row["status"], store.row["version"])
+	}
+	if len(store.versions) != 1 {
+		t.Fatalf("recorded %d versions, want 1", len(store.versions))
+	}
+	// The new version keeps reading the chunks of the uploaded content
+	want := map[string]driver.Value{"version": int64(4), "uploaded": true, "content_hash": "sha256:abc", "content_version": int64(3)}
+	for column, want := range want {
+		if got := store.versions[0][column]; got != want {
+			t.Errorf("version %s = %v, want %v", column, got, want)
+		}
+	}
+
+	// A status change racing another update is rejected like an update
+	store.interleave = (*templateStore).bump
+	err := m.Delete(context.Background(), "tenant-1", "tpl-1")
+	var stale *apperror.StaleError
+	if !errors.As(err, &stale) {
+		t.Fatalf("Delete racing an update: err = %v, want a stale error", err)
+	}
+	if store.row["status"] != string(models.TemplateStatusDeprecated) || len(store.versions) != 1 {
+		t.Errorf("racing delete left the template %v with %d versions", store.row["status"], len(store.versions))
+	}
+}
@@ -0,0 +1,186 @@
+// Package template provides template management for the control plane.
+package template
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/diff"
+)
+
+// RenderPreviewRequest represents a request to render a template preview
+type RenderPreviewRequest struct {
+	// Vars are the workflow variables the template is rendered with
+	Vars map[string]interface{} `json:"vars"`
+	// Env are the environment variables exposed as env.*
+	Env map[string]string `json:"env"`
+	// Facts are the agent facts exposed as facts.* (e.g. os, arch, hostname)
+	Facts map[string]interface{} `json:"facts"`
+	// Grains are the agent host facts exposed as grains.* (e.g. fqdn, ip_addresses)
+	Grains map[string]interface{} `json:"grains"`
+	// Steps are sample results of earlier steps exposed as steps.<id>,
+	// e.g. {"precheck": {"output": {"free_disk": "20G"}}}
+	Steps map[string]interface{} `json:"steps"`
+	// AgentID renders with the facts and grains last reported by this
+	// agent; explicit Facts and Grains override individual keys
+	AgentID string `json:"agent_id"`
+	// Version renders a specific template version (0 = current content)
+	Version int `json:"version"`
+	// CompareVersion renders another version with the same variables and diffs against it
+	CompareVersion int `json:"compare_version"`
+	// PreviousOutput is a prior render to diff against (takes precedence over CompareVersion)
+	PreviousOutput *string `json:"previous_output"`
+}
+
+// RenderPreviewResult contains the result of a template preview render
+type RenderPreviewResult struct {
+	TemplateID         string   `json:"template_id"`
+	Version            int      `json:"version"`
+	Valid              bool     `json:"valid"`
+	Output             string   `json:"output"`
+	UndefinedVariables []string `json:"undefined_variables"`
+	Error              string   `json:"error,omitempty"`
+	CompareVersion     int      `json:"compare_version,omitempty"`
+	Diff               string   `json:"diff,omitempty"`
+	Changed            bool     `json:"changed"`
+
+	// AgentID is the sample agent rendered as, and GrainsUpdatedAt when it
+	// last reported its grains
+	AgentID         string     `json:"agent_id,omitempty"`
+	GrainsUpdatedAt *time.Time `json:"grains_updated_at,omitempty"`
+}
+
+// RenderPreview renders a template with the supplied variables without
+// deploying it, reporting undefined variables and a diff against a prior render
+func (m *Manager) RenderPreview(ctx context.Context, tenantID, templateID string, req *RenderPreviewRequest) (*RenderPreviewResult, error) {
+	tpl, err := m.Get(ctx, tenantID, templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	content := tpl.Content
+	version := tpl.Version
+	uploaded := tpl.Uploaded
+	if req.Version > 0 && req.Version != tpl.Version {
+		v, err := m.GetVersion(ctx, tenantID, templateID, req.Version)
+		if err != nil {
+			return nil, err
+		}
+		content = v.Content
+		version = v.Version
+		uploaded = v.Uploaded
+	}
+	if uploaded {
+		return nil, apperror.InvalidState("version %d of template %s has uploaded content, which is deployed verbatim and cannot be rendered", version, tpl.Name)
+	}
+
+	renderCtx := &RenderContext{
+		Vars:   req.Vars,
+		Env:    req.Env,
+		Facts:  req.Facts,
+		Grains: req.Grains,
+		Steps:  req.Steps,
+	}
+
+	result := &RenderPreviewResult{
+		TemplateID: templateID,
+		Version:    version,
+	}
+
+	if req.AgentID != "" {
+		sample, err := m.sampleAgent(ctx, tenantID, req.AgentID)
+		if err != nil {
+			return nil, err
+		}
+		renderCtx.Facts = mergeFacts(agentFacts(sample), req.Facts)
+		renderCtx.Grains = mergeFacts(sample.Grains, req.Grains)
+		result.AgentID = sample.ID
+		result.GrainsUpdatedAt = sample.GrainsUpdatedAt
+	}
+	result.UndefinedVariables = m.renderer.UndefinedVariables(content, renderCtx)
+
+	output, err := m.renderer.Render(content, renderCtx)
+	if err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+	result.Output = output
+	result.Valid = len(result.UndefinedVariables) == 0
+
+	// Diff against a prior render
+	var previous string
+	var previousName string
+	switch {
+	case req.PreviousOutput != nil:
+		previous = *req.PreviousOutput
+		previousName = "previous"
+	case req.CompareVersion > 0:
+		v, err := m.GetVersion(ctx, tenantID, templateID, req.CompareVersion)
+		if err != nil {
+			return nil, err
+		}
+		if v.Uploaded {
+			return nil, apperror.InvalidState("version %d of template %s has uploaded content and cannot be rendered", v.Version, tpl.Name)
+		}
+		previous, err = m.renderer.Render(v.Content, renderCtx)
+		if err != nil {
+			return nil, apperror.InvalidInput("failed to render compare version %d: %w", req.CompareVersion, err)
+		}
+		previousName = fmt.Sprintf("%s (version %d)", tpl.Name, v.Version)
+		result.CompareVersion = v.Version
+	default:
+		return result, nil
+	}
+
+	result.Diff = diff.Unified(previousName, fmt.Sprintf("%s (version %d)", tpl.Name, version), previous, output)
+	result.Changed = result.Diff != ""
+
+	return result, nil
+}
+
+// sampleAgent loads the agent a preview is rendered as
+func (m *Manager) sampleAgent(ctx context.Context, tenantID, agentID string) (*models.Agent, error) {
+	var agent models.Agent
+	err := m.db.WithContext(ctx).
+		Select("id", "hostname", "os", "arch", "grains", "grains_updated_at").
+		Where("id = ? AND tenant_id = ?", agentID, tenantID).
+		First(&agent).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("agent not found")
+		}
+		return nil, fmt.Errorf("failed to get agent: %w", err)
+	}
+	return &agent, nil
+}
+
+// agentFacts returns the facts.* the agent would render with, as far as the
+// control plane knows them
+func agentFacts(agent *models.Agent) map[string]interface{} {
+	facts := map[string]interface{}{
+		"hostname": agent.Hostname,
+		"os":       agent.OS,
+		"arch":     agent.Arch,
+	}
+	if n, ok := agent.Grains["num_cpus"]; ok {
+		facts["num_cpu"] = n
+	}
+	return facts
+}
+
+// mergeFacts returns base with the keys in overrides replaced
+func mergeFacts(base, overrides map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(overrides))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged
+}
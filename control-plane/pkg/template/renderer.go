@@ -0,0 +1,280 @@
+// Package template provides template management for the control plane.
+package template
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/flosch/pongo2/v6"
+)
+
+// Renderer renders templates server-side using Pongo2 (Jinja2-compatible),
+// the same engine and filter set used by the agent's template step
+type Renderer struct {
+	set *pongo2.TemplateSet
+}
+
+var registerFiltersOnce sync.Once
+
+// NewRenderer creates a new sandboxed template renderer
+func NewRenderer() *Renderer {
+	registerFiltersOnce.Do(registerBuiltinFilters)
+
+	// Templates are rendered on the control plane host, so any tag that can
+	// reach the local filesystem is banned
+	set := pongo2.NewSet("preview", pongo2.MustNewLocalFileSystemLoader(""))
+	for _, tag := range []string{"include", "import", "extends", "ssi"} {
+		set.BanTag(tag)
+	}
+
+	return &Renderer{set: set}
+}
+
+// registerBuiltinFilters registers the custom filters the agent provides.
+// Registration errors are ignored for filters pongo2 already ships with,
+// matching the agent's behaviour.
+func registerBuiltinFilters() {
+	filters := map[string]pongo2.FilterFunction{
+		"default": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+			if in.IsNil() || (in.IsString() && in.String() == "") {
+				return param, nil
+			}
+			return in, nil
+		},
+		"quote": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+			return pongo2.AsValue(fmt.Sprintf("%q", in.String())), nil
+		},
+		"indent": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+			spaces := param.Integer()
+			if spaces <= 0 {
+				spaces = 4
+			}
+			indent := strings.Repeat(" ", spaces)
+			lines := strings.Split(in.String(), "\n")
+			for i, line := range lines {
+				if line != "" {
+					lines[i] = indent + line
+				}
+			}
+			return pongo2.AsValue(strings.Join(lines, "\n")), nil
+		},
+		"bool": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+			if in.Bool() {
+				return pongo2.AsValue("true"), nil
+			}
+			return pongo2.AsValue("false"), nil
+		},
+		"yaml_encode": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+			if in.IsNil() {
+				return pongo2.AsValue("null"), nil
+			}
+			if in.IsBool() {
+				return pongo2.AsValue(fmt.Sprintf("%t", in.Bool())), nil
+			}
+			if in.IsInteger() {
+				return pongo2.AsValue(fmt.Sprintf("%d", in.Integer())), nil
+			}
+			if in.IsFloat() {
+				return pongo2.AsValue(fmt.Sprintf("%g", in.Float())), nil
+			}
+			s := in.String()
+			if strings.ContainsAny(s, ":#{}[]&*?|>!%@`") || s == "" {
+				return pongo2.AsValue(fmt.Sprintf("%q", s)), nil
+			}
+			return in, nil
+		},
+	}
+
+	for name, fn := range filters {
+		pongo2.RegisterFilter(name, fn)
+	}
+}
+
+// RenderContext contains the data a template is rendered with. It mirrors
+// the agent's render context: vars at the top level, env, facts, grains and
+// earlier step results nested. Grains and steps are set last so a variable
+// cannot shadow them.
+type RenderContext struct {
+	Vars   map[string]interface{} `json:"vars"`
+	Env    map[string]string      `json:"env"`
+	Facts  map[string]interface{} `json:"facts"`
+	Grains map[string]interface{} `json:"grains"`
+	Steps  map[string]interface{} `json:"steps"`
+}
+
+// toContext converts the render context to a pongo2 context
+func (c *RenderContext) toContext() pongo2.Context {
+	ctx := pongo2.Context{}
+	for k, v := range c.Vars {
+		ctx[k] = v
+	}
+
+	env := c.Env
+	if env == nil {
+		env = map[string]string{}
+	}
+	facts := c.Facts
+	if facts == nil {
+		facts = map[string]interface{}{}
+	}
+	grains := c.Grains
+	if grains == nil {
+		grains = map[string]interface{}{}
+	}
+	steps := c.Steps
+	if steps == nil {
+		steps = map[string]interface{}{}
+	}
+	ctx["env"] = env
+	ctx["facts"] = facts
+	ctx["grains"] = grains
+	ctx["steps"] = steps
+
+	return ctx
+}
+
+// Render renders template content with the given context
+func (r *Renderer) Render(content string, ctx *RenderContext) (string, error) {
+	tpl, err := r.set.FromString(content)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	output, err := tpl.Execute(ctx.toContext())
+	if err != nil {
+		return "", fmt.Errorf("failed to render template: %w", err)
+	}
+
+	return output, nil
+}
+
+var (
+	// exprPattern matches {{ ... }} expressions
+	exprPattern = regexp.MustCompile(`\{\{-?\s*(.*?)\s*-?\}\}`)
+	// tagPattern matches {% ... %} tags
+	tagPattern = regexp.MustCompile(`\{%-?\s*(\w+)\s*(.*?)\s*-?%\}`)
+	// identPattern matches variable references, including dotted paths
+	identPattern = regexp.MustCompile(`(?:^|[^\w."'|])([A-Za-z_]\w*)((?:\.\w+)*)`)
+	// stringPattern matches quoted string literals
+	stringPattern = regexp.MustCompile(`"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`)
+)
+
+// exprKeywords are identifiers that are never variable references
+var exprKeywords = map[string]bool{
+	"and": true, "or": true, "not": true, "in": true, "is": true,
+	"true": true, "false": true, "True": true, "False": true,
+	"none": true, "None": true, "nil": true, "forloop": true,
+}
+
+// UndefinedVariables returns the top-level variables and env/facts/grains/steps keys
+// referenced by the template that are missing from the render context.
+// Expressions guarded with the default filter are not reported.
+func (r *Renderer) UndefinedVariables(content string, ctx *RenderContext) []string {
+	locals := make(map[string]bool)
+	var exprs []string
+
+	for _, m := range tagPattern.FindAllStringSubmatch(content, -1) {
+		tag, args := m[1], m[2]
+		switch tag {
+		case "for":
+			parts := strings.SplitN(args, " in ", 2)
+			if len(parts) == 2 {
+				for _, name := range strings.Split(parts[0], ",") {
+					locals[strings.TrimSpace(name)] = true
+				}
+				exprs = append(exprs, parts[1])
+			}
+		case "set":
+			parts := strings.SplitN(args, "=", 2)
+			if len(parts) == 2 {
+				locals[strings.TrimSpace(parts[0])] = true
+				exprs = append(exprs, parts[1])
+			}
+		case "with":
+			for _, assign := range strings.Fields(args) {
+				if parts := strings.SplitN(assign, "=", 2); len(parts) == 2 {
+					locals[strings.TrimSpace(parts[0])] = true
+					exprs = append(exprs, parts[1])
+				}
+			}
+		case "if", "elif":
+			exprs = append(exprs, args)
+		}
+	}
+	for _, m := range exprPattern.FindAllStringSubmatch(content, -1) {
+		exprs = append(exprs, m[1])
+	}
+
+	seen := make(map[string]bool)
+	for _, expr := range exprs {
+		if strings.Contains(expr, "|default") || strings.Contains(expr, "| default") {
+			continue
+		}
+		// Only inspect the part before the first filter
+		if idx := strings.Index(expr, "|"); idx >= 0 {
+			expr = expr[:idx]
+		}
+		expr = stringPattern.ReplaceAllString(expr, `""`)
+
+		for _, m := range identPattern.FindAllStringSubmatch(expr, -1) {
+			name, path := m[1], strings.TrimPrefix(m[2], ".")
+			if exprKeywords[name] || locals[name] {
+				continue
+			}
+
+			var missing string
+			switch name {
+			case "env":
+				if key := firstSegment(path); key != "" {
+					if _, ok := ctx.Env[key]; !ok {
+						missing = "env." + key
+					}
+				}
+			case "facts":
+				if key := firstSegment(path); key != "" {
+					if _, ok := ctx.Facts[key]; !ok {
+						missing = "facts." + key
+					}
+				}
+			case "grains":
+				if key := firstSegment(path); key != "" {
+					if _, ok := ctx.Grains[key]; !ok {
+						missing = "grains." + key
+					}
+				}
+			case "steps":
+				if key := firstSegment(path); key != "" {
+					if _, ok := ctx.Steps[key]; !ok {
+						missing = "steps." + key
+					}
+				}
+			default:
+				if _, ok := ctx.Vars[name]; !ok {
+					missing = name
+				}
+			}
+
+			if missing != "" && !seen[missing] {
+				seen[missing] = true
+			}
+		}
+	}
+
+	undefined := make([]string, 0, len(seen))
+	for name := range seen {
+		undefined = append(undefined, name)
+	}
+	sort.Strings(undefined)
+	return undefined
+}
+
+// firstSegment returns the first segment of a dotted path
+func firstSegment(path string) string {
+	if idx := strings.Index(path, "."); idx >= 0 {
+		return path[:idx]
+	}
+	return path
+}
@@ -0,0 +1,261 @@
+package template
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/encryption"
+)
+
+// SyncTemplateRequest is a template defined by a file in a Git repository
+type SyncTemplateRequest struct {
+	TenantID     string
+	RepositoryID string
+	// Path is the file defining the template and Commit the commit it was
+	// read at
+	Path        string
+	Commit      string
+	Name        string
+	Description string
+	Content     string
+	ContentType string
+	Tags        map[string]interface{}
+	Metadata    map[string]interface{}
+	// Status defaults to active
+	Status    models.TemplateStatus
+	CreatedBy string
+}
+
+// Sync creates or updates the template defined by a file in a Git
+// repository. Content changes are linted and recorded as a new version like
+// API updates; a template failing lint is not activated.
+func (m *Manager) Sync(ctx context.Context, req *SyncTemplateRequest) (*models.Template, models.GitSyncAction, error) {
+	status := req.Status
+	switch status {
+	case "":
+		status = models.TemplateStatusActive
+	case models.TemplateStatusDraft, models.TemplateStatusActive, models.TemplateStatusDeprecated:
+	default:
+		return nil, "", apperror.InvalidInput("invalid status %q: must be draft, active or deprecated", status)
+	}
+	if req.Name == "" {
+		return nil, "", apperror.InvalidInput("name is required")
+	}
+	if len(req.Content) == 0 {
+		return nil, "", apperror.InvalidInput("template content cannot be empty")
+	}
+	if err := checkInlineSize(req.Content); err != nil {
+		return nil, "", err
+	}
+	contentType := req.ContentType
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+
+	// Template names are unique within a tenant, deleted templates included
+	var named models.Template
+	err := m.db.WithContext(ctx).Select("id", "source_repository_id", "source_path").
+		Where("tenant_id = ? AND name = ?", req.TenantID, req.Name).
+		First(&named).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, "", fmt.Errorf("failed to check template name: %w", err)
+	}
+	if err == nil && (named.SourceRepositoryID == nil || *named.SourceRepositoryID != req.RepositoryID || named.SourcePath != req.Path) {
+		return nil, "", apperror.Conflict("template name %q is already used by template %s", req.Name, named.ID)
+	}
+
+	var existing models.Template
+	err = m.db.WithContext(ctx).
+		Where("tenant_id = ? AND source_repository_id = ? AND source_path = ?", req.TenantID, req.RepositoryID, req.Path).
+		First(&existing).Error
+	if err == gorm.ErrRecordNotFound {
+		return m.createSynced(ctx, req, contentType, status)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get synced template: %w", err)
+	}
+
+	updates := make(map[string]interface{})
+	contentChanged := existing.Content != req.Content
+	if existing.Name != req.Name {
+		updates["name"] = req.Name
+	}
+	if existing.Description != req.Description {
+		updates["description"] = req.Description
+	}
+	if contentChanged {
+		// Map updates bypass the model's encrypted column serializer
+		content, err := encryption.Seal(req.TenantID, req.Content)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to encrypt template content: %w", err)
+		}
+		updates["content"] = content
+	}
+	if existing.ContentType != contentType {
+		updates["content_type"] = contentType
+	}
+	if existing.Status != status {
+		updates["status"] = status
+	}
+	if !jsonMapsEqual(existing.Tags, req.Tags) {
+		updates["tags"] = req.Tags
+	}
+	metadataChanged := !jsonMapsEqual(existing.Metadata, req.Metadata)
+	if metadataChanged {
+		updates["metadata"] = req.Metadata
+	}
+	if len(updates) == 0 {
+		return &existing, models.GitSyncActionUnchanged, nil
+	}
+
+	lintStatus := existing.LintStatus
+	if contentChanged || existing.ContentType != contentType || metadataChanged {
+		lintResult := m.linter.Lint(ctx, req.Name, req.Content, contentType, lintVars(req.Metadata))
+		updates["lint_status"] = string(lintResult.Status)
+		updates["lint_results"] = lintResultToMap(lintResult)
+		lintStatus = string(lintResult.Status)
+	}
+	if status == models.TemplateStatusActive && lintStatus == string(LintStatusFailed) {
+		return nil, "", apperror.InvalidState("template failed lint checks and cannot be activated")
+	}
+
+	// Like edits, every sync update stores a new version
+	updates["version"] = existing.Version + 1
+	updates["source_commit"] = req.Commit
+	updates["updated_at"] = time.Now()
+	if err := m.db.WithContext(ctx).Model(&models.Template{}).
+		Where("id = ?", existing.ID).
+		Updates(updates).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to update synced template: %w", err)
+	}
+
+	version := &models.TemplateVersion{
+		ID:         uuid.New().String(),
+		TemplateID: existing.ID,
+		TenantID:   req.TenantID,
+		Version:    existing.Version + 1,
+		Content:    req.Content,
+		ChangedBy:  req.CreatedBy,
+		ChangeNote: syncChangeNote(req),
+		CreatedAt:  time.Now(),
+	}
+	if err := m.db.WithContext(ctx).Create(version).Error; err != nil {
+		m.logger.Warn("failed to create version record", zap.Error(err))
+	}
+
+	m.logger.Info("synced template updated",
+		zap.String("template_id", existing.ID),
+		zap.String("tenant_id", req.TenantID),
+		zap.String("repository_id", req.RepositoryID),
+		zap.String("path", req.Path),
+		zap.String("commit", req.Commit))
+
+	template, err := m.Get(ctx, req.TenantID, existing.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	if contentChanged {
+		m.auditContentChange(ctx, template, existing.Version, existing.Content, req.CreatedBy, syncChangeNote(req))
+	}
+	return template, models.GitSyncActionUpdated, nil
+}
+
+// createSynced creates a template for a file new to its repository
+func (m *Manager) createSynced(ctx context.Context, req *SyncTemplateRequest, contentType string, status models.TemplateStatus) (*models.Template, models.GitSyncAction, error) {
+	lintResult := m.linter.Lint(ctx, req.Name, req.Content, contentType, lintVars(req.Metadata))
+	if status == models.TemplateStatusActive && lintResult.Status == LintStatusFailed {
+		return nil, "", apperror.InvalidState("template failed lint checks and cannot be activated")
+	}
+
+	repositoryID := req.RepositoryID
+	template := &models.Template{
+		ID:                 uuid.New().String(),
+		TenantID:           req.TenantID,
+		Name:               req.Name,
+		Description:        req.Description,
+		Content:            req.Content,
+		ContentType:        contentType,
+		Version:            1,
+		Status:             status,
+		Tags:               req.Tags,
+		Metadata:           req.Metadata,
+		LintStatus:         string(lintResult.Status),
+		LintResults:        lintResultToMap(lintResult),
+		SourceRepositoryID: &repositoryID,
+		SourcePath:         req.Path,
+		SourceCommit:       req.Commit,
+		CreatedBy:          req.CreatedBy,
+		CreatedAt:          time.Now(),
+		UpdatedAt:          time.Now(),
+	}
+	if err := m.db.WithContext(ctx).Create(template).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to create synced template: %w", err)
+	}
+
+	version := &models.TemplateVersion{
+		ID:         uuid.New().String(),
+		TemplateID: template.ID,
+		TenantID:   req.TenantID,
+		Version:    1,
+		Content:    req.Content,
+		ChangedBy:  req.CreatedBy,
+		ChangeNote: syncChangeNote(req),
+		CreatedAt:  time.Now(),
+	}
+	if err := m.db.WithContext(ctx).Create(version).Error; err != nil {
+		m.logger.Warn("failed to create version record", zap.Error(err))
+	}
+
+	m.logger.Info("synced template created",
+		zap.String("template_id", template.ID),
+		zap.String("tenant_id", req.TenantID),
+		zap.String("repository_id", req.RepositoryID),
+		zap.String("path", req.Path),
+		zap.String("commit", req.Commit))
+	m.auditContentChange(ctx, template, 0, "", req.CreatedBy, version.ChangeNote)
+
+	return template, models.GitSyncActionCreated, nil
+}
+
+// DeprecateSynced deprecates a synced template whose file was removed from
+// its repository. Like a sync update it stores a new version, and fails with
+// an apperror.StaleError if the template was modified after it was read.
+func (m *Manager) DeprecateSynced(ctx context.Context, template *models.Template) error {
+	return m.setStatus(ctx, template, models.TemplateStatusDeprecated)
+}
+
+// checkNotSynced rejects API changes to a template synced from Git, which
+// would be overwritten by the next sync
+func checkNotSynced(template *models.Template) error {
+	if template.SourceRepositoryID == nil {
+		return nil
+	}
+	return apperror.InvalidState("template %s is synced from Git repository %s (%s); change it in the repository",
+		template.Name, *template.SourceRepositoryID, template.SourcePath)
+}
+
+// syncChangeNote describes a version created by a sync
+func syncChangeNote(req *SyncTemplateRequest) string {
+	commit := req.Commit
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	return fmt.Sprintf("Synced from %s at %s", req.Path, commit)
+}
+
+// jsonMapsEqual compares a stored JSON column with a value read from a file,
+// treating nil and empty maps alike
+func jsonMapsEqual(stored models.JSONMap, value map[string]interface{}) bool {
+	if len(stored) == 0 && len(value) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(map[string]interface{}(stored), value)
+}
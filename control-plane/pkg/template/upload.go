@@ -0,0 +1,389 @@
+package template
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/encryption"
+)
+
+// MaxInlineContentSize bounds template content sent in JSON requests or
+// synced from Git. Larger content is uploaded to the content endpoint.
+const MaxInlineContentSize = 1 << 20
+
+// DefaultMaxUploadSize is the default bound on uploaded template content
+const DefaultMaxUploadSize = 64 << 20
+
+// uploadChunkSize is the size of the chunks uploaded content is stored in
+const uploadChunkSize = 1 << 20
+
+// UploadConfig configures uploaded template content
+type UploadConfig struct {
+	// MaxSize bounds uploaded content
+	MaxSize int64
+	// SpoolDir holds uploads while they are received and stored; the
+	// system temporary directory when empty
+	SpoolDir string
+}
+
+// SetUploadConfig replaces the upload configuration
+func (m *Manager) SetUploadConfig(config UploadConfig) {
+	if config.MaxSize <= 0 {
+		config.MaxSize = DefaultMaxUploadSize
+	}
+	m.uploads = config
+}
+
+// MaxUploadSize returns the bound on uploaded content
+func (m *Manager) MaxUploadSize() int64 {
+	return m.uploads.MaxSize
+}
+
+// checkInlineSize rejects inline content too large to be copied through
+// requests, renders and diffs
+func checkInlineSize(content string) error {
+	if len(content) > MaxInlineContentSize {
+		return apperror.InvalidInput("template content is %d bytes; inline content is limited to %d bytes, upload larger content with PUT /templates/{id}/content",
+			len(content), MaxInlineContentSize)
+	}
+	return nil
+}
+
+// checkInline rejects operations that need uploaded content in memory
+func checkInline(template *models.Template, operation string) error {
+	if template.Uploaded {
+		return apperror.InvalidState("template %s has uploaded content, which cannot be %s", template.Name, operation)
+	}
+	return nil
+}
+
+// UploadContentRequest describes content uploaded as a template's next
+// version
+type UploadContentRequest struct {
+	ContentType string
+	ChangedBy   string
+	ChangeNote  string
+
+	// ExpectedVersion is the version the client last read; the upload
+	// fails with a conflict if the template has changed since
+	ExpectedVersion int
+}
+
+// UploadContent stores the content read from r as the template's next
+// version. The content is spooled to disk while it is hashed, then stored
+// in encrypted chunks, so it is never held in memory whole. Uploaded
+// content is deployed verbatim: it is not rendered or linted. Uploading the
+// current content again leaves the template unchanged.
+func (m *Manager) UploadContent(ctx context.Context, tenantID, templateID string, r io.Reader, req *UploadContentRequest) (*models.Template, error) {
+	template, err := m.Get(ctx, tenantID, templateID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkNotSynced(template); err != nil {
+		return nil, err
+	}
+	if req.ExpectedVersion != template.Version {
+		return nil, apperror.Stale(template, "template was modified by another update (current version %d)", template.Version)
+	}
+
+	spool, err := os.CreateTemp(m.uploads.SpoolDir, "template-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload spool file: %w", err)
+	}
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(spool, hasher), io.LimitReader(r, m.uploads.MaxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive template content: %w", err)
+	}
+	if size == 0 {
+		return nil, apperror.InvalidInput("template content cannot be empty")
+	}
+	if size > m.uploads.MaxSize {
+		return nil, apperror.InvalidInput("template content exceeds the upload limit of %d bytes", m.uploads.MaxSize)
+	}
+	hash := "sha256:" + hex.EncodeToString(hasher.Sum(nil))
+
+	contentType := req.ContentType
+	if contentType == "" {
+		contentType = template.ContentType
+	}
+	if template.Uploaded && template.ContentHash == hash && template.ContentType == contentType {
+		return template, nil
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read upload spool file: %w", err)
+	}
+
+	previousContent := template.Content
+	version := template.Version + 1
+	now := time.Now()
+	lintResult := &LintResult{
+		Status:      LintStatusSkipped,
+		ContentType: contentType,
+		Issues: []LintIssue{{
+			Severity: LintSeverityWarning,
+			Source:   "template",
+			Message:  "uploaded content is deployed verbatim and not linted",
+		}},
+		LintedAt: now,
+	}
+
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Empty content is sealed like any other value of the column
+		content, err := encryption.Seal(tenantID, "")
+		if err != nil {
+			return fmt.Errorf("failed to encrypt template content: %w", err)
+		}
+
+		// Claim the version first so concurrent uploads fail before
+		// storing any chunks
+		result := tx.Model(&models.Template{}).
+			Where("id = ? AND tenant_id = ? AND version = ?", templateID, tenantID, template.Version).
+			Updates(map[string]interface{}{
+				"content":         content,
+				"content_type":    contentType,
+				"uploaded":        true,
+				"content_hash":    hash,
+				"content_size":    size,
+				"content_version": 0,
+				"version":         version,
+				"lint_status":     string(lintResult.Status),
+				"lint_results":    lintResultToMap(lintResult),
+				"updated_at":      now,
+			})
+		if result.Error != nil {
+			return fmt.Errorf("failed to update template: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			current, err := m.Get(ctx, tenantID, templateID)
+			if err != nil {
+				return err
+			}
+			return apperror.Stale(current, "template was modified by another update (current version %d)", current.Version)
+		}
+
+		if err := storeChunks(tx, spool, tenantID, templateID, version); err != nil {
+			return err
+		}
+
+		return tx.Create(&models.TemplateVersion{
+			ID:          uuid.New().String(),
+			TemplateID:  templateID,
+			TenantID:    tenantID,
+			Version:     version,
+			Uploaded:    true,
+			ContentHash: hash,
+			ContentSize: size,
+			ChangedBy:   req.ChangedBy,
+			ChangeNote:  req.ChangeNote,
+			CreatedAt:   now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.logger.Info("template content uploaded",
+		zap.String("template_id", templateID),
+		zap.String("tenant_id", tenantID),
+		zap.Int("version", version),
+		zap.Int64("size", size))
+
+	updated, err := m.Get(ctx, tenantID, templateID)
+	if err != nil {
+		return nil, err
+	}
+	m.auditContentChange(ctx, updated, template.Version, previousContent, req.ChangedBy, req.ChangeNote)
+	return updated, nil
+}
+
+// storeChunks stores the content read from r as the chunks of a template
+// version
+func storeChunks(tx *gorm.DB, r io.Reader, tenantID, templateID string, version int) error {
+	buf := make([]byte, uploadChunkSize)
+	var offset int64
+	for seq := 0; ; seq++ {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			chunk := &models.TemplateChunk{
+				ID:         uuid.New().String(),
+				TenantID:   tenantID,
+				TemplateID: templateID,
+				Version:    version,
+				Seq:        seq,
+				ByteOffset: offset,
+				Data:       buf[:n],
+				Size:       n,
+				CreatedAt:  time.Now(),
+			}
+			if err := tx.Create(chunk).Error; err != nil {
+				return fmt.Errorf("failed to store template chunk %d: %w", seq, err)
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read upload spool file: %w", err)
+		}
+	}
+}
+
+// Content is the content of a template version, read from the database as
+// it is consumed
+type Content struct {
+	io.ReadSeeker
+	Hash        string
+	Size        int64
+	ContentType string
+	Uploaded    bool
+	ModTime     time.Time
+}
+
+// OpenContent opens the content of a template version; version 0 is the
+// current version. Uploaded content is read one chunk at a time.
+func (m *Manager) OpenContent(ctx context.Context, tenantID, templateID string, version int) (*Content, error) {
+	template, err := m.Get(ctx, tenantID, templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := template.ContentType
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	content := &Content{ContentType: contentType}
+
+	chunks := 0
+	if version == 0 || version == template.Version {
+		chunks = chunkVersion(template.Version, template.ContentVersion)
+		content.ModTime = template.UpdatedAt
+		content.Uploaded, content.Hash, content.Size = template.Uploaded, template.ContentHash, template.ContentSize
+		if !template.Uploaded {
+			content.Hash, content.Size = ContentHash(template.Content), int64(len(template.Content))
+			content.ReadSeeker = strings.NewReader(template.Content)
+		}
+	} else {
+		templateVersion, err := m.GetVersion(ctx, tenantID, templateID, version)
+		if err != nil {
+			return nil, err
+		}
+		chunks = chunkVersion(templateVersion.Version, templateVersion.ContentVersion)
+		content.ModTime = templateVersion.CreatedAt
+		content.Uploaded, content.Hash, content.Size = templateVersion.Uploaded, templateVersion.ContentHash, templateVersion.ContentSize
+		if !templateVersion.Uploaded {
+			content.Hash, content.Size = ContentHash(templateVersion.Content), int64(len(templateVersion.Content))
+			content.ReadSeeker = strings.NewReader(templateVersion.Content)
+		}
+	}
+
+	if content.Uploaded {
+		content.ReadSeeker = &chunkReader{
+			ctx:        ctx,
+			db:         m.db,
+			tenantID:   tenantID,
+			templateID: templateID,
+			version:    chunks,
+			size:       content.Size,
+		}
+	}
+	return content, nil
+}
+
+// chunkVersion returns the version whose chunks hold the uploaded content of
+// version, given its content_version
+func chunkVersion(version, contentVersion int) int {
+	if contentVersion > 0 {
+		return contentVersion
+	}
+	return version
+}
+
+// chunkReader reads uploaded content, loading the chunk holding the current
+// offset on demand
+type chunkReader struct {
+	ctx        context.Context
+	db         *gorm.DB
+	tenantID   string
+	templateID string
+	version    int
+	size       int64
+
+	offset int64
+	chunk  *models.TemplateChunk
+}
+
+// Read implements io.Reader
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.chunk == nil || r.offset < r.chunk.ByteOffset || r.offset >= r.chunk.ByteOffset+int64(len(r.chunk.Data)) {
+		if err := r.load(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.chunk.Data[r.offset-r.chunk.ByteOffset:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+// load loads the chunk holding the current offset
+func (r *chunkReader) load() error {
+	var chunk models.TemplateChunk
+	err := r.db.WithContext(r.ctx).
+		Where("template_id = ? AND tenant_id = ? AND version = ? AND byte_offset <= ?",
+			r.templateID, r.tenantID, r.version, r.offset).
+		Order("seq DESC").
+		First(&chunk).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("template %s version %d is missing content at offset %d", r.templateID, r.version, r.offset)
+		}
+		return fmt.Errorf("failed to read template chunk: %w", err)
+	}
+	if r.offset >= chunk.ByteOffset+int64(len(chunk.Data)) {
+		return fmt.Errorf("template %s version %d is missing content at offset %d", r.templateID, r.version, r.offset)
+	}
+	r.chunk = &chunk
+	return nil
+}
+
+// Seek implements io.Seeker
+func (r *chunkReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+	r.offset = offset
+	return offset, nil
+}
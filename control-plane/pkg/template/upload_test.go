@@ -0,0 +1,290 @@
+package template
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/internal/dbtest"
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/encryption"
+)
+
+// chunkStore answers the statements on template_chunks, passing the others
+// on to next
+type chunkStore struct {
+	t      *testing.T
+	chunks []map[string]driver.Value
+	loads  int
+	next   dbtest.Handler
+}
+
+var chunkColumns = []string{"id", "tenant_id", "template_id", "version", "seq", "byte_offset", "data", "size"}
+
+// add stores content as chunks of size bytes of a version
+func (s *chunkStore) add(version int, content string, size int) {
+	for seq, offset := 0, 0; offset < len(content); seq, offset = seq+1, offset+size {
+		end := offset + size
+		if end > len(content) {
+			end = len(content)
+		}
+		data, err := encryption.Seal("tenant-1", []byte(content[offset:end]))
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		s.chunks = append(s.chunks, map[string]driver.Value{
+			"id": "chunk", "tenant_id": "tenant-1", "template_id": "tpl-1", "version": int64(version),
+			"seq": int64(seq), "byte_offset": int64(offset), "data": data, "size": int64(end - offset),
+		})
+	}
+}
+
+func (s *chunkStore) handle(query string, args []driver.Value) (*dbtest.Result, error) {
+	switch {
+	case strings.HasPrefix(query, "SELECT") && strings.Contains(query, "FROM `template_chunks`"):
+		// template_id, tenant_id, version and the offset the chunk starts by
+		s.loads++
+		var best map[string]driver.Value
+		for _, chunk := range s.chunks {
+			if chunk["version"] == args[2] && chunk["byte_offset"].(int64) <= args[3].(int64) &&
+				(best == nil || chunk["seq"].(int64) > best["seq"].(int64)) {
+				best = chunk
+			}
+		}
+		if best == nil {
+			return &dbtest.Result{Columns: chunkColumns}, nil
+		}
+		row := make([]driver.Value, len(chunkColumns))
+		for i, column := range chunkColumns {
+			row[i] = best[column]
+		}
+		return &dbtest.Result{Columns: chunkColumns, Rows: [][]driver.Value{row}}, nil
+	case strings.HasPrefix(query, "INSERT INTO `template_chunks`"):
+		s.chunks = append(s.chunks, dbtest.Inserted(query, args))
+		return &dbtest.Result{RowsAffected: 1}, nil
+	}
+	if s.next == nil {
+		return &dbtest.Result{}, nil
+	}
+	return s.next(query, args)
+}
+
+func TestChunkReader(t *testing.T) {
+	const content = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+	// step seeks when whence is set, then reads n bytes
+	type step struct {
+		whence int
+		offset int64
+		n      int
+		want   string
+	}
+	seek := func(whence int, offset int64) step { return step{whence: whence, offset: offset, n: -1} }
+	read := func(n int, want string) step { return step{whence: -1, n: n, want: want} }
+
+	tests := []struct {
+		name      string
+		chunkSize int
+		steps     []step
+		wantLoads int
+	}{
+		{
+			name:      "read whole content across chunks",
+			chunkSize: 10,
+			steps:     []step{read(36, content)},
+			wantLoads: 4,
+		},
+		{
+			name:      "read ending on a chunk boundary",
+			chunkSize: 10,
+			steps:     []step{read(10, "0123456789"), read(1, "a")},
+			wantLoads: 2,
+		},
+		{
+			name:      "seek into the middle of a chunk",
+			chunkSize: 10,
+			steps:     []step{seek(io.SeekStart, 15), read(8, "fghijklm")},
+			wantLoads: 2,
+		},
+		{
+			name:      "seek back within the loaded chunk",
+			chunkSize: 10,
+			steps:     []step{seek(io.SeekStart, 12), read(2, "cd"), seek(io.SeekCurrent, -2), read(3, "cde")},
+			wantLoads: 1,
+		},
+		{
+			name:      "seek back to an earlier chunk",
+			chunkSize: 10,
+			steps:     []step{seek(io.SeekStart, 25), read(2, "pq"), seek(io.SeekStart, 9), read(2, "9a")},
+			wantLoads: 3,
+		},
+		{
+			name:      "seek from the end",
+			chunkSize: 10,
+			steps:     []step{seek(io.SeekEnd, -4), read(10, "wxyz")},
+			wantLoads: 1,
+		},
+		{
+			name:      "read at the end",
+			chunkSize: 10,
+			steps:     []step{seek(io.SeekEnd, 0), read(1, "")},
+			wantLoads: 0,
+		},
+		{
+			name:      "single byte chunks",
+			chunkSize: 1,
+			steps:     []step{seek(io.SeekStart, 34), read(5, "yz")},
+			wantLoads: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &chunkStore{t: t}
+			store.add(2, content, tt.chunkSize)
+			// Chunks of another version must not be read
+			store.add(1, strings.ToUpper(content), 5)
+			r := &chunkReader{
+				ctx:        context.Background(),
+				db:         dbtest.Open(t, store.handle),
+				tenantID:   "tenant-1",
+				templateID: "tpl-1",
+				version:    2,
+				size:       int64(len(content)),
+			}
+
+			for i, s := range tt.steps {
+				if s.whence >= 0 {
+					if _, err := r.Seek(s.offset, s.whence); err != nil {
+						t.Fatalf("step %d: Seek: %v", i, err)
+					}
+					continue
+				}
+				buf := make([]byte, s.n)
+				n, err := io.ReadFull(r, buf)
+				if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+					t.Fatalf("step %d: Read: %v", i, err)
+				}
+				if got := string(buf[:n]); got != s.want {
+					t.Fatalf("step %d: read %q, want %q", i, got, s.want)
+				}
+			}
+			if store.loads != tt.wantLoads {
+				t.Errorf("loaded %d chunks, want %d", store.loads, tt.wantLoads)
+			}
+		})
+	}
+}
+
+func TestChunkReaderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		chunks  string
+		size    int64
+		seek    int64
+		wantErr string
+	}{
+		{"missing chunk", "0123456789", 20, 12, "missing content at offset 12"},
+		{"no chunks", "", 10, 0, "missing content at offset 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &chunkStore{t: t}
+			store.add(1, tt.chunks, 10)
+			r := &chunkReader{ctx: context.Background(), db: dbtest.Open(t, store.handle),
+				tenantID: "tenant-1", templateID: "tpl-1", version: 1, size: tt.size}
+			if _, err := r.Seek(tt.seek, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			_, err := r.Read(make([]byte, 4))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Read error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	r := &chunkReader{size: 10}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("Seek to a negative offset succeeded")
+	}
+	if _, err := r.Seek(0, 7); err == nil {
+		t.Error("Seek with an invalid whence succeeded")
+	}
+}
+
+func TestUploadContent(t *testing.T) {
+	readAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	// Content spans several chunks
+	content := bytes.Repeat([]byte("0123456789abcdef"), uploadChunkSize/16*2+100)
+
+	tests := []struct {
+		name       string
+		interleave func(*templateStore)
+		expected   int
+		wantErr    error
+	}{
+		{name: "upload stores a new version", expected: 3},
+		{name: "outdated version", expected: 2, wantErr: apperror.ErrConflict},
+		{name: "concurrent update", expected: 3, interleave: (*templateStore).bump, wantErr: apperror.ErrConflict},
+		{
+			name:     "concurrent change of updated_at alone",
+			expected: 3,
+			interleave: func(s *templateStore) {
+				s.row["updated_at"] = s.row["updated_at"].(time.Time).Add(time.Second)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTemplateStore(t, 3, readAt)
+			store.interleave = tt.interleave
+			chunks := &chunkStore{t: t, next: store.handle}
+			m := NewManager(dbtest.Open(t, chunks.handle), zap.NewNop())
+			m.SetUploadConfig(UploadConfig{SpoolDir: t.TempDir()})
+
+			updated, err := m.UploadContent(context.Background(), "tenant-1", "tpl-1", bytes.NewReader(content),
+				&UploadContentRequest{ExpectedVersion: tt.expected})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("UploadContent error = %v, want %v", err, tt.wantErr)
+				}
+				if len(chunks.chunks) != 0 || len(store.versions) != 0 {
+					t.Errorf("rejected upload stored %d chunks and %d versions", len(chunks.chunks), len(store.versions))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UploadContent: %v", err)
+			}
+			if updated.Version != 4 || !updated.Uploaded || updated.ContentSize != int64(len(content)) {
+				t.Fatalf("uploaded version %d, uploaded %v, size %d", updated.Version, updated.Uploaded, updated.ContentSize)
+			}
+			if len(chunks.chunks) != 3 {
+				t.Errorf("stored %d chunks, want 3", len(chunks.chunks))
+			}
+
+			// Renaming stores a new version reading the uploaded chunks
+			renamed := "renamed"
+			if _, err := m.Update(context.Background(), "tenant-1", "tpl-1", &UpdateTemplateRequest{Name: &renamed, ExpectedVersion: &updated.Version}); err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			opened, err := m.OpenContent(context.Background(), "tenant-1", "tpl-1", 0)
+			if err != nil {
+				t.Fatalf("OpenContent: %v", err)
+			}
+			got, err := io.ReadAll(opened)
+			if err != nil {
+				t.Fatalf("reading content: %v", err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("read %d bytes that differ from the %d uploaded", len(got), len(content))
+			}
+		})
+	}
+}
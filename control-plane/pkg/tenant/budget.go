@@ -0,0 +1,167 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// budgetThresholds are the percentages of a budget that raise alerts
+var budgetThresholds = []int{80, 100}
+
+// BudgetMonitor raises alerts as tenants use up their monthly execution
+// budgets. Alerts are audit events of type alert, for notification
+// consumers, and each threshold alerts once a month.
+type BudgetMonitor struct {
+	db          *gorm.DB
+	interval    time.Duration
+	auditLogger *audit.Logger
+	logger      *zap.Logger
+}
+
+// NewBudgetMonitor creates a budget monitor checking every interval
+// (default 5m)
+func NewBudgetMonitor(db *gorm.DB, interval time.Duration, logger *zap.Logger) *BudgetMonitor {
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	return &BudgetMonitor{
+		db:       db,
+		interval: interval,
+		logger:   logger,
+	}
+}
+
+// SetAuditLogger sets the logger that receives budget alerts
+func (b *BudgetMonitor) SetAuditLogger(auditLogger *audit.Logger) {
+	b.auditLogger = auditLogger
+}
+
+// Run checks budgets every interval until the context is cancelled. Every
+// replica runs the check; each alert is recorded once.
+func (b *BudgetMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := b.Check(ctx); err != nil {
+			b.logger.Error("failed to check execution budgets", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check raises the alerts due for the current month
+func (b *BudgetMonitor) Check(ctx context.Context) error {
+	var tenants []models.Tenant
+	if err := b.db.WithContext(ctx).
+		Select("id", "quota_monthly_executions", "quota_monthly_minutes", "quota_hard_cap").
+		Where("status = ?", models.TenantStatusActive).
+		Where("quota_monthly_executions > 0 OR quota_monthly_minutes > 0").
+		Find(&tenants).Error; err != nil {
+		return fmt.Errorf("failed to list tenants with budgets: %w", err)
+	}
+
+	start, end := monthPeriod(time.Now())
+	month := start.Format(MonthFormat)
+	for _, t := range tenants {
+		executions, minutes, err := measureUsage(ctx, b.db, t.ID, start, end)
+		if err != nil {
+			b.logger.Warn("failed to measure tenant usage",
+				zap.String("tenant_id", t.ID),
+				zap.Error(err))
+			continue
+		}
+
+		tenant := &budgetTenant{
+			QuotaMonthlyExecutions: t.QuotaMonthlyExecutions,
+			QuotaMonthlyMinutes:    t.QuotaMonthlyMinutes,
+			QuotaHardCap:           t.QuotaHardCap,
+		}
+		for _, budget := range budgets(tenant, executions, minutes) {
+			for _, threshold := range budgetThresholds {
+				if budget.Used < float64(budget.Quota)*float64(threshold)/100 {
+					break
+				}
+				if err := b.alert(ctx, t.ID, month, &budget, threshold, t.QuotaHardCap); err != nil {
+					b.logger.Warn("failed to raise budget alert",
+						zap.String("tenant_id", t.ID),
+						zap.String("metric", string(budget.Metric)),
+						zap.Error(err))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// alert records a crossed threshold and, the first time, raises its alert
+func (b *BudgetMonitor) alert(ctx context.Context, tenantID, month string, budget *BudgetUsage, threshold int, hardCap bool) error {
+	record := &models.BudgetAlert{
+		ID:        uuid.New().String(),
+		TenantID:  tenantID,
+		Month:     month,
+		Metric:    budget.Metric,
+		Threshold: threshold,
+		Used:      budget.Used,
+		Quota:     budget.Quota,
+		CreatedAt: time.Now(),
+	}
+	result := b.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	if result.Error != nil {
+		return fmt.Errorf("failed to record budget alert: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	b.logger.Warn("tenant execution budget threshold reached",
+		zap.String("tenant_id", tenantID),
+		zap.String("month", month),
+		zap.String("metric", string(budget.Metric)),
+		zap.Int("threshold", threshold),
+		zap.Float64("used", budget.Used),
+		zap.Int("quota", budget.Quota))
+
+	if b.auditLogger == nil {
+		return nil
+	}
+	description := fmt.Sprintf("%d%% of the monthly %s budget used", threshold, budget.Metric)
+	if threshold >= 100 && hardCap {
+		description += "; new executions are refused"
+	}
+	if err := b.auditLogger.NewEventBuilder().
+		WithTenant(tenantID).
+		WithType(audit.EventTypeAlert).
+		WithAction(audit.ActionCreate).
+		WithOutcome(audit.OutcomeSuccess).
+		WithActor("budget-monitor", "system").
+		WithResource(record.ID, "budget_alert").
+		WithDescription(description).
+		WithMetadata(map[string]interface{}{
+			"kind":      "execution_budget",
+			"month":     month,
+			"metric":    budget.Metric,
+			"threshold": threshold,
+			"used":      budget.Used,
+			"quota":     budget.Quota,
+			"hard_cap":  hardCap,
+		}).
+		Log(ctx); err != nil {
+		return fmt.Errorf("failed to write budget alert: %w", err)
+	}
+	return nil
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 
 	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
 )
 
 // TenantScope is a GORM scope that filters by tenant
@@ -32,7 +34,7 @@ func (e *IsolationEnforcer) ScopedDB(tenantID string) *gorm.DB {
 // ValidateAccess validates that an entity belongs to the tenant
 func (e *IsolationEnforcer) ValidateAccess(tenantID, entityTenantID string) error {
 	if tenantID != entityTenantID {
-		return fmt.Errorf("access denied: entity belongs to different tenant")
+		return apperror.Forbidden("access denied: entity belongs to different tenant")
 	}
 	return nil
 }
@@ -44,7 +46,7 @@ func (e *IsolationEnforcer) ValidateAgentAccess(tenantID, agentID string) error
 		return fmt.Errorf("failed to validate agent access: %w", err)
 	}
 	if count == 0 {
-		return fmt.Errorf("agent not found or access denied")
+		return apperror.NotFound("agent not found or access denied")
 	}
 	return nil
 }
@@ -56,7 +58,7 @@ func (e *IsolationEnforcer) ValidateWorkflowAccess(tenantID, workflowID string)
 		return fmt.Errorf("failed to validate workflow access: %w", err)
 	}
 	if count == 0 {
-		return fmt.Errorf("workflow not found or access denied")
+		return apperror.NotFound("workflow not found or access denied")
 	}
 	return nil
 }
@@ -68,7 +70,7 @@ func (e *IsolationEnforcer) ValidateCampaignAccess(tenantID, campaignID string)
 		return fmt.Errorf("failed to validate campaign access: %w", err)
 	}
 	if count == 0 {
-		return fmt.Errorf("campaign not found or access denied")
+		return apperror.NotFound("campaign not found or access denied")
 	}
 	return nil
 }
@@ -98,7 +100,7 @@ func (c *QuotaChecker) CheckAgentQuota(tenantID string) error {
 	}
 
 	if int(count) >= tenant.QuotaAgents {
-		return fmt.Errorf("agent quota exceeded: %d/%d", count, tenant.QuotaAgents)
+		return apperror.QuotaExceeded("agent quota exceeded: %d/%d", count, tenant.QuotaAgents)
 	}
 
 	return nil
@@ -119,7 +121,7 @@ func (c *QuotaChecker) CheckWorkflowQuota(tenantID string) error {
 	}
 
 	if int(count) >= tenant.QuotaWorkflows {
-		return fmt.Errorf("workflow quota exceeded: %d/%d", count, tenant.QuotaWorkflows)
+		return apperror.QuotaExceeded("workflow quota exceeded: %d/%d", count, tenant.QuotaWorkflows)
 	}
 
 	return nil
@@ -10,7 +10,9 @@ import (
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 
+	"github.com/yourorg/control-plane/pkg/apperror"
 	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/encryption"
 )
 
 // Manager manages tenant operations
@@ -34,6 +36,10 @@ type CreateTenantRequest struct {
 	Settings       map[string]interface{} `json:"settings"`
 	QuotaAgents    int                    `json:"quota_agents"`
 	QuotaWorkflows int                    `json:"quota_workflows"`
+	QuotaConcurrentExecutions int         `json:"quota_concurrent_executions"`
+	QuotaMonthlyExecutions    int         `json:"quota_monthly_executions"`
+	QuotaMonthlyMinutes       int         `json:"quota_monthly_minutes"`
+	QuotaHardCap              bool        `json:"quota_hard_cap"`
 }
 
 // Create creates a new tenant
@@ -46,6 +52,10 @@ func (m *Manager) Create(ctx context.Context, req *CreateTenantRequest) (*models
 		Settings:    req.Settings,
 		QuotaAgents: req.QuotaAgents,
 		QuotaWorkflows: req.QuotaWorkflows,
+		QuotaConcurrentExecutions: req.QuotaConcurrentExecutions,
+		QuotaMonthlyExecutions: req.QuotaMonthlyExecutions,
+		QuotaMonthlyMinutes: req.QuotaMonthlyMinutes,
+		QuotaHardCap: req.QuotaHardCap,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
@@ -73,7 +83,7 @@ func (m *Manager) Get(ctx context.Context, tenantID string) (*models.Tenant, err
 	var tenant models.Tenant
 	if err := m.db.Where("id = ?", tenantID).First(&tenant).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("tenant not found")
+			return nil, apperror.NotFound("tenant not found")
 		}
 		return nil, fmt.Errorf("failed to get tenant: %w", err)
 	}
@@ -85,7 +95,7 @@ func (m *Manager) GetByName(ctx context.Context, name string) (*models.Tenant, e
 	var tenant models.Tenant
 	if err := m.db.Where("name = ?", name).First(&tenant).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("tenant not found")
+			return nil, apperror.NotFound("tenant not found")
 		}
 		return nil, fmt.Errorf("failed to get tenant: %w", err)
 	}
@@ -99,6 +109,12 @@ type UpdateTenantRequest struct {
 	Settings       map[string]interface{} `json:"settings"`
 	QuotaAgents    *int                   `json:"quota_agents"`
 	QuotaWorkflows *int                   `json:"quota_workflows"`
+	QuotaConcurrentExecutions *int        `json:"quota_concurrent_executions"`
+	QuotaMonthlyExecutions    *int        `json:"quota_monthly_executions"`
+	QuotaMonthlyMinutes       *int        `json:"quota_monthly_minutes"`
+	QuotaHardCap              *bool       `json:"quota_hard_cap"`
+	ShellEnabled   *bool                  `json:"shell_enabled"`
+	RemediationPaused *bool               `json:"remediation_paused"`
 }
 
 // Update updates a tenant
@@ -117,7 +133,12 @@ func (m *Manager) Update(ctx context.Context, tenantID string, req *UpdateTenant
 		updates["description"] = *req.Description
 	}
 	if req.Settings != nil {
-		updates["settings"] = req.Settings
+		// Map updates bypass the model's encrypted column serializer
+		settings, err := encryption.Seal(tenantID, req.Settings)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt tenant settings: %w", err)
+		}
+		updates["settings"] = settings
 	}
 	if req.QuotaAgents != nil {
 		updates["quota_agents"] = *req.QuotaAgents
@@ -125,6 +146,24 @@ func (m *Manager) Update(ctx context.Context, tenantID string, req *UpdateTenant
 	if req.QuotaWorkflows != nil {
 		updates["quota_workflows"] = *req.QuotaWorkflows
 	}
+	if req.QuotaConcurrentExecutions != nil {
+		updates["quota_concurrent_executions"] = *req.QuotaConcurrentExecutions
+	}
+	if req.QuotaMonthlyExecutions != nil {
+		updates["quota_monthly_executions"] = *req.QuotaMonthlyExecutions
+	}
+	if req.QuotaMonthlyMinutes != nil {
+		updates["quota_monthly_minutes"] = *req.QuotaMonthlyMinutes
+	}
+	if req.QuotaHardCap != nil {
+		updates["quota_hard_cap"] = *req.QuotaHardCap
+	}
+	if req.ShellEnabled != nil {
+		updates["shell_enabled"] = *req.ShellEnabled
+	}
+	if req.RemediationPaused != nil {
+		updates["remediation_paused"] = *req.RemediationPaused
+	}
 
 	if len(updates) == 0 {
 		return tenant, nil
@@ -152,7 +191,7 @@ func (m *Manager) Delete(ctx context.Context, tenantID string) error {
 	}
 
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("tenant not found")
+		return apperror.NotFound("tenant not found")
 	}
 
 	m.logger.Info("tenant deleted",
@@ -170,7 +209,7 @@ func (m *Manager) Suspend(ctx context.Context, tenantID string) error {
 	}
 
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("tenant not found")
+		return apperror.NotFound("tenant not found")
 	}
 
 	m.logger.Info("tenant suspended",
@@ -188,7 +227,7 @@ func (m *Manager) Activate(ctx context.Context, tenantID string) error {
 	}
 
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("tenant not found or not suspended")
+		return apperror.InvalidState("tenant not found or not suspended")
 	}
 
 	m.logger.Info("tenant activated",
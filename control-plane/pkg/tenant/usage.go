@@ -0,0 +1,172 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// MonthFormat is the layout of the months usage is reported for
+const MonthFormat = "2006-01"
+
+// Usage is a tenant's execution usage over a calendar month (UTC)
+type Usage struct {
+	TenantID    string    `json:"tenant_id"`
+	Month       string    `json:"month"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	// Executions counts the executions started in the month and Minutes
+	// their run time, up to now for those still running
+	Executions int64   `json:"executions"`
+	Minutes    float64 `json:"minutes"`
+	// Budgets are the budgets set for the tenant
+	Budgets []BudgetUsage `json:"budgets"`
+	HardCap bool          `json:"hard_cap"`
+	// Alerts are the budget thresholds crossed in the month
+	Alerts []models.BudgetAlert `json:"alerts"`
+}
+
+// BudgetUsage is the use of one monthly budget
+type BudgetUsage struct {
+	Metric    models.BudgetMetric `json:"metric"`
+	Used      float64             `json:"used"`
+	Quota     int                 `json:"quota"`
+	Percent   float64             `json:"percent"`
+	Exhausted bool                `json:"exhausted"`
+}
+
+// monthPeriod returns the start and end of the month containing t, in UTC
+func monthPeriod(t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// budgetTenant holds the budget columns of a tenant
+type budgetTenant struct {
+	QuotaMonthlyExecutions int
+	QuotaMonthlyMinutes    int
+	QuotaHardCap           bool
+}
+
+// measureUsage measures a tenant's executions created in [start, end) and
+// their run time in minutes
+func measureUsage(ctx context.Context, db *gorm.DB, tenantID string, start, end time.Time) (int64, float64, error) {
+	var row struct {
+		Executions int64
+		Seconds    float64
+	}
+	if err := db.WithContext(ctx).Model(&models.WorkflowExecution{}).
+		Select("COUNT(*) AS executions, "+
+			"COALESCE(SUM(CASE WHEN started_at IS NULL THEN 0 ELSE TIMESTAMPDIFF(SECOND, started_at, COALESCE(completed_at, ?)) END), 0) AS seconds",
+			time.Now().UTC()).
+		Where("tenant_id = ? AND created_at >= ? AND created_at < ?", tenantID, start, end).
+		Scan(&row).Error; err != nil {
+		return 0, 0, fmt.Errorf("failed to measure execution usage: %w", err)
+	}
+	return row.Executions, row.Seconds / 60, nil
+}
+
+// budgets returns the use of a tenant's budgets
+func budgets(tenant *budgetTenant, executions int64, minutes float64) []BudgetUsage {
+	usages := []BudgetUsage{}
+	add := func(metric models.BudgetMetric, used float64, quota int) {
+		if quota <= 0 {
+			return
+		}
+		usages = append(usages, BudgetUsage{
+			Metric:    metric,
+			Used:      used,
+			Quota:     quota,
+			Percent:   math.Round(used/float64(quota)*1000) / 10,
+			Exhausted: used >= float64(quota),
+		})
+	}
+	add(models.BudgetMetricExecutions, float64(executions), tenant.QuotaMonthlyExecutions)
+	add(models.BudgetMetricMinutes, math.Round(minutes*100)/100, tenant.QuotaMonthlyMinutes)
+	return usages
+}
+
+// GetUsage returns a tenant's usage over a month given as YYYY-MM, or the
+// current month when month is empty
+func (m *Manager) GetUsage(ctx context.Context, tenantID, month string) (*Usage, error) {
+	at := time.Now()
+	if month != "" {
+		parsed, err := time.Parse(MonthFormat, month)
+		if err != nil {
+			return nil, apperror.InvalidInput("invalid month %q: must be YYYY-MM", month)
+		}
+		at = parsed
+	}
+	start, end := monthPeriod(at)
+
+	var tenant budgetTenant
+	if err := m.db.WithContext(ctx).Model(&models.Tenant{}).
+		Select("quota_monthly_executions", "quota_monthly_minutes", "quota_hard_cap").
+		Where("id = ?", tenantID).
+		First(&tenant).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("tenant not found")
+		}
+		return nil, fmt.Errorf("failed to get tenant: %w", err)
+	}
+
+	executions, minutes, err := measureUsage(ctx, m.db, tenantID, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &Usage{
+		TenantID:    tenantID,
+		Month:       start.Format(MonthFormat),
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Executions:  executions,
+		Minutes:     math.Round(minutes*100) / 100,
+		Budgets:     budgets(&tenant, executions, minutes),
+		HardCap:     tenant.QuotaHardCap,
+		Alerts:      []models.BudgetAlert{},
+	}
+	if err := m.db.WithContext(ctx).
+		Where("tenant_id = ? AND month = ?", tenantID, usage.Month).
+		Order("created_at ASC").
+		Find(&usage.Alerts).Error; err != nil {
+		return nil, fmt.Errorf("failed to get budget alerts: %w", err)
+	}
+
+	return usage, nil
+}
+
+// CheckExecutionBudget checks that a tenant with a hard cap has budget
+// left this month for another execution. Running executions are not
+// stopped when the minutes run out.
+func (c *QuotaChecker) CheckExecutionBudget(ctx context.Context, tenantID string) error {
+	var tenant budgetTenant
+	if err := c.db.WithContext(ctx).Model(&models.Tenant{}).
+		Select("quota_monthly_executions", "quota_monthly_minutes", "quota_hard_cap").
+		Where("id = ?", tenantID).
+		First(&tenant).Error; err != nil {
+		return fmt.Errorf("failed to get tenant quota: %w", err)
+	}
+	if !tenant.QuotaHardCap || (tenant.QuotaMonthlyExecutions <= 0 && tenant.QuotaMonthlyMinutes <= 0) {
+		return nil
+	}
+
+	start, end := monthPeriod(time.Now())
+	executions, minutes, err := measureUsage(ctx, c.db, tenantID, start, end)
+	if err != nil {
+		return err
+	}
+	for _, budget := range budgets(&tenant, executions, minutes) {
+		if budget.Exhausted {
+			return apperror.QuotaExceeded("monthly %s budget exhausted: %g/%d", budget.Metric, budget.Used, budget.Quota)
+		}
+	}
+	return nil
+}
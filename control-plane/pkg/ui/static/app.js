@@ -0,0 +1,407 @@
+// VM Manager dashboard. Plain DOM and fetch against /api/v1; the API token
+// is kept in this browser's localStorage and sent as a bearer token.
+(function () {
+  'use strict';
+
+  var API = '/api/v1';
+  var TOKEN_KEY = 'vmmanager.token';
+  var PAGE_SIZE = 50;
+  var REFRESH_MS = 5000;
+  var TERMINAL = { success: true, failed: true, cancelled: true, timeout: true, completed: true };
+
+  var viewEl = document.getElementById('view');
+  var errorEl = document.getElementById('error');
+  var tokenEl = document.getElementById('token');
+  var refreshTimer = null;
+  var renderSeq = 0;
+
+  // h creates an element; attrs set properties, children are nodes or text
+  function h(tag, attrs) {
+    var el = document.createElement(tag);
+    Object.keys(attrs || {}).forEach(function (k) {
+      if (k === 'class') el.className = attrs[k];
+      else if (k.indexOf('on') === 0) el.addEventListener(k.slice(2), attrs[k]);
+      else el[k] = attrs[k];
+    });
+    for (var i = 2; i < arguments.length; i++) append(el, arguments[i]);
+    return el;
+  }
+
+  function append(el, child) {
+    if (child == null || child === false) return;
+    if (Array.isArray(child)) { child.forEach(function (c) { append(el, c); }); return; }
+    el.appendChild(child instanceof Node ? child : document.createTextNode(String(child)));
+  }
+
+  function token() { return localStorage.getItem(TOKEN_KEY) || ''; }
+
+  function api(path, opts) {
+    opts = opts || {};
+    var headers = { Accept: 'application/json' };
+    if (token()) headers.Authorization = 'Bearer ' + token();
+    if (opts.body !== undefined) headers['Content-Type'] = 'application/json';
+    return fetch(API + path, {
+      method: opts.method || 'GET',
+      headers: headers,
+      body: opts.body !== undefined ? JSON.stringify(opts.body) : undefined,
+      credentials: 'omit'
+    }).then(function (res) {
+      return res.text().then(function (text) {
+        var data = text ? JSON.parse(text) : null;
+        if (!res.ok) {
+          var msg = data && (data.error && data.error.message || data.error) || res.statusText;
+          if (res.status === 401) msg = 'Not authorised: ' + msg + '. Paste an API token above.';
+          throw new Error(msg);
+        }
+        return data;
+      });
+    });
+  }
+
+  function query(params) {
+    var parts = Object.keys(params).filter(function (k) {
+      return params[k] !== '' && params[k] != null;
+    }).map(function (k) {
+      return encodeURIComponent(k) + '=' + encodeURIComponent(params[k]);
+    });
+    return parts.length ? '?' + parts.join('&') : '';
+  }
+
+  function showError(err) {
+    errorEl.hidden = !err;
+    errorEl.textContent = err ? err.message || String(err) : '';
+  }
+
+  function badge(status) { return h('span', { class: 'badge ' + (status || '') }, status || 'unknown'); }
+
+  function when(ts) {
+    if (!ts) return h('span', { class: 'muted' }, '—');
+    var d = new Date(ts);
+    return h('span', { title: d.toISOString() }, ago(d));
+  }
+
+  function ago(d) {
+    var s = Math.round((Date.now() - d.getTime()) / 1000);
+    if (s < 0) return d.toLocaleString();
+    if (s < 60) return s + 's ago';
+    if (s < 3600) return Math.floor(s / 60) + 'm ago';
+    if (s < 86400) return Math.floor(s / 3600) + 'h ago';
+    return d.toLocaleString();
+  }
+
+  function duration(start, end) {
+    if (!start) return '—';
+    var ms = (end ? new Date(end) : new Date()) - new Date(start);
+    if (ms < 1000) return ms + 'ms';
+    if (ms < 60000) return (ms / 1000).toFixed(1) + 's';
+    return Math.floor(ms / 60000) + 'm ' + Math.round((ms % 60000) / 1000) + 's';
+  }
+
+  function link(href, text) { return h('a', { href: href }, text); }
+
+  function table(headers, rows, empty) {
+    if (!rows.length) return h('p', { class: 'muted' }, empty || 'Nothing to show.');
+    return h('table', null,
+      h('thead', null, h('tr', null, headers.map(function (t) { return h('th', null, t); }))),
+      h('tbody', null, rows.map(function (cells) {
+        return h('tr', null, cells.map(function (c) { return h('td', null, c); }));
+      })));
+  }
+
+  // progressBar shows succeeded and failed agents as a share of the total
+  function progressBar(total, succeeded, failed) {
+    var bar = h('div', { class: 'progress' });
+    var done = h('div', { class: 'done' });
+    var fail = h('div', { class: 'fail' });
+    var okPct = total ? (100 * succeeded / total) : 0;
+    var failPct = total ? (100 * failed / total) : 0;
+    done.style.width = okPct + '%';
+    fail.style.left = okPct + '%';
+    fail.style.width = failPct + '%';
+    bar.appendChild(done);
+    bar.appendChild(fail);
+    var label = (succeeded + failed) + ' / ' + total + ' agents';
+    if (failed) label += ' (' + failed + ' failed)';
+    return h('div', null, bar, h('div', { class: 'progress-label' }, label));
+  }
+
+  function pager(total, offset, go) {
+    if (total <= PAGE_SIZE) return null;
+    return h('div', { class: 'pager' },
+      h('button', { disabled: offset === 0, onclick: function () { go(Math.max(0, offset - PAGE_SIZE)); } }, 'Previous'),
+      h('span', { class: 'muted' }, (offset + 1) + '–' + Math.min(total, offset + PAGE_SIZE) + ' of ' + total),
+      h('button', { disabled: offset + PAGE_SIZE >= total, onclick: function () { go(offset + PAGE_SIZE); } }, 'Next'));
+  }
+
+  function select(options, value, onchange) {
+    return h('select', { onchange: function (e) { onchange(e.target.value); } },
+      options.map(function (o) { return h('option', { value: o[0], selected: o[0] === value }, o[1]); }));
+  }
+
+  // Views. Each returns a promise of the node to show; state lives in the
+  // hash so views can be linked and refreshed.
+
+  var views = {};
+
+  views.agents = function (params) {
+    var offset = +params.offset || 0;
+    return api('/agents' + query({ status: params.status, drain_state: params.drain_state, limit: PAGE_SIZE, offset: offset }))
+      .then(function (data) {
+        var agents = data.agents || [];
+        return h('div', null,
+          h('h1', null, 'Agents'),
+          h('div', { class: 'toolbar' },
+            select([['', 'All statuses'], ['online', 'Online'], ['offline', 'Offline'], ['degraded', 'Degraded'], ['unknown', 'Unknown']],
+              params.status || '', function (v) { navigate('agents', { status: v, drain_state: params.drain_state }); }),
+            select([['', 'Any drain state'], ['none', 'Serving'], ['draining', 'Draining'], ['drained', 'Drained']],
+              params.drain_state || '', function (v) { navigate('agents', { status: params.status, drain_state: v }); }),
+            h('span', { class: 'spacer' }),
+            h('span', { class: 'muted' }, data.total + ' agents')),
+          table(['Hostname', 'Status', 'Drain', 'OS / Arch', 'Version', 'Last seen', 'ID'],
+            agents.map(function (a) {
+              return [a.hostname, badge(a.status), a.drain_state === 'none' ? '' : badge(a.drain_state),
+                [a.os, a.arch].filter(Boolean).join(' / '), a.version || '', when(a.last_seen_at),
+                link('#/executions?agent_id=' + encodeURIComponent(a.id), a.id)];
+            }), 'No agents have registered yet.'),
+          pager(data.total, offset, function (o) { navigate('agents', { status: params.status, drain_state: params.drain_state, offset: o }); }));
+      });
+  };
+
+  views.workflows = function (params) {
+    var offset = +params.offset || 0;
+    return api('/workflows' + query({ q: params.q, status: params.status, limit: PAGE_SIZE, offset: offset }))
+      .then(function (data) {
+        var search = h('input', { type: 'search', placeholder: 'Search workflows', value: params.q || '' });
+        return h('div', null,
+          h('h1', null, 'Workflows'),
+          h('form', { class: 'toolbar', onsubmit: function (e) { e.preventDefault(); navigate('workflows', { q: search.value, status: params.status }); } },
+            search,
+            select([['', 'All statuses'], ['draft', 'Draft'], ['active', 'Active'], ['deprecated', 'Deprecated']],
+              params.status || '', function (v) { navigate('workflows', { q: params.q, status: v }); }),
+            h('span', { class: 'spacer' }),
+            h('span', { class: 'muted' }, data.total + ' workflows')),
+          table(['Name', 'Status', 'Version', 'Updated', 'Executions'],
+            (data.workflows || []).map(function (w) {
+              return [h('div', null, w.name, w.description ? h('div', { class: 'muted' }, w.description) : null),
+                badge(w.status), 'v' + w.version, when(w.updated_at),
+                link('#/executions?workflow_id=' + encodeURIComponent(w.id), 'View executions')];
+            }), 'No workflows found.'),
+          pager(data.total, offset, function (o) { navigate('workflows', { q: params.q, status: params.status, offset: o }); }));
+      });
+  };
+
+  views.campaigns = function (params) {
+    if (params.id) return campaignDetail(params.id);
+    var offset = +params.offset || 0;
+    return api('/campaigns' + query({ status: params.status, limit: PAGE_SIZE, offset: offset }))
+      .then(function (data) {
+        var campaigns = data.campaigns || [];
+        // Progress is computed per campaign; drafts have none yet
+        return Promise.all(campaigns.map(function (c) {
+          if (c.status === 'draft') return null;
+          return api('/campaigns/' + encodeURIComponent(c.id) + '/progress').catch(function () { return null; });
+        })).then(function (progress) {
+          return h('div', null,
+            h('h1', null, 'Campaigns'),
+            h('div', { class: 'toolbar' },
+              select([['', 'All statuses'], ['draft', 'Draft'], ['running', 'Running'], ['paused', 'Paused'],
+                ['completed', 'Completed'], ['failed', 'Failed'], ['cancelled', 'Cancelled'], ['rolling_back', 'Rolling back']],
+              params.status || '', function (v) { navigate('campaigns', { status: v }); }),
+              h('span', { class: 'spacer' }),
+              h('span', { class: 'muted' }, data.total + ' campaigns')),
+            table(['Name', 'Status', 'Phase', 'Progress', 'Started'],
+              campaigns.map(function (c, i) {
+                var p = progress[i];
+                return [link('#/campaigns?id=' + encodeURIComponent(c.id), c.name), badge(c.status),
+                  p ? [p.current_phase || '', p.awaiting_approval ? h('div', null, badge('awaiting approval')) : null] : '',
+                  p ? progressBar(p.total_agents, p.successful_agents, p.failed_agents) : h('span', { class: 'muted' }, 'Not started'),
+                  when(c.started_at)];
+              }), 'No campaigns found.'),
+            pager(data.total, offset, function (o) { navigate('campaigns', { status: params.status, offset: o }); }));
+        });
+      });
+  };
+
+  function campaignDetail(id) {
+    var path = '/campaigns/' + encodeURIComponent(id);
+    return Promise.all([api(path), api(path + '/progress').catch(function () { return null; })])
+      .then(function (res) {
+        var c = res[0];
+        var p = res[1];
+        var phases = (c.phases || []).slice().sort(function (a, b) { return a.phase_order - b.phase_order; });
+        if (!TERMINAL[c.status] && c.status !== 'draft') scheduleRefresh();
+
+        var actions = [];
+        if (c.status === 'draft' || c.status === 'paused') actions.push(action('Start', path + '/start'));
+        if (c.status === 'running') actions.push(action('Pause', path + '/pause'));
+        if (!TERMINAL[c.status]) actions.push(action('Cancel', path + '/cancel', 'Cancel this campaign?'));
+
+        return h('div', null,
+          h('p', null, link('#/campaigns', '← Campaigns')),
+          h('h1', null, c.name, ' ', badge(c.status)),
+          c.description ? h('p', { class: 'muted' }, c.description) : null,
+          h('div', { class: 'toolbar' }, actions),
+          p ? h('div', { class: 'cards' },
+            card('Agents', p.total_agents), card('Succeeded', p.successful_agents),
+            card('Failed', p.failed_agents), card('Success rate', (p.success_rate || 0).toFixed(1) + '%')) : null,
+          p ? progressBar(p.total_agents, p.successful_agents, p.failed_agents) : null,
+          h('h2', null, 'Phases'),
+          table(['#', 'Phase', 'Status', 'Progress', 'Started', ''],
+            phases.map(function (ph) {
+              var approve = ph.status === 'awaiting_approval'
+                ? action('Approve', path + '/phases/' + encodeURIComponent(ph.phase_name) + '/approve', 'Approve phase ' + ph.phase_name + '?')
+                : null;
+              return [ph.phase_order + 1, ph.phase_name, badge(ph.status),
+                progressBar(ph.target_count, ph.success_count, ph.failure_count), when(ph.started_at), approve];
+            }), 'Phases are created when the campaign starts.'),
+          p && p.deployment ? deploymentSummary(p.deployment) : null,
+          h('h2', null, 'Executions'),
+          h('p', null, link('#/executions?campaign_id=' + encodeURIComponent(c.id), 'View this campaign\'s executions')));
+      });
+  }
+
+  // deploymentSummary shows the per-agent file changes of a template_deploy campaign
+  function deploymentSummary(d) {
+    return h('div', null,
+      h('h2', null, 'Deployment'),
+      h('div', { class: 'cards' },
+        card('Created', d.created), card('Updated', d.updated), card('Unchanged', d.unchanged),
+        card('Drifted', d.drifted), card('Failed', d.failed)),
+      table(['Agent', 'Status', 'File', 'Diff', ''],
+        (d.agents || []).map(function (a) {
+          return [a.agent_id, badge(a.status), a.drifted ? badge('drifted') : (a.file_status || ''),
+            h('span', { class: 'mono' }, '+' + a.lines_added + ' −' + a.lines_removed),
+            link('#/executions?id=' + encodeURIComponent(a.execution_id), a.error ? a.error : 'View diff')];
+        }), 'No agent has finished yet.'));
+  }
+
+  function card(label, value) {
+    return h('div', { class: 'card' }, h('div', { class: 'value' }, value), h('div', { class: 'label' }, label));
+  }
+
+  function action(label, path, confirmText) {
+    return h('button', {
+      class: 'primary',
+      onclick: function () {
+        if (confirmText && !window.confirm(confirmText)) return;
+        api(path, { method: 'POST', body: {} }).then(render, showError);
+      }
+    }, label);
+  }
+
+  views.executions = function (params) {
+    if (params.id) return executionDetail(params.id);
+    var offset = +params.offset || 0;
+    var filter = { workflow_id: params.workflow_id, agent_id: params.agent_id, campaign_id: params.campaign_id, status: params.status };
+    return api('/executions' + query(Object.assign({ limit: PAGE_SIZE, offset: offset }, filter)))
+      .then(function (data) {
+        var executions = data.executions || [];
+        if (executions.some(function (e) { return !TERMINAL[e.status]; })) scheduleRefresh();
+        var filters = ['workflow_id', 'agent_id', 'campaign_id', 'status'].filter(function (k) { return params[k]; });
+        return h('div', null,
+          h('h1', null, 'Executions'),
+          h('div', { class: 'toolbar' },
+            filters.map(function (k) { return h('span', { class: 'badge' }, k + ': ' + params[k]); }),
+            filters.length ? link('#/executions', 'Clear filters') : null,
+            h('span', { class: 'spacer' }),
+            h('span', { class: 'muted' }, data.total + ' executions')),
+          table(['Execution', 'Workflow', 'Agent', 'Status', 'Created', 'Duration'],
+            executions.map(function (e) {
+              return [link('#/executions?id=' + encodeURIComponent(e.id), e.id),
+                e.workflow && e.workflow.name || e.workflow_id, e.agent && e.agent.hostname || e.agent_id,
+                badge(e.status), when(e.created_at), duration(e.started_at, e.completed_at)];
+            }), 'No executions found.'),
+          pager(data.total, offset, function (o) { navigate('executions', Object.assign({ offset: o }, filter)); }));
+      });
+  };
+
+  function executionDetail(id) {
+    return api('/executions/' + encodeURIComponent(id)).then(function (e) {
+      if (!TERMINAL[e.status]) scheduleRefresh();
+      var result = e.result || {};
+      var steps = result.steps || [];
+      return h('div', null,
+        h('p', null, link('#/executions', '← Executions')),
+        h('h1', null, 'Execution ', h('span', { class: 'mono' }, e.id), ' ', badge(e.status)),
+        h('div', { class: 'cards' },
+          card('Workflow', e.workflow && e.workflow.name || e.workflow_id),
+          card('Agent', e.agent && e.agent.hostname || e.agent_id),
+          card('Duration', duration(e.started_at, e.completed_at)),
+          card('Started', e.started_at ? new Date(e.started_at).toLocaleString() : '—')),
+        result.error ? h('div', { class: 'error' }, result.error) : null,
+        h('h2', null, 'Steps'),
+        steps.length ? steps.map(function (s) {
+          return h('div', { class: 'step' },
+            h('div', { class: 'step-head' },
+              h('strong', null, s.step_name || s.step_id), badge(s.status),
+              h('span', { class: 'muted' }, 'exit ' + s.exit_code),
+              h('span', { class: 'muted' }, duration(s.started_at, s.ended_at)),
+              s.retry_count ? h('span', { class: 'muted' }, s.retry_count + ' retries') : null),
+            s.output ? h('pre', { class: 'log' }, s.output) : h('div', { class: 'muted' }, 'No output.'),
+            s.error ? h('pre', { class: 'log stderr' }, s.error) : null);
+        }) : h('p', { class: 'muted' }, TERMINAL[e.status] ? 'The agent reported no step results.' : 'Waiting for the agent to report results…'));
+    });
+  }
+
+  // Routing
+
+  function parseHash() {
+    var hash = location.hash.replace(/^#\/?/, '');
+    var q = hash.indexOf('?');
+    var name = (q < 0 ? hash : hash.slice(0, q)) || 'agents';
+    var params = {};
+    if (q >= 0) {
+      hash.slice(q + 1).split('&').forEach(function (pair) {
+        var kv = pair.split('=');
+        if (kv[0]) params[decodeURIComponent(kv[0])] = decodeURIComponent(kv[1] || '');
+      });
+    }
+    return { name: views[name] ? name : 'agents', params: params };
+  }
+
+  function navigate(name, params) {
+    location.hash = '#/' + name + query(params || {});
+  }
+
+  function scheduleRefresh() {
+    clearTimeout(refreshTimer);
+    refreshTimer = setTimeout(function () {
+      if (!document.hidden) render();
+      else scheduleRefresh();
+    }, REFRESH_MS);
+  }
+
+  function render() {
+    clearTimeout(refreshTimer);
+    var route = parseHash();
+    var seq = ++renderSeq;
+    document.querySelectorAll('nav a').forEach(function (a) {
+      a.classList.toggle('active', a.getAttribute('data-view') === route.name);
+    });
+    if (!token()) {
+      showError(null);
+      viewEl.replaceChildren(h('p', { class: 'muted' }, 'Paste an API token above to load the dashboard.'));
+      return;
+    }
+    views[route.name](route.params).then(function (node) {
+      if (seq !== renderSeq) return;
+      showError(null);
+      viewEl.replaceChildren(node);
+    }, function (err) {
+      if (seq === renderSeq) showError(err);
+    });
+  }
+
+  document.getElementById('token-form').addEventListener('submit', function (e) {
+    e.preventDefault();
+    if (tokenEl.value) localStorage.setItem(TOKEN_KEY, tokenEl.value.trim());
+    tokenEl.value = '';
+    render();
+  });
+  document.getElementById('sign-out').addEventListener('click', function () {
+    localStorage.removeItem(TOKEN_KEY);
+    render();
+  });
+  window.addEventListener('hashchange', render);
+  render();
+})();
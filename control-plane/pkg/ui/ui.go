@@ -0,0 +1,42 @@
+// Package ui serves the control plane's embedded web dashboard.
+//
+// The dashboard is a static single-page application that talks to the REST
+// API with a token the user pastes in; it has no server-side state of its own.
+package ui
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Path is the URL prefix the dashboard is served under
+const Path = "/ui"
+
+//go:embed static
+var assets embed.FS
+
+// contentSecurityPolicy limits the dashboard to its own assets and the API
+const contentSecurityPolicy = "default-src 'self'; connect-src 'self'; img-src 'self' data:; " +
+	"frame-ancestors 'none'; base-uri 'none'; form-action 'none'"
+
+// Register serves the dashboard under Path. Requests for /ui are redirected
+// to /ui/ by the router.
+func Register(router gin.IRoutes) {
+	static, err := fs.Sub(assets, "static")
+	if err != nil {
+		panic(err) // the embedded directory is fixed at build time
+	}
+	files := http.StripPrefix(Path, http.FileServer(http.FS(static)))
+
+	router.GET(Path+"/*filepath", func(c *gin.Context) {
+		c.Header("Content-Security-Policy", contentSecurityPolicy)
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Header("Referrer-Policy", "no-referrer")
+		// Assets are not versioned, so revalidate to pick up upgrades
+		c.Header("Cache-Control", "no-cache")
+		files.ServeHTTP(c.Writer, c.Request)
+	})
+}
@@ -0,0 +1,653 @@
+package vulnerability
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// Feed formats
+const (
+	FormatOSV = "osv"
+	FormatNVD = "nvd"
+)
+
+// maxFeedBytes bounds a downloaded feed document
+const maxFeedBytes = 1 << 30
+
+// importBatchSize is the number of advisories stored per transaction
+const importBatchSize = 200
+
+// nvdPageDelay spaces requests for NVD API pages within its rate limit;
+// requests with an API key may be ten times as frequent
+const nvdPageDelay = 6 * time.Second
+
+// Feed is an advisory feed. OSV feeds are a record, a JSON array of
+// records or a zip of record files, such as the per-ecosystem all.zip
+// exports; NVD feeds are CVE API 2.0 responses, followed across pages.
+// URLs may be http(s) or file:// for mirrored feeds.
+type Feed struct {
+	Name   string `mapstructure:"name" json:"name"`
+	Format string `mapstructure:"format" json:"format"`
+	URL    string `mapstructure:"url" json:"url"`
+	// APIKey is sent to the NVD API to raise its rate limit
+	APIKey string `mapstructure:"api_key" json:"-"`
+}
+
+// SyncResult summarizes the import of a feed
+type SyncResult struct {
+	Feed      string `json:"feed"`
+	Fetched   int    `json:"fetched"`
+	Imported  int    `json:"imported"`
+	Unchanged int    `json:"unchanged"`
+	Withdrawn int    `json:"withdrawn"`
+}
+
+// RunFeedSync imports every feed periodically until the context is
+// cancelled
+func (m *Manager) RunFeedSync(ctx context.Context) {
+	if len(m.config.Feeds) == 0 {
+		m.logger.Info("no advisory feeds configured")
+		return
+	}
+
+	ticker := time.NewTicker(m.config.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, feed := range m.config.Feeds {
+			result, err := m.SyncFeed(ctx, feed)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				m.logger.Error("failed to sync advisory feed",
+					zap.String("feed", feed.Name),
+					zap.Error(err))
+				continue
+			}
+			m.logger.Info("advisory feed synced",
+				zap.String("feed", result.Feed),
+				zap.Int("fetched", result.Fetched),
+				zap.Int("imported", result.Imported),
+				zap.Int("withdrawn", result.Withdrawn))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SyncFeed downloads a feed and stores its new and modified advisories
+func (m *Manager) SyncFeed(ctx context.Context, feed Feed) (*SyncResult, error) {
+	var advisories []parsedAdvisory
+	var err error
+	switch feed.Format {
+	case FormatOSV:
+		advisories, err = m.fetchOSV(ctx, feed)
+	case FormatNVD:
+		advisories, err = m.fetchNVD(ctx, feed)
+	default:
+		return nil, fmt.Errorf("feed %s: unknown format %q", feed.Name, feed.Format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("feed %s: %w", feed.Name, err)
+	}
+
+	result := &SyncResult{Feed: feed.Name, Fetched: len(advisories)}
+	for start := 0; start < len(advisories); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(advisories) {
+			end = len(advisories)
+		}
+		if err := m.store(ctx, advisories[start:end], result); err != nil {
+			return nil, fmt.Errorf("feed %s: %w", feed.Name, err)
+		}
+	}
+	return result, nil
+}
+
+// parsedAdvisory is an advisory read from a feed; withdrawn advisories are
+// deleted
+type parsedAdvisory struct {
+	advisory  models.Advisory
+	withdrawn bool
+}
+
+// store saves a batch of advisories that are new or modified since stored,
+// replacing their ranges
+func (m *Manager) store(ctx context.Context, batch []parsedAdvisory, result *SyncResult) error {
+	ids := make([]string, len(batch))
+	for i := range batch {
+		ids[i] = batch[i].advisory.ID
+	}
+	var stored []models.Advisory
+	if err := m.db.WithContext(ctx).Select("id", "modified_at").
+		Where("id IN ?", ids).Find(&stored).Error; err != nil {
+		return fmt.Errorf("failed to load advisories: %w", err)
+	}
+	modified := make(map[string]time.Time, len(stored))
+	for _, advisory := range stored {
+		modified[advisory.ID] = advisory.ModifiedAt
+	}
+
+	var changed []models.Advisory
+	var withdrawn []string
+	for i := range batch {
+		advisory := &batch[i].advisory
+		previous, exists := modified[advisory.ID]
+		switch {
+		case batch[i].withdrawn:
+			if exists {
+				withdrawn = append(withdrawn, advisory.ID)
+			}
+		case exists && !advisory.ModifiedAt.Truncate(time.Second).After(previous):
+			result.Unchanged++
+		case len(advisory.Ranges) == 0:
+			// Only advisories naming affected package versions can match
+		default:
+			changed = append(changed, *advisory)
+		}
+	}
+	if len(changed) == 0 && len(withdrawn) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	changedIDs := make([]string, len(changed))
+	var ranges []models.AdvisoryRange
+	for i := range changed {
+		changed[i].ImportedAt = now
+		changedIDs[i] = changed[i].ID
+		ranges = append(ranges, changed[i].Ranges...)
+		changed[i].Ranges = nil
+	}
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if len(withdrawn) > 0 {
+			if err := tx.Where("id IN ?", withdrawn).Delete(&models.Advisory{}).Error; err != nil {
+				return fmt.Errorf("failed to delete withdrawn advisories: %w", err)
+			}
+		}
+		if len(changed) == 0 {
+			return nil
+		}
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&changed).Error; err != nil {
+			return fmt.Errorf("failed to store advisories: %w", err)
+		}
+		if err := tx.Where("advisory_id IN ?", changedIDs).Delete(&models.AdvisoryRange{}).Error; err != nil {
+			return fmt.Errorf("failed to replace advisory ranges: %w", err)
+		}
+		if err := tx.CreateInBatches(ranges, 500).Error; err != nil {
+			return fmt.Errorf("failed to store advisory ranges: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	result.Imported += len(changed)
+	result.Withdrawn += len(withdrawn)
+	return nil
+}
+
+// fetch reads a feed document from an http(s) or file URL
+func (m *Manager) fetch(ctx context.Context, rawURL string, header http.Header) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid feed URL: %w", err)
+	}
+	switch u.Scheme {
+	case "file":
+		return os.ReadFile(u.Path)
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported feed URL scheme %q", u.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create feed request: %w", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download feed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed download returned status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download feed: %w", err)
+	}
+	if len(data) > maxFeedBytes {
+		return nil, fmt.Errorf("feed exceeds %d bytes", maxFeedBytes)
+	}
+	return data, nil
+}
+
+// osvRecord is the part of an OSV record advisories are built from
+type osvRecord struct {
+	ID        string     `json:"id"`
+	Modified  time.Time  `json:"modified"`
+	Published *time.Time `json:"published"`
+	Withdrawn *time.Time `json:"withdrawn"`
+	Aliases   []string   `json:"aliases"`
+	Summary   string     `json:"summary"`
+	Details   string     `json:"details"`
+	Severity  []struct {
+		Type  string `json:"type"`
+		Score string `json:"score"`
+	} `json:"severity"`
+	Affected []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		Ranges []struct {
+			Type   string              `json:"type"`
+			Events []map[string]string `json:"events"`
+		} `json:"ranges"`
+		Versions         []string               `json:"versions"`
+		DatabaseSpecific map[string]interface{} `json:"database_specific"`
+	} `json:"affected"`
+	References []struct {
+		URL string `json:"url"`
+	} `json:"references"`
+	DatabaseSpecific map[string]interface{} `json:"database_specific"`
+}
+
+// osvEcosystems maps the OSV ecosystems of distributions agents list
+// packages from to the package manager and the os-release ID of their
+// releases
+var osvEcosystems = map[string]struct{ source, distro string }{
+	"Debian":      {"dpkg", "debian"},
+	"Ubuntu":      {"dpkg", "ubuntu"},
+	"AlmaLinux":   {"rpm", "almalinux"},
+	"Rocky Linux": {"rpm", "rocky"},
+	"Red Hat":     {"rpm", "rhel"},
+	"openSUSE":    {"rpm", "opensuse-leap"},
+	"SUSE":        {"rpm", "sles"},
+	"Mageia":      {"rpm", "mageia"},
+}
+
+// fetchOSV downloads and parses an OSV feed
+func (m *Manager) fetchOSV(ctx context.Context, feed Feed) ([]parsedAdvisory, error) {
+	data, err := m.fetch(ctx, feed.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var documents [][]byte
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid OSV archive: %w", err)
+		}
+		for _, file := range archive.File {
+			if !strings.HasSuffix(file.Name, ".json") {
+				continue
+			}
+			content, err := readZipFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("invalid OSV archive entry %s: %w", file.Name, err)
+			}
+			documents = append(documents, content)
+		}
+	} else {
+		documents = [][]byte{data}
+	}
+
+	var advisories []parsedAdvisory
+	for _, document := range documents {
+		var records []osvRecord
+		trimmed := bytes.TrimSpace(document)
+		if bytes.HasPrefix(trimmed, []byte("[")) {
+			err = json.Unmarshal(trimmed, &records)
+		} else {
+			var record osvRecord
+			err = json.Unmarshal(trimmed, &record)
+			records = []osvRecord{record}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid OSV record: %w", err)
+		}
+		for i := range records {
+			if records[i].ID != "" {
+				advisories = append(advisories, osvAdvisory(&records[i]))
+			}
+		}
+	}
+	return advisories, nil
+}
+
+// readZipFile reads a file of a zip archive
+func readZipFile(file *zip.File) ([]byte, error) {
+	r, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(io.LimitReader(r, maxFeedBytes))
+}
+
+// osvAdvisory converts an OSV record. Ranges are kept for the ecosystems
+// agents list packages from; ECOSYSTEM and SEMVER ranges are used, and the
+// listed versions when a package has none.
+func osvAdvisory(record *osvRecord) parsedAdvisory {
+	advisory := models.Advisory{
+		ID:          record.ID,
+		Source:      FormatOSV,
+		CVEID:       cveOf(record.ID, record.Aliases),
+		Aliases:     record.Aliases,
+		Summary:     firstNonEmpty(record.Summary, record.Details),
+		Severity:    models.AdvisorySeverityUnknown,
+		PublishedAt: record.Published,
+		ModifiedAt:  record.Modified,
+	}
+	for _, ref := range record.References {
+		advisory.References = append(advisory.References, ref.URL)
+	}
+
+	severities := []interface{}{record.DatabaseSpecific["severity"]}
+	for _, severity := range record.Severity {
+		if !strings.HasPrefix(severity.Type, "CVSS") {
+			severities = append(severities, severity.Score)
+		}
+	}
+
+	for _, affected := range record.Affected {
+		ecosystem, release, _ := strings.Cut(affected.Package.Ecosystem, ":")
+		target, ok := osvEcosystems[ecosystem]
+		if !ok || affected.Package.Name == "" {
+			continue
+		}
+		if release != "" {
+			// Ubuntu:22.04:LTS and similar qualify the release
+			release, _, _ = strings.Cut(release, ":")
+			release = target.distro + ":" + release
+		}
+		severities = append(severities, affected.DatabaseSpecific["severity"], affected.DatabaseSpecific["urgency"])
+
+		base := models.AdvisoryRange{
+			AdvisoryID: record.ID,
+			Source:     target.source,
+			Name:       affected.Package.Name,
+			Release:    release,
+		}
+		found := false
+		for _, r := range affected.Ranges {
+			if r.Type != "ECOSYSTEM" && r.Type != "SEMVER" {
+				continue
+			}
+			var open *models.AdvisoryRange
+			for _, event := range r.Events {
+				switch {
+				case event["introduced"] != "":
+					next := base
+					next.Introduced = event["introduced"]
+					open = &next
+				case open != nil && event["fixed"] != "":
+					open.Fixed = event["fixed"]
+					advisory.Ranges = append(advisory.Ranges, *open)
+					open, found = nil, true
+				case open != nil && event["last_affected"] != "":
+					open.LastAffected = event["last_affected"]
+					advisory.Ranges = append(advisory.Ranges, *open)
+					open, found = nil, true
+				}
+			}
+			if open != nil {
+				advisory.Ranges = append(advisory.Ranges, *open)
+				found = true
+			}
+		}
+		if !found {
+			for _, version := range affected.Versions {
+				exact := base
+				exact.Introduced, exact.LastAffected = version, version
+				advisory.Ranges = append(advisory.Ranges, exact)
+			}
+		}
+	}
+
+	for _, severity := range severities {
+		if s, ok := severity.(string); ok {
+			if level := normalizeSeverity(s); level != models.AdvisorySeverityUnknown {
+				advisory.Severity = level
+				break
+			}
+		}
+	}
+	return parsedAdvisory{advisory: advisory, withdrawn: record.Withdrawn != nil}
+}
+
+// nvdResponse is the part of an NVD CVE API 2.0 response advisories are
+// built from
+type nvdResponse struct {
+	ResultsPerPage  int `json:"resultsPerPage"`
+	StartIndex      int `json:"startIndex"`
+	TotalResults    int `json:"totalResults"`
+	Vulnerabilities []struct {
+		CVE nvdCVE `json:"cve"`
+	} `json:"vulnerabilities"`
+}
+
+type nvdCVE struct {
+	ID           string `json:"id"`
+	Published    string `json:"published"`
+	LastModified string `json:"lastModified"`
+	VulnStatus   string `json:"vulnStatus"`
+	Descriptions []struct {
+		Lang  string `json:"lang"`
+		Value string `json:"value"`
+	} `json:"descriptions"`
+	Metrics struct {
+		V31 []nvdMetric `json:"cvssMetricV31"`
+		V30 []nvdMetric `json:"cvssMetricV30"`
+	} `json:"metrics"`
+	Configurations []struct {
+		Nodes []struct {
+			CPEMatch []struct {
+				Vulnerable            bool   `json:"vulnerable"`
+				Criteria              string `json:"criteria"`
+				VersionStartIncluding string `json:"versionStartIncluding"`
+				VersionStartExcluding string `json:"versionStartExcluding"`
+				VersionEndIncluding   string `json:"versionEndIncluding"`
+				VersionEndExcluding   string `json:"versionEndExcluding"`
+			} `json:"cpeMatch"`
+		} `json:"nodes"`
+	} `json:"configurations"`
+	References []struct {
+		URL string `json:"url"`
+	} `json:"references"`
+}
+
+type nvdMetric struct {
+	CVSSData struct {
+		BaseScore    float64 `json:"baseScore"`
+		BaseSeverity string  `json:"baseSeverity"`
+	} `json:"cvssData"`
+}
+
+// nvdTimeLayout is the format of NVD timestamps, which are UTC
+const nvdTimeLayout = "2006-01-02T15:04:05.000"
+
+// fetchNVD downloads and parses an NVD feed, following its pages
+func (m *Manager) fetchNVD(ctx context.Context, feed Feed) ([]parsedAdvisory, error) {
+	header := http.Header{}
+	delay := nvdPageDelay
+	if feed.APIKey != "" {
+		header.Set("apiKey", feed.APIKey)
+		delay /= 10
+	}
+
+	var advisories []parsedAdvisory
+	pageURL := feed.URL
+	for {
+		data, err := m.fetch(ctx, pageURL, header)
+		if err != nil {
+			return nil, err
+		}
+		var page nvdResponse
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("invalid NVD response: %w", err)
+		}
+		for i := range page.Vulnerabilities {
+			if advisory, ok := nvdAdvisory(&page.Vulnerabilities[i].CVE); ok {
+				advisories = append(advisories, advisory)
+			}
+		}
+
+		next := page.StartIndex + len(page.Vulnerabilities)
+		if len(page.Vulnerabilities) == 0 || next >= page.TotalResults || strings.HasPrefix(feed.URL, "file:") {
+			return advisories, nil
+		}
+		u, err := url.Parse(feed.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid feed URL: %w", err)
+		}
+		query := u.Query()
+		query.Set("startIndex", strconv.Itoa(next))
+		u.RawQuery = query.Encode()
+		pageURL = u.String()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// nvdAdvisory converts an NVD CVE. Vulnerable application CPEs become
+// ranges of the package named like the CPE product, for any package
+// manager; CPEs matching every version are left out, as they would flag
+// every installed version.
+func nvdAdvisory(cve *nvdCVE) (parsedAdvisory, bool) {
+	if cve.ID == "" {
+		return parsedAdvisory{}, false
+	}
+	modified, err := time.Parse(nvdTimeLayout, cve.LastModified)
+	if err != nil {
+		return parsedAdvisory{}, false
+	}
+	advisory := models.Advisory{
+		ID:         cve.ID,
+		Source:     FormatNVD,
+		CVEID:      cveOf(cve.ID, nil),
+		Severity:   models.AdvisorySeverityUnknown,
+		ModifiedAt: modified,
+	}
+	if published, err := time.Parse(nvdTimeLayout, cve.Published); err == nil {
+		advisory.PublishedAt = &published
+	}
+	for _, description := range cve.Descriptions {
+		if description.Lang == "en" {
+			advisory.Summary = description.Value
+			break
+		}
+	}
+	for _, ref := range cve.References {
+		advisory.References = append(advisory.References, ref.URL)
+	}
+	for _, metrics := range [][]nvdMetric{cve.Metrics.V31, cve.Metrics.V30} {
+		if len(metrics) > 0 {
+			score := metrics[0].CVSSData.BaseScore
+			advisory.CVSSScore = &score
+			advisory.Severity = normalizeSeverity(metrics[0].CVSSData.BaseSeverity)
+			break
+		}
+	}
+
+	seen := make(map[models.AdvisoryRange]bool)
+	for _, configuration := range cve.Configurations {
+		for _, node := range configuration.Nodes {
+			for _, match := range node.CPEMatch {
+				parts := strings.Split(match.Criteria, ":")
+				if !match.Vulnerable || len(parts) < 6 || parts[2] != "a" {
+					continue
+				}
+				r := models.AdvisoryRange{
+					AdvisoryID:   cve.ID,
+					Name:         strings.ToLower(strings.ReplaceAll(parts[4], `\`, "")),
+					Introduced:   firstNonEmpty(match.VersionStartIncluding, match.VersionStartExcluding),
+					Fixed:        match.VersionEndExcluding,
+					LastAffected: match.VersionEndIncluding,
+				}
+				if version := strings.ReplaceAll(parts[5], `\`, ""); version != "*" && version != "-" {
+					r.Introduced, r.LastAffected = version, version
+				}
+				if r.Introduced == "" && r.Fixed == "" && r.LastAffected == "" {
+					continue
+				}
+				if !seen[r] {
+					seen[r] = true
+					advisory.Ranges = append(advisory.Ranges, r)
+				}
+			}
+		}
+	}
+
+	return parsedAdvisory{advisory: advisory, withdrawn: cve.VulnStatus == "Rejected"}, true
+}
+
+// cveOf returns the CVE an advisory is about: its ID or first CVE alias
+func cveOf(id string, aliases []string) string {
+	for _, candidate := range append([]string{id}, aliases...) {
+		if strings.HasPrefix(candidate, "CVE-") && len(candidate) <= 32 {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// normalizeSeverity maps the severities and urgencies of advisory
+// databases to the advisory severities
+func normalizeSeverity(severity string) models.AdvisorySeverity {
+	switch strings.ToLower(strings.TrimSpace(severity)) {
+	case "critical":
+		return models.AdvisorySeverityCritical
+	case "high", "important":
+		return models.AdvisorySeverityHigh
+	case "medium", "moderate":
+		return models.AdvisorySeverityMedium
+	case "low", "negligible", "unimportant":
+		return models.AdvisorySeverityLow
+	}
+	return models.AdvisorySeverityUnknown
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,422 @@
+// Package vulnerability matches agents' software inventory against security
+// advisories ingested from OSV and NVD feeds. Matching records a finding for
+// each affected package on each agent; findings resolve once the package is
+// upgraded or removed, and affected agents can be patched by a generated
+// campaign.
+package vulnerability
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/campaign"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// matchBatchSize is the number of package names matched at once
+const matchBatchSize = 200
+
+// maxFindings bounds the findings returned by ListFindings
+const maxFindings = 1000
+
+// Config configures advisory ingestion and matching
+type Config struct {
+	Feeds []Feed `json:"feeds" yaml:"feeds"`
+	// SyncInterval is how often the feeds are imported
+	SyncInterval time.Duration `json:"sync_interval" yaml:"sync_interval"`
+	// MatchInterval is how often inventories are matched against the
+	// advisories
+	MatchInterval time.Duration `json:"match_interval" yaml:"match_interval"`
+}
+
+// DefaultConfig returns the default ingestion and matching configuration
+func DefaultConfig() *Config {
+	return &Config{
+		SyncInterval:  12 * time.Hour,
+		MatchInterval: time.Hour,
+	}
+}
+
+// Manager ingests advisories, matches them against agents' software and
+// reports the findings
+type Manager struct {
+	db         *gorm.DB
+	campaigns  *campaign.Manager
+	config     *Config
+	httpClient *http.Client
+	logger     *zap.Logger
+}
+
+// NewManager creates a vulnerability manager
+func NewManager(db *gorm.DB, campaigns *campaign.Manager, config *Config, logger *zap.Logger) *Manager {
+	defaults := DefaultConfig()
+	cfg := *config
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = defaults.SyncInterval
+	}
+	if cfg.MatchInterval <= 0 {
+		cfg.MatchInterval = defaults.MatchInterval
+	}
+
+	return &Manager{
+		db:        db,
+		campaigns: campaigns,
+		config:    &cfg,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Minute,
+		},
+		logger: logger,
+	}
+}
+
+// MatchResult summarizes a matching run
+type MatchResult struct {
+	Packages int   `json:"packages"`
+	Open     int   `json:"open"`
+	Resolved int64 `json:"resolved"`
+}
+
+// RunMatching matches inventories against advisories periodically until the
+// context is cancelled
+func (m *Manager) RunMatching(ctx context.Context) {
+	ticker := time.NewTicker(m.config.MatchInterval)
+	defer ticker.Stop()
+
+	for {
+		result, err := m.Match(ctx, time.Now())
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			m.logger.Error("vulnerability matching failed", zap.Error(err))
+		} else if result.Open > 0 || result.Resolved > 0 {
+			m.logger.Info("vulnerability matching finished",
+				zap.Int("packages", result.Packages),
+				zap.Int("open", result.Open),
+				zap.Int64("resolved", result.Resolved))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Match matches every agent's installed packages against the advisory
+// ranges of packages with their name, opening or refreshing the findings
+// of affected packages, and resolves the open findings it no longer finds.
+// A range for a distribution release only applies to agents reporting that
+// release.
+func (m *Manager) Match(ctx context.Context, now time.Time) (*MatchResult, error) {
+	now = now.Truncate(time.Second)
+	var names []string
+	if err := m.db.WithContext(ctx).Model(&models.AdvisoryRange{}).
+		Distinct("advisory_ranges.name").
+		Joins("JOIN agent_software ON agent_software.name = advisory_ranges.name").
+		Pluck("advisory_ranges.name", &names).Error; err != nil {
+		return nil, fmt.Errorf("failed to find advisory packages: %w", err)
+	}
+
+	result := &MatchResult{}
+	releases := make(map[string]string)
+	for start := 0; start < len(names); start += matchBatchSize {
+		end := start + matchBatchSize
+		if end > len(names) {
+			end = len(names)
+		}
+		findings, packages, err := m.matchNames(ctx, names[start:end], releases, now)
+		if err != nil {
+			return nil, err
+		}
+		result.Packages += packages
+		if len(findings) == 0 {
+			continue
+		}
+		// last_seen_at never moves back, so a replica matching concurrently
+		// with an earlier now cannot get another's findings resolved
+		if err := m.db.WithContext(ctx).Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "open_key"}},
+			DoUpdates: clause.Set{
+				{Column: clause.Column{Name: "severity"}, Value: gorm.Expr("VALUES(severity)")},
+				{Column: clause.Column{Name: "installed_version"}, Value: gorm.Expr("VALUES(installed_version)")},
+				{Column: clause.Column{Name: "fixed_version"}, Value: gorm.Expr("VALUES(fixed_version)")},
+				{Column: clause.Column{Name: "last_seen_at"}, Value: gorm.Expr("GREATEST(last_seen_at, VALUES(last_seen_at))")},
+			},
+		}).CreateInBatches(findings, 500).Error; err != nil {
+			return nil, fmt.Errorf("failed to record vulnerability findings: %w", err)
+		}
+		result.Open += len(findings)
+	}
+
+	resolved := m.db.WithContext(ctx).Model(&models.VulnerabilityFinding{}).
+		Where("status = ? AND last_seen_at < ?", models.FindingStatusOpen, now).
+		Updates(map[string]interface{}{
+			"status":      models.FindingStatusResolved,
+			"open_key":    nil,
+			"resolved_at": now,
+		})
+	if resolved.Error != nil {
+		return nil, fmt.Errorf("failed to resolve vulnerability findings: %w", resolved.Error)
+	}
+	result.Resolved = resolved.RowsAffected
+	return result, nil
+}
+
+// matchNames returns the findings for the installed packages with the given
+// names, and how many packages were checked. releases caches the
+// distribution releases of agents.
+func (m *Manager) matchNames(ctx context.Context, names []string, releases map[string]string, now time.Time) ([]models.VulnerabilityFinding, int, error) {
+	var ranges []struct {
+		models.AdvisoryRange
+		CVEID    string `gorm:"column:cve_id"`
+		Severity models.AdvisorySeverity
+	}
+	if err := m.db.WithContext(ctx).Model(&models.AdvisoryRange{}).
+		Select("advisory_ranges.*, advisories.cve_id, advisories.severity").
+		Joins("JOIN advisories ON advisories.id = advisory_ranges.advisory_id").
+		Where("advisory_ranges.name IN ?", names).
+		Find(&ranges).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to load advisory ranges: %w", err)
+	}
+	byName := make(map[string][]int)
+	for i := range ranges {
+		name := strings.ToLower(ranges[i].Name)
+		byName[name] = append(byName[name], i)
+	}
+
+	var packages []models.AgentSoftware
+	if err := m.db.WithContext(ctx).
+		Select("agent_id", "tenant_id", "source", "name", "arch", "version").
+		Where("name IN ?", names).
+		Find(&packages).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to load installed packages: %w", err)
+	}
+	if err := m.loadReleases(ctx, packages, releases); err != nil {
+		return nil, 0, err
+	}
+
+	var findings []models.VulnerabilityFinding
+	seen := make(map[string]bool)
+	for _, pkg := range packages {
+		for _, i := range byName[strings.ToLower(pkg.Name)] {
+			r := &ranges[i]
+			if r.Source != "" && r.Source != pkg.Source {
+				continue
+			}
+			if !releaseMatches(r.Release, releases[pkg.AgentID]) || !affects(&r.AdvisoryRange, pkg.Version) {
+				continue
+			}
+			key := openKey(pkg.AgentID, r.AdvisoryID, pkg.Source, pkg.Name, pkg.Arch)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			findings = append(findings, models.VulnerabilityFinding{
+				ID:               uuid.New().String(),
+				TenantID:         pkg.TenantID,
+				AgentID:          pkg.AgentID,
+				AdvisoryID:       r.AdvisoryID,
+				CVEID:            r.CVEID,
+				Severity:         r.Severity,
+				Status:           models.FindingStatusOpen,
+				OpenKey:          &key,
+				Source:           pkg.Source,
+				PackageName:      pkg.Name,
+				Arch:             pkg.Arch,
+				InstalledVersion: pkg.Version,
+				FixedVersion:     r.Fixed,
+				DetectedAt:       now,
+				LastSeenAt:       now,
+			})
+		}
+	}
+	return findings, len(packages), nil
+}
+
+// loadReleases adds the distribution releases, e.g. debian:12, of the
+// agents of packages missing from releases. Agents report theirs as the
+// os_id and os_version_id grains; those that do not map to "".
+func (m *Manager) loadReleases(ctx context.Context, packages []models.AgentSoftware, releases map[string]string) error {
+	var missing []string
+	for _, pkg := range packages {
+		if _, ok := releases[pkg.AgentID]; !ok {
+			releases[pkg.AgentID] = ""
+			missing = append(missing, pkg.AgentID)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	var agents []models.Agent
+	if err := m.db.WithContext(ctx).Select("id", "grains").
+		Where("id IN ?", missing).Find(&agents).Error; err != nil {
+		return fmt.Errorf("failed to load agent releases: %w", err)
+	}
+	for _, agent := range agents {
+		id, _ := agent.Grains["os_id"].(string)
+		version, _ := agent.Grains["os_version_id"].(string)
+		if id != "" && version != "" {
+			releases[agent.ID] = id + ":" + version
+		}
+	}
+	return nil
+}
+
+// openKey identifies the open finding of an agent, advisory and package
+func openKey(agentID, advisoryID, source, name, arch string) string {
+	sum := sha256.Sum256([]byte(agentID + "\x00" + advisoryID + "\x00" + source + "\x00" + name + "\x00" + arch))
+	return hex.EncodeToString(sum[:])
+}
+
+// ListFindingsRequest filters vulnerability findings
+type ListFindingsRequest struct {
+	TenantID string
+	AgentID  string
+	// ID matches findings by CVE or advisory ID
+	ID       string
+	Severity models.AdvisorySeverity
+	// Status defaults to open
+	Status models.FindingStatus
+	Limit  int
+	Offset int
+}
+
+// ListFindings lists a tenant's vulnerability findings, most severe first
+func (m *Manager) ListFindings(ctx context.Context, req *ListFindingsRequest) ([]models.VulnerabilityFinding, int64, error) {
+	status := req.Status
+	if status == "" {
+		status = models.FindingStatusOpen
+	}
+	query := m.db.WithContext(ctx).Model(&models.VulnerabilityFinding{}).
+		Where("tenant_id = ? AND status = ?", req.TenantID, status)
+	if req.AgentID != "" {
+		query = query.Where("agent_id = ?", req.AgentID)
+	}
+	if req.ID != "" {
+		query = query.Where("cve_id = ? OR advisory_id = ?", req.ID, req.ID)
+	}
+	if req.Severity != "" {
+		query = query.Where("severity = ?", req.Severity)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count vulnerability findings: %w", err)
+	}
+	limit := req.Limit
+	if limit <= 0 || limit > maxFindings {
+		limit = maxFindings
+	}
+	if req.Offset > 0 {
+		query = query.Offset(req.Offset)
+	}
+
+	var findings []models.VulnerabilityFinding
+	if err := query.Order(severityOrder + ", detected_at DESC").Limit(limit).
+		Find(&findings).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to list vulnerability findings: %w", err)
+	}
+	return findings, total, nil
+}
+
+// severityOrder sorts findings from critical to unknown
+const severityOrder = "FIELD(severity, 'critical', 'high', 'medium', 'low', 'unknown')"
+
+// GetAdvisory returns an advisory, by ID or the CVE it is about, with its
+// ranges
+func (m *Manager) GetAdvisory(ctx context.Context, id string) (*models.Advisory, error) {
+	var advisory models.Advisory
+	if err := m.db.WithContext(ctx).Preload("Ranges").
+		Where("id = ? OR cve_id = ?", id, id).
+		Order("source ASC").
+		First(&advisory).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("advisory %s not found", id)
+		}
+		return nil, fmt.Errorf("failed to get advisory: %w", err)
+	}
+	return &advisory, nil
+}
+
+// AffectedPackage is a vulnerable package on an affected agent
+type AffectedPackage struct {
+	AdvisoryID       string `json:"advisory_id"`
+	Source           string `json:"source"`
+	Name             string `json:"name"`
+	Arch             string `json:"arch,omitempty"`
+	InstalledVersion string `json:"installed_version"`
+	FixedVersion     string `json:"fixed_version,omitempty"`
+}
+
+// AffectedAgent is an agent with open findings for a CVE
+type AffectedAgent struct {
+	AgentID  string             `json:"agent_id"`
+	Hostname string             `json:"hostname"`
+	Status   models.AgentStatus `json:"status"`
+	Packages []AffectedPackage  `json:"packages"`
+}
+
+// AffectedAgents lists the tenant's agents with open findings for a CVE
+// or advisory
+func (m *Manager) AffectedAgents(ctx context.Context, tenantID, id string) ([]AffectedAgent, error) {
+	if _, err := m.GetAdvisory(ctx, id); err != nil {
+		return nil, err
+	}
+
+	var findings []models.VulnerabilityFinding
+	if err := m.db.WithContext(ctx).
+		Where("tenant_id = ? AND status = ? AND (cve_id = ? OR advisory_id = ?)", tenantID, models.FindingStatusOpen, id, id).
+		Order("agent_id ASC, package_name ASC").
+		Find(&findings).Error; err != nil {
+		return nil, fmt.Errorf("failed to list affected agents: %w", err)
+	}
+
+	affected := []AffectedAgent{}
+	index := make(map[string]int)
+	var ids []string
+	for _, finding := range findings {
+		i, ok := index[finding.AgentID]
+		if !ok {
+			i = len(affected)
+			index[finding.AgentID] = i
+			affected = append(affected, AffectedAgent{AgentID: finding.AgentID})
+			ids = append(ids, finding.AgentID)
+		}
+		affected[i].Packages = append(affected[i].Packages, AffectedPackage{
+			AdvisoryID:       finding.AdvisoryID,
+			Source:           finding.Source,
+			Name:             finding.PackageName,
+			Arch:             finding.Arch,
+			InstalledVersion: finding.InstalledVersion,
+			FixedVersion:     finding.FixedVersion,
+		})
+	}
+	if len(ids) == 0 {
+		return affected, nil
+	}
+
+	var agents []models.Agent
+	if err := m.db.WithContext(ctx).Select("id", "hostname", "status").
+		Where("tenant_id = ? AND id IN ?", tenantID, ids).Find(&agents).Error; err != nil {
+		return nil, fmt.Errorf("failed to load affected agents: %w", err)
+	}
+	for _, agent := range agents {
+		affected[index[agent.ID]].Hostname = agent.Hostname
+		affected[index[agent.ID]].Status = agent.Status
+	}
+	return affected, nil
+}
@@ -0,0 +1,237 @@
+package vulnerability
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/campaign"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/workflow"
+)
+
+// patchStepTimeout bounds each package upgrade step
+const patchStepTimeout = "30m"
+
+// packageNamePattern are the package names patch commands are generated
+// for. Names come from agents' inventory, so anything that could be read
+// as shell syntax or an option is refused.
+var packageNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+:~-]*$`)
+
+// PatchCampaignRequest represents a request to patch the agents affected by
+// a CVE or advisory
+type PatchCampaignRequest struct {
+	TenantID string `json:"-"`
+	// ID is the CVE or advisory to patch
+	ID string `json:"-"`
+	// Name defaults to "Patch <ID>"
+	Name string `json:"name"`
+	// Source picks the package manager to patch with; it is required when
+	// the affected agents use several
+	Source string `json:"source"`
+	// PhaseConfig defaults to a 10% canary followed by the rest
+	PhaseConfig []campaign.PhaseConfig `json:"phase_config"`
+	Window      *campaign.Window       `json:"window"`
+	CreatedBy   string                 `json:"-"`
+}
+
+// defaultPatchPhases canary the upgrade before rolling it out
+var defaultPatchPhases = []campaign.PhaseConfig{
+	{Name: "canary", Percentage: 10, SuccessThreshold: 90, WaitMinutes: 30},
+	{Name: "rollout", Percentage: 100, SuccessThreshold: 90},
+}
+
+// PatchCampaign creates a draft campaign upgrading the vulnerable packages
+// on the agents affected by a CVE or advisory. Its workflow is generated for
+// the package manager the agents use, and the campaign targets those
+// agents only; it is started like any other campaign.
+func (m *Manager) PatchCampaign(ctx context.Context, req *PatchCampaignRequest) (*models.Campaign, error) {
+	affected, err := m.AffectedAgents(ctx, req.TenantID, req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	agentsBySource := make(map[string]map[string]bool)
+	packagesBySource := make(map[string]map[string]bool)
+	for _, agent := range affected {
+		for _, pkg := range agent.Packages {
+			if agentsBySource[pkg.Source] == nil {
+				agentsBySource[pkg.Source] = make(map[string]bool)
+				packagesBySource[pkg.Source] = make(map[string]bool)
+			}
+			agentsBySource[pkg.Source][agent.AgentID] = true
+			packagesBySource[pkg.Source][pkg.Name] = true
+		}
+	}
+	if len(agentsBySource) == 0 {
+		return nil, apperror.InvalidState("no agents are affected by %s", req.ID)
+	}
+
+	source := req.Source
+	if source == "" {
+		if len(agentsBySource) > 1 {
+			return nil, apperror.InvalidInput("the agents affected by %s use %s; set source to patch one package manager at a time",
+				req.ID, strings.Join(sortedKeys(agentsBySource), ", "))
+		}
+		source = sortedKeys(agentsBySource)[0]
+	}
+	if agentsBySource[source] == nil {
+		return nil, apperror.InvalidInput("no agents affected by %s use %s", req.ID, source)
+	}
+
+	packages := sortedKeys(packagesBySource[source])
+	for _, name := range packages {
+		if !packageNamePattern.MatchString(name) {
+			return nil, apperror.InvalidInput("package name %q cannot be patched safely", name)
+		}
+	}
+	steps, err := patchSteps(source, packages)
+	if err != nil {
+		return nil, err
+	}
+
+	name := req.Name
+	if name == "" {
+		name = "Patch " + req.ID
+	}
+	definition := map[string]interface{}{
+		"name":  name,
+		"steps": steps,
+	}
+	if err := workflow.NewValidator().Validate(definition); err != nil {
+		return nil, apperror.InvalidInput("generated patch workflow is invalid: %w", err)
+	}
+	policy, err := workflow.LoadPolicy(ctx, m.db, req.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	if err := workflow.ApplyPolicy(definition, policy, false); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	wf := &models.Workflow{
+		ID:          uuid.New().String(),
+		TenantID:    req.TenantID,
+		Name:        fmt.Sprintf("%s (patch)", name),
+		Description: fmt.Sprintf("Generated to patch %s: upgrades %s with %s", req.ID, strings.Join(packages, ", "), source),
+		Definition:  definition,
+		Version:     1,
+		Status:      models.WorkflowStatusActive,
+		Tags: models.JSONMap{
+			"generated_by": "vulnerability_patch",
+			"advisory":     req.ID,
+		},
+		CreatedBy: req.CreatedBy,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := m.db.WithContext(ctx).Create(wf).Error; err != nil {
+		return nil, fmt.Errorf("failed to create patch workflow: %w", err)
+	}
+
+	phases := req.PhaseConfig
+	if len(phases) == 0 {
+		phases = defaultPatchPhases
+	}
+	created, err := m.campaigns.Create(ctx, &campaign.CreateCampaignRequest{
+		TenantID:       req.TenantID,
+		WorkflowID:     wf.ID,
+		Name:           name,
+		Description:    fmt.Sprintf("Upgrades %s on the %d agents affected by %s", strings.Join(packages, ", "), len(agentsBySource[source]), req.ID),
+		TargetSelector: map[string]interface{}{"agent_ids": sortedKeys(agentsBySource[source])},
+		PhaseConfig:    phases,
+		CreatedBy:      req.CreatedBy,
+		Window:         req.Window,
+	})
+	if err != nil {
+		// The workflow is only useful to the campaign
+		if deleteErr := m.db.WithContext(ctx).Delete(wf).Error; deleteErr != nil {
+			m.logger.Warn("failed to delete unused patch workflow",
+				zap.String("workflow_id", wf.ID),
+				zap.Error(deleteErr))
+		}
+		return nil, err
+	}
+
+	m.logger.Info("patch campaign created",
+		zap.String("campaign_id", created.ID),
+		zap.String("tenant_id", req.TenantID),
+		zap.String("advisory", req.ID),
+		zap.String("source", source),
+		zap.Int("agents", len(agentsBySource[source])))
+
+	return created, nil
+}
+
+// patchSteps returns the workflow steps upgrading packages with a package
+// manager
+func patchSteps(source string, packages []string) ([]interface{}, error) {
+	step := func(id, name string, fields map[string]interface{}) map[string]interface{} {
+		s := map[string]interface{}{
+			"id":      id,
+			"name":    name,
+			"type":    "command",
+			"timeout": patchStepTimeout,
+		}
+		for key, value := range fields {
+			s[key] = value
+		}
+		return s
+	}
+	list := strings.Join(packages, " ")
+
+	switch source {
+	case "dpkg":
+		return []interface{}{
+			step("refresh", "Refresh package lists", map[string]interface{}{
+				"command": "apt-get update -q",
+			}),
+			step("upgrade", "Upgrade "+list, map[string]interface{}{
+				"command": "DEBIAN_FRONTEND=noninteractive apt-get install -y -q --only-upgrade " + list,
+			}),
+		}, nil
+	case "rpm":
+		return []interface{}{
+			step("upgrade", "Upgrade "+list, map[string]interface{}{
+				"command": fmt.Sprintf("if command -v dnf >/dev/null 2>&1; then dnf upgrade -y %s; else yum update -y %s; fi", list, list),
+			}),
+		}, nil
+	case "choco":
+		args := []interface{}{"choco", "upgrade", "-y", "--no-progress"}
+		for _, pkg := range packages {
+			args = append(args, pkg)
+		}
+		return []interface{}{
+			step("upgrade", "Upgrade "+list, map[string]interface{}{"args": args}),
+		}, nil
+	case "winget":
+		// winget upgrades one package per invocation
+		steps := make([]interface{}, len(packages))
+		for i, pkg := range packages {
+			steps[i] = step(fmt.Sprintf("upgrade-%d", i+1), "Upgrade "+pkg, map[string]interface{}{
+				"args": []interface{}{"winget", "upgrade", "--id", pkg, "--exact", "--silent",
+					"--accept-package-agreements", "--accept-source-agreements", "--disable-interactivity"},
+			})
+		}
+		return steps, nil
+	}
+	return nil, apperror.InvalidInput("unknown package source %q", source)
+}
+
+// sortedKeys returns the keys of a set in order
+func sortedKeys[V any](set map[string]V) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
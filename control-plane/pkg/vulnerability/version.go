@@ -0,0 +1,149 @@
+package vulnerability
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// CompareVersions orders package versions the way dpkg does: by epoch, then
+// upstream version, then revision, comparing runs of digits numerically and
+// sorting "~" before everything, so 1.0~rc1 < 1.0 < 1.0a < 1.0.1. RPM,
+// Chocolatey and winget versions order the same way for all practical
+// purposes.
+func CompareVersions(a, b string) int {
+	epochA, restA := splitEpoch(a)
+	epochB, restB := splitEpoch(b)
+	if epochA != epochB {
+		if epochA < epochB {
+			return -1
+		}
+		return 1
+	}
+
+	upstreamA, revisionA := splitRevision(restA)
+	upstreamB, revisionB := splitRevision(restB)
+	if c := compareFragment(upstreamA, upstreamB); c != 0 {
+		return c
+	}
+	return compareFragment(revisionA, revisionB)
+}
+
+// splitEpoch splits the "N:" epoch off a version; versions without one
+// have epoch 0
+func splitEpoch(version string) (int, string) {
+	if epoch, rest, ok := strings.Cut(version, ":"); ok {
+		if n, err := strconv.Atoi(epoch); err == nil {
+			return n, rest
+		}
+	}
+	return 0, version
+}
+
+// splitRevision splits a version at its last "-" into upstream version and
+// package revision
+func splitRevision(version string) (string, string) {
+	if i := strings.LastIndexByte(version, '-'); i >= 0 {
+		return version[:i], version[i+1:]
+	}
+	return version, ""
+}
+
+// compareFragment compares alternating runs of non-digits and digits
+func compareFragment(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for (i < len(a) && !isDigit(a[i])) || (j < len(b) && !isDigit(b[j])) {
+			ac, bc := charOrder(a, i), charOrder(b, j)
+			if ac != bc {
+				return sign(ac - bc)
+			}
+			i++
+			j++
+		}
+
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		firstDiff := 0
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if firstDiff == 0 {
+				firstDiff = int(a[i]) - int(b[j])
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if firstDiff != 0 {
+			return sign(firstDiff)
+		}
+	}
+	return 0
+}
+
+// charOrder weighs the character at i of a non-digit run: "~" sorts
+// first, then the end of the run, letters and other characters
+func charOrder(s string, i int) int {
+	if i >= len(s) {
+		return 0
+	}
+	c := s[i]
+	switch {
+	case isDigit(c):
+		return 0
+	case c == '~':
+		return -1
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		return int(c)
+	default:
+		return int(c) + 256
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// affects reports whether version falls in an advisory range
+func affects(r *models.AdvisoryRange, version string) bool {
+	if r.Introduced != "" && r.Introduced != "0" && CompareVersions(version, r.Introduced) < 0 {
+		return false
+	}
+	if r.Fixed != "" && CompareVersions(version, r.Fixed) >= 0 {
+		return false
+	}
+	if r.LastAffected != "" && CompareVersions(version, r.LastAffected) > 0 {
+		return false
+	}
+	return true
+}
+
+// releaseMatches reports whether a range for a distribution release, e.g.
+// debian:12 or almalinux:9, applies to an agent running release, e.g.
+// almalinux:9.3. Ranges without a release, and agents that do not report
+// theirs, always match.
+func releaseMatches(rangeRelease, agentRelease string) bool {
+	if rangeRelease == "" || agentRelease == "" {
+		return true
+	}
+	return agentRelease == rangeRelease || strings.HasPrefix(agentRelease, rangeRelease+".")
+}
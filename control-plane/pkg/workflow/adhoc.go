@@ -0,0 +1,115 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm/clause"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// MaxAdhocDefinitionSize bounds the JSON encoding of an ad-hoc definition
+const MaxAdhocDefinitionSize = 256 << 10
+
+// adhocNamespace derives the ID of each tenant's ad-hoc workflow
+var adhocNamespace = uuid.MustParse("5f0c7d2e-8f4b-4c55-9d3a-6b1e2a7c9f10")
+
+// AdhocExecuteRequest runs an inline workflow definition once on one agent,
+// without creating a workflow
+type AdhocExecuteRequest struct {
+	TenantID   string                 `json:"-"`
+	AgentID    string                 `json:"agent_id" binding:"required"`
+	Definition map[string]interface{} `json:"definition" binding:"required"`
+	Priority   string                 `json:"priority"`
+	Mode       models.ExecutionMode   `json:"mode"`
+	Vars       map[string]interface{} `json:"vars"`
+
+	// PolicyOverride skips the tenant workflow policy's ceilings; only
+	// tenant admins may set it
+	PolicyOverride bool `json:"policy_override"`
+}
+
+// AdhocWorkflowID returns the ID of the tenant's ad-hoc workflow, which
+// holds the definition snapshots of its ad-hoc executions. The workflow is
+// kept deleted so it is never listed, executed or referenced.
+func AdhocWorkflowID(tenantID string) string {
+	return uuid.NewSHA1(adhocNamespace, []byte(tenantID)).String()
+}
+
+// ExecuteAdhoc validates an inline definition like one being saved, applies
+// the tenant's workflow policy to it and queues it on the agent. The
+// execution records the definition as a snapshot of the tenant's ad-hoc
+// workflow and is marked adhoc.
+func (e *Executor) ExecuteAdhoc(ctx context.Context, req *AdhocExecuteRequest) (*models.WorkflowExecution, error) {
+	data, err := json.Marshal(req.Definition)
+	if err != nil {
+		return nil, apperror.InvalidInput("invalid definition: %w", err)
+	}
+	if len(data) > MaxAdhocDefinitionSize {
+		return nil, apperror.InvalidInput("definition is %d bytes; ad-hoc definitions are limited to %d", len(data), MaxAdhocDefinitionSize)
+	}
+
+	if err := NewValidator().Validate(req.Definition); err != nil {
+		return nil, apperror.InvalidInput("workflow validation failed: %w", err)
+	}
+	policy, err := LoadPolicy(ctx, e.db, req.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	if err := ApplyPolicy(req.Definition, policy, req.PolicyOverride); err != nil {
+		return nil, err
+	}
+	if req.PolicyOverride && policy != nil {
+		e.logger.Info("workflow policy overridden",
+			zap.String("tenant_id", req.TenantID))
+	}
+
+	holder, err := e.adhocWorkflow(ctx, req.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	holder.Definition = req.Definition
+	snapshot, err := Snapshot(ctx, e.db, holder)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Execute(ctx, &ExecuteRequest{
+		TenantID:       req.TenantID,
+		WorkflowID:     holder.ID,
+		AgentID:        req.AgentID,
+		Priority:       req.Priority,
+		Mode:           req.Mode,
+		Vars:           req.Vars,
+		DefinitionHash: snapshot.DefinitionHash,
+		adhoc:          true,
+	})
+}
+
+// adhocWorkflow returns the tenant's ad-hoc workflow, creating it on first
+// use
+func (e *Executor) adhocWorkflow(ctx context.Context, tenantID string) (*models.Workflow, error) {
+	now := time.Now()
+	workflow := &models.Workflow{
+		ID:          AdhocWorkflowID(tenantID),
+		TenantID:    tenantID,
+		Name:        "ad-hoc executions",
+		Description: "Holds the definitions of ad-hoc executions",
+		Definition:  models.JSONMap{"name": "ad-hoc executions", "steps": []interface{}{}},
+		Version:     1,
+		Status:      models.WorkflowStatusDeleted,
+		CreatedBy:   "system",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := e.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(workflow).Error; err != nil {
+		return nil, fmt.Errorf("failed to create ad-hoc workflow: %w", err)
+	}
+	return workflow, nil
+}
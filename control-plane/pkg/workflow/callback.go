@@ -0,0 +1,59 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// HeaderCallbackSignature carries the HMAC-SHA256 of a callback
+// confirmation's body, signed with the callback step's secret
+const HeaderCallbackSignature = "X-Signature-256"
+
+// RelayCallbackConfirmation forwards the confirmation of a callback step to
+// the agent running the execution. The control plane cannot check the
+// signature, which uses a secret only the workflow and the agent know, so
+// the agent verifies it.
+func (e *Executor) RelayCallbackConfirmation(ctx context.Context, executionID, callbackID string, body []byte, signature string) error {
+	var execution models.WorkflowExecution
+	if err := e.db.WithContext(ctx).Select("id", "tenant_id", "agent_id", "status").
+		Where("id = ?", executionID).
+		First(&execution).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return apperror.NotFound("execution not found")
+		}
+		return fmt.Errorf("failed to get execution: %w", err)
+	}
+	if execution.Status != models.ExecutionStatusRunning {
+		return apperror.InvalidState("execution is %s, not waiting for a callback", execution.Status)
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set(HeaderCallbackSignature, signature)
+
+	resp, dispatchErr := e.agentRequest(ctx, execution.TenantID, execution.AgentID, http.MethodPost, "/workflow/callback/"+callbackID, body, header)
+	if dispatchErr != nil {
+		if dispatchErr.StatusCode == http.StatusForbidden {
+			return apperror.Forbidden("callback confirmation refused: %v", dispatchErr)
+		}
+		e.logger.Warn("failed to relay callback confirmation",
+			zap.String("execution_id", executionID),
+			zap.String("agent_id", execution.AgentID),
+			zap.Error(dispatchErr))
+		return fmt.Errorf("failed to relay callback confirmation to agent: %w", dispatchErr)
+	}
+	resp.Body.Close()
+
+	e.logger.Info("relayed callback confirmation",
+		zap.String("execution_id", executionID),
+		zap.String("agent_id", execution.AgentID),
+		zap.String("callback_id", callbackID))
+	return nil
+}
@@ -0,0 +1,356 @@
+// Package workflow provides workflow management for the control plane.
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/auth"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// Dispatch failure classes recorded on executions that could not be sent
+const (
+	// FailureUnreachable means the agent could not be reached through Piko
+	FailureUnreachable = "unreachable"
+	// FailureRejected means the agent was reached but refused the request
+	FailureRejected = "rejected"
+	// FailureInsufficientResources means the agent lacks the disk or memory
+	// the workflow declared for now; it may accept the workflow later
+	FailureInsufficientResources = "insufficient_resources"
+)
+
+// DispatchConfig controls how requests are sent to agents through Piko
+type DispatchConfig struct {
+	// ConnectTimeout bounds establishing a connection to Piko
+	ConnectTimeout time.Duration
+	// ResponseTimeout bounds waiting for the response headers of one attempt
+	ResponseTimeout time.Duration
+	// MaxAttempts is the number of tries for transient failures
+	MaxAttempts int
+	// RetryBackoff is the delay before the first retry; it doubles per retry
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// BreakerThreshold is the number of consecutive unreachable failures
+	// after which requests to an agent fail fast for BreakerCooldown
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// DefaultDispatchConfig returns the default agent dispatch configuration
+func DefaultDispatchConfig() *DispatchConfig {
+	return &DispatchConfig{
+		ConnectTimeout:   5 * time.Second,
+		ResponseTimeout:  30 * time.Second,
+		MaxAttempts:      3,
+		RetryBackoff:     500 * time.Millisecond,
+		MaxRetryBackoff:  5 * time.Second,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+	}
+}
+
+// DispatchError is a failed request to an agent
+type DispatchError struct {
+	// Class is FailureUnreachable, FailureRejected or
+	// FailureInsufficientResources
+	Class string
+	// StatusCode is the HTTP status returned, zero if there was no response
+	StatusCode int
+	Attempts   int
+	Err        error
+}
+
+func (e *DispatchError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DispatchError) Unwrap() error {
+	return e.Err
+}
+
+// errCircuitOpen is returned while an agent's circuit breaker is open
+var errCircuitOpen = errors.New("circuit breaker open: agent recently unreachable")
+
+// circuitBreaker tracks consecutive unreachable failures for one agent
+type circuitBreaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+// newDispatchClient creates the HTTP client used for Piko requests. All
+// requests go to the Piko proxy, so idle connections are kept for reuse.
+func newDispatchClient(config *DispatchConfig) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   config.ConnectTimeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout:   config.ConnectTimeout,
+			ResponseHeaderTimeout: config.ResponseTimeout,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   100,
+			IdleConnTimeout:       90 * time.Second,
+		},
+	}
+}
+
+// SetDispatchConfig replaces the agent dispatch configuration. Unset fields
+// keep their defaults.
+func (e *Executor) SetDispatchConfig(config *DispatchConfig) {
+	defaults := DefaultDispatchConfig()
+	if config.ConnectTimeout <= 0 {
+		config.ConnectTimeout = defaults.ConnectTimeout
+	}
+	if config.ResponseTimeout <= 0 {
+		config.ResponseTimeout = defaults.ResponseTimeout
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaults.MaxAttempts
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = defaults.RetryBackoff
+	}
+	if config.MaxRetryBackoff <= 0 {
+		config.MaxRetryBackoff = defaults.MaxRetryBackoff
+	}
+	if config.BreakerThreshold <= 0 {
+		config.BreakerThreshold = defaults.BreakerThreshold
+	}
+	if config.BreakerCooldown <= 0 {
+		config.BreakerCooldown = defaults.BreakerCooldown
+	}
+
+	e.dispatch = config
+	e.httpClient = newDispatchClient(config)
+}
+
+// dispatchTimeout bounds all attempts of one agent request
+func (e *Executor) dispatchTimeout() time.Duration {
+	attempts := time.Duration(e.dispatch.MaxAttempts)
+	return attempts*(e.dispatch.ConnectTimeout+e.dispatch.ResponseTimeout) + (attempts-1)*e.dispatch.MaxRetryBackoff
+}
+
+// attemptTimeout bounds one attempt of an agent request, and so the
+// lifetime of the dispatch token it carries
+func (e *Executor) attemptTimeout() time.Duration {
+	return e.dispatch.ConnectTimeout + e.dispatch.ResponseTimeout
+}
+
+// agentRequest sends a request to an agent through the Piko proxy. Transient
+// failures are retried with exponential backoff, and requests to an agent
+// that keeps being unreachable fail fast until its breaker cools down. The
+// caller must close the response body.
+func (e *Executor) agentRequest(ctx context.Context, tenantID, agentID, method, path string, body []byte, header http.Header) (*http.Response, *DispatchError) {
+	if !e.breakerAllows(agentID) {
+		return nil, &DispatchError{Class: FailureUnreachable, Err: errCircuitOpen}
+	}
+
+	endpoint, dispatchKey, dispatchErr := e.bindDispatch(ctx, tenantID, agentID)
+	if dispatchErr != nil {
+		return nil, dispatchErr
+	}
+	url := fmt.Sprintf("%s/piko/v1/proxy/%s%s", e.pikoURL, endpoint, path)
+
+	backoff := e.dispatch.RetryBackoff
+	var lastErr *DispatchError
+	for attempt := 1; attempt <= e.dispatch.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				lastErr.Attempts = attempt - 1
+				return nil, lastErr
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > e.dispatch.MaxRetryBackoff {
+				backoff = e.dispatch.MaxRetryBackoff
+			}
+		}
+
+		// Agents accept a dispatch token once, so every attempt gets its own
+		attemptHeader, dispatchErr := signDispatch(dispatchKey, tenantID, agentID, endpoint, method, path, body, header, e.attemptTimeout())
+		if dispatchErr != nil {
+			return nil, dispatchErr
+		}
+		resp, err := e.agentAttempt(ctx, method, url, body, attemptHeader)
+		if err == nil {
+			e.breakerSucceeded(agentID)
+			return resp, nil
+		}
+
+		err.Attempts = attempt
+		lastErr = err
+		if err.Class != FailureUnreachable {
+			// The agent answered, so the connection is healthy
+			e.breakerSucceeded(agentID)
+			return nil, err
+		}
+		if !e.breakerFailed(agentID) {
+			return nil, err
+		}
+
+		e.logger.Debug("retrying agent request",
+			zap.String("agent_id", agentID),
+			zap.String("path", path),
+			zap.Int("attempt", attempt),
+			zap.Error(err))
+	}
+
+	return nil, lastErr
+}
+
+// bindDispatch resolves the agent's endpoint and checks it belongs to the
+// tenant the request is made for, so a bug in a caller cannot send one
+// tenant's work to another tenant's agent. It returns the endpoint and the
+// agent's dispatch key, empty for agents registered before dispatch keys.
+func (e *Executor) bindDispatch(ctx context.Context, tenantID, agentID string) (string, string, *DispatchError) {
+	var agent models.Agent
+	if err := e.db.WithContext(ctx).Select("id", "tenant_id", "piko_endpoint", "dispatch_key").
+		Where("id = ? AND tenant_id = ?", agentID, tenantID).
+		First(&agent).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return "", "", &DispatchError{Class: FailureRejected, Err: fmt.Errorf("agent %s is not registered to tenant %s", agentID, tenantID)}
+		}
+		return "", "", &DispatchError{Class: FailureUnreachable, Err: fmt.Errorf("failed to load agent endpoint: %w", err)}
+	}
+
+	endpoint := agent.Endpoint()
+	if !strings.HasPrefix(endpoint, "tenant-"+tenantID+"/") {
+		e.logger.Error("refused dispatch to an endpoint of another tenant",
+			zap.String("tenant_id", tenantID),
+			zap.String("agent_id", agentID),
+			zap.String("endpoint", endpoint))
+		return "", "", &DispatchError{Class: FailureRejected, Err: fmt.Errorf("endpoint of agent %s is not bound to tenant %s", agentID, tenantID)}
+	}
+	return endpoint, agent.DispatchKey, nil
+}
+
+// signDispatch returns the request headers with a dispatch token bound to
+// the endpoint and to this request's method, path and body, signed with the
+// agent's dispatch key. Without a key the headers are returned unchanged.
+func signDispatch(dispatchKey, tenantID, agentID, endpoint, method, path string, body []byte, header http.Header, expiry time.Duration) (http.Header, *DispatchError) {
+	if dispatchKey == "" {
+		return header, nil
+	}
+
+	token, err := auth.GenerateDispatchToken(dispatchKey, tenantID, agentID, endpoint, method, path, body, expiry)
+	if err != nil {
+		return nil, &DispatchError{Class: FailureRejected, Err: fmt.Errorf("failed to sign dispatch token: %w", err)}
+	}
+	bound := header.Clone()
+	if bound == nil {
+		bound = http.Header{}
+	}
+	bound.Set(auth.HeaderDispatchToken, token)
+	return bound, nil
+}
+
+// agentAttempt makes a single request and classifies any failure
+func (e *Executor) agentAttempt(ctx context.Context, method, url string, body []byte, header http.Header) (*http.Response, *DispatchError) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, &DispatchError{Class: FailureRejected, Err: fmt.Errorf("failed to create request: %w", err)}
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return nil, &DispatchError{Class: FailureUnreachable, Err: fmt.Errorf("failed to reach agent: %w", err)}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		// Piko has no connection to the agent, or the upstream timed out
+		resp.Body.Close()
+		return nil, &DispatchError{
+			Class:      FailureUnreachable,
+			StatusCode: resp.StatusCode,
+			Err:        fmt.Errorf("piko returned status %d", resp.StatusCode),
+		}
+	}
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		err := fmt.Errorf("agent returned status %d", resp.StatusCode)
+		if text := string(bytes.TrimSpace(message)); text != "" {
+			err = fmt.Errorf("agent returned status %d: %s", resp.StatusCode, text)
+		}
+		class := FailureRejected
+		if resp.StatusCode == http.StatusInsufficientStorage {
+			class = FailureInsufficientResources
+		}
+		return nil, &DispatchError{Class: class, StatusCode: resp.StatusCode, Err: err}
+	}
+
+	return resp, nil
+}
+
+// breakerAllows reports whether a request to the agent may be attempted.
+// Once the cooldown has passed a single trial request is let through.
+func (e *Executor) breakerAllows(agentID string) bool {
+	e.breakersMu.Lock()
+	defer e.breakersMu.Unlock()
+
+	b, ok := e.breakers[agentID]
+	if !ok || b.openUntil.IsZero() {
+		return true
+	}
+	if time.Now().Before(b.openUntil) {
+		return false
+	}
+	// Half-open: the next failure reopens the breaker immediately
+	b.openUntil = time.Time{}
+	b.failures = e.dispatch.BreakerThreshold - 1
+	return true
+}
+
+// breakerSucceeded resets the agent's breaker
+func (e *Executor) breakerSucceeded(agentID string) {
+	e.breakersMu.Lock()
+	delete(e.breakers, agentID)
+	e.breakersMu.Unlock()
+}
+
+// breakerFailed records an unreachable failure. It returns false if the
+// breaker opened and no further attempts should be made.
+func (e *Executor) breakerFailed(agentID string) bool {
+	e.breakersMu.Lock()
+	defer e.breakersMu.Unlock()
+
+	b, ok := e.breakers[agentID]
+	if !ok {
+		b = &circuitBreaker{}
+		e.breakers[agentID] = b
+	}
+	b.failures++
+	if b.failures < e.dispatch.BreakerThreshold {
+		return true
+	}
+
+	b.openUntil = time.Now().Add(e.dispatch.BreakerCooldown)
+	e.logger.Warn("agent circuit breaker opened",
+		zap.String("agent_id", agentID),
+		zap.Int("failures", b.failures),
+		zap.Duration("cooldown", e.dispatch.BreakerCooldown))
+	return false
+}
@@ -2,145 +2,369 @@
 package workflow
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/archive"
 	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/shutdown"
+	"github.com/yourorg/control-plane/pkg/tenant"
+	"github.com/yourorg/control-plane/pkg/tracing"
 )
 
 // Executor executes workflows on agents
 type Executor struct {
-	db         *gorm.DB
-	pikoURL    string
-	httpClient *http.Client
-	logger     *zap.Logger
+	db            *gorm.DB
+	pikoURL       string
+	httpClient    *http.Client
+	hookClient    *http.Client
+	dispatch      *DispatchConfig
+	logger        *zap.Logger
+	outputIndexer OutputIndexer
+	archiver      *archive.Archiver
+	quotaChecker  *tenant.QuotaChecker
+
+	// Per-agent circuit breakers for Piko requests
+	breakersMu sync.Mutex
+	breakers   map[string]*circuitBreaker
+
+	// In-flight Execute calls, waited for on shutdown; no new executions
+	// are started once draining
+	drainMu  sync.Mutex
+	draining bool
+	inflight sync.WaitGroup
+
+	// queue delivers queued executions; without one they wait in the
+	// outbox for another replica's queue
+	queue *DispatchQueue
+
+	// templateHashes caches the content hashes added to template steps
+	templateHashes templateHashCache
+}
+
+// OutputIndexer receives completed execution results for output search
+type OutputIndexer interface {
+	IndexResult(execution *models.WorkflowExecution, result map[string]interface{})
 }
 
 // NewExecutor creates a new workflow executor
 func NewExecutor(db *gorm.DB, pikoURL string, logger *zap.Logger) *Executor {
+	dispatch := DefaultDispatchConfig()
 	return &Executor{
-		db:      db,
-		pikoURL: pikoURL,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		logger: logger,
+		db:           db,
+		pikoURL:      pikoURL,
+		httpClient:   newDispatchClient(dispatch),
+		hookClient:   &http.Client{Timeout: hookTimeout},
+		dispatch:     dispatch,
+		logger:       logger,
+		quotaChecker: tenant.NewQuotaChecker(db),
+		breakers:     make(map[string]*circuitBreaker),
 	}
 }
 
+// SetOutputIndexer sets the indexer that receives completed execution results
+func (e *Executor) SetOutputIndexer(indexer OutputIndexer) {
+	e.outputIndexer = indexer
+}
+
+// SetArchiver sets the archiver that archived execution output is read back
+// from
+func (e *Executor) SetArchiver(archiver *archive.Archiver) {
+	e.archiver = archiver
+}
+
+// SetDispatchQueue sets the queue woken when an execution is queued
+func (e *Executor) SetDispatchQueue(queue *DispatchQueue) {
+	e.queue = queue
+}
+
+// notifyQueue wakes the local dispatch queue, if any
+func (e *Executor) notifyQueue() {
+	if e.queue != nil {
+		e.queue.Notify()
+	}
+}
+
+// beginDispatch registers an in-flight dispatch, or reports false once the
+// executor is draining
+func (e *Executor) beginDispatch() bool {
+	e.drainMu.Lock()
+	defer e.drainMu.Unlock()
+	if e.draining {
+		return false
+	}
+	e.inflight.Add(1)
+	return true
+}
+
+// Drain stops new executions from starting and waits for in-flight Execute
+// calls to finish queuing, or for ctx to be done. Queued executions are
+// delivered by the dispatch queue.
+func (e *Executor) Drain(ctx context.Context) error {
+	e.drainMu.Lock()
+	e.draining = true
+	e.drainMu.Unlock()
+
+	return shutdown.Wait(ctx, &e.inflight)
+}
+
 // ExecuteRequest represents a request to execute a workflow
 type ExecuteRequest struct {
 	TenantID   string `json:"tenant_id" binding:"required"`
 	WorkflowID string `json:"workflow_id" binding:"required"`
 	AgentID    string `json:"agent_id" binding:"required"`
 	CampaignID string `json:"campaign_id"`
+	Priority   string `json:"priority"` // Agent queue priority: high, normal (default) or low
+
+	// Mode is live (default) or validate, which makes no changes on the
+	// agent and only reports pre-check results and would-change diffs
+	Mode models.ExecutionMode `json:"mode"`
+
+	// DefinitionHash dispatches a snapshot of the workflow's definition
+	// instead of its current one; campaigns pin their executions with it
+	DefinitionHash string `json:"-"`
+
+	// Vars are set as workflow vars for this execution, over those the
+	// definition declares
+	Vars map[string]interface{} `json:"vars"`
+
+	// TriggeredBy is the execution whose trigger started this one
+	TriggeredBy  string `json:"-"`
+	triggerDepth int
+
+	// adhoc runs a snapshot of the tenant's ad-hoc workflow, pinned by
+	// DefinitionHash; see ExecuteAdhoc
+	adhoc bool
 }
 
-// Execute starts workflow execution on an agent
+// Execute queues workflow execution on an agent. The execution is written
+// with a dispatch job and delivered to the agent by the dispatch queue.
 func (e *Executor) Execute(ctx context.Context, req *ExecuteRequest) (*models.WorkflowExecution, error) {
+	switch req.Priority {
+	case "", "high", "normal", "low":
+	default:
+		return nil, apperror.InvalidInput("invalid priority %q: must be high, normal or low", req.Priority)
+	}
+	switch req.Mode {
+	case "":
+		req.Mode = models.ExecutionModeLive
+	case models.ExecutionModeLive, models.ExecutionModeValidate:
+	default:
+		return nil, apperror.InvalidInput("invalid mode %q: must be live or validate", req.Mode)
+	}
+	for _, reserved := range []string{"grains", "steps"} {
+		if _, ok := req.Vars[reserved]; ok {
+			return nil, apperror.InvalidInput("vars.%s is reserved", reserved)
+		}
+	}
+
+	if !e.beginDispatch() {
+		return nil, apperror.InvalidState("control plane is shutting down and not accepting executions")
+	}
+	defer e.inflight.Done()
+
 	// Get workflow
 	var workflow models.Workflow
 	if err := e.db.Where("id = ? AND tenant_id = ?", req.WorkflowID, req.TenantID).First(&workflow).Error; err != nil {
-		return nil, fmt.Errorf("workflow not found: %w", err)
+		return nil, apperror.NotFound("workflow not found: %w", err)
 	}
 
-	if workflow.Status != models.WorkflowStatusActive {
-		return nil, fmt.Errorf("workflow is not active")
+	// The ad-hoc workflow only holds the snapshots of ad-hoc definitions
+	// and is never active
+	if req.adhoc != (workflow.ID == AdhocWorkflowID(req.TenantID)) {
+		return nil, apperror.NotFound("workflow not found")
+	}
+	if !req.adhoc && workflow.Status != models.WorkflowStatusActive {
+		return nil, apperror.InvalidState("workflow is not active")
 	}
 
 	// Verify agent exists
 	var agent models.Agent
 	if err := e.db.Where("id = ? AND tenant_id = ?", req.AgentID, req.TenantID).First(&agent).Error; err != nil {
-		return nil, fmt.Errorf("agent not found: %w", err)
+		return nil, apperror.NotFound("agent not found: %w", err)
+	}
+
+	if agent.DrainState == models.AgentDrainDraining || agent.DrainState == models.AgentDrainDrained {
+		return nil, apperror.InvalidState("agent %s is %s and not accepting executions", agent.ID, agent.DrainState)
+	}
+	if agent.ApprovalStatus != models.AgentApprovalApproved {
+		return nil, apperror.InvalidState("agent %s registration is %s; only approved agents receive executions", agent.ID, agent.ApprovalStatus)
+	}
+
+	if err := e.quotaChecker.CheckExecutionBudget(ctx, req.TenantID); err != nil {
+		return nil, err
+	}
+
+	// Every execution records the definition it ran; a pinned one runs the
+	// snapshot rather than the workflow as it is now
+	if req.DefinitionHash != "" {
+		snapshot, err := LoadSnapshot(ctx, e.db, req.TenantID, req.WorkflowID, req.DefinitionHash)
+		if err != nil {
+			return nil, err
+		}
+		workflow.Definition = snapshot.Definition
+		workflow.Version = snapshot.WorkflowVersion
+	} else {
+		snapshot, err := Snapshot(ctx, e.db, &workflow)
+		if err != nil {
+			return nil, err
+		}
+		req.DefinitionHash = snapshot.DefinitionHash
 	}
 
 	// Create execution record
 	execution := &models.WorkflowExecution{
-		ID:         uuid.New().String(),
-		WorkflowID: req.WorkflowID,
-		TenantID:   req.TenantID,
-		AgentID:    req.AgentID,
-		Status:     models.ExecutionStatusPending,
-		CreatedAt:  time.Now(),
+		ID:              uuid.New().String(),
+		WorkflowID:      req.WorkflowID,
+		TenantID:        req.TenantID,
+		AgentID:         req.AgentID,
+		Status:          models.ExecutionStatusPending,
+		WorkflowVersion: workflow.Version,
+		DefinitionHash:  req.DefinitionHash,
+		Mode:            req.Mode,
+		Adhoc:           req.adhoc,
+		RequestID:       tracing.RequestID(ctx),
+		CreatedAt:       time.Now(),
 	}
 
 	if req.CampaignID != "" {
 		execution.CampaignID = &req.CampaignID
 	}
+	if req.TriggeredBy != "" {
+		execution.TriggeredByID = &req.TriggeredBy
+		execution.TriggerDepth = req.triggerDepth
+	}
 
-	if err := e.db.Create(execution).Error; err != nil {
-		return nil, fmt.Errorf("failed to create execution: %w", err)
+	definition, err := e.dispatchPayload(ctx, execution, &workflow)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Vars) > 0 {
+		vars := make(map[string]interface{})
+		if declared, ok := definition["vars"].(map[string]interface{}); ok {
+			for k, v := range declared {
+				vars[k] = v
+			}
+		}
+		for k, v := range req.Vars {
+			vars[k] = v
+		}
+		definition["vars"] = vars
+	}
+	job := &models.DispatchJob{
+		ID:            uuid.New().String(),
+		TenantID:      req.TenantID,
+		ExecutionID:   execution.ID,
+		AgentID:       req.AgentID,
+		Priority:      req.Priority,
+		Payload:       definition,
+		Status:        models.DispatchJobPending,
+		NextAttemptAt: execution.CreatedAt,
 	}
 
-	// Send to agent via Piko
-	go e.sendToAgent(execution, &workflow, &agent)
+	// The execution and its dispatch job are written together so every
+	// execution is delivered, even if this replica stops before sending it
+	if err := e.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(execution).Error; err != nil {
+			return err
+		}
+		return tx.Create(job).Error
+	}); err != nil {
+		return nil, fmt.Errorf("failed to create execution: %w", err)
+	}
+	e.notifyQueue()
 
-	e.logger.Info("workflow execution started",
+	tracing.Logger(ctx, e.logger).Info("workflow execution queued",
 		zap.String("execution_id", execution.ID),
 		zap.String("workflow_id", req.WorkflowID),
-		zap.String("agent_id", req.AgentID))
+		zap.String("agent_id", req.AgentID),
+		zap.String("mode", string(req.Mode)))
 
 	return execution, nil
 }
 
-// sendToAgent sends the workflow to the agent for execution
-func (e *Executor) sendToAgent(execution *models.WorkflowExecution, workflow *models.Workflow, agent *models.Agent) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	// Update status to running
-	e.db.Model(execution).Updates(map[string]interface{}{
-		"status":     models.ExecutionStatusRunning,
-		"started_at": time.Now(),
-	})
+// dispatchPayload returns the workflow definition sent to the agent. The
+// agent reports results under the execution ID.
+func (e *Executor) dispatchPayload(ctx context.Context, execution *models.WorkflowExecution, workflow *models.Workflow) (models.JSONMap, error) {
+	definition := make(models.JSONMap, len(workflow.Definition)+2)
+	for k, v := range workflow.Definition {
+		definition[k] = v
+	}
+	definition["id"] = execution.ID
+
+	// Plugin steps only run plugins the tenant allowlists; the list always
+	// replaces whatever the definition carries
+	definition["plugins"] = map[string]string{}
+	if usesPlugins(workflow.Definition) {
+		policy, err := LoadPolicy(ctx, e.db, workflow.TenantID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load plugin allowlist: %w", err)
+		}
+		if policy != nil && policy.AllowedPlugins != nil {
+			definition["plugins"] = policy.AllowedPlugins
+		}
+	}
 
-	// Build Piko endpoint URL
-	endpoint := fmt.Sprintf("tenant-%s/%s", agent.TenantID, agent.ID)
-	url := fmt.Sprintf("%s/piko/v1/proxy/%s/workflow/execute", e.pikoURL, endpoint)
+	// Template steps carry the hash of the content they deploy so agents
+	// can serve it from their cache
+	if err := e.addTemplateHashes(ctx, workflow.TenantID, definition); err != nil {
+		return nil, err
+	}
 
-	// Prepare workflow payload
-	payload, err := json.Marshal(workflow.Definition)
-	if err != nil {
-		e.markFailed(execution, fmt.Sprintf("failed to marshal workflow: %v", err))
-		return
+	// Like the allowlist, the mode is never taken from the definition
+	delete(definition, "mode")
+	if execution.Mode == models.ExecutionModeValidate {
+		definition["mode"] = string(models.ExecutionModeValidate)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	return definition, nil
+}
+
+// SetAgentDrain tells an agent through the Piko proxy to stop or resume
+// accepting workflows
+func (e *Executor) SetAgentDrain(ctx context.Context, agent *models.Agent, drain bool) error {
+	payload, err := json.Marshal(map[string]bool{"drain": drain})
 	if err != nil {
-		e.markFailed(execution, fmt.Sprintf("failed to create request: %v", err))
-		return
+		return fmt.Errorf("failed to marshal drain request: %w", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := e.httpClient.Do(req)
-	if err != nil {
-		e.markFailed(execution, fmt.Sprintf("failed to send to agent: %v", err))
-		return
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+
+	resp, dispatchErr := e.agentRequest(ctx, agent.TenantID, agent.ID, http.MethodPost, "/agent/drain", payload, header)
+	if dispatchErr != nil {
+		return fmt.Errorf("failed to send drain request to agent: %w", dispatchErr)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-		e.markFailed(execution, fmt.Sprintf("agent returned status %d", resp.StatusCode))
-		return
+	var status struct {
+		State string `json:"state"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		e.logger.Warn("failed to decode agent drain response", zap.Error(err))
 	}
 
-	// Parse response
-	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		e.logger.Warn("failed to decode agent response", zap.Error(err))
+	// An idle agent is drained as soon as it stops accepting work
+	if drain && status.State == string(models.AgentDrainDrained) {
+		now := time.Now()
+		e.db.WithContext(ctx).Model(&models.Agent{}).
+			Where("id = ? AND tenant_id = ? AND drain_state = ?", agent.ID, agent.TenantID, models.AgentDrainDraining).
+			Updates(map[string]interface{}{
+				"drain_state": models.AgentDrainDrained,
+				"drained_at":  now,
+			})
 	}
 
-	e.logger.Info("workflow sent to agent",
-		zap.String("execution_id", execution.ID),
-		zap.String("agent_id", agent.ID))
+	return nil
 }
 
 // markFailed marks an execution as failed
@@ -156,7 +380,45 @@ func (e *Executor) markFailed(execution *models.WorkflowExecution, errorMsg stri
 
 	e.logger.Error("workflow execution failed",
 		zap.String("execution_id", execution.ID),
+		zap.String("request_id", execution.RequestID),
 		zap.String("error", errorMsg))
+
+	e.fireTriggers(context.Background(), execution.ID)
+}
+
+// markDispatchFailed marks an execution that could not be sent to its agent
+// as failed, recording whether the agent was unreachable or rejected it and
+// how many times delivery was tried
+func (e *Executor) markDispatchFailed(execution *models.WorkflowExecution, dispatchErr *DispatchError, deliveries int) {
+	dispatch := map[string]interface{}{
+		"failure":    dispatchErr.Class,
+		"attempts":   dispatchErr.Attempts,
+		"deliveries": deliveries,
+	}
+	if dispatchErr.StatusCode != 0 {
+		dispatch["status_code"] = dispatchErr.StatusCode
+	}
+
+	now := time.Now()
+	e.db.Model(execution).Updates(map[string]interface{}{
+		"status":       models.ExecutionStatusFailed,
+		"completed_at": now,
+		"result": models.JSONMap{
+			"error":    fmt.Sprintf("failed to send to agent: %v", dispatchErr),
+			"dispatch": dispatch,
+		},
+	})
+
+	e.logger.Error("workflow dispatch failed",
+		zap.String("execution_id", execution.ID),
+		zap.String("request_id", execution.RequestID),
+		zap.String("agent_id", execution.AgentID),
+		zap.String("failure", dispatchErr.Class),
+		zap.Int("attempts", dispatchErr.Attempts),
+		zap.Int("deliveries", deliveries),
+		zap.Error(dispatchErr))
+
+	e.fireTriggers(context.Background(), execution.ID)
 }
 
 // UpdateExecutionResult updates the result of an execution
@@ -173,24 +435,184 @@ func (e *Executor) UpdateExecutionResult(ctx context.Context, executionID string
 	return e.db.Model(&models.WorkflowExecution{}).Where("id = ?", executionID).Updates(updates).Error
 }
 
-// GetExecution retrieves an execution by ID
+// RecordAgentResult stores a workflow result reported by the agent that ran
+// the execution. The environment snapshot is kept in its own column so it can
+// be read without the step output.
+func (e *Executor) RecordAgentResult(ctx context.Context, tenantID, agentID, executionID string, result map[string]interface{}) error {
+	status := executionStatusFromAgent(result["status"])
+	normalizeAgentResult(result)
+
+	updates := map[string]interface{}{
+		"status": status,
+		"result": models.JSONMap(result),
+	}
+	if environment, ok := result["environment"].(map[string]interface{}); ok {
+		updates["environment"] = models.JSONMap(environment)
+	}
+	terminal := status == models.ExecutionStatusSuccess || status == models.ExecutionStatusFailed || status == models.ExecutionStatusCancelled
+	if terminal {
+		updates["completed_at"] = time.Now()
+	}
+
+	res := e.db.WithContext(ctx).Model(&models.WorkflowExecution{}).
+		Where("id = ? AND tenant_id = ? AND agent_id = ?", executionID, tenantID, agentID).
+		Updates(updates)
+	if res.Error != nil {
+		return fmt.Errorf("failed to record execution result: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return apperror.NotFound("execution not found")
+	}
+
+	if terminal && e.outputIndexer != nil {
+		var execution models.WorkflowExecution
+		if err := e.db.WithContext(ctx).
+			Select("id", "workflow_id", "tenant_id", "agent_id", "campaign_id").
+			Where("id = ?", executionID).
+			First(&execution).Error; err != nil {
+			e.logger.Warn("failed to load execution for output indexing",
+				zap.String("execution_id", executionID),
+				zap.Error(err))
+		} else {
+			e.outputIndexer.IndexResult(&execution, result)
+		}
+	}
+	if terminal {
+		e.fireTriggers(ctx, executionID)
+	}
+
+	return nil
+}
+
+// executionStatusFromAgent maps an agent workflow status to an execution status
+func executionStatusFromAgent(status interface{}) models.ExecutionStatus {
+	s, _ := status.(string)
+	switch s {
+	case "success":
+		return models.ExecutionStatusSuccess
+	case "failed":
+		return models.ExecutionStatusFailed
+	case "cancelled":
+		return models.ExecutionStatusCancelled
+	case "pending":
+		return models.ExecutionStatusPending
+	case "paused":
+		return models.ExecutionStatusPaused
+	default:
+		return models.ExecutionStatusRunning
+	}
+}
+
+// normalizeAgentResult rewrites the timings of a result reported by an agent
+// before duration_ms, so stored results read the same whatever agent version
+// ran them: durations in nanoseconds become whole milliseconds and
+// timestamps are converted to UTC
+func normalizeAgentResult(result map[string]interface{}) {
+	normalizeTimings(result, "duration", "paused_for")
+	steps, _ := result["steps"].([]interface{})
+	for _, raw := range steps {
+		step, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		normalizeTimings(step, "duration")
+		iterations, _ := step["iterations"].([]interface{})
+		for _, raw := range iterations {
+			if iteration, ok := raw.(map[string]interface{}); ok {
+				normalizeTimings(iteration, "duration")
+			}
+		}
+	}
+}
+
+// normalizeTimings converts the named nanosecond durations of a result to
+// their _ms fields and its timestamps to UTC
+func normalizeTimings(result map[string]interface{}, durations ...string) {
+	for _, field := range durations {
+		if _, legacy := result[field]; !legacy {
+			continue
+		}
+		if d, ok := models.AgentDuration(result, field); ok {
+			result[field+"_ms"] = d.Milliseconds()
+		}
+		delete(result, field)
+	}
+	for _, field := range []string{"started_at", "ended_at", "paused_at"} {
+		s, _ := result[field].(string)
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			result[field] = t.UTC().Format(time.RFC3339Nano)
+		}
+	}
+}
+
+// GetExecution retrieves an execution by ID. The output of an archived
+// execution is read back from the archive; if that fails the summary kept
+// in the database is returned with the error under result.archive_error.
 func (e *Executor) GetExecution(ctx context.Context, tenantID, executionID string) (*models.WorkflowExecution, error) {
 	var execution models.WorkflowExecution
 	if err := e.db.Where("id = ? AND tenant_id = ?", executionID, tenantID).First(&execution).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("execution not found")
+			return nil, apperror.NotFound("execution not found")
 		}
 		return nil, err
 	}
+
+	if execution.ArchiveKey != nil {
+		var err error
+		if e.archiver == nil {
+			err = fmt.Errorf("execution archival is not configured")
+		} else {
+			err = e.archiver.Restore(ctx, &execution)
+		}
+		if err != nil {
+			e.logger.Warn("failed to read archived execution output",
+				zap.String("execution_id", execution.ID),
+				zap.String("archive_key", *execution.ArchiveKey),
+				zap.Error(err))
+			if execution.Result == nil {
+				execution.Result = models.JSONMap{}
+			}
+			execution.Result["archive_error"] = err.Error()
+		}
+	}
 	return &execution, nil
 }
 
+// ListExecutionsRequest represents a request to list executions
+type ListExecutionsRequest struct {
+	TenantID   string
+	WorkflowID string
+	AgentID    string
+	CampaignID string
+	RequestID  string
+	Status     models.ExecutionStatus
+	// Adhoc, when set, lists only ad-hoc executions or only the others
+	Adhoc  *bool
+	Limit  int
+	Offset int
+}
+
 // ListExecutions lists executions
-func (e *Executor) ListExecutions(ctx context.Context, tenantID string, workflowID string, limit, offset int) ([]models.WorkflowExecution, int64, error) {
-	query := e.db.Model(&models.WorkflowExecution{}).Where("tenant_id = ?", tenantID)
+func (e *Executor) ListExecutions(ctx context.Context, req *ListExecutionsRequest) ([]models.WorkflowExecution, int64, error) {
+	query := e.db.WithContext(ctx).Model(&models.WorkflowExecution{}).Where("tenant_id = ?", req.TenantID)
 
-	if workflowID != "" {
-		query = query.Where("workflow_id = ?", workflowID)
+	if req.WorkflowID != "" {
+		query = query.Where("workflow_id = ?", req.WorkflowID)
+	}
+	if req.AgentID != "" {
+		query = query.Where("agent_id = ?", req.AgentID)
+	}
+	if req.CampaignID != "" {
+		query = query.Where("campaign_id = ?", req.CampaignID)
+	}
+	if req.RequestID != "" {
+		query = query.Where("request_id = ?", req.RequestID)
+	}
+	if req.Status != "" {
+		query = query.Where("status = ?", req.Status)
+	}
+	if req.Adhoc != nil {
+		query = query.Where("adhoc = ?", *req.Adhoc)
 	}
 
 	var total int64
@@ -198,11 +620,11 @@ func (e *Executor) ListExecutions(ctx context.Context, tenantID string, workflow
 		return nil, 0, err
 	}
 
-	if limit > 0 {
-		query = query.Limit(limit)
+	if req.Limit > 0 {
+		query = query.Limit(req.Limit)
 	}
-	if offset > 0 {
-		query = query.Offset(offset)
+	if req.Offset > 0 {
+		query = query.Offset(req.Offset)
 	}
 
 	var executions []models.WorkflowExecution
@@ -216,7 +638,7 @@ func (e *Executor) ListExecutions(ctx context.Context, tenantID string, workflow
 // CancelExecution cancels a running execution
 func (e *Executor) CancelExecution(ctx context.Context, tenantID, executionID string) error {
 	result := e.db.Model(&models.WorkflowExecution{}).
-		Where("id = ? AND tenant_id = ? AND status IN ?", executionID, tenantID, []models.ExecutionStatus{models.ExecutionStatusPending, models.ExecutionStatusRunning}).
+		Where("id = ? AND tenant_id = ? AND status IN ?", executionID, tenantID, []models.ExecutionStatus{models.ExecutionStatusPending, models.ExecutionStatusRunning, models.ExecutionStatusPaused}).
 		Updates(map[string]interface{}{
 			"status":       models.ExecutionStatusCancelled,
 			"completed_at": time.Now(),
@@ -227,8 +649,16 @@ func (e *Executor) CancelExecution(ctx context.Context, tenantID, executionID st
 	}
 
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("execution not found or already completed")
+		return apperror.InvalidState("execution not found or already completed")
 	}
 
+	// An execution still waiting in the outbox is never sent
+	e.db.Model(&models.DispatchJob{}).
+		Where("execution_id = ? AND status = ?", executionID, models.DispatchJobPending).
+		Updates(map[string]interface{}{
+			"status":     models.DispatchJobCancelled,
+			"updated_at": time.Now(),
+		})
+
 	return nil
 }
@@ -0,0 +1,648 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/tracing"
+)
+
+// Webhook hooks are sent with a per-attempt timeout and retried on network
+// errors, 429 and 5xx responses
+const (
+	hookTimeout     = 10 * time.Second
+	hookAttempts    = 3
+	hookRetryDelay  = time.Second
+	hookMaxResponse = 4 << 10
+)
+
+// redactedHeader replaces header values in API responses
+const redactedHeader = "********"
+
+// HookContext is the data hook templates are rendered with, and the
+// default webhook body
+type HookContext struct {
+	HookID          string     `json:"hook_id"`
+	TenantID        string     `json:"tenant_id"`
+	ExecutionID     string     `json:"execution_id"`
+	WorkflowID      string     `json:"workflow_id"`
+	WorkflowName    string     `json:"workflow_name"`
+	WorkflowVersion int        `json:"workflow_version"`
+	AgentID         string     `json:"agent_id"`
+	Hostname        string     `json:"hostname"`
+	CampaignID      string     `json:"campaign_id,omitempty"`
+	Status          string     `json:"status"`
+	Mode            string     `json:"mode"`
+	Error           string     `json:"error,omitempty"`
+	StartedAt       *time.Time `json:"started_at,omitempty"`
+	CompletedAt     *time.Time `json:"completed_at,omitempty"`
+	RequestID       string     `json:"request_id,omitempty"`
+}
+
+// hookFuncs are the functions available to hook templates besides the
+// text/template builtins
+var hookFuncs = template.FuncMap{
+	// json encodes a value, for embedding it in a JSON body
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// parseHookTemplate parses a hook template; missing fields are errors
+// rather than "<no value>"
+func parseHookTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(hookFuncs).Option("missingkey=error").Parse(text)
+}
+
+// renderHookTemplate renders a hook template with an execution
+func renderHookTemplate(name, text string, data *HookContext) (string, error) {
+	tmpl, err := parseHookTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", name, err)
+	}
+	return b.String(), nil
+}
+
+// CreateHookRequest represents a request to create a workflow hook
+type CreateHookRequest struct {
+	TenantID    string                  `json:"-"`
+	WorkflowID  string                  `json:"-"`
+	On          models.TriggerCondition `json:"on" binding:"required"`
+	Action      models.HookAction       `json:"action" binding:"required"`
+	Description string                  `json:"description"`
+
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+
+	TargetWorkflowID string `json:"target_workflow_id"`
+	TargetAgentID    string `json:"target_agent_id"`
+	Priority         string `json:"priority"`
+
+	CreatedBy string `json:"-"`
+}
+
+// UpdateHookRequest represents a request to update a workflow hook. Unset
+// fields are left unchanged; headers, when set, replace all headers.
+type UpdateHookRequest struct {
+	On          *models.TriggerCondition `json:"on"`
+	Enabled     *bool                    `json:"enabled"`
+	Description *string                  `json:"description"`
+
+	URL     *string            `json:"url"`
+	Method  *string            `json:"method"`
+	Headers *map[string]string `json:"headers"`
+	Body    *string            `json:"body"`
+
+	TargetWorkflowID *string `json:"target_workflow_id"`
+	TargetAgentID    *string `json:"target_agent_id"`
+	Priority         *string `json:"priority"`
+}
+
+// CreateHook creates a hook on a workflow
+func (m *Manager) CreateHook(ctx context.Context, req *CreateHookRequest) (*models.WorkflowHook, error) {
+	if err := m.requireWorkflow(ctx, req.TenantID, req.WorkflowID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	hook := &models.WorkflowHook{
+		ID:               uuid.New().String(),
+		TenantID:         req.TenantID,
+		WorkflowID:       req.WorkflowID,
+		On:               req.On,
+		Action:           req.Action,
+		Enabled:          true,
+		Description:      req.Description,
+		URL:              req.URL,
+		Method:           strings.ToUpper(req.Method),
+		Body:             req.Body,
+		TargetWorkflowID: req.TargetWorkflowID,
+		TargetAgentID:    req.TargetAgentID,
+		Priority:         req.Priority,
+		CreatedBy:        req.CreatedBy,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	if req.Headers != nil {
+		hook.Headers = headerMap(req.Headers)
+	}
+	if err := m.validateHook(ctx, hook); err != nil {
+		return nil, err
+	}
+
+	if err := m.db.WithContext(ctx).Create(hook).Error; err != nil {
+		return nil, fmt.Errorf("failed to create hook: %w", err)
+	}
+
+	m.logger.Info("workflow hook created",
+		zap.String("hook_id", hook.ID),
+		zap.String("workflow_id", hook.WorkflowID),
+		zap.String("action", string(hook.Action)),
+		zap.String("on", string(hook.On)))
+
+	return redactHook(hook), nil
+}
+
+// requireWorkflow checks a workflow exists and is not deleted
+func (m *Manager) requireWorkflow(ctx context.Context, tenantID, workflowID string) error {
+	var count int64
+	if err := m.db.WithContext(ctx).Model(&models.Workflow{}).
+		Where("id = ? AND tenant_id = ? AND status <> ?", workflowID, tenantID, models.WorkflowStatusDeleted).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to look up workflow: %w", err)
+	}
+	if count == 0 {
+		return apperror.NotFound("workflow not found")
+	}
+	return nil
+}
+
+// validateHook checks a hook's condition, its action's parameters and that
+// its templates parse. Fields of the other action are cleared.
+func (m *Manager) validateHook(ctx context.Context, hook *models.WorkflowHook) error {
+	switch hook.On {
+	case models.TriggerOnSuccess, models.TriggerOnFailure, models.TriggerOnCompletion:
+	default:
+		return apperror.InvalidInput("invalid hook condition %q: must be success, failure or completion", hook.On)
+	}
+
+	switch hook.Action {
+	case models.HookActionWebhook:
+		hook.TargetWorkflowID, hook.TargetAgentID, hook.Priority = "", "", ""
+		if hook.URL == "" {
+			return apperror.InvalidInput("a webhook hook requires a url")
+		}
+		if _, err := parseHookTemplate("url", hook.URL); err != nil {
+			return apperror.InvalidInput("invalid url template: %w", err)
+		}
+		// A URL without template actions can be checked now; rendered
+		// ones are checked before every request
+		if !strings.Contains(hook.URL, "{{") {
+			if err := checkHookURL(hook.URL); err != nil {
+				return apperror.InvalidInput("%w", err)
+			}
+		}
+		switch hook.Method {
+		case "":
+			hook.Method = http.MethodPost
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+		default:
+			return apperror.InvalidInput("invalid method %q: must be POST, PUT or PATCH", hook.Method)
+		}
+		for name, value := range hook.Headers {
+			if name == "" || strings.ContainsAny(name, " :\r\n") {
+				return apperror.InvalidInput("invalid header name %q", name)
+			}
+			text, ok := value.(string)
+			if !ok {
+				return apperror.InvalidInput("header %s must be a string", name)
+			}
+			if _, err := parseHookTemplate("header "+name, text); err != nil {
+				return apperror.InvalidInput("invalid header %s template: %w", name, err)
+			}
+		}
+		if _, err := parseHookTemplate("body", hook.Body); err != nil {
+			return apperror.InvalidInput("invalid body template: %w", err)
+		}
+
+	case models.HookActionWorkflow:
+		hook.URL, hook.Method, hook.Headers, hook.Body = "", "", nil, ""
+		if hook.TargetWorkflowID == "" {
+			return apperror.InvalidInput("a workflow hook requires a target_workflow_id")
+		}
+		if err := m.requireWorkflow(ctx, hook.TenantID, hook.TargetWorkflowID); err != nil {
+			return apperror.NotFound("target workflow not found")
+		}
+		if _, err := parseHookTemplate("target_agent_id", hook.TargetAgentID); err != nil {
+			return apperror.InvalidInput("invalid target_agent_id template: %w", err)
+		}
+		switch hook.Priority {
+		case "", "high", "normal", "low":
+		default:
+			return apperror.InvalidInput("invalid priority %q: must be high, normal or low", hook.Priority)
+		}
+
+	default:
+		return apperror.InvalidInput("invalid hook action %q: must be webhook or workflow", hook.Action)
+	}
+	return nil
+}
+
+// checkHookURL checks a webhook URL is an absolute http or https URL
+func checkHookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook url must be an absolute http or https url")
+	}
+	return nil
+}
+
+// headerMap converts request headers to the stored column
+func headerMap(headers map[string]string) models.JSONMap {
+	m := make(models.JSONMap, len(headers))
+	for name, value := range headers {
+		m[http.CanonicalHeaderKey(name)] = value
+	}
+	return m
+}
+
+// redactHook returns a copy of a hook with its header values hidden
+func redactHook(hook *models.WorkflowHook) *models.WorkflowHook {
+	redacted := *hook
+	if hook.Headers != nil {
+		redacted.Headers = make(models.JSONMap, len(hook.Headers))
+		for name := range hook.Headers {
+			redacted.Headers[name] = redactedHeader
+		}
+	}
+	return &redacted
+}
+
+// getHook returns a hook with its header values
+func (m *Manager) getHook(ctx context.Context, tenantID, workflowID, hookID string) (*models.WorkflowHook, error) {
+	var hook models.WorkflowHook
+	if err := m.db.WithContext(ctx).
+		Where("id = ? AND tenant_id = ? AND workflow_id = ?", hookID, tenantID, workflowID).
+		First(&hook).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("hook not found")
+		}
+		return nil, fmt.Errorf("failed to get hook: %w", err)
+	}
+	return &hook, nil
+}
+
+// GetHook retrieves a workflow's hook by ID
+func (m *Manager) GetHook(ctx context.Context, tenantID, workflowID, hookID string) (*models.WorkflowHook, error) {
+	hook, err := m.getHook(ctx, tenantID, workflowID, hookID)
+	if err != nil {
+		return nil, err
+	}
+	return redactHook(hook), nil
+}
+
+// ListHooks lists a workflow's hooks
+func (m *Manager) ListHooks(ctx context.Context, tenantID, workflowID string) ([]*models.WorkflowHook, error) {
+	var hooks []models.WorkflowHook
+	if err := m.db.WithContext(ctx).
+		Where("tenant_id = ? AND workflow_id = ?", tenantID, workflowID).
+		Order("created_at ASC").
+		Find(&hooks).Error; err != nil {
+		return nil, fmt.Errorf("failed to list hooks: %w", err)
+	}
+
+	redacted := make([]*models.WorkflowHook, len(hooks))
+	for i := range hooks {
+		redacted[i] = redactHook(&hooks[i])
+	}
+	return redacted, nil
+}
+
+// UpdateHook changes a workflow's hook
+func (m *Manager) UpdateHook(ctx context.Context, tenantID, workflowID, hookID string, req *UpdateHookRequest) (*models.WorkflowHook, error) {
+	hook, err := m.getHook(ctx, tenantID, workflowID, hookID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.On != nil {
+		hook.On = *req.On
+	}
+	if req.Enabled != nil {
+		hook.Enabled = *req.Enabled
+	}
+	if req.Description != nil {
+		hook.Description = *req.Description
+	}
+	if req.URL != nil {
+		hook.URL = *req.URL
+	}
+	if req.Method != nil {
+		hook.Method = strings.ToUpper(*req.Method)
+	}
+	if req.Headers != nil {
+		hook.Headers = headerMap(*req.Headers)
+	}
+	if req.Body != nil {
+		hook.Body = *req.Body
+	}
+	if req.TargetWorkflowID != nil {
+		hook.TargetWorkflowID = *req.TargetWorkflowID
+	}
+	if req.TargetAgentID != nil {
+		hook.TargetAgentID = *req.TargetAgentID
+	}
+	if req.Priority != nil {
+		hook.Priority = *req.Priority
+	}
+	if err := m.validateHook(ctx, hook); err != nil {
+		return nil, err
+	}
+
+	hook.UpdatedAt = time.Now()
+	if err := m.db.WithContext(ctx).Save(hook).Error; err != nil {
+		return nil, fmt.Errorf("failed to update hook: %w", err)
+	}
+	return redactHook(hook), nil
+}
+
+// DeleteHook deletes a workflow's hook
+func (m *Manager) DeleteHook(ctx context.Context, tenantID, workflowID, hookID string) error {
+	result := m.db.WithContext(ctx).
+		Where("id = ? AND tenant_id = ? AND workflow_id = ?", hookID, tenantID, workflowID).
+		Delete(&models.WorkflowHook{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete hook: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return apperror.NotFound("hook not found")
+	}
+	return nil
+}
+
+// ListHookRuns lists the hooks an execution fired
+func (m *Manager) ListHookRuns(ctx context.Context, tenantID, executionID string) ([]models.WorkflowHookRun, error) {
+	var runs []models.WorkflowHookRun
+	if err := m.db.WithContext(ctx).
+		Where("tenant_id = ? AND execution_id = ?", tenantID, executionID).
+		Order("started_at ASC").
+		Find(&runs).Error; err != nil {
+		return nil, fmt.Errorf("failed to list hook runs: %w", err)
+	}
+	return runs, nil
+}
+
+// fireHooks runs the hooks of a finished execution's workflow in the
+// background. It is called once per execution, with its triggers.
+func (e *Executor) fireHooks(execution *models.WorkflowExecution) {
+	var hooks []models.WorkflowHook
+	if err := e.db.
+		Where("tenant_id = ? AND workflow_id = ? AND enabled = ?", execution.TenantID, execution.WorkflowID, true).
+		Find(&hooks).Error; err != nil {
+		e.logger.Warn("failed to load workflow hooks",
+			zap.String("execution_id", execution.ID),
+			zap.Error(err))
+		return
+	}
+
+	var matching []models.WorkflowHook
+	for _, hook := range hooks {
+		if hook.On.Matches(execution.Status) {
+			matching = append(matching, hook)
+		}
+	}
+	if len(matching) == 0 {
+		return
+	}
+
+	// Shutdown waits for hooks already running; once draining none start
+	if !e.beginDispatch() {
+		e.logger.Warn("workflow hooks skipped: control plane is shutting down",
+			zap.String("execution_id", execution.ID),
+			zap.Int("hooks", len(matching)))
+		return
+	}
+	go func() {
+		defer e.inflight.Done()
+
+		data, err := e.hookContext(execution)
+		if err != nil {
+			e.logger.Warn("failed to load execution for hooks",
+				zap.String("execution_id", execution.ID),
+				zap.Error(err))
+			return
+		}
+		for i := range matching {
+			e.runHook(&matching[i], execution, data)
+		}
+	}()
+}
+
+// hookContext loads the template data of a finished execution
+func (e *Executor) hookContext(execution *models.WorkflowExecution) (*HookContext, error) {
+	var full models.WorkflowExecution
+	if err := e.db.
+		Select("id", "workflow_id", "tenant_id", "agent_id", "campaign_id", "status", "workflow_version", "execution_mode", "request_id", "result", "started_at", "completed_at").
+		Preload("Workflow", func(db *gorm.DB) *gorm.DB { return db.Select("id", "name") }).
+		Preload("Agent", func(db *gorm.DB) *gorm.DB { return db.Select("id", "hostname") }).
+		Where("id = ?", execution.ID).
+		First(&full).Error; err != nil {
+		return nil, err
+	}
+
+	data := &HookContext{
+		TenantID:        full.TenantID,
+		ExecutionID:     full.ID,
+		WorkflowID:      full.WorkflowID,
+		WorkflowName:    full.Workflow.Name,
+		WorkflowVersion: full.WorkflowVersion,
+		AgentID:         full.AgentID,
+		Hostname:        full.Agent.Hostname,
+		Status:          string(full.Status),
+		Mode:            string(full.Mode),
+		StartedAt:       full.StartedAt,
+		CompletedAt:     full.CompletedAt,
+		RequestID:       full.RequestID,
+	}
+	if full.CampaignID != nil {
+		data.CampaignID = *full.CampaignID
+	}
+	if msg, ok := full.Result["error"].(string); ok {
+		data.Error = msg
+	}
+	return data, nil
+}
+
+// runHook runs one hook and records the run
+func (e *Executor) runHook(hook *models.WorkflowHook, execution *models.WorkflowExecution, data *HookContext) {
+	hookData := *data
+	hookData.HookID = hook.ID
+
+	run := &models.WorkflowHookRun{
+		ID:          uuid.New().String(),
+		TenantID:    hook.TenantID,
+		HookID:      hook.ID,
+		ExecutionID: execution.ID,
+		Action:      hook.Action,
+		StartedAt:   time.Now(),
+	}
+
+	var err error
+	switch hook.Action {
+	case models.HookActionWebhook:
+		err = e.sendWebhook(hook, &hookData, run)
+	case models.HookActionWorkflow:
+		err = e.runHookWorkflow(hook, execution, &hookData, run)
+	default:
+		err = fmt.Errorf("unknown hook action %q", hook.Action)
+	}
+
+	run.CompletedAt = time.Now()
+	run.Status = models.HookRunSuccess
+	if err != nil {
+		run.Status = models.HookRunFailed
+		run.Error = err.Error()
+		e.logger.Warn("workflow hook failed",
+			zap.String("execution_id", execution.ID),
+			zap.String("hook_id", hook.ID),
+			zap.String("action", string(hook.Action)),
+			zap.Error(err))
+	} else {
+		e.logger.Info("workflow hook fired",
+			zap.String("execution_id", execution.ID),
+			zap.String("hook_id", hook.ID),
+			zap.String("action", string(hook.Action)))
+	}
+
+	if err := e.db.Create(run).Error; err != nil {
+		e.logger.Warn("failed to record workflow hook run",
+			zap.String("execution_id", execution.ID),
+			zap.String("hook_id", hook.ID),
+			zap.Error(err))
+	}
+}
+
+// sendWebhook sends a webhook hook's request, retrying transient failures.
+// Without a body template the hook context is sent as JSON.
+func (e *Executor) sendWebhook(hook *models.WorkflowHook, data *HookContext, run *models.WorkflowHookRun) error {
+	target, err := renderHookTemplate("url", hook.URL, data)
+	if err != nil {
+		return err
+	}
+	if err := checkHookURL(target); err != nil {
+		return err
+	}
+
+	var body []byte
+	if hook.Body == "" {
+		if body, err = json.Marshal(data); err != nil {
+			return fmt.Errorf("failed to encode webhook body: %w", err)
+		}
+	} else {
+		rendered, err := renderHookTemplate("body", hook.Body, data)
+		if err != nil {
+			return err
+		}
+		body = []byte(rendered)
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("User-Agent", "vm-manager-control-plane")
+	if data.RequestID != "" {
+		header.Set(tracing.Header, data.RequestID)
+	}
+	for name, value := range hook.Headers {
+		text, _ := value.(string)
+		rendered, err := renderHookTemplate("header "+name, text, data)
+		if err != nil {
+			return err
+		}
+		header.Set(name, rendered)
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= hookAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(hookRetryDelay * time.Duration(1<<(attempt-2)))
+		}
+		run.Attempts = attempt
+
+		retry, err := e.sendWebhookAttempt(hook.Method, target, header, body, run)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+	return lastErr
+}
+
+// sendWebhookAttempt sends one webhook request and reports whether a
+// failure is worth retrying
+func (e *Executor) sendWebhookAttempt(method, target string, header http.Header, body []byte, run *models.WorkflowHookRun) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header = header.Clone()
+
+	resp, err := e.hookClient.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("failed to send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	run.StatusCode = resp.StatusCode
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, hookMaxResponse))
+		return false, nil
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, hookMaxResponse))
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook returned %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+}
+
+// runHookWorkflow starts a workflow hook's workflow. It counts as a
+// trigger: the execution joins the finished execution's trigger chain.
+func (e *Executor) runHookWorkflow(hook *models.WorkflowHook, execution *models.WorkflowExecution, data *HookContext, run *models.WorkflowHookRun) error {
+	run.Attempts = 1
+	if execution.TriggerDepth >= MaxTriggerDepth {
+		return fmt.Errorf("trigger chain depth limit of %d reached", MaxTriggerDepth)
+	}
+
+	agentID := execution.AgentID
+	if hook.TargetAgentID != "" {
+		rendered, err := renderHookTemplate("target_agent_id", hook.TargetAgentID, data)
+		if err != nil {
+			return err
+		}
+		agentID = strings.TrimSpace(rendered)
+	}
+
+	// A validate execution only starts validate executions
+	ctx := tracing.WithRequestID(context.Background(), execution.RequestID)
+	triggered, err := e.Execute(ctx, &ExecuteRequest{
+		TenantID:     execution.TenantID,
+		WorkflowID:   hook.TargetWorkflowID,
+		AgentID:      agentID,
+		Priority:     hook.Priority,
+		Mode:         execution.Mode,
+		TriggeredBy:  execution.ID,
+		triggerDepth: execution.TriggerDepth + 1,
+	})
+	if err != nil {
+		return err
+	}
+	run.TriggeredExecutionID = &triggered.ID
+	return nil
+}
@@ -0,0 +1,413 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// LintSeverity ranks a lint finding
+type LintSeverity string
+
+const (
+	// LintError findings are almost certainly mistakes
+	LintError LintSeverity = "error"
+	// LintWarning findings break a best practice
+	LintWarning LintSeverity = "warning"
+	// LintInfo findings are suggestions
+	LintInfo LintSeverity = "info"
+)
+
+// Lint rules
+const (
+	LintRuleStepTimeout      = "step-timeout"
+	LintRuleWorkflowTimeout  = "workflow-timeout"
+	LintRuleRetryDelay       = "retry-delay"
+	LintRuleDangerousCommand = "dangerous-command"
+	LintRuleRollback         = "rollback-hook"
+	LintRuleRunAsRoot        = "run-as-root"
+)
+
+// lintIgnoreComment begins comments suppressing rules in YAML sources, as
+// in "# lint:ignore step-timeout,run-as-root"
+const lintIgnoreComment = "lint:ignore"
+
+// lintIgnoreField lists the rules a workflow or step suppresses in
+// definitions without comments, such as those sent as JSON
+const lintIgnoreField = "lint_ignore"
+
+// LintFinding is one best-practice problem in a workflow definition
+type LintFinding struct {
+	Rule     string       `json:"rule"`
+	Severity LintSeverity `json:"severity"`
+	// Path locates the finding, as in validation errors ("steps[2].command");
+	// it is empty for findings about the whole workflow
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+// LintReport is the result of linting a workflow definition
+type LintReport struct {
+	// Valid reports whether the definition passes validation; Errors are
+	// the validation errors otherwise
+	Valid    bool          `json:"valid"`
+	Errors   []string      `json:"errors,omitempty"`
+	Findings []LintFinding `json:"findings"`
+	// Counts are the findings per severity
+	Counts map[LintSeverity]int `json:"counts"`
+	// Suppressed counts findings silenced by lint:ignore comments or
+	// lint_ignore fields
+	Suppressed int `json:"suppressed"`
+}
+
+// dangerousCommand is a shell pattern likely to damage the host
+type dangerousCommand struct {
+	pattern  *regexp.Regexp
+	severity LintSeverity
+	message  string
+}
+
+var dangerousCommands = []dangerousCommand{
+	{regexp.MustCompile(`\brm\s+(-[a-zA-Z]+\s+|--[a-z-]+\s+)*-[a-zA-Z]*[rR][a-zA-Z]*\s+(-[a-zA-Z]+\s+)*(/\*?|~/?|\$HOME/?|/(bin|boot|etc|lib|lib64|opt|root|sbin|usr|var)/?)(\s|;|&|\||$)`), LintError,
+		"recursively deletes the root filesystem, a system directory or the home directory"},
+	{regexp.MustCompile(`\bmkfs(\.[a-z0-9]+)?\s`), LintError, "formats a filesystem"},
+	{regexp.MustCompile(`\bdd\b[^;&|\n]*\bof=/dev/(sd|hd|vd|xvd|nvme|mmcblk|disk)`), LintError, "writes directly to a block device"},
+	{regexp.MustCompile(`>\s*/dev/(sd|hd|vd|xvd|nvme|mmcblk|disk)[a-z0-9]*`), LintError, "overwrites a block device"},
+	{regexp.MustCompile(`:\(\)\s*\{\s*:\s*\|\s*:\s*&\s*\}\s*;\s*:`), LintError, "is a fork bomb"},
+	{regexp.MustCompile(`\bchmod\s+(-[a-zA-Z]+\s+)*(0?777|a\+rwx|ugo\+rwx)\s+/(\s|;|&|\||$)`), LintError, "makes the root filesystem world-writable"},
+	{regexp.MustCompile(`(?i)\bformat-volume\b|\bformat(\.com)?\s+[a-z]:`), LintError, "formats a volume"},
+	{regexp.MustCompile(`(?i)\b(remove-item|rd|rmdir)\b[^;&|\n]*\s[a-z]:\\?(\s|$)`), LintError, "deletes a whole drive"},
+	{regexp.MustCompile(`\b(curl|wget)\b[^;&|\n]*\|\s*(sudo\s+)?(ba|z|k|da)?sh\b`), LintWarning, "pipes a download into a shell without verifying it"},
+	{regexp.MustCompile(`(?i)\b(iex|invoke-expression)\b[^;\n]*\b(iwr|invoke-webrequest|downloadstring)\b|\b(iwr|invoke-webrequest|downloadstring)\b[^;\n]*\|\s*(iex|invoke-expression)\b`), LintWarning, "runs a downloaded script without verifying it"},
+	{regexp.MustCompile(`(?i)\b(shutdown|reboot|halt|poweroff|restart-computer|stop-computer)\b`), LintWarning, "restarts or stops the host, ending the workflow"},
+	{regexp.MustCompile(`\bsetenforce\s+0\b|\biptables\s+(-[a-zA-Z]+\s+)*-F\b|\bufw\s+disable\b|(?i:\bset-mppreference\b[^;\n]*-disablerealtimemonitoring\s+\$?true)`), LintWarning, "disables a host security control"},
+}
+
+// rootAccounts are run_as values granting full control of the host
+var rootAccounts = map[string]bool{
+	"root":                      true,
+	"0":                         true,
+	"system":                    true,
+	"nt authority\\system":      true,
+	"localsystem":               true,
+	"administrator":             true,
+	"builtin\\administrators":   true,
+	"nt authority\\localsystem": true,
+}
+
+// sudoPattern matches commands that elevate themselves
+var sudoPattern = regexp.MustCompile(`(^|[;&|]\s*|\n\s*)(sudo|doas|su\s+-c)\s`)
+
+// timedStepTypes are the steps that wait on something the agent does not
+// control and so should bound how long they take
+var timedStepTypes = map[string]bool{"command": true, "script": true, "http": true, "plugin": true, "callback": true}
+
+// mutatingStepTypes are the steps that change the host
+var mutatingStepTypes = map[string]bool{"command": true, "script": true, "file": true, "template": true, "plugin": true}
+
+// Lint checks a workflow definition against best practices. The definition
+// is validated first; findings are reported for invalid definitions too.
+// The policy, which may be nil, fills in step timeouts as when the
+// workflow is saved.
+func Lint(definition map[string]interface{}, policy *models.WorkflowPolicy) *LintReport {
+	return lint(definition, policy, nil)
+}
+
+// LintSource parses a YAML or JSON workflow definition and lints it.
+// "# lint:ignore <rule>[,<rule>]" comments suppress rules: on a step for
+// that step, at the top of the document for the whole workflow. Sources
+// in the Git sync format are linted by their definition.
+func LintSource(source []byte, policy *models.WorkflowPolicy) (*LintReport, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(source, &doc); err != nil {
+		return nil, apperror.InvalidInput("invalid workflow source: %v", err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, apperror.InvalidInput("workflow source must be a mapping")
+	}
+
+	root := doc.Content[0]
+	ignored := make(map[string]map[string]bool)
+	addIgnored(ignored, "", doc.HeadComment, root.HeadComment)
+	if len(root.Content) > 0 {
+		addIgnored(ignored, "", root.Content[0].HeadComment)
+	}
+	if _, ok := mappingValue(root, "kind"); ok {
+		if definition, ok := mappingValue(root, "definition"); ok && definition.Kind == yaml.MappingNode {
+			root = definition
+		}
+	}
+
+	for _, field := range []string{"steps", "on_success", "on_failure", "on_cancel"} {
+		steps, ok := mappingValue(root, field)
+		if !ok || steps.Kind != yaml.SequenceNode {
+			continue
+		}
+		for i, step := range steps.Content {
+			path := fmt.Sprintf("%s[%d]", field, i)
+			addIgnored(ignored, path, step.HeadComment, step.LineComment)
+			if len(step.Content) > 1 {
+				addIgnored(ignored, path, step.Content[0].HeadComment, step.Content[0].LineComment, step.Content[1].LineComment)
+			}
+		}
+	}
+
+	// Decoding through JSON gives definitions the shape they have when
+	// sent to the API
+	var decoded interface{}
+	if err := root.Decode(&decoded); err != nil {
+		return nil, apperror.InvalidInput("invalid workflow source: %v", err)
+	}
+	raw, err := json.Marshal(decoded)
+	if err != nil {
+		return nil, apperror.InvalidInput("invalid workflow source: %v", err)
+	}
+	var definition map[string]interface{}
+	if err := json.Unmarshal(raw, &definition); err != nil {
+		return nil, apperror.InvalidInput("invalid workflow source: %v", err)
+	}
+
+	return lint(definition, policy, ignored), nil
+}
+
+// LintDefinition lints a definition against the tenant's workflow policy
+func (m *Manager) LintDefinition(ctx context.Context, tenantID string, definition map[string]interface{}) (*LintReport, error) {
+	policy, err := LoadPolicy(ctx, m.db, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return Lint(definition, policy), nil
+}
+
+// LintSource lints a YAML or JSON source against the tenant's workflow policy
+func (m *Manager) LintSource(ctx context.Context, tenantID string, source []byte) (*LintReport, error) {
+	policy, err := LoadPolicy(ctx, m.db, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return LintSource(source, policy)
+}
+
+// LintWorkflow lints a stored workflow's definition
+func (m *Manager) LintWorkflow(ctx context.Context, tenantID, workflowID string) (*LintReport, error) {
+	wf, err := m.Get(ctx, tenantID, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	// Stored definitions already carry the policy's defaults
+	return Lint(wf.Definition, nil), nil
+}
+
+// linter collects the findings of one definition
+type linter struct {
+	report  *LintReport
+	ignored map[string]map[string]bool
+}
+
+// lint lints a definition; ignored maps paths to the rules suppressed
+// there by comments, "" holding those suppressed for the whole workflow
+func lint(definition map[string]interface{}, policy *models.WorkflowPolicy, ignored map[string]map[string]bool) *LintReport {
+	report := &LintReport{
+		Valid:    true,
+		Findings: []LintFinding{},
+		Counts:   make(map[LintSeverity]int),
+	}
+	if err := NewValidator().Validate(definition); err != nil {
+		report.Valid = false
+		if errs, ok := err.(ValidationErrors); ok {
+			for _, e := range errs {
+				report.Errors = append(report.Errors, e.Error())
+			}
+		} else {
+			report.Errors = append(report.Errors, err.Error())
+		}
+	}
+
+	if ignored == nil {
+		ignored = make(map[string]map[string]bool)
+	}
+	addIgnoredRules(ignored, "", definition[lintIgnoreField])
+	l := &linter{report: report, ignored: ignored}
+
+	if _, ok := definition["timeout"]; !ok && (policy == nil || policy.MaxWorkflowTimeout == "") {
+		l.add(LintRuleWorkflowTimeout, LintInfo, "", "", "workflow has no timeout; it is bounded only by its step timeouts")
+	}
+
+	mutating := false
+	for _, field := range []string{"steps", "on_success", "on_failure", "on_cancel"} {
+		steps, _ := definition[field].([]interface{})
+		for i, raw := range steps {
+			step, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			path := fmt.Sprintf("%s[%d]", field, i)
+			addIgnoredRules(ignored, path, step[lintIgnoreField])
+			l.lintStep(path, step, policy)
+
+			stepType, _ := step["type"].(string)
+			if field == "steps" && mutatingStepTypes[stepType] {
+				mutating = true
+			}
+		}
+	}
+
+	if hooks, _ := definition["on_failure"].([]interface{}); mutating && len(hooks) == 0 {
+		l.add(LintRuleRollback, LintWarning, "", "on_failure",
+			"workflow changes the host but has no on_failure steps to roll back a failed run")
+	}
+
+	return report
+}
+
+// lintStep checks one step
+func (l *linter) lintStep(path string, step map[string]interface{}, policy *models.WorkflowPolicy) {
+	stepType, _ := step["type"].(string)
+
+	if _, ok := step["timeout"]; !ok && timedStepTypes[stepType] &&
+		(policy == nil || (policy.DefaultStepTimeout == "" && policy.MaxStepTimeout == "")) {
+		l.add(LintRuleStepTimeout, LintWarning, path, path+".timeout",
+			fmt.Sprintf("%s step has no timeout; a hung step holds the agent until the workflow times out", stepType))
+	}
+
+	if retries := lintNumber(step["retry_count"]); retries > 0 {
+		delay, _ := step["retry_delay"].(string)
+		if d, err := time.ParseDuration(delay); delay == "" || (err == nil && d <= 0) {
+			l.add(LintRuleRetryDelay, LintWarning, path, path+".retry_delay",
+				fmt.Sprintf("step retries %d times without a retry_delay; retries run back to back", int(retries)))
+		}
+	}
+
+	if repeat, ok := step["repeat"].(map[string]interface{}); ok {
+		delay, _ := repeat["delay"].(string)
+		if d, err := time.ParseDuration(delay); delay == "" || (err == nil && d <= 0) {
+			l.add(LintRuleRetryDelay, LintWarning, path, path+".repeat.delay",
+				"step repeats without a delay; iterations run back to back")
+		}
+	}
+
+	type shellField struct {
+		field string
+		text  string
+	}
+	var texts []shellField
+	if command, ok := step["command"].(string); ok {
+		line := command
+		if args, ok := step["args"].([]interface{}); ok {
+			for _, arg := range args {
+				line += " " + fmt.Sprint(arg)
+			}
+		}
+		texts = append(texts, shellField{"command", line})
+	}
+	if script, ok := step["script"].(string); ok {
+		texts = append(texts, shellField{"script", script})
+	}
+	elevated := false
+	for _, text := range texts {
+		for _, dangerous := range dangerousCommands {
+			if dangerous.pattern.MatchString(text.text) {
+				l.add(LintRuleDangerousCommand, dangerous.severity, path, path+"."+text.field,
+					fmt.Sprintf("%s %s", text.field, dangerous.message))
+			}
+		}
+		if !elevated && sudoPattern.MatchString(text.text) {
+			elevated = true
+			l.add(LintRuleRunAsRoot, LintWarning, path, path+"."+text.field,
+				fmt.Sprintf("%s elevates with sudo; grant the least privilege the step needs with run_as", text.field))
+		}
+	}
+
+	if runAs, ok := step["run_as"].(string); ok && rootAccounts[strings.ToLower(strings.TrimSpace(runAs))] {
+		l.add(LintRuleRunAsRoot, LintWarning, path, path+".run_as",
+			fmt.Sprintf("step runs as %s; run it as an account with only the access it needs", runAs))
+	}
+}
+
+// add records a finding unless a suppression covers it. scope is the path
+// of the step whose suppressions apply.
+func (l *linter) add(rule string, severity LintSeverity, scope, path, message string) {
+	if l.ignored[""][rule] || (scope != "" && l.ignored[scope][rule]) {
+		l.report.Suppressed++
+		return
+	}
+	l.report.Findings = append(l.report.Findings, LintFinding{
+		Rule:     rule,
+		Severity: severity,
+		Path:     path,
+		Message:  message,
+	})
+	l.report.Counts[severity]++
+}
+
+// addIgnored records the rules suppressed by lint:ignore comments
+func addIgnored(ignored map[string]map[string]bool, path string, comments ...string) {
+	for _, comment := range comments {
+		for _, line := range strings.Split(comment, "\n") {
+			line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "#"))
+			rules, ok := strings.CutPrefix(line, lintIgnoreComment)
+			if !ok {
+				continue
+			}
+			for _, rule := range strings.FieldsFunc(rules, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+				ignore(ignored, path, rule)
+			}
+		}
+	}
+}
+
+// addIgnoredRules records the rules suppressed by a lint_ignore field
+func addIgnoredRules(ignored map[string]map[string]bool, path string, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		ignore(ignored, path, v)
+	case []interface{}:
+		for _, rule := range v {
+			if s, ok := rule.(string); ok {
+				ignore(ignored, path, s)
+			}
+		}
+	}
+}
+
+func ignore(ignored map[string]map[string]bool, path, rule string) {
+	rule = strings.TrimSpace(rule)
+	if rule == "" {
+		return
+	}
+	if ignored[path] == nil {
+		ignored[path] = make(map[string]bool)
+	}
+	ignored[path][rule] = true
+}
+
+// mappingValue returns the value of a key in a YAML mapping
+func mappingValue(node *yaml.Node, key string) (*yaml.Node, bool) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1], true
+		}
+	}
+	return nil, false
+}
+
+// lintNumber reads a number decoded from JSON or YAML
+func lintNumber(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return 0
+}
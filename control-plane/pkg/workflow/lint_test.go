@@ -0,0 +1,225 @@
+package workflow
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// findings lists a report's findings as "rule path", sorted
+func findings(report *LintReport) []string {
+	var got []string
+	for _, f := range report.Findings {
+		got = append(got, f.Rule+" "+f.Path)
+	}
+	sort.Strings(got)
+	return got
+}
+
+func TestLintSource(t *testing.T) {
+	tests := []struct {
+		name           string
+		source         string
+		want           []string
+		wantSuppressed int
+		wantInvalid    bool
+		wantErr        bool
+	}{
+		{
+			name: "clean",
+			source: `name: check
+timeout: 10m
+steps:
+  - id: health
+    name: health
+    type: http
+    url: http://localhost/health
+    timeout: 30s
+`,
+		},
+		{
+			name: "step findings",
+			source: `name: cleanup
+timeout: 10m
+steps:
+  - id: wipe
+    name: wipe
+    type: command
+    command: sudo rm -rf /
+    retry_count: 3
+on_failure:
+  - id: notify
+    name: notify
+    type: command
+    command: echo failed
+    timeout: 5s
+`,
+			want: []string{
+				"dangerous-command steps[0].command",
+				"retry-delay steps[0].retry_delay",
+				"run-as-root steps[0].command",
+				"step-timeout steps[0].timeout",
+			},
+		},
+		{
+			name: "workflow findings",
+			source: `name: install
+steps:
+  - id: install
+    name: install
+    type: script
+    script: curl -fsSL https://get.example.com | sh
+    run_as: root
+    timeout: 5m
+`,
+			want: []string{
+				"dangerous-command steps[0].script",
+				"rollback-hook on_failure",
+				"run-as-root steps[0].run_as",
+				"workflow-timeout ",
+			},
+		},
+		{
+			name: "comments suppress rules for the workflow and a step",
+			source: `# lint:ignore workflow-timeout, rollback-hook
+name: install
+steps:
+  # lint:ignore step-timeout
+  - id: install
+    name: install
+    type: command
+    command: make install
+  - id: reboot # lint:ignore dangerous-command
+    name: reboot
+    type: command
+    command: reboot
+    timeout: 1m
+  - id: check
+    name: other
+    type: command
+    command: make check
+`,
+			want:           []string{"step-timeout steps[2].timeout"},
+			wantSuppressed: 4,
+		},
+		{
+			name: "comments elsewhere are not suppressions",
+			source: `name: install
+timeout: 10m
+# lint ignore step-timeout
+steps:
+  - id: install # lint:ignored
+    name: install
+    type: command
+    command: make
+`,
+			want: []string{"rollback-hook on_failure", "step-timeout steps[0].timeout"},
+		},
+		{
+			name: "lint_ignore fields",
+			source: `name: install
+timeout: 10m
+lint_ignore: rollback-hook
+steps:
+  - id: install
+    name: install
+    type: command
+    command: make install
+    lint_ignore: [step-timeout, run-as-root]
+    run_as: root
+`,
+			wantSuppressed: 3,
+		},
+		{
+			name: "git sync format",
+			source: `kind: workflow
+name: check
+definition:
+  name: check
+  timeout: 10m
+  steps:
+    - id: uptime
+      name: uptime
+      type: http
+      url: http://localhost/health
+`,
+			want: []string{"step-timeout steps[0].timeout"},
+		},
+		{
+			name:   "json",
+			source: `{"name": "check", "timeout": "10m", "steps": [{"id": "a", "name": "a", "type": "http", "url": "http://localhost/health"}]}`,
+			want:   []string{"step-timeout steps[0].timeout"},
+		},
+		{
+			name:        "invalid definitions are linted too",
+			source:      "timeout: 10m\nsteps: []\n",
+			wantInvalid: true,
+		},
+		{
+			name:    "not a mapping",
+			source:  "- name: check\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			source:  "",
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			source:  "name: [check\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := LintSource([]byte(tt.source), nil)
+			if tt.wantErr {
+				if !errors.Is(err, apperror.ErrInvalidInput) {
+					t.Fatalf("LintSource error = %v, want invalid input", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LintSource: %v", err)
+			}
+			if report.Valid == tt.wantInvalid {
+				t.Errorf("valid = %v, errors %v", report.Valid, report.Errors)
+			}
+			if got := findings(report); strings.Join(got, "; ") != strings.Join(tt.want, "; ") {
+				t.Errorf("findings = %q, want %q", got, tt.want)
+			}
+			if report.Suppressed != tt.wantSuppressed {
+				t.Errorf("suppressed = %d, want %d", report.Suppressed, tt.wantSuppressed)
+			}
+		})
+	}
+}
+
+func TestLintPolicyDefaults(t *testing.T) {
+	definition := map[string]interface{}{
+		"name":  "check",
+		"steps": []interface{}{map[string]interface{}{"id": "a", "name": "a", "type": "http", "url": "http://localhost/health"}},
+	}
+
+	// Timeouts the policy fills in when the workflow is saved are not
+	// reported missing
+	report := Lint(definition, &models.WorkflowPolicy{DefaultStepTimeout: "5m", MaxWorkflowTimeout: "1h"})
+	if got := findings(report); len(got) != 0 {
+		t.Errorf("findings under a policy with timeouts = %q", got)
+	}
+
+	report = Lint(definition, nil)
+	want := []string{"step-timeout steps[0].timeout", "workflow-timeout "}
+	if got := findings(report); strings.Join(got, "; ") != strings.Join(want, "; ") {
+		t.Errorf("findings = %q, want %q", got, want)
+	}
+	if report.Counts[LintWarning] != 1 || report.Counts[LintInfo] != 1 {
+		t.Errorf("counts = %v", report.Counts)
+	}
+}
@@ -0,0 +1,107 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// agentLoadTimeout bounds the status request made before a dispatch, so an
+// agent that is slow to answer does not hold up other dispatches
+const agentLoadTimeout = 5 * time.Second
+
+// AgentLoad is an agent's execution queue depth as reported by its probe
+// health check
+type AgentLoad struct {
+	ActiveJobs    int       `json:"active_jobs"`
+	MaxConcurrent int       `json:"max_concurrent"`
+	Source        string    `json:"source"` // "agent" or "health_report"
+	ObservedAt    time.Time `json:"observed_at"`
+}
+
+// Busy reports whether the agent is running as many jobs as it accepts
+func (l *AgentLoad) Busy() bool {
+	return l.MaxConcurrent > 0 && l.ActiveJobs >= l.MaxConcurrent
+}
+
+// AgentLoad asks the agent for its current queue depth through the Piko
+// proxy. If the agent does not answer, the last stored health report is used;
+// nil is returned when neither is available.
+func (e *Executor) AgentLoad(ctx context.Context, tenantID, agentID string) (*AgentLoad, error) {
+	load, err := e.liveAgentLoad(ctx, tenantID, agentID)
+	if err == nil && load != nil {
+		return load, nil
+	}
+
+	var reports []models.AgentHealthReport
+	if err := e.db.WithContext(ctx).
+		Where("tenant_id = ? AND agent_id = ?", tenantID, agentID).
+		Order("reported_at DESC").
+		Limit(1).
+		Find(&reports).Error; err != nil {
+		return nil, fmt.Errorf("failed to load health report: %w", err)
+	}
+	if len(reports) == 0 {
+		return nil, nil
+	}
+
+	load = probeLoad(reports[0].Components)
+	if load != nil {
+		load.Source = "health_report"
+		load.ObservedAt = reports[0].ReportedAt
+	}
+	return load, nil
+}
+
+// liveAgentLoad reads the probe component from the agent's status endpoint
+func (e *Executor) liveAgentLoad(ctx context.Context, tenantID, agentID string) (*AgentLoad, error) {
+	ctx, cancel := context.WithTimeout(ctx, agentLoadTimeout)
+	defer cancel()
+
+	resp, dispatchErr := e.agentRequest(ctx, tenantID, agentID, http.MethodGet, "/status", nil, nil)
+	if dispatchErr != nil {
+		return nil, dispatchErr
+	}
+	defer resp.Body.Close()
+
+	var status struct {
+		Components map[string]interface{} `json:"components"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("failed to decode agent status: %w", err)
+	}
+
+	load := probeLoad(status.Components)
+	if load != nil {
+		load.Source = "agent"
+		load.ObservedAt = time.Now()
+	}
+	return load, nil
+}
+
+// probeLoad extracts the probe queue depth from health components
+func probeLoad(components map[string]interface{}) *AgentLoad {
+	probe, ok := components["probe"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	details, ok := probe["details"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	active, ok := details["active_jobs"].(float64)
+	if !ok {
+		return nil
+	}
+	max, _ := details["max_concurrent"].(float64)
+
+	return &AgentLoad{
+		ActiveJobs:    int(active),
+		MaxConcurrent: int(max),
+	}
+}
@@ -4,12 +4,15 @@ package workflow
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 
+	"github.com/yourorg/control-plane/pkg/apperror"
 	"github.com/yourorg/control-plane/pkg/db/models"
 	"github.com/yourorg/control-plane/pkg/tenant"
 )
@@ -32,11 +35,16 @@ func NewManager(db *gorm.DB, logger *zap.Logger) *Manager {
 
 // CreateWorkflowRequest represents a request to create a workflow
 type CreateWorkflowRequest struct {
-	TenantID    string                 `json:"tenant_id" binding:"required"`
+	TenantID    string                 `json:"tenant_id"`
 	Name        string                 `json:"name" binding:"required"`
 	Description string                 `json:"description"`
 	Definition  map[string]interface{} `json:"definition" binding:"required"`
+	Tags        map[string]string      `json:"tags"`
 	CreatedBy   string                 `json:"created_by"`
+
+	// PolicyOverride skips the tenant workflow policy's ceilings; only
+	// tenant admins may set it
+	PolicyOverride bool `json:"policy_override"`
 }
 
 // Create creates a new workflow
@@ -49,7 +57,13 @@ func (m *Manager) Create(ctx context.Context, req *CreateWorkflowRequest) (*mode
 	// Validate workflow definition
 	validator := NewValidator()
 	if err := validator.Validate(req.Definition); err != nil {
-		return nil, fmt.Errorf("workflow validation failed: %w", err)
+		return nil, apperror.InvalidInput("workflow validation failed: %w", err)
+	}
+	if err := m.applyPolicy(ctx, req.TenantID, req.Definition, req.PolicyOverride); err != nil {
+		return nil, err
+	}
+	if err := validateTags(req.Tags); err != nil {
+		return nil, err
 	}
 
 	workflow := &models.Workflow{
@@ -60,6 +74,7 @@ func (m *Manager) Create(ctx context.Context, req *CreateWorkflowRequest) (*mode
 		Definition:  req.Definition,
 		Version:     1,
 		Status:      models.WorkflowStatusDraft,
+		Tags:        tagsToJSONMap(req.Tags),
 		CreatedBy:   req.CreatedBy,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
@@ -79,10 +94,14 @@ func (m *Manager) Create(ctx context.Context, req *CreateWorkflowRequest) (*mode
 
 // Get retrieves a workflow by ID
 func (m *Manager) Get(ctx context.Context, tenantID, workflowID string) (*models.Workflow, error) {
+	if workflowID == AdhocWorkflowID(tenantID) {
+		return nil, apperror.NotFound("workflow not found")
+	}
+
 	var workflow models.Workflow
 	if err := m.db.Where("id = ? AND tenant_id = ?", workflowID, tenantID).First(&workflow).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("workflow not found")
+			return nil, apperror.NotFound("workflow not found")
 		}
 		return nil, fmt.Errorf("failed to get workflow: %w", err)
 	}
@@ -95,14 +114,39 @@ type UpdateWorkflowRequest struct {
 	Description *string                `json:"description"`
 	Definition  map[string]interface{} `json:"definition"`
 	Status      *models.WorkflowStatus `json:"status"`
+	Tags        map[string]string      `json:"tags"`
+
+	// ExpectedVersion is the version the client last read; the update fails
+	// with a conflict if the workflow has changed since. Every update stores a
+	// new version. ExpectedUpdatedAt is accepted instead from clients that
+	// predate expected_version.
+	ExpectedVersion   *int       `json:"expected_version"`
+	ExpectedUpdatedAt *time.Time `json:"expected_updated_at"`
+
+	// PolicyOverride skips the tenant workflow policy's ceilings for the
+	// new definition; only tenant admins may set it
+	PolicyOverride bool `json:"policy_override"`
 }
 
-// Update updates a workflow
+// Update updates a workflow. Updates are rejected with an apperror.StaleError
+// holding the current workflow if it was modified after the expected version.
 func (m *Manager) Update(ctx context.Context, tenantID, workflowID string, req *UpdateWorkflowRequest) (*models.Workflow, error) {
+	if req.ExpectedVersion == nil && req.ExpectedUpdatedAt == nil {
+		return nil, apperror.InvalidInput("expected_version or expected_updated_at is required")
+	}
+
 	workflow, err := m.Get(ctx, tenantID, workflowID)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkNotSynced(workflow); err != nil {
+		return nil, err
+	}
+
+	if (req.ExpectedVersion != nil && *req.ExpectedVersion != workflow.Version) ||
+		(req.ExpectedUpdatedAt != nil && !req.ExpectedUpdatedAt.Equal(workflow.UpdatedAt)) {
+		return nil, apperror.Stale(workflow, "workflow was modified by another update (current version %d)", workflow.Version)
+	}
 
 	updates := make(map[string]interface{})
 
@@ -115,23 +159,44 @@ func (m *Manager) Update(ctx context.Context, tenantID, workflowID string, req *
 	if req.Definition != nil {
 		validator := NewValidator()
 		if err := validator.Validate(req.Definition); err != nil {
-			return nil, fmt.Errorf("workflow validation failed: %w", err)
+			return nil, apperror.InvalidInput("workflow validation failed: %w", err)
+		}
+		if err := m.applyPolicy(ctx, tenantID, req.Definition, req.PolicyOverride); err != nil {
+			return nil, err
 		}
 		updates["definition"] = req.Definition
-		updates["version"] = workflow.Version + 1
 	}
 	if req.Status != nil {
 		updates["status"] = *req.Status
 	}
+	if req.Tags != nil {
+		if err := validateTags(req.Tags); err != nil {
+			return nil, err
+		}
+		updates["tags"] = tagsToJSONMap(req.Tags)
+	}
 
 	if len(updates) == 0 {
 		return workflow, nil
 	}
 
+	// Every update stores a new version, so the version alone tells whether
+	// another update landed since the read above
+	updates["version"] = workflow.Version + 1
 	updates["updated_at"] = time.Now()
 
-	if err := m.db.Model(workflow).Updates(updates).Error; err != nil {
-		return nil, fmt.Errorf("failed to update workflow: %w", err)
+	result := m.db.Model(&models.Workflow{}).
+		Where("id = ? AND tenant_id = ? AND version = ?", workflowID, tenantID, workflow.Version).
+		Updates(updates)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to update workflow: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		current, err := m.Get(ctx, tenantID, workflowID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, apperror.Stale(current, "workflow was modified by another update (current version %d)", current.Version)
 	}
 
 	return m.Get(ctx, tenantID, workflowID)
@@ -139,16 +204,18 @@ func (m *Manager) Update(ctx context.Context, tenantID, workflowID string, req *
 
 // Delete soft-deletes a workflow
 func (m *Manager) Delete(ctx context.Context, tenantID, workflowID string) error {
-	result := m.db.Model(&models.Workflow{}).
-		Where("id = ? AND tenant_id = ?", workflowID, tenantID).
-		Update("status", models.WorkflowStatusDeleted)
-
-	if result.Error != nil {
-		return fmt.Errorf("failed to delete workflow: %w", result.Error)
+	workflow, err := m.Get(ctx, tenantID, workflowID)
+	if err != nil {
+		return err
 	}
-
-	if result.RowsAffected == 0 {
-		return fmt.Errorf("workflow not found")
+	if err := checkNotSynced(workflow); err != nil {
+		return err
+	}
+	if workflow.Status == models.WorkflowStatusDeleted {
+		return apperror.NotFound("workflow not found")
+	}
+	if err := m.setStatus(ctx, workflow, models.WorkflowStatusDeleted); err != nil {
+		return err
 	}
 
 	m.logger.Info("workflow deleted",
@@ -162,13 +229,24 @@ func (m *Manager) Delete(ctx context.Context, tenantID, workflowID string) error
 type ListWorkflowsRequest struct {
 	TenantID string
 	Status   models.WorkflowStatus
-	Limit    int
-	Offset   int
+	// Name matches workflows whose name contains the value
+	Name string
+	// Tags matches workflows having all of the given tag values
+	Tags      map[string]string
+	CreatedBy string
+	// ExecutedAfter and ExecutedBefore bound the time of the workflow's most
+	// recent execution; workflows never executed do not match either
+	ExecutedAfter  *time.Time
+	ExecutedBefore *time.Time
+	// Query matches workflows whose name, description or definition contains the value
+	Query  string
+	Limit  int
+	Offset int
 }
 
 // List lists workflows
 func (m *Manager) List(ctx context.Context, req *ListWorkflowsRequest) ([]models.Workflow, int64, error) {
-	query := m.db.Model(&models.Workflow{}).Where("tenant_id = ?", req.TenantID)
+	query := m.db.Model(&models.Workflow{}).Where("tenant_id = ? AND id <> ?", req.TenantID, AdhocWorkflowID(req.TenantID))
 
 	if req.Status != "" {
 		query = query.Where("status = ?", req.Status)
@@ -176,6 +254,37 @@ func (m *Manager) List(ctx context.Context, req *ListWorkflowsRequest) ([]models
 		query = query.Where("status != ?", models.WorkflowStatusDeleted)
 	}
 
+	if req.Name != "" {
+		query = query.Where("name LIKE ? ESCAPE '!'", containsPattern(req.Name))
+	}
+	if err := validateTags(req.Tags); err != nil {
+		return nil, 0, err
+	}
+	for key, value := range req.Tags {
+		query = query.Where("JSON_EXTRACT(tags, ?) = ?", `$."`+key+`"`, value)
+	}
+	if req.CreatedBy != "" {
+		query = query.Where("created_by = ?", req.CreatedBy)
+	}
+	if req.ExecutedAfter != nil || req.ExecutedBefore != nil {
+		lastExecuted := m.db.Model(&models.WorkflowExecution{}).
+			Select("workflow_id").
+			Where("tenant_id = ?", req.TenantID).
+			Group("workflow_id")
+		if req.ExecutedAfter != nil {
+			lastExecuted = lastExecuted.Having("MAX(created_at) >= ?", *req.ExecutedAfter)
+		}
+		if req.ExecutedBefore != nil {
+			lastExecuted = lastExecuted.Having("MAX(created_at) <= ?", *req.ExecutedBefore)
+		}
+		query = query.Where("id IN (?)", lastExecuted)
+	}
+	if req.Query != "" {
+		pattern := containsPattern(req.Query)
+		query = query.Where("(name LIKE ? ESCAPE '!' OR description LIKE ? ESCAPE '!' OR CAST(definition AS CHAR) LIKE ? ESCAPE '!')",
+			pattern, pattern, pattern)
+	}
+
 	var total int64
 	if err := query.Count(&total).Error; err != nil {
 		return nil, 0, fmt.Errorf("failed to count workflows: %w", err)
@@ -198,34 +307,109 @@ func (m *Manager) List(ctx context.Context, req *ListWorkflowsRequest) ([]models
 
 // Activate activates a workflow
 func (m *Manager) Activate(ctx context.Context, tenantID, workflowID string) error {
-	result := m.db.Model(&models.Workflow{}).
-		Where("id = ? AND tenant_id = ? AND status = ?", workflowID, tenantID, models.WorkflowStatusDraft).
-		Update("status", models.WorkflowStatusActive)
-
-	if result.Error != nil {
-		return fmt.Errorf("failed to activate workflow: %w", result.Error)
+	workflow, err := m.Get(ctx, tenantID, workflowID)
+	if err != nil {
+		return err
 	}
-
-	if result.RowsAffected == 0 {
-		return fmt.Errorf("workflow not found or not in draft status")
+	if err := checkNotSynced(workflow); err != nil {
+		return err
+	}
+	if workflow.Status != models.WorkflowStatusDraft {
+		return apperror.InvalidState("workflow not found or not in draft status")
+	}
+	if err := m.setStatus(ctx, workflow, models.WorkflowStatusActive); err != nil {
+		return err
 	}
-
 	return nil
 }
 
 // Deprecate deprecates a workflow
 func (m *Manager) Deprecate(ctx context.Context, tenantID, workflowID string) error {
-	result := m.db.Model(&models.Workflow{}).
-		Where("id = ? AND tenant_id = ? AND status = ?", workflowID, tenantID, models.WorkflowStatusActive).
-		Update("status", models.WorkflowStatusDeprecated)
+	workflow, err := m.Get(ctx, tenantID, workflowID)
+	if err != nil {
+		return err
+	}
+	if err := checkNotSynced(workflow); err != nil {
+		return err
+	}
+	if workflow.Status != models.WorkflowStatusActive {
+		return apperror.InvalidState("workflow not found or not active")
+	}
+	if err := m.setStatus(ctx, workflow, models.WorkflowStatusDeprecated); err != nil {
+		return err
+	}
+	return nil
+}
 
+// setStatus moves a workflow read by the caller to another status. Like an
+// update it stores a new version, and fails with an apperror.StaleError if
+// the workflow was modified after it was read.
+func (m *Manager) setStatus(ctx context.Context, workflow *models.Workflow, status models.WorkflowStatus) error {
+	result := m.db.Model(&models.Workflow{}).
+		Where("id = ? AND tenant_id = ? AND version = ?", workflow.ID, workflow.TenantID, workflow.Version).
+		Updates(map[string]interface{}{
+			"status":     status,
+			"version":    workflow.Version + 1,
+			"updated_at": time.Now(),
+		})
 	if result.Error != nil {
-		return fmt.Errorf("failed to deprecate workflow: %w", result.Error)
+		return fmt.Errorf("failed to set workflow status: %w", result.Error)
 	}
-
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("workflow not found or not active")
+		current, err := m.Get(ctx, workflow.TenantID, workflow.ID)
+		if err != nil {
+			return err
+		}
+		return apperror.Stale(current, "workflow was modified by another update (current version %d)", current.Version)
 	}
+	return nil
+}
 
+// tagKeyPattern restricts tag keys to names usable in a JSON path
+var tagKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// applyPolicy applies the tenant's workflow policy to a definition about to
+// be saved
+func (m *Manager) applyPolicy(ctx context.Context, tenantID string, definition map[string]interface{}, override bool) error {
+	policy, err := LoadPolicy(ctx, m.db, tenantID)
+	if err != nil {
+		return err
+	}
+	if err := ApplyPolicy(definition, policy, override); err != nil {
+		return err
+	}
+	if override && policy != nil {
+		m.logger.Info("workflow policy overridden",
+			zap.String("tenant_id", tenantID))
+	}
 	return nil
 }
+
+// validateTags checks that tag keys can be used in tag filters
+func validateTags(tags map[string]string) error {
+	for key := range tags {
+		if !tagKeyPattern.MatchString(key) {
+			return apperror.InvalidInput("invalid tag key %q: must start with a letter or underscore and contain only letters, digits, '_' and '-'", key)
+		}
+	}
+	return nil
+}
+
+// tagsToJSONMap converts workflow tags for storage
+func tagsToJSONMap(tags map[string]string) models.JSONMap {
+	if tags == nil {
+		return nil
+	}
+	m := make(models.JSONMap, len(tags))
+	for k, v := range tags {
+		m[k] = v
+	}
+	return m
+}
+
+// containsPattern returns a LIKE pattern (escape character '!') matching
+// values that contain s
+func containsPattern(s string) string {
+	s = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+	return "%" + s + "%"
+}
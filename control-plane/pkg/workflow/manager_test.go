@@ -0,0 +1,238 @@
+package workflow
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/internal/dbtest"
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// workflowRow answers the statements of Update and status changes for a
+// single workflow.
+// interleave runs before an UPDATE is applied, standing in for an update
+// that landed between the read and the write.
+type workflowRow struct {
+	t          *testing.T
+	name       string
+	version    int
+	status     models.WorkflowStatus
+	updatedAt  time.Time
+	interleave func(*workflowRow)
+}
+
+func (w *workflowRow) handle(query string, args []driver.Value) (*dbtest.Result, error) {
+	switch {
+	case strings.HasPrefix(query, "SELECT"):
+		status := w.status
+		if status == "" {
+			status = models.WorkflowStatusDraft
+		}
+		return &dbtest.Result{
+			Columns: []string{"id", "tenant_id", "name", "version", "status", "updated_at"},
+			Rows:    [][]driver.Value{{"wf-1", "tenant-1", w.name, int64(w.version), string(status), w.updatedAt}},
+		}, nil
+	case strings.HasPrefix(query, "UPDATE `workflows`"):
+		_, where, _ := strings.Cut(query, " WHERE ")
+		if strings.Contains(where, "updated_at") {
+			w.t.Errorf("update is guarded on updated_at: %s", where)
+		}
+		if w.interleave != nil {
+			w.interleave(w)
+			w.interleave = nil
+		}
+		set, conditions := dbtest.Assignments(query, args)
+		if conditions[len(conditions)-1] != int64(w.version) {
+			return &dbtest.Result{}, nil
+		}
+		if name, ok := set["name"]; ok {
+			w.name = name.(string)
+		}
+		if status, ok := set["status"]; ok {
+			w.status = models.WorkflowStatus(status.(string))
+		}
+		w.version = int(set["version"].(int64))
+		w.updatedAt = set["updated_at"].(time.Time)
+		return &dbtest.Result{RowsAffected: 1}, nil
+	}
+	w.t.Errorf("unexpected statement: %s", query)
+	return &dbtest.Result{}, nil
+}
+
+func TestUpdateOptimisticLock(t *testing.T) {
+	readAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	intp := func(v int) *int { return &v }
+	timep := func(v time.Time) *time.Time { return &v }
+	renamed := "renamed"
+	bump := func(w *workflowRow) {
+		w.version++
+		w.updatedAt = w.updatedAt.Add(time.Second)
+	}
+	// touch changes updated_at alone, as Git sync deprecating a workflow does
+	touch := func(w *workflowRow) { w.updatedAt = w.updatedAt.Add(time.Second) }
+
+	tests := []struct {
+		name        string
+		req         UpdateWorkflowRequest
+		interleave  func(*workflowRow)
+		wantErr     error
+		wantStale   int
+		wantVersion int
+	}{
+		{
+			name:        "name change stores a new version",
+			req:         UpdateWorkflowRequest{Name: &renamed, ExpectedVersion: intp(3)},
+			wantVersion: 4,
+		},
+		{
+			name:        "legacy expected_updated_at",
+			req:         UpdateWorkflowRequest{Name: &renamed, ExpectedUpdatedAt: timep(readAt)},
+			wantVersion: 4,
+		},
+		{
+			name:    "expectation required",
+			req:     UpdateWorkflowRequest{Name: &renamed},
+			wantErr: apperror.ErrInvalidInput,
+		},
+		{
+			name:      "outdated version",
+			req:       UpdateWorkflowRequest{Name: &renamed, ExpectedVersion: intp(2)},
+			wantErr:   apperror.ErrConflict,
+			wantStale: 3,
+		},
+		{
+			name:      "outdated updated_at",
+			req:       UpdateWorkflowRequest{Name: &renamed, ExpectedUpdatedAt: timep(readAt.Add(-time.Second))},
+			wantErr:   apperror.ErrConflict,
+			wantStale: 3,
+		},
+		{
+			name:       "concurrent update",
+			req:        UpdateWorkflowRequest{Name: &renamed, ExpectedVersion: intp(3)},
+			interleave: bump,
+			wantErr:    apperror.ErrConflict,
+			wantStale:  4,
+		},
+		{
+			name:        "concurrent change of updated_at alone",
+			req:         UpdateWorkflowRequest{Name: &renamed, ExpectedVersion: intp(3)},
+			interleave:  touch,
+			wantVersion: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := &workflowRow{t: t, name: "original", version: 3, updatedAt: readAt, interleave: tt.interleave}
+			m := NewManager(dbtest.Open(t, row.handle), zap.NewNop())
+
+			updated, err := m.Update(context.Background(), "tenant-1", "wf-1", &tt.req)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Update error = %v, want %v", err, tt.wantErr)
+				}
+				var stale *apperror.StaleError
+				if tt.wantStale > 0 {
+					if !errors.As(err, &stale) {
+						t.Fatalf("Update error = %v, want a stale error", err)
+					}
+					if current := stale.Current.(*models.Workflow); current.Version != tt.wantStale {
+						t.Errorf("stale error holds version %d, want %d", current.Version, tt.wantStale)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			if updated.Version != tt.wantVersion || updated.Name != renamed {
+				t.Errorf("updated to %q version %d, want %q version %d", updated.Name, updated.Version, renamed, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestSetStatusOptimisticLock(t *testing.T) {
+	bump := func(w *workflowRow) { w.version++ }
+
+	tests := []struct {
+		name        string
+		status      models.WorkflowStatus
+		change      func(m *Manager) error
+		interleave  func(*workflowRow)
+		wantErr     error
+		wantStatus  models.WorkflowStatus
+		wantVersion int
+	}{
+		{
+			name:        "activate stores a new version",
+			status:      models.WorkflowStatusDraft,
+			change:      func(m *Manager) error { return m.Activate(context.Background(), "tenant-1", "wf-1") },
+			wantStatus:  models.WorkflowStatusActive,
+			wantVersion: 4,
+		},
+		{
+			name:        "deprecate stores a new version",
+			status:      models.WorkflowStatusActive,
+			change:      func(m *Manager) error { return m.Deprecate(context.Background(), "tenant-1", "wf-1") },
+			wantStatus:  models.WorkflowStatusDeprecated,
+			wantVersion: 4,
+		},
+		{
+			name:        "delete stores a new version",
+			status:      models.WorkflowStatusActive,
+			change:      func(m *Manager) error { return m.Delete(context.Background(), "tenant-1", "wf-1") },
+			wantStatus:  models.WorkflowStatusDeleted,
+			wantVersion: 4,
+		},
+		{
+			name:       "activate not in draft",
+			status:     models.WorkflowStatusActive,
+			change:     func(m *Manager) error { return m.Activate(context.Background(), "tenant-1", "wf-1") },
+			wantErr:    apperror.ErrInvalidState,
+			wantStatus: models.WorkflowStatusActive,
+		},
+		{
+			name:       "delete already deleted",
+			status:     models.WorkflowStatusDeleted,
+			change:     func(m *Manager) error { return m.Delete(context.Background(), "tenant-1", "wf-1") },
+			wantErr:    apperror.ErrNotFound,
+			wantStatus: models.WorkflowStatusDeleted,
+		},
+		{
+			name:       "concurrent update",
+			status:     models.WorkflowStatusActive,
+			change:     func(m *Manager) error { return m.Deprecate(context.Background(), "tenant-1", "wf-1") },
+			interleave: bump,
+			wantErr:    apperror.ErrConflict,
+			wantStatus: models.WorkflowStatusActive,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := &workflowRow{t: t, name: "original", version: 3, status: tt.status, interleave: tt.interleave}
+			m := NewManager(dbtest.Open(t, row.handle), zap.NewNop())
+
+			err := tt.change(m)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if row.status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", row.status, tt.wantStatus)
+			}
+			if tt.wantErr == nil && row.version != tt.wantVersion {
+				t.Errorf("version = %d, want %d", row.version, tt.wantVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// PauseExecution asks the agent running an execution to pause it before its
+// next pause point. The step running meanwhile finishes; the execution
+// becomes paused when the agent reports it stopped.
+func (e *Executor) PauseExecution(ctx context.Context, tenantID, executionID string) error {
+	execution, err := e.pausableExecution(ctx, tenantID, executionID)
+	if err != nil {
+		return err
+	}
+	if execution.Status != models.ExecutionStatusRunning {
+		return apperror.InvalidState("execution is %s; only running executions can be paused", execution.Status)
+	}
+
+	return e.relayPause(ctx, execution, "pause")
+}
+
+// ResumeExecution resumes a paused execution, or withdraws a pause the
+// agent has not reached yet
+func (e *Executor) ResumeExecution(ctx context.Context, tenantID, executionID string) error {
+	execution, err := e.pausableExecution(ctx, tenantID, executionID)
+	if err != nil {
+		return err
+	}
+	if execution.Status != models.ExecutionStatusPaused && execution.Status != models.ExecutionStatusRunning {
+		return apperror.InvalidState("execution is %s, not paused", execution.Status)
+	}
+
+	if err := e.relayPause(ctx, execution, "resume"); err != nil {
+		return err
+	}
+
+	// The agent reports the execution running again once it has a slot;
+	// until then it is no longer paused either
+	return e.db.WithContext(ctx).Model(&models.WorkflowExecution{}).
+		Where("id = ? AND status = ?", execution.ID, models.ExecutionStatusPaused).
+		Update("status", models.ExecutionStatusRunning).Error
+}
+
+// pausableExecution loads the execution a pause or resume is for
+func (e *Executor) pausableExecution(ctx context.Context, tenantID, executionID string) (*models.WorkflowExecution, error) {
+	var execution models.WorkflowExecution
+	if err := e.db.WithContext(ctx).Select("id", "tenant_id", "agent_id", "status", "request_id").
+		Where("id = ? AND tenant_id = ?", executionID, tenantID).
+		First(&execution).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("execution not found")
+		}
+		return nil, fmt.Errorf("failed to get execution: %w", err)
+	}
+	return &execution, nil
+}
+
+// relayPause sends a pause or resume request for an execution to its agent
+func (e *Executor) relayPause(ctx context.Context, execution *models.WorkflowExecution, action string) error {
+	ctx, cancel := context.WithTimeout(ctx, e.dispatchTimeout())
+	defer cancel()
+
+	resp, dispatchErr := e.agentRequest(ctx, execution.TenantID, execution.AgentID, http.MethodPost, "/workflow/"+action+"?id="+url.QueryEscape(execution.ID), nil, nil)
+	if dispatchErr != nil {
+		switch dispatchErr.StatusCode {
+		case http.StatusConflict:
+			return apperror.InvalidState("agent refused to %s the execution: %v", action, dispatchErr)
+		case http.StatusNotFound:
+			return apperror.InvalidState("agent no longer has the execution")
+		}
+		e.logger.Warn("failed to relay execution "+action,
+			zap.String("execution_id", execution.ID),
+			zap.String("agent_id", execution.AgentID),
+			zap.Error(dispatchErr))
+		return fmt.Errorf("failed to send %s request to agent: %w", action, dispatchErr)
+	}
+	resp.Body.Close()
+
+	e.logger.Info("relayed execution "+action,
+		zap.String("execution_id", execution.ID),
+		zap.String("request_id", execution.RequestID),
+		zap.String("agent_id", execution.AgentID))
+	return nil
+}
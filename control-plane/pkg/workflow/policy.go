@@ -0,0 +1,308 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// stepTypes are the step types a policy may allow
+var stepTypes = []string{"command", "script", "file", "http", "validate", "template", "plugin", "callback"}
+
+// scriptInterpreters are the interpreters the agent runs script steps with
+var scriptInterpreters = []string{"sh", "bash", "python3", "pwsh", "cmd"}
+
+// interpreterAliases map shebang program names to interpreters, as on the agent
+var interpreterAliases = map[string]string{
+	"python":     "python3",
+	"powershell": "pwsh",
+}
+
+// sha256Pattern matches a hex SHA-256, optionally prefixed "sha256:"
+var sha256Pattern = regexp.MustCompile(`^(sha256:)?[0-9a-fA-F]{64}$`)
+
+// ValidatePolicy checks a workflow policy is well formed
+func ValidatePolicy(policy *models.WorkflowPolicy) error {
+	var errors ValidationErrors
+
+	durations := []struct{ field, value string }{
+		{"max_workflow_timeout", policy.MaxWorkflowTimeout},
+		{"max_step_timeout", policy.MaxStepTimeout},
+		{"default_step_timeout", policy.DefaultStepTimeout},
+	}
+	parsed := make(map[string]time.Duration)
+	for _, duration := range durations {
+		field, value := duration.field, duration.value
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			errors = append(errors, ValidationError{field, fmt.Sprintf("must be a positive duration, got %q", value)})
+			continue
+		}
+		parsed[field] = d
+	}
+	if def, ok := parsed["default_step_timeout"]; ok {
+		if max, ok := parsed["max_step_timeout"]; ok && def > max {
+			errors = append(errors, ValidationError{"default_step_timeout", "exceeds max_step_timeout"})
+		}
+	}
+
+	if policy.MaxRetryCount != nil && *policy.MaxRetryCount < 0 {
+		errors = append(errors, ValidationError{"max_retry_count", "must be non-negative"})
+	}
+	for i, t := range policy.AllowedStepTypes {
+		if !contains(stepTypes, t) {
+			errors = append(errors, ValidationError{fmt.Sprintf("allowed_step_types[%d]", i), fmt.Sprintf("unknown step type %q", t)})
+		}
+	}
+	for i, name := range policy.AllowedInterpreters {
+		if !contains(scriptInterpreters, name) {
+			errors = append(errors, ValidationError{fmt.Sprintf("allowed_interpreters[%d]", i), fmt.Sprintf("unknown interpreter %q: must be sh, bash, python3, pwsh or cmd", name)})
+		}
+	}
+	names := make([]string, 0, len(policy.AllowedPlugins))
+	for name := range policy.AllowedPlugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field := "allowed_plugins." + name
+		if !pluginNamePattern.MatchString(name) {
+			errors = append(errors, ValidationError{field, "plugin name must be lowercase letters, digits, - and _"})
+		}
+		if !sha256Pattern.MatchString(policy.AllowedPlugins[name]) {
+			errors = append(errors, ValidationError{field, "must be the executable's hex SHA-256"})
+		}
+	}
+
+	if len(errors) > 0 {
+		return apperror.InvalidInput("invalid workflow policy: %w", errors)
+	}
+	return nil
+}
+
+// LoadPolicy returns the tenant's workflow policy, or nil when it has none
+func LoadPolicy(ctx context.Context, db *gorm.DB, tenantID string) (*models.WorkflowPolicy, error) {
+	var tenant models.Tenant
+	if err := db.WithContext(ctx).Select("id", "workflow_policy").Where("id = ?", tenantID).First(&tenant).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("tenant not found")
+		}
+		return nil, fmt.Errorf("failed to load workflow policy: %w", err)
+	}
+	if tenant.WorkflowPolicy.IsEmpty() {
+		return nil, nil
+	}
+	return tenant.WorkflowPolicy, nil
+}
+
+// ApplyPolicy fills the policy's defaults into a validated definition and,
+// unless override is set, checks it against the policy's ceilings
+func ApplyPolicy(definition map[string]interface{}, policy *models.WorkflowPolicy, override bool) error {
+	if policy.IsEmpty() {
+		return nil
+	}
+
+	var errors ValidationErrors
+	if !override && policy.MaxWorkflowTimeout != "" {
+		if timeout, ok := definition["timeout"].(string); ok {
+			errors = append(errors, checkCeiling("timeout", timeout, policy.MaxWorkflowTimeout)...)
+		}
+	}
+	for _, field := range []string{"steps", "on_success", "on_failure", "on_cancel"} {
+		steps, _ := definition[field].([]interface{})
+		for i, raw := range steps {
+			step, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			prefix := fmt.Sprintf("%s[%d]", field, i)
+			applyStepDefaults(step, policy)
+			if !override {
+				errors = append(errors, checkStep(prefix, step, policy)...)
+			}
+		}
+	}
+
+	if len(errors) > 0 {
+		return apperror.InvalidInput("workflow violates the tenant's workflow policy: %w", errors)
+	}
+	return nil
+}
+
+// applyStepDefaults sets the policy's defaults on a step that leaves them
+// unset
+func applyStepDefaults(step map[string]interface{}, policy *models.WorkflowPolicy) {
+	if _, ok := step["timeout"]; !ok {
+		switch {
+		case policy.DefaultStepTimeout != "":
+			step["timeout"] = policy.DefaultStepTimeout
+		case policy.MaxStepTimeout != "":
+			step["timeout"] = policy.MaxStepTimeout
+		}
+	}
+	if policy.DefaultTemplateBackup != nil && step["type"] == "template" {
+		if tmpl, ok := step["template"].(map[string]interface{}); ok {
+			if _, ok := tmpl["backup"]; !ok {
+				tmpl["backup"] = *policy.DefaultTemplateBackup
+			}
+		}
+	}
+}
+
+// checkStep checks a step against the policy's ceilings
+func checkStep(prefix string, step map[string]interface{}, policy *models.WorkflowPolicy) ValidationErrors {
+	var errors ValidationErrors
+
+	stepType, _ := step["type"].(string)
+	if len(policy.AllowedStepTypes) > 0 && !contains(policy.AllowedStepTypes, stepType) {
+		errors = append(errors, ValidationError{prefix + ".type", fmt.Sprintf("step type %q is not allowed; allowed: %s", stepType, strings.Join(policy.AllowedStepTypes, ", "))})
+	}
+	if stepType == "plugin" {
+		plugin, _ := step["plugin"].(map[string]interface{})
+		name, _ := plugin["name"].(string)
+		if _, ok := policy.AllowedPlugins[name]; !ok {
+			errors = append(errors, ValidationError{prefix + ".plugin.name", fmt.Sprintf("plugin %q is not in the tenant's allowed_plugins", name)})
+		}
+	}
+	if stepType == "script" && len(policy.AllowedInterpreters) > 0 {
+		if name := scriptInterpreter(step); !contains(policy.AllowedInterpreters, name) {
+			errors = append(errors, ValidationError{prefix + ".interpreter", fmt.Sprintf("interpreter %q is not allowed; allowed: %s", name, strings.Join(policy.AllowedInterpreters, ", "))})
+		}
+	}
+	if policy.MaxStepTimeout != "" {
+		if timeout, ok := step["timeout"].(string); ok {
+			errors = append(errors, checkCeiling(prefix+".timeout", timeout, policy.MaxStepTimeout)...)
+		}
+	}
+	if policy.MaxRetryCount != nil {
+		if count, ok := step["retry_count"].(float64); ok && int(count) > *policy.MaxRetryCount {
+			errors = append(errors, ValidationError{prefix + ".retry_count", fmt.Sprintf("exceeds the maximum of %d", *policy.MaxRetryCount)})
+		} else if count, ok := step["retry_count"].(int); ok && count > *policy.MaxRetryCount {
+			errors = append(errors, ValidationError{prefix + ".retry_count", fmt.Sprintf("exceeds the maximum of %d", *policy.MaxRetryCount)})
+		}
+	}
+	return errors
+}
+
+// checkCeiling checks a duration does not exceed the policy maximum
+func checkCeiling(field, value, max string) ValidationErrors {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return nil // Reported by the validator
+	}
+	limit, err := time.ParseDuration(max)
+	if err != nil {
+		return nil
+	}
+	if d > limit {
+		return ValidationErrors{{field, fmt.Sprintf("%s exceeds the maximum of %s", value, max)}}
+	}
+	return nil
+}
+
+// scriptInterpreter returns the interpreter a script step runs with, the
+// way the agent picks it: the interpreter field, else the program named by
+// the script's shebang, else sh
+func scriptInterpreter(step map[string]interface{}) string {
+	if name, _ := step["interpreter"].(string); name != "" {
+		return name
+	}
+	script, _ := step["script"].(string)
+	if !strings.HasPrefix(script, "#!") {
+		return "sh"
+	}
+	line, _, _ := strings.Cut(script[2:], "\n")
+	fields := strings.Fields(line)
+	if len(fields) > 0 && path.Base(fields[0]) == "env" {
+		fields = fields[1:]
+		for len(fields) > 0 && strings.HasPrefix(fields[0], "-") {
+			fields = fields[1:]
+		}
+	}
+	if len(fields) == 0 {
+		return "sh"
+	}
+	prog := strings.TrimSuffix(path.Base(strings.ReplaceAll(fields[0], "\\", "/")), ".exe")
+	if alias, ok := interpreterAliases[prog]; ok {
+		return alias
+	}
+	return prog
+}
+
+// GetPolicy returns the tenant's workflow policy; it is empty when unset
+func (m *Manager) GetPolicy(ctx context.Context, tenantID string) (*models.WorkflowPolicy, error) {
+	policy, err := LoadPolicy(ctx, m.db, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		policy = &models.WorkflowPolicy{}
+	}
+	return policy, nil
+}
+
+// SetPolicy replaces the tenant's workflow policy. It applies to workflows
+// created or updated afterwards; existing definitions are not changed.
+func (m *Manager) SetPolicy(ctx context.Context, tenantID string, policy *models.WorkflowPolicy) (*models.WorkflowPolicy, error) {
+	if err := ValidatePolicy(policy); err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	if !policy.IsEmpty() {
+		value = policy
+	}
+	result := m.db.WithContext(ctx).Model(&models.Tenant{}).
+		Where("id = ?", tenantID).
+		Updates(map[string]interface{}{
+			"workflow_policy": value,
+			"updated_at":      time.Now(),
+		})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to update workflow policy: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, apperror.NotFound("tenant not found")
+	}
+
+	m.logger.Info("workflow policy updated",
+		zap.String("tenant_id", tenantID))
+
+	return m.GetPolicy(ctx, tenantID)
+}
+
+// usesPlugins reports whether a definition has plugin steps
+func usesPlugins(definition map[string]interface{}) bool {
+	for _, field := range []string{"steps", "on_success", "on_failure", "on_cancel"} {
+		steps, _ := definition[field].([]interface{})
+		for _, raw := range steps {
+			if step, ok := raw.(map[string]interface{}); ok && step["type"] == "plugin" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contains reports whether list contains s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
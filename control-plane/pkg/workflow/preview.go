@@ -0,0 +1,320 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/diff"
+)
+
+// Kinds of resources that reference a workflow
+const (
+	ReferenceCampaign        = "campaign"
+	ReferenceTrigger         = "workflow_trigger"
+	ReferenceHook            = "workflow_hook"
+	ReferenceInboundTrigger  = "inbound_trigger"
+	ReferenceRemediationRule = "remediation_rule"
+)
+
+// Impacts of an update on a resource referencing the workflow
+const (
+	// ImpactNewDefinition runs the updated definition from now on
+	ImpactNewDefinition = "runs_new_definition"
+	// ImpactNewDefinitionOnStart is a campaign not started yet, which pins
+	// the definition current when it starts
+	ImpactNewDefinitionOnStart = "runs_new_definition_on_start"
+	// ImpactPinned is a started campaign, which keeps dispatching the
+	// definition it pinned
+	ImpactPinned = "keeps_pinned_definition"
+	// ImpactOutcome fires on the workflow's outcomes, which the update may
+	// change
+	ImpactOutcome = "fires_on_outcome"
+)
+
+// UpdatePreview is what an update would do, without applying it
+type UpdatePreview struct {
+	WorkflowID string `json:"workflow_id"`
+	Version    int    `json:"version"`
+	// NextVersion is the version the update would store
+	NextVersion int `json:"next_version"`
+	// Changes are the fields the update changes
+	Changes []string `json:"changes"`
+	// Valid is false when the update would be rejected for Errors
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors,omitempty"`
+	// Diff compares the stored definition with the new one, as YAML, after
+	// the tenant's workflow policy fills in its defaults
+	Diff *diff.Result `json:"diff,omitempty"`
+	Lint *LintReport  `json:"lint,omitempty"`
+	// Affected are the resources referencing the workflow
+	Affected []WorkflowReference `json:"affected"`
+}
+
+// WorkflowReference is a resource that references a workflow, and how an
+// update to it affects the resource
+type WorkflowReference struct {
+	Kind    string `json:"kind"`
+	ID      string `json:"id"`
+	Name    string `json:"name,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Enabled *bool  `json:"enabled,omitempty"`
+	Impact  string `json:"impact"`
+}
+
+// PreviewUpdate reports what Update would do with a request: the fields and
+// definition it would change, whether the new definition passes validation,
+// the tenant's policy and lint, and the campaigns, triggers and rules
+// referencing the workflow. The version and Git sync checks fail as they
+// would on Update; validation failures are reported in the preview.
+func (m *Manager) PreviewUpdate(ctx context.Context, tenantID, workflowID string, req *UpdateWorkflowRequest) (*UpdatePreview, error) {
+	if req.ExpectedVersion == nil && req.ExpectedUpdatedAt == nil {
+		return nil, apperror.InvalidInput("expected_version or expected_updated_at is required")
+	}
+
+	workflow, err := m.Get(ctx, tenantID, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkNotSynced(workflow); err != nil {
+		return nil, err
+	}
+	if (req.ExpectedVersion != nil && *req.ExpectedVersion != workflow.Version) ||
+		(req.ExpectedUpdatedAt != nil && !req.ExpectedUpdatedAt.Equal(workflow.UpdatedAt)) {
+		return nil, apperror.Stale(workflow, "workflow was modified by another update (current version %d)", workflow.Version)
+	}
+
+	preview := &UpdatePreview{
+		WorkflowID:  workflow.ID,
+		Version:     workflow.Version,
+		NextVersion: workflow.Version,
+		Changes:     []string{},
+		Valid:       true,
+	}
+	reject := func(err error) {
+		preview.Valid = false
+		preview.Errors = append(preview.Errors, validationMessages(err)...)
+	}
+
+	if req.Name != nil && *req.Name != workflow.Name {
+		preview.Changes = append(preview.Changes, "name")
+	}
+	if req.Description != nil && *req.Description != workflow.Description {
+		preview.Changes = append(preview.Changes, "description")
+	}
+	if req.Status != nil && *req.Status != workflow.Status {
+		preview.Changes = append(preview.Changes, "status")
+	}
+	if req.Tags != nil {
+		if err := validateTags(req.Tags); err != nil {
+			reject(err)
+		}
+		if !reflect.DeepEqual(map[string]interface{}(tagsToJSONMap(req.Tags)), map[string]interface{}(workflow.Tags)) {
+			preview.Changes = append(preview.Changes, "tags")
+		}
+	}
+
+	// Update stores a new version for any field it is given
+	if req.Name != nil || req.Description != nil || req.Definition != nil || req.Status != nil || req.Tags != nil {
+		preview.NextVersion = workflow.Version + 1
+	}
+
+	if req.Definition != nil {
+		if err := NewValidator().Validate(req.Definition); err != nil {
+			reject(err)
+		} else if err := m.applyPolicy(ctx, tenantID, req.Definition, req.PolicyOverride); err != nil {
+			if !errors.Is(err, apperror.ErrInvalidInput) {
+				return nil, err
+			}
+			reject(err)
+		}
+		preview.Lint = Lint(req.Definition, nil)
+
+		preview.Diff, err = definitionDiff(workflow, req.Definition, preview.NextVersion)
+		if err != nil {
+			return nil, err
+		}
+		if preview.Diff.Changed {
+			preview.Changes = append(preview.Changes, "definition")
+		}
+	}
+
+	preview.Affected, err = m.references(ctx, tenantID, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	return preview, nil
+}
+
+// validationMessages splits a validation error into its messages
+func validationMessages(err error) []string {
+	var validation ValidationErrors
+	if errors.As(err, &validation) {
+		messages := make([]string, len(validation))
+		for i, e := range validation {
+			messages[i] = e.Error()
+		}
+		return messages
+	}
+	return []string{err.Error()}
+}
+
+// definitionDiff diffs a workflow's stored definition with a new one
+func definitionDiff(workflow *models.Workflow, definition map[string]interface{}, nextVersion int) (*diff.Result, error) {
+	current, err := definitionDocument(workflow.Definition)
+	if err != nil {
+		return nil, err
+	}
+	next, err := definitionDocument(definition)
+	if err != nil {
+		return nil, err
+	}
+	return diff.Compute(
+		fmt.Sprintf("%s (version %d)", workflow.Name, workflow.Version),
+		fmt.Sprintf("%s (version %d)", workflow.Name, nextVersion),
+		current, next, diff.DefaultContext), nil
+}
+
+// definitionDocument renders a definition as YAML. It goes through JSON
+// first so that stored and requested definitions, whose numbers decode to
+// different types, render alike.
+func definitionDocument(definition map[string]interface{}) (string, error) {
+	data, err := json.Marshal(definition)
+	if err != nil {
+		return "", fmt.Errorf("failed to render workflow definition: %w", err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", fmt.Errorf("failed to render workflow definition: %w", err)
+	}
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("failed to render workflow definition: %w", err)
+	}
+	return string(out), nil
+}
+
+// references lists the resources referencing a workflow that an update to
+// it can affect: campaigns not finished, workflow triggers and hooks that
+// run it or fire on its outcomes, inbound triggers and remediation rules
+func (m *Manager) references(ctx context.Context, tenantID, workflowID string) ([]WorkflowReference, error) {
+	tx := m.db.WithContext(ctx)
+	refs := []WorkflowReference{}
+
+	var campaigns []models.Campaign
+	if err := tx.Select("id", "name", "status", "definition_hash").
+		Where("tenant_id = ? AND workflow_id = ? AND status IN ?", tenantID, workflowID, []models.CampaignStatus{
+			models.CampaignStatusDraft,
+			models.CampaignStatusRunning,
+			models.CampaignStatusPaused,
+			models.CampaignStatusRollingBack,
+		}).
+		Order("created_at ASC").
+		Find(&campaigns).Error; err != nil {
+		return nil, fmt.Errorf("failed to list campaigns: %w", err)
+	}
+	for _, campaign := range campaigns {
+		impact := ImpactPinned
+		switch {
+		case campaign.Status == models.CampaignStatusDraft:
+			impact = ImpactNewDefinitionOnStart
+		case campaign.DefinitionHash == "":
+			// Started before definitions were pinned
+			impact = ImpactNewDefinition
+		}
+		refs = append(refs, WorkflowReference{
+			Kind:   ReferenceCampaign,
+			ID:     campaign.ID,
+			Name:   campaign.Name,
+			Status: string(campaign.Status),
+			Impact: impact,
+		})
+	}
+
+	var triggers []models.WorkflowTrigger
+	if err := tx.Where("tenant_id = ? AND (source_workflow_id = ? OR target_workflow_id = ?)", tenantID, workflowID, workflowID).
+		Order("created_at ASC").
+		Find(&triggers).Error; err != nil {
+		return nil, fmt.Errorf("failed to list workflow triggers: %w", err)
+	}
+	for i := range triggers {
+		trigger := &triggers[i]
+		impact := ImpactNewDefinition
+		if trigger.SourceWorkflowID == workflowID {
+			impact = ImpactOutcome
+		}
+		refs = append(refs, WorkflowReference{
+			Kind:    ReferenceTrigger,
+			ID:      trigger.ID,
+			Name:    trigger.Description,
+			Enabled: &trigger.Enabled,
+			Impact:  impact,
+		})
+	}
+
+	var hooks []models.WorkflowHook
+	if err := tx.Select("id", "workflow_id", "enabled", "description").
+		Where("tenant_id = ? AND (workflow_id = ? OR (action = ? AND target_workflow_id = ?))",
+			tenantID, workflowID, models.HookActionWorkflow, workflowID).
+		Order("created_at ASC").
+		Find(&hooks).Error; err != nil {
+		return nil, fmt.Errorf("failed to list workflow hooks: %w", err)
+	}
+	for i := range hooks {
+		hook := &hooks[i]
+		impact := ImpactNewDefinition
+		if hook.WorkflowID == workflowID {
+			impact = ImpactOutcome
+		}
+		refs = append(refs, WorkflowReference{
+			Kind:    ReferenceHook,
+			ID:      hook.ID,
+			Name:    hook.Description,
+			Enabled: &hook.Enabled,
+			Impact:  impact,
+		})
+	}
+
+	var inbound []models.InboundTrigger
+	if err := tx.Select("id", "name", "enabled").
+		Where("tenant_id = ? AND workflow_id = ?", tenantID, workflowID).
+		Order("created_at ASC").
+		Find(&inbound).Error; err != nil {
+		return nil, fmt.Errorf("failed to list inbound triggers: %w", err)
+	}
+	for i := range inbound {
+		refs = append(refs, WorkflowReference{
+			Kind:    ReferenceInboundTrigger,
+			ID:      inbound[i].ID,
+			Name:    inbound[i].Name,
+			Enabled: &inbound[i].Enabled,
+			Impact:  ImpactNewDefinition,
+		})
+	}
+
+	var rules []models.RemediationRule
+	if err := tx.Select("id", "name", "status", "enabled").
+		Where("tenant_id = ? AND workflow_id = ?", tenantID, workflowID).
+		Order("created_at ASC").
+		Find(&rules).Error; err != nil {
+		return nil, fmt.Errorf("failed to list remediation rules: %w", err)
+	}
+	for i := range rules {
+		refs = append(refs, WorkflowReference{
+			Kind:    ReferenceRemediationRule,
+			ID:      rules[i].ID,
+			Name:    rules[i].Name,
+			Status:  rules[i].Status,
+			Enabled: &rules[i].Enabled,
+			Impact:  ImpactNewDefinition,
+		})
+	}
+
+	return refs, nil
+}
@@ -0,0 +1,506 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/tracing"
+)
+
+// DispatchQueueConfig controls delivery of queued executions to agents
+type DispatchQueueConfig struct {
+	// Workers is the number of concurrent deliveries per replica
+	Workers int
+	// PollInterval is how often the outbox is checked for due jobs when no
+	// execution was queued locally
+	PollInterval time.Duration
+	// BatchSize bounds the jobs claimed per poll
+	BatchSize int
+	// Lease is how long a claimed job is held before another worker may
+	// retry it; zero derives it from the dispatch timeout
+	Lease time.Duration
+	// MaxAttempts is the number of deliveries before a job is dead-lettered
+	MaxAttempts int
+	// RetryBackoff is the delay before the first redelivery; it doubles per
+	// attempt up to MaxRetryBackoff
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// ResourceWait is how long a job whose agent lacks the resources the
+	// workflow declared keeps being retried, however many attempts it took
+	ResourceWait time.Duration
+}
+
+// DefaultDispatchQueueConfig returns the default dispatch queue configuration
+func DefaultDispatchQueueConfig() *DispatchQueueConfig {
+	return &DispatchQueueConfig{
+		Workers:         16,
+		PollInterval:    time.Second,
+		BatchSize:       32,
+		MaxAttempts:     8,
+		RetryBackoff:    5 * time.Second,
+		MaxRetryBackoff: 5 * time.Minute,
+		ResourceWait:    time.Hour,
+	}
+}
+
+// DispatchQueue delivers executions from the dispatch outbox to their
+// agents. Jobs are claimed with row locks so any number of replicas can run
+// a queue; a job whose worker died is retried once its lease expires, so
+// delivery is at least once.
+type DispatchQueue struct {
+	db       *gorm.DB
+	executor *Executor
+	config   *DispatchQueueConfig
+	logger   *zap.Logger
+	workerID string
+	wake     chan struct{}
+}
+
+// NewDispatchQueue creates a dispatch queue. Unset config fields keep their
+// defaults.
+func NewDispatchQueue(db *gorm.DB, executor *Executor, config *DispatchQueueConfig, logger *zap.Logger) *DispatchQueue {
+	defaults := DefaultDispatchQueueConfig()
+	if config == nil {
+		config = defaults
+	}
+	if config.Workers <= 0 {
+		config.Workers = defaults.Workers
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaults.PollInterval
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaults.BatchSize
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaults.MaxAttempts
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = defaults.RetryBackoff
+	}
+	if config.MaxRetryBackoff <= 0 {
+		config.MaxRetryBackoff = defaults.MaxRetryBackoff
+	}
+	if config.ResourceWait <= 0 {
+		config.ResourceWait = defaults.ResourceWait
+	}
+
+	hostname, _ := os.Hostname()
+	workerID := uuid.New().String()[:8]
+	if hostname != "" {
+		workerID = fmt.Sprintf("%.55s-%s", hostname, workerID)
+	}
+
+	return &DispatchQueue{
+		db:       db,
+		executor: executor,
+		config:   config,
+		logger:   logger,
+		workerID: workerID,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Notify wakes the queue to deliver a newly queued job without waiting for
+// the next poll
+func (q *DispatchQueue) Notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due jobs until ctx is cancelled, then waits for the
+// deliveries in progress to finish
+func (q *DispatchQueue) Run(ctx context.Context) {
+	q.logger.Info("dispatch queue started",
+		zap.String("worker_id", q.workerID),
+		zap.Int("workers", q.config.Workers))
+
+	slots := make(chan struct{}, q.config.Workers)
+	var deliveries sync.WaitGroup
+	defer deliveries.Wait()
+
+	ticker := time.NewTicker(q.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		q.dispatchDue(ctx, slots, &deliveries)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-q.wake:
+		}
+	}
+}
+
+// dispatchDue claims due jobs while there are free workers and starts
+// delivering them
+func (q *DispatchQueue) dispatchDue(ctx context.Context, slots chan struct{}, deliveries *sync.WaitGroup) {
+	for ctx.Err() == nil {
+		free := cap(slots) - len(slots)
+		if free == 0 {
+			return
+		}
+		limit := q.config.BatchSize
+		if free < limit {
+			limit = free
+		}
+
+		jobs, err := q.claim(ctx, limit)
+		if err != nil {
+			q.logger.Error("failed to claim dispatch jobs", zap.Error(err))
+			return
+		}
+		for i := range jobs {
+			job := &jobs[i]
+			slots <- struct{}{}
+			deliveries.Add(1)
+			go func() {
+				defer func() {
+					<-slots
+					deliveries.Done()
+				}()
+				q.deliver(job)
+			}()
+		}
+		if len(jobs) < limit {
+			return
+		}
+	}
+}
+
+// claim locks up to limit due jobs for this worker: pending jobs whose next
+// attempt is due and in-progress jobs whose lease expired
+func (q *DispatchQueue) claim(ctx context.Context, limit int) ([]models.DispatchJob, error) {
+	now := time.Now()
+	leaseUntil := now.Add(q.lease())
+
+	var jobs []models.DispatchJob
+	err := q.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status IN ? AND next_attempt_at <= ?",
+				[]models.DispatchJobStatus{models.DispatchJobPending, models.DispatchJobInProgress}, now).
+			Order("next_attempt_at").
+			Limit(limit).
+			Find(&jobs).Error; err != nil {
+			return err
+		}
+		if len(jobs) == 0 {
+			return nil
+		}
+
+		ids := make([]string, len(jobs))
+		for i := range jobs {
+			ids[i] = jobs[i].ID
+		}
+		return tx.Model(&models.DispatchJob{}).
+			Where("id IN ?", ids).
+			Updates(map[string]interface{}{
+				"status":          models.DispatchJobInProgress,
+				"locked_by":       q.workerID,
+				"attempts":        gorm.Expr("attempts + 1"),
+				"next_attempt_at": leaseUntil,
+				"updated_at":      now,
+			}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range jobs {
+		jobs[i].Status = models.DispatchJobInProgress
+		jobs[i].LockedBy = q.workerID
+		jobs[i].Attempts++
+		jobs[i].NextAttemptAt = leaseUntil
+	}
+	return jobs, nil
+}
+
+// deliver sends a claimed job to its agent and records the outcome
+func (q *DispatchQueue) deliver(job *models.DispatchJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), q.executor.dispatchTimeout())
+	defer cancel()
+
+	var execution models.WorkflowExecution
+	if err := q.db.WithContext(ctx).Where("id = ?", job.ExecutionID).First(&execution).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			q.finish(ctx, job, models.DispatchJobCancelled, "", "execution no longer exists")
+			return
+		}
+		q.retry(ctx, job, nil, fmt.Errorf("failed to load execution: %w", err))
+		return
+	}
+	if execution.Status != models.ExecutionStatusPending && execution.Status != models.ExecutionStatusRunning {
+		q.finish(ctx, job, models.DispatchJobCancelled, "", fmt.Sprintf("execution is %s", execution.Status))
+		return
+	}
+
+	payload, err := json.Marshal(job.Payload)
+	if err != nil {
+		q.finish(ctx, job, models.DispatchJobDead, FailureRejected, err.Error())
+		q.executor.markFailed(&execution, fmt.Sprintf("failed to marshal workflow: %v", err))
+		return
+	}
+
+	// Marked running before sending so a fast result is not overwritten
+	q.db.WithContext(ctx).Model(&models.WorkflowExecution{}).
+		Where("id = ? AND status = ?", execution.ID, models.ExecutionStatusPending).
+		Updates(map[string]interface{}{
+			"status":     models.ExecutionStatusRunning,
+			"started_at": time.Now(),
+		})
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	if job.Priority != "" {
+		header.Set("X-Workflow-Priority", job.Priority)
+	}
+	if execution.RequestID != "" {
+		header.Set(tracing.Header, execution.RequestID)
+	}
+
+	resp, dispatchErr := q.executor.agentRequest(ctx, job.TenantID, job.AgentID, http.MethodPost, "/workflow/execute", payload, header)
+	if dispatchErr != nil {
+		if q.retryable(job, dispatchErr) {
+			q.retry(ctx, job, &execution, dispatchErr)
+			return
+		}
+		q.finish(ctx, job, models.DispatchJobDead, dispatchErr.Class, dispatchErr.Error())
+		q.executor.markDispatchFailed(&execution, dispatchErr, job.Attempts)
+		return
+	}
+	resp.Body.Close()
+
+	q.finish(ctx, job, models.DispatchJobDelivered, "", "")
+
+	q.logger.Info("workflow sent to agent",
+		zap.String("execution_id", execution.ID),
+		zap.String("request_id", execution.RequestID),
+		zap.String("agent_id", job.AgentID),
+		zap.Int("attempt", job.Attempts))
+}
+
+// retry schedules the job's next delivery with exponential backoff. An
+// execution marked running for the failed attempt goes back to pending.
+func (q *DispatchQueue) retry(ctx context.Context, job *models.DispatchJob, execution *models.WorkflowExecution, err error) {
+	backoff := q.config.RetryBackoff
+	for i := 1; i < job.Attempts && backoff < q.config.MaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > q.config.MaxRetryBackoff {
+		backoff = q.config.MaxRetryBackoff
+	}
+
+	failureClass := ""
+	if dispatchErr, ok := err.(*DispatchError); ok {
+		failureClass = dispatchErr.Class
+	}
+
+	result := q.db.WithContext(ctx).Model(&models.DispatchJob{}).
+		Where("id = ? AND locked_by = ?", job.ID, q.workerID).
+		Updates(map[string]interface{}{
+			"status":          models.DispatchJobPending,
+			"locked_by":       "",
+			"next_attempt_at": time.Now().Add(backoff),
+			"failure_class":   failureClass,
+			"last_error":      err.Error(),
+			"updated_at":      time.Now(),
+		})
+	if result.Error != nil {
+		q.logger.Error("failed to reschedule dispatch job",
+			zap.String("job_id", job.ID),
+			zap.Error(result.Error))
+		return
+	}
+
+	if execution != nil {
+		q.db.WithContext(ctx).Model(&models.WorkflowExecution{}).
+			Where("id = ? AND status = ?", execution.ID, models.ExecutionStatusRunning).
+			Updates(map[string]interface{}{
+				"status":     models.ExecutionStatusPending,
+				"started_at": nil,
+			})
+	}
+
+	q.logger.Warn("workflow delivery failed, retrying",
+		zap.String("execution_id", job.ExecutionID),
+		zap.String("agent_id", job.AgentID),
+		zap.Int("attempt", job.Attempts),
+		zap.Int("max_attempts", q.config.MaxAttempts),
+		zap.Duration("backoff", backoff),
+		zap.Error(err))
+}
+
+// finish records a job's final state. A job whose lease was taken over by
+// another worker is left to that worker.
+func (q *DispatchQueue) finish(ctx context.Context, job *models.DispatchJob, status models.DispatchJobStatus, failureClass, lastError string) {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":        status,
+		"locked_by":     "",
+		"failure_class": failureClass,
+		"last_error":    lastError,
+		"updated_at":    now,
+	}
+	if status == models.DispatchJobDelivered {
+		updates["delivered_at"] = now
+	}
+
+	if err := q.db.WithContext(ctx).Model(&models.DispatchJob{}).
+		Where("id = ? AND locked_by = ?", job.ID, q.workerID).
+		Updates(updates).Error; err != nil {
+		q.logger.Error("failed to update dispatch job",
+			zap.String("job_id", job.ID),
+			zap.String("status", string(status)),
+			zap.Error(err))
+		return
+	}
+
+	if status == models.DispatchJobDead {
+		q.logger.Error("workflow delivery dead-lettered",
+			zap.String("job_id", job.ID),
+			zap.String("execution_id", job.ExecutionID),
+			zap.String("agent_id", job.AgentID),
+			zap.Int("attempts", job.Attempts),
+			zap.String("error", lastError))
+	}
+}
+
+// lease returns how long a claimed job is held by its worker
+func (q *DispatchQueue) lease() time.Duration {
+	if q.config.Lease > 0 {
+		return q.config.Lease
+	}
+	return q.executor.dispatchTimeout() + 30*time.Second
+}
+
+// retryable reports whether a failed delivery is tried again. An agent
+// short of resources is waited on for ResourceWait since the job was
+// queued; other retryable failures are tried up to MaxAttempts times.
+func (q *DispatchQueue) retryable(job *models.DispatchJob, err *DispatchError) bool {
+	if err.Class == FailureInsufficientResources {
+		return time.Since(job.CreatedAt) < q.config.ResourceWait
+	}
+	return retryableDispatch(err) && job.Attempts < q.config.MaxAttempts
+}
+
+// retryableDispatch reports whether a failed delivery may succeed later: the
+// agent was unreachable, or it answered that it is busy
+func retryableDispatch(err *DispatchError) bool {
+	if err.Class == FailureUnreachable {
+		return true
+	}
+	return err.StatusCode == http.StatusTooManyRequests || err.StatusCode == http.StatusServiceUnavailable
+}
+
+// ListDispatchJobsRequest filters the dispatch outbox
+type ListDispatchJobsRequest struct {
+	TenantID string
+	Status   models.DispatchJobStatus
+	AgentID  string
+	Limit    int
+	Offset   int
+}
+
+// ListDispatchJobs lists the tenant's dispatch jobs, newest first
+func (e *Executor) ListDispatchJobs(ctx context.Context, req *ListDispatchJobsRequest) ([]models.DispatchJob, int64, error) {
+	query := e.db.WithContext(ctx).Model(&models.DispatchJob{}).Where("tenant_id = ?", req.TenantID)
+	if req.Status != "" {
+		query = query.Where("status = ?", req.Status)
+	}
+	if req.AgentID != "" {
+		query = query.Where("agent_id = ?", req.AgentID)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count dispatch jobs: %w", err)
+	}
+
+	var jobs []models.DispatchJob
+	if err := query.Order("created_at DESC").Limit(req.Limit).Offset(req.Offset).Find(&jobs).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to list dispatch jobs: %w", err)
+	}
+	return jobs, total, nil
+}
+
+// RequeueDispatchJob puts a dead-lettered job back in the queue and returns
+// its execution to pending. Triggers that fired when the execution failed
+// do not fire again.
+func (e *Executor) RequeueDispatchJob(ctx context.Context, tenantID, jobID string) (*models.DispatchJob, error) {
+	var job models.DispatchJob
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND tenant_id = ?", jobID, tenantID).
+			First(&job).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return apperror.NotFound("dispatch job not found")
+			}
+			return err
+		}
+		if job.Status != models.DispatchJobDead {
+			return apperror.InvalidState("dispatch job is %s; only dead-lettered jobs can be requeued", job.Status)
+		}
+
+		result := tx.Model(&models.WorkflowExecution{}).
+			Where("id = ? AND tenant_id = ? AND status = ?", job.ExecutionID, tenantID, models.ExecutionStatusFailed).
+			Updates(map[string]interface{}{
+				"status":       models.ExecutionStatusPending,
+				"started_at":   nil,
+				"completed_at": nil,
+				"result":       nil,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return apperror.InvalidState("execution %s is no longer failed", job.ExecutionID)
+		}
+
+		now := time.Now()
+		job.Status = models.DispatchJobPending
+		job.Attempts = 0
+		job.NextAttemptAt = now
+		job.FailureClass = ""
+		job.LastError = ""
+		return tx.Model(&models.DispatchJob{}).
+			Where("id = ?", job.ID).
+			Updates(map[string]interface{}{
+				"status":          job.Status,
+				"attempts":        0,
+				"next_attempt_at": now,
+				"failure_class":   "",
+				"last_error":      "",
+				"updated_at":      now,
+			}).Error
+	})
+	if err != nil {
+		if apperror.KindOf(err) != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to requeue dispatch job: %w", err)
+	}
+
+	e.notifyQueue()
+
+	e.logger.Info("dispatch job requeued",
+		zap.String("job_id", job.ID),
+		zap.String("execution_id", job.ExecutionID))
+
+	return &job, nil
+}
@@ -0,0 +1,48 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+)
+
+// ShellRequest sends a live shell session request to an agent: action is
+// open, input, read, resize or close. The JSON response is decoded into
+// out unless it is nil.
+func (e *Executor) ShellRequest(ctx context.Context, tenantID, agentID, sessionID, action string, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode shell request: %w", err)
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+
+	resp, dispatchErr := e.agentRequest(ctx, tenantID, agentID, http.MethodPost, "/shell/"+sessionID+"/"+action, payload, header)
+	if dispatchErr != nil {
+		switch dispatchErr.StatusCode {
+		case http.StatusNotFound:
+			return apperror.NotFound("shell session not found on agent")
+		case http.StatusForbidden:
+			return apperror.Forbidden("agent refused the shell session: %v", dispatchErr)
+		case http.StatusConflict:
+			return apperror.Conflict("shell session already exists on agent")
+		case http.StatusTooManyRequests:
+			return apperror.QuotaExceeded("agent runs its maximum of shell sessions")
+		case http.StatusServiceUnavailable:
+			return apperror.InvalidState("agent does not support shell sessions")
+		}
+		return fmt.Errorf("shell %s request to agent failed: %w", action, dispatchErr)
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode shell %s response: %w", action, err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package workflow
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// DefinitionHash returns the SHA-256 of a workflow definition. JSON
+// encoding sorts map keys, so equal definitions hash alike.
+func DefinitionHash(definition models.JSONMap) (string, error) {
+	data, err := json.Marshal(definition)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode workflow definition: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Snapshot keeps a copy of the workflow's current definition, once per
+// distinct definition, and returns it
+func Snapshot(ctx context.Context, db *gorm.DB, workflow *models.Workflow) (*models.WorkflowSnapshot, error) {
+	hash, err := DefinitionHash(workflow.Definition)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &models.WorkflowSnapshot{
+		ID:              uuid.New().String(),
+		TenantID:        workflow.TenantID,
+		WorkflowID:      workflow.ID,
+		DefinitionHash:  hash,
+		WorkflowVersion: workflow.Version,
+		Definition:      workflow.Definition,
+		CreatedAt:       time.Now(),
+	}
+	if err := db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(snapshot).Error; err != nil {
+		return nil, fmt.Errorf("failed to snapshot workflow definition: %w", err)
+	}
+	return snapshot, nil
+}
+
+// LoadSnapshot returns the snapshot of a workflow's definition with a hash
+func LoadSnapshot(ctx context.Context, db *gorm.DB, tenantID, workflowID, hash string) (*models.WorkflowSnapshot, error) {
+	var snapshot models.WorkflowSnapshot
+	if err := db.WithContext(ctx).
+		Where("tenant_id = ? AND workflow_id = ? AND definition_hash = ?", tenantID, workflowID, hash).
+		First(&snapshot).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, apperror.NotFound("workflow definition %s not found", hash)
+		}
+		return nil, fmt.Errorf("failed to get workflow definition: %w", err)
+	}
+	return &snapshot, nil
+}
+
+// GetSnapshot returns the definition a workflow had when it hashed to hash
+func (m *Manager) GetSnapshot(ctx context.Context, tenantID, workflowID, hash string) (*models.WorkflowSnapshot, error) {
+	return LoadSnapshot(ctx, m.db, tenantID, workflowID, hash)
+}
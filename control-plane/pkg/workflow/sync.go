@@ -0,0 +1,168 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// SyncWorkflowRequest is a workflow defined by a file in a Git repository
+type SyncWorkflowRequest struct {
+	TenantID     string
+	RepositoryID string
+	// Path is the file defining the workflow and Commit the commit it was
+	// read at
+	Path        string
+	Commit      string
+	Name        string
+	Description string
+	Definition  map[string]interface{}
+	Tags        map[string]string
+	// Status defaults to active
+	Status    models.WorkflowStatus
+	CreatedBy string
+}
+
+// Sync creates or updates the workflow defined by a file in a Git
+// repository. The definition goes through the same validation, policy and
+// quota checks as an API change, and a changed definition bumps the version.
+func (m *Manager) Sync(ctx context.Context, req *SyncWorkflowRequest) (*models.Workflow, models.GitSyncAction, error) {
+	status := req.Status
+	switch status {
+	case "":
+		status = models.WorkflowStatusActive
+	case models.WorkflowStatusDraft, models.WorkflowStatusActive, models.WorkflowStatusDeprecated:
+	default:
+		return nil, "", apperror.InvalidInput("invalid status %q: must be draft, active or deprecated", status)
+	}
+	if req.Name == "" {
+		return nil, "", apperror.InvalidInput("name is required")
+	}
+
+	validator := NewValidator()
+	if err := validator.Validate(req.Definition); err != nil {
+		return nil, "", apperror.InvalidInput("workflow validation failed: %w", err)
+	}
+	if err := m.applyPolicy(ctx, req.TenantID, req.Definition, false); err != nil {
+		return nil, "", err
+	}
+	if err := validateTags(req.Tags); err != nil {
+		return nil, "", err
+	}
+
+	var existing models.Workflow
+	err := m.db.WithContext(ctx).
+		Where("tenant_id = ? AND source_repository_id = ? AND source_path = ?", req.TenantID, req.RepositoryID, req.Path).
+		First(&existing).Error
+	if err == gorm.ErrRecordNotFound {
+		return m.createSynced(ctx, req, status)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get synced workflow: %w", err)
+	}
+
+	updates := make(map[string]interface{})
+	if existing.Name != req.Name {
+		updates["name"] = req.Name
+	}
+	if existing.Description != req.Description {
+		updates["description"] = req.Description
+	}
+	if !reflect.DeepEqual(map[string]interface{}(existing.Definition), req.Definition) {
+		updates["definition"] = models.JSONMap(req.Definition)
+	}
+	if existing.Status != status {
+		updates["status"] = status
+	}
+	if tags := tagsToJSONMap(req.Tags); !(len(tags) == 0 && len(existing.Tags) == 0) && !reflect.DeepEqual(tags, existing.Tags) {
+		updates["tags"] = tags
+	}
+	if len(updates) == 0 {
+		return &existing, models.GitSyncActionUnchanged, nil
+	}
+
+	// Like edits, every sync update stores a new version
+	updates["version"] = existing.Version + 1
+	updates["source_commit"] = req.Commit
+	updates["updated_at"] = time.Now()
+	if err := m.db.WithContext(ctx).Model(&models.Workflow{}).
+		Where("id = ?", existing.ID).
+		Updates(updates).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to update synced workflow: %w", err)
+	}
+
+	m.logger.Info("synced workflow updated",
+		zap.String("workflow_id", existing.ID),
+		zap.String("tenant_id", req.TenantID),
+		zap.String("repository_id", req.RepositoryID),
+		zap.String("path", req.Path),
+		zap.String("commit", req.Commit))
+
+	workflow, err := m.Get(ctx, req.TenantID, existing.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	return workflow, models.GitSyncActionUpdated, nil
+}
+
+// createSynced creates a workflow for a file new to its repository
+func (m *Manager) createSynced(ctx context.Context, req *SyncWorkflowRequest, status models.WorkflowStatus) (*models.Workflow, models.GitSyncAction, error) {
+	if err := m.quotaChecker.CheckWorkflowQuota(req.TenantID); err != nil {
+		return nil, "", err
+	}
+
+	repositoryID := req.RepositoryID
+	workflow := &models.Workflow{
+		ID:                 uuid.New().String(),
+		TenantID:           req.TenantID,
+		Name:               req.Name,
+		Description:        req.Description,
+		Definition:         req.Definition,
+		Version:            1,
+		Status:             status,
+		Tags:               tagsToJSONMap(req.Tags),
+		SourceRepositoryID: &repositoryID,
+		SourcePath:         req.Path,
+		SourceCommit:       req.Commit,
+		CreatedBy:          req.CreatedBy,
+		CreatedAt:          time.Now(),
+		UpdatedAt:          time.Now(),
+	}
+	if err := m.db.WithContext(ctx).Create(workflow).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to create synced workflow: %w", err)
+	}
+
+	m.logger.Info("synced workflow created",
+		zap.String("workflow_id", workflow.ID),
+		zap.String("tenant_id", req.TenantID),
+		zap.String("repository_id", req.RepositoryID),
+		zap.String("path", req.Path),
+		zap.String("commit", req.Commit))
+
+	return workflow, models.GitSyncActionCreated, nil
+}
+
+// DeprecateSynced deprecates a synced workflow whose file was removed from
+// its repository. Like a sync update it stores a new version, and fails with
+// an apperror.StaleError if the workflow was modified after it was read.
+func (m *Manager) DeprecateSynced(ctx context.Context, workflow *models.Workflow) error {
+	return m.setStatus(ctx, workflow, models.WorkflowStatusDeprecated)
+}
+
+// checkNotSynced rejects API changes to a workflow synced from Git, which
+// would be overwritten by the next sync
+func checkNotSynced(workflow *models.Workflow) error {
+	if workflow.SourceRepositoryID == nil {
+		return nil
+	}
+	return apperror.InvalidState("workflow %s is synced from Git repository %s (%s); change it in the repository",
+		workflow.Name, *workflow.SourceRepositoryID, workflow.SourcePath)
+}
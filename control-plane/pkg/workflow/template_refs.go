@@ -0,0 +1,208 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/template"
+)
+
+// templateSourcePrefix is how workflow template steps reference stored templates
+const templateSourcePrefix = "control-plane://templates/"
+
+// maxTemplateHashes bounds the content hashes kept between dispatches
+const maxTemplateHashes = 4096
+
+// templateContent describes the content of a template version. Uploaded
+// content is deployed verbatim and fetched by agents in ranges.
+type templateContent struct {
+	hash     string
+	size     int64
+	uploaded bool
+}
+
+// templateHashCache keeps the content hashes of template versions so
+// dispatching a campaign to thousands of agents reads each template once.
+// Entries are keyed by template, version and update time; re-imported
+// templates reusing version numbers are hashed again.
+type templateHashCache struct {
+	mu     sync.Mutex
+	hashes map[string]templateContent
+}
+
+func (c *templateHashCache) get(key string) (templateContent, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	content, ok := c.hashes[key]
+	return content, ok
+}
+
+func (c *templateHashCache) put(key string, content templateContent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hashes == nil || len(c.hashes) >= maxTemplateHashes {
+		c.hashes = make(map[string]templateContent)
+	}
+	c.hashes[key] = content
+}
+
+// addTemplateHashes sets content_hash on the template steps of a dispatch
+// payload that use stored templates. Agents holding content with that hash
+// skip downloading it. Steps using uploaded content are also marked raw,
+// with its content_size, so agents download it in ranges without rendering
+// it. Steps whose template cannot be found are left for the agent to report.
+func (e *Executor) addTemplateHashes(ctx context.Context, tenantID string, definition models.JSONMap) error {
+	for _, field := range []string{"steps", "on_success", "on_failure", "on_cancel"} {
+		steps, _ := definition[field].([]interface{})
+		var hashed []interface{}
+		for i, raw := range steps {
+			step, ok := raw.(map[string]interface{})
+			if !ok || step["type"] != "template" {
+				continue
+			}
+			file, _ := step["template"].(map[string]interface{})
+			source, _ := file["source"].(string)
+			templateID, version, ok := parseTemplateSource(source)
+			if !ok {
+				continue
+			}
+
+			content, err := e.templateContent(ctx, tenantID, templateID, version)
+			if err != nil {
+				return err
+			}
+			if content == nil {
+				continue
+			}
+
+			// The payload shares its steps with the stored definition
+			if hashed == nil {
+				hashed = append([]interface{}(nil), steps...)
+			}
+			fileCopy := make(map[string]interface{}, len(file)+3)
+			for k, v := range file {
+				fileCopy[k] = v
+			}
+			fileCopy["content_hash"] = content.hash
+			if content.uploaded {
+				fileCopy["raw"] = true
+				fileCopy["content_size"] = content.size
+			} else {
+				delete(fileCopy, "raw")
+				delete(fileCopy, "content_size")
+			}
+			stepCopy := make(map[string]interface{}, len(step))
+			for k, v := range step {
+				stepCopy[k] = v
+			}
+			stepCopy["template"] = fileCopy
+			hashed[i] = stepCopy
+		}
+		if hashed != nil {
+			definition[field] = hashed
+		}
+	}
+	return nil
+}
+
+// templateContent describes the content of a template version, or of its
+// current version when version is 0. It returns nil for templates that do
+// not exist.
+func (e *Executor) templateContent(ctx context.Context, tenantID, templateID string, version int) (*templateContent, error) {
+	var tpl struct {
+		Version     int
+		Uploaded    bool
+		ContentHash string
+		ContentSize int64
+		UpdatedAt   time.Time
+	}
+	result := e.db.WithContext(ctx).Model(&models.Template{}).
+		Select("version", "uploaded", "content_hash", "content_size", "updated_at").
+		Where("id = ? AND tenant_id = ? AND status <> ?", templateID, tenantID, models.TemplateStatusDeleted).
+		Limit(1).
+		Scan(&tpl)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to look up template %s: %w", templateID, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	if version == 0 {
+		version = tpl.Version
+	}
+
+	// Uploaded content is hashed as it is stored
+	if version == tpl.Version && tpl.Uploaded {
+		return &templateContent{hash: tpl.ContentHash, size: tpl.ContentSize, uploaded: true}, nil
+	}
+
+	key := fmt.Sprintf("%s/%d/%d", templateID, version, tpl.UpdatedAt.UnixNano())
+	if content, ok := e.templateHashes.get(key); ok {
+		return &content, nil
+	}
+
+	var content templateContent
+	if version == tpl.Version {
+		var current models.Template
+		if err := e.db.WithContext(ctx).Select("id", "content").
+			Where("id = ? AND tenant_id = ?", templateID, tenantID).
+			First(&current).Error; err != nil {
+			return nil, fmt.Errorf("failed to get template %s: %w", templateID, err)
+		}
+		content.hash = template.ContentHash(current.Content)
+	} else {
+		var previous models.TemplateVersion
+		if err := e.db.WithContext(ctx).Select("id", "content", "uploaded", "content_hash", "content_size").
+			Where("template_id = ? AND tenant_id = ? AND version = ?", templateID, tenantID, version).
+			First(&previous).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("failed to get template %s version %d: %w", templateID, version, err)
+		}
+		if previous.Uploaded {
+			content = templateContent{hash: previous.ContentHash, size: previous.ContentSize, uploaded: true}
+		} else {
+			content.hash = template.ContentHash(previous.Content)
+		}
+	}
+
+	e.templateHashes.put(key, content)
+	return &content, nil
+}
+
+// parseTemplateSource parses a control-plane://templates/{id}[/content]
+// source and its optional version query
+func parseTemplateSource(source string) (string, int, bool) {
+	if !strings.HasPrefix(source, templateSourcePrefix) {
+		return "", 0, false
+	}
+	path, query, _ := strings.Cut(strings.TrimPrefix(source, templateSourcePrefix), "?")
+	templateID := strings.TrimSuffix(path, "/content")
+	if templateID == "" || strings.Contains(templateID, "/") {
+		return "", 0, false
+	}
+
+	version := 0
+	if query != "" {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return "", 0, false
+		}
+		if v := values.Get("version"); v != "" {
+			version, err = strconv.Atoi(v)
+			if err != nil || version < 0 {
+				return "", 0, false
+			}
+		}
+	}
+	return templateID, version, true
+}
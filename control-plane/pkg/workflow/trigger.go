@@ -0,0 +1,377 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/db/models"
+	"github.com/yourorg/control-plane/pkg/tracing"
+)
+
+// MaxTriggerDepth is the longest chain of triggered executions started from
+// one execution. Trigger creation rejects cycles; the depth limit also stops
+// chains that grow through triggers edited while executions are running.
+const MaxTriggerDepth = 10
+
+// CreateTriggerRequest represents a request to create a workflow trigger
+type CreateTriggerRequest struct {
+	TenantID         string                  `json:"-"`
+	SourceWorkflowID string                  `json:"source_workflow_id" binding:"required"`
+	TargetWorkflowID string                  `json:"target_workflow_id" binding:"required"`
+	On               models.TriggerCondition `json:"on" binding:"required"`
+	Description      string                  `json:"description"`
+	CreatedBy        string                  `json:"-"`
+}
+
+// UpdateTriggerRequest represents a request to update a workflow trigger
+type UpdateTriggerRequest struct {
+	Enabled     *bool   `json:"enabled"`
+	Description *string `json:"description"`
+}
+
+// CreateTrigger creates a trigger after checking both workflows exist and
+// the new edge does not close a cycle in the tenant's trigger graph
+func (m *Manager) CreateTrigger(ctx context.Context, req *CreateTriggerRequest) (*models.WorkflowTrigger, error) {
+	switch req.On {
+	case models.TriggerOnSuccess, models.TriggerOnFailure, models.TriggerOnCompletion:
+	default:
+		return nil, apperror.InvalidInput("invalid trigger condition %q: must be success, failure or completion", req.On)
+	}
+	if req.SourceWorkflowID == req.TargetWorkflowID {
+		return nil, apperror.InvalidInput("a workflow cannot trigger itself")
+	}
+
+	var count int64
+	if err := m.db.WithContext(ctx).Model(&models.Workflow{}).
+		Where("id IN ? AND tenant_id = ? AND status <> ?", []string{req.SourceWorkflowID, req.TargetWorkflowID}, req.TenantID, models.WorkflowStatusDeleted).
+		Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("failed to look up workflows: %w", err)
+	}
+	if count != 2 {
+		return nil, apperror.NotFound("source or target workflow not found")
+	}
+
+	triggers, err := m.tenantTriggers(ctx, req.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range triggers {
+		if t.SourceWorkflowID == req.SourceWorkflowID && t.TargetWorkflowID == req.TargetWorkflowID && t.On == req.On {
+			return nil, apperror.Conflict("trigger already exists: %s", t.ID)
+		}
+	}
+	if path := triggerPath(triggers, req.TargetWorkflowID, req.SourceWorkflowID); path != nil {
+		return nil, apperror.InvalidInput("trigger would create a cycle: %s -> %s", strings.Join(path, " -> "), req.TargetWorkflowID)
+	}
+
+	now := time.Now()
+	trigger := &models.WorkflowTrigger{
+		ID:               uuid.New().String(),
+		TenantID:         req.TenantID,
+		SourceWorkflowID: req.SourceWorkflowID,
+		TargetWorkflowID: req.TargetWorkflowID,
+		On:               req.On,
+		Enabled:          true,
+		Description:      req.Description,
+		CreatedBy:        req.CreatedBy,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	if err := m.db.WithContext(ctx).Create(trigger).Error; err != nil {
+		return nil, fmt.Errorf("failed to create trigger: %w", err)
+	}
+
+	m.logger.Info("workflow trigger created",
+		zap.String("trigger_id", trigger.ID),
+		zap.String("source_workflow_id", trigger.SourceWorkflowID),
+		zap.String("target_workflow_id", trigger.TargetWorkflowID),
+		zap.String("on", string(trigger.On)))
+
+	return trigger, nil
+}
+
+// GetTrigger retrieves a trigger by ID
+func (m *Manager) GetTrigger(ctx context.Context, tenantID, triggerID string) (*models.WorkflowTrigger, error) {
+	var trigger models.WorkflowTrigger
+	if err := m.db.WithContext(ctx).Where("id = ? AND tenant_id = ?", triggerID, tenantID).First(&trigger).Error; err != nil {
+		return nil, apperror.NotFound("trigger not found")
+	}
+	return &trigger, nil
+}
+
+// ListTriggers lists the tenant's triggers, or only those whose source or
+// target is workflowID when it is set
+func (m *Manager) ListTriggers(ctx context.Context, tenantID, workflowID string) ([]models.WorkflowTrigger, error) {
+	query := m.db.WithContext(ctx).Where("tenant_id = ?", tenantID)
+	if workflowID != "" {
+		query = query.Where("source_workflow_id = ? OR target_workflow_id = ?", workflowID, workflowID)
+	}
+
+	var triggers []models.WorkflowTrigger
+	if err := query.Order("created_at ASC").Find(&triggers).Error; err != nil {
+		return nil, fmt.Errorf("failed to list triggers: %w", err)
+	}
+	return triggers, nil
+}
+
+// UpdateTrigger enables or disables a trigger or changes its description
+func (m *Manager) UpdateTrigger(ctx context.Context, tenantID, triggerID string, req *UpdateTriggerRequest) (*models.WorkflowTrigger, error) {
+	trigger, err := m.GetTrigger(ctx, tenantID, triggerID)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{"updated_at": time.Now()}
+	if req.Enabled != nil {
+		updates["enabled"] = *req.Enabled
+	}
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+	if err := m.db.WithContext(ctx).Model(trigger).Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("failed to update trigger: %w", err)
+	}
+	return m.GetTrigger(ctx, tenantID, triggerID)
+}
+
+// DeleteTrigger deletes a trigger
+func (m *Manager) DeleteTrigger(ctx context.Context, tenantID, triggerID string) error {
+	result := m.db.WithContext(ctx).Where("id = ? AND tenant_id = ?", triggerID, tenantID).Delete(&models.WorkflowTrigger{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete trigger: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return apperror.NotFound("trigger not found")
+	}
+	return nil
+}
+
+// tenantTriggers returns all of a tenant's triggers. Disabled triggers are
+// included in cycle checks so re-enabling one cannot close a cycle.
+func (m *Manager) tenantTriggers(ctx context.Context, tenantID string) ([]models.WorkflowTrigger, error) {
+	var triggers []models.WorkflowTrigger
+	if err := m.db.WithContext(ctx).
+		Select("id", "source_workflow_id", "target_workflow_id", "trigger_on", "enabled").
+		Where("tenant_id = ?", tenantID).
+		Find(&triggers).Error; err != nil {
+		return nil, fmt.Errorf("failed to load triggers: %w", err)
+	}
+	return triggers, nil
+}
+
+// triggerPath returns the workflow IDs on a trigger path from one workflow
+// to another, or nil when there is none
+func triggerPath(triggers []models.WorkflowTrigger, from, to string) []string {
+	next := make(map[string][]string)
+	for _, t := range triggers {
+		next[t.SourceWorkflowID] = append(next[t.SourceWorkflowID], t.TargetWorkflowID)
+	}
+
+	visited := make(map[string]bool)
+	var walk func(id string, path []string) []string
+	walk = func(id string, path []string) []string {
+		path = append(path, id)
+		if id == to {
+			return path
+		}
+		if visited[id] {
+			return nil
+		}
+		visited[id] = true
+		for _, target := range next[id] {
+			if found := walk(target, path); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+	return walk(from, nil)
+}
+
+// TriggerGraphNode is a workflow in the trigger graph
+type TriggerGraphNode struct {
+	WorkflowID string                `json:"workflow_id"`
+	Name       string                `json:"name"`
+	Status     models.WorkflowStatus `json:"status"`
+}
+
+// TriggerGraphEdge is a trigger in the trigger graph
+type TriggerGraphEdge struct {
+	TriggerID string                  `json:"trigger_id"`
+	Source    string                  `json:"source"`
+	Target    string                  `json:"target"`
+	On        models.TriggerCondition `json:"on"`
+	Enabled   bool                    `json:"enabled"`
+}
+
+// TriggerGraph is a tenant's workflows connected by triggers
+type TriggerGraph struct {
+	Nodes []TriggerGraphNode `json:"nodes"`
+	Edges []TriggerGraphEdge `json:"edges"`
+	// Roots are the workflows no trigger starts, where chains begin
+	Roots []string `json:"roots"`
+}
+
+// GetTriggerGraph returns the tenant's trigger graph. Only workflows with at
+// least one trigger are included.
+func (m *Manager) GetTriggerGraph(ctx context.Context, tenantID string) (*TriggerGraph, error) {
+	triggers, err := m.ListTriggers(ctx, tenantID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	graph := &TriggerGraph{
+		Nodes: []TriggerGraphNode{},
+		Edges: make([]TriggerGraphEdge, 0, len(triggers)),
+		Roots: []string{},
+	}
+	ids := make(map[string]bool)
+	targets := make(map[string]bool)
+	for _, t := range triggers {
+		graph.Edges = append(graph.Edges, TriggerGraphEdge{
+			TriggerID: t.ID,
+			Source:    t.SourceWorkflowID,
+			Target:    t.TargetWorkflowID,
+			On:        t.On,
+			Enabled:   t.Enabled,
+		})
+		ids[t.SourceWorkflowID] = true
+		ids[t.TargetWorkflowID] = true
+		targets[t.TargetWorkflowID] = true
+	}
+	if len(ids) == 0 {
+		return graph, nil
+	}
+
+	workflowIDs := make([]string, 0, len(ids))
+	for id := range ids {
+		workflowIDs = append(workflowIDs, id)
+	}
+	var workflows []models.Workflow
+	if err := m.db.WithContext(ctx).
+		Select("id", "name", "status").
+		Where("id IN ? AND tenant_id = ?", workflowIDs, tenantID).
+		Order("name ASC").
+		Find(&workflows).Error; err != nil {
+		return nil, fmt.Errorf("failed to load workflows: %w", err)
+	}
+	for _, wf := range workflows {
+		graph.Nodes = append(graph.Nodes, TriggerGraphNode{WorkflowID: wf.ID, Name: wf.Name, Status: wf.Status})
+		if !targets[wf.ID] {
+			graph.Roots = append(graph.Roots, wf.ID)
+		}
+	}
+	return graph, nil
+}
+
+// DOT renders the graph in Graphviz DOT format
+func (g *TriggerGraph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph triggers {\n")
+	b.WriteString("  rankdir=LR;\n")
+
+	nodes := append([]TriggerGraphNode(nil), g.Nodes...)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].WorkflowID < nodes[j].WorkflowID })
+	for _, n := range nodes {
+		style := ""
+		if n.Status != models.WorkflowStatusActive {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(&b, "  %q [label=%q%s];\n", n.WorkflowID, n.Name, style)
+	}
+	for _, e := range g.Edges {
+		style := ""
+		if !e.Enabled {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(&b, "  %q -> %q [label=%q%s];\n", e.Source, e.Target, string(e.On), style)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// fireTriggers starts the executions triggered by a finished execution and
+// runs its workflow's hooks. It runs at most once per execution: the first
+// caller to claim the execution's triggers_fired flag fires them.
+func (e *Executor) fireTriggers(ctx context.Context, executionID string) {
+	claim := e.db.WithContext(ctx).Model(&models.WorkflowExecution{}).
+		Where("id = ? AND triggers_fired = ?", executionID, false).
+		Update("triggers_fired", true)
+	if claim.Error != nil {
+		e.logger.Warn("failed to claim execution triggers",
+			zap.String("execution_id", executionID),
+			zap.Error(claim.Error))
+		return
+	}
+	if claim.RowsAffected == 0 {
+		return
+	}
+
+	var execution models.WorkflowExecution
+	if err := e.db.WithContext(ctx).
+		Select("id", "workflow_id", "tenant_id", "agent_id", "status", "trigger_depth", "execution_mode", "request_id").
+		Where("id = ?", executionID).
+		First(&execution).Error; err != nil {
+		e.logger.Warn("failed to load execution for triggers",
+			zap.String("execution_id", executionID),
+			zap.Error(err))
+		return
+	}
+
+	e.fireHooks(&execution)
+
+	// Triggered executions are traced to the request behind the chain
+	ctx = tracing.WithRequestID(ctx, execution.RequestID)
+
+	var triggers []models.WorkflowTrigger
+	if err := e.db.WithContext(ctx).
+		Where("tenant_id = ? AND source_workflow_id = ? AND enabled = ?", execution.TenantID, execution.WorkflowID, true).
+		Find(&triggers).Error; err != nil {
+		e.logger.Warn("failed to load workflow triggers",
+			zap.String("execution_id", executionID),
+			zap.Error(err))
+		return
+	}
+
+	for _, trigger := range triggers {
+		if !trigger.On.Matches(execution.Status) {
+			continue
+		}
+		if execution.TriggerDepth >= MaxTriggerDepth {
+			e.logger.Warn("workflow trigger skipped: chain depth limit reached",
+				zap.String("execution_id", execution.ID),
+				zap.String("trigger_id", trigger.ID),
+				zap.Int("max_depth", MaxTriggerDepth))
+			continue
+		}
+
+		// A validate execution only triggers validate executions
+		triggered, err := e.Execute(ctx, &ExecuteRequest{
+			TenantID:     execution.TenantID,
+			WorkflowID:   trigger.TargetWorkflowID,
+			AgentID:      execution.AgentID,
+			Mode:         execution.Mode,
+			TriggeredBy:  execution.ID,
+			triggerDepth: execution.TriggerDepth + 1,
+		})
+		if err != nil {
+			e.logger.Warn("workflow trigger failed to start execution",
+				zap.String("execution_id", execution.ID),
+				zap.String("trigger_id", trigger.ID),
+				zap.String("target_workflow_id", trigger.TargetWorkflowID),
+				zap.Error(err))
+			continue
+		}
+		e.logger.Info("workflow trigger fired",
+			zap.String("execution_id", execution.ID),
+			zap.String("trigger_id", trigger.ID),
+			zap.String("triggered_execution_id", triggered.ID))
+	}
+}
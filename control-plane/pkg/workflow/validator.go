@@ -2,10 +2,31 @@
 package workflow
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/url"
+	"regexp"
 	"strings"
+	"time"
 )
 
+// MaxDefinitionSize bounds the JSON encoding of a workflow definition. Each
+// save, snapshot and dispatch copies the definition whole; large files
+// belong in templates, whose content can be uploaded.
+const MaxDefinitionSize = 1 << 20
+
+// maxMatrixCombinations mirrors the agent's limit on steps generated from one matrix
+const maxMatrixCombinations = 256
+
+// maxRepeatIterations mirrors the agent's limit on the iterations of a repeated step
+const maxRepeatIterations = 1000
+
+// matrixKeyPattern matches valid matrix keys
+var matrixKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// pluginNamePattern matches plugin names, as the agent requires
+var pluginNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
 // Validator validates workflow definitions
 type Validator struct{}
 
@@ -39,11 +60,37 @@ func (e ValidationErrors) Error() string {
 func (v *Validator) Validate(definition map[string]interface{}) error {
 	var errors ValidationErrors
 
+	if data, err := json.Marshal(definition); err == nil && len(data) > MaxDefinitionSize {
+		return ValidationErrors{{"definition", fmt.Sprintf("is %d bytes, over the limit of %d; move large content into templates", len(data), MaxDefinitionSize)}}
+	}
+
 	// Check required fields
 	if _, ok := definition["name"]; !ok {
 		errors = append(errors, ValidationError{"name", "required field"})
 	}
 
+	if timeout, ok := definition["timeout"]; ok {
+		if err := validateDuration(timeout); err != nil {
+			errors = append(errors, ValidationError{"timeout", err.Error()})
+		}
+	}
+
+	// Templates expose agent facts under grains and earlier step results
+	// under steps; a variable would be hidden
+	if vars, ok := definition["vars"].(map[string]interface{}); ok {
+		if _, ok := vars["grains"]; ok {
+			errors = append(errors, ValidationError{"vars.grains", "grains is reserved for agent facts"})
+		}
+		if _, ok := vars["steps"]; ok {
+			errors = append(errors, ValidationError{"vars.steps", "steps is reserved for step results"})
+		}
+	}
+
+	// Agents reserve the declared resources before accepting the workflow
+	if resources, ok := definition["resources"]; ok {
+		errors = append(errors, validateResources(resources)...)
+	}
+
 	// Check steps
 	steps, ok := definition["steps"]
 	if !ok {
@@ -55,14 +102,24 @@ func (v *Validator) Validate(definition map[string]interface{}) error {
 		} else if len(stepsList) == 0 {
 			errors = append(errors, ValidationError{"steps", "must have at least one step"})
 		} else {
-			for i, step := range stepsList {
-				if err := v.validateStep(i, step); err != nil {
-					errors = append(errors, err...)
-				}
-			}
+			errors = append(errors, v.validateSteps("steps", stepsList)...)
 		}
 	}
 
+	// Hook steps run with the same executor as regular steps
+	for _, hook := range []string{"on_success", "on_failure", "on_cancel"} {
+		hookSteps, ok := definition[hook]
+		if !ok {
+			continue
+		}
+		hookList, ok := hookSteps.([]interface{})
+		if !ok {
+			errors = append(errors, ValidationError{hook, "must be an array"})
+			continue
+		}
+		errors = append(errors, v.validateSteps(hook, hookList)...)
+	}
+
 	if len(errors) > 0 {
 		return errors
 	}
@@ -70,10 +127,64 @@ func (v *Validator) Validate(definition map[string]interface{}) error {
 	return nil
 }
 
+// validateResources validates a workflow's resource needs
+func validateResources(resources interface{}) ValidationErrors {
+	resourceMap, ok := resources.(map[string]interface{})
+	if !ok {
+		return ValidationErrors{{"resources", "must be an object"}}
+	}
+
+	var errors ValidationErrors
+	for key, value := range resourceMap {
+		field := "resources." + key
+		switch key {
+		case "disk_gb", "memory_mb":
+			switch v := value.(type) {
+			case float64:
+				if v < 0 {
+					errors = append(errors, ValidationError{field, "must be non-negative"})
+				}
+			case int:
+				if v < 0 {
+					errors = append(errors, ValidationError{field, "must be non-negative"})
+				}
+			default:
+				errors = append(errors, ValidationError{field, "must be a number"})
+			}
+		default:
+			errors = append(errors, ValidationError{field, "unknown resource; expected disk_gb or memory_mb"})
+		}
+	}
+	return errors
+}
+
+// validateSteps validates a list of steps and checks that step IDs are unique
+func (v *Validator) validateSteps(field string, steps []interface{}) ValidationErrors {
+	var errors ValidationErrors
+	seen := make(map[string]bool)
+
+	for i, step := range steps {
+		prefix := fmt.Sprintf("%s[%d]", field, i)
+		errors = append(errors, v.validateStep(prefix, step)...)
+
+		stepMap, ok := step.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if id, ok := stepMap["id"].(string); ok && id != "" {
+			if seen[id] {
+				errors = append(errors, ValidationError{prefix + ".id", fmt.Sprintf("duplicate step ID: %s", id)})
+			}
+			seen[id] = true
+		}
+	}
+
+	return errors
+}
+
 // validateStep validates a single step
-func (v *Validator) validateStep(index int, step interface{}) ValidationErrors {
+func (v *Validator) validateStep(prefix string, step interface{}) ValidationErrors {
 	var errors ValidationErrors
-	prefix := fmt.Sprintf("steps[%d]", index)
 
 	stepMap, ok := step.(map[string]interface{})
 	if !ok {
@@ -106,6 +217,9 @@ func (v *Validator) validateStep(index int, step interface{}) ValidationErrors {
 				"file":     true,
 				"http":     true,
 				"validate": true,
+				"template": true,
+				"plugin":   true,
+				"callback": true,
 			}
 			if !validTypes[typeStr] {
 				errors = append(errors, ValidationError{prefix + ".type", fmt.Sprintf("invalid type: %s", typeStr)})
@@ -128,11 +242,82 @@ func (v *Validator) validateStep(index int, step interface{}) ValidationErrors {
 		}
 	}
 
-	// Validate timeout if present
-	if timeout, ok := stepMap["timeout"]; ok {
-		if _, ok := timeout.(string); !ok {
-			errors = append(errors, ValidationError{prefix + ".timeout", "must be a string duration"})
+	if stepType == "template" {
+		tmpl, ok := stepMap["template"].(map[string]interface{})
+		if !ok {
+			errors = append(errors, ValidationError{prefix + ".template", "required for template step"})
+		} else {
+			if _, ok := tmpl["source"]; !ok {
+				errors = append(errors, ValidationError{prefix + ".template.source", "required for template step"})
+			}
+			if _, ok := tmpl["dest"]; !ok {
+				errors = append(errors, ValidationError{prefix + ".template.dest", "required for template step"})
+			}
+		}
+	}
+
+	if stepType == "plugin" {
+		plugin, ok := stepMap["plugin"].(map[string]interface{})
+		if !ok {
+			errors = append(errors, ValidationError{prefix + ".plugin", "required for plugin step"})
+		} else {
+			name, _ := plugin["name"].(string)
+			if name == "" {
+				errors = append(errors, ValidationError{prefix + ".plugin.name", "required for plugin step"})
+			} else if !pluginNamePattern.MatchString(name) {
+				errors = append(errors, ValidationError{prefix + ".plugin.name", "must be lowercase letters, digits, - and _"})
+			}
+			if input, ok := plugin["input"]; ok {
+				if _, ok := input.(map[string]interface{}); !ok {
+					errors = append(errors, ValidationError{prefix + ".plugin.input", "must be an object"})
+				}
+			}
+		}
+	}
+
+	if stepType == "callback" {
+		errors = append(errors, validateCallback(prefix+".callback", stepMap["callback"])...)
+	}
+
+	if parser, ok := stepMap["output_parser"]; ok {
+		if stepType != "command" && stepType != "script" {
+			errors = append(errors, ValidationError{prefix + ".output_parser", "only supported for command and script steps"})
+		}
+		errors = append(errors, validateOutputParser(prefix+".output_parser", parser)...)
+	}
+
+	if precheck, ok := stepMap["precheck"]; ok {
+		if _, ok := precheck.(bool); !ok {
+			errors = append(errors, ValidationError{prefix + ".precheck", "must be a boolean"})
+		} else if stepType != "command" && stepType != "script" {
+			errors = append(errors, ValidationError{prefix + ".precheck", "only supported for command and script steps"})
+		}
+	}
+
+	// Validate durations if present
+	for _, field := range []string{"timeout", "retry_delay"} {
+		if value, ok := stepMap[field]; ok {
+			if err := validateDuration(value); err != nil {
+				errors = append(errors, ValidationError{prefix + "." + field, err.Error()})
+			}
+		}
+	}
+
+	// Validate matrix if present
+	if matrix, ok := stepMap["matrix"]; ok {
+		errors = append(errors, validateMatrix(prefix+".matrix", matrix)...)
+	}
+
+	if repeat, ok := stepMap["repeat"]; ok {
+		if stepType != "command" && stepType != "script" {
+			errors = append(errors, ValidationError{prefix + ".repeat", "only supported for command and script steps"})
 		}
+		if retries, ok := stepMap["retry_count"].(float64); ok && retries > 0 {
+			errors = append(errors, ValidationError{prefix + ".repeat", "repeat and retry_count are mutually exclusive"})
+		} else if retries, ok := stepMap["retry_count"].(int); ok && retries > 0 {
+			errors = append(errors, ValidationError{prefix + ".repeat", "repeat and retry_count are mutually exclusive"})
+		}
+		errors = append(errors, validateRepeat(prefix+".repeat", repeat)...)
 	}
 
 	// Validate retry_count if present
@@ -154,6 +339,194 @@ func (v *Validator) validateStep(index int, step interface{}) ValidationErrors {
 	return errors
 }
 
+// validateCallback checks a callback step posts to an http(s) URL with a
+// signing secret, given inline or as an agent environment variable
+func validateCallback(field string, value interface{}) ValidationErrors {
+	callback, ok := value.(map[string]interface{})
+	if !ok {
+		return ValidationErrors{{field, "required for callback step"}}
+	}
+
+	var errors ValidationErrors
+	rawURL, _ := callback["url"].(string)
+	if rawURL == "" {
+		errors = append(errors, ValidationError{field + ".url", "required for callback step"})
+	} else if u, err := url.Parse(rawURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errors = append(errors, ValidationError{field + ".url", "must be an absolute http or https URL"})
+	}
+
+	secret, _ := callback["secret"].(string)
+	secretEnv, _ := callback["secret_env"].(string)
+	switch {
+	case secret == "" && secretEnv == "":
+		errors = append(errors, ValidationError{field + ".secret", "secret or secret_env is required"})
+	case secret != "" && secretEnv != "":
+		errors = append(errors, ValidationError{field + ".secret", "secret and secret_env are mutually exclusive"})
+	}
+
+	for _, key := range []string{"headers", "payload"} {
+		if v, ok := callback[key]; ok {
+			if _, ok := v.(map[string]interface{}); !ok {
+				errors = append(errors, ValidationError{field + "." + key, "must be an object"})
+			}
+		}
+	}
+	if v, ok := callback["wait_for_confirmation"]; ok {
+		if _, ok := v.(bool); !ok {
+			errors = append(errors, ValidationError{field + ".wait_for_confirmation", "must be a boolean"})
+		}
+	}
+
+	return errors
+}
+
+// validateMatrix checks that a step matrix maps identifier keys to non-empty
+// lists of scalar values and does not expand to too many steps
+func validateMatrix(field string, value interface{}) ValidationErrors {
+	var errors ValidationErrors
+
+	matrix, ok := value.(map[string]interface{})
+	if !ok {
+		return ValidationErrors{{field, "must be an object"}}
+	}
+	if len(matrix) == 0 {
+		return ValidationErrors{{field, "must have at least one key"}}
+	}
+
+	total := 1
+	for key, raw := range matrix {
+		keyField := field + "." + key
+		if !matrixKeyPattern.MatchString(key) {
+			errors = append(errors, ValidationError{keyField, "key must be an identifier"})
+		}
+		values, ok := raw.([]interface{})
+		if !ok {
+			errors = append(errors, ValidationError{keyField, "must be a list"})
+			continue
+		}
+		if len(values) == 0 {
+			errors = append(errors, ValidationError{keyField, "must have at least one value"})
+			continue
+		}
+		for i, item := range values {
+			switch item.(type) {
+			case string, float64, int, bool:
+			default:
+				errors = append(errors, ValidationError{fmt.Sprintf("%s[%d]", keyField, i), "must be a string, number or boolean"})
+			}
+		}
+		if total <= maxMatrixCombinations {
+			total *= len(values)
+		}
+	}
+
+	if total > maxMatrixCombinations {
+		errors = append(errors, ValidationError{field, fmt.Sprintf("expands to more than %d steps", maxMatrixCombinations)})
+	}
+
+	return errors
+}
+
+// validateRepeat checks a repeated step has an until condition, a bounded
+// number of iterations and a valid delay, as the agent requires
+func validateRepeat(field string, value interface{}) ValidationErrors {
+	repeat, ok := value.(map[string]interface{})
+	if !ok {
+		return ValidationErrors{{field, "must be an object"}}
+	}
+
+	var errors ValidationErrors
+	if until, _ := repeat["until"].(string); strings.TrimSpace(until) == "" {
+		errors = append(errors, ValidationError{field + ".until", "required for repeat"})
+	}
+
+	iterationsField := field + ".max_iterations"
+	iterationsRange := fmt.Sprintf("must be an integer between 1 and %d", maxRepeatIterations)
+	switch v := repeat["max_iterations"].(type) {
+	case nil:
+		errors = append(errors, ValidationError{iterationsField, "required for repeat"})
+	case float64:
+		if v < 1 || v > maxRepeatIterations || v != float64(int(v)) {
+			errors = append(errors, ValidationError{iterationsField, iterationsRange})
+		}
+	case int:
+		if v < 1 || v > maxRepeatIterations {
+			errors = append(errors, ValidationError{iterationsField, iterationsRange})
+		}
+	default:
+		errors = append(errors, ValidationError{iterationsField, "must be a number"})
+	}
+
+	if delay, ok := repeat["delay"]; ok {
+		if err := validateDuration(delay); err != nil {
+			errors = append(errors, ValidationError{field + ".delay", err.Error()})
+		}
+	}
+
+	return errors
+}
+
+// validateOutputParser checks a step's output parser, as the agent does
+func validateOutputParser(field string, value interface{}) ValidationErrors {
+	parser, ok := value.(map[string]interface{})
+	if !ok {
+		return ValidationErrors{{field, "must be an object"}}
+	}
+
+	parserType, _ := parser["type"].(string)
+	switch parserType {
+	case "json", "key_value":
+	case "regex":
+		pattern, _ := parser["pattern"].(string)
+		if pattern == "" {
+			return ValidationErrors{{field + ".pattern", "required for the regex parser"}}
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return ValidationErrors{{field + ".pattern", fmt.Sprintf("invalid pattern: %v", err)}}
+		}
+		if len(namedGroups(re)) == 0 {
+			return ValidationErrors{{field + ".pattern", "must have at least one named group, e.g. (?P<name>...)"}}
+		}
+	default:
+		return ValidationErrors{{field + ".type", fmt.Sprintf("unknown type %q: must be json, key_value or regex", parserType)}}
+	}
+
+	if separator, ok := parser["separator"]; ok {
+		if s, ok := separator.(string); !ok || s == "" {
+			return ValidationErrors{{field + ".separator", "must be a non-empty string"}}
+		}
+	}
+	return nil
+}
+
+// namedGroups returns the names of a regular expression's named groups
+func namedGroups(re *regexp.Regexp) []string {
+	var names []string
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// validateDuration checks that a value is a non-negative duration string such as "5m"
+func validateDuration(value interface{}) error {
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("must be a string duration")
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration: %s", s)
+	}
+	if d < 0 {
+		return fmt.Errorf("must be non-negative")
+	}
+	return nil
+}
+
 // ValidateForExecution validates a workflow is ready for execution
 func (v *Validator) ValidateForExecution(definition map[string]interface{}) error {
 	// First run standard validation
@@ -0,0 +1,174 @@
+package workflow
+
+import (
+	"strings"
+	"testing"
+)
+
+// stepErrors validates a workflow of one step, given as JSON decodes it,
+// and lists the errors
+func stepErrors(t *testing.T, step map[string]interface{}) []string {
+	t.Helper()
+	step["id"], step["name"] = "step", "step"
+	err := NewValidator().Validate(map[string]interface{}{
+		"name":  "check",
+		"steps": []interface{}{step},
+	})
+	if err == nil {
+		return nil
+	}
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("Validate error = %v, want ValidationErrors", err)
+	}
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Error())
+	}
+	return got
+}
+
+func TestValidateRepeat(t *testing.T) {
+	command := func(repeat interface{}) map[string]interface{} {
+		return map[string]interface{}{"type": "command", "command": "systemctl is-active nginx", "repeat": repeat}
+	}
+	tests := []struct {
+		name string
+		step map[string]interface{}
+		want []string
+	}{
+		{
+			name: "valid",
+			step: command(map[string]interface{}{"until": `test "$VM_AGENT_EXIT_CODE" -eq 0`, "max_iterations": float64(10), "delay": "5s"}),
+		},
+		{
+			name: "integer iterations",
+			step: command(map[string]interface{}{"until": "true", "max_iterations": 1000}),
+		},
+		{
+			name: "not an object",
+			step: command("until true"),
+			want: []string{"steps[0].repeat: must be an object"},
+		},
+		{
+			name: "missing fields",
+			step: command(map[string]interface{}{"until": " "}),
+			want: []string{"steps[0].repeat.until: required for repeat", "steps[0].repeat.max_iterations: required for repeat"},
+		},
+		{
+			name: "too many iterations",
+			step: command(map[string]interface{}{"until": "true", "max_iterations": float64(1001)}),
+			want: []string{"steps[0].repeat.max_iterations: must be an integer between 1 and 1000"},
+		},
+		{
+			name: "fractional iterations",
+			step: command(map[string]interface{}{"until": "true", "max_iterations": 2.5}),
+			want: []string{"steps[0].repeat.max_iterations: must be an integer between 1 and 1000"},
+		},
+		{
+			name: "no iterations",
+			step: command(map[string]interface{}{"until": "true", "max_iterations": 0}),
+			want: []string{"steps[0].repeat.max_iterations: must be an integer between 1 and 1000"},
+		},
+		{
+			name: "iterations as a string",
+			step: command(map[string]interface{}{"until": "true", "max_iterations": "10"}),
+			want: []string{"steps[0].repeat.max_iterations: must be a number"},
+		},
+		{
+			name: "invalid delay",
+			step: command(map[string]interface{}{"until": "true", "max_iterations": float64(3), "delay": "soon"}),
+			want: []string{"steps[0].repeat.delay: invalid duration: soon"},
+		},
+		{
+			name: "with retries",
+			step: map[string]interface{}{
+				"type": "script", "script": "curl -fsS http://localhost/health", "retry_count": float64(2),
+				"repeat": map[string]interface{}{"until": "true", "max_iterations": float64(3)},
+			},
+			want: []string{"steps[0].repeat: repeat and retry_count are mutually exclusive"},
+		},
+		{
+			name: "other step types",
+			step: map[string]interface{}{
+				"type": "http", "url": "http://localhost/health",
+				"repeat": map[string]interface{}{"until": "true", "max_iterations": float64(3)},
+			},
+			want: []string{"steps[0].repeat: only supported for command and script steps"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stepErrors(t, tt.step); strings.Join(got, "; ") != strings.Join(tt.want, "; ") {
+				t.Errorf("errors = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateOutputParser(t *testing.T) {
+	command := func(parser interface{}) map[string]interface{} {
+		return map[string]interface{}{"type": "command", "command": "df -h /", "output_parser": parser}
+	}
+	tests := []struct {
+		name string
+		step map[string]interface{}
+		want []string
+	}{
+		{name: "json", step: command(map[string]interface{}{"type": "json"})},
+		{name: "key_value", step: command(map[string]interface{}{"type": "key_value", "separator": ":"})},
+		{name: "regex", step: command(map[string]interface{}{"type": "regex", "pattern": `(?P<free>\d+)% free`})},
+		{
+			name: "not an object",
+			step: command("json"),
+			want: []string{"steps[0].output_parser: must be an object"},
+		},
+		{
+			name: "unknown type",
+			step: command(map[string]interface{}{"type": "xml"}),
+			want: []string{`steps[0].output_parser.type: unknown type "xml": must be json, key_value or regex`},
+		},
+		{
+			name: "regex without pattern",
+			step: command(map[string]interface{}{"type": "regex"}),
+			want: []string{"steps[0].output_parser.pattern: required for the regex parser"},
+		},
+		{
+			name: "invalid pattern",
+			step: command(map[string]interface{}{"type": "regex", "pattern": `(?P<free>\d+`}),
+			want: []string{"steps[0].output_parser.pattern: invalid pattern: error parsing regexp: missing closing ): `(?P<free>\\d+`"},
+		},
+		{
+			name: "no named group",
+			step: command(map[string]interface{}{"type": "regex", "pattern": `(\d+)% free`}),
+			want: []string{"steps[0].output_parser.pattern: must have at least one named group, e.g. (?P<name>...)"},
+		},
+		{
+			name: "empty separator",
+			step: command(map[string]interface{}{"type": "key_value", "separator": ""}),
+			want: []string{"steps[0].output_parser.separator: must be a non-empty string"},
+		},
+		{
+			name: "separator not a string",
+			step: command(map[string]interface{}{"type": "key_value", "separator": float64(1)}),
+			want: []string{"steps[0].output_parser.separator: must be a non-empty string"},
+		},
+		{
+			name: "other step types",
+			step: map[string]interface{}{
+				"type": "http", "url": "http://localhost/health",
+				"output_parser": map[string]interface{}{"type": "json"},
+			},
+			want: []string{"steps[0].output_parser: only supported for command and script steps"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stepErrors(t, tt.step); strings.Join(got, "; ") != strings.Join(tt.want, "; ") {
+				t.Errorf("errors = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,545 @@
+// Package workflow provides workflow management for the control plane.
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/yourorg/control-plane/pkg/apperror"
+	"github.com/yourorg/control-plane/pkg/audit"
+	"github.com/yourorg/control-plane/pkg/db/models"
+)
+
+// DefaultWatchdogInterval is how often the watchdog looks for stuck executions
+const DefaultWatchdogInterval = time.Minute
+
+// DefaultWatchdogGrace is how long an execution may run past its workflow
+// timeout before it is considered stuck
+const DefaultWatchdogGrace = 5 * time.Minute
+
+// defaultWorkflowTimeout is the timeout the agent applies to workflows that
+// do not set one
+const defaultWorkflowTimeout = 30 * time.Minute
+
+// maxStuckExecutions caps how many stuck executions are handled at once
+const maxStuckExecutions = 500
+
+// Stuck execution resolutions
+const (
+	StuckActionRequeue = "requeue"
+	StuckActionClose   = "close"
+)
+
+// errAgentLostExecution is returned when the agent has no record of an execution
+var errAgentLostExecution = errors.New("agent has no record of the execution")
+
+// StuckExecution is a pending or running execution past its deadline
+type StuckExecution struct {
+	ID         string                 `json:"id"`
+	TenantID   string                 `json:"tenant_id"`
+	WorkflowID string                 `json:"workflow_id"`
+	AgentID    string                 `json:"agent_id"`
+	CampaignID *string                `json:"campaign_id,omitempty"`
+	Status     models.ExecutionStatus `json:"status"`
+	Mode       models.ExecutionMode   `json:"mode"`
+	// Adhoc executions are requeued with the definition they ran
+	Adhoc          bool `json:"adhoc,omitempty"`
+	definitionHash string
+	// Since is when the execution started, or was created if it never started
+	Since    time.Time `json:"since"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// ResolveStuckRequest closes or requeues stuck executions. Either
+// ExecutionIDs or All must be set.
+type ResolveStuckRequest struct {
+	Action       string   `json:"action" binding:"required"`
+	ExecutionIDs []string `json:"execution_ids"`
+	All          bool     `json:"all"`
+	Reason       string   `json:"reason"`
+}
+
+// StuckResolution is the outcome of resolving one stuck execution
+type StuckResolution struct {
+	ExecutionID string `json:"execution_id"`
+	Action      string `json:"action"`
+	RequeuedAs  string `json:"requeued_as,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Watchdog fails executions that outlive their workflow timeout, typically
+// because the agent running them died
+type Watchdog struct {
+	db          *gorm.DB
+	executor    *Executor
+	logger      *zap.Logger
+	interval    time.Duration
+	grace       time.Duration
+	auditLogger *audit.Logger
+}
+
+// NewWatchdog creates a new stuck execution watchdog
+func NewWatchdog(db *gorm.DB, executor *Executor, interval, grace time.Duration, logger *zap.Logger) *Watchdog {
+	if interval <= 0 {
+		interval = DefaultWatchdogInterval
+	}
+	if grace <= 0 {
+		grace = DefaultWatchdogGrace
+	}
+	return &Watchdog{
+		db:       db,
+		executor: executor,
+		logger:   logger,
+		interval: interval,
+		grace:    grace,
+	}
+}
+
+// SetAuditLogger sets the logger that receives stuck execution events
+func (w *Watchdog) SetAuditLogger(auditLogger *audit.Logger) {
+	w.auditLogger = auditLogger
+}
+
+// Run checks for stuck executions until the context is cancelled
+func (w *Watchdog) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check asks the agent of every stuck execution for its status and fails the
+// execution unless the agent reports a final result
+func (w *Watchdog) check(ctx context.Context) {
+	stuck, err := w.findStuck(ctx, "", nil, maxStuckExecutions)
+	if err != nil {
+		w.logger.Error("failed to find stuck executions", zap.Error(err))
+		return
+	}
+
+	for i := range stuck {
+		w.reap(ctx, &stuck[i])
+	}
+}
+
+// reap resolves a single stuck execution
+func (w *Watchdog) reap(ctx context.Context, s *StuckExecution) {
+	result, err := w.queryAgent(ctx, s)
+	switch {
+	case err == nil && isFinalAgentStatus(result["status"]):
+		// The agent finished but its result never arrived
+		if err := w.executor.RecordAgentResult(ctx, s.TenantID, s.AgentID, s.ID, result); err != nil {
+			w.logger.Warn("failed to record result of stuck execution",
+				zap.String("execution_id", s.ID),
+				zap.Error(err))
+			return
+		}
+		w.audit(ctx, s, audit.ActionUpdate, "watchdog", "system", "recovered result of stuck execution from agent", audit.OutcomeSuccess, map[string]interface{}{
+			"status": result["status"],
+		})
+		return
+	case err == nil:
+		// The agent should have enforced the timeout itself; stop the run
+		if cancelErr := w.cancelOnAgent(ctx, s); cancelErr != nil {
+			w.logger.Warn("failed to cancel stuck execution on agent",
+				zap.String("execution_id", s.ID),
+				zap.Error(cancelErr))
+		}
+		w.fail(ctx, s, models.ExecutionStatusTimeout, "execution exceeded its workflow timeout")
+	case errors.Is(err, errAgentLostExecution):
+		w.fail(ctx, s, models.ExecutionStatusFailed, err.Error())
+	default:
+		w.fail(ctx, s, models.ExecutionStatusTimeout, fmt.Sprintf("execution exceeded its workflow timeout and the agent is unreachable: %v", err))
+	}
+}
+
+// fail closes a stuck execution with the given status and reason
+func (w *Watchdog) fail(ctx context.Context, s *StuckExecution, status models.ExecutionStatus, reason string) {
+	closed, err := w.close(ctx, s, status, models.JSONMap{
+		"error":    reason,
+		"deadline": s.Deadline,
+	})
+	if err != nil {
+		w.logger.Error("failed to close stuck execution",
+			zap.String("execution_id", s.ID),
+			zap.Error(err))
+		return
+	}
+	if !closed {
+		return
+	}
+
+	w.logger.Warn("stuck execution closed by watchdog",
+		zap.String("execution_id", s.ID),
+		zap.String("agent_id", s.AgentID),
+		zap.String("status", string(status)),
+		zap.String("reason", reason))
+	w.audit(ctx, s, audit.ActionStop, "watchdog", "system", reason, audit.OutcomeFailure, map[string]interface{}{
+		"status":   string(status),
+		"deadline": s.Deadline,
+	})
+}
+
+// close moves a stuck execution to a final status. It returns false if the
+// execution completed in the meantime.
+func (w *Watchdog) close(ctx context.Context, s *StuckExecution, status models.ExecutionStatus, result models.JSONMap) (bool, error) {
+	res := w.db.WithContext(ctx).Model(&models.WorkflowExecution{}).
+		Where("id = ? AND status IN ?", s.ID, []models.ExecutionStatus{models.ExecutionStatusPending, models.ExecutionStatusRunning}).
+		Updates(map[string]interface{}{
+			"status":       status,
+			"completed_at": time.Now(),
+			"result":       result,
+		})
+	if res.Error != nil {
+		return false, fmt.Errorf("failed to close execution: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return false, nil
+	}
+	w.executor.fireTriggers(ctx, s.ID)
+	return true, nil
+}
+
+// ListStuck returns the tenant's executions that are past their deadline,
+// oldest first
+func (w *Watchdog) ListStuck(ctx context.Context, tenantID string, limit int) ([]StuckExecution, error) {
+	if limit <= 0 || limit > maxStuckExecutions {
+		limit = maxStuckExecutions
+	}
+	return w.findStuck(ctx, tenantID, nil, limit)
+}
+
+// Resolve closes or requeues stuck executions of a tenant. Requeued
+// executions are closed as failed and dispatched again to the same agent.
+func (w *Watchdog) Resolve(ctx context.Context, tenantID string, req *ResolveStuckRequest, actorID string) ([]StuckResolution, error) {
+	if req.Action != StuckActionRequeue && req.Action != StuckActionClose {
+		return nil, apperror.InvalidInput("invalid action %q: must be %s or %s", req.Action, StuckActionRequeue, StuckActionClose)
+	}
+	if len(req.ExecutionIDs) == 0 && !req.All {
+		return nil, apperror.InvalidInput("execution_ids or all is required")
+	}
+	if len(req.ExecutionIDs) > maxStuckExecutions {
+		return nil, apperror.InvalidInput("at most %d executions can be resolved at once", maxStuckExecutions)
+	}
+
+	stuck, err := w.findStuck(ctx, tenantID, req.ExecutionIDs, maxStuckExecutions)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*StuckExecution, len(stuck))
+	for i := range stuck {
+		byID[stuck[i].ID] = &stuck[i]
+	}
+
+	ids := req.ExecutionIDs
+	if len(ids) == 0 {
+		for _, s := range stuck {
+			ids = append(ids, s.ID)
+		}
+	}
+
+	resolutions := make([]StuckResolution, 0, len(ids))
+	for _, id := range ids {
+		resolution := StuckResolution{ExecutionID: id, Action: req.Action}
+		s, ok := byID[id]
+		if !ok {
+			resolution.Error = "execution not found or not stuck"
+			resolutions = append(resolutions, resolution)
+			continue
+		}
+
+		if err := w.resolve(ctx, s, req, actorID, &resolution); err != nil {
+			resolution.Error = err.Error()
+		}
+		resolutions = append(resolutions, resolution)
+	}
+
+	return resolutions, nil
+}
+
+// resolve closes a stuck execution and, for requeue, starts a new execution
+// of the same workflow on the same agent
+func (w *Watchdog) resolve(ctx context.Context, s *StuckExecution, req *ResolveStuckRequest, actorID string, resolution *StuckResolution) error {
+	reason := fmt.Sprintf("closed by %s", actorID)
+	if req.Action == StuckActionRequeue {
+		reason = fmt.Sprintf("requeued by %s", actorID)
+	}
+	if req.Reason != "" {
+		reason += ": " + req.Reason
+	}
+
+	result := models.JSONMap{
+		"error":    reason,
+		"deadline": s.Deadline,
+	}
+	closed, err := w.close(ctx, s, models.ExecutionStatusFailed, result)
+	if err != nil {
+		return err
+	}
+	if !closed {
+		return apperror.InvalidState("execution completed before it could be resolved")
+	}
+
+	metadata := map[string]interface{}{
+		"status":   string(models.ExecutionStatusFailed),
+		"deadline": s.Deadline,
+	}
+
+	if req.Action == StuckActionRequeue {
+		executeReq := &ExecuteRequest{
+			TenantID:   s.TenantID,
+			WorkflowID: s.WorkflowID,
+			AgentID:    s.AgentID,
+			Mode:       s.Mode,
+		}
+		if s.CampaignID != nil {
+			executeReq.CampaignID = *s.CampaignID
+		}
+		if s.Adhoc {
+			executeReq.DefinitionHash = s.definitionHash
+			executeReq.adhoc = true
+		}
+
+		execution, err := w.executor.Execute(ctx, executeReq)
+		if err != nil {
+			w.audit(ctx, s, audit.ActionExecute, actorID, "user", reason, audit.OutcomeFailure, metadata)
+			return fmt.Errorf("execution closed but requeue failed: %w", err)
+		}
+		resolution.RequeuedAs = execution.ID
+		metadata["requeued_as"] = execution.ID
+
+		result["requeued_as"] = execution.ID
+		if err := w.db.WithContext(ctx).Model(&models.WorkflowExecution{}).
+			Where("id = ?", s.ID).
+			Update("result", result).Error; err != nil {
+			w.logger.Warn("failed to record requeued execution",
+				zap.String("execution_id", s.ID),
+				zap.Error(err))
+		}
+	}
+
+	action := audit.ActionStop
+	if req.Action == StuckActionRequeue {
+		action = audit.ActionExecute
+	}
+	w.audit(ctx, s, action, actorID, "user", reason, audit.OutcomeSuccess, metadata)
+
+	return nil
+}
+
+// findStuck returns pending or running executions whose deadline, the
+// workflow timeout plus the grace period, has passed. An empty tenantID
+// searches every tenant; ids restricts the search to those executions.
+func (w *Watchdog) findStuck(ctx context.Context, tenantID string, ids []string, limit int) ([]StuckExecution, error) {
+	now := time.Now()
+
+	// No execution can be stuck before the grace period has passed
+	query := w.db.WithContext(ctx).
+		Where("status IN ?", []models.ExecutionStatus{models.ExecutionStatusPending, models.ExecutionStatusRunning}).
+		Where("COALESCE(started_at, created_at) < ?", now.Add(-w.grace))
+	if tenantID != "" {
+		query = query.Where("tenant_id = ?", tenantID)
+	}
+	if len(ids) > 0 {
+		query = query.Where("id IN ?", ids)
+	}
+
+	var executions []models.WorkflowExecution
+	if err := query.Order("created_at ASC").Limit(limit).Find(&executions).Error; err != nil {
+		return nil, fmt.Errorf("failed to list running executions: %w", err)
+	}
+	if len(executions) == 0 {
+		return nil, nil
+	}
+
+	timeouts, err := w.workflowTimeouts(ctx, executions)
+	if err != nil {
+		return nil, err
+	}
+
+	var stuck []StuckExecution
+	for _, execution := range executions {
+		since := execution.CreatedAt
+		if execution.StartedAt != nil {
+			since = *execution.StartedAt
+		}
+		// Time spent paused does not count towards the workflow timeout
+		deadline := since.Add(timeouts[timeoutKey(&execution)] + w.grace + pausedFor(execution.Result))
+		if now.Before(deadline) {
+			continue
+		}
+		stuck = append(stuck, StuckExecution{
+			ID:             execution.ID,
+			TenantID:       execution.TenantID,
+			WorkflowID:     execution.WorkflowID,
+			AgentID:        execution.AgentID,
+			CampaignID:     execution.CampaignID,
+			Status:         execution.Status,
+			Mode:           execution.Mode,
+			Adhoc:          execution.Adhoc,
+			definitionHash: execution.DefinitionHash,
+			Since:          since,
+			Deadline:       deadline,
+		})
+	}
+
+	return stuck, nil
+}
+
+// workflowTimeouts returns the timeout of each workflow of the executions,
+// keyed by timeoutKey
+func (w *Watchdog) workflowTimeouts(ctx context.Context, executions []models.WorkflowExecution) (map[string]time.Duration, error) {
+	var workflowIDs, hashes []string
+	timeouts := make(map[string]time.Duration, len(executions))
+	for i := range executions {
+		key := timeoutKey(&executions[i])
+		if _, ok := timeouts[key]; ok {
+			continue
+		}
+		timeouts[key] = defaultWorkflowTimeout
+		if executions[i].Adhoc {
+			hashes = append(hashes, key)
+		} else {
+			workflowIDs = append(workflowIDs, key)
+		}
+	}
+
+	setTimeout := func(key string, definition models.JSONMap) {
+		if s, ok := definition["timeout"].(string); ok {
+			if d, err := time.ParseDuration(s); err == nil && d > 0 {
+				timeouts[key] = d
+			}
+		}
+	}
+
+	if len(workflowIDs) > 0 {
+		var workflows []models.Workflow
+		if err := w.db.WithContext(ctx).
+			Select("id", "definition").
+			Where("id IN ?", workflowIDs).
+			Find(&workflows).Error; err != nil {
+			return nil, fmt.Errorf("failed to load workflow timeouts: %w", err)
+		}
+		for _, wf := range workflows {
+			setTimeout(wf.ID, wf.Definition)
+		}
+	}
+
+	// Ad-hoc executions have no workflow of their own; their definition
+	// is only kept as a snapshot
+	if len(hashes) > 0 {
+		var snapshots []models.WorkflowSnapshot
+		if err := w.db.WithContext(ctx).
+			Select("definition_hash", "definition").
+			Where("definition_hash IN ?", hashes).
+			Find(&snapshots).Error; err != nil {
+			return nil, fmt.Errorf("failed to load ad-hoc definition timeouts: %w", err)
+		}
+		for _, snapshot := range snapshots {
+			setTimeout(snapshot.DefinitionHash, snapshot.Definition)
+		}
+	}
+
+	return timeouts, nil
+}
+
+// timeoutKey identifies the definition an execution's timeout comes from:
+// its workflow, or the definition hash of an ad-hoc execution
+func timeoutKey(execution *models.WorkflowExecution) string {
+	if execution.Adhoc {
+		return execution.DefinitionHash
+	}
+	return execution.WorkflowID
+}
+
+// queryAgent asks the agent for the status of an execution through the Piko proxy
+func (w *Watchdog) queryAgent(ctx context.Context, s *StuckExecution) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.executor.dispatchTimeout())
+	defer cancel()
+
+	resp, dispatchErr := w.executor.agentRequest(ctx, s.TenantID, s.AgentID, http.MethodGet, "/workflow/status?id="+url.QueryEscape(s.ID), nil, nil)
+	if dispatchErr != nil {
+		if dispatchErr.StatusCode == http.StatusNotFound {
+			return nil, errAgentLostExecution
+		}
+		return nil, fmt.Errorf("failed to query agent: %w", dispatchErr)
+	}
+	defer resp.Body.Close()
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode agent status: %w", err)
+	}
+	return result, nil
+}
+
+// cancelOnAgent asks the agent to cancel an execution through the Piko proxy
+func (w *Watchdog) cancelOnAgent(ctx context.Context, s *StuckExecution) error {
+	ctx, cancel := context.WithTimeout(ctx, w.executor.dispatchTimeout())
+	defer cancel()
+
+	resp, dispatchErr := w.executor.agentRequest(ctx, s.TenantID, s.AgentID, http.MethodPost, "/workflow/cancel?id="+url.QueryEscape(s.ID), nil, nil)
+	if dispatchErr != nil {
+		return fmt.Errorf("failed to send cancel request to agent: %w", dispatchErr)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// audit records a stuck execution event if an audit logger is configured
+func (w *Watchdog) audit(ctx context.Context, s *StuckExecution, action audit.EventAction, actorID, actorType, description string, outcome audit.EventOutcome, metadata map[string]interface{}) {
+	if w.auditLogger == nil {
+		return
+	}
+
+	metadata["workflow_id"] = s.WorkflowID
+	metadata["agent_id"] = s.AgentID
+	if s.CampaignID != nil {
+		metadata["campaign_id"] = *s.CampaignID
+	}
+
+	if err := w.auditLogger.NewEventBuilder().
+		WithTenant(s.TenantID).
+		WithType(audit.EventTypeWorkflow).
+		WithAction(action).
+		WithOutcome(outcome).
+		WithActor(actorID, actorType).
+		WithResource(s.ID, "execution").
+		WithDescription(description).
+		WithMetadata(metadata).
+		Log(ctx); err != nil {
+		w.logger.Warn("failed to audit stuck execution",
+			zap.String("execution_id", s.ID),
+			zap.Error(err))
+	}
+}
+
+// pausedFor returns how long an execution was paused, as last reported by
+// its agent
+func pausedFor(result models.JSONMap) time.Duration {
+	d, _ := models.AgentDuration(result, "paused_for")
+	return d
+}
+
+// isFinalAgentStatus reports whether an agent workflow status is final
+func isFinalAgentStatus(status interface{}) bool {
+	s, _ := status.(string)
+	return s == "success" || s == "failed" || s == "cancelled"
+}
@@ -123,6 +123,22 @@ detect_platform() {
     esac
 
     log_info "Detected platform: ${OS}/${ARCH}"
+
+    # macOS keeps daemon data and logs under /Library and runs daemons as root
+    if [ "${OS}" = "darwin" ]; then
+        DATA_DIR="/Library/Application Support/vm-agent"
+        LOG_DIR="/Library/Logs/vm-agent"
+        SERVICE_USER="root"
+    fi
+}
+
+# Print the SHA256 checksum of a file
+sha256_of() {
+    if command -v sha256sum &>/dev/null; then
+        sha256sum "$1" | awk '{print $1}'
+    else
+        shasum -a 256 "$1" | awk '{print $1}'
+    fi
 }
 
 # Create user and directories
@@ -130,13 +146,13 @@ setup_directories() {
     log_info "Setting up directories..."
 
     # Create service user if not exists
-    if ! id "${SERVICE_USER}" &>/dev/null; then
+    if [ "${OS}" = "linux" ] && ! id "${SERVICE_USER}" &>/dev/null; then
         useradd --system --no-create-home --shell /bin/false "${SERVICE_USER}"
     fi
 
     # Create directories
-    mkdir -p "${CONFIG_DIR}" "${DATA_DIR}" "${LOG_DIR}"
-    chown -R "${SERVICE_USER}:${SERVICE_USER}" "${DATA_DIR}" "${LOG_DIR}"
+    mkdir -p "${CONFIG_DIR}" "${DATA_DIR}" "${LOG_DIR}" "${INSTALL_DIR}"
+    chown -R "${SERVICE_USER}:$(service_group)" "${DATA_DIR}" "${LOG_DIR}"
     chmod 700 "${DATA_DIR}"
     chmod 755 "${LOG_DIR}"
 }
@@ -162,7 +178,7 @@ install_binary() {
     if curl -sSL -o "${TEMP_FILE}.sha256" "${CHECKSUM_URL}" 2>/dev/null; then
         log_info "Verifying checksum..."
         EXPECTED_SUM=$(cat "${TEMP_FILE}.sha256" | awk '{print $1}')
-        ACTUAL_SUM=$(sha256sum "${TEMP_FILE}" | awk '{print $1}')
+        ACTUAL_SUM=$(sha256_of "${TEMP_FILE}")
         if [ "${EXPECTED_SUM}" != "${ACTUAL_SUM}" ]; then
             log_error "Checksum verification failed"
             exit 1
@@ -220,11 +236,78 @@ logging:
 EOF
 
     chmod 600 "${CONFIG_DIR}/config.yaml"
-    chown "${SERVICE_USER}:${SERVICE_USER}" "${CONFIG_DIR}/config.yaml"
+    chown "${SERVICE_USER}:$(service_group)" "${CONFIG_DIR}/config.yaml"
 }
 
-# Install systemd service
+# Print the group owning agent files
+service_group() {
+    if [ "${OS}" = "darwin" ]; then
+        echo "wheel"
+    else
+        echo "${SERVICE_USER}"
+    fi
+}
+
+# Install the platform service
 install_service() {
+    case "${OS}" in
+        darwin)
+            install_launchd_service
+            ;;
+        *)
+            install_systemd_service
+            ;;
+    esac
+}
+
+# Install launchd daemon
+install_launchd_service() {
+    log_info "Installing launchd daemon..."
+
+    PLIST="/Library/LaunchDaemons/com.yourorg.vm-agent.plist"
+
+    cat > "${PLIST}" <<EOF
+<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>com.yourorg.vm-agent</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>${INSTALL_DIR}/vm-agent</string>
+		<string>run</string>
+		<string>--config</string>
+		<string>${CONFIG_DIR}/config.yaml</string>
+		<string>--data-dir</string>
+		<string>${DATA_DIR}</string>
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>ThrottleInterval</key>
+	<integer>10</integer>
+	<key>StandardOutPath</key>
+	<string>${LOG_DIR}/agent.out.log</string>
+	<key>StandardErrorPath</key>
+	<string>${LOG_DIR}/agent.err.log</string>
+</dict>
+</plist>
+EOF
+
+    chown root:wheel "${PLIST}"
+    chmod 644 "${PLIST}"
+
+    launchctl bootout system/com.yourorg.vm-agent 2>/dev/null || true
+    launchctl bootstrap system "${PLIST}"
+    launchctl enable system/com.yourorg.vm-agent
+
+    log_info "Daemon installed and started"
+}
+
+# Install systemd service
+install_systemd_service() {
     log_info "Installing systemd service..."
 
     cat > /etc/systemd/system/vm-agent.service <<EOF
@@ -275,9 +358,15 @@ main() {
     echo ""
     log_info "=== Installation Complete ==="
     log_info "Agent Status:"
-    systemctl status vm-agent --no-pager || true
-    echo ""
-    log_info "View logs: journalctl -u vm-agent -f"
+    if [ "${OS}" = "darwin" ]; then
+        launchctl print system/com.yourorg.vm-agent | grep -E '^\s*state =' || true
+        echo ""
+        log_info "View logs: tail -f '${LOG_DIR}/agent.out.log'"
+    else
+        systemctl status vm-agent --no-pager || true
+        echo ""
+        log_info "View logs: journalctl -u vm-agent -f"
+    fi
     log_info "Configuration: ${CONFIG_DIR}/config.yaml"
 }
 
@@ -0,0 +1,75 @@
+terraform {
+  required_providers {
+    vmmanager = {
+      source = "yourorg/vmmanager"
+    }
+  }
+}
+
+# endpoint and token default to VMMANAGER_ENDPOINT and VMMANAGER_TOKEN
+provider "vmmanager" {}
+
+resource "vmmanager_tenant" "acme" {
+  name            = "acme"
+  description     = "Acme Corp"
+  quota_agents    = 500
+  quota_workflows = 50
+}
+
+resource "vmmanager_installation_key" "web" {
+  tenant_id    = vmmanager_tenant.acme.id
+  description  = "Web tier bootstrap"
+  expiry_hours = 72
+  usage_limit  = 20
+  tags = {
+    role = "web"
+  }
+}
+
+resource "vmmanager_template" "motd" {
+  name    = "motd"
+  content = "Welcome to {{ .hostname }}\n"
+  status  = "active"
+}
+
+resource "vmmanager_workflow" "patch" {
+  name   = "patch-web"
+  status = "active"
+  tags = {
+    team = "platform"
+  }
+
+  definition = jsonencode({
+    name    = "patch-web"
+    timeout = "20m"
+    steps = [
+      {
+        id      = "update"
+        name    = "Install updates"
+        type    = "command"
+        command = "apt-get"
+        args    = ["-y", "upgrade"]
+      },
+    ]
+  })
+}
+
+# Patch at 3am in each agent's local timezone
+resource "vmmanager_schedule" "nightly_patch" {
+  name          = "nightly-patch-web"
+  workflow_id   = vmmanager_workflow.patch.id
+  cron          = "0 3 * * *"
+  timezone_mode = "agent_local"
+  max_agents    = 20
+
+  target_selector = jsonencode({
+    tags = {
+      role = "web"
+    }
+  })
+}
+
+output "web_installation_key" {
+  value     = vmmanager_installation_key.web.key
+  sensitive = true
+}
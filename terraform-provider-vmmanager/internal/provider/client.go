@@ -0,0 +1,394 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client calls the control plane REST API
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient creates a new API client for the control plane at endpoint
+func NewClient(endpoint, token string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(endpoint, "/") + "/api/v1",
+		token:   token,
+		httpClient: &http.Client{
+			Timeout: 60 * time.Second,
+		},
+	}
+}
+
+// APIError is an error response from the control plane
+type APIError struct {
+	StatusCode int
+	Code       string          `json:"code"`
+	Message    string          `json:"message"`
+	Details    json.RawMessage `json:"details,omitempty"`
+	RequestID  string          `json:"request_id,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("control plane returned %d %s: %s", e.StatusCode, e.Code, e.Message)
+	if e.RequestID != "" {
+		msg += " (request " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// IsNotFound reports whether err is a not found API error
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// do sends a JSON request and decodes the JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call control plane: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		var envelope struct {
+			Error *APIError `json:"error"`
+		}
+		envelope.Error = apiErr
+		if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil || apiErr.Message == "" {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+		apiErr.StatusCode = resp.StatusCode
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// Tenant is a control plane tenant
+type Tenant struct {
+	ID             string                 `json:"id"`
+	Name           string                 `json:"name"`
+	Description    string                 `json:"description,omitempty"`
+	Status         string                 `json:"status"`
+	Settings       map[string]interface{} `json:"settings,omitempty"`
+	QuotaAgents    int64                  `json:"quota_agents"`
+	QuotaWorkflows int64                  `json:"quota_workflows"`
+}
+
+// TenantRequest creates or updates a tenant
+type TenantRequest struct {
+	Name           *string                `json:"name,omitempty"`
+	Description    *string                `json:"description,omitempty"`
+	Settings       map[string]interface{} `json:"settings"`
+	QuotaAgents    *int64                 `json:"quota_agents,omitempty"`
+	QuotaWorkflows *int64                 `json:"quota_workflows,omitempty"`
+}
+
+// CreateTenant creates a tenant
+func (c *Client) CreateTenant(ctx context.Context, req *TenantRequest) (*Tenant, error) {
+	var t Tenant
+	if err := c.do(ctx, http.MethodPost, "/tenants", req, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetTenant gets a tenant. Deleted tenants are reported as not found.
+func (c *Client) GetTenant(ctx context.Context, id string) (*Tenant, error) {
+	var t Tenant
+	if err := c.do(ctx, http.MethodGet, "/tenants/"+url.PathEscape(id), nil, &t); err != nil {
+		return nil, err
+	}
+	if t.Status == "deleted" {
+		return nil, &APIError{StatusCode: http.StatusNotFound, Code: "not_found", Message: "tenant deleted"}
+	}
+	return &t, nil
+}
+
+// UpdateTenant updates a tenant
+func (c *Client) UpdateTenant(ctx context.Context, id string, req *TenantRequest) (*Tenant, error) {
+	var t Tenant
+	if err := c.do(ctx, http.MethodPut, "/tenants/"+url.PathEscape(id), req, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// DeleteTenant deletes a tenant
+func (c *Client) DeleteTenant(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/tenants/"+url.PathEscape(id), nil, nil)
+}
+
+// InstallationKey is an agent installation key. Its secret is only
+// returned when the key is created.
+type InstallationKey struct {
+	ID          string                 `json:"id"`
+	TenantID    string                 `json:"tenant_id"`
+	Description string                 `json:"description,omitempty"`
+	Tags        map[string]interface{} `json:"tags,omitempty"`
+	UsageLimit  int64                  `json:"usage_limit"`
+	UsageCount  int64                  `json:"usage_count"`
+	ExpiresAt   time.Time              `json:"expires_at"`
+}
+
+// InstallationKeyRequest creates an installation key
+type InstallationKeyRequest struct {
+	Description string            `json:"description,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	ExpiryHours int64             `json:"expiry_hours,omitempty"`
+	UsageLimit  int64             `json:"usage_limit,omitempty"`
+}
+
+// CreatedInstallationKey is returned once when an installation key is created
+type CreatedInstallationKey struct {
+	KeyID     string    `json:"key_id"`
+	Key       string    `json:"key"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateInstallationKey creates an installation key for a tenant
+func (c *Client) CreateInstallationKey(ctx context.Context, tenantID string, req *InstallationKeyRequest) (*CreatedInstallationKey, error) {
+	var key CreatedInstallationKey
+	if err := c.do(ctx, http.MethodPost, "/tenants/"+url.PathEscape(tenantID)+"/installation-keys", req, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetInstallationKey gets an installation key
+func (c *Client) GetInstallationKey(ctx context.Context, tenantID, keyID string) (*InstallationKey, error) {
+	var key InstallationKey
+	if err := c.do(ctx, http.MethodGet, "/tenants/"+url.PathEscape(tenantID)+"/installation-keys/"+url.PathEscape(keyID), nil, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// DeleteInstallationKey deletes an installation key
+func (c *Client) DeleteInstallationKey(ctx context.Context, tenantID, keyID string) error {
+	return c.do(ctx, http.MethodDelete, "/tenants/"+url.PathEscape(tenantID)+"/installation-keys/"+url.PathEscape(keyID), nil, nil)
+}
+
+// Workflow is a workflow definition in the token's tenant
+type Workflow struct {
+	ID          string                 `json:"id"`
+	TenantID    string                 `json:"tenant_id"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Definition  map[string]interface{} `json:"definition"`
+	Version     int64                  `json:"version"`
+	Status      string                 `json:"status"`
+	Tags        map[string]string      `json:"tags,omitempty"`
+}
+
+// WorkflowRequest creates or updates a workflow. ExpectedVersion is
+// required for updates.
+type WorkflowRequest struct {
+	Name            *string                `json:"name,omitempty"`
+	Description     *string                `json:"description,omitempty"`
+	Definition      map[string]interface{} `json:"definition,omitempty"`
+	Status          *string                `json:"status,omitempty"`
+	Tags            map[string]string      `json:"tags"`
+	ExpectedVersion *int64                 `json:"expected_version,omitempty"`
+}
+
+// CreateWorkflow creates a workflow
+func (c *Client) CreateWorkflow(ctx context.Context, req *WorkflowRequest) (*Workflow, error) {
+	var wf Workflow
+	if err := c.do(ctx, http.MethodPost, "/workflows", req, &wf); err != nil {
+		return nil, err
+	}
+	return &wf, nil
+}
+
+// GetWorkflow gets a workflow. Deleted workflows are reported as not found.
+func (c *Client) GetWorkflow(ctx context.Context, id string) (*Workflow, error) {
+	var wf Workflow
+	if err := c.do(ctx, http.MethodGet, "/workflows/"+url.PathEscape(id), nil, &wf); err != nil {
+		return nil, err
+	}
+	if wf.Status == "deleted" {
+		return nil, &APIError{StatusCode: http.StatusNotFound, Code: "not_found", Message: "workflow deleted"}
+	}
+	return &wf, nil
+}
+
+// UpdateWorkflow updates a workflow
+func (c *Client) UpdateWorkflow(ctx context.Context, id string, req *WorkflowRequest) (*Workflow, error) {
+	var wf Workflow
+	if err := c.do(ctx, http.MethodPut, "/workflows/"+url.PathEscape(id), req, &wf); err != nil {
+		return nil, err
+	}
+	return &wf, nil
+}
+
+// DeleteWorkflow deletes a workflow
+func (c *Client) DeleteWorkflow(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/workflows/"+url.PathEscape(id), nil, nil)
+}
+
+// Template is a configuration template in the token's tenant
+type Template struct {
+	ID          string                 `json:"id"`
+	TenantID    string                 `json:"tenant_id"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Content     string                 `json:"content"`
+	ContentType string                 `json:"content_type"`
+	Version     int64                  `json:"version"`
+	Status      string                 `json:"status"`
+	Tags        map[string]interface{} `json:"tags,omitempty"`
+}
+
+// TemplateRequest creates or updates a template. ExpectedVersion is
+// required for updates.
+type TemplateRequest struct {
+	Name            *string                `json:"name,omitempty"`
+	Description     *string                `json:"description,omitempty"`
+	Content         *string                `json:"content,omitempty"`
+	ContentType     *string                `json:"content_type,omitempty"`
+	Status          *string                `json:"status,omitempty"`
+	Tags            map[string]interface{} `json:"tags"`
+	ChangeNote      string                 `json:"change_note,omitempty"`
+	ExpectedVersion *int64                 `json:"expected_version,omitempty"`
+}
+
+// CreateTemplate creates a template
+func (c *Client) CreateTemplate(ctx context.Context, req *TemplateRequest) (*Template, error) {
+	var tpl Template
+	if err := c.do(ctx, http.MethodPost, "/templates", req, &tpl); err != nil {
+		return nil, err
+	}
+	return &tpl, nil
+}
+
+// GetTemplate gets a template. Deleted templates are reported as not found.
+func (c *Client) GetTemplate(ctx context.Context, id string) (*Template, error) {
+	var tpl Template
+	if err := c.do(ctx, http.MethodGet, "/templates/"+url.PathEscape(id), nil, &tpl); err != nil {
+		return nil, err
+	}
+	if tpl.Status == "deleted" {
+		return nil, &APIError{StatusCode: http.StatusNotFound, Code: "not_found", Message: "template deleted"}
+	}
+	return &tpl, nil
+}
+
+// UpdateTemplate updates a template
+func (c *Client) UpdateTemplate(ctx context.Context, id string, req *TemplateRequest) (*Template, error) {
+	var tpl Template
+	if err := c.do(ctx, http.MethodPut, "/templates/"+url.PathEscape(id), req, &tpl); err != nil {
+		return nil, err
+	}
+	return &tpl, nil
+}
+
+// DeleteTemplate deletes a template
+func (c *Client) DeleteTemplate(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/templates/"+url.PathEscape(id), nil, nil)
+}
+
+// Schedule runs a workflow by cron in the token's tenant
+type Schedule struct {
+	ID             string                 `json:"id"`
+	TenantID       string                 `json:"tenant_id"`
+	Name           string                 `json:"name"`
+	Description    string                 `json:"description,omitempty"`
+	WorkflowID     string                 `json:"workflow_id"`
+	TargetSelector map[string]interface{} `json:"target_selector"`
+	Vars           map[string]interface{} `json:"vars,omitempty"`
+	Cron           string                 `json:"cron"`
+	Timezone       string                 `json:"timezone"`
+	TimezoneMode   string                 `json:"timezone_mode"`
+	MaxAgents      int64                  `json:"max_agents"`
+	Enabled        bool                   `json:"enabled"`
+	NextRunAt      string                 `json:"next_run_at,omitempty"`
+}
+
+// ScheduleRequest creates or updates a schedule. An empty Vars map clears
+// the schedule's vars on update.
+type ScheduleRequest struct {
+	Name           *string                `json:"name,omitempty"`
+	Description    *string                `json:"description,omitempty"`
+	WorkflowID     *string                `json:"workflow_id,omitempty"`
+	TargetSelector map[string]interface{} `json:"target_selector,omitempty"`
+	Vars           map[string]interface{} `json:"vars,omitempty"`
+	Cron           *string                `json:"cron,omitempty"`
+	Timezone       *string                `json:"timezone,omitempty"`
+	TimezoneMode   *string                `json:"timezone_mode,omitempty"`
+	MaxAgents      *int64                 `json:"max_agents,omitempty"`
+	Enabled        *bool                  `json:"enabled,omitempty"`
+}
+
+// CreateSchedule creates a schedule
+func (c *Client) CreateSchedule(ctx context.Context, req *ScheduleRequest) (*Schedule, error) {
+	var s Schedule
+	if err := c.do(ctx, http.MethodPost, "/schedules", req, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetSchedule gets a schedule
+func (c *Client) GetSchedule(ctx context.Context, id string) (*Schedule, error) {
+	var s Schedule
+	if err := c.do(ctx, http.MethodGet, "/schedules/"+url.PathEscape(id), nil, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// UpdateSchedule updates a schedule
+func (c *Client) UpdateSchedule(ctx context.Context, id string, req *ScheduleRequest) (*Schedule, error) {
+	var s Schedule
+	if err := c.do(ctx, http.MethodPut, "/schedules/"+url.PathEscape(id), req, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// DeleteSchedule deletes a schedule
+func (c *Client) DeleteSchedule(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/schedules/"+url.PathEscape(id), nil, nil)
+}
@@ -0,0 +1,232 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64default"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/mapplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringdefault"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var (
+	_ resource.Resource                = &installationKeyResource{}
+	_ resource.ResourceWithConfigure   = &installationKeyResource{}
+	_ resource.ResourceWithImportState = &installationKeyResource{}
+)
+
+// installationKeyResource manages an agent installation key. Keys cannot be
+// changed once created, so every configurable attribute forces replacement.
+type installationKeyResource struct {
+	client *Client
+}
+
+// installationKeyModel is the vmmanager_installation_key state
+type installationKeyModel struct {
+	ID          types.String `tfsdk:"id"`
+	TenantID    types.String `tfsdk:"tenant_id"`
+	Description types.String `tfsdk:"description"`
+	Tags        types.Map    `tfsdk:"tags"`
+	ExpiryHours types.Int64  `tfsdk:"expiry_hours"`
+	UsageLimit  types.Int64  `tfsdk:"usage_limit"`
+	Key         types.String `tfsdk:"key"`
+	ExpiresAt   types.String `tfsdk:"expires_at"`
+	UsageCount  types.Int64  `tfsdk:"usage_count"`
+}
+
+// NewInstallationKeyResource creates the vmmanager_installation_key resource
+func NewInstallationKeyResource() resource.Resource {
+	return &installationKeyResource{}
+}
+
+// Metadata returns the resource type name
+func (r *installationKeyResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_installation_key"
+}
+
+// Schema returns the resource schema
+func (r *installationKeyResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "An agent installation key. Managing installation keys requires a token with the admin scope.",
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				Computed:      true,
+				PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+			"tenant_id": schema.StringAttribute{
+				Required:      true,
+				PlanModifiers: []planmodifier.String{stringplanmodifier.RequiresReplace()},
+			},
+			"description": schema.StringAttribute{
+				Optional:      true,
+				Computed:      true,
+				Default:       stringdefault.StaticString(""),
+				PlanModifiers: []planmodifier.String{stringplanmodifier.RequiresReplace()},
+			},
+			"tags": schema.MapAttribute{
+				Description:   "Tags applied to agents that register with this key.",
+				ElementType:   types.StringType,
+				Optional:      true,
+				PlanModifiers: []planmodifier.Map{mapplanmodifier.RequiresReplace()},
+			},
+			"expiry_hours": schema.Int64Attribute{
+				Optional:      true,
+				Computed:      true,
+				Default:       int64default.StaticInt64(24),
+				PlanModifiers: []planmodifier.Int64{int64planmodifier.RequiresReplace()},
+			},
+			"usage_limit": schema.Int64Attribute{
+				Description:   "Number of agents that can register with this key.",
+				Optional:      true,
+				Computed:      true,
+				Default:       int64default.StaticInt64(1),
+				PlanModifiers: []planmodifier.Int64{int64planmodifier.RequiresReplace()},
+			},
+			"key": schema.StringAttribute{
+				Description:   "The installation key. Only known for keys created by Terraform; it is null after import.",
+				Computed:      true,
+				Sensitive:     true,
+				PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+			"expires_at": schema.StringAttribute{
+				Description:   "Expiry time in RFC 3339 format.",
+				Computed:      true,
+				PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+			"usage_count": schema.Int64Attribute{
+				Description: "Number of agents that have registered with this key.",
+				Computed:    true,
+			},
+		},
+	}
+}
+
+// Configure stores the provider's API client
+func (r *installationKeyResource) Configure(ctx context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	r.client = clientFromProviderData(req.ProviderData, &resp.Diagnostics)
+}
+
+// Create creates the installation key and records its secret
+func (r *installationKeyResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var plan installationKeyModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	tags := stringMap(ctx, plan.Tags, &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	tenantID := plan.TenantID.ValueString()
+	created, err := r.client.CreateInstallationKey(ctx, tenantID, &InstallationKeyRequest{
+		Description: plan.Description.ValueString(),
+		Tags:        tags,
+		ExpiryHours: plan.ExpiryHours.ValueInt64(),
+		UsageLimit:  plan.UsageLimit.ValueInt64(),
+	})
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to create installation key", err.Error())
+		return
+	}
+
+	plan.ID = types.StringValue(created.KeyID)
+	plan.Key = types.StringValue(created.Key)
+	plan.ExpiresAt = types.StringValue(created.ExpiresAt.UTC().Format(time.RFC3339))
+	plan.UsageCount = types.Int64Value(0)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+// Read refreshes the installation key from the control plane
+func (r *installationKeyResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	var state installationKeyModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	key, err := r.client.GetInstallationKey(ctx, state.TenantID.ValueString(), state.ID.ValueString())
+	if IsNotFound(err) {
+		resp.State.RemoveResource(ctx)
+		return
+	}
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to read installation key", err.Error())
+		return
+	}
+
+	resp.Diagnostics.Append(r.setState(ctx, &state, key)...)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+}
+
+// Update only carries computed values forward; every configurable attribute
+// requires replacement.
+func (r *installationKeyResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	var plan, state installationKeyModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	plan.ID = state.ID
+	plan.Key = state.Key
+	plan.ExpiresAt = state.ExpiresAt
+	plan.UsageCount = state.UsageCount
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+// Delete revokes the installation key
+func (r *installationKeyResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	var state installationKeyModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if err := r.client.DeleteInstallationKey(ctx, state.TenantID.ValueString(), state.ID.ValueString()); err != nil && !IsNotFound(err) {
+		resp.Diagnostics.AddError("Failed to delete installation key", err.Error())
+	}
+}
+
+// ImportState imports an installation key by "tenant_id/key_id". The key
+// secret cannot be recovered and stays null.
+func (r *installationKeyResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
+	tenantID, keyID, ok := strings.Cut(req.ID, "/")
+	if !ok || tenantID == "" || keyID == "" {
+		resp.Diagnostics.AddError("Invalid import ID",
+			fmt.Sprintf("Expected an import ID of the form tenant_id/key_id, got %q.", req.ID))
+		return
+	}
+
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("tenant_id"), tenantID)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), keyID)...)
+}
+
+// setState copies an installation key read from the control plane into the
+// model. The control plane stores the expiry rather than expiry_hours, so an
+// imported key keeps the default.
+func (r *installationKeyResource) setState(ctx context.Context, m *installationKeyModel, key *InstallationKey) (diags diag.Diagnostics) {
+	m.ID = types.StringValue(key.ID)
+	m.TenantID = types.StringValue(key.TenantID)
+	m.Description = types.StringValue(key.Description)
+	m.UsageLimit = types.Int64Value(key.UsageLimit)
+	m.UsageCount = types.Int64Value(key.UsageCount)
+	m.ExpiresAt = types.StringValue(key.ExpiresAt.UTC().Format(time.RFC3339))
+	m.Tags = stringMapValue(ctx, m.Tags, stringifyMap(key.Tags), &diags)
+	if m.ExpiryHours.IsNull() {
+		m.ExpiryHours = types.Int64Value(24)
+	}
+	return diags
+}
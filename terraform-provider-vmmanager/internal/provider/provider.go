@@ -0,0 +1,126 @@
+// Package provider implements the VM manager Terraform provider.
+package provider
+
+import (
+	"context"
+	"os"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/provider"
+	"github.com/hashicorp/terraform-plugin-framework/provider/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// Environment variables read when the provider block omits a setting
+const (
+	envEndpoint = "VMMANAGER_ENDPOINT"
+	envToken    = "VMMANAGER_TOKEN"
+)
+
+var _ provider.Provider = &vmmanagerProvider{}
+
+// vmmanagerProvider manages control plane configuration through its REST API
+type vmmanagerProvider struct {
+	version string
+}
+
+// providerModel is the provider block configuration
+type providerModel struct {
+	Endpoint types.String `tfsdk:"endpoint"`
+	Token    types.String `tfsdk:"token"`
+}
+
+// New returns a constructor for the provider
+func New(version string) func() provider.Provider {
+	return func() provider.Provider {
+		return &vmmanagerProvider{version: version}
+	}
+}
+
+// Metadata returns the provider type name
+func (p *vmmanagerProvider) Metadata(ctx context.Context, req provider.MetadataRequest, resp *provider.MetadataResponse) {
+	resp.TypeName = "vmmanager"
+	resp.Version = p.version
+}
+
+// Schema returns the provider block schema
+func (p *vmmanagerProvider) Schema(ctx context.Context, req provider.SchemaRequest, resp *provider.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "Manages VM manager control plane configuration.",
+		Attributes: map[string]schema.Attribute{
+			"endpoint": schema.StringAttribute{
+				Description: "Control plane base URL, e.g. https://vm-manager.example.com. Defaults to " + envEndpoint + ".",
+				Optional:    true,
+			},
+			"token": schema.StringAttribute{
+				Description: "API token. Tenant resources need a token with the admin scope; workflows and templates are created in the token's tenant. Defaults to " + envToken + ".",
+				Optional:    true,
+				Sensitive:   true,
+			},
+		},
+	}
+}
+
+// Configure creates the API client shared by all resources
+func (p *vmmanagerProvider) Configure(ctx context.Context, req provider.ConfigureRequest, resp *provider.ConfigureResponse) {
+	var config providerModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &config)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if config.Endpoint.IsUnknown() {
+		resp.Diagnostics.AddAttributeError(path.Root("endpoint"), "Unknown control plane endpoint",
+			"The endpoint must be known when the provider is configured.")
+	}
+	if config.Token.IsUnknown() {
+		resp.Diagnostics.AddAttributeError(path.Root("token"), "Unknown API token",
+			"The token must be known when the provider is configured.")
+	}
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	endpoint := os.Getenv(envEndpoint)
+	if !config.Endpoint.IsNull() {
+		endpoint = config.Endpoint.ValueString()
+	}
+	token := os.Getenv(envToken)
+	if !config.Token.IsNull() {
+		token = config.Token.ValueString()
+	}
+
+	if endpoint == "" {
+		resp.Diagnostics.AddAttributeError(path.Root("endpoint"), "Missing control plane endpoint",
+			"Set endpoint in the provider block or the "+envEndpoint+" environment variable.")
+	}
+	if token == "" {
+		resp.Diagnostics.AddAttributeError(path.Root("token"), "Missing API token",
+			"Set token in the provider block or the "+envToken+" environment variable.")
+	}
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client := NewClient(endpoint, token)
+	resp.ResourceData = client
+	resp.DataSourceData = client
+}
+
+// Resources returns the provider's resources
+func (p *vmmanagerProvider) Resources(ctx context.Context) []func() resource.Resource {
+	return []func() resource.Resource{
+		NewTenantResource,
+		NewInstallationKeyResource,
+		NewWorkflowResource,
+		NewTemplateResource,
+		NewScheduleResource,
+	}
+}
+
+// DataSources returns the provider's data sources
+func (p *vmmanagerProvider) DataSources(ctx context.Context) []func() datasource.DataSource {
+	return nil
+}
@@ -0,0 +1,266 @@
+package provider
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/booldefault"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64default"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringdefault"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var (
+	_ resource.Resource                = &scheduleResource{}
+	_ resource.ResourceWithConfigure   = &scheduleResource{}
+	_ resource.ResourceWithImportState = &scheduleResource{}
+)
+
+// scheduleResource manages a schedule in the token's tenant
+type scheduleResource struct {
+	client *Client
+}
+
+// scheduleModel is the vmmanager_schedule state
+type scheduleModel struct {
+	ID             types.String `tfsdk:"id"`
+	Name           types.String `tfsdk:"name"`
+	Description    types.String `tfsdk:"description"`
+	WorkflowID     types.String `tfsdk:"workflow_id"`
+	TargetSelector types.String `tfsdk:"target_selector"`
+	Vars           types.String `tfsdk:"vars"`
+	Cron           types.String `tfsdk:"cron"`
+	Timezone       types.String `tfsdk:"timezone"`
+	TimezoneMode   types.String `tfsdk:"timezone_mode"`
+	MaxAgents      types.Int64  `tfsdk:"max_agents"`
+	Enabled        types.Bool   `tfsdk:"enabled"`
+	NextRunAt      types.String `tfsdk:"next_run_at"`
+}
+
+// NewScheduleResource creates the vmmanager_schedule resource
+func NewScheduleResource() resource.Resource {
+	return &scheduleResource{}
+}
+
+// Metadata returns the resource type name
+func (r *scheduleResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_schedule"
+}
+
+// Schema returns the resource schema
+func (r *scheduleResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "A schedule running a workflow by cron. Schedules are created in the tenant of the provider's token.",
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				Computed:      true,
+				PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+			"name": schema.StringAttribute{
+				Required: true,
+			},
+			"description": schema.StringAttribute{
+				Optional: true,
+				Computed: true,
+				Default:  stringdefault.StaticString(""),
+			},
+			"workflow_id": schema.StringAttribute{
+				Required: true,
+			},
+			"target_selector": schema.StringAttribute{
+				Description: "Agent selector as a JSON object with tags, agent_ids and status, usually jsonencode() of it.",
+				Required:    true,
+			},
+			"vars": schema.StringAttribute{
+				Description: "Workflow vars for every execution as a JSON object.",
+				Optional:    true,
+			},
+			"cron": schema.StringAttribute{
+				Description: "Five-field cron expression: minute, hour, day of month, month and day of week.",
+				Required:    true,
+			},
+			"timezone": schema.StringAttribute{
+				Description: "IANA timezone a fixed schedule is read in, and the fallback for agents that report no timezone.",
+				Optional:    true,
+				Computed:    true,
+				Default:     stringdefault.StaticString("UTC"),
+			},
+			"timezone_mode": schema.StringAttribute{
+				Description: "fixed reads the cron expression in timezone; agent_local reads it in each agent's local timezone.",
+				Optional:    true,
+				Computed:    true,
+				Default:     stringdefault.StaticString("fixed"),
+			},
+			"max_agents": schema.Int64Attribute{
+				Description: "A run whose selector matches more agents is skipped.",
+				Optional:    true,
+				Computed:    true,
+				Default:     int64default.StaticInt64(10),
+			},
+			"enabled": schema.BoolAttribute{
+				Optional: true,
+				Computed: true,
+				Default:  booldefault.StaticBool(true),
+			},
+			"next_run_at": schema.StringAttribute{
+				Description: "When the schedule is next evaluated, in RFC 3339 format.",
+				Computed:    true,
+			},
+		},
+	}
+}
+
+// Configure stores the provider's API client
+func (r *scheduleResource) Configure(ctx context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	r.client = clientFromProviderData(req.ProviderData, &resp.Diagnostics)
+}
+
+// Create creates the schedule
+func (r *scheduleResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var plan scheduleModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	body := scheduleRequest(&plan, &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	s, err := r.client.CreateSchedule(ctx, body)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to create schedule", err.Error())
+		return
+	}
+
+	resp.Diagnostics.Append(r.setState(&plan, s, true)...)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+// Read refreshes the schedule from the control plane
+func (r *scheduleResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	var state scheduleModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	s, err := r.client.GetSchedule(ctx, state.ID.ValueString())
+	if IsNotFound(err) {
+		resp.State.RemoveResource(ctx)
+		return
+	}
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to read schedule", err.Error())
+		return
+	}
+
+	resp.Diagnostics.Append(r.setState(&state, s, false)...)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+}
+
+// Update updates the schedule
+func (r *scheduleResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	var plan, state scheduleModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	body := scheduleRequest(&plan, &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if body.Vars == nil {
+		// An empty object clears vars removed from the configuration
+		body.Vars = map[string]interface{}{}
+	}
+	s, err := r.client.UpdateSchedule(ctx, state.ID.ValueString(), body)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to update schedule", err.Error())
+		return
+	}
+
+	resp.Diagnostics.Append(r.setState(&plan, s, true)...)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+// Delete deletes the schedule
+func (r *scheduleResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	var state scheduleModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if err := r.client.DeleteSchedule(ctx, state.ID.ValueString()); err != nil && !IsNotFound(err) {
+		resp.Diagnostics.AddError("Failed to delete schedule", err.Error())
+	}
+}
+
+// ImportState imports a schedule by ID
+func (r *scheduleResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
+	resource.ImportStatePassthroughID(ctx, path.Root("id"), req, resp)
+}
+
+// scheduleRequest builds the create or update request for a planned
+// schedule
+func scheduleRequest(plan *scheduleModel, diags *diag.Diagnostics) *ScheduleRequest {
+	name := plan.Name.ValueString()
+	description := plan.Description.ValueString()
+	workflowID := plan.WorkflowID.ValueString()
+	cron := plan.Cron.ValueString()
+	timezone := plan.Timezone.ValueString()
+	timezoneMode := plan.TimezoneMode.ValueString()
+	maxAgents := plan.MaxAgents.ValueInt64()
+	enabled := plan.Enabled.ValueBool()
+	return &ScheduleRequest{
+		Name:           &name,
+		Description:    &description,
+		WorkflowID:     &workflowID,
+		TargetSelector: decodeJSONObject("target_selector", plan.TargetSelector, diags),
+		Vars:           decodeJSONObject("vars", plan.Vars, diags),
+		Cron:           &cron,
+		Timezone:       &timezone,
+		TimezoneMode:   &timezoneMode,
+		MaxAgents:      &maxAgents,
+		Enabled:        &enabled,
+	}
+}
+
+// setState copies a schedule read from the control plane into the model.
+// After a create or update the planned JSON attributes are kept verbatim.
+func (r *scheduleResource) setState(m *scheduleModel, s *Schedule, planned bool) (diags diag.Diagnostics) {
+	m.ID = types.StringValue(s.ID)
+	m.Name = types.StringValue(s.Name)
+	m.Description = types.StringValue(s.Description)
+	m.WorkflowID = types.StringValue(s.WorkflowID)
+	m.Cron = types.StringValue(s.Cron)
+	m.Timezone = types.StringValue(s.Timezone)
+	m.TimezoneMode = types.StringValue(s.TimezoneMode)
+	m.MaxAgents = types.Int64Value(s.MaxAgents)
+	m.Enabled = types.BoolValue(s.Enabled)
+	m.NextRunAt = types.StringValue(s.NextRunAt)
+
+	if !planned {
+		selector, err := jsonObjectValue(m.TargetSelector, s.TargetSelector)
+		if err != nil {
+			diags.AddError("Failed to encode schedule target_selector", err.Error())
+			return diags
+		}
+		m.TargetSelector = selector
+		vars, err := jsonObjectValue(m.Vars, s.Vars)
+		if err != nil {
+			diags.AddError("Failed to encode schedule vars", err.Error())
+			return diags
+		}
+		m.Vars = vars
+	}
+	return diags
+}
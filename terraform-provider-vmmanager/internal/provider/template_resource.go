@@ -0,0 +1,253 @@
+package provider
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringdefault"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var (
+	_ resource.Resource                = &templateResource{}
+	_ resource.ResourceWithConfigure   = &templateResource{}
+	_ resource.ResourceWithImportState = &templateResource{}
+)
+
+// templateResource manages a configuration template in the token's tenant
+type templateResource struct {
+	client *Client
+}
+
+// templateModel is the vmmanager_template state
+type templateModel struct {
+	ID          types.String `tfsdk:"id"`
+	Name        types.String `tfsdk:"name"`
+	Description types.String `tfsdk:"description"`
+	Content     types.String `tfsdk:"content"`
+	ContentType types.String `tfsdk:"content_type"`
+	Status      types.String `tfsdk:"status"`
+	Tags        types.Map    `tfsdk:"tags"`
+	ChangeNote  types.String `tfsdk:"change_note"`
+	Version     types.Int64  `tfsdk:"version"`
+}
+
+// NewTemplateResource creates the vmmanager_template resource
+func NewTemplateResource() resource.Resource {
+	return &templateResource{}
+}
+
+// Metadata returns the resource type name
+func (r *templateResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_template"
+}
+
+// Schema returns the resource schema
+func (r *templateResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "A configuration template. Templates are created in the tenant of the provider's token.",
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				Computed:      true,
+				PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+			"name": schema.StringAttribute{
+				Required: true,
+			},
+			"description": schema.StringAttribute{
+				Optional: true,
+				Computed: true,
+				Default:  stringdefault.StaticString(""),
+			},
+			"content": schema.StringAttribute{
+				Required: true,
+			},
+			"content_type": schema.StringAttribute{
+				Optional: true,
+				Computed: true,
+				Default:  stringdefault.StaticString("text/plain"),
+			},
+			"status": schema.StringAttribute{
+				Description: "draft, active or deprecated.",
+				Optional:    true,
+				Computed:    true,
+				Default:     stringdefault.StaticString("draft"),
+			},
+			"tags": schema.MapAttribute{
+				ElementType: types.StringType,
+				Optional:    true,
+			},
+			"change_note": schema.StringAttribute{
+				Description: "Note recorded in the template's version history when it is updated.",
+				Optional:    true,
+			},
+			"version": schema.Int64Attribute{
+				Description: "Version of the template, incremented by the control plane on every change.",
+				Computed:    true,
+			},
+		},
+	}
+}
+
+// Configure stores the provider's API client
+func (r *templateResource) Configure(ctx context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	r.client = clientFromProviderData(req.ProviderData, &resp.Diagnostics)
+}
+
+// Create creates the template, then moves it out of draft if requested
+func (r *templateResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var plan templateModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	tags := stringMap(ctx, plan.Tags, &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	name := plan.Name.ValueString()
+	description := plan.Description.ValueString()
+	content := plan.Content.ValueString()
+	contentType := plan.ContentType.ValueString()
+	status := plan.Status.ValueString()
+	tpl, err := r.client.CreateTemplate(ctx, &TemplateRequest{
+		Name:        &name,
+		Description: &description,
+		Content:     &content,
+		ContentType: &contentType,
+		Tags:        interfaceMap(tags),
+	})
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to create template", err.Error())
+		return
+	}
+
+	// Record the ID first so a failed status change does not orphan the template
+	plan.ID = types.StringValue(tpl.ID)
+	plan.Version = types.Int64Value(tpl.Version)
+	plan.Status = types.StringValue(tpl.Status)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if status != tpl.Status {
+		version := tpl.Version
+		tpl, err = r.client.UpdateTemplate(ctx, tpl.ID, &TemplateRequest{
+			Status:          &status,
+			ExpectedVersion: &version,
+		})
+		if err != nil {
+			resp.Diagnostics.AddError("Failed to set template status", err.Error())
+			return
+		}
+	}
+
+	resp.Diagnostics.Append(r.setState(ctx, &plan, tpl)...)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+// Read refreshes the template from the control plane
+func (r *templateResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	var state templateModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	tpl, err := r.client.GetTemplate(ctx, state.ID.ValueString())
+	if IsNotFound(err) {
+		resp.State.RemoveResource(ctx)
+		return
+	}
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to read template", err.Error())
+		return
+	}
+
+	resp.Diagnostics.Append(r.setState(ctx, &state, tpl)...)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+}
+
+// Update updates the template, guarded by the version last read
+func (r *templateResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	var plan, state templateModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	tags := stringMap(ctx, plan.Tags, &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if tags == nil {
+		// An empty map clears tags removed from the configuration
+		tags = map[string]string{}
+	}
+
+	name := plan.Name.ValueString()
+	description := plan.Description.ValueString()
+	content := plan.Content.ValueString()
+	contentType := plan.ContentType.ValueString()
+	status := plan.Status.ValueString()
+	version := state.Version.ValueInt64()
+	tpl, err := r.client.UpdateTemplate(ctx, state.ID.ValueString(), &TemplateRequest{
+		Name:            &name,
+		Description:     &description,
+		Content:         &content,
+		ContentType:     &contentType,
+		Status:          &status,
+		Tags:            interfaceMap(tags),
+		ChangeNote:      plan.ChangeNote.ValueString(),
+		ExpectedVersion: &version,
+	})
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to update template", err.Error()+"\n\nIf the template was changed outside Terraform, run terraform refresh and plan again.")
+		return
+	}
+
+	plan.ID = state.ID
+	resp.Diagnostics.Append(r.setState(ctx, &plan, tpl)...)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+// Delete deletes the template
+func (r *templateResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	var state templateModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if err := r.client.DeleteTemplate(ctx, state.ID.ValueString()); err != nil && !IsNotFound(err) {
+		resp.Diagnostics.AddError("Failed to delete template", err.Error())
+	}
+}
+
+// ImportState imports a template by ID
+func (r *templateResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
+	resource.ImportStatePassthroughID(ctx, path.Root("id"), req, resp)
+}
+
+// setState copies a template read from the control plane into the model.
+// change_note is write-only and keeps its configured value.
+func (r *templateResource) setState(ctx context.Context, m *templateModel, tpl *Template) (diags diag.Diagnostics) {
+	m.ID = types.StringValue(tpl.ID)
+	m.Name = types.StringValue(tpl.Name)
+	m.Description = types.StringValue(tpl.Description)
+	m.Content = types.StringValue(tpl.Content)
+	m.ContentType = types.StringValue(tpl.ContentType)
+	m.Status = types.StringValue(tpl.Status)
+	m.Version = types.Int64Value(tpl.Version)
+	m.Tags = stringMapValue(ctx, m.Tags, stringifyMap(tpl.Tags), &diags)
+	return diags
+}
@@ -0,0 +1,224 @@
+package provider
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringdefault"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var (
+	_ resource.Resource                = &tenantResource{}
+	_ resource.ResourceWithConfigure   = &tenantResource{}
+	_ resource.ResourceWithImportState = &tenantResource{}
+)
+
+// tenantResource manages a tenant. It requires an admin token.
+type tenantResource struct {
+	client *Client
+}
+
+// tenantModel is the vmmanager_tenant state
+type tenantModel struct {
+	ID             types.String `tfsdk:"id"`
+	Name           types.String `tfsdk:"name"`
+	Description    types.String `tfsdk:"description"`
+	Settings       types.String `tfsdk:"settings"`
+	QuotaAgents    types.Int64  `tfsdk:"quota_agents"`
+	QuotaWorkflows types.Int64  `tfsdk:"quota_workflows"`
+	Status         types.String `tfsdk:"status"`
+}
+
+// NewTenantResource creates the vmmanager_tenant resource
+func NewTenantResource() resource.Resource {
+	return &tenantResource{}
+}
+
+// Metadata returns the resource type name
+func (r *tenantResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_tenant"
+}
+
+// Schema returns the resource schema
+func (r *tenantResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "A tenant. Managing tenants requires a token with the admin scope.",
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				Computed:      true,
+				PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+			"name": schema.StringAttribute{
+				Required: true,
+			},
+			"description": schema.StringAttribute{
+				Optional: true,
+				Computed: true,
+				Default:  stringdefault.StaticString(""),
+			},
+			"settings": schema.StringAttribute{
+				Description: "Tenant settings as a JSON object, usually from jsonencode(). Settings are stored encrypted.",
+				Optional:    true,
+				Sensitive:   true,
+			},
+			"quota_agents": schema.Int64Attribute{
+				Description:   "Maximum number of agents. Defaults to the control plane default when unset.",
+				Optional:      true,
+				Computed:      true,
+				PlanModifiers: []planmodifier.Int64{int64planmodifier.UseStateForUnknown()},
+			},
+			"quota_workflows": schema.Int64Attribute{
+				Description:   "Maximum number of workflows. Defaults to the control plane default when unset.",
+				Optional:      true,
+				Computed:      true,
+				PlanModifiers: []planmodifier.Int64{int64planmodifier.UseStateForUnknown()},
+			},
+			"status": schema.StringAttribute{
+				Computed:      true,
+				PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+		},
+	}
+}
+
+// Configure stores the provider's API client
+func (r *tenantResource) Configure(ctx context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	r.client = clientFromProviderData(req.ProviderData, &resp.Diagnostics)
+}
+
+// Create creates the tenant
+func (r *tenantResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var plan tenantModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	tenantReq := r.request(&plan, &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	t, err := r.client.CreateTenant(ctx, tenantReq)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to create tenant", err.Error())
+		return
+	}
+
+	resp.Diagnostics.Append(r.setState(&plan, t)...)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+// Read refreshes the tenant from the control plane
+func (r *tenantResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	var state tenantModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	t, err := r.client.GetTenant(ctx, state.ID.ValueString())
+	if IsNotFound(err) {
+		resp.State.RemoveResource(ctx)
+		return
+	}
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to read tenant", err.Error())
+		return
+	}
+
+	resp.Diagnostics.Append(r.setState(&state, t)...)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+}
+
+// Update updates the tenant
+func (r *tenantResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	var plan, state tenantModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	tenantReq := r.request(&plan, &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if tenantReq.Settings == nil && !state.Settings.IsNull() {
+		// An empty object clears settings removed from the configuration
+		tenantReq.Settings = map[string]interface{}{}
+	}
+
+	t, err := r.client.UpdateTenant(ctx, state.ID.ValueString(), tenantReq)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to update tenant", err.Error())
+		return
+	}
+
+	resp.Diagnostics.Append(r.setState(&plan, t)...)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+// Delete deletes the tenant
+func (r *tenantResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	var state tenantModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if err := r.client.DeleteTenant(ctx, state.ID.ValueString()); err != nil && !IsNotFound(err) {
+		resp.Diagnostics.AddError("Failed to delete tenant", err.Error())
+	}
+}
+
+// ImportState imports a tenant by ID
+func (r *tenantResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
+	resource.ImportStatePassthroughID(ctx, path.Root("id"), req, resp)
+}
+
+// request builds a create or update request from the plan. Unknown quotas
+// are left for the control plane to default or keep.
+func (r *tenantResource) request(plan *tenantModel, diags *diag.Diagnostics) *TenantRequest {
+	name := plan.Name.ValueString()
+	description := plan.Description.ValueString()
+	req := &TenantRequest{
+		Name:        &name,
+		Description: &description,
+		Settings:    decodeJSONObject("settings", plan.Settings, diags),
+	}
+	if !plan.QuotaAgents.IsNull() && !plan.QuotaAgents.IsUnknown() {
+		quota := plan.QuotaAgents.ValueInt64()
+		req.QuotaAgents = &quota
+	}
+	if !plan.QuotaWorkflows.IsNull() && !plan.QuotaWorkflows.IsUnknown() {
+		quota := plan.QuotaWorkflows.ValueInt64()
+		req.QuotaWorkflows = &quota
+	}
+	return req
+}
+
+// setState copies a tenant read from the control plane into the model
+func (r *tenantResource) setState(m *tenantModel, t *Tenant) (diags diag.Diagnostics) {
+	m.ID = types.StringValue(t.ID)
+	m.Name = types.StringValue(t.Name)
+	m.Description = types.StringValue(t.Description)
+	m.QuotaAgents = types.Int64Value(t.QuotaAgents)
+	m.QuotaWorkflows = types.Int64Value(t.QuotaWorkflows)
+	m.Status = types.StringValue(t.Status)
+
+	settings, err := jsonObjectValue(m.Settings, t.Settings)
+	if err != nil {
+		diags.AddError("Failed to encode tenant settings", err.Error())
+		return diags
+	}
+	m.Settings = settings
+	return diags
+}
@@ -0,0 +1,128 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// clientFromProviderData extracts the API client passed to resources by
+// the provider's Configure
+func clientFromProviderData(data interface{}, diags *diag.Diagnostics) *Client {
+	if data == nil {
+		// The provider has not been configured yet
+		return nil
+	}
+	client, ok := data.(*Client)
+	if !ok {
+		diags.AddError("Unexpected provider data",
+			fmt.Sprintf("Expected *provider.Client, got %T. Please report this issue to the provider developers.", data))
+		return nil
+	}
+	return client
+}
+
+// decodeJSONObject parses a JSON object attribute
+func decodeJSONObject(attribute string, value types.String, diags *diag.Diagnostics) map[string]interface{} {
+	if value.IsNull() || value.IsUnknown() {
+		return nil
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(value.ValueString()), &obj); err != nil {
+		diags.AddError("Invalid "+attribute, fmt.Sprintf("%s must be a JSON object, e.g. from jsonencode(): %v", attribute, err))
+		return nil
+	}
+	return obj
+}
+
+// jsonObjectValue returns the state value of a JSON object attribute. The
+// prior value is kept when it is semantically equal, so formatting in the
+// configuration does not show up as a diff; an empty object read back for a
+// null attribute stays null.
+func jsonObjectValue(prior types.String, obj map[string]interface{}) (types.String, error) {
+	if len(obj) == 0 && prior.IsNull() {
+		return prior, nil
+	}
+	if !prior.IsNull() && !prior.IsUnknown() {
+		var priorObj map[string]interface{}
+		if err := json.Unmarshal([]byte(prior.ValueString()), &priorObj); err == nil && jsonEqual(priorObj, obj) {
+			return prior, nil
+		}
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return prior, err
+	}
+	return types.StringValue(string(data)), nil
+}
+
+// jsonEqual compares two decoded JSON values, ignoring differences such as
+// integer versus float encoding of the same number
+func jsonEqual(a, b interface{}) bool {
+	normalize := func(v interface{}) interface{} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return v
+		}
+		var out interface{}
+		if err := json.Unmarshal(data, &out); err != nil {
+			return v
+		}
+		return out
+	}
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+// stringMap converts a map(string) attribute. Null and unknown maps convert
+// to nil.
+func stringMap(ctx context.Context, value types.Map, diags *diag.Diagnostics) map[string]string {
+	if value.IsNull() || value.IsUnknown() {
+		return nil
+	}
+	out := make(map[string]string, len(value.Elements()))
+	diags.Append(value.ElementsAs(ctx, &out, false)...)
+	return out
+}
+
+// stringMapValue returns the state value of a map(string) attribute. An
+// empty map read back for a null attribute stays null.
+func stringMapValue(ctx context.Context, prior types.Map, m map[string]string, diags *diag.Diagnostics) types.Map {
+	if len(m) == 0 && (prior.IsNull() || prior.IsUnknown()) {
+		return types.MapNull(types.StringType)
+	}
+	value, d := types.MapValueFrom(ctx, types.StringType, m)
+	diags.Append(d...)
+	return value
+}
+
+// stringifyMap converts JSON map values to strings for map(string) attributes
+func stringifyMap(m map[string]interface{}) map[string]string {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		if s, ok := v.(string); ok {
+			out[k] = s
+		} else {
+			out[k] = fmt.Sprint(v)
+		}
+	}
+	return out
+}
+
+// interfaceMap converts a map(string) attribute value for JSON map fields
+func interfaceMap(m map[string]string) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
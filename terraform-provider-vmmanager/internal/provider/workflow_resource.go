@@ -0,0 +1,245 @@
+package provider
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringdefault"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var (
+	_ resource.Resource                = &workflowResource{}
+	_ resource.ResourceWithConfigure   = &workflowResource{}
+	_ resource.ResourceWithImportState = &workflowResource{}
+)
+
+// workflowResource manages a workflow in the token's tenant
+type workflowResource struct {
+	client *Client
+}
+
+// workflowModel is the vmmanager_workflow state
+type workflowModel struct {
+	ID          types.String `tfsdk:"id"`
+	Name        types.String `tfsdk:"name"`
+	Description types.String `tfsdk:"description"`
+	Definition  types.String `tfsdk:"definition"`
+	Status      types.String `tfsdk:"status"`
+	Tags        types.Map    `tfsdk:"tags"`
+	Version     types.Int64  `tfsdk:"version"`
+}
+
+// NewWorkflowResource creates the vmmanager_workflow resource
+func NewWorkflowResource() resource.Resource {
+	return &workflowResource{}
+}
+
+// Metadata returns the resource type name
+func (r *workflowResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_workflow"
+}
+
+// Schema returns the resource schema
+func (r *workflowResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "A workflow definition. Workflows are created in the tenant of the provider's token.",
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				Computed:      true,
+				PlanModifiers: []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+			"name": schema.StringAttribute{
+				Required: true,
+			},
+			"description": schema.StringAttribute{
+				Optional: true,
+				Computed: true,
+				Default:  stringdefault.StaticString(""),
+			},
+			"definition": schema.StringAttribute{
+				Description: "Workflow definition as a JSON object, usually jsonencode() or yamldecode() of the workflow.",
+				Required:    true,
+			},
+			"status": schema.StringAttribute{
+				Description: "draft, active or deprecated. Only active workflows can be executed.",
+				Optional:    true,
+				Computed:    true,
+				Default:     stringdefault.StaticString("draft"),
+			},
+			"tags": schema.MapAttribute{
+				ElementType: types.StringType,
+				Optional:    true,
+			},
+			"version": schema.Int64Attribute{
+				Description: "Version of the workflow, incremented by the control plane on every change.",
+				Computed:    true,
+			},
+		},
+	}
+}
+
+// Configure stores the provider's API client
+func (r *workflowResource) Configure(ctx context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	r.client = clientFromProviderData(req.ProviderData, &resp.Diagnostics)
+}
+
+// Create creates the workflow, then moves it out of draft if requested
+func (r *workflowResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var plan workflowModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	definition := decodeJSONObject("definition", plan.Definition, &resp.Diagnostics)
+	tags := stringMap(ctx, plan.Tags, &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	name := plan.Name.ValueString()
+	description := plan.Description.ValueString()
+	status := plan.Status.ValueString()
+	wf, err := r.client.CreateWorkflow(ctx, &WorkflowRequest{
+		Name:        &name,
+		Description: &description,
+		Definition:  definition,
+		Tags:        tags,
+	})
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to create workflow", err.Error())
+		return
+	}
+
+	// Record the ID first so a failed status change does not orphan the workflow
+	plan.ID = types.StringValue(wf.ID)
+	plan.Version = types.Int64Value(wf.Version)
+	plan.Status = types.StringValue(wf.Status)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if status != wf.Status {
+		version := wf.Version
+		wf, err = r.client.UpdateWorkflow(ctx, wf.ID, &WorkflowRequest{
+			Status:          &status,
+			ExpectedVersion: &version,
+		})
+		if err != nil {
+			resp.Diagnostics.AddError("Failed to set workflow status", err.Error())
+			return
+		}
+	}
+
+	resp.Diagnostics.Append(r.setState(ctx, &plan, wf, true)...)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+// Read refreshes the workflow from the control plane
+func (r *workflowResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	var state workflowModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	wf, err := r.client.GetWorkflow(ctx, state.ID.ValueString())
+	if IsNotFound(err) {
+		resp.State.RemoveResource(ctx)
+		return
+	}
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to read workflow", err.Error())
+		return
+	}
+
+	resp.Diagnostics.Append(r.setState(ctx, &state, wf, false)...)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+}
+
+// Update updates the workflow, guarded by the version last read
+func (r *workflowResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	var plan, state workflowModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	definition := decodeJSONObject("definition", plan.Definition, &resp.Diagnostics)
+	tags := stringMap(ctx, plan.Tags, &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if tags == nil {
+		// An empty map clears tags removed from the configuration
+		tags = map[string]string{}
+	}
+
+	name := plan.Name.ValueString()
+	description := plan.Description.ValueString()
+	status := plan.Status.ValueString()
+	version := state.Version.ValueInt64()
+	wf, err := r.client.UpdateWorkflow(ctx, state.ID.ValueString(), &WorkflowRequest{
+		Name:            &name,
+		Description:     &description,
+		Definition:      definition,
+		Status:          &status,
+		Tags:            tags,
+		ExpectedVersion: &version,
+	})
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to update workflow", err.Error()+"\n\nIf the workflow was changed outside Terraform, run terraform refresh and plan again.")
+		return
+	}
+
+	plan.ID = state.ID
+	resp.Diagnostics.Append(r.setState(ctx, &plan, wf, true)...)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+// Delete deletes the workflow
+func (r *workflowResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	var state workflowModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if err := r.client.DeleteWorkflow(ctx, state.ID.ValueString()); err != nil && !IsNotFound(err) {
+		resp.Diagnostics.AddError("Failed to delete workflow", err.Error())
+	}
+}
+
+// ImportState imports a workflow by ID
+func (r *workflowResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
+	resource.ImportStatePassthroughID(ctx, path.Root("id"), req, resp)
+}
+
+// setState copies a workflow read from the control plane into the model.
+// After a create or update the planned definition is kept verbatim.
+func (r *workflowResource) setState(ctx context.Context, m *workflowModel, wf *Workflow, planned bool) (diags diag.Diagnostics) {
+	m.ID = types.StringValue(wf.ID)
+	m.Name = types.StringValue(wf.Name)
+	m.Description = types.StringValue(wf.Description)
+	m.Status = types.StringValue(wf.Status)
+	m.Version = types.Int64Value(wf.Version)
+	m.Tags = stringMapValue(ctx, m.Tags, wf.Tags, &diags)
+
+	if !planned {
+		definition, err := jsonObjectValue(m.Definition, wf.Definition)
+		if err != nil {
+			diags.AddError("Failed to encode workflow definition", err.Error())
+			return diags
+		}
+		m.Definition = definition
+	}
+	return diags
+}
@@ -0,0 +1,29 @@
+// Command terraform-provider-vmmanager serves the VM manager Terraform provider.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"github.com/hashicorp/terraform-plugin-framework/providerserver"
+
+	"github.com/yourorg/terraform-provider-vmmanager/internal/provider"
+)
+
+// version is set at build time
+var version = "dev"
+
+func main() {
+	var debug bool
+	flag.BoolVar(&debug, "debug", false, "run the provider with support for debuggers")
+	flag.Parse()
+
+	err := providerserver.Serve(context.Background(), provider.New(version), providerserver.ServeOpts{
+		Address: "registry.terraform.io/yourorg/vmmanager",
+		Debug:   debug,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
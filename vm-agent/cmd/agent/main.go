@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
@@ -40,14 +41,15 @@ var rootCmd = &cobra.Command{
 }
 
 func init() {
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "/etc/vm-agent/config.yaml", "config file path")
-	rootCmd.PersistentFlags().StringVar(&dataDir, "data-dir", "/var/lib/vm-agent", "data directory path")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", config.DefaultConfigPath(), "config file path")
+	rootCmd.PersistentFlags().StringVar(&dataDir, "data-dir", config.DefaultDataDir(), "data directory path")
 
 	rootCmd.AddCommand(runCmd)
 	rootCmd.AddCommand(installCmd)
 	rootCmd.AddCommand(configureCmd)
 	rootCmd.AddCommand(repairCmd)
 	rootCmd.AddCommand(upgradeCmd)
+	rootCmd.AddCommand(upgradeWatchdogCmd)
 	rootCmd.AddCommand(uninstallCmd)
 	rootCmd.AddCommand(statusCmd)
 	rootCmd.AddCommand(versionCmd)
@@ -66,6 +68,9 @@ var runCmd = &cobra.Command{
 		if err != nil {
 			return fmt.Errorf("failed to load configuration: %w", err)
 		}
+		for _, w := range loader.Warnings() {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
 
 		// Validate configuration
 		validator := config.NewValidator()
@@ -79,6 +84,11 @@ var runCmd = &cobra.Command{
 			return fmt.Errorf("failed to create manager: %w", err)
 		}
 
+		// Under the Windows service control manager, report status through it
+		if lifecycle.IsWindowsService() {
+			return lifecycle.RunAsService(mgr.Run, mgr.Shutdown)
+		}
+
 		return mgr.Run()
 	},
 }
@@ -130,7 +140,7 @@ var installCmd = &cobra.Command{
 func initInstallCmd() {
 	installCmd.Flags().String("tenant-id", "", "Tenant ID")
 	installCmd.Flags().String("key", "", "Installation key")
-	installCmd.Flags().String("piko-url", "", "Piko server URL")
+	installCmd.Flags().String("piko-url", "", "Piko server URL, or a comma-separated list in order of preference")
 	installCmd.Flags().String("control-plane-url", "", "Control plane URL")
 	installCmd.Flags().String("agent-id", "", "Agent ID (defaults to hostname)")
 }
@@ -158,9 +168,11 @@ var repairCmd = &cobra.Command{
 	Long:  "Diagnose and repair agent issues",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		diagnoseOnly, _ := cmd.Flags().GetBool("diagnose")
+		installKey, _ := cmd.Flags().GetString("key")
 		logger, _ := initBasicLogger()
 
 		repairer := lifecycle.NewRepairer(dataDir, cfgFile, logger)
+		repairer.SetInstallationKey(installKey)
 
 		var result *lifecycle.RepairResult
 		var err error
@@ -189,6 +201,7 @@ var repairCmd = &cobra.Command{
 
 func initRepairCmd() {
 	repairCmd.Flags().Bool("diagnose", false, "Only diagnose issues, don't repair")
+	repairCmd.Flags().String("key", "", "Installation key to re-register an agent whose token has expired")
 }
 
 var upgradeCmd = &cobra.Command{
@@ -204,15 +217,70 @@ var upgradeCmd = &cobra.Command{
 			return fmt.Errorf("--version, --url, and --checksum are required")
 		}
 
+		bandwidthLimit, _ := cmd.Flags().GetInt64("bandwidth-limit")
+
 		logger, _ := initBasicLogger()
 		upgrader := lifecycle.NewUpgrader(dataDir, logger)
 
+		downloadCfg := lifecycle.DefaultDownloadConfig()
+		downloadCfg.BandwidthLimit = bandwidthLimit
+		upgrader.SetDownloadConfig(downloadCfg)
+
 		if err := upgrader.StartUpgrade(targetVersion, downloadURL, checksum); err != nil {
 			return fmt.Errorf("upgrade failed: %w", err)
 		}
 
 		fmt.Println("Upgrade started")
-		return nil
+
+		// Wait for the upgrade to finish, reporting download progress
+		lastStatus := ""
+		for {
+			status := upgrader.GetUpgradeStatus()
+			if status.Status == "downloading" && status.TotalBytes > 0 {
+				fmt.Printf("\rDownloading: %d/%d bytes (%.1f%%)", status.BytesDownloaded, status.TotalBytes, status.Percent)
+			} else if status.Status != lastStatus {
+				if lastStatus == "downloading" {
+					fmt.Println()
+				}
+				fmt.Printf("Status: %s\n", status.Status)
+			}
+			lastStatus = status.Status
+
+			if !status.InProgress {
+				if status.Error != "" {
+					return fmt.Errorf("upgrade failed: %s", status.Error)
+				}
+				break
+			}
+			time.Sleep(500 * time.Millisecond)
+		}
+
+		// Wait for the watchdog to verify or roll back the new version
+		status := upgrader.GetUpgradeStatus()
+		for time.Now().Before(status.VerifyDeadline.Add(time.Minute)) {
+			outcome, _ := lifecycle.ReadUpgradeOutcome(dataDir)
+			if outcome != nil && outcome.ToVersion == targetVersion && outcome.CompletedAt.After(status.StartedAt) {
+				if outcome.Outcome == lifecycle.UpgradeOutcomeRolledBack {
+					return fmt.Errorf("upgrade rolled back: %s", outcome.Reason)
+				}
+				fmt.Printf("Upgrade to %s verified\n", targetVersion)
+				return nil
+			}
+			time.Sleep(time.Second)
+		}
+
+		return fmt.Errorf("timed out waiting for upgrade verification")
+	},
+}
+
+var upgradeWatchdogCmd = &cobra.Command{
+	Use:    "upgrade-watchdog",
+	Short:  "Verify an upgrade and roll back on failure",
+	Long:   "Wait for the upgraded agent to report healthy and restore the previous binary if it does not",
+	Hidden: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger, _ := initBasicLogger()
+		return lifecycle.RunWatchdog(context.Background(), dataDir, logger)
 	},
 }
 
@@ -220,6 +288,7 @@ func initUpgradeCmd() {
 	upgradeCmd.Flags().String("version", "", "Target version")
 	upgradeCmd.Flags().String("url", "", "Download URL")
 	upgradeCmd.Flags().String("checksum", "", "SHA256 checksum")
+	upgradeCmd.Flags().Int64("bandwidth-limit", 0, "Download bandwidth limit in bytes per second (0 = unlimited)")
 }
 
 var uninstallCmd = &cobra.Command{
@@ -4,6 +4,7 @@ package agent
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
@@ -17,9 +18,13 @@ import (
 	"github.com/yourorg/vm-agent/internal/version"
 	"github.com/yourorg/vm-agent/pkg/config"
 	"github.com/yourorg/vm-agent/pkg/health"
+	"github.com/yourorg/vm-agent/pkg/identity"
+	"github.com/yourorg/vm-agent/pkg/inventory"
 	"github.com/yourorg/vm-agent/pkg/lifecycle"
+	"github.com/yourorg/vm-agent/pkg/outbox"
 	"github.com/yourorg/vm-agent/pkg/piko"
 	"github.com/yourorg/vm-agent/pkg/probe"
+	"github.com/yourorg/vm-agent/pkg/shell"
 	"github.com/yourorg/vm-agent/pkg/webhook"
 )
 
@@ -33,8 +38,14 @@ type Manager struct {
 	probeExecutor *probe.Executor
 	healthMonitor *health.Monitor
 	healthReporter *health.Reporter
+	inventory      *inventory.Reporter
+	identity       *identity.Identity
+	resultReporter *probe.Reporter
+	outbox         *outbox.Outbox
 	upgrader      *lifecycle.Upgrader
 	configurator  *lifecycle.Configurator
+	shells        *shell.Manager
+	logLevel      zap.AtomicLevel
 	ctx           context.Context
 	cancel        context.CancelFunc
 	wg            sync.WaitGroup
@@ -44,26 +55,29 @@ type Manager struct {
 // NewManager creates a new agent manager
 func NewManager(cfg *config.Config) (*Manager, error) {
 	// Initialize logger
-	logger, err := initLogger(cfg.Logging)
+	logger, logLevel, err := initLogger(cfg.Logging)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize logger: %w", err)
 	}
 
 	return &Manager{
-		cfg:    cfg,
-		logger: logger,
+		cfg:      cfg,
+		logger:   logger,
+		logLevel: logLevel,
 	}, nil
 }
 
-// initLogger initializes the logger
-func initLogger(cfg config.LoggingConfig) (*zap.Logger, error) {
+// initLogger initializes the logger. The returned level changes the
+// logger's level at runtime.
+func initLogger(cfg config.LoggingConfig) (*zap.Logger, zap.AtomicLevel, error) {
 	var level zapcore.Level
 	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
 		level = zapcore.InfoLevel
 	}
+	atomicLevel := zap.NewAtomicLevelAt(level)
 
 	zapConfig := zap.Config{
-		Level:       zap.NewAtomicLevelAt(level),
+		Level:       atomicLevel,
 		Development: false,
 		Sampling: &zap.SamplingConfig{
 			Initial:    100,
@@ -79,7 +93,8 @@ func initLogger(cfg config.LoggingConfig) (*zap.Logger, error) {
 		zapConfig.OutputPaths = append(zapConfig.OutputPaths, cfg.File)
 	}
 
-	return zapConfig.Build()
+	logger, err := zapConfig.Build()
+	return logger, atomicLevel, err
 }
 
 // Run starts the agent
@@ -103,6 +118,11 @@ func (m *Manager) Run() error {
 		return fmt.Errorf("failed to initialize components: %w", err)
 	}
 
+	// Remove the binary replaced by a previous upgrade
+	if err := m.upgrader.CleanupOldBinary(); err != nil {
+		m.logger.Warn("failed to clean up old binary", zap.Error(err))
+	}
+
 	// Start components
 	if err := m.startComponents(); err != nil {
 		return fmt.Errorf("failed to start components: %w", err)
@@ -122,11 +142,58 @@ func (m *Manager) initComponents() error {
 	m.probeExecutor, err = probe.NewExecutor(&probe.ExecutorConfig{
 		WorkDir:       m.cfg.Probe.WorkDir,
 		MaxConcurrent: m.cfg.Probe.MaxConcurrent,
+		PriorityAging: m.cfg.Probe.PriorityAging,
+		AgentVersion:  version.Version,
+		AgentToken:    m.cfg.Agent.Token,
+		PluginDir:     m.cfg.Probe.PluginDir,
+		// Template steps fetch control plane templates with the agent token
+		ControlPlaneURL:  m.cfg.Agent.ControlPlaneURL,
+		ControlPlaneAuth: m.cfg.Agent.Token,
+		TemplateCacheDir: m.cfg.Probe.TemplateCacheDir,
+		TemplateCacheMax: m.cfg.Probe.TemplateCacheMaxBytes,
+		JobRetention:     m.cfg.Probe.JobRetention,
+		MaxJobs:          m.cfg.Probe.MaxRetainedJobs,
+		JobDiskQuota:     m.cfg.Probe.JobDiskQuotaBytes,
+		WorkDirQuota:     m.cfg.Probe.WorkDirQuotaBytes,
+		MinFreeDisk:      m.cfg.Probe.MinFreeDiskBytes,
 	}, m.logger)
 	if err != nil {
 		return fmt.Errorf("failed to create probe executor: %w", err)
 	}
 
+	// Load the enrollment key requests to the control plane are signed with
+	m.identity, err = identity.Load(m.cfg.Agent.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to load agent identity: %w", err)
+	}
+
+	// Results and health reports the control plane cannot be reached for
+	// are queued on disk and delivered once it can
+	m.outbox, err = outbox.New(&outbox.Config{
+		Dir:           m.cfg.Outbox.Dir,
+		MaxBytes:      m.cfg.Outbox.MaxBytes,
+		MaxAge:        m.cfg.Outbox.MaxAge,
+		RetryDelay:    m.cfg.Outbox.RetryDelay,
+		MaxRetryDelay: m.cfg.Outbox.MaxRetryDelay,
+	}, m.logger)
+	if err != nil {
+		return fmt.Errorf("failed to create outbox: %w", err)
+	}
+	m.outbox.SetToken(m.cfg.Agent.Token)
+	m.outbox.SetIdentity(m.identity)
+	m.probeExecutor.SetIdentity(m.identity)
+
+	// Initialize workflow result reporter
+	if m.cfg.Probe.ReportURL != "" {
+		m.resultReporter = probe.NewReporter(&probe.ReporterConfig{
+			ReportURL: m.cfg.Probe.ReportURL,
+			Token:     m.cfg.Agent.Token,
+			Identity:  m.identity,
+			Outbox:    m.outbox,
+		}, m.logger)
+		m.probeExecutor.SetReporter(m.resultReporter)
+	}
+
 	// Initialize health monitor
 	m.healthMonitor = health.NewMonitor(
 		m.cfg.Agent.ID,
@@ -138,9 +205,18 @@ func (m *Manager) initComponents() error {
 
 	// Initialize upgrader
 	m.upgrader = lifecycle.NewUpgrader(m.cfg.Agent.DataDir, m.logger)
+	m.upgrader.SetDownloadConfig(&lifecycle.DownloadConfig{
+		ChunkSize:      m.cfg.Upgrade.ChunkSize,
+		MaxRetries:     m.cfg.Upgrade.MaxRetries,
+		RetryDelay:     m.cfg.Upgrade.RetryDelay,
+		MaxRetryDelay:  m.cfg.Upgrade.MaxRetryDelay,
+		BandwidthLimit: m.cfg.Upgrade.BandwidthLimit,
+		StallTimeout:   m.cfg.Upgrade.StallTimeout,
+	})
+	m.upgrader.SetVerifyTimeout(m.cfg.Upgrade.VerifyTimeout)
 
 	// Initialize configurator
-	m.configurator = lifecycle.NewConfigurator("/etc/vm-agent/config.yaml", m.logger)
+	m.configurator = lifecycle.NewConfigurator(config.DefaultConfigPath(), m.logger)
 
 	// Initialize webhook handlers
 	webhookHandlers := webhook.NewHandlers(
@@ -151,9 +227,38 @@ func (m *Manager) initComponents() error {
 		m.upgrader,
 	)
 
+	// Drain mode stops new workflows; report promptly once in-flight jobs finish
+	webhookHandlers.SetDrainer(m.probeExecutor)
+
+	// Callback steps wait for confirmations relayed by the control plane
+	webhookHandlers.SetCallbackConfirmer(m.probeExecutor)
+	m.healthMonitor.SetDrainStateFunc(m.probeExecutor.DrainState)
+
+	// Live shell sessions are refused unless enabled in the configuration
+	m.shells = shell.NewManager(&shell.Config{
+		Enabled:     m.cfg.Shell.Enabled,
+		Command:     m.cfg.Shell.Command,
+		User:        m.cfg.Shell.User,
+		WorkDir:     m.cfg.Shell.WorkDir,
+		MaxSessions: m.cfg.Shell.MaxSessions,
+		MaxDuration: m.cfg.Shell.MaxDuration,
+		IdleTimeout: m.cfg.Shell.IdleTimeout,
+	}, m.logger)
+	webhookHandlers.SetShellHost(m.shells)
+
+	// Expose job queue metrics
+	webhookHandlers.RegisterHook("queue", func(r *http.Request) (any, error) {
+		return m.probeExecutor.QueueStats(), nil
+	})
+	webhookHandlers.RegisterHook("outbox", func(r *http.Request) (any, error) {
+		return m.outbox.Stats(), nil
+	})
+
 	// Initialize webhook authenticator
+	leeway, _ := m.cfg.Agent.TokenTiming()
 	webhookAuth := webhook.NewAuthenticator(&webhook.AuthConfig{
 		JWTSecret: m.cfg.Agent.Token,
+		Leeway:    leeway,
 	})
 
 	// Initialize webhook server
@@ -165,20 +270,53 @@ func (m *Manager) initComponents() error {
 		KeyFile:    m.cfg.Webhook.KeyFile,
 	}, webhookHandlers, webhookAuth, m.logger)
 
+	// Requests through Piko must carry a dispatch token for this agent.
+	// Agents registered before dispatch keys were issued accept them
+	// with the webhook credentials until they re-register.
+	var dispatchVerifier *webhook.DispatchVerifier
+	if m.cfg.Piko.DispatchKey != "" {
+		dispatchVerifier, err = webhook.NewDispatchVerifier(m.cfg.Piko.DispatchKey, m.cfg.Agent.TenantID, m.cfg.Agent.ID, m.cfg.Piko.Endpoint)
+		if err != nil {
+			return fmt.Errorf("failed to load dispatch key: %w", err)
+		}
+		dispatchVerifier.SetLeeway(leeway)
+	} else {
+		m.logger.Warn("no dispatch key configured; re-register the agent to verify Piko requests")
+	}
+
 	// Initialize Piko client
+	pikoServers := make([]piko.Server, 0, len(m.cfg.Piko.Servers))
+	for _, server := range m.cfg.Piko.Servers {
+		pikoServers = append(pikoServers, piko.Server{
+			URL:      server.URL,
+			Region:   server.Region,
+			Priority: server.Priority,
+		})
+	}
 	m.pikoClient = piko.NewClient(&piko.ClientConfig{
-		ServerURL:   m.cfg.Piko.ServerURL,
+		ServerURL: m.cfg.Piko.ServerURL,
+		Servers:   pikoServers,
+		Failover: &piko.FailoverConfig{
+			Strategy:     m.cfg.Piko.Failover.Strategy,
+			MaxFailures:  m.cfg.Piko.Failover.MaxFailures,
+			ProbeTimeout: m.cfg.Piko.Failover.ProbeTimeout,
+		},
 		Endpoint:    m.cfg.Piko.Endpoint,
 		Token:       m.cfg.Agent.Token,
 		TenantID:    m.cfg.Agent.TenantID,
-		HTTPHandler: m.webhookServer.Handler(),
+		HTTPHandler: m.webhookServer.PikoHandler(dispatchVerifier),
 		Reconnect: &piko.ReconnectConfig{
 			InitialDelay: m.cfg.Piko.Reconnect.InitialDelay,
 			MaxDelay:     m.cfg.Piko.Reconnect.MaxDelay,
 			Multiplier:   m.cfg.Piko.Reconnect.Multiplier,
+			Jitter:       m.cfg.Piko.Reconnect.Jitter,
 		},
 	}, m.logger)
 
+	// A new Piko connection suggests the control plane can be reached
+	// again, so queued reports are retried without waiting out the backoff
+	m.pikoClient.OnConnected(m.outbox.Wake)
+
 	// Initialize health reporter
 	m.healthReporter = health.NewReporter(
 		m.healthMonitor,
@@ -187,12 +325,57 @@ func (m *Manager) initComponents() error {
 		m.cfg.Health.ReportInterval,
 		m.logger,
 	)
+	m.healthReporter.SetIdentity(m.identity)
+	m.healthReporter.SetOutbox(m.outbox)
+	m.healthReporter.SetGrainsFunc(m.probeExecutor.Grains)
+
+	// Configuration profiles assigned in the control plane arrive in reply
+	// to health reports
+	m.healthReporter.SetConfigSync(newProfileSync(m))
+
+	// Initialize software inventory reporter
+	if m.cfg.Inventory.Enabled {
+		m.inventory = inventory.NewReporter(
+			inventory.NewCollector(m.cfg.Inventory.Sources, m.cfg.Inventory.Allowlist, m.logger),
+			m.cfg.Inventory.ReportURL,
+			m.cfg.Agent.Token,
+			m.cfg.Inventory.Interval,
+			m.logger,
+		)
+		m.inventory.SetIdentity(m.identity)
+	}
+
+	m.probeExecutor.OnDrained(func() {
+		if m.cfg.Health.ReportURL == "" {
+			return
+		}
+		if err := m.healthReporter.ForceReport(m.ctx); err != nil {
+			m.logger.Warn("failed to report drained state", zap.Error(err))
+		}
+	})
 
 	// Register health checkers
 	m.healthMonitor.RegisterChecker(health.NewSelfChecker())
 	m.healthMonitor.RegisterChecker(health.NewPikoChecker(
-		m.pikoClient.IsConnected,
-		m.pikoClient.LastError,
+		func() health.PikoConnection {
+			status := m.pikoClient.Status()
+			return health.PikoConnection{
+				Connected: m.pikoClient.IsConnected(),
+				State:     status.State.String(),
+				Server: health.PikoServer{
+					URL:     status.Server.URL,
+					Region:  status.Server.Region,
+					Latency: m.pikoClient.ActiveServerLatency(),
+				},
+				ConnectedSince: status.ConnectedSince,
+				Reconnects:     status.Reconnects,
+				FailedAttempts: status.FailedAttempts,
+				LastError:      status.LastError,
+				LastErrorAt:    status.LastErrorAt,
+				Backoff:        status.Backoff,
+				NextAttemptAt:  status.NextAttemptAt,
+			}
+		},
 	))
 	m.healthMonitor.RegisterChecker(health.NewWebhookChecker(
 		m.webhookServer.IsRunning,
@@ -203,10 +386,32 @@ func (m *Manager) initComponents() error {
 		m.cfg.Probe.MaxConcurrent,
 		func() error { return nil },
 	))
+	m.healthMonitor.RegisterChecker(health.NewDiskChecker(
+		func() health.DiskUsage {
+			usage := m.probeExecutor.DiskUsage()
+			return health.DiskUsage{
+				Path:         usage.Path,
+				Free:         usage.Free,
+				Total:        usage.Total,
+				Err:          usage.Err,
+				JobDirs:      usage.JobDirs,
+				JobDirsQuota: usage.JobDirsQuota,
+				JobQuota:     usage.JobQuota,
+				MinFree:      usage.MinFree,
+				Jobs:         usage.Jobs,
+			}
+		},
+		m.cfg.Health.DiskWarnPercent,
+		m.cfg.Health.DiskCriticalPercent,
+	))
 	m.healthMonitor.RegisterChecker(health.NewSystemChecker(
 		100*1024*1024, // 100MB minimum disk space
 		m.cfg.Agent.DataDir,
 	))
+	m.healthMonitor.RegisterChecker(health.NewUpgradeChecker(
+		m.lastUpgradeOutcome,
+		24*time.Hour,
+	))
 
 	return nil
 }
@@ -216,9 +421,22 @@ func (m *Manager) startComponents() error {
 	// Start health monitor
 	m.healthMonitor.Start(m.ctx)
 
+	// Start delivering queued reports
+	m.outbox.Start(m.ctx)
+
 	// Start health reporter
 	m.healthReporter.Start(m.ctx)
 
+	// Start workflow result reporter
+	if m.resultReporter != nil {
+		m.resultReporter.Start(m.ctx)
+	}
+
+	// Start software inventory reporter
+	if m.inventory != nil {
+		m.inventory.Start(m.ctx)
+	}
+
 	// Start Piko client
 	if err := m.pikoClient.Start(m.ctx); err != nil {
 		return fmt.Errorf("failed to start Piko client: %w", err)
@@ -231,9 +449,79 @@ func (m *Manager) startComponents() error {
 
 	m.logger.Info("all components started")
 
+	// Confirm a pending upgrade once this process is healthy
+	m.wg.Add(1)
+	go m.confirmUpgrade()
+
+	// Refresh the agent token ahead of its expiry
+	if m.cfg.Agent.ControlPlaneURL != "" {
+		m.wg.Add(1)
+		go m.refreshToken()
+	}
+
 	return nil
 }
 
+// confirmUpgrade writes the post-upgrade health marker as soon as the agent
+// is ready, so the upgrade watchdog does not roll this version back
+func (m *Manager) confirmUpgrade() {
+	defer m.wg.Done()
+
+	pending, err := lifecycle.ReadPendingUpgrade(m.cfg.Agent.DataDir)
+	if err != nil {
+		m.logger.Warn("failed to read pending upgrade", zap.Error(err))
+		return
+	}
+	if pending == nil || pending.ToVersion != version.Version {
+		return
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !m.healthMonitor.IsReady() {
+			continue
+		}
+
+		if _, err := lifecycle.WriteHealthMarker(m.cfg.Agent.DataDir, version.Version); err != nil {
+			m.logger.Error("failed to confirm upgrade", zap.Error(err))
+			return
+		}
+
+		m.logger.Info("upgrade health confirmed",
+			zap.String("from_version", pending.FromVersion),
+			zap.String("to_version", pending.ToVersion))
+		return
+	}
+}
+
+// lastUpgradeOutcome returns the last recorded upgrade verification result
+func (m *Manager) lastUpgradeOutcome() (string, time.Time, map[string]any) {
+	outcome, err := lifecycle.ReadUpgradeOutcome(m.cfg.Agent.DataDir)
+	if err != nil || outcome == nil {
+		return "", time.Time{}, nil
+	}
+
+	details := map[string]any{
+		"from_version": outcome.FromVersion,
+		"to_version":   outcome.ToVersion,
+		"outcome":      outcome.Outcome,
+		"completed_at": outcome.CompletedAt,
+	}
+	if outcome.Reason != "" {
+		details["reason"] = outcome.Reason
+	}
+
+	return outcome.Outcome, outcome.CompletedAt, details
+}
+
 // waitForShutdown waits for shutdown signal and performs graceful shutdown
 func (m *Manager) waitForShutdown() {
 	sigCh := make(chan os.Signal, 1)
@@ -269,18 +557,40 @@ func (m *Manager) Shutdown() {
 		m.webhookServer.Stop(ctx)
 	}
 
+	if m.shells != nil {
+		m.shells.Stop()
+	}
+
 	if m.pikoClient != nil {
 		m.pikoClient.Stop()
 	}
 
+	if m.inventory != nil {
+		m.inventory.Stop()
+	}
+
 	if m.healthReporter != nil {
 		m.healthReporter.Stop()
 	}
 
+	if m.resultReporter != nil {
+		m.resultReporter.Stop()
+	}
+
+	// Reports not delivered by now are kept for the next start
+	if m.outbox != nil {
+		m.outbox.Stop()
+	}
+
 	if m.healthMonitor != nil {
 		m.healthMonitor.Stop()
 	}
 
+	// Jobs still running are failed when the agent next starts
+	if m.probeExecutor != nil {
+		m.probeExecutor.Close()
+	}
+
 	// Sync logger
 	m.logger.Sync()
 
@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Outcomes of applying a configuration profile, as reported to the control
+// plane
+const (
+	profileStatusApplied        = "applied"
+	profileStatusPendingRestart = "pending_restart"
+	profileStatusFailed         = "failed"
+)
+
+// assignedProfile is a configuration profile as the control plane sends it
+type assignedProfile struct {
+	ProfileID string                 `json:"profile_id"`
+	Name      string                 `json:"name"`
+	Version   int                    `json:"version"`
+	Settings  map[string]interface{} `json:"settings"`
+}
+
+// profileState is the configuration state sent with health reports
+type profileState struct {
+	ProfileID      string   `json:"profile_id"`
+	Version        int      `json:"version"`
+	Status         string   `json:"status"`
+	Error          string   `json:"error,omitempty"`
+	PendingRestart []string `json:"pending_restart,omitempty"`
+	// Effective maps the profile-managed keys to the running values
+	Effective map[string]interface{} `json:"effective,omitempty"`
+}
+
+// profileSync applies the configuration profiles assigned to the agent: the
+// configurator saves them to the configuration file, and the settings that
+// can change at runtime are applied to the running agent. The rest take
+// effect on restart.
+type profileSync struct {
+	manager *Manager
+
+	mu    sync.Mutex
+	state *profileState
+}
+
+// newProfileSync creates the profile sync of an agent
+func newProfileSync(manager *Manager) *profileSync {
+	return &profileSync{manager: manager}
+}
+
+// ConfigState returns the state of the last profile handled, with the
+// running values of the profile-managed settings
+func (s *profileSync) ConfigState() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == nil {
+		return nil
+	}
+	state := *s.state
+	state.Effective = s.manager.GetConfig().ProfileValues()
+	return &state
+}
+
+// ApplyProfile applies a profile received from the control plane unless
+// its version was already handled. A profile that failed is retried when a
+// new version arrives or the agent restarts.
+func (s *profileSync) ApplyProfile(raw json.RawMessage) bool {
+	if len(raw) == 0 || string(raw) == "null" {
+		return false
+	}
+	var profile assignedProfile
+	if err := json.Unmarshal(raw, &profile); err != nil || profile.ProfileID == "" {
+		s.manager.logger.Warn("ignoring invalid configuration profile", zap.Error(err))
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != nil && s.state.ProfileID == profile.ProfileID && s.state.Version == profile.Version {
+		return false
+	}
+
+	state := &profileState{
+		ProfileID: profile.ProfileID,
+		Version:   profile.Version,
+		Status:    profileStatusApplied,
+	}
+	if _, err := s.manager.configurator.ApplyProfile(profile.Settings); err != nil {
+		state.Status = profileStatusFailed
+		state.Error = err.Error()
+		s.manager.logger.Warn("failed to apply configuration profile",
+			zap.String("profile", profile.Name),
+			zap.Int("version", profile.Version),
+			zap.Error(err))
+	} else {
+		state.PendingRestart = s.manager.applyRuntimeSettings(profile.Settings)
+		if len(state.PendingRestart) > 0 {
+			state.Status = profileStatusPendingRestart
+		}
+		s.manager.logger.Info("configuration profile applied",
+			zap.String("profile", profile.Name),
+			zap.Int("version", profile.Version),
+			zap.Strings("pending_restart", state.PendingRestart))
+	}
+	s.state = state
+	return true
+}
+
+// applyRuntimeSettings applies the profile settings that differ from the
+// running configuration and can change without a restart, and returns the
+// keys of the others. The settings were validated by the configurator.
+func (m *Manager) applyRuntimeSettings(settings map[string]interface{}) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	desired := *m.cfg
+	for key, value := range settings {
+		desired.SetProfileValue(key, value)
+	}
+	running := m.cfg.ProfileValues()
+
+	var pending []string
+	for key, value := range desired.ProfileValues() {
+		if fmt.Sprint(value) == fmt.Sprint(running[key]) {
+			continue
+		}
+		switch key {
+		case "logging.level":
+			var level zapcore.Level
+			if err := level.UnmarshalText([]byte(desired.Logging.Level)); err != nil {
+				pending = append(pending, key)
+				continue
+			}
+			m.logLevel.SetLevel(level)
+			m.cfg.Logging.Level = desired.Logging.Level
+		case "health.report_interval":
+			m.healthReporter.SetReportInterval(desired.Health.ReportInterval)
+			m.cfg.Health.ReportInterval = desired.Health.ReportInterval
+		default:
+			pending = append(pending, key)
+		}
+	}
+	sort.Strings(pending)
+	return pending
+}
@@ -0,0 +1,90 @@
+package agent
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/yourorg/vm-agent/pkg/lifecycle"
+)
+
+// tokenCheckInterval is how often the agent checks its token's expiry
+const tokenCheckInterval = time.Hour
+
+// refreshToken exchanges the agent token for a new one once it expires
+// within agent.token_refresh_before, until the agent shuts down
+func (m *Manager) refreshToken() {
+	defer m.wg.Done()
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	ticker := time.NewTicker(tokenCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		m.checkToken(client)
+
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkToken refreshes the token if it is due. The new token is saved to
+// the configuration file and handed to the reporters; the Piko connection
+// and webhook server pick it up when the agent restarts.
+func (m *Manager) checkToken(client *http.Client) {
+	m.mu.RLock()
+	token := m.cfg.Agent.Token
+	controlPlaneURL := m.cfg.Agent.ControlPlaneURL
+	leeway, refreshBefore := m.cfg.Agent.TokenTiming()
+	m.mu.RUnlock()
+
+	expiry, err := lifecycle.TokenExpiry(token)
+	if err != nil {
+		m.logger.Warn("failed to read agent token expiry", zap.Error(err))
+		return
+	}
+	if expiry.IsZero() || time.Until(expiry) > refreshBefore {
+		return
+	}
+	if time.Now().After(expiry.Add(leeway)) {
+		m.logger.Error("agent token expired; re-register with an installation key: vm-agent repair --key <installation key>",
+			zap.Time("expired_at", expiry))
+		return
+	}
+
+	refreshed, err := lifecycle.RefreshToken(m.ctx, client, controlPlaneURL, token, m.identity)
+	if err != nil {
+		if errors.Is(err, lifecycle.ErrTokenRejected) {
+			m.logger.Error("failed to refresh agent token", zap.Error(err))
+		} else {
+			m.logger.Warn("failed to refresh agent token, will retry", zap.Error(err))
+		}
+		return
+	}
+	if err := m.configurator.SaveToken(refreshed.Token); err != nil {
+		m.logger.Error("failed to save refreshed agent token", zap.Error(err))
+		return
+	}
+
+	m.mu.Lock()
+	m.cfg.Agent.Token = refreshed.Token
+	m.mu.Unlock()
+
+	m.healthReporter.SetToken(refreshed.Token)
+	m.outbox.SetToken(refreshed.Token)
+	m.probeExecutor.SetControlPlaneToken(refreshed.Token)
+	if m.resultReporter != nil {
+		m.resultReporter.SetToken(refreshed.Token)
+	}
+	if m.inventory != nil {
+		m.inventory.SetToken(refreshed.Token)
+	}
+
+	m.logger.Info("agent token refreshed",
+		zap.Time("expires_at", refreshed.ExpiresAt))
+}
@@ -12,71 +12,190 @@ import (
 
 // Config represents the complete agent configuration
 type Config struct {
-	Agent    AgentConfig    `mapstructure:"agent"`
-	Piko     PikoConfig     `mapstructure:"piko"`
-	Webhook  WebhookConfig  `mapstructure:"webhook"`
-	Probe    ProbeConfig    `mapstructure:"probe"`
-	Health   HealthConfig   `mapstructure:"health"`
-	Logging  LoggingConfig  `mapstructure:"logging"`
+	Agent     AgentConfig     `mapstructure:"agent" yaml:"agent"`
+	Piko      PikoConfig      `mapstructure:"piko" yaml:"piko"`
+	Webhook   WebhookConfig   `mapstructure:"webhook" yaml:"webhook"`
+	Probe     ProbeConfig     `mapstructure:"probe" yaml:"probe"`
+	Health    HealthConfig    `mapstructure:"health" yaml:"health"`
+	Inventory InventoryConfig `mapstructure:"inventory" yaml:"inventory"`
+	Upgrade   UpgradeConfig   `mapstructure:"upgrade" yaml:"upgrade"`
+	Logging   LoggingConfig   `mapstructure:"logging" yaml:"logging"`
+	Shell     ShellConfig     `mapstructure:"shell" yaml:"shell"`
+	Outbox    OutboxConfig    `mapstructure:"outbox" yaml:"outbox"`
 }
 
 // AgentConfig contains agent-specific configuration
 type AgentConfig struct {
-	ID              string `mapstructure:"id"`
-	TenantID        string `mapstructure:"tenant_id"`
-	ControlPlaneURL string `mapstructure:"control_plane_url"`
-	Token           string `mapstructure:"token"`
-	DataDir         string `mapstructure:"data_dir"`
+	ID              string `mapstructure:"id" yaml:"id"`
+	TenantID        string `mapstructure:"tenant_id" yaml:"tenant_id"`
+	ControlPlaneURL string `mapstructure:"control_plane_url" yaml:"control_plane_url"`
+	Token           string `mapstructure:"token" yaml:"token"`
+	DataDir         string `mapstructure:"data_dir" yaml:"data_dir"`
+	// TokenLeeway is the clock drift tolerated when checking the expiry of
+	// tokens from the control plane; the agent refreshes its own token once
+	// it expires within TokenRefreshBefore
+	TokenLeeway        time.Duration `mapstructure:"token_leeway" yaml:"token_leeway"`
+	TokenRefreshBefore time.Duration `mapstructure:"token_refresh_before" yaml:"token_refresh_before"`
+}
+
+// Token timing used when the configuration leaves it unset
+const (
+	DefaultTokenLeeway        = 5 * time.Minute
+	DefaultTokenRefreshBefore = 30 * 24 * time.Hour
+)
+
+// TokenTiming returns the token leeway and refresh lead time, or their
+// defaults when unset
+func (a *AgentConfig) TokenTiming() (leeway, refreshBefore time.Duration) {
+	leeway, refreshBefore = a.TokenLeeway, a.TokenRefreshBefore
+	if leeway <= 0 {
+		leeway = DefaultTokenLeeway
+	}
+	if refreshBefore <= 0 {
+		refreshBefore = DefaultTokenRefreshBefore
+	}
+	return leeway, refreshBefore
 }
 
 // PikoConfig contains Piko client configuration
 type PikoConfig struct {
-	ServerURL string          `mapstructure:"server_url"`
-	Endpoint  string          `mapstructure:"endpoint"`
-	Reconnect ReconnectConfig `mapstructure:"reconnect"`
+	ServerURL string             `mapstructure:"server_url" yaml:"server_url"`
+	Servers   []PikoServerConfig `mapstructure:"servers" yaml:"servers"` // multi-region; takes precedence over server_url
+	Failover  FailoverConfig     `mapstructure:"failover" yaml:"failover"`
+	Endpoint  string             `mapstructure:"endpoint" yaml:"endpoint"`
+	Reconnect ReconnectConfig    `mapstructure:"reconnect" yaml:"reconnect"`
+	// DispatchKey verifies the control plane's dispatch tokens on requests
+	// arriving through Piko; issued at registration
+	DispatchKey string `mapstructure:"dispatch_key" yaml:"dispatch_key"`
+}
+
+// PikoServerConfig is one of several Piko servers. Lower priority values
+// are preferred.
+type PikoServerConfig struct {
+	URL      string `mapstructure:"url" yaml:"url"`
+	Region   string `mapstructure:"region" yaml:"region"`
+	Priority int    `mapstructure:"priority" yaml:"priority"`
+}
+
+// FailoverConfig contains Piko server selection and failover settings
+type FailoverConfig struct {
+	Strategy     string        `mapstructure:"strategy" yaml:"strategy"`           // priority or latency
+	MaxFailures  int           `mapstructure:"max_failures" yaml:"max_failures"`   // consecutive connect failures before failing over
+	ProbeTimeout time.Duration `mapstructure:"probe_timeout" yaml:"probe_timeout"` // latency probe timeout per server
 }
 
 // ReconnectConfig contains reconnection settings
 type ReconnectConfig struct {
-	InitialDelay time.Duration `mapstructure:"initial_delay"`
-	MaxDelay     time.Duration `mapstructure:"max_delay"`
-	Multiplier   float64       `mapstructure:"multiplier"`
+	InitialDelay time.Duration `mapstructure:"initial_delay" yaml:"initial_delay"`
+	MaxDelay     time.Duration `mapstructure:"max_delay" yaml:"max_delay"`
+	Multiplier   float64       `mapstructure:"multiplier" yaml:"multiplier"`
+	Jitter       float64       `mapstructure:"jitter" yaml:"jitter"`
 }
 
 // WebhookConfig contains webhook server configuration
 type WebhookConfig struct {
-	ListenAddr string `mapstructure:"listen_addr"`
-	Port       int    `mapstructure:"port"`
-	TLSEnabled bool   `mapstructure:"tls_enabled"`
-	CertFile   string `mapstructure:"cert_file"`
-	KeyFile    string `mapstructure:"key_file"`
+	ListenAddr string `mapstructure:"listen_addr" yaml:"listen_addr"`
+	Port       int    `mapstructure:"port" yaml:"port"`
+	TLSEnabled bool   `mapstructure:"tls_enabled" yaml:"tls_enabled"`
+	CertFile   string `mapstructure:"cert_file" yaml:"cert_file"`
+	KeyFile    string `mapstructure:"key_file" yaml:"key_file"`
 }
 
 // ProbeConfig contains probe executor configuration
 type ProbeConfig struct {
-	WorkDir        string        `mapstructure:"work_dir"`
-	DefaultTimeout time.Duration `mapstructure:"default_timeout"`
-	MaxConcurrent  int           `mapstructure:"max_concurrent"`
+	WorkDir        string        `mapstructure:"work_dir" yaml:"work_dir"`
+	DefaultTimeout time.Duration `mapstructure:"default_timeout" yaml:"default_timeout"`
+	MaxConcurrent  int           `mapstructure:"max_concurrent" yaml:"max_concurrent"`
+	PriorityAging  time.Duration `mapstructure:"priority_aging" yaml:"priority_aging"`
+	ReportURL      string        `mapstructure:"report_url" yaml:"report_url"`
+	PluginDir      string        `mapstructure:"plugin_dir" yaml:"plugin_dir"`
+	// TemplateCacheDir keeps control plane template content by content
+	// hash, up to TemplateCacheMaxBytes; least recently used is evicted
+	TemplateCacheDir      string `mapstructure:"template_cache_dir" yaml:"template_cache_dir"`
+	TemplateCacheMaxBytes int64  `mapstructure:"template_cache_max_bytes" yaml:"template_cache_max_bytes"`
+	// Finished jobs are kept in the job store for JobRetention, and at
+	// most MaxRetainedJobs of them
+	JobRetention    time.Duration `mapstructure:"job_retention" yaml:"job_retention"`
+	MaxRetainedJobs int           `mapstructure:"max_retained_jobs" yaml:"max_retained_jobs"`
+	// Jobs run in their own directories under WorkDir. A job whose
+	// directory grows past JobDiskQuotaBytes fails; new jobs are refused
+	// while the job directories use WorkDirQuotaBytes or less than
+	// MinFreeDiskBytes would be left free. 0 disables a limit.
+	JobDiskQuotaBytes int64 `mapstructure:"job_disk_quota_bytes" yaml:"job_disk_quota_bytes"`
+	WorkDirQuotaBytes int64 `mapstructure:"work_dir_quota_bytes" yaml:"work_dir_quota_bytes"`
+	MinFreeDiskBytes  int64 `mapstructure:"min_free_disk_bytes" yaml:"min_free_disk_bytes"`
 }
 
 // HealthConfig contains health monitoring configuration
 type HealthConfig struct {
-	CheckInterval  time.Duration `mapstructure:"check_interval"`
-	ReportInterval time.Duration `mapstructure:"report_interval"`
-	ReportURL      string        `mapstructure:"report_url"`
+	CheckInterval  time.Duration `mapstructure:"check_interval" yaml:"check_interval"`
+	ReportInterval time.Duration `mapstructure:"report_interval" yaml:"report_interval"`
+	ReportURL      string        `mapstructure:"report_url" yaml:"report_url"`
+	// The disk component is degraded once DiskWarnPercent of the work
+	// directory's disk is used and unhealthy at DiskCriticalPercent
+	DiskWarnPercent     float64 `mapstructure:"disk_warn_percent" yaml:"disk_warn_percent"`
+	DiskCriticalPercent float64 `mapstructure:"disk_critical_percent" yaml:"disk_critical_percent"`
+}
+
+// InventoryConfig contains software inventory configuration. Packages are
+// listed from Sources (dpkg, rpm, choco, winget), or every package manager
+// found when empty, and only those whose name matches an Allowlist glob are
+// reported, unless it is empty.
+type InventoryConfig struct {
+	Enabled   bool          `mapstructure:"enabled" yaml:"enabled"`
+	Interval  time.Duration `mapstructure:"interval" yaml:"interval"`
+	ReportURL string        `mapstructure:"report_url" yaml:"report_url"`
+	Sources   []string      `mapstructure:"sources" yaml:"sources"`
+	Allowlist []string      `mapstructure:"allowlist" yaml:"allowlist"`
+}
+
+// UpgradeConfig contains self-upgrade download configuration
+type UpgradeConfig struct {
+	ChunkSize      int64         `mapstructure:"chunk_size" yaml:"chunk_size"`
+	MaxRetries     int           `mapstructure:"max_retries" yaml:"max_retries"`
+	RetryDelay     time.Duration `mapstructure:"retry_delay" yaml:"retry_delay"`
+	MaxRetryDelay  time.Duration `mapstructure:"max_retry_delay" yaml:"max_retry_delay"`
+	BandwidthLimit int64         `mapstructure:"bandwidth_limit" yaml:"bandwidth_limit"` // bytes per second, 0 = unlimited
+	StallTimeout   time.Duration `mapstructure:"stall_timeout" yaml:"stall_timeout"`
+	VerifyTimeout  time.Duration `mapstructure:"verify_timeout" yaml:"verify_timeout"` // time the new version has to report healthy
 }
 
 // LoggingConfig contains logging configuration
 type LoggingConfig struct {
-	Level  string `mapstructure:"level"`
-	Format string `mapstructure:"format"`
-	File   string `mapstructure:"file"`
+	Level  string `mapstructure:"level" yaml:"level"`
+	Format string `mapstructure:"format" yaml:"format"`
+	File   string `mapstructure:"file" yaml:"file"`
+}
+
+// ShellConfig contains live shell session settings. Sessions are opened by
+// the control plane and refused unless enabled here.
+type ShellConfig struct {
+	Enabled     bool          `mapstructure:"enabled" yaml:"enabled"`
+	Command     string        `mapstructure:"command" yaml:"command"`
+	User        string        `mapstructure:"user" yaml:"user"` // run sessions as this user; empty keeps the agent's
+	WorkDir     string        `mapstructure:"work_dir" yaml:"work_dir"`
+	MaxSessions int           `mapstructure:"max_sessions" yaml:"max_sessions"`
+	MaxDuration time.Duration `mapstructure:"max_duration" yaml:"max_duration"`
+	IdleTimeout time.Duration `mapstructure:"idle_timeout" yaml:"idle_timeout"` // time without input before a session is closed
+}
+
+// OutboxConfig contains the settings of the on-disk queue of results and
+// health reports the control plane could not be reached for. Queued
+// reports are retried after RetryDelay, doubling up to MaxRetryDelay, and
+// the oldest are dropped beyond MaxBytes or once older than MaxAge.
+type OutboxConfig struct {
+	Dir           string        `mapstructure:"dir" yaml:"dir"`
+	MaxBytes      int64         `mapstructure:"max_bytes" yaml:"max_bytes"`
+	MaxAge        time.Duration `mapstructure:"max_age" yaml:"max_age"`
+	RetryDelay    time.Duration `mapstructure:"retry_delay" yaml:"retry_delay"`
+	MaxRetryDelay time.Duration `mapstructure:"max_retry_delay" yaml:"max_retry_delay"`
 }
 
 // Loader handles configuration loading from multiple sources
 type Loader struct {
 	v          *viper.Viper
 	configPath string
+	warnings   []Warning
 }
 
 // NewLoader creates a new configuration loader
@@ -91,7 +210,9 @@ func (l *Loader) SetConfigPath(path string) {
 	l.configPath = path
 }
 
-// Load loads the configuration from all sources
+// Load loads the configuration from all sources. Settings of the wrong type
+// fail the load with ValidationErrors; unknown and deprecated keys are only
+// reported by Warnings.
 func (l *Loader) Load() (*Config, error) {
 	l.setDefaults()
 
@@ -102,7 +223,7 @@ func (l *Loader) Load() (*Config, error) {
 		// Search for config in standard locations
 		l.v.SetConfigName("config")
 		l.v.SetConfigType("yaml")
-		l.v.AddConfigPath("/etc/vm-agent")
+		l.v.AddConfigPath(filepath.Dir(DefaultConfigPath()))
 		l.v.AddConfigPath("$HOME/.vm-agent")
 		l.v.AddConfigPath(".")
 	}
@@ -114,6 +235,12 @@ func (l *Loader) Load() (*Config, error) {
 		}
 	}
 
+	warnings, err := l.checkSchema()
+	l.warnings = warnings
+	if err != nil {
+		return nil, err
+	}
+
 	// Override with environment variables
 	l.v.SetEnvPrefix("VM_AGENT")
 	l.v.AutomaticEnv()
@@ -126,16 +253,27 @@ func (l *Loader) Load() (*Config, error) {
 	return &cfg, nil
 }
 
+// Warnings returns the unknown and deprecated keys found by the last Load
+func (l *Loader) Warnings() []Warning {
+	return l.warnings
+}
+
 // setDefaults sets default configuration values
 func (l *Loader) setDefaults() {
 	// Agent defaults
 	l.v.SetDefault("agent.id", getHostname())
-	l.v.SetDefault("agent.data_dir", "/var/lib/vm-agent")
+	l.v.SetDefault("agent.data_dir", DefaultDataDir())
+	l.v.SetDefault("agent.token_leeway", DefaultTokenLeeway)
+	l.v.SetDefault("agent.token_refresh_before", DefaultTokenRefreshBefore)
 
 	// Piko defaults
 	l.v.SetDefault("piko.reconnect.initial_delay", "1s")
 	l.v.SetDefault("piko.reconnect.max_delay", "60s")
 	l.v.SetDefault("piko.reconnect.multiplier", 2.0)
+	l.v.SetDefault("piko.reconnect.jitter", 0.5)
+	l.v.SetDefault("piko.failover.strategy", "priority")
+	l.v.SetDefault("piko.failover.max_failures", 3)
+	l.v.SetDefault("piko.failover.probe_timeout", "5s")
 
 	// Webhook defaults
 	l.v.SetDefault("webhook.listen_addr", "0.0.0.0")
@@ -143,17 +281,55 @@ func (l *Loader) setDefaults() {
 	l.v.SetDefault("webhook.tls_enabled", false)
 
 	// Probe defaults
-	l.v.SetDefault("probe.work_dir", "/var/lib/vm-agent/work")
+	l.v.SetDefault("probe.work_dir", filepath.Join(DefaultDataDir(), "work"))
 	l.v.SetDefault("probe.default_timeout", "300s")
 	l.v.SetDefault("probe.max_concurrent", 5)
+	l.v.SetDefault("probe.priority_aging", "120s")
+	l.v.SetDefault("probe.plugin_dir", filepath.Join(DefaultDataDir(), "plugins"))
+	l.v.SetDefault("probe.template_cache_dir", filepath.Join(DefaultDataDir(), "template-cache"))
+	l.v.SetDefault("probe.template_cache_max_bytes", 256*1024*1024)
+	l.v.SetDefault("probe.job_retention", "168h")
+	l.v.SetDefault("probe.max_retained_jobs", 1000)
+	l.v.SetDefault("probe.job_disk_quota_bytes", 2*1024*1024*1024)
+	l.v.SetDefault("probe.work_dir_quota_bytes", 10*1024*1024*1024)
+	l.v.SetDefault("probe.min_free_disk_bytes", 256*1024*1024)
 
 	// Health defaults
 	l.v.SetDefault("health.check_interval", "30s")
 	l.v.SetDefault("health.report_interval", "300s")
+	l.v.SetDefault("health.disk_warn_percent", 85.0)
+	l.v.SetDefault("health.disk_critical_percent", 95.0)
+
+	// Inventory defaults
+	l.v.SetDefault("inventory.enabled", true)
+	l.v.SetDefault("inventory.interval", "6h")
+
+	// Upgrade defaults
+	l.v.SetDefault("upgrade.chunk_size", 8*1024*1024)
+	l.v.SetDefault("upgrade.max_retries", 10)
+	l.v.SetDefault("upgrade.retry_delay", "2s")
+	l.v.SetDefault("upgrade.max_retry_delay", "120s")
+	l.v.SetDefault("upgrade.bandwidth_limit", 0)
+	l.v.SetDefault("upgrade.stall_timeout", "60s")
+	l.v.SetDefault("upgrade.verify_timeout", "120s")
 
 	// Logging defaults
 	l.v.SetDefault("logging.level", "info")
 	l.v.SetDefault("logging.format", "json")
+
+	// Shell defaults
+	l.v.SetDefault("shell.enabled", false)
+	l.v.SetDefault("shell.command", "/bin/sh")
+	l.v.SetDefault("shell.max_sessions", 2)
+	l.v.SetDefault("shell.max_duration", "1h")
+	l.v.SetDefault("shell.idle_timeout", "15m")
+
+	// Outbox defaults
+	l.v.SetDefault("outbox.dir", filepath.Join(DefaultDataDir(), "outbox"))
+	l.v.SetDefault("outbox.max_bytes", 64*1024*1024)
+	l.v.SetDefault("outbox.max_age", "72h")
+	l.v.SetDefault("outbox.retry_delay", "5s")
+	l.v.SetDefault("outbox.max_retry_delay", "5m")
 }
 
 // getHostname returns the hostname or a default value
@@ -182,7 +358,11 @@ func (l *Loader) SaveConfig(cfg *Config, path string) error {
 	l.v.Set("webhook", cfg.Webhook)
 	l.v.Set("probe", cfg.Probe)
 	l.v.Set("health", cfg.Health)
+	l.v.Set("inventory", cfg.Inventory)
+	l.v.Set("upgrade", cfg.Upgrade)
 	l.v.Set("logging", cfg.Logging)
+	l.v.Set("shell", cfg.Shell)
+	l.v.Set("outbox", cfg.Outbox)
 
 	return l.v.WriteConfigAs(path)
 }
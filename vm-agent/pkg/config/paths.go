@@ -0,0 +1,56 @@
+// Package config handles configuration loading and management for the vm-agent.
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// DefaultConfigPath returns the platform's default configuration file path
+func DefaultConfigPath() string {
+	switch runtime.GOOS {
+	case "windows":
+		return filepath.Join(programDataDir(), "vm-agent", "config.yaml")
+	default:
+		return "/etc/vm-agent/config.yaml"
+	}
+}
+
+// DefaultDataDir returns the platform's default data directory
+func DefaultDataDir() string {
+	switch runtime.GOOS {
+	case "windows":
+		return filepath.Join(programDataDir(), "vm-agent")
+	case "darwin":
+		return "/Library/Application Support/vm-agent"
+	default:
+		return "/var/lib/vm-agent"
+	}
+}
+
+// DefaultBinaryPath returns the platform's default installed binary path
+func DefaultBinaryPath() string {
+	switch runtime.GOOS {
+	case "windows":
+		return filepath.Join(programFilesDir(), "vm-agent", "vm-agent.exe")
+	default:
+		return "/usr/local/bin/vm-agent"
+	}
+}
+
+// programDataDir returns %ProgramData%, falling back to the standard location
+func programDataDir() string {
+	if dir := os.Getenv("ProgramData"); dir != "" {
+		return dir
+	}
+	return `C:\ProgramData`
+}
+
+// programFilesDir returns %ProgramFiles%, falling back to the standard location
+func programFilesDir() string {
+	if dir := os.Getenv("ProgramFiles"); dir != "" {
+		return dir
+	}
+	return `C:\Program Files`
+}
@@ -7,6 +7,8 @@ import (
 	"io"
 	"net/http"
 	"time"
+
+	"github.com/yourorg/vm-agent/pkg/identity"
 )
 
 // Source represents a configuration source
@@ -73,6 +75,7 @@ type RemoteConfigFetcher struct {
 	controlPlaneURL string
 	token           string
 	agentID         string
+	identity        *identity.Identity
 	httpClient      *http.Client
 }
 
@@ -88,13 +91,20 @@ func NewRemoteConfigFetcher(controlPlaneURL, token, agentID string) *RemoteConfi
 	}
 }
 
+// SetIdentity sets the identity requests are signed with; the control
+// plane only serves an agent its configuration on signed requests
+func (f *RemoteConfigFetcher) SetIdentity(id *identity.Identity) {
+	f.identity = id
+}
+
 // Fetch retrieves configuration from the control plane
 func (f *RemoteConfigFetcher) Fetch() (*RemoteConfig, error) {
 	if f.controlPlaneURL == "" {
 		return nil, fmt.Errorf("control plane URL not configured")
 	}
 
-	url := fmt.Sprintf("%s/api/v1/agents/%s/config", f.controlPlaneURL, f.agentID)
+	// The agent is the one the token was issued to
+	url := fmt.Sprintf("%s/api/v1/agent/config", f.controlPlaneURL)
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
@@ -102,6 +112,9 @@ func (f *RemoteConfigFetcher) Fetch() (*RemoteConfig, error) {
 
 	req.Header.Set("Authorization", "Bearer "+f.token)
 	req.Header.Set("Accept", "application/json")
+	if f.identity != nil {
+		f.identity.SignRequest(req, nil)
+	}
 
 	resp, err := f.httpClient.Do(req)
 	if err != nil {
@@ -180,10 +193,18 @@ func MergeConfigs(base *Config, overlay *Config, overlaySource Source, resolver
 		result.Piko.ServerURL = overlay.Piko.ServerURL
 		resolver.SetSource("piko.server_url", overlaySource)
 	}
+	if len(overlay.Piko.Servers) > 0 && resolver.ShouldOverride("piko.servers", overlaySource) {
+		result.Piko.Servers = overlay.Piko.Servers
+		resolver.SetSource("piko.servers", overlaySource)
+	}
 	if overlay.Piko.Endpoint != "" && resolver.ShouldOverride("piko.endpoint", overlaySource) {
 		result.Piko.Endpoint = overlay.Piko.Endpoint
 		resolver.SetSource("piko.endpoint", overlaySource)
 	}
+	if overlay.Piko.DispatchKey != "" && resolver.ShouldOverride("piko.dispatch_key", overlaySource) {
+		result.Piko.DispatchKey = overlay.Piko.DispatchKey
+		resolver.SetSource("piko.dispatch_key", overlaySource)
+	}
 
 	// Merge webhook config
 	if overlay.Webhook.Port != 0 && resolver.ShouldOverride("webhook.port", overlaySource) {
@@ -0,0 +1,93 @@
+// Package config handles configuration loading and management for the vm-agent.
+package config
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// ProfileKeys are the configuration keys the control plane's configuration
+// profiles manage
+var ProfileKeys = []string{
+	"webhook.port",
+	"probe.max_concurrent",
+	"probe.default_timeout",
+	"probe.priority_aging",
+	"health.check_interval",
+	"health.report_interval",
+	"logging.level",
+	"logging.format",
+}
+
+// ProfileValues returns the profile-managed settings in the form profiles
+// set them: integers, duration strings and strings
+func (c *Config) ProfileValues() map[string]interface{} {
+	return map[string]interface{}{
+		"webhook.port":           c.Webhook.Port,
+		"probe.max_concurrent":   c.Probe.MaxConcurrent,
+		"probe.default_timeout":  c.Probe.DefaultTimeout.String(),
+		"probe.priority_aging":   c.Probe.PriorityAging.String(),
+		"health.check_interval":  c.Health.CheckInterval.String(),
+		"health.report_interval": c.Health.ReportInterval.String(),
+		"logging.level":          c.Logging.Level,
+		"logging.format":         c.Logging.Format,
+	}
+}
+
+// SetProfileValue sets a profile-managed setting from its JSON value
+func (c *Config) SetProfileValue(key string, value interface{}) error {
+	var err error
+	switch key {
+	case "webhook.port":
+		c.Webhook.Port, err = profileInt(value)
+	case "probe.max_concurrent":
+		c.Probe.MaxConcurrent, err = profileInt(value)
+	case "probe.default_timeout":
+		c.Probe.DefaultTimeout, err = profileDuration(value)
+	case "probe.priority_aging":
+		c.Probe.PriorityAging, err = profileDuration(value)
+	case "health.check_interval":
+		c.Health.CheckInterval, err = profileDuration(value)
+	case "health.report_interval":
+		c.Health.ReportInterval, err = profileDuration(value)
+	case "logging.level":
+		c.Logging.Level, err = profileEnum(value, "debug", "info", "warn", "error")
+	case "logging.format":
+		c.Logging.Format, err = profileEnum(value, "json", "console")
+	default:
+		return fmt.Errorf("%s is not managed by profiles", key)
+	}
+	return err
+}
+
+// profileInt converts a JSON number to an integer
+func profileInt(value interface{}) (int, error) {
+	n, ok := value.(float64)
+	if !ok || n != math.Trunc(n) {
+		return 0, fmt.Errorf("must be an integer")
+	}
+	return int(n), nil
+}
+
+// profileDuration parses a duration string
+func profileDuration(value interface{}) (time.Duration, error) {
+	s, ok := value.(string)
+	if !ok {
+		return 0, fmt.Errorf("must be a duration string")
+	}
+	return time.ParseDuration(s)
+}
+
+// profileEnum checks a string is one of the allowed values
+func profileEnum(value interface{}, allowed ...string) (string, error) {
+	s, ok := value.(string)
+	if ok {
+		for _, a := range allowed {
+			if s == a {
+				return s, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("must be one of %v", allowed)
+}
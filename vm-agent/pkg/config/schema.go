@@ -0,0 +1,364 @@
+// Package config handles configuration loading and management for the vm-agent.
+package config
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Warning is a configuration problem that does not stop the agent, such as
+// an unknown or deprecated key
+type Warning struct {
+	Field   string
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s", w.Field, w.Message)
+}
+
+// Deprecation is a configuration key that was renamed or removed. The value
+// of a renamed key is used for its replacement unless that is set too.
+type Deprecation struct {
+	Key        string
+	ReplacedBy string // empty when the setting was removed
+	Reason     string // why a removed setting is no longer needed
+}
+
+// Deprecations are the keys written by earlier agents and install scripts
+var Deprecations = []Deprecation{
+	{Key: "control_plane.endpoint", ReplacedBy: "agent.control_plane_url"},
+	{Key: "control_plane.installation_key", Reason: "the installation key is only used by install and repair --key"},
+	{Key: "logging.output", ReplacedBy: "logging.file"},
+	{Key: "piko.enabled", Reason: "the Piko client always runs"},
+	{Key: "webhook.enabled", Reason: "the webhook server always runs"},
+	{Key: "health.enabled", Reason: "health checks always run"},
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// schemaCheck collects the problems found checking settings against the
+// types of the Config fields they decode into
+type schemaCheck struct {
+	warnings []Warning
+	errors   ValidationErrors
+}
+
+// checkSchema checks the settings read by the loader, before environment
+// overrides, and carries deprecated keys over to their replacements
+func (l *Loader) checkSchema() ([]Warning, error) {
+	c := &schemaCheck{}
+
+	for _, d := range Deprecations {
+		if !l.v.InConfig(d.Key) {
+			continue
+		}
+		switch {
+		case d.ReplacedBy == "":
+			c.warn(d.Key, "is no longer used and is ignored: "+d.Reason)
+		case l.v.InConfig(d.ReplacedBy):
+			c.warn(d.Key, fmt.Sprintf("is deprecated and ignored because %s is set", d.ReplacedBy))
+		default:
+			c.warn(d.Key, fmt.Sprintf("is deprecated, use %s", d.ReplacedBy))
+			value := l.v.Get(d.Key)
+			if c.check(d.Key, keyType(d.ReplacedBy), value) {
+				// As a default the value still gives way to the environment
+				l.v.SetDefault(d.ReplacedBy, value)
+			}
+		}
+	}
+
+	c.check("", reflect.TypeOf(Config{}), l.v.AllSettings())
+
+	if len(c.errors) > 0 {
+		return c.warnings, c.errors
+	}
+	return c.warnings, nil
+}
+
+// check checks a setting decodes into a field of type t and reports whether
+// it does
+func (c *schemaCheck) check(field string, t reflect.Type, value interface{}) bool {
+	if value == nil {
+		// Empty settings keep their defaults
+		return true
+	}
+	errs := len(c.errors)
+
+	switch {
+	case t.Kind() == reflect.Struct:
+		settings, ok := value.(map[string]interface{})
+		if !ok {
+			c.addError(field, "must be a section of settings")
+			break
+		}
+		fields := fieldsOf(t)
+		for _, key := range sortedKeys(settings) {
+			name := joinKey(field, key)
+			if ft, ok := fields[key]; ok {
+				c.check(name, ft, settings[key])
+			} else {
+				c.unknown(name, settings[key], fields)
+			}
+		}
+
+	case t.Kind() == reflect.Slice:
+		items := reflect.ValueOf(value)
+		if items.Kind() != reflect.Slice {
+			// A single value is read as a list of one, but a section is not
+			if t.Elem().Kind() == reflect.Struct {
+				c.addError(field, "must be a list")
+			} else {
+				c.check(field, t.Elem(), value)
+			}
+			break
+		}
+		for i := 0; i < items.Len(); i++ {
+			c.check(fmt.Sprintf("%s[%d]", field, i), t.Elem(), items.Index(i).Interface())
+		}
+
+	default:
+		if msg := checkScalar(t, value); msg != "" {
+			c.addError(field, msg)
+		}
+	}
+
+	return len(c.errors) == errs
+}
+
+// unknown reports a setting no field decodes, suggesting the one it is
+// most likely a misspelling of. Deprecated keys were reported already.
+func (c *schemaCheck) unknown(field string, value interface{}, siblings map[string]reflect.Type) {
+	for _, d := range Deprecations {
+		if d.Key == field {
+			return
+		}
+	}
+	if settings, ok := value.(map[string]interface{}); ok && isDeprecatedSection(field) {
+		for _, key := range sortedKeys(settings) {
+			c.unknown(joinKey(field, key), settings[key], nil)
+		}
+		return
+	}
+
+	section, key := "", field
+	if i := strings.LastIndex(field, "."); i >= 0 {
+		section, key = field[:i], field[i+1:]
+	}
+	msg := "unknown setting, ignored"
+	if suggestion := closestKey(key, siblings); suggestion != "" {
+		msg += fmt.Sprintf("; did you mean %s?", joinKey(section, suggestion))
+	}
+	c.warn(field, msg)
+}
+
+func (c *schemaCheck) warn(field, message string) {
+	c.warnings = append(c.warnings, Warning{Field: field, Message: message})
+}
+
+func (c *schemaCheck) addError(field, message string) {
+	c.errors = append(c.errors, ValidationError{Field: field, Message: message})
+}
+
+// checkScalar checks a value converts to a scalar field of type t the way
+// the loader converts it, returning why it does not
+func checkScalar(t reflect.Type, value interface{}) string {
+	v := reflect.ValueOf(value)
+
+	if t == durationType {
+		switch {
+		case v.Type() == durationType:
+		case v.Kind() == reflect.String:
+			if _, err := time.ParseDuration(v.String()); err != nil {
+				return fmt.Sprintf("must be a duration such as 30s, 5m or 1h, got %q", v.String())
+			}
+		case isNumber(v):
+			// A bare number would be read as nanoseconds
+			if n := toFloat(v); n != 0 {
+				return fmt.Sprintf("must be a duration with a unit, such as %gs, got %g", n, n)
+			}
+		default:
+			return fmt.Sprintf("must be a duration such as 30s, 5m or 1h, got %v", value)
+		}
+		return ""
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if v.Kind() == reflect.String {
+			if _, err := strconv.ParseBool(v.String()); err == nil {
+				return ""
+			}
+		}
+		if v.Kind() != reflect.Bool {
+			return fmt.Sprintf("must be true or false, got %v", value)
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Kind() == reflect.String {
+			if _, err := strconv.ParseInt(v.String(), 0, 64); err == nil {
+				return ""
+			}
+		}
+		if !isNumber(v) || toFloat(v) != math.Trunc(toFloat(v)) {
+			return fmt.Sprintf("must be a whole number, got %v", value)
+		}
+
+	case reflect.Float32, reflect.Float64:
+		if v.Kind() == reflect.String {
+			if _, err := strconv.ParseFloat(v.String(), 64); err == nil {
+				return ""
+			}
+		}
+		if !isNumber(v) {
+			return fmt.Sprintf("must be a number, got %v", value)
+		}
+
+	case reflect.String:
+		switch v.Kind() {
+		case reflect.Map, reflect.Slice, reflect.Array:
+			return "must be a single value, not a list or section"
+		}
+	}
+	return ""
+}
+
+// fieldsOf maps the keys of a config section to the types of their fields
+func fieldsOf(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if key := f.Tag.Get("mapstructure"); key != "" {
+			fields[key] = f.Type
+		}
+	}
+	return fields
+}
+
+// keyType returns the type of the field a dotted key decodes into
+func keyType(key string) reflect.Type {
+	t := reflect.TypeOf(Config{})
+	for _, part := range strings.Split(key, ".") {
+		t = fieldsOf(t)[part]
+	}
+	return t
+}
+
+// isDeprecatedSection reports whether a section only holds deprecated keys
+func isDeprecatedSection(section string) bool {
+	for _, d := range Deprecations {
+		if strings.HasPrefix(d.Key, section+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// closestKey returns the key a misspelt key most likely meant, if any is
+// close enough
+func closestKey(key string, keys map[string]reflect.Type) string {
+	best, bestDistance := "", len(key)/3+1
+	for candidate := range keys {
+		if d := editDistance(key, candidate); d < bestDistance || (d == bestDistance && best != "" && candidate < best) {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between two keys
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func isNumber(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func toFloat(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	}
+	return v.Float()
+}
+
+func sortedKeys(settings map[string]interface{}) []string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinKey(section, key string) string {
+	if section == "" {
+		return key
+	}
+	return section + "." + key
+}
+
+// FillDefaults sets the settings cfg leaves at their zero value to their
+// defaults and returns their keys. Switches are left alone, as false cannot
+// be told apart from unset.
+func FillDefaults(cfg *Config) []string {
+	l := NewLoader()
+	l.setDefaults()
+	var defaults Config
+	if err := l.v.Unmarshal(&defaults); err != nil {
+		return nil
+	}
+
+	var filled []string
+	fillDefaults("", reflect.ValueOf(cfg).Elem(), reflect.ValueOf(defaults), &filled)
+	return filled
+}
+
+func fillDefaults(section string, v, defaults reflect.Value, filled *[]string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("mapstructure")
+		if key == "" {
+			continue
+		}
+		field, def := v.Field(i), defaults.Field(i)
+		switch {
+		case field.Kind() == reflect.Struct && field.Type() != durationType:
+			fillDefaults(joinKey(section, key), field, def, filled)
+		case field.Kind() == reflect.Bool:
+		case field.IsZero() && !def.IsZero():
+			field.Set(def)
+			*filled = append(*filled, joinKey(section, key))
+		}
+	}
+}
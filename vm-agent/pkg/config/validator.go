@@ -2,10 +2,14 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
 	"net/url"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
 // ValidationError represents a configuration validation error
@@ -45,9 +49,14 @@ func (v *Validator) Validate(cfg *Config) error {
 
 	v.validateAgent(cfg.Agent)
 	v.validatePiko(cfg.Piko)
+	v.validateIsolation(cfg.Agent, cfg.Piko)
 	v.validateWebhook(cfg.Webhook)
 	v.validateProbe(cfg.Probe)
 	v.validateHealth(cfg.Health)
+	v.validateInventory(cfg.Inventory)
+	v.validateUpgrade(cfg.Upgrade)
+	v.validateShell(cfg.Shell)
+	v.validateOutbox(cfg.Outbox)
 
 	if len(v.errors) > 0 {
 		return v.errors
@@ -80,14 +89,33 @@ func (v *Validator) validateAgent(cfg AgentConfig) {
 
 // validatePiko validates Piko configuration
 func (v *Validator) validatePiko(cfg PikoConfig) {
-	if cfg.ServerURL == "" {
+	if cfg.ServerURL == "" && len(cfg.Servers) == 0 {
 		v.addError("piko.server_url", "Piko server URL is required")
-	} else {
+	} else if cfg.ServerURL != "" {
 		if _, err := url.Parse(cfg.ServerURL); err != nil {
 			v.addError("piko.server_url", "invalid URL format")
 		}
 	}
 
+	for i, server := range cfg.Servers {
+		field := fmt.Sprintf("piko.servers[%d].url", i)
+		if server.URL == "" {
+			v.addError(field, "Piko server URL is required")
+		} else if _, err := url.Parse(server.URL); err != nil {
+			v.addError(field, "invalid URL format")
+		}
+	}
+
+	switch cfg.Failover.Strategy {
+	case "", "priority", "latency":
+	default:
+		v.addError("piko.failover.strategy", "must be priority or latency")
+	}
+
+	if cfg.Failover.MaxFailures < 0 {
+		v.addError("piko.failover.max_failures", "must not be negative")
+	}
+
 	if cfg.Endpoint == "" {
 		v.addError("piko.endpoint", "Piko endpoint is required")
 	}
@@ -107,6 +135,24 @@ func (v *Validator) validatePiko(cfg PikoConfig) {
 	if cfg.Reconnect.Multiplier < 1 {
 		v.addError("piko.reconnect.multiplier", "must be at least 1")
 	}
+
+	if cfg.Reconnect.Jitter < 0 || cfg.Reconnect.Jitter > 1 {
+		v.addError("piko.reconnect.jitter", "must be between 0 and 1")
+	}
+}
+
+// validateIsolation checks the agent only listens on an endpoint of its own
+// tenant and that its dispatch key is well formed
+func (v *Validator) validateIsolation(agent AgentConfig, piko PikoConfig) {
+	if agent.TenantID != "" && piko.Endpoint != "" && !strings.HasPrefix(piko.Endpoint, "tenant-"+agent.TenantID+"/") {
+		v.addError("piko.endpoint", fmt.Sprintf("must be an endpoint of tenant %s (tenant-%s/...)", agent.TenantID, agent.TenantID))
+	}
+
+	if piko.DispatchKey != "" {
+		if key, err := base64.StdEncoding.DecodeString(piko.DispatchKey); err != nil || len(key) != 32 {
+			v.addError("piko.dispatch_key", "must be the base64 32-byte key issued at registration")
+		}
+	}
 }
 
 // validateWebhook validates webhook configuration
@@ -143,6 +189,26 @@ func (v *Validator) validateProbe(cfg ProbeConfig) {
 	if cfg.MaxConcurrent < 1 {
 		v.addError("probe.max_concurrent", "must be at least 1")
 	}
+
+	if cfg.PriorityAging < 0 {
+		v.addError("probe.priority_aging", "must not be negative")
+	}
+
+	if cfg.JobDiskQuotaBytes < 0 {
+		v.addError("probe.job_disk_quota_bytes", "must not be negative")
+	}
+	if cfg.WorkDirQuotaBytes < 0 {
+		v.addError("probe.work_dir_quota_bytes", "must not be negative")
+	}
+	if cfg.MinFreeDiskBytes < 0 {
+		v.addError("probe.min_free_disk_bytes", "must not be negative")
+	}
+
+	if cfg.ReportURL != "" {
+		if _, err := url.Parse(cfg.ReportURL); err != nil {
+			v.addError("probe.report_url", "invalid URL format")
+		}
+	}
 }
 
 // validateHealth validates health configuration
@@ -155,6 +221,13 @@ func (v *Validator) validateHealth(cfg HealthConfig) {
 		v.addError("health.report_interval", "must be positive")
 	}
 
+	if cfg.DiskWarnPercent <= 0 || cfg.DiskWarnPercent > 100 {
+		v.addError("health.disk_warn_percent", "must be between 0 and 100")
+	}
+	if cfg.DiskCriticalPercent < cfg.DiskWarnPercent || cfg.DiskCriticalPercent > 100 {
+		v.addError("health.disk_critical_percent", "must be between disk_warn_percent and 100")
+	}
+
 	if cfg.ReportURL != "" {
 		if _, err := url.Parse(cfg.ReportURL); err != nil {
 			v.addError("health.report_url", "invalid URL format")
@@ -162,6 +235,107 @@ func (v *Validator) validateHealth(cfg HealthConfig) {
 	}
 }
 
+// validateInventory validates software inventory configuration
+func (v *Validator) validateInventory(cfg InventoryConfig) {
+	if !cfg.Enabled {
+		return
+	}
+
+	if cfg.Interval < time.Minute {
+		v.addError("inventory.interval", "must be at least 1m")
+	}
+
+	if cfg.ReportURL != "" {
+		if _, err := url.Parse(cfg.ReportURL); err != nil {
+			v.addError("inventory.report_url", "invalid URL format")
+		}
+	}
+
+	for i, source := range cfg.Sources {
+		switch source {
+		case "dpkg", "rpm", "choco", "winget":
+		default:
+			v.addError(fmt.Sprintf("inventory.sources[%d]", i), "must be dpkg, rpm, choco or winget")
+		}
+	}
+
+	for i, pattern := range cfg.Allowlist {
+		if _, err := path.Match(pattern, ""); err != nil {
+			v.addError(fmt.Sprintf("inventory.allowlist[%d]", i), "invalid glob pattern")
+		}
+	}
+}
+
+// validateUpgrade validates upgrade configuration
+func (v *Validator) validateUpgrade(cfg UpgradeConfig) {
+	if cfg.ChunkSize < 0 {
+		v.addError("upgrade.chunk_size", "must not be negative")
+	}
+
+	if cfg.MaxRetries < 0 {
+		v.addError("upgrade.max_retries", "must not be negative")
+	}
+
+	if cfg.BandwidthLimit < 0 {
+		v.addError("upgrade.bandwidth_limit", "must not be negative")
+	}
+
+	if cfg.VerifyTimeout < 0 {
+		v.addError("upgrade.verify_timeout", "must not be negative")
+	} else if cfg.VerifyTimeout > 0 && cfg.VerifyTimeout < 10*time.Second {
+		v.addError("upgrade.verify_timeout", "must be at least 10s")
+	}
+
+	if cfg.MaxRetryDelay > 0 && cfg.MaxRetryDelay < cfg.RetryDelay {
+		v.addError("upgrade.max_retry_delay", "must be greater than or equal to retry_delay")
+	}
+}
+
+// validateShell validates shell session configuration
+func (v *Validator) validateShell(cfg ShellConfig) {
+	if !cfg.Enabled {
+		return
+	}
+
+	if !filepath.IsAbs(cfg.Command) {
+		v.addError("shell.command", "must be an absolute path")
+	}
+
+	if cfg.MaxSessions <= 0 {
+		v.addError("shell.max_sessions", "must be positive")
+	}
+
+	if cfg.MaxDuration <= 0 {
+		v.addError("shell.max_duration", "must be positive")
+	}
+
+	if cfg.IdleTimeout <= 0 {
+		v.addError("shell.idle_timeout", "must be positive")
+	}
+}
+
+// validateOutbox validates the outbox configuration
+func (v *Validator) validateOutbox(cfg OutboxConfig) {
+	if cfg.Dir == "" {
+		v.addError("outbox.dir", "outbox directory is required")
+	}
+
+	if cfg.MaxBytes <= 0 {
+		v.addError("outbox.max_bytes", "must be positive")
+	}
+
+	if cfg.MaxAge <= 0 {
+		v.addError("outbox.max_age", "must be positive")
+	}
+
+	if cfg.RetryDelay <= 0 {
+		v.addError("outbox.retry_delay", "must be positive")
+	}
+	if cfg.MaxRetryDelay < cfg.RetryDelay {
+		v.addError("outbox.max_retry_delay", "must be greater than or equal to retry_delay")
+	}
+}
+
 // addError adds a validation error
 func (v *Validator) addError(field, message string) {
 	v.errors = append(v.errors, ValidationError{
@@ -212,7 +386,7 @@ func ValidateForInstall(cfg *Config) error {
 		v.addError("agent.tenant_id", "tenant ID is required for installation")
 	}
 
-	if cfg.Piko.ServerURL == "" {
+	if cfg.Piko.ServerURL == "" && len(cfg.Piko.Servers) == 0 {
 		v.addError("piko.server_url", "Piko server URL is required for installation")
 	}
 
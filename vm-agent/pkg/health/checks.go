@@ -3,24 +3,42 @@ package health
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"runtime"
 	"time"
-
-	"golang.org/x/sys/unix"
 )
 
+// PikoServer describes the Piko server the agent is homed to
+type PikoServer struct {
+	URL     string
+	Region  string
+	Latency time.Duration // zero when not probed
+}
+
+// PikoConnection describes the state of the Piko connection
+type PikoConnection struct {
+	Connected      bool
+	State          string
+	Server         PikoServer
+	ConnectedSince time.Time // zero while not connected
+	Reconnects     int
+	FailedAttempts int // since the last connection
+	LastError      error
+	LastErrorAt    time.Time
+	Backoff        time.Duration // zero unless waiting to reconnect
+	NextAttemptAt  time.Time
+}
+
 // PikoChecker checks the health of the Piko connection
 type PikoChecker struct {
-	isConnected func() bool
-	lastError   func() error
+	connection func() PikoConnection
 }
 
 // NewPikoChecker creates a new Piko health checker
-func NewPikoChecker(isConnected func() bool, lastError func() error) *PikoChecker {
+func NewPikoChecker(connection func() PikoConnection) *PikoChecker {
 	return &PikoChecker{
-		isConnected: isConnected,
-		lastError:   lastError,
+		connection: connection,
 	}
 }
 
@@ -37,13 +55,44 @@ func (c *PikoChecker) Check(ctx context.Context) *Component {
 		Details:     make(map[string]any),
 	}
 
-	if c.isConnected() {
+	conn := c.connection()
+
+	// Report the active server so operators can see which region each
+	// agent is homed to
+	component.Details["server_url"] = conn.Server.URL
+	if conn.Server.Region != "" {
+		component.Details["region"] = conn.Server.Region
+	}
+	if conn.Server.Latency > 0 {
+		component.Details["latency_ms"] = conn.Server.Latency.Milliseconds()
+	}
+
+	// Report how the connection has been holding up, so flapping agents
+	// and ones stuck in backoff stand out
+	component.Details["state"] = conn.State
+	component.Details["reconnects"] = conn.Reconnects
+	if !conn.ConnectedSince.IsZero() {
+		component.Details["connected_since"] = conn.ConnectedSince.UTC().Format(time.RFC3339)
+	}
+	if conn.FailedAttempts > 0 {
+		component.Details["failed_attempts"] = conn.FailedAttempts
+	}
+	if conn.LastError != nil {
+		component.Details["last_error"] = conn.LastError.Error()
+		component.Details["last_error_at"] = conn.LastErrorAt.UTC().Format(time.RFC3339)
+	}
+	if conn.Backoff > 0 {
+		component.Details["backoff_ms"] = conn.Backoff.Milliseconds()
+		component.Details["next_attempt_at"] = conn.NextAttemptAt.UTC().Format(time.RFC3339)
+	}
+
+	if conn.Connected {
 		component.Status = StatusHealthy
 		component.Message = "connected to Piko server"
 	} else {
 		component.Status = StatusUnhealthy
-		if err := c.lastError(); err != nil {
-			component.Message = err.Error()
+		if conn.LastError != nil {
+			component.Message = conn.LastError.Error()
 		} else {
 			component.Message = "disconnected from Piko server"
 		}
@@ -120,7 +169,7 @@ func (c *ProbeChecker) Check(ctx context.Context) *Component {
 		Name:        c.Name(),
 		LastChecked: time.Now(),
 		Details: map[string]any{
-			"active_jobs":   activeJobs,
+			"active_jobs":    activeJobs,
 			"max_concurrent": c.maxConcurrent,
 		},
 	}
@@ -136,6 +185,101 @@ func (c *ProbeChecker) Check(ctx context.Context) *Component {
 	return component
 }
 
+// DiskUsage describes the disk usage of the agent's work directory. Limits
+// are 0 when not set.
+type DiskUsage struct {
+	Path         string
+	Free         uint64
+	Total        uint64
+	Err          error // set when free and total space could not be read
+	JobDirs      uint64
+	JobDirsQuota uint64
+	JobQuota     uint64
+	MinFree      uint64
+	Jobs         int
+}
+
+// DiskChecker checks the disk space of the work directory and the size of
+// job directories against their quota
+type DiskChecker struct {
+	usage           func() DiskUsage
+	warnPercent     float64
+	criticalPercent float64
+}
+
+// NewDiskChecker creates a new disk usage health checker, degraded once
+// warnPercent of the disk is used and unhealthy at criticalPercent
+func NewDiskChecker(usage func() DiskUsage, warnPercent, criticalPercent float64) *DiskChecker {
+	return &DiskChecker{
+		usage:           usage,
+		warnPercent:     warnPercent,
+		criticalPercent: criticalPercent,
+	}
+}
+
+// Name returns the checker name
+func (c *DiskChecker) Name() string {
+	return "disk"
+}
+
+// Check performs the health check
+func (c *DiskChecker) Check(ctx context.Context) *Component {
+	usage := c.usage()
+	component := &Component{
+		Name:        c.Name(),
+		LastChecked: time.Now(),
+		Details: map[string]any{
+			"path":             usage.Path,
+			"job_dirs":         usage.Jobs,
+			"job_dirs_bytes":   usage.JobDirs,
+			"warn_percent":     c.warnPercent,
+			"critical_percent": c.criticalPercent,
+		},
+	}
+	if usage.JobDirsQuota > 0 {
+		component.Details["job_dirs_quota_bytes"] = usage.JobDirsQuota
+	}
+	if usage.JobQuota > 0 {
+		component.Details["job_quota_bytes"] = usage.JobQuota
+	}
+	if usage.MinFree > 0 {
+		component.Details["min_free_bytes"] = usage.MinFree
+	}
+
+	if usage.Err != nil || usage.Total == 0 {
+		component.Status = StatusUnknown
+		component.Message = "disk space could not be read"
+		if usage.Err != nil {
+			component.Message += ": " + usage.Err.Error()
+		}
+		return component
+	}
+
+	usedPercent := float64(usage.Total-usage.Free) / float64(usage.Total) * 100
+	component.Details["free_bytes"] = usage.Free
+	component.Details["total_bytes"] = usage.Total
+	component.Details["used_percent"] = usedPercent
+
+	switch {
+	case usedPercent >= c.criticalPercent:
+		component.Status = StatusUnhealthy
+		component.Message = fmt.Sprintf("disk %.0f%% used", usedPercent)
+	case usage.MinFree > 0 && usage.Free < usage.MinFree:
+		component.Status = StatusDegraded
+		component.Message = "free disk space below min_free_disk_bytes, new jobs refused"
+	case usage.JobDirsQuota > 0 && usage.JobDirs >= usage.JobDirsQuota:
+		component.Status = StatusDegraded
+		component.Message = "job directories at their quota, new jobs refused"
+	case usedPercent >= c.warnPercent:
+		component.Status = StatusDegraded
+		component.Message = fmt.Sprintf("disk %.0f%% used", usedPercent)
+	default:
+		component.Status = StatusHealthy
+		component.Message = "disk space OK"
+	}
+	return component
+}
+
 // SystemChecker checks system resources
 type SystemChecker struct {
 	minDiskSpace uint64 // minimum disk space in bytes
@@ -190,17 +334,6 @@ func (c *SystemChecker) Check(ctx context.Context) *Component {
 	return component
 }
 
-// getDiskSpace returns free and total disk space for a path
-func getDiskSpace(path string) (free, total uint64, err error) {
-	var stat unix.Statfs_t
-	if err = unix.Statfs(path, &stat); err != nil {
-		return 0, 0, err
-	}
-	free = stat.Bavail * uint64(stat.Bsize)
-	total = stat.Blocks * uint64(stat.Bsize)
-	return
-}
-
 // ControlPlaneChecker checks connectivity to control plane
 type ControlPlaneChecker struct {
 	isConnected func() bool
@@ -248,6 +381,53 @@ func (c *ControlPlaneChecker) Check(ctx context.Context) *Component {
 	return component
 }
 
+// UpgradeChecker reports the outcome of the last post-upgrade verification
+type UpgradeChecker struct {
+	lastOutcome func() (outcome string, completedAt time.Time, details map[string]any)
+	window      time.Duration
+}
+
+// NewUpgradeChecker creates a new upgrade health checker. A rollback is
+// reported as degraded for window after it happened.
+func NewUpgradeChecker(lastOutcome func() (string, time.Time, map[string]any), window time.Duration) *UpgradeChecker {
+	return &UpgradeChecker{
+		lastOutcome: lastOutcome,
+		window:      window,
+	}
+}
+
+// Name returns the checker name
+func (c *UpgradeChecker) Name() string {
+	return "upgrade"
+}
+
+// Check performs the health check
+func (c *UpgradeChecker) Check(ctx context.Context) *Component {
+	component := &Component{
+		Name:        c.Name(),
+		Status:      StatusHealthy,
+		LastChecked: time.Now(),
+		Details:     make(map[string]any),
+	}
+
+	outcome, completedAt, details := c.lastOutcome()
+	for k, v := range details {
+		component.Details[k] = v
+	}
+
+	switch {
+	case outcome == "":
+		component.Message = "no upgrade recorded"
+	case outcome == "rolled_back" && time.Since(completedAt) < c.window:
+		component.Status = StatusDegraded
+		component.Message = "last upgrade failed verification and was rolled back"
+	default:
+		component.Message = "last upgrade " + outcome
+	}
+
+	return component
+}
+
 // SelfChecker checks the agent's own health
 type SelfChecker struct {
 	startTime time.Time
@@ -275,8 +455,8 @@ func (c *SelfChecker) Check(ctx context.Context) *Component {
 		Message:     "agent running",
 		LastChecked: time.Now(),
 		Details: map[string]any{
-			"pid":       c.pid,
-			"uptime_s":  time.Since(c.startTime).Seconds(),
+			"pid":        c.pid,
+			"uptime_s":   time.Since(c.startTime).Seconds(),
 			"go_version": runtime.Version(),
 		},
 	}
@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+// Package health provides health monitoring for the vm-agent.
+package health
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// getDiskSpace returns free and total disk space for a path
+func getDiskSpace(path string) (free, total uint64, err error) {
+	var stat unix.Statfs_t
+	if err = unix.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	free = uint64(stat.Bavail) * uint64(stat.Bsize)
+	total = uint64(stat.Blocks) * uint64(stat.Bsize)
+	return
+}
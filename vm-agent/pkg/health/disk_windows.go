@@ -0,0 +1,21 @@
+//go:build windows
+// +build windows
+
+// Package health provides health monitoring for the vm-agent.
+package health
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// getDiskSpace returns free and total disk space for a path
+func getDiskSpace(path string) (free, total uint64, err error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err = windows.GetDiskFreeSpaceEx(pathPtr, &free, &total, nil); err != nil {
+		return 0, 0, err
+	}
+	return
+}
@@ -37,6 +37,7 @@ type Status struct {
 	Version     string                `json:"version"`
 	Uptime      time.Duration         `json:"uptime"`
 	LastUpdated time.Time             `json:"last_updated"`
+	DrainState  string                `json:"drain_state,omitempty"`
 }
 
 // Checker is the interface for health checks
@@ -56,6 +57,7 @@ type Monitor struct {
 	agentID       string
 	tenantID      string
 	version       string
+	drainState    func() string
 	stopCh        chan struct{}
 	wg            sync.WaitGroup
 }
@@ -88,6 +90,13 @@ func (m *Monitor) RegisterChecker(checker Checker) {
 	m.checkers = append(m.checkers, checker)
 }
 
+// SetDrainStateFunc sets the source of the drain state included in status reports
+func (m *Monitor) SetDrainStateFunc(fn func() string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.drainState = fn
+}
+
 // Start starts the health monitoring loop
 func (m *Monitor) Start(ctx context.Context) {
 	m.wg.Add(1)
@@ -164,6 +173,9 @@ func (m *Monitor) GetStatus() *Status {
 		status.Components[k] = &component
 	}
 	status.Uptime = time.Since(m.startTime)
+	if m.drainState != nil {
+		status.DrainState = m.drainState()
+	}
 
 	return &status
 }
@@ -11,6 +11,9 @@ import (
 	"time"
 
 	"go.uber.org/zap"
+
+	"github.com/yourorg/vm-agent/pkg/identity"
+	"github.com/yourorg/vm-agent/pkg/outbox"
 )
 
 // Reporter reports health status to the control plane
@@ -19,6 +22,7 @@ type Reporter struct {
 	monitor        *Monitor
 	reportURL      string
 	token          string
+	identity       *identity.Identity
 	reportInterval time.Duration
 	httpClient     *http.Client
 	logger         *zap.Logger
@@ -26,6 +30,52 @@ type Reporter struct {
 	wg             sync.WaitGroup
 	lastReport     time.Time
 	lastError      error
+
+	// Round trip of the last successful report, sent with the next one, and
+	// the agent clock's offset from the control plane estimated from it
+	lastLatency     time.Duration
+	lastClockOffset time.Duration
+
+	// grains returns the host facts sent with each report
+	grains func() map[string]interface{}
+
+	// configSync exchanges configuration profile state with the control
+	// plane; intervalCh passes report interval changes to the loop
+	configSync ConfigSync
+	intervalCh chan time.Duration
+
+	// outbox keeps the last report the control plane could not be reached
+	// for, until it is reached or a later report is sent
+	outbox *outbox.Outbox
+}
+
+// ConfigSync applies the configuration profiles the control plane returns
+// in reply to health reports
+type ConfigSync interface {
+	// ConfigState returns the state sent with each report, or nil before a
+	// profile was handled
+	ConfigState() interface{}
+	// ApplyProfile applies the profile from a reply, null if none is
+	// assigned, and reports whether the state to send changed
+	ApplyProfile(profile json.RawMessage) bool
+}
+
+// reportPayload is a health report with the timing the control plane uses
+// to derive latency and clock skew
+type reportPayload struct {
+	*Status
+	AgentTime time.Time `json:"agent_time"`
+	LatencyMs *int64    `json:"latency_ms,omitempty"`
+	// Grains are the host facts the control plane renders previews with
+	Grains map[string]interface{} `json:"grains,omitempty"`
+	// Config is the agent's configuration profile state
+	Config interface{} `json:"config,omitempty"`
+}
+
+// reportResponse is the control plane's reply to a health report
+type reportResponse struct {
+	ServerTime    time.Time       `json:"server_time"`
+	ConfigProfile json.RawMessage `json:"config_profile"`
 }
 
 // NewReporter creates a new health reporter
@@ -38,9 +88,63 @@ func NewReporter(monitor *Monitor, reportURL, token string, reportInterval time.
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		logger: logger,
-		stopCh: make(chan struct{}),
+		logger:     logger,
+		stopCh:     make(chan struct{}),
+		intervalCh: make(chan time.Duration, 1),
+	}
+}
+
+// SetIdentity sets the key reports are signed with
+func (r *Reporter) SetIdentity(id *identity.Identity) {
+	r.identity = id
+}
+
+// SetOutbox sets the outbox reports that cannot be sent are queued in
+func (r *Reporter) SetOutbox(o *outbox.Outbox) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.outbox = o
+}
+
+// SetGrainsFunc sets the source of the grains included in reports
+func (r *Reporter) SetGrainsFunc(fn func() map[string]interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.grains = fn
+}
+
+// SetConfigSync sets where configuration profiles received in replies are
+// applied
+func (r *Reporter) SetConfigSync(sync ConfigSync) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.configSync = sync
+}
+
+// SetToken replaces the token reports authenticate with, after the agent
+// refreshed it
+func (r *Reporter) SetToken(token string) {
+	r.mu.Lock()
+	r.token = token
+	r.mu.Unlock()
+}
+
+// SetReportInterval changes the interval between reports, taking effect
+// from the next report
+func (r *Reporter) SetReportInterval(interval time.Duration) {
+	if interval <= 0 {
+		return
 	}
+	r.mu.Lock()
+	r.reportInterval = interval
+	r.mu.Unlock()
+
+	// Only the latest change matters
+	select {
+	case <-r.intervalCh:
+	default:
+	}
+	r.intervalCh <- interval
 }
 
 // Start starts the health reporting loop
@@ -66,6 +170,8 @@ func (r *Reporter) Start(ctx context.Context) {
 				return
 			case <-r.stopCh:
 				return
+			case interval := <-r.intervalCh:
+				ticker.Reset(interval)
 			case <-ticker.C:
 				r.report(ctx)
 			}
@@ -83,7 +189,25 @@ func (r *Reporter) Stop() {
 func (r *Reporter) report(ctx context.Context) {
 	status := r.monitor.GetStatus()
 
-	payload, err := json.Marshal(status)
+	sentAt := time.Now()
+	body := reportPayload{Status: status, AgentTime: sentAt}
+	if latency := r.GetLatency(); latency > 0 {
+		ms := latency.Milliseconds()
+		body.LatencyMs = &ms
+	}
+	r.mu.RLock()
+	token := r.token
+	grains := r.grains
+	configSync := r.configSync
+	queue := r.outbox
+	r.mu.RUnlock()
+	if grains != nil {
+		body.Grains = grains()
+	}
+	if configSync != nil {
+		body.Config = configSync.ConfigState()
+	}
+	payload, err := json.Marshal(body)
 	if err != nil {
 		r.logger.Error("failed to marshal health status", zap.Error(err))
 		r.setLastError(err)
@@ -98,34 +222,90 @@ func (r *Reporter) report(ctx context.Context) {
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+r.token)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if r.identity != nil {
+		r.identity.SignRequest(req, payload)
+	}
 
 	resp, err := r.httpClient.Do(req)
 	if err != nil {
 		r.logger.Error("failed to send health report", zap.Error(err))
 		r.setLastError(err)
+		r.enqueue(queue, status, body.Grains)
 		return
 	}
 	defer resp.Body.Close()
+	latency := time.Since(sentAt)
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
 		err := fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 		r.logger.Error("health report rejected", zap.Int("status_code", resp.StatusCode))
 		r.setLastError(err)
+		if outbox.Retryable(resp.StatusCode) {
+			r.enqueue(queue, status, body.Grains)
+		}
 		return
 	}
 
+	// The server stamps its time on receipt, about half the round trip
+	// after the report was sent
+	var offset time.Duration
+	var reply reportResponse
+	decodeErr := json.NewDecoder(resp.Body).Decode(&reply)
+	if decodeErr == nil && !reply.ServerTime.IsZero() {
+		offset = sentAt.Add(latency / 2).Sub(reply.ServerTime)
+	}
+
 	r.logger.Debug("health report sent successfully",
-		zap.String("overall_status", string(status.Overall)))
-	r.setLastReport()
+		zap.String("overall_status", string(status.Overall)),
+		zap.Duration("latency", latency),
+		zap.Duration("clock_offset", offset))
+	r.setLastReport(latency, offset)
+
+	// This report supersedes the one queued, and the control plane can be
+	// reached again for the queued results
+	if queue != nil {
+		queue.Discard(outbox.KindHealth)
+		queue.Wake()
+	}
+
+	// Report the outcome of applying a new profile without waiting for the
+	// next interval
+	if decodeErr == nil && configSync != nil && configSync.ApplyProfile(reply.ConfigProfile) {
+		r.report(ctx)
+	}
+}
+
+// enqueue queues a report in the outbox in place of the one queued before.
+// It carries no timing, which would be stale once delivered, nor the
+// configuration state, whose reply is not applied.
+func (r *Reporter) enqueue(queue *outbox.Outbox, status *Status, grains map[string]interface{}) {
+	if queue == nil {
+		return
+	}
+	payload, err := json.Marshal(reportPayload{Status: status, Grains: grains})
+	if err != nil {
+		r.logger.Error("failed to marshal health status", zap.Error(err))
+		return
+	}
+	if err := queue.Enqueue(&outbox.Entry{
+		Kind: outbox.KindHealth,
+		Key:  outbox.KindHealth,
+		URL:  r.reportURL,
+		Body: payload,
+	}); err != nil {
+		r.logger.Warn("failed to queue health report", zap.Error(err))
+	}
 }
 
-// setLastReport records a successful report
-func (r *Reporter) setLastReport() {
+// setLastReport records a successful report and its timing
+func (r *Reporter) setLastReport(latency, offset time.Duration) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.lastReport = time.Now()
 	r.lastError = nil
+	r.lastLatency = latency
+	r.lastClockOffset = offset
 }
 
 // setLastError records a reporting error
@@ -142,6 +322,21 @@ func (r *Reporter) GetLastReport() time.Time {
 	return r.lastReport
 }
 
+// GetLatency returns the round trip of the last successful report
+func (r *Reporter) GetLatency() time.Duration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lastLatency
+}
+
+// GetClockOffset returns how far the agent's clock was ahead of the control
+// plane's at the last successful report; negative when behind
+func (r *Reporter) GetClockOffset() time.Duration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lastClockOffset
+}
+
 // GetLastError returns the last reporting error
 func (r *Reporter) GetLastError() error {
 	r.mu.RLock()
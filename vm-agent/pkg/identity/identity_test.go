@@ -0,0 +1,72 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"testing"
+)
+
+func TestLoadKeepsKey(t *testing.T) {
+	dir := t.TempDir()
+	first, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.PublicKey() != second.PublicKey() {
+		t.Fatal("loading the identity again generated a new key")
+	}
+}
+
+func TestSignRequest(t *testing.T) {
+	id, err := Load(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, _ := base64.StdEncoding.DecodeString(id.PublicKey())
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   []byte
+	}{
+		{"get", http.MethodGet, "https://cp.example.com/api/v1/agent/config", nil},
+		{"post", http.MethodPost, "https://cp.example.com/api/v1/agent/health", []byte(`{"overall":"healthy"}`)},
+		{"escaped path", http.MethodGet, "https://cp.example.com/api/v1/agent/templates/a%2Fb/content", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := map[string]bool{}
+			for i := 0; i < 2; i++ {
+				req, _ := http.NewRequest(tt.method, tt.url, nil)
+				id.SignRequest(req, tt.body)
+
+				nonce := req.Header.Get(HeaderNonce)
+				if nonce == "" || seen[nonce] {
+					t.Fatalf("nonce %q is missing or reused", nonce)
+				}
+				seen[nonce] = true
+				if req.Header.Get(HeaderPublicKey) != id.PublicKey() {
+					t.Fatalf("public key header = %q", req.Header.Get(HeaderPublicKey))
+				}
+
+				sum := sha256.Sum256(tt.body)
+				message := tt.method + "\n" + req.URL.EscapedPath() + "\n" + req.Header.Get(HeaderTimestamp) + "\n" + nonce + "\n" + hex.EncodeToString(sum[:])
+				signature, err := base64.StdEncoding.DecodeString(req.Header.Get(HeaderSignature))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ed25519.Verify(publicKey, []byte(message), signature) {
+					t.Fatal("signature does not cover the request")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+// Package inventory collects the software installed on the host and reports
+// it to the control plane.
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// collectTimeout bounds listing the packages of all sources
+const collectTimeout = 5 * time.Minute
+
+// Sources maps the package managers software is listed from to the command
+// each is detected by
+var Sources = map[string]string{
+	"dpkg":   "dpkg-query",
+	"rpm":    "rpm",
+	"choco":  "choco",
+	"winget": "winget",
+}
+
+// Package is an installed package
+type Package struct {
+	Source  string `json:"source"`
+	Name    string `json:"name"`
+	Arch    string `json:"arch,omitempty"`
+	Version string `json:"version"`
+}
+
+// key identifies a package; versions of one package replace each other
+func (p *Package) key() string {
+	return p.Source + "\x00" + p.Name + "\x00" + p.Arch
+}
+
+// Collector lists installed packages
+type Collector struct {
+	sources   []string
+	allowlist []string
+	logger    *zap.Logger
+}
+
+// NewCollector creates a collector listing packages from sources, or from
+// every package manager found on the host when none are given. Only
+// packages whose name matches an allowlist pattern are kept, unless the
+// allowlist is empty.
+func NewCollector(sources, allowlist []string, logger *zap.Logger) *Collector {
+	return &Collector{
+		sources:   sources,
+		allowlist: allowlist,
+		logger:    logger,
+	}
+}
+
+// Collect lists the installed packages, sorted. It fails if any source
+// fails, so a partial listing is never reported as removed packages.
+func (c *Collector) Collect(ctx context.Context) ([]Package, error) {
+	ctx, cancel := context.WithTimeout(ctx, collectTimeout)
+	defer cancel()
+
+	var packages []Package
+	for _, source := range c.activeSources() {
+		listed, err := list(ctx, source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s packages: %w", source, err)
+		}
+		for _, pkg := range listed {
+			if c.allowed(pkg.Name) {
+				packages = append(packages, pkg)
+			}
+		}
+	}
+
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].key() != packages[j].key() {
+			return packages[i].key() < packages[j].key()
+		}
+		return packages[i].Version < packages[j].Version
+	})
+	return packages, nil
+}
+
+// activeSources returns the configured sources, or those whose command is
+// installed
+func (c *Collector) activeSources() []string {
+	if len(c.sources) > 0 {
+		return c.sources
+	}
+
+	var sources []string
+	for source, command := range Sources {
+		if _, err := exec.LookPath(command); err == nil {
+			sources = append(sources, source)
+		}
+	}
+	sort.Strings(sources)
+	if len(sources) == 0 {
+		c.logger.Debug("no package manager found for software inventory")
+	}
+	return sources
+}
+
+// allowed reports whether a package is kept in the inventory
+func (c *Collector) allowed(name string) bool {
+	if len(c.allowlist) == 0 {
+		return true
+	}
+	for _, pattern := range c.allowlist {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// list returns the packages installed by a package manager
+func list(ctx context.Context, source string) ([]Package, error) {
+	switch source {
+	case "dpkg":
+		out, err := exec.CommandContext(ctx, "dpkg-query", "-W",
+			"-f=${Package}\t${Version}\t${Architecture}\t${db:Status-Status}\n").Output()
+		if err != nil {
+			return nil, err
+		}
+		// Removed packages whose configuration files remain are still
+		// listed, with another status
+		return parseLines(source, out, "\t", func(fields []string) bool {
+			return len(fields) < 4 || fields[3] == "installed"
+		}), nil
+	case "rpm":
+		out, err := exec.CommandContext(ctx, "rpm", "-qa",
+			"--qf", "%{NAME}\t%{EPOCHNUM}:%{VERSION}-%{RELEASE}\t%{ARCH}\n").Output()
+		if err != nil {
+			return nil, err
+		}
+		packages := parseLines(source, out, "\t", nil)
+		for i := range packages {
+			packages[i].Version = strings.TrimPrefix(packages[i].Version, "0:")
+		}
+		return packages, nil
+	case "choco":
+		out, err := exec.CommandContext(ctx, "choco", "list", "--limit-output").Output()
+		if err != nil {
+			return nil, err
+		}
+		return parseLines(source, out, "|", nil), nil
+	case "winget":
+		return listWinget(ctx)
+	default:
+		return nil, fmt.Errorf("unknown source %q", source)
+	}
+}
+
+// parseLines parses one package per line as name, version and optionally
+// arch separated by sep. keep, when set, filters packages by their fields.
+func parseLines(source string, out []byte, sep string, keep func(fields []string) bool) []Package {
+	var packages []Package
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), sep)
+		if len(fields) < 2 || fields[0] == "" || fields[1] == "" {
+			continue
+		}
+		if keep != nil && !keep(fields) {
+			continue
+		}
+		pkg := Package{Source: source, Name: fields[0], Version: fields[1]}
+		if len(fields) > 2 && fields[2] != "(none)" {
+			pkg.Arch = fields[2]
+		}
+		packages = append(packages, pkg)
+	}
+	return packages
+}
+
+// wingetExport is the part of a winget export file listing packages
+type wingetExport struct {
+	Sources []struct {
+		Packages []struct {
+			PackageIdentifier string `json:"PackageIdentifier"`
+			Version           string `json:"Version"`
+		} `json:"Packages"`
+	} `json:"Sources"`
+}
+
+// listWinget returns the packages winget manages. winget only lists
+// versions machine-readably in its export file.
+func listWinget(ctx context.Context) ([]Package, error) {
+	file, err := os.CreateTemp("", "vm-agent-winget-*.json")
+	if err != nil {
+		return nil, err
+	}
+	name := file.Name()
+	file.Close()
+	defer os.Remove(name)
+
+	if out, err := exec.CommandContext(ctx, "winget", "export", "-o", name,
+		"--include-versions", "--accept-source-agreements", "--disable-interactivity").CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var export wingetExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("invalid winget export: %w", err)
+	}
+
+	var packages []Package
+	for _, source := range export.Sources {
+		for _, pkg := range source.Packages {
+			if pkg.PackageIdentifier != "" && pkg.Version != "" {
+				packages = append(packages, Package{Source: "winget", Name: pkg.PackageIdentifier, Version: pkg.Version})
+			}
+		}
+	}
+	return packages, nil
+}
+
+// Hash returns the digest identifying an inventory of sorted packages
+func Hash(packages []Package) string {
+	h := sha256.New()
+	for _, pkg := range packages {
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\n", pkg.Source, pkg.Name, pkg.Arch, pkg.Version)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
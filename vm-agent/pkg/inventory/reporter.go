@@ -0,0 +1,248 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/yourorg/vm-agent/pkg/identity"
+)
+
+// errStaleBase is returned when the control plane no longer holds the
+// inventory a delta was computed against
+var errStaleBase = errors.New("control plane refused delta against stale inventory")
+
+// Reporter periodically uploads the software inventory to the control
+// plane. The first upload after start lists every package; later ones only
+// the packages changed since the last acknowledged upload.
+type Reporter struct {
+	mu         sync.RWMutex
+	collector  *Collector
+	reportURL  string
+	token      string
+	identity   *identity.Identity
+	interval   time.Duration
+	httpClient *http.Client
+	logger     *zap.Logger
+	stopCh     chan struct{}
+	wg         sync.WaitGroup
+
+	// The inventory the control plane last acknowledged, by package key
+	lastHash     string
+	lastPackages map[string]Package
+	lastReport   time.Time
+	lastError    error
+}
+
+// report is an inventory upload; the control plane applies a delta to the
+// inventory identified by Base
+type report struct {
+	Full     bool      `json:"full"`
+	Base     string    `json:"base,omitempty"`
+	Hash     string    `json:"hash"`
+	Packages []Package `json:"packages"`
+	Removed  []Package `json:"removed,omitempty"`
+}
+
+// NewReporter creates a new software inventory reporter
+func NewReporter(collector *Collector, reportURL, token string, interval time.Duration, logger *zap.Logger) *Reporter {
+	return &Reporter{
+		collector: collector,
+		reportURL: reportURL,
+		token:     token,
+		interval:  interval,
+		httpClient: &http.Client{
+			Timeout: 60 * time.Second,
+		},
+		logger: logger,
+		stopCh: make(chan struct{}),
+	}
+}
+
+// SetIdentity sets the key uploads are signed with
+func (r *Reporter) SetIdentity(id *identity.Identity) {
+	r.identity = id
+}
+
+// SetToken replaces the token uploads authenticate with, after the agent
+// refreshed it
+func (r *Reporter) SetToken(token string) {
+	r.mu.Lock()
+	r.token = token
+	r.mu.Unlock()
+}
+
+// Start starts the inventory reporting loop
+func (r *Reporter) Start(ctx context.Context) {
+	if r.reportURL == "" {
+		r.logger.Info("software inventory disabled (no report URL configured)")
+		return
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		// Initial report
+		r.report(ctx)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.stopCh:
+				return
+			case <-ticker.C:
+				r.report(ctx)
+			}
+		}
+	}()
+}
+
+// Stop stops the inventory reporter
+func (r *Reporter) Stop() {
+	close(r.stopCh)
+	r.wg.Wait()
+}
+
+// LastReport returns when the inventory was last acknowledged and the
+// error of the last attempt, if it failed
+func (r *Reporter) LastReport() (time.Time, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lastReport, r.lastError
+}
+
+// report collects the inventory and uploads what changed
+func (r *Reporter) report(ctx context.Context) {
+	packages, err := r.collector.Collect(ctx)
+	if err != nil {
+		r.logger.Error("failed to collect software inventory", zap.Error(err))
+		r.setResult(err)
+		return
+	}
+	hash := Hash(packages)
+
+	r.mu.RLock()
+	lastHash, lastPackages := r.lastHash, r.lastPackages
+	r.mu.RUnlock()
+	if hash == lastHash {
+		r.logger.Debug("software inventory unchanged", zap.Int("packages", len(packages)))
+		r.setResult(nil)
+		return
+	}
+
+	body := &report{Full: lastPackages == nil, Hash: hash, Packages: packages}
+	if !body.Full {
+		body.Base = lastHash
+		body.Packages, body.Removed = delta(lastPackages, packages)
+	}
+
+	err = r.send(ctx, body)
+	if errors.Is(err, errStaleBase) {
+		r.logger.Info("control plane inventory is stale, sending full inventory")
+		body = &report{Full: true, Hash: hash, Packages: packages}
+		err = r.send(ctx, body)
+	}
+	if err != nil {
+		r.logger.Error("failed to send software inventory", zap.Error(err))
+		r.setResult(err)
+		return
+	}
+
+	byKey := make(map[string]Package, len(packages))
+	for _, pkg := range packages {
+		byKey[pkg.key()] = pkg
+	}
+	r.mu.Lock()
+	r.lastHash = hash
+	r.lastPackages = byKey
+	r.mu.Unlock()
+	r.setResult(nil)
+
+	r.logger.Debug("software inventory sent",
+		zap.Bool("full", body.Full),
+		zap.Int("packages", len(packages)),
+		zap.Int("changed", len(body.Packages)),
+		zap.Int("removed", len(body.Removed)))
+}
+
+// delta returns the packages installed or updated and those removed since
+// the last acknowledged inventory
+func delta(last map[string]Package, packages []Package) (changed, removed []Package) {
+	current := make(map[string]bool, len(packages))
+	changed = []Package{}
+	for _, pkg := range packages {
+		key := pkg.key()
+		current[key] = true
+		if previous, ok := last[key]; !ok || previous.Version != pkg.Version {
+			changed = append(changed, pkg)
+		}
+	}
+	for key, pkg := range last {
+		if !current[key] {
+			removed = append(removed, pkg)
+		}
+	}
+	return changed, removed
+}
+
+// send uploads an inventory report
+func (r *Reporter) send(ctx context.Context, body *report) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal software inventory: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.reportURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create software inventory request: %w", err)
+	}
+
+	r.mu.RLock()
+	token := r.token
+	r.mu.RUnlock()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	if r.identity != nil {
+		r.identity.SignRequest(req, payload)
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted:
+		return nil
+	case http.StatusConflict:
+		if !body.Full {
+			return errStaleBase
+		}
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+}
+
+// setResult records the outcome of a report
+func (r *Reporter) setResult(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastError = err
+	if err == nil {
+		r.lastReport = time.Now()
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
 
 	"go.uber.org/zap"
 
@@ -93,6 +94,47 @@ func (c *Configurator) applyUpdates(cfg *config.Config, updates map[string]inter
 	return nil
 }
 
+// ApplyProfile applies the settings of a configuration profile to the
+// configuration file and returns the keys whose values changed. The file
+// is left untouched if a setting is invalid or nothing changed.
+func (c *Configurator) ApplyProfile(settings map[string]interface{}) ([]string, error) {
+	cfg, err := c.loader.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load current config: %w", err)
+	}
+
+	before := cfg.ProfileValues()
+	for key, value := range settings {
+		if err := cfg.SetProfileValue(key, value); err != nil {
+			return nil, fmt.Errorf("invalid setting %s: %w", key, err)
+		}
+	}
+
+	validator := config.NewValidator()
+	if err := validator.Validate(cfg); err != nil {
+		return nil, fmt.Errorf("configuration validation failed: %w", err)
+	}
+
+	var changed []string
+	for key, value := range cfg.ProfileValues() {
+		if fmt.Sprint(value) != fmt.Sprint(before[key]) {
+			changed = append(changed, key)
+		}
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+	sort.Strings(changed)
+
+	if err := c.loader.SaveConfig(cfg, c.configPath); err != nil {
+		return nil, fmt.Errorf("failed to save configuration: %w", err)
+	}
+
+	c.logger.Info("configuration profile applied", zap.Strings("changed", changed))
+
+	return changed, nil
+}
+
 // ConfigureFromEnv applies configuration from environment variables
 func (c *Configurator) ConfigureFromEnv() error {
 	cfg, err := c.loader.Load()
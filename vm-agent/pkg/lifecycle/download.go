@@ -0,0 +1,402 @@
+// Package lifecycle handles agent lifecycle management.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DownloadConfig contains configuration for binary downloads
+type DownloadConfig struct {
+	// ChunkSize is the number of bytes fetched per ranged request
+	ChunkSize int64
+	// MaxRetries is the number of consecutive failed requests tolerated before giving up
+	MaxRetries int
+	// RetryDelay is the initial backoff between retries
+	RetryDelay time.Duration
+	// MaxRetryDelay caps the exponential backoff
+	MaxRetryDelay time.Duration
+	// BandwidthLimit throttles the download in bytes per second (0 = unlimited)
+	BandwidthLimit int64
+	// StallTimeout aborts a request that receives no data for this long
+	StallTimeout time.Duration
+}
+
+// DefaultDownloadConfig returns the default download configuration
+func DefaultDownloadConfig() *DownloadConfig {
+	return &DownloadConfig{
+		ChunkSize:     8 * 1024 * 1024,
+		MaxRetries:    10,
+		RetryDelay:    2 * time.Second,
+		MaxRetryDelay: 2 * time.Minute,
+		StallTimeout:  60 * time.Second,
+	}
+}
+
+// ProgressFunc is called as bytes are written. total is -1 if unknown.
+type ProgressFunc func(downloaded, total int64)
+
+// Downloader performs chunked, resumable HTTP downloads using Range requests.
+// A partially downloaded file is resumed on the next call with the same
+// destination; the server's ETag is kept alongside it so a changed file
+// restarts from scratch instead of being spliced.
+type Downloader struct {
+	cfg        *DownloadConfig
+	httpClient *http.Client
+	logger     *zap.Logger
+}
+
+// NewDownloader creates a new downloader
+func NewDownloader(cfg *DownloadConfig, logger *zap.Logger) *Downloader {
+	defaults := DefaultDownloadConfig()
+	c := *cfg
+	if c.ChunkSize <= 0 {
+		c.ChunkSize = defaults.ChunkSize
+	}
+	if c.MaxRetries <= 0 {
+		c.MaxRetries = defaults.MaxRetries
+	}
+	if c.RetryDelay <= 0 {
+		c.RetryDelay = defaults.RetryDelay
+	}
+	if c.MaxRetryDelay <= 0 {
+		c.MaxRetryDelay = defaults.MaxRetryDelay
+	}
+	if c.StallTimeout <= 0 {
+		c.StallTimeout = defaults.StallTimeout
+	}
+
+	return &Downloader{
+		cfg: &c,
+		httpClient: &http.Client{
+			// No overall timeout: each request is bounded by the chunk size
+			// and the stall timeout instead
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				DialContext: (&net.Dialer{
+					Timeout:   30 * time.Second,
+					KeepAlive: 30 * time.Second,
+				}).DialContext,
+				TLSHandshakeTimeout:   30 * time.Second,
+				ResponseHeaderTimeout: 60 * time.Second,
+				IdleConnTimeout:       90 * time.Second,
+			},
+		},
+		logger: logger,
+	}
+}
+
+// errRestart signals that the partial file is unusable and must be discarded
+var errRestart = errors.New("partial download invalidated")
+
+// permanentError marks an error that should not be retried
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Download downloads url to destPath, resuming any partial file already present
+func (d *Downloader) Download(ctx context.Context, url, destPath string, progress ProgressFunc) error {
+	out, err := os.OpenFile(destPath, os.O_CREATE|os.O_WRONLY, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer out.Close()
+
+	offset, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to seek file: %w", err)
+	}
+
+	etagPath := destPath + ".etag"
+	etag := ""
+	if data, err := os.ReadFile(etagPath); err == nil {
+		etag = strings.TrimSpace(string(data))
+	}
+	if offset > 0 && etag == "" {
+		// Without a validator we cannot prove the partial file matches the server
+		offset = 0
+	}
+	if offset == 0 {
+		if err := out.Truncate(0); err != nil {
+			return fmt.Errorf("failed to truncate file: %w", err)
+		}
+		// Truncating leaves the write position at the old end of the file
+		if _, err := out.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek file: %w", err)
+		}
+		etag = ""
+	} else {
+		d.logger.Info("resuming download", zap.String("url", url), zap.Int64("offset", offset))
+	}
+
+	throttle := newThrottle(d.cfg.BandwidthLimit)
+	total := int64(-1)
+	failures := 0
+	delay := d.cfg.RetryDelay
+
+	for {
+		next, newTotal, newETag, complete, err := d.fetchChunk(ctx, url, out, offset, etag, throttle, progress)
+		if newETag != "" && newETag != etag {
+			etag = newETag
+			os.WriteFile(etagPath, []byte(etag), 0644)
+		}
+		if newTotal >= 0 {
+			total = newTotal
+		}
+		progressed := next > offset
+		offset = next
+
+		if errors.Is(err, errRestart) {
+			d.logger.Warn("remote file changed, restarting download", zap.String("url", url))
+			if err := out.Truncate(0); err != nil {
+				return fmt.Errorf("failed to truncate file: %w", err)
+			}
+			if _, err := out.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("failed to seek file: %w", err)
+			}
+			offset, etag = 0, ""
+			os.Remove(etagPath)
+			continue
+		}
+
+		if err == nil {
+			failures = 0
+			delay = d.cfg.RetryDelay
+			if complete || (total >= 0 && offset >= total) {
+				break
+			}
+			continue
+		}
+
+		var permErr *permanentError
+		if errors.As(err, &permErr) || ctx.Err() != nil {
+			return err
+		}
+
+		if progressed {
+			failures = 0
+			delay = d.cfg.RetryDelay
+		}
+		failures++
+		if failures > d.cfg.MaxRetries {
+			return fmt.Errorf("download failed after %d retries: %w", d.cfg.MaxRetries, err)
+		}
+
+		d.logger.Warn("download request failed, retrying",
+			zap.String("url", url),
+			zap.Int64("offset", offset),
+			zap.Int("attempt", failures),
+			zap.Duration("backoff", delay),
+			zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > d.cfg.MaxRetryDelay {
+			delay = d.cfg.MaxRetryDelay
+		}
+	}
+
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	os.Remove(etagPath)
+
+	return nil
+}
+
+// fetchChunk requests the next range starting at offset and appends it to out.
+// It returns the new offset, the total size if known, the response ETag and
+// whether the server indicated the download is complete.
+func (d *Downloader) fetchChunk(ctx context.Context, url string, out *os.File, offset int64, etag string, throttle *throttle, progress ProgressFunc) (int64, int64, string, bool, error) {
+	reqCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, url, nil)
+	if err != nil {
+		return offset, -1, "", false, &permanentError{fmt.Errorf("failed to create request: %w", err)}
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+d.cfg.ChunkSize-1))
+	if etag != "" {
+		req.Header.Set("If-Range", etag)
+	}
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return offset, -1, "", false, fmt.Errorf("download request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respETag := resp.Header.Get("ETag")
+	if strings.HasPrefix(respETag, "W/") {
+		// Weak validators cannot be used with If-Range
+		respETag = ""
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, _, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			return offset, -1, "", false, errRestart
+		}
+		written, err := d.copyBody(reqCtx, cancel, out, resp.Body, offset, total, throttle, progress)
+		// With an unknown total, a short chunk marks the end of the file
+		complete := err == nil && total < 0 && written < d.cfg.ChunkSize
+		return offset + written, total, respETag, complete, err
+
+	case http.StatusOK:
+		// Server ignored the range (no range support or the file changed):
+		// the body is the whole file
+		if offset > 0 {
+			if err := out.Truncate(0); err != nil {
+				return offset, -1, "", false, &permanentError{fmt.Errorf("failed to truncate file: %w", err)}
+			}
+			if _, err := out.Seek(0, io.SeekStart); err != nil {
+				return offset, -1, "", false, &permanentError{fmt.Errorf("failed to seek file: %w", err)}
+			}
+		}
+		total := resp.ContentLength
+		written, err := d.copyBody(reqCtx, cancel, out, resp.Body, 0, total, throttle, progress)
+		return written, total, respETag, err == nil, err
+
+	case http.StatusRequestedRangeNotSatisfiable:
+		_, _, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if ok && total == offset {
+			return offset, total, "", true, nil
+		}
+		return offset, -1, "", false, errRestart
+
+	default:
+		err := fmt.Errorf("download failed with status %d", resp.StatusCode)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+			resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+			return offset, -1, "", false, &permanentError{err}
+		}
+		return offset, -1, "", false, err
+	}
+}
+
+// copyBody copies the response body to out, aborting the request if it stalls
+func (d *Downloader) copyBody(ctx context.Context, cancel context.CancelFunc, out *os.File, body io.Reader, offset, total int64, throttle *throttle, progress ProgressFunc) (int64, error) {
+	stall := time.AfterFunc(d.cfg.StallTimeout, cancel)
+	defer stall.Stop()
+
+	buf := make([]byte, 32*1024)
+	var written int64
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			if _, err := out.Write(buf[:n]); err != nil {
+				return written, &permanentError{fmt.Errorf("failed to write file: %w", err)}
+			}
+			written += int64(n)
+			if progress != nil {
+				progress(offset+written, total)
+			}
+			// Throttling sleeps must not count towards the stall timeout
+			stall.Stop()
+			if err := throttle.wait(ctx, n); err != nil {
+				return written, err
+			}
+			stall.Reset(d.cfg.StallTimeout)
+		}
+		if readErr == io.EOF {
+			return written, nil
+		}
+		if readErr != nil {
+			if ctx.Err() != nil {
+				return written, fmt.Errorf("download stalled or cancelled: %w", readErr)
+			}
+			return written, fmt.Errorf("failed to read response: %w", readErr)
+		}
+	}
+}
+
+// parseContentRange parses "bytes start-end/total" or "bytes */total".
+// total is -1 if the server reports it as unknown.
+func parseContentRange(header string) (start, end, total int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes ")
+	if !found {
+		return 0, 0, 0, false
+	}
+	rangePart, totalPart, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, 0, false
+	}
+
+	total = -1
+	if totalPart != "*" {
+		t, err := strconv.ParseInt(totalPart, 10, 64)
+		if err != nil {
+			return 0, 0, 0, false
+		}
+		total = t
+	}
+
+	if rangePart == "*" {
+		return 0, 0, total, true
+	}
+
+	startStr, endStr, found := strings.Cut(rangePart, "-")
+	if !found {
+		return 0, 0, 0, false
+	}
+	s, err1 := strconv.ParseInt(startStr, 10, 64)
+	e, err2 := strconv.ParseInt(endStr, 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, 0, false
+	}
+
+	return s, e, total, true
+}
+
+// throttle limits throughput to a fixed number of bytes per second
+type throttle struct {
+	limit   int64
+	started time.Time
+	bytes   int64
+}
+
+// newThrottle creates a throttle (limit <= 0 disables throttling)
+func newThrottle(limit int64) *throttle {
+	return &throttle{limit: limit, started: time.Now()}
+}
+
+// wait sleeps as needed after n bytes were transferred
+func (t *throttle) wait(ctx context.Context, n int) error {
+	if t.limit <= 0 {
+		return nil
+	}
+
+	t.bytes += int64(n)
+	expected := time.Duration(float64(t.bytes) / float64(t.limit) * float64(time.Second))
+	sleep := expected - time.Since(t.started)
+	if sleep <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(sleep)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
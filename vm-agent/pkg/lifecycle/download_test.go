@@ -0,0 +1,201 @@
+package lifecycle
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header            string
+		start, end, total int64
+		ok                bool
+	}{
+		{"bytes 0-99/1000", 0, 99, 1000, true},
+		{"bytes 100-199/*", 100, 199, -1, true},
+		{"bytes */1000", 0, 0, 1000, true},
+		{"bytes */*", 0, 0, -1, true},
+		{"", 0, 0, 0, false},
+		{"0-99/1000", 0, 0, 0, false},
+		{"bytes 0-99", 0, 0, 0, false},
+		{"bytes 0-99/many", 0, 0, 0, false},
+		{"bytes 0/1000", 0, 0, 0, false},
+		{"bytes a-99/1000", 0, 0, 0, false},
+		{"bytes 0-b/1000", 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			start, end, total, ok := parseContentRange(tt.header)
+			if ok != tt.ok || start != tt.start || end != tt.end || total != tt.total {
+				t.Errorf("parseContentRange(%q) = %d, %d, %d, %v; want %d, %d, %d, %v",
+					tt.header, start, end, total, ok, tt.start, tt.end, tt.total, tt.ok)
+			}
+		})
+	}
+}
+
+// fileServer serves content with Range support, recording the ranges asked
+// for. The first failures requests fail with 503.
+type fileServer struct {
+	mu       sync.Mutex
+	content  []byte
+	etag     string
+	failures int
+	ranges   []string
+	served   int64
+}
+
+func (f *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.ranges = append(f.ranges, r.Header.Get("Range"))
+	if f.failures > 0 {
+		f.failures--
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	content, etag := f.content, f.etag
+	f.mu.Unlock()
+
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	counter := &countingWriter{ResponseWriter: w}
+	http.ServeContent(counter, r, "agent", time.Time{}, bytes.NewReader(content))
+	f.mu.Lock()
+	f.served += counter.n
+	f.mu.Unlock()
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	tests := []struct {
+		name string
+		// partial and partialETag are left by an earlier attempt
+		partial     []byte
+		partialETag string
+		etag        string
+		failures    int
+		// wantServed is the number of body bytes the server sent
+		wantServed   int64
+		wantRequests int
+	}{
+		{
+			name:         "fresh download in chunks",
+			etag:         `"v1"`,
+			wantServed:   1000,
+			wantRequests: 4,
+		},
+		{
+			name:         "resume matching partial file",
+			partial:      content[:600],
+			partialETag:  `"v1"`,
+			etag:         `"v1"`,
+			wantServed:   400,
+			wantRequests: 2,
+		},
+		{
+			name:         "restart when the file changed",
+			partial:      []byte("stale content"),
+			partialETag:  `"v0"`,
+			etag:         `"v1"`,
+			wantServed:   1000,
+			wantRequests: 1,
+		},
+		{
+			name:         "restart partial file without a validator",
+			partial:      content[:600],
+			etag:         `"v1"`,
+			wantServed:   1000,
+			wantRequests: 4,
+		},
+		{
+			name:         "retry failed requests",
+			etag:         `"v1"`,
+			failures:     2,
+			wantServed:   1000,
+			wantRequests: 6,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &fileServer{content: content, etag: tt.etag, failures: tt.failures}
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+
+			dest := filepath.Join(t.TempDir(), "agent")
+			if tt.partial != nil {
+				if err := os.WriteFile(dest, tt.partial, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.partialETag != "" {
+				if err := os.WriteFile(dest+".etag", []byte(tt.partialETag), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			d := NewDownloader(&DownloadConfig{ChunkSize: 300, RetryDelay: time.Millisecond, MaxRetries: 3}, zap.NewNop())
+			var last int64
+			err := d.Download(context.Background(), ts.URL, dest, func(downloaded, total int64) {
+				last = downloaded
+			})
+			if err != nil {
+				t.Fatalf("Download: %v", err)
+			}
+
+			got, err := os.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Fatalf("downloaded %d bytes that differ from the served file", len(got))
+			}
+			if last != int64(len(content)) {
+				t.Errorf("last progress = %d, want %d", last, len(content))
+			}
+			if _, err := os.Stat(dest + ".etag"); !os.IsNotExist(err) {
+				t.Errorf("etag file kept after a complete download")
+			}
+			if server.served != tt.wantServed {
+				t.Errorf("served %d bytes, want %d", server.served, tt.wantServed)
+			}
+			if len(server.ranges) != tt.wantRequests {
+				t.Errorf("made %d requests (%s), want %d", len(server.ranges), strings.Join(server.ranges, ", "), tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestDownloadPermanentFailure(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	d := NewDownloader(&DownloadConfig{RetryDelay: time.Millisecond}, zap.NewNop())
+	err := d.Download(context.Background(), ts.URL, filepath.Join(t.TempDir(), "agent"), nil)
+	if err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("Download = %v, want a 404 error", err)
+	}
+}
@@ -13,20 +13,22 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/yourorg/vm-agent/pkg/config"
+	"github.com/yourorg/vm-agent/pkg/identity"
 )
 
 // Installer handles agent installation
 type Installer struct {
-	logger         *zap.Logger
-	dataDir        string
-	configPath     string
+	logger          *zap.Logger
+	dataDir         string
+	configPath      string
 	controlPlaneURL string
-	httpClient     *http.Client
+	httpClient      *http.Client
 }
 
 // InstallerConfig contains installer configuration
@@ -60,13 +62,13 @@ func (i *Installer) Install(ctx context.Context, opts *InstallOptions) error {
 	}
 
 	// Step 2: Register with control plane
-	token, agentID, err := i.registerAgent(ctx, opts)
+	reg, err := i.registerAgent(ctx, opts)
 	if err != nil {
 		return fmt.Errorf("failed to register agent: %w", err)
 	}
 
 	// Step 3: Generate configuration
-	cfg := i.generateConfig(opts, token, agentID)
+	cfg := i.generateConfig(opts, reg)
 
 	// Step 4: Save configuration
 	loader := config.NewLoader()
@@ -80,7 +82,11 @@ func (i *Installer) Install(ctx context.Context, opts *InstallOptions) error {
 	}
 
 	i.logger.Info("agent installation completed",
-		zap.String("agent_id", agentID))
+		zap.String("agent_id", reg.AgentID))
+	if reg.ApprovalStatus == "pending" {
+		i.logger.Warn("agent is awaiting approval; it receives no executions until a tenant admin approves it",
+			zap.String("agent_id", reg.AgentID))
+	}
 
 	return nil
 }
@@ -114,14 +120,26 @@ func (i *Installer) createDirectories() error {
 	return nil
 }
 
+// registration is the control plane's answer to a registration. Endpoint
+// and DispatchKey are empty from control planes that do not issue them.
+// ApprovalStatus is pending for tenants that approve new agents; the
+// agent runs as usual but receives no executions until approved.
+type registration struct {
+	Token          string `json:"token"`
+	AgentID        string `json:"agent_id"`
+	Endpoint       string `json:"endpoint"`
+	DispatchKey    string `json:"dispatch_key"`
+	ApprovalStatus string `json:"approval_status"`
+}
+
 // registerAgent registers the agent with the control plane
-func (i *Installer) registerAgent(ctx context.Context, opts *InstallOptions) (token string, agentID string, err error) {
+func (i *Installer) registerAgent(ctx context.Context, opts *InstallOptions) (*registration, error) {
 	if opts.ControlPlaneURL == "" {
 		opts.ControlPlaneURL = i.controlPlaneURL
 	}
 
 	if opts.ControlPlaneURL == "" {
-		return "", "", fmt.Errorf("control plane URL not configured")
+		return nil, fmt.Errorf("control plane URL not configured")
 	}
 
 	hostname, _ := os.Hostname()
@@ -129,6 +147,14 @@ func (i *Installer) registerAgent(ctx context.Context, opts *InstallOptions) (to
 		opts.AgentID = hostname
 	}
 
+	// The agent ID is bound to this key; keeping the existing key lets a
+	// reinstall re-register without an identity reset
+	id, err := identity.Load(i.dataDir)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, signature := id.SignEnrollment(opts.AgentID)
+
 	reqBody := map[string]interface{}{
 		"installation_key": opts.InstallationKey,
 		"agent_id":         opts.AgentID,
@@ -136,56 +162,62 @@ func (i *Installer) registerAgent(ctx context.Context, opts *InstallOptions) (to
 		"os":               runtime.GOOS,
 		"arch":             runtime.GOARCH,
 		"tags":             opts.Tags,
+		"public_key":       id.PublicKey(),
+		"timestamp":        timestamp,
+		"signature":        signature,
 	}
 
 	payload, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to marshal registration request: %w", err)
+		return nil, fmt.Errorf("failed to marshal registration request: %w", err)
 	}
 
 	url := fmt.Sprintf("%s/api/v1/agents/register", opts.ControlPlaneURL)
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := i.httpClient.Do(req)
 	if err != nil {
-		return "", "", fmt.Errorf("registration request failed: %w", err)
+		return nil, fmt.Errorf("registration request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
 		body, _ := io.ReadAll(resp.Body)
-		return "", "", fmt.Errorf("registration failed with status %d: %s", resp.StatusCode, string(body))
-	}
-
-	var result struct {
-		Token   string `json:"token"`
-		AgentID string `json:"agent_id"`
+		return nil, fmt.Errorf("registration failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
+	var result registration
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", "", fmt.Errorf("failed to decode registration response: %w", err)
+		return nil, fmt.Errorf("failed to decode registration response: %w", err)
 	}
 
-	return result.Token, result.AgentID, nil
+	return &result, nil
 }
 
 // generateConfig generates the agent configuration
-func (i *Installer) generateConfig(opts *InstallOptions, token, agentID string) *config.Config {
+func (i *Installer) generateConfig(opts *InstallOptions, reg *registration) *config.Config {
+	// Older control planes do not issue endpoints
+	endpoint := reg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("tenant-%s/%s", opts.TenantID, reg.AgentID)
+	}
+
 	cfg := &config.Config{
 		Agent: config.AgentConfig{
-			ID:              agentID,
+			ID:              reg.AgentID,
 			TenantID:        opts.TenantID,
 			ControlPlaneURL: opts.ControlPlaneURL,
-			Token:           token,
+			Token:           reg.Token,
 			DataDir:         i.dataDir,
 		},
 		Piko: config.PikoConfig{
-			ServerURL: opts.PikoServerURL,
-			Endpoint:  fmt.Sprintf("tenant-%s/%s", opts.TenantID, agentID),
+			ServerURL:   opts.PikoServerURL,
+			Endpoint:    endpoint,
+			DispatchKey: reg.DispatchKey,
 			Reconnect: config.ReconnectConfig{
 				InitialDelay: time.Second,
 				MaxDelay:     60 * time.Second,
@@ -198,15 +230,23 @@ func (i *Installer) generateConfig(opts *InstallOptions, token, agentID string)
 			TLSEnabled: false,
 		},
 		Probe: config.ProbeConfig{
-			WorkDir:        filepath.Join(i.dataDir, "work"),
-			DefaultTimeout: 5 * time.Minute,
-			MaxConcurrent:  5,
+			WorkDir:          filepath.Join(i.dataDir, "work"),
+			DefaultTimeout:   5 * time.Minute,
+			MaxConcurrent:    5,
+			ReportURL:        fmt.Sprintf("%s/api/v1/agent/executions/results", opts.ControlPlaneURL),
+			PluginDir:        filepath.Join(i.dataDir, "plugins"),
+			TemplateCacheDir: filepath.Join(i.dataDir, "template-cache"),
 		},
 		Health: config.HealthConfig{
 			CheckInterval:  30 * time.Second,
 			ReportInterval: 5 * time.Minute,
 			ReportURL:      fmt.Sprintf("%s/api/v1/agents/health", opts.ControlPlaneURL),
 		},
+		Inventory: config.InventoryConfig{
+			Enabled:   true,
+			Interval:  6 * time.Hour,
+			ReportURL: fmt.Sprintf("%s/api/v1/agent/software", opts.ControlPlaneURL),
+		},
 		Logging: config.LoggingConfig{
 			Level:  "info",
 			Format: "json",
@@ -214,19 +254,45 @@ func (i *Installer) generateConfig(opts *InstallOptions, token, agentID string)
 		},
 	}
 
+	// Several Piko URLs configure multi-region failover
+	if servers := pikoServers(opts.PikoServerURL); len(servers) > 0 {
+		cfg.Piko.ServerURL = ""
+		cfg.Piko.Servers = servers
+	}
+
+	// Settings left out above would be saved as zero and override the
+	// loader's defaults
+	config.FillDefaults(cfg)
+
 	return cfg
 }
 
+// serviceStatusUnsupported is reported where no service manager integration exists
+const serviceStatusUnsupported = "unsupported"
+
+// launchdLabel is the launchd job label of the agent daemon on macOS
+const launchdLabel = "com.yourorg.vm-agent"
+
+// pikoServers returns the server list for a comma-separated list of Piko
+// URLs, preferred in the order given. A single URL is kept in server_url.
+func pikoServers(urls string) []config.PikoServerConfig {
+	parts := strings.Split(urls, ",")
+	if len(parts) < 2 {
+		return nil
+	}
+
+	servers := make([]config.PikoServerConfig, 0, len(parts))
+	for _, u := range parts {
+		if u = strings.TrimSpace(u); u != "" {
+			servers = append(servers, config.PikoServerConfig{URL: u, Priority: len(servers)})
+		}
+	}
+	return servers
+}
+
 // installService installs the agent as a system service
 func (i *Installer) installService(opts *InstallOptions) error {
-	switch runtime.GOOS {
-	case "linux":
-		return installLinuxService(i.configPath)
-	case "windows":
-		return installWindowsService(i.configPath)
-	default:
-		return fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
-	}
+	return installAgentService(i.configPath)
 }
 
 // VerifyChecksum verifies a file's SHA256 checksum
@@ -252,14 +318,14 @@ func VerifyChecksum(filePath, expectedChecksum string) error {
 
 // GetInstallInfo returns information about the current installation
 type InstallInfo struct {
-	AgentID    string            `json:"agent_id"`
-	TenantID   string            `json:"tenant_id"`
-	Version    string            `json:"version"`
-	DataDir    string            `json:"data_dir"`
-	ConfigPath string            `json:"config_path"`
-	ServiceStatus string         `json:"service_status"`
-	InstalledAt time.Time        `json:"installed_at"`
-	Tags       map[string]string `json:"tags,omitempty"`
+	AgentID       string            `json:"agent_id"`
+	TenantID      string            `json:"tenant_id"`
+	Version       string            `json:"version"`
+	DataDir       string            `json:"data_dir"`
+	ConfigPath    string            `json:"config_path"`
+	ServiceStatus string            `json:"service_status"`
+	InstalledAt   *time.Time        `json:"installed_at,omitempty"`
+	Tags          map[string]string `json:"tags,omitempty"`
 }
 
 // GetInstallInfo returns installation information
@@ -273,15 +339,7 @@ func (i *Installer) GetInstallInfo() (*InstallInfo, error) {
 	}
 
 	// Get service status
-	var serviceStatus string
-	switch runtime.GOOS {
-	case "linux":
-		serviceStatus = getLinuxServiceStatus()
-	case "windows":
-		serviceStatus = getWindowsServiceStatus()
-	default:
-		serviceStatus = "unknown"
-	}
+	serviceStatus := getAgentServiceStatus()
 
 	info := &InstallInfo{
 		AgentID:       cfg.Agent.ID,
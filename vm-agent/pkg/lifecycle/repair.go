@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"runtime"
 	"time"
 
 	"go.uber.org/zap"
@@ -19,6 +18,7 @@ type Repairer struct {
 	logger     *zap.Logger
 	dataDir    string
 	configPath string
+	installKey string
 }
 
 // NewRepairer creates a new repairer
@@ -30,13 +30,19 @@ func NewRepairer(dataDir, configPath string, logger *zap.Logger) *Repairer {
 	}
 }
 
+// SetInstallationKey sets the key used to re-register an agent whose token
+// has expired
+func (r *Repairer) SetInstallationKey(key string) {
+	r.installKey = key
+}
+
 // RepairResult contains repair operation results
 type RepairResult struct {
-	Success       bool                `json:"success"`
-	Issues        []RepairIssue       `json:"issues"`
-	Repaired      []RepairIssue       `json:"repaired"`
-	FailedRepairs []RepairIssue       `json:"failed_repairs,omitempty"`
-	Duration      time.Duration       `json:"duration"`
+	Success       bool          `json:"success"`
+	Issues        []RepairIssue `json:"issues"`
+	Repaired      []RepairIssue `json:"repaired"`
+	FailedRepairs []RepairIssue `json:"failed_repairs,omitempty"`
+	DurationMs    int64         `json:"duration_ms"`
 }
 
 // RepairIssue represents a detected issue
@@ -61,6 +67,9 @@ func (r *Repairer) Diagnose(ctx context.Context) (*RepairResult, error) {
 	// Check configuration
 	r.checkConfiguration(result)
 
+	// Check token
+	r.checkToken(result)
+
 	// Check service
 	r.checkService(result)
 
@@ -98,13 +107,16 @@ func (r *Repairer) Repair(ctx context.Context) (*RepairResult, error) {
 	// Check and repair configuration
 	r.repairConfiguration(result)
 
+	// Check and repair token
+	r.repairToken(ctx, result)
+
 	// Check and repair service
 	r.repairService(result)
 
 	// Check and repair permissions
 	r.repairPermissions(result)
 
-	result.Duration = time.Since(startTime)
+	result.DurationMs = time.Since(startTime).Milliseconds()
 
 	// Determine overall success
 	for _, issue := range result.FailedRepairs {
@@ -189,6 +201,16 @@ func (r *Repairer) checkConfiguration(result *RepairResult) {
 			Severity:    "critical",
 		})
 	}
+
+	// Unknown and deprecated keys still load, but may not mean what the
+	// file says
+	for _, w := range loader.Warnings() {
+		result.Issues = append(result.Issues, RepairIssue{
+			Type:        "config_warning",
+			Description: w.String(),
+			Severity:    "warning",
+		})
+	}
 }
 
 // repairConfiguration attempts to repair configuration issues
@@ -236,15 +258,108 @@ func (r *Repairer) repairConfiguration(result *RepairResult) {
 	copyFile(r.configPath, backupPath)
 }
 
+// checkToken checks the agent token has not expired
+func (r *Repairer) checkToken(result *RepairResult) {
+	loader := config.NewLoader()
+	loader.SetConfigPath(r.configPath)
+
+	cfg, err := loader.Load()
+	if err != nil {
+		return
+	}
+
+	if issue := tokenIssue(cfg, time.Now()); issue != nil {
+		result.Issues = append(result.Issues, *issue)
+	}
+}
+
+// repairToken re-registers an agent whose token is missing or expired
+// using the installation key, which issues a new token for the same agent
+func (r *Repairer) repairToken(ctx context.Context, result *RepairResult) {
+	loader := config.NewLoader()
+	loader.SetConfigPath(r.configPath)
+
+	cfg, err := loader.Load()
+	if err != nil {
+		return
+	}
+
+	issue := tokenIssue(cfg, time.Now())
+	if issue == nil {
+		return
+	}
+	if issue.Severity != "critical" {
+		result.Issues = append(result.Issues, *issue)
+		return
+	}
+
+	if r.installKey == "" {
+		issue.Error = "an installation key is required to re-register: vm-agent repair --key <installation key>"
+		result.FailedRepairs = append(result.FailedRepairs, *issue)
+		result.Issues = append(result.Issues, *issue)
+		return
+	}
+
+	issue.Description = "Re-registering agent with the installation key"
+	if err := r.reregister(ctx, loader, cfg); err != nil {
+		issue.Error = err.Error()
+		result.FailedRepairs = append(result.FailedRepairs, *issue)
+	} else {
+		issue.Repaired = true
+		result.Repaired = append(result.Repaired, *issue)
+	}
+	result.Issues = append(result.Issues, *issue)
+}
+
+// reregister registers the agent again under its existing ID and identity,
+// saves the new credentials and restarts a running service to load them
+func (r *Repairer) reregister(ctx context.Context, loader *config.Loader, cfg *config.Config) error {
+	installer := NewInstaller(&InstallerConfig{
+		DataDir:         r.dataDir,
+		ConfigPath:      r.configPath,
+		ControlPlaneURL: cfg.Agent.ControlPlaneURL,
+	}, r.logger)
+
+	reg, err := installer.registerAgent(ctx, &InstallOptions{
+		TenantID:        cfg.Agent.TenantID,
+		InstallationKey: r.installKey,
+		ControlPlaneURL: cfg.Agent.ControlPlaneURL,
+		AgentID:         cfg.Agent.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	cfg.Agent.Token = reg.Token
+	if reg.DispatchKey != "" {
+		cfg.Piko.DispatchKey = reg.DispatchKey
+	}
+	if reg.Endpoint != "" {
+		cfg.Piko.Endpoint = reg.Endpoint
+	}
+	if err := loader.SaveConfig(cfg, r.configPath); err != nil {
+		return fmt.Errorf("failed to save configuration: %w", err)
+	}
+
+	r.logger.Info("agent re-registered", zap.String("agent_id", cfg.Agent.ID))
+
+	status := getAgentServiceStatus()
+	if status != "running" && status != "active" {
+		return nil
+	}
+	if err := stopAgentService(); err != nil {
+		return fmt.Errorf("failed to stop service: %w", err)
+	}
+	if err := startAgentService(); err != nil {
+		return fmt.Errorf("failed to start service: %w", err)
+	}
+	return nil
+}
+
 // checkService checks service status
 func (r *Repairer) checkService(result *RepairResult) {
-	var status string
-	switch runtime.GOOS {
-	case "linux":
-		status = getLinuxServiceStatus()
-	case "windows":
-		status = getWindowsServiceStatus()
-	default:
+	status := getAgentServiceStatus()
+	if status == serviceStatusUnsupported {
 		return
 	}
 
@@ -259,13 +374,8 @@ func (r *Repairer) checkService(result *RepairResult) {
 
 // repairService attempts to repair service issues
 func (r *Repairer) repairService(result *RepairResult) {
-	var status string
-	switch runtime.GOOS {
-	case "linux":
-		status = getLinuxServiceStatus()
-	case "windows":
-		status = getWindowsServiceStatus()
-	default:
+	status := getAgentServiceStatus()
+	if status == serviceStatusUnsupported {
 		return
 	}
 
@@ -276,15 +386,7 @@ func (r *Repairer) repairService(result *RepairResult) {
 			Severity:    "warning",
 		}
 
-		var err error
-		switch runtime.GOOS {
-		case "linux":
-			err = startLinuxService()
-		case "windows":
-			err = startWindowsService()
-		}
-
-		if err != nil {
+		if err := startAgentService(); err != nil {
 			issue.Error = err.Error()
 			result.FailedRepairs = append(result.FailedRepairs, issue)
 		} else {
@@ -0,0 +1,230 @@
+//go:build darwin
+
+// Package lifecycle handles agent lifecycle management.
+package lifecycle
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/yourorg/vm-agent/pkg/config"
+)
+
+const launchdPlistTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>%s</string>
+		<string>run</string>
+		<string>--config</string>
+		<string>%s</string>
+		<string>--data-dir</string>
+		<string>%s</string>
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>ThrottleInterval</key>
+	<integer>5</integer>
+	<key>StandardOutPath</key>
+	<string>%s</string>
+	<key>StandardErrorPath</key>
+	<string>%s</string>
+</dict>
+</plist>
+`
+
+const launchdPlistPath = "/Library/LaunchDaemons/" + launchdLabel + ".plist"
+
+// launchdLogDir is where launchd writes the agent's stdout and stderr
+const launchdLogDir = "/Library/Logs/vm-agent"
+
+// launchdTarget is the launchctl service target for the agent daemon
+const launchdTarget = "system/" + launchdLabel
+
+// installAgentService installs the agent as a launchd daemon
+func installAgentService(configPath string) error {
+	return installDarwinService(configPath)
+}
+
+// getAgentServiceStatus returns the service status
+func getAgentServiceStatus() string {
+	return getDarwinServiceStatus()
+}
+
+// startAgentService starts the agent service
+func startAgentService() error {
+	return startDarwinService()
+}
+
+// stopAgentService stops the agent service
+func stopAgentService() error {
+	return stopDarwinService()
+}
+
+// removeAgentService removes the agent service
+func removeAgentService() error {
+	return removeDarwinService()
+}
+
+// installDarwinService writes the launchd plist and loads the daemon
+func installDarwinService(configPath string) error {
+	if err := os.MkdirAll(launchdLogDir, 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	content := fmt.Sprintf(launchdPlistTemplate,
+		launchdLabel,
+		plistEscape(config.DefaultBinaryPath()),
+		plistEscape(configPath),
+		plistEscape(config.DefaultDataDir()),
+		plistEscape(filepath.Join(launchdLogDir, "agent.out.log")),
+		plistEscape(filepath.Join(launchdLogDir, "agent.err.log")),
+	)
+
+	// launchd refuses plists that are group or world writable
+	if err := os.WriteFile(launchdPlistPath, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write launchd plist: %w", err)
+	}
+
+	// Reload if a previous definition is loaded
+	if darwinServiceLoaded() {
+		exec.Command("launchctl", "bootout", launchdTarget).Run()
+	}
+
+	if out, err := exec.Command("launchctl", "bootstrap", "system", launchdPlistPath).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to load launchd daemon: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	if err := exec.Command("launchctl", "enable", launchdTarget).Run(); err != nil {
+		return fmt.Errorf("failed to enable launchd daemon: %w", err)
+	}
+
+	return nil
+}
+
+// darwinServiceLoaded reports whether the daemon is loaded into launchd
+func darwinServiceLoaded() bool {
+	return exec.Command("launchctl", "print", launchdTarget).Run() == nil
+}
+
+// getDarwinServiceStatus returns the service status
+func getDarwinServiceStatus() string {
+	if _, err := os.Stat(launchdPlistPath); os.IsNotExist(err) {
+		return "not_installed"
+	}
+
+	output, err := exec.Command("launchctl", "print", launchdTarget).Output()
+	if err != nil {
+		// Installed but not loaded
+		return "stopped"
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if state, ok := strings.CutPrefix(line, "state = "); ok {
+			switch state {
+			case "running":
+				return "running"
+			case "not running", "exited":
+				return "stopped"
+			default:
+				return state
+			}
+		}
+	}
+
+	return "unknown"
+}
+
+// startDarwinService loads the daemon if needed and starts it
+func startDarwinService() error {
+	if !darwinServiceLoaded() {
+		if out, err := exec.Command("launchctl", "bootstrap", "system", launchdPlistPath).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to load launchd daemon: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+	return exec.Command("launchctl", "kickstart", launchdTarget).Run()
+}
+
+// stopDarwinService stops the daemon. KeepAlive would restart a killed
+// process, so the daemon is unloaded; the plist stays so it loads at boot.
+func stopDarwinService() error {
+	if !darwinServiceLoaded() {
+		return nil
+	}
+	return exec.Command("launchctl", "bootout", launchdTarget).Run()
+}
+
+// removeDarwinService unloads the daemon and removes its plist
+func removeDarwinService() error {
+	if darwinServiceLoaded() {
+		exec.Command("launchctl", "bootout", launchdTarget).Run()
+	}
+
+	if err := os.Remove(launchdPlistPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove launchd plist: %w", err)
+	}
+
+	return nil
+}
+
+// plistEscape escapes a value for inclusion in a plist <string>
+func plistEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// startWatchdogProcess launches the upgrade watchdog in its own session so
+// launchd does not kill it along with the daemon's process group
+func startWatchdogProcess(cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// removeFileDeferred removes a file; running binaries can be unlinked on macOS
+func removeFileDeferred(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// removeInstallDir removes the launchd log directory; the binary lives in /usr/local/bin
+func removeInstallDir() error {
+	return os.RemoveAll(launchdLogDir)
+}
+
+// IsWindowsService returns true if running as a Windows service
+func IsWindowsService() bool {
+	return false
+}
+
+// RunAsService runs the agent as a Windows service
+func RunAsService(run func() error, stop func()) error {
+	return fmt.Errorf("not running under the Windows service control manager")
+}
+
+// getDarwinServiceLogs returns recent service logs
+func getDarwinServiceLogs(lines int) (string, error) {
+	output, err := exec.Command("tail", "-n", fmt.Sprintf("%d", lines), filepath.Join(launchdLogDir, "agent.out.log")).Output()
+	if err != nil {
+		return "", err
+	}
+	return string(output), nil
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"syscall"
 )
 
 const systemdServiceTemplate = `[Unit]
@@ -37,6 +38,21 @@ WantedBy=multi-user.target
 
 const systemdServicePath = "/etc/systemd/system/vm-agent.service"
 
+// installAgentService installs the agent as a systemd service
+func installAgentService(configPath string) error {
+	return installLinuxService(configPath)
+}
+
+// getAgentServiceStatus returns the service status
+func getAgentServiceStatus() string {
+	return getLinuxServiceStatus()
+}
+
+// removeAgentService removes the agent service
+func removeAgentService() error {
+	return removeLinuxService()
+}
+
 // installLinuxService installs the systemd service
 func installLinuxService(configPath string) error {
 	// Generate service file content
@@ -117,6 +133,55 @@ func disableLinuxService() error {
 	return exec.Command("systemctl", "disable", "vm-agent").Run()
 }
 
+// startWatchdogProcess launches the upgrade watchdog outside the service's
+// cgroup so that restarting (or stopping) vm-agent does not kill it
+func startWatchdogProcess(cmd *exec.Cmd) error {
+	if systemdRun, err := exec.LookPath("systemd-run"); err == nil {
+		args := []string{"--unit", "vm-agent-upgrade-watchdog", "--collect", "--quiet", "--", cmd.Path}
+		args = append(args, cmd.Args[1:]...)
+		return exec.Command(systemdRun, args...).Run()
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// stopAgentService stops the agent service
+func stopAgentService() error {
+	return stopLinuxService()
+}
+
+// startAgentService starts the agent service
+func startAgentService() error {
+	return startLinuxService()
+}
+
+// removeFileDeferred removes a file; running binaries can be unlinked on Linux
+func removeFileDeferred(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// removeInstallDir is a no-op on Linux, where the binary is installed directly in /usr/local/bin
+func removeInstallDir() error {
+	return nil
+}
+
+// IsWindowsService returns true if running as a Windows service
+func IsWindowsService() bool {
+	return false
+}
+
+// RunAsService runs the agent as a Windows service
+func RunAsService(run func() error, stop func()) error {
+	return fmt.Errorf("not running under the Windows service control manager")
+}
+
 // getLinuxServiceLogs returns recent service logs
 func getLinuxServiceLogs(lines int) (string, error) {
 	output, err := exec.Command("journalctl", "-u", "vm-agent", "-n", fmt.Sprintf("%d", lines), "--no-pager").Output()
@@ -0,0 +1,70 @@
+//go:build !linux && !windows && !darwin
+
+// Package lifecycle handles agent lifecycle management.
+package lifecycle
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// errUnsupportedOS is returned for service operations on unsupported platforms
+var errUnsupportedOS = fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
+
+// installAgentService installs the agent as a system service
+func installAgentService(configPath string) error {
+	return errUnsupportedOS
+}
+
+// getAgentServiceStatus returns the service status
+func getAgentServiceStatus() string {
+	return serviceStatusUnsupported
+}
+
+// startAgentService starts the agent service
+func startAgentService() error {
+	return errUnsupportedOS
+}
+
+// stopAgentService stops the agent service
+func stopAgentService() error {
+	return errUnsupportedOS
+}
+
+// removeAgentService removes the agent service
+func removeAgentService() error {
+	return errUnsupportedOS
+}
+
+// startWatchdogProcess launches the upgrade watchdog
+func startWatchdogProcess(cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// removeFileDeferred removes a file
+func removeFileDeferred(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// removeInstallDir is a no-op on platforms without a dedicated install directory
+func removeInstallDir() error {
+	return nil
+}
+
+// IsWindowsService returns true if running as a Windows service
+func IsWindowsService() bool {
+	return false
+}
+
+// RunAsService runs the agent as a Windows service
+func RunAsService(run func() error, stop func()) error {
+	return fmt.Errorf("not running under the Windows service control manager")
+}
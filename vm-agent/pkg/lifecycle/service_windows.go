@@ -5,23 +5,73 @@ package lifecycle
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
+	"golang.org/x/sys/windows"
 	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
 	"golang.org/x/sys/windows/svc/mgr"
+
+	"github.com/yourorg/vm-agent/pkg/config"
 )
 
 const serviceName = "vm-agent"
 const serviceDisplayName = "VM Agent"
 const serviceDescription = "Multi-Tenant VM Management Agent"
 
-// installWindowsService installs the Windows service
+// Event IDs written to the Application event log
+const (
+	eventIDStarted uint32 = 1
+	eventIDStopped uint32 = 2
+	eventIDFailed  uint32 = 3
+)
+
+// recoveryResetPeriod is the time in seconds after which the failure count resets
+const recoveryResetPeriod = 86400
+
+// installAgentService installs the agent as a Windows service
+func installAgentService(configPath string) error {
+	return installWindowsService(configPath)
+}
+
+// getAgentServiceStatus returns the service status
+func getAgentServiceStatus() string {
+	return getWindowsServiceStatus()
+}
+
+// startAgentService starts the agent service
+func startAgentService() error {
+	return startWindowsService()
+}
+
+// stopAgentService stops the agent service
+func stopAgentService() error {
+	return stopWindowsService()
+}
+
+// removeAgentService removes the agent service
+func removeAgentService() error {
+	return removeWindowsService()
+}
+
+// installWindowsService installs the Windows service. The binary is copied to
+// Program Files, the event log source is registered and recovery actions are
+// configured so the service manager restarts the agent on failure. Installing
+// over an existing service updates its configuration.
 func installWindowsService(configPath string) error {
-	exePath, err := os.Executable()
+	exePath, err := installWindowsBinary()
 	if err != nil {
-		return fmt.Errorf("failed to get executable path: %w", err)
+		return err
+	}
+
+	if err := installEventLogSource(); err != nil {
+		return err
 	}
 
 	m, err := mgr.Connect()
@@ -30,24 +80,29 @@ func installWindowsService(configPath string) error {
 	}
 	defer m.Disconnect()
 
-	// Check if service already exists
-	s, err := m.OpenService(serviceName)
-	if err == nil {
-		s.Close()
-		return fmt.Errorf("service already exists")
-	}
-
-	// Create service
-	config := mgr.Config{
-		DisplayName:  serviceDisplayName,
-		Description:  serviceDescription,
-		StartType:    mgr.StartAutomatic,
-		ErrorControl: mgr.ErrorNormal,
+	serviceConfig := mgr.Config{
+		DisplayName:      serviceDisplayName,
+		Description:      serviceDescription,
+		StartType:        mgr.StartAutomatic,
+		ErrorControl:     mgr.ErrorNormal,
+		DelayedAutoStart: true,
 	}
+	args := []string{"run", "--config", configPath, "--data-dir", config.DefaultDataDir()}
 
-	s, err = m.CreateService(serviceName, exePath, config, "run", "--config", configPath)
-	if err != nil {
-		return fmt.Errorf("failed to create service: %w", err)
+	s, err := m.OpenService(serviceName)
+	if err == nil {
+		// Service already exists; refresh its command line and settings
+		serviceConfig.ServiceType = windows.SERVICE_WIN32_OWN_PROCESS
+		serviceConfig.BinaryPathName = windowsCommandLine(exePath, args)
+		if err := s.UpdateConfig(serviceConfig); err != nil {
+			s.Close()
+			return fmt.Errorf("failed to update service: %w", err)
+		}
+	} else {
+		s, err = m.CreateService(serviceName, exePath, serviceConfig, args...)
+		if err != nil {
+			return fmt.Errorf("failed to create service: %w", err)
+		}
 	}
 	defer s.Close()
 
@@ -57,11 +112,23 @@ func installWindowsService(configPath string) error {
 		{Type: mgr.ServiceRestart, Delay: 10 * time.Second},
 		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
 	}
-	if err := s.SetRecoveryActions(recoveryActions, 86400); err != nil {
-		// Non-fatal error
+	if err := s.SetRecoveryActions(recoveryActions, recoveryResetPeriod); err != nil {
+		return fmt.Errorf("failed to set recovery actions: %w", err)
+	}
+
+	// Also restart when the agent exits with an error rather than crashing
+	if err := s.SetRecoveryActionsOnNonCrashFailures(true); err != nil {
+		return fmt.Errorf("failed to enable recovery on non-crash failures: %w", err)
 	}
 
 	// Start service
+	status, err := s.Query()
+	if err != nil {
+		return fmt.Errorf("failed to query service status: %w", err)
+	}
+	if status.State == svc.Running {
+		return nil
+	}
 	if err := s.Start(); err != nil {
 		return fmt.Errorf("failed to start service: %w", err)
 	}
@@ -69,6 +136,60 @@ func installWindowsService(configPath string) error {
 	return nil
 }
 
+// installWindowsBinary copies the running executable to Program Files and
+// returns the installed path
+func installWindowsBinary() (string, error) {
+	exePath, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to get executable path: %w", err)
+	}
+
+	targetPath := config.DefaultBinaryPath()
+	if strings.EqualFold(filepath.Clean(exePath), filepath.Clean(targetPath)) {
+		return targetPath, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create install directory: %w", err)
+	}
+
+	src, err := os.Open(exePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open executable: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create installed binary: %w", err)
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return "", fmt.Errorf("failed to copy binary: %w", err)
+	}
+
+	return targetPath, nil
+}
+
+// windowsCommandLine builds a quoted service command line
+func windowsCommandLine(exePath string, args []string) string {
+	parts := []string{syscall.EscapeArg(exePath)}
+	for _, arg := range args {
+		parts = append(parts, syscall.EscapeArg(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// installEventLogSource registers the agent as an Application event log source
+func installEventLogSource() error {
+	err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info)
+	if err != nil && !strings.Contains(err.Error(), "registry key already exists") {
+		return fmt.Errorf("failed to register event log source: %w", err)
+	}
+	return nil
+}
+
 // getWindowsServiceStatus returns the service status
 func getWindowsServiceStatus() string {
 	m, err := mgr.Connect()
@@ -135,15 +256,30 @@ func stopWindowsService() error {
 	}
 	defer s.Close()
 
-	status, err := s.Control(svc.Stop)
+	return stopAndWait(s, 30*time.Second)
+}
+
+// stopAndWait stops a service and waits until it reports stopped
+func stopAndWait(s *mgr.Service, timeout time.Duration) error {
+	status, err := s.Query()
 	if err != nil {
-		return fmt.Errorf("failed to stop service: %w", err)
+		return fmt.Errorf("failed to query service status: %w", err)
+	}
+	if status.State == svc.Stopped {
+		return nil
+	}
+
+	if status.State != svc.StopPending {
+		status, err = s.Control(svc.Stop)
+		if err != nil {
+			return fmt.Errorf("failed to stop service: %w", err)
+		}
 	}
 
 	// Wait for service to stop
-	timeout := time.Now().Add(30 * time.Second)
+	deadline := time.Now().Add(timeout)
 	for status.State != svc.Stopped {
-		if time.Now().After(timeout) {
+		if time.Now().After(deadline) {
 			return fmt.Errorf("timeout waiting for service to stop")
 		}
 		time.Sleep(500 * time.Millisecond)
@@ -156,7 +292,20 @@ func stopWindowsService() error {
 	return nil
 }
 
-// removeWindowsService removes the Windows service
+// startWatchdogProcess launches the upgrade watchdog detached from the
+// service process so that stopping vm-agent does not terminate it
+func startWatchdogProcess(cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: windows.DETACHED_PROCESS | syscall.CREATE_NEW_PROCESS_GROUP,
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// removeWindowsService stops and deletes the Windows service and removes its
+// event log source
 func removeWindowsService() error {
 	m, err := mgr.Connect()
 	if err != nil {
@@ -170,21 +319,69 @@ func removeWindowsService() error {
 	}
 	defer s.Close()
 
-	// Stop if running
-	status, _ := s.Query()
-	if status.State == svc.Running {
-		s.Control(svc.Stop)
-		time.Sleep(2 * time.Second)
+	// Stop first; deleting a running service only marks it for deletion
+	if err := stopAndWait(s, 30*time.Second); err != nil {
+		return err
 	}
 
 	// Delete service
-	return s.Delete()
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to delete service: %w", err)
+	}
+
+	if err := eventlog.Remove(serviceName); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove event log source: %w", err)
+	}
+
+	return nil
+}
+
+// removeFileDeferred removes a file, scheduling deletion at the next reboot
+// if it is locked (e.g. the running executable)
+func removeFileDeferred(path string) error {
+	err := os.Remove(path)
+	if err == nil || os.IsNotExist(err) {
+		return nil
+	}
+
+	pathPtr, convErr := windows.UTF16PtrFromString(path)
+	if convErr != nil {
+		return err
+	}
+	if moveErr := windows.MoveFileEx(pathPtr, nil, windows.MOVEFILE_DELAY_UNTIL_REBOOT); moveErr != nil {
+		return fmt.Errorf("failed to remove %s: %v; failed to schedule removal: %w", path, err, moveErr)
+	}
+
+	return nil
+}
+
+// removeInstallDir removes the Program Files install directory, deferring
+// files that are still in use until reboot
+func removeInstallDir() error {
+	dir := filepath.Dir(config.DefaultBinaryPath())
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := removeFileDeferred(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return removeFileDeferred(dir)
 }
 
 // WindowsService implements the Windows service interface
 type WindowsService struct {
-	stopCh chan struct{}
-	run    func() error
+	run  func() error
+	stop func()
+	elog *eventlog.Log
 }
 
 // Execute implements svc.Handler
@@ -194,23 +391,32 @@ func (ws *WindowsService) Execute(args []string, r <-chan svc.ChangeRequest, cha
 	changes <- svc.Status{State: svc.StartPending}
 
 	// Start the actual service
+	errCh := make(chan error, 1)
 	go func() {
-		if err := ws.run(); err != nil {
-			// Log error
-		}
+		errCh <- ws.run()
 	}()
 
 	changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
+	ws.logEvent(eventlog.Info, eventIDStarted, "vm-agent service started")
 
 	for {
 		select {
+		case err := <-errCh:
+			// The agent exited on its own; a non-zero exit code triggers recovery
+			if err != nil {
+				ws.logEvent(eventlog.Error, eventIDFailed, fmt.Sprintf("vm-agent service failed: %v", err))
+				return false, 1
+			}
+			ws.logEvent(eventlog.Info, eventIDStopped, "vm-agent service stopped")
+			return false, 0
 		case c := <-r:
 			switch c.Cmd {
 			case svc.Interrogate:
 				changes <- c.CurrentStatus
 			case svc.Stop, svc.Shutdown:
 				changes <- svc.Status{State: svc.StopPending}
-				close(ws.stopCh)
+				ws.stop()
+				ws.logEvent(eventlog.Info, eventIDStopped, "vm-agent service stopped")
 				return false, 0
 			default:
 				// Unexpected control request
@@ -219,11 +425,33 @@ func (ws *WindowsService) Execute(args []string, r <-chan svc.ChangeRequest, cha
 	}
 }
 
-// RunAsService runs the agent as a Windows service
-func RunAsService(run func() error) error {
+// logEvent writes to the Application event log if the source is available
+func (ws *WindowsService) logEvent(etype uint32, eid uint32, msg string) {
+	if ws.elog == nil {
+		return
+	}
+
+	switch etype {
+	case eventlog.Error:
+		ws.elog.Error(eid, msg)
+	case eventlog.Warning:
+		ws.elog.Warning(eid, msg)
+	default:
+		ws.elog.Info(eid, msg)
+	}
+}
+
+// RunAsService runs the agent as a Windows service. stop is called when the
+// service control manager asks the service to stop.
+func RunAsService(run func() error, stop func()) error {
 	ws := &WindowsService{
-		stopCh: make(chan struct{}),
-		run:    run,
+		run:  run,
+		stop: stop,
+	}
+
+	if elog, err := eventlog.Open(serviceName); err == nil {
+		ws.elog = elog
+		defer elog.Close()
 	}
 
 	return svc.Run(serviceName, ws)
@@ -0,0 +1,143 @@
+package lifecycle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/yourorg/vm-agent/pkg/config"
+	"github.com/yourorg/vm-agent/pkg/identity"
+)
+
+// reregisterHint tells operators how to recover an agent whose token the
+// control plane no longer accepts
+const reregisterHint = "re-register with an installation key: vm-agent repair --key <installation key>"
+
+// ErrTokenRejected is returned when the control plane refuses the agent's
+// token, so it cannot be refreshed
+var ErrTokenRejected = errors.New("agent token rejected by the control plane; " + reregisterHint)
+
+// TokenExpiry returns when an agent token expires, or the zero time if it
+// does not. The signature is not checked; only the control plane can.
+func TokenExpiry(token string) (time.Time, error) {
+	var claims jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse agent token: %w", err)
+	}
+	if claims.ExpiresAt == nil {
+		return time.Time{}, nil
+	}
+	return claims.ExpiresAt.Time, nil
+}
+
+// RefreshedToken is a token the control plane issued in exchange for the
+// agent's current one
+type RefreshedToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RefreshToken exchanges the agent's token for a new one before it expires.
+// Requests are signed with the agent's identity like all agent requests.
+func RefreshToken(ctx context.Context, client *http.Client, controlPlaneURL, token string, id *identity.Identity) (*RefreshedToken, error) {
+	if controlPlaneURL == "" {
+		return nil, fmt.Errorf("control plane URL not configured")
+	}
+	body := []byte("{}")
+	url := strings.TrimRight(controlPlaneURL, "/") + "/api/v1/agent/token/refresh"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	if id != nil {
+		id.SignRequest(req, body)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token refresh request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, ErrTokenRejected
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("token refresh failed with status %d: %s", resp.StatusCode, string(msg))
+	}
+
+	var refreshed RefreshedToken
+	if err := json.NewDecoder(resp.Body).Decode(&refreshed); err != nil {
+		return nil, fmt.Errorf("failed to decode token refresh response: %w", err)
+	}
+	if refreshed.Token == "" {
+		return nil, fmt.Errorf("token refresh response carries no token")
+	}
+	return &refreshed, nil
+}
+
+// tokenIssue returns the problem with the configured agent token, if any.
+// Tokens past expiry by more than the leeway need re-registration; tokens
+// expiring soon are refreshed by the running agent.
+func tokenIssue(cfg *config.Config, now time.Time) *RepairIssue {
+	if cfg.Agent.Token == "" {
+		return &RepairIssue{
+			Type:        "missing_token",
+			Description: "No agent token configured; " + reregisterHint,
+			Severity:    "critical",
+		}
+	}
+	expiry, err := TokenExpiry(cfg.Agent.Token)
+	if err != nil {
+		return &RepairIssue{
+			Type:        "invalid_token",
+			Description: fmt.Sprintf("Agent token is invalid (%v); %s", err, reregisterHint),
+			Severity:    "critical",
+		}
+	}
+	if expiry.IsZero() {
+		return nil
+	}
+
+	leeway, refreshBefore := cfg.Agent.TokenTiming()
+	switch {
+	case now.After(expiry.Add(leeway)):
+		return &RepairIssue{
+			Type:        "token_expired",
+			Description: fmt.Sprintf("Agent token expired at %s; %s", expiry.UTC().Format(time.RFC3339), reregisterHint),
+			Severity:    "critical",
+		}
+	case expiry.Sub(now) < refreshBefore:
+		return &RepairIssue{
+			Type:        "token_expiring",
+			Description: fmt.Sprintf("Agent token expires at %s; the running agent refreshes it ahead of expiry", expiry.UTC().Format(time.RFC3339)),
+			Severity:    "warning",
+		}
+	}
+	return nil
+}
+
+// SaveToken replaces the agent token in the configuration file
+func (c *Configurator) SaveToken(token string) error {
+	cfg, err := c.loader.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load current config: %w", err)
+	}
+	cfg.Agent.Token = token
+	if err := c.loader.SaveConfig(cfg, c.configPath); err != nil {
+		return fmt.Errorf("failed to save configuration: %w", err)
+	}
+	c.logger.Info("agent token saved")
+	return nil
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"runtime"
 	"time"
 
 	"go.uber.org/zap"
@@ -40,14 +39,14 @@ type UninstallOptions struct {
 
 // UninstallResult contains uninstallation results
 type UninstallResult struct {
-	Success      bool          `json:"success"`
-	StoppedService bool        `json:"stopped_service"`
-	RemovedService bool        `json:"removed_service"`
-	RemovedData    bool        `json:"removed_data"`
-	RemovedConfig  bool        `json:"removed_config"`
-	Deregistered   bool        `json:"deregistered"`
-	Errors       []string      `json:"errors,omitempty"`
-	Duration     time.Duration `json:"duration"`
+	Success        bool     `json:"success"`
+	StoppedService bool     `json:"stopped_service"`
+	RemovedService bool     `json:"removed_service"`
+	RemovedData    bool     `json:"removed_data"`
+	RemovedConfig  bool     `json:"removed_config"`
+	Deregistered   bool     `json:"deregistered"`
+	Errors         []string `json:"errors,omitempty"`
+	DurationMs     int64    `json:"duration_ms"`
 }
 
 // Uninstall performs agent uninstallation
@@ -83,7 +82,12 @@ func (u *Uninstaller) Uninstall(ctx context.Context, opts *UninstallOptions) (*U
 		result.RemovedService = true
 	}
 
-	// Step 4: Remove data directory (if not keeping)
+	// Step 4: Remove binaries left behind by upgrades
+	if err := u.removeUpgradeLeftovers(); err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("failed to remove upgrade leftovers: %v", err))
+	}
+
+	// Step 5: Remove data directory (if not keeping)
 	if !opts.KeepData {
 		if err := u.removeDataDir(opts); err != nil {
 			result.Errors = append(result.Errors, fmt.Sprintf("failed to remove data directory: %v", err))
@@ -92,7 +96,7 @@ func (u *Uninstaller) Uninstall(ctx context.Context, opts *UninstallOptions) (*U
 		}
 	}
 
-	// Step 5: Remove configuration (if not keeping)
+	// Step 6: Remove configuration (if not keeping)
 	if !opts.KeepConfig {
 		if err := os.Remove(u.configPath); err != nil && !os.IsNotExist(err) {
 			result.Errors = append(result.Errors, fmt.Sprintf("failed to remove config: %v", err))
@@ -101,12 +105,13 @@ func (u *Uninstaller) Uninstall(ctx context.Context, opts *UninstallOptions) (*U
 		}
 	}
 
-	result.Duration = time.Since(startTime)
+	duration := time.Since(startTime)
+	result.DurationMs = duration.Milliseconds()
 	result.Success = len(result.Errors) == 0
 
 	u.logger.Info("agent uninstallation completed",
 		zap.Bool("success", result.Success),
-		zap.Duration("duration", result.Duration))
+		zap.Duration("duration", duration))
 
 	return result, nil
 }
@@ -122,26 +127,29 @@ func (u *Uninstaller) deregister(ctx context.Context, opts *UninstallOptions) er
 
 // stopService stops the agent service
 func (u *Uninstaller) stopService() error {
-	switch runtime.GOOS {
-	case "linux":
-		return stopLinuxService()
-	case "windows":
-		return stopWindowsService()
-	default:
-		return fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
-	}
+	return stopAgentService()
 }
 
 // removeService removes the agent service
 func (u *Uninstaller) removeService() error {
-	switch runtime.GOOS {
-	case "linux":
-		return removeLinuxService()
-	case "windows":
-		return removeWindowsService()
-	default:
-		return fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
+	return removeAgentService()
+}
+
+// removeUpgradeLeftovers removes the .old binary kept by Windows upgrades and
+// any rollback copy, deferring removal of locked files until reboot
+func (u *Uninstaller) removeUpgradeLeftovers() error {
+	binaryPath, _ := os.Executable()
+	if binaryPath == "" {
+		return nil
 	}
+
+	for _, path := range []string{binaryPath + ".old", binaryPath + ".rollback"} {
+		if err := removeFileDeferred(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // removeDataDir removes the data directory
@@ -185,16 +193,20 @@ func (u *Uninstaller) Purge(ctx context.Context) error {
 		return fmt.Errorf("purge failed with errors: %v", result.Errors)
 	}
 
-	// Remove binary
+	// Remove binary (the running executable is removed at next reboot on Windows)
 	binaryPath, _ := os.Executable()
 	if binaryPath != "" {
-		if err := os.Remove(binaryPath); err != nil && !os.IsNotExist(err) {
+		if err := removeFileDeferred(binaryPath); err != nil {
 			u.logger.Warn("failed to remove binary",
 				zap.String("path", binaryPath),
 				zap.Error(err))
 		}
 	}
 
+	if err := removeInstallDir(); err != nil {
+		u.logger.Warn("failed to remove install directory", zap.Error(err))
+	}
+
 	return nil
 }
 
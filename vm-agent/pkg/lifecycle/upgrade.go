@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -22,13 +23,15 @@ import (
 
 // Upgrader handles agent self-upgrade
 type Upgrader struct {
-	mu          sync.Mutex
-	logger      *zap.Logger
-	dataDir     string
-	currentBin  string
-	httpClient  *http.Client
-	inProgress  bool
-	lastStatus  *UpgradeStatus
+	mu            sync.Mutex
+	logger        *zap.Logger
+	dataDir       string
+	currentBin    string
+	httpClient    *http.Client
+	downloader    *Downloader
+	verifyTimeout time.Duration
+	inProgress    bool
+	lastStatus    *UpgradeStatus
 }
 
 // UpgradeStatus represents upgrade status
@@ -39,6 +42,15 @@ type UpgradeStatus struct {
 	CompletedAt time.Time `json:"completed_at,omitempty"`
 	Status      string    `json:"status,omitempty"`
 	Error       string    `json:"error,omitempty"`
+
+	// Download progress
+	BytesDownloaded int64   `json:"bytes_downloaded,omitempty"`
+	TotalBytes      int64   `json:"total_bytes,omitempty"`
+	Percent         float64 `json:"percent,omitempty"`
+
+	// Post-upgrade verification
+	VerifyDeadline time.Time       `json:"verify_deadline,omitempty"`
+	LastOutcome    *UpgradeOutcome `json:"last_outcome,omitempty"`
 }
 
 // NewUpgrader creates a new upgrader
@@ -53,7 +65,26 @@ func NewUpgrader(dataDir string, logger *zap.Logger) *Upgrader {
 		httpClient: &http.Client{
 			Timeout: 10 * time.Minute,
 		},
-		lastStatus: &UpgradeStatus{},
+		downloader:    NewDownloader(DefaultDownloadConfig(), logger),
+		verifyTimeout: DefaultVerifyTimeout,
+		lastStatus:    &UpgradeStatus{},
+	}
+}
+
+// SetDownloadConfig configures chunking, retries and throttling for binary downloads
+func (u *Upgrader) SetDownloadConfig(cfg *DownloadConfig) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.downloader = NewDownloader(cfg, u.logger)
+}
+
+// SetVerifyTimeout sets how long the upgraded agent has to report healthy
+// before the watchdog restores the previous binary
+func (u *Upgrader) SetVerifyTimeout(timeout time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if timeout > 0 {
+		u.verifyTimeout = timeout
 	}
 }
 
@@ -64,6 +95,10 @@ func (u *Upgrader) StartUpgrade(version, downloadURL, checksum string) error {
 		u.mu.Unlock()
 		return fmt.Errorf("upgrade already in progress")
 	}
+	if pending, _ := ReadPendingUpgrade(u.dataDir); pending != nil && time.Now().Before(pending.Deadline) {
+		u.mu.Unlock()
+		return fmt.Errorf("verification of upgrade to %s still in progress", pending.ToVersion)
+	}
 	u.inProgress = true
 	u.lastStatus = &UpgradeStatus{
 		InProgress: true,
@@ -97,9 +132,9 @@ func (u *Upgrader) performUpgrade(targetVersion, downloadURL, checksum string) {
 		return
 	}
 
+	// A failed download keeps the partial file so the next attempt resumes it
 	if err := u.downloadBinary(downloadURL, tempPath); err != nil {
 		u.updateStatus("failed", fmt.Sprintf("download failed: %v", err))
-		os.Remove(tempPath)
 		return
 	}
 
@@ -109,6 +144,7 @@ func (u *Upgrader) performUpgrade(targetVersion, downloadURL, checksum string) {
 	if err := u.verifyChecksum(tempPath, checksum); err != nil {
 		u.updateStatus("failed", fmt.Sprintf("checksum verification failed: %v", err))
 		os.Remove(tempPath)
+		os.Remove(tempPath + ".etag")
 		return
 	}
 
@@ -133,47 +169,74 @@ func (u *Upgrader) performUpgrade(targetVersion, downloadURL, checksum string) {
 		return
 	}
 
+	// Step 5: Arm the watchdog that rolls back if the new version never reports healthy
+	u.mu.Lock()
+	verifyTimeout := u.verifyTimeout
+	u.mu.Unlock()
+
+	now := time.Now()
+	pending := &PendingUpgrade{
+		FromVersion: version.Version,
+		ToVersion:   targetVersion,
+		BinaryPath:  u.currentBin,
+		BackupPath:  backupPath,
+		StartedAt:   now,
+		Deadline:    now.Add(verifyTimeout),
+	}
+	if err := u.armWatchdog(pending); err != nil {
+		u.updateStatus("failed", err.Error())
+		u.rollback(backupPath)
+		return
+	}
+
+	u.mu.Lock()
+	u.lastStatus.VerifyDeadline = pending.Deadline
+	u.mu.Unlock()
+
 	u.updateStatus("restarting", "")
 
-	// Step 5: Restart service
+	// Step 6: Restart service
 	if err := u.restartService(); err != nil {
 		u.updateStatus("failed", fmt.Sprintf("service restart failed: %v", err))
 		// Attempt rollback
+		u.disarmWatchdog()
 		u.rollback(backupPath)
 		return
 	}
 
-	// Step 6: Verify new version (would be done by the new process)
-	u.updateStatus("success", "")
-	u.logger.Info("upgrade completed successfully",
+	// Step 7: Verification is completed by the new process and the watchdog
+	u.updateStatus("verifying_health", "")
+	u.logger.Info("upgrade installed, awaiting health verification",
 		zap.String("from_version", version.Version),
-		zap.String("to_version", targetVersion))
+		zap.String("to_version", targetVersion),
+		zap.Time("verify_deadline", pending.Deadline))
 }
 
-// downloadBinary downloads the new binary
+// downloadBinary downloads the new binary, resuming a previous partial download
 func (u *Upgrader) downloadBinary(url, destPath string) error {
-	resp, err := u.httpClient.Get(url)
-	if err != nil {
-		return fmt.Errorf("download request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed with status %d", resp.StatusCode)
-	}
-
-	out, err := os.OpenFile(destPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
-	}
-	defer out.Close()
+	u.mu.Lock()
+	downloader := u.downloader
+	u.mu.Unlock()
 
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
-	}
+	lastLogged := -1
+	return downloader.Download(context.Background(), url, destPath, func(downloaded, total int64) {
+		u.mu.Lock()
+		u.lastStatus.BytesDownloaded = downloaded
+		if total > 0 {
+			u.lastStatus.TotalBytes = total
+			u.lastStatus.Percent = float64(downloaded) * 100 / float64(total)
+		}
+		percent := int(u.lastStatus.Percent)
+		u.mu.Unlock()
 
-	return nil
+		if total > 0 && percent/10 != lastLogged/10 {
+			lastLogged = percent
+			u.logger.Info("upgrade download progress",
+				zap.Int64("bytes_downloaded", downloaded),
+				zap.Int64("total_bytes", total),
+				zap.Int("percent", percent))
+		}
+	})
 }
 
 // verifyChecksum verifies the SHA256 checksum of a file
@@ -248,12 +311,28 @@ func (u *Upgrader) replaceWindowsBinary(newBinPath string) error {
 		return fmt.Errorf("failed to move new binary: %w", err)
 	}
 
-	// Schedule old binary for deletion
-	// (Will be cleaned up on next boot or by cleanup routine)
+	// The old binary is still mapped by this process; it is removed by
+	// CleanupOldBinary on the next start or uninstall
 
 	return nil
 }
 
+// CleanupOldBinary removes the binary left behind by a previous Windows
+// upgrade, scheduling removal at reboot if it is still locked
+func (u *Upgrader) CleanupOldBinary() error {
+	oldPath := u.currentBin + ".old"
+	if _, err := os.Stat(oldPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	if err := removeFileDeferred(oldPath); err != nil {
+		return fmt.Errorf("failed to remove old binary: %w", err)
+	}
+
+	u.logger.Info("removed binary from previous upgrade", zap.String("path", oldPath))
+	return nil
+}
+
 // restartService restarts the agent service
 func (u *Upgrader) restartService() error {
 	switch runtime.GOOS {
@@ -261,6 +340,8 @@ func (u *Upgrader) restartService() error {
 		return u.restartLinuxService()
 	case "windows":
 		return u.restartWindowsService()
+	case "darwin":
+		return u.restartDarwinService()
 	default:
 		return fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
 	}
@@ -272,6 +353,12 @@ func (u *Upgrader) restartLinuxService() error {
 	return cmd.Run()
 }
 
+// restartDarwinService restarts the launchd daemon
+func (u *Upgrader) restartDarwinService() error {
+	cmd := exec.Command("launchctl", "kickstart", "-k", "system/"+launchdLabel)
+	return cmd.Run()
+}
+
 // restartWindowsService restarts the Windows service
 func (u *Upgrader) restartWindowsService() error {
 	// Stop service
@@ -331,6 +418,9 @@ func (u *Upgrader) GetUpgradeStatus() *UpgradeStatus {
 	u.mu.Lock()
 	defer u.mu.Unlock()
 	status := *u.lastStatus
+	if outcome, err := ReadUpgradeOutcome(u.dataDir); err == nil {
+		status.LastOutcome = outcome
+	}
 	return &status
 }
 
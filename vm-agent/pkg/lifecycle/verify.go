@@ -0,0 +1,305 @@
+// Package lifecycle handles agent lifecycle management.
+package lifecycle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultVerifyTimeout is how long a freshly upgraded agent has to report healthy
+	DefaultVerifyTimeout = 2 * time.Minute
+
+	pendingUpgradeFile = "pending.json"
+	healthMarkerFile   = "healthy.json"
+	upgradeResultFile  = "last_result.json"
+
+	watchdogPollInterval = 2 * time.Second
+)
+
+// Upgrade verification outcomes
+const (
+	UpgradeOutcomeVerified   = "verified"
+	UpgradeOutcomeRolledBack = "rolled_back"
+)
+
+// PendingUpgrade describes an upgrade awaiting verification by the watchdog
+type PendingUpgrade struct {
+	FromVersion string    `json:"from_version"`
+	ToVersion   string    `json:"to_version"`
+	BinaryPath  string    `json:"binary_path"`
+	BackupPath  string    `json:"backup_path"`
+	StartedAt   time.Time `json:"started_at"`
+	Deadline    time.Time `json:"deadline"`
+}
+
+// HealthMarker is written by the new agent process once it is healthy
+type HealthMarker struct {
+	Version   string    `json:"version"`
+	PID       int       `json:"pid"`
+	WrittenAt time.Time `json:"written_at"`
+}
+
+// UpgradeOutcome records the result of post-upgrade verification
+type UpgradeOutcome struct {
+	FromVersion string    `json:"from_version"`
+	ToVersion   string    `json:"to_version"`
+	Outcome     string    `json:"outcome"`
+	Reason      string    `json:"reason,omitempty"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// upgradeStateDir returns the directory holding upgrade state files
+func upgradeStateDir(dataDir string) string {
+	return filepath.Join(dataDir, "upgrade")
+}
+
+// ReadPendingUpgrade returns the upgrade awaiting verification, or nil if there is none
+func ReadPendingUpgrade(dataDir string) (*PendingUpgrade, error) {
+	var pending PendingUpgrade
+	if err := readStateFile(dataDir, pendingUpgradeFile, &pending); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read pending upgrade: %w", err)
+	}
+	return &pending, nil
+}
+
+// ReadUpgradeOutcome returns the result of the last verified upgrade, or nil if there is none
+func ReadUpgradeOutcome(dataDir string) (*UpgradeOutcome, error) {
+	var outcome UpgradeOutcome
+	if err := readStateFile(dataDir, upgradeResultFile, &outcome); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read upgrade outcome: %w", err)
+	}
+	return &outcome, nil
+}
+
+// WriteHealthMarker records that the running agent came up healthy after an
+// upgrade. It is a no-op unless an upgrade to currentVersion is pending.
+func WriteHealthMarker(dataDir, currentVersion string) (bool, error) {
+	pending, err := ReadPendingUpgrade(dataDir)
+	if err != nil {
+		return false, err
+	}
+	if pending == nil || pending.ToVersion != currentVersion {
+		return false, nil
+	}
+
+	marker := &HealthMarker{
+		Version:   currentVersion,
+		PID:       os.Getpid(),
+		WrittenAt: time.Now(),
+	}
+	if err := writeStateFile(dataDir, healthMarkerFile, marker); err != nil {
+		return false, fmt.Errorf("failed to write health marker: %w", err)
+	}
+
+	return true, nil
+}
+
+// armWatchdog records the pending upgrade and launches the watchdog from the
+// backup binary, so verification survives the service restart and does not
+// depend on the build being verified
+func (u *Upgrader) armWatchdog(pending *PendingUpgrade) error {
+	os.Remove(filepath.Join(upgradeStateDir(u.dataDir), healthMarkerFile))
+
+	if err := writeStateFile(u.dataDir, pendingUpgradeFile, pending); err != nil {
+		return fmt.Errorf("failed to write pending upgrade: %w", err)
+	}
+
+	cmd := exec.Command(pending.BackupPath, "upgrade-watchdog", "--data-dir", u.dataDir)
+	if err := startWatchdogProcess(cmd); err != nil {
+		os.Remove(filepath.Join(upgradeStateDir(u.dataDir), pendingUpgradeFile))
+		return fmt.Errorf("failed to start upgrade watchdog: %w", err)
+	}
+
+	u.logger.Info("upgrade watchdog armed",
+		zap.String("to_version", pending.ToVersion),
+		zap.Time("deadline", pending.Deadline))
+
+	return nil
+}
+
+// disarmWatchdog clears a pending upgrade whose restart never happened
+func (u *Upgrader) disarmWatchdog() {
+	os.Remove(filepath.Join(upgradeStateDir(u.dataDir), pendingUpgradeFile))
+}
+
+// RunWatchdog waits for the upgraded agent to write its health marker before
+// the pending upgrade's deadline. If it does not, the backup binary is
+// restored and the service restarted. The outcome is recorded so the running
+// agent can report it to the control plane.
+func RunWatchdog(ctx context.Context, dataDir string, logger *zap.Logger) error {
+	pending, err := ReadPendingUpgrade(dataDir)
+	if err != nil {
+		return err
+	}
+	if pending == nil {
+		return fmt.Errorf("no pending upgrade to verify")
+	}
+
+	logger.Info("verifying upgrade",
+		zap.String("from_version", pending.FromVersion),
+		zap.String("to_version", pending.ToVersion),
+		zap.Time("deadline", pending.Deadline))
+
+	ticker := time.NewTicker(watchdogPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if ok, err := healthMarkerValid(dataDir, pending); ok {
+			logger.Info("upgrade verified", zap.String("version", pending.ToVersion))
+			return finishWatchdog(dataDir, pending, UpgradeOutcomeVerified, "")
+		} else if err != nil {
+			logger.Debug("health marker not usable", zap.Error(err))
+		}
+
+		if time.Now().After(pending.Deadline) {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	reason := fmt.Sprintf("agent %s did not report healthy before %s", pending.ToVersion, pending.Deadline.Format(time.RFC3339))
+	logger.Warn("upgrade verification failed, rolling back", zap.String("reason", reason))
+
+	// Stop first so a crash-looping binary is not busy while it is replaced
+	stopAgentService()
+
+	restoreErr := restoreBackup(pending)
+	if restoreErr != nil {
+		reason = fmt.Sprintf("%s; rollback failed: %v", reason, restoreErr)
+	}
+
+	// Record the outcome before starting so the restored agent reports it on startup
+	if err := finishWatchdog(dataDir, pending, UpgradeOutcomeRolledBack, reason); err != nil {
+		logger.Error("failed to record rollback", zap.Error(err))
+	}
+
+	if err := startAgentService(); err != nil {
+		return fmt.Errorf("failed to start service after rollback: %w", err)
+	}
+	if restoreErr != nil {
+		return fmt.Errorf("rollback failed: %w", restoreErr)
+	}
+
+	logger.Info("upgrade rolled back", zap.String("version", pending.FromVersion))
+	return nil
+}
+
+// healthMarkerValid reports whether the health marker was written by the upgraded version
+func healthMarkerValid(dataDir string, pending *PendingUpgrade) (bool, error) {
+	var marker HealthMarker
+	if err := readStateFile(dataDir, healthMarkerFile, &marker); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if marker.Version != pending.ToVersion {
+		return false, fmt.Errorf("health marker is for version %s", marker.Version)
+	}
+	if marker.WrittenAt.Before(pending.StartedAt) {
+		return false, fmt.Errorf("health marker predates upgrade")
+	}
+
+	return true, nil
+}
+
+// restoreBackup atomically puts the backup binary back in place
+func restoreBackup(pending *PendingUpgrade) error {
+	src, err := os.Open(pending.BackupPath)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer src.Close()
+
+	tmpPath := pending.BinaryPath + ".rollback"
+	dst, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create rollback binary: %w", err)
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to copy backup: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write rollback binary: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, pending.BinaryPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to restore binary: %w", err)
+	}
+
+	return nil
+}
+
+// finishWatchdog records the outcome and clears the pending upgrade state
+func finishWatchdog(dataDir string, pending *PendingUpgrade, outcome, reason string) error {
+	result := &UpgradeOutcome{
+		FromVersion: pending.FromVersion,
+		ToVersion:   pending.ToVersion,
+		Outcome:     outcome,
+		Reason:      reason,
+		CompletedAt: time.Now(),
+	}
+	if err := writeStateFile(dataDir, upgradeResultFile, result); err != nil {
+		return fmt.Errorf("failed to write upgrade outcome: %w", err)
+	}
+
+	stateDir := upgradeStateDir(dataDir)
+	os.Remove(filepath.Join(stateDir, pendingUpgradeFile))
+	os.Remove(filepath.Join(stateDir, healthMarkerFile))
+
+	return nil
+}
+
+// readStateFile decodes a JSON state file from the upgrade directory
+func readStateFile(dataDir, name string, v any) error {
+	data, err := os.ReadFile(filepath.Join(upgradeStateDir(dataDir), name))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeStateFile atomically writes a JSON state file to the upgrade directory
+func writeStateFile(dataDir, name string, v any) error {
+	dir := upgradeStateDir(dataDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
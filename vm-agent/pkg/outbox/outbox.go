@@ -0,0 +1,555 @@
+// Package outbox keeps the reports the agent could not deliver to the
+// control plane on disk, and delivers them once it can be reached again.
+// Entries are delivered oldest first, retried with backoff and dropped,
+// oldest first, once the outbox outgrows its size or they outgrow its age.
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/yourorg/vm-agent/pkg/identity"
+)
+
+// Kinds of queued reports
+const (
+	KindResult = "result"
+	KindHealth = "health"
+)
+
+// entryExt ends the name of each entry's file
+const entryExt = ".json"
+
+// Config contains outbox settings
+type Config struct {
+	Dir string
+	// MaxBytes bounds the entries kept on disk, and MaxAge how long they
+	// are kept
+	MaxBytes int64
+	MaxAge   time.Duration
+	// RetryDelay is the delay after a failed delivery, doubling up to
+	// MaxRetryDelay while the control plane stays unreachable
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+}
+
+// DefaultConfig returns the default outbox settings, without a directory
+func DefaultConfig() *Config {
+	return &Config{
+		MaxBytes:      64 * 1024 * 1024,
+		MaxAge:        72 * time.Hour,
+		RetryDelay:    5 * time.Second,
+		MaxRetryDelay: 5 * time.Minute,
+	}
+}
+
+// Entry is a report queued for the control plane. Entries with a Key
+// replace those queued before with the same key, e.g. the results of one
+// workflow, which each carry all of its steps.
+type Entry struct {
+	ID        string          `json:"-"`
+	Kind      string          `json:"kind"`
+	Key       string          `json:"key,omitempty"`
+	URL       string          `json:"url"`
+	RequestID string          `json:"request_id,omitempty"`
+	Body      json.RawMessage `json:"body"`
+	QueuedAt  time.Time       `json:"queued_at"`
+}
+
+// Stats describes the queued entries
+type Stats struct {
+	Entries  int        `json:"entries"`
+	Bytes    int64      `json:"bytes"`
+	MaxBytes int64      `json:"max_bytes"`
+	Oldest   *time.Time `json:"oldest,omitempty"`
+	// Dropped counts the entries dropped for space, age or rejection since
+	// the agent started
+	Dropped     int        `json:"dropped"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// rejectedError is a delivery the control plane refused, which is not
+// retried
+type rejectedError struct {
+	statusCode int
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("report rejected with status %d", e.statusCode)
+}
+
+// Outbox is the on-disk queue of reports awaiting delivery
+type Outbox struct {
+	config     *Config
+	httpClient *http.Client
+	logger     *zap.Logger
+
+	mu       sync.Mutex
+	token    string
+	identity *identity.Identity
+	// entries are the queued entries oldest first, without their bodies,
+	// and bytes their size on disk
+	entries     []*Entry
+	sizes       map[string]int64
+	bytes       int64
+	seq         uint64
+	dropped     int
+	lastError   error
+	lastErrorAt time.Time
+
+	// queuedCh signals a new entry, wakeCh regained connectivity
+	queuedCh chan struct{}
+	wakeCh   chan struct{}
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+}
+
+// New creates an outbox in the configured directory, with the entries a
+// previous run left there. Settings left zero take their defaults.
+func New(config *Config, logger *zap.Logger) (*Outbox, error) {
+	defaults := DefaultConfig()
+	cfg := *config
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = defaults.MaxBytes
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = defaults.MaxAge
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = defaults.RetryDelay
+	}
+	if cfg.MaxRetryDelay < cfg.RetryDelay {
+		cfg.MaxRetryDelay = max(defaults.MaxRetryDelay, cfg.RetryDelay)
+	}
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+
+	o := &Outbox{
+		config: &cfg,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		logger:   logger,
+		sizes:    make(map[string]int64),
+		queuedCh: make(chan struct{}, 1),
+		wakeCh:   make(chan struct{}, 1),
+		stopCh:   make(chan struct{}),
+	}
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+	if len(o.entries) > 0 {
+		logger.Info("delivering reports queued before the agent stopped",
+			zap.Int("entries", len(o.entries)),
+			zap.Int64("bytes", o.bytes))
+	}
+	return o, nil
+}
+
+// load reads the entries queued in the directory. Unreadable entries and
+// files left by interrupted writes are removed.
+func (o *Outbox) load() error {
+	files, err := os.ReadDir(o.config.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to read outbox directory: %w", err)
+	}
+	for _, file := range files {
+		path := filepath.Join(o.config.Dir, file.Name())
+		if !file.Type().IsRegular() {
+			continue
+		}
+		if !strings.HasSuffix(file.Name(), entryExt) {
+			os.Remove(path)
+			continue
+		}
+		entry, err := o.read(strings.TrimSuffix(file.Name(), entryExt))
+		info, statErr := file.Info()
+		if err != nil || statErr != nil {
+			o.logger.Warn("removing unreadable outbox entry",
+				zap.String("path", path),
+				zap.Error(err))
+			os.Remove(path)
+			continue
+		}
+		entry.Body = nil
+		o.entries = append(o.entries, entry)
+		o.sizes[entry.ID] = info.Size()
+		o.bytes += info.Size()
+	}
+	// Entry IDs begin with the time they were queued
+	sort.Slice(o.entries, func(i, j int) bool { return o.entries[i].ID < o.entries[j].ID })
+	return nil
+}
+
+// SetToken replaces the token deliveries authenticate with, after the agent
+// refreshed it
+func (o *Outbox) SetToken(token string) {
+	o.mu.Lock()
+	o.token = token
+	o.mu.Unlock()
+}
+
+// SetIdentity sets the key deliveries are signed with
+func (o *Outbox) SetIdentity(id *identity.Identity) {
+	o.mu.Lock()
+	o.identity = id
+	o.mu.Unlock()
+}
+
+// Start starts delivering queued entries
+func (o *Outbox) Start(ctx context.Context) {
+	o.wg.Add(1)
+	go o.run(ctx)
+}
+
+// Stop stops delivering entries; those not delivered stay on disk
+func (o *Outbox) Stop() {
+	close(o.stopCh)
+	o.wg.Wait()
+}
+
+// Enqueue queues an entry on disk, replacing an entry with the same key
+// and dropping the oldest entries to make room for it
+func (o *Outbox) Enqueue(entry *Entry) error {
+	if entry.QueuedAt.IsZero() {
+		entry.QueuedAt = time.Now()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox entry: %w", err)
+	}
+	size := int64(len(data))
+	if size > o.config.MaxBytes {
+		return fmt.Errorf("report of %d bytes exceeds the outbox size of %d bytes", size, o.config.MaxBytes)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if entry.Key != "" {
+		for _, queued := range o.entries {
+			if queued.Key == entry.Key {
+				o.removeLocked(queued.ID)
+				break
+			}
+		}
+	}
+	for len(o.entries) > 0 && o.bytes+size > o.config.MaxBytes {
+		oldest := o.entries[0]
+		o.logger.Warn("outbox full, dropping oldest queued report",
+			zap.String("kind", oldest.Kind),
+			zap.String("key", oldest.Key),
+			zap.Time("queued_at", oldest.QueuedAt))
+		o.removeLocked(oldest.ID)
+		o.dropped++
+	}
+
+	o.seq++
+	id := fmt.Sprintf("%020d-%06d", entry.QueuedAt.UnixNano(), o.seq%1000000)
+	if err := o.write(id, data); err != nil {
+		return err
+	}
+
+	queued := *entry
+	queued.ID = id
+	queued.Body = nil
+	o.entries = append(o.entries, &queued)
+	o.sizes[id] = size
+	o.bytes += size
+
+	select {
+	case o.queuedCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Discard removes the entry queued with a key, once a newer report made it
+// obsolete
+func (o *Outbox) Discard(key string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, queued := range o.entries {
+		if queued.Key == key {
+			o.removeLocked(queued.ID)
+			return
+		}
+	}
+}
+
+// Wake retries delivery straight away, when the agent learns the control
+// plane can be reached again
+func (o *Outbox) Wake() {
+	select {
+	case o.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// Len returns the number of queued entries
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+// Stats returns the queued entries' count and size and the last delivery
+// error
+func (o *Outbox) Stats() Stats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	stats := Stats{
+		Entries:  len(o.entries),
+		Bytes:    o.bytes,
+		MaxBytes: o.config.MaxBytes,
+		Dropped:  o.dropped,
+	}
+	if len(o.entries) > 0 {
+		oldest := o.entries[0].QueuedAt
+		stats.Oldest = &oldest
+	}
+	if o.lastError != nil {
+		at := o.lastErrorAt
+		stats.LastError = o.lastError.Error()
+		stats.LastErrorAt = &at
+	}
+	return stats
+}
+
+// run delivers entries until stopped, backing off while deliveries fail
+func (o *Outbox) run(ctx context.Context) {
+	defer o.wg.Done()
+
+	delay := o.config.RetryDelay
+	for {
+		entry := o.next()
+		if entry == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-o.stopCh:
+				return
+			case <-o.queuedCh:
+			case <-o.wakeCh:
+			}
+			continue
+		}
+
+		err := o.send(ctx, entry)
+		var rejected *rejectedError
+		if err == nil || errors.As(err, &rejected) {
+			if rejected != nil {
+				o.logger.Warn("queued report rejected, dropping it",
+					zap.String("kind", entry.Kind),
+					zap.String("key", entry.Key),
+					zap.Int("status_code", rejected.statusCode))
+			} else {
+				o.logger.Debug("queued report delivered",
+					zap.String("kind", entry.Kind),
+					zap.String("key", entry.Key),
+					zap.Duration("queued_for", time.Since(entry.QueuedAt)))
+			}
+			o.delivered(entry.ID, rejected != nil)
+			delay = o.config.RetryDelay
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		o.mu.Lock()
+		o.lastError = err
+		o.lastErrorAt = time.Now()
+		queued := len(o.entries)
+		o.mu.Unlock()
+		o.logger.Warn("failed to deliver queued reports, retrying",
+			zap.Int("queued", queued),
+			zap.Duration("retry_in", delay),
+			zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.stopCh:
+			return
+		case <-o.wakeCh:
+			delay = o.config.RetryDelay
+		case <-time.After(delay):
+			delay = min(delay*2, o.config.MaxRetryDelay)
+		}
+	}
+}
+
+// next returns the oldest entry to deliver, with its body, dropping those
+// past their age
+func (o *Outbox) next() *Entry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for len(o.entries) > 0 {
+		oldest := o.entries[0]
+		if time.Since(oldest.QueuedAt) > o.config.MaxAge {
+			o.logger.Warn("dropping queued report past the outbox max age",
+				zap.String("kind", oldest.Kind),
+				zap.String("key", oldest.Key),
+				zap.Time("queued_at", oldest.QueuedAt))
+			o.removeLocked(oldest.ID)
+			o.dropped++
+			continue
+		}
+		entry, err := o.read(oldest.ID)
+		if err != nil {
+			o.logger.Warn("dropping unreadable outbox entry",
+				zap.String("id", oldest.ID),
+				zap.Error(err))
+			o.removeLocked(oldest.ID)
+			o.dropped++
+			continue
+		}
+		return entry
+	}
+	return nil
+}
+
+// send posts an entry to the control plane
+func (o *Outbox) send(ctx context.Context, entry *Entry) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, entry.URL, bytes.NewReader(entry.Body))
+	if err != nil {
+		return &rejectedError{}
+	}
+
+	o.mu.Lock()
+	token := o.token
+	id := o.identity
+	o.mu.Unlock()
+
+	req.Header.Set("Content-Type", "application/json")
+	if entry.RequestID != "" {
+		req.Header.Set("X-Request-ID", entry.RequestID)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	// Signatures carry the time they were made, so entries are signed when
+	// they are sent rather than when they were queued
+	if id != nil {
+		id.SignRequest(req, entry.Body)
+	}
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusAccepted {
+		return nil
+	}
+	if Retryable(resp.StatusCode) {
+		return fmt.Errorf("control plane returned status %d", resp.StatusCode)
+	}
+	return &rejectedError{statusCode: resp.StatusCode}
+}
+
+// delivered removes an entry once it was delivered or rejected
+func (o *Outbox) delivered(id string, rejected bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.removeLocked(id)
+	if rejected {
+		o.dropped++
+	} else {
+		o.lastError = nil
+	}
+}
+
+// removeLocked removes an entry and its file. The entry may already be gone
+// when it was replaced while being delivered.
+func (o *Outbox) removeLocked(id string) {
+	for i, queued := range o.entries {
+		if queued.ID != id {
+			continue
+		}
+		o.entries = append(o.entries[:i], o.entries[i+1:]...)
+		o.bytes -= o.sizes[id]
+		delete(o.sizes, id)
+		if err := os.Remove(o.path(id)); err != nil && !os.IsNotExist(err) {
+			o.logger.Warn("failed to remove outbox entry",
+				zap.String("id", id),
+				zap.Error(err))
+		}
+		return
+	}
+}
+
+// read reads an entry from its file
+func (o *Outbox) read(id string) (*Entry, error) {
+	data, err := os.ReadFile(o.path(id))
+	if err != nil {
+		return nil, err
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to decode outbox entry: %w", err)
+	}
+	entry.ID = id
+	return &entry, nil
+}
+
+// write writes an entry's file, through a temporary file so an interrupted
+// write leaves no partial entry
+func (o *Outbox) write(id string, data []byte) error {
+	tmp, err := os.CreateTemp(o.config.Dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to queue report: %w", err)
+	}
+	_, writeErr := tmp.Write(data)
+	if writeErr == nil {
+		writeErr = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr == nil {
+		writeErr = os.Rename(tmp.Name(), o.path(id))
+	}
+	if writeErr != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to queue report: %w", writeErr)
+	}
+	return nil
+}
+
+// path returns the file of an entry
+func (o *Outbox) path(id string) string {
+	return filepath.Join(o.config.Dir, id+entryExt)
+}
+
+// Retryable reports whether a request the control plane answered with a
+// status is worth retrying: server errors, timeouts and throttling, and
+// authentication failures a refreshed token may cure
+func Retryable(statusCode int) bool {
+	switch statusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return statusCode >= 500
+}
@@ -17,23 +17,55 @@ import (
 // Client represents a Piko client connection
 type Client struct {
 	mu          sync.RWMutex
-	serverURL   string
+	servers     *serverSelector
 	endpoint    string
 	token       string
 	tenantID    string
 	conn        *websocket.Conn
 	connected   bool
 	lastError   error
+	lastErrorAt time.Time
 	logger      *zap.Logger
 	httpHandler http.Handler
 	stopCh      chan struct{}
 	wg          sync.WaitGroup
 	reconnect   *ReconnectConfig
+
+	// Connection state reported by Status
+	state          ConnectionState
+	connectedSince time.Time
+	connections    int
+	failedAttempts int
+	backoff        time.Duration
+	nextAttemptAt  time.Time
+
+	// onConnected is called after each connection is established
+	onConnected func()
+}
+
+// ConnectionStatus is a snapshot of the client's connection to Piko
+type ConnectionStatus struct {
+	State          ConnectionState
+	Server         Server
+	ConnectedSince time.Time // zero while not connected
+	// Reconnects counts the connections made after the first one
+	Reconnects int
+	// FailedAttempts counts the connect attempts failed since the last
+	// connection
+	FailedAttempts int
+	LastError      error
+	LastErrorAt    time.Time
+	// Backoff is the delay before the next attempt and NextAttemptAt when
+	// it is made, while the client waits to reconnect
+	Backoff       time.Duration
+	NextAttemptAt time.Time
 }
 
 // ClientConfig contains client configuration
 type ClientConfig struct {
 	ServerURL   string
+	Servers     []Server // takes precedence over ServerURL when set
+	Failover    *FailoverConfig
 	Endpoint    string
 	Token       string
 	TenantID    string
@@ -48,8 +80,13 @@ func NewClient(cfg *ClientConfig, logger *zap.Logger) *Client {
 		reconnect = DefaultReconnectConfig()
 	}
 
+	servers := cfg.Servers
+	if len(servers) == 0 {
+		servers = []Server{{URL: cfg.ServerURL}}
+	}
+
 	return &Client{
-		serverURL:   cfg.ServerURL,
+		servers:     newServerSelector(servers, cfg.Failover, logger),
 		endpoint:    cfg.Endpoint,
 		token:       cfg.Token,
 		tenantID:    cfg.TenantID,
@@ -60,6 +97,13 @@ func NewClient(cfg *ClientConfig, logger *zap.Logger) *Client {
 	}
 }
 
+// OnConnected sets a callback invoked each time the client connects
+func (c *Client) OnConnected(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onConnected = fn
+}
+
 // Start establishes the connection and starts handling requests
 func (c *Client) Start(ctx context.Context) error {
 	c.wg.Add(1)
@@ -81,6 +125,8 @@ func (c *Client) Stop() error {
 		c.conn = nil
 		c.connected = false
 	}
+	c.state = StateDisconnected
+	c.connectedSince = time.Time{}
 
 	return nil
 }
@@ -90,6 +136,7 @@ func (c *Client) connectionLoop(ctx context.Context) {
 	defer c.wg.Done()
 
 	backoff := NewBackoff(c.reconnect)
+	c.servers.Rank(ctx)
 
 	for {
 		select {
@@ -100,43 +147,91 @@ func (c *Client) connectionLoop(ctx context.Context) {
 		default:
 		}
 
-		err := c.connect(ctx)
+		server := c.servers.Current()
+		err := c.connect(ctx, server)
 		if err != nil {
 			c.setError(err)
 			c.logger.Error("failed to connect to Piko",
 				zap.Error(err),
-				zap.String("server_url", c.serverURL),
+				zap.String("server_url", server.URL),
 				zap.String("endpoint", c.endpoint))
 
-			delay := backoff.Next()
-			c.logger.Info("reconnecting after delay",
-				zap.Duration("delay", delay))
+			// Try the next server straight away; the backoff keeps growing
+			// so an outage of every server is still retried slowly
+			if c.servers.Failed() {
+				continue
+			}
 
-			select {
-			case <-ctx.Done():
+			if !c.wait(ctx, backoff.Next()) {
 				return
-			case <-c.stopCh:
-				return
-			case <-time.After(delay):
-				continue
 			}
+			continue
 		}
 
 		// Reset backoff on successful connection
 		backoff.Reset()
+		c.servers.Connected()
+
+		c.mu.RLock()
+		onConnected := c.onConnected
+		c.mu.RUnlock()
+		if onConnected != nil {
+			onConnected()
+		}
 
 		// Handle requests until disconnected
 		c.handleRequests(ctx)
+
+		// Re-rank so a recovered preferred server is used again
+		c.servers.Rank(ctx)
+
+		// Every agent of a Piko server that restarts is disconnected at
+		// once; the jittered delay spreads out their reconnects
+		if !c.wait(ctx, backoff.Next()) {
+			return
+		}
 	}
 }
 
-// connect establishes the WebSocket connection to Piko
-func (c *Client) connect(ctx context.Context) error {
+// wait waits out a reconnect delay, returning false if the client is
+// stopped meanwhile
+func (c *Client) wait(ctx context.Context, delay time.Duration) bool {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.state = StateReconnecting
+	c.backoff = delay
+	c.nextAttemptAt = time.Now().Add(delay)
+	c.mu.Unlock()
+
+	c.logger.Info("reconnecting after delay",
+		zap.Duration("delay", delay))
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-c.stopCh:
+		return false
+	case <-time.After(delay):
+	}
+
+	c.mu.Lock()
+	c.backoff = 0
+	c.nextAttemptAt = time.Time{}
+	c.mu.Unlock()
+	return true
+}
+
+// connect establishes the WebSocket connection to a Piko server
+func (c *Client) connect(ctx context.Context, server Server) error {
+	// The lock is not held while dialing, so the connection state can be
+	// read during a slow handshake
+	c.mu.Lock()
+	if c.connections == 0 {
+		c.state = StateConnecting
+	}
+	c.mu.Unlock()
 
 	// Build the connection URL
-	url := fmt.Sprintf("%s/piko/v1/upstream/%s", c.serverURL, c.endpoint)
+	url := fmt.Sprintf("%s/piko/v1/upstream/%s", server.URL, c.endpoint)
 
 	// Create headers
 	headers := http.Header{}
@@ -154,16 +249,29 @@ func (c *Client) connect(ctx context.Context) error {
 	conn, resp, err := dialer.DialContext(ctx, url, headers)
 	if err != nil {
 		if resp != nil {
-			return fmt.Errorf("connection failed with status %d: %w", resp.StatusCode, err)
+			err = fmt.Errorf("connection failed with status %d: %w", resp.StatusCode, err)
+		} else {
+			err = fmt.Errorf("connection failed: %w", err)
 		}
-		return fmt.Errorf("connection failed: %w", err)
+		c.mu.Lock()
+		c.failedAttempts++
+		c.mu.Unlock()
+		return err
 	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.conn = conn
 	c.connected = true
 	c.lastError = nil
+	c.state = StateConnected
+	c.connectedSince = time.Now()
+	c.connections++
+	c.failedAttempts = 0
 
 	c.logger.Info("connected to Piko server",
+		zap.String("server_url", server.URL),
+		zap.String("region", server.Region),
 		zap.String("endpoint", c.endpoint))
 
 	return nil
@@ -192,6 +300,7 @@ func (c *Client) handleRequests(ctx context.Context) {
 		messageType, reader, err := conn.NextReader()
 		if err != nil {
 			c.logger.Error("error reading from WebSocket", zap.Error(err))
+			c.setError(fmt.Errorf("connection lost: %w", err))
 			c.setConnected(false)
 			return
 		}
@@ -251,6 +360,8 @@ func (c *Client) setConnected(connected bool) {
 	defer c.mu.Unlock()
 	c.connected = connected
 	if !connected {
+		c.state = StateDisconnected
+		c.connectedSince = time.Time{}
 		if c.conn != nil {
 			c.conn.Close()
 			c.conn = nil
@@ -263,6 +374,7 @@ func (c *Client) setError(err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.lastError = err
+	c.lastErrorAt = time.Now()
 	c.connected = false
 }
 
@@ -280,6 +392,41 @@ func (c *Client) LastError() error {
 	return c.lastError
 }
 
+// Status returns the state of the connection, including the reconnects
+// made and the backoff before the next attempt
+func (c *Client) Status() ConnectionStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	reconnects := 0
+	if c.connections > 1 {
+		reconnects = c.connections - 1
+	}
+	return ConnectionStatus{
+		State:          c.state,
+		Server:         c.servers.Current(),
+		ConnectedSince: c.connectedSince,
+		Reconnects:     reconnects,
+		FailedAttempts: c.failedAttempts,
+		LastError:      c.lastError,
+		LastErrorAt:    c.lastErrorAt,
+		Backoff:        c.backoff,
+		NextAttemptAt:  c.nextAttemptAt,
+	}
+}
+
+// ActiveServer returns the server the client is connected to, or is
+// currently trying to connect to
+func (c *Client) ActiveServer() Server {
+	return c.servers.Current()
+}
+
+// ActiveServerLatency returns the probed connect latency of the active
+// server, or zero when latency-based selection is not used
+func (c *Client) ActiveServerLatency() time.Duration {
+	return c.servers.Latency()
+}
+
 // GetEndpoint returns the current endpoint
 func (c *Client) GetEndpoint() string {
 	return c.endpoint
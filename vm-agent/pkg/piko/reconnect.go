@@ -2,6 +2,7 @@
 package piko
 
 import (
+	"math"
 	"math/rand"
 	"sync"
 	"time"
@@ -12,7 +13,9 @@ type ReconnectConfig struct {
 	InitialDelay time.Duration
 	MaxDelay     time.Duration
 	Multiplier   float64
-	Jitter       float64
+	// Jitter shortens each delay by a random fraction of up to Jitter (0-1),
+	// so clients disconnected together do not reconnect together
+	Jitter float64
 }
 
 // DefaultReconnectConfig returns the default reconnection configuration
@@ -21,7 +24,7 @@ func DefaultReconnectConfig() *ReconnectConfig {
 		InitialDelay: 1 * time.Second,
 		MaxDelay:     60 * time.Second,
 		Multiplier:   2.0,
-		Jitter:       0.1, // 10% jitter
+		Jitter:       0.5,
 	}
 }
 
@@ -52,10 +55,9 @@ func (b *Backoff) Next() time.Duration {
 	delay := b.currentDelay
 	b.attempts++
 
-	// Apply jitter
-	if b.config.Jitter > 0 {
-		jitter := float64(delay) * b.config.Jitter * (rand.Float64()*2 - 1)
-		delay = time.Duration(float64(delay) + jitter)
+	// Apply jitter below the delay, so MaxDelay stays the longest wait
+	if jitter := math.Min(b.config.Jitter, 1); jitter > 0 {
+		delay -= time.Duration(float64(delay) * jitter * rand.Float64())
 	}
 
 	// Calculate next delay
@@ -0,0 +1,231 @@
+package piko
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Server selection strategies
+const (
+	// StrategyPriority prefers servers with the lowest priority value
+	StrategyPriority = "priority"
+	// StrategyLatency prefers the server with the lowest connect latency,
+	// using priority to break ties and order unreachable servers
+	StrategyLatency = "latency"
+)
+
+// Server is a Piko server the client can connect to
+type Server struct {
+	URL      string
+	Region   string
+	Priority int
+}
+
+// FailoverConfig controls server selection and failover
+type FailoverConfig struct {
+	Strategy     string
+	MaxFailures  int           // consecutive connect failures before failing over
+	ProbeTimeout time.Duration // per-server latency probe timeout
+}
+
+// DefaultFailoverConfig returns the default failover configuration
+func DefaultFailoverConfig() *FailoverConfig {
+	return &FailoverConfig{
+		Strategy:     StrategyPriority,
+		MaxFailures:  3,
+		ProbeTimeout: 5 * time.Second,
+	}
+}
+
+// serverSelector orders the configured servers and tracks connection
+// failures against the active one
+type serverSelector struct {
+	mu       sync.RWMutex
+	servers  []Server
+	config   FailoverConfig
+	order    []int
+	current  int
+	failures int
+	latency  map[int]time.Duration
+	logger   *zap.Logger
+}
+
+// newServerSelector creates a selector for servers, which must not be empty
+func newServerSelector(servers []Server, config *FailoverConfig, logger *zap.Logger) *serverSelector {
+	cfg := *DefaultFailoverConfig()
+	if config != nil {
+		if config.Strategy != "" {
+			cfg.Strategy = config.Strategy
+		}
+		if config.MaxFailures > 0 {
+			cfg.MaxFailures = config.MaxFailures
+		}
+		if config.ProbeTimeout > 0 {
+			cfg.ProbeTimeout = config.ProbeTimeout
+		}
+	}
+
+	s := &serverSelector{
+		servers: servers,
+		config:  cfg,
+		logger:  logger,
+	}
+	s.order = s.priorityOrder()
+	return s
+}
+
+// Current returns the server to connect to
+func (s *serverSelector) Current() Server {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.servers[s.order[s.current]]
+}
+
+// Latency returns the last probed connect latency of the current server,
+// or zero if it was not probed
+func (s *serverSelector) Latency() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.latency[s.order[s.current]]
+}
+
+// Rank orders the servers by the configured strategy and selects the most
+// preferred. Latency probes run without holding the lock.
+func (s *serverSelector) Rank(ctx context.Context) {
+	order := s.priorityOrder()
+
+	var latency map[int]time.Duration
+	if s.config.Strategy == StrategyLatency && len(s.servers) > 1 {
+		latency = s.probeAll(ctx)
+		sort.SliceStable(order, func(a, b int) bool {
+			la, oka := latency[order[a]]
+			lb, okb := latency[order[b]]
+			if oka != okb {
+				return oka
+			}
+			return oka && la < lb
+		})
+	}
+
+	s.mu.Lock()
+	s.order = order
+	s.current = 0
+	s.failures = 0
+	s.latency = latency
+	s.mu.Unlock()
+}
+
+// Connected resets the failure count after a successful connection
+func (s *serverSelector) Connected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = 0
+}
+
+// Failed records a connection failure against the current server. After
+// MaxFailures consecutive failures it fails over to the next server and
+// returns true.
+func (s *serverSelector) Failed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures++
+	if s.failures < s.config.MaxFailures || len(s.servers) < 2 {
+		return false
+	}
+
+	from := s.servers[s.order[s.current]]
+	s.current = (s.current + 1) % len(s.order)
+	s.failures = 0
+	to := s.servers[s.order[s.current]]
+
+	s.logger.Warn("failing over to next Piko server",
+		zap.String("from", from.URL),
+		zap.String("to", to.URL),
+		zap.String("region", to.Region),
+		zap.Int("failures", s.config.MaxFailures))
+
+	return true
+}
+
+// priorityOrder returns server indexes ordered by priority, keeping the
+// configured order for equal priorities
+func (s *serverSelector) priorityOrder() []int {
+	order := make([]int, len(s.servers))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return s.servers[order[a]].Priority < s.servers[order[b]].Priority
+	})
+	return order
+}
+
+// probeAll measures the TCP connect latency of every server concurrently.
+// Unreachable servers are left out of the result.
+func (s *serverSelector) probeAll(ctx context.Context) map[int]time.Duration {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		latency = make(map[int]time.Duration, len(s.servers))
+	)
+
+	for i, server := range s.servers {
+		wg.Add(1)
+		go func(i int, server Server) {
+			defer wg.Done()
+			d, err := probeServer(ctx, server.URL, s.config.ProbeTimeout)
+			if err != nil {
+				s.logger.Debug("Piko server probe failed",
+					zap.String("server_url", server.URL),
+					zap.Error(err))
+				return
+			}
+			mu.Lock()
+			latency[i] = d
+			mu.Unlock()
+		}(i, server)
+	}
+	wg.Wait()
+
+	return latency
+}
+
+// probeServer returns the time taken to open a TCP connection to the host
+// of serverURL
+func probeServer(ctx context.Context, serverURL string, timeout time.Duration) (time.Duration, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return 0, err
+	}
+
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https", "wss":
+			port = "443"
+		default:
+			port = "80"
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return 0, err
+	}
+	elapsed := time.Since(start)
+	conn.Close()
+
+	return elapsed, nil
+}
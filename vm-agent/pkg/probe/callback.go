@@ -0,0 +1,258 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// CallbackSignatureHeader carries the HMAC-SHA256 of a callback request's
+// body, hex encoded with a "sha256=" prefix, on both the payload the agent
+// posts and the confirmation it receives
+const CallbackSignatureHeader = "X-Signature-256"
+
+// maxConfirmationSize bounds the body of a callback confirmation
+const maxConfirmationSize = 1 << 20
+
+// Errors returned when a callback confirmation is refused
+var (
+	ErrCallbackNotFound  = errors.New("no step is waiting for this callback")
+	ErrCallbackSignature = errors.New("invalid callback signature")
+)
+
+// CallbackConfig contains configuration for callback steps. The step posts
+// a signed JSON payload to URL and, with WaitForConfirmation, blocks until
+// the receiver confirms or rejects it or the step times out.
+type CallbackConfig struct {
+	// URL receives the payload; it must be http or https
+	URL string `yaml:"url" json:"url"`
+	// Secret is the HMAC-SHA256 key the payload is signed with and the
+	// confirmation must be signed with. SecretEnv names an agent
+	// environment variable holding it instead, keeping it out of the
+	// workflow definition.
+	Secret    string `yaml:"secret,omitempty" json:"secret,omitempty"`
+	SecretEnv string `yaml:"secret_env,omitempty" json:"secret_env,omitempty"`
+	// Headers are added to the request
+	Headers map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	// Payload is sent as the request's data
+	Payload map[string]interface{} `yaml:"payload,omitempty" json:"payload,omitempty"`
+	// WaitForConfirmation pauses the workflow until the receiver posts a
+	// confirmation to the payload's confirm_url
+	WaitForConfirmation bool `yaml:"wait_for_confirmation,omitempty" json:"wait_for_confirmation,omitempty"`
+}
+
+// Validate validates a callback configuration
+func (c *CallbackConfig) Validate() error {
+	if c.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if c.Secret == "" && c.SecretEnv == "" {
+		return fmt.Errorf("secret or secret_env is required")
+	}
+	if c.Secret != "" && c.SecretEnv != "" {
+		return fmt.Errorf("secret and secret_env are mutually exclusive")
+	}
+	return nil
+}
+
+// CallbackPayload is the body posted by a callback step
+type CallbackPayload struct {
+	CallbackID   string                 `json:"callback_id"`
+	WorkflowID   string                 `json:"workflow_id"`
+	WorkflowName string                 `json:"workflow_name"`
+	StepID       string                 `json:"step_id"`
+	StepName     string                 `json:"step_name"`
+	Timestamp    time.Time              `json:"timestamp"`
+	Data         map[string]interface{} `json:"data,omitempty"`
+	// Steps are the results of the steps that already ran
+	Steps map[string]interface{} `json:"steps"`
+	// ConfirmURL is where the confirmation is posted, through the control
+	// plane, when the step waits for one
+	ConfirmURL string `json:"confirm_url,omitempty"`
+}
+
+// CallbackConfirmation is the body of a confirmation
+type CallbackConfirmation struct {
+	// Approved continues the workflow; false fails the step
+	Approved *bool                  `json:"approved"`
+	Message  string                 `json:"message,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+}
+
+// pendingCallback is a callback step waiting for its confirmation
+type pendingCallback struct {
+	secret    []byte
+	confirmed chan CallbackConfirmation
+}
+
+// executeCallback runs a callback step and returns its output, exit code
+// and the confirmation's data
+func (e *Executor) executeCallback(ctx context.Context, step *Step, job *Job) (string, int, map[string]interface{}, error) {
+	cfg := step.Callback
+	secret := cfg.Secret
+	if cfg.SecretEnv != "" {
+		secret = os.Getenv(cfg.SecretEnv)
+		if secret == "" {
+			return "", 1, nil, fmt.Errorf("callback secret variable %s is not set", cfg.SecretEnv)
+		}
+	}
+
+	callbackID, err := newCallbackID()
+	if err != nil {
+		return "", 1, nil, err
+	}
+
+	payload := CallbackPayload{
+		CallbackID:   callbackID,
+		WorkflowID:   job.ID,
+		WorkflowName: job.Workflow.Name,
+		StepID:       step.ID,
+		StepName:     step.Name,
+		Timestamp:    time.Now().UTC(),
+		Data:         cfg.Payload,
+		Steps:        stepsContext(job.Result.Steps),
+	}
+
+	var pending *pendingCallback
+	if cfg.WaitForConfirmation {
+		if e.controlPlaneURL == "" {
+			return "", 1, nil, fmt.Errorf("waiting for a confirmation requires the control plane URL")
+		}
+		payload.ConfirmURL = fmt.Sprintf("%s/api/v1/callbacks/%s/%s", strings.TrimSuffix(e.controlPlaneURL, "/"), job.ID, callbackID)
+
+		// Register before posting so an immediate confirmation is not lost
+		pending = &pendingCallback{secret: []byte(secret), confirmed: make(chan CallbackConfirmation, 1)}
+		e.callbacksMu.Lock()
+		e.callbacks[callbackID] = pending
+		e.callbacksMu.Unlock()
+		defer func() {
+			e.callbacksMu.Lock()
+			delete(e.callbacks, callbackID)
+			e.callbacksMu.Unlock()
+		}()
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", 1, nil, fmt.Errorf("failed to marshal callback payload: %w", err)
+	}
+	if err := postCallback(ctx, cfg, body, []byte(secret)); err != nil {
+		return "", 1, nil, err
+	}
+
+	if pending == nil {
+		return fmt.Sprintf("callback %s delivered to %s", callbackID, cfg.URL), 0, nil, nil
+	}
+
+	e.jobLogger(job).Info("waiting for callback confirmation",
+		zap.String("workflow_id", job.ID),
+		zap.String("step_id", step.ID),
+		zap.String("callback_id", callbackID))
+
+	select {
+	case <-ctx.Done():
+		return "", 1, nil, fmt.Errorf("no confirmation for callback %s: %w", callbackID, ctx.Err())
+	case confirmation := <-pending.confirmed:
+		output := fmt.Sprintf("callback %s confirmed", callbackID)
+		if confirmation.Message != "" {
+			output += ": " + confirmation.Message
+		}
+		if !*confirmation.Approved {
+			return output, 1, confirmation.Data, fmt.Errorf("callback %s was rejected", callbackID)
+		}
+		return output, 0, confirmation.Data, nil
+	}
+}
+
+// postCallback posts a signed payload. Any status other than 2xx fails the
+// step, so it can be retried with retry_count.
+func postCallback(ctx context.Context, cfg *CallbackConfig, body, secret []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create callback request: %w", err)
+	}
+	for key, value := range cfg.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(CallbackSignatureHeader, signCallback(secret, body))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post callback: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("callback receiver returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ConfirmCallback delivers a confirmation to the callback step waiting for
+// it. The body must be signed with the step's secret.
+func (e *Executor) ConfirmCallback(callbackID string, body []byte, signature string) error {
+	e.callbacksMu.Lock()
+	pending, ok := e.callbacks[callbackID]
+	e.callbacksMu.Unlock()
+	if !ok {
+		return ErrCallbackNotFound
+	}
+
+	if len(body) > maxConfirmationSize {
+		return fmt.Errorf("confirmation exceeds %d bytes", maxConfirmationSize)
+	}
+	if !hmac.Equal([]byte(signature), []byte(signCallback(pending.secret, body))) {
+		return ErrCallbackSignature
+	}
+
+	var confirmation CallbackConfirmation
+	if err := json.Unmarshal(body, &confirmation); err != nil {
+		return fmt.Errorf("invalid confirmation: %w", err)
+	}
+	if confirmation.Approved == nil {
+		return fmt.Errorf("invalid confirmation: approved is required")
+	}
+
+	select {
+	case pending.confirmed <- confirmation:
+		return nil
+	default:
+		return fmt.Errorf("callback %s was already confirmed", callbackID)
+	}
+}
+
+// signCallback returns the signature header value for a body
+func signCallback(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newCallbackID returns an unguessable callback identifier
+func newCallbackID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate callback ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,108 @@
+package probe
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// agentTokenEnv is the environment variable the agent reads its token from
+const agentTokenEnv = "VM_AGENT_TOKEN"
+
+// requestIDEnv is the variable steps find the control plane request ID in
+const requestIDEnv = "VM_AGENT_REQUEST_ID"
+
+// cleanEnvBase are the variables kept in a clean environment so commands
+// can still be located and run
+var (
+	cleanEnvBase        = []string{"PATH", "HOME", "USER", "LOGNAME", "LANG", "TMPDIR"}
+	cleanEnvBaseWindows = []string{"PATH", "PATHEXT", "SystemRoot", "SystemDrive", "windir", "ComSpec", "TEMP", "TMP", "USERPROFILE"}
+)
+
+// envPolicy resolves the environment isolation settings of a step
+type envPolicy struct {
+	clean       bool
+	passthrough []string
+}
+
+// stepEnvPolicy combines the workflow and step isolation settings. The step's
+// clean_env overrides the workflow's; passthrough lists are merged.
+func stepEnvPolicy(workflow *Workflow, step *Step) envPolicy {
+	policy := envPolicy{clean: workflow.CleanEnv}
+	if step.CleanEnv != nil {
+		policy.clean = *step.CleanEnv
+	}
+	policy.passthrough = append(policy.passthrough, workflow.EnvPassthrough...)
+	policy.passthrough = append(policy.passthrough, step.EnvPassthrough...)
+	return policy
+}
+
+// inheritedEnv returns the part of the agent's environment a step receives.
+// The agent token is never passed on, whether by name or by value.
+func (e *Executor) inheritedEnv(policy envPolicy) []string {
+	var allowed map[string]bool
+	if policy.clean {
+		base := cleanEnvBase
+		if runtime.GOOS == "windows" {
+			base = cleanEnvBaseWindows
+		}
+		allowed = make(map[string]bool, len(base)+len(policy.passthrough))
+		for _, name := range base {
+			allowed[envKey(name)] = true
+		}
+		for _, name := range policy.passthrough {
+			allowed[envKey(name)] = true
+		}
+	}
+
+	var env []string
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if envKey(name) == envKey(agentTokenEnv) {
+			continue
+		}
+		if e.agentToken != "" && strings.Contains(value, e.agentToken) {
+			continue
+		}
+		if allowed != nil && !allowed[envKey(name)] {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+// stepEnv builds the full environment of a step: the inherited environment,
+// request ID and job directory followed by the workflow and step variables
+func (e *Executor) stepEnv(job *Job, step *Step) []string {
+	env := e.inheritedEnv(stepEnvPolicy(job.Workflow, step))
+	if job.Result.RequestID != "" {
+		env = append(env, requestIDEnv+"="+job.Result.RequestID)
+	}
+	env = append(env, e.jobEnv(job)...)
+	env = appendEnv(env, job.Workflow.Env)
+	return appendEnv(env, step.Env)
+}
+
+// appendEnv appends vars in key order so the environment is deterministic
+func appendEnv(env []string, vars map[string]string) []string {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, fmt.Sprintf("%s=%s", k, vars[k]))
+	}
+	return env
+}
+
+// envKey normalises a variable name; names are case-insensitive on Windows
+func envKey(name string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ToUpper(name)
+	}
+	return name
+}
@@ -4,17 +4,22 @@ package probe
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
+
+	"github.com/yourorg/vm-agent/pkg/identity"
 )
 
 // Executor executes workflows
@@ -23,21 +28,70 @@ type Executor struct {
 	workDir          string
 	maxConcurrent    int
 	activeJobs       int32
-	jobs             map[string]*Job
+	jobs             map[string]*Job // queued and running jobs
+	store            *JobStore
+	recovered        []*WorkflowResult // interrupted jobs, reported once a reporter is set
 	logger           *zap.Logger
-	semaphore        chan struct{}
+	queue            *JobQueue
 	templateFetcher  *TemplateFetcher
 	templateRenderer *TemplateRenderer
 	fileManager      *FileManager
+	agentVersion     string
+	agentToken       string
+	pluginDir        string
+	reporter         *Reporter
+	grains           grainsCache
+	controlPlaneURL  string
+
+	// Callback steps waiting for a confirmation, by callback ID
+	callbacksMu sync.Mutex
+	callbacks   map[string]*pendingCallback
+
+	// Drain mode
+	draining  bool
+	inFlight  int
+	onDrained func()
+
+	// reserved is the sum of the resources held by accepted jobs
+	reserved reservation
+
+	// Disk limits in bytes, 0 when not set: a job directory's quota, the
+	// quota of all job directories together and the free space kept on the
+	// work directory's file system
+	jobDiskQuota uint64
+	workDirQuota uint64
+	minFreeDisk  uint64
+	jobDirsUsage jobDirsUsage
 }
 
+// ErrDraining is returned for workflows submitted while the agent is draining
+var ErrDraining = errors.New("agent is draining, not accepting new workflows")
+
+// Drain states reported by DrainState
+const (
+	DrainStateDraining = "draining"
+	DrainStateDrained  = "drained"
+)
+
 // ExecutorConfig contains executor configuration
 type ExecutorConfig struct {
 	WorkDir          string
 	MaxConcurrent    int
-	ControlPlaneURL  string // URL for control plane template fetching
-	ControlPlaneAuth string // Auth token for control plane
-	BackupDir        string // Directory for file backups
+	ControlPlaneURL  string        // URL for control plane template fetching and callback confirmations
+	ControlPlaneAuth string        // Auth token for control plane
+	BackupDir        string        // Directory for file backups
+	PriorityAging    time.Duration // Queue wait after which a job is promoted one priority level; 0 disables aging
+	AgentVersion     string        // Agent version recorded in environment snapshots
+	AgentToken       string        // Agent token, never passed to step environments
+	PluginDir        string        // Directory plugin step executables are found in
+	TemplateCacheDir string        // Directory control plane template content is cached in
+	TemplateCacheMax int64         // Template cache size in bytes
+	JobStorePath     string        // Job database path (default WorkDir/jobs.db)
+	JobRetention     time.Duration // How long finished jobs are kept
+	MaxJobs          int           // Finished jobs kept, oldest removed first
+	JobDiskQuota     int64         // Bytes a job directory may grow to, 0 = unlimited
+	WorkDirQuota     int64         // Bytes all job directories may use before new jobs are refused, 0 = unlimited
+	MinFreeDisk      int64         // Bytes kept free in the work directory; jobs are refused that would use them
 }
 
 // Job represents a running workflow job
@@ -46,10 +100,29 @@ type Job struct {
 	Workflow   *Workflow
 	Result     *WorkflowResult
 	Status     StepStatus
+	Priority   Priority
+	QueuedAt   time.Time
 	StartedAt  time.Time
 	EndedAt    time.Time
 	CancelFunc context.CancelFunc
 	Done       chan struct{}
+
+	// previewed are the template destinations previewed rather than
+	// written in validate mode
+	previewed map[string]bool
+
+	// reserved is what the job holds of the agent's resources
+	reserved reservation
+
+	// pauseRequested is set until the job reaches a pause point, paused
+	// while it is paused, and resume is closed to resume it. All three are
+	// guarded by e.mu.
+	pauseRequested bool
+	paused         bool
+	resume         chan struct{}
+
+	// holdsSlot is whether the job holds an execution slot
+	holdsSlot bool
 }
 
 // NewExecutor creates a new workflow executor
@@ -65,9 +138,18 @@ func NewExecutor(cfg *ExecutorConfig, logger *zap.Logger) (*Executor, error) {
 	}
 
 	// Initialize template components
+	cacheDir := cfg.TemplateCacheDir
+	if cacheDir == "" {
+		cacheDir = filepath.Join(cfg.WorkDir, "template-cache")
+	}
+	templateCache, err := NewTemplateCache(cacheDir, cfg.TemplateCacheMax)
+	if err != nil {
+		return nil, err
+	}
 	templateFetcher := NewTemplateFetcher(&TemplateFetcherConfig{
 		ControlPlaneURL:  cfg.ControlPlaneURL,
 		ControlPlaneAuth: cfg.ControlPlaneAuth,
+		Cache:            templateCache,
 	})
 
 	templateRenderer := NewTemplateRenderer()
@@ -80,20 +162,200 @@ func NewExecutor(cfg *ExecutorConfig, logger *zap.Logger) (*Executor, error) {
 		BackupDir: backupDir,
 	})
 
-	return &Executor{
+	pluginDir := cfg.PluginDir
+	if pluginDir == "" {
+		pluginDir = filepath.Join(cfg.WorkDir, "plugins")
+	}
+
+	storePath := cfg.JobStorePath
+	if storePath == "" {
+		storePath = filepath.Join(cfg.WorkDir, "jobs.db")
+	}
+	store, err := NewJobStore(&JobStoreConfig{
+		Path:      storePath,
+		Retention: cfg.JobRetention,
+		MaxJobs:   cfg.MaxJobs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Jobs the previous agent process did not finish are failed, so the
+	// control plane does not wait for them forever
+	interrupted, err := store.Recover()
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to recover jobs: %w", err)
+	}
+	recovered := make([]*WorkflowResult, 0, len(interrupted))
+	for _, record := range interrupted {
+		logger.Warn("workflow interrupted by agent restart",
+			zap.String("workflow_id", record.Result.WorkflowID))
+		recovered = append(recovered, record.Result)
+	}
+	paused, err := store.List(StepStatusPaused, 0)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to recover paused jobs: %w", err)
+	}
+
+	executor := &Executor{
 		workDir:          cfg.WorkDir,
 		maxConcurrent:    maxConcurrent,
 		jobs:             make(map[string]*Job),
+		store:            store,
+		recovered:        recovered,
 		logger:           logger,
-		semaphore:        make(chan struct{}, maxConcurrent),
+		queue:            NewJobQueue(maxConcurrent, cfg.PriorityAging),
 		templateFetcher:  templateFetcher,
 		templateRenderer: templateRenderer,
 		fileManager:      fileManager,
-	}, nil
+		agentVersion:     cfg.AgentVersion,
+		agentToken:       cfg.AgentToken,
+		pluginDir:        pluginDir,
+		controlPlaneURL:  cfg.ControlPlaneURL,
+		callbacks:        make(map[string]*pendingCallback),
+		jobDiskQuota:     nonNegative(cfg.JobDiskQuota),
+		workDirQuota:     nonNegative(cfg.WorkDirQuota),
+		minFreeDisk:      nonNegative(cfg.MinFreeDisk),
+	}
+	executor.sweepJobDirs(paused)
+	executor.restorePaused(paused)
+	return executor, nil
+}
+
+// SetIdentity sets the identity template requests to the control plane are
+// signed with
+func (e *Executor) SetIdentity(id *identity.Identity) {
+	e.templateFetcher.SetIdentity(id)
 }
 
-// Execute starts workflow execution
+// SetControlPlaneToken replaces the token templates are fetched from the
+// control plane with, after the agent token is refreshed
+func (e *Executor) SetControlPlaneToken(token string) {
+	e.templateFetcher.SetControlPlaneConfig(e.controlPlaneURL, token)
+}
+
+// SetReporter sets the reporter that receives completed workflow results.
+// Jobs interrupted by an agent restart are reported to the first one.
+func (e *Executor) SetReporter(reporter *Reporter) {
+	e.mu.Lock()
+	e.reporter = reporter
+	recovered := e.recovered
+	if reporter != nil {
+		e.recovered = nil
+		reporter.OnReported(e.resultReported)
+	}
+	e.mu.Unlock()
+
+	if reporter != nil {
+		for _, result := range recovered {
+			reporter.Report(result)
+		}
+	}
+}
+
+// Close closes the job store
+func (e *Executor) Close() error {
+	return e.store.Close()
+}
+
+// persist writes a job's current state to the job store. A failed write
+// only costs durability, so it is logged rather than failing the job.
+func (e *Executor) persist(job *Job) {
+	record := &JobRecord{QueuedAt: job.QueuedAt, Result: job.Result}
+	if job.Status == StepStatusPaused {
+		record.Workflow = job.Workflow
+	}
+	if err := e.store.Put(record); err != nil {
+		e.logger.Warn("failed to persist job",
+			zap.String("workflow_id", job.ID),
+			zap.Error(err))
+	}
+}
+
+// SetDraining enables or disables drain mode. While draining, new workflows
+// are rejected with ErrDraining and queued and running jobs run to completion.
+func (e *Executor) SetDraining(draining bool) {
+	e.mu.Lock()
+	wasDraining := e.draining
+	e.draining = draining
+	drained := draining && !wasDraining && e.inFlight == 0
+	onDrained := e.onDrained
+	e.mu.Unlock()
+
+	if draining != wasDraining {
+		e.logger.Info("drain mode changed", zap.Bool("draining", draining))
+	}
+	if drained && onDrained != nil {
+		go onDrained()
+	}
+}
+
+// OnDrained sets a callback invoked once the last in-flight job finishes
+// while draining
+func (e *Executor) OnDrained(fn func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onDrained = fn
+}
+
+// DrainState returns "draining", "drained", or an empty string when the
+// agent is accepting workflows
+func (e *Executor) DrainState() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.drainStateLocked()
+}
+
+func (e *Executor) drainStateLocked() string {
+	switch {
+	case !e.draining:
+		return ""
+	case e.inFlight > 0:
+		return DrainStateDraining
+	default:
+		return DrainStateDrained
+	}
+}
+
+// DrainStatus returns the drain state with the number of in-flight jobs
+func (e *Executor) DrainStatus() any {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	state := e.drainStateLocked()
+	return map[string]any{
+		"draining":  e.draining,
+		"drained":   state == DrainStateDrained,
+		"state":     state,
+		"in_flight": e.inFlight,
+	}
+}
+
+// Execute starts workflow execution using the priority from the workflow definition
 func (e *Executor) Execute(workflowData []byte) (string, error) {
+	return e.ExecuteWithPriority(workflowData, "")
+}
+
+// ExecuteOptions are the delivery settings a workflow is started with
+type ExecuteOptions struct {
+	// Priority overrides the workflow definition's priority
+	Priority string
+	// RequestID is the control plane request the workflow was dispatched
+	// for. It is logged with the workflow and returned in its results.
+	RequestID string
+}
+
+// ExecuteWithPriority starts workflow execution with the given priority.
+// An empty priority falls back to the workflow definition, then normal.
+func (e *Executor) ExecuteWithPriority(workflowData []byte, priority string) (string, error) {
+	return e.ExecuteWithOptions(workflowData, ExecuteOptions{Priority: priority})
+}
+
+// ExecuteWithOptions starts workflow execution with the given options
+func (e *Executor) ExecuteWithOptions(workflowData []byte, opts ExecuteOptions) (string, error) {
+	priority := opts.Priority
 	workflow, err := ParseWorkflow(workflowData)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse workflow: %w", err)
@@ -103,6 +365,14 @@ func (e *Executor) Execute(workflowData []byte) (string, error) {
 		return "", fmt.Errorf("workflow validation failed: %w", err)
 	}
 
+	if priority == "" {
+		priority = string(workflow.Priority)
+	}
+	jobPriority, err := ParsePriority(priority)
+	if err != nil {
+		return "", err
+	}
+
 	// Generate workflow ID if not provided
 	if workflow.ID == "" {
 		workflow.ID = uuid.New().String()
@@ -115,75 +385,179 @@ func (e *Executor) Execute(workflowData []byte) (string, error) {
 		ID:         workflow.ID,
 		Workflow:   workflow,
 		Status:     StepStatusPending,
+		Priority:   jobPriority,
+		QueuedAt:   time.Now(),
 		CancelFunc: cancel,
 		Done:       make(chan struct{}),
 		Result: &WorkflowResult{
 			WorkflowID: workflow.ID,
 			Name:       workflow.Name,
 			Status:     StepStatusPending,
+			Priority:   jobPriority,
 			Steps:      make([]StepResult, 0),
+			Mode:       workflow.Mode,
+			RequestID:  opts.RequestID,
 		},
+		previewed: make(map[string]bool),
+	}
+
+	// Measured before locking, since walking the job directories is slow
+	var jobDirs uint64
+	if e.workDirQuota > 0 {
+		jobDirs = e.measureJobDirs()
 	}
 
 	e.mu.Lock()
+	if e.draining {
+		e.mu.Unlock()
+		cancel()
+		return "", ErrDraining
+	}
+	// The control plane delivers at least once; a redelivered workflow
+	// is already queued, running or finished and is not started again
+	if _, exists := e.jobs[job.ID]; exists {
+		e.mu.Unlock()
+		cancel()
+		return job.ID, nil
+	}
+	if _, err := e.store.Get(job.ID); err == nil {
+		e.mu.Unlock()
+		cancel()
+		return job.ID, nil
+	}
+	if err := e.reserve(job, jobDirs); err != nil {
+		e.mu.Unlock()
+		cancel()
+		e.jobLogger(job).Warn("workflow rejected",
+			zap.String("workflow_id", job.ID),
+			zap.Error(err))
+		return "", err
+	}
 	e.jobs[job.ID] = job
+	e.inFlight++
 	e.mu.Unlock()
 
+	e.persist(job)
+
 	// Start execution in background
 	go e.executeJob(ctx, job)
 
 	return job.ID, nil
 }
 
+// jobLogger returns the logger for a job's log lines, carrying the control
+// plane request ID when the dispatch had one
+func (e *Executor) jobLogger(job *Job) *zap.Logger {
+	if job.Result.RequestID != "" {
+		return e.logger.With(zap.String("request_id", job.Result.RequestID))
+	}
+	return e.logger
+}
+
+// acquireSlot waits for an execution slot for the job
+func (e *Executor) acquireSlot(ctx context.Context, job *Job) error {
+	if err := e.queue.Acquire(ctx, job.ID, job.Priority); err != nil {
+		return err
+	}
+	job.holdsSlot = true
+	atomic.AddInt32(&e.activeJobs, 1)
+	return nil
+}
+
+// releaseSlot gives up the job's execution slot, if it holds one
+func (e *Executor) releaseSlot(job *Job) {
+	if !job.holdsSlot {
+		return
+	}
+	job.holdsSlot = false
+	e.queue.Release()
+	atomic.AddInt32(&e.activeJobs, -1)
+}
+
 // executeJob executes a workflow job
 func (e *Executor) executeJob(ctx context.Context, job *Job) {
+	defer e.finishJob(job)
 	defer close(job.Done)
+	defer e.reportResult(job)
+	defer e.completeJob(job)
+	defer e.releaseSlot(job)
+
+	workflow := job.Workflow
 
-	// Acquire semaphore
-	select {
-	case e.semaphore <- struct{}{}:
-		defer func() { <-e.semaphore }()
-	case <-ctx.Done():
-		job.Status = StepStatusCancelled
-		job.Result.Status = StepStatusCancelled
+	if err := e.prepareJobDir(job); err != nil {
+		job.Status = StepStatusFailed
+		job.Result.Status = StepStatusFailed
+		job.Result.Error = err.Error()
 		return
 	}
 
-	atomic.AddInt32(&e.activeJobs, 1)
-	defer atomic.AddInt32(&e.activeJobs, -1)
-
-	job.StartedAt = time.Now()
-	job.Status = StepStatusRunning
-	job.Result.StartedAt = job.StartedAt
-	job.Result.Status = StepStatusRunning
-
-	workflow := job.Workflow
+	if job.Status == StepStatusPaused {
+		// Paused before an agent restart: it takes a slot once resumed
+		if err := e.waitResumed(ctx, job); err != nil {
+			job.Status = StepStatusCancelled
+			job.Result.Status = StepStatusCancelled
+			e.executeHooks(ctx, job, workflow.OnCancel)
+			return
+		}
+	} else {
+		// Wait for an execution slot
+		if err := e.acquireSlot(ctx, job); err != nil {
+			job.Status = StepStatusCancelled
+			job.Result.Status = StepStatusCancelled
+			return
+		}
 
-	// Create workflow timeout context
-	if workflow.Timeout > 0 {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, workflow.Timeout)
-		defer cancel()
+		job.StartedAt = time.Now()
+		job.Status = StepStatusRunning
+		job.Result.StartedAt = job.StartedAt
+		job.Result.Status = StepStatusRunning
+		job.Result.Environment = CaptureEnvironment(e.workDir, e.agentVersion)
+		e.persist(job)
+
+		e.jobLogger(job).Info("starting workflow execution",
+			zap.String("workflow_id", job.ID),
+			zap.String("workflow_name", workflow.Name),
+			zap.String("priority", string(job.Priority)),
+			zap.String("mode", workflow.Mode),
+			zap.Duration("queued", job.StartedAt.Sub(job.QueuedAt)))
 	}
 
-	e.logger.Info("starting workflow execution",
-		zap.String("workflow_id", job.ID),
-		zap.String("workflow_name", workflow.Name))
+	// Steps are stopped when the job directory outgrows its quota
+	quotaCtx, stopWatch := e.watchJobDir(ctx, job)
+	defer stopWatch()
 
-	// Execute steps
+	// Create workflow timeout context; it is renewed after a pause
+	runCtx, stopRun := e.runContext(quotaCtx, job)
+	defer func() { stopRun() }()
+
+	// Execute steps, from the first one not run when the job was paused
 	success := true
-	for _, step := range workflow.Steps {
+steps:
+	for i := len(job.Result.Steps); i < len(workflow.Steps); i++ {
+		step := workflow.Steps[i]
+		if e.pauseDue(job, i) && runCtx.Err() == nil {
+			stopRun()
+			if err := e.waitResumed(ctx, job); err == nil {
+				runCtx, stopRun = e.runContext(quotaCtx, job)
+			}
+		}
+
 		select {
-		case <-ctx.Done():
+		case <-runCtx.Done():
+			if diskQuotaExceeded(quotaCtx) != nil {
+				success = false
+				break steps
+			}
 			job.Status = StepStatusCancelled
 			job.Result.Status = StepStatusCancelled
-			e.executeHooks(ctx, job, workflow.OnCancel)
+			e.executeHooks(runCtx, job, workflow.OnCancel)
 			return
 		default:
 		}
 
-		result := e.executeStep(ctx, job, &step)
+		result := e.executeStep(runCtx, job, &step)
 		job.Result.Steps = append(job.Result.Steps, *result)
+		e.persist(job)
 
 		if result.Status == StepStatusFailed && !step.ContinueOnError {
 			success = false
@@ -195,34 +569,113 @@ func (e *Executor) executeJob(ctx context.Context, job *Job) {
 	job.Result.EndedAt = job.EndedAt
 	job.Result.Duration = job.EndedAt.Sub(job.StartedAt)
 
+	// A job stopped over its quota fails, and its hooks still get to run
+	hookCtx := runCtx
+	if err := diskQuotaExceeded(quotaCtx); err != nil {
+		success = false
+		job.Result.Error = err.Error()
+		hookCtx = ctx
+	}
+
 	if success {
 		job.Status = StepStatusSuccess
 		job.Result.Status = StepStatusSuccess
-		e.executeHooks(ctx, job, workflow.OnSuccess)
+		e.executeHooks(hookCtx, job, workflow.OnSuccess)
 	} else {
 		job.Status = StepStatusFailed
 		job.Result.Status = StepStatusFailed
-		e.executeHooks(ctx, job, workflow.OnFailure)
+		e.executeHooks(hookCtx, job, workflow.OnFailure)
 	}
 
-	e.logger.Info("workflow execution completed",
+	e.jobLogger(job).Info("workflow execution completed",
 		zap.String("workflow_id", job.ID),
 		zap.String("status", string(job.Status)),
 		zap.Duration("duration", job.Result.Duration))
 }
 
+// completeJob stamps the end of a job that was cancelled before finishing
+// and persists its final state
+func (e *Executor) completeJob(job *Job) {
+	if job.EndedAt.IsZero() {
+		job.EndedAt = time.Now()
+		job.Result.EndedAt = job.EndedAt
+		if !job.StartedAt.IsZero() {
+			job.Result.Duration = job.EndedAt.Sub(job.StartedAt)
+		}
+	}
+	e.persist(job)
+}
+
+// finishJob removes a finished job from the live jobs, releases its
+// in-flight slot and resources and signals drain completion
+func (e *Executor) finishJob(job *Job) {
+	e.mu.Lock()
+	delete(e.jobs, job.ID)
+	e.unreserve(job)
+	e.inFlight--
+	drained := e.draining && e.inFlight == 0
+	onDrained := e.onDrained
+	e.mu.Unlock()
+
+	if drained {
+		e.logger.Info("agent drained, no workflows in flight")
+		if onDrained != nil {
+			onDrained()
+		}
+	}
+}
+
+// reportResult sends the final workflow result to the control plane
+func (e *Executor) reportResult(job *Job) {
+	e.mu.RLock()
+	reporter := e.reporter
+	e.mu.RUnlock()
+
+	if reporter != nil {
+		reporter.Report(job.Result)
+	} else {
+		e.removeJobDir(job.ID)
+	}
+}
+
+// reportProgress reports the state of a job still in flight, such as a
+// pause. The result is copied since the job goes on changing it.
+func (e *Executor) reportProgress(job *Job) {
+	e.mu.RLock()
+	reporter := e.reporter
+	e.mu.RUnlock()
+
+	if reporter != nil {
+		snapshot := *job.Result
+		snapshot.Steps = append([]StepResult(nil), job.Result.Steps...)
+		reporter.Report(&snapshot)
+	}
+}
+
 // executeStep executes a single step
 func (e *Executor) executeStep(ctx context.Context, job *Job, step *Step) *StepResult {
 	result := &StepResult{
-		StepID:    step.ID,
-		StepName:  step.Name,
-		Status:    StepStatusRunning,
-		StartedAt: time.Now(),
+		StepID:       step.ID,
+		StepName:     step.Name,
+		Status:       StepStatusRunning,
+		StartedAt:    time.Now(),
+		MatrixParent: step.MatrixParent,
+		Matrix:       step.MatrixValues,
+		RequestID:    job.Result.RequestID,
+	}
+
+	// Steps skipped in validate mode do not evaluate their condition either
+	if reason := validateModeSkip(job, step); reason != "" {
+		result.Status = StepStatusSkipped
+		result.Output = reason
+		result.EndedAt = time.Now()
+		result.Duration = result.EndedAt.Sub(result.StartedAt)
+		return result
 	}
 
 	// Check condition
 	if step.Condition != "" {
-		if !e.evaluateCondition(ctx, step.Condition, job) {
+		if !e.evaluateCondition(ctx, step.Condition, job, step) {
 			result.Status = StepStatusSkipped
 			result.EndedAt = time.Now()
 			result.Duration = result.EndedAt.Sub(result.StartedAt)
@@ -238,45 +691,34 @@ func (e *Executor) executeStep(ctx context.Context, job *Job, step *Step) *StepR
 		defer cancel()
 	}
 
-	// Execute with retries
+	// Execute with retries, or the iterations of a repeated step
 	var lastErr error
-	for attempt := 0; attempt <= step.RetryCount; attempt++ {
-		result.RetryCount = attempt
-
-		if attempt > 0 {
-			e.logger.Info("retrying step",
-				zap.String("step_id", step.ID),
-				zap.Int("attempt", attempt))
-			time.Sleep(step.RetryDelay)
-		}
-
-		var output string
-		var exitCode int
-		var err error
-
-		switch step.Type {
-		case StepTypeCommand:
-			output, exitCode, err = e.executeCommand(stepCtx, step, job)
-		case StepTypeScript:
-			output, exitCode, err = e.executeScript(stepCtx, step, job)
-		case StepTypeTemplate:
-			output, exitCode, err = e.executeTemplate(stepCtx, step, job)
-		default:
-			err = fmt.Errorf("unsupported step type: %s", step.Type)
-			exitCode = 1
-		}
+	if step.Repeat != nil {
+		lastErr = e.executeRepeat(stepCtx, job, step, result)
+	} else {
+		for attempt := 0; attempt <= step.RetryCount; attempt++ {
+			result.RetryCount = attempt
+
+			if attempt > 0 {
+				e.jobLogger(job).Info("retrying step",
+					zap.String("step_id", step.ID),
+					zap.Int("attempt", attempt))
+				time.Sleep(step.RetryDelay)
+			}
 
-		result.Output = output
-		result.ExitCode = exitCode
+			output, exitCode, err := e.runStep(stepCtx, job, step, result)
+			result.Output = output
+			result.ExitCode = exitCode
 
-		if err == nil && exitCode == 0 {
-			result.Status = StepStatusSuccess
-			break
-		}
+			if err == nil && exitCode == 0 {
+				result.Status = StepStatusSuccess
+				break
+			}
 
-		lastErr = err
-		if exitCode != 0 {
-			lastErr = fmt.Errorf("command exited with code %d", exitCode)
+			lastErr = err
+			if exitCode != 0 {
+				lastErr = fmt.Errorf("command exited with code %d", exitCode)
+			}
 		}
 	}
 
@@ -287,10 +729,21 @@ func (e *Executor) executeStep(ctx context.Context, job *Job, step *Step) *StepR
 		}
 	}
 
+	// Output that does not parse fails the step, since later steps rely on it
+	if result.Status == StepStatusSuccess && step.OutputParser != nil {
+		parsed, err := step.OutputParser.Parse(stepStdout(result.Output))
+		if err != nil {
+			result.Status = StepStatusFailed
+			result.Error = fmt.Sprintf("output_parser: %v", err)
+		} else {
+			result.ParsedOutput = parsed
+		}
+	}
+
 	result.EndedAt = time.Now()
 	result.Duration = result.EndedAt.Sub(result.StartedAt)
 
-	e.logger.Info("step completed",
+	e.jobLogger(job).Info("step completed",
 		zap.String("workflow_id", job.ID),
 		zap.String("step_id", step.ID),
 		zap.String("status", string(result.Status)),
@@ -299,31 +752,84 @@ func (e *Executor) executeStep(ctx context.Context, job *Job, step *Step) *StepR
 	return result
 }
 
+// runStep runs a step once by its type. Plugin and callback steps set the
+// result's data.
+func (e *Executor) runStep(ctx context.Context, job *Job, step *Step, result *StepResult) (string, int, error) {
+	var output string
+	var exitCode int
+	var err error
+
+	switch step.Type {
+	case StepTypeCommand:
+		output, exitCode, err = e.executeCommand(ctx, step, job)
+	case StepTypeScript:
+		output, exitCode, err = e.executeScript(ctx, step, job)
+	case StepTypeTemplate:
+		output, exitCode, err = e.executeTemplate(ctx, step, job)
+	case StepTypePlugin:
+		output, exitCode, result.Data, err = e.executePlugin(ctx, step, job)
+	case StepTypeCallback:
+		output, exitCode, result.Data, err = e.executeCallback(ctx, step, job)
+	default:
+		err = fmt.Errorf("unsupported step type: %s", step.Type)
+		exitCode = 1
+	}
+	return output, exitCode, err
+}
+
+// validateModeSkip returns why a step does not run in validate mode, or an
+// empty string when it runs. A diff-only template step checking a file an
+// earlier step only previewed is skipped too, since the file was not written.
+func validateModeSkip(job *Job, step *Step) string {
+	if job.Workflow.Mode != WorkflowModeValidate {
+		return ""
+	}
+	switch step.Type {
+	case StepTypeCommand, StepTypeScript:
+		if !step.Precheck {
+			return "not run in validate mode"
+		}
+	case StepTypePlugin, StepTypeCallback:
+		return "not run in validate mode"
+	case StepTypeTemplate:
+		if step.Template.DiffOnly && job.previewed[step.Template.Dest] {
+			return fmt.Sprintf("not run in validate mode: %s was previewed, not deployed", step.Template.Dest)
+		}
+	}
+	return ""
+}
+
 // executeCommand executes a command step
 func (e *Executor) executeCommand(ctx context.Context, step *Step, job *Job) (string, int, error) {
-	var cmd *exec.Cmd
+	argv := step.Args
+	if len(argv) == 0 {
+		argv = []string{"sh", "-c", step.Command}
+	}
 
-	if len(step.Args) > 0 {
-		cmd = exec.CommandContext(ctx, step.Args[0], step.Args[1:]...)
-	} else {
-		cmd = exec.CommandContext(ctx, "sh", "-c", step.Command)
+	// Wrap in a container when the step is sandboxed
+	var runtime, container string
+	if step.Sandbox != nil {
+		var err error
+		if runtime, err = e.sandboxRuntime(step); err != nil {
+			return "", 1, err
+		}
+		if runtime != "" {
+			container = sandboxName(job, step)
+			argv = sandboxArgs(runtime, container, step, job, argv)
+		}
 	}
 
-	// Set working directory
-	if step.WorkDir != "" {
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+
+	// Set working directory; a sandboxed step's work_dir is inside the container
+	if step.WorkDir != "" && container == "" {
 		cmd.Dir = step.WorkDir
 	} else {
-		cmd.Dir = e.workDir
+		cmd.Dir = e.jobDir(job)
 	}
 
 	// Set environment
-	cmd.Env = os.Environ()
-	for k, v := range job.Workflow.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
-	}
-	for k, v := range step.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
-	}
+	cmd.Env = e.stepEnv(job, step)
 
 	// Capture output
 	var stdout, stderr bytes.Buffer
@@ -331,10 +837,13 @@ func (e *Executor) executeCommand(ctx context.Context, step *Step, job *Job) (st
 	cmd.Stderr = &stderr
 
 	err := cmd.Run()
+	if container != "" && ctx.Err() != nil {
+		e.removeSandbox(runtime, container)
+	}
 
 	output := stdout.String()
 	if stderr.Len() > 0 {
-		output += "\n--- stderr ---\n" + stderr.String()
+		output += stderrSeparator + stderr.String()
 	}
 
 	exitCode := 0
@@ -351,21 +860,48 @@ func (e *Executor) executeCommand(ctx context.Context, step *Step, job *Job) (st
 
 // executeScript executes a script step
 func (e *Executor) executeScript(ctx context.Context, step *Step, job *Job) (string, int, error) {
-	// Create temporary script file
-	tmpDir := filepath.Join(e.workDir, "scripts")
-	if err := os.MkdirAll(tmpDir, 0755); err != nil {
-		return "", 1, fmt.Errorf("failed to create script directory: %w", err)
+	// Create temporary script file in the job directory
+	tmpDir := filepath.Join(e.jobDir(job), jobTmpDir)
+
+	interpreter, err := scriptInterpreter(step)
+	if err != nil {
+		return "", 1, err
 	}
 
-	scriptPath := filepath.Join(tmpDir, fmt.Sprintf("%s-%s.sh", job.ID, step.ID))
+	scriptPath := filepath.Join(tmpDir, fmt.Sprintf("%s-%s%s", job.ID, step.ID, interpreters[interpreter].ext))
 	if err := os.WriteFile(scriptPath, []byte(step.Script), 0755); err != nil {
 		return "", 1, fmt.Errorf("failed to write script: %w", err)
 	}
 	defer os.Remove(scriptPath)
 
+	run := *step
+	if step.Sandbox != nil {
+		runtime, err := e.sandboxRuntime(step)
+		if err != nil {
+			return "", 1, err
+		}
+		if runtime == "" {
+			run.Sandbox = nil
+		}
+	}
+
+	var args []string
+	if run.Sandbox != nil {
+		// Mount the script into the container and run it with the image's
+		// interpreter
+		target := path.Join(sandboxScriptDir, filepath.Base(scriptPath))
+		sandbox := *run.Sandbox
+		sandbox.Mounts = append(append([]SandboxMount(nil), sandbox.Mounts...),
+			SandboxMount{Source: scriptPath, Target: target, ReadOnly: true})
+		run.Sandbox = &sandbox
+		args = containerScriptCommand(interpreter, target)
+	} else if args, err = scriptCommand(interpreter, scriptPath); err != nil {
+		return "", 1, err
+	}
+
 	// Execute the script
-	step.Args = []string{"sh", scriptPath}
-	return e.executeCommand(ctx, step, job)
+	run.Args = args
+	return e.executeCommand(ctx, &run, job)
 }
 
 // executeTemplate executes a template step (Salt Stack-like template deployment)
@@ -380,12 +916,14 @@ func (e *Executor) executeTemplate(ctx context.Context, step *Step, job *Job) (s
 	renderCtx := NewRenderContext().
 		WithVars(job.Workflow.Vars).
 		WithEnv(job.Workflow.Env).
-		WithSystemFacts()
+		WithSystemFacts().
+		WithGrains(e.Grains()).
+		WithSteps(job.Result.Steps)
 
 	// Add step-specific env vars
 	renderCtx.WithEnv(step.Env)
 
-	e.logger.Info("executing template step",
+	e.jobLogger(job).Info("executing template step",
 		zap.String("step_id", step.ID),
 		zap.String("source", step.Template.Source),
 		zap.String("dest", step.Template.Dest))
@@ -397,13 +935,34 @@ func (e *Executor) executeTemplate(ctx context.Context, step *Step, job *Job) (s
 	}
 	outputBuilder.WriteString(fmt.Sprintf("Destination: %s\n", destPath))
 
+	// Uploaded content is deployed verbatim, downloaded only if it differs
+	if step.Template.Raw {
+		outputBuilder.WriteString(fmt.Sprintf("Uploaded template from: %s (%d bytes, deployed verbatim)\n",
+			step.Template.Source, step.Template.ContentSize))
+		return e.deployTemplate(job, step, destPath, &DeployOptions{
+			Hash: strings.TrimPrefix(step.Template.ContentHash, "sha256:"),
+			Write: func(dir string) (string, error) {
+				path, err := e.templateFetcher.FetchFile(ctx, step.Template.Source, step.Template.ContentHash, step.Template.ContentSize, dir)
+				if err != nil {
+					return "", fmt.Errorf("failed to fetch template: %w", err)
+				}
+				outputBuilder.WriteString(fmt.Sprintf("Template fetched successfully (%d bytes)\n", step.Template.ContentSize))
+				return path, nil
+			},
+		}, &outputBuilder)
+	}
+
 	// 2. Fetch the template
 	outputBuilder.WriteString(fmt.Sprintf("Fetching template from: %s\n", step.Template.Source))
-	fetchResult, err := e.templateFetcher.Fetch(ctx, step.Template.Source)
+	fetchResult, err := e.templateFetcher.Fetch(ctx, step.Template.Source, step.Template.ContentHash)
 	if err != nil {
 		return outputBuilder.String(), 1, fmt.Errorf("failed to fetch template: %w", err)
 	}
-	outputBuilder.WriteString(fmt.Sprintf("Template fetched successfully (%d bytes)\n", len(fetchResult.Content)))
+	if fetchResult.Cached {
+		outputBuilder.WriteString(fmt.Sprintf("Template served from cache (%d bytes)\n", len(fetchResult.Content)))
+	} else {
+		outputBuilder.WriteString(fmt.Sprintf("Template fetched successfully (%d bytes)\n", len(fetchResult.Content)))
+	}
 
 	// 3. Render the template with variables
 	outputBuilder.WriteString("Rendering template with variables...\n")
@@ -413,17 +972,25 @@ func (e *Executor) executeTemplate(ctx context.Context, step *Step, job *Job) (s
 	}
 	outputBuilder.WriteString(fmt.Sprintf("Template rendered successfully (%d bytes)\n", len(renderResult.Content)))
 
-	// 4. Deploy the file
-	deployOpts := &DeployOptions{
-		Dest:       destPath,
-		Content:    renderResult.Content,
-		Mode:       step.Template.Mode,
-		Owner:      step.Template.Owner,
-		Group:      step.Template.Group,
-		Backup:     step.Template.Backup,
-		DiffOnly:   step.Template.DiffOnly,
-		CreateDirs: step.Template.CreateDirs,
+	return e.deployTemplate(job, step, destPath, &DeployOptions{Content: renderResult.Content}, &outputBuilder)
+}
+
+// deployTemplate deploys a template step's content to destPath with the
+// step's file options and reports the result; validate mode only previews it
+func (e *Executor) deployTemplate(job *Job, step *Step, destPath string, deployOpts *DeployOptions, outputBuilder *bytes.Buffer) (string, int, error) {
+	diffOnly := step.Template.DiffOnly
+	if job.Workflow.Mode == WorkflowModeValidate && !diffOnly {
+		diffOnly = true
+		job.previewed[step.Template.Dest] = true
+		outputBuilder.WriteString("Validate mode: previewing changes only\n")
 	}
+	deployOpts.Dest = destPath
+	deployOpts.Mode = step.Template.Mode
+	deployOpts.Owner = step.Template.Owner
+	deployOpts.Group = step.Template.Group
+	deployOpts.Backup = step.Template.Backup
+	deployOpts.DiffOnly = diffOnly
+	deployOpts.CreateDirs = step.Template.CreateDirs
 
 	deployResult := e.fileManager.Deploy(deployOpts)
 
@@ -448,8 +1015,12 @@ func (e *Executor) executeTemplate(ctx context.Context, step *Step, job *Job) (s
 	if deployResult.Status == "error" {
 		exitCode = 1
 	}
+	if step.Template.FailOnChange && deployResult.Changed {
+		outputBuilder.WriteString(fmt.Sprintf("Error: %s does not match the rendered template\n", destPath))
+		exitCode = 1
+	}
 
-	e.logger.Info("template step completed",
+	e.jobLogger(job).Info("template step completed",
 		zap.String("step_id", step.ID),
 		zap.String("status", deployResult.Status),
 		zap.Bool("changed", deployResult.Changed))
@@ -465,34 +1036,53 @@ func (e *Executor) executeHooks(ctx context.Context, job *Job, hooks []Step) {
 	}
 }
 
-// evaluateCondition evaluates a step condition
-func (e *Executor) evaluateCondition(ctx context.Context, condition string, job *Job) bool {
+// evaluateCondition evaluates a step condition, with extra variables added
+// to the step's environment
+func (e *Executor) evaluateCondition(ctx context.Context, condition string, job *Job, step *Step, env ...string) bool {
 	// Simple condition evaluation - executes as shell command
 	cmd := exec.CommandContext(ctx, "sh", "-c", condition)
-	cmd.Dir = e.workDir
+	cmd.Dir = e.jobDir(job)
+	cmd.Env = append(e.stepEnv(job, step), env...)
 	return cmd.Run() == nil
 }
 
-// GetStatus returns the status of a workflow
+// GetStatus returns the status of a workflow, as of its last completed step
 func (e *Executor) GetStatus(workflowID string) (*WorkflowResult, error) {
-	e.mu.RLock()
-	job, ok := e.jobs[workflowID]
-	e.mu.RUnlock()
-
-	if !ok {
+	record, err := e.store.Get(workflowID)
+	if errors.Is(err, ErrJobNotFound) {
 		return nil, fmt.Errorf("workflow not found: %s", workflowID)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	return record.Result, nil
+}
 
-	return job.Result, nil
+// ListJobs returns up to limit workflows with a status; a limit of 0
+// returns them all
+func (e *Executor) ListJobs(status StepStatus, limit int) ([]*WorkflowResult, error) {
+	records, err := e.store.List(status, limit)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*WorkflowResult, len(records))
+	for i, record := range records {
+		results[i] = record.Result
+	}
+	return results, nil
 }
 
-// Cancel cancels a running workflow
+// Cancel cancels a running workflow; a finished one is left as it is
 func (e *Executor) Cancel(workflowID string) error {
 	e.mu.RLock()
 	job, ok := e.jobs[workflowID]
 	e.mu.RUnlock()
 
 	if !ok {
+		if _, err := e.store.Get(workflowID); err == nil {
+			return nil
+		}
 		return fmt.Errorf("workflow not found: %s", workflowID)
 	}
 
@@ -508,67 +1098,65 @@ func (e *Executor) ActiveJobs() int {
 	return int(atomic.LoadInt32(&e.activeJobs))
 }
 
+// QueueStats returns job queue metrics
+func (e *Executor) QueueStats() QueueStats {
+	return e.queue.Stats()
+}
+
 // WaitForJob waits for a job to complete
 func (e *Executor) WaitForJob(workflowID string) error {
 	e.mu.RLock()
 	job, ok := e.jobs[workflowID]
 	e.mu.RUnlock()
 
-	if !ok {
+	if ok {
+		<-job.Done
+		return nil
+	}
+	if _, err := e.store.Get(workflowID); err != nil {
 		return fmt.Errorf("workflow not found: %s", workflowID)
 	}
-
-	<-job.Done
 	return nil
 }
 
-// StreamOutput streams the output of a running job
+// StreamOutput writes the output of a job's steps as they complete, until
+// the job finishes
 func (e *Executor) StreamOutput(workflowID string, w io.Writer) error {
-	e.mu.RLock()
-	job, ok := e.jobs[workflowID]
-	e.mu.RUnlock()
-
-	if !ok {
-		return fmt.Errorf("workflow not found: %s", workflowID)
-	}
-
-	// Wait for job to complete while streaming output
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
-
 	lastStep := 0
 	for {
-		select {
-		case <-job.Done:
-			// Stream final output
-			for i := lastStep; i < len(job.Result.Steps); i++ {
-				fmt.Fprintf(w, "[%s] %s\n", job.Result.Steps[i].Status, job.Result.Steps[i].Output)
+		// Watch before reading, so a step completing in between is not missed
+		changed := e.store.Watch(workflowID)
+		record, err := e.store.Get(workflowID)
+		if err != nil {
+			if errors.Is(err, ErrJobNotFound) && lastStep > 0 {
+				// Removed by retention while streaming
+				return nil
 			}
-			return nil
-		case <-ticker.C:
-			// Stream new output
-			for i := lastStep; i < len(job.Result.Steps); i++ {
-				fmt.Fprintf(w, "[%s] %s\n", job.Result.Steps[i].Status, job.Result.Steps[i].Output)
+			if errors.Is(err, ErrJobNotFound) {
+				return fmt.Errorf("workflow not found: %s", workflowID)
 			}
-			lastStep = len(job.Result.Steps)
+			return err
 		}
-	}
-}
 
-// Cleanup removes completed jobs older than maxAge
-func (e *Executor) Cleanup(maxAge time.Duration) int {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-
-	cutoff := time.Now().Add(-maxAge)
-	removed := 0
+		steps := record.Result.Steps
+		for i := lastStep; i < len(steps); i++ {
+			fmt.Fprintf(w, "[%s] %s\n", steps[i].Status, steps[i].Output)
+		}
+		lastStep = len(steps)
 
-	for id, job := range e.jobs {
-		if job.Status != StepStatusRunning && job.EndedAt.Before(cutoff) {
-			delete(e.jobs, id)
-			removed++
+		if terminal(record.Result.Status) {
+			return nil
 		}
+		<-changed
 	}
+}
 
+// Cleanup removes finished jobs older than maxAge, in addition to the job
+// store's own retention
+func (e *Executor) Cleanup(maxAge time.Duration) int {
+	removed, err := e.store.Prune(maxAge)
+	if err != nil {
+		e.logger.Warn("failed to remove old jobs", zap.Error(err))
+	}
 	return removed
 }
@@ -0,0 +1,58 @@
+// Package probe provides workflow execution functionality.
+package probe
+
+import (
+	"os"
+	"runtime"
+	"time"
+)
+
+// EnvironmentFacts is a snapshot of the host taken when a workflow starts,
+// attached to the result so failures can be read in context
+type EnvironmentFacts struct {
+	Hostname       string    `json:"hostname"`
+	OS             string    `json:"os"`
+	Arch           string    `json:"arch"`
+	Kernel         string    `json:"kernel,omitempty"`
+	AgentVersion   string    `json:"agent_version,omitempty"`
+	DiskPath       string    `json:"disk_path,omitempty"`
+	DiskFreeBytes  uint64    `json:"disk_free_bytes,omitempty"`
+	DiskTotalBytes uint64    `json:"disk_total_bytes,omitempty"`
+	LoadAverage    []float64 `json:"load_average,omitempty"`
+	NumCPU         int       `json:"num_cpu"`
+	CapturedAt     time.Time `json:"captured_at"`
+}
+
+// CaptureEnvironment collects environment facts. Facts that cannot be read
+// on this platform are left empty rather than failing the workflow.
+func CaptureEnvironment(workDir, agentVersion string) *EnvironmentFacts {
+	facts := &EnvironmentFacts{
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+		AgentVersion: agentVersion,
+		NumCPU:       runtime.NumCPU(),
+		CapturedAt:   time.Now(),
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		facts.Hostname = hostname
+	}
+
+	if kernel, err := kernelVersion(); err == nil {
+		facts.Kernel = kernel
+	}
+
+	if workDir != "" {
+		if free, total, err := diskSpace(workDir); err == nil {
+			facts.DiskPath = workDir
+			facts.DiskFreeBytes = free
+			facts.DiskTotalBytes = total
+		}
+	}
+
+	if load, err := loadAverage(); err == nil {
+		facts.LoadAverage = load
+	}
+
+	return facts
+}
@@ -0,0 +1,106 @@
+//go:build !windows
+// +build !windows
+
+// Package probe provides workflow execution functionality.
+package probe
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// kernelVersion returns the kernel release reported by uname
+func kernelVersion() (string, error) {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return "", err
+	}
+	return unix.ByteSliceToString(uts.Release[:]), nil
+}
+
+// osRelease returns the distribution ID and release from os-release, e.g.
+// debian and 12, which advisories for distribution packages are matched by
+func osRelease() (id, versionID string, err error) {
+	data, err := os.ReadFile("/etc/os-release")
+	if err != nil {
+		if data, err = os.ReadFile("/usr/lib/os-release"); err != nil {
+			return "", "", err
+		}
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			id = value
+		case "VERSION_ID":
+			versionID = value
+		}
+	}
+	if id == "" || versionID == "" {
+		return "", "", fmt.Errorf("os-release has no ID or VERSION_ID")
+	}
+	return id, versionID, nil
+}
+
+// diskSpace returns free and total disk space for a path
+func diskSpace(path string) (free, total uint64, err error) {
+	var stat unix.Statfs_t
+	if err = unix.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	free = uint64(stat.Bavail) * uint64(stat.Bsize)
+	total = uint64(stat.Blocks) * uint64(stat.Bsize)
+	return
+}
+
+// availableMemory returns the memory available for new work, MemAvailable
+// in /proc/meminfo
+func availableMemory() (uint64, error) {
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse MemAvailable: %w", err)
+		}
+		return kb * 1024, nil
+	}
+	return 0, fmt.Errorf("/proc/meminfo has no MemAvailable")
+}
+
+// loadAverage returns the 1, 5 and 15 minute load averages from /proc/loadavg
+func loadAverage() ([]float64, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("unexpected /proc/loadavg format")
+	}
+
+	load := make([]float64, 3)
+	for i := range load {
+		if load[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return nil, fmt.Errorf("failed to parse load average: %w", err)
+		}
+	}
+	return load, nil
+}
@@ -0,0 +1,66 @@
+//go:build windows
+// +build windows
+
+// Package probe provides workflow execution functionality.
+package probe
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var procGlobalMemoryStatusEx = windows.NewLazySystemDLL("kernel32.dll").NewProc("GlobalMemoryStatusEx")
+
+// memoryStatusEx is the MEMORYSTATUSEX structure
+type memoryStatusEx struct {
+	Length               uint32
+	MemoryLoad           uint32
+	TotalPhys            uint64
+	AvailPhys            uint64
+	TotalPageFile        uint64
+	AvailPageFile        uint64
+	TotalVirtual         uint64
+	AvailVirtual         uint64
+	AvailExtendedVirtual uint64
+}
+
+// kernelVersion returns the Windows version and build number
+func kernelVersion() (string, error) {
+	v := windows.RtlGetVersion()
+	return fmt.Sprintf("%d.%d.%d", v.MajorVersion, v.MinorVersion, v.BuildNumber), nil
+}
+
+// osRelease is not available on Windows, whose packages are not matched by
+// distribution release
+func osRelease() (id, versionID string, err error) {
+	return "", "", fmt.Errorf("os-release is not available on windows")
+}
+
+// diskSpace returns free and total disk space for a path
+func diskSpace(path string) (free, total uint64, err error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err = windows.GetDiskFreeSpaceEx(pathPtr, &free, &total, nil); err != nil {
+		return 0, 0, err
+	}
+	return
+}
+
+// availableMemory returns the available physical memory
+func availableMemory() (uint64, error) {
+	status := memoryStatusEx{}
+	status.Length = uint32(unsafe.Sizeof(status))
+	if ok, _, err := procGlobalMemoryStatusEx.Call(uintptr(unsafe.Pointer(&status))); ok == 0 {
+		return 0, err
+	}
+	return status.AvailPhys, nil
+}
+
+// loadAverage is not available on Windows
+func loadAverage() ([]float64, error) {
+	return nil, fmt.Errorf("load average not supported on windows")
+}
@@ -9,8 +9,9 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
 	"time"
+
+	"github.com/yourorg/vm-agent/pkg/diff"
 )
 
 // FileManager handles file operations for template deployment
@@ -96,6 +97,12 @@ type DeployOptions struct {
 	CreateDirs bool
 	// DirMode is the permissions for created directories
 	DirMode string
+	// Hash and Write replace Content for content too large to hold in
+	// memory. Hash is the content's SHA-256 in hex; Write stores the
+	// content in a new file in the given directory and returns its path.
+	// Write is only called when the file changes, and no diff is produced.
+	Hash  string
+	Write func(dir string) (string, error)
 }
 
 // Deploy deploys content to a file with backup and diff support
@@ -106,7 +113,11 @@ func (m *FileManager) Deploy(opts *DeployOptions) *DeployResult {
 	}
 
 	// Calculate hash of new content
-	result.NewHash = hashContent(opts.Content)
+	if opts.Write != nil {
+		result.NewHash = opts.Hash
+	} else {
+		result.NewHash = hashContent(opts.Content)
+	}
 
 	// Check if destination exists
 	existingInfo, err := m.GetFileInfo(opts.Dest)
@@ -126,9 +137,11 @@ func (m *FileManager) Deploy(opts *DeployOptions) *DeployResult {
 		}
 
 		// Read existing content for diff
-		existingContent, err := os.ReadFile(opts.Dest)
-		if err == nil {
-			result.Diff = generateDiff(opts.Dest, string(existingContent), opts.Content)
+		if opts.Write == nil {
+			existingContent, err := os.ReadFile(opts.Dest)
+			if err == nil {
+				result.Diff = generateDiff(opts.Dest, string(existingContent), opts.Content)
+			}
 		}
 
 		// If DiffOnly, return here
@@ -178,23 +191,18 @@ func (m *FileManager) Deploy(opts *DeployOptions) *DeployResult {
 	}
 
 	// Write to temporary file first (atomic write)
-	tempFile, err := os.CreateTemp(filepath.Dir(opts.Dest), ".tmp-")
-	if err != nil {
-		result.Error = fmt.Sprintf("failed to create temp file: %v", err)
-		result.Status = "error"
-		return result
+	write := opts.Write
+	if write == nil {
+		write = func(dir string) (string, error) {
+			return writeTempFile(dir, opts.Content)
+		}
 	}
-	tempPath := tempFile.Name()
-
-	// Write content
-	if _, err := tempFile.WriteString(opts.Content); err != nil {
-		tempFile.Close()
-		os.Remove(tempPath)
-		result.Error = fmt.Sprintf("failed to write content: %v", err)
+	tempPath, err := write(filepath.Dir(opts.Dest))
+	if err != nil {
+		result.Error = err.Error()
 		result.Status = "error"
 		return result
 	}
-	tempFile.Close()
 
 	// Set file permissions (platform-specific)
 	if err := setFilePermissions(tempPath, opts.Mode, opts.Owner, opts.Group); err != nil {
@@ -308,6 +316,24 @@ func hashFile(path string) (string, error) {
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
+// writeTempFile writes content to a new temporary file in dir
+func writeTempFile(dir, content string) (string, error) {
+	tempFile, err := os.CreateTemp(dir, ".tmp-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	if _, err := tempFile.WriteString(content); err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		return "", fmt.Errorf("failed to write content: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		os.Remove(tempFile.Name())
+		return "", fmt.Errorf("failed to write content: %w", err)
+	}
+	return tempFile.Name(), nil
+}
+
 // hashContent calculates SHA256 hash of content
 func hashContent(content string) string {
 	h := sha256.New()
@@ -341,54 +367,9 @@ func copyFile(src, dst string) error {
 	return nil
 }
 
-// generateDiff generates a simple unified diff between two strings
+// generateDiff generates a unified diff between the existing and new file content
 func generateDiff(filename, old, new string) string {
-	oldLines := strings.Split(old, "\n")
-	newLines := strings.Split(new, "\n")
-
-	var diff strings.Builder
-	diff.WriteString(fmt.Sprintf("--- %s (original)\n", filename))
-	diff.WriteString(fmt.Sprintf("+++ %s (new)\n", filename))
-
-	// Simple line-by-line diff (not a proper unified diff algorithm)
-	maxLines := len(oldLines)
-	if len(newLines) > maxLines {
-		maxLines = len(newLines)
-	}
-
-	inHunk := false
-	hunkStart := 0
-
-	for i := 0; i < maxLines; i++ {
-		oldLine := ""
-		newLine := ""
-		if i < len(oldLines) {
-			oldLine = oldLines[i]
-		}
-		if i < len(newLines) {
-			newLine = newLines[i]
-		}
-
-		if oldLine != newLine {
-			if !inHunk {
-				hunkStart = i + 1
-				inHunk = true
-				diff.WriteString(fmt.Sprintf("@@ -%d +%d @@\n", hunkStart, hunkStart))
-			}
-			if i < len(oldLines) {
-				diff.WriteString(fmt.Sprintf("-%s\n", oldLine))
-			}
-			if i < len(newLines) {
-				diff.WriteString(fmt.Sprintf("+%s\n", newLine))
-			}
-		} else if inHunk {
-			// Context line
-			diff.WriteString(fmt.Sprintf(" %s\n", oldLine))
-			inHunk = false
-		}
-	}
-
-	return diff.String()
+	return diff.Unified(filename+" (original)", filename+" (new)", old, new)
 }
 
 // ParseUnixMode parses a Unix-style mode string (e.g., "0644") to os.FileMode
@@ -7,9 +7,7 @@ package probe
 import (
 	"fmt"
 	"os"
-	"os/user"
 	"strconv"
-	"syscall"
 )
 
 // getDefaultBackupDir returns the default backup directory for Unix systems
@@ -42,8 +40,8 @@ func setFilePermissions(path, mode, owner, group string) error {
 		uid, gid := -1, -1
 
 		if owner != "" {
-			if u, err := user.Lookup(owner); err == nil {
-				uid, _ = strconv.Atoi(u.Uid)
+			if id, err := lookupUID(owner); err == nil {
+				uid = id
 			} else if parsed, err := strconv.Atoi(owner); err == nil {
 				uid = parsed
 			} else {
@@ -52,8 +50,8 @@ func setFilePermissions(path, mode, owner, group string) error {
 		}
 
 		if group != "" {
-			if g, err := user.LookupGroup(group); err == nil {
-				gid, _ = strconv.Atoi(g.Gid)
+			if id, err := lookupGID(group); err == nil {
+				gid = id
 			} else if parsed, err := strconv.Atoi(group); err == nil {
 				gid = parsed
 			} else {
@@ -76,15 +74,18 @@ func setFilePermissions(path, mode, owner, group string) error {
 
 // getFileOwnership retrieves file ownership information on Unix systems
 func getFileOwnership(stat os.FileInfo, info *FileInfo) {
-	if sys, ok := stat.Sys().(*syscall.Stat_t); ok {
-		info.OwnerUID = int(sys.Uid)
-		info.GroupGID = int(sys.Gid)
+	uid, gid, ok := fileOwnerIDs(stat)
+	if !ok {
+		return
+	}
 
-		if u, err := user.LookupId(strconv.Itoa(info.OwnerUID)); err == nil {
-			info.Owner = u.Username
-		}
-		if g, err := user.LookupGroupId(strconv.Itoa(info.GroupGID)); err == nil {
-			info.Group = g.Name
-		}
+	info.OwnerUID = uid
+	info.GroupGID = gid
+
+	if name, err := lookupUserName(uid); err == nil {
+		info.Owner = name
+	}
+	if name, err := lookupGroupName(gid); err == nil {
+		info.Group = name
 	}
 }
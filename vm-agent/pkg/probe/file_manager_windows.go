@@ -7,9 +7,6 @@ package probe
 import (
 	"fmt"
 	"os"
-	"os/user"
-	"strconv"
-	"unsafe"
 
 	"golang.org/x/sys/windows"
 )
@@ -157,8 +154,8 @@ func setWindowsACLFromMode(path, mode, owner string) error {
 }
 
 // unixPermsToWindowsAccess converts Unix permission bits (rwx) to Windows access mask
-func unixPermsToWindowsAccess(perms os.FileMode) uint32 {
-	var access uint32 = 0
+func unixPermsToWindowsAccess(perms os.FileMode) windows.ACCESS_MASK {
+	var access windows.ACCESS_MASK = 0
 
 	// Read permission
 	if perms&0x4 != 0 {
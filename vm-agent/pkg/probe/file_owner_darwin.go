@@ -0,0 +1,89 @@
+//go:build darwin
+// +build darwin
+
+// Package probe provides workflow execution functionality.
+package probe
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// fileOwnerIDs returns the numeric owner and group of a file. Darwin's
+// Stat_t carries uid/gid as uint32 alongside BSD-specific fields, so only the
+// ownership fields are read here.
+func fileOwnerIDs(stat os.FileInfo) (uid, gid int, ok bool) {
+	sys, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(sys.Uid), int(sys.Gid), true
+}
+
+// Without cgo, os/user on macOS only reads /etc/passwd and /etc/group, which
+// do not contain Open Directory accounts. Each lookup falls back to
+// dscacheutil, which queries the directory service.
+
+// lookupUID resolves a user name to a UID
+func lookupUID(name string) (int, error) {
+	if u, err := user.Lookup(name); err == nil {
+		return strconv.Atoi(u.Uid)
+	}
+	value, err := dscacheLookup("user", "name", name, "uid")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+// lookupGID resolves a group name to a GID
+func lookupGID(name string) (int, error) {
+	if g, err := user.LookupGroup(name); err == nil {
+		return strconv.Atoi(g.Gid)
+	}
+	value, err := dscacheLookup("group", "name", name, "gid")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+// lookupUserName resolves a UID to a user name
+func lookupUserName(uid int) (string, error) {
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		return u.Username, nil
+	}
+	return dscacheLookup("user", "uid", strconv.Itoa(uid), "name")
+}
+
+// lookupGroupName resolves a GID to a group name
+func lookupGroupName(gid int) (string, error) {
+	if g, err := user.LookupGroupId(strconv.Itoa(gid)); err == nil {
+		return g.Name, nil
+	}
+	return dscacheLookup("group", "gid", strconv.Itoa(gid), "name")
+}
+
+// dscacheLookup queries the directory service for a user or group record
+// matching key=value and returns the requested field
+func dscacheLookup(category, key, value, field string) (string, error) {
+	output, err := exec.Command("dscacheutil", "-q", category, "-a", key, value).Output()
+	if err != nil {
+		return "", fmt.Errorf("directory lookup failed: %w", err)
+	}
+
+	prefix := field + ":"
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, prefix) {
+			return strings.TrimSpace(strings.TrimPrefix(line, prefix)), nil
+		}
+	}
+
+	return "", fmt.Errorf("%s %s=%s not found", category, key, value)
+}
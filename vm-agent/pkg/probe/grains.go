@@ -0,0 +1,184 @@
+// Package probe provides workflow execution functionality.
+package probe
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GrainsNamespace is the render context key grains are exposed under.
+// Workflow variables cannot shadow it.
+const GrainsNamespace = "grains"
+
+// grainsTTL is how long collected grains are reused before being refreshed
+const grainsTTL = time.Minute
+
+// fqdnLookupTimeout bounds the DNS lookup used to resolve the FQDN
+const fqdnLookupTimeout = 2 * time.Second
+
+// grainsCache holds the most recently collected grains
+type grainsCache struct {
+	mu          sync.Mutex
+	grains      map[string]interface{}
+	collectedAt time.Time
+}
+
+// Grains returns the agent's facts for template rendering, e.g.
+// {{ grains.fqdn }} or {{ grains.ip_addresses.eth0 }}. They are collected at
+// most once per grainsTTL.
+func (e *Executor) Grains() map[string]interface{} {
+	e.grains.mu.Lock()
+	defer e.grains.mu.Unlock()
+
+	if e.grains.grains == nil || time.Since(e.grains.collectedAt) > grainsTTL {
+		e.grains.grains = CollectGrains(e.agentVersion)
+		e.grains.collectedAt = time.Now()
+	}
+	return copyGrains(e.grains.grains)
+}
+
+// CollectGrains collects the host's facts. Facts that cannot be read on this
+// platform are left out.
+func CollectGrains(agentVersion string) map[string]interface{} {
+	grains := map[string]interface{}{
+		"os":       runtime.GOOS,
+		"arch":     runtime.GOARCH,
+		"num_cpus": runtime.NumCPU(),
+	}
+	if agentVersion != "" {
+		grains["agent_version"] = agentVersion
+	}
+	if kernel, err := kernelVersion(); err == nil {
+		grains["kernel"] = kernel
+	}
+	if id, versionID, err := osRelease(); err == nil {
+		grains["os_id"] = id
+		grains["os_version_id"] = versionID
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		fqdn := resolveFQDN(hostname)
+		host, domain, _ := strings.Cut(fqdn, ".")
+		grains["hostname"] = hostname
+		grains["fqdn"] = fqdn
+		grains["host"] = host
+		grains["domain"] = domain
+	}
+
+	// The local timezone, for work timed in the agent's local time
+	grains["timezone"] = localTimezone()
+	grains["utc_offset"] = time.Now().Format("-07:00")
+
+	ipAddresses, ip4, ip6 := interfaceAddresses()
+	grains["ip_addresses"] = ipAddresses
+	grains["ip4_interfaces"] = ip4
+	grains["ip6_interfaces"] = ip6
+
+	return grains
+}
+
+// resolveFQDN returns the canonical name of hostname, or hostname when it
+// does not resolve
+func resolveFQDN(hostname string) string {
+	if strings.Contains(hostname, ".") {
+		return hostname
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fqdnLookupTimeout)
+	defer cancel()
+
+	cname, err := net.DefaultResolver.LookupCNAME(ctx, hostname)
+	if err != nil || cname == "" {
+		return hostname
+	}
+	return strings.TrimSuffix(cname, ".")
+}
+
+// localTimezone returns the IANA name of the local timezone (e.g.
+// Europe/Berlin) from TZ or /etc/localtime, falling back to the zone
+// abbreviation where the name cannot be determined
+func localTimezone() string {
+	if tz := strings.TrimPrefix(os.Getenv("TZ"), ":"); tz != "" {
+		return tz
+	}
+	if target, err := filepath.EvalSymlinks("/etc/localtime"); err == nil {
+		if _, name, ok := strings.Cut(filepath.ToSlash(target), "zoneinfo/"); ok && name != "" {
+			return name
+		}
+	}
+	if data, err := os.ReadFile("/etc/timezone"); err == nil {
+		if name := strings.TrimSpace(string(data)); name != "" {
+			return name
+		}
+	}
+	zone, _ := time.Now().Zone()
+	return zone
+}
+
+// interfaceAddresses returns each non-loopback interface's primary address
+// (IPv4 preferred) and its IPv4 and IPv6 addresses, keyed by interface name
+func interfaceAddresses() (map[string]interface{}, map[string]interface{}, map[string]interface{}) {
+	primary := make(map[string]interface{})
+	ip4 := make(map[string]interface{})
+	ip6 := make(map[string]interface{})
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return primary, ip4, ip6
+	}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		var v4, v6 []interface{}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			if ipNet.IP.To4() != nil {
+				v4 = append(v4, ipNet.IP.String())
+			} else if !ipNet.IP.IsLinkLocalUnicast() {
+				v6 = append(v6, ipNet.IP.String())
+			}
+		}
+
+		switch {
+		case len(v4) > 0:
+			primary[iface.Name] = v4[0]
+		case len(v6) > 0:
+			primary[iface.Name] = v6[0]
+		default:
+			continue
+		}
+		if len(v4) > 0 {
+			ip4[iface.Name] = v4
+		}
+		if len(v6) > 0 {
+			ip6[iface.Name] = v6
+		}
+	}
+
+	return primary, ip4, ip6
+}
+
+// copyGrains returns a copy of grains so callers cannot modify the cache
+func copyGrains(grains map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(grains))
+	for k, v := range grains {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,154 @@
+package probe
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// interpreter describes how a script step is run
+type interpreter struct {
+	// ext is the script file extension; Windows selects handlers by it
+	ext string
+	// binaries are the executables tried in order
+	binaries []string
+	// windowsBinaries replace binaries on Windows when set
+	windowsBinaries []string
+	// args returns the arguments passed to the binary for a script path
+	args func(path string) []string
+}
+
+// interpreters are the supported values of a script step's interpreter
+var interpreters = map[string]interpreter{
+	"sh": {
+		ext:      ".sh",
+		binaries: []string{"sh"},
+		args:     func(path string) []string { return []string{path} },
+	},
+	"bash": {
+		ext:      ".sh",
+		binaries: []string{"bash"},
+		args:     func(path string) []string { return []string{path} },
+	},
+	"python3": {
+		ext:             ".py",
+		binaries:        []string{"python3", "python"},
+		windowsBinaries: []string{"python", "python3", "py"},
+		args:            func(path string) []string { return []string{path} },
+	},
+	"pwsh": {
+		ext:             ".ps1",
+		binaries:        []string{"pwsh"},
+		windowsBinaries: []string{"pwsh", "powershell"},
+		args: func(path string) []string {
+			return []string{"-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", path}
+		},
+	},
+	"cmd": {
+		ext:      ".cmd",
+		binaries: []string{"cmd"},
+		args:     func(path string) []string { return []string{"/C", path} },
+	},
+}
+
+// interpreterAliases map shebang program names to interpreters
+var interpreterAliases = map[string]string{
+	"python":     "python3",
+	"powershell": "pwsh",
+}
+
+// validateInterpreter checks name is a supported interpreter
+func validateInterpreter(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, ok := interpreters[name]; !ok {
+		return fmt.Errorf("unsupported interpreter %q: must be sh, bash, python3, pwsh or cmd", name)
+	}
+	if name == "cmd" && runtime.GOOS != "windows" {
+		return fmt.Errorf("interpreter cmd is only available on Windows")
+	}
+	return nil
+}
+
+// scriptInterpreter picks the interpreter of a script step: the step's
+// interpreter field, else the script's shebang when it names a supported
+// interpreter, else sh. It returns "" for a shebang naming another program,
+// in which case the script is executed directly outside Windows.
+func scriptInterpreter(step *Step) (string, error) {
+	if step.Interpreter != "" {
+		return step.Interpreter, validateInterpreter(step.Interpreter)
+	}
+	if name := shebangInterpreter(step.Script); name != "" {
+		return name, validateInterpreter(name)
+	}
+	if strings.HasPrefix(step.Script, "#!") && runtime.GOOS != "windows" {
+		return "", nil
+	}
+	return "sh", nil
+}
+
+// shebangInterpreter returns the supported interpreter named by a script's
+// "#!" line, handling "/usr/bin/env prog" forms. Unknown programs return "".
+func shebangInterpreter(script string) string {
+	if !strings.HasPrefix(script, "#!") {
+		return ""
+	}
+	line, _, _ := strings.Cut(script[2:], "\n")
+	fields := strings.Fields(strings.TrimSpace(line))
+	if len(fields) == 0 {
+		return ""
+	}
+
+	prog := filepath.Base(fields[0])
+	if prog == "env" {
+		fields = fields[1:]
+		// Skip env options such as -S
+		for len(fields) > 0 && strings.HasPrefix(fields[0], "-") {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			return ""
+		}
+		prog = filepath.Base(fields[0])
+	}
+	prog = strings.TrimSuffix(prog, ".exe")
+
+	if alias, ok := interpreterAliases[prog]; ok {
+		return alias
+	}
+	if _, ok := interpreters[prog]; ok {
+		return prog
+	}
+	return ""
+}
+
+// scriptCommand returns the command line that runs the script at path with
+// the named interpreter, failing when none of its binaries is installed
+func scriptCommand(name, path string) ([]string, error) {
+	if name == "" {
+		return []string{path}, nil
+	}
+
+	interp := interpreters[name]
+	binaries := interp.binaries
+	if runtime.GOOS == "windows" && len(interp.windowsBinaries) > 0 {
+		binaries = interp.windowsBinaries
+	}
+
+	for _, bin := range binaries {
+		resolved, err := exec.LookPath(bin)
+		if err != nil {
+			continue
+		}
+		args := interp.args(path)
+		if bin == "py" {
+			args = append([]string{"-3"}, args...)
+		}
+		return append([]string{resolved}, args...), nil
+	}
+	return nil, fmt.Errorf("interpreter %s is not available on this agent (looked for %s in PATH)",
+		name, strings.Join(binaries, ", "))
+}
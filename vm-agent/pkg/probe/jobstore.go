@@ -0,0 +1,366 @@
+package probe
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Default job retention, applied when none is configured
+const (
+	DefaultJobRetention = 7 * 24 * time.Hour
+	DefaultMaxJobs      = 1000
+)
+
+// ErrJobNotFound is returned for jobs the store does not have
+var ErrJobNotFound = errors.New("job not found")
+
+// Buckets of the job store. Jobs hold the records by ID; byStatus indexes
+// them by status and ID, and byEnd indexes finished jobs by end time and ID
+// for retention.
+var (
+	jobsBucket     = []byte("jobs")
+	byStatusBucket = []byte("jobs_by_status")
+	byEndBucket    = []byte("jobs_by_end")
+)
+
+// JobRecord is the persisted state of a workflow job
+type JobRecord struct {
+	QueuedAt time.Time       `json:"queued_at"`
+	Result   *WorkflowResult `json:"result"`
+	// Workflow is kept while the job is paused, so it can be resumed
+	// after an agent restart
+	Workflow *Workflow `json:"workflow,omitempty"`
+}
+
+// terminal reports whether a job status is final
+func terminal(status StepStatus) bool {
+	return status != StepStatusPending && status != StepStatusRunning && status != StepStatusPaused
+}
+
+// JobStoreConfig contains job store configuration
+type JobStoreConfig struct {
+	Path string
+	// Retention is how long finished jobs are kept (default 7 days)
+	Retention time.Duration
+	// MaxJobs bounds the finished jobs kept, oldest removed first
+	// (default 1000)
+	MaxJobs int
+}
+
+// JobStore persists workflow jobs in a bbolt database, so results survive
+// agent restarts and redelivered workflows are recognised after them.
+// Finished jobs are removed past their retention. Watch channels are closed
+// whenever a job changes, so readers wait for changes instead of polling.
+type JobStore struct {
+	db        *bolt.DB
+	retention time.Duration
+	maxJobs   int
+
+	mu       sync.Mutex
+	watchers map[string]chan struct{}
+}
+
+// NewJobStore opens or creates the job store
+func NewJobStore(cfg *JobStoreConfig) (*JobStore, error) {
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create job store directory: %w", err)
+	}
+	// A second agent on the same store fails instead of waiting forever
+	db, err := bolt.Open(cfg.Path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job store: %w", err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{jobsBucket, byStatusBucket, byEndBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize job store: %w", err)
+	}
+
+	retention := cfg.Retention
+	if retention <= 0 {
+		retention = DefaultJobRetention
+	}
+	maxJobs := cfg.MaxJobs
+	if maxJobs <= 0 {
+		maxJobs = DefaultMaxJobs
+	}
+
+	return &JobStore{
+		db:        db,
+		retention: retention,
+		maxJobs:   maxJobs,
+		watchers:  make(map[string]chan struct{}),
+	}, nil
+}
+
+// Close closes the store
+func (s *JobStore) Close() error {
+	return s.db.Close()
+}
+
+// statusKey is a job's key in the status index
+func statusKey(status StepStatus, id string) []byte {
+	return append(append([]byte(status), 0), id...)
+}
+
+// endKey is a finished job's key in the end time index
+func endKey(ended time.Time, id string) []byte {
+	key := make([]byte, 8, 8+len(id))
+	binary.BigEndian.PutUint64(key, uint64(ended.UnixNano()))
+	return append(key, id...)
+}
+
+// Put writes a job, updates its index entries and notifies its watchers.
+// Writing a finished job removes finished jobs past the retention.
+func (s *JobStore) Put(record *JobRecord) error {
+	id := record.Result.WorkflowID
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+
+	var removed []string
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		jobs := tx.Bucket(jobsBucket)
+		if previous, err := decodeRecord(jobs.Get([]byte(id))); err != nil {
+			return err
+		} else if previous != nil {
+			if err := unindex(tx, previous); err != nil {
+				return err
+			}
+		}
+		if err := jobs.Put([]byte(id), data); err != nil {
+			return err
+		}
+		if err := tx.Bucket(byStatusBucket).Put(statusKey(record.Result.Status, id), nil); err != nil {
+			return err
+		}
+		if !terminal(record.Result.Status) {
+			return nil
+		}
+		if err := tx.Bucket(byEndBucket).Put(endKey(record.Result.EndedAt, id), nil); err != nil {
+			return err
+		}
+		removed, err = s.pruneOlder(tx, time.Now().Add(-s.retention))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store job %s: %w", id, err)
+	}
+	s.notify(id)
+	for _, pruned := range removed {
+		s.notify(pruned)
+	}
+	return nil
+}
+
+// Get returns a job, or ErrJobNotFound
+func (s *JobStore) Get(id string) (*JobRecord, error) {
+	var record *JobRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		record, err = decodeRecord(tx.Bucket(jobsBucket).Get([]byte(id)))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, ErrJobNotFound
+	}
+	return record, nil
+}
+
+// List returns up to limit jobs with a status, in ID order; a limit of 0
+// returns them all
+func (s *JobStore) List(status StepStatus, limit int) ([]*JobRecord, error) {
+	var records []*JobRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		jobs := tx.Bucket(jobsBucket)
+		prefix := statusKey(status, "")
+		c := tx.Bucket(byStatusBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && hasPrefix(k, prefix); k, _ = c.Next() {
+			record, err := decodeRecord(jobs.Get(k[len(prefix):]))
+			if err != nil {
+				return err
+			}
+			if record != nil {
+				records = append(records, record)
+			}
+			if limit > 0 && len(records) == limit {
+				break
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+// Count returns the number of jobs with a status
+func (s *JobStore) Count(status StepStatus) (int, error) {
+	count := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		prefix := statusKey(status, "")
+		c := tx.Bucket(byStatusBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && hasPrefix(k, prefix); k, _ = c.Next() {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Watch returns a channel closed on the next change to a job, including its
+// removal. Take it before reading the job so no change is missed.
+func (s *JobStore) Watch(id string) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.watchers[id]
+	if !ok {
+		ch = make(chan struct{})
+		s.watchers[id] = ch
+	}
+	return ch
+}
+
+// notify wakes the watchers of a job
+func (s *JobStore) notify(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ch, ok := s.watchers[id]; ok {
+		close(ch)
+		delete(s.watchers, id)
+	}
+}
+
+// Prune removes finished jobs ended before maxAge ago, and the oldest ones
+// past the job limit, and returns how many were removed
+func (s *JobStore) Prune(maxAge time.Duration) (int, error) {
+	var removed []string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		removed, err = s.pruneOlder(tx, time.Now().Add(-maxAge))
+		return err
+	})
+	for _, id := range removed {
+		s.notify(id)
+	}
+	return len(removed), err
+}
+
+// pruneOlder removes finished jobs ended before cutoff and, oldest first,
+// those past the job limit
+func (s *JobStore) pruneOlder(tx *bolt.Tx, cutoff time.Time) ([]string, error) {
+	byEnd := tx.Bucket(byEndBucket)
+	// Stats would miss the writes of this transaction
+	excess := -s.maxJobs
+	c := byEnd.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		excess++
+	}
+
+	var removed []string
+	for k, _ := c.First(); k != nil; k, _ = c.First() {
+		ended := time.Unix(0, int64(binary.BigEndian.Uint64(k[:8])))
+		if !ended.Before(cutoff) && excess <= 0 {
+			break
+		}
+		id := string(k[8:])
+		if err := s.delete(tx, id); err != nil {
+			return removed, err
+		}
+		// An index entry without its job would otherwise stop pruning
+		if err := byEnd.Delete(k); err != nil {
+			return removed, err
+		}
+		removed = append(removed, id)
+		excess--
+	}
+	return removed, nil
+}
+
+// delete removes a job and its index entries
+func (s *JobStore) delete(tx *bolt.Tx, id string) error {
+	jobs := tx.Bucket(jobsBucket)
+	record, err := decodeRecord(jobs.Get([]byte(id)))
+	if err != nil || record == nil {
+		return err
+	}
+	if err := unindex(tx, record); err != nil {
+		return err
+	}
+	return jobs.Delete([]byte(id))
+}
+
+// Recover marks jobs left pending or running by an agent that stopped as
+// failed, and returns them
+func (s *JobStore) Recover() ([]*JobRecord, error) {
+	var recovered []*JobRecord
+	for _, status := range []StepStatus{StepStatusPending, StepStatusRunning} {
+		records, err := s.List(status, 0)
+		if err != nil {
+			return nil, err
+		}
+		recovered = append(recovered, records...)
+	}
+
+	now := time.Now()
+	for _, record := range recovered {
+		result := record.Result
+		result.Status = StepStatusFailed
+		result.Error = "agent stopped before the workflow finished"
+		result.EndedAt = now
+		if !result.StartedAt.IsZero() {
+			result.Duration = now.Sub(result.StartedAt)
+		}
+		if err := s.Put(record); err != nil {
+			return nil, err
+		}
+	}
+	return recovered, nil
+}
+
+// unindex removes a job's index entries
+func unindex(tx *bolt.Tx, record *JobRecord) error {
+	id := record.Result.WorkflowID
+	if err := tx.Bucket(byStatusBucket).Delete(statusKey(record.Result.Status, id)); err != nil {
+		return err
+	}
+	if terminal(record.Result.Status) {
+		return tx.Bucket(byEndBucket).Delete(endKey(record.Result.EndedAt, id))
+	}
+	return nil
+}
+
+// decodeRecord decodes a stored job; nil data is a missing job
+func decodeRecord(data []byte) (*JobRecord, error) {
+	if data == nil {
+		return nil, nil
+	}
+	var record JobRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("corrupt job record: %w", err)
+	}
+	if record.Result == nil {
+		return nil, fmt.Errorf("corrupt job record: no result")
+	}
+	return &record, nil
+}
+
+func hasPrefix(key, prefix []byte) bool {
+	return len(key) >= len(prefix) && string(key[:len(prefix)]) == string(prefix)
+}
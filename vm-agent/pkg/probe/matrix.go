@@ -0,0 +1,157 @@
+// Package probe provides workflow execution functionality.
+package probe
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MaxMatrixCombinations limits how many steps a single matrix step may generate
+const MaxMatrixCombinations = 256
+
+// matrixPlaceholder matches ${{ matrix.<key> }} references in step fields
+var matrixPlaceholder = regexp.MustCompile(`\$\{\{\s*matrix\.([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// matrixKeyPattern restricts matrix keys to identifiers usable in env var names
+var matrixKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// expandMatrixSteps replaces every step that has a matrix with one generated
+// step per combination of matrix values. Generated steps get the ID
+// <id>-<value>[-<value>...], a MATRIX_<KEY> env var for each key, and
+// ${{ matrix.<key> }} placeholders substituted in their string fields.
+func expandMatrixSteps(steps []Step) ([]Step, error) {
+	var expanded []Step
+	for _, step := range steps {
+		if len(step.Matrix) == 0 {
+			expanded = append(expanded, step)
+			continue
+		}
+
+		combinations, err := matrixCombinations(step.Matrix)
+		if err != nil {
+			return nil, fmt.Errorf("step %s: %w", step.ID, err)
+		}
+
+		for _, values := range combinations {
+			expanded = append(expanded, expandMatrixStep(step, values))
+		}
+	}
+	return expanded, nil
+}
+
+// matrixCombinations returns the cartesian product of the matrix values,
+// with keys in sorted order so generated steps are deterministic
+func matrixCombinations(matrix map[string][]string) ([]map[string]string, error) {
+	keys := make([]string, 0, len(matrix))
+	total := 1
+	for key, values := range matrix {
+		if !matrixKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("matrix key %q must be an identifier", key)
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("matrix key %q has no values", key)
+		}
+		total *= len(values)
+		if total > MaxMatrixCombinations {
+			return nil, fmt.Errorf("matrix expands to more than %d steps", MaxMatrixCombinations)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	combinations := []map[string]string{{}}
+	for _, key := range keys {
+		next := make([]map[string]string, 0, len(combinations)*len(matrix[key]))
+		for _, combination := range combinations {
+			for _, value := range matrix[key] {
+				values := make(map[string]string, len(combination)+1)
+				for k, v := range combination {
+					values[k] = v
+				}
+				values[key] = value
+				next = append(next, values)
+			}
+		}
+		combinations = next
+	}
+	return combinations, nil
+}
+
+// expandMatrixStep generates the step for one matrix combination
+func expandMatrixStep(step Step, values map[string]string) Step {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	suffix := make([]string, len(keys))
+	for i, key := range keys {
+		suffix[i] = sanitizeMatrixValue(values[key])
+	}
+
+	subst := func(s string) string {
+		return matrixPlaceholder.ReplaceAllStringFunc(s, func(match string) string {
+			key := matrixPlaceholder.FindStringSubmatch(match)[1]
+			if value, ok := values[key]; ok {
+				return value
+			}
+			return match
+		})
+	}
+
+	generated := step
+	generated.ID = step.ID + "-" + strings.Join(suffix, "-")
+	generated.Name = subst(step.Name)
+	generated.Command = subst(step.Command)
+	generated.Script = subst(step.Script)
+	generated.WorkDir = subst(step.WorkDir)
+	generated.Condition = subst(step.Condition)
+	generated.Matrix = nil
+	generated.MatrixParent = step.ID
+	generated.MatrixValues = values
+
+	if len(step.Args) > 0 {
+		generated.Args = make([]string, len(step.Args))
+		for i, arg := range step.Args {
+			generated.Args[i] = subst(arg)
+		}
+	}
+
+	generated.Env = make(map[string]string, len(step.Env)+len(values))
+	for k, v := range step.Env {
+		generated.Env[k] = subst(v)
+	}
+	for _, key := range keys {
+		generated.Env["MATRIX_"+strings.ToUpper(key)] = values[key]
+	}
+
+	if step.Repeat != nil {
+		repeat := *step.Repeat
+		repeat.Until = subst(repeat.Until)
+		generated.Repeat = &repeat
+	}
+
+	if step.Template != nil {
+		tmpl := *step.Template
+		tmpl.Source = subst(tmpl.Source)
+		tmpl.Dest = subst(tmpl.Dest)
+		generated.Template = &tmpl
+	}
+
+	return generated
+}
+
+// sanitizeMatrixValue makes a matrix value safe for use in a step ID
+func sanitizeMatrixValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, value)
+}
@@ -0,0 +1,172 @@
+package probe
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// StepsNamespace is the render context key earlier step results are exposed
+// under, e.g. {{ steps.precheck.output.free_disk }}
+const StepsNamespace = "steps"
+
+// stderrSeparator separates stdout from stderr in a step's output
+const stderrSeparator = "\n--- stderr ---\n"
+
+// Output parser types
+const (
+	OutputParserJSON     = "json"
+	OutputParserKeyValue = "key_value"
+	OutputParserRegex    = "regex"
+)
+
+// OutputParser parses a step's stdout into a structured map
+type OutputParser struct {
+	// Type is json (stdout is a JSON object), key_value (one key=value per
+	// line) or regex (a pattern whose named groups become the keys)
+	Type string `yaml:"type" json:"type"`
+	// Separator splits key_value lines; defaults to "="
+	Separator string `yaml:"separator,omitempty" json:"separator,omitempty"`
+	// Pattern is the regular expression for the regex parser. The first
+	// match is used; unmatched optional groups are left out.
+	Pattern string `yaml:"pattern,omitempty" json:"pattern,omitempty"`
+}
+
+// Validate validates the output parser configuration
+func (p *OutputParser) Validate() error {
+	switch p.Type {
+	case OutputParserJSON, OutputParserKeyValue:
+	case OutputParserRegex:
+		if p.Pattern == "" {
+			return fmt.Errorf("pattern is required for the regex parser")
+		}
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		if !hasNamedGroup(re) {
+			return fmt.Errorf("pattern must have at least one named group, e.g. (?P<name>...)")
+		}
+	default:
+		return fmt.Errorf("unknown type %q: must be json, key_value or regex", p.Type)
+	}
+	return nil
+}
+
+// Parse parses stdout into a map. JSON values keep their types; key_value
+// and regex values are strings.
+func (p *OutputParser) Parse(stdout string) (map[string]interface{}, error) {
+	switch p.Type {
+	case OutputParserJSON:
+		var parsed map[string]interface{}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(stdout)), &parsed); err != nil {
+			return nil, fmt.Errorf("stdout is not a JSON object: %w", err)
+		}
+		return normalizeNumbers(parsed).(map[string]interface{}), nil
+
+	case OutputParserKeyValue:
+		separator := p.Separator
+		if separator == "" {
+			separator = "="
+		}
+		parsed := make(map[string]interface{})
+		scanner := bufio.NewScanner(strings.NewReader(stdout))
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			key, value, ok := strings.Cut(line, separator)
+			if !ok {
+				continue
+			}
+			if key = strings.TrimSpace(key); key != "" {
+				parsed[key] = strings.TrimSpace(value)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read stdout: %w", err)
+		}
+		return parsed, nil
+
+	case OutputParserRegex:
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+		match := re.FindStringSubmatchIndex(stdout)
+		if match == nil {
+			return nil, fmt.Errorf("stdout does not match the pattern")
+		}
+		parsed := make(map[string]interface{})
+		for i, name := range re.SubexpNames() {
+			if name == "" || match[2*i] < 0 {
+				continue
+			}
+			parsed[name] = stdout[match[2*i]:match[2*i+1]]
+		}
+		return parsed, nil
+	}
+
+	return nil, fmt.Errorf("unknown output parser type %q", p.Type)
+}
+
+// normalizeNumbers converts whole JSON numbers to integers so templates
+// render them as 5 rather than 5.000000
+func normalizeNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = normalizeNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeNumbers(item)
+		}
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+	}
+	return value
+}
+
+// hasNamedGroup reports whether a regular expression has a named group
+func hasNamedGroup(re *regexp.Regexp) bool {
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// stepStdout returns the stdout part of a step's output
+func stepStdout(output string) string {
+	stdout, _, _ := strings.Cut(output, stderrSeparator)
+	return stdout
+}
+
+// stepsContext returns the results of completed steps for templating, keyed
+// by step ID. A step's output is its parsed output when it has an output
+// parser, else its raw output.
+func stepsContext(results []StepResult) map[string]interface{} {
+	steps := make(map[string]interface{}, len(results))
+	for _, r := range results {
+		var output interface{} = r.Output
+		if r.ParsedOutput != nil {
+			output = r.ParsedOutput
+		}
+		steps[r.StepID] = map[string]interface{}{
+			"status":    string(r.Status),
+			"exit_code": r.ExitCode,
+			"output":    output,
+			"data":      r.Data,
+		}
+	}
+	return steps
+}
@@ -0,0 +1,144 @@
+package probe
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOutputParserValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		parser OutputParser
+		// wantErr is part of the expected error message
+		wantErr string
+	}{
+		{name: "json", parser: OutputParser{Type: OutputParserJSON}},
+		{name: "key_value", parser: OutputParser{Type: OutputParserKeyValue, Separator: ":"}},
+		{name: "regex", parser: OutputParser{Type: OutputParserRegex, Pattern: `(?P<free>\d+)% free`}},
+		{name: "no type", parser: OutputParser{}, wantErr: `unknown type ""`},
+		{name: "unknown type", parser: OutputParser{Type: "xml"}, wantErr: `unknown type "xml"`},
+		{name: "regex without pattern", parser: OutputParser{Type: OutputParserRegex}, wantErr: "pattern is required"},
+		{name: "invalid pattern", parser: OutputParser{Type: OutputParserRegex, Pattern: `(?P<free>\d+`}, wantErr: "invalid pattern"},
+		{name: "no named group", parser: OutputParser{Type: OutputParserRegex, Pattern: `(\d+)% free`}, wantErr: "at least one named group"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.parser.Validate()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Validate error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+		})
+	}
+}
+
+func TestOutputParserParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		parser  OutputParser
+		stdout  string
+		want    map[string]interface{}
+		wantErr string
+	}{
+		{
+			name:   "json",
+			parser: OutputParser{Type: OutputParserJSON},
+			stdout: "\n{\"free_disk\": 42, \"ratio\": 0.5, \"ok\": true, \"mounts\": [1, {\"size\": 2}]}\n",
+			want: map[string]interface{}{
+				"free_disk": int64(42),
+				"ratio":     0.5,
+				"ok":        true,
+				"mounts":    []interface{}{int64(1), map[string]interface{}{"size": int64(2)}},
+			},
+		},
+		{
+			name:    "json array",
+			parser:  OutputParser{Type: OutputParserJSON},
+			stdout:  `[1, 2]`,
+			wantErr: "stdout is not a JSON object",
+		},
+		{
+			name:    "not json",
+			parser:  OutputParser{Type: OutputParserJSON},
+			stdout:  "free_disk=42",
+			wantErr: "stdout is not a JSON object",
+		},
+		{
+			name:   "key_value",
+			parser: OutputParser{Type: OutputParserKeyValue},
+			stdout: "# facts\nfree_disk = 42\n\nowner=ops=team\nnot a pair\n=orphan\nempty=\n",
+			want:   map[string]interface{}{"free_disk": "42", "owner": "ops=team", "empty": ""},
+		},
+		{
+			name:   "key_value separator",
+			parser: OutputParser{Type: OutputParserKeyValue, Separator: ": "},
+			stdout: "Kernel: 5.15.0\nUptime: 3 days\nfree_disk=42\n",
+			want:   map[string]interface{}{"Kernel": "5.15.0", "Uptime": "3 days"},
+		},
+		{
+			name:   "key_value empty",
+			parser: OutputParser{Type: OutputParserKeyValue},
+			stdout: "",
+			want:   map[string]interface{}{},
+		},
+		{
+			name:   "regex first match",
+			parser: OutputParser{Type: OutputParserRegex, Pattern: `(?P<mount>/\S*) (?P<free>\d+)%( (?P<note>\w+))?`},
+			stdout: "/ 42%\n/var 7% low\n",
+			want:   map[string]interface{}{"mount": "/", "free": "42"},
+		},
+		{
+			name:    "regex no match",
+			parser:  OutputParser{Type: OutputParserRegex, Pattern: `(?P<free>\d+)%`},
+			stdout:  "no disks",
+			wantErr: "stdout does not match the pattern",
+		},
+		{
+			name:    "unknown type",
+			parser:  OutputParser{Type: "xml"},
+			stdout:  "<free>42</free>",
+			wantErr: `unknown output parser type "xml"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.parser.Parse(tt.stdout)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStepStdout(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{"42", "42"},
+		{"42" + stderrSeparator + "warning: low disk", "42"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := stepStdout(tt.output); got != tt.want {
+			t.Errorf("stepStdout(%q) = %q, want %q", tt.output, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Errors returned for workflows that cannot be paused or resumed
+var (
+	ErrNotRunning = errors.New("workflow is not running")
+	ErrNotPaused  = errors.New("workflow is not paused")
+)
+
+// Pause asks a queued or running workflow to pause before its next pause
+// point: the next step, or the next checkpoint when the workflow declares
+// checkpoints. The step running meanwhile is finished, not interrupted.
+func (e *Executor) Pause(workflowID string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	job, ok := e.jobs[workflowID]
+	if !ok {
+		return e.notLive(workflowID, ErrNotRunning)
+	}
+	if !job.paused && !job.pauseRequested {
+		job.pauseRequested = true
+		e.jobLogger(job).Info("workflow pause requested",
+			zap.String("workflow_id", job.ID))
+	}
+	return nil
+}
+
+// Resume resumes a paused workflow, or withdraws a pause it has not
+// reached yet
+func (e *Executor) Resume(workflowID string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	job, ok := e.jobs[workflowID]
+	if !ok {
+		return e.notLive(workflowID, ErrNotPaused)
+	}
+	switch {
+	case job.paused:
+		job.paused = false
+		close(job.resume)
+	case job.pauseRequested:
+		job.pauseRequested = false
+	default:
+		return fmt.Errorf("%w: %s", ErrNotPaused, workflowID)
+	}
+	return nil
+}
+
+// notLive returns err for a finished workflow and a not found error for an
+// unknown one
+func (e *Executor) notLive(workflowID string, err error) error {
+	if _, getErr := e.store.Get(workflowID); getErr == nil {
+		return fmt.Errorf("%w: %s", err, workflowID)
+	}
+	return fmt.Errorf("workflow not found: %s", workflowID)
+}
+
+// pauseDue reports whether a job must pause before running step i
+func (e *Executor) pauseDue(job *Job, i int) bool {
+	e.mu.RLock()
+	requested := job.pauseRequested
+	e.mu.RUnlock()
+	return requested && job.Workflow.checkpointAt(i)
+}
+
+// waitResumed keeps a job paused until it is resumed, giving up its
+// execution slot meanwhile. The paused state is persisted with the workflow
+// so the job survives an agent restart. It returns an error if the job is
+// cancelled instead.
+func (e *Executor) waitResumed(ctx context.Context, job *Job) error {
+	// A job restored after a restart is paused already
+	restored := job.Status == StepStatusPaused
+
+	e.mu.Lock()
+	if !restored {
+		if !job.pauseRequested {
+			// Withdrawn before the job got here
+			e.mu.Unlock()
+			return nil
+		}
+		job.pauseRequested = false
+		job.paused = true
+		job.resume = make(chan struct{})
+	}
+	resume := job.resume
+	e.mu.Unlock()
+
+	e.releaseSlot(job)
+	if !restored {
+		now := time.Now()
+		job.Status = StepStatusPaused
+		job.Result.Status = StepStatusPaused
+		job.Result.PausedAt = &now
+		e.persist(job)
+		e.reportProgress(job)
+
+		next := ""
+		if i := len(job.Result.Steps); i < len(job.Workflow.Steps) {
+			next = job.Workflow.Steps[i].ID
+		}
+		e.jobLogger(job).Info("workflow paused",
+			zap.String("workflow_id", job.ID),
+			zap.String("next_step", next))
+	}
+
+	var err error
+	select {
+	case <-resume:
+		err = e.acquireSlot(ctx, job)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if job.Result.PausedAt != nil {
+		job.Result.PausedFor += time.Since(*job.Result.PausedAt)
+		job.Result.PausedAt = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	job.Status = StepStatusRunning
+	job.Result.Status = StepStatusRunning
+	e.persist(job)
+	e.reportProgress(job)
+
+	e.jobLogger(job).Info("workflow resumed",
+		zap.String("workflow_id", job.ID),
+		zap.Duration("paused_for", job.Result.PausedFor))
+	return nil
+}
+
+// runContext returns the context steps run in: ctx bounded by what is left
+// of the workflow timeout, which does not run while the job is paused
+func (e *Executor) runContext(ctx context.Context, job *Job) (context.Context, context.CancelFunc) {
+	if job.Workflow.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	elapsed := time.Since(job.StartedAt) - job.Result.PausedFor
+	return context.WithTimeout(ctx, job.Workflow.Timeout-elapsed)
+}
+
+// restorePaused restores the jobs that were paused when the agent stopped,
+// still paused. A job stored without its workflow cannot resume and is
+// failed like an interrupted one.
+func (e *Executor) restorePaused(records []*JobRecord) {
+	for _, record := range records {
+		result := record.Result
+		if record.Workflow == nil {
+			now := time.Now()
+			result.Status = StepStatusFailed
+			result.Error = "agent stopped before the workflow finished"
+			result.EndedAt = now
+			if !result.StartedAt.IsZero() {
+				result.Duration = now.Sub(result.StartedAt)
+			}
+			if err := e.store.Put(record); err != nil {
+				e.logger.Warn("failed to persist job",
+					zap.String("workflow_id", result.WorkflowID),
+					zap.Error(err))
+			}
+			e.recovered = append(e.recovered, result)
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		job := &Job{
+			ID:         result.WorkflowID,
+			Workflow:   record.Workflow,
+			Result:     result,
+			Status:     StepStatusPaused,
+			Priority:   result.Priority,
+			QueuedAt:   record.QueuedAt,
+			StartedAt:  result.StartedAt,
+			CancelFunc: cancel,
+			Done:       make(chan struct{}),
+			previewed:  make(map[string]bool),
+			reserved:   reservationOf(record.Workflow.Resources),
+			paused:     true,
+			resume:     make(chan struct{}),
+		}
+		// The job held its resources before the restart and keeps them
+		e.reserved.disk += job.reserved.disk
+		e.reserved.memory += job.reserved.memory
+		e.jobs[job.ID] = job
+		e.inFlight++
+
+		e.jobLogger(job).Info("paused workflow restored",
+			zap.String("workflow_id", job.ID))
+		go e.executeJob(ctx, job)
+	}
+}
+
+// firstStepOf returns the index of a step, or of the first step generated
+// from a matrix step, or -1 if the workflow has neither
+func (w *Workflow) firstStepOf(id string) int {
+	for i, step := range w.Steps {
+		if step.ID == id || step.MatrixParent == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkpointAt reports whether a paused workflow may stop before step i
+func (w *Workflow) checkpointAt(i int) bool {
+	if len(w.Checkpoints) == 0 {
+		return true
+	}
+	for _, checkpoint := range w.Checkpoints {
+		if w.firstStepOf(checkpoint) == i {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// pluginContractVersion is the version of the JSON exchanged with plugins
+const pluginContractVersion = 1
+
+// pluginNamePattern restricts plugin names so they map to a file directly
+// under the plugin directory
+var pluginNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// PluginConfig contains configuration for plugin steps. The plugin is the
+// executable <plugin_dir>/<name> (<name>.exe on Windows); it only runs when
+// the control plane allowlists it and its SHA-256 matches the pinned value.
+type PluginConfig struct {
+	// Name selects the plugin executable
+	Name string `yaml:"name" json:"name"`
+	// Input is passed to the plugin as the request's input
+	Input map[string]interface{} `yaml:"input,omitempty" json:"input,omitempty"`
+}
+
+// Validate validates a plugin configuration
+func (p *PluginConfig) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if !pluginNamePattern.MatchString(p.Name) {
+		return fmt.Errorf("invalid name %q: must be lowercase letters, digits, - and _", p.Name)
+	}
+	return nil
+}
+
+// PluginRequest is written to a plugin's stdin
+type PluginRequest struct {
+	ContractVersion int                    `json:"contract_version"`
+	WorkflowID      string                 `json:"workflow_id"`
+	WorkflowName    string                 `json:"workflow_name"`
+	StepID          string                 `json:"step_id"`
+	StepName        string                 `json:"step_name"`
+	Input           map[string]interface{} `json:"input"`
+	Vars            map[string]interface{} `json:"vars,omitempty"`
+	WorkDir         string                 `json:"work_dir"`
+	Deadline        *time.Time             `json:"deadline,omitempty"`
+}
+
+// PluginResponse is read from a plugin's stdout. Status is "success" or
+// "failed"; a non-zero exit code fails the step whatever the status.
+type PluginResponse struct {
+	Status string                 `json:"status"`
+	Output string                 `json:"output,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+}
+
+// executePlugin runs a plugin step and returns its output, exit code and
+// structured data
+func (e *Executor) executePlugin(ctx context.Context, step *Step, job *Job) (string, int, map[string]interface{}, error) {
+	if step.Plugin == nil {
+		return "", 1, nil, fmt.Errorf("plugin configuration is required")
+	}
+	name := step.Plugin.Name
+
+	path, err := e.verifyPlugin(name, job.Workflow.Plugins)
+	if err != nil {
+		return "", 1, nil, err
+	}
+
+	request := &PluginRequest{
+		ContractVersion: pluginContractVersion,
+		WorkflowID:      job.Workflow.ID,
+		WorkflowName:    job.Workflow.Name,
+		StepID:          step.ID,
+		StepName:        step.Name,
+		Input:           step.Plugin.Input,
+		Vars:            job.Workflow.Vars,
+		WorkDir:         e.jobDir(job),
+	}
+	if request.Input == nil {
+		request.Input = map[string]interface{}{}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		request.Deadline = &deadline
+	}
+	stdin, err := json.Marshal(request)
+	if err != nil {
+		return "", 1, nil, fmt.Errorf("failed to encode plugin request: %w", err)
+	}
+
+	e.jobLogger(job).Info("executing plugin step",
+		zap.String("step_id", step.ID),
+		zap.String("plugin", name))
+
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Dir = e.jobDir(job)
+	if step.WorkDir != "" {
+		cmd.Dir = step.WorkDir
+	}
+	cmd.Env = e.stepEnv(job, step)
+	cmd.Stdin = bytes.NewReader(stdin)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	runErr := cmd.Run()
+	exitCode := 0
+	if runErr != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(runErr, &exitErr) {
+			return stderr.String(), 1, nil, fmt.Errorf("failed to run plugin %s: %w", name, runErr)
+		}
+		exitCode = exitErr.ExitCode()
+	}
+
+	var response PluginResponse
+	if err := json.Unmarshal(bytes.TrimSpace(stdout.Bytes()), &response); err != nil {
+		output := withStderr(stdout.String(), &stderr)
+		if exitCode != 0 {
+			return output, exitCode, nil, nil
+		}
+		return output, 1, nil, fmt.Errorf("plugin %s returned an invalid result: %w", name, err)
+	}
+
+	output := withStderr(response.Output, &stderr)
+	switch response.Status {
+	case "success":
+		return output, exitCode, response.Data, nil
+	case "failed":
+		msg := response.Error
+		if msg == "" {
+			msg = "plugin reported failure"
+		}
+		return output, exitCode, response.Data, fmt.Errorf("plugin %s: %s", name, msg)
+	default:
+		return output, exitCode, response.Data, fmt.Errorf("plugin %s returned unknown status %q", name, response.Status)
+	}
+}
+
+// verifyPlugin checks the plugin is allowlisted and that its executable
+// matches the pinned checksum, and returns the executable's path
+func (e *Executor) verifyPlugin(name string, allowlist map[string]string) (string, error) {
+	if !pluginNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid plugin name %q", name)
+	}
+	pinned, ok := allowlist[name]
+	if !ok {
+		return "", fmt.Errorf("plugin %q is not allowlisted by the control plane", name)
+	}
+
+	file := name
+	if runtime.GOOS == "windows" {
+		file += ".exe"
+	}
+	path := filepath.Join(e.pluginDir, file)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("plugin %q not found in %s: %w", name, e.pluginDir, err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("plugin %q is not a regular file", name)
+	}
+	// Anyone who can rewrite the plugin could swap it after it is verified
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o022 != 0 {
+		return "", fmt.Errorf("plugin %q is writable by group or others", name)
+	}
+
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to checksum plugin %q: %w", name, err)
+	}
+	if !strings.EqualFold(sum, strings.TrimPrefix(pinned, "sha256:")) {
+		return "", fmt.Errorf("plugin %q checksum %s does not match the pinned checksum", name, sum)
+	}
+
+	return path, nil
+}
+
+// withStderr appends a plugin's stderr to its output
+func withStderr(output string, stderr *bytes.Buffer) string {
+	if stderr.Len() > 0 {
+		output += "\n--- stderr ---\n" + stderr.String()
+	}
+	return output
+}
+
+// fileSHA256 returns the hex SHA-256 of a file
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,237 @@
+// Package probe provides workflow execution functionality.
+package probe
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Priority represents the scheduling priority of a workflow job
+type Priority string
+
+const (
+	PriorityHigh   Priority = "high"
+	PriorityNormal Priority = "normal"
+	PriorityLow    Priority = "low"
+)
+
+// ParsePriority parses a priority string, defaulting to normal when empty
+func ParsePriority(s string) (Priority, error) {
+	switch Priority(strings.ToLower(strings.TrimSpace(s))) {
+	case "", PriorityNormal:
+		return PriorityNormal, nil
+	case PriorityHigh:
+		return PriorityHigh, nil
+	case PriorityLow:
+		return PriorityLow, nil
+	default:
+		return "", fmt.Errorf("invalid priority %q: must be high, normal or low", s)
+	}
+}
+
+// rank returns the numeric rank of a priority (higher runs first)
+func (p Priority) rank() int {
+	switch p {
+	case PriorityHigh:
+		return 2
+	case PriorityLow:
+		return 0
+	default:
+		return 1
+	}
+}
+
+// priorities lists all priority levels from highest to lowest
+var priorities = []Priority{PriorityHigh, PriorityNormal, PriorityLow}
+
+// queueEntry is a job waiting for an execution slot
+type queueEntry struct {
+	jobID      string
+	priority   Priority
+	enqueuedAt time.Time
+	ready      chan struct{}
+}
+
+// effectiveRank returns the rank after aging: waiting jobs are promoted one
+// level per aging interval so low-priority work is never starved
+func (e *queueEntry) effectiveRank(now time.Time, aging time.Duration) int {
+	rank := e.priority.rank()
+	if aging > 0 {
+		rank += int(now.Sub(e.enqueuedAt) / aging)
+	}
+	if rank > PriorityHigh.rank() {
+		rank = PriorityHigh.rank()
+	}
+	return rank
+}
+
+// priorityStats contains per-priority counters
+type priorityStats struct {
+	dispatched int64
+	totalWait  time.Duration
+	maxWait    time.Duration
+}
+
+// JobQueue schedules workflow jobs onto a fixed number of execution slots,
+// dispatching higher-priority jobs first
+type JobQueue struct {
+	mu       sync.Mutex
+	capacity int
+	running  int
+	aging    time.Duration
+	waiting  []*queueEntry
+	stats    map[Priority]*priorityStats
+	promoted int64
+}
+
+// NewJobQueue creates a new job queue. aging is the wait time after which a
+// queued job is promoted by one priority level (0 disables aging).
+func NewJobQueue(capacity int, aging time.Duration) *JobQueue {
+	stats := make(map[Priority]*priorityStats, len(priorities))
+	for _, p := range priorities {
+		stats[p] = &priorityStats{}
+	}
+
+	return &JobQueue{
+		capacity: capacity,
+		aging:    aging,
+		stats:    stats,
+	}
+}
+
+// Acquire blocks until the job is granted an execution slot or ctx is done
+func (q *JobQueue) Acquire(ctx context.Context, jobID string, priority Priority) error {
+	q.mu.Lock()
+	entry := &queueEntry{
+		jobID:      jobID,
+		priority:   priority,
+		enqueuedAt: time.Now(),
+		ready:      make(chan struct{}),
+	}
+	q.waiting = append(q.waiting, entry)
+	q.dispatchLocked()
+	q.mu.Unlock()
+
+	select {
+	case <-entry.ready:
+		return nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		for i, e := range q.waiting {
+			if e == entry {
+				q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+				return ctx.Err()
+			}
+		}
+
+		// The slot was granted concurrently with cancellation; hand it on
+		q.running--
+		q.dispatchLocked()
+		return ctx.Err()
+	}
+}
+
+// Release returns an execution slot to the queue
+func (q *JobQueue) Release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.running--
+	q.dispatchLocked()
+}
+
+// dispatchLocked grants free slots to the best waiting jobs
+func (q *JobQueue) dispatchLocked() {
+	now := time.Now()
+
+	for q.running < q.capacity && len(q.waiting) > 0 {
+		best := 0
+		bestRank := q.waiting[0].effectiveRank(now, q.aging)
+		for i := 1; i < len(q.waiting); i++ {
+			// Entries are in arrival order, so strict comparison keeps FIFO within a rank
+			if rank := q.waiting[i].effectiveRank(now, q.aging); rank > bestRank {
+				best, bestRank = i, rank
+			}
+		}
+
+		entry := q.waiting[best]
+		q.waiting = append(q.waiting[:best], q.waiting[best+1:]...)
+		q.running++
+
+		wait := now.Sub(entry.enqueuedAt)
+		stats := q.stats[entry.priority]
+		stats.dispatched++
+		stats.totalWait += wait
+		if wait > stats.maxWait {
+			stats.maxWait = wait
+		}
+		if bestRank > entry.priority.rank() {
+			q.promoted++
+		}
+
+		close(entry.ready)
+	}
+}
+
+// PriorityQueueStats contains queue metrics for a single priority level
+type PriorityQueueStats struct {
+	Queued              int     `json:"queued"`
+	Dispatched          int64   `json:"dispatched"`
+	AvgWaitSeconds      float64 `json:"avg_wait_seconds"`
+	MaxWaitSeconds      float64 `json:"max_wait_seconds"`
+	OldestQueuedSeconds float64 `json:"oldest_queued_seconds"`
+}
+
+// QueueStats contains job queue metrics
+type QueueStats struct {
+	Capacity     int                             `json:"capacity"`
+	Running      int                             `json:"running"`
+	Queued       int                             `json:"queued"`
+	Promoted     int64                           `json:"promoted"`
+	AgingSeconds float64                         `json:"aging_seconds"`
+	Priorities   map[Priority]PriorityQueueStats `json:"priorities"`
+}
+
+// Stats returns a snapshot of queue metrics
+func (q *JobQueue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	stats := QueueStats{
+		Capacity:     q.capacity,
+		Running:      q.running,
+		Queued:       len(q.waiting),
+		Promoted:     q.promoted,
+		AgingSeconds: q.aging.Seconds(),
+		Priorities:   make(map[Priority]PriorityQueueStats, len(priorities)),
+	}
+
+	for _, p := range priorities {
+		s := q.stats[p]
+		ps := PriorityQueueStats{
+			Dispatched:     s.dispatched,
+			MaxWaitSeconds: s.maxWait.Seconds(),
+		}
+		if s.dispatched > 0 {
+			ps.AvgWaitSeconds = (s.totalWait / time.Duration(s.dispatched)).Seconds()
+		}
+		stats.Priorities[p] = ps
+	}
+
+	for _, e := range q.waiting {
+		ps := stats.Priorities[e.priority]
+		ps.Queued++
+		if wait := now.Sub(e.enqueuedAt).Seconds(); wait > ps.OldestQueuedSeconds {
+			ps.OldestQueuedSeconds = wait
+		}
+		stats.Priorities[e.priority] = ps
+	}
+
+	return stats
+}
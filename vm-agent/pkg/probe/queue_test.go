@@ -0,0 +1,142 @@
+package probe
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEffectiveRank(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		priority Priority
+		waited   time.Duration
+		aging    time.Duration
+		want     int
+	}{
+		{"low not yet aged", PriorityLow, time.Minute, 2 * time.Minute, 0},
+		{"low aged once", PriorityLow, 2 * time.Minute, 2 * time.Minute, 1},
+		{"low aged twice", PriorityLow, 5 * time.Minute, 2 * time.Minute, 2},
+		{"promotion stops at high", PriorityLow, time.Hour, 2 * time.Minute, 2},
+		{"normal aged once", PriorityNormal, 2 * time.Minute, 2 * time.Minute, 2},
+		{"high stays high", PriorityHigh, time.Hour, 2 * time.Minute, 2},
+		{"aging disabled", PriorityLow, time.Hour, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &queueEntry{priority: tt.priority, enqueuedAt: now.Add(-tt.waited)}
+			if got := e.effectiveRank(now, tt.aging); got != tt.want {
+				t.Errorf("effectiveRank = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJobQueueOrder(t *testing.T) {
+	type job struct {
+		id       string
+		priority Priority
+		// waited backdates the job's arrival
+		waited time.Duration
+	}
+	tests := []struct {
+		name  string
+		aging time.Duration
+		jobs  []job
+		want  []string
+	}{
+		{
+			name:  "higher priority first",
+			aging: 2 * time.Minute,
+			jobs:  []job{{"low", PriorityLow, 0}, {"normal", PriorityNormal, 0}, {"high", PriorityHigh, 0}},
+			want:  []string{"high", "normal", "low"},
+		},
+		{
+			name:  "arrival order within a priority",
+			aging: 2 * time.Minute,
+			jobs:  []job{{"a", PriorityNormal, 0}, {"b", PriorityNormal, 0}, {"c", PriorityNormal, 0}},
+			want:  []string{"a", "b", "c"},
+		},
+		{
+			name:  "aged low job runs before a normal job",
+			aging: 2 * time.Minute,
+			jobs:  []job{{"normal", PriorityNormal, 0}, {"low", PriorityLow, 5 * time.Minute}},
+			want:  []string{"low", "normal"},
+		},
+		{
+			name:  "aged job keeps arrival order with high jobs",
+			aging: 2 * time.Minute,
+			jobs:  []job{{"high", PriorityHigh, 0}, {"low", PriorityLow, 5 * time.Minute}, {"normal", PriorityNormal, 3 * time.Minute}, {"later", PriorityHigh, 0}},
+			want:  []string{"high", "low", "normal", "later"},
+		},
+		{
+			name:  "no promotion when aging is disabled",
+			aging: 0,
+			jobs:  []job{{"low", PriorityLow, time.Hour}, {"normal", PriorityNormal, 0}},
+			want:  []string{"normal", "low"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewJobQueue(1, tt.aging)
+			ctx := context.Background()
+			if err := q.Acquire(ctx, "running", PriorityNormal); err != nil {
+				t.Fatal(err)
+			}
+
+			granted := make(chan string, len(tt.jobs))
+			for i, j := range tt.jobs {
+				go func(j job) {
+					if err := q.Acquire(ctx, j.id, j.priority); err == nil {
+						granted <- j.id
+					}
+				}(j)
+				waitQueued(t, q, i+1)
+				q.mu.Lock()
+				q.waiting[i].enqueuedAt = q.waiting[i].enqueuedAt.Add(-j.waited)
+				q.mu.Unlock()
+			}
+
+			for i, want := range tt.want {
+				q.Release()
+				if got := <-granted; got != want {
+					t.Fatalf("job %d = %s, want %s", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestJobQueueCancel(t *testing.T) {
+	q := NewJobQueue(1, 0)
+	if err := q.Acquire(context.Background(), "running", PriorityNormal); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- q.Acquire(ctx, "cancelled", PriorityHigh) }()
+	waitQueued(t, q, 1)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Acquire = %v, want context.Canceled", err)
+	}
+
+	stats := q.Stats()
+	if stats.Queued != 0 || stats.Running != 1 {
+		t.Fatalf("queued %d, running %d after cancel; want 0 and 1", stats.Queued, stats.Running)
+	}
+}
+
+// waitQueued waits until n jobs are waiting for a slot
+func waitQueued(t *testing.T, q *JobQueue, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Stats().Queued < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d jobs never queued", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
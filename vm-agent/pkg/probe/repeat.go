@@ -0,0 +1,126 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxRepeatIterations bounds the iterations of a repeated step
+const maxRepeatIterations = 1000
+
+// Variables the until condition of a repeated step reads the iteration it
+// follows from: its number, counted from 1, exit code and stdout
+const (
+	iterationEnv = "VM_AGENT_ITERATION"
+	exitCodeEnv  = "VM_AGENT_EXIT_CODE"
+	outputEnv    = "VM_AGENT_OUTPUT"
+)
+
+// maxUntilOutput bounds the stdout passed to an until condition, and
+// maxIterationOutput the output kept in each iteration's result
+const (
+	maxUntilOutput     = 32 * 1024
+	maxIterationOutput = 4 * 1024
+)
+
+// RepeatConfig runs a command or script step again until a condition holds
+// after an iteration, up to MaxIterations times, waiting Delay between
+// iterations. Until is a shell command like a step condition; it holds
+// when it exits 0.
+type RepeatConfig struct {
+	Until         string        `yaml:"until" json:"until"`
+	MaxIterations int           `yaml:"max_iterations" json:"max_iterations"`
+	Delay         time.Duration `yaml:"delay,omitempty" json:"delay,omitempty"`
+}
+
+// Validate validates a repeat configuration
+func (r *RepeatConfig) Validate() error {
+	if strings.TrimSpace(r.Until) == "" {
+		return fmt.Errorf("until is required")
+	}
+	if r.MaxIterations < 1 || r.MaxIterations > maxRepeatIterations {
+		return fmt.Errorf("max_iterations must be between 1 and %d", maxRepeatIterations)
+	}
+	if r.Delay < 0 {
+		return fmt.Errorf("delay must not be negative")
+	}
+	return nil
+}
+
+// IterationResult is the outcome of one iteration of a repeated step
+type IterationResult struct {
+	Iteration int    `json:"iteration"`
+	ExitCode  int    `json:"exit_code"`
+	Output    string `json:"output"`
+	Error     string `json:"error,omitempty"`
+	// UntilMet is whether the until condition held after the iteration
+	UntilMet  bool          `json:"until_met"`
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"-"` // written as duration_ms
+}
+
+// executeRepeat runs a repeated step's iterations, recording each in the
+// step result. The step succeeds once the until condition holds, whatever
+// the exit code of the iteration before it, and fails when it still does
+// not hold after the last iteration.
+func (e *Executor) executeRepeat(ctx context.Context, job *Job, step *Step, result *StepResult) error {
+	repeat := step.Repeat
+	for i := 1; i <= repeat.MaxIterations; i++ {
+		if i > 1 && repeat.Delay > 0 {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("stopped after %d iterations: %w", i-1, ctx.Err())
+			case <-time.After(repeat.Delay):
+			}
+		}
+
+		iteration := IterationResult{Iteration: i, StartedAt: time.Now()}
+		output, exitCode, err := e.runStep(ctx, job, step, result)
+		result.Output = output
+		result.ExitCode = exitCode
+		iteration.ExitCode = exitCode
+		iteration.Output = truncateOutput(output, maxIterationOutput)
+		if err != nil {
+			iteration.Error = err.Error()
+		}
+
+		if ctx.Err() != nil {
+			iteration.Duration = time.Since(iteration.StartedAt)
+			result.Iterations = append(result.Iterations, iteration)
+			return fmt.Errorf("stopped after %d iterations: %w", i, ctx.Err())
+		}
+
+		iteration.UntilMet = e.evaluateCondition(ctx, repeat.Until, job, step,
+			iterationEnv+"="+strconv.Itoa(i),
+			exitCodeEnv+"="+strconv.Itoa(exitCode),
+			outputEnv+"="+truncateOutput(strings.TrimRight(stepStdout(output), "\r\n"), maxUntilOutput))
+		iteration.Duration = time.Since(iteration.StartedAt)
+		result.Iterations = append(result.Iterations, iteration)
+
+		if iteration.UntilMet {
+			result.Status = StepStatusSuccess
+			e.jobLogger(job).Info("repeated step condition met",
+				zap.String("step_id", step.ID),
+				zap.Int("iteration", i))
+			return nil
+		}
+		e.jobLogger(job).Debug("repeated step condition not met",
+			zap.String("step_id", step.ID),
+			zap.Int("iteration", i),
+			zap.Int("exit_code", exitCode))
+	}
+	return fmt.Errorf("until condition not met after %d iterations", repeat.MaxIterations)
+}
+
+// truncateOutput cuts output to its first limit bytes
+func truncateOutput(output string, limit int) string {
+	if len(output) <= limit {
+		return output
+	}
+	return output[:limit]
+}
@@ -0,0 +1,129 @@
+package probe
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRepeat(t *testing.T) {
+	tests := []struct {
+		name string
+		// step is the YAML of the workflow's one step, after its id and name
+		step string
+		// wantErr is part of the expected error message
+		wantErr   string
+		wantDelay time.Duration
+	}{
+		{
+			name: "command",
+			step: `command: systemctl is-active nginx
+repeat:
+  until: test "$VM_AGENT_EXIT_CODE" -eq 0
+  max_iterations: 10
+  delay: 5s`,
+			wantDelay: 5 * time.Second,
+		},
+		{
+			name: "script without delay",
+			step: `type: script
+script: curl -fsS http://localhost/health
+repeat:
+  until: echo "$VM_AGENT_OUTPUT" | grep -q ok
+  max_iterations: 1`,
+		},
+		{
+			name: "until required",
+			step: `command: "true"
+repeat:
+  until: "  "
+  max_iterations: 3`,
+			wantErr: "repeat: until is required",
+		},
+		{
+			name: "max_iterations required",
+			step: `command: "true"
+repeat:
+  until: "true"`,
+			wantErr: "repeat: max_iterations must be between 1 and 1000",
+		},
+		{
+			name: "max_iterations bounded",
+			step: `command: "true"
+repeat:
+  until: "true"
+  max_iterations: 1001`,
+			wantErr: "repeat: max_iterations must be between 1 and 1000",
+		},
+		{
+			name: "negative delay",
+			step: `command: "true"
+repeat:
+  until: "true"
+  max_iterations: 3
+  delay: -1s`,
+			wantErr: "repeat: delay must not be negative",
+		},
+		{
+			name: "retries",
+			step: `command: "true"
+retry_count: 2
+repeat:
+  until: "true"
+  max_iterations: 3`,
+			wantErr: "repeat and retry_count are mutually exclusive",
+		},
+		{
+			name: "other step types",
+			step: `type: http
+http:
+  url: http://localhost/health
+repeat:
+  until: "true"
+  max_iterations: 3`,
+			wantErr: "repeat is only supported for command and script steps",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "name: repeat\nsteps:\n  - id: step\n    name: step\n    " +
+				strings.ReplaceAll(tt.step, "\n", "\n    ") + "\n"
+			workflow, err := ParseWorkflow([]byte(source))
+			if err != nil {
+				t.Fatalf("ParseWorkflow: %v", err)
+			}
+			err = workflow.Validate()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Validate error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if got := workflow.Steps[0].Repeat.Delay; got != tt.wantDelay {
+				t.Errorf("delay = %v, want %v", got, tt.wantDelay)
+			}
+		})
+	}
+}
+
+func TestTruncateOutput(t *testing.T) {
+	tests := []struct {
+		output string
+		limit  int
+		want   string
+	}{
+		{"", 4, ""},
+		{"ok", 4, "ok"},
+		{"okay", 4, "okay"},
+		{"okay then", 4, "okay"},
+	}
+	for _, tt := range tests {
+		if got := truncateOutput(tt.output, tt.limit); got != tt.want {
+			t.Errorf("truncateOutput(%q, %d) = %q, want %q", tt.output, tt.limit, got, tt.want)
+		}
+	}
+}
@@ -11,6 +11,9 @@ import (
 	"time"
 
 	"go.uber.org/zap"
+
+	"github.com/yourorg/vm-agent/pkg/identity"
+	"github.com/yourorg/vm-agent/pkg/outbox"
 )
 
 // Reporter reports workflow results to the control plane
@@ -18,11 +21,14 @@ type Reporter struct {
 	mu         sync.Mutex
 	reportURL  string
 	token      string
+	identity   *identity.Identity
 	httpClient *http.Client
 	logger     *zap.Logger
 	queue      chan *WorkflowResult
 	wg         sync.WaitGroup
 	stopCh     chan struct{}
+	onReported func(*WorkflowResult)
+	outbox     *outbox.Outbox
 }
 
 // ReporterConfig contains reporter configuration
@@ -32,6 +38,42 @@ type ReporterConfig struct {
 	QueueSize   int
 	MaxRetries  int
 	RetryDelay  time.Duration
+	Identity    *identity.Identity // Signs reports when set
+	// Outbox queues the results the control plane cannot be reached for
+	Outbox *outbox.Outbox
+}
+
+// SetToken replaces the token reports authenticate with, after the agent
+// refreshed it
+func (r *Reporter) SetToken(token string) {
+	r.mu.Lock()
+	r.token = token
+	r.mu.Unlock()
+}
+
+// OnReported sets a callback invoked with each result once it has been
+// sent, queued in the outbox, or given up on
+func (r *Reporter) OnReported(fn func(result *WorkflowResult)) {
+	r.mu.Lock()
+	r.onReported = fn
+	r.mu.Unlock()
+}
+
+// reported invokes the OnReported callback for a result
+func (r *Reporter) reported(result *WorkflowResult) {
+	r.mu.Lock()
+	fn := r.onReported
+	r.mu.Unlock()
+	if fn != nil {
+		fn(result)
+	}
+}
+
+// currentToken returns the token reports authenticate with
+func (r *Reporter) currentToken() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.token
 }
 
 // NewReporter creates a new workflow result reporter
@@ -44,6 +86,8 @@ func NewReporter(cfg *ReporterConfig, logger *zap.Logger) *Reporter {
 	return &Reporter{
 		reportURL: cfg.ReportURL,
 		token:     cfg.Token,
+		identity:  cfg.Identity,
+		outbox:    cfg.Outbox,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
@@ -72,6 +116,7 @@ func (r *Reporter) Report(result *WorkflowResult) {
 	default:
 		r.logger.Warn("report queue full, dropping result",
 			zap.String("workflow_id", result.WorkflowID))
+		r.reported(result)
 	}
 }
 
@@ -108,8 +153,10 @@ func (r *Reporter) flushQueue() {
 	}
 }
 
-// sendReport sends a single report
+// sendReport sends a single report, or queues it in the outbox when the
+// control plane cannot be reached
 func (r *Reporter) sendReport(ctx context.Context, result *WorkflowResult) {
+	defer r.reported(result)
 	if r.reportURL == "" {
 		return
 	}
@@ -122,6 +169,13 @@ func (r *Reporter) sendReport(ctx context.Context, result *WorkflowResult) {
 		return
 	}
 
+	// Results already queued go first, so that a workflow's results reach
+	// the control plane in order
+	if r.outbox != nil && r.outbox.Len() > 0 {
+		r.enqueue(result, payload)
+		return
+	}
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.reportURL, bytes.NewReader(payload))
 	if err != nil {
 		r.logger.Error("failed to create report request",
@@ -131,8 +185,14 @@ func (r *Reporter) sendReport(ctx context.Context, result *WorkflowResult) {
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	if r.token != "" {
-		req.Header.Set("Authorization", "Bearer "+r.token)
+	if result.RequestID != "" {
+		req.Header.Set("X-Request-ID", result.RequestID)
+	}
+	if token := r.currentToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if r.identity != nil {
+		r.identity.SignRequest(req, payload)
 	}
 
 	resp, err := r.httpClient.Do(req)
@@ -140,6 +200,7 @@ func (r *Reporter) sendReport(ctx context.Context, result *WorkflowResult) {
 		r.logger.Error("failed to send workflow report",
 			zap.String("workflow_id", result.WorkflowID),
 			zap.Error(err))
+		r.enqueue(result, payload)
 		return
 	}
 	defer resp.Body.Close()
@@ -148,6 +209,9 @@ func (r *Reporter) sendReport(ctx context.Context, result *WorkflowResult) {
 		r.logger.Error("workflow report rejected",
 			zap.String("workflow_id", result.WorkflowID),
 			zap.Int("status_code", resp.StatusCode))
+		if outbox.Retryable(resp.StatusCode) {
+			r.enqueue(result, payload)
+		}
 		return
 	}
 
@@ -156,6 +220,30 @@ func (r *Reporter) sendReport(ctx context.Context, result *WorkflowResult) {
 		zap.String("status", string(result.Status)))
 }
 
+// enqueue queues a report in the outbox, replacing the workflow's result
+// queued before, which the new one supersedes
+func (r *Reporter) enqueue(result *WorkflowResult, payload []byte) {
+	if r.outbox == nil {
+		return
+	}
+	err := r.outbox.Enqueue(&outbox.Entry{
+		Kind:      outbox.KindResult,
+		Key:       outbox.KindResult + ":" + result.WorkflowID,
+		URL:       r.reportURL,
+		RequestID: result.RequestID,
+		Body:      payload,
+	})
+	if err != nil {
+		r.logger.Error("failed to queue workflow report, dropping it",
+			zap.String("workflow_id", result.WorkflowID),
+			zap.Error(err))
+		return
+	}
+	r.logger.Info("workflow report queued until the control plane can be reached",
+		zap.String("workflow_id", result.WorkflowID),
+		zap.String("status", string(result.Status)))
+}
+
 // ReportSync sends a report synchronously and returns any error
 func (r *Reporter) ReportSync(ctx context.Context, result *WorkflowResult) error {
 	if r.reportURL == "" {
@@ -173,8 +261,14 @@ func (r *Reporter) ReportSync(ctx context.Context, result *WorkflowResult) error
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	if r.token != "" {
-		req.Header.Set("Authorization", "Bearer "+r.token)
+	if result.RequestID != "" {
+		req.Header.Set("X-Request-ID", result.RequestID)
+	}
+	if token := r.currentToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if r.identity != nil {
+		r.identity.SignRequest(req, payload)
 	}
 
 	resp, err := r.httpClient.Do(req)
@@ -0,0 +1,150 @@
+package probe
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Resources are what a workflow expects to need on the agent. They are
+// reserved from the time the workflow is accepted until it finishes, so
+// workflows accepted together cannot oversubscribe the host.
+type Resources struct {
+	// DiskGB is free disk space needed in the agent's work directory
+	DiskGB float64 `yaml:"disk_gb,omitempty" json:"disk_gb,omitempty"`
+	// MemoryMB is available memory needed
+	MemoryMB float64 `yaml:"memory_mb,omitempty" json:"memory_mb,omitempty"`
+}
+
+// Resource kinds reported by InsufficientResourcesError. ResourceWorkDir is
+// the quota of all job directories together.
+const (
+	ResourceDisk    = "disk"
+	ResourceMemory  = "memory"
+	ResourceWorkDir = "work_dir"
+)
+
+// ErrInsufficientResources is wrapped by InsufficientResourcesError
+var ErrInsufficientResources = errors.New("insufficient resources")
+
+// InsufficientResourcesError rejects a workflow the agent has no room for
+// at the moment. The workflow may be accepted once other workflows finish,
+// so callers should retry it later rather than fail it.
+type InsufficientResourcesError struct {
+	Resource string `json:"resource"`
+	// Required and Available are in bytes; Available excludes what
+	// workflows already accepted have reserved. Required disk includes the
+	// free space the agent keeps.
+	Required  uint64 `json:"required"`
+	Available uint64 `json:"available"`
+}
+
+func (e *InsufficientResourcesError) Error() string {
+	if e.Required == 0 {
+		return fmt.Sprintf("insufficient resources: %s quota is used up", e.Resource)
+	}
+	return fmt.Sprintf("insufficient resources: workflow needs %s of %s, %s available",
+		formatBytes(e.Required), e.Resource, formatBytes(e.Available))
+}
+
+func (e *InsufficientResourcesError) Unwrap() error {
+	return ErrInsufficientResources
+}
+
+// validate checks the declared resources
+func (r *Resources) validate() error {
+	if r.DiskGB < 0 {
+		return fmt.Errorf("resources.disk_gb must not be negative")
+	}
+	if r.MemoryMB < 0 {
+		return fmt.Errorf("resources.memory_mb must not be negative")
+	}
+	return nil
+}
+
+// reservation is the resources a job holds, in bytes
+type reservation struct {
+	disk   uint64
+	memory uint64
+}
+
+// reservationOf converts declared resources to bytes
+func reservationOf(r *Resources) reservation {
+	if r == nil {
+		return reservation{}
+	}
+	return reservation{
+		disk:   uint64(r.DiskGB * (1 << 30)),
+		memory: uint64(r.MemoryMB * (1 << 20)),
+	}
+}
+
+// reserve sets aside a job's resources, or returns an
+// InsufficientResourcesError when what is left after the reservations of
+// accepted jobs does not cover them, less the free space kept on disk, or
+// the job directories, measuring jobDirs, fill their quota. A resource that
+// cannot be measured on this platform is not checked. The caller must hold
+// e.mu.
+func (e *Executor) reserve(job *Job, jobDirs uint64) error {
+	need := reservationOf(job.Workflow.Resources)
+	if e.workDirQuota > 0 {
+		if available := remaining(e.workDirQuota, jobDirs); available == 0 || need.disk > available {
+			return &InsufficientResourcesError{Resource: ResourceWorkDir, Required: need.disk, Available: available}
+		}
+	}
+	if need.disk > 0 || e.minFreeDisk > 0 {
+		free, _, err := diskSpace(e.workDir)
+		if err == nil {
+			if available := remaining(free, e.reserved.disk); need.disk+e.minFreeDisk > available {
+				return &InsufficientResourcesError{Resource: ResourceDisk, Required: need.disk + e.minFreeDisk, Available: available}
+			}
+		}
+	}
+	if need.memory > 0 {
+		free, err := availableMemory()
+		if err == nil {
+			if available := remaining(free, e.reserved.memory); need.memory > available {
+				return &InsufficientResourcesError{Resource: ResourceMemory, Required: need.memory, Available: available}
+			}
+		}
+	}
+
+	job.reserved = need
+	e.reserved.disk += need.disk
+	e.reserved.memory += need.memory
+	return nil
+}
+
+// unreserve releases a finished job's resources. The caller must hold e.mu.
+func (e *Executor) unreserve(job *Job) {
+	e.reserved.disk -= job.reserved.disk
+	e.reserved.memory -= job.reserved.memory
+	job.reserved = reservation{}
+}
+
+// nonNegative converts a configured byte count, treating negative as unset
+func nonNegative(n int64) uint64 {
+	if n < 0 {
+		return 0
+	}
+	return uint64(n)
+}
+
+// remaining is free less reserved, never below zero
+func remaining(free, reserved uint64) uint64 {
+	if reserved >= free {
+		return 0
+	}
+	return free - reserved
+}
+
+// formatBytes renders a byte count for messages
+func formatBytes(n uint64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	default:
+		return fmt.Sprintf("%d bytes", n)
+	}
+}
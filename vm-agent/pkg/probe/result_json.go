@@ -0,0 +1,125 @@
+package probe
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Results are written with RFC 3339 timestamps in UTC and durations in whole
+// milliseconds in fields ending in _ms. Agents before duration_ms wrote
+// durations in nanoseconds without the suffix; those are still read, so jobs
+// and queued results persisted by an older agent survive an upgrade.
+
+// MarshalJSON implements json.Marshaler
+func (r StepResult) MarshalJSON() ([]byte, error) {
+	type plain StepResult
+	return json.Marshal(struct {
+		plain
+		StartedAt  time.Time `json:"started_at"`
+		EndedAt    time.Time `json:"ended_at"`
+		DurationMs int64     `json:"duration_ms"`
+	}{
+		plain:      plain(r),
+		StartedAt:  r.StartedAt.UTC(),
+		EndedAt:    r.EndedAt.UTC(),
+		DurationMs: r.Duration.Milliseconds(),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (r *StepResult) UnmarshalJSON(data []byte) error {
+	type plain StepResult
+	aux := struct {
+		*plain
+		Duration   *int64 `json:"duration"`
+		DurationMs *int64 `json:"duration_ms"`
+	}{plain: (*plain)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	r.Duration = decodeDuration(aux.DurationMs, aux.Duration)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (r WorkflowResult) MarshalJSON() ([]byte, error) {
+	type plain WorkflowResult
+	out := struct {
+		plain
+		StartedAt   time.Time  `json:"started_at"`
+		EndedAt     time.Time  `json:"ended_at"`
+		DurationMs  int64      `json:"duration_ms"`
+		PausedAt    *time.Time `json:"paused_at,omitempty"`
+		PausedForMs int64      `json:"paused_for_ms,omitempty"`
+	}{
+		plain:       plain(r),
+		StartedAt:   r.StartedAt.UTC(),
+		EndedAt:     r.EndedAt.UTC(),
+		DurationMs:  r.Duration.Milliseconds(),
+		PausedForMs: r.PausedFor.Milliseconds(),
+	}
+	if r.PausedAt != nil {
+		pausedAt := r.PausedAt.UTC()
+		out.PausedAt = &pausedAt
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (r *WorkflowResult) UnmarshalJSON(data []byte) error {
+	type plain WorkflowResult
+	aux := struct {
+		*plain
+		Duration    *int64 `json:"duration"`
+		DurationMs  *int64 `json:"duration_ms"`
+		PausedFor   *int64 `json:"paused_for"`
+		PausedForMs *int64 `json:"paused_for_ms"`
+	}{plain: (*plain)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	r.Duration = decodeDuration(aux.DurationMs, aux.Duration)
+	r.PausedFor = decodeDuration(aux.PausedForMs, aux.PausedFor)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (r IterationResult) MarshalJSON() ([]byte, error) {
+	type plain IterationResult
+	return json.Marshal(struct {
+		plain
+		StartedAt  time.Time `json:"started_at"`
+		DurationMs int64     `json:"duration_ms"`
+	}{
+		plain:      plain(r),
+		StartedAt:  r.StartedAt.UTC(),
+		DurationMs: r.Duration.Milliseconds(),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (r *IterationResult) UnmarshalJSON(data []byte) error {
+	type plain IterationResult
+	aux := struct {
+		*plain
+		Duration   *int64 `json:"duration"`
+		DurationMs *int64 `json:"duration_ms"`
+	}{plain: (*plain)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	r.Duration = decodeDuration(aux.DurationMs, aux.Duration)
+	return nil
+}
+
+// decodeDuration returns a duration read in milliseconds, or in nanoseconds
+// as written by older agents
+func decodeDuration(ms, ns *int64) time.Duration {
+	switch {
+	case ms != nil:
+		return time.Duration(*ms) * time.Millisecond
+	case ns != nil:
+		return time.Duration(*ns)
+	}
+	return 0
+}
@@ -0,0 +1,188 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Sandbox network modes
+const (
+	SandboxNetworkNone   = "none"
+	SandboxNetworkBridge = "bridge"
+	SandboxNetworkHost   = "host"
+)
+
+// Sandbox fallbacks when no container runtime is installed
+const (
+	SandboxFallbackFail = "fail"
+	SandboxFallbackHost = "host"
+)
+
+// sandboxRuntimes are the container runtimes tried in order
+var sandboxRuntimes = []string{"docker", "podman"}
+
+// sandboxScriptDir is where script files are mounted inside a sandbox
+const sandboxScriptDir = "/run/vm-agent"
+
+// SandboxConfig runs a step inside a container instead of on the host
+type SandboxConfig struct {
+	// Image is the container image the step runs in
+	Image string `yaml:"image" json:"image"`
+	// Runtime is docker or podman; the first one installed is used when unset
+	Runtime string `yaml:"runtime,omitempty" json:"runtime,omitempty"`
+	// Network is none (default), bridge or host
+	Network string `yaml:"network,omitempty" json:"network,omitempty"`
+	// Mounts are host paths made available inside the container
+	Mounts []SandboxMount `yaml:"mounts,omitempty" json:"mounts,omitempty"`
+	// Fallback is what happens when no runtime is installed: fail (default)
+	// or host, which runs the step on the host without isolation
+	Fallback string `yaml:"fallback,omitempty" json:"fallback,omitempty"`
+}
+
+// SandboxMount bind-mounts a host path into the sandbox
+type SandboxMount struct {
+	Source   string `yaml:"source" json:"source"`
+	Target   string `yaml:"target" json:"target"`
+	ReadOnly bool   `yaml:"read_only,omitempty" json:"read_only,omitempty"`
+}
+
+// Validate validates the sandbox configuration
+func (s *SandboxConfig) Validate() error {
+	if s.Image == "" {
+		return fmt.Errorf("image is required")
+	}
+	switch s.Runtime {
+	case "", "docker", "podman":
+	default:
+		return fmt.Errorf("invalid runtime %q: must be docker or podman", s.Runtime)
+	}
+	switch s.Network {
+	case "", SandboxNetworkNone, SandboxNetworkBridge, SandboxNetworkHost:
+	default:
+		return fmt.Errorf("invalid network %q: must be none, bridge or host", s.Network)
+	}
+	switch s.Fallback {
+	case "", SandboxFallbackFail, SandboxFallbackHost:
+	default:
+		return fmt.Errorf("invalid fallback %q: must be fail or host", s.Fallback)
+	}
+	for _, m := range s.Mounts {
+		if m.Source == "" || m.Target == "" {
+			return fmt.Errorf("mount source and target are required")
+		}
+		if !path.IsAbs(m.Target) {
+			return fmt.Errorf("mount target %q must be an absolute path", m.Target)
+		}
+	}
+	return nil
+}
+
+// sandboxRuntime returns the container runtime binary for a sandboxed step.
+// It returns "" when none is installed and the sandbox falls back to the
+// host, and an error when it does not.
+func (e *Executor) sandboxRuntime(step *Step) (string, error) {
+	candidates := sandboxRuntimes
+	if step.Sandbox.Runtime != "" {
+		candidates = []string{step.Sandbox.Runtime}
+	}
+	for _, name := range candidates {
+		if resolved, err := exec.LookPath(name); err == nil {
+			return resolved, nil
+		}
+	}
+
+	if step.Sandbox.Fallback == SandboxFallbackHost {
+		e.logger.Warn("no container runtime available, running sandboxed step on the host",
+			zap.String("step_id", step.ID),
+			zap.Strings("runtimes", candidates))
+		return "", nil
+	}
+	return "", fmt.Errorf("step requires a container sandbox but no runtime is available on this agent (looked for %s in PATH)",
+		strings.Join(candidates, ", "))
+}
+
+// sandboxArgs wraps argv in a "run" of the step's sandbox image. Workflow
+// and step variables are passed by name so their values are read from the
+// runtime's environment rather than appearing on its command line; the
+// agent's own environment is not passed into the container.
+func sandboxArgs(runtime, name string, step *Step, job *Job, argv []string) []string {
+	sb := step.Sandbox
+	network := sb.Network
+	if network == "" {
+		network = SandboxNetworkNone
+	}
+
+	args := []string{runtime, "run", "--rm", "--name", name, "--network", network}
+	if step.WorkDir != "" {
+		args = append(args, "--workdir", step.WorkDir)
+	}
+	for _, m := range sb.Mounts {
+		spec := m.Source + ":" + m.Target
+		if m.ReadOnly {
+			spec += ":ro"
+		}
+		args = append(args, "--volume", spec)
+	}
+
+	vars := make(map[string]bool, len(job.Workflow.Env)+len(step.Env))
+	for k := range job.Workflow.Env {
+		vars[k] = true
+	}
+	for k := range step.Env {
+		vars[k] = true
+	}
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "--env", k)
+	}
+
+	args = append(args, sb.Image)
+	return append(args, argv...)
+}
+
+// containerScriptCommand returns the command line that runs the script at
+// path inside a container with the named interpreter. The image is expected
+// to provide the interpreter's usual binary.
+func containerScriptCommand(name, path string) []string {
+	if name == "" {
+		return []string{path}
+	}
+	interp := interpreters[name]
+	return append([]string{interp.binaries[0]}, interp.args(path)...)
+}
+
+// sandboxName returns the container name of a step run
+func sandboxName(job *Job, step *Step) string {
+	name := fmt.Sprintf("vm-agent-%s-%s-%d", job.ID, step.ID, time.Now().UnixNano())
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+// removeSandbox force-removes a step's container. Killing the runtime client
+// on cancellation or timeout does not stop the container itself.
+func (e *Executor) removeSandbox(runtime, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if out, err := exec.CommandContext(ctx, runtime, "rm", "--force", name).CombinedOutput(); err != nil {
+		e.logger.Warn("failed to remove sandbox container",
+			zap.String("container", name),
+			zap.String("output", strings.TrimSpace(string(out))),
+			zap.Error(err))
+	}
+}
@@ -0,0 +1,192 @@
+package probe
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTemplateCacheMaxBytes bounds the template cache when no limit is
+// configured
+const DefaultTemplateCacheMaxBytes = 256 * 1024 * 1024
+
+// contentHashPrefix begins every content hash
+const contentHashPrefix = "sha256:"
+
+// ContentHash returns the hash the control plane identifies template
+// content by, "sha256:" followed by the hex digest
+func ContentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return contentHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// TemplateCache keeps fetched template content on disk under its content
+// hash, so content the agent already has is not downloaded again. The
+// least recently used entries are evicted once the cache exceeds its size.
+type TemplateCache struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	// sources maps sources to the hash of the content last fetched from
+	// them, sent back as If-None-Match
+	sources map[string]string
+}
+
+// NewTemplateCache creates a template cache in dir holding at most maxBytes
+// of content (default 256MiB)
+func NewTemplateCache(dir string, maxBytes int64) (*TemplateCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create template cache directory: %w", err)
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultTemplateCacheMaxBytes
+	}
+	return &TemplateCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		sources:  make(map[string]string),
+	}, nil
+}
+
+// Get returns the cached content with a hash. Entries that no longer match
+// their hash are removed and reported missing.
+func (c *TemplateCache) Get(hash string) (string, bool) {
+	path, ok := c.path(hash)
+	if !ok {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	content := string(data)
+	if ContentHash(content) != hash {
+		os.Remove(path)
+		return "", false
+	}
+
+	// The modification time orders entries for eviction
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return content, true
+}
+
+// Put caches content and returns its hash, evicting the least recently
+// used entries to stay within the cache size. Content larger than the
+// whole cache is not kept.
+func (c *TemplateCache) Put(content string) (string, error) {
+	hash := ContentHash(content)
+	if int64(len(content)) > c.maxBytes {
+		return hash, nil
+	}
+	path, _ := c.path(hash)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to cache template: %w", err)
+	}
+	_, writeErr := tmp.WriteString(content)
+	closeErr := tmp.Close()
+	if writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr == nil {
+		writeErr = os.Rename(tmp.Name(), path)
+	}
+	if writeErr != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to cache template: %w", writeErr)
+	}
+
+	c.evict(path)
+	return hash, nil
+}
+
+// SourceHash returns the hash of the content last fetched from a source
+func (c *TemplateCache) SourceHash(source string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sources[source]
+}
+
+// SetSourceHash records the hash of the content fetched from a source
+func (c *TemplateCache) SetSourceHash(source, hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sources[source] = hash
+}
+
+// path returns the file caching content with a hash, if it is a valid hash
+func (c *TemplateCache) path(hash string) (string, bool) {
+	digest := strings.TrimPrefix(hash, contentHashPrefix)
+	if digest == hash || len(digest) != sha256.Size*2 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(digest); err != nil {
+		return "", false
+	}
+	return filepath.Join(c.dir, digest), true
+}
+
+// evict removes the least recently used entries other than keep until the
+// cache fits its size. The caller holds the lock.
+func (c *TemplateCache) evict(keep string) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+
+	type cached struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cached
+	var total int64
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cached{
+			path:    filepath.Join(c.dir, entry.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+		total += info.Size()
+	}
+	if total <= c.maxBytes {
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, file := range files {
+		if total <= c.maxBytes {
+			break
+		}
+		if file.path == keep {
+			continue
+		}
+		if err := os.Remove(file.path); err == nil {
+			total -= file.size
+		}
+	}
+}
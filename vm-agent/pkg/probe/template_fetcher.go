@@ -3,18 +3,29 @@ package probe
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/yourorg/vm-agent/pkg/identity"
 )
 
 // TemplateFetcher fetches templates from various sources
 type TemplateFetcher struct {
-	httpClient       *http.Client
+	httpClient *http.Client
+	cache      *TemplateCache
+
+	mu               sync.RWMutex
 	controlPlaneURL  string
 	controlPlaneAuth string
+	identity         *identity.Identity
 }
 
 // TemplateFetcherConfig contains configuration for the template fetcher
@@ -25,6 +36,9 @@ type TemplateFetcherConfig struct {
 	ControlPlaneURL string
 	// ControlPlaneAuth is the authentication token for control plane
 	ControlPlaneAuth string
+	// Cache keeps control plane template content by content hash; nil
+	// downloads every template
+	Cache *TemplateCache
 }
 
 // NewTemplateFetcher creates a new template fetcher
@@ -38,6 +52,7 @@ func NewTemplateFetcher(cfg *TemplateFetcherConfig) *TemplateFetcher {
 		httpClient: &http.Client{
 			Timeout: timeout,
 		},
+		cache:            cfg.Cache,
 		controlPlaneURL:  cfg.ControlPlaneURL,
 		controlPlaneAuth: cfg.ControlPlaneAuth,
 	}
@@ -49,6 +64,9 @@ type FetchResult struct {
 	Source      string
 	ContentType string
 	ETag        string
+	// Cached reports the content was served from the template cache
+	// rather than downloaded
+	Cached bool
 }
 
 // Fetch fetches a template from the given source
@@ -56,12 +74,15 @@ type FetchResult struct {
 //   - http:// or https:// - fetch from HTTP URL
 //   - control-plane://templates/{id} - fetch from control plane
 //   - control-plane://templates/{id}/content - fetch raw content from control plane
-func (f *TemplateFetcher) Fetch(ctx context.Context, source string) (*FetchResult, error) {
+//
+// contentHash is the hash the control plane dispatched a control plane
+// template with; cached content with that hash is used without a request.
+func (f *TemplateFetcher) Fetch(ctx context.Context, source, contentHash string) (*FetchResult, error) {
 	switch {
 	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
 		return f.fetchHTTP(ctx, source)
 	case strings.HasPrefix(source, "control-plane://"):
-		return f.fetchControlPlane(ctx, source)
+		return f.fetchControlPlane(ctx, source, contentHash)
 	default:
 		return nil, fmt.Errorf("unsupported template source: %s", source)
 	}
@@ -97,32 +118,46 @@ func (f *TemplateFetcher) fetchHTTP(ctx context.Context, url string) (*FetchResu
 	}, nil
 }
 
-// fetchControlPlane fetches a template from the control plane
-func (f *TemplateFetcher) fetchControlPlane(ctx context.Context, source string) (*FetchResult, error) {
-	if f.controlPlaneURL == "" {
-		return nil, fmt.Errorf("control plane URL not configured")
+// fetchControlPlane fetches a template from the control plane, or from the
+// cache when it holds the dispatched content hash. Otherwise the hash last
+// fetched from the source is sent as If-None-Match, so unchanged content is
+// not transferred again.
+func (f *TemplateFetcher) fetchControlPlane(ctx context.Context, source, contentHash string) (*FetchResult, error) {
+	if f.cache != nil && contentHash != "" {
+		if content, ok := f.cache.Get(contentHash); ok {
+			return &FetchResult{
+				Content: content,
+				Source:  source,
+				ETag:    `"` + contentHash + `"`,
+				Cached:  true,
+			}, nil
+		}
 	}
 
-	// Parse the control-plane:// URL
-	// Format: control-plane://templates/{id} or control-plane://templates/{id}/content
-	path := strings.TrimPrefix(source, "control-plane://")
-
-	// Build the full URL
-	url := fmt.Sprintf("%s/api/v1/%s", strings.TrimSuffix(f.controlPlaneURL, "/"), path)
-
-	// Ensure we're fetching the content endpoint
-	if !strings.HasSuffix(url, "/content") {
-		url = url + "/content"
+	var cachedHash string
+	if f.cache != nil {
+		cachedHash = f.cache.SourceHash(source)
+	}
+	result, err := f.requestControlPlane(ctx, source, cachedHash)
+	if err == errTemplateEvicted {
+		result, err = f.requestControlPlane(ctx, source, "")
 	}
+	return result, err
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// errTemplateEvicted is returned when the control plane confirms content
+// the cache has since evicted
+var errTemplateEvicted = errors.New("cached template evicted")
+
+// requestControlPlane requests a template from the control plane,
+// conditionally on the content with cachedHash being current if set
+func (f *TemplateFetcher) requestControlPlane(ctx context.Context, source, cachedHash string) (*FetchResult, error) {
+	req, err := f.newControlPlaneRequest(ctx, source)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
-
-	// Add authentication header
-	if f.controlPlaneAuth != "" {
-		req.Header.Set("Authorization", "Bearer "+f.controlPlaneAuth)
+	if cachedHash != "" {
+		req.Header.Set("If-None-Match", `"`+cachedHash+`"`)
 	}
 
 	resp, err := f.httpClient.Do(req)
@@ -135,6 +170,19 @@ func (f *TemplateFetcher) fetchControlPlane(ctx context.Context, source string)
 		return nil, fmt.Errorf("template not found: %s", source)
 	}
 
+	if resp.StatusCode == http.StatusNotModified && cachedHash != "" {
+		content, ok := f.cache.Get(cachedHash)
+		if !ok {
+			return nil, errTemplateEvicted
+		}
+		return &FetchResult{
+			Content: content,
+			Source:  source,
+			ETag:    resp.Header.Get("ETag"),
+			Cached:  true,
+		}, nil
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("failed to fetch template from control plane: HTTP %d", resp.StatusCode)
 	}
@@ -144,6 +192,13 @@ func (f *TemplateFetcher) fetchControlPlane(ctx context.Context, source string)
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
+	// A cache failure costs a download next time, not this fetch
+	if f.cache != nil {
+		if hash, err := f.cache.Put(string(content)); err == nil {
+			f.cache.SetSourceHash(source, hash)
+		}
+	}
+
 	return &FetchResult{
 		Content:     string(content),
 		Source:      source,
@@ -152,8 +207,161 @@ func (f *TemplateFetcher) fetchControlPlane(ctx context.Context, source string)
 	}, nil
 }
 
+// newControlPlaneRequest creates an authenticated request for the content
+// of a control plane template. Agents read templates through the signed
+// agent routes, so the request is signed with the agent's identity.
+func (f *TemplateFetcher) newControlPlaneRequest(ctx context.Context, source string) (*http.Request, error) {
+	f.mu.RLock()
+	controlPlaneURL, controlPlaneAuth, id := f.controlPlaneURL, f.controlPlaneAuth, f.identity
+	f.mu.RUnlock()
+	if controlPlaneURL == "" {
+		return nil, fmt.Errorf("control plane URL not configured")
+	}
+
+	// Parse the control-plane:// URL
+	// Format: control-plane://templates/{id} or control-plane://templates/{id}/content,
+	// optionally with a query such as ?version=3
+	path := strings.TrimPrefix(source, "control-plane://")
+	path, query, _ := strings.Cut(path, "?")
+
+	// Build the full URL
+	url := fmt.Sprintf("%s/api/v1/agent/%s", strings.TrimSuffix(controlPlaneURL, "/"), path)
+
+	// Ensure we're fetching the content endpoint
+	if !strings.HasSuffix(url, "/content") {
+		url = url + "/content"
+	}
+	if query != "" {
+		url += "?" + query
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add authentication header
+	if controlPlaneAuth != "" {
+		req.Header.Set("Authorization", "Bearer "+controlPlaneAuth)
+	}
+	if id != nil {
+		id.SignRequest(req, nil)
+	}
+	return req, nil
+}
+
+// rawRangeSize is the size of the ranges uploaded content is downloaded in,
+// and rawRangeAttempts the attempts made at each range
+const (
+	rawRangeSize     = 4 << 20
+	rawRangeAttempts = 3
+)
+
+// FetchFile downloads the uploaded content of a control plane template
+// into a new temporary file in dir and returns its path. The content is
+// requested in ranges, each retried on failure, conditionally on it still
+// having contentHash; the file is checked against contentHash and size.
+// Uploaded content is deployed verbatim, so it is not held in memory or
+// cached.
+func (f *TemplateFetcher) FetchFile(ctx context.Context, source, contentHash string, size int64, dir string) (string, error) {
+	file, err := os.CreateTemp(dir, ".tmp-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	complete := false
+	defer func() {
+		if !complete {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+
+	hasher := sha256.New()
+	w := io.MultiWriter(file, hasher)
+	for offset := int64(0); offset < size; offset += rawRangeSize {
+		end := min(offset+rawRangeSize, size) - 1
+
+		var data []byte
+		for attempt := 1; ; attempt++ {
+			data, err = f.fetchRange(ctx, source, contentHash, offset, end)
+			if err == nil || attempt == rawRangeAttempts || ctx.Err() != nil || errors.Is(err, errTemplateChanged) {
+				break
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Duration(attempt) * time.Second):
+			}
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to fetch bytes %d-%d of template: %w", offset, end, err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return "", fmt.Errorf("failed to write template: %w", err)
+		}
+	}
+
+	if hash := "sha256:" + hex.EncodeToString(hasher.Sum(nil)); hash != contentHash {
+		return "", fmt.Errorf("template content hash %s does not match %s", hash, contentHash)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("failed to write template: %w", err)
+	}
+	complete = true
+	return file.Name(), nil
+}
+
+// errTemplateChanged is returned when a template's content changes while
+// it is downloaded in ranges
+var errTemplateChanged = errors.New("template content changed during download")
+
+// fetchRange fetches bytes start to end, inclusive, of a template's content
+func (f *TemplateFetcher) fetchRange(ctx context.Context, source, contentHash string, start, end int64) ([]byte, error) {
+	req, err := f.newControlPlaneRequest(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	req.Header.Set("If-Range", `"`+contentHash+`"`)
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// The whole content is only sent once it no longer has the hash
+		return nil, errTemplateChanged
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("template not found: %s", source)
+	default:
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	want := end - start + 1
+	data, err := io.ReadAll(io.LimitReader(resp.Body, want+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if int64(len(data)) != want {
+		return nil, fmt.Errorf("received %d bytes, expected %d", len(data), want)
+	}
+	return data, nil
+}
+
 // SetControlPlaneConfig updates the control plane configuration
 func (f *TemplateFetcher) SetControlPlaneConfig(url, auth string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	f.controlPlaneURL = url
 	f.controlPlaneAuth = auth
 }
+
+// SetIdentity sets the identity control plane requests are signed with
+func (f *TemplateFetcher) SetIdentity(id *identity.Identity) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.identity = id
+}
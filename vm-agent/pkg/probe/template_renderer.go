@@ -111,14 +111,21 @@ type RenderContext struct {
 	Env map[string]string
 	// Facts contains system facts gathered from the agent
 	Facts map[string]interface{}
+	// Grains contains the agent's host facts, exposed as grains.*
+	Grains map[string]interface{}
+	// Steps contains the results of the workflow's completed steps,
+	// exposed as steps.<id>
+	Steps map[string]interface{}
 }
 
 // NewRenderContext creates a new render context with defaults
 func NewRenderContext() *RenderContext {
 	return &RenderContext{
-		Vars:  make(map[string]interface{}),
-		Env:   make(map[string]string),
-		Facts: make(map[string]interface{}),
+		Vars:   make(map[string]interface{}),
+		Env:    make(map[string]string),
+		Facts:  make(map[string]interface{}),
+		Grains: make(map[string]interface{}),
+		Steps:  make(map[string]interface{}),
 	}
 }
 
@@ -163,6 +170,22 @@ func (c *RenderContext) WithSystemFacts() *RenderContext {
 	return c
 }
 
+// WithGrains adds the agent's grains to the context
+func (c *RenderContext) WithGrains(grains map[string]interface{}) *RenderContext {
+	for k, v := range grains {
+		c.Grains[k] = v
+	}
+	return c
+}
+
+// WithSteps adds the results of completed steps to the context
+func (c *RenderContext) WithSteps(results []StepResult) *RenderContext {
+	for k, v := range stepsContext(results) {
+		c.Steps[k] = v
+	}
+	return c
+}
+
 // ToContext converts RenderContext to pongo2.Context
 func (c *RenderContext) ToContext() pongo2.Context {
 	ctx := pongo2.Context{}
@@ -178,6 +201,11 @@ func (c *RenderContext) ToContext() pongo2.Context {
 	// Add facts as a nested object
 	ctx["facts"] = c.Facts
 
+	// Add grains and step results last so a workflow variable cannot
+	// shadow them
+	ctx[GrainsNamespace] = c.Grains
+	ctx[StepsNamespace] = c.Steps
+
 	return ctx
 }
 
@@ -15,14 +15,40 @@ type Workflow struct {
 	Description string                 `yaml:"description,omitempty" json:"description,omitempty"`
 	Version     string                 `yaml:"version,omitempty" json:"version,omitempty"`
 	Timeout     time.Duration          `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	Priority    Priority               `yaml:"priority,omitempty" json:"priority,omitempty"` // Scheduling priority (high/normal/low)
 	Env         map[string]string      `yaml:"env,omitempty" json:"env,omitempty"`
 	Vars        map[string]interface{} `yaml:"vars,omitempty" json:"vars,omitempty"` // Template variables (like Salt Pillar)
 	Steps       []Step                 `yaml:"steps" json:"steps"`
 	OnSuccess   []Step                 `yaml:"on_success,omitempty" json:"on_success,omitempty"`
 	OnFailure   []Step                 `yaml:"on_failure,omitempty" json:"on_failure,omitempty"`
 	OnCancel    []Step                 `yaml:"on_cancel,omitempty" json:"on_cancel,omitempty"`
+
+	// CleanEnv starts steps from an empty environment instead of the agent's;
+	// only PATH, HOME and similar basics plus EnvPassthrough are inherited
+	CleanEnv       bool     `yaml:"clean_env,omitempty" json:"clean_env,omitempty"`
+	EnvPassthrough []string `yaml:"env_passthrough,omitempty" json:"env_passthrough,omitempty"`
+
+	// Plugins is the control plane's allowlist of plugins steps may run,
+	// mapping each plugin name to the SHA-256 its executable must have
+	Plugins map[string]string `yaml:"plugins,omitempty" json:"plugins,omitempty"`
+
+	// Mode is set to validate by the control plane for executions that must
+	// not change the host; see WorkflowModeValidate
+	Mode string `yaml:"mode,omitempty" json:"mode,omitempty"`
+
+	// Resources are checked and reserved before the workflow is accepted
+	Resources *Resources `yaml:"resources,omitempty" json:"resources,omitempty"`
+
+	// Checkpoints are the steps a paused workflow may stop before, by step
+	// ID or matrix step ID. Without them it stops before the next step.
+	Checkpoints []string `yaml:"checkpoints,omitempty" json:"checkpoints,omitempty"`
 }
 
+// WorkflowModeValidate runs a workflow without changing the host: precheck
+// steps run, template steps only report the diff they would apply and all
+// other command, script, plugin and callback steps are skipped
+const WorkflowModeValidate = "validate"
+
 // Step represents a single step in a workflow
 type Step struct {
 	ID              string            `yaml:"id" json:"id"`
@@ -40,6 +66,42 @@ type Step struct {
 	Condition       string            `yaml:"condition,omitempty" json:"condition,omitempty"`
 	RunAs           string            `yaml:"run_as,omitempty" json:"run_as,omitempty"`
 	Template        *TemplateConfig   `yaml:"template,omitempty" json:"template,omitempty"` // Template step configuration
+	// Matrix expands the step into one step per combination of values
+	// (e.g. service: [nginx, haproxy, redis]) when the workflow is parsed
+	Matrix map[string][]string `yaml:"matrix,omitempty" json:"matrix,omitempty"`
+	// MatrixParent and MatrixValues are set on steps generated from a matrix
+	MatrixParent string            `yaml:"-" json:"matrix_parent,omitempty"`
+	MatrixValues map[string]string `yaml:"-" json:"matrix_values,omitempty"`
+
+	// CleanEnv overrides the workflow's clean_env for this step, and
+	// EnvPassthrough adds to the workflow's passthrough variables
+	CleanEnv       *bool    `yaml:"clean_env,omitempty" json:"clean_env,omitempty"`
+	EnvPassthrough []string `yaml:"env_passthrough,omitempty" json:"env_passthrough,omitempty"`
+
+	// Interpreter runs a script step with sh, bash, python3, pwsh or cmd.
+	// When unset, the script's shebang selects it, defaulting to sh.
+	Interpreter string `yaml:"interpreter,omitempty" json:"interpreter,omitempty"`
+
+	// Sandbox runs a command or script step inside a container
+	Sandbox *SandboxConfig `yaml:"sandbox,omitempty" json:"sandbox,omitempty"`
+
+	// Plugin runs an external plugin executable for a plugin step
+	Plugin *PluginConfig `yaml:"plugin,omitempty" json:"plugin,omitempty"`
+
+	// Callback posts a signed payload to an external system for a callback step
+	Callback *CallbackConfig `yaml:"callback,omitempty" json:"callback,omitempty"`
+
+	// OutputParser parses a command or script step's stdout into a map
+	// later steps can reference as steps.<id>.output
+	OutputParser *OutputParser `yaml:"output_parser,omitempty" json:"output_parser,omitempty"`
+
+	// Precheck marks a command or script step that only inspects the host,
+	// so it also runs in validate mode
+	Precheck bool `yaml:"precheck,omitempty" json:"precheck,omitempty"`
+
+	// Repeat runs a command or script step until a condition holds, in
+	// place of retries
+	Repeat *RepeatConfig `yaml:"repeat,omitempty" json:"repeat,omitempty"`
 }
 
 // TemplateConfig contains configuration for template steps
@@ -69,6 +131,18 @@ type TemplateConfig struct {
 	DiffOnly bool `yaml:"diff_only,omitempty" json:"diff_only,omitempty"`
 	// CreateDirs creates parent directories if they don't exist
 	CreateDirs bool `yaml:"create_dirs,omitempty" json:"create_dirs,omitempty"`
+	// FailOnChange fails the step when the file differs from the rendered
+	// template; with DiffOnly it checks a deployed file has not drifted
+	FailOnChange bool `yaml:"fail_on_change,omitempty" json:"fail_on_change,omitempty"`
+	// ContentHash is set by the control plane on control plane templates;
+	// cached content with this hash is deployed without downloading it
+	ContentHash string `yaml:"content_hash,omitempty" json:"content_hash,omitempty"`
+	// Raw is set by the control plane on templates with uploaded content,
+	// which is deployed verbatim. It is downloaded in ranges straight to
+	// the destination's directory, and only when the file differs from it.
+	Raw bool `yaml:"raw,omitempty" json:"raw,omitempty"`
+	// ContentSize is the size of uploaded content
+	ContentSize int64 `yaml:"content_size,omitempty" json:"content_size,omitempty"`
 }
 
 // StepType represents the type of step
@@ -81,6 +155,8 @@ const (
 	StepTypeHTTP     StepType = "http"
 	StepTypeValidate StepType = "validate"
 	StepTypeTemplate StepType = "template" // Salt Stack-like template deployment
+	StepTypePlugin   StepType = "plugin"   // External plugin executable
+	StepTypeCallback StepType = "callback" // Signed webhook, optionally awaiting confirmation
 )
 
 // ParseWorkflow parses a workflow from YAML
@@ -90,6 +166,15 @@ func ParseWorkflow(data []byte) (*Workflow, error) {
 		return nil, fmt.Errorf("failed to parse workflow: %w", err)
 	}
 
+	// Expand matrix steps before defaults so generated steps get them too
+	for _, steps := range []*[]Step{&workflow.Steps, &workflow.OnSuccess, &workflow.OnFailure, &workflow.OnCancel} {
+		expanded, err := expandMatrixSteps(*steps)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand matrix: %w", err)
+		}
+		*steps = expanded
+	}
+
 	// Set defaults
 	if workflow.Timeout == 0 {
 		workflow.Timeout = 30 * time.Minute
@@ -117,6 +202,16 @@ func (w *Workflow) Validate() error {
 		return fmt.Errorf("workflow must have at least one step")
 	}
 
+	if w.Mode != "" && w.Mode != WorkflowModeValidate {
+		return fmt.Errorf("unknown mode %q", w.Mode)
+	}
+
+	if w.Resources != nil {
+		if err := w.Resources.validate(); err != nil {
+			return err
+		}
+	}
+
 	seenIDs := make(map[string]bool)
 	for i, step := range w.Steps {
 		if step.ID == "" {
@@ -136,6 +231,12 @@ func (w *Workflow) Validate() error {
 		}
 	}
 
+	for _, checkpoint := range w.Checkpoints {
+		if w.firstStepOf(checkpoint) < 0 {
+			return fmt.Errorf("checkpoint %q is not a step", checkpoint)
+		}
+	}
+
 	return nil
 }
 
@@ -150,6 +251,9 @@ func (s *Step) Validate() error {
 		if s.Script == "" {
 			return fmt.Errorf("script required for script step")
 		}
+		if err := validateInterpreter(s.Interpreter); err != nil {
+			return err
+		}
 	case StepTypeTemplate:
 		if s.Template == nil {
 			return fmt.Errorf("template configuration required for template step")
@@ -157,6 +261,20 @@ func (s *Step) Validate() error {
 		if err := s.Template.Validate(); err != nil {
 			return fmt.Errorf("template config: %w", err)
 		}
+	case StepTypePlugin:
+		if s.Plugin == nil {
+			return fmt.Errorf("plugin configuration required for plugin step")
+		}
+		if err := s.Plugin.Validate(); err != nil {
+			return fmt.Errorf("plugin config: %w", err)
+		}
+	case StepTypeCallback:
+		if s.Callback == nil {
+			return fmt.Errorf("callback configuration required for callback step")
+		}
+		if err := s.Callback.Validate(); err != nil {
+			return fmt.Errorf("callback config: %w", err)
+		}
 	case StepTypeFile:
 		// File operations validated at execution time
 	case StepTypeHTTP:
@@ -175,6 +293,40 @@ func (s *Step) Validate() error {
 		return fmt.Errorf("retry_count must be non-negative")
 	}
 
+	if s.Precheck && s.Type != StepTypeCommand && s.Type != StepTypeScript {
+		return fmt.Errorf("precheck is only supported for command and script steps")
+	}
+
+	if s.OutputParser != nil {
+		if s.Type != StepTypeCommand && s.Type != StepTypeScript {
+			return fmt.Errorf("output_parser is only supported for command and script steps")
+		}
+		if err := s.OutputParser.Validate(); err != nil {
+			return fmt.Errorf("output_parser: %w", err)
+		}
+	}
+
+	if s.Repeat != nil {
+		if s.Type != StepTypeCommand && s.Type != StepTypeScript {
+			return fmt.Errorf("repeat is only supported for command and script steps")
+		}
+		if s.RetryCount > 0 {
+			return fmt.Errorf("repeat and retry_count are mutually exclusive")
+		}
+		if err := s.Repeat.Validate(); err != nil {
+			return fmt.Errorf("repeat: %w", err)
+		}
+	}
+
+	if s.Sandbox != nil {
+		if s.Type != StepTypeCommand && s.Type != StepTypeScript {
+			return fmt.Errorf("sandbox is only supported for command and script steps")
+		}
+		if err := s.Sandbox.Validate(); err != nil {
+			return fmt.Errorf("sandbox config: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -186,6 +338,9 @@ func (t *TemplateConfig) Validate() error {
 	if t.Dest == "" {
 		return fmt.Errorf("dest is required")
 	}
+	if t.Raw && (t.ContentHash == "" || t.ContentSize <= 0) {
+		return fmt.Errorf("raw templates require content_hash and content_size")
+	}
 	return nil
 }
 
@@ -199,8 +354,20 @@ type StepResult struct {
 	Error       string        `json:"error,omitempty"`
 	StartedAt   time.Time     `json:"started_at"`
 	EndedAt     time.Time     `json:"ended_at"`
-	Duration    time.Duration `json:"duration"`
+	Duration    time.Duration `json:"-"` // written as duration_ms
 	RetryCount  int           `json:"retry_count"`
+	// MatrixParent and Matrix identify the matrix step and values this step was generated from
+	MatrixParent string            `json:"matrix_parent,omitempty"`
+	Matrix       map[string]string `json:"matrix,omitempty"`
+	// Data is the structured result returned by a plugin step, or the data
+	// of a callback step's confirmation
+	Data map[string]interface{} `json:"data,omitempty"`
+	// ParsedOutput is the stdout parsed by the step's output parser
+	ParsedOutput map[string]interface{} `json:"parsed_output,omitempty"`
+	// Iterations are the iterations of a repeated step, in order
+	Iterations []IterationResult `json:"iterations,omitempty"`
+	// RequestID is the control plane request that started the workflow
+	RequestID string `json:"request_id,omitempty"`
 }
 
 // StepStatus represents the status of a step
@@ -213,16 +380,30 @@ const (
 	StepStatusFailed    StepStatus = "failed"
 	StepStatusSkipped   StepStatus = "skipped"
 	StepStatusCancelled StepStatus = "cancelled"
+	StepStatusPaused    StepStatus = "paused" // workflow stopped between steps until resumed
 )
 
 // WorkflowResult represents the result of a workflow execution
 type WorkflowResult struct {
-	WorkflowID  string        `json:"workflow_id"`
-	Name        string        `json:"name"`
-	Status      StepStatus    `json:"status"`
-	Steps       []StepResult  `json:"steps"`
-	StartedAt   time.Time     `json:"started_at"`
-	EndedAt     time.Time     `json:"ended_at"`
-	Duration    time.Duration `json:"duration"`
-	Error       string        `json:"error,omitempty"`
+	WorkflowID string        `json:"workflow_id"`
+	Name       string        `json:"name"`
+	Status     StepStatus    `json:"status"`
+	Priority   Priority      `json:"priority,omitempty"`
+	Steps      []StepResult  `json:"steps"`
+	StartedAt  time.Time     `json:"started_at"`
+	EndedAt    time.Time     `json:"ended_at"`
+	Duration   time.Duration `json:"-"` // written as duration_ms
+	Error      string        `json:"error,omitempty"`
+	// Environment is the host snapshot taken when the workflow started
+	Environment *EnvironmentFacts `json:"environment,omitempty"`
+	// Mode is validate when the workflow ran without changing the host
+	Mode string `json:"mode,omitempty"`
+	// RequestID is the control plane request that started the workflow,
+	// as sent in the X-Request-ID header of the dispatch
+	RequestID string `json:"request_id,omitempty"`
+	// PausedAt is when a paused workflow stopped, and PausedFor how long it
+	// spent paused before, written as paused_for_ms; paused time does not
+	// count towards the timeout
+	PausedAt  *time.Time    `json:"paused_at,omitempty"`
+	PausedFor time.Duration `json:"-"`
 }
@@ -0,0 +1,234 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Each job runs in its own directory, <work_dir>/jobs/<workflow id>, which
+// steps without a work_dir start in and whose tmp directory is their
+// TMPDIR. The directory is removed once the job's result is reported.
+const (
+	jobsDirName = "jobs"
+	jobTmpDir   = "tmp"
+)
+
+// jobDirEnv is the variable steps find their job directory in
+const jobDirEnv = "VM_AGENT_JOB_DIR"
+
+// diskQuotaInterval is how often a running job's directory is measured
+const diskQuotaInterval = 5 * time.Second
+
+// jobDirsUsageTTL is how long a measurement of all job directories is used
+// for admitting new jobs before it is taken again
+const jobDirsUsageTTL = 10 * time.Second
+
+// DiskQuotaError fails a job whose directory grew past its quota
+type DiskQuotaError struct {
+	Used  uint64
+	Quota uint64
+}
+
+func (e *DiskQuotaError) Error() string {
+	return fmt.Sprintf("job directory uses %s, over its %s disk quota", formatBytes(e.Used), formatBytes(e.Quota))
+}
+
+// DiskUsage is the agent's work directory disk usage, for health reports
+type DiskUsage struct {
+	Path  string
+	Free  uint64
+	Total uint64
+	// Err is set when free and total space could not be read
+	Err error
+	// JobDirs is the size of all job directories, and JobDirsQuota, JobQuota
+	// and MinFree the configured limits, 0 when not set
+	JobDirs      uint64
+	JobDirsQuota uint64
+	JobQuota     uint64
+	MinFree      uint64
+	Jobs         int
+}
+
+// jobDirsUsage caches the size of all job directories
+type jobDirsUsage struct {
+	mu         sync.Mutex
+	bytes      uint64
+	measuredAt time.Time
+}
+
+// jobsDir returns the directory job directories are created in
+func (e *Executor) jobsDir() string {
+	return filepath.Join(e.workDir, jobsDirName)
+}
+
+// jobDir returns a job's directory
+func (e *Executor) jobDir(job *Job) string {
+	return filepath.Join(e.jobsDir(), job.ID)
+}
+
+// prepareJobDir creates a job's directory, which a job restored after a
+// restart may have kept
+func (e *Executor) prepareJobDir(job *Job) error {
+	if err := os.MkdirAll(filepath.Join(e.jobDir(job), jobTmpDir), 0700); err != nil {
+		return fmt.Errorf("failed to create job directory: %w", err)
+	}
+	return nil
+}
+
+// removeJobDir removes a finished job's directory
+func (e *Executor) removeJobDir(workflowID string) {
+	dir := filepath.Join(e.jobsDir(), workflowID)
+	if err := os.RemoveAll(dir); err != nil {
+		e.logger.Warn("failed to remove job directory",
+			zap.String("workflow_id", workflowID),
+			zap.String("path", dir),
+			zap.Error(err))
+	}
+}
+
+// resultReported removes the directory of a job once its final result has
+// been reported
+func (e *Executor) resultReported(result *WorkflowResult) {
+	if terminal(result.Status) {
+		e.removeJobDir(result.WorkflowID)
+	}
+}
+
+// sweepJobDirs removes the job directories left behind when the agent
+// stopped, but those of the paused jobs it restores
+func (e *Executor) sweepJobDirs(paused []*JobRecord) {
+	entries, err := os.ReadDir(e.jobsDir())
+	if err != nil {
+		return
+	}
+	keep := make(map[string]bool, len(paused))
+	for _, record := range paused {
+		if record.Workflow != nil {
+			keep[record.Result.WorkflowID] = true
+		}
+	}
+	for _, entry := range entries {
+		if !keep[entry.Name()] {
+			e.removeJobDir(entry.Name())
+		}
+	}
+}
+
+// jobEnv returns the variables pointing a step at its job directory
+func (e *Executor) jobEnv(job *Job) []string {
+	tmp := filepath.Join(e.jobDir(job), jobTmpDir)
+	env := []string{jobDirEnv + "=" + e.jobDir(job)}
+	if runtime.GOOS == "windows" {
+		return append(env, "TEMP="+tmp, "TMP="+tmp)
+	}
+	return append(env, "TMPDIR="+tmp)
+}
+
+// jobQuota returns the disk quota of a job's directory: the configured one,
+// raised to the disk space the workflow declares it needs
+func (e *Executor) jobQuota(job *Job) uint64 {
+	if e.jobDiskQuota == 0 {
+		return 0
+	}
+	if declared := reservationOf(job.Workflow.Resources).disk; declared > e.jobDiskQuota {
+		return declared
+	}
+	return e.jobDiskQuota
+}
+
+// watchJobDir returns a context that is cancelled with a DiskQuotaError if
+// the job's directory grows past its quota, and a function to stop watching
+func (e *Executor) watchJobDir(ctx context.Context, job *Job) (context.Context, func()) {
+	quotaCtx, cancel := context.WithCancelCause(ctx)
+	quota := e.jobQuota(job)
+	if quota == 0 {
+		return quotaCtx, func() { cancel(nil) }
+	}
+
+	dir := e.jobDir(job)
+	go func() {
+		ticker := time.NewTicker(diskQuotaInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quotaCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			if used := dirSize(dir); used > quota {
+				e.jobLogger(job).Warn("job directory over its disk quota, stopping workflow",
+					zap.String("workflow_id", job.ID),
+					zap.Uint64("used_bytes", used),
+					zap.Uint64("quota_bytes", quota))
+				cancel(&DiskQuotaError{Used: used, Quota: quota})
+				return
+			}
+		}
+	}()
+	return quotaCtx, func() { cancel(nil) }
+}
+
+// diskQuotaExceeded returns the DiskQuotaError a job's quota context was
+// cancelled with, or nil
+func diskQuotaExceeded(quotaCtx context.Context) error {
+	var quotaErr *DiskQuotaError
+	if errors.As(context.Cause(quotaCtx), &quotaErr) {
+		return quotaErr
+	}
+	return nil
+}
+
+// measureJobDirs measures all job directories unless the last measurement
+// is recent enough, and returns their size
+func (e *Executor) measureJobDirs() uint64 {
+	e.jobDirsUsage.mu.Lock()
+	defer e.jobDirsUsage.mu.Unlock()
+	if time.Since(e.jobDirsUsage.measuredAt) > jobDirsUsageTTL {
+		e.jobDirsUsage.bytes = dirSize(e.jobsDir())
+		e.jobDirsUsage.measuredAt = time.Now()
+	}
+	return e.jobDirsUsage.bytes
+}
+
+// DiskUsage returns the disk usage of the work directory and job
+// directories against the configured limits
+func (e *Executor) DiskUsage() DiskUsage {
+	usage := DiskUsage{
+		Path:         e.workDir,
+		JobDirs:      e.measureJobDirs(),
+		JobDirsQuota: e.workDirQuota,
+		JobQuota:     e.jobDiskQuota,
+		MinFree:      e.minFreeDisk,
+	}
+	usage.Free, usage.Total, usage.Err = diskSpace(e.workDir)
+
+	e.mu.RLock()
+	usage.Jobs = len(e.jobs)
+	e.mu.RUnlock()
+	return usage
+}
+
+// dirSize returns the size of the regular files under a directory. Files
+// removed while it is walked are skipped.
+func dirSize(dir string) uint64 {
+	var size uint64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size
+}
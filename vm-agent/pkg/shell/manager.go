@@ -0,0 +1,435 @@
+// Package shell runs the live shell sessions the control plane opens on the
+// agent. Each session runs the configured command on a pseudo-terminal; the
+// control plane relays the operator's input and polls the output through
+// Piko.
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// maxBuffered is the output buffered per session; the shell is paused
+	// until the control plane reads it
+	maxBuffered = 1 << 20
+	// maxReadSize bounds the output returned by one read
+	maxReadSize = 256 << 10
+	// MaxReadWait bounds how long a read waits for output
+	MaxReadWait = 10 * time.Second
+	// orphanTimeout closes sessions the control plane stopped reading,
+	// e.g. after it restarted
+	orphanTimeout = time.Minute
+)
+
+// Session end reasons
+const (
+	ReasonExited   = "exited"
+	ReasonClosed   = "closed"
+	ReasonDuration = "max_duration"
+	ReasonIdle     = "idle_timeout"
+	ReasonOrphaned = "orphaned"
+	ReasonStopped  = "agent_stopped"
+)
+
+var (
+	// ErrDisabled is returned when shell sessions are not enabled
+	ErrDisabled = errors.New("shell sessions are disabled on this agent")
+	// ErrNotFound is returned for unknown sessions
+	ErrNotFound = errors.New("shell session not found")
+	// ErrExists is returned when opening a session that is already open
+	ErrExists = errors.New("shell session already exists")
+	// ErrLimit is returned when the agent runs its maximum of sessions
+	ErrLimit = errors.New("too many shell sessions")
+)
+
+// Config contains shell session settings
+type Config struct {
+	Enabled     bool
+	Command     string
+	User        string
+	WorkDir     string
+	MaxSessions int
+	MaxDuration time.Duration
+	IdleTimeout time.Duration
+}
+
+// Output is the output read from a session. Once Exited is set the output
+// is complete and the session is gone.
+type Output struct {
+	Data     []byte `json:"data,omitempty"`
+	Exited   bool   `json:"exited"`
+	ExitCode int    `json:"exit_code,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Manager runs the agent's shell sessions
+type Manager struct {
+	config *Config
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// session is a shell running on a pseudo-terminal
+type session struct {
+	id        string
+	cmd       *exec.Cmd
+	pty       *os.File
+	startedAt time.Time
+
+	mu sync.Mutex
+	// cond signals output, room in the buffer and the shell's exit
+	cond   *sync.Cond
+	output []byte
+	// sent is the output returned by the last read, kept until a read
+	// acknowledges it; sentOffset is its offset in the session's output
+	sent       []byte
+	sentOffset uint64
+	lastInput  time.Time
+	lastRead   time.Time
+	inputSeq   uint64
+	exited     bool
+	exitCode   int
+	reason     string
+	terminated bool
+}
+
+// NewManager creates a shell session manager
+func NewManager(config *Config, logger *zap.Logger) *Manager {
+	return &Manager{
+		config:   config,
+		logger:   logger,
+		sessions: make(map[string]*session),
+	}
+}
+
+// OpenShell starts a session with the given ID and terminal size
+func (m *Manager) OpenShell(sessionID string, rows, cols uint16) error {
+	if !m.config.Enabled {
+		return ErrDisabled
+	}
+	if sessionID == "" {
+		return fmt.Errorf("session ID is required")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessions[sessionID]; ok {
+		return ErrExists
+	}
+	if len(m.sessions) >= m.config.MaxSessions {
+		return ErrLimit
+	}
+
+	cmd := exec.Command(m.config.Command)
+	cmd.Dir = m.config.WorkDir
+	cmd.Env = []string{
+		"TERM=xterm-256color",
+		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"VM_AGENT_SHELL_SESSION=" + sessionID,
+	}
+	if m.config.User != "" {
+		if err := runAs(cmd, m.config.User); err != nil {
+			return err
+		}
+	}
+
+	pty, err := startPTY(cmd, orDefault(rows, 24), orDefault(cols, 80))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	s := &session{
+		id:        sessionID,
+		cmd:       cmd,
+		pty:       pty,
+		startedAt: now,
+		lastInput: now,
+		lastRead:  now,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	m.sessions[sessionID] = s
+
+	go s.copyOutput()
+	go s.wait()
+	go m.supervise(s)
+
+	m.logger.Info("shell session opened",
+		zap.String("session_id", sessionID),
+		zap.String("command", m.config.Command),
+		zap.String("user", m.config.User))
+	return nil
+}
+
+// WriteShell writes input to a session. Input carries a sequence number so
+// that input the control plane resends after a failed request is written
+// once.
+func (m *Manager) WriteShell(sessionID string, seq uint64, data []byte) error {
+	s, err := m.get(sessionID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.exited || s.terminated {
+		return ErrNotFound
+	}
+	if seq != 0 && seq <= s.inputSeq {
+		return nil
+	}
+	if _, err := s.pty.Write(data); err != nil {
+		return fmt.Errorf("failed to write to shell: %w", err)
+	}
+	if seq != 0 {
+		s.inputSeq = seq
+	}
+	s.lastInput = time.Now()
+	return nil
+}
+
+// ReadShell returns the session's buffered output, waiting up to wait for
+// some to arrive. offset is the amount of output the caller received so
+// far: a read repeating the offset of the previous one gets the same output
+// again, so a response lost in transit is not lost from the session. The
+// read that reports the shell's exit removes the session.
+func (m *Manager) ReadShell(sessionID string, offset uint64, wait time.Duration) (*Output, error) {
+	s, err := m.get(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if wait > MaxReadWait {
+		wait = MaxReadWait
+	}
+
+	s.mu.Lock()
+	s.lastRead = time.Now()
+	if len(s.sent) > 0 {
+		if offset == s.sentOffset {
+			out := &Output{Data: s.sent}
+			s.mu.Unlock()
+			return out, nil
+		}
+		s.sentOffset += uint64(len(s.sent))
+		s.sent = nil
+	}
+	if len(s.output) == 0 && !s.exited && wait > 0 {
+		timer := time.AfterFunc(wait, func() {
+			s.mu.Lock()
+			s.cond.Broadcast()
+			s.mu.Unlock()
+		})
+		deadline := time.Now().Add(wait)
+		for len(s.output) == 0 && !s.exited && time.Now().Before(deadline) {
+			s.cond.Wait()
+		}
+		timer.Stop()
+	}
+
+	out := &Output{}
+	n := len(s.output)
+	if n > maxReadSize {
+		n = maxReadSize
+	}
+	if n > 0 {
+		out.Data = append([]byte(nil), s.output[:n]...)
+		s.output = s.output[n:]
+		s.sent = out.Data
+		s.cond.Broadcast()
+	}
+	if s.exited && len(s.output) == 0 {
+		out.Exited = true
+		out.ExitCode = s.exitCode
+		out.Reason = s.reason
+	}
+	s.lastRead = time.Now()
+	s.mu.Unlock()
+
+	if out.Exited {
+		m.remove(s)
+	}
+	return out, nil
+}
+
+// ResizeShell sets a session's terminal size
+func (m *Manager) ResizeShell(sessionID string, rows, cols uint16) error {
+	s, err := m.get(sessionID)
+	if err != nil {
+		return err
+	}
+	if rows == 0 || cols == 0 {
+		return fmt.Errorf("rows and cols must be positive")
+	}
+	return setSize(s.pty, rows, cols)
+}
+
+// CloseShell ends a session, killing the shell and the processes it
+// started
+func (m *Manager) CloseShell(sessionID string) error {
+	s, err := m.get(sessionID)
+	if err != nil {
+		return err
+	}
+	s.terminate(ReasonClosed)
+	m.remove(s)
+	return nil
+}
+
+// Stop ends every session
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	sessions := make([]*session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	m.mu.Unlock()
+
+	for _, s := range sessions {
+		s.terminate(ReasonStopped)
+		m.remove(s)
+	}
+}
+
+// get returns an open session
+func (m *Manager) get(sessionID string) (*session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return s, nil
+}
+
+// isOpen reports whether a session is still registered
+func (m *Manager) isOpen(s *session) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sessions[s.id] == s
+}
+
+// remove forgets a session once it ended
+func (m *Manager) remove(s *session) {
+	m.mu.Lock()
+	if m.sessions[s.id] != s {
+		m.mu.Unlock()
+		return
+	}
+	delete(m.sessions, s.id)
+	m.mu.Unlock()
+
+	s.mu.Lock()
+	reason, code := s.reason, s.exitCode
+	s.mu.Unlock()
+	m.logger.Info("shell session ended",
+		zap.String("session_id", s.id),
+		zap.String("reason", reason),
+		zap.Int("exit_code", code),
+		zap.Duration("duration", time.Since(s.startedAt)))
+}
+
+// supervise enforces a session's time limits and closes it when the
+// control plane stops reading it. Exited sessions whose final output is
+// never read are removed the same way.
+func (m *Manager) supervise(s *session) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	deadline := s.startedAt.Add(m.config.MaxDuration)
+
+	for range ticker.C {
+		if !m.isOpen(s) {
+			return
+		}
+		s.mu.Lock()
+		exited, lastInput, lastRead := s.exited, s.lastInput, s.lastRead
+		s.mu.Unlock()
+
+		now := time.Now()
+		switch {
+		case now.Sub(lastRead) > orphanTimeout:
+			s.terminate(ReasonOrphaned)
+			m.remove(s)
+			return
+		case exited:
+		case m.config.MaxDuration > 0 && now.After(deadline):
+			s.terminate(ReasonDuration)
+		case m.config.IdleTimeout > 0 && now.Sub(lastInput) > m.config.IdleTimeout:
+			s.terminate(ReasonIdle)
+		}
+	}
+}
+
+// copyOutput buffers the shell's output, pausing while the buffer is full
+func (s *session) copyOutput() {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := s.pty.Read(buf)
+		if n > 0 {
+			s.mu.Lock()
+			for len(s.output) >= maxBuffered && !s.terminated {
+				s.cond.Wait()
+			}
+			if !s.terminated {
+				s.output = append(s.output, buf[:n]...)
+				s.cond.Broadcast()
+			}
+			s.mu.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// wait records the shell's exit
+func (s *session) wait() {
+	err := s.cmd.Wait()
+	// Output written just before the exit may still be in flight
+	time.Sleep(100 * time.Millisecond)
+	s.pty.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exited = true
+	if s.reason == "" {
+		s.reason = ReasonExited
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		s.exitCode = exitErr.ExitCode()
+	}
+	s.cond.Broadcast()
+}
+
+// terminate kills the shell, recording why it ended
+func (s *session) terminate(reason string) {
+	s.mu.Lock()
+	if s.terminated || s.exited {
+		s.mu.Unlock()
+		return
+	}
+	s.terminated = true
+	s.reason = reason
+	s.output = append(s.output, []byte(fmt.Sprintf("\r\n[session ended: %s]\r\n", reason))...)
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	killSession(s.cmd)
+}
+
+// orDefault returns v, or def when v is zero
+func orDefault(v, def uint16) uint16 {
+	if v == 0 {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,36 @@
+//go:build !linux
+
+package shell
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// errUnsupportedOS is returned when opening shells on platforms without
+// pseudo-terminal support
+var errUnsupportedOS = fmt.Errorf("shell sessions are not supported on %s", runtime.GOOS)
+
+// startPTY starts cmd on a new pseudo-terminal
+func startPTY(cmd *exec.Cmd, rows, cols uint16) (*os.File, error) {
+	return nil, errUnsupportedOS
+}
+
+// setSize sets a pseudo-terminal's window size
+func setSize(master *os.File, rows, cols uint16) error {
+	return errUnsupportedOS
+}
+
+// runAs makes cmd run as a local user
+func runAs(cmd *exec.Cmd, username string) error {
+	return errUnsupportedOS
+}
+
+// killSession kills a shell
+func killSession(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
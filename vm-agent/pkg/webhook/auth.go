@@ -18,19 +18,23 @@ type Authenticator struct {
 	jwtSecret     []byte
 	hmacSecret    []byte
 	allowedTokens map[string]bool
+	leeway        time.Duration
 }
 
-// AuthConfig contains authentication configuration
+// AuthConfig contains authentication configuration. Leeway is the clock
+// drift tolerated when checking JWT expiry and not-before.
 type AuthConfig struct {
 	JWTSecret     string
 	HMACSecret    string
 	AllowedTokens []string
+	Leeway        time.Duration
 }
 
 // NewAuthenticator creates a new authenticator
 func NewAuthenticator(cfg *AuthConfig) *Authenticator {
 	auth := &Authenticator{
 		allowedTokens: make(map[string]bool),
+		leeway:        cfg.Leeway,
 	}
 
 	if cfg.JWTSecret != "" {
@@ -93,27 +97,15 @@ func (a *Authenticator) authenticateJWT(r *http.Request) bool {
 
 	tokenString := parts[1]
 
+	// Parsing checks expiry and not-before, allowing for the leeway
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, jwt.ErrSignatureInvalid
 		}
 		return a.jwtSecret, nil
-	})
-
-	if err != nil || !token.Valid {
-		return false
-	}
+	}, jwt.WithLeeway(a.leeway))
 
-	// Check expiration
-	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		if exp, ok := claims["exp"].(float64); ok {
-			if time.Unix(int64(exp), 0).Before(time.Now()) {
-				return false
-			}
-		}
-	}
-
-	return true
+	return err == nil && token.Valid
 }
 
 // authenticateHMAC verifies HMAC signatures
@@ -208,7 +200,7 @@ func (a *Authenticator) ParseTokenClaims(r *http.Request) (*TokenClaims, error)
 
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		return a.jwtSecret, nil
-	})
+	}, jwt.WithLeeway(a.leeway))
 
 	if err != nil {
 		return nil, err
@@ -0,0 +1,167 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DispatchTokenHeader carries the control plane's dispatch token on
+// requests proxied through Piko
+const DispatchTokenHeader = "X-Dispatch-Token"
+
+// DefaultDispatchTokenLeeway allows for clock skew between agent and
+// control plane
+const DefaultDispatchTokenLeeway = 5 * time.Minute
+
+// Limits on dispatched requests
+const (
+	maxDispatchBodySize = 16 << 20
+	// maxDispatchReplayEntries bounds the token IDs remembered until they
+	// expire; past it dispatches are refused rather than let replays in
+	maxDispatchReplayEntries = 10000
+)
+
+// errForeignDispatch is returned for a valid token issued for another
+// tenant or agent
+var errForeignDispatch = errors.New("dispatch token was issued for another tenant or agent")
+
+// dispatchClaims are the claims of a control plane dispatch token
+type dispatchClaims struct {
+	jwt.RegisteredClaims
+	TenantID   string `json:"tenant_id"`
+	AgentID    string `json:"agent_id"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	BodySHA256 string `json:"body_sha256"`
+}
+
+// DispatchVerifier checks requests arriving through Piko carry a dispatch
+// token for this agent: signed with its dispatch key, issued for its tenant,
+// agent ID and endpoint, and bound to the request's method, path and body.
+// Each token is accepted once.
+type DispatchVerifier struct {
+	key      []byte
+	tenantID string
+	agentID  string
+	endpoint string
+	leeway   time.Duration
+
+	mu sync.Mutex
+	// seen holds the IDs of accepted tokens until they expire
+	seen map[string]time.Time
+}
+
+// NewDispatchVerifier creates a verifier from the base64 dispatch key issued
+// at registration
+func NewDispatchVerifier(dispatchKey, tenantID, agentID, endpoint string) (*DispatchVerifier, error) {
+	key, err := base64.StdEncoding.DecodeString(dispatchKey)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("invalid dispatch key")
+	}
+	return &DispatchVerifier{
+		key:      key,
+		tenantID: tenantID,
+		agentID:  agentID,
+		endpoint: endpoint,
+		leeway:   DefaultDispatchTokenLeeway,
+		seen:     make(map[string]time.Time),
+	}, nil
+}
+
+// SetLeeway sets the clock skew tolerated on token expiry and not-before
+// (default 5m)
+func (v *DispatchVerifier) SetLeeway(leeway time.Duration) {
+	if leeway > 0 {
+		v.leeway = leeway
+	}
+}
+
+// Verify checks the request's dispatch token
+func (v *DispatchVerifier) Verify(r *http.Request) error {
+	tokenString := r.Header.Get(DispatchTokenHeader)
+	if tokenString == "" {
+		return fmt.Errorf("missing dispatch token")
+	}
+
+	var claims dispatchClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		return v.key, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(v.leeway))
+	if err != nil {
+		return fmt.Errorf("invalid dispatch token: %w", err)
+	}
+
+	if claims.TenantID != v.tenantID || claims.AgentID != v.agentID {
+		return errForeignDispatch
+	}
+	if !audienceContains(claims.Audience, v.endpoint) {
+		return fmt.Errorf("dispatch token is not bound to this agent's endpoint")
+	}
+	if claims.Method != r.Method || claims.Path != r.URL.RequestURI() {
+		return fmt.Errorf("dispatch token was issued for %s %s", claims.Method, claims.Path)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxDispatchBodySize+1))
+	if err != nil {
+		return fmt.Errorf("failed to read request body: %w", err)
+	}
+	if len(body) > maxDispatchBodySize {
+		return fmt.Errorf("request body exceeds %d bytes", maxDispatchBodySize)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	bodyHash := sha256.Sum256(body)
+	if claims.BodySHA256 == "" || claims.BodySHA256 != hex.EncodeToString(bodyHash[:]) {
+		return fmt.Errorf("dispatch token was issued for another request body")
+	}
+
+	if claims.ID == "" {
+		return fmt.Errorf("dispatch token has no ID")
+	}
+	return v.remember(claims.ID, claims.ExpiresAt.Time.Add(v.leeway), time.Now())
+}
+
+// remember records a token ID until it expires, failing if it was already
+// used
+func (v *DispatchVerifier) remember(id string, expires, now time.Time) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if until, ok := v.seen[id]; ok && now.Before(until) {
+		return fmt.Errorf("dispatch token was already used")
+	}
+	if len(v.seen) >= maxDispatchReplayEntries {
+		for seenID, until := range v.seen {
+			if !now.Before(until) {
+				delete(v.seen, seenID)
+			}
+		}
+		if len(v.seen) >= maxDispatchReplayEntries {
+			return fmt.Errorf("too many dispatch tokens in flight")
+		}
+	}
+	v.seen[id] = expires
+	return nil
+}
+
+// audienceContains reports whether the token audience includes endpoint
+func audienceContains(audience jwt.ClaimStrings, endpoint string) bool {
+	for _, aud := range audience {
+		if aud == endpoint {
+			return true
+		}
+	}
+	return false
+}
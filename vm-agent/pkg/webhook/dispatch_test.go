@@ -0,0 +1,172 @@
+package webhook
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testEndpoint = "https://piko.example/agent-1"
+	testBody     = `{"workflow_id":"wf-1"}`
+)
+
+var testDispatchKey = make([]byte, 32)
+
+// signDispatch signs a dispatch token the way the control plane does
+func signDispatch(t *testing.T, id, agentID, path, body string) string {
+	t.Helper()
+	bodyHash := sha256.Sum256([]byte(body))
+	claims := dispatchClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			Audience:  jwt.ClaimStrings{testEndpoint},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+		TenantID:   "tenant-1",
+		AgentID:    agentID,
+		Method:     "POST",
+		Path:       path,
+		BodySHA256: hex.EncodeToString(bodyHash[:]),
+	}
+	if body == "" {
+		claims.BodySHA256 = ""
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(testDispatchKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestDispatchVerifierVerify(t *testing.T) {
+	tests := []struct {
+		name        string
+		token       func(t *testing.T) string
+		body        string
+		wantErr     bool
+		wantForeign bool
+	}{
+		{
+			name:  "valid",
+			token: func(t *testing.T) string { return signDispatch(t, "jti-1", "agent-1", "/workflows/execute", testBody) },
+			body:  testBody,
+		},
+		{
+			name:    "body changed in transit",
+			token:   func(t *testing.T) string { return signDispatch(t, "jti-2", "agent-1", "/workflows/execute", testBody) },
+			body:    `{"workflow_id":"wf-2"}`,
+			wantErr: true,
+		},
+		{
+			name:    "no body hash",
+			token:   func(t *testing.T) string { return signDispatch(t, "jti-3", "agent-1", "/workflows/execute", "") },
+			body:    testBody,
+			wantErr: true,
+		},
+		{
+			name:    "no token ID",
+			token:   func(t *testing.T) string { return signDispatch(t, "", "agent-1", "/workflows/execute", testBody) },
+			body:    testBody,
+			wantErr: true,
+		},
+		{
+			name:    "another path",
+			token:   func(t *testing.T) string { return signDispatch(t, "jti-4", "agent-1", "/upgrade", testBody) },
+			body:    testBody,
+			wantErr: true,
+		},
+		{
+			name:        "another agent",
+			token:       func(t *testing.T) string { return signDispatch(t, "jti-5", "agent-2", "/workflows/execute", testBody) },
+			body:        testBody,
+			wantErr:     true,
+			wantForeign: true,
+		},
+		{
+			name:    "missing token",
+			token:   func(t *testing.T) string { return "" },
+			body:    testBody,
+			wantErr: true,
+		},
+	}
+
+	v, err := NewDispatchVerifier(base64.StdEncoding.EncodeToString(testDispatchKey), "tenant-1", "agent-1", testEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/workflows/execute", strings.NewReader(tt.body))
+			r.Header.Set(DispatchTokenHeader, tt.token(t))
+			err := v.Verify(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, errForeignDispatch) != tt.wantForeign {
+				t.Errorf("Verify error = %v, want foreign %v", err, tt.wantForeign)
+			}
+			if err != nil {
+				return
+			}
+			// The handler still reads the body the token was checked against
+			body, _ := io.ReadAll(r.Body)
+			if string(body) != tt.body {
+				t.Errorf("body after Verify = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestDispatchVerifierReplay(t *testing.T) {
+	v, err := NewDispatchVerifier(base64.StdEncoding.EncodeToString(testDispatchKey), "tenant-1", "agent-1", testEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := signDispatch(t, "jti-1", "agent-1", "/workflows/execute", testBody)
+	request := func() error {
+		r := httptest.NewRequest("POST", "/workflows/execute", strings.NewReader(testBody))
+		r.Header.Set(DispatchTokenHeader, token)
+		return v.Verify(r)
+	}
+
+	if err := request(); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := request(); err == nil {
+		t.Fatal("replayed token was accepted")
+	}
+}
+
+func TestDispatchVerifierRememberBound(t *testing.T) {
+	v := &DispatchVerifier{seen: make(map[string]time.Time)}
+	now := time.Now()
+	for i := 0; i < maxDispatchReplayEntries; i++ {
+		v.seen[strconv.Itoa(i)+"-expired"] = now.Add(-time.Second)
+	}
+
+	// Expired IDs make room once the cache is full
+	if err := v.remember("fresh", now.Add(time.Minute), now); err != nil {
+		t.Fatalf("remember with expired entries: %v", err)
+	}
+	if len(v.seen) != 1 {
+		t.Errorf("cache holds %d IDs after pruning, want 1", len(v.seen))
+	}
+
+	// A cache full of live IDs refuses rather than forgets
+	for i := 0; i < maxDispatchReplayEntries; i++ {
+		v.seen[strconv.Itoa(i)+"-live"] = now.Add(time.Minute)
+	}
+	if err := v.remember("another", now.Add(time.Minute), now); err == nil {
+		t.Error("remember accepted an ID past the bound")
+	}
+}
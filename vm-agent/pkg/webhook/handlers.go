@@ -3,6 +3,7 @@ package webhook
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"strings"
@@ -10,13 +11,19 @@ import (
 	"time"
 
 	"go.uber.org/zap"
+
+	"github.com/yourorg/vm-agent/pkg/probe"
+	"github.com/yourorg/vm-agent/pkg/shell"
 )
 
 // WorkflowExecutor executes workflows
 type WorkflowExecutor interface {
 	Execute(workflow []byte) (string, error)
+	ExecuteWithOptions(workflow []byte, opts probe.ExecuteOptions) (string, error)
 	GetStatus(workflowID string) (*WorkflowStatus, error)
 	Cancel(workflowID string) error
+	Pause(workflowID string) error
+	Resume(workflowID string) error
 }
 
 // WorkflowStatus represents workflow execution status
@@ -55,6 +62,35 @@ type UpgradeStatus struct {
 	StartedAt  time.Time `json:"started_at,omitempty"`
 	Status     string    `json:"status,omitempty"`
 	Error      string    `json:"error,omitempty"`
+
+	BytesDownloaded int64   `json:"bytes_downloaded,omitempty"`
+	TotalBytes      int64   `json:"total_bytes,omitempty"`
+	Percent         float64 `json:"percent,omitempty"`
+
+	VerifyDeadline time.Time      `json:"verify_deadline,omitempty"`
+	LastOutcome    map[string]any `json:"last_outcome,omitempty"`
+}
+
+// Drainer controls agent drain mode
+type Drainer interface {
+	SetDraining(draining bool)
+	DrainState() string
+	DrainStatus() any
+}
+
+// CallbackConfirmer delivers confirmations to callback steps waiting for
+// them. The signature is the confirmation's X-Signature-256 header.
+type CallbackConfirmer interface {
+	ConfirmCallback(callbackID string, body []byte, signature string) error
+}
+
+// ShellHost runs the live shell sessions the control plane opens
+type ShellHost interface {
+	OpenShell(sessionID string, rows, cols uint16) error
+	WriteShell(sessionID string, seq uint64, data []byte) error
+	ReadShell(sessionID string, offset uint64, wait time.Duration) (*shell.Output, error)
+	ResizeShell(sessionID string, rows, cols uint16) error
+	CloseShell(sessionID string) error
 }
 
 // Handlers contains all webhook handlers
@@ -65,6 +101,9 @@ type Handlers struct {
 	healthChecker   HealthChecker
 	configProvider  ConfigProvider
 	upgradeHandler  UpgradeHandler
+	drainer         Drainer
+	callbacks       CallbackConfirmer
+	shells          ShellHost
 	hooks           map[string]HookHandler
 }
 
@@ -96,6 +135,27 @@ func (h *Handlers) RegisterHook(name string, handler HookHandler) {
 	h.hooks[name] = handler
 }
 
+// SetDrainer sets the drain mode controller
+func (h *Handlers) SetDrainer(drainer Drainer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.drainer = drainer
+}
+
+// SetCallbackConfirmer sets the receiver of callback step confirmations
+func (h *Handlers) SetCallbackConfirmer(callbacks CallbackConfirmer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.callbacks = callbacks
+}
+
+// SetShellHost sets the runner of live shell sessions
+func (h *Handlers) SetShellHost(shells ShellHost) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.shells = shells
+}
+
 // HealthzHandler handles liveness probe
 func (h *Handlers) HealthzHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -177,6 +237,14 @@ func (h *Handlers) ExecuteWorkflowHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	h.mu.RLock()
+	drainer := h.drainer
+	h.mu.RUnlock()
+	if drainer != nil && drainer.DrainState() != "" {
+		http.Error(w, "Agent is draining", http.StatusServiceUnavailable)
+		return
+	}
+
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, "Failed to read request body", http.StatusBadRequest)
@@ -184,9 +252,39 @@ func (h *Handlers) ExecuteWorkflowHandler(w http.ResponseWriter, r *http.Request
 	}
 	defer r.Body.Close()
 
-	workflowID, err := h.workflowExec.Execute(body)
+	// Priority is carried from the dispatch request, falling back to the workflow definition
+	priority := r.Header.Get("X-Workflow-Priority")
+	if priority == "" {
+		priority = r.URL.Query().Get("priority")
+	}
+
+	// The request ID ties the workflow's logs and results to the control
+	// plane request it was dispatched for
+	requestID := r.Header.Get("X-Request-ID")
+
+	workflowID, err := h.workflowExec.ExecuteWithOptions(body, probe.ExecuteOptions{
+		Priority:  priority,
+		RequestID: requestID,
+	})
+	var insufficient *probe.InsufficientResourcesError
+	if errors.As(err, &insufficient) {
+		// Not a failure of the workflow: the control plane retries it
+		// once other workflows have released their resources
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInsufficientStorage)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error":     insufficient.Error(),
+			"code":      "insufficient_resources",
+			"resource":  insufficient.Resource,
+			"required":  insufficient.Required,
+			"available": insufficient.Available,
+		})
+		return
+	}
 	if err != nil {
-		h.logger.Error("workflow execution failed", zap.Error(err))
+		h.logger.Error("workflow execution failed",
+			zap.String("request_id", requestID),
+			zap.Error(err))
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -251,6 +349,185 @@ func (h *Handlers) CancelWorkflowHandler(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// PauseWorkflowHandler asks a workflow to pause before its next pause point
+func (h *Handlers) PauseWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	h.pauseOrResume(w, r, "pause_requested", func(id string) error { return h.workflowExec.Pause(id) })
+}
+
+// ResumeWorkflowHandler resumes a paused workflow
+func (h *Handlers) ResumeWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	h.pauseOrResume(w, r, "resumed", func(id string) error { return h.workflowExec.Resume(id) })
+}
+
+// pauseOrResume applies a pause or resume request to the workflow named by
+// the id query parameter. A workflow in the wrong state is a conflict.
+func (h *Handlers) pauseOrResume(w http.ResponseWriter, r *http.Request, status string, apply func(string) error) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.workflowExec == nil {
+		http.Error(w, "Workflow executor not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	workflowID := r.URL.Query().Get("id")
+	if workflowID == "" {
+		http.Error(w, "Missing workflow ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := apply(workflowID); err != nil {
+		switch {
+		case errors.Is(err, probe.ErrNotRunning), errors.Is(err, probe.ErrNotPaused):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"workflow_id": workflowID,
+		"status":      status,
+	})
+}
+
+// CallbackHandler delivers a confirmation, relayed by the control plane, to
+// the callback step waiting at /workflow/callback/{callback_id}
+func (h *Handlers) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.mu.RLock()
+	callbacks := h.callbacks
+	h.mu.RUnlock()
+	if callbacks == nil {
+		http.Error(w, "Callbacks not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	callbackID := strings.TrimPrefix(r.URL.Path, "/workflow/callback/")
+	if callbackID == "" || strings.Contains(callbackID, "/") {
+		http.Error(w, "Missing callback ID", http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20+1))
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	// Unknown callbacks, bad signatures and invalid bodies are all refused
+	// with 403; the control plane passes the message on
+	if err := callbacks.ConfirmCallback(callbackID, body, r.Header.Get("X-Signature-256")); err != nil {
+		h.logger.Warn("callback confirmation refused",
+			zap.String("callback_id", callbackID),
+			zap.Error(err))
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"callback_id": callbackID,
+		"status":      "confirmed",
+	})
+}
+
+// shellRequest is the body of shell session requests
+type shellRequest struct {
+	Rows uint16 `json:"rows"`
+	Cols uint16 `json:"cols"`
+	// Seq numbers input so that resent input is written once
+	Seq  uint64 `json:"seq"`
+	Data []byte `json:"data"`
+	// Offset is the amount of output received so far
+	Offset uint64 `json:"offset"`
+	// WaitMS is how long a read waits for output
+	WaitMS int64 `json:"wait_ms"`
+}
+
+// ShellHandler handles the live shell session requests the control plane
+// relays: POST /shell/{session_id}/{open|input|read|resize|close}
+func (h *Handlers) ShellHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.mu.RLock()
+	shells := h.shells
+	h.mu.RUnlock()
+	if shells == nil {
+		http.Error(w, "Shell sessions not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/shell/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	sessionID, action := parts[0], parts[1]
+
+	var req shellRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20+1)).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	var (
+		result any = map[string]string{"session_id": sessionID, "status": "ok"}
+		err    error
+	)
+	switch action {
+	case "open":
+		err = shells.OpenShell(sessionID, req.Rows, req.Cols)
+	case "input":
+		err = shells.WriteShell(sessionID, req.Seq, req.Data)
+	case "read":
+		result, err = shells.ReadShell(sessionID, req.Offset, time.Duration(req.WaitMS)*time.Millisecond)
+	case "resize":
+		err = shells.ResizeShell(sessionID, req.Rows, req.Cols)
+	case "close":
+		err = shells.CloseShell(sessionID)
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, shell.ErrNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, shell.ErrDisabled):
+			status = http.StatusForbidden
+		case errors.Is(err, shell.ErrExists):
+			status = http.StatusConflict
+		case errors.Is(err, shell.ErrLimit):
+			status = http.StatusTooManyRequests
+		}
+		if action == "open" {
+			h.logger.Warn("shell session refused",
+				zap.String("session_id", sessionID),
+				zap.Error(err))
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
 // ConfigHandler handles configuration requests
 func (h *Handlers) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 	if h.configProvider == nil {
@@ -284,6 +561,44 @@ func (h *Handlers) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// DrainHandler handles drain mode requests. POST {"drain": true} stops the
+// agent accepting workflows; {"drain": false} resumes.
+func (h *Handlers) DrainHandler(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	drainer := h.drainer
+	h.mu.RUnlock()
+
+	if drainer == nil {
+		http.Error(w, "Drain mode not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(drainer.DrainStatus())
+
+	case http.MethodPost:
+		var req struct {
+			Drain *bool `json:"drain"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Drain == nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		drainer.SetDraining(*req.Drain)
+		h.logger.Info("drain mode requested", zap.Bool("drain", *req.Drain))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(drainer.DrainStatus())
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 // UpgradeHandler handles upgrade requests
 func (h *Handlers) UpgradeHandler(w http.ResponseWriter, r *http.Request) {
 	if h.upgradeHandler == nil {
@@ -3,6 +3,7 @@ package webhook
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -69,7 +70,7 @@ func (s *Server) Start(ctx context.Context) error {
 	s.listener = listener
 
 	mux := http.NewServeMux()
-	s.registerRoutes(mux)
+	s.registerRoutes(mux, s.wrapWithAuth)
 
 	s.httpServer = &http.Server{
 		Handler:           mux,
@@ -125,24 +126,32 @@ func (s *Server) IsRunning() bool {
 	return s.running
 }
 
-// registerRoutes registers all HTTP routes
-func (s *Server) registerRoutes(mux *http.ServeMux) {
+// registerRoutes registers all HTTP routes; protect wraps every route but
+// the health probes
+func (s *Server) registerRoutes(mux *http.ServeMux, protect func(http.HandlerFunc) http.HandlerFunc) {
 	// Health endpoints
 	mux.HandleFunc("/healthz", s.handlers.HealthzHandler)
 	mux.HandleFunc("/readyz", s.handlers.ReadyzHandler)
-	mux.HandleFunc("/status", s.wrapWithAuth(s.handlers.StatusHandler))
+	mux.HandleFunc("/status", protect(s.handlers.StatusHandler))
 
 	// Webhook endpoints
-	mux.HandleFunc("/hooks/", s.wrapWithAuth(s.handlers.WebhookHandler))
+	mux.HandleFunc("/hooks/", protect(s.handlers.WebhookHandler))
 
 	// Workflow endpoints
-	mux.HandleFunc("/workflow/execute", s.wrapWithAuth(s.handlers.ExecuteWorkflowHandler))
-	mux.HandleFunc("/workflow/status", s.wrapWithAuth(s.handlers.WorkflowStatusHandler))
-	mux.HandleFunc("/workflow/cancel", s.wrapWithAuth(s.handlers.CancelWorkflowHandler))
+	mux.HandleFunc("/workflow/execute", protect(s.handlers.ExecuteWorkflowHandler))
+	mux.HandleFunc("/workflow/status", protect(s.handlers.WorkflowStatusHandler))
+	mux.HandleFunc("/workflow/cancel", protect(s.handlers.CancelWorkflowHandler))
+	mux.HandleFunc("/workflow/pause", protect(s.handlers.PauseWorkflowHandler))
+	mux.HandleFunc("/workflow/resume", protect(s.handlers.ResumeWorkflowHandler))
+	mux.HandleFunc("/workflow/callback/", protect(s.handlers.CallbackHandler))
+
+	// Live shell sessions
+	mux.HandleFunc("/shell/", protect(s.handlers.ShellHandler))
 
 	// Agent management endpoints
-	mux.HandleFunc("/agent/config", s.wrapWithAuth(s.handlers.ConfigHandler))
-	mux.HandleFunc("/agent/upgrade", s.wrapWithAuth(s.handlers.UpgradeHandler))
+	mux.HandleFunc("/agent/config", protect(s.handlers.ConfigHandler))
+	mux.HandleFunc("/agent/upgrade", protect(s.handlers.UpgradeHandler))
+	mux.HandleFunc("/agent/drain", protect(s.handlers.DrainHandler))
 }
 
 // wrapWithAuth wraps a handler with authentication
@@ -161,10 +170,43 @@ func (s *Server) wrapWithAuth(handler http.HandlerFunc) http.HandlerFunc {
 // Handler returns the HTTP handler for use with Piko
 func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
-	s.registerRoutes(mux)
+	s.registerRoutes(mux, s.wrapWithAuth)
 	return mux
 }
 
+// PikoHandler returns the HTTP handler for requests proxied through Piko.
+// Requests must carry a dispatch token verified by verifier instead of the
+// webhook credentials; with no verifier it is Handler.
+func (s *Server) PikoHandler(verifier *DispatchVerifier) http.Handler {
+	if verifier == nil {
+		return s.Handler()
+	}
+	mux := http.NewServeMux()
+	s.registerRoutes(mux, func(handler http.HandlerFunc) http.HandlerFunc {
+		return s.wrapWithDispatch(verifier, handler)
+	})
+	return mux
+}
+
+// wrapWithDispatch wraps a handler with dispatch token verification
+func (s *Server) wrapWithDispatch(verifier *DispatchVerifier, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := verifier.Verify(r); err != nil {
+			if errors.Is(err, errForeignDispatch) {
+				s.logger.Warn("rejected Piko request for another tenant or agent",
+					zap.String("method", r.Method),
+					zap.String("path", r.URL.Path))
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			s.logger.Debug("rejected Piko request", zap.String("path", r.URL.Path), zap.Error(err))
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
 // GetPort returns the server port
 func (s *Server) GetPort() int {
 	return s.port